@@ -0,0 +1,103 @@
+// Command backfill-subscription-periods repairs subscriptions stored with
+// Unix epoch billing periods by webhooks that read them from the unexpanded
+// latest invoice. Each affected subscription is fetched from Stripe and its
+// current period taken from the subscription items. Run it once with
+// DATABASE_URL and STRIPE_SECRET_KEY set; -dry-run only reports the changes.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/joho/godotenv"
+)
+
+// Periods before this are the zero timestamps written by the old webhooks
+var epochCutoff = time.Date(1971, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the changes without saving them")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	if _, err := os.Stat(common.PRIVATE_CREDENTIALS_DOTENV); err == nil {
+		if err := godotenv.Load(common.PRIVATE_CREDENTIALS_DOTENV); err != nil {
+			slog.Error("Failed to load credentials", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	stripeSecretKey := os.Getenv("STRIPE_SECRET_KEY")
+	if databaseURL == "" || stripeSecretKey == "" {
+		slog.Error("DATABASE_URL and STRIPE_SECRET_KEY are required")
+		os.Exit(1)
+	}
+
+	database, err := db.Connect(databaseURL)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	stripeSvc := services.NewStripeService(nil, stripeSecretKey, "", "", "")
+
+	var subs []models.Subscription
+	err = database.DB.WithContext(ctx).
+		Where("current_period_start < ? OR current_period_end < ?", epochCutoff, epochCutoff).
+		Find(&subs).Error
+	if err != nil {
+		slog.Error("Failed to list subscriptions", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Subscriptions with epoch periods", "count", len(subs), "dry_run", *dryRun)
+
+	fixed, failed := 0, 0
+	for _, local := range subs {
+		sub, err := stripeSvc.GetSubscription(ctx, local.StripeSubscriptionID)
+		if err != nil {
+			slog.Error("Failed to fetch subscription", "stripe_id", local.StripeSubscriptionID, "error", err)
+			failed++
+			continue
+		}
+		start, end, ok := services.SubscriptionPeriod(sub)
+		if !ok {
+			slog.Warn("Subscription has no period on its items", "stripe_id", local.StripeSubscriptionID)
+			failed++
+			continue
+		}
+
+		slog.Info("Backfilling subscription period", "id", local.ID, "stripe_id", local.StripeSubscriptionID,
+			"current_period_start", start.UTC(), "current_period_end", end.UTC())
+		if *dryRun {
+			fixed++
+			continue
+		}
+
+		err = database.DB.WithContext(ctx).Model(&local).Updates(map[string]interface{}{
+			"current_period_start": start,
+			"current_period_end":   end,
+		}).Error
+		if err != nil {
+			slog.Error("Failed to update subscription", "id", local.ID, "error", err)
+			failed++
+			continue
+		}
+		fixed++
+	}
+
+	slog.Info("Backfill finished", "fixed", fixed, "failed", failed, "dry_run", *dryRun)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
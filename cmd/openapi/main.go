@@ -0,0 +1,43 @@
+// Command openapi writes the OpenAPI document for the route catalog in
+// package openapi. With -check it exits non-zero when the file on disk
+// differs from the generated document, for CI.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"awning-backend/openapi"
+)
+
+func main() {
+	out := flag.String("o", "openapi/openapi.json", "file to write")
+	check := flag.Bool("check", false, "compare with the file instead of writing it")
+	flag.Parse()
+
+	data, err := openapi.Build(openapi.Routes).JSON()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode spec: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *check {
+		current, err := os.ReadFile(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", *out, err)
+			os.Exit(1)
+		}
+		if !bytes.Equal(current, data) {
+			fmt.Fprintf(os.Stderr, "%s is out of date, run make openapi\n", *out)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *out, err)
+		os.Exit(1)
+	}
+}
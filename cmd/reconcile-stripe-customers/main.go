@@ -0,0 +1,136 @@
+// Command reconcile-stripe-customers links users to their Stripe customers.
+// Each user without a stored customer ID is matched to the customers with
+// their email, preferring the one created for their user ID, or a customer
+// is created when there is none. Emails with several customers are reported
+// for a manual merge in the Stripe dashboard. Run it with DATABASE_URL and
+// STRIPE_SECRET_KEY set; -dry-run only reports the changes.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/joho/godotenv"
+	"github.com/stripe/stripe-go/v84"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "report the changes without saving them")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	if _, err := os.Stat(common.PRIVATE_CREDENTIALS_DOTENV); err == nil {
+		if err := godotenv.Load(common.PRIVATE_CREDENTIALS_DOTENV); err != nil {
+			slog.Error("Failed to load credentials", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	databaseURL := os.Getenv("DATABASE_URL")
+	stripeSecretKey := os.Getenv("STRIPE_SECRET_KEY")
+	if databaseURL == "" || stripeSecretKey == "" {
+		slog.Error("DATABASE_URL and STRIPE_SECRET_KEY are required")
+		os.Exit(1)
+	}
+
+	database, err := db.Connect(databaseURL)
+	if err != nil {
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	stripeSvc := services.NewStripeService(nil, stripeSecretKey, "", "", "")
+
+	var users []models.User
+	if err := database.DB.WithContext(ctx).Where("stripe_customer_id IS NULL").Order("id").Find(&users).Error; err != nil {
+		slog.Error("Failed to list users", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Users without a Stripe customer", "count", len(users), "dry_run", *dryRun)
+
+	linked, created, duplicates, failed := 0, 0, 0, 0
+	for _, user := range users {
+		userID := strconv.FormatUint(uint64(user.ID), 10)
+
+		customers, err := stripeSvc.FindCustomersByEmail(ctx, user.Email)
+		if err != nil {
+			slog.Error("Failed to search customers", "user_id", user.ID, "error", err)
+			failed++
+			continue
+		}
+
+		var customerID string
+		switch {
+		case len(customers) == 0 && *dryRun:
+			slog.Info("Would create customer", "user_id", user.ID, "email", user.Email)
+			created++
+			continue
+		case len(customers) == 0:
+			cust, err := stripeSvc.CreateCustomer(ctx, user.Email, user.FirstName+" "+user.LastName, map[string]string{
+				"user_id": userID,
+				"email":   user.Email,
+			})
+			if err != nil {
+				slog.Error("Failed to create customer", "user_id", user.ID, "error", err)
+				failed++
+				continue
+			}
+			customerID = cust.ID
+			created++
+		default:
+			kept := pickCustomer(customers, userID)
+			customerID = kept.ID
+			if len(customers) > 1 {
+				var others []string
+				for _, cust := range customers {
+					if cust.ID != kept.ID {
+						others = append(others, cust.ID)
+					}
+				}
+				slog.Warn("Duplicate Stripe customers, merge manually", "user_id", user.ID, "email", user.Email,
+					"kept", kept.ID, "duplicates", others)
+				duplicates++
+			}
+			linked++
+		}
+
+		slog.Info("Linking user to customer", "user_id", user.ID, "customer_id", customerID)
+		if *dryRun {
+			continue
+		}
+
+		err = database.DB.WithContext(ctx).Model(&models.User{}).
+			Where("id = ? AND stripe_customer_id IS NULL", user.ID).
+			Update("stripe_customer_id", customerID).Error
+		if err != nil {
+			slog.Error("Failed to update user", "user_id", user.ID, "error", err)
+			failed++
+		}
+	}
+
+	slog.Info("Reconciliation finished", "linked", linked, "created", created,
+		"duplicates", duplicates, "failed", failed, "dry_run", *dryRun)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// pickCustomer returns the customer created for the user, else the oldest
+func pickCustomer(customers []*stripe.Customer, userID string) *stripe.Customer {
+	for _, cust := range customers {
+		if cust.Metadata["user_id"] == userID {
+			return cust
+		}
+	}
+	return customers[0]
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	GOOGLE_FONTS_API_URL   = "https://fonts.googleapis.com"
+	GOOGLE_FONTS_FILES_URL = "https://fonts.gstatic.com"
+)
+
+// BrandFont is a Google Fonts family tenants can pick as a brand font
+type BrandFont struct {
+	// Generic family the font falls back to, such as serif
+	Fallback string
+	// Weights linked, as in the css2 API (400;700), or empty for families
+	// with only a regular weight
+	Weights string
+}
+
+// BrandFonts is the curated list of brand fonts, by family name
+var BrandFonts = map[string]BrandFont{
+	"Inter":             {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Roboto":            {Fallback: "sans-serif", Weights: "400;500;700"},
+	"Open Sans":         {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Lato":              {Fallback: "sans-serif", Weights: "400;700"},
+	"Montserrat":        {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Poppins":           {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Nunito":            {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Raleway":           {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Work Sans":         {Fallback: "sans-serif", Weights: "400;600;700"},
+	"DM Sans":           {Fallback: "sans-serif", Weights: "400;500;700"},
+	"Oswald":            {Fallback: "sans-serif", Weights: "400;600;700"},
+	"Bebas Neue":        {Fallback: "sans-serif"},
+	"Playfair Display":  {Fallback: "serif", Weights: "400;700"},
+	"Merriweather":      {Fallback: "serif", Weights: "400;700"},
+	"Lora":              {Fallback: "serif", Weights: "400;700"},
+	"Libre Baskerville": {Fallback: "serif", Weights: "400;700"},
+	"EB Garamond":       {Fallback: "serif", Weights: "400;600;700"},
+	"Pacifico":          {Fallback: "cursive"},
+	"Dancing Script":    {Fallback: "cursive", Weights: "400;700"},
+	"Source Code Pro":   {Fallback: "monospace", Weights: "400;600"},
+}
+
+// BrandFontNames returns the curated brand font families, sorted
+func BrandFontNames() []string {
+	names := make([]string, 0, len(BrandFonts))
+	for name := range BrandFonts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GoogleFontsURL returns the stylesheet URL loading the brand font
+// families, or "" when there are none. Families not in BrandFonts are
+// left out.
+func GoogleFontsURL(families ...string) string {
+	var params []string
+	for _, family := range families {
+		font, ok := BrandFonts[family]
+		if !ok {
+			continue
+		}
+		param := "family=" + strings.ReplaceAll(url.PathEscape(family), "%20", "+")
+		if font.Weights != "" {
+			param += ":wght@" + font.Weights
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		return ""
+	}
+	return GOOGLE_FONTS_API_URL + "/css2?" + strings.Join(params, "&") + "&display=swap"
+}
+
+// FontFamilyValue returns the CSS font-family value for a brand font, with
+// its fallback
+func FontFamilyValue(family string) string {
+	fallback := "sans-serif"
+	if font, ok := BrandFonts[family]; ok {
+		fallback = font.Fallback
+	}
+	return `"` + family + `", ` + fallback
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 )
 
 type PromptFormat string
@@ -16,6 +17,12 @@ const (
 	PromptFormatHtmlTemplateBased PromptFormat = "html"
 )
 
+// ModerationRule is a denylist pattern and the category reported when it matches
+type ModerationRule struct {
+	Category string `json:"category"`
+	Pattern  string `json:"pattern"`
+}
+
 type Config struct {
 	ListenAddr               string       `json:"listen_addr"`
 	MinInputTokens           int          `json:"min_input_tokens"`
@@ -37,8 +44,196 @@ type Config struct {
 	VarDir                   string       `json:"var_dir"`
 	SaveResponses            bool         `json:"save_responses"`
 
+	// Mock responses stream like the model's: content events of
+	// mock_chunk_size characters (0 for the whole page in one event),
+	// mock_chunk_delay_ms apart, after mock_latency_ms
+	MockLatencyMs    int `json:"mock_latency_ms"`
+	MockChunkSize    int `json:"mock_chunk_size"`
+	MockChunkDelayMs int `json:"mock_chunk_delay_ms"`
+
 	SendThinking bool `json:"send_thinking"`
 
+	// How reasoning reaches chat clients: off, placeholder (a periodic
+	// "still thinking..."), throttled (buffered text at most every
+	// thinking_interval_ms, capped at thinking_max_chars, or a summary with
+	// thinking_summary) or full (every delta). Empty follows send_thinking.
+	ThinkingMode       string `json:"thinking_mode"`
+	ThinkingIntervalMs int    `json:"thinking_interval_ms"`
+	ThinkingMaxChars   int    `json:"thinking_max_chars"`
+	ThinkingSummary    bool   `json:"thinking_summary"`
+
+	// Server-side timeout for non-streaming chat completions
+	ChatCompleteTimeoutSeconds int `json:"chat_complete_timeout_seconds"`
+
+	// Async generations (POST /api/v1/chat/generate) run as background jobs
+	// and are cut off after async_generation_timeout_seconds, which must
+	// stay under the 5 minute job lease. Finished jobs are kept for polling
+	// for async_generation_result_ttl_minutes.
+	AsyncGenerationTimeoutSeconds   int `json:"async_generation_timeout_seconds"`
+	AsyncGenerationResultTTLMinutes int `json:"async_generation_result_ttl_minutes"`
+
+	// The done event references the generated content, fetched from
+	// /api/v1/chat/:id/content/:messageId, unless inline content is kept
+	ChatDoneInlineContent bool `json:"chat_done_inline_content"`
+
+	// GET /api/v1/chat/:id cuts message contents longer than this many runes
+	// to a preview with a content_ref, unless the request passes
+	// ?include=content. Full content keeps sending whole messages, for
+	// clients that predate content refs.
+	ChatContentPreviewRunes int  `json:"chat_content_preview_runes"`
+	ChatFullContent         bool `json:"chat_full_content"`
+
+	// A chat request identical to one made in the last
+	// chat_dedup_ttl_seconds (same chat, message and onboarding data) waits
+	// for the first and gets its result instead of generating again, unless
+	// it sets force. 0 turns the guard off.
+	ChatDedupTTLSeconds int `json:"chat_dedup_ttl_seconds"`
+
+	// Default generation parameters per model, overridden by the request's
+	// generation parameters; the model's output token limit caps both
+	ModelParams map[string]GenerationParams `json:"model_params"`
+
+	// Token limits per model, over the built-in DefaultModelLimits. Fields
+	// left at 0 fall back to context_window, max_input_tokens and
+	// max_output_tokens.
+	ModelLimits   map[string]ModelLimits `json:"model_limits"`
+	ContextWindow int                    `json:"context_window"`
+
+	// Token prices per model, which the max_monthly_cost_cents tenant
+	// setting is checked against. Models without a price cost nothing.
+	ModelPrices map[string]ModelPrice `json:"model_prices"`
+
+	// Chat titles are generated after the first response unless disabled.
+	// An empty model uses the default model.
+	ChatTitlesEnabled bool   `json:"chat_titles_enabled"`
+	ChatTitleModel    string `json:"chat_title_model"`
+
+	// After a page is generated, ask the model for a JSON summary of it
+	// (sections, palette, fonts, nav labels) for the editor. Requests can
+	// turn it on or off with site_metadata.
+	SiteMetadataEnabled bool `json:"site_metadata_enabled"`
+
+	// Multi-page generations (pages or multi_page in the chat request) may
+	// have up to multi_page_max_pages pages, generated
+	// multi_page_concurrency at a time
+	MultiPageMaxPages    int `json:"multi_page_max_pages"`
+	MultiPageConcurrency int `json:"multi_page_concurrency"`
+
+	// Before generating, ask the model for up to clarification_max_questions
+	// facts it is missing and pause the generation until they are answered.
+	// Requests can turn it on or off with clarify. Unanswered questions
+	// are assumed once the request skips them or
+	// clarification_timeout_seconds pass.
+	ClarificationEnabled        bool `json:"clarification_enabled"`
+	ClarificationMaxQuestions   int  `json:"clarification_max_questions"`
+	ClarificationTimeoutSeconds int  `json:"clarification_timeout_seconds"`
+
+	// Update generations (chat_stage update) are given an outline of the
+	// chat's latest draft, or else the current publication, and keep its
+	// sections; the done event lists what changed. With
+	// update_keep_unchanged_sections the model may leave sections as
+	// placeholders, which are filled from the current page verbatim.
+	UpdateCurrentSite           bool `json:"update_current_site"`
+	UpdateKeepUnchangedSections bool `json:"update_keep_unchanged_sections"`
+
+	// Once a chat's history passes history_compaction_threshold_tokens (0
+	// compacts every prompt), pages before the latest are replaced in the
+	// prompt by an outline (history_summary_strategy: heuristic, or model
+	// with history_summary_model, empty for the default model) and user
+	// messages are cut to history_user_message_max_chars.
+	HistoryCompactionThresholdTokens int    `json:"history_compaction_threshold_tokens"`
+	HistorySummaryStrategy           string `json:"history_summary_strategy"`
+	HistorySummaryModel              string `json:"history_summary_model"`
+	HistoryUserMessageMaxChars       int    `json:"history_user_message_max_chars"`
+
+	// Refresh the Vertex access token this many seconds before it expires
+	VertexTokenRefreshSeconds int `json:"vertex_token_refresh_seconds"`
+
+	// Largest server-sent event accepted from a streaming model response;
+	// a larger event fails the generation
+	StreamMaxEventBytes int `json:"stream_max_event_bytes"`
+
+	// Content a generation may stream before it is cut short: a hard size
+	// cap, and a token ceiling estimated from the size (0 for the
+	// generation's max_output_tokens)
+	OutputGuardMaxBytes  int `json:"output_guard_max_bytes"`
+	OutputGuardMaxTokens int `json:"output_guard_max_tokens"`
+
+	// Lines of tenant brand voice containing any of these phrases are dropped
+	BrandVoiceDenylist []string `json:"brand_voice_denylist"`
+
+	// Generate once more, with a stronger language instruction, when a reply
+	// isn't detected as the tenant's locale (or the request's language)
+	LanguageRetry bool `json:"language_retry"`
+
+	// Chats can only be read, changed and deleted by their tenant (or user,
+	// for chats without a tenant); chats from before owners were recorded
+	// only through the admin routes. Off keeps chats open to any
+	// authenticated user, as the standalone server without auth has them.
+	ChatOwnershipChecks bool `json:"chat_ownership_checks"`
+
+	// Redis topology (redis_mode: single, sentinel or cluster). single
+	// connects to redis_addr; sentinel asks redis_sentinel_addrs for the
+	// master named redis_master_name; cluster takes comma-separated seed
+	// nodes in redis_addr. redis_pool_size 0 keeps go-redis' default.
+	RedisMode             string   `json:"redis_mode"`
+	RedisMasterName       string   `json:"redis_master_name"`
+	RedisSentinelAddrs    []string `json:"redis_sentinel_addrs"`
+	RedisSentinelPassword string   `json:"redis_sentinel_password"`
+	RedisTLS              bool     `json:"redis_tls"`
+	RedisTLSSkipVerify    bool     `json:"redis_tls_skip_verify"`
+	RedisPoolSize         int      `json:"redis_pool_size"`
+	RedisMinIdleConns     int      `json:"redis_min_idle_conns"`
+	RedisDialTimeoutMs    int      `json:"redis_dial_timeout_ms"`
+	RedisReadTimeoutMs    int      `json:"redis_read_timeout_ms"`
+	RedisWriteTimeoutMs   int      `json:"redis_write_timeout_ms"`
+
+	// Chat persistence (chat_store: "" or redis, postgres, cached for
+	// postgres behind a Redis cache). postgres and cached keep chats in the
+	// tenant schema, so chats without a tenant can't be saved.
+	ChatStore string `json:"chat_store"`
+
+	// Deleted chats stay in the trash, restorable, for this many days before
+	// they are deleted for good
+	ChatTrashRetentionDays int `json:"chat_trash_retention_days"`
+
+	// Chats whose save fails after a generation are spilled and saved by a
+	// background retry (chat_spill: dir, redis or off). chat_spill_redis_addr
+	// is a secondary Redis for the redis spill; empty uses the main one. An
+	// alert is raised when more than chat_spill_alert_threshold are pending.
+	ChatSpill               string `json:"chat_spill"`
+	ChatSpillDir            string `json:"chat_spill_dir"`
+	ChatSpillRedisAddr      string `json:"chat_spill_redis_addr"`
+	ChatSpillAlertThreshold int    `json:"chat_spill_alert_threshold"`
+
+	// Orphaned Redis keys are reaped every redis_reaper_interval_hours by
+	// the policy of their namespace (redis_reaper_policies, over the
+	// built-in ReaperPolicies). Scans fetch redis_reaper_scan_count keys at
+	// a time, redis_reaper_scan_pause_ms apart. Dry runs only report.
+	RedisReaperEnabled       bool                      `json:"redis_reaper_enabled"`
+	RedisReaperIntervalHours int                       `json:"redis_reaper_interval_hours"`
+	RedisReaperDryRun        bool                      `json:"redis_reaper_dry_run"`
+	RedisReaperScanCount     int                       `json:"redis_reaper_scan_count"`
+	RedisReaperScanPauseMs   int                       `json:"redis_reaper_scan_pause_ms"`
+	RedisReaperPolicies      map[string]RedisKeyPolicy `json:"redis_reaper_policies"`
+
+	// Image rehosting (image_store: "" disabled, local, gcs)
+	ImageStore              string `json:"image_store"`
+	ImageStoreLocalDir      string `json:"image_store_local_dir"`
+	ImageStoreBucket        string `json:"image_store_bucket"`
+	ImageStorePublicBaseURL string `json:"image_store_public_base_url"`
+	ImageRehostConcurrency  int    `json:"image_rehost_concurrency"`
+
+	// srcset widths per image size class
+	ImageHeroWidths    []int `json:"image_hero_widths"`
+	ImageCardWidths    []int `json:"image_card_widths"`
+	ImageDefaultWidths []int `json:"image_default_widths"`
+
+	// Per-processor settings by processor name (image, header, cleanup),
+	// decoded by ImageProcessorSettings and the like. Overridden field by
+	// field by PROCESSOR_<NAME>_SETTINGS.
+	ProcessorSettings map[string]json.RawMessage `json:"processor_settings"`
+
 	ApiKey       string `json:"api_key"`
 	ApiKeySecret string `json:"api_key_secret"`
 
@@ -59,36 +254,214 @@ type Config struct {
 	DomainRegistrarUsername string `json:"domain_registrar_username"`
 	DomainRegistrarSandbox  bool   `json:"domain_registrar_sandbox"`
 
+	// Published sites are served on <tenant>.<site_base_domain> and verified custom domains
+	SiteBaseDomain string `json:"site_base_domain"`
+	// Extra hosts serving the app itself; requests to other hosts must belong to a tenant
+	AppHosts []string `json:"app_hosts"`
+
+	// Proxies (IPs or CIDRs) whose forwarding headers are trusted for the
+	// client IP, and those headers in the order they are tried. Headers
+	// from other sources are ignored.
+	TrustedProxies  []string `json:"trusted_proxies"`
+	ClientIPHeaders []string `json:"client_ip_headers"`
+
 	// Base URL for OAuth callbacks
 	BaseURL string `json:"base_url"`
+	// Frontend base URL, allowed as a redirect target and used when a
+	// requested OAuth redirect isn't allowed
+	FrontendURL string `json:"frontend_url"`
+	// Origins allowed as OAuth and checkout redirect targets, such as
+	// https://app.example.com, or https://*.example.com for subdomains
+	AllowedRedirectOrigins []string `json:"allowed_redirect_origins"`
+
+	// Generation quota for tenants without an active subscription (0 = unlimited)
+	FreeGenerationsPerMonth int `json:"free_generations_per_month"`
+
+	// Basic credits charged for each completed chat generation, through the
+	// credit ledger (0 = generations aren't charged)
+	ChatGenerationCredits int `json:"chat_generation_credits"`
+
+	// Number of background job workers
+	JobsConcurrency int `json:"jobs_concurrency"`
+
+	// Minimum milliseconds between Unsplash searches while reprocessing saved
+	// sites, shared by the batch's jobs on each instance (0 = no limit)
+	ReprocessThrottleMs int `json:"reprocess_throttle_ms"`
+
+	// Unsplash searches stop once the hourly rate limit has
+	// unsplash_quota_reserve or fewer left, until it resets. Search results
+	// are cached for unsplash_cache_ttl_hours (0 = not cached) and stand in
+	// for similar searches in the meantime.
+	UnsplashQuotaReserve  int `json:"unsplash_quota_reserve"`
+	UnsplashCacheTTLHours int `json:"unsplash_cache_ttl_hours"`
+
+	// Days between a tenant requesting deletion and its schema being dropped
+	TenantDeletionGraceDays int `json:"tenant_deletion_grace_days"`
+
+	// Price charged to the tenant for each year of a domain renewal
+	DomainRenewalPriceCents int    `json:"domain_renewal_price_cents"`
+	DomainRenewalCurrency   string `json:"domain_renewal_currency"`
+
+	// Filesystem entries larger than this are skipped by search (0 = no limit)
+	FilesystemSearchMaxBytes int `json:"filesystem_search_max_bytes"`
+
+	// Filesystem namespaces per subscription plan, over the built-in
+	// DefaultFilesystemNamespaces
+	FilesystemNamespaces map[string][]FilesystemNamespace `json:"filesystem_namespaces"`
+
+	// Alternative base prompts tried on a share of new chats
+	PromptExperiments []PromptExperiment `json:"prompt_experiments"`
+
+	// Template placeholders allowed besides the onboarding data keys,
+	// locale and language. With prompt_lint_strict, unknown placeholders
+	// stop the server at startup instead of being logged.
+	PromptVariables  []string `json:"prompt_variables"`
+	PromptLintStrict bool     `json:"prompt_lint_strict"`
+
+	// Moderation of user messages before prompt construction
+	// (moderation_provider: denylist, endpoint; moderation_mode: block, flag).
+	// Tenants can be given their own mode by an admin.
+	ModerationEnabled     bool             `json:"moderation_enabled"`
+	ModerationMode        string           `json:"moderation_mode"`
+	ModerationProvider    string           `json:"moderation_provider"`
+	ModerationEndpointURL string           `json:"moderation_endpoint_url"`
+	ModerationRules       []ModerationRule `json:"moderation_rules"`
+
+	// Serve the Swagger UI at /api/docs; the spec itself is always served
+	ApiDocsEnabled bool `json:"api_docs_enabled"`
+
+	// Responses at least this large are gzipped for clients accepting it
+	// (-1 disables compression)
+	GzipMinBytes int `json:"gzip_min_bytes"`
+
+	// Failed logins before an email or client IP is locked out. Lockouts
+	// last login_lockout_minutes and double on each repeat within a day.
+	LoginMaxAttempts    int `json:"login_max_attempts"`
+	LoginIPMaxAttempts  int `json:"login_ip_max_attempts"`
+	LoginLockoutMinutes int `json:"login_lockout_minutes"`
+
+	// Contact form submissions accepted per client IP per hour through the
+	// public content API
+	ContactRateLimitPerHour int `json:"contact_rate_limit_per_hour"`
+
+	// Password policy for registration and password resets. Common passwords
+	// in the denylist are rejected regardless of case.
+	PasswordMinLength int      `json:"password_min_length"`
+	PasswordMaxLength int      `json:"password_max_length"`
+	PasswordDenylist  []string `json:"password_denylist"`
+
+	// Responses to requests sent with an Idempotency-Key are replayed for
+	// idempotency_ttl_hours. Duplicates arriving while the first is still
+	// running wait up to idempotency_wait_seconds, then get 409.
+	IdempotencyTTLHours     int `json:"idempotency_ttl_hours"`
+	IdempotencyMaxBodyBytes int `json:"idempotency_max_body_bytes"`
+	IdempotencyWaitSeconds  int `json:"idempotency_wait_seconds"`
+
+	// Tenants a user may own without a paid plan (plans set maxTenants)
+	FreeMaxTenants int `json:"free_max_tenants"`
+
+	// Sections processed at once for chat requests with stream_processing
+	SectionConcurrency int `json:"section_processing_concurrency"`
+
+	// A processor still running after processor_timeout_seconds is
+	// abandoned and the page passed on as it was before it
+	ProcessorTimeoutSeconds int `json:"processor_timeout_seconds"`
+
+	// Chat drafts (drafts/chat/...) not updated for this many days are
+	// deleted by a daily job (0 keeps them)
+	DraftMaxAgeDays int `json:"draft_max_age_days"`
+
+	// Per-tenant log of API requests for debugging: every response outside
+	// 2xx and request_log_success_sample_percent of the rest, deleted after
+	// request_log_retention_days. Bodies are never stored.
+	RequestLogEnabled              bool `json:"request_log_enabled"`
+	RequestLogSuccessSamplePercent int  `json:"request_log_success_sample_percent"`
+	RequestLogRetentionDays        int  `json:"request_log_retention_days"`
+
+	// Prometheus metrics at /metrics, for the server API key. Tenants in
+	// metrics_tenant_allowlist (or the list set through
+	// /api/v1/admin/metrics/tenants) are labelled by schema, the rest by one
+	// of metrics_tenant_buckets hash buckets, or "other" with none. An
+	// allowlist longer than metrics_max_tenant_labels is ignored.
+	MetricsEnabled         bool     `json:"metrics_enabled"`
+	MetricsTenantAllowlist []string `json:"metrics_tenant_allowlist"`
+	MetricsTenantBuckets   int      `json:"metrics_tenant_buckets"`
+	MetricsMaxTenantLabels int      `json:"metrics_max_tenant_labels"`
+
+	// Feature flag defaults by name, see KnownFeatureFlags. Overrides set
+	// through /api/v1/admin/flags take precedence; flags left out keep their
+	// built-in default.
+	FeatureFlags map[string]bool `json:"feature_flags"`
+
+	// Retry-After sent with 503s for switched off features and maintenance
+	MaintenanceRetryAfterSeconds int `json:"maintenance_retry_after_seconds"`
+
+	// Preview share links expire after share_link_days unless the request
+	// asks for another lifetime, up to share_link_max_days
+	ShareLinkDays    int `json:"share_link_days"`
+	ShareLinkMaxDays int `json:"share_link_max_days"`
+
+	// Password attempts allowed per hour on a preview link, counted for the
+	// link and for the client IP
+	PreviewUnlockPerHour int `json:"preview_unlock_per_hour"`
+
+	// Routes the server registers (server_mode: simple or full). simple
+	// runs without Postgres and serves the API docs and static files; full
+	// needs DATABASE_URL and JWT_PRIVATE_KEY and adds the tenant sections.
+	// Empty picks full when both are set, as before the setting existed.
+	ServerMode string `json:"server_mode"`
+
+	// Path the frontend from APP_PUBLIC is served under. Files below
+	// static_immutable_prefixes (relative to the base path) have hashed
+	// names and are cached for a year; everything else, including the
+	// index.html fallback, is revalidated on every request.
+	StaticBasePath          string   `json:"static_base_path"`
+	StaticImmutablePrefixes []string `json:"static_immutable_prefixes"`
 
 	enabledProcessorsMap map[string]struct{}
 	enabledModelsMap     map[string]struct{}
 }
 
-func LoadConfig(dir string) (*Config, error) {
-	cfg := DefaultConfig()
-
-	// Load config (JSON + env overrides)
+// ConfigFiles returns the config files LoadConfig applies, in order: the base
+// file (CONFIG_FILE, default config.json) and then config.<APP_ENV>.json
+// beside it. Missing files are skipped.
+func ConfigFiles(dir string) []string {
 	configPath := os.Getenv("CONFIG_FILE")
 	if configPath == "" {
 		configPath = DEFAULT_CONFIG_FILE
 	}
 
-	slog.Info("Loading config from", "config_path", configPath)
-
 	if !strings.HasPrefix(configPath, "/") && dir != "" {
 		configPath = path.Join(dir, configPath)
 	}
 
-	if _, err := os.Stat(configPath); err == nil {
-		slog.Info("Found config file", "config_path", configPath)
+	env := os.Getenv("APP_ENV")
+	if env == "" {
+		env = DEFAULT_APP_ENV
+	}
+
+	ext := path.Ext(configPath)
+	envPath := strings.TrimSuffix(configPath, ext) + "." + env + ext
+
+	return []string{configPath, envPath}
+}
+
+// LoadConfig builds the config from defaults, then each of ConfigFiles, then
+// environment variables. Later layers override only the settings they set.
+func LoadConfig(dir string) (*Config, error) {
+	cfg := DefaultConfig()
 
-		fileCfg, err := LoadConfigFile(configPath)
-		if err != nil {
+	for _, configPath := range ConfigFiles(dir) {
+		slog.Info("Loading config from", "config_path", configPath)
+
+		if _, err := os.Stat(configPath); err != nil {
+			continue
+		}
+
+		slog.Info("Found config file", "config_path", configPath)
+		if err := cfg.decodeFile(configPath); err != nil {
 			return nil, fmt.Errorf("failed to load config file: %w", err)
 		}
-		cfg.applyConfigOverrides(fileCfg)
 	}
 
 	cfg.applyEnvOverrides()
@@ -98,44 +471,143 @@ func LoadConfig(dir string) (*Config, error) {
 	return cfg, nil
 }
 
+// LoadConfigFile loads a single config file over the defaults
 func LoadConfigFile(path string) (*Config, error) {
 	cfg := DefaultConfig()
 	if path != "" {
-		f, err := os.Open(path)
-		if err == nil {
-			defer f.Close()
-			dec := json.NewDecoder(f)
-			_ = dec.Decode(&cfg) // ignore error, fallback to env/defaults
+		if err := cfg.decodeFile(path); err != nil {
+			return nil, err
 		}
 	}
-	// cfg.applyEnvOverrides()
 	return cfg, nil
 }
 
+// decodeFile decodes a JSON config file onto c, so only keys present in the
+// file change
+func (c *Config) decodeFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("failed to decode config file %s: %w", path, err)
+	}
+	return nil
+}
+
 func DefaultConfig() *Config {
 	return &Config{
-		ApiKey:                   "",
-		ApiKeySecret:             "",
-		ApiFrontendKey:           "",
-		MinInputTokens:           DEFAULT_MIN_INPUT_TOKENS,
-		MaxInputTokens:           DEFAULT_MAX_INPUT_TOKENS,
-		MaxOutputTokens:          DEFAULT_MAX_OUTPUT_TOKENS,
-		RedisAddr:                DEFAULT_REDIS_ADDR,
-		RedisPassword:            "",
-		RedisPrefix:              DEFAULT_REDIS_PREFIX,
-		ListenAddr:               DEFAULT_LISTEN_ADDR,
-		EnabledModels:            strings.Split(DEFAULT_ENABLED_MODELS, ","),
-		DefaultModel:             DEFAULT_MODEL,
-		PromptFormat:             PromptFormatOneShotPage,
-		PromptName:               "prompt4",
-		UnsplashAPIAccessKey:     "",
-		UnsplashAPISecretKey:     "",
-		MockResponse:             false,
-		PostProcessMockResponses: false,
-		MockContent:              "",
-		VarDir:                   DEFAULT_VAR_DIR,
-		SaveResponses:            false,
-		SendThinking:             true,
+		ApiKey:                     "",
+		ApiKeySecret:               "",
+		ApiFrontendKey:             "",
+		MinInputTokens:             DEFAULT_MIN_INPUT_TOKENS,
+		MaxInputTokens:             DEFAULT_MAX_INPUT_TOKENS,
+		MaxOutputTokens:            DEFAULT_MAX_OUTPUT_TOKENS,
+		ContextWindow:              DEFAULT_CONTEXT_WINDOW,
+		RedisAddr:                  DEFAULT_REDIS_ADDR,
+		RedisPassword:              "",
+		RedisPrefix:                DEFAULT_REDIS_PREFIX,
+		RedisMode:                  DEFAULT_REDIS_MODE,
+		RedisDialTimeoutMs:         DEFAULT_REDIS_DIAL_TIMEOUT_MS,
+		RedisReadTimeoutMs:         DEFAULT_REDIS_READ_TIMEOUT_MS,
+		RedisWriteTimeoutMs:        DEFAULT_REDIS_WRITE_TIMEOUT_MS,
+		ListenAddr:                 DEFAULT_LISTEN_ADDR,
+		EnabledModels:              strings.Split(DEFAULT_ENABLED_MODELS, ","),
+		DefaultModel:               DEFAULT_MODEL,
+		PromptFormat:               PromptFormatOneShotPage,
+		PromptName:                 "prompt4",
+		UnsplashAPIAccessKey:       "",
+		UnsplashAPISecretKey:       "",
+		MockResponse:               false,
+		PostProcessMockResponses:   false,
+		MockContent:                "",
+		VarDir:                     DEFAULT_VAR_DIR,
+		SaveResponses:              false,
+		SendThinking:               true,
+		ThinkingIntervalMs:         DEFAULT_THINKING_INTERVAL_MS,
+		ThinkingMaxChars:           DEFAULT_THINKING_MAX_CHARS,
+		FreeGenerationsPerMonth:    DEFAULT_FREE_GENERATIONS_PER_MONTH,
+		TenantDeletionGraceDays:    DEFAULT_TENANT_DELETION_GRACE_DAYS,
+		JobsConcurrency:            DEFAULT_JOBS_CONCURRENCY,
+		ReprocessThrottleMs:        DEFAULT_REPROCESS_THROTTLE_MS,
+		UnsplashQuotaReserve:       DEFAULT_UNSPLASH_QUOTA_RESERVE,
+		UnsplashCacheTTLHours:      DEFAULT_UNSPLASH_CACHE_TTL_HOURS,
+		DomainRenewalPriceCents:    DEFAULT_DOMAIN_RENEWAL_PRICE_CENTS,
+		DomainRenewalCurrency:      DEFAULT_DOMAIN_RENEWAL_CURRENCY,
+		FilesystemSearchMaxBytes:   DEFAULT_FILESYSTEM_SEARCH_MAX_BYTES,
+		ModerationMode:             DEFAULT_MODERATION_MODE,
+		GzipMinBytes:               DEFAULT_GZIP_MIN_BYTES,
+		ChatContentPreviewRunes:    DEFAULT_CHAT_CONTENT_PREVIEW_RUNES,
+		ChatDedupTTLSeconds:        DEFAULT_CHAT_DEDUP_TTL_SECONDS,
+		LoginMaxAttempts:           DEFAULT_LOGIN_MAX_ATTEMPTS,
+		LoginIPMaxAttempts:         DEFAULT_LOGIN_IP_MAX_ATTEMPTS,
+		LoginLockoutMinutes:        DEFAULT_LOGIN_LOCKOUT_MINUTES,
+		ContactRateLimitPerHour:    DEFAULT_CONTACT_RATE_LIMIT_PER_HOUR,
+		PasswordMinLength:          DEFAULT_PASSWORD_MIN_LENGTH,
+		PasswordMaxLength:          DEFAULT_PASSWORD_MAX_LENGTH,
+		PasswordDenylist:           strings.Split(DEFAULT_PASSWORD_DENYLIST, ","),
+		IdempotencyTTLHours:        DEFAULT_IDEMPOTENCY_TTL_HOURS,
+		IdempotencyMaxBodyBytes:    DEFAULT_IDEMPOTENCY_MAX_BODY_BYTES,
+		IdempotencyWaitSeconds:     DEFAULT_IDEMPOTENCY_WAIT_SECONDS,
+		FreeMaxTenants:             DEFAULT_FREE_MAX_TENANTS,
+		ShareLinkDays:              DEFAULT_SHARE_LINK_DAYS,
+		SectionConcurrency:         DEFAULT_SECTION_PROCESSING_CONCURRENCY,
+		ProcessorTimeoutSeconds:    DEFAULT_PROCESSOR_TIMEOUT_SECONDS,
+		ShareLinkMaxDays:           DEFAULT_SHARE_LINK_MAX_DAYS,
+		PreviewUnlockPerHour:       DEFAULT_PREVIEW_UNLOCK_PER_HOUR,
+		DraftMaxAgeDays:            DEFAULT_DRAFT_MAX_AGE_DAYS,
+		ChatCompleteTimeoutSeconds: DEFAULT_CHAT_COMPLETE_TIMEOUT_SECONDS,
+		VertexTokenRefreshSeconds:  DEFAULT_VERTEX_TOKEN_REFRESH_SECONDS,
+		StreamMaxEventBytes:        DEFAULT_STREAM_MAX_EVENT_BYTES,
+		OutputGuardMaxBytes:        DEFAULT_OUTPUT_GUARD_MAX_BYTES,
+		ChatTitlesEnabled:          true,
+		ChatOwnershipChecks:        true,
+		BrandVoiceDenylist:         strings.Split(DEFAULT_BRAND_VOICE_DENYLIST, ","),
+		ImageStoreLocalDir:         DEFAULT_IMAGE_STORE_LOCAL_DIR,
+		ImageStorePublicBaseURL:    DEFAULT_IMAGE_STORE_PUBLIC_BASE_URL,
+		SiteBaseDomain:             DEFAULT_SITE_BASE_DOMAIN,
+		ImageRehostConcurrency:     DEFAULT_IMAGE_REHOST_CONCURRENCY,
+		ImageHeroWidths:            atoiList(DEFAULT_IMAGE_HERO_WIDTHS),
+		ImageCardWidths:            atoiList(DEFAULT_IMAGE_CARD_WIDTHS),
+		ImageDefaultWidths:         atoiList(DEFAULT_IMAGE_DEFAULT_WIDTHS),
+
+		RequestLogSuccessSamplePercent: DEFAULT_REQUEST_LOG_SUCCESS_SAMPLE_PERCENT,
+		RequestLogRetentionDays:        DEFAULT_REQUEST_LOG_RETENTION_DAYS,
+		MetricsMaxTenantLabels:         DEFAULT_METRICS_MAX_TENANT_LABELS,
+
+		MaintenanceRetryAfterSeconds: DEFAULT_MAINTENANCE_RETRY_AFTER_SECONDS,
+
+		HistoryCompactionThresholdTokens: DEFAULT_HISTORY_COMPACTION_THRESHOLD_TOKENS,
+		HistorySummaryStrategy:           DEFAULT_HISTORY_SUMMARY_STRATEGY,
+		HistoryUserMessageMaxChars:       DEFAULT_HISTORY_USER_MESSAGE_MAX_CHARS,
+
+		ChatTrashRetentionDays: DEFAULT_CHAT_TRASH_RETENTION_DAYS,
+
+		ChatSpill:               DEFAULT_CHAT_SPILL,
+		ChatSpillDir:            DEFAULT_CHAT_SPILL_DIR,
+		ChatSpillAlertThreshold: DEFAULT_CHAT_SPILL_ALERT_THRESHOLD,
+
+		RedisReaperIntervalHours: DEFAULT_REDIS_REAPER_INTERVAL_HOURS,
+		RedisReaperScanCount:     DEFAULT_REDIS_REAPER_SCAN_COUNT,
+		RedisReaperScanPauseMs:   DEFAULT_REDIS_REAPER_SCAN_PAUSE_MS,
+
+		StaticBasePath:          DEFAULT_STATIC_BASE_PATH,
+		StaticImmutablePrefixes: strings.Split(DEFAULT_STATIC_IMMUTABLE_PREFIXES, ","),
+		ClientIPHeaders:         strings.Split(DEFAULT_CLIENT_IP_HEADERS, ","),
+
+		AsyncGenerationTimeoutSeconds:   DEFAULT_ASYNC_GENERATION_TIMEOUT_SECONDS,
+		AsyncGenerationResultTTLMinutes: DEFAULT_ASYNC_GENERATION_RESULT_TTL_MINUTES,
+
+		MultiPageMaxPages:    DEFAULT_MULTI_PAGE_MAX_PAGES,
+		MultiPageConcurrency: DEFAULT_MULTI_PAGE_CONCURRENCY,
+
+		ClarificationMaxQuestions:   DEFAULT_CLARIFICATION_MAX_QUESTIONS,
+		ClarificationTimeoutSeconds: DEFAULT_CLARIFICATION_TIMEOUT_SECONDS,
+
+		UpdateCurrentSite: true,
 	}
 }
 
@@ -158,6 +630,9 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv("MAX_OUTPUT_TOKENS"); v != "" {
 		c.MaxOutputTokens = atoiOrDefault(v, c.MaxOutputTokens)
 	}
+	if v := os.Getenv("CONTEXT_WINDOW"); v != "" {
+		c.ContextWindow = atoiOrDefault(v, c.ContextWindow)
+	}
 	if v := os.Getenv("REDIS_ADDR"); v != "" {
 		c.RedisAddr = v
 	}
@@ -167,6 +642,39 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv("REDIS_PREFIX"); v != "" {
 		c.RedisPrefix = v
 	}
+	if v := os.Getenv("REDIS_MODE"); v != "" {
+		c.RedisMode = v
+	}
+	if v := os.Getenv("REDIS_MASTER_NAME"); v != "" {
+		c.RedisMasterName = v
+	}
+	if v := os.Getenv("REDIS_SENTINEL_ADDRS"); v != "" {
+		c.RedisSentinelAddrs = strings.Split(v, ",")
+	}
+	if v := os.Getenv("REDIS_SENTINEL_PASSWORD"); v != "" {
+		c.RedisSentinelPassword = v
+	}
+	if v := os.Getenv("REDIS_TLS"); v != "" {
+		c.RedisTLS = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("REDIS_TLS_SKIP_VERIFY"); v != "" {
+		c.RedisTLSSkipVerify = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("REDIS_POOL_SIZE"); v != "" {
+		c.RedisPoolSize = atoiOrDefault(v, c.RedisPoolSize)
+	}
+	if v := os.Getenv("REDIS_MIN_IDLE_CONNS"); v != "" {
+		c.RedisMinIdleConns = atoiOrDefault(v, c.RedisMinIdleConns)
+	}
+	if v := os.Getenv("REDIS_DIAL_TIMEOUT_MS"); v != "" {
+		c.RedisDialTimeoutMs = atoiOrDefault(v, c.RedisDialTimeoutMs)
+	}
+	if v := os.Getenv("REDIS_READ_TIMEOUT_MS"); v != "" {
+		c.RedisReadTimeoutMs = atoiOrDefault(v, c.RedisReadTimeoutMs)
+	}
+	if v := os.Getenv("REDIS_WRITE_TIMEOUT_MS"); v != "" {
+		c.RedisWriteTimeoutMs = atoiOrDefault(v, c.RedisWriteTimeoutMs)
+	}
 	if v := os.Getenv("LISTEN_ADDR"); v != "" {
 		c.ListenAddr = v
 	}
@@ -197,6 +705,15 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv("MOCK_CONTENT"); v != "" {
 		c.MockContent = v
 	}
+	if v := os.Getenv("MOCK_LATENCY_MS"); v != "" {
+		c.MockLatencyMs = atoiOrDefault(v, c.MockLatencyMs)
+	}
+	if v := os.Getenv("MOCK_CHUNK_SIZE"); v != "" {
+		c.MockChunkSize = atoiOrDefault(v, c.MockChunkSize)
+	}
+	if v := os.Getenv("MOCK_CHUNK_DELAY_MS"); v != "" {
+		c.MockChunkDelayMs = atoiOrDefault(v, c.MockChunkDelayMs)
+	}
 	if v := os.Getenv("VAR_DIR"); v != "" {
 		c.VarDir = v
 	}
@@ -206,9 +723,153 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv("SEND_THINKING"); v != "" {
 		c.SendThinking = strings.ToLower(v) == "true" || v == "1"
 	}
+	if v := os.Getenv("THINKING_MODE"); v != "" {
+		c.ThinkingMode = strings.ToLower(v)
+	}
+	if v := os.Getenv("THINKING_INTERVAL_MS"); v != "" {
+		c.ThinkingIntervalMs = atoiOrDefault(v, c.ThinkingIntervalMs)
+	}
+	if v := os.Getenv("THINKING_MAX_CHARS"); v != "" {
+		c.ThinkingMaxChars = atoiOrDefault(v, c.ThinkingMaxChars)
+	}
+	if v := os.Getenv("THINKING_SUMMARY"); v != "" {
+		c.ThinkingSummary = strings.ToLower(v) == "true" || v == "1"
+	}
 	if v := os.Getenv("POST_PROCESS_MOCK_RESPONSES"); v != "" {
 		c.PostProcessMockResponses = strings.ToLower(v) == "true" || v == "1"
 	}
+	if v := os.Getenv("CHAT_COMPLETE_TIMEOUT_SECONDS"); v != "" {
+		c.ChatCompleteTimeoutSeconds = atoiOrDefault(v, c.ChatCompleteTimeoutSeconds)
+	}
+	if v := os.Getenv("ASYNC_GENERATION_TIMEOUT_SECONDS"); v != "" {
+		c.AsyncGenerationTimeoutSeconds = atoiOrDefault(v, c.AsyncGenerationTimeoutSeconds)
+	}
+	if v := os.Getenv("ASYNC_GENERATION_RESULT_TTL_MINUTES"); v != "" {
+		c.AsyncGenerationResultTTLMinutes = atoiOrDefault(v, c.AsyncGenerationResultTTLMinutes)
+	}
+	if v := os.Getenv("CHAT_TITLES_ENABLED"); v != "" {
+		c.ChatTitlesEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CHAT_TITLE_MODEL"); v != "" {
+		c.ChatTitleModel = v
+	}
+	if v := os.Getenv("SITE_METADATA_ENABLED"); v != "" {
+		c.SiteMetadataEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("MULTI_PAGE_MAX_PAGES"); v != "" {
+		c.MultiPageMaxPages = atoiOrDefault(v, c.MultiPageMaxPages)
+	}
+	if v := os.Getenv("CLARIFICATION_ENABLED"); v != "" {
+		c.ClarificationEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CLARIFICATION_MAX_QUESTIONS"); v != "" {
+		c.ClarificationMaxQuestions = atoiOrDefault(v, c.ClarificationMaxQuestions)
+	}
+	if v := os.Getenv("CLARIFICATION_TIMEOUT_SECONDS"); v != "" {
+		c.ClarificationTimeoutSeconds = atoiOrDefault(v, c.ClarificationTimeoutSeconds)
+	}
+	if v := os.Getenv("UPDATE_CURRENT_SITE"); v != "" {
+		c.UpdateCurrentSite = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("UPDATE_KEEP_UNCHANGED_SECTIONS"); v != "" {
+		c.UpdateKeepUnchangedSections = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("MULTI_PAGE_CONCURRENCY"); v != "" {
+		c.MultiPageConcurrency = atoiOrDefault(v, c.MultiPageConcurrency)
+	}
+	if v := os.Getenv("HISTORY_COMPACTION_THRESHOLD_TOKENS"); v != "" {
+		c.HistoryCompactionThresholdTokens = atoiOrDefault(v, c.HistoryCompactionThresholdTokens)
+	}
+	if v := os.Getenv("HISTORY_SUMMARY_STRATEGY"); v != "" {
+		c.HistorySummaryStrategy = v
+	}
+	if v := os.Getenv("HISTORY_SUMMARY_MODEL"); v != "" {
+		c.HistorySummaryModel = v
+	}
+	if v := os.Getenv("HISTORY_USER_MESSAGE_MAX_CHARS"); v != "" {
+		c.HistoryUserMessageMaxChars = atoiOrDefault(v, c.HistoryUserMessageMaxChars)
+	}
+	if v := os.Getenv("VERTEX_TOKEN_REFRESH_SECONDS"); v != "" {
+		c.VertexTokenRefreshSeconds = atoiOrDefault(v, c.VertexTokenRefreshSeconds)
+	}
+	if v := os.Getenv("STREAM_MAX_EVENT_BYTES"); v != "" {
+		c.StreamMaxEventBytes = atoiOrDefault(v, c.StreamMaxEventBytes)
+	}
+	if v := os.Getenv("OUTPUT_GUARD_MAX_BYTES"); v != "" {
+		c.OutputGuardMaxBytes = atoiOrDefault(v, c.OutputGuardMaxBytes)
+	}
+	if v := os.Getenv("OUTPUT_GUARD_MAX_TOKENS"); v != "" {
+		c.OutputGuardMaxTokens = atoiOrDefault(v, c.OutputGuardMaxTokens)
+	}
+	if v := os.Getenv("BRAND_VOICE_DENYLIST"); v != "" {
+		c.BrandVoiceDenylist = strings.Split(v, ",")
+	}
+	if v := os.Getenv("LANGUAGE_RETRY"); v != "" {
+		c.LanguageRetry = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CHAT_OWNERSHIP_CHECKS"); v != "" {
+		c.ChatOwnershipChecks = strings.ToLower(v) == "true" || v == "1"
+	}
+
+	// Image rehosting
+	if v := os.Getenv("CHAT_STORE"); v != "" {
+		c.ChatStore = v
+	}
+	if v := os.Getenv("CHAT_TRASH_RETENTION_DAYS"); v != "" {
+		c.ChatTrashRetentionDays = atoiOrDefault(v, c.ChatTrashRetentionDays)
+	}
+	if v := os.Getenv("CHAT_SPILL"); v != "" {
+		c.ChatSpill = v
+	}
+	if v := os.Getenv("CHAT_SPILL_DIR"); v != "" {
+		c.ChatSpillDir = v
+	}
+	if v := os.Getenv("CHAT_SPILL_REDIS_ADDR"); v != "" {
+		c.ChatSpillRedisAddr = v
+	}
+	if v := os.Getenv("CHAT_SPILL_ALERT_THRESHOLD"); v != "" {
+		c.ChatSpillAlertThreshold = atoiOrDefault(v, c.ChatSpillAlertThreshold)
+	}
+	if v := os.Getenv("REDIS_REAPER_ENABLED"); v != "" {
+		c.RedisReaperEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("REDIS_REAPER_INTERVAL_HOURS"); v != "" {
+		c.RedisReaperIntervalHours = atoiOrDefault(v, c.RedisReaperIntervalHours)
+	}
+	if v := os.Getenv("REDIS_REAPER_DRY_RUN"); v != "" {
+		c.RedisReaperDryRun = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("REDIS_REAPER_SCAN_COUNT"); v != "" {
+		c.RedisReaperScanCount = atoiOrDefault(v, c.RedisReaperScanCount)
+	}
+	if v := os.Getenv("REDIS_REAPER_SCAN_PAUSE_MS"); v != "" {
+		c.RedisReaperScanPauseMs = atoiOrDefault(v, c.RedisReaperScanPauseMs)
+	}
+	if v := os.Getenv("IMAGE_STORE"); v != "" {
+		c.ImageStore = v
+	}
+	if v := os.Getenv("IMAGE_STORE_LOCAL_DIR"); v != "" {
+		c.ImageStoreLocalDir = v
+	}
+	if v := os.Getenv("IMAGE_STORE_BUCKET"); v != "" {
+		c.ImageStoreBucket = v
+	}
+	if v := os.Getenv("IMAGE_STORE_PUBLIC_BASE_URL"); v != "" {
+		c.ImageStorePublicBaseURL = v
+	}
+	if v := os.Getenv("IMAGE_REHOST_CONCURRENCY"); v != "" {
+		c.ImageRehostConcurrency = atoiOrDefault(v, c.ImageRehostConcurrency)
+	}
+	if v := os.Getenv("IMAGE_HERO_WIDTHS"); v != "" {
+		c.ImageHeroWidths = atoiList(v)
+	}
+	if v := os.Getenv("IMAGE_CARD_WIDTHS"); v != "" {
+		c.ImageCardWidths = atoiList(v)
+	}
+	if v := os.Getenv("IMAGE_DEFAULT_WIDTHS"); v != "" {
+		c.ImageDefaultWidths = atoiList(v)
+	}
+	c.applyProcessorSettingsEnv()
 
 	// OAuth configuration
 	if v := os.Getenv("OAUTH_GOOGLE_CLIENT_ID"); v != "" {
@@ -251,70 +912,172 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv("BASE_URL"); v != "" {
 		c.BaseURL = v
 	}
-}
+	if v := os.Getenv("FRONTEND_URL"); v != "" {
+		c.FrontendURL = v
+	}
+	if v := os.Getenv("ALLOWED_REDIRECT_ORIGINS"); v != "" {
+		c.AllowedRedirectOrigins = strings.Split(v, ",")
+	}
+	if v := os.Getenv("SITE_BASE_DOMAIN"); v != "" {
+		c.SiteBaseDomain = v
+	}
+	if v := os.Getenv("APP_HOSTS"); v != "" {
+		c.AppHosts = strings.Split(v, ",")
+	}
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		c.TrustedProxies = strings.Split(v, ",")
+	}
+	if v := os.Getenv("CLIENT_IP_HEADERS"); v != "" {
+		c.ClientIPHeaders = strings.Split(v, ",")
+	}
 
-func (c *Config) applyConfigOverrides(cfg *Config) {
-	if cfg.ApiKey != "" {
-		c.ApiKey = cfg.ApiKey
+	if v := os.Getenv("FREE_GENERATIONS_PER_MONTH"); v != "" {
+		c.FreeGenerationsPerMonth = atoiOrDefault(v, c.FreeGenerationsPerMonth)
+	}
+	if v := os.Getenv("CHAT_GENERATION_CREDITS"); v != "" {
+		c.ChatGenerationCredits = atoiOrDefault(v, c.ChatGenerationCredits)
+	}
+	if v := os.Getenv("JOBS_CONCURRENCY"); v != "" {
+		c.JobsConcurrency = atoiOrDefault(v, c.JobsConcurrency)
+	}
+	if v := os.Getenv("REPROCESS_THROTTLE_MS"); v != "" {
+		c.ReprocessThrottleMs = atoiOrDefault(v, c.ReprocessThrottleMs)
+	}
+	if v := os.Getenv("UNSPLASH_QUOTA_RESERVE"); v != "" {
+		c.UnsplashQuotaReserve = atoiOrDefault(v, c.UnsplashQuotaReserve)
+	}
+	if v := os.Getenv("UNSPLASH_CACHE_TTL_HOURS"); v != "" {
+		c.UnsplashCacheTTLHours = atoiOrDefault(v, c.UnsplashCacheTTLHours)
+	}
+	if v := os.Getenv("TENANT_DELETION_GRACE_DAYS"); v != "" {
+		c.TenantDeletionGraceDays = atoiOrDefault(v, c.TenantDeletionGraceDays)
+	}
+	if v := os.Getenv("DOMAIN_RENEWAL_PRICE_CENTS"); v != "" {
+		c.DomainRenewalPriceCents = atoiOrDefault(v, c.DomainRenewalPriceCents)
+	}
+	if v := os.Getenv("FILESYSTEM_SEARCH_MAX_BYTES"); v != "" {
+		c.FilesystemSearchMaxBytes = atoiOrDefault(v, c.FilesystemSearchMaxBytes)
+	}
+	if v := os.Getenv("DOMAIN_RENEWAL_CURRENCY"); v != "" {
+		c.DomainRenewalCurrency = strings.ToLower(v)
+	}
+	if v := os.Getenv("MODERATION_ENABLED"); v != "" {
+		c.ModerationEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("MODERATION_MODE"); v != "" {
+		c.ModerationMode = strings.ToLower(v)
+	}
+	if v := os.Getenv("MODERATION_PROVIDER"); v != "" {
+		c.ModerationProvider = strings.ToLower(v)
+	}
+	if v := os.Getenv("MODERATION_ENDPOINT_URL"); v != "" {
+		c.ModerationEndpointURL = v
+	}
+	if v := os.Getenv("API_DOCS_ENABLED"); v != "" {
+		c.ApiDocsEnabled = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("GZIP_MIN_BYTES"); v != "" {
+		c.GzipMinBytes = atoiOrDefault(v, c.GzipMinBytes)
+	}
+	if v := os.Getenv("CHAT_DONE_INLINE_CONTENT"); v != "" {
+		c.ChatDoneInlineContent = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CHAT_CONTENT_PREVIEW_RUNES"); v != "" {
+		c.ChatContentPreviewRunes = atoiOrDefault(v, c.ChatContentPreviewRunes)
+	}
+	if v := os.Getenv("CHAT_FULL_CONTENT"); v != "" {
+		c.ChatFullContent = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CHAT_DEDUP_TTL_SECONDS"); v != "" {
+		c.ChatDedupTTLSeconds = atoiOrDefault(v, c.ChatDedupTTLSeconds)
+	}
+	if v := os.Getenv("LOGIN_MAX_ATTEMPTS"); v != "" {
+		c.LoginMaxAttempts = atoiOrDefault(v, c.LoginMaxAttempts)
+	}
+	if v := os.Getenv("LOGIN_IP_MAX_ATTEMPTS"); v != "" {
+		c.LoginIPMaxAttempts = atoiOrDefault(v, c.LoginIPMaxAttempts)
+	}
+	if v := os.Getenv("LOGIN_LOCKOUT_MINUTES"); v != "" {
+		c.LoginLockoutMinutes = atoiOrDefault(v, c.LoginLockoutMinutes)
+	}
+	if v := os.Getenv("CONTACT_RATE_LIMIT_PER_HOUR"); v != "" {
+		c.ContactRateLimitPerHour = atoiOrDefault(v, c.ContactRateLimitPerHour)
 	}
-	if cfg.ApiKeySecret != "" {
-		c.ApiKeySecret = cfg.ApiKeySecret
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		c.PasswordMinLength = atoiOrDefault(v, c.PasswordMinLength)
 	}
-	if cfg.ApiFrontendKey != "" {
-		c.ApiFrontendKey = cfg.ApiFrontendKey
+	if v := os.Getenv("PASSWORD_MAX_LENGTH"); v != "" {
+		c.PasswordMaxLength = atoiOrDefault(v, c.PasswordMaxLength)
 	}
-	if cfg.MinInputTokens != 0 {
-		c.MinInputTokens = cfg.MinInputTokens
+	if v := os.Getenv("IDEMPOTENCY_TTL_HOURS"); v != "" {
+		c.IdempotencyTTLHours = atoiOrDefault(v, c.IdempotencyTTLHours)
 	}
-	if cfg.MaxInputTokens != 0 {
-		c.MaxInputTokens = cfg.MaxInputTokens
+	if v := os.Getenv("IDEMPOTENCY_MAX_BODY_BYTES"); v != "" {
+		c.IdempotencyMaxBodyBytes = atoiOrDefault(v, c.IdempotencyMaxBodyBytes)
 	}
-	if cfg.MaxOutputTokens != 0 {
-		c.MaxOutputTokens = cfg.MaxOutputTokens
+	if v := os.Getenv("IDEMPOTENCY_WAIT_SECONDS"); v != "" {
+		c.IdempotencyWaitSeconds = atoiOrDefault(v, c.IdempotencyWaitSeconds)
 	}
-	if cfg.RedisAddr != "" {
-		c.RedisAddr = cfg.RedisAddr
+	if v := os.Getenv("FREE_MAX_TENANTS"); v != "" {
+		c.FreeMaxTenants = atoiOrDefault(v, c.FreeMaxTenants)
 	}
-	if cfg.RedisPassword != "" {
-		c.RedisPassword = cfg.RedisPassword
+	if v := os.Getenv("SECTION_PROCESSING_CONCURRENCY"); v != "" {
+		c.SectionConcurrency = atoiOrDefault(v, c.SectionConcurrency)
 	}
-	if cfg.RedisPrefix != "" {
-		c.RedisPrefix = cfg.RedisPrefix
+	if v := os.Getenv("PROCESSOR_TIMEOUT_SECONDS"); v != "" {
+		c.ProcessorTimeoutSeconds = atoiOrDefault(v, c.ProcessorTimeoutSeconds)
 	}
-	if cfg.ListenAddr != "" {
-		c.ListenAddr = cfg.ListenAddr
+	if v := os.Getenv("SHARE_LINK_DAYS"); v != "" {
+		c.ShareLinkDays = atoiOrDefault(v, c.ShareLinkDays)
 	}
-	if len(cfg.EnabledModels) > 0 {
-		c.EnabledModels = cfg.EnabledModels
+	if v := os.Getenv("SHARE_LINK_MAX_DAYS"); v != "" {
+		c.ShareLinkMaxDays = atoiOrDefault(v, c.ShareLinkMaxDays)
 	}
-	if len(cfg.EnabledProcessors) > 0 {
-		c.EnabledProcessors = cfg.EnabledProcessors
+	if v := os.Getenv("PREVIEW_UNLOCK_PER_HOUR"); v != "" {
+		c.PreviewUnlockPerHour = atoiOrDefault(v, c.PreviewUnlockPerHour)
 	}
-	if cfg.DefaultModel != "" {
-		c.DefaultModel = cfg.DefaultModel
+	if v := os.Getenv("DRAFT_MAX_AGE_DAYS"); v != "" {
+		c.DraftMaxAgeDays = atoiOrDefault(v, c.DraftMaxAgeDays)
 	}
-	if cfg.PromptFormat != "" {
-		c.PromptFormat = cfg.PromptFormat
+	if v := os.Getenv("REQUEST_LOG_ENABLED"); v != "" {
+		c.RequestLogEnabled = strings.ToLower(v) == "true" || v == "1"
 	}
-	if cfg.PromptName != "" {
-		c.PromptName = cfg.PromptName
+	if v := os.Getenv("REQUEST_LOG_SUCCESS_SAMPLE_PERCENT"); v != "" {
+		c.RequestLogSuccessSamplePercent = atoiOrDefault(v, c.RequestLogSuccessSamplePercent)
 	}
-	if cfg.UnsplashAPIAccessKey != "" {
-		c.UnsplashAPIAccessKey = cfg.UnsplashAPIAccessKey
+	if v := os.Getenv("REQUEST_LOG_RETENTION_DAYS"); v != "" {
+		c.RequestLogRetentionDays = atoiOrDefault(v, c.RequestLogRetentionDays)
 	}
-	if cfg.UnsplashAPISecretKey != "" {
-		c.UnsplashAPISecretKey = cfg.UnsplashAPISecretKey
+	if v := os.Getenv("METRICS_ENABLED"); v != "" {
+		c.MetricsEnabled = strings.ToLower(v) == "true" || v == "1"
 	}
-	c.MockResponse = cfg.MockResponse
-	c.PostProcessMockResponses = cfg.PostProcessMockResponses
-	if cfg.MockContent != "" {
-		c.MockContent = cfg.MockContent
+	if v := os.Getenv("METRICS_TENANT_ALLOWLIST"); v != "" {
+		c.MetricsTenantAllowlist = strings.Split(v, ",")
 	}
-	if cfg.VarDir != "" {
-		c.VarDir = cfg.VarDir
+	if v := os.Getenv("METRICS_TENANT_BUCKETS"); v != "" {
+		c.MetricsTenantBuckets = atoiOrDefault(v, c.MetricsTenantBuckets)
+	}
+	if v := os.Getenv("METRICS_MAX_TENANT_LABELS"); v != "" {
+		c.MetricsMaxTenantLabels = atoiOrDefault(v, c.MetricsMaxTenantLabels)
+	}
+	if v := os.Getenv("MAINTENANCE_RETRY_AFTER_SECONDS"); v != "" {
+		c.MaintenanceRetryAfterSeconds = atoiOrDefault(v, c.MaintenanceRetryAfterSeconds)
+	}
+	if v := os.Getenv("SERVER_MODE"); v != "" {
+		c.ServerMode = strings.ToLower(v)
+	}
+	if v := os.Getenv("PROMPT_VARIABLES"); v != "" {
+		c.PromptVariables = strings.Split(v, ",")
+	}
+	if v := os.Getenv("PROMPT_LINT_STRICT"); v != "" {
+		c.PromptLintStrict = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("STATIC_BASE_PATH"); v != "" {
+		c.StaticBasePath = v
+	}
+	if v := os.Getenv("STATIC_IMMUTABLE_PREFIXES"); v != "" {
+		c.StaticImmutablePrefixes = strings.Split(v, ",")
 	}
-	c.SaveResponses = cfg.SaveResponses
-	c.SendThinking = cfg.SendThinking
 }
 
 func (c *Config) updateMaps() {
@@ -338,6 +1101,18 @@ func atoiOrDefault(s string, def int) int {
 	return n
 }
 
+// atoiList parses a comma-separated list of integers, skipping invalid entries
+func atoiList(s string) []int {
+	var list []int
+	for _, part := range strings.Split(s, ",") {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(part), "%d", &n); err == nil {
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
 func (c *Config) IsProcessorEnabled(name string) bool {
 	_, ok := c.enabledProcessorsMap[name]
 	return ok
@@ -348,6 +1123,18 @@ func (c *Config) IsModelEnabled(name string) bool {
 	return ok
 }
 
+// GetThinkingMode returns thinking_mode, or full or placeholder depending on
+// send_thinking when it isn't set
+func (c *Config) GetThinkingMode() string {
+	if c.ThinkingMode != "" {
+		return c.ThinkingMode
+	}
+	if c.SendThinking {
+		return THINKING_MODE_FULL
+	}
+	return THINKING_MODE_PLACEHOLDER
+}
+
 func (c *Config) GetDefaultModel() (string, bool) {
 	defaultModel := c.DefaultModel
 
@@ -358,3 +1145,45 @@ func (c *Config) GetDefaultModel() (string, bool) {
 
 	return defaultModel, true
 }
+
+// ChatTrashRetention is how long deleted chats can be restored
+func (c *Config) ChatTrashRetention() time.Duration {
+	return time.Duration(c.ChatTrashRetentionDays) * 24 * time.Hour
+}
+
+const REDACTED = "[redacted]"
+
+// secrets returns pointers to every secret setting
+func (c *Config) secrets() []*string {
+	return []*string{
+		&c.RedisPassword,
+		&c.RedisSentinelPassword,
+		&c.UnsplashAPIAccessKey,
+		&c.UnsplashAPISecretKey,
+		&c.ApiKey,
+		&c.ApiKeySecret,
+		&c.ApiFrontendKey,
+		&c.OauthGoogleClientSecret,
+		&c.OauthFacebookClientSecret,
+		&c.OauthTikTokClientSecret,
+		&c.DomainRegistrarAPIKey,
+		&c.DomainRegistrarSecret,
+	}
+}
+
+// Redacted returns a copy of the config that is safe to log, with every
+// secret that is set replaced by REDACTED
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, secret := range redacted.secrets() {
+		if *secret != "" {
+			*secret = REDACTED
+		}
+	}
+	return &redacted
+}
+
+// LogValue makes slog log the redacted config
+func (c *Config) LogValue() slog.Value {
+	return slog.AnyValue(*c.Redacted())
+}
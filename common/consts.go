@@ -6,20 +6,151 @@ const (
 	DEFAULT_PROMPT_NAME        = "prompt4"
 	DEFAULT_CONFIG_DIR         = ".config/"
 	DEFAULT_CONFIG_FILE        = "config.json"
+	DEFAULT_APP_ENV            = "production"
 
 	DEFAULT_MIN_INPUT_TOKENS  = 1
 	DEFAULT_MAX_INPUT_TOKENS  = 200000
 	DEFAULT_MAX_OUTPUT_TOKENS = 400000
-	DEFAULT_REDIS_ADDR        = "localhost:6379"
-	DEFAULT_REDIS_PASSWORD    = ""
-	DEFAULT_REDIS_PREFIX      = "awning:"
-	DEFAULT_LISTEN_ADDR       = ":4000"
+	DEFAULT_CONTEXT_WINDOW    = 262144
+
+	DEFAULT_HISTORY_COMPACTION_THRESHOLD_TOKENS = 20000
+	DEFAULT_HISTORY_SUMMARY_STRATEGY            = "heuristic"
+	DEFAULT_HISTORY_USER_MESSAGE_MAX_CHARS      = 4000
+	DEFAULT_REDIS_ADDR                          = "localhost:6379"
+	DEFAULT_REDIS_PASSWORD                      = ""
+	DEFAULT_REDIS_PREFIX                        = "awning:"
+	DEFAULT_LISTEN_ADDR                         = ":4000"
+
+	DEFAULT_REDIS_MODE             = "single"
+	DEFAULT_REDIS_DIAL_TIMEOUT_MS  = 5000
+	DEFAULT_REDIS_READ_TIMEOUT_MS  = 3000
+	DEFAULT_REDIS_WRITE_TIMEOUT_MS = 3000
 
 	DEFAULT_ENABLED_MODELS = "qwen/qwen3-next-80b-a3b-thinking-maas"
 	DEFAULT_MODEL          = "qwen/qwen3-next-80b-a3b-thinking-maas"
 
 	DEFAULT_VAR_DIR = ".var"
 
+	DEFAULT_FREE_GENERATIONS_PER_MONTH    = 3
+	DEFAULT_CHAT_COMPLETE_TIMEOUT_SECONDS = 120
+	DEFAULT_VERTEX_TOKEN_REFRESH_SECONDS  = 300
+	DEFAULT_STREAM_MAX_EVENT_BYTES        = 1 << 20
+	DEFAULT_OUTPUT_GUARD_MAX_BYTES        = 4 << 20
+	DEFAULT_TENANT_DELETION_GRACE_DAYS    = 30
+	DEFAULT_JOBS_CONCURRENCY              = 4
+	DEFAULT_REPROCESS_THROTTLE_MS         = 1000
+	DEFAULT_DOMAIN_RENEWAL_PRICE_CENTS    = 1450
+	DEFAULT_DOMAIN_RENEWAL_CURRENCY       = "usd"
+
+	DEFAULT_FILESYSTEM_SEARCH_MAX_BYTES = 1 << 20
+
+	DEFAULT_MODERATION_MODE = "block"
+
+	DEFAULT_GZIP_MIN_BYTES = 1024
+
+	// Runes of message content GET /api/v1/chat/:id sends before cutting it
+	// to a preview
+	DEFAULT_CHAT_CONTENT_PREVIEW_RUNES = 500
+
+	// Seconds an identical chat request is answered with the first one's
+	// result
+	DEFAULT_CHAT_DEDUP_TTL_SECONDS = 120
+
+	DEFAULT_STATIC_BASE_PATH          = "/"
+	DEFAULT_STATIC_IMMUTABLE_PREFIXES = "/assets/"
+
+	// Headers carrying the client IP, tried in order for requests from
+	// trusted proxies
+	DEFAULT_CLIENT_IP_HEADERS = "X-Forwarded-For,X-Real-IP"
+
+	// Below the job queue's 5 minute lease, so a running generation is
+	// never handed to a second worker
+	DEFAULT_ASYNC_GENERATION_TIMEOUT_SECONDS    = 240
+	DEFAULT_ASYNC_GENERATION_RESULT_TTL_MINUTES = 60
+
+	DEFAULT_MULTI_PAGE_MAX_PAGES   = 6
+	DEFAULT_MULTI_PAGE_CONCURRENCY = 1
+	MAX_MULTI_PAGE_PAGES           = 10
+	MAX_MULTI_PAGE_CONCURRENCY     = 4
+
+	DEFAULT_CLARIFICATION_MAX_QUESTIONS   = 5
+	DEFAULT_CLARIFICATION_TIMEOUT_SECONDS = 900
+	MAX_CLARIFICATION_QUESTIONS           = 10
+
+	DEFAULT_LOGIN_MAX_ATTEMPTS    = 5
+	DEFAULT_LOGIN_IP_MAX_ATTEMPTS = 20
+	DEFAULT_LOGIN_LOCKOUT_MINUTES = 15
+
+	DEFAULT_CONTACT_RATE_LIMIT_PER_HOUR = 5
+
+	// bcrypt ignores password bytes beyond 72
+	DEFAULT_PASSWORD_MIN_LENGTH = 8
+	DEFAULT_PASSWORD_MAX_LENGTH = 72
+	DEFAULT_PASSWORD_DENYLIST   = "password,password1,password123,passw0rd,12345678,123456789,1234567890,87654321,11111111,00000000,qwerty123,qwertyuiop,1q2w3e4r,asdfghjkl,iloveyou,letmein1,welcome1,sunshine,football,baseball,superman,trustno1,princess,starwars,dragon123,monkey123,abc12345,changeme,admin123"
+
+	DEFAULT_IDEMPOTENCY_TTL_HOURS      = 24
+	DEFAULT_IDEMPOTENCY_MAX_BODY_BYTES = 64 * 1024
+	DEFAULT_IDEMPOTENCY_WAIT_SECONDS   = 5
+
+	DEFAULT_FREE_MAX_TENANTS = 1
+
+	THINKING_MODE_OFF         = "off"
+	THINKING_MODE_PLACEHOLDER = "placeholder"
+	THINKING_MODE_THROTTLED   = "throttled"
+	THINKING_MODE_FULL        = "full"
+
+	DEFAULT_THINKING_INTERVAL_MS = 1000
+	DEFAULT_THINKING_MAX_CHARS   = 2000
+
+	DEFAULT_SECTION_PROCESSING_CONCURRENCY = 4
+
+	DEFAULT_PROCESSOR_TIMEOUT_SECONDS = 20
+
+	DEFAULT_SHARE_LINK_DAYS     = 7
+	DEFAULT_SHARE_LINK_MAX_DAYS = 30
+
+	DEFAULT_PREVIEW_UNLOCK_PER_HOUR = 10
+
+	DEFAULT_DRAFT_MAX_AGE_DAYS = 30
+
+	DEFAULT_CHAT_TRASH_RETENTION_DAYS = 30
+
+	DEFAULT_CHAT_SPILL                 = "dir"
+	DEFAULT_CHAT_SPILL_DIR             = "data/chat-spill"
+	DEFAULT_CHAT_SPILL_ALERT_THRESHOLD = 25
+
+	DEFAULT_REDIS_REAPER_INTERVAL_HOURS = 24
+	DEFAULT_REDIS_REAPER_SCAN_COUNT     = 100
+	DEFAULT_REDIS_REAPER_SCAN_PAUSE_MS  = 50
+
+	DEFAULT_REQUEST_LOG_SUCCESS_SAMPLE_PERCENT = 10
+	DEFAULT_REQUEST_LOG_RETENTION_DAYS         = 14
+
+	DEFAULT_METRICS_MAX_TENANT_LABELS = 50
+	MAX_METRICS_TENANT_BUCKETS        = 100
+
+	DEFAULT_MAINTENANCE_RETRY_AFTER_SECONDS = 300
+
+	DEFAULT_BRAND_VOICE_DENYLIST = "ignore previous instructions,ignore all previous,ignore the above,disregard previous,disregard all,forget your instructions,system prompt,you are now,new instructions"
+
+	DEFAULT_IMAGE_STORE_LOCAL_DIR       = ".var/images"
+	DEFAULT_IMAGE_STORE_PUBLIC_BASE_URL = "/media"
+	DEFAULT_IMAGE_REHOST_CONCURRENCY    = 4
+
+	DEFAULT_IMAGE_HERO_WIDTHS    = "1280,1920,2560"
+	DEFAULT_IMAGE_CARD_WIDTHS    = "320,480,640"
+	DEFAULT_IMAGE_DEFAULT_WIDTHS = "640,1080,1600"
+
+	DEFAULT_IMAGE_PER_QUERY  = 5
+	DEFAULT_TAILWIND_CSS_URL = "https://cdnjs.cloudflare.com/ajax/libs/tailwindcss/2.2.19/tailwind.min.css"
+
+	// Searches left of Unsplash's hourly limit that aren't used, and how
+	// long search results are cached
+	DEFAULT_UNSPLASH_QUOTA_RESERVE   = 5
+	DEFAULT_UNSPLASH_CACHE_TTL_HOURS = 24
+
+	DEFAULT_SITE_BASE_DOMAIN = "awning.site"
+
 	// Unsplash API constants
 	UNSPLASH_API_BASE_URL = "https://api.unsplash.com"
 )
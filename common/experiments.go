@@ -0,0 +1,49 @@
+package common
+
+import "hash/fnv"
+
+// PromptExperiment sends a share of new chats to an alternative base prompt.
+// Template is a prompt name, loaded from prompts/<prompt_format>/<template>-base.md
+// like prompt_name.
+type PromptExperiment struct {
+	Name           string `json:"name"`
+	Template       string `json:"template"`
+	TrafficPercent int    `json:"traffic_percent"`
+}
+
+// AssignPromptVariant picks the experiment for a new chat, or "" for the
+// default template. Chat IDs are hashed into 100 buckets which are handed out
+// to experiments in config order, so a chat always gets the same variant for
+// the same config.
+func AssignPromptVariant(chatID string, experiments []PromptExperiment) string {
+	h := fnv.New32a()
+	h.Write([]byte(chatID))
+	bucket := int(h.Sum32() % 100)
+
+	upper := 0
+	for _, e := range experiments {
+		if e.TrafficPercent <= 0 {
+			continue
+		}
+		upper += e.TrafficPercent
+		if bucket < upper {
+			return e.Name
+		}
+	}
+	return ""
+}
+
+// IsPromptExperimentActive reports whether the named experiment is configured
+// with traffic. Chats assigned to an experiment that has since been turned
+// off use the default template.
+func (c *Config) IsPromptExperimentActive(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, e := range c.PromptExperiments {
+		if e.Name == name {
+			return e.TrafficPercent > 0
+		}
+	}
+	return false
+}
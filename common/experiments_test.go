@@ -0,0 +1,82 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestAssignPromptVariantDeterministic(t *testing.T) {
+	experiments := []PromptExperiment{{Name: "warm", Template: "warm", TrafficPercent: 50}}
+	for i := range 100 {
+		chatID := fmt.Sprintf("chat-%d", i)
+		first := AssignPromptVariant(chatID, experiments)
+		for range 3 {
+			if got := AssignPromptVariant(chatID, experiments); got != first {
+				t.Fatalf("AssignPromptVariant(%q) = %q, then %q", chatID, first, got)
+			}
+		}
+	}
+
+	// Adding an experiment after the others keeps existing buckets
+	more := append(experiments, PromptExperiment{Name: "bold", Template: "bold", TrafficPercent: 20})
+	for i := range 1000 {
+		chatID := fmt.Sprintf("chat-%d", i)
+		if before := AssignPromptVariant(chatID, experiments); before == "warm" && AssignPromptVariant(chatID, more) != "warm" {
+			t.Errorf("chat %s moved off warm when bold was added", chatID)
+		}
+	}
+}
+
+func TestAssignPromptVariantProportions(t *testing.T) {
+	tests := []struct {
+		name        string
+		experiments []PromptExperiment
+		want        map[string]float64
+	}{
+		{"none", nil, map[string]float64{"": 1}},
+		{"half", []PromptExperiment{{Name: "a", TrafficPercent: 50}}, map[string]float64{"a": 0.5, "": 0.5}},
+		{"split", []PromptExperiment{{Name: "a", TrafficPercent: 10}, {Name: "b", TrafficPercent: 30}}, map[string]float64{"a": 0.1, "b": 0.3, "": 0.6}},
+		{"all", []PromptExperiment{{Name: "a", TrafficPercent: 100}}, map[string]float64{"a": 1}},
+		// Turned off experiments get nothing and take no buckets
+		{"off", []PromptExperiment{{Name: "a", TrafficPercent: 0}, {Name: "b", TrafficPercent: 25}}, map[string]float64{"b": 0.25, "": 0.75}},
+	}
+	const chats = 20000
+	for _, tt := range tests {
+		counts := map[string]int{}
+		for range chats {
+			counts[AssignPromptVariant(RandomID(), tt.experiments)]++
+		}
+		for variant, share := range tt.want {
+			if got := float64(counts[variant]) / chats; math.Abs(got-share) > 0.02 {
+				t.Errorf("%s: variant %q got %.3f of chats, want %.2f", tt.name, variant, got, share)
+			}
+		}
+		for variant := range counts {
+			if _, ok := tt.want[variant]; !ok {
+				t.Errorf("%s: unexpected variant %q", tt.name, variant)
+			}
+		}
+	}
+}
+
+func TestIsPromptExperimentActive(t *testing.T) {
+	cfg := &Config{PromptExperiments: []PromptExperiment{
+		{Name: "warm", Template: "warm", TrafficPercent: 20},
+		{Name: "paused", Template: "paused", TrafficPercent: 0},
+	}}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"warm", true},
+		{"paused", false},
+		{"removed", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.IsPromptExperimentActive(tt.name); got != tt.want {
+			t.Errorf("IsPromptExperimentActive(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
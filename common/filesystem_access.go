@@ -0,0 +1,55 @@
+package common
+
+import (
+	"slices"
+	"strings"
+)
+
+// Access levels of a filesystem namespace
+const (
+	FilesystemAccessRead  = "read"
+	FilesystemAccessWrite = "write" // implies read
+)
+
+// FilesystemRoles are the membership roles namespace access is granted to
+var FilesystemRoles = []string{"owner", "admin", "member"}
+
+// FilesystemNamespace grants access to the filesystem keys under Prefix by
+// membership role. A key belongs to the longest prefix it matches, ignoring
+// a leading slash. Roles not listed have no access; server code writes
+// through filesystem.SaveEntry, which is not checked.
+type FilesystemNamespace struct {
+	Prefix string            `json:"prefix"`
+	Access map[string]string `json:"access"`
+}
+
+// DefaultFilesystemNamespaces are the namespaces of every plan, applied
+// unless filesystem_namespaces sets a prefix for the tenant's plan
+var DefaultFilesystemNamespaces = []FilesystemNamespace{
+	{Prefix: "", Access: map[string]string{"owner": FilesystemAccessWrite, "admin": FilesystemAccessWrite, "member": FilesystemAccessWrite}},
+	{Prefix: "site/", Access: map[string]string{"owner": FilesystemAccessWrite, "admin": FilesystemAccessWrite, "member": FilesystemAccessWrite}},
+	{Prefix: "settings/", Access: map[string]string{"owner": FilesystemAccessWrite, "admin": FilesystemAccessWrite}},
+	{Prefix: "system/", Access: map[string]string{}},
+}
+
+// FilesystemNamespacesFor returns the namespaces of a subscription plan:
+// DefaultFilesystemNamespaces with those of filesystem_namespaces for the
+// plan replacing the ones of the same prefix, sorted by prefix
+func (c *Config) FilesystemNamespacesFor(plan string) []FilesystemNamespace {
+	byPrefix := map[string]FilesystemNamespace{}
+	for _, ns := range DefaultFilesystemNamespaces {
+		byPrefix[ns.Prefix] = ns
+	}
+	for _, ns := range c.FilesystemNamespaces[plan] {
+		byPrefix[ns.Prefix] = ns
+	}
+
+	namespaces := make([]FilesystemNamespace, 0, len(byPrefix))
+	for _, ns := range byPrefix {
+		namespaces = append(namespaces, ns)
+	}
+	slices.SortFunc(namespaces, func(a, b FilesystemNamespace) int {
+		return strings.Compare(a.Prefix, b.Prefix)
+	})
+	return namespaces
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ranges accepted for generation parameters
+const (
+	MIN_TEMPERATURE = 0.0
+	MAX_TEMPERATURE = 2.0
+)
+
+var ErrInvalidGenerationParams = errors.New("invalid generation parameters")
+
+// GenerationParams are the sampling parameters sent with a generation. Unset
+// fields are left to the model's own defaults.
+type GenerationParams struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxOutputTokens *int     `json:"max_output_tokens,omitempty"`
+}
+
+// Validate checks the set fields: temperature from 0 to 2, top_p above 0 up
+// to 1 and max_output_tokens of at least 1
+func (p GenerationParams) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < MIN_TEMPERATURE || *p.Temperature > MAX_TEMPERATURE) {
+		return fmt.Errorf("%w: temperature must be between %g and %g", ErrInvalidGenerationParams, MIN_TEMPERATURE, MAX_TEMPERATURE)
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		return fmt.Errorf("%w: top_p must be above 0 and at most 1", ErrInvalidGenerationParams)
+	}
+	if p.MaxOutputTokens != nil && *p.MaxOutputTokens < 1 {
+		return fmt.Errorf("%w: max_output_tokens must be at least 1", ErrInvalidGenerationParams)
+	}
+	return nil
+}
+
+// ResolveGenerationParams returns the parameters of a generation on model:
+// the requested fields over the model's model_params defaults, with
+// max_output_tokens capped at the model's output token limit. Requested
+// values out of range are rejected.
+func (c *Config) ResolveGenerationParams(model string, requested *GenerationParams) (GenerationParams, error) {
+	params := c.ModelParams[model]
+	if requested != nil {
+		if err := requested.Validate(); err != nil {
+			return GenerationParams{}, err
+		}
+		if requested.Temperature != nil {
+			params.Temperature = requested.Temperature
+		}
+		if requested.TopP != nil {
+			params.TopP = requested.TopP
+		}
+		if requested.MaxOutputTokens != nil {
+			params.MaxOutputTokens = requested.MaxOutputTokens
+		}
+	}
+
+	if limit := c.LimitsFor(model).MaxOutputTokens; params.MaxOutputTokens != nil && limit > 0 && *params.MaxOutputTokens > limit {
+		params.MaxOutputTokens = &limit
+	}
+	return params, nil
+}
+
+// ModelLimits are the token limits of a model. The context window holds
+// both the prompt and the output.
+type ModelLimits struct {
+	ContextWindow   int `json:"context_window,omitempty"`
+	MaxInputTokens  int `json:"max_input_tokens,omitempty"`
+	MaxOutputTokens int `json:"max_output_tokens,omitempty"`
+}
+
+// DefaultModelLimits are the published limits of models we use, applied
+// unless model_limits sets them
+var DefaultModelLimits = map[string]ModelLimits{
+	"qwen/qwen3-next-80b-a3b-thinking-maas": {ContextWindow: 262144},
+	"google/gemini-2.5-pro":                 {ContextWindow: 1048576, MaxOutputTokens: 65536},
+	"google/gemini-2.5-flash":               {ContextWindow: 1048576, MaxOutputTokens: 65536},
+}
+
+// PromptTooLongError is returned by FitOutputBudget for a prompt over the
+// model's input limit
+type PromptTooLongError struct {
+	PromptTokens int
+	Limits       ModelLimits
+}
+
+func (e *PromptTooLongError) Error() string {
+	return fmt.Sprintf("input of %d tokens exceeds the limit of %d", e.PromptTokens, e.Limits.MaxInputTokens)
+}
+
+// LimitsFor returns the token limits of model: model_limits, then
+// DefaultModelLimits, then the context_window, max_input_tokens and
+// max_output_tokens settings, field by field. The input limit is kept
+// below the context window.
+func (c *Config) LimitsFor(model string) ModelLimits {
+	limits := c.ModelLimits[model]
+	defaults := DefaultModelLimits[model]
+
+	pick := func(values ...int) int {
+		for _, v := range values {
+			if v > 0 {
+				return v
+			}
+		}
+		return 0
+	}
+	limits.ContextWindow = pick(limits.ContextWindow, defaults.ContextWindow, c.ContextWindow)
+	limits.MaxInputTokens = pick(limits.MaxInputTokens, defaults.MaxInputTokens, c.MaxInputTokens)
+	limits.MaxOutputTokens = pick(limits.MaxOutputTokens, defaults.MaxOutputTokens, c.MaxOutputTokens)
+
+	if limits.ContextWindow > 0 && (limits.MaxInputTokens <= 0 || limits.MaxInputTokens >= limits.ContextWindow) {
+		limits.MaxInputTokens = limits.ContextWindow - 1
+	}
+	return limits
+}
+
+// FitOutputBudget checks a prompt of promptTokens against the model's input
+// limit and caps params.MaxOutputTokens at what is left of the context
+// window, setting it when unset. Prompts over the limit return a
+// *PromptTooLongError.
+func (c *Config) FitOutputBudget(model string, promptTokens int, params *GenerationParams) error {
+	limits := c.LimitsFor(model)
+	if promptTokens > limits.MaxInputTokens {
+		return &PromptTooLongError{PromptTokens: promptTokens, Limits: limits}
+	}
+
+	budget := limits.ContextWindow - promptTokens
+	if limits.MaxOutputTokens > 0 {
+		budget = min(budget, limits.MaxOutputTokens)
+	}
+	if params.MaxOutputTokens == nil || *params.MaxOutputTokens > budget {
+		params.MaxOutputTokens = &budget
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// describe formats params for test messages, with "-" for unset fields
+func describe(p GenerationParams) string {
+	format := func(v any) string {
+		switch v := v.(type) {
+		case *float64:
+			if v != nil {
+				return fmt.Sprintf("%g", *v)
+			}
+		case *int:
+			if v != nil {
+				return fmt.Sprint(*v)
+			}
+		}
+		return "-"
+	}
+	return format(p.Temperature) + "/" + format(p.TopP) + "/" + format(p.MaxOutputTokens)
+}
+
+func TestResolveGenerationParams(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ModelLimits = map[string]ModelLimits{"m": {ContextWindow: 10000, MaxOutputTokens: 4096}}
+	cfg.ModelParams = map[string]GenerationParams{"m": {Temperature: ptr(0.7), MaxOutputTokens: ptr(2048)}}
+
+	tests := []struct {
+		name      string
+		model     string
+		requested *GenerationParams
+		want      string
+	}{
+		{"model defaults", "m", nil, "0.7/-/2048"},
+		{"requested over defaults", "m", &GenerationParams{Temperature: ptr(1.5), TopP: ptr(0.9)}, "1.5/0.9/2048"},
+		{"capped at the output limit", "m", &GenerationParams{MaxOutputTokens: ptr(100000)}, "0.7/-/4096"},
+		{"under the limit", "m", &GenerationParams{MaxOutputTokens: ptr(10)}, "0.7/-/10"},
+		{"bounds accepted", "m", &GenerationParams{Temperature: ptr(0.0), TopP: ptr(1.0)}, "0/1/2048"},
+		{"model without defaults", "other", &GenerationParams{Temperature: ptr(2.0)}, "2/-/-"},
+	}
+	for _, tt := range tests {
+		got, err := cfg.ResolveGenerationParams(tt.model, tt.requested)
+		if err != nil {
+			t.Errorf("%s: ResolveGenerationParams() error = %v", tt.name, err)
+			continue
+		}
+		if describe(got) != tt.want {
+			t.Errorf("%s: ResolveGenerationParams() = %s, want %s", tt.name, describe(got), tt.want)
+		}
+	}
+
+	// The model's defaults aren't changed by a request
+	if got := describe(cfg.ModelParams["m"]); got != "0.7/-/2048" {
+		t.Errorf("model_params after resolving = %s", got)
+	}
+}
+
+func TestResolveGenerationParamsRejects(t *testing.T) {
+	cfg := DefaultConfig()
+	for _, requested := range []GenerationParams{
+		{Temperature: ptr(-0.1)},
+		{Temperature: ptr(2.1)},
+		{TopP: ptr(0.0)},
+		{TopP: ptr(1.1)},
+		{MaxOutputTokens: ptr(0)},
+	} {
+		if _, err := cfg.ResolveGenerationParams("m", &requested); !errors.Is(err, ErrInvalidGenerationParams) {
+			t.Errorf("ResolveGenerationParams(%s) error = %v, want ErrInvalidGenerationParams", describe(requested), err)
+		}
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ContextWindow, cfg.MaxInputTokens, cfg.MaxOutputTokens = 50000, 40000, 8000
+	cfg.ModelLimits = map[string]ModelLimits{
+		"google/gemini-2.5-flash": {MaxOutputTokens: 1000},
+		"small":                   {ContextWindow: 4000},
+	}
+
+	tests := []struct {
+		model string
+		want  ModelLimits
+	}{
+		// Configured fields over the built-in defaults, field by field
+		{"google/gemini-2.5-flash", ModelLimits{ContextWindow: 1048576, MaxInputTokens: 40000, MaxOutputTokens: 1000}},
+		{"google/gemini-2.5-pro", ModelLimits{ContextWindow: 1048576, MaxInputTokens: 40000, MaxOutputTokens: 65536}},
+		// The input limit is kept below the window
+		{"small", ModelLimits{ContextWindow: 4000, MaxInputTokens: 3999, MaxOutputTokens: 8000}},
+		// Unknown models use the global settings
+		{"other/model", ModelLimits{ContextWindow: 50000, MaxInputTokens: 40000, MaxOutputTokens: 8000}},
+	}
+	for _, tt := range tests {
+		if got := cfg.LimitsFor(tt.model); got != tt.want {
+			t.Errorf("LimitsFor(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestFitOutputBudgetFollowsDefaultModel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ModelLimits = map[string]ModelLimits{
+		"a": {ContextWindow: 10000, MaxOutputTokens: 20000},
+		"b": {ContextWindow: 100000, MaxOutputTokens: 20000},
+	}
+
+	// Switching models changes the limits a prompt is held to
+	params := GenerationParams{}
+	if err := cfg.FitOutputBudget("a", 9000, &params); err != nil || *params.MaxOutputTokens != 1000 {
+		t.Errorf("FitOutputBudget(a) = %s, %v, want a budget of 1000", describe(params), err)
+	}
+	params = GenerationParams{}
+	if err := cfg.FitOutputBudget("b", 9000, &params); err != nil || *params.MaxOutputTokens != 20000 {
+		t.Errorf("FitOutputBudget(b) = %s, %v, want a budget of 20000", describe(params), err)
+	}
+	if err := cfg.FitOutputBudget("a", 10000, &GenerationParams{}); err == nil {
+		t.Error("FitOutputBudget(a) of a prompt filling the window error = nil")
+	}
+}
+
+func TestFitOutputBudget(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ModelLimits = map[string]ModelLimits{"m": {ContextWindow: 10000, MaxInputTokens: 8000, MaxOutputTokens: 4096}}
+
+	tests := []struct {
+		prompt int
+		max    *int
+		want   int
+	}{
+		{1000, nil, 4096},
+		{7000, nil, 3000},
+		{1000, ptr(500), 500},
+		{7000, ptr(5000), 3000},
+	}
+	for _, tt := range tests {
+		params := GenerationParams{MaxOutputTokens: tt.max}
+		if err := cfg.FitOutputBudget("m", tt.prompt, &params); err != nil {
+			t.Fatalf("FitOutputBudget(%d) error = %v", tt.prompt, err)
+		}
+		if *params.MaxOutputTokens != tt.want {
+			t.Errorf("FitOutputBudget(%d, %s) = %d, want %d", tt.prompt, describe(GenerationParams{MaxOutputTokens: tt.max}), *params.MaxOutputTokens, tt.want)
+		}
+	}
+
+	var tooLong *PromptTooLongError
+	if err := cfg.FitOutputBudget("m", 8001, &GenerationParams{}); !errors.As(err, &tooLong) || tooLong.PromptTokens != 8001 {
+		t.Errorf("FitOutputBudget() of a prompt over the limit error = %v", err)
+	}
+}
@@ -17,6 +17,15 @@ type Plan struct {
 	ChargeDomain bool   `json:"chargeDomain"`
 	ProductId    string `json:"productId"`
 	PriceId      string `json:"priceId"`
+
+	// GenerationsPerMonth limits site generations per billing period (0 = unlimited)
+	GenerationsPerMonth int `json:"generationsPerMonth"`
+
+	// MaxTenants limits the tenants a subscriber may own (0 = unlimited)
+	MaxTenants int `json:"maxTenants"`
+
+	// Features lists plan highlights shown on the pricing page
+	Features []string `json:"features,omitempty"`
 }
 
 func LoadPlans(cfgDir string) ([]Plan, error) {
@@ -0,0 +1,304 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// Unsplash returns at most 30 photos per page
+	MAX_IMAGE_PER_QUERY = 30
+)
+
+// Orientations accepted by the Unsplash search API
+var KnownImageOrientations = []string{"", "landscape", "portrait", "squarish"}
+
+// Default classes hinting at avatar-shaped and wide images
+var (
+	DefaultImageSquarishClasses = []string{
+		"rounded-full", "aspect-square", "w-10", "w-12", "w-14", "w-16", "w-20", "w-24",
+	}
+	DefaultImageLandscapeClasses = []string{
+		"w-full", "w-screen", "h-screen", "min-h-screen", "h-64", "h-72", "h-80", "h-96", "h-[", "min-h-[", "aspect-video",
+	}
+)
+
+// ImageProcessorSettings configures the image processor (processor_settings.image).
+// The rehost concurrency and srcset widths default to the top-level image_*
+// settings.
+type ImageProcessorSettings struct {
+	// Photos fetched per keyword search; the first is used
+	PerQuery int `json:"per_query"`
+	// Unsplash orientation filter for images no hint applies to, empty for
+	// any
+	Orientation string `json:"orientation"`
+	// Orientation hints: nodes with one of squarish_classes search squarish
+	// photos, then nodes with one of landscape_classes landscape ones, and
+	// backgrounds matching neither background_orientation. Classes match
+	// exactly or as prefixes. data-image-orientation on the node overrides
+	// them all.
+	SquarishClasses       []string `json:"squarish_classes"`
+	LandscapeClasses      []string `json:"landscape_classes"`
+	BackgroundOrientation string   `json:"background_orientation"`
+	// Use matching tenant uploads before stock photos
+	PreferTenantImages bool `json:"prefer_tenant_images"`
+	// Stock image URLs used, keyed by business motif ("generic" for any),
+	// when Unsplash's quota is exhausted and no cached search is similar
+	FallbackImages map[string][]string `json:"fallback_images"`
+
+	RehostConcurrency int   `json:"rehost_concurrency"`
+	HeroWidths        []int `json:"hero_widths"`
+	CardWidths        []int `json:"card_widths"`
+	DefaultWidths     []int `json:"default_widths"`
+}
+
+// Fields a placeholder can be replaced with, taken from the chat's
+// onboarding data and the tenant profile. Empty only flags the placeholder.
+var KnownPlaceholderFields = []string{"", "business_name", "phone", "email", "address"}
+
+// PlaceholderPattern is stock text the model left in instead of real content
+type PlaceholderPattern struct {
+	// Reported in warnings and data-placeholder-warning
+	Name string `json:"name"`
+	// Go regular expression
+	Pattern string `json:"pattern"`
+	// Replace matches with this value when it is known, see
+	// KnownPlaceholderFields
+	Field string `json:"field"`
+}
+
+// Default placeholder patterns, applied in order
+var DefaultPlaceholderPatterns = []PlaceholderPattern{
+	{Name: "lorem_ipsum", Pattern: `(?i)\blorem ipsum\b|\bdolor sit amet\b|\bconsectetur adipiscing\b`},
+	{Name: "business_name", Pattern: `(?i)\[?\b(?:your|my) (?:business|company|brand) name\b\]?|\bcompany name here\b`, Field: "business_name"},
+	{Name: "phone", Pattern: `\(555\) ?\d{3}[-. ]\d{4}\b|\b555[-. ]\d{3}[-. ]\d{4}\b|\b555\d{7}\b|\(?\b123\)?[-. ]?456[-. ]7890\b`, Field: "phone"},
+	{Name: "email", Pattern: `(?i)\b[\w.+-]+@(?:example|yourdomain|yourbusiness|yourcompany|company|domain)\.(?:com|net|org)\b`, Field: "email"},
+	{Name: "address", Pattern: `(?i)\b123 (?:main|any|fake|elm) (?:street|st|avenue|ave|road|rd)\b\.?(?:,\s*(?:anytown|your city)\b(?:,\s*[a-z]{2}\b)?(?:\s+\d{5})?)?`, Field: "address"},
+	{Name: "city", Pattern: `(?i)\banytown\b`},
+	{Name: "template", Pattern: `\{\{[^{}]*\}\}|\[(?:Your|Business|Company|Insert)\b[^\]]{0,40}\]`},
+}
+
+// Attributes the placeholder processor checks besides text
+var DefaultPlaceholderAttributes = []string{"alt", "title", "placeholder", "aria-label", "content", "href", "value"}
+
+// PlaceholderProcessorSettings configures the placeholder processor
+// (processor_settings.placeholders)
+type PlaceholderProcessorSettings struct {
+	// Replaces the default patterns when set
+	Patterns []PlaceholderPattern `json:"patterns"`
+	// Attributes checked besides text
+	Attributes []string `json:"attributes"`
+}
+
+// HeaderProcessorSettings configures the header processor (processor_settings.header)
+type HeaderProcessorSettings struct {
+	// Stylesheets linked from <head>, in order
+	CSSURLs []string `json:"css_urls"`
+	// Add a responsive viewport meta tag when the page has none
+	InjectViewport bool `json:"inject_viewport"`
+	// Add the tenant's brand favicon, font links and color palette
+	InjectBrand bool `json:"inject_brand"`
+}
+
+// CleanupProcessorSettings configures the cleanup processor (processor_settings.cleanup)
+type CleanupProcessorSettings struct {
+	// Remove <br> tags that are direct children of grid containers
+	RemoveBrInGrids bool `json:"remove_br_in_grids"`
+	// Remove <p> elements with no content but whitespace
+	StripEmptyParagraphs bool `json:"strip_empty_paragraphs"`
+	// Remove <div> elements without attributes and with no content but
+	// whitespace. Divs with a class, style or id may be decorative and stay.
+	StripEmptyDivs bool `json:"strip_empty_divs"`
+	// Collapse runs of <br> separated only by whitespace into one
+	CollapseBr bool `json:"collapse_br"`
+	// Remove fixed pixel width, min-width and max-width inline styles from
+	// the children of grid containers
+	StripGridChildPixelWidths bool `json:"strip_grid_child_pixel_widths"`
+	// Rename repeated id attributes, keeping the first: a second "hero"
+	// becomes "hero-2"
+	DedupeIDs bool `json:"dedupe_ids"`
+}
+
+// ImageProcessorSettings returns the image processor settings: defaults,
+// overlaid by processor_settings.image
+func (c *Config) ImageProcessorSettings() (ImageProcessorSettings, error) {
+	settings := ImageProcessorSettings{
+		PerQuery:           DEFAULT_IMAGE_PER_QUERY,
+		PreferTenantImages: true,
+		RehostConcurrency:  c.ImageRehostConcurrency,
+		HeroWidths:         c.ImageHeroWidths,
+		CardWidths:         c.ImageCardWidths,
+		DefaultWidths:      c.ImageDefaultWidths,
+
+		SquarishClasses:       slices.Clone(DefaultImageSquarishClasses),
+		LandscapeClasses:      slices.Clone(DefaultImageLandscapeClasses),
+		BackgroundOrientation: "landscape",
+	}
+	if err := c.decodeProcessorSettings("image", &settings); err != nil {
+		return settings, err
+	}
+
+	if settings.PerQuery < 1 || settings.PerQuery > MAX_IMAGE_PER_QUERY {
+		return settings, fmt.Errorf("per_query must be between 1 and %d", MAX_IMAGE_PER_QUERY)
+	}
+	if !slices.Contains(KnownImageOrientations, settings.Orientation) {
+		return settings, fmt.Errorf("unknown orientation %q (known: %s)", settings.Orientation, strings.Join(KnownImageOrientations[1:], ", "))
+	}
+	if !slices.Contains(KnownImageOrientations, settings.BackgroundOrientation) {
+		return settings, fmt.Errorf("unknown background_orientation %q (known: %s)", settings.BackgroundOrientation, strings.Join(KnownImageOrientations[1:], ", "))
+	}
+	if settings.RehostConcurrency < 0 {
+		return settings, fmt.Errorf("rehost_concurrency must not be negative")
+	}
+	for _, motif := range slices.Sorted(maps.Keys(settings.FallbackImages)) {
+		for _, raw := range settings.FallbackImages[motif] {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return settings, fmt.Errorf("fallback_images: %s: %q is not an http(s) URL", motif, raw)
+			}
+		}
+	}
+	for _, widths := range [][]int{settings.HeroWidths, settings.CardWidths, settings.DefaultWidths} {
+		if !slices.IsSorted(widths) || (len(widths) > 0 && widths[0] <= 0) {
+			return settings, fmt.Errorf("widths must be positive and ascending")
+		}
+	}
+	return settings, nil
+}
+
+// HeaderProcessorSettings returns the header processor settings: defaults,
+// overlaid by processor_settings.header
+func (c *Config) HeaderProcessorSettings() (HeaderProcessorSettings, error) {
+	settings := HeaderProcessorSettings{
+		CSSURLs:     []string{DEFAULT_TAILWIND_CSS_URL},
+		InjectBrand: true,
+	}
+	if err := c.decodeProcessorSettings("header", &settings); err != nil {
+		return settings, err
+	}
+
+	for _, raw := range settings.CSSURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return settings, fmt.Errorf("css_urls: %q is not an http(s) URL", raw)
+		}
+	}
+	return settings, nil
+}
+
+// CleanupProcessorSettings returns the cleanup processor settings: defaults,
+// overlaid by processor_settings.cleanup
+func (c *Config) CleanupProcessorSettings() (CleanupProcessorSettings, error) {
+	settings := CleanupProcessorSettings{
+		RemoveBrInGrids: true,
+	}
+	err := c.decodeProcessorSettings("cleanup", &settings)
+	return settings, err
+}
+
+// PlaceholderProcessorSettings returns the placeholder processor settings:
+// defaults, overlaid by processor_settings.placeholders
+func (c *Config) PlaceholderProcessorSettings() (PlaceholderProcessorSettings, error) {
+	settings := PlaceholderProcessorSettings{
+		Patterns:   slices.Clone(DefaultPlaceholderPatterns),
+		Attributes: slices.Clone(DefaultPlaceholderAttributes),
+	}
+	if err := c.decodeProcessorSettings("placeholders", &settings); err != nil {
+		return settings, err
+	}
+
+	for i, pattern := range settings.Patterns {
+		if pattern.Name == "" {
+			return settings, fmt.Errorf("patterns[%d]: name is required", i)
+		}
+		if _, err := regexp.Compile(pattern.Pattern); err != nil || pattern.Pattern == "" {
+			return settings, fmt.Errorf("patterns[%d] (%s): invalid pattern %q", i, pattern.Name, pattern.Pattern)
+		}
+		if !slices.Contains(KnownPlaceholderFields, pattern.Field) {
+			return settings, fmt.Errorf("patterns[%d] (%s): unknown field %q (known: %s)", i, pattern.Name, pattern.Field, strings.Join(KnownPlaceholderFields[1:], ", "))
+		}
+	}
+	return settings, nil
+}
+
+// validateProcessorSettings decodes each processor's settings, returning one
+// error per processor
+func (c *Config) validateProcessorSettings() map[string]error {
+	errs := map[string]error{}
+	for _, name := range slices.Sorted(maps.Keys(c.ProcessorSettings)) {
+		if !slices.Contains(KnownProcessors, name) {
+			errs[name] = fmt.Errorf("unknown processor (known: %s)", strings.Join(KnownProcessors, ", "))
+		}
+	}
+
+	for name, load := range map[string]func() error{
+		"image":   func() error { _, err := c.ImageProcessorSettings(); return err },
+		"header":  func() error { _, err := c.HeaderProcessorSettings(); return err },
+		"cleanup": func() error { _, err := c.CleanupProcessorSettings(); return err },
+
+		"placeholders": func() error { _, err := c.PlaceholderProcessorSettings(); return err },
+	} {
+		if err := load(); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// decodeProcessorSettings overlays the processor's raw settings on settings.
+// Unknown fields are an error, so typos don't silently keep the default.
+func (c *Config) decodeProcessorSettings(name string, settings any) error {
+	raw, ok := c.ProcessorSettings[name]
+	if !ok || len(bytes.TrimSpace(raw)) == 0 || string(bytes.TrimSpace(raw)) == "null" {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(settings); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	return nil
+}
+
+// applyProcessorSettingsEnv reads PROCESSOR_<NAME>_SETTINGS, a JSON object
+// whose fields take precedence over the same processor's fields from the
+// config files
+func (c *Config) applyProcessorSettingsEnv() {
+	for _, name := range KnownProcessors {
+		v := os.Getenv("PROCESSOR_" + strings.ToUpper(name) + "_SETTINGS")
+		if v == "" {
+			continue
+		}
+		if c.ProcessorSettings == nil {
+			c.ProcessorSettings = map[string]json.RawMessage{}
+		}
+		c.ProcessorSettings[name] = mergeJSONObjects(c.ProcessorSettings[name], json.RawMessage(v))
+	}
+}
+
+// mergeJSONObjects returns base with the top-level fields of override
+// replacing its own. When either isn't an object, override is returned
+// as-is, for validation to report.
+func mergeJSONObjects(base, override json.RawMessage) json.RawMessage {
+	var baseFields, overrideFields map[string]json.RawMessage
+	if len(base) == 0 || json.Unmarshal(base, &baseFields) != nil || json.Unmarshal(override, &overrideFields) != nil {
+		return override
+	}
+	if baseFields == nil {
+		baseFields = map[string]json.RawMessage{}
+	}
+	maps.Copy(baseFields, overrideFields)
+	merged, err := json.Marshal(baseFields)
+	if err != nil {
+		return override
+	}
+	return merged
+}
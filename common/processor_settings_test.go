@@ -0,0 +1,127 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProcessorSettingsDefaults(t *testing.T) {
+	cfg := DefaultConfig()
+
+	image, err := cfg.ImageProcessorSettings()
+	if err != nil {
+		t.Fatalf("ImageProcessorSettings() error = %v", err)
+	}
+	if image.PerQuery != DEFAULT_IMAGE_PER_QUERY || !image.PreferTenantImages || image.BackgroundOrientation != "landscape" ||
+		!slices.Equal(image.HeroWidths, cfg.ImageHeroWidths) {
+		t.Errorf("ImageProcessorSettings() = %+v, want the defaults", image)
+	}
+	header, err := cfg.HeaderProcessorSettings()
+	if err != nil || !slices.Equal(header.CSSURLs, []string{DEFAULT_TAILWIND_CSS_URL}) || !header.InjectBrand || header.InjectViewport {
+		t.Errorf("HeaderProcessorSettings() = %+v, %v; want the defaults", header, err)
+	}
+	cleanup, err := cfg.CleanupProcessorSettings()
+	if err != nil || cleanup != (CleanupProcessorSettings{RemoveBrInGrids: true}) {
+		t.Errorf("CleanupProcessorSettings() = %+v, %v; want only the grid <br> cleanup", cleanup, err)
+	}
+
+	// Set fields overlay the defaults, leaving the rest
+	cfg.ProcessorSettings = map[string]json.RawMessage{
+		"image":   json.RawMessage(`{"per_query": 10, "orientation": "portrait"}`),
+		"cleanup": json.RawMessage(`{"strip_empty_paragraphs": true}`),
+		"header":  json.RawMessage(`null`),
+	}
+	image, err = cfg.ImageProcessorSettings()
+	if err != nil || image.PerQuery != 10 || image.Orientation != "portrait" || !image.PreferTenantImages {
+		t.Errorf("ImageProcessorSettings() = %+v, %v; want the set fields over the defaults", image, err)
+	}
+	cleanup, _ = cfg.CleanupProcessorSettings()
+	if !cleanup.RemoveBrInGrids || !cleanup.StripEmptyParagraphs {
+		t.Errorf("CleanupProcessorSettings() = %+v, want both cleanups", cleanup)
+	}
+	if header, _ := cfg.HeaderProcessorSettings(); len(header.CSSURLs) != 1 {
+		t.Errorf("HeaderProcessorSettings() of null = %+v, want the defaults", header)
+	}
+}
+
+func TestProcessorSettingsValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		message  string
+	}{
+		{"unknown field", map[string]string{"cleanup": `{"remove_br_in_grid": false}`}, `cleanup: invalid settings: json: unknown field "remove_br_in_grid"`},
+		{"unknown processor", map[string]string{"sparkle": `{}`}, "sparkle: unknown processor"},
+		{"wrong type", map[string]string{"image": `{"per_query": "ten"}`}, "image: invalid settings"},
+		{"per query out of range", map[string]string{"image": `{"per_query": 31}`}, "image: per_query must be between 1 and 30"},
+		{"unknown orientation", map[string]string{"image": `{"orientation": "diagonal"}`}, `image: unknown orientation "diagonal"`},
+		{"css url", map[string]string{"header": `{"css_urls": ["/local.css"]}`}, `header: css_urls: "/local.css" is not an http(s) URL`},
+		{"placeholder pattern", map[string]string{"placeholders": `{"patterns": [{"name": "x", "pattern": "("}]}`}, "placeholders: patterns[0] (x): invalid pattern"},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		cfg.ProcessorSettings = map[string]json.RawMessage{}
+		for name, raw := range tt.settings {
+			cfg.ProcessorSettings[name] = json.RawMessage(raw)
+		}
+
+		var errs ValidationErrors
+		if err := cfg.Validate(); !errors.As(err, &errs) {
+			t.Errorf("%s: Validate() = %v, want ValidationErrors", tt.name, err)
+			continue
+		}
+		found := false
+		for _, e := range errs {
+			if e.Field == "processor_settings" && strings.Contains(e.Message, tt.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: Validate() = %v, want processor_settings: ...%s...", tt.name, errs, tt.message)
+		}
+	}
+}
+
+func TestProcessorSettingsEnvPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("APP_ENV", "test")
+	base := `{"processor_settings": {"image": {"per_query": 3, "orientation": "portrait"}, "cleanup": {"collapse_br": true}}}`
+	if err := os.WriteFile(filepath.Join(dir, DEFAULT_CONFIG_FILE), []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := `{"processor_settings": {"image": {"per_query": 4, "orientation": "portrait"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.test.json"), []byte(env), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment replaces single fields of the files' settings
+	t.Setenv("PROCESSOR_IMAGE_SETTINGS", `{"per_query": 9}`)
+	t.Setenv("PROCESSOR_HEADER_SETTINGS", `{"inject_viewport": true}`)
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := cfg.ImageProcessorSettings()
+	if err != nil || image.PerQuery != 9 || image.Orientation != "portrait" {
+		t.Errorf("ImageProcessorSettings() = %+v, %v; want per_query from the environment and orientation from the files", image, err)
+	}
+	if header, _ := cfg.HeaderProcessorSettings(); !header.InjectViewport || !header.InjectBrand {
+		t.Errorf("HeaderProcessorSettings() = %+v, want the environment over the defaults", header)
+	}
+	if cleanup, _ := cfg.CleanupProcessorSettings(); !cleanup.CollapseBr {
+		t.Errorf("CleanupProcessorSettings() = %+v, want the file's settings without an override", cleanup)
+	}
+
+	// Settings that aren't an object are left for validation to report
+	t.Setenv("PROCESSOR_IMAGE_SETTINGS", `[1]`)
+	if cfg, _ = LoadConfig(dir); cfg.Validate() == nil {
+		t.Error("Validate() with PROCESSOR_IMAGE_SETTINGS=[1] = nil, want an error")
+	}
+}
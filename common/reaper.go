@@ -0,0 +1,48 @@
+package common
+
+import (
+	"maps"
+	"time"
+)
+
+// Redis key reaper policies
+const (
+	// REAPER_POLICY_SESSIONS deletes sessions whose user no longer exists or
+	// is inactive, and expires the rest like REAPER_POLICY_TTL
+	REAPER_POLICY_SESSIONS = "sessions"
+	// REAPER_POLICY_TENANT_CACHE deletes <namespace>:<tenant schema>:* keys
+	// of tenants that no longer exist
+	REAPER_POLICY_TENANT_CACHE = "tenant_cache"
+	// REAPER_POLICY_TTL gives keys without an expiry ttl_seconds
+	REAPER_POLICY_TTL = "ttl"
+	// REAPER_POLICY_OFF leaves the namespace alone
+	REAPER_POLICY_OFF = "off"
+)
+
+// RedisKeyPolicy is how the Redis key reaper treats the keys of a namespace
+type RedisKeyPolicy struct {
+	Policy     string `json:"policy"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+// TTL is the expiry the policy gives keys that have none
+func (p RedisKeyPolicy) TTL() time.Duration {
+	return time.Duration(p.TTLSeconds) * time.Second
+}
+
+// ReaperPolicies returns the reaper's policy of each key namespace: the
+// built-in ones, matching the TTLs the keys are written with, overridden by
+// redis_reaper_policies
+func (c *Config) ReaperPolicies() map[string]RedisKeyPolicy {
+	policies := map[string]RedisKeyPolicy{
+		"session":         {Policy: REAPER_POLICY_SESSIONS, TTLSeconds: 24 * 60 * 60},
+		"fs":              {Policy: REAPER_POLICY_TENANT_CACHE},
+		"oauth_state":     {Policy: REAPER_POLICY_TTL, TTLSeconds: 10 * 60},
+		"oauth_code":      {Policy: REAPER_POLICY_TTL, TTLSeconds: 60},
+		"oauth_used_code": {Policy: REAPER_POLICY_TTL, TTLSeconds: 15 * 60},
+		"idempotency":     {Policy: REAPER_POLICY_TTL, TTLSeconds: c.IdempotencyTTLHours * 60 * 60},
+		"unsplash":        {Policy: REAPER_POLICY_TTL, TTLSeconds: c.UnsplashCacheTTLHours * 60 * 60},
+	}
+	maps.Copy(policies, c.RedisReaperPolicies)
+	return policies
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrRedirectNotAllowed is returned for redirect targets outside
+// allowed_redirect_origins
+var ErrRedirectNotAllowed = errors.New("redirect target is not allowed")
+
+var errRedirectPath = errors.New("must be an origin without path, query or fragment")
+
+// ValidateRedirectURL checks that raw is an absolute http(s) URL on one of
+// the allowed_redirect_origins, or on the frontend_url origin. An origin of
+// the form https://*.example.com allows any subdomain of example.com, but not
+// example.com itself.
+func (c *Config) ValidateRedirectURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return ErrRedirectNotAllowed
+	}
+
+	origins := c.AllowedRedirectOrigins
+	if c.FrontendURL != "" {
+		origins = append([]string{c.FrontendURL}, origins...)
+	}
+
+	host := strings.ToLower(u.Host)
+	for _, origin := range origins {
+		o, err := url.Parse(strings.TrimSpace(origin))
+		if err != nil || !strings.EqualFold(o.Scheme, u.Scheme) {
+			continue
+		}
+		allowed := strings.ToLower(o.Host)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+		} else if host == allowed {
+			return nil
+		}
+	}
+	return ErrRedirectNotAllowed
+}
+
+// OriginAllowed reports whether a browser origin may call the API from a
+// page: the API's own base_url, or a redirect origin (frontend_url,
+// allowed_redirect_origins)
+func (c *Config) OriginAllowed(origin string) bool {
+	if base, err := url.Parse(c.BaseURL); err == nil && base.Host != "" &&
+		strings.EqualFold(origin, base.Scheme+"://"+base.Host) {
+		return true
+	}
+	return c.ValidateRedirectURL(origin) == nil
+}
+
+// validateRedirectOrigin checks an allowed_redirect_origins entry
+func validateRedirectOrigin(origin string) error {
+	// The wildcard isn't a valid host, so check the rest of it
+	o, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+	if err != nil {
+		return err
+	}
+	if o.Scheme != "http" && o.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if o.Host == "" {
+		return fmt.Errorf("host is missing")
+	}
+	if o.Path != "" && o.Path != "/" || o.RawQuery != "" || o.Fragment != "" {
+		return errRedirectPath
+	}
+	return nil
+}
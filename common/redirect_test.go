@@ -0,0 +1,73 @@
+package common
+
+import "testing"
+
+func TestOriginAllowed(t *testing.T) {
+	cfg := &Config{
+		BaseURL:                "https://api.example.com/v1",
+		FrontendURL:            "https://app.example.com",
+		AllowedRedirectOrigins: []string{"https://*.sites.example.com"},
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://api.example.com", true},
+		{"https://app.example.com", true},
+		{"https://shop.sites.example.com", true},
+		{"https://sites.example.com", false},
+		{"http://app.example.com", false},
+		{"https://evil.example", false},
+		{"https://app.example.com.evil.example", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.OriginAllowed(tt.origin); got != tt.want {
+			t.Errorf("OriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRedirectURL(t *testing.T) {
+	cfg := &Config{
+		FrontendURL:            "https://app.example.com",
+		AllowedRedirectOrigins: []string{"https://*.sites.example.com", " http://localhost:3000 "},
+	}
+
+	tests := []struct {
+		raw     string
+		allowed bool
+	}{
+		{"https://app.example.com/login/done?x=1", true},
+		{"https://APP.example.com/", true},
+		{"https://shop.sites.example.com/checkout", true},
+		{"https://a.b.sites.example.com", true},
+		{"http://localhost:3000/callback", true},
+		{"https://sites.example.com", false},
+		{"https://evilsites.example.com", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://app.example.com.evil.example", false},
+		{"https://user@app.example.com", false},
+		{"javascript:alert(1)", false},
+		{"//evil.example/path", false},
+		{"/relative/path", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		err := cfg.ValidateRedirectURL(tt.raw)
+		if tt.allowed && err != nil {
+			t.Errorf("ValidateRedirectURL(%q) = %v, want allowed", tt.raw, err)
+		}
+		if !tt.allowed && err != ErrRedirectNotAllowed {
+			t.Errorf("ValidateRedirectURL(%q) = %v, want ErrRedirectNotAllowed", tt.raw, err)
+		}
+	}
+
+	// Without a frontend_url only the listed origins are allowed
+	cfg.FrontendURL = ""
+	if err := cfg.ValidateRedirectURL("https://app.example.com"); err == nil {
+		t.Error("ValidateRedirectURL() of the unset frontend_url = nil, want an error")
+	}
+}
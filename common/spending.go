@@ -0,0 +1,27 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// ModelPrice is what a model's tokens cost, in cents per million tokens
+type ModelPrice struct {
+	PromptCentsPerMillion     float64 `json:"prompt_cents_per_million"`
+	CompletionCentsPerMillion float64 `json:"completion_cents_per_million"`
+}
+
+// CostMicrocents returns what the tokens cost on model in millionths of a
+// cent, priced by model_prices. Models without a price cost nothing.
+func (c *Config) CostMicrocents(model string, promptTokens, completionTokens int64) int64 {
+	price := c.ModelPrices[model]
+	return int64(math.Round(float64(promptTokens)*price.PromptCentsPerMillion + float64(completionTokens)*price.CompletionCentsPerMillion))
+}
+
+// SpendingPeriod returns the start and end of the calendar month (UTC)
+// spending caps are counted over
+func SpendingPeriod(now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
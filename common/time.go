@@ -0,0 +1,28 @@
+package common
+
+import "time"
+
+// API responses carry timestamps as RFC3339 in UTC. Clients convert to the
+// tenant's display timezone, sent in the X-Tenant-Timezone header.
+
+// FormatTime formats t as RFC3339 in UTC
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// FormatUnix formats Unix seconds as RFC3339 in UTC, or "" for zero
+func FormatUnix(sec int64) string {
+	if sec == 0 {
+		return ""
+	}
+	return FormatTime(time.Unix(sec, 0))
+}
+
+// UTC returns t converted to UTC, or nil for nil
+func UTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC), "2026-03-01T09:30:00Z"},
+		// Always UTC, whatever the zone of the value
+		{time.Date(2026, 7, 1, 12, 0, 0, 0, berlin), "2026-07-01T10:00:00Z"},
+		// Whole seconds only
+		{time.Date(2026, 3, 1, 9, 30, 0, 999_000_000, time.UTC), "2026-03-01T09:30:00Z"},
+	}
+	for _, tt := range tests {
+		if got := FormatTime(tt.in); got != tt.want {
+			t.Errorf("FormatTime(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatUnix(t *testing.T) {
+	tests := []struct {
+		sec  int64
+		want string
+	}{
+		{0, ""},
+		{1772357400, "2026-03-01T09:30:00Z"},
+		{-1, "1969-12-31T23:59:59Z"},
+	}
+	for _, tt := range tests {
+		if got := FormatUnix(tt.sec); got != tt.want {
+			t.Errorf("FormatUnix(%d) = %q, want %q", tt.sec, got, tt.want)
+		}
+	}
+}
+
+func TestUTC(t *testing.T) {
+	if UTC(nil) != nil {
+		t.Error("UTC(nil) != nil")
+	}
+	local := time.Date(2026, 3, 1, 9, 30, 0, 0, time.FixedZone("UTC+2", 2*3600))
+	got := UTC(&local)
+	if got.Location() != time.UTC || !got.Equal(local) {
+		t.Errorf("UTC(%v) = %v, want the same instant in UTC", local, got)
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timings records how long the phases of a request took, in the order they
+// were first recorded. Time added to a phase more than once accumulates, so
+// processors running over sections in parallel report their summed time.
+// A nil *Timings ignores everything, so code can record phases without
+// checking whether the caller collects them.
+type Timings struct {
+	mu     sync.Mutex
+	names  []string
+	phases map[string]time.Duration
+}
+
+// NewTimings returns an empty Timings
+func NewTimings() *Timings {
+	return &Timings{phases: make(map[string]time.Duration)}
+}
+
+type timingsCtxKey struct{}
+
+// WithTimings attaches t to the context so the code a request calls into can
+// record its phases
+func WithTimings(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, timingsCtxKey{}, t)
+}
+
+// TimingsFromContext returns the Timings set by WithTimings, or nil
+func TimingsFromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsCtxKey{}).(*Timings)
+	return t
+}
+
+// StartTiming starts timing phase on the context's Timings. Call the
+// returned function when the phase ends.
+func StartTiming(ctx context.Context, phase string) func() {
+	return TimingsFromContext(ctx).Start(phase)
+}
+
+// Add adds d to phase
+func (t *Timings) Add(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.phases[phase]; !ok {
+		t.names = append(t.names, phase)
+	}
+	t.phases[phase] += d
+}
+
+// Start starts timing phase. Call the returned function when it ends.
+func (t *Timings) Start(phase string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.Add(phase, time.Since(start))
+	}
+}
+
+// Milliseconds returns the recorded phases in milliseconds, or nil when none
+// were recorded
+func (t *Timings) Milliseconds() map[string]int64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.names) == 0 {
+		return nil
+	}
+	ms := make(map[string]int64, len(t.names))
+	for _, name := range t.names {
+		ms[name] = t.phases[name].Milliseconds()
+	}
+	return ms
+}
+
+// ServerTiming formats the recorded phases as a Server-Timing header value,
+// e.g. "chat_load;dur=12.3, db;dur=4.0"
+func (t *Timings) ServerTiming() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.names))
+	for i, name := range t.names {
+		parts[i] = fmt.Sprintf("%s;dur=%.1f", name, float64(t.phases[name].Microseconds())/1000)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTimingsAccumulate(t *testing.T) {
+	timings := NewTimings()
+	timings.Add("model_ttfb", 40*time.Millisecond)
+	timings.Add("postprocess_images", 5*time.Millisecond)
+	timings.Add("postprocess_images", 7*time.Millisecond)
+
+	got := timings.Milliseconds()
+	want := map[string]int64{"model_ttfb": 40, "postprocess_images": 12}
+	if len(got) != len(want) {
+		t.Fatalf("Milliseconds() = %v, want %v", got, want)
+	}
+	for phase, ms := range want {
+		if got[phase] != ms {
+			t.Errorf("Milliseconds()[%s] = %d, want %d", phase, got[phase], ms)
+		}
+	}
+
+	// Phases keep the order they were first recorded in
+	if got, want := timings.ServerTiming(), "model_ttfb;dur=40.0, postprocess_images;dur=12.0"; got != want {
+		t.Errorf("ServerTiming() = %q, want %q", got, want)
+	}
+}
+
+func TestTimingsStart(t *testing.T) {
+	timings := NewTimings()
+	stop := timings.Start("db")
+	time.Sleep(10 * time.Millisecond)
+	stop()
+
+	if ms := timings.Milliseconds()["db"]; ms < 10 {
+		t.Errorf("db = %dms, want at least the 10ms slept", ms)
+	}
+	if !regexp.MustCompile(`^db;dur=\d+\.\d$`).MatchString(timings.ServerTiming()) {
+		t.Errorf("ServerTiming() = %q", timings.ServerTiming())
+	}
+}
+
+func TestTimingsNil(t *testing.T) {
+	var timings *Timings
+	timings.Add("db", time.Second)
+	timings.Start("db")()
+	if timings.Milliseconds() != nil || timings.ServerTiming() != "" {
+		t.Error("nil Timings recorded a phase")
+	}
+
+	// Nothing is recorded when the context has no Timings
+	StartTiming(context.Background(), "db")()
+
+	if ms := NewTimings().Milliseconds(); ms != nil {
+		t.Errorf("Milliseconds() with no phases = %v, want nil", ms)
+	}
+}
+
+func TestTimingsContext(t *testing.T) {
+	timings := NewTimings()
+	ctx := WithTimings(context.Background(), timings)
+	if TimingsFromContext(ctx) != timings {
+		t.Fatal("TimingsFromContext() did not return the attached Timings")
+	}
+
+	StartTiming(ctx, "cache")()
+	if _, ok := timings.Milliseconds()["cache"]; !ok {
+		t.Error("StartTiming() did not record on the context's Timings")
+	}
+}
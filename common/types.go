@@ -1,12 +1,93 @@
 package common
 
-import "context"
+import (
+	"context"
+
+	"golang.org/x/net/html"
+)
 
 type Processor interface {
 	Name() string
 	Process(ctx context.Context, input []byte) ([]byte, error)
 }
 
+// ProcessorResult is a processor's output with details for the processing report
+type ProcessorResult struct {
+	Output   []byte
+	Counts   map[string]int
+	Warnings []string
+}
+
+// Count adds n to a named counter
+func (r *ProcessorResult) Count(name string, n int) {
+	if r.Counts == nil {
+		r.Counts = make(map[string]int)
+	}
+	r.Counts[name] += n
+}
+
+// Warn records a non-fatal problem
+func (r *ProcessorResult) Warn(message string) {
+	r.Warnings = append(r.Warnings, message)
+}
+
+// ReportingProcessor is implemented by processors that report what they did
+type ReportingProcessor interface {
+	Processor
+	ProcessWithResult(ctx context.Context, input []byte) (*ProcessorResult, error)
+}
+
+// SubtreeProcessor is implemented by processors that can work on part of a
+// parsed document, so sections can be processed independently. Nodes meant
+// for the document's <head> are appended to head instead.
+type SubtreeProcessor interface {
+	Processor
+	ProcessSubtree(ctx context.Context, node, head *html.Node) (*ProcessorResult, error)
+}
+
+// NodeProcessor is implemented by processors that work on the parsed
+// document in place, so the pipeline parses the page once and renders it
+// once at the end instead of each processor doing both. The result's Output
+// is unused.
+type NodeProcessor interface {
+	Processor
+	ProcessNode(ctx context.Context, doc *html.Node) (*ProcessorResult, error)
+}
+
+// RunProcessor runs p, adapting plain Processors to a ProcessorResult
+func RunProcessor(ctx context.Context, p Processor, input []byte) (*ProcessorResult, error) {
+	if rp, ok := p.(ReportingProcessor); ok {
+		return rp.ProcessWithResult(ctx, input)
+	}
+	output, err := p.Process(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessorResult{Output: output}, nil
+}
+
+// ProcessorReport is one processor's outcome, returned to the client
+type ProcessorReport struct {
+	Name       string         `json:"name"`
+	Success    bool           `json:"success"`
+	Skipped    bool           `json:"skipped,omitempty"`
+	DurationMs int64          `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	Warnings   []string       `json:"warnings,omitempty"`
+	Counts     map[string]int `json:"counts,omitempty"`
+
+	// How the processor failed: ProcessorFailureError, ProcessorFailurePanic
+	// or ProcessorFailureTimeout
+	Failure string `json:"failure,omitempty"`
+}
+
+// Ways a processor can fail, as set in ProcessorReport.Failure
+const (
+	ProcessorFailureError   = "error"
+	ProcessorFailurePanic   = "panic"
+	ProcessorFailureTimeout = "timeout"
+)
+
 // // API types
 // export interface ApiResponse<T> {
 //   data: T;
@@ -0,0 +1,549 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Processor names registered in main
+var KnownProcessors = []string{"header", "image", "cleanup", "placeholders", "contact"}
+
+// Redis topologies supported by storage.NewRedisClientWithOptions
+var KnownRedisModes = []string{"single", "sentinel", "cluster"}
+
+// Ways of outlining earlier pages when compacting chat history
+var KnownHistorySummaryStrategies = []string{"heuristic", "model"}
+
+// Chat stores supported by sections.NewChatStore
+var KnownChatStores = []string{"", "redis", "postgres", "cached"}
+
+// Chat spills supported by sections.NewChatSpill
+var KnownChatSpills = []string{"", "dir", "redis", "off"}
+
+// Redis key reaper policies, see ReaperPolicies
+var KnownReaperPolicies = []string{REAPER_POLICY_SESSIONS, REAPER_POLICY_TENANT_CACHE, REAPER_POLICY_TTL, REAPER_POLICY_OFF}
+
+// Image store backends supported by services.NewImageStoreFromConfig
+var KnownImageStores = []string{"", "local", "gcs"}
+
+// Feature flags defined by the flags package
+var KnownFeatureFlags = []string{"maintenance_mode", "chat_generation", "image_processing", "domain_registration", "checkout"}
+
+// Moderation providers supported by services.NewModerationServiceFromConfig
+var KnownModerationProviders = []string{"", "denylist", "endpoint"}
+
+// Thinking modes supported by services.ThinkingStream
+var KnownThinkingModes = []string{"", THINKING_MODE_OFF, THINKING_MODE_PLACEHOLDER, THINKING_MODE_THROTTLED, THINKING_MODE_FULL}
+
+// Domain registrar providers supported by domains.NewRegistrarFactory
+var KnownRegistrarProviders = []string{"", "namecheap", "cloudflare", "opensrs", "mock"}
+
+// Server modes supported by serverbuilder.ResolveMode
+var KnownServerModes = []string{"", "simple", "full"}
+
+// ConfigError describes a single invalid config setting
+type ConfigError struct {
+	Field   string
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found by Config.Validate
+type ValidationErrors []*ConfigError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, e := range v {
+		msgs[i] = e.Error()
+	}
+	return fmt.Sprintf("invalid config (%d problems): %s", len(v), strings.Join(msgs, "; "))
+}
+
+// Unwrap allows errors.As to match individual ConfigErrors
+func (v ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(v))
+	for i, e := range v {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Validate checks the config for settings that would otherwise only fail at
+// request time. It returns nil or a ValidationErrors listing every problem.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+	add := func(field, format string, args ...any) {
+		errs = append(errs, &ConfigError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if err := validateListenAddr(c.ListenAddr); err != nil {
+		add("listen_addr", "%v", err)
+	}
+
+	if c.MinInputTokens < 0 {
+		add("min_input_tokens", "must not be negative")
+	}
+	if c.MaxInputTokens <= 0 {
+		add("max_input_tokens", "must be positive")
+	}
+	if c.MaxOutputTokens <= 0 {
+		add("max_output_tokens", "must be positive")
+	}
+	if c.MinInputTokens > c.MaxInputTokens && c.MaxInputTokens > 0 {
+		add("min_input_tokens", "%d is greater than max_input_tokens %d", c.MinInputTokens, c.MaxInputTokens)
+	}
+
+	for _, p := range c.EnabledProcessors {
+		if !slices.Contains(KnownProcessors, p) {
+			add("enabled_processors", "unknown processor %q (known: %s)", p, strings.Join(KnownProcessors, ", "))
+		}
+	}
+	// The contact processor normalizes the tel: links placeholders fills in
+	if contact, placeholders := slices.Index(c.EnabledProcessors, "contact"), slices.Index(c.EnabledProcessors, "placeholders"); contact >= 0 && contact < placeholders {
+		add("enabled_processors", "contact must come after placeholders")
+	}
+
+	if len(c.EnabledModels) == 0 {
+		add("enabled_models", "at least one model is required")
+	}
+	for _, m := range c.EnabledModels {
+		if !validModelName(m) {
+			add("enabled_models", "malformed model %q, expected publisher/model", m)
+		}
+	}
+	if c.DefaultModel == "" {
+		add("default_model", "is required")
+	} else if !slices.Contains(c.EnabledModels, c.DefaultModel) {
+		add("default_model", "%q is not in enabled_models", c.DefaultModel)
+	}
+	if c.ChatTitleModel != "" && !slices.Contains(c.EnabledModels, c.ChatTitleModel) {
+		add("chat_title_model", "%q is not in enabled_models", c.ChatTitleModel)
+	}
+	if c.HistorySummaryModel != "" && !slices.Contains(c.EnabledModels, c.HistorySummaryModel) {
+		add("history_summary_model", "%q is not in enabled_models", c.HistorySummaryModel)
+	}
+	for _, m := range slices.Sorted(maps.Keys(c.ModelParams)) {
+		params := c.ModelParams[m]
+		if !slices.Contains(c.EnabledModels, m) {
+			add("model_params", "%q is not in enabled_models", m)
+		}
+		if err := params.Validate(); err != nil {
+			add("model_params", "%s: %s", m, strings.TrimPrefix(err.Error(), ErrInvalidGenerationParams.Error()+": "))
+		}
+	}
+
+	if c.ContextWindow <= 0 {
+		add("context_window", "must be positive")
+	}
+
+	if c.HistoryCompactionThresholdTokens < 0 {
+		add("history_compaction_threshold_tokens", "must not be negative")
+	}
+	if !slices.Contains(KnownHistorySummaryStrategies, c.HistorySummaryStrategy) {
+		add("history_summary_strategy", "unknown strategy %q (known: %s)", c.HistorySummaryStrategy, strings.Join(KnownHistorySummaryStrategies, ", "))
+	}
+	if c.HistoryUserMessageMaxChars <= 0 {
+		add("history_user_message_max_chars", "must be positive")
+	}
+	for _, plan := range slices.Sorted(maps.Keys(c.FilesystemNamespaces)) {
+		for _, ns := range c.FilesystemNamespaces[plan] {
+			for _, role := range slices.Sorted(maps.Keys(ns.Access)) {
+				if !slices.Contains(FilesystemRoles, role) {
+					add("filesystem_namespaces", "%s: %q: unknown role %q (known: %s)", plan, ns.Prefix, role, strings.Join(FilesystemRoles, ", "))
+				}
+				if access := ns.Access[role]; access != FilesystemAccessRead && access != FilesystemAccessWrite {
+					add("filesystem_namespaces", "%s: %q: access %q of %s must be %s or %s", plan, ns.Prefix, access, role, FilesystemAccessRead, FilesystemAccessWrite)
+				}
+			}
+		}
+	}
+	if c.ChatContentPreviewRunes < 0 {
+		add("chat_content_preview_runes", "must not be negative")
+	}
+	if c.ChatDedupTTLSeconds < 0 {
+		add("chat_dedup_ttl_seconds", "must not be negative")
+	}
+	for _, m := range slices.Sorted(maps.Keys(c.ModelLimits)) {
+		limits := c.ModelLimits[m]
+		if !slices.Contains(c.EnabledModels, m) {
+			add("model_limits", "%q is not in enabled_models", m)
+		}
+		if limits.ContextWindow < 0 || limits.MaxInputTokens < 0 || limits.MaxOutputTokens < 0 {
+			add("model_limits", "%s: limits must not be negative", m)
+		}
+		if limits.ContextWindow > 0 && limits.MaxInputTokens >= limits.ContextWindow {
+			add("model_limits", "%s: max_input_tokens %d leaves no room for output in context_window %d", m, limits.MaxInputTokens, limits.ContextWindow)
+		}
+	}
+
+	for _, m := range slices.Sorted(maps.Keys(c.ModelPrices)) {
+		if price := c.ModelPrices[m]; price.PromptCentsPerMillion < 0 || price.CompletionCentsPerMillion < 0 {
+			add("model_prices", "%s: prices must not be negative", m)
+		}
+	}
+
+	if c.PromptFormat != "" && c.PromptFormat != PromptFormatOneShotPage && c.PromptFormat != PromptFormatHtmlTemplateBased {
+		add("prompt_format", "unknown format %q", c.PromptFormat)
+	}
+
+	if c.UnsplashAPIAccessKey != "" && c.UnsplashAPISecretKey == "" {
+		add("unsplash_api_secret_key", "required when unsplash_api_access_key is set")
+	}
+
+	// Stripe keys are read from the environment in main
+	if os.Getenv("STRIPE_SECRET_KEY") != "" && os.Getenv("STRIPE_WEBHOOK_SECRET") == "" {
+		add("STRIPE_WEBHOOK_SECRET", "required when STRIPE_SECRET_KEY is set")
+	}
+
+	if c.ChatCompleteTimeoutSeconds < 0 {
+		add("chat_complete_timeout_seconds", "must not be negative")
+	}
+	if c.AsyncGenerationTimeoutSeconds < 1 || c.AsyncGenerationTimeoutSeconds >= 300 {
+		add("async_generation_timeout_seconds", "must be between 1 and 299, under the job lease")
+	}
+	if c.AsyncGenerationResultTTLMinutes < 1 {
+		add("async_generation_result_ttl_minutes", "must be at least 1")
+	}
+	if c.MultiPageMaxPages < 1 || c.MultiPageMaxPages > MAX_MULTI_PAGE_PAGES {
+		add("multi_page_max_pages", "must be between 1 and %d", MAX_MULTI_PAGE_PAGES)
+	}
+	if c.MultiPageConcurrency < 1 || c.MultiPageConcurrency > MAX_MULTI_PAGE_CONCURRENCY {
+		add("multi_page_concurrency", "must be between 1 and %d", MAX_MULTI_PAGE_CONCURRENCY)
+	}
+	if c.ClarificationMaxQuestions < 1 || c.ClarificationMaxQuestions > MAX_CLARIFICATION_QUESTIONS {
+		add("clarification_max_questions", "must be between 1 and %d", MAX_CLARIFICATION_QUESTIONS)
+	}
+	if c.ClarificationTimeoutSeconds < 1 {
+		add("clarification_timeout_seconds", "must be at least 1")
+	}
+	if c.VertexTokenRefreshSeconds < 0 {
+		add("vertex_token_refresh_seconds", "must not be negative")
+	}
+	if c.StreamMaxEventBytes <= 0 {
+		add("stream_max_event_bytes", "must be positive")
+	}
+	if c.OutputGuardMaxBytes <= 0 {
+		add("output_guard_max_bytes", "must be positive")
+	}
+	if c.OutputGuardMaxTokens < 0 {
+		add("output_guard_max_tokens", "must not be negative")
+	}
+	if c.FreeGenerationsPerMonth < 0 {
+		add("free_generations_per_month", "must not be negative")
+	}
+	if c.ChatGenerationCredits < 0 {
+		add("chat_generation_credits", "must not be negative")
+	}
+	if c.JobsConcurrency < 0 {
+		add("jobs_concurrency", "must not be negative")
+	}
+	if c.ReprocessThrottleMs < 0 {
+		add("reprocess_throttle_ms", "must not be negative")
+	}
+	if c.UnsplashQuotaReserve < 0 {
+		add("unsplash_quota_reserve", "must not be negative")
+	}
+	if c.UnsplashCacheTTLHours < 0 {
+		add("unsplash_cache_ttl_hours", "must not be negative")
+	}
+	if c.TenantDeletionGraceDays < 0 {
+		add("tenant_deletion_grace_days", "must not be negative")
+	}
+	if c.FilesystemSearchMaxBytes < 0 {
+		add("filesystem_search_max_bytes", "must not be negative")
+	}
+	if c.DomainRenewalPriceCents < 0 {
+		add("domain_renewal_price_cents", "must not be negative")
+	}
+
+	totalTraffic := 0
+	experimentNames := map[string]bool{}
+	for _, e := range c.PromptExperiments {
+		if e.Name == "" {
+			add("prompt_experiments", "name is required")
+		} else if experimentNames[e.Name] {
+			add("prompt_experiments", "duplicate experiment %q", e.Name)
+		}
+		experimentNames[e.Name] = true
+		if e.Template == "" {
+			add("prompt_experiments", "template is required for experiment %q", e.Name)
+		}
+		if e.TrafficPercent < 0 || e.TrafficPercent > 100 {
+			add("prompt_experiments", "traffic_percent of experiment %q must be between 0 and 100", e.Name)
+		}
+		totalTraffic += max(e.TrafficPercent, 0)
+	}
+	if totalTraffic > 100 {
+		add("prompt_experiments", "traffic_percent adds up to %d, more than 100", totalTraffic)
+	}
+
+	if c.ModerationMode != "block" && c.ModerationMode != "flag" {
+		add("moderation_mode", "unknown mode %q (block, flag)", c.ModerationMode)
+	}
+	if !slices.Contains(KnownModerationProviders, c.ModerationProvider) {
+		add("moderation_provider", "unknown provider %q", c.ModerationProvider)
+	}
+	if c.ModerationEnabled && c.ModerationProvider == "endpoint" && c.ModerationEndpointURL == "" {
+		add("moderation_endpoint_url", "required when moderation_provider is endpoint")
+	}
+	for _, rule := range c.ModerationRules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" {
+			add("moderation_rules", "invalid pattern %q", rule.Pattern)
+		}
+	}
+
+	if c.LoginMaxAttempts < 1 {
+		add("login_max_attempts", "must be at least 1")
+	}
+	if c.LoginIPMaxAttempts < 1 {
+		add("login_ip_max_attempts", "must be at least 1")
+	}
+	if c.LoginLockoutMinutes < 1 {
+		add("login_lockout_minutes", "must be at least 1")
+	}
+	if c.ContactRateLimitPerHour < 1 {
+		add("contact_rate_limit_per_hour", "must be at least 1")
+	}
+	if c.PasswordMinLength < 1 {
+		add("password_min_length", "must be at least 1")
+	}
+	if c.PasswordMaxLength < c.PasswordMinLength || c.PasswordMaxLength > DEFAULT_PASSWORD_MAX_LENGTH {
+		add("password_max_length", "must be between password_min_length and %d", DEFAULT_PASSWORD_MAX_LENGTH)
+	}
+
+	if c.IdempotencyTTLHours < 1 {
+		add("idempotency_ttl_hours", "must be at least 1")
+	}
+	if c.IdempotencyMaxBodyBytes < 1 {
+		add("idempotency_max_body_bytes", "must be at least 1")
+	}
+	if c.IdempotencyWaitSeconds < 0 {
+		add("idempotency_wait_seconds", "must not be negative")
+	}
+	if c.FreeMaxTenants < 1 {
+		add("free_max_tenants", "must be at least 1")
+	}
+	if !slices.Contains(KnownThinkingModes, c.ThinkingMode) {
+		add("thinking_mode", "unknown mode %q (known: %s)", c.ThinkingMode, strings.Join(KnownThinkingModes[1:], ", "))
+	}
+	if c.ThinkingIntervalMs < 100 {
+		add("thinking_interval_ms", "must be at least 100")
+	}
+	if c.MockLatencyMs < 0 {
+		add("mock_latency_ms", "must not be negative")
+	}
+	if c.MockChunkSize < 0 {
+		add("mock_chunk_size", "must not be negative")
+	}
+	if c.MockChunkDelayMs < 0 {
+		add("mock_chunk_delay_ms", "must not be negative")
+	}
+	if c.ThinkingMaxChars < 1 {
+		add("thinking_max_chars", "must be at least 1")
+	}
+	if c.SectionConcurrency < 1 {
+		add("section_processing_concurrency", "must be at least 1")
+	}
+	if c.ProcessorTimeoutSeconds < 1 {
+		add("processor_timeout_seconds", "must be at least 1")
+	}
+	if c.ShareLinkDays < 1 {
+		add("share_link_days", "must be at least 1")
+	}
+	if c.ShareLinkMaxDays < c.ShareLinkDays {
+		add("share_link_max_days", "must be at least share_link_days (%d)", c.ShareLinkDays)
+	}
+	if c.PreviewUnlockPerHour < 1 {
+		add("preview_unlock_per_hour", "must be at least 1")
+	}
+	if c.DraftMaxAgeDays < 0 {
+		add("draft_max_age_days", "must not be negative")
+	}
+	if c.RequestLogSuccessSamplePercent < 0 || c.RequestLogSuccessSamplePercent > 100 {
+		add("request_log_success_sample_percent", "must be between 0 and 100")
+	}
+	if c.RequestLogRetentionDays < 1 {
+		add("request_log_retention_days", "must be at least 1")
+	}
+	if c.MetricsTenantBuckets < 0 || c.MetricsTenantBuckets > MAX_METRICS_TENANT_BUCKETS {
+		add("metrics_tenant_buckets", "must be between 0 and %d", MAX_METRICS_TENANT_BUCKETS)
+	}
+	if c.MetricsMaxTenantLabels < 0 {
+		add("metrics_max_tenant_labels", "must not be negative")
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.FeatureFlags)) {
+		if !slices.Contains(KnownFeatureFlags, name) {
+			add("feature_flags", "unknown flag %q", name)
+		}
+	}
+	if c.MaintenanceRetryAfterSeconds < 1 {
+		add("maintenance_retry_after_seconds", "must be at least 1")
+	}
+	if c.FrontendURL != "" {
+		if err := validateRedirectOrigin(c.FrontendURL); err != nil && !errors.Is(err, errRedirectPath) {
+			add("frontend_url", "%v", err)
+		}
+	}
+	for _, origin := range c.AllowedRedirectOrigins {
+		if err := validateRedirectOrigin(strings.TrimSpace(origin)); err != nil {
+			add("allowed_redirect_origins", "%q: %v", origin, err)
+		}
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, err := ParseIPPrefix(proxy); err != nil {
+			add("trusted_proxies", "%v", err)
+		}
+	}
+	for _, header := range c.ClientIPHeaders {
+		if strings.TrimSpace(header) == "" {
+			add("client_ip_headers", "header names must not be empty")
+		}
+	}
+
+	if !slices.Contains(KnownServerModes, c.ServerMode) {
+		add("server_mode", "unknown mode %q (simple, full)", c.ServerMode)
+	}
+	if !strings.HasPrefix(c.StaticBasePath, "/") {
+		add("static_base_path", "must start with /")
+	}
+	for _, prefix := range c.StaticImmutablePrefixes {
+		if !strings.HasPrefix(strings.TrimSpace(prefix), "/") {
+			add("static_immutable_prefixes", "%q must start with /", prefix)
+		}
+	}
+
+	if !slices.Contains(KnownRedisModes, c.RedisMode) {
+		add("redis_mode", "unknown mode %q (known: %s)", c.RedisMode, strings.Join(KnownRedisModes, ", "))
+	}
+	if c.RedisMode == "sentinel" {
+		if c.RedisMasterName == "" {
+			add("redis_master_name", "is required in sentinel mode")
+		}
+		if len(c.RedisSentinelAddrs) == 0 {
+			add("redis_sentinel_addrs", "at least one sentinel is required in sentinel mode")
+		}
+	}
+	if c.RedisMode != "sentinel" && c.RedisAddr == "" {
+		add("redis_addr", "is required")
+	}
+	if c.RedisPoolSize < 0 {
+		add("redis_pool_size", "must not be negative")
+	}
+	if c.RedisMinIdleConns < 0 {
+		add("redis_min_idle_conns", "must not be negative")
+	}
+	if c.RedisDialTimeoutMs <= 0 {
+		add("redis_dial_timeout_ms", "must be positive")
+	}
+	if c.RedisReadTimeoutMs <= 0 {
+		add("redis_read_timeout_ms", "must be positive")
+	}
+	if c.RedisWriteTimeoutMs <= 0 {
+		add("redis_write_timeout_ms", "must be positive")
+	}
+
+	if !slices.Contains(KnownChatStores, c.ChatStore) {
+		add("chat_store", "unknown chat store %q", c.ChatStore)
+	}
+	if c.ChatTrashRetentionDays < 1 {
+		add("chat_trash_retention_days", "must be at least 1")
+	}
+	if !slices.Contains(KnownChatSpills, c.ChatSpill) {
+		add("chat_spill", "unknown chat spill %q", c.ChatSpill)
+	}
+	if (c.ChatSpill == "" || c.ChatSpill == "dir") && c.ChatSpillDir == "" {
+		add("chat_spill_dir", "is required for the dir spill")
+	}
+	if c.ChatSpillAlertThreshold < 1 {
+		add("chat_spill_alert_threshold", "must be at least 1")
+	}
+	if c.RedisReaperIntervalHours < 1 {
+		add("redis_reaper_interval_hours", "must be at least 1")
+	}
+	if c.RedisReaperScanCount < 1 {
+		add("redis_reaper_scan_count", "must be at least 1")
+	}
+	if c.RedisReaperScanPauseMs < 0 {
+		add("redis_reaper_scan_pause_ms", "must not be negative")
+	}
+	for _, namespace := range slices.Sorted(maps.Keys(c.RedisReaperPolicies)) {
+		policy := c.RedisReaperPolicies[namespace]
+		switch {
+		case namespace == "" || strings.ContainsAny(namespace, ":*?[]{}"):
+			add("redis_reaper_policies", "%q: namespace must be a plain key prefix", namespace)
+		case !slices.Contains(KnownReaperPolicies, policy.Policy):
+			add("redis_reaper_policies", "%s: unknown policy %q (known: %s)", namespace, policy.Policy, strings.Join(KnownReaperPolicies, ", "))
+		case policy.Policy == REAPER_POLICY_TTL && policy.TTLSeconds < 1:
+			add("redis_reaper_policies", "%s: ttl_seconds must be at least 1", namespace)
+		case policy.TTLSeconds < 0:
+			add("redis_reaper_policies", "%s: ttl_seconds must not be negative", namespace)
+		}
+	}
+
+	if !slices.Contains(KnownImageStores, c.ImageStore) {
+		add("image_store", "unknown image store %q", c.ImageStore)
+	}
+	if c.ImageStore == "gcs" && c.ImageStoreBucket == "" {
+		add("image_store_bucket", "required when image_store is gcs")
+	}
+	if c.ImageRehostConcurrency < 0 {
+		add("image_rehost_concurrency", "must not be negative")
+	}
+	for field, widths := range map[string][]int{
+		"image_hero_widths":    c.ImageHeroWidths,
+		"image_card_widths":    c.ImageCardWidths,
+		"image_default_widths": c.ImageDefaultWidths,
+	} {
+		if !slices.IsSorted(widths) || (len(widths) > 0 && widths[0] <= 0) {
+			add(field, "widths must be positive and ascending")
+		}
+	}
+
+	processorErrs := c.validateProcessorSettings()
+	for _, name := range slices.Sorted(maps.Keys(processorErrs)) {
+		add("processor_settings", "%s: %v", name, processorErrs[name])
+	}
+
+	if !slices.Contains(KnownRegistrarProviders, c.DomainRegistrarProvider) {
+		add("domain_registrar_provider", "unknown provider %q", c.DomainRegistrarProvider)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(errs, func(a, b *ConfigError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return errs
+}
+
+func validateListenAddr(addr string) error {
+	if addr == "" {
+		return fmt.Errorf("is required")
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("malformed address %q: %w", addr, err)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+func validModelName(name string) bool {
+	publisher, model, ok := strings.Cut(name, "/")
+	return ok && publisher != "" && model != "" && !strings.ContainsAny(name, " \t")
+}
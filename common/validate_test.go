@@ -0,0 +1,146 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultConfigValid(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("DefaultConfig().Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(c *Config)
+		field   string
+		message string
+	}{
+		{"empty listen addr", func(c *Config) { c.ListenAddr = "" }, "listen_addr", "is required"},
+		{"listen addr without port", func(c *Config) { c.ListenAddr = "localhost" }, "listen_addr", "malformed address"},
+		{"listen addr bad port", func(c *Config) { c.ListenAddr = ":http-ish" }, "listen_addr", "invalid port"},
+		{"listen addr port out of range", func(c *Config) { c.ListenAddr = ":70000" }, "listen_addr", "invalid port"},
+		{"negative min input tokens", func(c *Config) { c.MinInputTokens = -1 }, "min_input_tokens", "must not be negative"},
+		{"negative max input tokens", func(c *Config) { c.MaxInputTokens = -1 }, "max_input_tokens", "must be positive"},
+		{"zero max output tokens", func(c *Config) { c.MaxOutputTokens = 0 }, "max_output_tokens", "must be positive"},
+		{"min over max input tokens", func(c *Config) { c.MinInputTokens, c.MaxInputTokens = 200, 100 }, "min_input_tokens", "greater than max_input_tokens"},
+		{"unknown processor", func(c *Config) { c.EnabledProcessors = append(c.EnabledProcessors, "sparkle") }, "enabled_processors", `unknown processor "sparkle"`},
+		{"contact before placeholders", func(c *Config) { c.EnabledProcessors = []string{"contact", "placeholders"} }, "enabled_processors", "contact must come after placeholders"},
+		{"no models", func(c *Config) { c.EnabledModels, c.DefaultModel = nil, "" }, "enabled_models", "at least one model"},
+		{"malformed model", func(c *Config) { c.EnabledModels = append(c.EnabledModels, "gemini") }, "enabled_models", `malformed model "gemini"`},
+		{"default model not enabled", func(c *Config) { c.DefaultModel = "google/unknown" }, "default_model", "is not in enabled_models"},
+		{"no default model", func(c *Config) { c.DefaultModel = "" }, "default_model", "is required"},
+		{"title model not enabled", func(c *Config) { c.ChatTitleModel = "google/unknown" }, "chat_title_model", "is not in enabled_models"},
+		{"unknown history strategy", func(c *Config) { c.HistorySummaryStrategy = "magic" }, "history_summary_strategy", `unknown strategy "magic"`},
+		{"unsplash key without secret", func(c *Config) { c.UnsplashAPIAccessKey, c.UnsplashAPISecretKey = "access", "" }, "unsplash_api_secret_key", "required when unsplash_api_access_key is set"},
+		{"unknown prompt format", func(c *Config) { c.PromptFormat = "haiku" }, "prompt_format", `unknown format "haiku"`},
+		{"unknown redis mode", func(c *Config) { c.RedisMode = "mesh" }, "redis_mode", `unknown mode "mesh"`},
+		{"sentinel without master", func(c *Config) { c.RedisMode, c.RedisSentinelAddrs = "sentinel", []string{"localhost:26379"} }, "redis_master_name", "is required in sentinel mode"},
+		{"sentinel without sentinels", func(c *Config) { c.RedisMode, c.RedisMasterName = "sentinel", "mymaster" }, "redis_sentinel_addrs", "at least one sentinel"},
+		{"cluster without seeds", func(c *Config) { c.RedisMode, c.RedisAddr = "cluster", "" }, "redis_addr", "is required"},
+		{"negative redis pool", func(c *Config) { c.RedisPoolSize = -1 }, "redis_pool_size", "must not be negative"},
+		{"zero redis read timeout", func(c *Config) { c.RedisReadTimeoutMs = 0 }, "redis_read_timeout_ms", "must be positive"},
+		{"unknown chat store", func(c *Config) { c.ChatStore = "mongo" }, "chat_store", `unknown chat store "mongo"`},
+		{"unknown feature flag", func(c *Config) { c.FeatureFlags = map[string]bool{"time_travel": true} }, "feature_flags", `unknown flag "time_travel"`},
+		{"unknown server mode", func(c *Config) { c.ServerMode = "turbo" }, "server_mode", `unknown mode "turbo"`},
+		{"relative static base path", func(c *Config) { c.StaticBasePath = "app/" }, "static_base_path", "must start with /"},
+		{"relative immutable prefix", func(c *Config) { c.StaticImmutablePrefixes = []string{"/assets/", "static/"} }, "static_immutable_prefixes", `"static/" must start with /`},
+		{"gcs without bucket", func(c *Config) { c.ImageStore, c.ImageStoreBucket = "gcs", "" }, "image_store_bucket", "required when image_store is gcs"},
+		{"descending widths", func(c *Config) { c.ImageHeroWidths = []int{1920, 1280} }, "image_hero_widths", "ascending"},
+		{"traffic over 100", func(c *Config) {
+			c.PromptExperiments = []PromptExperiment{{Name: "a", Template: "a.md", TrafficPercent: 60}, {Name: "b", Template: "b.md", TrafficPercent: 60}}
+		}, "prompt_experiments", "adds up to 120"},
+		{"frontend url without scheme", func(c *Config) { c.FrontendURL = "app.example.com" }, "frontend_url", "scheme must be http or https"},
+		{"redirect origin with path", func(c *Config) { c.AllowedRedirectOrigins = []string{"https://app.example.com/login"} }, "allowed_redirect_origins", "must be an origin"},
+		{"redirect origin without host", func(c *Config) { c.AllowedRedirectOrigins = []string{"https://"} }, "allowed_redirect_origins", "host is missing"},
+		{"negative generation credits", func(c *Config) { c.ChatGenerationCredits = -1 }, "chat_generation_credits", "must not be negative"},
+		{"zero context window", func(c *Config) { c.ContextWindow = 0 }, "context_window", "must be positive"},
+		{"limits for a disabled model", func(c *Config) { c.ModelLimits = map[string]ModelLimits{"google/unknown": {ContextWindow: 1000}} }, "model_limits", `"google/unknown" is not in enabled_models`},
+		{"negative model limit", func(c *Config) { c.ModelLimits = map[string]ModelLimits{c.DefaultModel: {MaxOutputTokens: -1}} }, "model_limits", "must not be negative"},
+		{"input fills the window", func(c *Config) {
+			c.ModelLimits = map[string]ModelLimits{c.DefaultModel: {ContextWindow: 1000, MaxInputTokens: 1000}}
+		}, "model_limits", "leaves no room for output"},
+		{"bad moderation rule", func(c *Config) { c.ModerationRules = []ModerationRule{{Pattern: "("}} }, "moderation_rules", `invalid pattern "("`},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.mutate(cfg)
+		err := cfg.Validate()
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: Validate() = %v, want ValidationErrors", tt.name, err)
+			continue
+		}
+		found := false
+		for _, e := range errs {
+			if e.Field == tt.field && strings.Contains(e.Message, tt.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: Validate() = %v, want %s: ...%s...", tt.name, err, tt.field, tt.message)
+		}
+	}
+}
+
+func TestValidateStripeWebhookSecret(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_123")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "")
+
+	var target *ConfigError
+	if err := DefaultConfig().Validate(); !errors.As(err, &target) || target.Field != "STRIPE_WEBHOOK_SECRET" {
+		t.Errorf("Validate() = %v, want a STRIPE_WEBHOOK_SECRET error", err)
+	}
+
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "whsec_123")
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ListenAddr = ""
+	cfg.MaxOutputTokens = 0
+	cfg.DefaultModel = "google/unknown"
+
+	var errs ValidationErrors
+	if err := cfg.Validate(); !errors.As(err, &errs) {
+		t.Fatalf("Validate() = %v, want ValidationErrors", err)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	// Sorted by field
+	want := []string{"default_model", "listen_addr", "max_output_tokens"}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("Validate() fields = %q, want %q", fields, want)
+	}
+	if !strings.HasPrefix(errs.Error(), "invalid config (3 problems): ") {
+		t.Errorf("Error() = %q", errs.Error())
+	}
+}
+
+func TestLoadConfigFileDecodeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"listen_addr": ":8080",`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), "failed to decode config file") {
+		t.Errorf("LoadConfigFile() error = %v, want a decode error", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"max_input_tokens": "many"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(path); err == nil {
+		t.Error("LoadConfigFile() of a mistyped setting error = nil, want a decode error")
+	}
+}
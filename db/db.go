@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	_ "github.com/bartventer/gorm-multitenancy/postgres/v8"
 	multitenancy "github.com/bartventer/gorm-multitenancy/v8"
@@ -18,17 +19,44 @@ type DB struct {
 	*multitenancy.DB
 }
 
+// Connection pool defaults, sized so several replicas stay well within
+// Postgres' default max_connections
+const (
+	DefaultMaxOpenConns       = 25
+	DefaultMaxIdleConns       = 5
+	DefaultConnMaxLifetime    = 30 * time.Minute
+	DefaultConnMaxIdleTime    = 5 * time.Minute
+	DefaultSlowQueryThreshold = 500 * time.Millisecond
+)
+
 // Config holds database configuration
 type Config struct {
 	DatabaseURL string
 	Debug       bool
+
+	// Connection pool, applied to the underlying sql.DB
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// Queries taking at least this long are logged; 0 turns it off
+	SlowQueryThreshold time.Duration
 }
 
-// NewConfig creates a database config from environment variables
+// NewConfig creates a database config from environment variables:
+// DATABASE_URL, DB_DEBUG, DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS,
+// DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME and DB_SLOW_QUERY_THRESHOLD
+// (durations such as 30m or 250ms)
 func NewConfig() *Config {
 	return &Config{
-		DatabaseURL: os.Getenv("DATABASE_URL"),
-		Debug:       os.Getenv("DB_DEBUG") == "true",
+		DatabaseURL:        os.Getenv("DATABASE_URL"),
+		Debug:              os.Getenv("DB_DEBUG") == "true",
+		MaxOpenConns:       envInt("DB_MAX_OPEN_CONNS", DefaultMaxOpenConns),
+		MaxIdleConns:       envInt("DB_MAX_IDLE_CONNS", DefaultMaxIdleConns),
+		ConnMaxLifetime:    envDuration("DB_CONN_MAX_LIFETIME", DefaultConnMaxLifetime),
+		ConnMaxIdleTime:    envDuration("DB_CONN_MAX_IDLE_TIME", DefaultConnMaxIdleTime),
+		SlowQueryThreshold: envDuration("DB_SLOW_QUERY_THRESHOLD", DefaultSlowQueryThreshold),
 	}
 }
 
@@ -36,10 +64,8 @@ func NewConfig() *Config {
 // This is a simplified version that takes just a database URL
 func Connect(databaseURL string) (*DB, error) {
 	ctx := context.Background()
-	cfg := &Config{
-		DatabaseURL: databaseURL,
-		Debug:       os.Getenv("DB_DEBUG") == "true",
-	}
+	cfg := NewConfig()
+	cfg.DatabaseURL = databaseURL
 	return ConnectWithConfig(ctx, cfg)
 }
 
@@ -49,9 +75,12 @@ func ConnectWithConfig(ctx context.Context, cfg *Config) (*DB, error) {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
 	}
 
-	gormConfig := &gorm.Config{}
+	gormLogger := logger.Default
 	if cfg.Debug {
-		gormConfig.Logger = logger.Default.LogMode(logger.Info)
+		gormLogger = gormLogger.LogMode(logger.Info)
+	}
+	gormConfig := &gorm.Config{
+		Logger: newSlowQueryLogger(gormLogger, cfg.SlowQueryThreshold),
 	}
 
 	db, err := multitenancy.OpenDB(ctx, cfg.DatabaseURL, gormConfig)
@@ -59,7 +88,19 @@ func ConnectWithConfig(ctx context.Context, cfg *Config) (*DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	slog.Info("Database connection established")
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get underlying DB: %w", err)
+	}
+	applyPool(sqlDB, cfg)
+
+	slog.Info("Database connection established",
+		"max_open_conns", cfg.MaxOpenConns,
+		"max_idle_conns", cfg.MaxIdleConns,
+		"conn_max_lifetime", cfg.ConnMaxLifetime,
+		"conn_max_idle_time", cfg.ConnMaxIdleTime,
+		"slow_query_threshold", cfg.SlowQueryThreshold,
+	)
 	return &DB{DB: db}, nil
 }
 
@@ -77,6 +118,9 @@ func (db *DB) MigrateSharedModels(ctx context.Context) error {
 	if err := db.DB.MigrateSharedModels(ctx); err != nil {
 		return fmt.Errorf("failed to migrate shared models: %w", err)
 	}
+	if err := db.upgradeSharedData(ctx); err != nil {
+		return fmt.Errorf("failed to upgrade shared data: %w", err)
+	}
 	slog.Info("Shared models migrated")
 	return nil
 }
@@ -109,6 +153,7 @@ func (db *DB) DeleteTenantSchema(ctx context.Context, tenantID string) error {
 
 // WithTenant executes a function within a tenant's context
 func (db *DB) WithTenant(ctx context.Context, tenantID string, fn func(tx *gorm.DB) error) error {
+	ctx = withTenantSchema(ctx, tenantID)
 	return db.DB.WithTenant(ctx, tenantID, func(tx *multitenancy.DB) error {
 		return fn(tx.DB)
 	})
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// tenantColumnRename renames a column in existing tenant schemas. AutoMigrate
+// would otherwise add the new column beside the old one.
+type tenantColumnRename struct {
+	table string
+	from  string
+	to    string
+}
+
+var tenantColumnRenames = []tenantColumnRename{
+	// "primary" is a reserved word in Postgres
+	{table: "domains", from: "primary", to: "is_primary"},
+}
+
+// tenantFixup adjusts existing data so it satisfies constraints added by
+// AutoMigrate. It runs only when the column exists. The SQL receives the
+// quoted schema name as %[1]s.
+type tenantFixup struct {
+	table  string
+	column string
+	sql    string
+}
+
+var tenantFixups = []tenantFixup{
+	// At most one primary domain per tenant, required by idx_domains_one_primary
+	{table: "domains", column: "is_primary", sql: `UPDATE %[1]s.domains SET is_primary = false
+		WHERE is_primary AND (deleted_at IS NOT NULL OR id NOT IN (
+			SELECT MIN(id) FROM %[1]s.domains WHERE is_primary AND deleted_at IS NULL GROUP BY tenant_schema
+		))`},
+	// One account per tenant, required by idx_accounts_one_per_tenant
+	{table: "accounts", column: "tenant_schema", sql: `UPDATE %[1]s.accounts SET deleted_at = now()
+		WHERE deleted_at IS NULL AND id NOT IN (
+			SELECT MIN(id) FROM %[1]s.accounts WHERE deleted_at IS NULL GROUP BY tenant_schema
+		)`},
+}
+
+// tenantBackfills fill tables added by AutoMigrate from existing data, so
+// they run after the tenant's models are migrated. Like tenantFixups they
+// run only when the column exists, and must be safe to run again.
+var tenantBackfills = []tenantFixup{
+	// Balances from before the credit ledger become its opening transactions
+	{table: "accounts", column: "basic_credits", sql: `INSERT INTO %[1]s.credit_transactions
+		(created_at, updated_at, tenant_schema, credit_type, type, delta, balance_after, reason, actor)
+		SELECT now(), now(), a.tenant_schema, b.credit_type, 'opening_balance', b.balance, b.balance, 'Balance before the credit ledger', 'system'
+		FROM %[1]s.accounts a
+		CROSS JOIN LATERAL (VALUES ('basic', a.basic_credits), ('premium', a.premium_credits)) AS b(credit_type, balance)
+		WHERE a.deleted_at IS NULL AND b.balance <> 0 AND NOT EXISTS (
+			SELECT 1 FROM %[1]s.credit_transactions t
+			WHERE t.tenant_schema = a.tenant_schema AND t.credit_type = b.credit_type
+		)`},
+}
+
+// sharedFixups adjust existing rows in shared tables after they are migrated
+var sharedFixups = []string{
+	// Password reset tokens are stored as "sha256:<hex>"; hash tokens issued
+	// before that so outstanding reset links keep working
+	`UPDATE public.users SET password_reset_token = 'sha256:' || encode(sha256(convert_to(password_reset_token, 'UTF8')), 'hex')
+		WHERE password_reset_token IS NOT NULL AND password_reset_token NOT LIKE 'sha256:%'`,
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func columnExists(tx *gorm.DB, schema, table, column string) (bool, error) {
+	var exists bool
+	err := tx.Raw(`SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ? AND column_name = ?
+	)`, schema, table, column).Scan(&exists).Error
+	return exists, err
+}
+
+// MigrateExistingTenants applies column renames and data fixups to existing
+// tenant schemas and then migrates their models
+func (db *DB) MigrateExistingTenants(ctx context.Context, tenantIDs []string) error {
+	for _, tenantID := range tenantIDs {
+		if err := db.upgradeTenantSchema(ctx, tenantID); err != nil {
+			return fmt.Errorf("failed to upgrade tenant %s: %w", tenantID, err)
+		}
+		if err := db.MigrateTenantModels(ctx, tenantID); err != nil {
+			return err
+		}
+		if err := db.backfillTenantData(ctx, tenantID); err != nil {
+			return fmt.Errorf("failed to backfill tenant %s: %w", tenantID, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) upgradeTenantSchema(ctx context.Context, tenantID string) error {
+	return db.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		schema := quoteIdent(tenantID)
+
+		for _, r := range tenantColumnRenames {
+			exists, err := columnExists(tx, tenantID, r.table, r.from)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+
+			sql := fmt.Sprintf("ALTER TABLE %s.%s RENAME COLUMN %s TO %s",
+				schema, quoteIdent(r.table), quoteIdent(r.from), quoteIdent(r.to))
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+			slog.Info("Renamed tenant column", "tenant", tenantID, "table", r.table, "from", r.from, "to", r.to)
+		}
+
+		for _, f := range tenantFixups {
+			exists, err := columnExists(tx, tenantID, f.table, f.column)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := tx.Exec(fmt.Sprintf(f.sql, schema)).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// backfillTenantData applies the tenant backfills
+func (db *DB) backfillTenantData(ctx context.Context, tenantID string) error {
+	return db.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		schema := quoteIdent(tenantID)
+
+		for _, f := range tenantBackfills {
+			exists, err := columnExists(tx, tenantID, f.table, f.column)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := tx.Exec(fmt.Sprintf(f.sql, schema)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// upgradeSharedData applies the shared data fixups
+func (db *DB) upgradeSharedData(ctx context.Context) error {
+	return db.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, sql := range sharedFixups {
+			if err := tx.Exec(sql).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
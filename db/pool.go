@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// applyPool sets the connection pool limits of cfg on sqlDB
+func applyPool(sqlDB *sql.DB, cfg *Config) {
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
+
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	MaxOpenConns      int   `json:"maxOpenConns"`
+	OpenConns         int   `json:"openConns"`
+	InUse             int   `json:"inUse"`
+	Idle              int   `json:"idle"`
+	WaitCount         int64 `json:"waitCount"`
+	WaitDurationMs    int64 `json:"waitDurationMs"`
+	MaxIdleClosed     int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64 `json:"maxLifetimeClosed"`
+}
+
+// Stats returns the connection pool's current statistics
+func (db *DB) Stats() (PoolStats, error) {
+	sqlDB, err := db.DB.DB.DB()
+	if err != nil {
+		return PoolStats{}, err
+	}
+	s := sqlDB.Stats()
+	return PoolStats{
+		MaxOpenConns:      s.MaxOpenConnections,
+		OpenConns:         s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDurationMs:    s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}, nil
+}
+
+type tenantSchemaKey struct{}
+
+// withTenantSchema records the tenant a query runs for, for the slow query log
+func withTenantSchema(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantSchemaKey{}, tenantID)
+}
+
+// slowQueryLogger passes everything to the gorm logger it wraps and also
+// logs queries slower than threshold, with their tenant schema
+type slowQueryLogger struct {
+	logger.Interface
+	threshold time.Duration
+	log       *slog.Logger
+}
+
+func newSlowQueryLogger(inner logger.Interface, threshold time.Duration) *slowQueryLogger {
+	return &slowQueryLogger{
+		Interface: inner,
+		threshold: threshold,
+		log:       slog.With("service", "Database"),
+	}
+}
+
+// LogMode keeps the slow query log when the wrapped logger's level changes
+func (l *slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &slowQueryLogger{Interface: l.Interface.LogMode(level), threshold: l.threshold, log: l.log}
+}
+
+// Trace is called by gorm after every query
+func (l *slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+
+	elapsed := time.Since(begin)
+	if l.threshold <= 0 || elapsed < l.threshold {
+		return
+	}
+	query, rows := fc()
+	tenant, _ := ctx.Value(tenantSchemaKey{}).(string)
+	l.log.Warn("Slow query",
+		"duration_ms", elapsed.Milliseconds(),
+		"threshold_ms", l.threshold.Milliseconds(),
+		"rows", rows,
+		"tenant", tenant,
+		"sql", query,
+	)
+}
+
+// envInt reads a non-negative integer from the environment
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		slog.Warn("Invalid database setting, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a non-negative duration such as 30m from the environment
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		slog.Warn("Invalid database setting, using default", "name", name, "value", v, "default", def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// unreachableDriver is a database/sql driver whose connections always fail,
+// enough to open a pool without a server
+type unreachableDriver struct{}
+
+func (unreachableDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("no database in unit tests")
+}
+
+func init() {
+	sql.Register("db-unit-test", unreachableDriver{})
+}
+
+func TestApplyPool(t *testing.T) {
+	sqlDB, err := sql.Open("db-unit-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+
+	applyPool(sqlDB, &Config{MaxOpenConns: 7, MaxIdleConns: 2, ConnMaxLifetime: time.Minute, ConnMaxIdleTime: time.Second})
+	if got := sqlDB.Stats().MaxOpenConnections; got != 7 {
+		t.Errorf("MaxOpenConnections = %d, want 7", got)
+	}
+}
+
+func TestNewConfigPool(t *testing.T) {
+	cfg := NewConfig()
+	if cfg.MaxOpenConns != DefaultMaxOpenConns || cfg.MaxIdleConns != DefaultMaxIdleConns ||
+		cfg.ConnMaxLifetime != DefaultConnMaxLifetime || cfg.ConnMaxIdleTime != DefaultConnMaxIdleTime ||
+		cfg.SlowQueryThreshold != DefaultSlowQueryThreshold {
+		t.Errorf("NewConfig() without environment = %+v, want the defaults", cfg)
+	}
+
+	t.Setenv("DB_MAX_OPEN_CONNS", "40")
+	t.Setenv("DB_MAX_IDLE_CONNS", "-3")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+	t.Setenv("DB_CONN_MAX_IDLE_TIME", "soon")
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "0")
+	cfg = NewConfig()
+	if cfg.MaxOpenConns != 40 || cfg.ConnMaxLifetime != time.Hour || cfg.SlowQueryThreshold != 0 {
+		t.Errorf("NewConfig() = %+v, want the environment's settings", cfg)
+	}
+	// Invalid settings fall back to the defaults
+	if cfg.MaxIdleConns != DefaultMaxIdleConns || cfg.ConnMaxIdleTime != DefaultConnMaxIdleTime {
+		t.Errorf("NewConfig() with invalid settings = %+v, want the defaults", cfg)
+	}
+}
+
+// recordingLogger is a gorm logger counting Trace calls
+type recordingLogger struct {
+	logger.Interface
+	traces int
+}
+
+func (l *recordingLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.traces++
+}
+
+func TestSlowQueryLogger(t *testing.T) {
+	var buf bytes.Buffer
+	inner := &recordingLogger{Interface: logger.Discard}
+	l := newSlowQueryLogger(inner, 100*time.Millisecond)
+	l.log = slog.New(slog.NewTextHandler(&buf, nil))
+	query := func() (string, int64) { return `SELECT * FROM "chats"`, 3 }
+
+	ctx := withTenantSchema(context.Background(), "tenant_bakery")
+	l.Trace(ctx, time.Now(), query, nil)
+	if buf.Len() != 0 {
+		t.Errorf("fast query logged: %s", buf.String())
+	}
+
+	l.Trace(ctx, time.Now().Add(-250*time.Millisecond), query, nil)
+	logged := buf.String()
+	for _, want := range []string{"Slow query", "tenant=tenant_bakery", "rows=3", "threshold_ms=100", `SELECT * FROM \"chats\"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("slow query log = %s, want %s", logged, want)
+		}
+	}
+	if inner.traces != 2 {
+		t.Errorf("wrapped logger traced %d queries, want every one", inner.traces)
+	}
+
+	// A zero threshold turns the log off
+	buf.Reset()
+	off := newSlowQueryLogger(inner, 0)
+	off.log = l.log
+	off.Trace(ctx, time.Now().Add(-time.Hour), query, nil)
+	if buf.Len() != 0 {
+		t.Errorf("query logged with the threshold off: %s", buf.String())
+	}
+
+	// Changing the level keeps the slow query log
+	if _, ok := l.LogMode(logger.Info).(*slowQueryLogger); !ok {
+		t.Error("LogMode() dropped the slow query logger")
+	}
+}
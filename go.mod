@@ -3,32 +3,35 @@ module awning-backend
 go 1.24.1
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/bartventer/gorm-multitenancy/middleware/gin/v8 v8.6.0
+	github.com/bartventer/gorm-multitenancy/postgres/v8 v8.9.0
+	github.com/bartventer/gorm-multitenancy/v8 v8.9.0
 	github.com/gin-contrib/cors v1.7.6
 	github.com/gin-gonic/gin v1.11.0
+	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/google/uuid v1.6.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/redis/go-redis/v9 v9.17.2
+	github.com/stripe/stripe-go/v84 v84.1.0
 	github.com/tiktoken-go/tokenizer v0.7.0
+	gorm.io/gorm v1.31.1
 )
 
 require (
-	github.com/bartventer/gorm-multitenancy/middleware/gin/v8 v8.6.0 // indirect
 	github.com/bartventer/gorm-multitenancy/middleware/nethttp/v8 v8.8.1 // indirect
-	github.com/bartventer/gorm-multitenancy/postgres/v8 v8.9.0 // indirect
-	github.com/bartventer/gorm-multitenancy/v8 v8.9.0 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-viper/mapstructure/v2 v2.3.0 // indirect
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20240606120523-5a60cdf6a761 // indirect
 	github.com/jackc/pgx/v5 v5.7.5 // indirect
 	github.com/jackc/puddle/v2 v2.2.2 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
-	github.com/stripe/stripe-go/v84 v84.1.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	gorm.io/driver/postgres v1.6.0 // indirect
-	gorm.io/gorm v1.31.1 // indirect
 )
 
 require (
@@ -57,11 +60,11 @@ require (
 	github.com/ugorji/go/codec v1.3.0 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.20.0 // indirect
-	golang.org/x/crypto v0.43.0 // indirect
+	golang.org/x/crypto v0.43.0
 	golang.org/x/mod v0.28.0 // indirect
 	golang.org/x/net v0.46.0
 	golang.org/x/oauth2 v0.33.0
-	golang.org/x/sync v0.18.0 // indirect
+	golang.org/x/sync v0.18.0
 	golang.org/x/sys v0.37.0 // indirect
 	golang.org/x/text v0.30.0 // indirect
 	golang.org/x/tools v0.37.0 // indirect
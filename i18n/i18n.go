@@ -0,0 +1,158 @@
+// Package i18n translates the human-readable messages of API errors. Error
+// codes stay stable; each locale's catalog maps codes to messages, and codes
+// missing from every catalog in a locale's fallback chain keep the English
+// message the handler passed.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is used when neither the request nor the tenant names a
+// supported locale. Its messages are the handlers' own.
+const DefaultLocale = "en-US"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs maps a lowercase locale, such as es or es-mx, to its messages by
+// error code
+var catalogs = func() map[string]map[string]string {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: reading locales: %v", err))
+	}
+	catalogs := make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: reading %s: %v", entry.Name(), err))
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("i18n: parsing %s: %v", entry.Name(), err))
+		}
+		catalogs[strings.ToLower(strings.TrimSuffix(entry.Name(), ".json"))] = messages
+	}
+	return catalogs
+}()
+
+// Locales returns the supported locales: en, which uses the handlers'
+// messages, and one per catalog, sorted
+func Locales() []string {
+	locales := []string{"en"}
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	slices.Sort(locales)
+	return slices.Compact(locales)
+}
+
+// Chain returns the locales tried for locale, most specific first, ending
+// with en: es-MX gives es-mx, es, en
+func Chain(locale string) []string {
+	chain := parents(locale)
+	if !slices.Contains(chain, "en") {
+		chain = append(chain, "en")
+	}
+	return chain
+}
+
+// parents returns locale and its parent locales in lowercase, es-mx and es
+// for es-MX
+func parents(locale string) []string {
+	var tags []string
+	tag := strings.ToLower(strings.TrimSpace(locale))
+	for tag != "" {
+		tags = append(tags, tag)
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	return tags
+}
+
+// Supported reports whether locale is English or it or one of its parent
+// locales has a catalog
+func Supported(locale string) bool {
+	for _, tag := range parents(locale) {
+		if _, ok := catalogs[tag]; ok || tag == "en" {
+			return true
+		}
+	}
+	return false
+}
+
+// Translate returns the message for code in locale, walking the locale's
+// fallback chain. Codes without a translation keep message. {name}
+// placeholders in a translation are replaced with args[name].
+func Translate(locale, code, message string, args map[string]any) string {
+	if code == "" {
+		return message
+	}
+	for _, tag := range Chain(locale) {
+		translated, ok := catalogs[tag][code]
+		if !ok {
+			continue
+		}
+		for name, value := range args {
+			translated = strings.ReplaceAll(translated, "{"+name+"}", fmt.Sprint(value))
+		}
+		return translated
+	}
+	return message
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header
+// in order of preference. Tags with q=0 and the * wildcard are left out.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, q})
+	}
+	slices.SortStableFunc(tags, func(a, b weighted) int {
+		switch {
+		case a.q > b.q:
+			return -1
+		case a.q < b.q:
+			return 1
+		}
+		return 0
+	})
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package i18n
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"es-MX", []string{"es-MX"}},
+		{"en-US,en;q=0.9,es;q=0.8", []string{"en-US", "en", "es"}},
+		{"fr;q=0.5, es-MX;q=0.9, de", []string{"de", "es-MX", "fr"}},
+		// Equal weights keep the header's order
+		{"es, fr", []string{"es", "fr"}},
+		{"es;q=0, fr;Q=0.4, *", []string{"fr"}},
+		{"es;q=abc, fr", []string{"fr"}},
+		{" es-MX ; q=0.7 , ,en-GB", []string{"en-GB", "es-MX"}},
+	}
+	for _, tt := range tests {
+		if got := ParseAcceptLanguage(tt.header); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   []string
+	}{
+		{"es-MX", []string{"es-mx", "es", "en"}},
+		{"zh-Hant-TW", []string{"zh-hant-tw", "zh-hant", "zh", "en"}},
+		{"en-US", []string{"en-us", "en"}},
+		{"", []string{"en"}},
+	}
+	for _, tt := range tests {
+		if got := Chain(tt.locale); !slices.Equal(got, tt.want) {
+			t.Errorf("Chain(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for locale, want := range map[string]bool{
+		"es":    true,
+		"ES-mx": true,
+		"en-GB": true,
+		"en":    true,
+		"fr":    false,
+		"fr-CA": false,
+		"":      false,
+	} {
+		if got := Supported(locale); got != want {
+			t.Errorf("Supported(%q) = %v, want %v", locale, got, want)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		locale, code, message string
+		args                  map[string]any
+		want                  string
+	}{
+		{"es-MX", "chat_forbidden", "You don't have access to this chat", nil, "No tienes acceso a este chat"},
+		{"es", "prompt_too_long", "Message exceeds the limit", map[string]any{"max_input_tokens": 4096}, "El mensaje supera el límite máximo de 4096 tokens"},
+		// Codes no catalog has keep the English message
+		{"es-MX", "not_in_any_catalog", "Something went wrong", nil, "Something went wrong"},
+		{"en-US", "chat_forbidden", "You don't have access to this chat", nil, "You don't have access to this chat"},
+		{"fr", "chat_forbidden", "You don't have access to this chat", nil, "You don't have access to this chat"},
+		{"es", "", "No code", nil, "No code"},
+	}
+	for _, tt := range tests {
+		if got := Translate(tt.locale, tt.code, tt.message, tt.args); got != tt.want {
+			t.Errorf("Translate(%q, %q) = %q, want %q", tt.locale, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestLocales(t *testing.T) {
+	if got, want := Locales(), []string{"en", "es"}; !slices.Equal(got, want) {
+		t.Errorf("Locales() = %q, want %q", got, want)
+	}
+}
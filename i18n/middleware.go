@@ -0,0 +1,102 @@
+package i18n
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	localeKey       = "i18n.locale"
+	tenantLocaleKey = "i18n.tenantLocale"
+)
+
+// TenantLocaleFunc returns the locale of the request's tenant, or "" when the
+// request has no tenant or it has no locale set
+type TenantLocaleFunc func(c *gin.Context) string
+
+// Middleware makes the request's locale available to Locale and Error. The
+// locale is resolved on first use, since the tenant is only known once the
+// route's tenant middleware ran. tenantLocale may be nil.
+func Middleware(tenantLocale TenantLocaleFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if tenantLocale != nil {
+			c.Set(tenantLocaleKey, tenantLocale)
+		}
+		c.Next()
+	}
+}
+
+// Locale returns the request's locale: the first supported locale in the
+// Accept-Language header, then the tenant profile's locale, then
+// DefaultLocale
+func Locale(c *gin.Context) string {
+	if locale := c.GetString(localeKey); locale != "" {
+		return locale
+	}
+	locale := resolveLocale(c)
+	c.Set(localeKey, locale)
+	return locale
+}
+
+func resolveLocale(c *gin.Context) string {
+	for _, tag := range ParseAcceptLanguage(c.GetHeader("Accept-Language")) {
+		if Supported(tag) {
+			return tag
+		}
+	}
+	if tenantLocale, ok := c.Value(tenantLocaleKey).(TenantLocaleFunc); ok {
+		if locale := tenantLocale(c); locale != "" && Supported(locale) {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
+
+// Message translates message, the English text for code, into the request's
+// locale
+func Message(c *gin.Context, code, message string) string {
+	return Translate(Locale(c), code, message, nil)
+}
+
+// Error writes the error envelope, {"error": message, "code": code}, with
+// message translated into the request's locale
+func Error(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"error": Message(c, code, message), "code": code})
+}
+
+// AbortWithError is Error for middleware: it also stops the handler chain
+func AbortWithError(c *gin.Context, status int, code, message string) {
+	c.Abort()
+	Error(c, status, code, message)
+}
+
+// Localize returns body with its "error" message translated by its "code".
+// The body's other fields fill {name} placeholders in the translation, for
+// envelopes that carry details such as a limit. body itself is not changed.
+func Localize(c *gin.Context, body gin.H) gin.H {
+	code, _ := body["code"].(string)
+	message, _ := body["error"].(string)
+	if code == "" || message == "" {
+		return body
+	}
+	localized := make(gin.H, len(body))
+	for k, v := range body {
+		localized[k] = v
+	}
+	localized["error"] = Translate(Locale(c), code, message, body)
+	return localized
+}
+
+// LocalesResponse lists the supported locales
+type LocalesResponse struct {
+	Locales []string `json:"locales"`
+	Default string   `json:"default"`
+}
+
+// RegisterRoutes adds GET /api/v1/meta/locales
+func RegisterRoutes(r gin.IRouter) {
+	r.GET("/api/v1/meta/locales", func(c *gin.Context) {
+		c.JSON(http.StatusOK, LocalesResponse{Locales: Locales(), Default: DefaultLocale})
+	})
+}
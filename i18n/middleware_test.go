@@ -0,0 +1,134 @@
+package i18n
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter serves an error at /error and the locale at /locale, with
+// the tenant locale tenantLocale; calls counts its lookups
+func newTestRouter(tenantLocale string, calls *int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(func(c *gin.Context) string {
+		*calls++
+		return tenantLocale
+	}))
+	r.GET("/error", func(c *gin.Context) {
+		Error(c, http.StatusForbidden, "chat_forbidden", "You don't have access to this chat")
+	})
+	r.GET("/locale", func(c *gin.Context) {
+		Locale(c)
+		c.String(http.StatusOK, Locale(c))
+	})
+	r.GET("/localize", func(c *gin.Context) {
+		c.JSON(http.StatusRequestEntityTooLarge, Localize(c, gin.H{"error": "Too long", "code": "prompt_too_long", "max_input_tokens": 100}))
+	})
+	RegisterRoutes(r)
+	return r
+}
+
+func get(r *gin.Engine, path, acceptLanguage string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLocaleResolution(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		tenantLocale   string
+		want           string
+	}{
+		{"header", "es-MX,en;q=0.5", "", "es-MX"},
+		{"first supported tag", "fr-FR, de;q=0.9, es;q=0.8", "", "es"},
+		{"header wins over tenant", "en-GB", "es-MX", "en-GB"},
+		{"tenant fallback", "fr-FR", "es-MX", "es-MX"},
+		{"no header", "", "es", "es"},
+		{"unsupported tenant locale", "", "fr-FR", DefaultLocale},
+		{"default", "", "", DefaultLocale},
+	}
+	for _, tt := range tests {
+		calls := 0
+		r := newTestRouter(tt.tenantLocale, &calls)
+		if got := get(r, "/locale", tt.acceptLanguage).Body.String(); got != tt.want {
+			t.Errorf("%s: Locale() = %q, want %q", tt.name, got, tt.want)
+		}
+		// Resolved once per request
+		if calls > 1 {
+			t.Errorf("%s: tenant locale looked up %d times", tt.name, calls)
+		}
+	}
+}
+
+func TestMiddlewareWithoutTenantLocale(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware(nil))
+	r.GET("/locale", func(c *gin.Context) { c.String(http.StatusOK, Locale(c)) })
+
+	if got := get(r, "/locale", "fr").Body.String(); got != DefaultLocale {
+		t.Errorf("Locale() = %q, want %q", got, DefaultLocale)
+	}
+}
+
+func TestError(t *testing.T) {
+	calls := 0
+	r := newTestRouter("", &calls)
+
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"es-MX", "No tienes acceso a este chat"},
+		{"en-US", "You don't have access to this chat"},
+		{"", "You don't have access to this chat"},
+	}
+	for _, tt := range tests {
+		w := get(r, "/error", tt.acceptLanguage)
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusForbidden || body.Error != tt.want || body.Code != "chat_forbidden" {
+			t.Errorf("Accept-Language %q: error = %d %+v, want 403 %q with the code kept", tt.acceptLanguage, w.Code, body, tt.want)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	calls := 0
+	w := get(newTestRouter("", &calls), "/localize", "es")
+	var body map[string]any
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["error"] != "El mensaje supera el límite máximo de 100 tokens" || body["code"] != "prompt_too_long" || body["max_input_tokens"] != float64(100) {
+		t.Errorf("Localize() = %v", body)
+	}
+
+	body = gin.H{"error": "Too long"}
+	if got := Localize(nil, body); got["error"] != "Too long" {
+		t.Errorf("Localize() of a body without a code = %v", got)
+	}
+}
+
+func TestLocalesRoute(t *testing.T) {
+	calls := 0
+	w := get(newTestRouter("", &calls), "/api/v1/meta/locales", "")
+	var resp LocalesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /api/v1/meta/locales = %d: %s", w.Code, w.Body)
+	}
+	if len(resp.Locales) != 2 || resp.Locales[0] != "en" || resp.Locales[1] != "es" || resp.Default != DefaultLocale {
+		t.Errorf("locales = %+v", resp)
+	}
+}
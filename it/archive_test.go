@@ -0,0 +1,223 @@
+//go:build integration
+
+package it_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/filesystem"
+
+	"gorm.io/gorm"
+)
+
+type importResponse struct {
+	Mode    string                    `json:"mode"`
+	Results []filesystem.ImportResult `json:"results"`
+	Counts  map[string]int            `json:"counts"`
+}
+
+// importArchive posts archive to the import endpoint with query
+func importArchive(t *testing.T, s *it.Server, user *it.SeededUser, query string, archive []byte) *it.Response {
+	t.Helper()
+
+	return s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/filesystem/import" + query,
+		Token:  user.Token,
+		Body:   archive,
+		Header: http.Header{"Content-Type": {"application/zip"}},
+	})
+}
+
+// importStatuses imports archive and returns the status of each key
+func importStatuses(t *testing.T, s *it.Server, user *it.SeededUser, query string, archive []byte) map[string]string {
+	t.Helper()
+
+	var resp importResponse
+	importArchive(t, s, user, query, archive).Expect(t, http.StatusOK).Decode(t, &resp)
+	statuses := map[string]string{}
+	for _, result := range resp.Results {
+		statuses[result.Key] = result.Status
+	}
+	return statuses
+}
+
+// readArchive returns the files of a zip archive by name
+func readArchive(t *testing.T, archive []byte) map[string][]byte {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("export is not a zip archive: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
+
+// rezip writes files back into an archive
+func rezip(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func putEntry(t *testing.T, s *it.Server, user *it.SeededUser, key string, data any) {
+	t.Helper()
+
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/filesystem" + key, Token: user.Token, Body: data}).
+		Expect(t, http.StatusOK)
+}
+
+// getEntryData reads an entry through the API, so through its cache
+func getEntryData(t *testing.T, s *it.Server, user *it.SeededUser, key string) string {
+	t.Helper()
+
+	var entry struct {
+		Data json.RawMessage `json:"data"`
+	}
+	s.Get(t, "/api/v1/filesystem"+key, user.Token).Expect(t, http.StatusOK).Decode(t, &entry)
+	return string(entry.Data)
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	putEntry(t, s, alice, "/site/index", map[string]any{"title": "Home", "sections": []string{"hero"}})
+	putEntry(t, s, alice, "/site/about", map[string]any{"title": "About"})
+	putEntry(t, s, alice, "/pages/home.html", "<h1>Welcome</h1>\n")
+	err := s.Deps.DB.WithTenant(context.Background(), alice.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantFilesystem{}).Where("tenant_schema = ? AND key = ?", alice.TenantSchema, "/pages/home.html").
+			Update("content_type", "text/html").Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := s.Get(t, "/api/v1/filesystem/export", alice.Token).Expect(t, http.StatusOK)
+	if ct := export.Header.Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("export Content-Type = %q", ct)
+	}
+	files := readArchive(t, export.Body)
+	var manifest filesystem.ArchiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("manifest.json: %v", err)
+	}
+	paths := map[string]string{}
+	for _, entry := range manifest.Entries {
+		paths[entry.Key] = entry.Path
+	}
+	if len(paths) != 3 || paths["/pages/home.html"] != "files/pages/home.html.html" {
+		t.Fatalf("manifest entries = %+v, want the three entries", manifest.Entries)
+	}
+	if got := string(files[paths["/pages/home.html"]]); got != "<h1>Welcome</h1>\n" {
+		t.Errorf("exported HTML = %q, want the page as written", got)
+	}
+
+	// Importing an unchanged export changes nothing
+	for key, status := range importStatuses(t, s, alice, "", export.Body) {
+		if status != filesystem.ImportUnchanged {
+			t.Errorf("import of an unchanged export: %s = %s, want unchanged", key, status)
+		}
+	}
+
+	// Changed, deleted and new entries since the export
+	putEntry(t, s, alice, "/site/index", map[string]any{"title": "Changed"})
+	s.Do(t, it.Request{Method: http.MethodDelete, Path: "/api/v1/filesystem/site/about", Token: alice.Token}).Expect(t, http.StatusOK)
+	putEntry(t, s, alice, "/site/extra", map[string]any{"title": "Extra"})
+	if got := getEntryData(t, s, alice, "/site/index"); got != `{"title":"Changed"}` {
+		t.Fatalf("entry before import = %s", got)
+	}
+
+	statuses := importStatuses(t, s, alice, "", export.Body)
+	if statuses["/site/index"] != filesystem.ImportConflict || statuses["/site/about"] != filesystem.ImportCreated ||
+		statuses["/pages/home.html"] != filesystem.ImportUnchanged {
+		t.Errorf("merge import = %v, want a conflict, a re-created entry and an unchanged one", statuses)
+	}
+	if got := getEntryData(t, s, alice, "/site/index"); got != `{"title":"Changed"}` {
+		t.Errorf("conflicting entry after a skip import = %s, want it kept", got)
+	}
+
+	statuses = importStatuses(t, s, alice, "?on_conflict=overwrite", export.Body)
+	if statuses["/site/index"] != filesystem.ImportUpdated || statuses["/site/about"] != filesystem.ImportUnchanged {
+		t.Errorf("overwrite import = %v, want the conflict updated", statuses)
+	}
+	// The cached entry was invalidated
+	if got := getEntryData(t, s, alice, "/site/index"); got != `{"sections":["hero"],"title":"Home"}` {
+		t.Errorf("entry after an overwrite import = %s, want the exported data", got)
+	}
+	if statuses["/site/extra"] != "" {
+		t.Errorf("merge import touched /site/extra: %s", statuses["/site/extra"])
+	}
+
+	statuses = importStatuses(t, s, alice, "?mode=replace", export.Body)
+	if statuses["/site/extra"] != filesystem.ImportDeleted {
+		t.Errorf("replace import = %v, want /site/extra deleted", statuses)
+	}
+	s.Get(t, "/api/v1/filesystem/site/extra", alice.Token).Expect(t, http.StatusNotFound)
+	var page string
+	json.Unmarshal([]byte(getEntryData(t, s, alice, "/pages/home.html")), &page)
+	if page != "<h1>Welcome</h1>\n" {
+		t.Errorf("HTML entry after imports = %q, want the page as written", page)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	putEntry(t, s, alice, "/site/index", map[string]any{"title": "Home"})
+	export := s.Get(t, "/api/v1/filesystem/export", alice.Token).Expect(t, http.StatusOK)
+	files := readArchive(t, export.Body)
+
+	importArchive(t, s, alice, "?mode=upsert", export.Body).Expect(t, http.StatusBadRequest)
+	importArchive(t, s, alice, "?on_conflict=ignore", export.Body).Expect(t, http.StatusBadRequest)
+	importArchive(t, s, alice, "", []byte("not a zip")).Expect(t, http.StatusBadRequest)
+
+	noManifest := rezip(t, map[string][]byte{"files/site/index.json": files["files/site/index.json"]})
+	var body struct {
+		Code string `json:"code"`
+	}
+	importArchive(t, s, alice, "", noManifest).Expect(t, http.StatusBadRequest).Decode(t, &body)
+	if body.Code != "invalid_manifest" {
+		t.Errorf("import without a manifest code = %q, want invalid_manifest", body.Code)
+	}
+
+	// A file edited after export fails its checksum and isn't written
+	files["files/site/index.json"] = []byte(`{"title": "Tampered"}`)
+	statuses := importStatuses(t, s, alice, "?on_conflict=overwrite", rezip(t, files))
+	if statuses["/site/index"] != filesystem.ImportFailed {
+		t.Errorf("import of a tampered file = %v, want failed", statuses)
+	}
+	if got := getEntryData(t, s, alice, "/site/index"); got != `{"title":"Home"}` {
+		t.Errorf("entry after a failed import = %s", got)
+	}
+}
@@ -0,0 +1,199 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/models"
+)
+
+var hashedResetToken = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+func login(t *testing.T, s *it.Server, email, password string) *it.Response {
+	t.Helper()
+	return s.Post(t, "/api/v1/auth/login", "", map[string]string{"email": email, "password": password})
+}
+
+func confirmReset(t *testing.T, s *it.Server, token, password string) *it.Response {
+	t.Helper()
+	return s.Post(t, "/api/v1/auth/password-reset/confirm", "", map[string]string{"token": token, "newPassword": password})
+}
+
+// setResetToken stores the users table's value of a reset token valid for an
+// hour. Tokens themselves are only ever emailed, so tests pick their own.
+func setResetToken(t *testing.T, s *it.Server, userID uint, stored string) {
+	t.Helper()
+	err := s.Deps.DB.DB.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]any{
+		"password_reset_token":   stored,
+		"password_reset_expires": time.Now().Add(time.Hour),
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to set reset token: %v", err)
+	}
+}
+
+func storedResetToken(t *testing.T, s *it.Server, userID uint) string {
+	t.Helper()
+	var user models.User
+	if err := s.Deps.DB.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		t.Fatalf("failed to load user: %v", err)
+	}
+	if user.PasswordResetToken == nil {
+		return ""
+	}
+	return *user.PasswordResetToken
+}
+
+func sha256Token(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) {
+		cfg.LoginMaxAttempts = 3
+		cfg.LoginIPMaxAttempts = 5
+	})
+	seeded := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := seeded["alice"], seeded["bob"]
+
+	login(t, s, alice.Email, "wrong-password-1").Expect(t, http.StatusUnauthorized)
+	login(t, s, alice.Email, "wrong-password-2").Expect(t, http.StatusUnauthorized)
+	// Emails are counted case-insensitively, so the third failure locks
+	res := login(t, s, strings.ToUpper(alice.Email), "wrong-password-3").Expect(t, http.StatusTooManyRequests)
+	if got, want := res.Header.Get("Retry-After"), "900"; got != want {
+		t.Errorf("Retry-After = %q, want %q", got, want)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	res.Decode(t, &body)
+	if body.Code != users.LoginLockedCode {
+		t.Errorf("code = %q, want %q", body.Code, users.LoginLockedCode)
+	}
+
+	// The right password doesn't get past the lockout
+	login(t, s, alice.Email, alice.Password).Expect(t, http.StatusTooManyRequests)
+
+	var events int64
+	s.Deps.DB.DB.Model(&models.AuditEvent{}).
+		Where("action = ? AND user_id = ?", audit.ActionLoginLocked, alice.ID).Count(&events)
+	if events != 1 {
+		t.Errorf("%d login_locked audit events, want 1", events)
+	}
+
+	// bob is below his email's limit, but the client IP reaches its own
+	// after three failures for alice and two for him
+	login(t, s, bob.Email, "wrong-password-1").Expect(t, http.StatusUnauthorized)
+	login(t, s, bob.Email, "wrong-password-2").Expect(t, http.StatusTooManyRequests)
+	login(t, s, bob.Email, bob.Password).Expect(t, http.StatusTooManyRequests)
+}
+
+func TestLoginClearsFailures(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.LoginMaxAttempts = 3 })
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	login(t, s, alice.Email, "wrong-password-1").Expect(t, http.StatusUnauthorized)
+	login(t, s, alice.Email, "wrong-password-2").Expect(t, http.StatusUnauthorized)
+	login(t, s, alice.Email, alice.Password).Expect(t, http.StatusOK)
+	login(t, s, alice.Email, "wrong-password-3").Expect(t, http.StatusUnauthorized)
+	login(t, s, alice.Email, "wrong-password-4").Expect(t, http.StatusUnauthorized)
+}
+
+func TestPasswordResetTokenHashed(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	newPassword := "Another-Horse-8"
+
+	s.Post(t, "/api/v1/auth/password-reset/request", "", map[string]string{
+		"email": strings.ToUpper(alice.Email),
+	}).Expect(t, http.StatusOK)
+	stored := storedResetToken(t, s, alice.ID)
+	if !hashedResetToken.MatchString(stored) {
+		t.Fatalf("stored reset token %q is not a SHA-256 hash", stored)
+	}
+	// Someone reading the users table can't use what is stored
+	confirmReset(t, s, stored, newPassword).Expect(t, http.StatusBadRequest)
+	confirmReset(t, s, strings.TrimPrefix(stored, "sha256:"), newPassword).Expect(t, http.StatusBadRequest)
+
+	token := "it-reset-token-0123456789abcdef"
+	setResetToken(t, s, alice.ID, sha256Token(token))
+	confirmReset(t, s, token, newPassword).Expect(t, http.StatusOK)
+	if stored := storedResetToken(t, s, alice.ID); stored != "" {
+		t.Errorf("reset token %q kept after use", stored)
+	}
+	confirmReset(t, s, token, "Third-Horse-9").Expect(t, http.StatusBadRequest)
+
+	login(t, s, alice.Email, alice.Password).Expect(t, http.StatusUnauthorized)
+	login(t, s, alice.Email, newPassword).Expect(t, http.StatusOK)
+}
+
+func TestPasswordResetTokenExpired(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	token := "it-reset-token-expired"
+	setResetToken(t, s, alice.ID, sha256Token(token))
+	s.Deps.DB.DB.Model(&models.User{}).Where("id = ?", alice.ID).
+		Update("password_reset_expires", time.Now().Add(-time.Minute))
+	confirmReset(t, s, token, "Another-Horse-8").Expect(t, http.StatusBadRequest)
+}
+
+func TestPlaintextResetTokensHashedOnMigrate(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	// A token stored before tokens were hashed
+	token := "it-legacy-reset-token"
+	setResetToken(t, s, alice.ID, token)
+	if err := s.Deps.DB.MigrateSharedModels(context.Background()); err != nil {
+		t.Fatalf("MigrateSharedModels() error = %v", err)
+	}
+	if got, want := storedResetToken(t, s, alice.ID), sha256Token(token); got != want {
+		t.Fatalf("stored token after migrating = %q, want %q", got, want)
+	}
+
+	// Migrating again leaves hashed tokens alone, and the emailed link
+	// still works
+	if err := s.Deps.DB.MigrateSharedModels(context.Background()); err != nil {
+		t.Fatalf("MigrateSharedModels() error = %v", err)
+	}
+	confirmReset(t, s, token, "Another-Horse-8").Expect(t, http.StatusOK)
+}
+
+func TestOAuthCodeExchange(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	login, _ := json.Marshal(map[string]any{"token": alice.Token, "session_id": "session-1", "user_id": alice.ID})
+	if err := s.Deps.Redis.SetOAuthCode(ctx, "code-1", login, users.OAuthCodeTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		Data users.OAuthExchangeResponse `json:"data"`
+	}
+	s.Post(t, "/api/v1/auth/oauth/exchange", "", map[string]string{"code": "code-1"}).
+		Expect(t, http.StatusOK).Decode(t, &resp)
+	if resp.Data.Token != alice.Token || resp.Data.SessionID != "session-1" || resp.Data.User.Email != alice.Email {
+		t.Errorf("exchange = %+v, want alice's login", resp.Data)
+	}
+
+	// Each code is exchanged once
+	s.Post(t, "/api/v1/auth/oauth/exchange", "", map[string]string{"code": "code-1"}).
+		Expect(t, http.StatusUnauthorized)
+}
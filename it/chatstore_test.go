@@ -0,0 +1,35 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections"
+	"awning-backend/services"
+	"awning-backend/storage"
+	"awning-backend/storage/storetest"
+)
+
+func TestChatStoreContract(t *testing.T) {
+	s := it.NewServer(t)
+
+	// Each test gets a new tenant, so its chats table starts empty
+	tenantContext := func(t *testing.T) context.Context {
+		alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+		return services.WithTenantSchema(context.Background(), alice.TenantSchema)
+	}
+
+	t.Run("Postgres", func(t *testing.T) {
+		storetest.TestChatStore(t, func(t *testing.T) (storage.ChatStore, context.Context) {
+			return sections.NewPostgresChatStore(s.Deps.DB), tenantContext(t)
+		})
+	})
+	t.Run("Cached", func(t *testing.T) {
+		storetest.TestChatStore(t, func(t *testing.T) (storage.ChatStore, context.Context) {
+			return sections.NewCachedChatStore(s.Deps.Redis, sections.NewPostgresChatStore(s.Deps.DB)), tenantContext(t)
+		})
+	})
+}
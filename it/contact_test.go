@@ -0,0 +1,78 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// preflight sends a CORS preflight for a contact submission from origin,
+// returning whether the origin was allowed
+func preflight(t *testing.T, s *it.Server, origin string) bool {
+	t.Helper()
+
+	resp := s.Do(t, it.Request{
+		Method: http.MethodOptions,
+		Path:   "/api/public/v1/contact",
+		Header: http.Header{"Origin": {origin}, "Access-Control-Request-Method": {http.MethodPost}},
+	})
+	return resp.Status == http.StatusNoContent && resp.Header.Get("Access-Control-Allow-Origin") == origin
+}
+
+func TestPublicAPIVerifiedDomainOrigin(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	domain := fmt.Sprintf("bakery-%d.example.com", time.Now().UnixNano())
+	origin := "https://" + domain
+	s.Post(t, "/api/v1/domains", alice.Token, map[string]string{"domain": domain}).Expect(t, http.StatusCreated)
+	err := s.Deps.DB.WithTenant(ctx, alice.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantDomain{}).Where("domain = ?", domain).Update("verified", true).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !preflight(t, s, origin) {
+		t.Fatal("preflight from the verified domain was refused")
+	}
+	if preflight(t, s, "https://unknown-"+domain) {
+		t.Error("preflight from an unknown domain was allowed")
+	}
+
+	resp := s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/api/public/v1/contact",
+		Header: http.Header{"Origin": {origin}},
+		Body:   map[string]string{"name": "Bob", "email": "bob@example.com", "message": "Do you bake rye?"},
+	}).Expect(t, http.StatusCreated)
+	if resp.Header.Get("Access-Control-Allow-Origin") != origin {
+		t.Errorf("submission Access-Control-Allow-Origin = %q", resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	var submissions []models.ContactSubmission
+	err = s.Deps.DB.WithTenant(ctx, alice.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", alice.TenantSchema).Find(&submissions).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(submissions) != 1 || submissions[0].Origin != origin || submissions[0].Message != "Do you bake rye?" {
+		t.Errorf("submissions = %+v, want the message saved for the domain's tenant", submissions)
+	}
+
+	// Removing the domain drops its cached tenant straight away
+	s.Do(t, it.Request{Method: http.MethodDelete, Path: "/api/v1/domains/" + domain, Token: alice.Token}).Expect(t, http.StatusOK)
+	if preflight(t, s, origin) {
+		t.Error("preflight from a removed domain was allowed")
+	}
+}
@@ -0,0 +1,213 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+
+	"gorm.io/gorm"
+)
+
+type creditHistory struct {
+	Transactions []models.CreditTransaction `json:"transactions"`
+	Total        int                        `json:"total"`
+}
+
+// checkLedger fails unless the usage transactions of the tenant's basic
+// credits are count distinct spends of amount ending at balance
+func checkLedger(t *testing.T, s *it.Server, user *it.SeededUser, count, amount, balance int) {
+	t.Helper()
+
+	var history creditHistory
+	s.Get(t, "/api/v1/account/credits/history?type=usage&creditType=basic&per_page=200", user.Token).
+		Expect(t, http.StatusOK).Decode(t, &history)
+	if history.Total != count {
+		t.Fatalf("%d usage transactions, want %d", history.Total, count)
+	}
+
+	// Each spend saw the balance the one before it left
+	seen := map[int]bool{}
+	for _, txn := range history.Transactions {
+		if txn.Delta != -amount {
+			t.Errorf("transaction delta %d, want %d", txn.Delta, -amount)
+		}
+		if seen[txn.BalanceAfter] {
+			t.Errorf("two transactions left a balance of %d", txn.BalanceAfter)
+		}
+		seen[txn.BalanceAfter] = true
+	}
+	for i := range count {
+		if after := balance + i*amount; !seen[after] {
+			t.Errorf("no transaction left a balance of %d", after)
+		}
+	}
+
+	var acct account.AccountResponse
+	s.Get(t, "/api/v1/account", user.Token).Expect(t, http.StatusOK).Decode(t, &acct)
+	if acct.BasicCredits != balance {
+		t.Errorf("basic credits = %d, want %d", acct.BasicCredits, balance)
+	}
+}
+
+func TestUseCreditsConcurrent(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"] // 10 basic credits
+
+	const requests = 25
+	statuses := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.Send(it.Request{
+				Method: http.MethodPost,
+				Path:   "/api/v1/account/credits/use",
+				Token:  alice.Token,
+				Body:   map[string]any{"type": "basic", "amount": 1, "reason": "concurrency test"},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses[i] = resp.Status
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 10 || counts[http.StatusBadRequest] != requests-10 {
+		t.Fatalf("statuses = %v, want ten 200s and %d 400s", counts, requests-10)
+	}
+	checkLedger(t, s, alice, 10, 1, 0)
+}
+
+func TestChangeCreditsConcurrent(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"] // 10 basic credits
+
+	// Spends of 3 from 10 leave 1, however they interleave
+	var spent, refused atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := account.ChangeCredits(context.Background(), s.Deps, alice.TenantSchema, false,
+				func(*models.TenantAccount) ([]account.CreditEntry, error) {
+					return []account.CreditEntry{{CreditType: account.CreditTypeBasic, Type: account.CreditTxnUsage, Delta: -3}}, nil
+				})
+			switch {
+			case err == nil:
+				spent.Add(1)
+			case errors.Is(err, account.ErrInsufficientCredits):
+				refused.Add(1)
+			default:
+				t.Errorf("ChangeCredits() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if spent.Load() != 3 || refused.Load() != 17 {
+		t.Fatalf("%d spends and %d refusals, want 3 and 17", spent.Load(), refused.Load())
+	}
+	checkLedger(t, s, alice, 3, 3, 1)
+}
+
+func TestChatGenerationCharges(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) {
+		cfg.FreeGenerationsPerMonth = 0
+		cfg.ChatGenerationCredits = 1
+	})
+	bob := s.Seed(t, it.LoadSeed(t, "basic"))["bob"] // 1 basic credit
+
+	var chat struct {
+		ChatID string `json:"chat_id"`
+	}
+	s.Post(t, "/api/v1/chat/complete", bob.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "A site for my garage"},
+	}).Expect(t, http.StatusOK).Decode(t, &chat)
+
+	var history creditHistory
+	s.Get(t, "/api/v1/account/credits/history?type=usage", bob.Token).Expect(t, http.StatusOK).Decode(t, &history)
+	if history.Total != 1 {
+		t.Fatalf("%d usage transactions after a generation, want 1", history.Total)
+	}
+	if txn := history.Transactions[0]; txn.Delta != -1 || txn.BalanceAfter != 0 || txn.Reason != "Chat generation" || txn.ChatID != chat.ChatID {
+		t.Errorf("generation transaction = %+v, want a charge of 1 for chat %s", txn, chat.ChatID)
+	}
+
+	// Out of credits, the model isn't asked
+	prompts := len(s.Vertex.Prompts())
+	var body struct {
+		Code string `json:"code"`
+	}
+	s.Post(t, "/api/v1/chat/complete", bob.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "Another site"},
+	}).Expect(t, http.StatusPaymentRequired).Decode(t, &body)
+	if body.Code != "insufficient_credits" {
+		t.Errorf("code = %q, want insufficient_credits", body.Code)
+	}
+	if n := len(s.Vertex.Prompts()); n != prompts {
+		t.Errorf("model asked %d more times without credits", n-prompts)
+	}
+	checkLedger(t, s, bob, 1, 1, 0)
+}
+
+func TestChangeCreditsCreatesAccountOnce(t *testing.T) {
+	s := it.NewServer(t)
+	bob := s.Seed(t, it.LoadSeed(t, "basic"))["bob"]
+	ctx := context.Background()
+
+	err := s.Deps.DB.WithTenant(ctx, bob.TenantSchema, func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("1 = 1").Delete(&models.CreditTransaction{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("tenant_schema = ?", bob.TenantSchema).Delete(&models.TenantAccount{}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent first grants share the one account they create
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := account.ChangeCredits(ctx, s.Deps, bob.TenantSchema, true,
+				func(*models.TenantAccount) ([]account.CreditEntry, error) {
+					return []account.CreditEntry{{CreditType: account.CreditTypeBasic, Type: account.CreditTxnGrant, Delta: 2}}, nil
+				})
+			if err != nil {
+				t.Errorf("ChangeCredits() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var accounts []models.TenantAccount
+	err = s.Deps.DB.WithTenant(ctx, bob.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", bob.TenantSchema).Find(&accounts).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0].BasicCredits != 20 {
+		t.Errorf("accounts = %+v, want one holding all 20 credits", accounts)
+	}
+}
@@ -0,0 +1,91 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/tenant/dashboard"
+)
+
+// getDashboard fetches the user's dashboard, returning it and its X-Cache
+// header
+func getDashboard(t *testing.T, s *it.Server, user *it.SeededUser) (dashboard.DashboardResponse, string) {
+	t.Helper()
+
+	var d dashboard.DashboardResponse
+	resp := s.Get(t, "/api/v1/dashboard", user.Token).Expect(t, http.StatusOK)
+	resp.Decode(t, &d)
+	return d, resp.Header.Get("X-Cache")
+}
+
+func TestDashboardCacheInvalidatedOnCreditUpdate(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"] // 10 basic credits
+
+	d, cache := getDashboard(t, s, alice)
+	if cache != "MISS" || d.Version != dashboard.Version || !d.Credits.OK || d.Credits.Basic != 10 {
+		t.Fatalf("first dashboard = %s %+v, want a fresh one with 10 credits", cache, d.Credits)
+	}
+	if _, cache := getDashboard(t, s, alice); cache != "HIT" {
+		t.Fatalf("second dashboard X-Cache = %s, want HIT", cache)
+	}
+
+	s.Post(t, "/api/v1/account/credits/use", alice.Token, map[string]any{"type": "basic", "amount": 3, "reason": "dashboard test"}).
+		Expect(t, http.StatusOK)
+
+	d, cache = getDashboard(t, s, alice)
+	if cache != "MISS" || d.Credits.Basic != 7 {
+		t.Errorf("dashboard after spending credits = %s with %d credits, want a fresh one with 7", cache, d.Credits.Basic)
+	}
+}
+
+func TestDashboardPartialFailure(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	// Only alice's filesystem table goes missing
+	table := fmt.Sprintf("%q.filesystem", alice.TenantSchema)
+	if err := s.Deps.DB.DB.Exec("ALTER TABLE " + table + " RENAME TO filesystem_gone").Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Deps.DB.DB.Exec(fmt.Sprintf("ALTER TABLE %q.filesystem_gone RENAME TO filesystem", alice.TenantSchema))
+	})
+
+	d, _ := getDashboard(t, s, alice)
+	if d.Storage.OK || d.Storage.Error != "failed to load storage" {
+		t.Errorf("storage section = %+v, want a flagged failure", d.Storage)
+	}
+	for name, section := range map[string]dashboard.Section{
+		"generations":  d.Generations.Section,
+		"credits":      d.Credits.Section,
+		"domains":      d.Domains.Section,
+		"subscription": d.Subscription.Section,
+		"recentChats":  d.RecentChats.Section,
+	} {
+		if !section.OK {
+			t.Errorf("%s section = %+v, want it loaded despite the storage failure", name, section)
+		}
+	}
+	if d.Subscription.Status != "none" || d.Credits.Basic != 10 {
+		t.Errorf("dashboard = %+v, want the other sections filled in", d)
+	}
+
+	// Partial dashboards are not cached
+	if _, cache := getDashboard(t, s, alice); cache != "MISS" {
+		t.Errorf("dashboard after a partial one X-Cache = %s, want MISS", cache)
+	}
+	if cached, _ := s.Deps.Redis.GetDashboard(context.Background(), alice.TenantSchema); cached != nil {
+		t.Errorf("partial dashboard cached: %s", cached)
+	}
+
+	if d, _ := getDashboard(t, s, bob); !d.Storage.OK {
+		t.Errorf("bob's storage section = %+v, want it unaffected", d.Storage)
+	}
+}
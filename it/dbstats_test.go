@@ -0,0 +1,29 @@
+//go:build integration
+
+package it_test
+
+import (
+	"net/http"
+	"testing"
+
+	"awning-backend/db"
+	"awning-backend/it"
+)
+
+func TestDBStats(t *testing.T) {
+	s := it.NewServer(t)
+	s.Seed(t, it.LoadSeed(t, "basic"))
+
+	var resp struct {
+		Pool db.PoolStats `json:"pool"`
+	}
+	s.Admin(t, http.MethodGet, "/api/v1/admin/db/stats", nil).Expect(t, http.StatusOK).Decode(t, &resp)
+	if resp.Pool.MaxOpenConns != db.DefaultMaxOpenConns {
+		t.Errorf("maxOpenConns = %d, want the default pool limit %d", resp.Pool.MaxOpenConns, db.DefaultMaxOpenConns)
+	}
+	if resp.Pool.OpenConns == 0 || resp.Pool.OpenConns != resp.Pool.InUse+resp.Pool.Idle {
+		t.Errorf("pool = %+v, want open connections split into in use and idle", resp.Pool)
+	}
+
+	s.Get(t, "/api/v1/admin/db/stats", "").Expect(t, http.StatusUnauthorized)
+}
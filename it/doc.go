@@ -0,0 +1,14 @@
+// Package it holds the end-to-end integration tests. They run the full-mode
+// API against the Postgres and Redis of docker-compose.yml, with fakes for
+// Vertex, Stripe and the OAuth providers, and only build with the
+// integration tag:
+//
+//	docker compose -f it/docker-compose.yml up -d --wait
+//	go test -tags integration ./it/...
+//
+// The operational subcommands' tests in the root package use the same
+// database harness, with go test -tags integration .
+//
+// IT_DATABASE_URL and IT_REDIS_ADDR point the tests at other servers. Each
+// test package gets a database and a Redis database of its own.
+package it
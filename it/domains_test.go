@@ -0,0 +1,135 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+type domainList struct {
+	Domains []struct {
+		Domain  string `json:"domain"`
+		Primary bool   `json:"primary"`
+	} `json:"domains"`
+}
+
+// primaryDomains lists the user's primary domains through the API
+func primaryDomains(t *testing.T, s *it.Server, user *it.SeededUser) []string {
+	t.Helper()
+
+	var list domainList
+	s.Get(t, "/api/v1/domains", user.Token).Expect(t, http.StatusOK).Decode(t, &list)
+	var primaries []string
+	for _, d := range list.Domains {
+		if d.Primary {
+			primaries = append(primaries, d.Domain)
+		}
+	}
+	return primaries
+}
+
+func TestSetPrimaryDomainRace(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	suffix := time.Now().UnixNano()
+
+	names := make([]string, 3)
+	for i := range names {
+		names[i] = fmt.Sprintf("race-%d-%d.example.com", i, suffix)
+		s.Post(t, "/api/v1/domains", alice.Token, map[string]string{"domain": names[i]}).
+			Expect(t, http.StatusCreated)
+	}
+	if primaries := primaryDomains(t, s, alice); len(primaries) != 1 || primaries[0] != names[0] {
+		t.Fatalf("primary domains = %q, want the first domain added", primaries)
+	}
+
+	// Pairs of calls race to make different domains primary
+	for round := range 10 {
+		var wg sync.WaitGroup
+		for _, name := range names[1:] {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := s.Send(it.Request{Method: http.MethodPost, Path: "/api/v1/domains/" + name + "/primary", Token: alice.Token})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if resp.Status != http.StatusOK {
+					t.Errorf("round %d: SetPrimary(%s) = %d: %s", round, name, resp.Status, resp.Body)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if primaries := primaryDomains(t, s, alice); len(primaries) != 1 {
+			t.Fatalf("round %d: primary domains = %q, want exactly one", round, primaries)
+		}
+	}
+}
+
+func TestAddDomainConcurrentFirstIsPrimary(t *testing.T) {
+	s := it.NewServer(t)
+	bob := s.Seed(t, it.LoadSeed(t, "basic"))["bob"]
+	suffix := time.Now().UnixNano()
+
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.Send(it.Request{
+				Method: http.MethodPost,
+				Path:   "/api/v1/domains",
+				Token:  bob.Token,
+				Body:   map[string]string{"domain": fmt.Sprintf("first-%d-%d.example.com", i, suffix)},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if resp.Status != http.StatusCreated {
+				t.Errorf("AddDomain() = %d: %s", resp.Status, resp.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if primaries := primaryDomains(t, s, bob); len(primaries) != 1 {
+		t.Errorf("primary domains = %q, want exactly one", primaries)
+	}
+}
+
+func TestSinglePrimaryIndex(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	suffix := time.Now().UnixNano()
+
+	// The index holds even for writes that skip the handlers
+	err := s.Deps.DB.WithTenant(context.Background(), alice.TenantSchema, func(tx *gorm.DB) error {
+		for i := range 2 {
+			if err := tx.Create(&models.TenantDomain{
+				TenantSchema: alice.TenantSchema,
+				Domain:       fmt.Sprintf("direct-%d-%d.example.com", i, suffix),
+				DomainType:   "custom",
+				IsPrimary:    true,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		t.Error("created two primary domains for one tenant, want the unique index to refuse")
+	}
+}
@@ -0,0 +1,136 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/model"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/chat"
+	"awning-backend/sections/tenant/filesystem"
+
+	"gorm.io/gorm"
+)
+
+// draftKeys lists the tenant's filesystem keys under the chat's drafts
+func draftKeys(t *testing.T, s *it.Server, tenantSchema, chatID string) []string {
+	t.Helper()
+
+	var keys []string
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantFilesystem{}).
+			Where("tenant_schema = ? AND key LIKE ?", tenantSchema, chat.DraftKeyPrefix+chatID+"/%").
+			Order("key").
+			Pluck("key", &keys).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestChatDraftSetting(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	var completion model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "A site for my flower shop"},
+	}).Expect(t, http.StatusOK).Decode(t, &completion)
+
+	draft := completion.Draft
+	if draft == nil || draft.Key != chat.DraftKeyPrefix+completion.ChatID+"/latest" || draft.Version == "" {
+		t.Fatalf("draft = %+v, want the chat's latest draft", draft)
+	}
+	keys := draftKeys(t, s, alice.TenantSchema, completion.ChatID)
+	if len(keys) != 2 || keys[0] != draft.VersionKey || keys[1] != draft.Key {
+		t.Errorf("draft keys = %q, want the version and latest", keys)
+	}
+	s.Get(t, "/api/v1/filesystem/"+draft.Key, alice.Token).Expect(t, http.StatusOK)
+
+	// Turned off, the page stays in the chat only
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/" + settings.AutoSaveDrafts, Token: alice.Token, Body: map[string]any{"value": false}}).
+		Expect(t, http.StatusOK)
+	var next model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"chat_id": completion.ChatID,
+		"message": map[string]string{"role": "user", "content": "Make it blue"},
+	}).Expect(t, http.StatusOK).Decode(t, &next)
+	if next.Draft != nil {
+		t.Errorf("draft with auto_save_drafts off = %+v", next.Draft)
+	}
+	if got := draftKeys(t, s, alice.TenantSchema, completion.ChatID); len(got) != 2 {
+		t.Errorf("draft keys with auto_save_drafts off = %q, want the first generation's only", got)
+	}
+}
+
+func TestPruneDrafts(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	ctx := context.Background()
+	old := time.Now().Add(-45 * 24 * time.Hour)
+
+	// Old and fresh drafts, and an old entry that isn't a draft
+	entries := map[string]time.Time{
+		chat.DraftKeyPrefix + "c1/latest":               old,
+		chat.DraftKeyPrefix + "c1/20260801T000000.000Z": old,
+		chat.DraftKeyPrefix + "c2/latest":               time.Now(),
+		"site/index.json":                               old,
+	}
+	for _, user := range []*it.SeededUser{alice, bob} {
+		err := s.Deps.DB.WithTenant(ctx, user.TenantSchema, func(tx *gorm.DB) error {
+			for key, updatedAt := range entries {
+				entry := models.TenantFilesystem{TenantSchema: user.TenantSchema, Key: key, Data: `"<p>page</p>"`}
+				if err := tx.Create(&entry).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&entry).UpdateColumn("updated_at", updatedAt).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cacheKey := filesystem.CacheKey(alice.TenantSchema, chat.DraftKeyPrefix+"c1/latest")
+	if err := s.Deps.KV.SetWithTTL(ctx, cacheKey, []byte(`"<p>page</p>"`), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// Off without a maximum age
+	if n, err := chat.NewDraftPruner(s.Deps.DB, s.Deps.KV, 0).PruneAll(ctx); err != nil || n != 0 {
+		t.Fatalf("PruneAll() without a maximum age = %d, %v; want nothing pruned", n, err)
+	}
+
+	if _, err := chat.NewDraftPruner(s.Deps.DB, s.Deps.KV, 30).PruneAll(ctx); err != nil {
+		t.Fatalf("PruneAll() error = %v", err)
+	}
+	created := make([]string, 0, len(entries))
+	for key := range entries {
+		created = append(created, key)
+	}
+	for _, user := range []*it.SeededUser{alice, bob} {
+		var keys []string
+		s.Deps.DB.WithTenant(ctx, user.TenantSchema, func(tx *gorm.DB) error {
+			return tx.Unscoped().Model(&models.TenantFilesystem{}).
+				Where("tenant_schema = ? AND key IN ?", user.TenantSchema, created).
+				Order("key").Pluck("key", &keys).Error
+		})
+		want := []string{chat.DraftKeyPrefix + "c2/latest", "site/index.json"}
+		if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+			t.Errorf("%s keys after pruning = %q, want %q", user.TenantSchema, keys, want)
+		}
+	}
+	if cached, _ := s.Deps.KV.Get(ctx, cacheKey); cached != nil {
+		t.Errorf("pruned draft still cached: %s", cached)
+	}
+}
@@ -0,0 +1,293 @@
+//go:build integration
+
+package it
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/services/ai"
+
+	"github.com/stripe/stripe-go/v84/webhook"
+)
+
+// DefaultPage is what the fake Vertex generates unless told otherwise
+const DefaultPage = "<!DOCTYPE html><html><head><title>Test</title></head><body><h1>Hello</h1><p>Integration test page</p></body></html>"
+
+// Reply is a scripted model reply
+type Reply struct {
+	Content  string
+	Thinking string        // streamed as reasoning before the content
+	Status   int           // an error status instead of a reply, when set
+	Delay    time.Duration // before the reply starts
+	Chunks   int           // content is streamed in this many chunks (default 4)
+}
+
+// FakeVertex serves the OpenAI-compatible completions endpoint of Vertex AI,
+// answering prompts with scripted replies
+type FakeVertex struct {
+	URL string
+
+	mu      sync.Mutex
+	replies []scriptedReply
+	def     Reply
+	prompts []string
+}
+
+type scriptedReply struct {
+	match string
+	reply Reply
+}
+
+// NewFakeVertex serves a fake Vertex AI until the test ends
+func NewFakeVertex(t *testing.T) *FakeVertex {
+	t.Helper()
+
+	f := &FakeVertex{def: Reply{Content: DefaultPage}}
+	ts := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(ts.Close)
+	f.URL = ts.URL
+	return f
+}
+
+// Reply answers prompts containing match with reply; the first match wins
+func (f *FakeVertex) Reply(match string, reply Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.replies = append(f.replies, scriptedReply{match: match, reply: reply})
+}
+
+// Default answers prompts no scripted reply matches with reply
+func (f *FakeVertex) Default(reply Reply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.def = reply
+}
+
+// Prompts returns the prompts received so far
+func (f *FakeVertex) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.prompts...)
+}
+
+func (f *FakeVertex) reply(prompt string) Reply {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prompts = append(f.prompts, prompt)
+	for _, r := range f.replies {
+		if strings.Contains(prompt, r.match) {
+			return r.reply
+		}
+	}
+	return f.def
+}
+
+func (f *FakeVertex) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return
+	}
+
+	var req ai.OpenAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var prompt strings.Builder
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+	}
+
+	reply := f.reply(prompt.String())
+	if reply.Delay > 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if reply.Status != 0 {
+		http.Error(w, `{"error":{"message":"scripted failure"}}`, reply.Status)
+		return
+	}
+
+	if !req.Stream {
+		_ = json.NewEncoder(w).Encode(ai.OpenAIChatResponse{
+			ID: "it-completion",
+			Choices: []ai.OpenAIChoice{{
+				Message:      ai.OpenAIMessage{Role: "assistant", Content: reply.Content},
+				FinishReason: "stop",
+			}},
+			Usage: &ai.OpenAIUsage{PromptTokens: prompt.Len() / 4, CompletionTokens: len(reply.Content) / 4},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	send := func(chunk ai.OpenAIStreamResponse) {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	delta := func(d ai.OpenAIMessageDelta, finish string) ai.OpenAIStreamResponse {
+		return ai.OpenAIStreamResponse{
+			ID:      "it-completion",
+			Choices: []ai.OpenAIStreamChoice{{Delta: d, FinishReason: finish}},
+		}
+	}
+
+	if reply.Thinking != "" {
+		send(delta(ai.OpenAIMessageDelta{Role: "assistant", ReasoningContent: reply.Thinking}, ""))
+	}
+	chunks := reply.Chunks
+	if chunks <= 0 {
+		chunks = 4
+	}
+	size := (len(reply.Content) + chunks - 1) / chunks
+	for start := 0; start < len(reply.Content); start += size {
+		end := min(start+size, len(reply.Content))
+		send(delta(ai.OpenAIMessageDelta{Content: reply.Content[start:end]}, ""))
+	}
+	send(delta(ai.OpenAIMessageDelta{}, "stop"))
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// StripeSigner signs webhook payloads as Stripe does
+type StripeSigner struct {
+	Secret string
+}
+
+// Sign returns the Stripe-Signature header for payload
+func (s *StripeSigner) Sign(payload []byte) string {
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload: payload,
+		Secret:  s.Secret,
+	})
+	return signed.Header
+}
+
+// Event returns a signed webhook event of the type, with object as its
+// data, and its Stripe-Signature header
+func (s *StripeSigner) Event(t *testing.T, eventType string, object map[string]any) ([]byte, string) {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]any{
+		"id":          fmt.Sprintf("evt_it_%d", time.Now().UnixNano()),
+		"object":      "event",
+		"type":        eventType,
+		"api_version": "2025-10-29.clover",
+		"created":     time.Now().Unix(),
+		"data":        map[string]any{"object": object},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	return payload, s.Sign(payload)
+}
+
+// RouteHosts sends requests for the hosts through http.DefaultTransport to
+// target instead, until the test ends. It's for clients with hard-coded
+// URLs, so tests using it mustn't run in parallel.
+func RouteHosts(t *testing.T, target string, hosts ...string) {
+	t.Helper()
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatalf("invalid target %q: %v", target, err)
+	}
+	routed := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		routed[host] = true
+	}
+
+	base := http.DefaultTransport
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if routed[r.URL.Hostname()] {
+			r = r.Clone(r.Context())
+			r.URL.Scheme = u.Scheme
+			r.URL.Host = u.Host
+			r.Host = u.Host
+		}
+		return base.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = base })
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// GoogleUser is who the fake Google signs in
+type GoogleUser struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"verified_email"`
+	GivenName string `json:"given_name"`
+}
+
+// FakeGoogle serves Google's token and userinfo endpoints. Every
+// authorization code is exchanged for a token of the user.
+type FakeGoogle struct {
+	User GoogleUser
+
+	mu        sync.Mutex
+	exchanged []string
+}
+
+// NewFakeGoogle serves a fake Google and routes Google's API hosts to it
+// until the test ends
+func NewFakeGoogle(t *testing.T, user GoogleUser) *FakeGoogle {
+	t.Helper()
+
+	f := &FakeGoogle{User: user}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("code") == "" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.exchanged = append(f.exchanged, r.PostForm.Get("code"))
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "it-google-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("GET /oauth2/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("access_token") != "it-google-access-token" {
+			http.Error(w, `{"error":"invalid_token"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(f.User)
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	RouteHosts(t, ts.URL, "oauth2.googleapis.com", "www.googleapis.com")
+	return f
+}
+
+// Exchanged returns the authorization codes exchanged so far
+func (f *FakeGoogle) Exchanged() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.exchanged...)
+}
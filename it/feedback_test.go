@@ -0,0 +1,93 @@
+//go:build integration
+
+package it_test
+
+import (
+	"net/http"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/chat"
+)
+
+type feedbackReport struct {
+	Feedback []models.MessageFeedback `json:"feedback"`
+	Counts   []chat.FeedbackCount     `json:"counts"`
+	Total    int64                    `json:"total"`
+}
+
+func TestMessageFeedback(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	var completion model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "A site for my flower shop"},
+	}).Expect(t, http.StatusOK).Decode(t, &completion)
+	feedbackPath := "/api/v1/chat/" + completion.ChatID + "/messages/" + completion.Message.ID + "/feedback"
+
+	// The message ID in the response is the stored message's
+	var rated models.MessageFeedback
+	s.Post(t, feedbackPath, alice.Token, map[string]any{
+		"rating":  "down",
+		"reasons": []string{"layout", " ", "colors"},
+		"comment": "  Too busy  ",
+	}).Expect(t, http.StatusOK).Decode(t, &rated)
+	if rated.Rating != "down" || len(rated.Reasons) != 2 || rated.Comment != "Too busy" || rated.TenantSchema != alice.TenantSchema {
+		t.Errorf("feedback = %+v", rated)
+	}
+
+	// Rating again replaces the first rating
+	var rerated models.MessageFeedback
+	s.Post(t, feedbackPath, alice.Token, map[string]any{"rating": "up"}).
+		Expect(t, http.StatusOK).Decode(t, &rerated)
+	if rerated.ID != rated.ID || rerated.Rating != "up" || len(rerated.Reasons) != 0 {
+		t.Errorf("second rating = %+v, want row %d updated", rerated, rated.ID)
+	}
+
+	s.Post(t, feedbackPath, alice.Token, map[string]any{"rating": "meh"}).Expect(t, http.StatusBadRequest)
+	s.Post(t, "/api/v1/chat/"+completion.ChatID+"/messages/missing/feedback", alice.Token, map[string]any{"rating": "up"}).
+		Expect(t, http.StatusNotFound)
+
+	var stored model.Chat
+	s.Get(t, "/api/v1/chat/"+completion.ChatID, alice.Token).Expect(t, http.StatusOK).Decode(t, &stored)
+	for _, msg := range stored.Messages {
+		if msg.Role == model.ChatMessageRoleUser {
+			s.Post(t, "/api/v1/chat/"+completion.ChatID+"/messages/"+msg.ID+"/feedback", alice.Token, map[string]any{"rating": "up"}).
+				Expect(t, http.StatusBadRequest)
+		}
+	}
+
+	// Another tenant can't rate the chat
+	if resp, err := s.Send(it.Request{Method: http.MethodPost, Path: feedbackPath, Token: bob.Token, Body: map[string]any{"rating": "down"}}); err != nil {
+		t.Fatal(err)
+	} else if resp.Status != http.StatusNotFound && resp.Status != http.StatusForbidden {
+		t.Errorf("rating another tenant's chat = %d, want 403 or 404", resp.Status)
+	}
+
+	var report feedbackReport
+	s.Admin(t, http.MethodGet, "/api/v1/admin/feedback?tenant="+alice.TenantSchema, nil).
+		Expect(t, http.StatusOK).Decode(t, &report)
+	if report.Total != 1 || len(report.Feedback) != 1 || report.Feedback[0].Rating != "up" {
+		t.Errorf("report = %+v, want alice's one up rating", report)
+	}
+	if len(report.Counts) != 1 || report.Counts[0].Up != 1 || report.Counts[0].Down != 0 {
+		t.Errorf("counts = %+v, want one up", report.Counts)
+	}
+
+	s.Admin(t, http.MethodGet, "/api/v1/admin/feedback?rating=down&tenant="+alice.TenantSchema, nil).
+		Expect(t, http.StatusOK).Decode(t, &report)
+	if report.Total != 0 {
+		t.Errorf("down ratings = %d, want 0", report.Total)
+	}
+	s.Admin(t, http.MethodGet, "/api/v1/admin/feedback?from=2000-01-01&to=2000-01-31&tenant="+alice.TenantSchema, nil).
+		Expect(t, http.StatusOK).Decode(t, &report)
+	if report.Total != 0 {
+		t.Errorf("ratings in 2000 = %d, want 0", report.Total)
+	}
+	s.Admin(t, http.MethodGet, "/api/v1/admin/feedback?from=yesterday", nil).Expect(t, http.StatusBadRequest)
+	s.Admin(t, http.MethodGet, "/api/v1/admin/feedback?rating=meh", nil).Expect(t, http.StatusBadRequest)
+}
@@ -0,0 +1,220 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/filesystem"
+
+	"gorm.io/gorm"
+)
+
+// seedMembers creates a tenant with an owner, an admin and a member,
+// returning a token for each on the owner's tenant and the tenant's schema
+func seedMembers(t *testing.T, s *it.Server) (map[string]string, string) {
+	t.Helper()
+
+	seeded := s.Seed(t, it.Seed{Users: []it.SeedUser{
+		{Key: "owner", Email: "owner@awning.test", Password: "Correct-Horse-7", FirstName: "Olive", TenantName: "Olive's Shop"},
+		{Key: "admin", Email: "admin@awning.test", Password: "Correct-Horse-7", FirstName: "Adam", TenantName: "Adam's Shop"},
+		{Key: "member", Email: "member@awning.test", Password: "Correct-Horse-7", FirstName: "Mia", TenantName: "Mia's Shop"},
+	}})
+	schema := seeded["owner"].TenantSchema
+
+	tokens := map[string]string{"owner": seeded["owner"].Token}
+	for _, role := range []string{"admin", "member"} {
+		user := seeded[role]
+		if err := s.Deps.DB.DB.Create(&models.UserTenant{UserID: user.ID, TenantSchema: schema, Role: role}).Error; err != nil {
+			t.Fatalf("failed to add %s: %v", role, err)
+		}
+		token, err := s.JWT.GenerateToken(user.ID, user.Email, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = token
+	}
+	return tokens, schema
+}
+
+func TestFilesystemNamespaceAccess(t *testing.T) {
+	s := it.NewServer(t)
+	tokens, schema := seedMembers(t, s)
+
+	// One key per default namespace, written as server code does, which
+	// reaches system/ too
+	keys := map[string]string{
+		"":          "notes.json",
+		"site/":     "site/index.json",
+		"settings/": "settings/billing.json",
+		"system/":   "system/publish-manifest.json",
+	}
+	saved := map[string]*filesystem.FilesystemEntry{}
+	for _, key := range keys {
+		entry, err := filesystem.SaveEntry(context.Background(), s.Deps, schema, key, json.RawMessage(`{"v":1}`))
+		if err != nil {
+			t.Fatalf("SaveEntry(%q) error = %v", key, err)
+		}
+		saved[key] = entry
+	}
+
+	const none, read, write = 0, 1, 2
+	want := map[string]map[string]int{
+		"owner":  {"": write, "site/": write, "settings/": write, "system/": none},
+		"admin":  {"": write, "site/": write, "settings/": write, "system/": none},
+		"member": {"": write, "site/": write, "settings/": none, "system/": none},
+	}
+	for role, byPrefix := range want {
+		t.Run(role, func(t *testing.T) {
+			token := tokens[role]
+
+			var list struct {
+				Entries []filesystem.EntryMeta `json:"entries"`
+			}
+			s.Get(t, "/api/v1/filesystem", token).Expect(t, http.StatusOK).Decode(t, &list)
+			var listed []string
+			for _, entry := range list.Entries {
+				listed = append(listed, entry.Key)
+			}
+
+			for prefix, level := range byPrefix {
+				key := keys[prefix]
+				if slices.Contains(listed, key) != (level != none) {
+					t.Errorf("listing has %s = %v, want %v", key, slices.Contains(listed, key), level != none)
+				}
+
+				readStatus := http.StatusOK
+				if level == none {
+					readStatus = http.StatusForbidden
+				}
+				s.Get(t, "/api/v1/filesystem/"+key, token).Expect(t, readStatus)
+
+				if level != write {
+					s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/filesystem/" + key, Token: token, Body: map[string]int{"v": 2}}).
+						Expect(t, http.StatusForbidden)
+					s.Do(t, it.Request{Method: http.MethodDelete, Path: "/api/v1/filesystem/" + key, Token: token}).
+						Expect(t, http.StatusForbidden)
+					continue
+				}
+				own := key + "." + role
+				s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/filesystem/" + own, Token: token, Body: map[string]int{"v": 2}}).
+					Expect(t, http.StatusOK)
+				s.Do(t, it.Request{Method: http.MethodDelete, Path: "/api/v1/filesystem/" + own, Token: token}).
+					Expect(t, http.StatusOK)
+			}
+		})
+	}
+
+	// The refused writes and deletes left the entries alone
+	for _, key := range keys {
+		var stored models.TenantFilesystem
+		err := s.Deps.DB.WithTenant(context.Background(), schema, func(tx *gorm.DB) error {
+			return tx.Where("key = ?", key).First(&stored).Error
+		})
+		if err != nil {
+			t.Fatalf("entry %s missing: %v", key, err)
+		}
+		if stored.Checksum != saved[key].Checksum {
+			t.Errorf("entry %s was overwritten", key)
+		}
+	}
+}
+
+type searchPage struct {
+	Results []filesystem.SearchResult `json:"results"`
+	Page    int                       `json:"page"`
+	HasMore bool                      `json:"hasMore"`
+}
+
+func searchKeys(page searchPage) []string {
+	keys := make([]string, len(page.Results))
+	for i, r := range page.Results {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+func TestFilesystemSearch(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.FilesystemSearchMaxBytes = 2048 })
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	ctx := context.Background()
+
+	entries := map[string]string{
+		"site/pages/home.json":  `{"title": "Home", "sections": [{"id": "hero", "heading": "Fresh sourdough daily"}]}`,
+		"site/pages/menu.json":  `{"title": "Menu", "items": ["Rye", "Sourdough loaf"]}`,
+		"site/pages/about.json": `{"title": "About", "sections": [{"id": "team"}]}`,
+		"drafts/home.json":      `{"title": "Draft", "note": "sourdough?"}`,
+		// Over the size limit, so never searched
+		"site/pages/big.json": `{"title": "Big", "text": "sourdough ` + strings.Repeat("x", 4096) + `"}`,
+	}
+	for key, data := range entries {
+		if _, err := filesystem.SaveEntry(ctx, s.Deps, alice.TenantSchema, key, json.RawMessage(data)); err != nil {
+			t.Fatalf("SaveEntry(%q) error = %v", key, err)
+		}
+	}
+	// Another tenant's entry is never found
+	if _, err := filesystem.SaveEntry(ctx, s.Deps, bob.TenantSchema, "site/pages/home.json", json.RawMessage(`{"title": "sourdough"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var all searchPage
+	s.Get(t, "/api/v1/filesystem/search?q=SOURDOUGH", alice.Token).Expect(t, http.StatusOK).Decode(t, &all)
+	if got, want := searchKeys(all), []string{"drafts/home.json", "site/pages/home.json", "site/pages/menu.json"}; !slices.Equal(got, want) {
+		t.Fatalf("search keys = %q, want %q", got, want)
+	}
+	paths := map[string]string{}
+	for _, r := range all.Results {
+		if len(r.Matches) != 1 {
+			t.Errorf("%s has %d matches, want 1", r.Key, len(r.Matches))
+			continue
+		}
+		paths[r.Key] = r.Matches[0].Path
+	}
+	wantPaths := map[string]string{
+		"drafts/home.json":     "$.note",
+		"site/pages/home.json": "$.sections[0].heading",
+		"site/pages/menu.json": "$.items[1]",
+	}
+	for key, path := range wantPaths {
+		if paths[key] != path {
+			t.Errorf("match path in %s = %q, want %q", key, paths[key], path)
+		}
+	}
+
+	// Scoped to a prefix and paged
+	var first, second searchPage
+	s.Get(t, "/api/v1/filesystem/search?q=sourdough&prefix=site/&per_page=1", alice.Token).Expect(t, http.StatusOK).Decode(t, &first)
+	s.Get(t, "/api/v1/filesystem/search?q=sourdough&prefix=site/&per_page=1&page=2", alice.Token).Expect(t, http.StatusOK).Decode(t, &second)
+	if got := searchKeys(first); !slices.Equal(got, []string{"site/pages/home.json"}) || !first.HasMore {
+		t.Errorf("page 1 = %q, hasMore %v; want home and more", got, first.HasMore)
+	}
+	if got := searchKeys(second); !slices.Equal(got, []string{"site/pages/menu.json"}) || second.HasMore {
+		t.Errorf("page 2 = %q, hasMore %v; want menu and no more", got, second.HasMore)
+	}
+
+	// JSON queries match by containment
+	var contained searchPage
+	s.Get(t, "/api/v1/filesystem/search?q="+url.QueryEscape(`{"sections":[{"id":"team"}]}`), alice.Token).Expect(t, http.StatusOK).Decode(t, &contained)
+	if got := searchKeys(contained); !slices.Equal(got, []string{"site/pages/about.json"}) {
+		t.Errorf("containment search keys = %q, want about", got)
+	} else if contained.Results[0].Matches[0].Path != "$" {
+		t.Errorf("containment match path = %q, want $", contained.Results[0].Matches[0].Path)
+	}
+
+	// LIKE wildcards are literal
+	var wildcard searchPage
+	s.Get(t, "/api/v1/filesystem/search?q=%25", alice.Token).Expect(t, http.StatusOK).Decode(t, &wildcard)
+	if len(wildcard.Results) != 0 {
+		t.Errorf("search for %% matched %q", searchKeys(wildcard))
+	}
+}
@@ -0,0 +1,121 @@
+//go:build integration
+
+package it
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Seed is a fixture of users to create, each with their own tenant
+type Seed struct {
+	Users []SeedUser `json:"users"`
+}
+
+// SeedUser is a user of a seed fixture. Emails are made unique per test, so
+// fixtures can be seeded more than once in a package.
+type SeedUser struct {
+	Key            string `json:"key"` // how tests refer to the user
+	Email          string `json:"email"`
+	Password       string `json:"password"`
+	FirstName      string `json:"firstName"`
+	TenantName     string `json:"tenantName"`
+	BasicCredits   int    `json:"basicCredits"`
+	PremiumCredits int    `json:"premiumCredits"`
+}
+
+// SeededUser is a user created from a seed fixture
+type SeededUser struct {
+	ID           uint
+	Email        string
+	Password     string
+	TenantSchema string
+	Token        string // a JWT for the user on their tenant
+}
+
+var seedCount atomic.Int64
+
+// LoadSeed reads testdata/seed/<name>.json
+func LoadSeed(t *testing.T, name string) Seed {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "seed", name+".json"))
+	if err != nil {
+		t.Fatalf("failed to read seed %q: %v", name, err)
+	}
+	var seed Seed
+	if err := json.Unmarshal(data, &seed); err != nil {
+		t.Fatalf("invalid seed %q: %v", name, err)
+	}
+	return seed
+}
+
+// Seed creates the seed's users with their tenants and credits, returning
+// them by key
+func (s *Server) Seed(t *testing.T, seed Seed) map[string]*SeededUser {
+	t.Helper()
+
+	ctx := context.Background()
+	service := users.NewUserService(s.Deps)
+	seeded := make(map[string]*SeededUser, len(seed.Users))
+	for _, su := range seed.Users {
+		n := seedCount.Add(1)
+		local, domain, _ := strings.Cut(su.Email, "@")
+		email := fmt.Sprintf("%s+%d@%s", local, n, domain)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(su.Password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, tenant, err := service.CreateUserWithTenant(ctx, users.CreateUserWithTenantParams{
+			User: models.User{
+				Email:         email,
+				PasswordHash:  string(hash),
+				FirstName:     su.FirstName,
+				EmailVerified: true,
+				Active:        true,
+			},
+			TenantName:   fmt.Sprintf("%s %d", su.TenantName, n),
+			TenantSchema: fmt.Sprintf("it_%s_%d", su.Key, n),
+		})
+		if err != nil {
+			t.Fatalf("failed to seed user %q: %v", su.Key, err)
+		}
+
+		_, _, err = account.ChangeCredits(ctx, s.Deps, tenant.SchemaName, true,
+			func(*models.TenantAccount) ([]account.CreditEntry, error) {
+				return []account.CreditEntry{
+					{CreditType: account.CreditTypeBasic, Type: account.CreditTxnGrant, Delta: su.BasicCredits, Reason: "seed"},
+					{CreditType: account.CreditTypePremium, Type: account.CreditTxnGrant, Delta: su.PremiumCredits, Reason: "seed"},
+				}, nil
+			})
+		if err != nil {
+			t.Fatalf("failed to seed credits of %q: %v", su.Key, err)
+		}
+
+		token, err := s.JWT.GenerateToken(user.ID, user.Email, tenant.SchemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seeded[su.Key] = &SeededUser{
+			ID:           user.ID,
+			Email:        email,
+			Password:     su.Password,
+			TenantSchema: tenant.SchemaName,
+			Token:        token,
+		}
+	}
+	return seeded
+}
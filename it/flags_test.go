@@ -0,0 +1,99 @@
+//go:build integration
+
+package it_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/models"
+)
+
+// setFlags overrides flags through the admin API
+func setFlags(t *testing.T, s *it.Server, overrides map[string]any) {
+	t.Helper()
+	s.Admin(t, http.MethodPut, "/api/v1/admin/flags", map[string]any{"flags": overrides}).Expect(t, http.StatusOK)
+}
+
+// expectUnavailable checks a 503 response with Retry-After and its code
+func expectUnavailable(t *testing.T, resp *it.Response, code string) {
+	t.Helper()
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	resp.Expect(t, http.StatusServiceUnavailable).Decode(t, &body)
+	if body.Code != code || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("503 code = %q with Retry-After %q, want %q with Retry-After", body.Code, resp.Header.Get("Retry-After"), code)
+	}
+}
+
+// flagEvents counts the flags.updated audit events
+func flagEvents(t *testing.T, s *it.Server) int64 {
+	t.Helper()
+
+	var count int64
+	if err := s.Deps.DB.DB.Model(&models.AuditEvent{}).Where("action = ?", audit.ActionFlagsUpdated).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestFlagOverridesConfig(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) {
+		cfg.FeatureFlags = map[string]bool{flags.Checkout: false}
+	})
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	expectUnavailable(t, s.Post(t, "/api/v1/payments/plan", alice.Token, map[string]string{"planId": "pro"}), flags.ErrCodeFeatureDisabled)
+
+	var list struct {
+		Flags []flags.Flag `json:"flags"`
+	}
+	s.Admin(t, http.MethodGet, "/api/v1/admin/flags", nil).Expect(t, http.StatusOK).Decode(t, &list)
+	for _, flag := range list.Flags {
+		if flag.Name == flags.Checkout && (flag.Enabled || flag.Default || flag.Overridden) {
+			t.Errorf("checkout flag = %+v, want off by config", flag)
+		}
+	}
+
+	events := flagEvents(t, s)
+
+	// The override wins over config, and removing it restores config's value
+	setFlags(t, s, map[string]any{flags.Checkout: true, flags.ChatGeneration: false})
+	if resp := s.Post(t, "/api/v1/payments/plan", alice.Token, map[string]string{"planId": "nope"}); resp.Status == http.StatusServiceUnavailable {
+		t.Errorf("checkout with its override on = %d: %s", resp.Status, resp.Body)
+	}
+	expectUnavailable(t, s.Post(t, "/api/v1/chat/stream", alice.Token, map[string]string{"message": "A bakery"}), flags.ErrCodeFeatureDisabled)
+
+	setFlags(t, s, map[string]any{flags.Checkout: nil})
+	expectUnavailable(t, s.Post(t, "/api/v1/payments/plan", alice.Token, map[string]string{"planId": "pro"}), flags.ErrCodeFeatureDisabled)
+
+	if n := flagEvents(t, s) - events; n != 2 {
+		t.Errorf("%d flags.updated audit events, want one per change", n)
+	}
+}
+
+func TestMaintenanceModeRejectsWrites(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	domain := fmt.Sprintf("maintenance-%d.example.com", time.Now().UnixNano())
+
+	setFlags(t, s, map[string]any{flags.MaintenanceMode: true})
+
+	expectUnavailable(t, s.Post(t, "/api/v1/chat/stream", alice.Token, map[string]string{"message": "A bakery"}), auth.ErrCodeMaintenanceMode)
+	expectUnavailable(t, s.Post(t, "/api/v1/payments/plan", alice.Token, map[string]string{"planId": "pro"}), auth.ErrCodeMaintenanceMode)
+	expectUnavailable(t, s.Post(t, "/api/v1/domains", alice.Token, map[string]string{"domain": domain}), auth.ErrCodeMaintenanceMode)
+	s.Get(t, "/api/v1/domains", alice.Token).Expect(t, http.StatusOK)
+
+	// Admins can still end it
+	setFlags(t, s, map[string]any{flags.MaintenanceMode: false})
+	s.Post(t, "/api/v1/domains", alice.Token, map[string]string{"domain": domain}).Expect(t, http.StatusCreated)
+}
@@ -0,0 +1,268 @@
+//go:build integration
+
+package it_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+)
+
+func TestMain(m *testing.M) {
+	it.Main(m)
+}
+
+func TestSignupToPublish(t *testing.T) {
+	s := it.NewServer(t)
+	email := fmt.Sprintf("carol+%d@awning.test", time.Now().UnixNano())
+	password := "Correct-Horse-7"
+
+	s.Post(t, "/api/v1/auth/register", "", map[string]string{
+		"email":     email,
+		"password":  password,
+		"firstName": "Carol",
+	}).Expect(t, http.StatusCreated)
+
+	var login struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	s.Post(t, "/api/v1/auth/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	}).Expect(t, http.StatusOK).Decode(t, &login)
+	claims, err := s.JWT.ValidateToken(login.Data.Token)
+	if err != nil || claims.TenantSchema == "" {
+		t.Fatalf("login token has no tenant: %v", err)
+	}
+	token := login.Data.Token
+
+	var completion model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "A site for my flower shop"},
+	}).Expect(t, http.StatusOK).Decode(t, &completion)
+	if completion.Draft == nil || completion.Draft.Key == "" {
+		t.Fatalf("completion saved no draft: %+v", completion)
+	}
+	if !strings.Contains(completion.Message.Content, "Integration test page") {
+		t.Errorf("completion content = %q, want the fake Vertex page", completion.Message.Content)
+	}
+	if !promptContains(s.Vertex.Prompts(), "A site for my flower shop") {
+		t.Errorf("fake Vertex never received the user's message")
+	}
+
+	var published struct {
+		Publication struct {
+			Version int    `json:"version"`
+			Source  string `json:"source"`
+		} `json:"publication"`
+	}
+	s.Post(t, "/api/v1/publish", token, map[string]string{
+		"filesystemKey": completion.Draft.Key,
+	}).Expect(t, http.StatusCreated).Decode(t, &published)
+	if published.Publication.Version != 1 {
+		t.Errorf("published version %d, want 1", published.Publication.Version)
+	}
+
+	var list struct {
+		Publications []struct {
+			Version int  `json:"version"`
+			Current bool `json:"current"`
+		} `json:"publications"`
+	}
+	s.Get(t, "/api/v1/publications", token).Expect(t, http.StatusOK).Decode(t, &list)
+	if len(list.Publications) != 1 || !list.Publications[0].Current {
+		t.Errorf("publications = %+v, want the one current publication", list.Publications)
+	}
+}
+
+func TestStripeCheckoutWebhook(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	paymentIntent := fmt.Sprintf("pi_it_%d", time.Now().UnixNano())
+
+	payload, signature := s.Stripe.Event(t, "checkout.session.completed", map[string]any{
+		"id":             "cs_it_" + paymentIntent,
+		"object":         "checkout.session",
+		"mode":           "payment",
+		"amount_total":   2000,
+		"currency":       "usd",
+		"payment_intent": paymentIntent,
+		"customer":       "cus_it",
+		"metadata": map[string]string{
+			"tenant_schema": alice.TenantSchema,
+			"user_id":       fmt.Sprint(alice.ID),
+		},
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		forged := (&it.StripeSigner{Secret: "whsec_forged"}).Sign(payload)
+		s.Do(t, it.Request{
+			Method: http.MethodPost,
+			Path:   "/webhooks/stripe/webhook",
+			Body:   payload,
+			Header: http.Header{"Stripe-Signature": {forged}},
+		}).Expect(t, http.StatusBadRequest)
+	})
+
+	t.Run("signed", func(t *testing.T) {
+		s.Do(t, it.Request{
+			Method: http.MethodPost,
+			Path:   "/webhooks/stripe/webhook",
+			Body:   payload,
+			Header: http.Header{"Stripe-Signature": {signature}},
+		}).Expect(t, http.StatusOK)
+
+		var payment models.Payment
+		if err := s.Deps.DB.DB.Where("stripe_payment_intent_id = ?", paymentIntent).First(&payment).Error; err != nil {
+			t.Fatalf("payment not recorded: %v", err)
+		}
+		if payment.TenantSchema != alice.TenantSchema || payment.Amount != 2000 || payment.Status != "succeeded" {
+			t.Errorf("payment = %+v, want 2000 succeeded for %s", payment, alice.TenantSchema)
+		}
+	})
+}
+
+func TestGoogleLogin(t *testing.T) {
+	google := it.NewFakeGoogle(t, it.GoogleUser{
+		ID:        fmt.Sprint(time.Now().UnixNano()),
+		Email:     fmt.Sprintf("dave+%d@awning.test", time.Now().UnixNano()),
+		Verified:  true,
+		GivenName: "Dave",
+	})
+	s := it.NewServer(t)
+
+	// A page on another origin can't start a login
+	s.Do(t, it.Request{
+		Method: http.MethodGet,
+		Path:   "/api/v1/auth/google",
+		Header: http.Header{"Accept": {"application/json"}, "Origin": {"https://evil.example"}},
+	}).Expect(t, http.StatusForbidden)
+
+	state, cookie := beginGoogleLogin(t, s)
+	callback := func(state, code string, cookie *http.Cookie) *it.Response {
+		header := http.Header{}
+		if cookie != nil {
+			header.Set("Cookie", cookie.String())
+		}
+		return s.Do(t, it.Request{
+			Method: http.MethodGet,
+			Path:   "/api/v1/auth/google/callback?" + url.Values{"state": {state}, "code": {code}}.Encode(),
+			Header: header,
+		})
+	}
+
+	var login struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	callback(state, "it-code-1", cookie).Expect(t, http.StatusOK).Decode(t, &login)
+	claims, err := s.JWT.ValidateToken(login.Data.Token)
+	if err != nil || claims.Email != google.User.Email || claims.TenantSchema == "" {
+		t.Fatalf("callback token claims = %+v, %v", claims, err)
+	}
+
+	// The state is single use, and so is the code under a new state
+	callback(state, "it-code-1", cookie).Expect(t, http.StatusBadRequest)
+	state, cookie = beginGoogleLogin(t, s)
+	callback(state, "it-code-1", cookie).Expect(t, http.StatusBadRequest)
+
+	if exchanged := google.Exchanged(); len(exchanged) != 1 {
+		t.Errorf("exchanged %d codes with Google, want 1", len(exchanged))
+	}
+}
+
+// beginGoogleLogin starts a login, returning its state and state cookie
+func beginGoogleLogin(t *testing.T, s *it.Server) (string, *http.Cookie) {
+	t.Helper()
+
+	resp := s.Do(t, it.Request{
+		Method: http.MethodGet,
+		Path:   "/api/v1/auth/google",
+		Header: http.Header{"Accept": {"application/json"}},
+	}).Expect(t, http.StatusOK)
+
+	var begin struct {
+		Data struct {
+			RedirectURL string `json:"redirectUrl"`
+		} `json:"data"`
+	}
+	resp.Decode(t, &begin)
+	u, err := url.Parse(begin.Data.RedirectURL)
+	if err != nil {
+		t.Fatalf("invalid redirect URL %q: %v", begin.Data.RedirectURL, err)
+	}
+
+	for _, cookie := range (&http.Response{Header: resp.Header}).Cookies() {
+		if cookie.Name == "oauth_state" {
+			return u.Query().Get("state"), &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return "", nil
+}
+
+func TestDomains(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	suffix := time.Now().UnixNano()
+
+	var added struct {
+		Domain   string `json:"domain"`
+		Verified bool   `json:"verified"`
+		Primary  bool   `json:"primary"`
+	}
+	s.Post(t, "/api/v1/domains", alice.Token, map[string]string{
+		"domain":     fmt.Sprintf("alice-%d.example.com", suffix),
+		"domainType": "custom",
+	}).Expect(t, http.StatusCreated).Decode(t, &added)
+	if added.Verified || !added.Primary {
+		t.Errorf("added domain = %+v, want unverified and primary", added)
+	}
+
+	var registered struct {
+		Domain struct {
+			Verified bool `json:"verified"`
+			Primary  bool `json:"primary"`
+		} `json:"domain"`
+	}
+	s.Post(t, "/api/v1/domains/register", alice.Token, map[string]any{
+		"domain": fmt.Sprintf("alice-%d.test", suffix),
+		"years":  1,
+		"contact": map[string]string{
+			"firstName": "Alice", "lastName": "Baker", "email": alice.Email, "phone": "+1.5555550100",
+			"address1": "1 Main St", "city": "Springfield", "state": "IL", "postalCode": "62701", "country": "US",
+		},
+	}).Expect(t, http.StatusCreated).Decode(t, &registered)
+	if !registered.Domain.Verified || registered.Domain.Primary {
+		t.Errorf("registered domain = %+v, want verified and not primary", registered.Domain)
+	}
+
+	var list struct {
+		Domains []struct {
+			Domain string `json:"domain"`
+		} `json:"domains"`
+	}
+	s.Get(t, "/api/v1/domains", alice.Token).Expect(t, http.StatusOK).Decode(t, &list)
+	if len(list.Domains) != 2 {
+		t.Errorf("listed %d domains, want 2", len(list.Domains))
+	}
+}
+
+func promptContains(prompts []string, s string) bool {
+	for _, prompt := range prompts {
+		if strings.Contains(prompt, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/publish"
+
+	"gorm.io/gorm"
+)
+
+type verifyResponse struct {
+	Results    []publish.VerifyResult `json:"results"`
+	Mismatches int                    `json:"mismatches"`
+}
+
+// verifyTenant runs the admin verification over the tenant's publications,
+// returning each version's status
+func verifyTenant(t *testing.T, s *it.Server, tenantSchema string) (map[int]string, int) {
+	t.Helper()
+
+	var resp verifyResponse
+	s.Admin(t, http.MethodGet, "/api/v1/admin/publications/verify?tenants="+tenantSchema, nil).
+		Expect(t, http.StatusOK).Decode(t, &resp)
+	statuses := map[int]string{}
+	for _, result := range resp.Results {
+		statuses[result.Version] = result.Status
+	}
+	return statuses, resp.Mismatches
+}
+
+// publishChat generates a page in a new chat and publishes it
+func publishChat(t *testing.T, s *it.Server, user *it.SeededUser, request string) int {
+	t.Helper()
+
+	var completion model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", user.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": request},
+	}).Expect(t, http.StatusOK).Decode(t, &completion)
+
+	var resp struct {
+		Publication publish.PublicationResponse `json:"publication"`
+	}
+	s.Post(t, "/api/v1/publish", user.Token, map[string]string{"chatId": completion.ChatID}).
+		Expect(t, http.StatusCreated).Decode(t, &resp)
+	return resp.Publication.Version
+}
+
+func TestPublicationSignature(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	version := publishChat(t, s, alice, "A site for my bakery")
+
+	var sig publish.SignatureResponse
+	s.Get(t, fmt.Sprintf("/api/v1/publications/%d/signature", version), alice.Token).
+		Expect(t, http.StatusOK).Decode(t, &sig)
+	if sig.Version != version || sig.KeyID != s.Deps.JWT.KeyID() || sig.Statement.Tenant != alice.TenantSchema || sig.Statement.Digests["/"] == "" {
+		t.Errorf("signature = %+v", sig)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(sig.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, err := s.Deps.JWT.VerifyDetached(sig.Signature, payload); err != nil || kid != sig.KeyID {
+		t.Errorf("VerifyDetached() of the published signature = %q, %v", kid, err)
+	}
+
+	s.Get(t, "/api/v1/publications/999/signature", alice.Token).Expect(t, http.StatusNotFound)
+}
+
+func TestVerifyPublicationsFlagsMutatedRows(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	first := publishChat(t, s, alice, "A site for my bakery")
+	s.Vertex.Default(it.Reply{Content: "<!DOCTYPE html><html><head><title>Test</title></head><body><h1>Second</h1></body></html>"})
+	second := publishChat(t, s, alice, "A site for my bakery, in blue")
+
+	if statuses, mismatches := verifyTenant(t, s, alice.TenantSchema); statuses[first] != publish.VerifyOK || statuses[second] != publish.VerifyOK || mismatches != 0 {
+		t.Fatalf("statuses before tampering = %v (%d mismatches), want both ok", statuses, mismatches)
+	}
+
+	update := func(version int, fields map[string]any) {
+		t.Helper()
+		err := s.Deps.DB.WithTenant(ctx, alice.TenantSchema, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantPublication{}).Where("tenant_schema = ? AND version = ?", alice.TenantSchema, version).Updates(fields).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := func(version int) string {
+		t.Helper()
+		var publication models.TenantPublication
+		err := s.Deps.DB.WithTenant(ctx, alice.TenantSchema, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ? AND version = ?", alice.TenantSchema, version).First(&publication).Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return publication.Content
+	}
+
+	// Content changed behind the hash's back, and content changed along
+	// with a recomputed hash, which only the signature catches
+	update(first, map[string]any{"content": content(first) + "<script>steal()</script>"})
+	tampered := content(second) + "<script>steal()</script>"
+	sum := sha256.Sum256([]byte(tampered))
+	update(second, map[string]any{"content": tampered, "content_hash": hex.EncodeToString(sum[:])})
+
+	statuses, mismatches := verifyTenant(t, s, alice.TenantSchema)
+	if statuses[first] != publish.VerifyHashMismatch || statuses[second] != publish.VerifySignatureInvalid || mismatches != 2 {
+		t.Errorf("statuses after tampering = %v (%d mismatches), want hash_mismatch and signature_invalid", statuses, mismatches)
+	}
+}
@@ -0,0 +1,168 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+)
+
+// recordingSender keeps the subjects of the emails it was asked to send
+type recordingSender struct {
+	mu       sync.Mutex
+	subjects []string
+}
+
+func (r *recordingSender) SendEmail(_ context.Context, to []string, subject, _ string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subjects = append(r.subjects, subject)
+	return nil
+}
+
+// putSetting sets one of the user's tenant settings through the API
+func putSetting(t *testing.T, s *it.Server, user *it.SeededUser, key string, value any) {
+	t.Helper()
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/" + key, Token: user.Token, Body: map[string]any{"value": value}}).
+		Expect(t, http.StatusOK)
+}
+
+type notificationList struct {
+	Notifications []models.TenantNotification `json:"notifications"`
+	Total         int64                       `json:"total"`
+	Unread        int64                       `json:"unread"`
+}
+
+func listNotifications(t *testing.T, s *it.Server, user *it.SeededUser, query string) notificationList {
+	t.Helper()
+
+	var list notificationList
+	s.Get(t, "/api/v1/notifications"+query, user.Token).Expect(t, http.StatusOK).Decode(t, &list)
+	return list
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	email := &recordingSender{}
+	service := notifications.NewService(s.Deps.DB, s.Deps.Redis, s.Deps.Settings, email)
+	ctx := context.Background()
+
+	putSetting(t, s, alice, settings.NotificationEmails, []string{"owner@awning.test"})
+	putSetting(t, s, alice, settings.NotifyPaymentFailedInApp, false)
+	putSetting(t, s, alice, settings.NotifyDomainExpiringEmail, false)
+
+	for _, n := range []notifications.Notification{
+		{Type: notifications.TypePaymentFailed, Title: "Payment failed"},
+		{Type: notifications.TypeDomainExpiring, Title: "Domain expiring"},
+		{Type: notifications.TypeGenerationCompleted, Title: "Site ready"}, // opt-in
+	} {
+		if err := service.Notify(ctx, alice.TenantSchema, n); err != nil {
+			t.Fatalf("Notify(%s) error = %v", n.Type, err)
+		}
+	}
+
+	list := listNotifications(t, s, alice, "")
+	if len(list.Notifications) != 1 || list.Notifications[0].Type != notifications.TypeDomainExpiring {
+		t.Errorf("feed = %+v, want only the domain notification", list.Notifications)
+	}
+	if len(email.subjects) != 1 || email.subjects[0] != "Payment failed" {
+		t.Errorf("emailed %q, want only the payment notification", email.subjects)
+	}
+
+	// Opting in to generation notifications takes effect at once
+	putSetting(t, s, alice, settings.NotifyGenerationCompletedInApp, true)
+	if err := service.Notify(ctx, alice.TenantSchema, notifications.Notification{Type: notifications.TypeGenerationCompleted, Title: "Site ready"}); err != nil {
+		t.Fatal(err)
+	}
+	if list := listNotifications(t, s, alice, ""); list.Total != 2 || list.Notifications[0].Type != notifications.TypeGenerationCompleted {
+		t.Errorf("feed after opting in = %+v, want the generation notification first", list.Notifications)
+	}
+}
+
+func TestNotificationUnreadCount(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	service := s.Deps.Notifications
+	ctx := context.Background()
+
+	unread := func(user *it.SeededUser) int64 {
+		t.Helper()
+		var body struct {
+			Unread int64 `json:"unread"`
+		}
+		s.Get(t, "/api/v1/notifications/unread-count", user.Token).Expect(t, http.StatusOK).Decode(t, &body)
+		return body.Unread
+	}
+	notify := func(n int) {
+		t.Helper()
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := service.Notify(ctx, alice.TenantSchema, notifications.Notification{Type: notifications.TypePaymentFailed, Title: fmt.Sprintf("Payment %d failed", i)})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// The first read counts the table and caches the count, which inserts
+	// then keep up to date
+	notify(3)
+	if got := unread(alice); got != 3 {
+		t.Fatalf("unread = %d, want 3", got)
+	}
+	notify(5)
+	if got := unread(alice); got != 8 {
+		t.Errorf("unread after concurrent inserts = %d, want 8", got)
+	}
+	if got := unread(bob); got != 0 {
+		t.Errorf("bob's unread = %d, want 0", got)
+	}
+
+	list := listNotifications(t, s, alice, "?unread=true&per_page=2")
+	if list.Total != 8 || list.Unread != 8 || len(list.Notifications) != 2 {
+		t.Fatalf("unread page = %d of %d, unread %d", len(list.Notifications), list.Total, list.Unread)
+	}
+
+	// Marking read twice counts once
+	path := fmt.Sprintf("/api/v1/notifications/%d/read", list.Notifications[0].ID)
+	s.Post(t, path, alice.Token, nil).Expect(t, http.StatusOK)
+	s.Post(t, path, alice.Token, nil).Expect(t, http.StatusOK)
+	if got := unread(alice); got != 7 {
+		t.Errorf("unread after marking one read = %d, want 7", got)
+	}
+	s.Post(t, path, bob.Token, nil).Expect(t, http.StatusNotFound)
+
+	var readAll struct {
+		Marked int64 `json:"marked"`
+		Unread int64 `json:"unread"`
+	}
+	s.Post(t, "/api/v1/notifications/read-all", alice.Token, nil).Expect(t, http.StatusOK).Decode(t, &readAll)
+	if readAll.Marked != 7 || readAll.Unread != 0 {
+		t.Errorf("read-all = %+v, want 7 marked and none unread", readAll)
+	}
+
+	// The cached count matches a recount of the table
+	notify(2)
+	cached := unread(alice)
+	if err := s.Deps.Redis.ClearUnreadNotifications(ctx, alice.TenantSchema); err != nil {
+		t.Fatal(err)
+	}
+	if recounted := unread(alice); cached != 2 || recounted != cached {
+		t.Errorf("cached unread = %d, recounted %d; want both 2", cached, recounted)
+	}
+}
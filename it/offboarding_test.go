@@ -0,0 +1,140 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/offboarding"
+)
+
+// scheduleDeletion deletes the user's tenant through the API, returning the
+// scheduled deletion time
+func scheduleDeletion(t *testing.T, s *it.Server, user *it.SeededUser) time.Time {
+	t.Helper()
+
+	var resp struct {
+		DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+	}
+	s.Do(t, it.Request{
+		Method: http.MethodDelete,
+		Path:   "/api/v1/tenant",
+		Token:  user.Token,
+		Body:   map[string]string{"password": user.Password},
+	}).Expect(t, http.StatusAccepted).Decode(t, &resp)
+	return resp.DeletionScheduledAt
+}
+
+// deletionJob returns the tenant's deletion job
+func deletionJob(t *testing.T, s *it.Server, tenantSchema string) models.ScheduledJob {
+	t.Helper()
+
+	var job models.ScheduledJob
+	if err := s.Deps.DB.DB.Where("kind = ? AND tenant_schema = ?", offboarding.JobKindDeleteTenant, tenantSchema).
+		Order("id DESC").First(&job).Error; err != nil {
+		t.Fatalf("failed to load deletion job: %v", err)
+	}
+	return job
+}
+
+func schemaExists(t *testing.T, s *it.Server, schema string) bool {
+	t.Helper()
+
+	var count int64
+	if err := s.Deps.DB.DB.Raw("SELECT count(*) FROM information_schema.schemata WHERE schema_name = ?", schema).Scan(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestDeletionWorkerWaitsForGracePeriod(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	deletionAt := scheduleDeletion(t, s, alice)
+	if grace := time.Until(deletionAt); grace < 29*24*time.Hour {
+		t.Fatalf("deletion scheduled in %s, want the 30 day grace period", grace)
+	}
+
+	now := deletionAt.Add(-time.Minute)
+	worker := offboarding.NewWorker(s.Deps.DB, s.Deps.Sites, func() time.Time { return now })
+
+	if _, err := worker.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if job := deletionJob(t, s, alice.TenantSchema); job.Status != offboarding.JobStatusPending {
+		t.Fatalf("job status before the grace period ends = %q, want pending", job.Status)
+	}
+	if !schemaExists(t, s, alice.TenantSchema) {
+		t.Fatal("schema dropped before the grace period ended")
+	}
+
+	now = deletionAt.Add(time.Minute)
+	if _, err := worker.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	job := deletionJob(t, s, alice.TenantSchema)
+	if job.Status != offboarding.JobStatusDone || job.Attempts != 1 {
+		t.Errorf("job = %s after %d attempts, want done after 1", job.Status, job.Attempts)
+	}
+	if job.CompletedAt == nil || !job.CompletedAt.Equal(now.UTC()) {
+		t.Errorf("job completed at %v, want the fake clock's %v", job.CompletedAt, now.UTC())
+	}
+	if schemaExists(t, s, alice.TenantSchema) {
+		t.Error("schema still exists after the grace period")
+	}
+	var tenants int64
+	s.Deps.DB.DB.Model(&models.Tenant{}).Where("schema_name = ?", alice.TenantSchema).Count(&tenants)
+	if tenants != 0 {
+		t.Error("tenant record still exists after deletion")
+	}
+
+	// Done jobs don't run again
+	now = now.Add(48 * time.Hour)
+	if _, err := worker.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if job := deletionJob(t, s, alice.TenantSchema); job.Attempts != 1 {
+		t.Errorf("job ran %d times, want once", job.Attempts)
+	}
+}
+
+func TestDeletionWorkerSkipsRestoredTenant(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	ctx := context.Background()
+
+	// Restored through the API, which cancels the job
+	deletionAt := scheduleDeletion(t, s, alice)
+	s.Post(t, "/api/v1/tenant/restore", alice.Token, nil).Expect(t, http.StatusOK)
+
+	// Restored behind the worker's back, leaving the job pending
+	scheduleDeletion(t, s, bob)
+	if err := s.Deps.DB.DB.Model(&models.Tenant{}).Where("schema_name = ?", bob.TenantSchema).
+		Updates(map[string]interface{}{"active": true, "deletion_scheduled_at": nil}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := deletionAt.Add(time.Hour)
+	worker := offboarding.NewWorker(s.Deps.DB, s.Deps.Sites, func() time.Time { return now })
+	if _, err := worker.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+
+	for _, user := range []*it.SeededUser{alice, bob} {
+		if job := deletionJob(t, s, user.TenantSchema); job.Status != offboarding.JobStatusCanceled {
+			t.Errorf("job of restored tenant %s = %q, want canceled", user.TenantSchema, job.Status)
+		}
+		if !schemaExists(t, s, user.TenantSchema) {
+			t.Errorf("schema of restored tenant %s was dropped", user.TenantSchema)
+		}
+	}
+	s.Get(t, "/api/v1/tenant/export", alice.Token).Expect(t, http.StatusOK)
+}
@@ -0,0 +1,174 @@
+//go:build integration
+
+package it_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/models"
+)
+
+const previewPage = "<!DOCTYPE html><html><body><h1>Preview</h1></body></html>"
+
+type share struct {
+	ID    uint   `json:"id"`
+	URL   string `json:"url"`
+	token string
+}
+
+// createShare saves previewPage to the user's filesystem and shares it
+func createShare(t *testing.T, s *it.Server, user *it.SeededUser, password string) share {
+	t.Helper()
+
+	s.Do(t, it.Request{
+		Method: http.MethodPut,
+		Path:   "/api/v1/filesystem/home",
+		Token:  user.Token,
+		Body:   previewPage,
+	}).Expect(t, http.StatusOK)
+
+	var created share
+	s.Post(t, "/api/v1/shares", user.Token, map[string]string{
+		"filesystemKey": "home",
+		"password":      password,
+	}).Expect(t, http.StatusCreated).Decode(t, &created)
+	created.token = created.URL[strings.LastIndex(created.URL, "/")+1:]
+	return created
+}
+
+func getPreview(t *testing.T, s *it.Server, token string, cookies ...*http.Cookie) *it.Response {
+	t.Helper()
+
+	header := http.Header{}
+	for _, cookie := range cookies {
+		header.Add("Cookie", cookie.String())
+	}
+	return s.Do(t, it.Request{Method: http.MethodGet, Path: "/preview/" + token, Header: header})
+}
+
+func unlockPreview(t *testing.T, s *it.Server, token, password string) *it.Response {
+	t.Helper()
+
+	return s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/preview/" + token,
+		Body:   []byte(url.Values{"password": {password}}.Encode()),
+		Header: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+	})
+}
+
+func previewCookie(t *testing.T, resp *it.Response) *http.Cookie {
+	t.Helper()
+
+	for _, cookie := range (&http.Response{Header: resp.Header}).Cookies() {
+		if cookie.Name == "awning_preview" {
+			return &http.Cookie{Name: cookie.Name, Value: cookie.Value}
+		}
+	}
+	t.Fatalf("no preview cookie set: %v", resp.Header)
+	return nil
+}
+
+func TestPreviewSandboxed(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	link := createShare(t, s, alice, "")
+
+	resp := getPreview(t, s, link.token).Expect(t, http.StatusOK)
+	if !strings.Contains(string(resp.Body), "<h1>Preview</h1>") {
+		t.Errorf("preview body = %s", resp.Body)
+	}
+	if csp := resp.Header.Get("Content-Security-Policy"); !strings.HasPrefix(csp, "sandbox") {
+		t.Errorf("Content-Security-Policy = %q, want a sandbox", csp)
+	}
+	if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+}
+
+func TestPreviewPassword(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	link := createShare(t, s, users["alice"], "open sesame")
+
+	form := getPreview(t, s, link.token).Expect(t, http.StatusUnauthorized)
+	if strings.Contains(string(form.Body), "<h1>Preview</h1>") {
+		t.Fatal("locked preview served the page")
+	}
+	if csp := form.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'none'") {
+		t.Errorf("password form Content-Security-Policy = %q", csp)
+	}
+
+	unlockPreview(t, s, link.token, "wrong").Expect(t, http.StatusUnauthorized)
+	cookie := previewCookie(t, unlockPreview(t, s, link.token, "open sesame").Expect(t, http.StatusSeeOther))
+	getPreview(t, s, link.token, cookie).Expect(t, http.StatusOK)
+
+	// The cookie proves the password of its own link only
+	other := createShare(t, s, users["bob"], "open sesame")
+	getPreview(t, s, other.token, cookie).Expect(t, http.StatusUnauthorized)
+}
+
+func TestPreviewUnlockRateLimit(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.PreviewUnlockPerHour = 3 })
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	link := createShare(t, s, users["alice"], "open sesame")
+
+	for range 3 {
+		unlockPreview(t, s, link.token, "wrong").Expect(t, http.StatusUnauthorized)
+	}
+	limited := unlockPreview(t, s, link.token, "open sesame").Expect(t, http.StatusTooManyRequests)
+	if limited.Header.Get("Retry-After") == "" {
+		t.Error("rate limited unlock has no Retry-After")
+	}
+
+	// The client's attempts count across links too
+	other := createShare(t, s, users["bob"], "open sesame")
+	unlockPreview(t, s, other.token, "open sesame").Expect(t, http.StatusTooManyRequests)
+}
+
+func TestPreviewExpiry(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	link := createShare(t, s, alice, "")
+	getPreview(t, s, link.token).Expect(t, http.StatusOK)
+
+	if err := s.Deps.DB.DB.Model(&models.ShareLink{}).Where("id = ?", link.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	getPreview(t, s, link.token).Expect(t, http.StatusGone)
+	unlockPreview(t, s, link.token, "").Expect(t, http.StatusGone)
+
+	// Expired links are listed until deleted
+	var list struct {
+		Shares []struct {
+			ID      uint `json:"id"`
+			Expired bool `json:"expired"`
+		} `json:"shares"`
+	}
+	s.Get(t, "/api/v1/shares", alice.Token).Expect(t, http.StatusOK).Decode(t, &list)
+	if len(list.Shares) != 1 || !list.Shares[0].Expired {
+		t.Errorf("shares = %+v, want the expired link", list.Shares)
+	}
+}
+
+func TestPreviewRevocation(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	link := createShare(t, s, users["alice"], "")
+	path := fmt.Sprintf("/api/v1/shares/%d", link.ID)
+
+	// Another tenant can't revoke the link
+	s.Do(t, it.Request{Method: http.MethodDelete, Path: path, Token: users["bob"].Token}).Expect(t, http.StatusNotFound)
+	getPreview(t, s, link.token).Expect(t, http.StatusOK)
+
+	s.Do(t, it.Request{Method: http.MethodDelete, Path: path, Token: users["alice"].Token}).Expect(t, http.StatusNoContent)
+	getPreview(t, s, link.token).Expect(t, http.StatusNotFound)
+}
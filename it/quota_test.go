@@ -0,0 +1,56 @@
+//go:build integration
+
+package it_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+)
+
+func TestGenerationQuotaConcurrentAtLimitOne(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.FreeGenerationsPerMonth = 1 })
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	// Slow replies keep every request in flight together
+	s.Vertex.Default(it.Reply{Content: it.DefaultPage, Delay: 500 * time.Millisecond})
+
+	const requests = 8
+	statuses := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := s.Send(it.Request{
+				Method: http.MethodPost,
+				Path:   "/api/v1/chat/complete",
+				Token:  alice.Token,
+				Body:   map[string]any{"message": map[string]string{"role": "user", "content": "A site for my bakery"}},
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses[i] = resp.Status
+		}()
+	}
+	wg.Wait()
+
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusPaymentRequired] != requests-1 {
+		t.Fatalf("statuses = %v, want one 200 and %d 402s", counts, requests-1)
+	}
+
+	// The slot is used up, not just in flight
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "Another site"},
+	}).Expect(t, http.StatusPaymentRequired)
+}
@@ -0,0 +1,116 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+)
+
+// runReaper queues a reaper run and waits for its report
+func runReaper(t *testing.T, s *it.Server, dryRun bool) *storage.ReaperReport {
+	t.Helper()
+
+	path := "/api/v1/admin/redis/reaper/run"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+	s.Admin(t, http.MethodPost, path, nil).Expect(t, http.StatusAccepted)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp := s.Admin(t, http.MethodGet, "/api/v1/admin/redis/reaper/report", nil)
+		if resp.Status == http.StatusOK {
+			var report storage.ReaperReport
+			resp.Decode(t, &report)
+			if report.DryRun == dryRun {
+				return &report
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reaper report = %d %s, want a finished run", resp.Status, resp.Body)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRedisKeyReaper(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	ctx := context.Background()
+	redisClient := s.Deps.Redis.Client()
+
+	s.Admin(t, http.MethodGet, "/api/v1/admin/redis/reaper/report", nil).Expect(t, http.StatusNotFound)
+
+	// Bob is deactivated, and user 999999 never existed
+	if err := s.Deps.DB.DB.Model(&models.User{}).Where("id = ?", bob.ID).Update("active", false).Error; err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.JWT.GenerateToken(999999, "gone@awning.test", alice.TenantSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := map[string]bool{
+		"session:alice":                      true,
+		"session:bob":                        false,
+		"session:deleted":                    false,
+		"session:garbage":                    false,
+		"fs:" + alice.TenantSchema + ":home": true,
+		"fs:tenant_offboarded:home":          false,
+		"oauth_state:abandoned":              true,
+		"chat:not-reaped":                    true,
+	}
+	values := map[string]string{
+		"session:alice":   alice.Token,
+		"session:bob":     bob.Token,
+		"session:deleted": deleted,
+		"session:garbage": "not-a-jwt",
+	}
+	for key := range kept {
+		value := values[key]
+		if value == "" {
+			value = "x"
+		}
+		if err := redisClient.Set(ctx, key, value, 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A dry run counts without touching anything
+	report := runReaper(t, s, true)
+	counts := map[string]storage.ReaperNamespaceReport{}
+	for _, ns := range report.Namespaces {
+		counts[ns.Namespace] = ns
+	}
+	if counts["session"].Deleted != 3 || counts["fs"].Deleted != 1 || counts["oauth_state"].Expired != 1 {
+		t.Errorf("dry run counts = %+v", report.Namespaces)
+	}
+	for key := range kept {
+		if n, _ := redisClient.Exists(ctx, key).Result(); n != 1 {
+			t.Errorf("dry run removed %s", key)
+		}
+	}
+
+	runReaper(t, s, false)
+	for key, want := range kept {
+		if n, _ := redisClient.Exists(ctx, key).Result(); (n == 1) != want {
+			t.Errorf("%s exists = %v, want %v", key, n == 1, want)
+		}
+	}
+	for key, want := range map[string]time.Duration{
+		"session:alice":         24 * time.Hour,
+		"oauth_state:abandoned": 10 * time.Minute,
+		"chat:not-reaped":       -1,
+	} {
+		if ttl, _ := redisClient.TTL(ctx, key).Result(); ttl > want || (want > 0 && ttl < want-time.Minute) {
+			t.Errorf("TTL(%s) = %v, want %v", key, ttl, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/processors"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/publish"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+)
+
+// runReprocess starts a batch over one tenant and waits for it to finish
+func runReprocess(t *testing.T, s *it.Server, tenantSchema string, dryRun bool) *storage.ReprocessBatch {
+	t.Helper()
+
+	var batch storage.ReprocessBatch
+	s.Admin(t, http.MethodPost, "/api/v1/admin/reprocess", map[string]any{
+		"tenants":    []string{tenantSchema},
+		"processors": []string{"placeholders"},
+		"dryRun":     dryRun,
+	}).Expect(t, http.StatusAccepted).Decode(t, &batch)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var progress storage.ReprocessBatch
+		s.Admin(t, http.MethodGet, "/api/v1/admin/reprocess/"+batch.ID, nil).Expect(t, http.StatusOK).Decode(t, &progress)
+		if progress.TenantsDone == progress.Tenants {
+			return &progress
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reprocess batch = %+v, still running", progress)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// entryData returns the stored data of a filesystem entry
+func entryData(t *testing.T, s *it.Server, tenantSchema, key string) string {
+	t.Helper()
+
+	var entry models.TenantFilesystem
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND key = ?", tenantSchema, key).First(&entry).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry.Data
+}
+
+func TestReprocessDryRunThenSave(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	const key = "site/index.json"
+	err := s.Deps.DB.WithTenant(ctx, alice.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Create(&models.TenantFilesystem{
+			TenantSchema: alice.TenantSchema,
+			Key:          key,
+			Data:         `{"html": "<div><p>Lorem ipsum</p></div>"}`,
+		}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := entryData(t, s, alice.TenantSchema, key)
+
+	// A dry run reports the diff and leaves the entry alone
+	batch := runReprocess(t, s, alice.TenantSchema, true)
+	if batch.Processed != 1 || batch.Changed != 1 || batch.Failed != 0 {
+		t.Fatalf("dry run counts = %+v, want the entry processed and changed", batch)
+	}
+	if len(batch.Diffs) != 1 {
+		t.Fatalf("dry run diffs = %q, want one", batch.Diffs)
+	}
+	var result publish.ReprocessResult
+	if err := json.Unmarshal(batch.Diffs[0], &result); err != nil {
+		t.Fatal(err)
+	}
+	diff := strings.Join(result.Diff, "\n")
+	if result.Source != "filesystem:"+key || !strings.Contains(diff, processors.PlaceholderWarningAttr) {
+		t.Errorf("dry run diff = %+v, want the placeholder flagged in %s", result, key)
+	}
+	if got := entryData(t, s, alice.TenantSchema, key); got != original {
+		t.Errorf("entry after a dry run = %s, want it unchanged", got)
+	}
+
+	// A real run saves the change
+	batch = runReprocess(t, s, alice.TenantSchema, false)
+	if batch.Processed != 1 || batch.Changed != 1 || len(batch.Diffs) != 0 {
+		t.Fatalf("run counts = %+v, want the entry changed without diffs", batch)
+	}
+	saved := entryData(t, s, alice.TenantSchema, key)
+	if !strings.Contains(saved, processors.PlaceholderWarningAttr) {
+		t.Errorf("entry after a run = %s, want the placeholder flagged", saved)
+	}
+
+	// Running again finds nothing left to change
+	batch = runReprocess(t, s, alice.TenantSchema, false)
+	if batch.Processed != 1 || batch.Changed != 0 || batch.Failed != 0 {
+		t.Errorf("second run counts = %+v, want the entry unchanged", batch)
+	}
+	if got := entryData(t, s, alice.TenantSchema, key); got != saved {
+		t.Errorf("entry after a second run = %s, want %s", got, saved)
+	}
+
+	// A job delivered again doesn't count its tenant twice
+	payload, _ := json.Marshal(map[string]any{
+		"batchId":      batch.ID,
+		"tenantSchema": alice.TenantSchema,
+		"processors":   []string{"placeholders"},
+	})
+	if err := publish.NewReprocessor(s.Deps).HandleJob(ctx, payload); err != nil {
+		t.Fatalf("HandleJob() error = %v", err)
+	}
+	again, err := s.Deps.Redis.GetReprocessBatch(ctx, batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Processed != batch.Processed || again.TenantsDone != batch.TenantsDone {
+		t.Errorf("counts after redelivery = %+v, want %+v", again, batch)
+	}
+}
+
+func TestReprocessUnknownProcessor(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	s.Admin(t, http.MethodPost, "/api/v1/admin/reprocess", map[string]any{
+		"tenants":    []string{alice.TenantSchema},
+		"processors": []string{"nope"},
+	}).Expect(t, http.StatusBadRequest)
+	s.Admin(t, http.MethodGet, "/api/v1/admin/reprocess/missing", nil).Expect(t, http.StatusNotFound)
+}
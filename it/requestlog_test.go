@@ -0,0 +1,132 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/common/requestlog"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+type requestList struct {
+	Requests []models.TenantRequestLog `json:"requests"`
+	Total    int                       `json:"total"`
+}
+
+// insertRequestLogs writes request records created at each of times
+func insertRequestLogs(t *testing.T, s *it.Server, tenantSchema string, status int, times ...time.Time) {
+	t.Helper()
+
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		for _, at := range times {
+			record := models.TenantRequestLog{CreatedAt: at, TenantSchema: tenantSchema, Method: http.MethodGet, Route: "/api/v1/test", Status: status}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countRequestLogs(t *testing.T, s *it.Server, tenantSchema string) int64 {
+	t.Helper()
+
+	var count int64
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantRequestLog{}).Count(&count).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestPruneRequestLogs(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	// Other tests' recent records stay within the retention
+	now := time.Now().UTC()
+	insertRequestLogs(t, s, alice.TenantSchema, http.StatusOK, now.AddDate(0, 0, -8), now.AddDate(0, 0, -7).Add(-time.Minute), now.AddDate(0, 0, -6))
+	insertRequestLogs(t, s, bob.TenantSchema, http.StatusInternalServerError, now.AddDate(0, 0, -30), now.Add(-time.Hour))
+
+	pruner := requestlog.NewPruner(s.Deps.DB, 7, func() time.Time { return now })
+	pruned, err := pruner.PruneAll(context.Background())
+	if err != nil {
+		t.Fatalf("PruneAll() error = %v", err)
+	}
+	if pruned != 3 {
+		t.Errorf("PruneAll() pruned %d records, want the 3 older than 7 days", pruned)
+	}
+	if n := countRequestLogs(t, s, alice.TenantSchema); n != 1 {
+		t.Errorf("alice has %d records left, want 1", n)
+	}
+	if n := countRequestLogs(t, s, bob.TenantSchema); n != 1 {
+		t.Errorf("bob has %d records left, want 1", n)
+	}
+
+	// A day later the next one goes; retention 0 keeps everything
+	now = now.Add(24 * time.Hour)
+	if pruned, _ := pruner.PruneAll(context.Background()); pruned != 1 {
+		t.Errorf("PruneAll() a day later pruned %d records, want 1", pruned)
+	}
+	keep := requestlog.NewPruner(s.Deps.DB, 0, func() time.Time { return now.AddDate(1, 0, 0) })
+	if pruned, _ := keep.PruneAll(context.Background()); pruned != 0 {
+		t.Errorf("PruneAll() without retention pruned %d records", pruned)
+	}
+}
+
+func TestRequestLogEndToEnd(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) {
+		cfg.RequestLogEnabled = true
+		cfg.RequestLogSuccessSamplePercent = 0
+	})
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	s.Do(t, it.Request{
+		Method: http.MethodGet,
+		Path:   "/api/v1/filesystem/nothing/here",
+		Token:  alice.Token,
+		Header: http.Header{requestlog.RequestIDHeader: {"it-missing-entry"}},
+	}).Expect(t, http.StatusNotFound)
+	s.Get(t, "/api/v1/account", alice.Token).Expect(t, http.StatusOK)
+
+	// Records are written in the background
+	path := "/api/v1/admin/tenants/" + alice.TenantSchema + "/requests?status=4xx"
+	deadline := time.Now().Add(5 * time.Second)
+	var list requestList
+	for {
+		s.Admin(t, http.MethodGet, path, nil).Expect(t, http.StatusOK).Decode(t, &list)
+		if list.Total > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if list.Total != 1 {
+		t.Fatalf("logged 4xx requests = %+v, want the missing entry", list.Requests)
+	}
+	if got := list.Requests[0]; got.RequestID != "it-missing-entry" || got.Route != "/api/v1/filesystem/*key" || got.UserID == nil {
+		t.Errorf("record = %+v", got)
+	}
+
+	// Unsampled successes aren't logged
+	s.Admin(t, http.MethodGet, "/api/v1/admin/tenants/"+alice.TenantSchema+"/requests?status=2xx", nil).
+		Expect(t, http.StatusOK).Decode(t, &list)
+	if list.Total != 0 {
+		t.Errorf("logged %d successes at a 0%% sample", list.Total)
+	}
+	s.Admin(t, http.MethodGet, "/api/v1/admin/tenants/"+alice.TenantSchema+"/requests?status=7xx", nil).Expect(t, http.StatusBadRequest)
+	s.Admin(t, http.MethodGet, "/api/v1/admin/tenants/no_such_tenant/requests", nil).Expect(t, http.StatusNotFound)
+}
@@ -0,0 +1,322 @@
+//go:build integration
+
+package it
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/jobs"
+	"awning-backend/processors"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/auth/authtest"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/tenant/domains"
+	"awning-backend/serverbuilder"
+	"awning-backend/services"
+	"awning-backend/services/ai"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Credentials and origins of test servers
+const (
+	FrontendKey         = "it-frontend-key"
+	APIKey              = "it-api-key"
+	APISecret           = "it-api-secret"
+	FrontendURL         = "https://app.awning.test"
+	StripeWebhookSecret = "whsec_it"
+	GoogleClientID      = "it-google-client"
+)
+
+// Plans are the plans of test servers
+var Plans = []common.Plan{
+	{ID: "free", Name: "Free", Currency: "usd", Interval: "month", GenerationsPerMonth: 50, MaxTenants: 1},
+	{ID: "pro", Name: "Pro", PriceCents: 2000, Currency: "usd", Interval: "month", PriceId: "price_it_pro", MaxTenants: 5},
+}
+
+// Server is the full-mode API served by httptest, on the package's database
+// and Redis, with fakes for the services it calls
+type Server struct {
+	URL    string
+	Config *common.Config
+	Deps   *sections.Dependencies
+	JWT    *auth.JWTManager
+	Vertex *FakeVertex
+	Stripe *StripeSigner
+
+	client *http.Client
+}
+
+// Option changes a test server's config before it is built
+type Option func(cfg *common.Config)
+
+// NewServer builds the full-mode router and serves it until the test ends.
+// Each server starts with an empty Redis database, so rate limits and
+// caches don't carry over from earlier tests. Like main.go it sets the auth
+// package's checkers, so tests with servers mustn't run in parallel.
+func NewServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	if err := emptyRedis(context.Background(), env.redis); err != nil {
+		t.Fatalf("failed to empty Redis: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(nil)
+
+	cfg := common.DefaultConfig()
+	cfg.BaseURL = "http://" + ts.Listener.Addr().String()
+	cfg.FrontendURL = FrontendURL
+	cfg.ApiFrontendKey = FrontendKey
+	cfg.ApiKey = APIKey
+	cfg.ApiKeySecret = APISecret
+	cfg.OauthGoogleClientID = GoogleClientID
+	cfg.OauthGoogleClientSecret = "it-google-secret"
+	cfg.MockResponse = false
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	vertex := NewFakeVertex(t)
+	vertexClient := ai.NewOpenAICompatibleClient(cfg, vertex.URL+"/chat/completions", func() (string, error) {
+		return "it-vertex-token", nil
+	})
+
+	featureFlags := flags.New(env.redis, cfg.FeatureFlags, time.Duration(cfg.MaintenanceRetryAfterSeconds)*time.Second)
+	auth.SetMaintenanceChecker(featureFlags)
+
+	jobQueue := jobs.NewQueue(env.redis.Client(), cfg.RedisPrefix)
+	jobPool := jobs.NewPool(jobQueue, cfg.JobsConcurrency, jobs.Hooks{})
+
+	processorsSvc := services.NewProcessors(cfg)
+	placeholderSettings, _ := cfg.PlaceholderProcessorSettings()
+	processorsSvc.RegisterProcessor("placeholders", processors.NewPlaceholderProcessor(placeholderSettings))
+	processorsSvc.RegisterProcessor("contact", processors.NewContactProcessor())
+
+	jwtManager := authtest.NewJWTManager(t)
+	deps := &sections.Dependencies{
+		Config:        cfg,
+		DB:            env.db,
+		Redis:         env.redis,
+		KV:            env.redis,
+		ChatLocks:     env.redis,
+		PromptBuilder: newPromptBuilder(t),
+		VertexClient:  vertexClient,
+		ProcessorsSvc: processorsSvc,
+		Responses:     services.NewLocalResponseStore(t.TempDir()),
+		Plans:         Plans,
+		Jobs:          jobQueue,
+		Flags:         featureFlags,
+		JWT:           jwtManager,
+	}
+	deps.Sites = sites.NewResolver(cfg, env.db, env.redis)
+	deps.Settings = settings.NewStore(env.db, env.redis)
+	auth.SetTenantStatusChecker(deps.Sites)
+	auth.SetTenantTimezoneLookup(deps.Sites)
+
+	chatStore, err := sections.NewChatStore(cfg, env.db, env.redis)
+	if err != nil {
+		t.Fatalf("failed to create chat store: %v", err)
+	}
+	deps.Chats = chatStore
+	deps.Webhooks = webhooks.NewEmitter(env.db, jobQueue)
+	deps.Notifications = notifications.NewService(env.db, env.redis, deps.Settings, nil)
+
+	registrar, _ := domains.NewMockRegistrar(&domains.RegistrarConfig{Provider: "mock", Sandbox: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := serverbuilder.New(ctx, deps, serverbuilder.Options{
+		Mode:       serverbuilder.ModeFull,
+		Env:        "development",
+		JWTManager: jwtManager,
+		Jobs:       jobPool,
+		Stripe:     services.NewStripeService(Plans, "sk_test_it", StripeWebhookSecret, FrontendURL+"/success", FrontendURL+"/cancel"),
+		Registrar:  registrar,
+	})
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to build router: %v", err)
+	}
+	jobPool.Start(ctx)
+
+	ts.Config.Handler = r
+	ts.Start()
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+		shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+		defer stop()
+		jobPool.Shutdown(shutdownCtx)
+	})
+
+	return &Server{
+		URL:    ts.URL,
+		Config: cfg,
+		Deps:   deps,
+		JWT:    jwtManager,
+		Vertex: vertex,
+		Stripe: &StripeSigner{Secret: StripeWebhookSecret},
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			// Redirects are checked by the tests, not followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// newPromptBuilder loads a minimal prompt, so replies depend only on the
+// fake Vertex script
+func newPromptBuilder(t *testing.T) *utils.PromptBuilder {
+	t.Helper()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "it-base.md")
+	request := filepath.Join(dir, "it-request.md")
+	if err := os.WriteFile(base, []byte("Generate a one-page website as HTML.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(request, []byte("{{user_request}}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builder, err := utils.NewPromptBuilder(base, request)
+	if err != nil {
+		t.Fatalf("failed to load prompts: %v", err)
+	}
+	return builder
+}
+
+// Request is an API request made by a test. The frontend key is always sent.
+type Request struct {
+	Method string
+	Path   string
+	Token  string // sent as a bearer token when set
+	Tenant string // sent as X-Tenant-ID when set
+	Body   any    // sent as is when []byte, as JSON otherwise
+	Header http.Header
+}
+
+// Response is a fully read API response
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Decode unmarshals the body into v, failing the test if it isn't JSON
+func (r *Response) Decode(t *testing.T, v any) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		t.Fatalf("response is not JSON (status %d): %v\n%s", r.Status, err, r.Body)
+	}
+}
+
+// Expect fails the test unless the response has the status
+func (r *Response) Expect(t *testing.T, status int) *Response {
+	t.Helper()
+	if r.Status != status {
+		t.Fatalf("status = %d, want %d\n%s", r.Status, status, r.Body)
+	}
+	return r
+}
+
+// Do sends the request and reads the response, failing the test if it
+// can't be sent
+func (s *Server) Do(t *testing.T, req Request) *Response {
+	t.Helper()
+
+	resp, err := s.Send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// Send sends the request and reads the response. Unlike Do it may be called
+// from other goroutines than the test's.
+func (s *Server) Send(req Request) (*Response, error) {
+	var body io.Reader
+	switch b := req.Body.(type) {
+	case nil:
+	case []byte:
+		body = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequest(req.Method, s.URL+req.Path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	httpReq.Header.Set("X-Awning-Frontend-Key", FrontendKey)
+	if body != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.Token)
+	}
+	if req.Tenant != "" {
+		httpReq.Header.Set("X-Tenant-ID", req.Tenant)
+	}
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", req.Method, req.Path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of %s %s: %w", req.Method, req.Path, err)
+	}
+	return &Response{Status: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+// Get sends a GET request with the token
+func (s *Server) Get(t *testing.T, path, token string) *Response {
+	t.Helper()
+	return s.Do(t, Request{Method: http.MethodGet, Path: path, Token: token})
+}
+
+// Post sends a POST request with the token and a JSON body
+func (s *Server) Post(t *testing.T, path, token string, body any) *Response {
+	t.Helper()
+	return s.Do(t, Request{Method: http.MethodPost, Path: path, Token: token, Body: body})
+}
+
+// Admin sends a request authenticated with the server API key. Its body is
+// sent as JSON.
+func (s *Server) Admin(t *testing.T, method, path string, body any) *Response {
+	t.Helper()
+	header := http.Header{}
+	header.Set("Authorization", "ApiKey "+APIKey+":"+APISecret)
+	return s.Do(t, Request{Method: method, Path: path, Body: body, Header: header})
+}
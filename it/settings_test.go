@@ -0,0 +1,82 @@
+//go:build integration
+
+package it_test
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/common/settings"
+)
+
+// settingValue returns the effective value of key from GET /api/v1/settings
+func settingValue(t *testing.T, s *it.Server, user *it.SeededUser, key string) settings.Setting {
+	t.Helper()
+
+	var list struct {
+		Settings []settings.Setting `json:"settings"`
+	}
+	s.Get(t, "/api/v1/settings", user.Token).Expect(t, http.StatusOK).Decode(t, &list)
+	for _, setting := range list.Settings {
+		if setting.Key == key {
+			return setting
+		}
+	}
+	t.Fatalf("settings have no %s", key)
+	return settings.Setting{}
+}
+
+func TestSettingsWriteInvalidatesCache(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	// Reading caches the defaults
+	if got := settingValue(t, s, alice, settings.AutoPublish); got.Value != false || got.Overridden {
+		t.Fatalf("auto_publish = %+v, want the default", got)
+	}
+
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/auto_publish", Token: alice.Token, Body: map[string]any{"value": true}}).
+		Expect(t, http.StatusOK)
+	if got := settingValue(t, s, alice, settings.AutoPublish); got.Value != true || !got.Overridden {
+		t.Errorf("auto_publish after PUT = %+v, want the new value", got)
+	}
+	if !s.Deps.Settings.GetBool(t.Context(), alice.TenantSchema, settings.AutoPublish) {
+		t.Error("GetBool() after PUT = false")
+	}
+
+	// Other tenants keep the default
+	if got := settingValue(t, s, bob, settings.AutoPublish); got.Value != false {
+		t.Errorf("bob's auto_publish = %+v, want the default", got)
+	}
+
+	// Null restores the default
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/auto_publish", Token: alice.Token, Body: map[string]any{"value": nil}}).
+		Expect(t, http.StatusOK)
+	if got := settingValue(t, s, alice, settings.AutoPublish); got.Value != false || got.Overridden {
+		t.Errorf("auto_publish after reset = %+v, want the default", got)
+	}
+}
+
+func TestSettingsValidation(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	var unknown struct {
+		Code      string   `json:"code"`
+		ValidKeys []string `json:"validKeys"`
+	}
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/favourite_colour", Token: alice.Token, Body: map[string]any{"value": "blue"}}).
+		Expect(t, http.StatusUnprocessableEntity).Decode(t, &unknown)
+	if unknown.Code != "unknown_setting" || !slices.Equal(unknown.ValidKeys, settings.Keys()) {
+		t.Errorf("unknown key response = %+v, want the valid keys", unknown)
+	}
+
+	s.Do(t, it.Request{Method: http.MethodPut, Path: "/api/v1/settings/brand_colors", Token: alice.Token, Body: map[string]any{"value": []string{"red"}}}).
+		Expect(t, http.StatusBadRequest)
+	if got := settingValue(t, s, alice, settings.BrandColors); got.Overridden {
+		t.Errorf("brand_colors = %+v after an invalid PUT, want the default", got)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/chat"
+
+	"gorm.io/gorm"
+)
+
+const sitePlanReply = `{"site_name": "Crumb", "pages": [
+	{"slug": "home", "title": "Welcome", "nav_label": "Home", "purpose": "Introduce the bakery"},
+	{"slug": "about", "title": "About us", "nav_label": "About", "purpose": "Tell our story"},
+	{"slug": "contact", "title": "Contact", "nav_label": "Contact", "purpose": "Find us"}
+]}`
+
+// siteEntry decodes the tenant filesystem entry saved under key into v,
+// returning false when there isn't one
+func siteEntry(t *testing.T, s *it.Server, tenantSchema, key string, v any) bool {
+	t.Helper()
+
+	var entries []models.TenantFilesystem
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND key = ?", tenantSchema, key).Find(&entries).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		return false
+	}
+	if err := json.Unmarshal([]byte(entries[0].Data), v); err != nil {
+		t.Fatalf("failed to decode entry %s: %v", key, err)
+	}
+	return true
+}
+
+// sitePage returns the page saved under key, or false when there isn't one
+func sitePage(t *testing.T, s *it.Server, tenantSchema, key string) (string, bool) {
+	t.Helper()
+
+	var page string
+	found := siteEntry(t, s, tenantSchema, key, &page)
+	return page, found
+}
+
+func TestMultiPageSite(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	s.Vertex.Reply("Plan a small multi-page website", it.Reply{Content: sitePlanReply})
+	s.Vertex.Reply(`page (home)`, it.Reply{Content: `<html><body><nav><a href="/">Home</a><a href="about.html">About</a><a href="#contact">Contact</a></nav><h1>Crumb</h1></body></html>`})
+	s.Vertex.Reply(`page (about)`, it.Reply{Content: `<html><body><nav><a href="index.html">Home</a></nav><h1>Our story</h1></body></html>`})
+	s.Vertex.Reply(`page (contact)`, it.Reply{Status: http.StatusInternalServerError})
+
+	var completion model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"message": map[string]string{"role": "user", "content": "A site for my bakery"},
+		"pages":   []string{"about", "contact"},
+	}).Expect(t, http.StatusOK).Decode(t, &completion)
+
+	manifest := completion.Site
+	if manifest == nil || manifest.SiteName != "Crumb" || manifest.Key != chat.SiteManifestKey {
+		t.Fatalf("site manifest = %+v, want the plan's site saved as %s", manifest, chat.SiteManifestKey)
+	}
+	if len(manifest.Nav) != 3 || manifest.Nav[1].Href != "/about" || manifest.Nav[2].Href != "/contact" {
+		t.Errorf("nav = %+v, want the three pages in order", manifest.Nav)
+	}
+	if len(manifest.Pages) != 3 {
+		t.Fatalf("manifest pages = %+v, want all three", manifest.Pages)
+	}
+
+	// The failed page is reported, the others are saved with their hashes
+	for _, page := range manifest.Pages {
+		saved, found := sitePage(t, s, alice.TenantSchema, chat.SitePageKeyPrefix+page.Slug)
+		if page.Slug == "contact" {
+			if page.Status != model.SitePageFailed || page.Error == "" || page.Key != "" || found {
+				t.Errorf("contact page = %+v (saved %v), want a failed page that isn't saved", page, found)
+			}
+			continue
+		}
+		if page.Status != model.SitePageDone || page.Key != chat.SitePageKeyPrefix+page.Slug || !found {
+			t.Errorf("%s page = %+v (saved %v), want a saved page", page.Slug, page, found)
+			continue
+		}
+		sum := sha256.Sum256([]byte(saved))
+		if page.Hash != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s page hash = %s, want that of the saved page", page.Slug, page.Hash)
+		}
+		if page.Usage == nil || page.Usage.PromptTokens == 0 {
+			t.Errorf("%s page usage = %+v", page.Slug, page.Usage)
+		}
+	}
+
+	// Links between pages point at the pages' paths, the home page is the
+	// chat's reply
+	home, _ := sitePage(t, s, alice.TenantSchema, chat.SitePageKeyPrefix+"home")
+	if !strings.Contains(home, `href="/about"`) || strings.Contains(home, "about.html") {
+		t.Errorf("home page links weren't rewritten:\n%s", home)
+	}
+	if about, _ := sitePage(t, s, alice.TenantSchema, chat.SitePageKeyPrefix+"about"); strings.Contains(about, "index.html") {
+		t.Errorf("about page links weren't rewritten:\n%s", about)
+	}
+	if completion.Message.Content != home {
+		t.Errorf("completion content = %s, want the home page", completion.Message.Content)
+	}
+
+	// The saved manifest matches the one returned
+	var saved model.SiteManifest
+	if !siteEntry(t, s, alice.TenantSchema, chat.SiteManifestKey, &saved) {
+		t.Fatal("site manifest wasn't saved")
+	}
+	if len(saved.Pages) != len(manifest.Pages) {
+		t.Fatalf("saved manifest pages = %+v, want %+v", saved.Pages, manifest.Pages)
+	}
+	for i, page := range saved.Pages {
+		if want := manifest.Pages[i]; page.Slug != want.Slug || page.Status != want.Status || page.Hash != want.Hash {
+			t.Errorf("saved manifest page %d = %+v, want %+v", i, page, want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+)
+
+// setSpendingCap sets the tenant's max_monthly_tokens, as a platform admin
+// would
+func setSpendingCap(t *testing.T, s *it.Server, user *it.SeededUser, tokens int) {
+	t.Helper()
+	if _, err := s.Deps.Settings.Set(context.Background(), user.TenantSchema, settings.MaxMonthlyTokens, json.RawMessage(strconv.Itoa(tokens)), user.ID); err != nil {
+		t.Fatalf("failed to set the spending cap: %v", err)
+	}
+}
+
+// dropSpendingCounters deletes the tenant's Redis spending counters, as if
+// Redis had lost them, keeping the alert marks
+func dropSpendingCounters(t *testing.T, s *it.Server, tenantSchema string) {
+	t.Helper()
+
+	ctx := context.Background()
+	keys, err := s.Deps.Redis.Client().Keys(ctx, "*spending:"+tenantSchema+":*").Result()
+	if err != nil || len(keys) == 0 {
+		t.Fatalf("spending counters = %q, %v; want some to drop", keys, err)
+	}
+	if err := s.Deps.Redis.Client().Del(ctx, keys...).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// spendingAlerts returns the titles of the tenant's spending cap
+// notifications, oldest first
+func spendingAlerts(t *testing.T, s *it.Server, user *it.SeededUser) []string {
+	t.Helper()
+
+	var titles []string
+	for _, n := range listNotifications(t, s, user, "").Notifications {
+		if n.Type == notifications.TypeSpendingCap {
+			titles = append([]string{n.Title}, titles...)
+		}
+	}
+	return titles
+}
+
+func TestSpendingAlertsFireOnce(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	ctx := context.Background()
+
+	email := &recordingSender{}
+	deps := *s.Deps
+	deps.Notifications = notifications.NewService(s.Deps.DB, s.Deps.Redis, s.Deps.Settings, email)
+	spending := account.NewSpendingService(&deps)
+	setSpendingCap(t, s, alice, 1000)
+
+	// generate saves a usage record and counts it, as finished generations do
+	generate := func(tokens int64) {
+		t.Helper()
+		seedUsage(t, s, alice.TenantSchema, []models.UsageRecord{{Model: "gemini-flash", PromptTokens: tokens}})
+		spending.Record(ctx, alice.TenantSchema, "gemini-flash", tokens, 0)
+	}
+
+	// The first generation finds no counters and rebuilds them with itself
+	generate(500)
+	if status, err := spending.GetStatus(ctx, alice.TenantSchema); err != nil || status.Tokens != 500 {
+		t.Fatalf("GetStatus() = %+v, %v; want 500 tokens", status, err)
+	}
+	generate(300)
+	generate(50)
+	if alerts := spendingAlerts(t, s, alice); len(alerts) != 1 || !strings.Contains(alerts[0], "80%") {
+		t.Fatalf("alerts at 85%% = %q, want the 80%% alert once", alerts)
+	}
+
+	// Generations finishing together past the cap alert once between them
+	seedUsage(t, s, alice.TenantSchema, []models.UsageRecord{
+		{Model: "gemini-flash", PromptTokens: 100},
+		{Model: "gemini-flash", PromptTokens: 100},
+		{Model: "gemini-flash", PromptTokens: 100},
+	})
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spending.Record(ctx, alice.TenantSchema, "gemini-flash", 100, 0)
+		}()
+	}
+	wg.Wait()
+	alerts := spendingAlerts(t, s, alice)
+	if len(alerts) != 2 || !strings.Contains(alerts[1], "100%") {
+		t.Fatalf("alerts past the cap = %q, want the 80%% and 100%% alerts once each", alerts)
+	}
+	if len(email.subjects) != 2 {
+		t.Errorf("emailed %q, want each alert once", email.subjects)
+	}
+	if _, err := spending.Check(ctx, alice.TenantSchema); !errors.Is(err, account.ErrSpendingCapReached) {
+		t.Errorf("Check() past the cap error = %v, want ErrSpendingCapReached", err)
+	}
+
+	// Losing the counters rebuilds them from usage records, without sending
+	// the month's alerts again
+	dropSpendingCounters(t, s, alice.TenantSchema)
+	generate(10)
+	status, err := spending.GetStatus(ctx, alice.TenantSchema)
+	if err != nil || status.Tokens != 1160 {
+		t.Errorf("GetStatus() after losing the counters = %+v, %v; want 1160 tokens", status, err)
+	}
+	if alerts := spendingAlerts(t, s, alice); len(alerts) != 2 || len(email.subjects) != 2 {
+		t.Errorf("alerts after rebuilding = %q, emailed %q; want no more", alerts, email.subjects)
+	}
+
+	// Raising the cap lets generations start again
+	setSpendingCap(t, s, alice, 5000)
+	if status, err := spending.Check(ctx, alice.TenantSchema); err != nil || status.Reached() {
+		t.Errorf("Check() under a raised cap = %+v, %v", status, err)
+	}
+}
+
+func TestSpendingCapStopsGeneration(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	setSpendingCap(t, s, alice, 100)
+	seedUsage(t, s, alice.TenantSchema, []models.UsageRecord{{Model: "gemini-flash", PromptTokens: 60, CompletionTokens: 90}})
+	body := map[string]any{"message": map[string]string{"role": "user", "content": "A site for my bakery"}}
+
+	// With no counters in Redis the check rebuilds them from usage records
+	var capped struct {
+		Code      string `json:"code"`
+		Tokens    int64  `json:"tokens"`
+		MaxTokens int64  `json:"maxTokens"`
+	}
+	s.Post(t, "/api/v1/chat/complete", alice.Token, body).Expect(t, http.StatusPaymentRequired).Decode(t, &capped)
+	if capped.Code != "spending_cap_reached" || capped.Tokens != 150 || capped.MaxTokens != 100 {
+		t.Errorf("capped response = %+v, want 150 of 100 tokens used", capped)
+	}
+	if prompts := s.Vertex.Prompts(); len(prompts) != 0 {
+		t.Errorf("model called %d times past the cap, want none", len(prompts))
+	}
+
+	// Tenants without caps aren't stopped
+	s.Vertex.Default(it.Reply{Content: it.DefaultPage})
+	s.Post(t, "/api/v1/chat/complete", bob.Token, body).Expect(t, http.StatusOK)
+}
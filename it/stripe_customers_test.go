@@ -0,0 +1,179 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// fakeStripeCustomers serves Stripe's customer search and create and payment
+// intent endpoints. existing are the customers search finds.
+type fakeStripeCustomers struct {
+	mu       sync.Mutex
+	existing []string
+	searches int
+	created  []string
+	charged  []string // customer of each payment intent
+}
+
+func newFakeStripeCustomers(t *testing.T, existing ...string) *fakeStripeCustomers {
+	t.Helper()
+
+	f := &fakeStripeCustomers{existing: existing}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/customers/search", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.searches++
+		data := make([]map[string]any, len(f.existing))
+		for i, id := range f.existing {
+			data[i] = map[string]any{"id": id, "object": "customer", "created": 1700000000 + i}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"object": "search_result", "data": data, "has_more": false})
+	})
+	mux.HandleFunc("POST /v1/customers", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		id := fmt.Sprintf("cus_it_new_%d", len(f.created)+1)
+		f.created = append(f.created, id)
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "object": "customer", "email": r.FormValue("email")})
+	})
+	mux.HandleFunc("POST /v1/payment_intents", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.charged = append(f.charged, r.FormValue("customer"))
+		id := fmt.Sprintf("pi_it_%d", len(f.charged))
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "object": "payment_intent", "client_secret": id + "_secret"})
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	it.RouteHosts(t, ts.URL, "api.stripe.com")
+	return f
+}
+
+// payForPlan creates a payment intent for the pro plan
+func payForPlan(t *testing.T, s *it.Server, user *it.SeededUser) {
+	t.Helper()
+
+	s.Post(t, "/api/v1/payments/plan", user.Token, map[string]string{"planId": "pro"}).Expect(t, http.StatusOK)
+}
+
+// storedCustomers returns the Stripe customer IDs stored on the user and on
+// their tenant's account, "" when unset
+func storedCustomers(t *testing.T, s *it.Server, user *it.SeededUser) (string, string) {
+	t.Helper()
+
+	var u models.User
+	if err := s.Deps.DB.DB.First(&u, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	var acct models.TenantAccount
+	err := s.Deps.DB.WithTenant(context.Background(), user.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", user.TenantSchema).First(&acct).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deref := func(id *string) string {
+		if id == nil {
+			return ""
+		}
+		return *id
+	}
+	return deref(u.StripeCustomerID), deref(acct.StripeCustomerID)
+}
+
+func TestStripeCustomerStoredOnFirstPayment(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	stripe := newFakeStripeCustomers(t)
+
+	payForPlan(t, s, alice)
+	if stripe.searches != 1 || len(stripe.created) != 1 {
+		t.Fatalf("first payment: %d searches, %d customers created; want the fallback to search then create", stripe.searches, len(stripe.created))
+	}
+	created := stripe.created[0]
+	if user, account := storedCustomers(t, s, alice); user != created || account != created {
+		t.Errorf("stored customers = %q on the user, %q on the account; want %q on both", user, account, created)
+	}
+
+	// Later payments read the stored customer, even after an email change
+	if err := s.Deps.DB.DB.Model(&models.User{}).Where("id = ?", alice.ID).Update("email", "alice.new@awning.test").Error; err != nil {
+		t.Fatal(err)
+	}
+	payForPlan(t, s, alice)
+	payForPlan(t, s, alice)
+	if stripe.searches != 1 || len(stripe.created) != 1 {
+		t.Errorf("later payments: %d searches, %d customers created; want none beyond the first", stripe.searches, len(stripe.created))
+	}
+	for i, customer := range stripe.charged {
+		if customer != created {
+			t.Errorf("payment %d charged %q, want %q", i, customer, created)
+		}
+	}
+}
+
+func TestStripeCustomerFoundByEmail(t *testing.T) {
+	s := it.NewServer(t)
+	bob := s.Seed(t, it.LoadSeed(t, "basic"))["bob"]
+	stripe := newFakeStripeCustomers(t, "cus_it_oldest", "cus_it_newer")
+
+	payForPlan(t, s, bob)
+	if len(stripe.created) != 0 {
+		t.Errorf("created %v for an email with customers", stripe.created)
+	}
+	if user, account := storedCustomers(t, s, bob); user != "cus_it_oldest" || account != "cus_it_oldest" {
+		t.Errorf("stored customers = %q, %q; want the oldest match", user, account)
+	}
+	if len(stripe.charged) != 1 || stripe.charged[0] != "cus_it_oldest" {
+		t.Errorf("charged %v, want the found customer", stripe.charged)
+	}
+}
+
+func TestCustomerDeletedWebhookClearsIDs(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+	stripe := newFakeStripeCustomers(t)
+
+	payForPlan(t, s, alice)
+	payForPlan(t, s, bob)
+	aliceCustomer, _ := storedCustomers(t, s, alice)
+
+	payload, signature := s.Stripe.Event(t, "customer.deleted", map[string]any{"id": aliceCustomer, "object": "customer", "deleted": true})
+	s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/webhooks/stripe/webhook",
+		Body:   payload,
+		Header: http.Header{"Stripe-Signature": {signature}},
+	}).Expect(t, http.StatusOK)
+
+	if user, account := storedCustomers(t, s, alice); user != "" || account != "" {
+		t.Errorf("alice's customers after deletion = %q, %q; want both cleared", user, account)
+	}
+	if user, account := storedCustomers(t, s, bob); !strings.HasPrefix(user, "cus_it_new_") || account != user {
+		t.Errorf("bob's customers = %q, %q; want them kept", user, account)
+	}
+
+	// The next payment creates a new customer
+	payForPlan(t, s, alice)
+	if user, _ := storedCustomers(t, s, alice); user == "" || user == aliceCustomer {
+		t.Errorf("customer after the next payment = %q, want a new one", user)
+	}
+	if len(stripe.created) != 3 {
+		t.Errorf("created %v, want a third customer after the deletion", stripe.created)
+	}
+}
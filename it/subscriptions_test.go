@@ -0,0 +1,150 @@
+//go:build integration
+
+package it_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+)
+
+// stripeFixture reads testdata/stripe/<name>.json, filling in the
+// subscription, tenant and user
+func stripeFixture(t *testing.T, name, subscriptionID string, user *it.SeededUser) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "stripe", name+".json"))
+	if err != nil {
+		t.Fatalf("failed to read Stripe fixture %q: %v", name, err)
+	}
+	return []byte(strings.NewReplacer(
+		"$SUBSCRIPTION_ID", subscriptionID,
+		"$TENANT_SCHEMA", user.TenantSchema,
+		"$USER_ID", fmt.Sprint(user.ID),
+	).Replace(string(data)))
+}
+
+// sendStripeEvent delivers a signed webhook event from a fixture
+func sendStripeEvent(t *testing.T, s *it.Server, name, subscriptionID string, user *it.SeededUser) {
+	t.Helper()
+
+	payload := stripeFixture(t, name, subscriptionID, user)
+	s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/webhooks/stripe/webhook",
+		Body:   payload,
+		Header: http.Header{"Stripe-Signature": {s.Stripe.Sign(payload)}},
+	}).Expect(t, http.StatusOK)
+}
+
+// fakeStripeSubscriptions serves GET /v1/subscriptions/:id from the
+// subscription fixture for the subscriptions given, and 404 for others.
+// It returns the number of subscriptions fetched.
+func fakeStripeSubscriptions(t *testing.T, user *it.SeededUser, subscriptionIDs ...string) *atomic.Int32 {
+	t.Helper()
+
+	fetched := &atomic.Int32{}
+	known := map[string][]byte{}
+	for _, id := range subscriptionIDs {
+		known[id] = stripeFixture(t, "subscription", id, user)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutPrefix(r.URL.Path, "/v1/subscriptions/")
+		w.Header().Set("Content-Type", "application/json")
+		if body, found := known[id]; ok && found && r.Method == http.MethodGet {
+			fetched.Add(1)
+			w.Write(body)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error": {"type": "invalid_request_error", "code": "resource_missing", "message": "No such subscription: '%s'"}}`, id)
+	}))
+	t.Cleanup(ts.Close)
+	it.RouteHosts(t, ts.URL, "api.stripe.com")
+	return fetched
+}
+
+func loadSubscription(t *testing.T, s *it.Server, subscriptionID string) models.Subscription {
+	t.Helper()
+
+	var sub models.Subscription
+	if err := s.Deps.DB.DB.Where("stripe_subscription_id = ?", subscriptionID).First(&sub).Error; err != nil {
+		t.Fatalf("subscription %s not stored: %v", subscriptionID, err)
+	}
+	return sub
+}
+
+func TestSubscriptionWebhookLifecycle(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	subscriptionID := fmt.Sprintf("sub_it_%d", time.Now().UnixNano())
+	fetched := fakeStripeSubscriptions(t, alice, subscriptionID)
+
+	// The period comes from the items; latest_invoice is only an ID
+	sendStripeEvent(t, s, "customer.subscription.created", subscriptionID, alice)
+	sub := loadSubscription(t, s, subscriptionID)
+	if !sub.CurrentPeriodStart.Equal(time.Unix(1790000000, 0)) || !sub.CurrentPeriodEnd.Equal(time.Unix(1792592000, 0)) {
+		t.Errorf("created period = %s to %s, want the item's period", sub.CurrentPeriodStart, sub.CurrentPeriodEnd)
+	}
+	if sub.StripePriceID != "price_it_pro" || sub.StripeProductID != "prod_it_pro" || sub.Amount != 2000 || sub.Interval != "month" || sub.StripeCustomerID != "cus_it_sub" {
+		t.Errorf("created subscription = %+v", sub)
+	}
+	if n := fetched.Load(); n != 0 {
+		t.Errorf("fetched %d subscriptions for a payload with items, want 0", n)
+	}
+
+	// Without items in the payload the subscription is fetched
+	sendStripeEvent(t, s, "customer.subscription.updated.unexpanded", subscriptionID, alice)
+	sub = loadSubscription(t, s, subscriptionID)
+	if !sub.CurrentPeriodStart.Equal(time.Unix(1792592000, 0)) || !sub.CurrentPeriodEnd.Equal(time.Unix(1795270400, 0)) {
+		t.Errorf("updated period = %s to %s, want the fetched item's period", sub.CurrentPeriodStart, sub.CurrentPeriodEnd)
+	}
+	if !sub.CancelAtPeriodEnd || sub.PlanName != "Pro" {
+		t.Errorf("updated subscription = %+v, want cancel at period end on the Pro plan", sub)
+	}
+	if n := fetched.Load(); n != 1 {
+		t.Errorf("fetched %d subscriptions, want 1", n)
+	}
+
+	sendStripeEvent(t, s, "customer.subscription.deleted", subscriptionID, alice)
+	sub = loadSubscription(t, s, subscriptionID)
+	if sub.Status != "canceled" {
+		t.Errorf("status after deletion = %q, want canceled", sub.Status)
+	}
+	if sub.CurrentPeriodStart.Year() < 2000 {
+		t.Errorf("period start = %s after deletion", sub.CurrentPeriodStart)
+	}
+}
+
+func TestSubscriptionWebhookWithoutItems(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	subscriptionID := fmt.Sprintf("sub_it_%d", time.Now().UnixNano())
+	// Stripe doesn't know the subscription either
+	fakeStripeSubscriptions(t, alice)
+
+	sendStripeEvent(t, s, "customer.subscription.created.no_items", subscriptionID, alice)
+	sub := loadSubscription(t, s, subscriptionID)
+	if !sub.CurrentPeriodStart.Equal(time.Unix(1790000000, 0)) || sub.StripePriceID != "" {
+		t.Errorf("subscription = %+v, want the start date as its period and no price", sub)
+	}
+
+	// An update that can't be resolved keeps the stored period
+	sendStripeEvent(t, s, "customer.subscription.updated.unexpanded", subscriptionID, alice)
+	updated := loadSubscription(t, s, subscriptionID)
+	if !updated.CurrentPeriodStart.Equal(sub.CurrentPeriodStart) || !updated.CurrentPeriodEnd.Equal(sub.CurrentPeriodEnd) {
+		t.Errorf("period after an unresolved update = %s to %s, want it kept", updated.CurrentPeriodStart, updated.CurrentPeriodEnd)
+	}
+	if updated.CurrentPeriodStart.Year() < 2000 {
+		t.Errorf("stored the Unix epoch as the period start")
+	}
+}
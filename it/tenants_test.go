@@ -0,0 +1,220 @@
+//go:build integration
+
+package it_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/models"
+)
+
+// createTenant creates a tenant for the user through the API
+func createTenant(s *it.Server, user *it.SeededUser, name, schemaHint string) (*it.Response, error) {
+	return s.Send(it.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/tenants",
+		Token:  user.Token,
+		Body:   map[string]string{"name": name, "schemaHint": schemaHint},
+	})
+}
+
+func userTenants(t *testing.T, s *it.Server, token string) []users.TenantResponse {
+	t.Helper()
+
+	var list common.ApiResponse[[]users.TenantResponse]
+	s.Get(t, "/api/v1/users/me/tenants", token).Expect(t, http.StatusOK).Decode(t, &list)
+	return list.Data
+}
+
+func TestCreateTenantFreeLimit(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	// Signup used up the free plan's one tenant
+	var refused struct {
+		Code  string `json:"code"`
+		Limit int    `json:"limit"`
+	}
+	s.Do(t, it.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/tenants",
+		Token:  alice.Token,
+		Body:   map[string]string{"name": "Second Site"},
+	}).Expect(t, http.StatusForbidden).Decode(t, &refused)
+	if refused.Code != users.TenantLimitCode || refused.Limit != common.DEFAULT_FREE_MAX_TENANTS {
+		t.Errorf("refusal = %+v, want the tenant limit of the free plan", refused)
+	}
+
+	// An active Pro subscription raises the limit
+	if err := s.Deps.DB.DB.Create(&models.Subscription{
+		TenantSchema:         alice.TenantSchema,
+		UserID:               alice.ID,
+		StripeSubscriptionID: fmt.Sprintf("sub_it_%d", time.Now().UnixNano()),
+		StripePriceID:        "price_it_pro",
+		Status:               "active",
+		CurrentPeriodStart:   time.Now(),
+		CurrentPeriodEnd:     time.Now().AddDate(0, 1, 0),
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var created users.TenantTokenResponse
+	s.Post(t, "/api/v1/tenants", alice.Token, map[string]string{"name": "Second Site"}).
+		Expect(t, http.StatusCreated).Decode(t, &created)
+	if created.Tenant.Role != "owner" || created.Tenant.SchemaName == alice.TenantSchema {
+		t.Errorf("created tenant = %+v", created.Tenant)
+	}
+	claims, err := s.JWT.ValidateToken(created.Token)
+	if err != nil || claims.TenantSchema != created.Tenant.SchemaName {
+		t.Errorf("token tenant = %v (%v), want %s", claims, err, created.Tenant.SchemaName)
+	}
+	if !schemaExists(t, s, created.Tenant.SchemaName) {
+		t.Error("new tenant has no schema")
+	}
+	if tenants := userTenants(t, s, alice.Token); len(tenants) != 2 {
+		t.Errorf("alice belongs to %d tenants, want 2", len(tenants))
+	}
+}
+
+func TestCreateTenantSchemaCollision(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.FreeMaxTenants = 10 })
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	base := fmt.Sprintf("shop%d", time.Now().UnixNano())
+
+	tests := []struct {
+		name       string
+		schemaHint string
+		want       string
+	}{
+		{"Shop", base, base},
+		{"Shop again", base, base + "_1"},
+		{"Shop once more", base, base + "_2"},
+		{"Sanitized", "  " + base + " Co!", base + "_co_"},
+		{"Digits first", "9" + base, "t_9" + base},
+	}
+	for _, tt := range tests {
+		var created users.TenantTokenResponse
+		s.Post(t, "/api/v1/tenants", alice.Token, map[string]string{"name": tt.name, "schemaHint": tt.schemaHint}).
+			Expect(t, http.StatusCreated).Decode(t, &created)
+		got := created.Tenant.SchemaName
+		if got != tt.want {
+			t.Errorf("schema for hint %q = %q, want %q", tt.schemaHint, got, tt.want)
+		}
+		if !schemaExists(t, s, got) {
+			t.Errorf("schema %q was not created", got)
+		}
+	}
+
+	// Reserved names are prefixed; earlier runs may have taken t_public
+	var created users.TenantTokenResponse
+	s.Post(t, "/api/v1/tenants", alice.Token, map[string]string{"name": "Public", "schemaHint": "public"}).
+		Expect(t, http.StatusCreated).Decode(t, &created)
+	if !strings.HasPrefix(created.Tenant.SchemaName, "t_public") {
+		t.Errorf("schema for hint \"public\" = %q, want t_public", created.Tenant.SchemaName)
+	}
+}
+
+func TestCreateTenantConcurrentLimit(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.FreeMaxTenants = 2 })
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	base := fmt.Sprintf("race%d", time.Now().UnixNano())
+
+	// Only one of these fits under the limit; the schemas of the others
+	// are dropped again
+	statuses := make([]int, 4)
+	var wg sync.WaitGroup
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := createTenant(s, alice, fmt.Sprintf("Race %d", i), fmt.Sprintf("%s_%d", base, i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			statuses[i] = resp.Status
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i, status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusForbidden:
+			if schemaExists(t, s, fmt.Sprintf("%s_%d", base, i)) {
+				t.Errorf("refused tenant %d left its schema behind", i)
+			}
+		default:
+			t.Errorf("request %d status = %d", i, status)
+		}
+	}
+	if created != 1 {
+		t.Errorf("created %d tenants, want 1 under a limit of 2", created)
+	}
+
+	var orphans int64
+	s.Deps.DB.DB.Model(&models.Tenant{}).
+		Where("schema_name LIKE ? AND schema_name NOT IN (?)", base+"%",
+			s.Deps.DB.DB.Model(&models.UserTenant{}).Select("tenant_schema")).
+		Count(&orphans)
+	if orphans != 0 {
+		t.Errorf("%d tenant rows without a member", orphans)
+	}
+}
+
+func TestSetPrimaryTenant(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) { cfg.FreeMaxTenants = 2 })
+	seeded := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := seeded["alice"], seeded["bob"]
+
+	var created users.TenantTokenResponse
+	s.Post(t, "/api/v1/tenants", alice.Token, map[string]string{"name": "Second Site"}).
+		Expect(t, http.StatusCreated).Decode(t, &created)
+	if created.Tenant.Primary {
+		t.Error("a created tenant became primary")
+	}
+
+	var switched users.TenantTokenResponse
+	s.Do(t, it.Request{Method: http.MethodPatch, Path: "/api/v1/users/me/tenants/" + created.Tenant.SchemaName + "/primary", Token: alice.Token}).
+		Expect(t, http.StatusOK).Decode(t, &switched)
+	if !switched.Tenant.Primary {
+		t.Errorf("switched tenant = %+v, want primary", switched.Tenant)
+	}
+	primaries := 0
+	for _, tenant := range userTenants(t, s, alice.Token) {
+		if tenant.Primary {
+			primaries++
+			if tenant.SchemaName != created.Tenant.SchemaName {
+				t.Errorf("primary tenant = %s, want %s", tenant.SchemaName, created.Tenant.SchemaName)
+			}
+		}
+	}
+	if primaries != 1 {
+		t.Errorf("%d primary tenants, want 1", primaries)
+	}
+
+	// Logging in lands in the new primary tenant
+	var login struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	s.Post(t, "/api/v1/auth/login", "", map[string]string{"email": alice.Email, "password": alice.Password}).
+		Expect(t, http.StatusOK).Decode(t, &login)
+	if claims, err := s.JWT.ValidateToken(login.Data.Token); err != nil || claims.TenantSchema != created.Tenant.SchemaName {
+		t.Errorf("login tenant = %v (%v), want the new primary", claims, err)
+	}
+
+	s.Do(t, it.Request{Method: http.MethodPatch, Path: "/api/v1/users/me/tenants/" + created.Tenant.SchemaName + "/primary", Token: bob.Token}).
+		Expect(t, http.StatusNotFound)
+}
@@ -0,0 +1,55 @@
+//go:build integration
+
+package it_test
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/it"
+	"awning-backend/model"
+)
+
+func TestUpdateDiffsAgainstPublication(t *testing.T) {
+	s := it.NewServer(t, func(cfg *common.Config) {
+		cfg.UpdateCurrentSite = true
+		cfg.UpdateKeepUnchangedSections = true
+	})
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	s.Vertex.Default(it.Reply{Content: `<!DOCTYPE html><html><head><title>Bakery</title></head><body>` +
+		`<section id="hero"><h1>Rye &amp; Co</h1></section>` +
+		`<section id="menu"><p>Bread</p></section>` +
+		`<section id="contact"><p>Call us</p></section></body></html>`})
+	publishChat(t, s, alice, "A site for my bakery")
+
+	s.Vertex.Default(it.Reply{Content: `<!DOCTYPE html><html><head><title>Bakery</title></head><body>` +
+		`<section id="hero" data-awning-unchanged></section>` +
+		`<section id="menu"><p>Bread and cakes</p></section>` +
+		`<section id="hours"><p>7am to 3pm</p></section></body></html>`})
+	var response model.ChatResponse
+	s.Post(t, "/api/v1/chat/complete", alice.Token, map[string]any{
+		"chat_stage": "update",
+		"message":    map[string]string{"role": "user", "content": "Add cakes to the menu, our hours, and drop the contact section"},
+	}).Expect(t, http.StatusOK).Decode(t, &response)
+
+	prompts := s.Vertex.Prompts()
+	if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, "## Current Site") || !strings.Contains(prompt, "- #menu: Bread") {
+		t.Errorf("update prompt doesn't outline the published site:\n%s", prompt)
+	}
+
+	diff := response.SectionDiff
+	if diff == nil || diff.Base != "publication" {
+		t.Fatalf("section diff = %+v, want one against the publication", diff)
+	}
+	if !slices.Equal(diff.Added, []string{"hours"}) || !slices.Equal(diff.Removed, []string{"contact"}) ||
+		!slices.Equal(diff.Modified, []string{"menu"}) || !slices.Equal(diff.Unchanged, []string{"hero"}) {
+		t.Errorf("section diff = %+v", diff)
+	}
+	if !strings.Contains(response.Message.Content, "Rye &amp; Co") || strings.Contains(response.Message.Content, "data-awning-unchanged") {
+		t.Errorf("page = %q, want the unchanged hero taken from the publication", response.Message.Content)
+	}
+}
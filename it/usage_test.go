@@ -0,0 +1,195 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/usage"
+
+	"gorm.io/gorm"
+)
+
+// seedUsage adds usage records to the tenant as if generations had made them
+func seedUsage(t *testing.T, s *it.Server, tenantSchema string, records []models.UsageRecord) {
+	t.Helper()
+
+	err := s.Deps.DB.WithTenant(context.Background(), tenantSchema, func(tx *gorm.DB) error {
+		for i := range records {
+			records[i].TenantSchema = tenantSchema
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// csvReport reads a CSV usage report into JSON report rows, checking the
+// total row against the rows above it
+func csvReport(t *testing.T, body []byte, admin bool) ([]usage.Row, usage.Totals) {
+	t.Helper()
+
+	records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if err != nil {
+		t.Fatalf("report is not CSV: %v\n%s", err, body)
+	}
+	if len(records) < 2 {
+		t.Fatalf("CSV report = %q, want a header and a total row", records)
+	}
+
+	number := func(s string) int64 {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			t.Fatalf("CSV field %q is not a number", s)
+		}
+		return n
+	}
+	parse := func(fields []string) usage.Row {
+		var row usage.Row
+		if admin {
+			row.TenantSchema, fields = fields[0], fields[1:]
+		}
+		row.Period, row.Model = fields[0], fields[1]
+		row.Generations, row.PromptTokens, row.CompletionTokens = number(fields[2]), number(fields[3]), number(fields[4])
+		row.TotalTokens, row.ImageSearches = number(fields[5]), number(fields[6])
+		return row
+	}
+
+	var rows []usage.Row
+	for _, fields := range records[1 : len(records)-1] {
+		rows = append(rows, parse(fields))
+	}
+	total := parse(records[len(records)-1])
+	if total.Period != "total" {
+		t.Fatalf("last CSV row = %q, want the total row", records[len(records)-1])
+	}
+	return rows, usage.Totals{
+		Generations:      total.Generations,
+		PromptTokens:     total.PromptTokens,
+		CompletionTokens: total.CompletionTokens,
+		TotalTokens:      total.TotalTokens,
+		ImageSearches:    total.ImageSearches,
+	}
+}
+
+func TestUsageReportCSVMatchesJSON(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+
+	athens := time.FixedZone("UTC+2", 2*60*60)
+	newYork := time.FixedZone("UTC-5", -5*60*60)
+	seedUsage(t, s, alice.TenantSchema, []models.UsageRecord{
+		// Before the range
+		{CreatedAt: time.Date(2026, 2, 27, 23, 59, 59, 0, time.UTC), Model: "gemini-flash", PromptTokens: 1000},
+		{CreatedAt: time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC), Model: "gemini-flash", PromptTokens: 100, CompletionTokens: 400, ImageSearches: 2},
+		// March 1st in Athens, still February 28th in UTC
+		{CreatedAt: time.Date(2026, 3, 1, 0, 30, 0, 0, athens), Model: "gemini-flash", PromptTokens: 10, CompletionTokens: 40},
+		// February 28th in New York, March 1st in UTC
+		{CreatedAt: time.Date(2026, 2, 28, 20, 0, 0, 0, newYork), Model: "gemini-flash", PromptTokens: 20, CompletionTokens: 80, ImageSearches: 1},
+		{CreatedAt: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC), Model: "gemini-pro", PromptTokens: 300, CompletionTokens: 900},
+		{CreatedAt: time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC), Model: "gemini-pro", PromptTokens: 5, CompletionTokens: 5},
+		// After the range
+		{CreatedAt: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), Model: "gemini-pro", PromptTokens: 1000},
+	})
+
+	var report usage.Report
+	s.Get(t, "/api/v1/usage/report?from=2026-02-28&to=2026-03-31", alice.Token).Expect(t, http.StatusOK).Decode(t, &report)
+
+	want := []usage.Row{
+		{Period: "2026-02-28", Model: "gemini-flash", Generations: 2, PromptTokens: 110, CompletionTokens: 440, TotalTokens: 550, ImageSearches: 2},
+		{Period: "2026-03-01", Model: "gemini-flash", Generations: 1, PromptTokens: 20, CompletionTokens: 80, TotalTokens: 100, ImageSearches: 1},
+		{Period: "2026-03-01", Model: "gemini-pro", Generations: 1, PromptTokens: 300, CompletionTokens: 900, TotalTokens: 1200},
+		{Period: "2026-03-31", Model: "gemini-pro", Generations: 1, PromptTokens: 5, CompletionTokens: 5, TotalTokens: 10},
+	}
+	wantTotals := usage.Totals{Generations: 5, PromptTokens: 435, CompletionTokens: 1425, TotalTokens: 1860, ImageSearches: 3}
+	if report.Timezone != "UTC" || report.From != "2026-02-28" || report.To != "2026-03-31" {
+		t.Errorf("report range = %s..%s %s", report.From, report.To, report.Timezone)
+	}
+	if len(report.Rows) != len(want) {
+		t.Fatalf("JSON rows = %+v, want %+v", report.Rows, want)
+	}
+	for i := range want {
+		if report.Rows[i] != want[i] {
+			t.Errorf("JSON row %d = %+v, want %+v", i, report.Rows[i], want[i])
+		}
+	}
+	if report.Totals != wantTotals {
+		t.Errorf("JSON totals = %+v, want %+v", report.Totals, wantTotals)
+	}
+
+	resp := s.Get(t, "/api/v1/usage/report?from=2026-02-28&to=2026-03-31&format=csv", alice.Token).Expect(t, http.StatusOK)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q, want text/csv", ct)
+	}
+	wantDisposition := `attachment; filename="usage-` + alice.TenantSchema + `-2026-02-28-2026-03-31.csv"`
+	if got := resp.Header.Get("Content-Disposition"); got != wantDisposition {
+		t.Errorf("Content-Disposition = %q, want %q", got, wantDisposition)
+	}
+	rows, totals := csvReport(t, resp.Body, false)
+	if len(rows) != len(report.Rows) {
+		t.Fatalf("CSV rows = %+v, want the JSON rows %+v", rows, report.Rows)
+	}
+	for i := range rows {
+		if rows[i] != report.Rows[i] {
+			t.Errorf("CSV row %d = %+v, want the JSON row %+v", i, rows[i], report.Rows[i])
+		}
+	}
+	if totals != report.Totals {
+		t.Errorf("CSV totals = %+v, want the JSON totals %+v", totals, report.Totals)
+	}
+
+	// Months add up to the same totals
+	var months usage.Report
+	s.Get(t, "/api/v1/usage/report?from=2026-02-28&to=2026-03-31&granularity=month", alice.Token).Expect(t, http.StatusOK).Decode(t, &months)
+	if len(months.Rows) != 3 || months.Rows[0].Period != "2026-02" || months.Rows[1].Period != "2026-03" {
+		t.Errorf("month rows = %+v, want February's flash and March's flash and pro", months.Rows)
+	}
+	if months.Totals != wantTotals {
+		t.Errorf("month totals = %+v, want %+v", months.Totals, wantTotals)
+	}
+}
+
+func TestAdminUsageReport(t *testing.T) {
+	s := it.NewServer(t)
+	users := s.Seed(t, it.LoadSeed(t, "basic"))
+	alice, bob := users["alice"], users["bob"]
+
+	day := time.Date(2026, 5, 10, 12, 0, 0, 0, time.UTC)
+	seedUsage(t, s, alice.TenantSchema, []models.UsageRecord{{CreatedAt: day, Model: "gemini-flash", PromptTokens: 7, CompletionTokens: 3}})
+	seedUsage(t, s, bob.TenantSchema, []models.UsageRecord{{CreatedAt: day, Model: "gemini-flash", PromptTokens: 11, CompletionTokens: 9, ImageSearches: 4}})
+
+	path := "/api/v1/admin/usage/report?from=2026-05-10&to=2026-05-10"
+	s.Get(t, path, alice.Token).Expect(t, http.StatusUnauthorized)
+
+	var report usage.Report
+	s.Admin(t, http.MethodGet, path, nil).Expect(t, http.StatusOK).Decode(t, &report)
+	byTenant := map[string]usage.Row{}
+	for _, row := range report.Rows {
+		byTenant[row.TenantSchema] = row
+	}
+	if row := byTenant[alice.TenantSchema]; row.TotalTokens != 10 || row.Period != "2026-05-10" {
+		t.Errorf("alice's row = %+v", row)
+	}
+	if row := byTenant[bob.TenantSchema]; row.TotalTokens != 20 || row.ImageSearches != 4 {
+		t.Errorf("bob's row = %+v", row)
+	}
+
+	rows, totals := csvReport(t, s.Admin(t, http.MethodGet, path+"&format=csv", nil).Expect(t, http.StatusOK).Body, true)
+	if len(rows) != len(report.Rows) || totals != report.Totals {
+		t.Fatalf("CSV = %+v totalling %+v, want the JSON rows totalling %+v", rows, totals, report.Totals)
+	}
+	for i := range rows {
+		if rows[i] != report.Rows[i] {
+			t.Errorf("CSV row %d = %+v, want the JSON row %+v", i, rows[i], report.Rows[i])
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//go:build integration
+
+package it_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"awning-backend/it"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// createWebhook stores a webhook for the tenant directly, bypassing the
+// URL checks of the API
+func createWebhook(t *testing.T, s *it.Server, schema, url string, enabled bool) *models.TenantWebhook {
+	t.Helper()
+
+	hook := &models.TenantWebhook{
+		TenantSchema: schema,
+		URL:          url,
+		Secret:       "whsec_it",
+		Events:       []string{webhooks.EventChatCompleted},
+		Enabled:      true,
+	}
+	err := s.Deps.DB.WithTenant(context.Background(), schema, func(tx *gorm.DB) error {
+		if err := tx.Create(hook).Error; err != nil {
+			return err
+		}
+		// Enabled defaults to true, so false has to be written explicitly
+		return tx.Model(hook).Update("enabled", enabled).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	return hook
+}
+
+func deliveryJob(t *testing.T, schema string, webhookID uint, eventID string) json.RawMessage {
+	t.Helper()
+
+	raw, err := json.Marshal(map[string]any{
+		"tenantSchema": schema,
+		"webhookId":    webhookID,
+		"eventId":      eventID,
+		"eventType":    webhooks.EventChatCompleted,
+		"body":         map[string]string{"id": eventID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func webhookDeliveries(t *testing.T, s *it.Server, schema string, webhookID uint) []models.TenantWebhookDelivery {
+	t.Helper()
+
+	var deliveries []models.TenantWebhookDelivery
+	err := s.Deps.DB.WithTenant(context.Background(), schema, func(tx *gorm.DB) error {
+		return tx.Where("webhook_id = ?", webhookID).Order("attempt").Find(&deliveries).Error
+	})
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	return deliveries
+}
+
+func TestWebhookDeliveryToBlockedTarget(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	hook := createWebhook(t, s, alice.TenantSchema, "http://169.254.169.254/latest/meta-data", true)
+	d := webhooks.NewDeliverer(s.Deps.DB, time.Second)
+	eventID := fmt.Sprintf("evt_it_%d", time.Now().UnixNano())
+
+	// Dropped rather than retried, but each attempt is logged
+	for range 2 {
+		if err := d.HandleDeliver(context.Background(), deliveryJob(t, alice.TenantSchema, hook.ID, eventID)); err != nil {
+			t.Fatalf("HandleDeliver() error = %v, want nil so it isn't retried", err)
+		}
+	}
+	deliveries := webhookDeliveries(t, s, alice.TenantSchema, hook.ID)
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries logged, want 2", len(deliveries))
+	}
+	for i, delivery := range deliveries {
+		if delivery.Attempt != i+1 || delivery.Success || delivery.StatusCode != 0 || delivery.EventID != eventID {
+			t.Errorf("delivery %d = %+v, want failed attempt %d with no response", i, delivery, i+1)
+		}
+	}
+}
+
+func TestWebhookDeliveryDropped(t *testing.T) {
+	s := it.NewServer(t)
+	alice := s.Seed(t, it.LoadSeed(t, "basic"))["alice"]
+	d := webhooks.NewDeliverer(s.Deps.DB, time.Second)
+
+	disabled := createWebhook(t, s, alice.TenantSchema, "https://93.184.216.34/hooks", false)
+	deleted := createWebhook(t, s, alice.TenantSchema, "https://93.184.216.34/hooks", true)
+	if err := s.Deps.DB.WithTenant(context.Background(), alice.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Delete(deleted).Error
+	}); err != nil {
+		t.Fatalf("failed to delete webhook: %v", err)
+	}
+
+	for name, hook := range map[string]*models.TenantWebhook{"disabled": disabled, "deleted": deleted} {
+		if err := d.HandleDeliver(context.Background(), deliveryJob(t, alice.TenantSchema, hook.ID, "evt_it_dropped")); err != nil {
+			t.Errorf("%s: HandleDeliver() error = %v, want nil", name, err)
+		}
+		if deliveries := webhookDeliveries(t, s, alice.TenantSchema, hook.ID); len(deliveries) != 0 {
+			t.Errorf("%s: %d deliveries logged, want none attempted", name, len(deliveries))
+		}
+	}
+}
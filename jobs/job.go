@@ -0,0 +1,91 @@
+// Package jobs runs background work from a Redis-backed queue with retries,
+// delayed scheduling and a dead-letter list
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// DEFAULT_MAX_RETRIES applies when a job does not set its own limit
+	DEFAULT_MAX_RETRIES = 5
+	// DEFAULT_VISIBILITY_TIMEOUT is how long a fetched job may run before it
+	// is handed to another worker
+	DEFAULT_VISIBILITY_TIMEOUT = 5 * time.Minute
+	// FETCH_POLL_INTERVAL is how often an empty ready list is checked again
+	FETCH_POLL_INTERVAL = 100 * time.Millisecond
+	// Backoff between retries doubles from BASE_RETRY_DELAY up to MAX_RETRY_DELAY
+	BASE_RETRY_DELAY = 5 * time.Second
+	MAX_RETRY_DELAY  = time.Hour
+)
+
+// Job is a unit of background work. The payload is stored as JSON.
+type Job interface {
+	Name() string
+	Payload() any
+	MaxRetries() int
+}
+
+// Handler processes the payload of jobs with a given name
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// Envelope is the queued form of a job
+type Envelope struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempt    int             `json:"attempt"`
+	MaxRetries int             `json:"max_retries"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+	LastError  string          `json:"last_error,omitempty"`
+}
+
+// simpleJob is the Job returned by New
+type simpleJob struct {
+	name       string
+	payload    any
+	maxRetries int
+}
+
+func (j simpleJob) Name() string    { return j.name }
+func (j simpleJob) Payload() any    { return j.payload }
+func (j simpleJob) MaxRetries() int { return j.maxRetries }
+
+// New returns a job with the given name and payload. A negative maxRetries
+// uses DEFAULT_MAX_RETRIES.
+func New(name string, payload any, maxRetries int) Job {
+	if maxRetries < 0 {
+		maxRetries = DEFAULT_MAX_RETRIES
+	}
+	return simpleJob{name: name, payload: payload, maxRetries: maxRetries}
+}
+
+// Backoff returns the delay before retrying a job that failed attempt times
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := BASE_RETRY_DELAY
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= MAX_RETRY_DELAY {
+			return MAX_RETRY_DELAY
+		}
+	}
+	return delay
+}
+
+// Hooks receive queue metrics. Any field may be nil.
+type Hooks struct {
+	// QueueDepth reports the length of the ready, processing, scheduled and
+	// dead lists after each maintenance pass
+	QueueDepth func(depth Depth)
+	// JobSucceeded is called after a handler returns nil
+	JobSucceeded func(name string, duration time.Duration)
+	// JobFailed is called after a handler returns an error
+	JobFailed func(name string, attempt int, err error)
+	// JobDeadLettered is called when a job runs out of retries
+	JobDeadLettered func(name string, err error)
+}
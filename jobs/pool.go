@@ -0,0 +1,256 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// FETCH_TIMEOUT bounds each blocking fetch so workers notice shutdown
+	FETCH_TIMEOUT = time.Second
+	// MAINTENANCE_INTERVAL is how often scheduled jobs are promoted and
+	// expired leases are reaped
+	MAINTENANCE_INTERVAL = time.Second
+	// PROMOTE_BATCH is the most scheduled jobs promoted per pass
+	PROMOTE_BATCH = 100
+)
+
+// ErrUnknownJob is recorded for jobs without a registered handler
+var ErrUnknownJob = errors.New("no handler registered for job")
+
+// Pool runs registered handlers for jobs fetched from a Queue
+type Pool struct {
+	logger      *slog.Logger
+	queue       *Queue
+	concurrency int
+	hooks       Hooks
+
+	mu        sync.RWMutex
+	handlers  map[string]Handler
+	periodics []periodic
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPool creates a worker pool with the given number of workers
+func NewPool(queue *Queue, concurrency int, hooks Hooks) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Pool{
+		logger:      slog.With("service", "JobPool"),
+		queue:       queue,
+		concurrency: concurrency,
+		hooks:       hooks,
+		handlers:    make(map[string]Handler),
+	}
+}
+
+// Queue returns the queue the pool consumes
+func (p *Pool) Queue() *Queue {
+	return p.queue
+}
+
+// Register sets the handler for jobs with the given name
+func (p *Pool) Register(name string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[name] = handler
+}
+
+// periodic is a job enqueued once per interval by the maintenance loop
+type periodic struct {
+	name     string
+	interval time.Duration
+}
+
+// Every registers handler and enqueues a job with the given name once per
+// interval. The interval is claimed in Redis, so only one instance enqueues
+// it and restarts do not reset the schedule.
+func (p *Pool) Every(name string, interval time.Duration, handler Handler) {
+	p.Register(name, handler)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.periodics = append(p.periodics, periodic{name: name, interval: interval})
+}
+
+func (p *Pool) handler(name string) (Handler, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	h, ok := p.handlers[name]
+	return h, ok
+}
+
+// Start launches the workers and the maintenance loop
+func (p *Pool) Start(ctx context.Context) {
+	ctx, p.cancel = context.WithCancel(ctx)
+
+	for i := 0; i < p.concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.maintain(ctx)
+	}()
+
+	p.logger.Info("Job pool started", "concurrency", p.concurrency)
+}
+
+// Shutdown stops fetching new jobs and waits for running handlers to finish
+// or for ctx to end. Jobs still running when ctx ends are redelivered after
+// their lease expires.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	if p.cancel == nil {
+		return nil
+	}
+	p.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.logger.Info("Job pool stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("job pool shutdown: %w", ctx.Err())
+	}
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		d, err := p.queue.Fetch(ctx, FETCH_TIMEOUT)
+		if errors.Is(err, ErrNoJob) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.logger.Error("Failed to fetch job", "error", err)
+			time.Sleep(FETCH_TIMEOUT)
+			continue
+		}
+
+		// Handlers run to completion on shutdown, so they get a context
+		// that is not cancelled with the pool
+		p.process(context.WithoutCancel(ctx), d)
+	}
+}
+
+// process runs the handler for a delivery and records the outcome
+func (p *Pool) process(ctx context.Context, d *Delivery) {
+	start := time.Now()
+
+	err := ErrUnknownJob
+	if h, ok := p.handler(d.Name); ok {
+		err = p.run(ctx, h, d)
+	}
+
+	if err == nil {
+		if ackErr := p.queue.Ack(ctx, d); ackErr != nil {
+			p.logger.Error("Failed to ack job", "job", d.Name, "id", d.ID, "error", ackErr)
+		}
+		if p.hooks.JobSucceeded != nil {
+			p.hooks.JobSucceeded(d.Name, time.Since(start))
+		}
+		return
+	}
+
+	p.logger.Warn("Job failed", "job", d.Name, "id", d.ID, "attempt", d.Attempt+1, "error", err)
+	if p.hooks.JobFailed != nil {
+		p.hooks.JobFailed(d.Name, d.Attempt+1, err)
+	}
+
+	dead, failErr := p.queue.Fail(ctx, d, err)
+	if failErr != nil {
+		p.logger.Error("Failed to record job failure", "job", d.Name, "id", d.ID, "error", failErr)
+		return
+	}
+	if dead {
+		p.logger.Error("Job moved to dead-letter list", "job", d.Name, "id", d.ID, "error", err)
+		if p.hooks.JobDeadLettered != nil {
+			p.hooks.JobDeadLettered(d.Name, err)
+		}
+	}
+}
+
+// run calls the handler, turning a panic into an error
+func (p *Pool) run(ctx context.Context, h Handler, d *Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, d.Payload)
+}
+
+func (p *Pool) maintain(ctx context.Context) {
+	ticker := time.NewTicker(MAINTENANCE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := p.queue.PromoteDue(ctx, PROMOTE_BATCH); err != nil && ctx.Err() == nil {
+			p.logger.Error("Failed to promote scheduled jobs", "error", err)
+		}
+		if n, err := p.queue.ReapExpired(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("Failed to reap expired jobs", "error", err)
+		} else if n > 0 {
+			p.logger.Warn("Redelivered jobs with expired leases", "count", n)
+		}
+
+		p.enqueuePeriodic(ctx)
+
+		if p.hooks.QueueDepth != nil {
+			if depth, err := p.queue.Depth(ctx); err == nil {
+				p.hooks.QueueDepth(depth)
+			}
+		}
+	}
+}
+
+// enqueuePeriodic enqueues each periodic job whose interval has not been
+// claimed yet
+func (p *Pool) enqueuePeriodic(ctx context.Context) {
+	p.mu.RLock()
+	periodics := p.periodics
+	p.mu.RUnlock()
+
+	for _, job := range periodics {
+		claimed, err := p.queue.claimPeriod(ctx, job.name, job.interval)
+		if err != nil {
+			if ctx.Err() == nil {
+				p.logger.Error("Failed to claim periodic job", "job", job.name, "error", err)
+			}
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := p.queue.Enqueue(ctx, New(job.name, nil, -1)); err != nil {
+			p.logger.Error("Failed to enqueue periodic job", "job", job.name, "error", err)
+			_ = p.queue.releasePeriod(ctx, job.name)
+		}
+	}
+}
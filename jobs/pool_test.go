@@ -0,0 +1,150 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingHooks counts the hook calls of a pool
+type recordingHooks struct {
+	mu           sync.Mutex
+	succeeded    []string
+	failed       []int
+	deadLettered []string
+}
+
+func (r *recordingHooks) hooks() Hooks {
+	return Hooks{
+		JobSucceeded: func(name string, _ time.Duration) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.succeeded = append(r.succeeded, name)
+		},
+		JobFailed: func(_ string, attempt int, _ error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.failed = append(r.failed, attempt)
+		},
+		JobDeadLettered: func(name string, _ error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.deadLettered = append(r.deadLettered, name)
+		},
+	}
+}
+
+// processNext fetches one job and processes it on p
+func processNext(t *testing.T, p *Pool) {
+	t.Helper()
+
+	d, err := p.queue.Fetch(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	p.process(context.Background(), d)
+}
+
+func TestPoolRetriesThenSucceeds(t *testing.T) {
+	q, clock, _ := newTestQueue(t)
+	hooks := &recordingHooks{}
+	p := NewPool(q, 1, hooks.hooks())
+	ctx := context.Background()
+
+	calls := 0
+	p.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+		calls++
+		if calls < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	q.Enqueue(ctx, New("flaky", nil, 5))
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		processNext(t, p)
+		if attempt < 3 {
+			clock.Advance(Backoff(attempt))
+			q.PromoteDue(ctx, PROMOTE_BATCH)
+		}
+	}
+
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+	if len(hooks.failed) != 2 || hooks.failed[0] != 1 || hooks.failed[1] != 2 {
+		t.Errorf("JobFailed attempts = %v, want [1 2]", hooks.failed)
+	}
+	if len(hooks.succeeded) != 1 || len(hooks.deadLettered) != 0 {
+		t.Errorf("succeeded = %v, dead-lettered = %v", hooks.succeeded, hooks.deadLettered)
+	}
+	checkDepth(t, q, Depth{})
+}
+
+func TestPoolDeadLetters(t *testing.T) {
+	q, _, _ := newTestQueue(t)
+	hooks := &recordingHooks{}
+	p := NewPool(q, 1, hooks.hooks())
+	ctx := context.Background()
+
+	p.Register("broken", func(ctx context.Context, payload json.RawMessage) error {
+		panic("boom")
+	})
+	q.Enqueue(ctx, New("broken", nil, 0))
+	q.Enqueue(ctx, New("unregistered", nil, 0))
+
+	processNext(t, p)
+	processNext(t, p)
+
+	if len(hooks.deadLettered) != 2 {
+		t.Errorf("dead-lettered = %v, want both jobs", hooks.deadLettered)
+	}
+	checkDepth(t, q, Depth{Dead: 2})
+
+	letters, _ := q.DeadLetters(ctx, 10)
+	errs := map[string]string{}
+	for _, e := range letters {
+		errs[e.Name] = e.LastError
+	}
+	if errs["broken"] != "job panicked: boom" || errs["unregistered"] != ErrUnknownJob.Error() {
+		t.Errorf("dead letter errors = %q", errs)
+	}
+}
+
+func TestPoolStartAndShutdown(t *testing.T) {
+	q, _, _ := newTestQueue(t)
+	p := NewPool(q, 2, Hooks{})
+	ctx := context.Background()
+
+	done := make(chan string, 3)
+	p.Register("greet", func(ctx context.Context, payload json.RawMessage) error {
+		var name string
+		json.Unmarshal(payload, &name)
+		done <- name
+		return nil
+	})
+	p.Start(ctx)
+	for _, name := range []string{"a", "b", "c"} {
+		q.Enqueue(ctx, New("greet", name, 0))
+	}
+
+	seen := map[string]bool{}
+	for range 3 {
+		select {
+		case name := <-done:
+			seen[name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %v, want all three jobs", seen)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := p.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	checkDepth(t, q, Depth{})
+}
@@ -0,0 +1,305 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNoJob is returned by Fetch when no job arrived before the timeout
+var ErrNoJob = errors.New("no job available")
+
+// promoteScript moves due jobs from a sorted set onto the ready list.
+// KEYS[1] sorted set, KEYS[2] ready list, ARGV[1] now (unix ms), ARGV[2] limit
+var promoteScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, tonumber(ARGV[2]))
+for _, raw in ipairs(due) do
+	redis.call("ZREM", KEYS[1], raw)
+	redis.call("LPUSH", KEYS[2], raw)
+end
+return #due
+`)
+
+// fetchScript moves the oldest ready job onto the processing list and leases
+// it in one step, so a crash can't leave a job in processing without a lease
+// for the reaper to find.
+// KEYS[1] ready list, KEYS[2] processing list, KEYS[3] leases, ARGV[1] lease deadline (unix ms)
+var fetchScript = redis.NewScript(`
+local raw = redis.call("RPOPLPUSH", KEYS[1], KEYS[2])
+if raw then
+	redis.call("ZADD", KEYS[3], ARGV[1], raw)
+end
+return raw
+`)
+
+// reapScript returns jobs whose lease expired to the ready list, if they are
+// still in the processing list.
+// KEYS[1] leases, KEYS[2] processing list, KEYS[3] ready list, ARGV[1] now (unix ms)
+var reapScript = redis.NewScript(`
+local expired = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+local n = 0
+for _, raw in ipairs(expired) do
+	redis.call("ZREM", KEYS[1], raw)
+	if redis.call("LREM", KEYS[2], 1, raw) > 0 then
+		redis.call("LPUSH", KEYS[3], raw)
+		n = n + 1
+	end
+end
+return n
+`)
+
+// Queue stores jobs in Redis. Fetched jobs are moved atomically onto a
+// processing list and leased for the visibility timeout; jobs that are not
+// acknowledged in time are delivered again, so handlers must be idempotent.
+type Queue struct {
+	client            redis.UniversalClient
+	readyKey          string
+	processingKey     string
+	leasesKey         string
+	scheduledKey      string
+	deadKey           string
+	periodicPrefix    string
+	visibilityTimeout time.Duration
+	now               func() time.Time
+}
+
+// NewQueue creates a queue whose keys start with prefix. On a Redis Cluster
+// the keys share a hash tag, since scripts and moves span several of them.
+func NewQueue(client redis.UniversalClient, prefix string) *Queue {
+	base := prefix + "jobs:"
+	if _, ok := client.(*redis.ClusterClient); ok {
+		base = prefix + "{jobs}:"
+	}
+	return &Queue{
+		client:            client,
+		readyKey:          base + "ready",
+		processingKey:     base + "processing",
+		leasesKey:         base + "leases",
+		scheduledKey:      base + "scheduled",
+		deadKey:           base + "dead",
+		periodicPrefix:    base + "periodic:",
+		visibilityTimeout: DEFAULT_VISIBILITY_TIMEOUT,
+		now:               time.Now,
+	}
+}
+
+// WithVisibilityTimeout sets how long a fetched job is leased to a worker
+func (q *Queue) WithVisibilityTimeout(d time.Duration) *Queue {
+	q.visibilityTimeout = d
+	return q
+}
+
+func newJobID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (q *Queue) envelope(job Job) ([]byte, error) {
+	payload, err := json.Marshal(job.Payload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload for %s: %w", job.Name(), err)
+	}
+	return json.Marshal(Envelope{
+		ID:         newJobID(),
+		Name:       job.Name(),
+		Payload:    payload,
+		MaxRetries: job.MaxRetries(),
+		EnqueuedAt: q.now().UTC(),
+	})
+}
+
+// Enqueue adds a job to the ready list
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	raw, err := q.envelope(job)
+	if err != nil {
+		return err
+	}
+	if err := q.client.LPush(ctx, q.readyKey, raw).Err(); err != nil {
+		return fmt.Errorf("failed to enqueue %s: %w", job.Name(), err)
+	}
+	return nil
+}
+
+// EnqueueAt schedules a job to become ready at runAt
+func (q *Queue) EnqueueAt(ctx context.Context, job Job, runAt time.Time) error {
+	raw, err := q.envelope(job)
+	if err != nil {
+		return err
+	}
+	if err := q.client.ZAdd(ctx, q.scheduledKey, redis.Z{Score: float64(runAt.UnixMilli()), Member: raw}).Err(); err != nil {
+		return fmt.Errorf("failed to schedule %s: %w", job.Name(), err)
+	}
+	return nil
+}
+
+// EnqueueIn schedules a job to become ready after delay
+func (q *Queue) EnqueueIn(ctx context.Context, job Job, delay time.Duration) error {
+	return q.EnqueueAt(ctx, job, q.now().Add(delay))
+}
+
+// Delivery is a fetched job. Raw is the stored form used to acknowledge it.
+type Delivery struct {
+	Envelope
+	Raw string
+}
+
+// claimPeriod reports whether the caller is the first, across all
+// instances, to claim the current interval of a periodic job
+func (q *Queue) claimPeriod(ctx context.Context, name string, interval time.Duration) (bool, error) {
+	return q.client.SetNX(ctx, q.periodicPrefix+name, q.now().UnixMilli(), interval).Result()
+}
+
+// releasePeriod drops a claim so the periodic job is enqueued on the next pass
+func (q *Queue) releasePeriod(ctx context.Context, name string) error {
+	return q.client.Del(ctx, q.periodicPrefix+name).Err()
+}
+
+// Fetch waits up to timeout for a ready job, moves it to the processing list
+// and leases it for the visibility timeout. Scripts can't block, so the
+// ready list is polled every FETCH_POLL_INTERVAL while it is empty.
+func (q *Queue) Fetch(ctx context.Context, timeout time.Duration) (*Delivery, error) {
+	deadline := time.Now().Add(timeout)
+	var raw string
+	for {
+		leaseUntil := q.now().Add(q.visibilityTimeout)
+		var err error
+		raw, err = fetchScript.Run(ctx, q.client, []string{q.readyKey, q.processingKey, q.leasesKey},
+			strconv.FormatInt(leaseUntil.UnixMilli(), 10)).Text()
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("failed to fetch job: %w", err)
+		}
+
+		wait := min(FETCH_POLL_INTERVAL, time.Until(deadline))
+		if wait <= 0 {
+			return nil, ErrNoJob
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	d := &Delivery{Raw: raw}
+	if err := json.Unmarshal([]byte(raw), &d.Envelope); err != nil {
+		// Unreadable entries can never succeed
+		_ = q.client.LPush(ctx, q.deadKey, raw).Err()
+		_ = q.Ack(ctx, d)
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return d, nil
+}
+
+// Ack removes a delivery from the processing list and its lease
+func (q *Queue) Ack(ctx context.Context, d *Delivery) error {
+	pipe := q.client.TxPipeline()
+	pipe.LRem(ctx, q.processingKey, 1, d.Raw)
+	pipe.ZRem(ctx, q.leasesKey, d.Raw)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to ack job: %w", err)
+	}
+	return nil
+}
+
+// Fail acknowledges a failed delivery and either schedules a retry with
+// exponential backoff or moves it to the dead-letter list. It reports whether
+// the job was dead-lettered.
+func (q *Queue) Fail(ctx context.Context, d *Delivery, jobErr error) (bool, error) {
+	next := d.Envelope
+	next.Attempt++
+	next.LastError = jobErr.Error()
+
+	raw, err := json.Marshal(next)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	dead := next.Attempt > next.MaxRetries
+
+	pipe := q.client.TxPipeline()
+	pipe.LRem(ctx, q.processingKey, 1, d.Raw)
+	pipe.ZRem(ctx, q.leasesKey, d.Raw)
+	if dead {
+		pipe.LPush(ctx, q.deadKey, raw)
+	} else {
+		runAt := q.now().Add(Backoff(next.Attempt))
+		pipe.ZAdd(ctx, q.scheduledKey, redis.Z{Score: float64(runAt.UnixMilli()), Member: raw})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return dead, nil
+}
+
+// PromoteDue moves scheduled jobs whose run time has passed onto the ready list
+func (q *Queue) PromoteDue(ctx context.Context, limit int) (int, error) {
+	n, err := promoteScript.Run(ctx, q.client, []string{q.scheduledKey, q.readyKey},
+		strconv.FormatInt(q.now().UnixMilli(), 10), limit).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to promote scheduled jobs: %w", err)
+	}
+	return n, nil
+}
+
+// ReapExpired redelivers jobs whose lease ran out, e.g. after a worker crash
+func (q *Queue) ReapExpired(ctx context.Context) (int, error) {
+	n, err := reapScript.Run(ctx, q.client, []string{q.leasesKey, q.processingKey, q.readyKey},
+		strconv.FormatInt(q.now().UnixMilli(), 10)).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap expired jobs: %w", err)
+	}
+	return n, nil
+}
+
+// Depth is the length of each queue list
+type Depth struct {
+	Ready      int64 `json:"ready"`
+	Processing int64 `json:"processing"`
+	Scheduled  int64 `json:"scheduled"`
+	Dead       int64 `json:"dead"`
+}
+
+// Depth returns the current queue lengths
+func (q *Queue) Depth(ctx context.Context) (Depth, error) {
+	pipe := q.client.Pipeline()
+	ready := pipe.LLen(ctx, q.readyKey)
+	processing := pipe.LLen(ctx, q.processingKey)
+	scheduled := pipe.ZCard(ctx, q.scheduledKey)
+	dead := pipe.LLen(ctx, q.deadKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Depth{}, fmt.Errorf("failed to read queue depth: %w", err)
+	}
+	return Depth{
+		Ready:      ready.Val(),
+		Processing: processing.Val(),
+		Scheduled:  scheduled.Val(),
+		Dead:       dead.Val(),
+	}, nil
+}
+
+// DeadLetters returns up to limit dead-lettered jobs, newest first
+func (q *Queue) DeadLetters(ctx context.Context, limit int64) ([]Envelope, error) {
+	raws, err := q.client.LRange(ctx, q.deadKey, 0, limit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead letters: %w", err)
+	}
+	envelopes := make([]Envelope, 0, len(raws))
+	for _, raw := range raws {
+		var e Envelope
+		if err := json.Unmarshal([]byte(raw), &e); err == nil {
+			envelopes = append(envelopes, e)
+		}
+	}
+	return envelopes, nil
+}
@@ -0,0 +1,247 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeClock is a settable clock, safe for the pool's goroutines
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestQueue returns a queue on miniredis whose time is the returned clock
+func newTestQueue(t *testing.T) (*Queue, *fakeClock, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)}
+	q := NewQueue(client, "test:")
+	q.now = clock.Now
+	return q, clock, server
+}
+
+func checkDepth(t *testing.T, q *Queue, want Depth) {
+	t.Helper()
+
+	got, err := q.Depth(context.Background())
+	if err != nil {
+		t.Fatalf("Depth() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Depth() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFetchLeasesAtomically(t *testing.T) {
+	q, clock, server := newTestQueue(t)
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, New("email.send", map[string]string{"to": "a@example.com"}, 3)); err != nil {
+		t.Fatal(err)
+	}
+	d, err := q.Fetch(ctx, time.Second)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if d.Name != "email.send" || string(d.Payload) != `{"to":"a@example.com"}` || d.MaxRetries != 3 || d.Attempt != 0 {
+		t.Errorf("Fetch() = %+v", d.Envelope)
+	}
+
+	// The job is in processing together with its lease
+	checkDepth(t, q, Depth{Processing: 1})
+	score, err := server.ZScore(q.leasesKey, d.Raw)
+	if err != nil {
+		t.Fatalf("fetched job has no lease: %v", err)
+	}
+	if want := clock.Now().Add(DEFAULT_VISIBILITY_TIMEOUT).UnixMilli(); int64(score) != want {
+		t.Errorf("lease deadline = %d, want %d", int64(score), want)
+	}
+
+	if err := q.Ack(ctx, d); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	checkDepth(t, q, Depth{})
+	if members, _ := server.ZMembers(q.leasesKey); len(members) != 0 {
+		t.Errorf("leases after Ack = %q", members)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	q, _, _ := newTestQueue(t)
+
+	start := time.Now()
+	if _, err := q.Fetch(context.Background(), 250*time.Millisecond); !errors.Is(err, ErrNoJob) {
+		t.Errorf("Fetch() of an empty queue error = %v, want ErrNoJob", err)
+	}
+	if waited := time.Since(start); waited < 250*time.Millisecond {
+		t.Errorf("Fetch() returned after %s, want it to wait for the timeout", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := q.Fetch(ctx, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Fetch() with a cancelled context error = %v", err)
+	}
+}
+
+func TestFetchWaitsForEnqueue(t *testing.T) {
+	q, _, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		q.Enqueue(ctx, New("late", nil, 0))
+	}()
+	d, err := q.Fetch(ctx, 2*time.Second)
+	if err != nil || d.Name != "late" {
+		t.Errorf("Fetch() = %v, %v; want the job enqueued while waiting", d, err)
+	}
+}
+
+func TestFetchDeadLettersUnreadableJobs(t *testing.T) {
+	q, _, server := newTestQueue(t)
+
+	server.Lpush(q.readyKey, "not json")
+	if _, err := q.Fetch(context.Background(), time.Second); err == nil {
+		t.Error("Fetch() of an unreadable job error = nil")
+	}
+	checkDepth(t, q, Depth{Dead: 1})
+}
+
+func TestFailRetriesWithBackoff(t *testing.T) {
+	q, clock, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	if err := q.Enqueue(ctx, New("domain.verify", nil, 2)); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		d, err := q.Fetch(ctx, time.Second)
+		if err != nil {
+			t.Fatalf("attempt %d: Fetch() error = %v", attempt, err)
+		}
+		if d.Attempt != attempt-1 {
+			t.Errorf("attempt %d: delivery Attempt = %d", attempt, d.Attempt)
+		}
+		dead, err := q.Fail(ctx, d, errors.New("dns not ready"))
+		if err != nil || dead {
+			t.Fatalf("attempt %d: Fail() = %v, %v; want a retry", attempt, dead, err)
+		}
+		checkDepth(t, q, Depth{Scheduled: 1})
+
+		// Not before the backoff has passed
+		clock.Advance(Backoff(attempt) - time.Millisecond)
+		if n, _ := q.PromoteDue(ctx, 10); n != 0 {
+			t.Fatalf("attempt %d: promoted %d jobs before the backoff passed", attempt, n)
+		}
+		clock.Advance(time.Millisecond)
+		if n, _ := q.PromoteDue(ctx, 10); n != 1 {
+			t.Fatalf("attempt %d: promoted %d jobs after the backoff, want 1", attempt, n)
+		}
+		checkDepth(t, q, Depth{Ready: 1})
+	}
+
+	d, err := q.Fetch(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead, err := q.Fail(ctx, d, errors.New("dns still not ready"))
+	if err != nil || !dead {
+		t.Fatalf("Fail() after the last retry = %v, %v; want dead-lettered", dead, err)
+	}
+	checkDepth(t, q, Depth{Dead: 1})
+
+	letters, err := q.DeadLetters(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Name != "domain.verify" || letters[0].Attempt != 3 || letters[0].LastError != "dns still not ready" {
+		t.Errorf("DeadLetters() = %+v", letters)
+	}
+}
+
+func TestEnqueueIn(t *testing.T) {
+	q, clock, _ := newTestQueue(t)
+	ctx := context.Background()
+
+	if err := q.EnqueueIn(ctx, New("reminder", nil, 0), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Minute)
+	if n, _ := q.PromoteDue(ctx, 10); n != 0 {
+		t.Errorf("promoted %d jobs an hour early", n)
+	}
+	clock.Advance(time.Minute)
+	if n, _ := q.PromoteDue(ctx, 10); n != 1 {
+		t.Errorf("promoted %d jobs when due, want 1", n)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	q, clock, _ := newTestQueue(t)
+	q.WithVisibilityTimeout(time.Minute)
+	ctx := context.Background()
+
+	q.Enqueue(ctx, New("crashed", nil, 0))
+	q.Enqueue(ctx, New("acked", nil, 0))
+	crashed, _ := q.Fetch(ctx, time.Second)
+	acked, _ := q.Fetch(ctx, time.Second)
+	if err := q.Ack(ctx, acked); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := q.ReapExpired(ctx); n != 0 {
+		t.Errorf("reaped %d jobs before their lease expired", n)
+	}
+	clock.Advance(time.Minute + time.Millisecond)
+	if n, _ := q.ReapExpired(ctx); n != 1 {
+		t.Errorf("reaped %d jobs after the lease expired, want 1", n)
+	}
+	checkDepth(t, q, Depth{Ready: 1})
+
+	d, err := q.Fetch(ctx, time.Second)
+	if err != nil || d.ID != crashed.ID {
+		t.Errorf("Fetch() after reaping = %v, %v; want the crashed job again", d, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, BASE_RETRY_DELAY},
+		{1, BASE_RETRY_DELAY},
+		{2, 2 * BASE_RETRY_DELAY},
+		{4, 8 * BASE_RETRY_DELAY},
+		{20, MAX_RETRY_DELAY},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
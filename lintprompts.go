@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/utils"
+)
+
+// runLintPrompts implements the lint-prompts subcommand, checking every
+// template under the config directory's prompts for unknown placeholders:
+//
+//	awning-backend lint-prompts
+//
+// Each problem is printed as file:line. It returns the exit code, 1 when a
+// template has problems or can't be read.
+func runLintPrompts(cfgDir string, cfg *common.Config) int {
+	known := utils.KnownPromptVariables(cfg.PromptVariables)
+	promptsDir := path.Join(cfgDir, "prompts")
+
+	var checked, problems int
+	err := filepath.WalkDir(promptsDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(file, ".md") {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		checked++
+		for _, problem := range utils.LintPromptTemplate(file, string(data), known) {
+			fmt.Println(problem)
+			problems++
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to read prompt templates", "dir", promptsDir, "error", err)
+		return 1
+	}
+
+	slog.Info("Prompt templates checked", "dir", promptsDir, "templates", checked, "problems", problems)
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
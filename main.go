@@ -2,76 +2,45 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	cryptoRand "crypto/rand"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/pem"
+	"errors"
+	"flag"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"syscall"
+	"time"
 
 	"awning-backend/common"
 	"awning-backend/db"
-	"awning-backend/handlers"
-	"awning-backend/middleware"
+	"awning-backend/jobs"
+	"awning-backend/metrics"
 	"awning-backend/processors"
 	"awning-backend/sections"
+	"awning-backend/sections/common/audit"
 	"awning-backend/sections/common/auth"
-	"awning-backend/sections/common/users"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/common/webhooks"
 	"awning-backend/sections/models"
-	"awning-backend/sections/tenant/account"
-	"awning-backend/sections/tenant/chat"
 	"awning-backend/sections/tenant/domains"
-	"awning-backend/sections/tenant/filesystem"
 	"awning-backend/sections/tenant/images"
-	"awning-backend/sections/tenant/payment"
-	"awning-backend/sections/tenant/profile"
+	"awning-backend/serverbuilder"
 	"awning-backend/services"
+	"awning-backend/services/ai"
 	"awning-backend/storage"
 	"awning-backend/utils"
 
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
-// VertexClientAdapter adapts the main package VertexOpenAIClient to both handlers.VertexClient
-// and sections.VertexClient interfaces
-type VertexClientAdapter struct {
-	client *VertexOpenAIClient
-}
-
-func (a *VertexClientAdapter) GenerateContentStream(ctx context.Context, prompt string, callback func(handlers.StreamEvent) error) error {
-	return a.client.GenerateContentStream(ctx, prompt, func(event StreamEvent) error {
-		// Convert main.StreamEvent to handlers.StreamEvent
-		return callback(handlers.StreamEvent{
-			Type:    event.Type,
-			Content: event.Content,
-		})
-	})
-}
-
-// GenerateContentStreamSections adapts to sections.VertexClient interface
-func (a *VertexClientAdapter) GenerateContentStreamSections(ctx context.Context, prompt string, callback func(sections.StreamEvent) error) error {
-	return a.client.GenerateContentStream(ctx, prompt, func(event StreamEvent) error {
-		return callback(sections.StreamEvent{
-			Type:    event.Type,
-			Content: event.Content,
-		})
-	})
-}
-
-// sectionsVertexAdapter wraps VertexClientAdapter to implement sections.VertexClient
-type sectionsVertexAdapter struct {
-	adapter *VertexClientAdapter
-}
-
-func (s *sectionsVertexAdapter) GenerateContentStream(ctx context.Context, prompt string, callback func(sections.StreamEvent) error) error {
-	return s.adapter.GenerateContentStreamSections(ctx, prompt, callback)
-}
+// shutdownTimeout bounds how long in-flight requests and jobs may run after
+// a shutdown signal
+const shutdownTimeout = 30 * time.Second
 
 func getEnv(key, fallback string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -83,56 +52,23 @@ func getEnv(key, fallback string) string {
 func main() {
 	ctx := context.Background()
 
-	// If called with geneckey argument, generate a keypair and exit
+	// If called with geneckey argument, generate a keypair and exit; kept
+	// for scripts predating gen-jwt-key, which works the same
 	if len(os.Args) == 2 && os.Args[1] == "geneckey" {
-		// privateKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
-		// if err != nil {
-		// 	slog.Error("Failed to generate key pair", "error", err)
-		// 	os.Exit(1)
-		// }
-		// slog.Info("Generated JWT key pair")
-		// // slog.Info("Private Key (base64):", "key", auth.EncodePrivateKeyToBase64(privateKey))
-		// // slog.Info("Public Key (base64):", "key", auth.EncodePublicKeyToBase64(publicKey))
-		// slog.Info("Private Key (PEM):", "key", auth.EncodePrivateKeyToPEM(privateKey))
-		// os.Exit(0)
-
-		// Generate ECDSA P-521 key pair
-		privateKey, err := ecdsa.GenerateKey(elliptic.P521(), cryptoRand.Reader)
+		privB64, pubB64, _, err := generateJWTKey()
 		if err != nil {
-			slog.Error("Failed to generate ECDSA key pair", "error", err)
+			slog.Error("Failed to generate key pair", "error", err)
 			os.Exit(1)
 		}
-		publicKey := &privateKey.PublicKey
-
-		// Encode keys to PEM format
-		privBytes, err := x509.MarshalECPrivateKey(privateKey)
-		if err != nil {
-			slog.Error("Failed to marshal private key", "error", err)
-			os.Exit(1)
-		}
-		privPem := pem.EncodeToMemory(&pem.Block{
-			Type:  "EC PRIVATE KEY",
-			Bytes: privBytes,
-		})
-		privB64 := base64.StdEncoding.EncodeToString(privPem)
-
-		pubBytes, err := x509.MarshalPKIXPublicKey(publicKey)
-		if err != nil {
-			slog.Error("Failed to marshal public key", "error", err)
-			os.Exit(1)
-		}
-		pubPem := pem.EncodeToMemory(&pem.Block{
-			Type:  "PUBLIC KEY",
-			Bytes: pubBytes,
-		})
-		pubB64 := base64.StdEncoding.EncodeToString(pubPem)
-
 		slog.Info("Generated ECDSA P-521 key pair")
 		slog.Info("Private Key (BASE64'd PEM):\n" + privB64)
 		slog.Info("Public Key (BASE64'd PEM):\n" + pubB64)
 		os.Exit(0)
 	}
 
+	checkConfig := flag.Bool("check-config", false, "load and validate the config, then exit")
+	flag.Parse()
+
 	// Set up structured logging with debug level
 	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})))
 
@@ -154,7 +90,34 @@ func main() {
 		os.Exit(1)
 	}
 
-	slog.Info("cfg: ", slog.Any("config", cfg))
+	if err := cfg.Validate(); err != nil {
+		var problems common.ValidationErrors
+		if errors.As(err, &problems) {
+			for _, problem := range problems {
+				slog.Error("Invalid config", "field", problem.Field, "error", problem.Message)
+			}
+		} else {
+			slog.Error("Invalid config", "error", err)
+		}
+		os.Exit(1)
+	}
+
+	if *checkConfig {
+		slog.Info("Config is valid")
+		os.Exit(0)
+	}
+
+	slog.Info("Effective config (secrets redacted)", slog.Any("config", cfg.Redacted()))
+
+	// Check the prompt templates for CI and exit
+	if flag.Arg(0) == "lint-prompts" {
+		os.Exit(runLintPrompts(cfgDir, cfg))
+	}
+
+	// Operational subcommands only need the config and the database
+	if run, ok := opsCommands[flag.Arg(0)]; ok {
+		os.Exit(run(ctx, flag.Args()[1:], cfg))
+	}
 
 	// promptName := getEnv("PROMPT_NAME", common.DEFAULT_PROMPT_NAME)
 
@@ -189,13 +152,43 @@ func main() {
 	}
 	slog.Info("Prompt template loaded successfully")
 
+	for _, e := range cfg.PromptExperiments {
+		variantFile := path.Join(cfgDir, "prompts", promptType, e.Template+"-base.md")
+		if err := promptBuilder.LoadVariant(e.Name, variantFile); err != nil {
+			slog.Error("Failed to load prompt experiment template", "experiment", e.Name, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Prompt experiment loaded", "experiment", e.Name, "template", e.Template, "traffic_percent", e.TrafficPercent)
+	}
+
+	// Pages of multi-page sites use their own request template when present
+	pagePromptFile := path.Join(cfgDir, "prompts", promptType, promptName+"-page.md")
+	if _, err := os.Stat(pagePromptFile); err == nil {
+		if err := promptBuilder.LoadPageTemplate(pagePromptFile); err != nil {
+			slog.Error("Failed to load page prompt template", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("Page prompt template loaded", "file", pagePromptFile)
+	}
+
+	// Unknown placeholders would reach the model as literal braces
+	if problems := promptBuilder.Lint(utils.KnownPromptVariables(cfg.PromptVariables)); len(problems) > 0 {
+		for _, problem := range problems {
+			slog.Warn("Unknown prompt placeholder", "template", problem.Template, "line", problem.Line, "placeholder", problem.Placeholder)
+		}
+		if cfg.PromptLintStrict {
+			slog.Error("Prompt templates have unknown placeholders", "count", len(problems))
+			os.Exit(1)
+		}
+	}
+
 	plans, err := common.LoadPlans(cfgDir)
 	if err != nil {
 		slog.Error("Failed to load plans", "error", err)
 		os.Exit(1)
 	}
 
-	env := getEnv("APP_ENV", "production")
+	env := getEnv("APP_ENV", common.DEFAULT_APP_ENV)
 
 	// Require api key and secret in production
 	// if env == "production" && (cfg.ApiKey == "" || cfg.ApiKeySecret == "") {
@@ -231,22 +224,70 @@ func main() {
 	}
 
 	// Initialize Vertex AI client using service account credentials
-	if err := InitGlobalVertexOpenAIClient(ctx, cfg, credData); err != nil {
+	vertexClient, err := ai.NewVertexOpenAIClient(ctx, cfg, credData)
+	if err != nil {
 		slog.Error("Failed to initialize Vertex AI client", "error", err)
 		os.Exit(1)
 	}
 
 	// Initialize Redis client
-	redisClient, err := storage.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, 0)
+	redisClient, err := storage.NewRedisClientWithOptions(storage.RedisOptions{
+		Mode:             cfg.RedisMode,
+		Addrs:            strings.Split(cfg.RedisAddr, ","),
+		MasterName:       cfg.RedisMasterName,
+		SentinelAddrs:    cfg.RedisSentinelAddrs,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		Password:         cfg.RedisPassword,
+		TLS:              cfg.RedisTLS,
+		TLSSkipVerify:    cfg.RedisTLSSkipVerify,
+		PoolSize:         cfg.RedisPoolSize,
+		MinIdleConns:     cfg.RedisMinIdleConns,
+		DialTimeout:      time.Duration(cfg.RedisDialTimeoutMs) * time.Millisecond,
+		ReadTimeout:      time.Duration(cfg.RedisReadTimeoutMs) * time.Millisecond,
+		WriteTimeout:     time.Duration(cfg.RedisWriteTimeoutMs) * time.Millisecond,
+	})
 	if err != nil {
 		slog.Error("Failed to initialize Redis client", "error", err)
 		os.Exit(1)
 	}
 	defer redisClient.Close()
 
+	// Feature flags switch features off at runtime; maintenance_mode rejects
+	// non-GET tenant requests
+	featureFlags := flags.New(redisClient, cfg.FeatureFlags, time.Duration(cfg.MaintenanceRetryAfterSeconds)*time.Second)
+	auth.SetMaintenanceChecker(featureFlags)
+
+	// Tenants are labelled in metrics by schema only when allowlisted; the
+	// allowlist can be changed at runtime
+	tenantLabels := metrics.NewTenantLabels(redisClient, cfg.MetricsTenantAllowlist, cfg.MetricsTenantBuckets, cfg.MetricsMaxTenantLabels)
+	metrics.SetTenantLabels(tenantLabels)
+	if cfg.MetricsEnabled {
+		go tenantLabels.Run(ctx)
+	}
+
+	// Background jobs run from a Redis queue; sections register handlers by
+	// job name and the pool is started once routes are set up
+	jobQueue := jobs.NewQueue(redisClient.Client(), cfg.RedisPrefix)
+	jobPool := jobs.NewPool(jobQueue, cfg.JobsConcurrency, jobs.Hooks{
+		QueueDepth: func(depth jobs.Depth) {
+			slog.Debug("Job queue depth", "ready", depth.Ready, "processing", depth.Processing, "scheduled", depth.Scheduled, "dead", depth.Dead)
+		},
+	})
+
+	// Pick the server mode; simple skips the database even when it's set
+	databaseURL := getEnv("DATABASE_URL", "")
+	mode, err := serverbuilder.ResolveMode(cfg.ServerMode, databaseURL != "", getEnv("JWT_PRIVATE_KEY", "") != "")
+	if err != nil {
+		slog.Error("Invalid server mode", "error", err)
+		os.Exit(1)
+	}
+	if cfg.ServerMode == string(serverbuilder.ModeSimple) && databaseURL != "" {
+		slog.Info("server_mode is simple - ignoring DATABASE_URL")
+		databaseURL = ""
+	}
+
 	// Initialize database connection (optional - only if DATABASE_URL is set)
 	var database *db.DB
-	databaseURL := getEnv("DATABASE_URL", "")
 	if databaseURL != "" {
 		slog.Info("Connecting to database")
 		database, err = db.Connect(databaseURL)
@@ -256,18 +297,7 @@ func main() {
 		}
 
 		// Register and migrate shared models
-		if err := database.RegisterModels(ctx,
-			&models.Tenant{},
-			&models.User{},
-			&models.UserTenant{},
-			&models.Payment{},
-			&models.Subscription{},
-			// Tenant models
-			&models.TenantFilesystem{},
-			&models.TenantChat{},
-			&models.TenantProfile{},
-			&models.TenantDomain{},
-		); err != nil {
+		if err := registerModels(ctx, database); err != nil {
 			slog.Error("Failed to register models", "error", err)
 			os.Exit(1)
 		}
@@ -276,6 +306,17 @@ func main() {
 			slog.Error("Failed to migrate shared models", "error", err)
 			os.Exit(1)
 		}
+		// Bring existing tenant schemas up to date
+		var tenantSchemas []string
+		if err := database.DB.Model(&models.Tenant{}).Pluck("schema_name", &tenantSchemas).Error; err != nil {
+			slog.Error("Failed to list tenants", "error", err)
+			os.Exit(1)
+		}
+		if err := database.MigrateExistingTenants(ctx, tenantSchemas); err != nil {
+			slog.Error("Failed to migrate tenant schemas", "error", err)
+			os.Exit(1)
+		}
+
 		slog.Info("Database connected and shared models migrated")
 	} else {
 		slog.Info("No DATABASE_URL set - running in legacy mode without PostgreSQL")
@@ -296,170 +337,168 @@ func main() {
 
 	// Create processors service
 	processorsSvc := services.NewProcessors(cfg)
+	processorsSvc.SetHooks(services.ProcessorHooks{
+		ProcessorFailed: func(name, failure string, err error) {
+			slog.Warn("Processor failed", "processor", name, "failure", failure, "error", err)
+		},
+	})
 
-	// Initialize chat handler with adapter (legacy handler)
-	vertexAdapter := &VertexClientAdapter{client: GlobalVertexOpenAIClient}
-	// chatHandler := handlers.NewChatHandler(cfg, redisClient, promptBuilder, vertexAdapter, processorsSvc)
+	// Initialize image store for rehosting generated images (optional)
+	imageStore, err := services.NewImageStoreFromConfig(ctx, cfg, credData)
+	if err != nil {
+		slog.Error("Failed to initialize image store", "error", err)
+		os.Exit(1)
+	}
+	if imageStore != nil {
+		slog.Info("Image store initialized", "store", imageStore.Name())
+	}
+
+	// Initialize moderation of user messages (optional)
+	moderationSvc, err := services.NewModerationServiceFromConfig(ctx, cfg, credData)
+	if err != nil {
+		slog.Error("Failed to initialize moderation", "error", err)
+		os.Exit(1)
+	}
+	if moderationSvc != nil {
+		slog.Info("Moderation initialized", "provider", moderationSvc.Name(), "mode", cfg.ModerationMode)
+	}
 
-	// var imageHandler *handlers.ImageHandler
 	var unsplashSvc *services.UnsplashService
 
 	// Initialize Unsplash API client (if API key provided)
 	if accessKey, secretKey := cfg.UnsplashAPIAccessKey, cfg.UnsplashAPISecretKey; accessKey != "" && secretKey != "" {
-		slog.Info("Unsplash API keys provided, initializing Unsplash service and image handler")
+		slog.Info("Unsplash API keys provided, initializing Unsplash service")
 
 		unsplashSvc = services.NewUnsplashService(accessKey, secretKey)
 
-		// Initialize Unsplash handler
-		// imageHandler = handlers.NewImageHandler(cfg, unsplashSvc)
+		// Stop searching short of the hourly limit, standing in cached
+		// searches and fallback images until it resets
+		unsplashSvc.SetQuotaReserve(cfg.UnsplashQuotaReserve)
+		if cfg.UnsplashCacheTTLHours > 0 {
+			unsplashSvc.SetSearchCache(redisClient, time.Duration(cfg.UnsplashCacheTTLHours)*time.Hour)
+		}
+		unsplashSvc.SetHooks(services.UnsplashHooks{
+			QuotaUpdated: func(quota services.UnsplashQuota) {
+				slog.Debug("Unsplash quota", "remaining", quota.Remaining, "limit", quota.Limit, "exhausted", quota.Exhausted)
+			},
+		})
+
+		// Processor settings were checked by cfg.Validate
+		headerSettings, _ := cfg.HeaderProcessorSettings()
+		imageSettings, _ := cfg.ImageProcessorSettings()
+		cleanupSettings, _ := cfg.CleanupProcessorSettings()
 
 		// Register header processor
-		processorsSvc.RegisterProcessor("header", processors.NewHeaderProcessor(cfg))
+		processorsSvc.RegisterProcessor("header", processors.NewHeaderProcessor(headerSettings))
 
-		// Register image processor
-		processorsSvc.RegisterProcessor("image", processors.NewImageProcessor(cfg, unsplashSvc))
+		// Register image processor (rehosting selected images when an image store is configured)
+		var rehoster *services.ImageRehoster
+		if imageStore != nil {
+			rehoster = services.NewImageRehoster(imageStore)
+		}
+		// Prefer the tenant's own uploads when the database is available
+		var uploads services.UploadedImageSource
+		if database != nil {
+			uploads = images.NewUploadedImageSource(database)
+		}
+		processorsSvc.RegisterProcessor("image", processors.NewImageProcessor(imageSettings, unsplashSvc, rehoster, uploads))
+		processorsSvc.AddGate("image", "skipped, the image_processing flag is off", func(ctx context.Context) bool {
+			return featureFlags.Enabled(ctx, flags.ImageProcessing)
+		})
+		processorsSvc.AddGate("image", "skipped, the output was truncated", func(ctx context.Context) bool {
+			return !services.TruncatedOutput(ctx)
+		})
 
 		// Register cleanup processor
-		processorsSvc.RegisterProcessor("cleanup", processors.NewCleanupProcessor(cfg))
+		processorsSvc.RegisterProcessor("cleanup", processors.NewCleanupProcessor(cleanupSettings))
 
 	} else {
 		slog.Info("No Unsplash API key provided - skipping Unsplash service and image handler initialization")
 	}
 
-	// Initialize Gin router
-	r := gin.Default()
+	// Register placeholder processor
+	placeholderSettings, _ := cfg.PlaceholderProcessorSettings()
+	processorsSvc.RegisterProcessor("placeholders", processors.NewPlaceholderProcessor(placeholderSettings))
 
-	trustedProxies := getEnv("TRUSTED_PROXIES", "")
+	// Register contact processor, filling in the tenant profile's contact details
+	processorsSvc.RegisterProcessor("contact", processors.NewContactProcessor())
 
-	if env != "development" && trustedProxies == "" {
-		slog.Error("In production mode, TRUSTED_PROXIES must be set")
-		os.Exit(1)
-	} else if trustedProxies != "" {
-		slog.Info("Setting trusted proxies", "proxies", trustedProxies)
-		proxies := strings.Split(trustedProxies, ",")
-		if err := r.SetTrustedProxies(proxies); err != nil {
-			slog.Error("Failed to set trusted proxies", "error", err)
-			os.Exit(1)
-		}
-	} else {
-		slog.Warn("No trusted proxies set (TRUSTED_PROXIES not defined)")
+	// Re-run processors over saved sites and exit
+	if flag.Arg(0) == "reprocess" {
+		os.Exit(runReprocess(ctx, flag.Args()[1:], cfg, database, redisClient, processorsSvc, jwtManager))
 	}
 
-	// Configure CORS
-	corsConfig := cors.DefaultConfig()
+	// Check stored publications against their hashes and signatures and exit
+	if flag.Arg(0) == "verify-publications" {
+		os.Exit(runVerifyPublications(ctx, flag.Args()[1:], cfg, database, jwtManager))
+	}
 
-	corsOrigins := getEnv("CORS_ORIGINS", "")
-	if env != "development" && corsOrigins == "" {
-		slog.Error("In production mode, CORS_ORIGINS must be set")
-		os.Exit(1)
-	} else if corsOrigins != "" {
-		slog.Info("CORS origins set from CORS_ORIGINS")
-		corsConfig.AllowOrigins = strings.Split(corsOrigins, ",")
-	} else {
-		slog.Warn("Using default origin function in non-production mode (CORS_ORIGINS not defined)")
-		corsConfig.AllowOriginFunc = func(origin string) bool {
-			// slog.Info("CORS origin check", "origin", origin)
-			// fmt.Println("CORS origin check:", origin)
-			if origin == "http://localhost" || strings.HasPrefix(origin, "http://localhost:") {
-				return true
-			}
-			return false
-		}
+	// Shared dependencies; the database-backed ones stay nil without one
+	deps := &sections.Dependencies{
+		Config:        cfg,
+		DB:            database,
+		Redis:         redisClient,
+		Chats:         redisClient,
+		ChatLocks:     redisClient,
+		KV:            redisClient,
+		PromptBuilder: promptBuilder,
+		VertexClient:  vertexClient,
+		ProcessorsSvc: processorsSvc,
+		UnsplashSvc:   unsplashSvc,
+		ImageStore:    imageStore,
+		Responses:     services.NewLocalResponseStore(services.SAVED_RESPONSES_DIR),
+		Moderation:    moderationSvc,
+		Plans:         plans,
+		Jobs:          jobQueue,
+		Flags:         featureFlags,
+		MetricsLabels: tenantLabels,
+		JWT:           jwtManager,
 	}
+	if database != nil {
+		deps.Sites = sites.NewResolver(cfg, database, redisClient)
+		deps.Settings = settings.NewStore(database, redisClient)
 
-	// corsConfig.AllowOriginFunc = func(origin string) bool {
-	// 	if origin == "http://localhost" || strings.HasPrefix(origin, "http://localhost:") {
-	// 		return true
-	// 	}
-	// 	return false
-	// }
+		// Block tenants that are pending deletion
+		auth.SetTenantStatusChecker(deps.Sites)
 
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Awning-Frontend-Key"}
-	r.Use(cors.New(corsConfig))
-
-	// // Require api key and secret for all requests
-	// r.Use(middleware.APIKeyAuthMiddleware(func(ctx context.Context, providedKey, providedSecret string) (context.Context, error) {
-	// 	if providedKey == cfg.ApiKey && providedSecret == cfg.ApiKeySecret {
-	// 		return ctx, nil
-	// 	}
-	// 	return ctx, middleware.ErrMissingAPICredentials
-	// }))
-
-	// Allow frontend API key for all requests
-	// r.Use(middleware.APIFrontendKeyAuthMiddleware(cfg.ApiFrontendKey))
-
-	// publicRoutes := r.Group("/")
-
-	frontendRoutes := r.Group("/")
-	frontendRoutes.Use(middleware.APIFrontendKeyAuthMiddleware(cfg.ApiFrontendKey))
-
-	privateRoutes := r.Group("/")
-	privateRoutes.Use(middleware.APIFrontendKeyAuthMiddleware(cfg.ApiFrontendKey))
-	privateRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
-	privateRoutes.Use(auth.TenantFromHeaderMiddleware(auth.DefaultTenantMiddlewareConfig()))
-
-	callbackRoutes := r.Group("/callbacks")
-	webhookRoutes := r.Group("/webhooks")
-
-	slog.Info("Database: ", slog.Any("database", database))
-	slog.Info("JWT Manager: ", slog.Any("jwt_manager", jwtManager))
-
-	// Initialize new sections-based routes if database is available
-	if database != nil && jwtManager != nil {
-		slog.Info("Initializing multi-tenant sections")
-
-		// Create shared dependencies for new handlers
-		deps := &sections.Dependencies{
-			Config:        cfg,
-			DB:            database,
-			Redis:         redisClient,
-			PromptBuilder: promptBuilder,
-			VertexClient:  &sectionsVertexAdapter{adapter: vertexAdapter},
-			ProcessorsSvc: processorsSvc,
-			UnsplashSvc:   unsplashSvc,
-		}
+		// Tell clients which timezone to display the tenant's times in
+		auth.SetTenantTimezoneLookup(deps.Sites)
+	}
 
-		// Register user routes (public - no tenant context needed)
-		users.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	serverOpts := serverbuilder.Options{
+		Mode:        mode,
+		Env:         env,
+		CORSOrigins: getEnv("CORS_ORIGINS", ""),
+		PublicDir:   os.Getenv("APP_PUBLIC"),
+		PublicProxy: os.Getenv("APP_PUBLIC_PROXY"),
+		JWTManager:  jwtManager,
+		Jobs:        jobPool,
+	}
 
-		// Register OAuth routes if configured
-		if cfg.OauthGoogleClientID != "" || cfg.OauthFacebookClientID != "" || cfg.OauthTikTokClientID != "" {
-			slog.Info("OAuth client IDs provided, registering OAuth routes")
-			oauthConfig := users.NewOAuthConfig(cfg)
-			users.RegisterOAuthRoutes(frontendRoutes, callbackRoutes, deps, jwtManager, oauthConfig)
-			slog.Info("OAuth routes registered")
+	if mode == serverbuilder.ModeFull {
+		chatStore, err := sections.NewChatStore(cfg, database, redisClient)
+		if err != nil {
+			slog.Error("Failed to initialize chat store", "error", err)
+			os.Exit(1)
 		}
+		deps.Chats = chatStore
+		deps.Webhooks = webhooks.NewEmitter(database, jobQueue)
+		deps.Notifications = notifications.NewService(database, redisClient, deps.Settings, nil)
 
 		// Initialize Stripe service if configured
-		var stripeSvc *services.StripeService
 		stripeSecretKey := getEnv("STRIPE_SECRET_KEY", "")
 		stripeWebhookSecret := getEnv("STRIPE_WEBHOOK_SECRET", "")
 		if stripeSecretKey != "" && stripeWebhookSecret != "" {
 			slog.Info("Stripe keys provided, initializing Stripe service")
 			stripeSuccessURL := getEnv("STRIPE_SUCCESS_URL", cfg.BaseURL+"/payment/success")
 			stripeCancelURL := getEnv("STRIPE_CANCEL_URL", cfg.BaseURL+"/payment/cancel")
-			stripeSvc = services.NewStripeService(plans, stripeSecretKey, stripeWebhookSecret, stripeSuccessURL, stripeCancelURL)
+			serverOpts.Stripe = services.NewStripeService(plans, stripeSecretKey, stripeWebhookSecret, stripeSuccessURL, stripeCancelURL)
 			slog.Info("Stripe service initialized")
 		} else {
 			slog.Info("Stripe not configured - payment features disabled")
 		}
 
-		// Register tenant-scoped routes
-		// Each RegisterRoutes function creates its own route group with JWT + tenant middleware
-		chat.RegisterRoutes(frontendRoutes, deps, jwtManager)
-		images.RegisterRoutes(frontendRoutes, deps, jwtManager)
-		profile.RegisterRoutes(frontendRoutes, deps, jwtManager)
-		account.RegisterRoutes(frontendRoutes, deps, jwtManager)
-		filesystem.RegisterRoutes(frontendRoutes, deps, jwtManager)
-
-		// Register payment routes if Stripe is configured
-		if stripeSvc != nil {
-			payment.RegisterRoutes(frontendRoutes, webhookRoutes, deps, jwtManager, stripeSvc)
-			slog.Info("Payment routes registered")
-		}
-
-		// Initialize domain registrar and register domain routes
+		// Initialize domain registrar
 		registrarFactory := domains.NewRegistrarFactory()
 		registrar, err := registrarFactory.Create(&domains.RegistrarConfig{
 			Provider:  cfg.DomainRegistrarProvider,
@@ -471,46 +510,71 @@ func main() {
 		if err != nil {
 			slog.Warn("Failed to create domain registrar, domain routes will be unavailable", "error", err)
 		} else {
-			domains.RegisterRoutes(r, deps, jwtManager, registrar)
-			slog.Info("Domain routes registered", "provider", cfg.DomainRegistrarProvider)
+			serverOpts.Registrar = registrar
 		}
-
-		slog.Info("Multi-tenant sections initialized")
 	}
 
-	// Legacy API routes - streaming chat only (backward compatibility)
-	// r.POST("/api/v1/chat/stream", chatHandler.CreateChatStream)
-	// r.GET("/api/v1/chat/:id", chatHandler.GetChat)
-	// r.DELETE("/api/v1/chat/:id", chatHandler.DeleteChat)
-
-	// Image API routes
-	// if imageHandler != nil {
-	// 	r.GET("/api/v1/images/search", imageHandler.SearchPhotos)
-	// 	r.GET("/api/v1/images/photos/:id", imageHandler.GetPhoto)
-	// }
-
-	// Serve static files from APP_PUBLIC if set
-	if publicDir := os.Getenv("APP_PUBLIC"); publicDir != "" {
-		slog.Info("Serving static files", "directory", publicDir)
-		// r.Static("/assets", publicDir+"/assets")
-		// r.StaticFile("/", publicDir+"/index.html")
-		r.Static("/", publicDir)
-		r.NoRoute(func(c *gin.Context) {
-			// For SPA: serve index.html for non-API routes that don't match static files
-			if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
-				c.File(publicDir + "/index.html")
-			}
+	// Chats whose save fails are spilled and retried in the background
+	chatSpill, err := sections.NewChatSpill(cfg, redisClient)
+	if err != nil {
+		slog.Error("Failed to initialize chat spill", "error", err)
+		os.Exit(1)
+	}
+	if chatSpill != nil {
+		deps.ChatSpill = sections.NewSpillingChatStore(deps.Chats, chatSpill, cfg.ChatSpillAlertThreshold)
+		deps.ChatSpill.SetHooks(sections.ChatSpillHooks{
+			Spilled: func(chatID string, cause error) {
+				slog.Warn("Chat save spilled", "chat_id", chatID, "error", cause)
+			},
+			Recovered: func(chatID string, attempts int, pending time.Duration) {
+				slog.Info("Spilled chat save recovered", "chat_id", chatID, "attempts", attempts, "pending", pending)
+			},
+			Backlog: func(pending int) {
+				slog.Debug("Chat spill backlog", "pending", pending)
+			},
+			BacklogExceeded: func(pending, threshold int) {
+				audit.Record(ctx, database, "", nil, audit.ActionChatSpillBacklog, map[string]any{
+					"pending":   pending,
+					"threshold": threshold,
+					"spill":     cfg.ChatSpill,
+				})
+			},
 		})
-	} else if publicProxy := os.Getenv("APP_PUBLIC_PROXY"); publicProxy != "" {
-		slog.Info("Serving static files via proxy", "proxy", publicProxy)
-		r.Use(middleware.StaticProxyMiddleware(publicProxy))
-	} else {
-		slog.Info("No static file directory set (APP_PUBLIC not defined) and no proxy set (APP_PUBLIC_PROXY not defined)")
+		deps.Chats = deps.ChatSpill
+		go deps.ChatSpill.Run(ctx)
 	}
 
-	slog.Info("Server starting", "addr", cfg.ListenAddr)
-	if err := r.Run(cfg.ListenAddr); err != nil {
-		slog.Error("Failed to start server", "error", err)
+	slog.Info("Building router", "mode", mode)
+	r, err := serverbuilder.New(ctx, deps, serverOpts)
+	if err != nil {
+		slog.Error("Failed to build router", "error", err)
 		os.Exit(1)
 	}
+
+	jobPool.Start(ctx)
+
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: r}
+	go func() {
+		slog.Info("Server starting", "addr", cfg.ListenAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Stop accepting requests and let running jobs finish on SIGINT/SIGTERM
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	<-sigCtx.Done()
+	stop()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down server", "error", err)
+	}
+	if err := jobPool.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down job pool", "error", err)
+	}
 }
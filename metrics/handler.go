@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"awning-backend/db"
+	"awning-backend/middleware"
+	"awning-backend/sections/common/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the metrics and the tenant label policy
+type Handler struct {
+	logger *slog.Logger
+	labels *TenantLabels
+	db     *db.DB
+}
+
+// NewHandler creates a new metrics handler; policy changes are audited to
+// database
+func NewHandler(labels *TenantLabels, database *db.DB) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "MetricsHandler"),
+		labels: labels,
+		db:     database,
+	}
+}
+
+// UpdateTenantsRequest sets the tenants labelled by schema; null restores
+// metrics_tenant_allowlist
+type UpdateTenantsRequest struct {
+	Tenants []string `json:"tenants"`
+}
+
+// Metrics writes the counters in the Prometheus text format
+func (h *Handler) Metrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := WriteText(c.Writer); err != nil {
+		h.logger.Error("Failed to write metrics", "error", err)
+	}
+}
+
+// GetTenants returns the tenant label policy
+func (h *Handler) GetTenants(c *gin.Context) {
+	c.JSON(http.StatusOK, h.labels.Policy())
+}
+
+// UpdateTenants replaces the allowlist on every instance, without a restart,
+// and audits the change
+func (h *Handler) UpdateTenants(c *gin.Context) {
+	var req UpdateTenantsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var tenants []string
+	if req.Tenants != nil {
+		tenants = []string{}
+		for _, tenant := range req.Tenants {
+			if tenant == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tenants must not be empty strings"})
+				return
+			}
+			if !slices.Contains(tenants, tenant) {
+				tenants = append(tenants, tenant)
+			}
+		}
+	}
+	if maxLabels := h.labels.Policy().MaxLabels; len(tenants) > maxLabels {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     "too many tenants for metrics_max_tenant_labels",
+			"code":      "too_many_tenant_labels",
+			"maxLabels": maxLabels,
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before := h.labels.Policy()
+	policy, err := h.labels.Set(ctx, tenants)
+	if err != nil {
+		h.logger.Error("Failed to update metrics tenant allowlist", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update metrics tenant allowlist"})
+		return
+	}
+
+	audit.Record(ctx, h.db, "", nil, audit.ActionMetricsTenants, map[string]any{
+		"from": before.Tenants,
+		"to":   policy.Tenants,
+	})
+	h.logger.Info("Metrics tenant allowlist updated", "tenants", len(policy.Tenants), "overridden", policy.Overridden)
+
+	c.JSON(http.StatusOK, policy)
+}
+
+// RegisterRoutes registers /metrics and the admin tenant label routes,
+// authenticated with the server API key
+func RegisterRoutes(r *gin.RouterGroup, labels *TenantLabels, database *db.DB, apiKey, apiKeySecret string) {
+	handler := NewHandler(labels, database)
+	apiKeyAuth := middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret))
+
+	r.GET("/metrics", apiKeyAuth, handler.Metrics)
+
+	adminRoutes := r.Group("/api/v1/admin/metrics")
+	adminRoutes.Use(apiKeyAuth)
+	{
+		adminRoutes.GET("/tenants", handler.GetTenants)
+		adminRoutes.PUT("/tenants", handler.UpdateTenants)
+	}
+}
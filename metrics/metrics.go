@@ -0,0 +1,131 @@
+// Package metrics keeps counters and serves them in the Prometheus text
+// exposition format. Tenants are labelled through TenantLabel, which keeps
+// the number of tenant label values bounded.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	count  float64
+}
+
+var registry = struct {
+	mu       sync.Mutex
+	counters map[string]*CounterVec
+}{counters: map[string]*CounterVec{}}
+
+// NewCounterVec creates and registers a counter. Names must be unique; a
+// name registered twice panics, as for a programming error.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: map[string]*series{}}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if _, ok := registry.counters[name]; ok {
+		panic("metrics: counter " + name + " registered twice")
+	}
+	registry.counters[name] = c
+	return c
+}
+
+// Add adds delta, which must not be negative, to the series with the label
+// values, given in the order of the counter's labels
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 || len(values) != len(c.labels) {
+		return
+	}
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(values)}
+		c.series[key] = s
+	}
+	s.count += delta
+}
+
+// Inc adds 1 to the series with the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// WriteText writes every registered counter in the Prometheus text format,
+// sorted by name and label values
+func WriteText(w io.Writer) error {
+	registry.mu.Lock()
+	counters := make([]*CounterVec, 0, len(registry.counters))
+	for _, c := range registry.counters {
+		counters = append(counters, c)
+	}
+	registry.mu.Unlock()
+	slices.SortFunc(counters, func(a, b *CounterVec) int { return strings.Compare(a.name, b.name) })
+
+	var b strings.Builder
+	for _, c := range counters {
+		c.writeText(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c *CounterVec) writeText(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n", c.name, escapeHelp(c.help))
+	fmt.Fprintf(b, "# TYPE %s counter\n", c.name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := c.series[key]
+		b.WriteString(c.name)
+		if len(c.labels) > 0 {
+			b.WriteByte('{')
+			for i, label := range c.labels {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(b, "%s=\"%s\"", label, escapeLabelValue(s.values[i]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(s.count, 'g', -1, 64))
+		b.WriteByte('\n')
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
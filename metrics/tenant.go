@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// OtherTenants labels tenants outside the allowlist when there are no
+	// hash buckets, and every tenant before SetTenantLabels
+	OtherTenants = "other"
+	// NoTenant labels work done outside a tenant
+	NoTenant = "none"
+)
+
+// ReloadInterval is how often TenantLabels.Run reloads the allowlist, so
+// changes made through another instance apply within it
+const ReloadInterval = 10 * time.Second
+
+// AllowlistStore keeps the allowlist set at runtime, implemented by
+// storage.RedisClient
+type AllowlistStore interface {
+	GetMetricsTenantAllowlist(ctx context.Context) ([]string, bool, error)
+	SetMetricsTenantAllowlist(ctx context.Context, tenants []string) error
+}
+
+// TenantPolicy is how tenants are labelled
+type TenantPolicy struct {
+	// Tenants labelled by schema, unless Bucketed
+	Tenants []string `json:"tenants"`
+	// Whether Tenants was set at runtime rather than by config
+	Overridden bool `json:"overridden"`
+	// Hash buckets for the other tenants, 0 for "other"
+	Buckets   int `json:"buckets"`
+	MaxLabels int `json:"maxLabels"`
+	// Set when Tenants is over MaxLabels and every tenant is bucketed
+	Bucketed bool `json:"bucketed"`
+
+	allowed map[string]bool
+}
+
+// TenantLabels labels tenants in metrics by a policy: allowlisted tenants
+// by schema and the others by hash bucket. The allowlist from config can be
+// replaced at runtime through the store.
+type TenantLabels struct {
+	logger     *slog.Logger
+	store      AllowlistStore
+	configured []string
+	buckets    int
+	maxLabels  int
+
+	policy atomic.Pointer[TenantPolicy]
+}
+
+// NewTenantLabels creates tenant labels with config's allowlist until Run
+// or Set loads one from the store. store may be nil.
+func NewTenantLabels(store AllowlistStore, allowlist []string, buckets, maxLabels int) *TenantLabels {
+	t := &TenantLabels{
+		logger:     slog.With("service", "MetricsTenantLabels"),
+		store:      store,
+		configured: allowlist,
+		buckets:    buckets,
+		maxLabels:  maxLabels,
+	}
+	t.apply(allowlist, false)
+	return t
+}
+
+// apply makes tenants the allowlist, bucketing every tenant when there
+// are more than maxLabels
+func (t *TenantLabels) apply(tenants []string, overridden bool) *TenantPolicy {
+	policy := &TenantPolicy{
+		Tenants:    slices.Clone(tenants),
+		Overridden: overridden,
+		Buckets:    t.buckets,
+		MaxLabels:  t.maxLabels,
+		allowed:    map[string]bool{},
+	}
+	if policy.Tenants == nil {
+		policy.Tenants = []string{}
+	}
+	if len(tenants) > t.maxLabels {
+		policy.Bucketed = true
+		t.logger.Warn("Metrics tenant allowlist is over the label cap, bucketing every tenant", "tenants", len(tenants), "max_labels", t.maxLabels)
+	} else {
+		for _, tenant := range tenants {
+			policy.allowed[tenant] = true
+		}
+	}
+	t.policy.Store(policy)
+	return policy
+}
+
+// Policy returns the current policy
+func (t *TenantLabels) Policy() TenantPolicy {
+	return *t.policy.Load()
+}
+
+// Label returns the metrics label of a tenant schema: the schema when it is
+// allowlisted, otherwise a hash bucket (stable across instances and
+// restarts) or "other"
+func (t *TenantLabels) Label(tenantSchema string) string {
+	if tenantSchema == "" {
+		return NoTenant
+	}
+	policy := t.policy.Load()
+	if policy.allowed[tenantSchema] {
+		return tenantSchema
+	}
+	if policy.Buckets <= 0 {
+		return OtherTenants
+	}
+	h := fnv.New32a()
+	h.Write([]byte(tenantSchema))
+	return fmt.Sprintf("bucket_%02d", h.Sum32()%uint32(policy.Buckets))
+}
+
+// Reload loads the allowlist from the store, falling back to config's when
+// none is stored. When the store fails the current policy is kept.
+func (t *TenantLabels) Reload(ctx context.Context) error {
+	if t.store == nil {
+		return nil
+	}
+	tenants, ok, err := t.store.GetMetricsTenantAllowlist(ctx)
+	if err != nil {
+		return err
+	}
+	current := t.policy.Load()
+	if !ok {
+		tenants = t.configured
+	}
+	if current.Overridden == ok && slices.Equal(current.Tenants, tenants) {
+		return nil
+	}
+	t.apply(tenants, ok)
+	t.logger.Info("Metrics tenant allowlist reloaded", "tenants", len(tenants), "overridden", ok)
+	return nil
+}
+
+// Run reloads the allowlist every ReloadInterval until ctx is done
+func (t *TenantLabels) Run(ctx context.Context) {
+	ticker := time.NewTicker(ReloadInterval)
+	defer ticker.Stop()
+	for {
+		if err := t.Reload(ctx); err != nil {
+			t.logger.Error("Failed to reload metrics tenant allowlist", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Set stores the allowlist for every instance and applies it to this one
+// at once. nil restores config's allowlist.
+func (t *TenantLabels) Set(ctx context.Context, tenants []string) (TenantPolicy, error) {
+	if t.store != nil {
+		if err := t.store.SetMetricsTenantAllowlist(ctx, tenants); err != nil {
+			return TenantPolicy{}, err
+		}
+	}
+	if tenants == nil {
+		return *t.apply(t.configured, false), nil
+	}
+	return *t.apply(tenants, true), nil
+}
+
+var defaultTenantLabels atomic.Pointer[TenantLabels]
+
+// SetTenantLabels sets the policy TenantLabel uses
+func SetTenantLabels(t *TenantLabels) {
+	defaultTenantLabels.Store(t)
+}
+
+// TenantLabel returns the metrics label of a tenant schema under the policy
+// set with SetTenantLabels. Every tenant is "other" until one is set.
+func TenantLabel(tenantSchema string) string {
+	if t := defaultTenantLabels.Load(); t != nil {
+		return t.Label(tenantSchema)
+	}
+	if tenantSchema == "" {
+		return NoTenant
+	}
+	return OtherTenants
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestStore returns an allowlist store on an in-process Redis
+func newTestStore(t *testing.T) *storage.RedisClient {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// failingStore is an AllowlistStore that is down
+type failingStore struct{}
+
+func (failingStore) GetMetricsTenantAllowlist(ctx context.Context) ([]string, bool, error) {
+	return nil, false, errors.New("connection refused")
+}
+
+func (failingStore) SetMetricsTenantAllowlist(ctx context.Context, tenants []string) error {
+	return errors.New("connection refused")
+}
+
+func TestTenantLabelBuckets(t *testing.T) {
+	labels := NewTenantLabels(nil, []string{"tenant_big"}, 16, 50)
+
+	// Buckets are a hash of the schema, so they don't change between
+	// instances, restarts or releases
+	tests := []struct {
+		tenantSchema string
+		want         string
+	}{
+		{"tenant_big", "tenant_big"},
+		{"tenant_rye_and_co", "bucket_03"},
+		{"tenant_bobs_garage", "bucket_06"},
+		{"tenant_alice", "bucket_06"},
+		{"tenant_0001", "bucket_01"},
+		{"", NoTenant},
+	}
+	for _, tt := range tests {
+		for _, l := range []*TenantLabels{labels, NewTenantLabels(nil, []string{"tenant_big"}, 16, 50)} {
+			if got := l.Label(tt.tenantSchema); got != tt.want {
+				t.Errorf("Label(%q) = %q, want %q", tt.tenantSchema, got, tt.want)
+			}
+		}
+	}
+
+	// Many tenants share the buckets, and use them all
+	seen := map[string]int{}
+	for i := range 2000 {
+		seen[labels.Label(fmt.Sprintf("tenant_%04d", i))]++
+	}
+	if len(seen) != 16 {
+		t.Errorf("2000 tenants got %d labels, want the 16 buckets", len(seen))
+	}
+	for label, n := range seen {
+		if n < 2000/16/2 {
+			t.Errorf("%s labels %d of 2000 tenants, want about %d", label, n, 2000/16)
+		}
+	}
+
+	// Without buckets the others are one label
+	if got := NewTenantLabels(nil, nil, 0, 50).Label("tenant_rye_and_co"); got != OtherTenants {
+		t.Errorf("Label() without buckets = %q, want %q", got, OtherTenants)
+	}
+}
+
+func TestTenantLabelMaxLabels(t *testing.T) {
+	ctx := context.Background()
+
+	// An allowlist over the cap buckets every tenant instead
+	labels := NewTenantLabels(nil, []string{"tenant_a", "tenant_b", "tenant_c"}, 16, 2)
+	if policy := labels.Policy(); !policy.Bucketed || len(policy.Tenants) != 3 {
+		t.Errorf("Policy() over the cap = %+v, want the allowlist kept and bucketed", policy)
+	}
+	if got := labels.Label("tenant_a"); got == "tenant_a" {
+		t.Errorf("Label() over the cap = %q, want a bucket", got)
+	}
+
+	// Allowlists set at runtime are capped the same way
+	policy, err := labels.Set(ctx, []string{"tenant_a", "tenant_b"})
+	if err != nil || policy.Bucketed || labels.Label("tenant_a") != "tenant_a" {
+		t.Errorf("Set() at the cap = %+v, %v; want the tenants labelled by schema", policy, err)
+	}
+	policy, _ = labels.Set(ctx, []string{"tenant_a", "tenant_b", "tenant_c"})
+	if !policy.Bucketed || labels.Label("tenant_a") == "tenant_a" {
+		t.Errorf("Set() over the cap = %+v, want every tenant bucketed", policy)
+	}
+}
+
+func TestTenantLabelReload(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+	a := NewTenantLabels(store, []string{"tenant_big"}, 0, 50)
+	b := NewTenantLabels(store, []string{"tenant_big"}, 0, 50)
+
+	// An allowlist set through one instance reaches the other on reload,
+	// without a restart
+	if _, err := a.Set(ctx, []string{"tenant_new"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Label("tenant_new") != "tenant_new" || a.Label("tenant_big") != OtherTenants {
+		t.Errorf("Set() didn't apply to its own instance at once: %+v", a.Policy())
+	}
+	if b.Label("tenant_new") != OtherTenants {
+		t.Error("the other instance changed before reloading")
+	}
+	if err := b.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if policy := b.Policy(); !policy.Overridden || !slices.Equal(policy.Tenants, []string{"tenant_new"}) || b.Label("tenant_new") != "tenant_new" {
+		t.Errorf("Policy() after reloading = %+v, want the stored allowlist", policy)
+	}
+
+	// Clearing the stored allowlist restores config's
+	if _, err := a.Set(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if policy := b.Policy(); policy.Overridden || b.Label("tenant_big") != "tenant_big" || b.Label("tenant_new") != OtherTenants {
+		t.Errorf("Policy() after clearing = %+v, want config's allowlist", policy)
+	}
+
+	// Run loads the stored allowlist when it starts
+	if _, err := a.Set(ctx, []string{"tenant_run"}); err != nil {
+		t.Fatal(err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		b.Run(runCtx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for b.Label("tenant_run") != "tenant_run" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if b.Label("tenant_run") != "tenant_run" {
+		t.Error("Run() didn't load the stored allowlist")
+	}
+}
+
+func TestTenantLabelReloadFailure(t *testing.T) {
+	labels := NewTenantLabels(failingStore{}, []string{"tenant_big"}, 0, 50)
+
+	// A store that is down keeps the current policy
+	if err := labels.Reload(context.Background()); err == nil {
+		t.Error("Reload() with the store down error = nil")
+	}
+	if labels.Label("tenant_big") != "tenant_big" {
+		t.Errorf("Policy() after a failed reload = %+v, want config's allowlist", labels.Policy())
+	}
+	if _, err := labels.Set(context.Background(), []string{"tenant_new"}); err == nil || labels.Label("tenant_new") != OtherTenants {
+		t.Errorf("Set() with the store down = %v, %+v; want an error and no change", err, labels.Policy())
+	}
+}
+
+func TestTenantLabelDefault(t *testing.T) {
+	t.Cleanup(func() { SetTenantLabels(nil) })
+
+	if TenantLabel("tenant_big") != OtherTenants || TenantLabel("") != NoTenant {
+		t.Errorf("TenantLabel() before SetTenantLabels = %q, %q", TenantLabel("tenant_big"), TenantLabel(""))
+	}
+	SetTenantLabels(NewTenantLabels(nil, []string{"tenant_big"}, 0, 50))
+	if got := TenantLabel("tenant_big"); got != "tenant_big" {
+		t.Errorf("TenantLabel() = %q, want the allowlisted schema", got)
+	}
+}
@@ -4,6 +4,7 @@ package middleware
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +14,10 @@ var ErrMissingFrontendAPIKey = errors.New("missing or invalid frontend API key")
 func APIFrontendKeyAuthMiddleware(expectedKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		providedKey := c.GetHeader("X-Awning-Frontend-Key")
+		if providedKey == "" && strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			// Browsers can't set custom headers on WebSocket requests
+			providedKey = c.Query("frontend_key")
+		}
 		if providedKey == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Frontend API key is required"})
 			return
@@ -2,9 +2,10 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
-	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,15 +20,9 @@ func APIKeyAuthMiddleware(validateFunc func(ctx context.Context, apiKey, apiSecr
 		authHeader := c.GetHeader("Authorization")
 
 		var apiKey, apiSecret string
-		if len(authHeader) > 7 && authHeader[:7] == "ApiKey " {
+		if credentials, ok := strings.CutPrefix(authHeader, "ApiKey "); ok {
 			// Expected format: "ApiKey key:secret"
-			credentials := authHeader[7:]
-			parts := make([]string, 2)
-			n, _ := fmt.Sscanf(credentials, "%[^:]:%s", &parts[0], &parts[1])
-			if n == 2 {
-				apiKey = parts[0]
-				apiSecret = parts[1]
-			}
+			apiKey, apiSecret, _ = strings.Cut(credentials, ":")
 		}
 
 		if apiKey == "" || apiSecret == "" {
@@ -45,3 +40,19 @@ func APIKeyAuthMiddleware(validateFunc func(ctx context.Context, apiKey, apiSecr
 		c.Next()
 	}
 }
+
+// StaticAPIKeyValidator validates credentials against a single configured key
+// pair. An empty configured key rejects every request.
+func StaticAPIKeyValidator(expectedKey, expectedSecret string) func(ctx context.Context, apiKey, apiSecret string) (context.Context, error) {
+	return func(ctx context.Context, apiKey, apiSecret string) (context.Context, error) {
+		if expectedKey == "" || expectedSecret == "" {
+			return ctx, ErrMissingAPICredentials
+		}
+		keyOK := subtle.ConstantTimeCompare([]byte(apiKey), []byte(expectedKey)) == 1
+		secretOK := subtle.ConstantTimeCompare([]byte(apiSecret), []byte(expectedSecret)) == 1
+		if !keyOK || !secretOK {
+			return ctx, ErrMissingAPICredentials
+		}
+		return ctx, nil
+	}
+}
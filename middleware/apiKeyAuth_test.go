@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", APIKeyAuthMiddleware(StaticAPIKeyValidator("key", "s3cr:et")), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"ApiKey key:s3cr:et", http.StatusNoContent},
+		{"ApiKey key:s3cr", http.StatusUnauthorized},
+		{"ApiKey other:s3cr:et", http.StatusUnauthorized},
+		{"ApiKey key", http.StatusUnauthorized},
+		{"ApiKey :s3cr:et", http.StatusUnauthorized},
+		{"Bearer key:s3cr:et", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", tt.header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.header, w.Code, tt.want)
+		}
+	}
+}
+
+func TestStaticAPIKeyValidatorWithoutKey(t *testing.T) {
+	validate := StaticAPIKeyValidator("", "")
+	if _, err := validate(t.Context(), "", ""); err == nil {
+		t.Error("validator without a configured key accepted empty credentials")
+	}
+}
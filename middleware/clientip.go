@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIPResolver finds the IP of the client behind trusted proxies
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+	headers []string
+}
+
+// NewClientIPResolver creates a resolver trusting the forwarding headers,
+// tried in order, of requests from the trusted proxies (IPs or CIDRs)
+func NewClientIPResolver(trustedProxies, headers []string) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{}
+	for _, proxy := range trustedProxies {
+		prefix, err := common.ParseIPPrefix(proxy)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+	for _, header := range headers {
+		if header = strings.TrimSpace(header); header != "" {
+			r.headers = append(r.headers, http.CanonicalHeaderKey(header))
+		}
+	}
+	return r, nil
+}
+
+// Resolve returns the client IP of a request. Requests from untrusted
+// sources are answered with their remote address, whatever headers they
+// send. From a trusted proxy, the first header that names a client wins;
+// X-Forwarded-For is read from the right, skipping trusted proxies, so
+// addresses the client prepended itself are ignored.
+func (r *ClientIPResolver) Resolve(req *http.Request) string {
+	remote, ok := parseIP(req.RemoteAddr)
+	if !ok {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(remote) {
+		return remote.String()
+	}
+
+	for _, header := range r.headers {
+		values := req.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+		if header == "X-Forwarded-For" {
+			if ip, ok := r.forwardedFor(values); ok {
+				return ip.String()
+			}
+			continue
+		}
+		if ip, ok := parseIP(values[0]); ok {
+			return ip.String()
+		}
+	}
+	return remote.String()
+}
+
+// forwardedFor returns the rightmost address of the X-Forwarded-For chain
+// that isn't a trusted proxy, or the leftmost when all of them are
+func (r *ClientIPResolver) forwardedFor(values []string) (netip.Addr, bool) {
+	var chain []string
+	for _, value := range values {
+		chain = append(chain, strings.Split(value, ",")...)
+	}
+
+	var last netip.Addr
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip, ok := parseIP(chain[i])
+		if !ok {
+			// A malformed hop can't be vouched for; stop at the last good one
+			break
+		}
+		last = ip
+		if !r.isTrusted(ip) {
+			return ip, true
+		}
+	}
+	return last, last.IsValid()
+}
+
+func (r *ClientIPResolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses an address with or without a port, as in RemoteAddr and
+// forwarding headers: 203.0.113.7, 203.0.113.7:443, 2001:db8::1 or
+// [2001:db8::1]:443. IPv4-mapped IPv6 addresses become IPv4 and zones are
+// dropped.
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
+
+// ClientIPMiddleware resolves the client IP of each request and stores it
+// in the request context, where ClientIP and common.ClientIPFromContext
+// read it
+func ClientIPMiddleware(resolver *ClientIPResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := resolver.Resolve(c.Request)
+		c.Request = c.Request.WithContext(common.WithClientIP(c.Request.Context(), ip))
+		c.Next()
+	}
+}
+
+// ClientIP returns the client IP resolved by ClientIPMiddleware, falling
+// back to gin's, which is configured with the same trusted proxies
+func ClientIP(c *gin.Context) string {
+	if ip := common.ClientIPFromContext(c.Request.Context()); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testTrustedProxies = []string{"10.0.0.0/8", "192.0.2.1", "2001:db8:1::/48"}
+
+func newTestResolver(t *testing.T, headers ...string) *ClientIPResolver {
+	t.Helper()
+	if len(headers) == 0 {
+		headers = strings.Split(common.DEFAULT_CLIENT_IP_HEADERS, ",")
+	}
+	r, err := NewClientIPResolver(testTrustedProxies, headers)
+	if err != nil {
+		t.Fatalf("NewClientIPResolver() error = %v", err)
+	}
+	return r
+}
+
+func TestClientIPResolver(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   string
+	}{
+		// Untrusted sources get their own address, whatever they claim
+		{"untrusted, spoofed XFF", "203.0.113.7:5000",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"untrusted, spoofed X-Real-IP", "203.0.113.7:5000",
+			http.Header{"X-Real-Ip": {"10.0.0.1"}}, "203.0.113.7"},
+		{"untrusted IPv6, spoofed XFF", "[2001:db8:ff::9]:443",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "2001:db8:ff::9"},
+		{"untrusted in a trusted range's neighbour", "192.0.2.2:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.2"},
+
+		// Trusted proxies
+		{"trusted, no headers", "10.0.0.1:80", nil, "10.0.0.1"},
+		{"trusted, single XFF", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"trusted single IP", "192.0.2.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"chained XFF, client-prepended hop ignored", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2"}}, "203.0.113.7"},
+		{"chained XFF over several header lines", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7,10.0.0.3", "10.0.0.2"}}, "203.0.113.7"},
+		{"chained XFF of trusted proxies only", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"chained XFF stops at a malformed hop", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.7, not-an-ip, 10.0.0.2"}}, "10.0.0.2"},
+		{"malformed XFF falls through to X-Real-IP", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"not-an-ip"}, "X-Real-Ip": {"203.0.113.7"}}, "203.0.113.7"},
+		{"XFF comes before X-Real-IP", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}, "X-Real-Ip": {"198.51.100.1"}}, "203.0.113.7"},
+		{"unconfigured header ignored", "10.0.0.1:80",
+			http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}}, "10.0.0.1"},
+
+		// IPv6
+		{"trusted IPv6 proxy, IPv6 chain", "[2001:db8:1::5]:443",
+			http.Header{"X-Forwarded-For": {"2001:db8:ffff::1, 2001:db8:abcd::7, 2001:db8:1::2"}}, "2001:db8:abcd::7"},
+		{"bracketed IPv6 hop with a port", "[2001:db8:1::5]:443",
+			http.Header{"X-Forwarded-For": {"[2001:db8:abcd::7]:51000"}}, "2001:db8:abcd::7"},
+		{"IPv6 hop with a zone", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"fe80::1%eth0"}}, "fe80::1"},
+		{"IPv4-mapped IPv6 hop", "10.0.0.1:80",
+			http.Header{"X-Forwarded-For": {"::ffff:203.0.113.7"}}, "203.0.113.7"},
+		{"IPv4-mapped trusted proxy", "[::ffff:10.0.0.1]:80",
+			http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "203.0.113.7"},
+		{"IPv6 X-Real-IP", "10.0.0.1:80",
+			http.Header{"X-Real-Ip": {"2001:db8:abcd::7"}}, "2001:db8:abcd::7"},
+
+		{"unparsable remote address", "@", http.Header{"X-Forwarded-For": {"203.0.113.7"}}, "@"},
+	}
+	r := newTestResolver(t)
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		req.Header = tt.header
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if got := r.Resolve(req); got != tt.want {
+			t.Errorf("%s: Resolve() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientIPResolverHeaderOrder(t *testing.T) {
+	r := newTestResolver(t, " cf-connecting-ip", "X-Forwarded-For ", "")
+	header := http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:80"
+	req.Header = header.Clone()
+	if got := r.Resolve(req); got != "203.0.113.7" {
+		t.Errorf("Resolve() = %q, want the first configured header's", got)
+	}
+
+	req.Header.Set("Cf-Connecting-Ip", "garbage")
+	if got := r.Resolve(req); got != "198.51.100.1" {
+		t.Errorf("Resolve() = %q, want the next header's when the first is malformed", got)
+	}
+}
+
+func TestNewClientIPResolverRejectsBadProxies(t *testing.T) {
+	for _, proxy := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8/8"} {
+		if _, err := NewClientIPResolver([]string{proxy}, nil); err == nil {
+			t.Errorf("NewClientIPResolver(%q) error = nil", proxy)
+		}
+	}
+}
+
+// TestClientIPMatchesGin checks that the resolver and gin's ClientIP, set
+// up from the same trusted proxies and headers as serverbuilder does,
+// agree on well-formed requests
+func TestClientIPMatchesGin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	headers := strings.Split(common.DEFAULT_CLIENT_IP_HEADERS, ",")
+
+	engine := gin.New()
+	if err := engine.SetTrustedProxies(testTrustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	engine.RemoteIPHeaders = headers
+	engine.Use(ClientIPMiddleware(newTestResolver(t, headers...)))
+	engine.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, ClientIP(c)+" "+c.ClientIP())
+	})
+
+	requests := []struct {
+		remote string
+		header http.Header
+	}{
+		{"203.0.113.7:5000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}},
+		{"10.0.0.1:80", nil},
+		{"10.0.0.1:80", http.Header{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.0.0.2"}}},
+		{"10.0.0.1:80", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}},
+		{"10.0.0.1:80", http.Header{"X-Real-Ip": {"203.0.113.7"}}},
+		{"[2001:db8:1::5]:443", http.Header{"X-Forwarded-For": {"2001:db8:ffff::1, 2001:db8:abcd::7, 2001:db8:1::2"}}},
+		{"[2001:db8:ff::9]:443", http.Header{"X-Forwarded-For": {"198.51.100.1"}}},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		for name, values := range tt.header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+
+		ours, gins, _ := strings.Cut(w.Body.String(), " ")
+		if ours != gins {
+			t.Errorf("from %s with %v: resolved %q, gin %q", tt.remote, tt.header, ours, gins)
+		}
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, ClientIP(c)+"|"+common.ClientIPFromContext(c.Request.Context()))
+	}
+
+	withMiddleware := gin.New()
+	withMiddleware.Use(ClientIPMiddleware(newTestResolver(t)))
+	withMiddleware.GET("/", handler)
+	without := gin.New()
+	without.GET("/", handler)
+
+	tests := []struct {
+		name   string
+		engine *gin.Engine
+		want   string
+	}{
+		{"stored in the context", withMiddleware, "203.0.113.7|203.0.113.7"},
+		// Unconfigured, gin trusts every hop and takes the client's own
+		{"falls back to gin without it", without, "198.51.100.1|"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:80"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+		w := httptest.NewRecorder()
+		tt.engine.ServeHTTP(w, req)
+		if w.Body.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, w.Body, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GzipMiddleware compresses JSON, HTML and text responses of at least
+// minBytes for clients sending Accept-Encoding: gzip. Responses are buffered
+// until the handler returns; event streams, WebSocket upgrades and responses
+// that are flushed or already encoded pass through unchanged.
+func GzipMiddleware(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+
+		w.finish(c.Request, minBytes)
+	}
+}
+
+// AcceptsGzip reports whether the Accept-Encoding header allows gzip
+func AcceptsGzip(acceptEncoding string) bool {
+	return AcceptsEncoding(acceptEncoding, "gzip")
+}
+
+// AcceptsEncoding reports whether the Accept-Encoding header allows
+// encoding, such as gzip or br
+func AcceptsEncoding(acceptEncoding, encoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != encoding && coding != "*" {
+			continue
+		}
+		// q=0 means not acceptable
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compressible reports whether responses of contentType are worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") && mediaType != "text/event-stream" ||
+		mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/javascript"
+}
+
+// gzipWriter buffers the response so its size is known before choosing an
+// encoding
+type gzipWriter struct {
+	gin.ResponseWriter
+	buf         bytes.Buffer
+	status      int
+	passthrough bool
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *gzipWriter) WriteHeaderNow() {
+	if w.passthrough {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if !w.passthrough && w.buf.Len() == 0 && !w.buffering() {
+		w.startPassthrough()
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been buffered as-is and stops buffering, so streamed
+// responses are never held back
+func (w *gzipWriter) Flush() {
+	if !w.passthrough {
+		w.startPassthrough()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.passthrough = true
+	return w.ResponseWriter.Hijack()
+}
+
+func (w *gzipWriter) Status() int {
+	if !w.passthrough && w.status != 0 {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *gzipWriter) Size() int {
+	if !w.passthrough && w.buf.Len() > 0 {
+		return w.buf.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *gzipWriter) Written() bool {
+	return w.status != 0 || w.buf.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// buffering reports whether the response about to be written can be
+// compressed
+func (w *gzipWriter) buffering() bool {
+	header := w.Header()
+	return header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type"))
+}
+
+// startPassthrough writes the status and anything buffered, then sends
+// further writes straight to the client
+func (w *gzipWriter) startPassthrough() {
+	w.passthrough = true
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.buf.Len() > 0 {
+		w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// finish writes the buffered response, gzipped when it is large enough and
+// the client accepts it
+func (w *gzipWriter) finish(req *http.Request, minBytes int) {
+	if w.passthrough {
+		return
+	}
+
+	header := w.Header()
+	if w.buf.Len() == 0 {
+		w.startPassthrough()
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+
+	// Byte ranges refer to the uncompressed body
+	if w.status == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		w.startPassthrough()
+		return
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	if minBytes < 0 || w.buf.Len() < minBytes || !AcceptsGzip(req.Header.Get("Accept-Encoding")) {
+		w.startPassthrough()
+		return
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(w.buf.Bytes()); err != nil || gz.Close() != nil {
+		slog.Error("Failed to gzip response, sending uncompressed", "path", req.URL.Path, "error", err)
+		w.startPassthrough()
+		return
+	}
+
+	slog.Debug("Compressed response", "path", req.URL.Path, "bytes", w.buf.Len(), "gzip_bytes", compressed.Len())
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.buf = compressed
+	w.startPassthrough()
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.8", true},
+		{"br, deflate", false},
+		{"*", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"gzip;q=0.001", true},
+		{"identity, *;q=0", false},
+	}
+	for _, tt := range tests {
+		if got := AcceptsGzip(tt.header); got != tt.want {
+			t.Errorf("AcceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+// newGzipRouter serves a JSON body of size bytes at /json, the same as an
+// event stream at /stream and a flushed JSON response at /flushed
+func newGzipRouter(size int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GzipMiddleware(100))
+	body := `{"content":"` + strings.Repeat("a", size) + `"}`
+	r.GET("/json", func(c *gin.Context) {
+		c.Data(http.StatusCreated, "application/json; charset=utf-8", []byte(body))
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/event-stream", []byte(body))
+	})
+	r.GET("/flushed", func(c *gin.Context) {
+		c.Header("Content-Type", "application/json")
+		c.Writer.WriteString(body)
+		c.Writer.Flush()
+	})
+	return r
+}
+
+func getWithEncoding(r *gin.Engine, path, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		size           int
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"large JSON", "/json", 1000, "gzip, deflate", true},
+		{"small JSON", "/json", 10, "gzip", false},
+		{"no Accept-Encoding", "/json", 1000, "", false},
+		{"gzip refused", "/json", 1000, "gzip;q=0", false},
+		{"event stream", "/stream", 1000, "gzip", false},
+		{"flushed", "/flushed", 1000, "gzip", false},
+	}
+	for _, tt := range tests {
+		w := getWithEncoding(newGzipRouter(tt.size), tt.path, tt.acceptEncoding)
+		want := `{"content":"` + strings.Repeat("a", tt.size) + `"}`
+
+		body := w.Body.String()
+		gzipped := w.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != tt.wantGzip {
+			t.Errorf("%s: Content-Encoding = %q, want gzip %v", tt.name, w.Header().Get("Content-Encoding"), tt.wantGzip)
+			continue
+		}
+		if gzipped {
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("%s: invalid gzip body: %v", tt.name, err)
+			}
+			data, _ := io.ReadAll(gz)
+			body = string(data)
+			if w.Body.Len() >= len(want) {
+				t.Errorf("%s: gzipped body is %d bytes, not smaller than %d", tt.name, w.Body.Len(), len(want))
+			}
+		}
+		if body != want {
+			t.Errorf("%s: body = %.40q..., want the handler's body", tt.name, body)
+		}
+	}
+
+	w := getWithEncoding(newGzipRouter(1000), "/json", "gzip")
+	if w.Code != http.StatusCreated {
+		t.Errorf("status = %d, want the handler's 201", w.Code)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", vary)
+	}
+}
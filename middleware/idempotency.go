@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	MaxIdempotencyKeyLength = 255
+
+	idempotencyKeyContextKey = "idempotencyKey"
+	idempotencyPollInterval  = 100 * time.Millisecond
+)
+
+var idempotencyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// IdempotencyConfig configures IdempotencyMiddleware
+type IdempotencyConfig struct {
+	// TTL is how long responses are replayed for
+	TTL time.Duration
+	// MaxBodyBytes caps stored responses; larger ones are not stored
+	MaxBodyBytes int
+	// Wait is how long a duplicate waits for the first request to finish
+	// before getting 409
+	Wait time.Duration
+	// Scope returns the owner keys belong to, such as the tenant
+	Scope func(c *gin.Context) string
+}
+
+// IdempotencyMiddleware makes requests carrying an Idempotency-Key header
+// safe to retry. The first response for a key (per scope and route) is
+// stored and replayed to later requests with the same key and body, marked
+// with Idempotent-Replayed: true. Server errors are not stored, so those
+// requests can be retried. Requests without the header, or without Redis,
+// are handled normally.
+func IdempotencyMiddleware(store *storage.RedisClient, cfg IdempotencyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > MaxIdempotencyKeyLength || !idempotencyKeyPattern.MatchString(key) {
+			i18n.AbortWithError(c, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key must be 1-255 letters, digits, '_', '-', '.' or ':'")
+			return
+		}
+		if store == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := ""
+		if cfg.Scope != nil {
+			scope = cfg.Scope(c)
+		}
+		route := c.Request.Method + " " + c.FullPath()
+		fingerprint := requestFingerprint(c.Request, body)
+		ctx := c.Request.Context()
+
+		deadline := time.Now().Add(cfg.Wait)
+		for {
+			stored, storedFingerprint, err := store.BeginIdempotentRequest(ctx, scope, route, key, fingerprint)
+			if storedFingerprint != "" && storedFingerprint != fingerprint {
+				i18n.AbortWithError(c, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used with a different request")
+				return
+			}
+			if errors.Is(err, storage.ErrIdempotencyInProgress) {
+				if time.Now().Before(deadline) {
+					select {
+					case <-time.After(idempotencyPollInterval):
+						continue
+					case <-ctx.Done():
+						c.Abort()
+						return
+					}
+				}
+				i18n.AbortWithError(c, http.StatusConflict, "idempotency_in_progress", "a request with this Idempotency-Key is in progress")
+				return
+			}
+			if err != nil {
+				// Fail open: handling the request beats refusing it
+				slog.Error("Failed to check idempotency key", "route", route, "error", err)
+				c.Next()
+				return
+			}
+			if stored != nil {
+				slog.Debug("Replaying idempotent response", "route", route, "scope", scope, "status", stored.Status)
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(stored.Status, stored.ContentType, stored.Body)
+				c.Abort()
+				return
+			}
+			break
+		}
+
+		w := &idempotencyWriter{ResponseWriter: c.Writer, max: cfg.MaxBodyBytes}
+		c.Writer = w
+		c.Set(idempotencyKeyContextKey, stripeKey(scope, route, key))
+
+		c.Next()
+
+		c.Writer = w.ResponseWriter
+
+		// Store even if the client went away, so its retry is replayed
+		ctx = context.WithoutCancel(ctx)
+		status := w.Status()
+		if status >= http.StatusInternalServerError || w.overflow {
+			if w.overflow {
+				slog.Warn("Idempotent response too large to store", "route", route, "max_bytes", cfg.MaxBodyBytes)
+			}
+			if err := store.ReleaseIdempotentRequest(ctx, scope, route, key); err != nil {
+				slog.Error("Failed to release idempotency key", "route", route, "error", err)
+			}
+			return
+		}
+
+		err = store.SaveIdempotentResponse(ctx, scope, route, key, &storage.IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        w.body.Bytes(),
+		}, cfg.TTL)
+		if err != nil {
+			slog.Error("Failed to store idempotent response", "route", route, "error", err)
+		}
+	}
+}
+
+// IdempotencyKey returns a key derived from the request's Idempotency-Key,
+// unique per scope and route, for passing on to upstream APIs such as
+// Stripe. It is empty when the request has no key.
+func IdempotencyKey(c *gin.Context) string {
+	return c.GetString(idempotencyKeyContextKey)
+}
+
+func stripeKey(scope, route, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + route + "\x00" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestFingerprint identifies a request by its path, query and body
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyWriter copies the response body, up to max bytes, for storing
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (w *idempotencyWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *idempotencyWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > w.max {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
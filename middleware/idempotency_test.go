@@ -0,0 +1,230 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentRouter serves POST /charge behind the middleware, counting the
+// calls that reach the handler. Requests are scoped by the X-Tenant header.
+type idempotentRouter struct {
+	*gin.Engine
+	server  *miniredis.Miniredis
+	calls   atomic.Int32
+	status  int
+	release chan struct{}
+}
+
+func newIdempotentRouter(t *testing.T, cfg IdempotencyConfig) *idempotentRouter {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	store, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if cfg.TTL == 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 1024
+	}
+	cfg.Scope = func(c *gin.Context) string { return c.GetHeader("X-Tenant") }
+
+	r := &idempotentRouter{Engine: gin.New(), server: server, status: http.StatusCreated}
+	r.POST("/charge", IdempotencyMiddleware(store, cfg), func(c *gin.Context) {
+		n := r.calls.Add(1)
+		if r.release != nil {
+			<-r.release
+		}
+		c.JSON(r.status, gin.H{"charge": n, "stripeKey": IdempotencyKey(c) != ""})
+	})
+	return r
+}
+
+func (r *idempotentRouter) post(key, tenant, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/charge", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	req.Header.Set("X-Tenant", tenant)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{})
+
+	first := r.post("key-1", "tenant_a", `{"amount": 100}`)
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("first request = %d %q", first.Code, first.Header().Get(IdempotentReplayedHeader))
+	}
+	if !strings.Contains(first.Body.String(), `"stripeKey":true`) {
+		t.Errorf("handler saw no idempotency key to pass to Stripe: %s", first.Body)
+	}
+
+	replay := r.post("key-1", "tenant_a", `{"amount": 100}`)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("replay has no Idempotent-Replayed header")
+	}
+	if ct := replay.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("replay Content-Type = %q", ct)
+	}
+
+	// Other keys, scopes and requests without a key reach the handler
+	r.post("key-2", "tenant_a", `{"amount": 100}`)
+	r.post("key-1", "tenant_b", `{"amount": 100}`)
+	r.post("", "tenant_a", `{"amount": 100}`)
+	r.post("", "tenant_a", `{"amount": 100}`)
+	if n := r.calls.Load(); n != 5 {
+		t.Errorf("handler called %d times, want 5", n)
+	}
+
+	if w := r.post("key-1", "tenant_a", `{"amount": 999}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body = %d, want 422", w.Code)
+	}
+}
+
+func TestIdempotencyKeyValidation(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{})
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"order-42_retry.1:a", http.StatusCreated},
+		{strings.Repeat("k", MaxIdempotencyKeyLength), http.StatusCreated},
+		{strings.Repeat("k", MaxIdempotencyKeyLength+1), http.StatusBadRequest},
+		{"has space", http.StatusBadRequest},
+		{"slash/key", http.StatusBadRequest},
+		{"ключ", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := r.post(tt.key, "tenant_a", `{}`); w.Code != tt.want {
+			t.Errorf("key %.20q: status = %d, want %d", tt.key, w.Code, tt.want)
+		}
+	}
+}
+
+func TestIdempotencyNotStored(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{MaxBodyBytes: 10})
+
+	// Too large to store
+	r.post("big", "tenant_a", `{}`)
+	if w := r.post("big", "tenant_a", `{}`); w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Error("replayed a response larger than MaxBodyBytes")
+	}
+
+	// Server errors can be retried
+	r.status = http.StatusBadGateway
+	r.post("flaky", "tenant_a", `{}`)
+	r.post("flaky", "tenant_a", `{}`)
+	if n := r.calls.Load(); n != 4 {
+		t.Errorf("handler called %d times, want every request handled", n)
+	}
+}
+
+func TestIdempotencyTTL(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{TTL: time.Hour})
+
+	r.post("key-1", "tenant_a", `{}`)
+	r.server.FastForward(59 * time.Minute)
+	if w := r.post("key-1", "tenant_a", `{}`); w.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("response not replayed within the TTL")
+	}
+	r.server.FastForward(2 * time.Minute)
+	if w := r.post("key-1", "tenant_a", `{}`); w.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Error("response replayed after the TTL")
+	}
+	if n := r.calls.Load(); n != 2 {
+		t.Errorf("handler called %d times, want 2", n)
+	}
+}
+
+// concurrentDuplicates sends a request, then a duplicate while the first is
+// still being handled, returning both responses
+func concurrentDuplicates(r *idempotentRouter) (first, second *httptest.ResponseRecorder) {
+	r.release = make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = r.post("key-1", "tenant_a", `{}`)
+	}()
+	for r.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		second = r.post("key-1", "tenant_a", `{}`)
+	}()
+	time.Sleep(3 * idempotencyPollInterval)
+	close(r.release)
+	wg.Wait()
+	return first, second
+}
+
+func TestIdempotencyConcurrentDuplicateWaits(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{Wait: 5 * time.Second})
+
+	first, second := concurrentDuplicates(r)
+	if second.Code != first.Code || second.Body.String() != first.Body.String() || second.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("waiting duplicate = %d %s, want the first response replayed", second.Code, second.Body)
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyConcurrentDuplicateConflicts(t *testing.T) {
+	r := newIdempotentRouter(t, IdempotencyConfig{})
+
+	first, second := concurrentDuplicates(r)
+	if first.Code != http.StatusCreated || second.Code != http.StatusConflict {
+		t.Errorf("statuses = %d, %d; want 201 then 409 in progress", first.Code, second.Code)
+	}
+	if n := r.calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyWithoutRedis(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/charge", IdempotencyMiddleware(nil, IdempotencyConfig{}), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	for _, tt := range []struct {
+		key  string
+		want int
+	}{{"key-1", http.StatusCreated}, {"bad key", http.StatusBadRequest}} {
+		req := httptest.NewRequest(http.MethodPost, "/charge", nil)
+		req.Header.Set(IdempotencyKeyHeader, tt.key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("key %q without Redis: status = %d, want %d", tt.key, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTimingMiddleware collects the phases handlers record on the request
+// context (see common.StartTiming) and sends them in a Server-Timing header,
+// followed by the total time until the response headers were written.
+// Phases recorded after the headers went out, as in event streams, are not
+// included.
+func ServerTimingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timings := common.NewTimings()
+		c.Request = c.Request.WithContext(common.WithTimings(c.Request.Context(), timings))
+
+		w := &serverTimingWriter{ResponseWriter: c.Writer, timings: timings, start: time.Now()}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// serverTimingWriter sets the Server-Timing header just before the headers
+// are written
+type serverTimingWriter struct {
+	gin.ResponseWriter
+	timings *common.Timings
+	start   time.Time
+	done    bool
+}
+
+func (w *serverTimingWriter) setHeader() {
+	if w.done || w.ResponseWriter.Written() {
+		return
+	}
+	w.done = true
+	value := fmt.Sprintf("total;dur=%.1f", float64(time.Since(w.start).Microseconds())/1000)
+	if phases := w.timings.ServerTiming(); phases != "" {
+		value = phases + ", " + value
+	}
+	w.Header().Set("Server-Timing", value)
+}
+
+func (w *serverTimingWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverTimingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *serverTimingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *serverTimingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServerTimingMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ServerTimingMiddleware())
+	r.GET("/chat", func(c *gin.Context) {
+		common.TimingsFromContext(c.Request.Context()).Add("chat_load", 12*time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+
+		// Too late for the header
+		common.StartTiming(c.Request.Context(), "after")()
+	})
+	r.GET("/empty", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/chat", `^chat_load;dur=12\.0, total;dur=\d+\.\d$`},
+		{"/empty", `^total;dur=\d+\.\d$`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := w.Header().Get("Server-Timing"); !regexp.MustCompile(tt.want).MatchString(got) {
+			t.Errorf("GET %s Server-Timing = %q, want it to match %s", tt.path, got, tt.want)
+		}
+	}
+}
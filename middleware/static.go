@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"awning-backend/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Cache-Control for files with hashed names
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Cache-Control for everything else, index.html in particular
+	revalidateCacheControl = "no-cache"
+)
+
+// StaticConfig configures StaticHandler
+type StaticConfig struct {
+	// Directory holding the built frontend
+	Dir string
+	// Path the directory is served under, such as / or /app/
+	BasePath string
+	// Paths relative to BasePath, such as /assets/, whose files have hashed
+	// names and never change
+	ImmutablePrefixes []string
+}
+
+// precompressed lists the encodings StaticHandler looks for next to a file,
+// in order of preference
+var precompressed = []struct{ encoding, ext string }{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// StaticHandler serves a built single-page frontend. Register it with
+// NoRoute so it never shadows API routes.
+//
+// Behavior:
+//   - Unknown /api/ paths get a JSON 404 instead of the frontend.
+//   - Files are opened through an os.Root, so paths can't escape Dir, even
+//     through symlinks.
+//   - A .br or .gz file next to the requested one is sent instead when the
+//     client accepts that encoding.
+//   - Paths without a file extension that match no file get index.html, so
+//     client-side routes work; missing assets get a 404.
+func StaticHandler(cfg StaticConfig) gin.HandlerFunc {
+	basePath := "/" + strings.Trim(cfg.BasePath, "/")
+	root, err := os.OpenRoot(cfg.Dir)
+	if err != nil {
+		slog.Error("Failed to open static file directory", "directory", cfg.Dir, "error", err)
+	}
+
+	return func(c *gin.Context) {
+		urlPath := c.Request.URL.Path
+		if urlPath == "/api" || strings.HasPrefix(urlPath, "/api/") {
+			i18n.Error(c, http.StatusNotFound, "route_not_found", "no API route matches "+c.Request.Method+" "+urlPath)
+			return
+		}
+		if root == nil || (c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+			c.String(http.StatusNotFound, "404 page not found")
+			return
+		}
+
+		rel, ok := strings.CutPrefix(urlPath, basePath)
+		if !ok || (basePath != "/" && rel != "" && !strings.HasPrefix(rel, "/")) {
+			c.String(http.StatusNotFound, "404 page not found")
+			return
+		}
+		name, ok := staticFileName(rel)
+		if !ok {
+			c.String(http.StatusBadRequest, "invalid path")
+			return
+		}
+
+		if serveStaticFile(c, root, name, cfg.ImmutablePrefixes) {
+			return
+		}
+		// Missing assets are real 404s; anything else is a client-side route
+		if path.Ext(name) != "" {
+			c.String(http.StatusNotFound, "404 page not found")
+			return
+		}
+		if !serveStaticFile(c, root, "index.html", nil) {
+			c.String(http.StatusNotFound, "404 page not found")
+		}
+	}
+}
+
+// staticFileName turns a request path below the base path into a name
+// relative to the static directory, index.html for directories. It rejects
+// .. segments, backslashes and NUL bytes rather than cleaning them away.
+func staticFileName(rel string) (string, bool) {
+	if strings.ContainsAny(rel, "\\\x00") {
+		return "", false
+	}
+	for _, segment := range strings.Split(rel, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+rel), "/")
+	if name == "" || strings.HasSuffix(rel, "/") {
+		name = path.Join(name, "index.html")
+	}
+	return name, true
+}
+
+// serveStaticFile sends name from root with its cache headers, preferring a
+// precompressed variant. It reports false when there is no such file.
+func serveStaticFile(c *gin.Context, root *os.Root, name string, immutablePrefixes []string) bool {
+	file, info, ok := openStaticFile(root, name)
+	if !ok {
+		return false
+	}
+	defer file.Close()
+
+	header := c.Writer.Header()
+	header.Set("Cache-Control", revalidateCacheControl)
+	for _, prefix := range immutablePrefixes {
+		if strings.HasPrefix("/"+name, "/"+strings.Trim(strings.TrimSpace(prefix), "/")+"/") {
+			header.Set("Cache-Control", immutableCacheControl)
+			break
+		}
+	}
+
+	header.Add("Vary", "Accept-Encoding")
+	acceptEncoding := c.GetHeader("Accept-Encoding")
+	for _, variant := range precompressed {
+		if !AcceptsEncoding(acceptEncoding, variant.encoding) {
+			continue
+		}
+		encoded, encodedInfo, ok := openStaticFile(root, name+variant.ext)
+		if !ok {
+			continue
+		}
+		defer encoded.Close()
+		header.Set("Content-Encoding", variant.encoding)
+		// ServeContent picks the Content-Type from the uncompressed name
+		http.ServeContent(c.Writer, c.Request, name, encodedInfo.ModTime(), encoded)
+		return true
+	}
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), file)
+	return true
+}
+
+// openStaticFile opens a regular file in root
+func openStaticFile(root *os.Root, name string) (*os.File, fs.FileInfo, bool) {
+	file, err := root.Open(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to open static file", "name", name, "error", err)
+		}
+		return nil, nil, false
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, false
+	}
+	return file, info, true
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newStaticRouter serves a built frontend from a temp dir under basePath,
+// next to an API route, with a secret file outside the dir and a symlink
+// to it inside
+func newStaticRouter(t *testing.T, basePath string) *gin.Engine {
+	t.Helper()
+
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.txt")
+	dir := filepath.Join(outside, "public")
+	files := map[string]string{
+		"index.html":               "<html>app</html>",
+		"robots.txt":               "User-agent: *",
+		"assets/app.3f2a.js":       "console.log('app')",
+		"assets/app.3f2a.js.br":    "brotli bytes",
+		"assets/app.3f2a.js.gz":    "gzip bytes",
+		"assets/logo.9c1d.svg":     "<svg/>",
+		"docs/index.html":          "<html>docs</html>",
+		"assets/style.77ab.css":    "body{}",
+		"assets/style.77ab.css.gz": "gzip css",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "leak.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"ok": true}) })
+	r.NoRoute(StaticHandler(StaticConfig{Dir: dir, BasePath: basePath, ImmutablePrefixes: []string{" /assets "}}))
+	return r
+}
+
+func serveStatic(r *gin.Engine, method, target, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", nil)
+	// Set the path as is, so it isn't cleaned on the way in
+	req.URL.Path = target
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestStaticHandlerCacheHeaders(t *testing.T) {
+	r := newStaticRouter(t, "/")
+
+	tests := []struct {
+		path         string
+		body         string
+		cacheControl string
+	}{
+		{"/assets/app.3f2a.js", "console.log('app')", immutableCacheControl},
+		{"/assets/logo.9c1d.svg", "<svg/>", immutableCacheControl},
+		{"/", "<html>app</html>", revalidateCacheControl},
+		{"/robots.txt", "User-agent: *", revalidateCacheControl},
+		{"/docs/", "<html>docs</html>", revalidateCacheControl},
+		// Client-side routes get index.html, never cached
+		{"/dashboard/settings", "<html>app</html>", revalidateCacheControl},
+	}
+	for _, tt := range tests {
+		w := serveStatic(r, http.MethodGet, tt.path, "")
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %q, want 200 %q", tt.path, w.Code, w.Body, tt.body)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("GET %s Cache-Control = %q, want %q", tt.path, got, tt.cacheControl)
+		}
+	}
+}
+
+func TestStaticHandlerPrecompressed(t *testing.T) {
+	r := newStaticRouter(t, "/")
+
+	tests := []struct {
+		path, acceptEncoding string
+		encoding, body       string
+	}{
+		{"/assets/app.3f2a.js", "gzip, deflate, br", "br", "brotli bytes"},
+		{"/assets/app.3f2a.js", "gzip", "gzip", "gzip bytes"},
+		{"/assets/app.3f2a.js", "br;q=0, gzip", "gzip", "gzip bytes"},
+		{"/assets/app.3f2a.js", "", "", "console.log('app')"},
+		{"/assets/style.77ab.css", "br", "", "body{}"},
+		{"/assets/style.77ab.css", "br, gzip", "gzip", "gzip css"},
+	}
+	for _, tt := range tests {
+		w := serveStatic(r, http.MethodGet, tt.path, tt.acceptEncoding)
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("GET %s (Accept-Encoding %q) = %d %q, want %q", tt.path, tt.acceptEncoding, w.Code, w.Body, tt.body)
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("GET %s (Accept-Encoding %q) Content-Encoding = %q, want %q", tt.path, tt.acceptEncoding, got, tt.encoding)
+		}
+		// The type is the uncompressed file's
+		if ct := w.Header().Get("Content-Type"); strings.Contains(ct, "gzip") || strings.Contains(ct, "brotli") || ct == "application/octet-stream" {
+			t.Errorf("GET %s (Accept-Encoding %q) Content-Type = %q", tt.path, tt.acceptEncoding, ct)
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("GET %s Vary = %q", tt.path, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestStaticHandlerTraversal(t *testing.T) {
+	r := newStaticRouter(t, "/")
+
+	for _, path := range []string{
+		"/../secret.txt",
+		"/assets/../../secret.txt",
+		"/..",
+		"/assets/..\\..\\secret.txt",
+		"/assets\\..\\index.html",
+		"/secret.txt\x00.js",
+		// Symlinks can't leave the directory either
+		"/leak.txt",
+	} {
+		w := serveStatic(r, http.MethodGet, path, "")
+		if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("GET %q = %d %q, want it refused", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestStaticHandlerAPIPaths(t *testing.T) {
+	r := newStaticRouter(t, "/")
+
+	for _, path := range []string{"/api", "/api/", "/api/v2/chat", "/api/v1/helth"} {
+		w := serveStatic(r, http.MethodGet, path, "")
+		var body struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusNotFound || body.Code != "route_not_found" {
+			t.Errorf("GET %s = %d %s, want a route_not_found 404", path, w.Code, w.Body)
+		}
+	}
+
+	// API routes are untouched, and only the /api/ prefix is reserved
+	if w := serveStatic(r, http.MethodGet, "/api/v1/health", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ok") {
+		t.Errorf("GET /api/v1/health = %d %s", w.Code, w.Body)
+	}
+	if w := serveStatic(r, http.MethodGet, "/apiary", ""); w.Code != http.StatusOK || w.Body.String() != "<html>app</html>" {
+		t.Errorf("GET /apiary = %d %s, want the frontend", w.Code, w.Body)
+	}
+}
+
+func TestStaticHandlerNotFound(t *testing.T) {
+	r := newStaticRouter(t, "/")
+
+	tests := []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/assets/missing.4e5f.js", http.StatusNotFound},
+		{http.MethodGet, "/favicon.ico", http.StatusNotFound},
+		{http.MethodPost, "/dashboard", http.StatusNotFound},
+		{http.MethodHead, "/dashboard", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := serveStatic(r, tt.method, tt.path, ""); w.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+		}
+	}
+}
+
+func TestStaticHandlerBasePath(t *testing.T) {
+	r := newStaticRouter(t, "/app/")
+
+	tests := []struct {
+		path string
+		want int
+		body string
+	}{
+		{"/app", http.StatusOK, "<html>app</html>"},
+		{"/app/", http.StatusOK, "<html>app</html>"},
+		{"/app/projects/1", http.StatusOK, "<html>app</html>"},
+		{"/app/assets/app.3f2a.js", http.StatusOK, "console.log('app')"},
+		{"/apps", http.StatusNotFound, ""},
+		{"/assets/app.3f2a.js", http.StatusNotFound, ""},
+		{"/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := serveStatic(r, http.MethodGet, tt.path, "")
+		if w.Code != tt.want || (tt.body != "" && w.Body.String() != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, w.Code, w.Body, tt.want, tt.body)
+		}
+	}
+	if got := serveStatic(r, http.MethodGet, "/app/assets/app.3f2a.js", "").Header().Get("Cache-Control"); got != immutableCacheControl {
+		t.Errorf("Cache-Control below the base path = %q, want %q", got, immutableCacheControl)
+	}
+}
+
+func TestStaticHandlerMissingDir(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.NoRoute(StaticHandler(StaticConfig{Dir: filepath.Join(t.TempDir(), "missing"), BasePath: "/"}))
+
+	if w := serveStatic(r, http.MethodGet, "/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET / without a directory = %d, want 404", w.Code)
+	}
+}
+
+func TestStaticFileName(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want string
+		ok   bool
+	}{
+		{"", "index.html", true},
+		{"/", "index.html", true},
+		{"/docs/", "docs/index.html", true},
+		{"/assets/app.js", "assets/app.js", true},
+		{"//assets/./app.js", "assets/app.js", true},
+		{"/../secret", "", false},
+		{"/a/../b", "", false},
+		{"/a\\b", "", false},
+		{"/a\x00b", "", false},
+		{"/..foo/bar", "..foo/bar", true},
+	}
+	for _, tt := range tests {
+		got, ok := staticFileName(tt.rel)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("staticFileName(%q) = %q, %v; want %q, %v", tt.rel, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"awning-backend/common"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type ChatMessageContext struct {
@@ -18,6 +20,37 @@ type ChatMessage struct {
 	Content   string              `json:"content"`
 	Timestamp int64               `json:"timestamp"`
 	Context   *ChatMessageContext `json:"context,omitempty"`
+	Model     string              `json:"model,omitempty"` // Model that generated an assistant message
+
+	// Structured summary of a generated page, when site metadata is on
+	SiteMetadata *SiteMetadata `json:"site_metadata,omitempty"`
+
+	// Pages of a multi-page generation; Content holds the home page
+	Site *SiteManifest `json:"site,omitempty"`
+
+	// Short outline of a generated page, stored the first time the message
+	// is compacted out of a prompt's history
+	Summary string `json:"summary,omitempty"`
+
+	// Set in responses whose Content is cut to a preview; never stored
+	ContentRef *ContentRef `json:"content_ref,omitempty"`
+}
+
+// ContentRef points to message content left out of a response, fetched
+// from URL
+type ContentRef struct {
+	MessageID string `json:"message_id"`
+	URL       string `json:"url"`
+	Size      int    `json:"size"` // of the full content, in bytes
+}
+
+// MarshalJSON adds timestamp_iso, the RFC3339 UTC form of Timestamp
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	return json.Marshal(struct {
+		plain
+		TimestampISO string `json:"timestamp_iso,omitempty"`
+	}{plain(m), common.FormatUnix(m.Timestamp)})
 }
 
 func NewChatMessage(role ChatMessageRole, content string) *ChatMessage {
@@ -41,16 +74,69 @@ type ChatStage string
 const (
 	ChatStageInitialCreation ChatStage = "initial_creation"
 	ChatStageUserInput       ChatStage = "update"
+
+	// Paused until the questions in the chat's Clarification are answered
+	ChatStageClarification ChatStage = "clarification"
 )
 
 // Chat represents a conversation with multiple messages
 type Chat struct {
 	ID        string          `json:"id"`
+	Title     string          `json:"title,omitempty"`
 	Messages  []ChatMessage   `json:"messages"`
 	ChatStage ChatStage       `json:"chat_stage"`
 	CreatedAt int64           `json:"created_at"`
 	UpdatedAt int64           `json:"updated_at"`
 	LastRole  ChatMessageRole `json:"last_role"` // "user" or "assistant"
+	Revision  int64           `json:"revision"`  // Incremented by each save, for optimistic locking
+
+	// Prompt experiment assigned when the chat was created, empty for the
+	// default template
+	Variant string `json:"prompt_variant,omitempty"`
+
+	// Moderation categories of messages that were flagged but allowed
+	ModerationFlags []string `json:"moderation_flags,omitempty"`
+
+	// Owner stamped when the chat is created, or on the next generation for
+	// chats created before ownership was recorded
+	TenantSchema string `json:"tenant_schema,omitempty"`
+	UserID       uint   `json:"user_id,omitempty"`
+
+	// When the chat was moved to the trash, 0 for live chats
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+
+	// Template variables kept from earlier requests, including answers to
+	// clarification questions; a request's own variables win
+	Variables map[string]string `json:"variables,omitempty"`
+
+	// Questions the chat is paused on while its stage is clarification
+	Clarification *ChatClarification `json:"clarification,omitempty"`
+}
+
+// HasOwner reports whether the chat's owner was recorded
+func (c *Chat) HasOwner() bool {
+	return c.TenantSchema != "" || c.UserID != 0
+}
+
+// OwnedBy reports whether the chat belongs to the tenant or, for chats
+// created without a tenant, to the user
+func (c *Chat) OwnedBy(tenantSchema string, userID uint) bool {
+	if c.TenantSchema != "" {
+		return c.TenantSchema == tenantSchema
+	}
+	return c.UserID != 0 && c.UserID == userID
+}
+
+// MarshalJSON adds created_at_iso, updated_at_iso and deleted_at_iso, the
+// RFC3339 UTC forms of CreatedAt, UpdatedAt and DeletedAt
+func (c Chat) MarshalJSON() ([]byte, error) {
+	type plain Chat
+	return json.Marshal(struct {
+		plain
+		CreatedAtISO string `json:"created_at_iso,omitempty"`
+		UpdatedAtISO string `json:"updated_at_iso,omitempty"`
+		DeletedAtISO string `json:"deleted_at_iso,omitempty"`
+	}{plain(c), common.FormatUnix(c.CreatedAt), common.FormatUnix(c.UpdatedAt), common.FormatUnix(c.DeletedAt)})
 }
 
 // ChatRequest represents the incoming chat request
@@ -61,6 +147,64 @@ type ChatRequest struct {
 	Message       *ChatMessage      `json:"message,omitempty"`        // Optional, for updating chat state
 	Variables     map[string]string `json:"variables,omitempty"`      // For template variables
 	TemplateInput string            `json:"template_input,omitempty"` // For template-based responses
+
+	// Stream each processed section as it finishes, for /chat/stream
+	StreamProcessing bool `json:"stream_processing,omitempty"`
+
+	// Replace only the targeted element of CurrentHTML instead of
+	// regenerating the page
+	EditTarget  *EditTarget `json:"edit_target,omitempty"`
+	CurrentHTML string      `json:"current_html,omitempty"`
+
+	// Sampling parameters over the model's defaults: temperature 0-2,
+	// top_p and max_output_tokens (capped by config)
+	Generation *common.GenerationParams `json:"generation,omitempty"`
+
+	// Locale to write the page in, such as es-MX, instead of the tenant's
+	Language string `json:"language,omitempty"`
+
+	// Generate site metadata for the page, overriding site_metadata_enabled
+	SiteMetadata *bool `json:"site_metadata,omitempty"`
+
+	// Generate a multi-page site with these pages (slugs such as about), or
+	// with pages derived from the onboarding goals when MultiPage is set
+	Pages     []string `json:"pages,omitempty"`
+	MultiPage bool     `json:"multi_page,omitempty"`
+
+	// Ask the model for missing facts before generating, overriding
+	// clarification_enabled. SkipClarification resumes a paused chat,
+	// assuming whatever Variables don't answer.
+	Clarify           *bool `json:"clarify,omitempty"`
+	SkipClarification bool  `json:"skip_clarification,omitempty"`
+
+	// Generate even when an identical request was just made, instead of
+	// getting its result
+	Force bool `json:"force,omitempty"`
+}
+
+// EditTarget picks the element to replace in a targeted edit: a simple CSS
+// selector (tag, #id, .class and [attr=value], no combinators) matching
+// exactly one element, or the index of a top-level <section>
+type EditTarget struct {
+	Selector     string `json:"selector,omitempty"`
+	SectionIndex *int   `json:"section_index,omitempty"`
+}
+
+// SectionEditDiff is the element a targeted edit replaced, before and after
+type SectionEditDiff struct {
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// SectionDiff lists, by id, the top-level sections an update added, removed,
+// modified and kept compared to the page it started from. Sections without
+// an id are named section-<n> by their position.
+type SectionDiff struct {
+	Base      string   `json:"base"` // draft or publication
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Modified  []string `json:"modified"`
+	Unchanged []string `json:"unchanged"`
 }
 
 // ChatResponse represents the response to a chat request
@@ -70,6 +214,83 @@ type ChatResponse struct {
 	Message        ChatMessage `json:"message"`
 	Timestamp      int64       `json:"timestamp"`
 	TemplateOutput string      `json:"template_output,omitempty"` // For template-based responses
+	Images         []ChatImage `json:"images,omitempty"`          // Images chosen by the image processor
+
+	// Outcome of each post-processor, in the order they ran
+	ProcessingReport []common.ProcessorReport `json:"processing_report,omitempty"`
+
+	// Set for targeted edits; Message holds the whole updated document
+	SectionEdit *SectionEditDiff `json:"section_edit,omitempty"`
+
+	// Generation parameters in effect, after model defaults and limits
+	Generation *common.GenerationParams `json:"generation,omitempty"`
+
+	// Filesystem draft the generated page was saved to, if any
+	Draft *ChatDraft `json:"draft,omitempty"`
+
+	// Locale the page was requested in, and whether the reply was detected
+	// as another language (after the retry, when language_retry is on)
+	Language         string `json:"language,omitempty"`
+	LanguageMismatch bool   `json:"language_mismatch,omitempty"`
+
+	// Structured summary of the page, and the tokens spent generating it
+	SiteMetadata      *SiteMetadata      `json:"site_metadata,omitempty"`
+	SiteMetadataUsage *SiteMetadataUsage `json:"site_metadata_usage,omitempty"`
+
+	// Pages of a multi-page generation, with their token usage
+	Site *SiteManifest `json:"site,omitempty"`
+
+	// Set when the generation paused to ask for missing facts, with
+	// ChatStage clarification and an empty Message
+	Clarification *ChatClarification `json:"clarification,omitempty"`
+
+	// Questions the generation went ahead without answers to, with the
+	// assumption it made for each
+	Assumptions []ClarificationQuestion `json:"assumptions,omitempty"`
+
+	// Set for update generations that started from the current site
+	SectionDiff *SectionDiff `json:"section_diff,omitempty"`
+
+	// Set when the model's output was cut short after its last complete
+	// top-level element, with why: output_too_large or repetition
+	Truncated       bool   `json:"truncated,omitempty"`
+	TruncatedReason string `json:"truncated_reason,omitempty"`
+
+	// Set when this is the result of an identical earlier request rather
+	// than a new generation
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// ChatDraft locates a generated page saved to the tenant filesystem: Key
+// always holds the latest page and VersionKey this generation's
+type ChatDraft struct {
+	Key        string `json:"key"`
+	VersionKey string `json:"version_key"`
+	Version    string `json:"version"`
+
+	// Site metadata of this generation's version, when generated
+	MetadataKey string `json:"metadata_key,omitempty"`
+}
+
+// MarshalJSON adds timestamp_iso, the RFC3339 UTC form of Timestamp
+func (r ChatResponse) MarshalJSON() ([]byte, error) {
+	type plain ChatResponse
+	return json.Marshal(struct {
+		plain
+		TimestampISO string `json:"timestamp_iso,omitempty"`
+	}{plain(r), common.FormatUnix(r.Timestamp)})
+}
+
+// ChatImage describes an image placed in generated content. NodeID matches
+// the data-image-id attribute on the element.
+type ChatImage struct {
+	NodeID   string `json:"nodeId"`
+	PhotoID  string `json:"photoId"`
+	UploadID uint   `json:"uploadId,omitempty"` // Set instead of PhotoID for tenant uploads
+	Alt      string `json:"alt"`
+	Credit   string `json:"credit,omitempty"`
+	// Orientation the photo was searched with, empty for any
+	Orientation string `json:"orientation,omitempty"`
 }
 
 // NewChat creates a new chat instance
@@ -96,6 +317,67 @@ func (c *Chat) AddMessage(message *ChatMessage) {
 	c.ChatStage = ChatStageUserInput
 }
 
+// ChatMeta is a chat summary without the messages
+type ChatMeta struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	ChatStage    ChatStage `json:"chat_stage"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    int64     `json:"created_at"`
+	UpdatedAt    int64     `json:"updated_at"`
+	DeletedAt    int64     `json:"deleted_at,omitempty"`
+}
+
+// MarshalJSON adds created_at_iso, updated_at_iso and deleted_at_iso, the
+// RFC3339 UTC forms of CreatedAt, UpdatedAt and DeletedAt
+func (m ChatMeta) MarshalJSON() ([]byte, error) {
+	type plain ChatMeta
+	return json.Marshal(struct {
+		plain
+		CreatedAtISO string `json:"created_at_iso,omitempty"`
+		UpdatedAtISO string `json:"updated_at_iso,omitempty"`
+		DeletedAtISO string `json:"deleted_at_iso,omitempty"`
+	}{plain(m), common.FormatUnix(m.CreatedAt), common.FormatUnix(m.UpdatedAt), common.FormatUnix(m.DeletedAt)})
+}
+
+// Meta returns the chat summary
+func (c *Chat) Meta() ChatMeta {
+	return ChatMeta{
+		ID:           c.ID,
+		Title:        c.Title,
+		ChatStage:    c.ChatStage,
+		MessageCount: len(c.Messages),
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		DeletedAt:    c.DeletedAt,
+	}
+}
+
+// NeedsTitle reports whether the chat has just received its first assistant
+// response and has no title yet
+func (c *Chat) NeedsTitle() bool {
+	if c.Title != "" {
+		return false
+	}
+	assistantMessages := 0
+	for _, msg := range c.Messages {
+		if msg.Role == ChatMessageRoleAssistant {
+			assistantMessages++
+		}
+	}
+	return assistantMessages == 1
+}
+
+// FirstUserMessage returns the content of the first user message
+func (c *Chat) FirstUserMessage() string {
+	for _, msg := range c.Messages {
+		if msg.Role == ChatMessageRoleUser {
+			return msg.Content
+		}
+	}
+	return ""
+}
+
 // ToJSON converts the chat to JSON
 func (c *Chat) ToJSON() ([]byte, error) {
 	return json.Marshal(c)
@@ -119,3 +401,38 @@ func (c *Chat) GetMessageHistory() string {
 	}
 	return result
 }
+
+// LatestAssistantIndex returns the index of the last assistant message, or
+// -1 when there is none
+func (c *Chat) LatestAssistantIndex() int {
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		if c.Messages[i].Role == ChatMessageRoleAssistant {
+			return i
+		}
+	}
+	return -1
+}
+
+// CompactMessageHistory formats the history like GetMessageHistory, but
+// with only the latest assistant page in full. Earlier pages are replaced by
+// a placeholder with their Summary, and user messages are cut to
+// maxUserRunes (0 keeps them whole).
+func (c *Chat) CompactMessageHistory(maxUserRunes int) string {
+	latest := c.LatestAssistantIndex()
+
+	var b strings.Builder
+	for i, msg := range c.Messages {
+		content := msg.Content
+		switch {
+		case msg.Role == ChatMessageRoleAssistant && i != latest:
+			content = "[Earlier version of the page, omitted]"
+			if msg.Summary != "" {
+				content = "[Earlier version of the page, omitted. Outline:\n" + msg.Summary + "]"
+			}
+		case msg.Role == ChatMessageRoleUser && maxUserRunes > 0 && utf8.RuneCountInString(content) > maxUserRunes:
+			content = string([]rune(content)[:maxUserRunes]) + " [...]"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", msg.Role, content)
+	}
+	return b.String()
+}
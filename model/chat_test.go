@@ -0,0 +1,88 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// 2026-03-01T09:30:00Z and an hour later
+const (
+	testCreatedAt int64 = 1772357400
+	testUpdatedAt int64 = 1772361000
+)
+
+func TestTimestampSerialization(t *testing.T) {
+	message := ChatMessage{ID: "m1", Role: ChatMessageRoleUser, Content: "hi", Timestamp: testCreatedAt}
+	tests := []struct {
+		name    string
+		value   any
+		want    []string
+		notWant []string
+	}{
+		{"message", message, []string{
+			`"timestamp":1772357400`,
+			`"timestamp_iso":"2026-03-01T09:30:00Z"`,
+		}, nil},
+		{"message pointer", &message, []string{`"timestamp_iso":"2026-03-01T09:30:00Z"`}, nil},
+		{"message without timestamp", ChatMessage{ID: "m2"}, []string{`"timestamp":0`}, []string{"timestamp_iso"}},
+		{"chat", &Chat{ID: "c1", Messages: []ChatMessage{message}, CreatedAt: testCreatedAt, UpdatedAt: testUpdatedAt}, []string{
+			`"created_at":1772357400`,
+			`"created_at_iso":"2026-03-01T09:30:00Z"`,
+			`"updated_at_iso":"2026-03-01T10:30:00Z"`,
+			// Nested messages get theirs too
+			`"timestamp_iso":"2026-03-01T09:30:00Z"`,
+		}, []string{"deleted_at"}},
+		{"trashed chat", Chat{ID: "c1", CreatedAt: testCreatedAt, DeletedAt: testUpdatedAt}, []string{
+			`"deleted_at":1772361000`,
+			`"deleted_at_iso":"2026-03-01T10:30:00Z"`,
+		}, []string{"updated_at_iso"}},
+		{"response", ChatResponse{ChatID: "c1", Message: message, Timestamp: testUpdatedAt}, []string{
+			`"timestamp":1772361000`,
+			`"timestamp_iso":"2026-03-01T10:30:00Z"`,
+			`"message":{"id":"m1"`,
+		}, nil},
+		{"meta", ChatMeta{ID: "c1", CreatedAt: testCreatedAt, UpdatedAt: testUpdatedAt}, []string{
+			`"created_at_iso":"2026-03-01T09:30:00Z"`,
+			`"updated_at_iso":"2026-03-01T10:30:00Z"`,
+		}, []string{"deleted_at"}},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.value)
+		if err != nil {
+			t.Fatalf("%s: Marshal() error = %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(data), want) {
+				t.Errorf("%s: %s lacks %s", tt.name, data, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(string(data), notWant) {
+				t.Errorf("%s: %s has %s", tt.name, data, notWant)
+			}
+		}
+	}
+}
+
+func TestChatJSONRoundTrip(t *testing.T) {
+	chat := &Chat{
+		ID:        "c1",
+		Messages:  []ChatMessage{{ID: "m1", Role: ChatMessageRoleAssistant, Content: "<html></html>", Timestamp: testUpdatedAt}},
+		CreatedAt: testCreatedAt,
+		UpdatedAt: testUpdatedAt,
+		Revision:  3,
+	}
+	data, err := chat.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromJSON(data)
+	if err != nil {
+		t.Fatalf("FromJSON() error = %v", err)
+	}
+	if got.CreatedAt != testCreatedAt || got.UpdatedAt != testUpdatedAt || got.Revision != 3 ||
+		len(got.Messages) != 1 || got.Messages[0].Timestamp != testUpdatedAt {
+		t.Errorf("FromJSON(ToJSON()) = %+v, want %+v", got, chat)
+	}
+}
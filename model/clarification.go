@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxClarificationTextLength caps the question and assumption of a
+// clarification question, in characters
+const MaxClarificationTextLength = 300
+
+var clarificationKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// ClarificationQuestion is a fact the model asked for before generating.
+// The answer is sent back as the variable named Key.
+type ClarificationQuestion struct {
+	Key        string `json:"key"`
+	Question   string `json:"question"`
+	Assumption string `json:"assumption,omitempty"` // Used when unanswered
+}
+
+// ChatClarification is the set of questions a paused generation waits on
+type ChatClarification struct {
+	Questions []ClarificationQuestion `json:"questions"`
+
+	// User message the paused generation was for
+	MessageID string `json:"message_id"`
+
+	AskedAt   int64 `json:"asked_at"`
+	ExpiresAt int64 `json:"expires_at"` // Unanswered questions are assumed after this
+}
+
+// Expired reports whether the questions may be assumed without asking
+func (c *ChatClarification) Expired(now time.Time) bool {
+	return now.Unix() >= c.ExpiresAt
+}
+
+// Message returns the paused user message from the chat's messages
+func (c *ChatClarification) Message(chat *Chat) *ChatMessage {
+	for i := len(chat.Messages) - 1; i >= 0; i-- {
+		if chat.Messages[i].ID == c.MessageID {
+			return &chat.Messages[i]
+		}
+	}
+	return nil
+}
+
+// ValidateClarificationQuestions trims the questions and checks them: keys
+// in snake_case, unique, and questions of at most
+// MaxClarificationTextLength characters. At most max are kept.
+func ValidateClarificationQuestions(questions []ClarificationQuestion, max int) ([]ClarificationQuestion, error) {
+	seen := map[string]bool{}
+	valid := make([]ClarificationQuestion, 0, len(questions))
+	for i, q := range questions {
+		q.Key = strings.TrimSpace(q.Key)
+		q.Question = strings.TrimSpace(q.Question)
+		q.Assumption = strings.TrimSpace(q.Assumption)
+		if !clarificationKeyPattern.MatchString(q.Key) {
+			return nil, fmt.Errorf("questions[%d].key: must be snake_case, got %q", i, q.Key)
+		}
+		if seen[q.Key] {
+			return nil, fmt.Errorf("questions[%d].key: duplicate key %q", i, q.Key)
+		}
+		if q.Question == "" {
+			return nil, fmt.Errorf("questions[%d].question: is required", i)
+		}
+		if utf8.RuneCountInString(q.Question) > MaxClarificationTextLength || utf8.RuneCountInString(q.Assumption) > MaxClarificationTextLength {
+			return nil, fmt.Errorf("questions[%d]: question and assumption must be at most %d characters", i, MaxClarificationTextLength)
+		}
+		seen[q.Key] = true
+		valid = append(valid, q)
+	}
+	if len(valid) > max {
+		valid = valid[:max]
+	}
+	return valid, nil
+}
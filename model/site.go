@@ -0,0 +1,187 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"awning-backend/common"
+)
+
+// SiteHomePage is the slug of the page served at /
+const SiteHomePage = "home"
+
+// Statuses of a page in a SiteManifest
+const (
+	SitePageDone   = "done"
+	SitePageFailed = "failed"
+)
+
+var sitePageSlugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// ErrNoSitePages fails a multi-page generation in which every page failed
+var ErrNoSitePages = errors.New("no page of the site could be generated")
+
+// ValidSitePageSlug reports whether slug can name a page: lowercase letters,
+// digits and single hyphens, up to 40 characters
+func ValidSitePageSlug(slug string) bool {
+	return len(slug) <= 40 && sitePageSlugPattern.MatchString(slug)
+}
+
+// SitePagePath returns the path a page is linked and served at: / for the
+// home page, /<slug> for the others
+func SitePagePath(slug string) string {
+	if slug == SiteHomePage {
+		return "/"
+	}
+	return "/" + slug
+}
+
+// SitePages returns the pages of a multi-page site derived from the
+// onboarding goals: home, a page per goal that needs one, about and contact
+func (o *OnboardingData) SitePages() []string {
+	pages := []string{SiteHomePage}
+	if o != nil {
+		for _, goal := range o.Goals {
+			switch goal {
+			case BusinessGoalServiceInfo:
+				pages = append(pages, "services")
+			case BusinessGoalPromotions:
+				pages = append(pages, "specials")
+			case BusinessGoalStoreTraffic:
+				pages = append(pages, "visit")
+			}
+		}
+	}
+	return slices.Compact(append(pages, "about", "contact"))
+}
+
+// SitePlan is the shared plan of a multi-page site, asked of the model
+// before its pages are generated
+type SitePlan struct {
+	SiteName string         `json:"site_name"`
+	Pages    []SitePlanPage `json:"pages"`
+}
+
+// SitePlanPage is one page of a SitePlan
+type SitePlanPage struct {
+	Slug     string   `json:"slug"`
+	Title    string   `json:"title"`
+	NavLabel string   `json:"nav_label"`
+	Purpose  string   `json:"purpose"`
+	Sections []string `json:"sections,omitempty"`
+}
+
+// DefaultSitePlan is the plan used when the model's plan fails or for mock
+// responses: each page titled after its slug
+func DefaultSitePlan(siteName string, slugs []string) *SitePlan {
+	plan := &SitePlan{SiteName: siteName}
+	for _, slug := range slugs {
+		title := strings.ToUpper(slug[:1]) + strings.ReplaceAll(slug[1:], "-", " ")
+		plan.Pages = append(plan.Pages, SitePlanPage{Slug: slug, Title: title, NavLabel: title})
+	}
+	return plan
+}
+
+// Validate trims the plan's strings and checks it has exactly the requested
+// pages. Pages are put in the requested order, and a missing nav label
+// defaults to the title.
+func (p *SitePlan) Validate(slugs []string) error {
+	p.SiteName = strings.TrimSpace(p.SiteName)
+	if len(p.Pages) != len(slugs) {
+		return fmt.Errorf("pages: expected %d pages, got %d", len(slugs), len(p.Pages))
+	}
+
+	bySlug := map[string]SitePlanPage{}
+	for i, page := range p.Pages {
+		page.Slug = strings.TrimSpace(page.Slug)
+		page.Title = strings.TrimSpace(page.Title)
+		page.NavLabel = strings.TrimSpace(page.NavLabel)
+		page.Purpose = strings.TrimSpace(page.Purpose)
+		if !slices.Contains(slugs, page.Slug) {
+			return fmt.Errorf("pages[%d]: unexpected slug %q", i, page.Slug)
+		}
+		if _, ok := bySlug[page.Slug]; ok {
+			return fmt.Errorf("pages[%d]: duplicate slug %q", i, page.Slug)
+		}
+		if page.Title == "" {
+			return fmt.Errorf("pages[%d]: title is required", i)
+		}
+		if page.NavLabel == "" {
+			page.NavLabel = page.Title
+		}
+		bySlug[page.Slug] = page
+	}
+
+	for i, slug := range slugs {
+		p.Pages[i] = bySlug[slug]
+	}
+	return nil
+}
+
+// Nav returns the navigation links every page of the site shares, in page
+// order
+func (p *SitePlan) Nav() []SiteNavLink {
+	links := make([]SiteNavLink, len(p.Pages))
+	for i, page := range p.Pages {
+		links[i] = SiteNavLink{Slug: page.Slug, Label: page.NavLabel, Href: SitePagePath(page.Slug)}
+	}
+	return links
+}
+
+// SiteNavLink is one entry of a multi-page site's navigation
+type SiteNavLink struct {
+	Slug  string `json:"slug"`
+	Label string `json:"label"`
+	Href  string `json:"href"`
+}
+
+// TokenUsage is the tokens spent on one model request, or several
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// SiteManifest describes the pages of a multi-page generation. Completed
+// pages are stored in the tenant filesystem under Key; failed ones carry
+// their error instead.
+type SiteManifest struct {
+	SiteName string        `json:"site_name,omitempty"`
+	Nav      []SiteNavLink `json:"nav"`
+	Pages    []SitePage    `json:"pages"`
+
+	// Tokens spent on the site plan
+	PlanUsage *TokenUsage `json:"plan_usage,omitempty"`
+
+	// Filesystem key the manifest was saved to
+	Key string `json:"key,omitempty"`
+}
+
+// SitePage is one page of a SiteManifest
+type SitePage struct {
+	Slug   string `json:"slug"`
+	Title  string `json:"title"`
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// Filesystem key of the page and SHA-256 of the HTML stored there
+	Key  string `json:"key,omitempty"`
+	Hash string `json:"hash,omitempty"`
+
+	Usage            *TokenUsage              `json:"usage,omitempty"`
+	ProcessingReport []common.ProcessorReport `json:"processing_report,omitempty"`
+}
+
+// Done returns the pages that were generated and stored
+func (m *SiteManifest) Done() []SitePage {
+	var done []SitePage
+	for _, page := range m.Pages {
+		if page.Status == SitePageDone {
+			done = append(done, page)
+		}
+	}
+	return done
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Limits on the site metadata the model returns
+const (
+	MaxSiteMetadataSections  = 50
+	MaxSiteMetadataColors    = 12
+	MaxSiteMetadataFonts     = 6
+	MaxSiteMetadataNavLabels = 12
+)
+
+var hexColorPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// SiteMetadata is the structured summary of a generated page used by the
+// editor, generated from the page after its HTML
+type SiteMetadata struct {
+	Sections  []SiteMetadataSection `json:"sections"`
+	Palette   []string              `json:"palette"` // Hex colors, most prominent first
+	Fonts     []string              `json:"fonts"`
+	NavLabels []string              `json:"nav_labels,omitempty"`
+}
+
+// SiteMetadataSection is one top-level section of the page; ID is the
+// section element's id attribute
+type SiteMetadataSection struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// SiteMetadataUsage is the token usage of the site metadata phase, kept
+// apart from the page generation's
+type SiteMetadataUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	Attempts         int `json:"attempts"`
+}
+
+// Validate trims the metadata's strings and checks it against the schema
+// given to the model
+func (m *SiteMetadata) Validate() error {
+	if len(m.Sections) == 0 {
+		return errors.New("sections: at least one section is required")
+	}
+	if len(m.Sections) > MaxSiteMetadataSections {
+		return fmt.Errorf("sections: at most %d allowed", MaxSiteMetadataSections)
+	}
+	seen := map[string]bool{}
+	for i := range m.Sections {
+		section := &m.Sections[i]
+		section.ID = strings.TrimSpace(section.ID)
+		section.Title = strings.TrimSpace(section.Title)
+		if section.ID == "" || section.Title == "" {
+			return fmt.Errorf("sections[%d]: id and title are required", i)
+		}
+		if seen[section.ID] {
+			return fmt.Errorf("sections[%d]: duplicate id %q", i, section.ID)
+		}
+		seen[section.ID] = true
+	}
+
+	if len(m.Palette) == 0 || len(m.Palette) > MaxSiteMetadataColors {
+		return fmt.Errorf("palette: 1 to %d colors required", MaxSiteMetadataColors)
+	}
+	for i, color := range m.Palette {
+		m.Palette[i] = strings.TrimSpace(color)
+		if !hexColorPattern.MatchString(m.Palette[i]) {
+			return fmt.Errorf("palette[%d]: %q is not a hex color", i, color)
+		}
+	}
+
+	if len(m.Fonts) > MaxSiteMetadataFonts {
+		return fmt.Errorf("fonts: at most %d allowed", MaxSiteMetadataFonts)
+	}
+	if len(m.NavLabels) > MaxSiteMetadataNavLabels {
+		return fmt.Errorf("nav_labels: at most %d allowed", MaxSiteMetadataNavLabels)
+	}
+	for _, list := range [][]string{m.Fonts, m.NavLabels} {
+		for i := range list {
+			if list[i] = strings.TrimSpace(list[i]); list[i] == "" {
+				return errors.New("fonts and nav_labels must not contain empty names")
+			}
+		}
+	}
+	return nil
+}
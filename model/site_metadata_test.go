@@ -0,0 +1,47 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSiteMetadataValidate(t *testing.T) {
+	valid := func() SiteMetadata {
+		return SiteMetadata{
+			Sections: []SiteMetadataSection{{ID: " hero ", Title: " Welcome "}},
+			Palette:  []string{" #aabbcc", "#FFF"},
+			Fonts:    []string{"Inter "},
+		}
+	}
+
+	m := valid()
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if m.Sections[0].ID != "hero" || m.Sections[0].Title != "Welcome" || m.Palette[0] != "#aabbcc" || m.Fonts[0] != "Inter" {
+		t.Errorf("Validate() left %+v, want its strings trimmed", m)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(m *SiteMetadata)
+		want   string
+	}{
+		{"no sections", func(m *SiteMetadata) { m.Sections = nil }, "at least one section"},
+		{"untitled section", func(m *SiteMetadata) { m.Sections[0].Title = " " }, "sections[0]: id and title are required"},
+		{"duplicate id", func(m *SiteMetadata) {
+			m.Sections = append(m.Sections, SiteMetadataSection{ID: "hero", Title: "Again"})
+		}, `duplicate id "hero"`},
+		{"no palette", func(m *SiteMetadata) { m.Palette = nil }, "palette: 1 to 12"},
+		{"named color", func(m *SiteMetadata) { m.Palette[1] = "red" }, `palette[1]: "red" is not a hex color`},
+		{"too many fonts", func(m *SiteMetadata) { m.Fonts = strings.Split("a,b,c,d,e,f,g", ",") }, "fonts: at most 6"},
+		{"empty nav label", func(m *SiteMetadata) { m.NavLabels = []string{"Home", ""} }, "must not contain empty names"},
+	}
+	for _, tt := range tests {
+		m := valid()
+		tt.mutate(&m)
+		if err := m.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
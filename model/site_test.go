@@ -0,0 +1,118 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSitePlanValidate(t *testing.T) {
+	slugs := []string{"home", "about", "contact"}
+	plan := SitePlan{
+		SiteName: " Crumb & Co ",
+		Pages: []SitePlanPage{
+			{Slug: "contact", Title: " Contact us ", NavLabel: "Contact"},
+			{Slug: " home", Title: "Welcome", NavLabel: " Home "},
+			{Slug: "about", Title: "Our story"},
+		},
+	}
+	if err := plan.Validate(slugs); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if plan.SiteName != "Crumb & Co" {
+		t.Errorf("SiteName = %q, want it trimmed", plan.SiteName)
+	}
+
+	// Pages come back in the requested order, nav labels defaulted
+	want := []SiteNavLink{
+		{Slug: "home", Label: "Home", Href: "/"},
+		{Slug: "about", Label: "Our story", Href: "/about"},
+		{Slug: "contact", Label: "Contact", Href: "/contact"},
+	}
+	if got := plan.Nav(); !slices.Equal(got, want) {
+		t.Errorf("Nav() = %+v, want %+v", got, want)
+	}
+	if plan.Pages[2].Title != "Contact us" {
+		t.Errorf("contact title = %q, want it trimmed", plan.Pages[2].Title)
+	}
+
+	tests := []struct {
+		name  string
+		pages []SitePlanPage
+		want  string
+	}{
+		{"too few pages", []SitePlanPage{{Slug: "home", Title: "Home"}}, "expected 3 pages, got 1"},
+		{"unexpected slug", []SitePlanPage{{Slug: "home", Title: "Home"}, {Slug: "about", Title: "About"}, {Slug: "blog", Title: "Blog"}}, `pages[2]: unexpected slug "blog"`},
+		{"duplicate slug", []SitePlanPage{{Slug: "home", Title: "Home"}, {Slug: "home", Title: "Again"}, {Slug: "about", Title: "About"}}, `pages[1]: duplicate slug "home"`},
+		{"untitled page", []SitePlanPage{{Slug: "home", Title: "Home"}, {Slug: "about", Title: " "}, {Slug: "contact", Title: "Contact"}}, "pages[1]: title is required"},
+	}
+	for _, tt := range tests {
+		bad := SitePlan{Pages: tt.pages}
+		if err := bad.Validate(slugs); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestDefaultSitePlan(t *testing.T) {
+	plan := DefaultSitePlan("Crumb & Co", []string{"home", "our-team"})
+	if err := plan.Validate([]string{"home", "our-team"}); err != nil {
+		t.Fatalf("default plan doesn't validate: %v", err)
+	}
+	if plan.SiteName != "Crumb & Co" || plan.Pages[0].Title != "Home" || plan.Pages[1].Title != "Our team" || plan.Pages[1].NavLabel != "Our team" {
+		t.Errorf("DefaultSitePlan() = %+v", plan)
+	}
+}
+
+func TestOnboardingSitePages(t *testing.T) {
+	tests := []struct {
+		goals []BusinessGoal
+		want  []string
+	}{
+		{nil, []string{"home", "about", "contact"}},
+		{[]BusinessGoal{BusinessGoalServiceInfo, BusinessGoalCustom}, []string{"home", "services", "about", "contact"}},
+		{[]BusinessGoal{BusinessGoalStoreTraffic, BusinessGoalPromotions}, []string{"home", "visit", "specials", "about", "contact"}},
+	}
+	for _, tt := range tests {
+		if got := (&OnboardingData{Goals: tt.goals}).SitePages(); !slices.Equal(got, tt.want) {
+			t.Errorf("SitePages() for %v = %q, want %q", tt.goals, got, tt.want)
+		}
+	}
+	var none *OnboardingData
+	if got := none.SitePages(); !slices.Equal(got, []string{"home", "about", "contact"}) {
+		t.Errorf("SitePages() without onboarding data = %q", got)
+	}
+}
+
+func TestValidSitePageSlug(t *testing.T) {
+	for slug, want := range map[string]bool{
+		"about":                 true,
+		"our-team":              true,
+		"faq2":                  true,
+		"":                      false,
+		"About":                 false,
+		"our--team":             false,
+		"-about":                false,
+		"about/team":            false,
+		strings.Repeat("a", 41): false,
+	} {
+		if got := ValidSitePageSlug(slug); got != want {
+			t.Errorf("ValidSitePageSlug(%q) = %v, want %v", slug, got, want)
+		}
+	}
+	if SitePagePath("home") != "/" || SitePagePath("about") != "/about" {
+		t.Errorf("SitePagePath() = %q, %q", SitePagePath("home"), SitePagePath("about"))
+	}
+}
+
+func TestSiteManifestDone(t *testing.T) {
+	manifest := SiteManifest{Pages: []SitePage{
+		{Slug: "home", Status: SitePageDone},
+		{Slug: "about", Status: SitePageFailed},
+		{Slug: "contact", Status: SitePageDone},
+	}}
+	done := manifest.Done()
+	if len(done) != 2 || done[0].Slug != "home" || done[1].Slug != "contact" {
+		t.Errorf("Done() = %+v, want home and contact", done)
+	}
+}
@@ -0,0 +1,49 @@
+package openapi
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:generate go run ../cmd/openapi -o openapi.json
+
+// spec is the generated document, checked in and refreshed with make openapi
+//
+//go:embed openapi.json
+var spec []byte
+
+const SPEC_PATH = "/api/v1/openapi.json"
+
+// docsPage loads Swagger UI from the CDN and points it at the spec
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Awning API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "` + SPEC_PATH + `", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// RegisterRoutes serves the spec and, when docsEnabled is set, Swagger UI at
+// /api/docs. Neither requires authentication.
+func RegisterRoutes(r *gin.Engine, docsEnabled bool) {
+	r.GET(SPEC_PATH, func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	})
+
+	if docsEnabled {
+		r.GET("/api/docs", func(c *gin.Context) {
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+		})
+	}
+}
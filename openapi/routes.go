@@ -0,0 +1,420 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/i18n"
+	"awning-backend/metrics"
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/pricing"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+	"awning-backend/sections/tenant/chat"
+	"awning-backend/sections/tenant/contact"
+	"awning-backend/sections/tenant/dashboard"
+	"awning-backend/sections/tenant/domains"
+	"awning-backend/sections/tenant/filesystem"
+	"awning-backend/sections/tenant/offboarding"
+	"awning-backend/sections/tenant/payment"
+	"awning-backend/sections/tenant/profile"
+	"awning-backend/sections/tenant/publish"
+	"awning-backend/sections/tenant/usage"
+	"awning-backend/services"
+	"awning-backend/storage"
+)
+
+var (
+	public = []string{SchemeFrontendKey}
+	user   = []string{SchemeFrontendKey, SchemeBearer}
+	admin  = []string{SchemeFrontendKey, SchemeApiKey}
+
+	message = Object{"message": ""}
+
+	usageReportParams = []Param{
+		{Name: "from", Description: "UTC date (YYYY-MM-DD), defaults to the start of to's month, or year for months"},
+		{Name: "to", Description: "UTC date (YYYY-MM-DD), inclusive, defaults to today"},
+		{Name: "granularity", Description: "day (default, at most 366) or month (at most 36)"},
+		{Name: "format", Description: "json (default) or csv, streamed with a total row"},
+	}
+)
+
+// marshalExtras lists the fields added by MarshalJSON methods, which are not
+// visible on the struct
+var marshalExtras = map[reflect.Type]Object{
+	reflect.TypeOf(model.Chat{}):         {"created_at_iso": "", "updated_at_iso": ""},
+	reflect.TypeOf(model.ChatMeta{}):     {"created_at_iso": "", "updated_at_iso": ""},
+	reflect.TypeOf(model.ChatMessage{}):  {"timestamp_iso": ""},
+	reflect.TypeOf(model.ChatResponse{}): {"timestamp_iso": ""},
+}
+
+// Routes is the catalog of documented routes. Keep it in step with the
+// RegisterRoutes functions and run make openapi after changing either; the
+// serverbuilder and openapi tests fail when they drift.
+var Routes = []Route{
+	// Auth and users
+	{Method: http.MethodPost, Path: "/api/v1/auth/register", Tag: "auth", Summary: "Register a user",
+		Security: public, Request: users.RegisterRequest{}, Status: http.StatusCreated, Response: users.AuthResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/auth/login", Tag: "auth", Summary: "Log in with email and password",
+		Security: public, Request: users.LoginRequest{}, Response: common.ApiResponse[users.AuthResponse]{}},
+	{Method: http.MethodPost, Path: "/api/v1/auth/password-reset/request", Tag: "auth", Summary: "Request a password reset email",
+		Security: public, Request: users.PasswordResetRequest{}, Response: common.ApiResponse[any]{}},
+	{Method: http.MethodPost, Path: "/api/v1/auth/password-reset/confirm", Tag: "auth", Summary: "Set a new password with a reset token",
+		Security: public, Request: users.PasswordResetConfirmRequest{}, Response: common.ApiResponse[any]{}},
+	{Method: http.MethodPost, Path: "/api/v1/auth/oauth/exchange", Tag: "auth", Summary: "Exchange a one-time OAuth redirect code for the login",
+		Security: public, Request: users.OAuthExchangeRequest{}, Response: common.ApiResponse[users.OAuthExchangeResponse]{}},
+	{Method: http.MethodGet, Path: "/api/v1/users/me", Tag: "users", Summary: "Get the current user",
+		Security: user, Response: common.ApiResponse[users.UserResponse]{}},
+	{Method: http.MethodPut, Path: "/api/v1/users/me", Tag: "users", Summary: "Update the current user's name",
+		Security: user, Request: users.UpdateUserRequest{}, Response: common.ApiResponse[users.UserResponse]{}},
+	{Method: http.MethodGet, Path: "/api/v1/users/me/tenants", Tag: "users", Summary: "List the current user's tenants",
+		Security: user, Response: common.ApiResponse[[]users.TenantResponse]{}},
+	{Method: http.MethodPatch, Path: "/api/v1/users/me/tenants/:schema/primary", Tag: "users", Summary: "Make a tenant the current user's primary tenant",
+		Security: user, Response: users.TenantTokenResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/tenants", Tag: "users", Summary: "Create an additional tenant owned by the current user",
+		Security: user, Request: users.CreateTenantRequest{}, Status: http.StatusCreated, Response: users.TenantTokenResponse{}},
+
+	// Plans
+	{Method: http.MethodGet, Path: "/api/v1/plans", Tag: "plans", Summary: "List plans",
+		Security: public, Query: []Param{{Name: "currency", Description: "Localize prices to this currency"}},
+		Response: Object{"plans": []pricing.PlanResponse{}}},
+	{Method: http.MethodGet, Path: "/api/v1/plans/:id", Tag: "plans", Summary: "Get a plan",
+		Security: public, Query: []Param{{Name: "currency", Description: "Localize prices to this currency"}},
+		Response: pricing.PlanResponse{}},
+
+	// Meta
+	{Method: http.MethodGet, Path: "/api/v1/meta/locales", Tag: "meta", Summary: "List the locales error messages are translated into",
+		Response: i18n.LocalesResponse{}},
+
+	// Chat
+	{Method: http.MethodPost, Path: "/api/v1/chat/stream", Tag: "chat", Summary: "Send a message and stream the response",
+		Security: user, Request: model.ChatRequest{}, Stream: true},
+	{Method: http.MethodPost, Path: "/api/v1/chat/complete", Tag: "chat", Summary: "Send a message and wait for the response",
+		Security: user, Request: model.ChatRequest{}, Response: model.ChatResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/chat/generate", Tag: "chat", Summary: "Queue a generation to poll for instead of streaming it",
+		Security: user, Request: model.ChatRequest{}, Status: http.StatusAccepted, Response: storage.GenerationJob{}},
+	{Method: http.MethodGet, Path: "/api/v1/chat/generate/:jobId", Tag: "chat", Summary: "Get the status, progress and result of a queued generation",
+		Security: user, Response: storage.GenerationJob{}},
+	{Method: http.MethodGet, Path: "/api/v1/chat/:id", Tag: "chat", Summary: "Get a chat with its messages",
+		Security: user, Query: []Param{{Name: "include", Description: "Pass content for whole message contents instead of previews"}},
+		Response: model.Chat{}},
+	{Method: http.MethodGet, Path: "/api/v1/chat/:id/meta", Tag: "chat", Summary: "Get a chat summary",
+		Security: user, Response: model.ChatMeta{}},
+	{Method: http.MethodGet, Path: "/api/v1/chat/:id/content/:messageId", Tag: "chat", Summary: "Get the content of a message referenced by the done event",
+		Security: user, Response: chat.MessageContent{}},
+	{Method: http.MethodGet, Path: "/api/v1/chat/:id/messages/:messageId", Tag: "chat", Summary: "Get a message with its whole content",
+		Security: user, Response: model.ChatMessage{}},
+	{Method: http.MethodPost, Path: "/api/v1/chat/:id/messages/:messageId/feedback", Tag: "chat", Summary: "Rate an assistant message",
+		Security: user, Request: chat.FeedbackRequest{}, Response: models.MessageFeedback{}},
+	{Method: http.MethodPatch, Path: "/api/v1/chat/:id", Tag: "chat", Summary: "Rename a chat",
+		Security: user, Request: chat.UpdateChatRequest{}, Response: model.ChatMeta{}},
+	{Method: http.MethodDelete, Path: "/api/v1/chat/:id", Tag: "chat", Summary: "Move a chat to the trash",
+		Security: user, Response: message},
+	{Method: http.MethodGet, Path: "/api/v1/chat/trash", Tag: "chat", Summary: "List chats in the trash, most recently deleted first",
+		Security: user,
+		Query: []Param{
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"items": []model.ChatMeta{}, "page": 0, "perPage": 0, "total": 0, "retentionDays": 0}},
+	{Method: http.MethodPost, Path: "/api/v1/chat/:id/restore", Tag: "chat", Summary: "Restore a chat from the trash",
+		Security: user, Response: model.ChatMeta{}},
+	{Method: http.MethodDelete, Path: "/api/v1/chat/trash/:id", Tag: "chat", Summary: "Delete a chat in the trash permanently",
+		Security: user, Response: message},
+
+	// Profile and account
+	{Method: http.MethodGet, Path: "/api/v1/profile", Tag: "profile", Summary: "Get the tenant profile",
+		Security: user, Tenant: true, Response: profile.ProfileResponse{}},
+	{Method: http.MethodPut, Path: "/api/v1/profile", Tag: "profile", Summary: "Update the tenant profile",
+		Security: user, Tenant: true, Request: profile.ProfileRequest{}, Response: profile.ProfileResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/settings", Tag: "settings", Summary: "List tenant settings with their effective values",
+		Security: user, Tenant: true, Response: Object{"settings": []settings.Setting{}}},
+	{Method: http.MethodPut, Path: "/api/v1/settings/:key", Tag: "settings", Summary: "Set a tenant setting; null restores the default",
+		Security: user, Tenant: true, Request: settings.SettingRequest{}, Response: settings.Setting{}},
+	{Method: http.MethodGet, Path: "/api/v1/settings/brand", Tag: "settings", Summary: "Get the tenant's brand settings",
+		Security: user, Tenant: true, Response: settings.Brand{}},
+	{Method: http.MethodPut, Path: "/api/v1/settings/brand", Tag: "settings", Summary: "Set the brand settings in the body; null restores a default",
+		Security: user, Tenant: true, Request: settings.BrandRequest{}, Response: settings.Brand{}},
+	{Method: http.MethodGet, Path: "/api/v1/webhooks", Tag: "webhooks", Summary: "List the tenant's webhooks",
+		Security: user, Tenant: true, Response: Object{"webhooks": []models.TenantWebhook{}, "eventTypes": []string{}}},
+	{Method: http.MethodPost, Path: "/api/v1/webhooks", Tag: "webhooks", Summary: "Add a webhook; its signing secret is only returned here",
+		Security: user, Tenant: true, Request: webhooks.CreateWebhookRequest{}, Status: http.StatusCreated,
+		Response: Object{"webhook": models.TenantWebhook{}, "secret": ""}},
+	{Method: http.MethodGet, Path: "/api/v1/webhooks/:id", Tag: "webhooks", Summary: "Get a webhook",
+		Security: user, Tenant: true, Response: models.TenantWebhook{}},
+	{Method: http.MethodPatch, Path: "/api/v1/webhooks/:id", Tag: "webhooks", Summary: "Change a webhook's URL, events or whether it is enabled",
+		Security: user, Tenant: true, Request: webhooks.UpdateWebhookRequest{}, Response: models.TenantWebhook{}},
+	{Method: http.MethodDelete, Path: "/api/v1/webhooks/:id", Tag: "webhooks", Summary: "Delete a webhook",
+		Security: user, Tenant: true, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/api/v1/webhooks/:id/deliveries", Tag: "webhooks", Summary: "List a webhook's delivery attempts, newest first",
+		Security: user, Tenant: true,
+		Query: []Param{
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"deliveries": []models.TenantWebhookDelivery{}, "page": 0, "perPage": 0, "total": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/notifications", Tag: "notifications", Summary: "List the tenant's notifications, newest first",
+		Security: user, Tenant: true,
+		Query: []Param{
+			{Name: "unread", Type: "boolean", Description: "Only list unread notifications"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"notifications": []models.TenantNotification{}, "page": 0, "perPage": 0, "total": int64(0), "unread": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/notifications/unread-count", Tag: "notifications", Summary: "Count the tenant's unread notifications",
+		Security: user, Tenant: true, Response: Object{"unread": int64(0)}},
+	{Method: http.MethodPost, Path: "/api/v1/notifications/:id/read", Tag: "notifications", Summary: "Mark a notification read",
+		Security: user, Tenant: true, Response: Object{"unread": int64(0)}},
+	{Method: http.MethodPost, Path: "/api/v1/notifications/read-all", Tag: "notifications", Summary: "Mark every notification read",
+		Security: user, Tenant: true, Response: Object{"marked": int64(0), "unread": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/account", Tag: "account", Summary: "Get the tenant account",
+		Security: user, Tenant: true, Response: account.AccountResponse{}},
+	{Method: http.MethodPut, Path: "/api/v1/account", Tag: "account", Summary: "Update the tenant account",
+		Security: user, Tenant: true, Request: account.UpdateAccountRequest{}, Response: account.AccountResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/account/credits/add", Tag: "account", Summary: "Add credits",
+		Security: user, Tenant: true, Idempotent: true, Request: account.CreditsRequest{}, Response: account.AccountResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/account/credits/use", Tag: "account", Summary: "Use credits",
+		Security: user, Tenant: true, Idempotent: true, Request: account.CreditsRequest{}, Response: account.AccountResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/account/credits/history", Tag: "account", Summary: "List credit transactions, newest first",
+		Security: user, Tenant: true,
+		Query: []Param{
+			{Name: "type", Description: "opening_balance, grant, usage, adjustment or refund"},
+			{Name: "creditType", Description: "basic or premium"},
+			{Name: "chatId"},
+			{Name: "from", Description: "RFC3339 time or YYYY-MM-DD"},
+			{Name: "to", Description: "RFC3339 time or YYYY-MM-DD, inclusive"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"transactions": []models.CreditTransaction{}, "page": 0, "perPage": 0, "total": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/account/quota", Tag: "account", Summary: "Get the generation quota for the current period",
+		Security: user, Tenant: true, Response: account.QuotaStatus{}},
+	{Method: http.MethodGet, Path: "/api/v1/account/spending", Tag: "account", Summary: "Get AI usage this month against the spending caps",
+		Security: user, Tenant: true, Response: account.SpendingStatus{}},
+	{Method: http.MethodGet, Path: "/api/v1/dashboard", Tag: "account", Summary: "Get the tenant dashboard: quota, credits, storage, domains, subscription and recent chats",
+		Security: user, Tenant: true, Response: dashboard.DashboardResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/usage/report", Tag: "account", Summary: "Report the tenant's tokens, generations and image searches per UTC day or month and model",
+		Security: user, Tenant: true, Query: usageReportParams, Response: usage.Report{}},
+
+	// Filesystem
+	{Method: http.MethodGet, Path: "/api/v1/filesystem", Tag: "filesystem", Summary: "List entries",
+		Security: user, Tenant: true, Query: []Param{{Name: "prefix", Description: "Only list keys with this prefix"}},
+		Response: Object{"entries": []filesystem.EntryMeta{}}},
+	{Method: http.MethodGet, Path: "/api/v1/filesystem/export", Tag: "filesystem", Summary: "Download all entries as a zip with a manifest.json",
+		Security: user, Tenant: true, Zip: true},
+	{Method: http.MethodPost, Path: "/api/v1/filesystem/import", Tag: "filesystem", Summary: "Import an exported zip, sent as the body or the multipart field file",
+		Security: user, Tenant: true,
+		Query: []Param{
+			{Name: "mode", Description: "merge (default) or replace, which deletes entries missing from the archive"},
+			{Name: "on_conflict", Description: "skip (default) or overwrite entries changed since the export"},
+		},
+		Response: Object{"mode": "", "results": []filesystem.ImportResult{}, "counts": map[string]int{}}},
+	{Method: http.MethodGet, Path: "/api/v1/filesystem/*key", Tag: "filesystem", Summary: "Get an entry, or search entries when key is search and q is set",
+		Security: user, Tenant: true,
+		Query: []Param{
+			{Name: "q", Description: "Search query, with key search"},
+			{Name: "prefix", Description: "Only search keys with this prefix"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: filesystem.FilesystemEntry{}},
+	{Method: http.MethodPut, Path: "/api/v1/filesystem/*key", Tag: "filesystem", Summary: "Create or replace an entry with any JSON value",
+		Security: user, Tenant: true, Request: Object{}, Response: filesystem.FilesystemEntry{}},
+	{Method: http.MethodDelete, Path: "/api/v1/filesystem/*key", Tag: "filesystem", Summary: "Delete an entry",
+		Security: user, Tenant: true, Response: message},
+
+	// Tenant offboarding
+	{Method: http.MethodDelete, Path: "/api/v1/tenant", Tag: "account", Summary: "Schedule the tenant for deletion after the grace period, exporting its data",
+		Security: user, Tenant: true, Request: offboarding.DeleteTenantRequest{}, Status: http.StatusAccepted,
+		Response: Object{"deletionScheduledAt": time.Time{}, "exportId": 0, "exportSize": 0, "subscriptionsCanceled": 0}},
+	{Method: http.MethodPost, Path: "/api/v1/tenant/restore", Tag: "account", Summary: "Cancel a scheduled tenant deletion",
+		Security: user, Tenant: true, Response: Object{"restored": true}},
+	{Method: http.MethodGet, Path: "/api/v1/tenant/export", Tag: "account", Summary: "Download the data exported when the tenant was scheduled for deletion",
+		Security: user, Tenant: true, Response: offboarding.TenantExport{}},
+
+	// Publications
+	{Method: http.MethodPost, Path: "/api/v1/publish", Tag: "publications", Summary: "Publish a filesystem entry or chat as the tenant's site, unless it is unchanged",
+		Security: user, Tenant: true, Request: publish.PublishRequest{}, Status: http.StatusCreated,
+		Response: Object{"publication": publish.PublicationResponse{}, "unchanged": false}},
+	{Method: http.MethodGet, Path: "/api/v1/publications", Tag: "publications", Summary: "List publications, newest first",
+		Security: user, Tenant: true, Response: Object{"publications": []publish.PublicationResponse{}}},
+	{Method: http.MethodPost, Path: "/api/v1/publications/:version/rollback", Tag: "publications", Summary: "Make an earlier publication current",
+		Security: user, Tenant: true, Response: Object{"publication": publish.PublicationResponse{}}},
+	{Method: http.MethodGet, Path: "/api/v1/publications/:version/signature", Tag: "publications", Summary: "Get the detached ES512 signature of a publication and the statement it signs",
+		Security: user, Tenant: true, Response: publish.SignatureResponse{}},
+	{Method: http.MethodGet, Path: "/.well-known/jwks.json", Tag: "publications", Summary: "List the public keys publication signatures are verified with",
+		Security: public, Response: auth.JWKSet{}},
+
+	// Contact form
+	{Method: http.MethodPost, Path: "/api/public/v1/contact", Tag: "contact", Summary: "Submit a published site's contact form, for the tenant of the request's origin or host",
+		Security: public, Request: contact.SubmitRequest{}, Status: http.StatusCreated, Response: contact.SubmitResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/contact/submissions", Tag: "contact", Summary: "List contact form submissions, newest first",
+		Security: user, Tenant: true, Query: []Param{{Name: "page", Type: "integer"}, {Name: "per_page", Type: "integer"}},
+		Response: Object{"submissions": []models.ContactSubmission{}, "page": 0, "perPage": 0, "total": 0}},
+
+	// Share links
+	{Method: http.MethodPost, Path: "/api/v1/shares", Tag: "shares", Summary: "Create a preview link to a chat message or filesystem entry",
+		Security: user, Tenant: true, Request: publish.ShareRequest{}, Status: http.StatusCreated, Response: publish.ShareResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/chat/:id/share", Tag: "shares", Summary: "Create a preview link to a chat's latest assistant message",
+		Security: user, Tenant: true, Request: publish.ShareRequest{}, Status: http.StatusCreated, Response: publish.ShareResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/shares", Tag: "shares", Summary: "List preview links",
+		Security: user, Tenant: true, Response: Object{"shares": []publish.ShareResponse{}}},
+	{Method: http.MethodDelete, Path: "/api/v1/shares/:id", Tag: "shares", Summary: "Revoke a preview link",
+		Security: user, Tenant: true, Status: http.StatusNoContent},
+	{Method: http.MethodGet, Path: "/preview/:token", Tag: "shares", Summary: "View a shared preview, or its password form",
+		HTML: true},
+	{Method: http.MethodPost, Path: "/preview/:token", Tag: "shares", Summary: "Submit the password of a protected preview",
+		Multipart: Object{"password": ""}, Status: http.StatusSeeOther},
+
+	// Domains
+	{Method: http.MethodGet, Path: "/api/v1/domains", Tag: "domains", Summary: "List domains",
+		Security: []string{SchemeBearer}, Tenant: true, Response: Object{"domains": []domains.DomainResponse{}}},
+	{Method: http.MethodGet, Path: "/api/v1/domains/expiring", Tag: "domains", Summary: "List domains expiring soon",
+		Security: []string{SchemeBearer}, Tenant: true, Query: []Param{{Name: "days", Type: "integer"}},
+		Response: Object{"domains": []domains.DomainResponse{}, "days": 0}},
+	{Method: http.MethodGet, Path: "/api/v1/domains/check", Tag: "domains", Summary: "Check whether a domain can be registered",
+		Security: []string{SchemeBearer}, Tenant: true, Query: []Param{{Name: "domain", Required: true}},
+		Response: domains.AvailabilityResult{}},
+	{Method: http.MethodPost, Path: "/api/v1/domains/register", Tag: "domains", Summary: "Register a domain",
+		Security: []string{SchemeBearer}, Tenant: true, Idempotent: true, Request: domains.RegisterDomainRequest{}, Status: http.StatusCreated,
+		Response: Object{"registration": domains.RegistrationResult{}, "domain": domains.DomainResponse{}}},
+	{Method: http.MethodGet, Path: "/api/v1/domains/:domain", Tag: "domains", Summary: "Get a domain",
+		Security: []string{SchemeBearer}, Tenant: true, Response: domains.DomainResponse{}},
+	{Method: http.MethodPost, Path: "/api/v1/domains", Tag: "domains", Summary: "Add a domain",
+		Security: []string{SchemeBearer}, Tenant: true, Request: domains.AddDomainRequest{}, Status: http.StatusCreated,
+		Response: domains.DomainResponse{}},
+	{Method: http.MethodDelete, Path: "/api/v1/domains/:domain", Tag: "domains", Summary: "Delete a domain",
+		Security: []string{SchemeBearer}, Tenant: true, Response: message},
+	{Method: http.MethodPost, Path: "/api/v1/domains/:domain/primary", Tag: "domains", Summary: "Make a domain primary",
+		Security: []string{SchemeBearer}, Tenant: true, Response: message},
+	{Method: http.MethodPost, Path: "/api/v1/domains/:domain/renew", Tag: "domains", Summary: "Pay for, then renew, a registered domain",
+		Security: []string{SchemeBearer}, Tenant: true, Request: domains.RenewDomainRequest{},
+		Response: Object{"renewal": domains.RenewalResult{}, "domain": domains.DomainResponse{}}},
+	{Method: http.MethodPost, Path: "/api/v1/domains/:domain/ssl/check", Tag: "domains", Summary: "Check the domain's certificate",
+		Security: []string{SchemeBearer}, Tenant: true, Response: domains.SSLCheckResult{}},
+
+	// Images
+	{Method: http.MethodGet, Path: "/api/v1/images/search", Tag: "images", Summary: "Search stock photos",
+		Security: user,
+		Query: []Param{
+			{Name: "query", Required: true},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+			{Name: "orientation", Description: "landscape, portrait or squarish"},
+			{Name: "order_by", Description: "latest, oldest or popular"},
+		},
+		Response: services.UnsplashSearchResponse{}},
+	{Method: http.MethodGet, Path: "/api/v1/images/photos/:id", Tag: "images", Summary: "Get a stock photo",
+		Security: user, Response: services.UnsplashPhoto{}},
+	{Method: http.MethodPost, Path: "/api/v1/images/upload", Tag: "images", Summary: "Upload an image",
+		Security: user, Tenant: true, Multipart: Object{"file": []byte{}, "keywords": "", "alt": "", "purpose": ""},
+		Status: http.StatusCreated, Response: models.TenantImage{}},
+	{Method: http.MethodGet, Path: "/api/v1/images", Tag: "images", Summary: "List uploaded images",
+		Security: user, Tenant: true, Query: []Param{{Name: "keyword"}},
+		Response: Object{"images": []models.TenantImage{}}},
+	{Method: http.MethodDelete, Path: "/api/v1/images/:id", Tag: "images", Summary: "Delete an uploaded image",
+		Security: user, Tenant: true, Response: message},
+
+	// Payments
+	{Method: http.MethodPost, Path: "/api/v1/payments/plan", Tag: "payments", Summary: "Create a payment intent for a plan",
+		Security: user, Idempotent: true, Request: payment.CreatePlanPaymentRequest{}, Response: common.ApiResponse[payment.PaymentIntentResponse]{}},
+	{Method: http.MethodPost, Path: "/api/v1/payments/checkout", Tag: "payments", Summary: "Create a checkout session",
+		Security: user, Idempotent: true, Request: payment.CreateCheckoutSessionRequest{}, Response: common.ApiResponse[payment.CheckoutSessionResponse]{}},
+	{Method: http.MethodPost, Path: "/api/v1/subscriptions/:id/change-plan", Tag: "payments", Summary: "Change a subscription's plan",
+		Security: user, Tenant: true, Request: payment.ChangePlanRequest{},
+		Response: Object{"subscription": models.Subscription{}}},
+
+	// Admin
+	{Method: http.MethodPost, Path: "/api/v1/admin/tenants/:tenantSchema/quota/grant", Tag: "admin", Summary: "Grant extra generations",
+		Security: admin, Request: account.GrantQuotaRequest{}, Response: account.QuotaStatus{}},
+	{Method: http.MethodPut, Path: "/api/v1/admin/tenants/:tenantSchema/moderation", Tag: "admin", Summary: "Set a tenant's moderation mode",
+		Security: admin, Request: account.ModerationModeRequest{},
+		Response: Object{"tenantSchema": "", "override": "", "mode": ""}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/tenants/:tenantSchema/requests", Tag: "admin", Summary: "List a tenant's logged API requests",
+		Security: admin,
+		Query: []Param{
+			{Name: "from", Description: "RFC3339 time or YYYY-MM-DD"},
+			{Name: "to", Description: "RFC3339 time or YYYY-MM-DD, inclusive"},
+			{Name: "status", Description: "Status code, such as 404, or class, such as 5xx"},
+			{Name: "method"},
+			{Name: "route", Description: "Route pattern, such as /api/v1/chat/:id"},
+			{Name: "request_id"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"requests": []models.TenantRequestLog{}, "page": 0, "perPage": 0, "total": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/flags", Tag: "admin", Summary: "List feature flags with their effective values",
+		Security: admin, Response: Object{"flags": []flags.Flag{}}},
+	{Method: http.MethodPut, Path: "/api/v1/admin/flags", Tag: "admin", Summary: "Override feature flags; null restores the default",
+		Security: admin, Request: flags.UpdateFlagsRequest{}, Response: Object{"flags": []flags.Flag{}}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/metrics/tenants", Tag: "admin", Summary: "Get how tenants are labelled in metrics",
+		Security: admin, Response: metrics.TenantPolicy{}},
+	{Method: http.MethodPut, Path: "/api/v1/admin/metrics/tenants", Tag: "admin", Summary: "Set the tenants labelled by schema in metrics on every instance; null restores metrics_tenant_allowlist",
+		Security: admin, Request: metrics.UpdateTenantsRequest{}, Response: metrics.TenantPolicy{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/usage/report", Tag: "admin", Summary: "Report usage per tenant, UTC day or month and model",
+		Security: admin, Query: usageReportParams, Response: usage.Report{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/db/stats", Tag: "admin", Summary: "Database connection pool statistics of this instance",
+		Security: admin, Response: Object{"pool": db.PoolStats{}}},
+	{Method: http.MethodPost, Path: "/api/v1/admin/redis/reaper/run", Tag: "admin", Summary: "Queue a run of the orphaned Redis key reaper",
+		Security: admin, Query: []Param{{Name: "dry_run", Type: "boolean", Description: "Only report what would be removed"}},
+		Status: http.StatusAccepted, Response: Object{"status": "", "dryRun": false}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/redis/reaper/report", Tag: "admin", Summary: "Get the report of the latest Redis key reaper run",
+		Security: admin, Response: storage.ReaperReport{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/unsplash/status", Tag: "admin", Summary: "Unsplash rate limit as of the latest response",
+		Security: admin, Response: Object{"configured": false, "quota": services.UnsplashQuota{}}},
+	{Method: http.MethodPost, Path: "/api/v1/admin/payments/:id/refund", Tag: "admin", Summary: "Refund a payment",
+		Security: admin, Request: payment.RefundRequest{},
+		Response: Object{"refund": models.Refund{}, "payment": models.Payment{}}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/responses", Tag: "admin", Summary: "List saved model responses",
+		Security: admin,
+		Query: []Param{
+			{Name: "keyword", Description: "Comma-separated keywords"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"responses": []services.SavedResponse{}, "page": 0, "perPage": 0, "total": 0}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/responses/:id", Tag: "admin", Summary: "Get the HTML of a saved model response",
+		Security: admin, HTML: true},
+	{Method: http.MethodPost, Path: "/api/v1/admin/responses/:id/replay", Tag: "admin", Summary: "Replay a saved response through the processors",
+		Security: admin,
+		Response: Object{"response": services.SavedResponse{}, "content": "", "processingReport": []common.ProcessorReport{}}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/feedback", Tag: "admin", Summary: "List message feedback with counts per model and prompt variant",
+		Security: admin,
+		Query: []Param{
+			{Name: "from", Description: "RFC3339 time or YYYY-MM-DD"},
+			{Name: "to", Description: "RFC3339 time or YYYY-MM-DD, inclusive"},
+			{Name: "rating", Description: "up or down"},
+			{Name: "model"},
+			{Name: "variant"},
+			{Name: "tenant"},
+			{Name: "page", Type: "integer"},
+			{Name: "per_page", Type: "integer"},
+		},
+		Response: Object{"feedback": []models.MessageFeedback{}, "counts": []chat.FeedbackCount{}, "page": 0, "perPage": 0, "total": int64(0)}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/chats/:id", Tag: "admin", Summary: "Get any chat, including chats without a recorded owner",
+		Security: admin, Query: []Param{{Name: "tenant", Description: "Tenant schema, for the postgres and cached chat stores"}},
+		Response: model.Chat{}},
+	{Method: http.MethodDelete, Path: "/api/v1/admin/chats/:id", Tag: "admin", Summary: "Delete any chat",
+		Security: admin, Query: []Param{{Name: "tenant", Description: "Tenant schema, for the postgres and cached chat stores"}},
+		Response: message},
+	{Method: http.MethodPut, Path: "/api/v1/admin/chats/:id/owner", Tag: "admin", Summary: "Assign an owner to a chat created before owners were recorded",
+		Security: admin, Query: []Param{{Name: "tenant", Description: "Tenant schema, for the postgres and cached chat stores"}},
+		Request: chat.ChatOwnerRequest{}, Response: model.Chat{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/experiments", Tag: "admin", Summary: "List prompt experiments with their usage",
+		Security: admin, Response: Object{"experiments": []chat.ExperimentStatus{}}},
+	{Method: http.MethodPost, Path: "/api/v1/admin/reprocess", Tag: "admin", Summary: "Re-run processors over saved sites in the background",
+		Security: admin, Request: publish.ReprocessRequest{}, Status: http.StatusAccepted, Response: storage.ReprocessBatch{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/reprocess/:jobId", Tag: "admin", Summary: "Get the progress of a reprocessing batch",
+		Security: admin, Response: storage.ReprocessBatch{}},
+	{Method: http.MethodGet, Path: "/api/v1/admin/publications/verify", Tag: "admin", Summary: "Re-hash stored publications and check their signatures",
+		Security: admin, Query: []Param{{Name: "tenants", Required: true, Description: `"all" or tenant schemas separated by commas`}},
+		Response: Object{"results": []publish.VerifyResult{}, "counts": map[string]int{}, "mismatches": 0}},
+}
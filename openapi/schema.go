@@ -0,0 +1,274 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Schema is an OpenAPI 3.0 schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Description          string             `json:"description,omitempty"`
+}
+
+// Object describes a JSON object built inline by a handler (gin.H). Values
+// are sample values whose types give the property schemas.
+type Object map[string]any
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	deletedAtType  = reflect.TypeOf(gorm.DeletedAt{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	objectType     = reflect.TypeOf(Object{})
+
+	genericArgs = regexp.MustCompile(`[\w./-]*\.`)
+)
+
+// schemas builds component schemas from Go types
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+	taken      map[string]reflect.Type
+	extra      map[reflect.Type]Object
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: map[string]*Schema{},
+		names:      map[reflect.Type]string{},
+		taken:      map[string]reflect.Type{},
+		extra:      map[reflect.Type]Object{},
+	}
+}
+
+// of returns the schema for a sample value: nil, an Object, or any value
+// whose type is reflected
+func (s *schemas) of(sample any) *Schema {
+	if sample == nil {
+		return nil
+	}
+	if obj, ok := sample.(Object); ok {
+		return s.object(obj)
+	}
+	return s.forType(reflect.TypeOf(sample))
+}
+
+func (s *schemas) object(obj Object) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for name, sample := range obj {
+		if sample == nil {
+			schema.Properties[name] = &Schema{}
+			continue
+		}
+		schema.Properties[name] = s.of(sample)
+	}
+	return schema
+}
+
+func (s *schemas) forType(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case deletedAtType:
+		return &Schema{Type: "string", Format: "date-time", Nullable: true}
+	case rawMessageType:
+		return &Schema{}
+	case objectType:
+		return &Schema{Type: "object"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.forType(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: "integer", Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.forType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		return s.ref(t)
+	default:
+		// interfaces and anything else accept any JSON value
+		return &Schema{}
+	}
+}
+
+// ref registers a named struct as a component and returns a reference to it
+func (s *schemas) ref(t reflect.Type) *Schema {
+	if name, ok := s.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	name := s.componentName(t)
+	s.names[t] = name
+	s.taken[name] = t
+	s.components[name] = &Schema{} // placeholder for recursive types
+	s.components[name] = s.structSchema(t)
+
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// componentName names a type after itself, prefixing the package when two
+// packages export the same name. Generic instantiations such as
+// ApiResponse[users.AuthResponse] become ApiResponse_AuthResponse.
+func (s *schemas) componentName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.Index(name, "["); i >= 0 {
+		args := genericArgs.ReplaceAllString(name[i+1:len(name)-1], "")
+		args = strings.NewReplacer("[]", "List", "*", "", ",", "_", "interface {}", "Any").Replace(args)
+		name = name[:i] + "_" + args
+	}
+
+	if other, ok := s.taken[name]; ok && other != t {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = upperFirst(pkg) + name
+	}
+	return name
+}
+
+func (s *schemas) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.addFields(schema, t)
+
+	for name, sample := range s.extra[t] {
+		schema.Properties[name] = s.of(sample)
+	}
+	return schema
+}
+
+func (s *schemas) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a JSON name are flattened, like encoding/json
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addFields(schema, ft)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := s.forType(field.Type)
+		binding := field.Tag.Get("binding")
+		applyBinding(prop, binding)
+		schema.Properties[name] = prop
+
+		if hasRule(binding, "required") && !strings.Contains(opts, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// applyBinding documents the validator rules used by gin bindings
+func applyBinding(schema *Schema, binding string) {
+	for _, rule := range strings.Split(binding, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "oneof":
+			schema.Enum = strings.Fields(value)
+		case "email":
+			schema.Format = "email"
+		case "min", "max":
+			n, ok := parseNumber(value)
+			if !ok {
+				continue
+			}
+			if schema.Type == "string" {
+				length := int(n)
+				if key == "min" {
+					schema.MinLength = &length
+				} else {
+					schema.MaxLength = &length
+				}
+				continue
+			}
+			if key == "min" {
+				schema.Minimum = &n
+			} else {
+				schema.Maximum = &n
+			}
+		}
+	}
+}
+
+func hasRule(binding, rule string) bool {
+	for _, r := range strings.Split(binding, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNumber(s string) (float64, bool) {
+	var n float64
+	if err := json.Unmarshal([]byte(s), &n); err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func upperFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
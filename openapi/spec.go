@@ -0,0 +1,321 @@
+// Package openapi builds the OpenAPI 3 document for the public API from the
+// route catalog in routes.go and the request and response structs the
+// handlers bind and return
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	OPENAPI_VERSION = "3.0.3"
+	API_TITLE       = "Awning API"
+	API_VERSION     = "v1"
+
+	// Security scheme names
+	SchemeBearer      = "bearerAuth"
+	SchemeApiKey      = "apiKey"
+	SchemeFrontendKey = "frontendKey"
+
+	TenantHeader         = "X-Tenant-ID"
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// Param is a query parameter
+type Param struct {
+	Name        string
+	Type        string // string, integer or boolean; defaults to string
+	Required    bool
+	Description string
+}
+
+// Route documents one registered route. Request and Response are sample
+// values: a struct, a pointer or slice of one, or an Object for gin.H bodies.
+type Route struct {
+	Method  string
+	Path    string // gin path, e.g. /api/v1/chat/:id
+	Tag     string
+	Summary string
+
+	// Security lists the schemes that must all be satisfied, nil for none
+	Security []string
+	// Tenant routes require the X-Tenant-ID header
+	Tenant bool
+	// Idempotent routes accept an optional Idempotency-Key header
+	Idempotent bool
+
+	Query     []Param
+	Request   any
+	Multipart Object // multipart/form-data fields, instead of a JSON Request
+	Status    int    // success status, defaults to 200
+	Response  any
+	Stream    bool // the success response is a text/event-stream
+	HTML      bool // the success response is an HTML document
+	Zip       bool // the success response is a zip archive
+}
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Tags       []Tag                           `json:"tags,omitempty"`
+	Paths      map[string]map[string]Operation `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Security    []map[string][]string `json:"security"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// ErrorResponse is the error envelope returned by every handler. Code is set
+// for errors clients handle specially, such as moderation_blocked.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`[:*](\w+)`)
+
+// Build returns the document for routes
+func Build(routes []Route) *Document {
+	s := newSchemas()
+	s.extra = marshalExtras
+
+	doc := &Document{
+		OpenAPI: OPENAPI_VERSION,
+		Info: Info{
+			Title:       API_TITLE,
+			Version:     API_VERSION,
+			Description: "Generated from the handler request and response types by cmd/openapi. Errors use the ErrorResponse envelope.",
+		},
+		Paths: map[string]map[string]Operation{},
+	}
+
+	errorRef := s.of(ErrorResponse{})
+	tags := map[string]bool{}
+
+	for _, route := range routes {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]Operation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = s.operation(route, errorRef)
+		tags[route.Tag] = true
+	}
+
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+
+	doc.Components = Components{
+		Schemas: s.components,
+		SecuritySchemes: map[string]*SecurityScheme{
+			SchemeBearer: {
+				Type:         "http",
+				Scheme:       "bearer",
+				BearerFormat: "JWT",
+				Description:  "User token returned by login, register or the OAuth callbacks",
+			},
+			SchemeApiKey: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "Authorization",
+				Description: "Server API key for admin and internal routes, sent as \"ApiKey <key>:<secret>\"",
+			},
+			SchemeFrontendKey: {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "X-Awning-Frontend-Key",
+				Description: "Frontend key required on all routes served to the web app",
+			},
+		},
+	}
+
+	return doc
+}
+
+// JSON returns the indented document with a trailing newline, as written by
+// cmd/openapi
+func (d *Document) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (s *schemas) operation(route Route, errorRef *Schema) Operation {
+	op := Operation{
+		OperationID: operationID(route),
+		Summary:     route.Summary,
+		Tags:        []string{route.Tag},
+		Security:    []map[string][]string{},
+		Responses:   map[string]Response{},
+	}
+
+	if len(route.Security) > 0 {
+		requirement := map[string][]string{}
+		for _, scheme := range route.Security {
+			requirement[scheme] = []string{}
+		}
+		op.Security = append(op.Security, requirement)
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     match[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	if route.Tenant {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        TenantHeader,
+			In:          "header",
+			Required:    true,
+			Description: "Schema name of the tenant to act on",
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	if route.Idempotent {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        IdempotencyKeyHeader,
+			In:          "header",
+			Description: "Retries with the same key and body replay the first response, with Idempotent-Replayed: true. Up to 255 letters, digits, '_', '-', '.' or ':'.",
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+	for _, q := range route.Query {
+		typ := q.Type
+		if typ == "" {
+			typ = "string"
+		}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        q.Name,
+			In:          "query",
+			Required:    q.Required,
+			Description: q.Description,
+			Schema:      &Schema{Type: typ},
+		})
+	}
+
+	switch {
+	case route.Multipart != nil:
+		form := s.object(route.Multipart)
+		for _, prop := range form.Properties {
+			if prop.Format == "byte" {
+				prop.Format = "binary" // file parts
+			}
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+			"multipart/form-data": {Schema: form},
+		}}
+	case route.Request != nil:
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{
+			"application/json": {Schema: s.of(route.Request)},
+		}}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := Response{Description: http.StatusText(status)}
+	switch {
+	case route.Stream:
+		success.Content = map[string]MediaType{
+			"text/event-stream": {Schema: &Schema{Type: "string", Description: "Server-sent events"}},
+		}
+	case route.HTML:
+		success.Content = map[string]MediaType{
+			"text/html": {Schema: &Schema{Type: "string"}},
+		}
+	case route.Zip:
+		success.Content = map[string]MediaType{
+			"application/zip": {Schema: &Schema{Type: "string", Format: "binary"}},
+		}
+	case route.Response != nil:
+		success.Content = map[string]MediaType{
+			"application/json": {Schema: s.of(route.Response)},
+		}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+	op.Responses["default"] = Response{
+		Description: "Error",
+		Content:     map[string]MediaType{"application/json": {Schema: errorRef}},
+	}
+
+	return op
+}
+
+// operationID derives a stable ID from the method and path, e.g.
+// GET /api/v1/chat/:id/meta becomes getChatIdMeta
+func operationID(route Route) string {
+	id := strings.ToLower(route.Method)
+	path := strings.TrimPrefix(route.Path, "/api/v1")
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '-' || r == ':' || r == '*'
+	}) {
+		id += upperFirst(part)
+	}
+	return id
+}
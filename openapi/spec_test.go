@@ -0,0 +1,21 @@
+package openapi
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSpecFileUpToDate(t *testing.T) {
+	want, err := Build(Routes).JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	got, err := os.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("failed to read openapi.json: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("openapi.json differs from the route catalog, run make openapi")
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptoRand "crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"os"
+	"slices"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+
+	"gorm.io/gorm"
+)
+
+// opsCommand runs an operational subcommand with its arguments and returns
+// the exit code: 0 on success, 1 when it failed and 2 for invalid arguments
+type opsCommand func(ctx context.Context, args []string, cfg *common.Config) int
+
+// opsCommands are the operational subcommands, run once the config has been
+// loaded and validated as for the server:
+//
+//	awning-backend migrate [-tenant a,b | -all] [-dry-run]
+//	awning-backend create-tenant -email user@example.com -name "Acme" [-dry-run]
+//	awning-backend grant-credits -tenant a -type basic|premium -amount 10 -reason "..." [-dry-run]
+//	awning-backend list-tenants
+//	awning-backend gen-jwt-key
+var opsCommands = map[string]opsCommand{
+	"migrate":       runMigrate,
+	"create-tenant": runCreateTenant,
+	"grant-credits": runGrantCredits,
+	"list-tenants":  runListTenants,
+	"gen-jwt-key":   runGenJWTKey,
+}
+
+// registerModels registers the shared and tenant models with the database
+func registerModels(ctx context.Context, database *db.DB) error {
+	return database.RegisterModels(ctx, models.All()...)
+}
+
+// connectDatabase connects to DATABASE_URL and registers the models, for
+// subcommands that need the database
+func connectDatabase(ctx context.Context, command string) (*db.DB, error) {
+	databaseURL := getEnv("DATABASE_URL", "")
+	if databaseURL == "" {
+		return nil, fmt.Errorf("%s needs DATABASE_URL", command)
+	}
+	database, err := db.Connect(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := registerModels(ctx, database); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to register models: %w", err)
+	}
+	return database, nil
+}
+
+// usageError prints a usage problem with the command's flags and returns 2
+func usageError(fs *flag.FlagSet, format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, fs.Name()+": "+format+"\n", args...)
+	fs.Usage()
+	return 2
+}
+
+// runMigrate migrates the shared models, and the tenant schemas named by
+// -tenant or all of them with -all. -dry-run lists what would be migrated.
+func runMigrate(ctx context.Context, args []string, _ *common.Config) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	tenantFlag := fs.String("tenant", "", "tenant schemas to migrate, separated by commas")
+	all := fs.Bool("all", false, "migrate every tenant schema")
+	dryRun := fs.Bool("dry-run", false, "list what would be migrated without migrating")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tenantFlag != "" && *all {
+		return usageError(fs, "-tenant and -all can't be combined")
+	}
+
+	database, err := connectDatabase(ctx, "migrate")
+	if err != nil {
+		slog.Error("Migration failed", "error", err)
+		return 1
+	}
+	defer database.Close()
+
+	var tenants []string
+	switch {
+	case *all:
+		if err := database.DB.WithContext(ctx).Model(&models.Tenant{}).Order("schema_name").Pluck("schema_name", &tenants).Error; err != nil {
+			slog.Error("Failed to list tenants", "error", err)
+			return 1
+		}
+	case *tenantFlag != "":
+		tenants = strings.Split(*tenantFlag, ",")
+		var known []string
+		if err := database.DB.WithContext(ctx).Model(&models.Tenant{}).Where("schema_name IN ?", tenants).Pluck("schema_name", &known).Error; err != nil {
+			slog.Error("Failed to look up tenants", "error", err)
+			return 1
+		}
+		for _, tenant := range tenants {
+			if !slices.Contains(known, tenant) {
+				slog.Error("Unknown tenant", "tenant", tenant)
+				return 2
+			}
+		}
+	}
+
+	if *dryRun {
+		slog.Info("Dry run: would migrate the shared models and tenant schemas", "tenants", tenants)
+		return 0
+	}
+
+	if err := database.MigrateSharedModels(ctx); err != nil {
+		slog.Error("Failed to migrate shared models", "error", err)
+		return 1
+	}
+	if len(tenants) > 0 {
+		if err := database.MigrateExistingTenants(ctx, tenants); err != nil {
+			slog.Error("Failed to migrate tenant schemas", "error", err)
+			return 1
+		}
+	}
+
+	slog.Info("Migration finished", "tenants", len(tenants))
+	return 0
+}
+
+// runCreateTenant creates a user and their tenant as signup does, without a
+// password; the user signs in through a password reset or OAuth
+func runCreateTenant(ctx context.Context, args []string, cfg *common.Config) int {
+	fs := flag.NewFlagSet("create-tenant", flag.ContinueOnError)
+	email := fs.String("email", "", "email of the tenant's first user, made its admin")
+	name := fs.String("name", "", "tenant name (default from the email)")
+	dryRun := fs.Bool("dry-run", false, "check the arguments without creating anything")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	*email = strings.ToLower(strings.TrimSpace(*email))
+	if *email == "" {
+		return usageError(fs, "-email is required")
+	}
+	if _, err := mail.ParseAddress(*email); err != nil {
+		return usageError(fs, "invalid -email %q", *email)
+	}
+
+	database, err := connectDatabase(ctx, "create-tenant")
+	if err != nil {
+		slog.Error("Creating tenant failed", "error", err)
+		return 1
+	}
+	defer database.Close()
+
+	var count int64
+	if err := database.DB.WithContext(ctx).Model(&models.User{}).Where("email = ?", *email).Count(&count).Error; err != nil {
+		slog.Error("Failed to look up user", "error", err)
+		return 1
+	}
+	if count > 0 {
+		slog.Error("A user with this email already exists", "email", *email)
+		return 1
+	}
+
+	if *dryRun {
+		slog.Info("Dry run: would create the user and tenant", "email", *email, "name", *name)
+		return 0
+	}
+
+	userService := users.NewUserService(&sections.Dependencies{Config: cfg, DB: database})
+	user, tenant, err := userService.CreateUserWithTenant(ctx, users.CreateUserWithTenantParams{
+		User:       models.User{Email: *email, Active: true},
+		TenantName: strings.TrimSpace(*name),
+	})
+	if err != nil {
+		slog.Error("Failed to create tenant", "error", err)
+		return 1
+	}
+
+	out, _ := json.Marshal(map[string]any{"userId": user.ID, "email": user.Email, "tenantSchema": tenant.SchemaName, "tenantName": tenant.Name})
+	fmt.Println(string(out))
+	return 0
+}
+
+// runGrantCredits grants credits to a tenant through the credit ledger,
+// recorded as a grant by the cli actor
+func runGrantCredits(ctx context.Context, args []string, cfg *common.Config) int {
+	fs := flag.NewFlagSet("grant-credits", flag.ContinueOnError)
+	tenant := fs.String("tenant", "", "tenant schema")
+	creditType := fs.String("type", "", "credit type: basic or premium")
+	amount := fs.Int("amount", 0, "credits to grant")
+	reason := fs.String("reason", "", "reason recorded with the transaction")
+	dryRun := fs.Bool("dry-run", false, "check the arguments without granting")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	switch {
+	case *tenant == "":
+		return usageError(fs, "-tenant is required")
+	case *creditType != account.CreditTypeBasic && *creditType != account.CreditTypePremium:
+		return usageError(fs, "-type must be %s or %s", account.CreditTypeBasic, account.CreditTypePremium)
+	case *amount <= 0:
+		return usageError(fs, "-amount must be positive")
+	case strings.TrimSpace(*reason) == "":
+		return usageError(fs, "-reason is required")
+	}
+
+	database, err := connectDatabase(ctx, "grant-credits")
+	if err != nil {
+		slog.Error("Granting credits failed", "error", err)
+		return 1
+	}
+	defer database.Close()
+
+	var tenantRow models.Tenant
+	err = database.DB.WithContext(ctx).Where("schema_name = ?", *tenant).First(&tenantRow).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.Error("Unknown tenant", "tenant", *tenant)
+		return 1
+	}
+	if err != nil {
+		slog.Error("Failed to look up tenant", "error", err)
+		return 1
+	}
+
+	if *dryRun {
+		slog.Info("Dry run: would grant credits", "tenant", *tenant, "type", *creditType, "amount", *amount, "reason", *reason)
+		return 0
+	}
+
+	deps := &sections.Dependencies{Config: cfg, DB: database}
+	acct, _, err := account.ChangeCredits(ctx, deps, *tenant, true, func(*models.TenantAccount) ([]account.CreditEntry, error) {
+		return []account.CreditEntry{{CreditType: *creditType, Type: account.CreditTxnGrant, Delta: *amount,
+			Reason: strings.TrimSpace(*reason), Actor: "cli"}}, nil
+	})
+	if err != nil {
+		slog.Error("Failed to grant credits", "tenant", *tenant, "error", err)
+		return 1
+	}
+
+	out, _ := json.Marshal(map[string]any{"tenantSchema": *tenant, "basicCredits": acct.BasicCredits, "premiumCredits": acct.PremiumCredits})
+	fmt.Println(string(out))
+	return 0
+}
+
+// runListTenants prints every tenant as a JSON line
+func runListTenants(ctx context.Context, args []string, _ *common.Config) int {
+	fs := flag.NewFlagSet("list-tenants", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	database, err := connectDatabase(ctx, "list-tenants")
+	if err != nil {
+		slog.Error("Listing tenants failed", "error", err)
+		return 1
+	}
+	defer database.Close()
+
+	var tenants []models.Tenant
+	if err := database.DB.WithContext(ctx).Order("schema_name").Find(&tenants).Error; err != nil {
+		slog.Error("Failed to list tenants", "error", err)
+		return 1
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	for _, tenant := range tenants {
+		out.Encode(map[string]any{
+			"schema":              tenant.SchemaName,
+			"name":                tenant.Name,
+			"active":              tenant.Active,
+			"createdAt":           common.FormatTime(tenant.CreatedAt),
+			"deletionScheduledAt": tenant.DeletionScheduledAt,
+		})
+	}
+	return 0
+}
+
+// runGenJWTKey prints a new ES512 key pair as the base64 PEM that
+// JWT_PRIVATE_KEY and JWT_VERIFICATION_KEYS take, and the public key's JWK
+func runGenJWTKey(_ context.Context, args []string, _ *common.Config) int {
+	fs := flag.NewFlagSet("gen-jwt-key", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	privB64, pubB64, jwk, err := generateJWTKey()
+	if err != nil {
+		slog.Error("Failed to generate JWT key", "error", err)
+		return 1
+	}
+
+	out, _ := json.MarshalIndent(map[string]any{"privateKey": privB64, "publicKey": pubB64, "jwk": jwk}, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
+
+// generateJWTKey generates an ECDSA P-521 key pair, returning both keys as
+// base64 PEM and the public key as a JWK
+func generateJWTKey() (privB64, pubB64 string, jwk auth.JWK, err error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P521(), cryptoRand.Reader)
+	if err != nil {
+		return "", "", jwk, fmt.Errorf("failed to generate ECDSA key pair: %w", err)
+	}
+
+	privBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return "", "", jwk, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	privPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes})
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return "", "", jwk, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	pubPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+
+	// The JWK is the one the server will publish at its JWKS endpoint
+	manager, err := auth.NewJWTManager(string(privPem), "", 0)
+	if err != nil {
+		return "", "", jwk, err
+	}
+	return base64.StdEncoding.EncodeToString(privPem), base64.StdEncoding.EncodeToString(pubPem), manager.JWKS().Keys[0], nil
+}
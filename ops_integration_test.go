@@ -0,0 +1,125 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/it"
+	"awning-backend/sections/models"
+)
+
+func TestMain(m *testing.M) {
+	it.Main(m)
+}
+
+// runOps runs an operational command against the package's database and
+// returns its exit code and what it printed
+func runOps(t *testing.T, command string, args ...string) (int, string) {
+	t.Helper()
+	t.Setenv("DATABASE_URL", it.DatabaseURL())
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	code := opsCommands[command](context.Background(), args, common.DefaultConfig())
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return code, string(out)
+}
+
+// testDB connects to the package's database
+func testDB(t *testing.T) *db.DB {
+	t.Helper()
+
+	database, err := db.Connect(it.DatabaseURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return database
+}
+
+func TestCreateTenantCommand(t *testing.T) {
+	database := testDB(t)
+	email := fmt.Sprintf("ops+%d@awning.test", time.Now().UnixNano())
+
+	// A dry run creates nothing
+	if code, _ := runOps(t, "create-tenant", "-email", email, "-dry-run"); code != 0 {
+		t.Fatalf("create-tenant -dry-run = %d, want 0", code)
+	}
+	var count int64
+	database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+	if count != 0 {
+		t.Fatal("create-tenant -dry-run created the user")
+	}
+
+	code, out := runOps(t, "create-tenant", "-email", " "+email+" ", "-name", "Crumb & Co")
+	if code != 0 {
+		t.Fatalf("create-tenant = %d, want 0", code)
+	}
+	var created struct {
+		UserID       uint   `json:"userId"`
+		Email        string `json:"email"`
+		TenantSchema string `json:"tenantSchema"`
+		TenantName   string `json:"tenantName"`
+	}
+	if err := json.Unmarshal([]byte(out), &created); err != nil || created.UserID == 0 || created.Email != email || created.TenantName != "Crumb & Co" {
+		t.Fatalf("create-tenant printed %q, want the new user and tenant", out)
+	}
+
+	var tenant models.Tenant
+	if err := database.DB.Where("schema_name = ?", created.TenantSchema).First(&tenant).Error; err != nil || !tenant.Active {
+		t.Errorf("tenant %s = %+v, %v; want it created active", created.TenantSchema, tenant, err)
+	}
+	database.DB.Raw("SELECT count(*) FROM information_schema.schemata WHERE schema_name = ?", created.TenantSchema).Scan(&count)
+	if count != 1 {
+		t.Errorf("schema %s wasn't created", created.TenantSchema)
+	}
+
+	// The same email again fails
+	if code, _ := runOps(t, "create-tenant", "-email", email); code != 1 {
+		t.Errorf("create-tenant of an existing user = %d, want 1", code)
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	code, out := runOps(t, "create-tenant", "-email", fmt.Sprintf("migrate+%d@awning.test", time.Now().UnixNano()))
+	if code != 0 {
+		t.Fatalf("create-tenant = %d, want 0", code)
+	}
+	var created struct {
+		TenantSchema string `json:"tenantSchema"`
+	}
+	json.Unmarshal([]byte(out), &created)
+
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, 0},
+		{[]string{"-dry-run", "-all"}, 0},
+		{[]string{"-tenant", created.TenantSchema}, 0},
+		{[]string{"-all"}, 0},
+		// Migrations can run again
+		{[]string{"-all"}, 0},
+		{[]string{"-tenant", created.TenantSchema + ",tenant_unknown"}, 2},
+	}
+	for _, tt := range tests {
+		if code, _ := runOps(t, "migrate", tt.args...); code != tt.want {
+			t.Errorf("migrate %q = %d, want %d", tt.args, code, tt.want)
+		}
+	}
+}
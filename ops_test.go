@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+
+	"awning-backend/common"
+)
+
+func TestOpsArgumentValidation(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	cfg := common.DefaultConfig()
+
+	tests := []struct {
+		command string
+		args    []string
+		want    int
+	}{
+		{"migrate", []string{"-tenant", "tenant_a", "-all"}, 2},
+		{"migrate", []string{"-unknown"}, 2},
+		{"create-tenant", nil, 2},
+		{"create-tenant", []string{"-email", "  "}, 2},
+		{"create-tenant", []string{"-email", "not an email"}, 2},
+		{"grant-credits", []string{"-type", "basic", "-amount", "5", "-reason", "Refund"}, 2},
+		{"grant-credits", []string{"-tenant", "tenant_a", "-type", "gold", "-amount", "5", "-reason", "Refund"}, 2},
+		{"grant-credits", []string{"-tenant", "tenant_a", "-type", "basic", "-amount", "0", "-reason", "Refund"}, 2},
+		{"grant-credits", []string{"-tenant", "tenant_a", "-type", "basic", "-amount", "5", "-reason", " "}, 2},
+		{"gen-jwt-key", []string{"-flag"}, 2},
+		// Valid arguments, but no database to run them against
+		{"migrate", []string{"-dry-run"}, 1},
+		{"create-tenant", []string{"-email", "owner@awning.test", "-dry-run"}, 1},
+		{"grant-credits", []string{"-tenant", "tenant_a", "-type", "premium", "-amount", "5", "-reason", "Refund", "-dry-run"}, 1},
+		{"list-tenants", nil, 1},
+	}
+	for _, tt := range tests {
+		run, ok := opsCommands[tt.command]
+		if !ok {
+			t.Fatalf("no %s command", tt.command)
+		}
+		if got := run(context.Background(), tt.args, cfg); got != tt.want {
+			t.Errorf("%s %q = %d, want %d", tt.command, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateJWTKey(t *testing.T) {
+	privB64, pubB64, jwk, err := generateJWTKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privPem, err := base64.StdEncoding.DecodeString(privB64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(privPem)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatalf("private key = %q, want an EC PRIVATE KEY PEM", privPem)
+	}
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil || privateKey.Curve.Params().Name != "P-521" {
+		t.Fatalf("private key = %v, %v; want a P-521 key", privateKey, err)
+	}
+
+	pubPem, _ := base64.StdEncoding.DecodeString(pubB64)
+	block, _ = pem.Decode(pubPem)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("public key = %q, want a PUBLIC KEY PEM", pubPem)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil || !privateKey.PublicKey.Equal(publicKey.(*ecdsa.PublicKey)) {
+		t.Errorf("public key doesn't match the private key: %v", err)
+	}
+	if jwk.Kty != "EC" || jwk.Crv != "P-521" || jwk.Alg != "ES512" || jwk.Kid == "" {
+		t.Errorf("JWK = %+v, want the ES512 public key", jwk)
+	}
+}
@@ -2,26 +2,29 @@ package processors
 
 import (
 	"awning-backend/common"
+	"awning-backend/utils"
 	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"strings"
 
 	"golang.org/x/net/html"
 )
 
 // CleanupProcessor implements the Processor interface.
 type CleanupProcessor struct {
-	logger *slog.Logger
-	cfg    *common.Config
+	logger   *slog.Logger
+	settings common.CleanupProcessorSettings
 }
 
-func NewCleanupProcessor(cfg *common.Config) *CleanupProcessor {
+func NewCleanupProcessor(settings common.CleanupProcessorSettings) *CleanupProcessor {
 	logger := slog.With("processor", "CleanupProcessor")
 
 	return &CleanupProcessor{
-		logger: logger,
-		cfg:    cfg,
+		logger:   logger,
+		settings: settings,
 	}
 }
 
@@ -29,9 +32,13 @@ func (c *CleanupProcessor) Name() string {
 	return "CleanupProcessor"
 }
 
-func (c *CleanupProcessor) cleanupBrInGrids(rootNode *html.Node) {
+// cleanupBrInGrids removes <br> tags inside grid containers and returns how
+// many were removed
+func (c *CleanupProcessor) cleanupBrInGrids(rootNode *html.Node) int {
 	c.logger.Info("Cleaning up <br> tags in grid containers")
 
+	removed := 0
+
 	filter := func(n *html.Node) bool {
 		return true
 	}
@@ -41,10 +48,11 @@ func (c *CleanupProcessor) cleanupBrInGrids(rootNode *html.Node) {
 			c.logger.Info("Found <br> tag")
 
 			parent := n.Parent
-			if parent != nil && hasAnyClassOrPrefix(parent, "grid", "grid-cols-", "grid-rows-") {
+			if parent != nil && parent.Type == html.ElementNode && hasAnyClassOrPrefix(parent, "grid", "grid-cols-", "grid-rows-") {
 				c.logger.Info("Removing <br> tag inside grid container")
 				// Remove the <br> node
 				parent.RemoveChild(n)
+				removed++
 				// Returning true to stop further processing of this node
 				return true
 			}
@@ -55,10 +63,21 @@ func (c *CleanupProcessor) cleanupBrInGrids(rootNode *html.Node) {
 	}
 
 	WalkNodes(c.logger, rootNode, filter, walker)
+
+	return removed
 }
 
 // Process performs the cleanup operation.
-func (c *CleanupProcessor) Process(_ context.Context, input []byte) ([]byte, error) {
+func (c *CleanupProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	result, err := c.ProcessWithResult(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// ProcessWithResult performs the cleanup operation and reports what was removed
+func (c *CleanupProcessor) ProcessWithResult(ctx context.Context, input []byte) (*common.ProcessorResult, error) {
 	fmt.Println("Performing cleanup...")
 
 	sr := bytes.NewReader(input)
@@ -70,14 +89,242 @@ func (c *CleanupProcessor) Process(_ context.Context, input []byte) ([]byte, err
 		return nil, err
 	}
 
-	c.cleanupBrInGrids(rootNode)
+	result, _ := c.ProcessSubtree(ctx, rootNode, nil)
 
 	// Render the modified HTML back to bytes
 	var outputBuf bytes.Buffer
-	if err := html.Render(&outputBuf, rootNode); err != nil {
+	if err := utils.RenderCanonical(&outputBuf, rootNode); err != nil {
 		c.logger.Error("Failed to render HTML", "error", err)
 		return nil, err
 	}
 
-	return outputBuf.Bytes(), nil
+	result.Output = outputBuf.Bytes()
+	return result, nil
+}
+
+// ProcessNode performs the cleanup operation on a parsed document
+func (c *CleanupProcessor) ProcessNode(ctx context.Context, doc *html.Node) (*common.ProcessorResult, error) {
+	return c.ProcessSubtree(ctx, doc, nil)
+}
+
+// ProcessSubtree performs the cleanup operation on part of a document
+func (c *CleanupProcessor) ProcessSubtree(_ context.Context, node, _ *html.Node) (*common.ProcessorResult, error) {
+	result := &common.ProcessorResult{}
+	if c.settings.RemoveBrInGrids {
+		result.Count("br_removed", c.cleanupBrInGrids(node))
+	}
+	if c.settings.StripEmptyParagraphs {
+		result.Count("empty_p_removed", c.stripEmptyParagraphs(node))
+	}
+	if c.settings.StripEmptyDivs {
+		result.Count("empty_div_removed", c.stripEmptyDivs(node))
+	}
+	if c.settings.CollapseBr {
+		result.Count("br_collapsed", c.collapseBr(node))
+	}
+	if c.settings.StripGridChildPixelWidths {
+		result.Count("pixel_widths_stripped", c.stripGridChildPixelWidths(node))
+	}
+	if c.settings.DedupeIDs {
+		result.Count("ids_renamed", c.dedupeIDs(node))
+	}
+	return result, nil
+}
+
+// stripEmptyParagraphs removes <p> elements with nothing but whitespace in
+// them and returns how many were removed
+func (c *CleanupProcessor) stripEmptyParagraphs(rootNode *html.Node) int {
+	var empty []*html.Node
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "p" && isBlank(n) {
+			empty = append(empty, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(rootNode)
+
+	for _, n := range empty {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+	return len(empty)
+}
+
+// isBlank reports whether n has only whitespace text and comments in it
+func isBlank(n *html.Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case html.CommentNode:
+		case html.TextNode:
+			if strings.TrimSpace(child.Data) != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// stripEmptyDivs removes <div> elements without attributes and with nothing
+// but whitespace in them, and returns how many were removed. Removing one can
+// leave its parent empty, so the parent is checked again afterwards.
+func (c *CleanupProcessor) stripEmptyDivs(rootNode *html.Node) int {
+	isEmptyDiv := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "div" && len(n.Attr) == 0 && isBlank(n)
+	}
+
+	var empty []*html.Node
+	WalkNodes(c.logger, rootNode, isEmptyDiv, func(n *html.Node) bool {
+		empty = append(empty, n)
+		return true
+	})
+
+	removed := 0
+	for len(empty) > 0 {
+		n := empty[0]
+		empty = empty[1:]
+		parent := n.Parent
+		if parent == nil {
+			continue
+		}
+		parent.RemoveChild(n)
+		removed++
+		if parent != rootNode && isEmptyDiv(parent) {
+			empty = append(empty, parent)
+		}
+	}
+	return removed
+}
+
+// collapseBr removes each <br> whose previous sibling, ignoring whitespace
+// text and comments, is also a <br>, and returns how many were removed
+func (c *CleanupProcessor) collapseBr(rootNode *html.Node) int {
+	isBr := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "br"
+	}
+
+	var repeated []*html.Node
+	WalkNodes(c.logger, rootNode, isBr, func(n *html.Node) bool {
+		for prev := n.PrevSibling; prev != nil; prev = prev.PrevSibling {
+			if prev.Type == html.CommentNode || (prev.Type == html.TextNode && strings.TrimSpace(prev.Data) == "") {
+				continue
+			}
+			if isBr(prev) {
+				repeated = append(repeated, n)
+			}
+			break
+		}
+		return true
+	})
+
+	for _, n := range repeated {
+		n.Parent.RemoveChild(n)
+	}
+	return len(repeated)
+}
+
+// pixelWidthProperties are the inline style properties stripPixelWidths
+// removes when they are set in pixels
+var pixelWidthProperties = map[string]bool{"width": true, "min-width": true, "max-width": true}
+
+// stripGridChildPixelWidths removes pixel widths from the inline style of
+// grid containers' children, which stop them from fitting their grid track,
+// and returns how many declarations were removed
+func (c *CleanupProcessor) stripGridChildPixelWidths(rootNode *html.Node) int {
+	isGridChild := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Parent != nil && n.Parent.Type == html.ElementNode &&
+			hasAnyClassOrPrefix(n.Parent, "grid", "grid-cols-", "grid-rows-") && getAttr(n, "style") != ""
+	}
+
+	stripped := 0
+	WalkNodes(c.logger, rootNode, isGridChild, func(n *html.Node) bool {
+		style, count := stripPixelWidths(getAttr(n, "style"))
+		if count == 0 {
+			return false
+		}
+		stripped += count
+		if style == "" {
+			removeAttr(n, "style")
+		} else {
+			setAttr(n, "style", style)
+		}
+		return false
+	})
+	return stripped
+}
+
+// stripPixelWidths removes width declarations with a pixel value from an
+// inline style, returning the remaining style and how many were removed
+func stripPixelWidths(style string) (string, int) {
+	var kept []string
+	removed := 0
+	for _, decl := range strings.Split(style, ";") {
+		decl = strings.TrimSpace(decl)
+		if decl == "" {
+			continue
+		}
+		prop, value, ok := strings.Cut(decl, ":")
+		if ok && pixelWidthProperties[strings.ToLower(strings.TrimSpace(prop))] && isPixelValue(value) {
+			removed++
+			continue
+		}
+		kept = append(kept, decl)
+	}
+	return strings.Join(kept, "; "), removed
+}
+
+// isPixelValue reports whether a CSS value is a length in px, such as
+// "320px" or "12.5px !important"
+func isPixelValue(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	value = strings.TrimSpace(strings.TrimSuffix(value, "!important"))
+	number, ok := strings.CutSuffix(value, "px")
+	if !ok || number == "" {
+		return false
+	}
+	_, err := strconv.ParseFloat(number, 64)
+	return err == nil
+}
+
+// dedupeIDs renames repeated id attributes in document order, keeping the
+// first, and returns how many were renamed. A repeat of "hero" becomes the
+// first of "hero-2", "hero-3", ... not already in use.
+func (c *CleanupProcessor) dedupeIDs(rootNode *html.Node) int {
+	hasID := func(n *html.Node) bool {
+		return n.Type == html.ElementNode && getAttr(n, "id") != ""
+	}
+
+	var elements []*html.Node
+	used := map[string]bool{}
+	WalkNodes(c.logger, rootNode, hasID, func(n *html.Node) bool {
+		elements = append(elements, n)
+		used[getAttr(n, "id")] = true
+		return false
+	})
+
+	seen := map[string]bool{}
+	renamed := 0
+	for _, n := range elements {
+		id := getAttr(n, "id")
+		if !seen[id] {
+			seen[id] = true
+			continue
+		}
+		next := id
+		for i := 2; used[next]; i++ {
+			next = id + "-" + strconv.Itoa(i)
+		}
+		used[next] = true
+		seen[next] = true
+		setAttr(n, "id", next)
+		renamed++
+	}
+	return renamed
 }
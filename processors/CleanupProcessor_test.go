@@ -0,0 +1,103 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/utils"
+
+	"golang.org/x/net/html"
+)
+
+func TestCleanupFixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		settings common.CleanupProcessorSettings
+		counts   map[string]int
+	}{
+		// Every <br> of the nested grids goes, without skipping siblings
+		{"nested-grid", common.CleanupProcessorSettings{RemoveBrInGrids: true}, map[string]int{"br_removed": 11}},
+		{"collapse-br", common.CleanupProcessorSettings{CollapseBr: true}, map[string]int{"br_collapsed": 5}},
+		{"empty-elements", common.CleanupProcessorSettings{StripEmptyParagraphs: true, StripEmptyDivs: true}, map[string]int{"empty_p_removed": 2, "empty_div_removed": 5}},
+		{"grid-widths", common.CleanupProcessorSettings{StripGridChildPixelWidths: true}, map[string]int{"pixel_widths_stripped": 4}},
+		{"duplicate-ids", common.CleanupProcessorSettings{DedupeIDs: true}, map[string]int{"ids_renamed": 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			p := NewCleanupProcessor(tt.settings)
+			result, err := p.ProcessWithResult(context.Background(), readFixture(t, "cleanup/"+tt.fixture+".html"))
+			if err != nil {
+				t.Fatalf("ProcessWithResult() error = %v", err)
+			}
+			checkGolden(t, "cleanup/"+tt.fixture+".golden.html", result.Output)
+			for key, want := range tt.counts {
+				if got := result.Counts[key]; got != want {
+					t.Errorf("counts[%s] = %d, want %d", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCleanupRulesToggle(t *testing.T) {
+	// With every rule off the fixtures come out as parsed
+	for _, fixture := range []string{"nested-grid", "collapse-br", "empty-elements", "grid-widths", "duplicate-ids"} {
+		input := readFixture(t, "cleanup/"+fixture+".html")
+		got, err := NewCleanupProcessor(common.CleanupProcessorSettings{}).Process(context.Background(), input)
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", fixture, err)
+		}
+		if want := renderFixture(t, input); string(got) != want {
+			t.Errorf("%s: Process() with no rules changed the page:\ngot:\n%s\nwant:\n%s", fixture, got, want)
+		}
+	}
+}
+
+func TestCleanupSettingsDefaults(t *testing.T) {
+	settings, err := common.DefaultConfig().CleanupProcessorSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings != (common.CleanupProcessorSettings{RemoveBrInGrids: true}) {
+		t.Errorf("default settings = %+v, want only remove_br_in_grids", settings)
+	}
+}
+
+func TestWalkNodesRemovalSafe(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<ul><li>a</li><li>b</li><li>c</li><li>d</li></ul>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing each visited item and its next sibling still visits the rest
+	var visited []string
+	isItem := func(n *html.Node) bool { return n.Type == html.ElementNode && n.Data == "li" }
+	WalkNodes(nil, doc, isItem, func(n *html.Node) bool {
+		visited = append(visited, n.FirstChild.Data)
+		if next := n.NextSibling; next != nil {
+			n.Parent.RemoveChild(next)
+		}
+		n.Parent.RemoveChild(n)
+		return true
+	})
+	if got := strings.Join(visited, ","); got != "a,c" {
+		t.Errorf("visited %s, want a,c (b and d removed before their turn)", got)
+	}
+}
+
+// renderFixture parses and renders input the way the processors do
+func renderFixture(t *testing.T, input []byte) string {
+	t.Helper()
+
+	doc, err := html.Parse(strings.NewReader(string(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := utils.RenderCanonical(&b, doc); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package processors
+
+import (
+	"strings"
+)
+
+// Country calling codes of the regions phone numbers are formatted for
+var phoneCallingCodes = map[string]string{
+	"US": "1", "CA": "1", "GB": "44", "IE": "353", "FR": "33", "BE": "32", "CH": "41", "DE": "49",
+	"AT": "43", "NL": "31", "ES": "34", "PT": "351", "IT": "39", "SE": "46", "NO": "47", "DK": "45",
+	"FI": "358", "PL": "48", "AU": "61", "NZ": "64", "MX": "52", "BR": "55", "AR": "54", "JP": "81",
+	"IN": "91", "ZA": "27",
+}
+
+// Regions whose national numbers are dialled with a leading 0
+var phoneTrunkZero = map[string]bool{
+	"GB": true, "IE": true, "FR": true, "BE": true, "CH": true, "DE": true, "AT": true, "NL": true,
+	"SE": true, "FI": true, "AU": true, "NZ": true, "AR": true, "JP": true, "IN": true, "ZA": true,
+}
+
+// Region of a locale without one, by language
+var phoneLanguageRegions = map[string]string{
+	"en": "US", "fr": "FR", "de": "DE", "es": "ES", "it": "IT", "nl": "NL", "pt": "PT", "sv": "SE",
+	"nb": "NO", "da": "DK", "fi": "FI", "pl": "PL", "ja": "JP",
+}
+
+// localeRegion returns the region of a locale such as en-GB or fr_CA,
+// guessing it from the language when there is none
+func localeRegion(locale string) string {
+	language, region, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	if region = strings.ToUpper(region); len(region) == 2 {
+		return region
+	}
+	return phoneLanguageRegions[strings.ToLower(language)]
+}
+
+// formatPhone returns the tel: URI and the display form of a phone number
+// for the locale: national format for numbers of the locale's region,
+// international format for the others. Numbers without a + are taken to be
+// of the locale's region. ok is false when phone has too few digits to be a
+// phone number.
+func formatPhone(phone, locale string) (uri, display string, ok bool) {
+	phone = strings.TrimSpace(phone)
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+
+	international := strings.HasPrefix(phone, "+")
+	if !international && strings.HasPrefix(digits, "00") {
+		international, digits = true, digits[2:]
+	}
+	if len(digits) < 7 || len(digits) > 15 {
+		return "", "", false
+	}
+
+	localRegion := localeRegion(locale)
+	region, code, national := localRegion, phoneCallingCodes[localRegion], digits
+	if international {
+		region, code = "", ""
+		// Calling codes are prefix-free, so at most one matches
+		for r, c := range phoneCallingCodes {
+			if strings.HasPrefix(digits, c) && (region == "" || r == localRegion) {
+				region, code = r, c
+			}
+		}
+		national = strings.TrimPrefix(digits, code)
+	} else if code == "" {
+		// Unknown region: dial the number as it was written
+		return "tel:" + digits, phone, true
+	} else if code == "1" && len(digits) == 11 && digits[0] == '1' {
+		national = digits[1:]
+	} else if phoneTrunkZero[region] {
+		national = strings.TrimPrefix(digits, "0")
+	}
+
+	if code == "" {
+		return "tel:+" + digits, "+" + groupDigits(digits), true
+	}
+	uri = "tel:+" + code + national
+	if region != localRegion && code != phoneCallingCodes[localRegion] {
+		return uri, "+" + code + " " + groupDigits(national), true
+	}
+	if display, ok := nationalPhone(region, national); ok {
+		return uri, display, true
+	}
+	// Area codes vary in length; a number written with spaces keeps them
+	if !international && strings.ContainsAny(phone, " -./()") {
+		return uri, phone, true
+	}
+	if phoneTrunkZero[region] {
+		return uri, "0" + groupDigits(national), true
+	}
+	return uri, groupDigits(national), true
+}
+
+// nationalPhone formats a national number the way the region writes it, for
+// the regions with a fixed format
+func nationalPhone(region, national string) (string, bool) {
+	switch {
+	case phoneCallingCodes[region] == "1" && len(national) == 10:
+		return "(" + national[:3] + ") " + national[3:6] + "-" + national[6:], true
+	case region == "FR" && len(national) == 9:
+		return "0" + national[:1] + " " + national[1:3] + " " + national[3:5] + " " + national[5:7] + " " + national[7:], true
+	case region == "GB" && len(national) == 10 && national[0] == '2':
+		return "0" + national[:2] + " " + national[2:6] + " " + national[6:], true
+	case region == "GB" && len(national) == 10:
+		return "0" + national[:4] + " " + national[4:], true
+	}
+	return "", false
+}
+
+// groupDigits splits digits into groups of three, the first possibly
+// shorter and the last of four
+func groupDigits(digits string) string {
+	if len(digits) <= 4 {
+		return digits
+	}
+	head, tail := digits[:len(digits)-4], digits[len(digits)-4:]
+	groups := []string{tail}
+	for len(head) > 3 {
+		groups = append([]string{head[len(head)-3:]}, groups...)
+		head = head[:len(head)-3]
+	}
+	return strings.Join(append([]string{head}, groups...), " ")
+}
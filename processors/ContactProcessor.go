@@ -0,0 +1,263 @@
+package processors
+
+import (
+	"awning-backend/common"
+	"awning-backend/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// ContactAttr marks an element to fill with one of the tenant's contact
+	// details: address, phone, email or hours
+	ContactAttr = "data-contact"
+)
+
+var (
+	// Visible text that is a phone number or an email address, and so is
+	// replaced along with the link's href
+	contactPhoneText = regexp.MustCompile(`^\+?[\d\s().\-/]{7,}$`)
+	contactEmailText = regexp.MustCompile(`^[^\s@]+@[^\s@]+\.[^\s@]+$`)
+)
+
+// ContactDetails are the tenant's contact details, from its profile. Empty
+// values leave the matching markup as it was generated.
+type ContactDetails struct {
+	Phone   string
+	Email   string
+	Address string
+	Hours   string
+	Locale  string // formats the phone number, such as en-US
+}
+
+type contactDetailsCtxKey struct{}
+
+// WithContactDetails returns a context carrying the contact details the
+// contact processor fills in
+func WithContactDetails(ctx context.Context, details ContactDetails) context.Context {
+	return context.WithValue(ctx, contactDetailsCtxKey{}, details)
+}
+
+func contactDetailsFromContext(ctx context.Context) ContactDetails {
+	details, _ := ctx.Value(contactDetailsCtxKey{}).(ContactDetails)
+	return details
+}
+
+// ContactProcessor puts the tenant's real contact details into the page:
+// tel: and mailto: links, <address> elements and elements marked with
+// data-contact. It runs after the placeholder processor, which replaces
+// stock contact text elsewhere.
+type ContactProcessor struct {
+	logger *slog.Logger
+}
+
+// NewContactProcessor creates a contact processor
+func NewContactProcessor() *ContactProcessor {
+	return &ContactProcessor{
+		logger: slog.With("processor", "ContactProcessor"),
+	}
+}
+
+func (p *ContactProcessor) Name() string {
+	return "ContactProcessor"
+}
+
+// Process fills in contact details
+func (p *ContactProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	result, err := p.ProcessWithResult(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// ProcessWithResult fills in contact details, reporting what it replaced
+// and what the profile lacks
+func (p *ContactProcessor) ProcessWithResult(ctx context.Context, input []byte) (*common.ProcessorResult, error) {
+	rootNode, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		p.logger.Error("Failed to parse HTML", "error", err)
+		return nil, err
+	}
+
+	result, _ := p.ProcessSubtree(ctx, rootNode, nil)
+
+	var outputBuf bytes.Buffer
+	if err := utils.RenderCanonical(&outputBuf, rootNode); err != nil {
+		p.logger.Error("Failed to render HTML", "error", err)
+		return nil, err
+	}
+
+	result.Output = outputBuf.Bytes()
+	return result, nil
+}
+
+// ProcessNode fills in contact details in a parsed document
+func (p *ContactProcessor) ProcessNode(ctx context.Context, doc *html.Node) (*common.ProcessorResult, error) {
+	return p.ProcessSubtree(ctx, doc, nil)
+}
+
+// contactScan collects what one pass over a document found
+type contactScan struct {
+	details      ContactDetails
+	phoneURI     string
+	phoneDisplay string
+	result       *common.ProcessorResult
+	missing      map[string]int // markup left as generated, by detail
+}
+
+// ProcessSubtree fills in contact details in part of a document
+func (p *ContactProcessor) ProcessSubtree(ctx context.Context, node, _ *html.Node) (*common.ProcessorResult, error) {
+	scan := &contactScan{
+		details: contactDetailsFromContext(ctx),
+		result:  &common.ProcessorResult{},
+		missing: map[string]int{},
+	}
+	if scan.details.Phone != "" {
+		var ok bool
+		scan.phoneURI, scan.phoneDisplay, ok = formatPhone(scan.details.Phone, scan.details.Locale)
+		if !ok {
+			scan.result.Warn(fmt.Sprintf("Profile phone %q is not a phone number, phone links left as generated", scan.details.Phone))
+			scan.details.Phone = ""
+		}
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "script" || n.Data == "style":
+				return
+			case getAttr(n, ContactAttr) != "":
+				p.fillMarked(scan, n)
+				return
+			case n.Data == "a":
+				p.fillLink(scan, n)
+			case n.Data == "address" && onlyTextAndBreaks(n):
+				p.fill(scan, n, "address", scan.details.Address)
+				return
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	for _, detail := range []string{"phone", "email", "address", "hours"} {
+		if count := scan.missing[detail]; count > 0 {
+			scan.result.Count("missing", count)
+			scan.result.Warn(fmt.Sprintf("No %s in the tenant profile, %d elements left as generated", detail, count))
+		}
+	}
+	return scan.result, nil
+}
+
+// fillMarked fills an element marked with data-contact. Phone and email
+// links get their href too.
+func (p *ContactProcessor) fillMarked(scan *contactScan, n *html.Node) {
+	switch detail := strings.TrimSpace(getAttr(n, ContactAttr)); detail {
+	case "phone":
+		if p.fill(scan, n, detail, scan.phoneDisplay) && n.Data == "a" {
+			setAttr(n, "href", scan.phoneURI)
+		}
+	case "email":
+		if p.fill(scan, n, detail, scan.details.Email) && n.Data == "a" {
+			setAttr(n, "href", "mailto:"+scan.details.Email)
+		}
+	case "address":
+		p.fill(scan, n, detail, scan.details.Address)
+	case "hours":
+		p.fill(scan, n, detail, scan.details.Hours)
+	default:
+		scan.result.Warn(fmt.Sprintf("Unknown %s %q on <%s>", ContactAttr, detail, n.Data))
+	}
+}
+
+// fillLink points tel: and mailto: links at the profile's phone and email,
+// replacing their text when it is the number or address itself
+func (p *ContactProcessor) fillLink(scan *contactScan, n *html.Node) {
+	href := strings.TrimSpace(getAttr(n, "href"))
+	scheme, _, _ := strings.Cut(href, ":")
+	switch strings.ToLower(scheme) {
+	case "tel":
+		if scan.details.Phone == "" {
+			scan.missing["phone"]++
+			return
+		}
+		setAttr(n, "href", scan.phoneURI)
+		replaceMatchingText(n, contactPhoneText, scan.phoneDisplay)
+		scan.result.Count("phone_replaced", 1)
+	case "mailto":
+		if scan.details.Email == "" {
+			scan.missing["email"]++
+			return
+		}
+		// Keep ?subject= and the like
+		_, query, _ := strings.Cut(href, "?")
+		if query != "" {
+			query = "?" + query
+		}
+		setAttr(n, "href", "mailto:"+scan.details.Email+query)
+		replaceMatchingText(n, contactEmailText, scan.details.Email)
+		scan.result.Count("email_replaced", 1)
+	}
+}
+
+// fill replaces the content of n with value, lines separated by <br>. It
+// leaves n alone and reports false when the profile lacks the detail.
+func (p *ContactProcessor) fill(scan *contactScan, n *html.Node, detail, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		scan.missing[detail]++
+		return false
+	}
+	setText(n, value)
+	scan.result.Count(detail+"_replaced", 1)
+	return true
+}
+
+// setText replaces the children of n with the lines of text, separated by <br>
+func setText(n *html.Node, text string) {
+	for n.FirstChild != nil {
+		n.RemoveChild(n.FirstChild)
+	}
+	for i, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		if i > 0 {
+			n.AppendChild(&html.Node{Type: html.ElementNode, Data: "br"})
+		}
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: strings.TrimSpace(line)})
+	}
+}
+
+// replaceMatchingText replaces the text nodes under n that re matches, once
+// trimmed, with value, keeping icons and other markup around them
+func replaceMatchingText(n *html.Node, re *regexp.Regexp, value string) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == html.TextNode:
+			trimmed := strings.TrimSpace(child.Data)
+			if trimmed != "" && re.MatchString(trimmed) {
+				child.Data = strings.Replace(child.Data, trimmed, value, 1)
+			}
+		case child.Type == html.ElementNode:
+			replaceMatchingText(child, re, value)
+		}
+	}
+}
+
+// onlyTextAndBreaks reports whether n holds nothing but text and <br>, so
+// replacing its content loses no markup
+func onlyTextAndBreaks(n *html.Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data != "br" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package processors
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContactFixtures(t *testing.T) {
+	tests := []struct {
+		name     string
+		details  ContactDetails
+		counts   map[string]int
+		warnings []string
+	}{
+		{"full", ContactDetails{
+			Phone:   "415-555-0142",
+			Email:   "hello@crumb.co",
+			Address: "18 Valencia St\nSan Francisco, CA 94103",
+			Hours:   "Tue–Sun 7am–3pm",
+			Locale:  "en-US",
+		}, map[string]int{"phone_replaced": 3, "email_replaced": 2, "address_replaced": 1, "hours_replaced": 1}, []string{
+			`Unknown data-contact "fax" on <div>`,
+		}},
+		// Details the profile lacks are left as generated
+		{"partial", ContactDetails{Email: "hello@crumb.co", Locale: "en-US"}, map[string]int{"email_replaced": 2, "missing": 5}, []string{
+			"No phone in the tenant profile, 3 elements left as generated",
+			"No address in the tenant profile, 1 elements left as generated",
+			"No hours in the tenant profile, 1 elements left as generated",
+		}},
+		{"empty", ContactDetails{}, map[string]int{"missing": 7}, []string{
+			"No email in the tenant profile, 2 elements left as generated",
+		}},
+		{"bad-phone", ContactDetails{Phone: "call us", Locale: "en-US"}, map[string]int{"missing": 7}, []string{
+			`Profile phone "call us" is not a phone number, phone links left as generated`,
+		}},
+		// A London number, written nationally for a British site and
+		// internationally for a French one
+		{"gb", ContactDetails{Phone: "020 7946 0958", Locale: "en-GB"}, map[string]int{"phone_replaced": 3}, nil},
+		{"gb-for-fr", ContactDetails{Phone: "+44 20 7946 0958", Locale: "fr-FR"}, map[string]int{"phone_replaced": 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithContactDetails(context.Background(), tt.details)
+			result, err := NewContactProcessor().ProcessWithResult(ctx, readFixture(t, "contact/page.html"))
+			if err != nil {
+				t.Fatalf("ProcessWithResult() error = %v", err)
+			}
+			checkGolden(t, "contact/"+tt.name+".golden.html", result.Output)
+
+			for name, want := range tt.counts {
+				if result.Counts[name] != want {
+					t.Errorf("counts = %v, want %s %d", result.Counts, name, want)
+				}
+			}
+			for _, want := range tt.warnings {
+				found := false
+				for _, warning := range result.Warnings {
+					found = found || warning == want
+				}
+				if !found {
+					t.Errorf("warnings lack %s: %q", want, result.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatPhone(t *testing.T) {
+	tests := []struct {
+		phone, locale string
+		uri, display  string
+	}{
+		// North America, one calling code for both regions
+		{"415-555-0142", "en-US", "tel:+14155550142", "(415) 555-0142"},
+		{"1 (415) 555 0142", "en_CA", "tel:+14155550142", "(415) 555-0142"},
+		{"+1 415 555 0142", "en", "tel:+14155550142", "(415) 555-0142"},
+		{"+1 415 555 0142", "en-CA", "tel:+14155550142", "(415) 555-0142"},
+		// National formats drop the trunk zero from the URI
+		{"01 42 68 53 00", "fr-FR", "tel:+33142685300", "01 42 68 53 00"},
+		{"0142685300", "fr", "tel:+33142685300", "01 42 68 53 00"},
+		{"+44 20 7946 0958", "en-GB", "tel:+442079460958", "020 7946 0958"},
+		{"01632 960983", "en-GB", "tel:+441632960983", "01632 960983"},
+		{"+353 1 234 5678", "en-IE", "tel:+35312345678", "01 234 5678"},
+		// Without a fixed format, spacing as written is kept
+		{"030 1234567", "de-DE", "tel:+49301234567", "030 1234567"},
+		{"0301234567", "de", "tel:+49301234567", "030 123 4567"},
+		// Numbers of another region are written internationally
+		{"+33 1 42 68 53 00", "en-US", "tel:+33142685300", "+33 14 268 5300"},
+		{"0044 20 7946 0958", "fr-FR", "tel:+442079460958", "+44 207 946 0958"},
+		{"+81 3 1234 5678", "en-GB", "tel:+81312345678", "+81 31 234 5678"},
+		// Unknown regions are dialled as written
+		{"5551234", "xx", "tel:5551234", "5551234"},
+	}
+	for _, tt := range tests {
+		uri, display, ok := formatPhone(tt.phone, tt.locale)
+		if !ok || uri != tt.uri || display != tt.display {
+			t.Errorf("formatPhone(%q, %q) = %q, %q, %v; want %q, %q", tt.phone, tt.locale, uri, display, ok, tt.uri, tt.display)
+		}
+	}
+
+	for _, phone := range []string{"12345", "call us", "+1 234 567 890 123 456 7"} {
+		if uri, display, ok := formatPhone(phone, "en-US"); ok {
+			t.Errorf("formatPhone(%q) = %q, %q; want it refused", phone, uri, display)
+		}
+	}
+}
@@ -2,28 +2,63 @@ package processors
 
 import (
 	"awning-backend/common"
+	"awning-backend/utils"
 	"bytes"
 	"context"
+	"fmt"
 	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
 
 	"golang.org/x/net/html"
 )
 
 const (
-	TAILWIND_CDN_CSS_URL = "https://cdnjs.cloudflare.com/ajax/libs/tailwindcss/2.2.19/tailwind.min.css"
+	TAILWIND_CDN_CSS_URL = common.DEFAULT_TAILWIND_CSS_URL
 )
 
+const (
+	// BrandAttr marks the elements added for the tenant's brand, so
+	// processing a page again replaces them instead of adding more
+	BrandAttr = "data-awning-brand"
+)
+
+var brandColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// BrandAssets are the tenant's brand settings the header processor adds to
+// the page. Empty values add nothing.
+type BrandAssets struct {
+	FaviconURL    string
+	PrimaryFont   string // from common.BrandFonts
+	SecondaryFont string
+	Colors        []string // #rrggbb, primary first
+}
+
+type brandAssetsCtxKey struct{}
+
+// WithBrandAssets returns a context carrying the brand assets the header
+// processor adds
+func WithBrandAssets(ctx context.Context, brand BrandAssets) context.Context {
+	return context.WithValue(ctx, brandAssetsCtxKey{}, brand)
+}
+
+func brandAssetsFromContext(ctx context.Context) BrandAssets {
+	brand, _ := ctx.Value(brandAssetsCtxKey{}).(BrandAssets)
+	return brand
+}
+
 type HeaderProcessor struct {
-	logger *slog.Logger
-	cfg    *common.Config
+	logger   *slog.Logger
+	settings common.HeaderProcessorSettings
 }
 
-func NewHeaderProcessor(cfg *common.Config) *HeaderProcessor {
+func NewHeaderProcessor(settings common.HeaderProcessorSettings) *HeaderProcessor {
 	logger := slog.With("processor", "HeaderProcessor")
 
 	return &HeaderProcessor{
-		logger: logger,
-		cfg:    cfg,
+		logger:   logger,
+		settings: settings,
 	}
 }
 
@@ -43,7 +78,23 @@ func (p *HeaderProcessor) Process(ctx context.Context, input []byte) ([]byte, er
 		return nil, err
 	}
 
-	p.logger.Info("Root node", "type", rootNode.Type, "data", rootNode.Data)
+	if _, err := p.ProcessNode(ctx, rootNode); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := utils.RenderCanonical(&buf, rootNode); err != nil {
+		p.logger.Error("Failed to render modified HTML", "error", err)
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ProcessNode adds the viewport meta tag, stylesheet links and the
+// tenant's brand assets to the <head> of a parsed document
+func (p *HeaderProcessor) ProcessNode(ctx context.Context, rootNode *html.Node) (*common.ProcessorResult, error) {
+	result := &common.ProcessorResult{}
 
 	htmlNode := rootNode.FirstChild
 	for htmlNode != nil && !(htmlNode.Type == html.ElementNode && htmlNode.Data == "html") {
@@ -53,7 +104,7 @@ func (p *HeaderProcessor) Process(ctx context.Context, input []byte) ([]byte, er
 
 	if htmlNode == nil {
 		p.logger.Warn("No <html> element found in HTML")
-		return input, nil
+		return result, nil
 	}
 
 	head := htmlNode.FirstChild
@@ -64,27 +115,151 @@ func (p *HeaderProcessor) Process(ctx context.Context, input []byte) ([]byte, er
 
 	if head == nil {
 		p.logger.Warn("No <head> element found in HTML")
-		return input, nil
+		return result, nil
+	}
+
+	if p.settings.InjectViewport && !hasViewportMeta(head) {
+		head.AppendChild(&html.Node{
+			Type: html.ElementNode,
+			Data: "meta",
+			Attr: []html.Attribute{
+				{Key: "name", Val: "viewport"},
+				{Key: "content", Val: "width=device-width, initial-scale=1"},
+			},
+		})
 	}
 
-	p.logger.Info("Adding Tailwind CSS link to <head> - not supported by Tailwind CDN for production use")
+	p.logger.Info("Adding CSS links to <head> - the Tailwind CDN build is not meant for production use", "css_urls", p.settings.CSSURLs)
 
-	// Add Tailwind CSS link
-	linkNode := &html.Node{
-		Type: html.ElementNode,
-		Data: "link",
-		Attr: []html.Attribute{
-			{Key: "rel", Val: "stylesheet"},
-			{Key: "href", Val: TAILWIND_CDN_CSS_URL},
-		},
+	// Add stylesheet links the page doesn't already have
+	for _, cssURL := range p.settings.CSSURLs {
+		if hasLink(head, "stylesheet", cssURL) {
+			continue
+		}
+		linkNode := &html.Node{
+			Type: html.ElementNode,
+			Data: "link",
+			Attr: []html.Attribute{
+				{Key: "rel", Val: "stylesheet"},
+				{Key: "href", Val: cssURL},
+			},
+		}
+		head.AppendChild(linkNode)
 	}
-	head.AppendChild(linkNode)
 
-	var buf bytes.Buffer
-	if err := html.Render(&buf, rootNode); err != nil {
-		p.logger.Error("Failed to render modified HTML", "error", err)
-		return nil, err
+	if p.settings.InjectBrand {
+		injectBrand(head, brandAssetsFromContext(ctx), result)
 	}
 
-	return buf.Bytes(), nil
+	return result, nil
+}
+
+// injectBrand adds the favicon link, font links and a :root block of CSS
+// custom properties for the palette and fonts to head, replacing those added
+// before. The favicon replaces the page's own icon links; font links the
+// page already has aren't added again.
+func injectBrand(head *html.Node, brand BrandAssets, result *common.ProcessorResult) {
+	for n := head.FirstChild; n != nil; {
+		next := n.NextSibling
+		if n.Type == html.ElementNode && hasAnyAttr(n, []string{BrandAttr}) {
+			head.RemoveChild(n)
+		}
+		n = next
+	}
+
+	add := func(data string, attrs ...string) *html.Node {
+		node := &html.Node{Type: html.ElementNode, Data: data, Attr: []html.Attribute{{Key: BrandAttr, Val: ""}}}
+		for i := 0; i+1 < len(attrs); i += 2 {
+			node.Attr = append(node.Attr, html.Attribute{Key: attrs[i], Val: attrs[i+1]})
+		}
+		head.AppendChild(node)
+		return node
+	}
+
+	if brand.FaviconURL != "" {
+		for n := head.FirstChild; n != nil; {
+			next := n.NextSibling
+			if isIconLink(n) {
+				head.RemoveChild(n)
+			}
+			n = next
+		}
+		add("link", "rel", "icon", "href", brand.FaviconURL)
+		result.Count("favicon", 1)
+	}
+
+	var fonts []string
+	for _, font := range []string{brand.PrimaryFont, brand.SecondaryFont} {
+		if _, ok := common.BrandFonts[font]; ok && !slices.Contains(fonts, font) {
+			fonts = append(fonts, font)
+		}
+	}
+	if fontsURL := common.GoogleFontsURL(fonts...); fontsURL != "" {
+		if !hasLink(head, "preconnect", common.GOOGLE_FONTS_API_URL) {
+			add("link", "rel", "preconnect", "href", common.GOOGLE_FONTS_API_URL)
+		}
+		if !hasLink(head, "preconnect", common.GOOGLE_FONTS_FILES_URL) {
+			add("link", "rel", "preconnect", "href", common.GOOGLE_FONTS_FILES_URL, "crossorigin", "")
+		}
+		if !hasLink(head, "stylesheet", fontsURL) {
+			add("link", "rel", "stylesheet", "href", fontsURL)
+		}
+		result.Count("fonts", len(fonts))
+	}
+
+	var properties []string
+	var colors int
+	for _, color := range brand.Colors {
+		if !brandColor.MatchString(color) {
+			result.Warn(fmt.Sprintf("Brand color %q is not #rrggbb, left out", color))
+			continue
+		}
+		colors++
+		properties = append(properties, fmt.Sprintf("--brand-color-%d:%s", colors, color))
+		switch colors {
+		case 1:
+			properties = append(properties, "--brand-primary:"+color)
+		case 2:
+			properties = append(properties, "--brand-secondary:"+color)
+		}
+	}
+	if _, ok := common.BrandFonts[brand.PrimaryFont]; ok {
+		properties = append(properties, "--brand-font-primary:"+common.FontFamilyValue(brand.PrimaryFont))
+	}
+	if _, ok := common.BrandFonts[brand.SecondaryFont]; ok {
+		properties = append(properties, "--brand-font-secondary:"+common.FontFamilyValue(brand.SecondaryFont))
+	}
+	if len(properties) > 0 {
+		style := add("style")
+		style.AppendChild(&html.Node{Type: html.TextNode, Data: ":root{" + strings.Join(properties, ";") + "}"})
+		result.Count("colors", colors)
+	}
+}
+
+// isIconLink reports whether n is a favicon link (rel icon or shortcut icon)
+func isIconLink(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "link" {
+		return false
+	}
+	return slices.Contains(strings.Fields(strings.ToLower(getAttr(n, "rel"))), "icon")
+}
+
+// hasLink reports whether head already links href with rel
+func hasLink(head *html.Node, rel, href string) bool {
+	for n := head.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && n.Data == "link" && strings.EqualFold(getAttr(n, "rel"), rel) && strings.TrimRight(getAttr(n, "href"), "/") == strings.TrimRight(href, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasViewportMeta reports whether head already has a viewport meta tag
+func hasViewportMeta(head *html.Node) bool {
+	for n := head.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == html.ElementNode && n.Data == "meta" && strings.EqualFold(getAttr(n, "name"), "viewport") {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,110 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+
+	"golang.org/x/net/html"
+)
+
+// newTestHeaderProcessor returns a header processor with the default settings
+func newTestHeaderProcessor(t *testing.T) *HeaderProcessor {
+	t.Helper()
+
+	settings, err := common.DefaultConfig().HeaderProcessorSettings()
+	if err != nil {
+		t.Fatalf("HeaderProcessorSettings() error = %v", err)
+	}
+	return NewHeaderProcessor(settings)
+}
+
+func TestHeaderBrandFixtures(t *testing.T) {
+	brand := BrandAssets{
+		FaviconURL:    "https://cdn.awning.test/favicon.png",
+		PrimaryFont:   "Playfair Display",
+		SecondaryFont: "Inter",
+		Colors:        []string{"#7a3e1d", "#f4e9dc", "red", "#2f2f2f"},
+	}
+	tests := []struct {
+		fixture string
+		golden  string
+		brand   BrandAssets
+		counts  map[string]int
+	}{
+		{"brand", "brand", brand, map[string]int{"favicon": 1, "fonts": 2, "colors": 3}},
+		// The page's icons and an earlier run's brand elements are replaced,
+		// and links the page already has aren't added again
+		{"existing", "existing", brand, map[string]int{"favicon": 1, "fonts": 2, "colors": 3}},
+		// Fragments are given a <head>
+		{"no-head", "no-head", brand, map[string]int{"favicon": 1, "fonts": 2, "colors": 3}},
+		{"existing", "existing-unbranded", BrandAssets{}, nil},
+	}
+	for _, tt := range tests {
+		ctx := WithBrandAssets(context.Background(), tt.brand)
+		p := newTestHeaderProcessor(t)
+
+		doc, err := html.Parse(strings.NewReader(string(readFixture(t, "header/"+tt.fixture+".html"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := p.ProcessNode(ctx, doc)
+		if err != nil {
+			t.Fatalf("%s: ProcessNode() error = %v", tt.golden, err)
+		}
+		for name, want := range tt.counts {
+			if result.Counts[name] != want {
+				t.Errorf("%s: counts = %v, want %s %d", tt.golden, result.Counts, name, want)
+			}
+		}
+		if len(tt.counts) == 0 && len(result.Counts) > 0 {
+			t.Errorf("%s: counts = %v, want none", tt.golden, result.Counts)
+		}
+		if tt.brand.Colors != nil && (len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], `"red"`)) {
+			t.Errorf("%s: warnings = %q, want one for the invalid color", tt.golden, result.Warnings)
+		}
+
+		output, err := p.Process(ctx, readFixture(t, "header/"+tt.fixture+".html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, "header/"+tt.golden+".golden.html", output)
+
+		// Processing the page again adds nothing
+		again, err := p.Process(ctx, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(output) {
+			t.Errorf("%s: Process() isn't idempotent:\nonce:\n%s\ntwice:\n%s", tt.golden, output, again)
+		}
+	}
+}
+
+func TestHeaderWithoutHead(t *testing.T) {
+	p := newTestHeaderProcessor(t)
+	ctx := WithBrandAssets(context.Background(), BrandAssets{FaviconURL: "https://cdn.awning.test/favicon.png"})
+
+	// Trees that didn't come from html.Parse may have no <html> or <head>
+	section := &html.Node{Type: html.ElementNode, Data: "section"}
+	for _, root := range []*html.Node{
+		{Type: html.DocumentNode},
+		func() *html.Node {
+			root := &html.Node{Type: html.DocumentNode}
+			htmlNode := &html.Node{Type: html.ElementNode, Data: "html"}
+			root.AppendChild(htmlNode)
+			htmlNode.AppendChild(section)
+			return root
+		}(),
+	} {
+		result, err := p.ProcessNode(ctx, root)
+		if err != nil || len(result.Counts) > 0 {
+			t.Errorf("ProcessNode() without a <head> = %+v, %v; want nothing done", result, err)
+		}
+	}
+	if section.FirstChild != nil || section.NextSibling != nil {
+		t.Error("ProcessNode() without a <head> changed the page")
+	}
+}
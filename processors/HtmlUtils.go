@@ -2,6 +2,7 @@ package processors
 
 import (
 	"log/slog"
+	"slices"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -37,6 +38,12 @@ func setAttr(n *html.Node, key, value string) {
 	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
 }
 
+func removeAttr(n *html.Node, key string) {
+	n.Attr = slices.DeleteFunc(n.Attr, func(attr html.Attribute) bool {
+		return attr.Key == key
+	})
+}
+
 func hasAnyClassOrPrefix(n *html.Node, classes ...string) bool {
 	classAttr := getAttr(n, "class")
 	if classAttr == "" {
@@ -83,9 +90,25 @@ func hasAnyOfChildren(n *html.Node, keys []string) bool {
 	return false
 }
 
+// findElement returns the first element named tag under n, depth first
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
 type NodeFilter func(*html.Node) bool
 type NodeWalker func(node *html.Node) (stop bool)
 
+// WalkNodes calls walker on n and its descendants that pass filter, depth
+// first. A walker returning true skips the node's children. The walker may
+// remove the node it is given, or its siblings, from the tree.
 func WalkNodes(logger *slog.Logger, n *html.Node, filter NodeFilter, walker NodeWalker) {
 	if filter(n) {
 		stop := walker(n)
@@ -94,7 +117,15 @@ func WalkNodes(logger *slog.Logger, n *html.Node, filter NodeFilter, walker Node
 		}
 	}
 
+	// The walker may remove nodes, so walk a snapshot of the children and
+	// skip those no longer under n
+	var children []*html.Node
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
-		WalkNodes(logger, child, filter, walker)
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if child.Parent == n {
+			WalkNodes(logger, child, filter, walker)
+		}
 	}
 }
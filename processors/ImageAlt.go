@@ -0,0 +1,103 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"awning-backend/model"
+	"awning-backend/services"
+)
+
+// MAX_IMAGE_ALT_LENGTH caps generated alt text; screen readers handle
+// short descriptions best
+const MAX_IMAGE_ALT_LENGTH = 125
+
+// imageAltText returns alt text for a selected photo, preferring the
+// Unsplash alt description, then the description, then the search keywords
+func imageAltText(photo *services.UnsplashPhoto, keywords string) string {
+	var alt string
+	if photo != nil {
+		for _, desc := range []*string{photo.AltDescription, photo.Description} {
+			if desc != nil && strings.TrimSpace(*desc) != "" {
+				alt = *desc
+				break
+			}
+		}
+	}
+	if alt == "" {
+		alt = keywords
+	}
+
+	alt = strings.Join(strings.Fields(alt), " ")
+	if alt == "" {
+		alt = "Image"
+	}
+
+	return truncateAltText(alt, MAX_IMAGE_ALT_LENGTH)
+}
+
+// truncateAltText shortens text to at most max runes, cutting at a word
+// boundary where possible
+func truncateAltText(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)
+	cut := string(runes[:max])
+	if i := strings.LastIndex(cut, " "); i > max/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:-")
+}
+
+// photoCredit returns the attribution line for a photo
+func photoCredit(photo *services.UnsplashPhoto) string {
+	if photo == nil || photo.User.Name == "" {
+		return ""
+	}
+	return "Photo by " + photo.User.Name + " on Unsplash"
+}
+
+// ImageManifest collects the images chosen while processing a response so
+// they can be returned to the client alongside the content
+type ImageManifest struct {
+	mu     sync.Mutex
+	images []model.ChatImage
+}
+
+type imageManifestCtxKey struct{}
+
+// WithImageManifest returns a context that records chosen images into the
+// returned manifest
+func WithImageManifest(ctx context.Context) (context.Context, *ImageManifest) {
+	manifest := &ImageManifest{}
+	return context.WithValue(ctx, imageManifestCtxKey{}, manifest), manifest
+}
+
+func imageManifestFromContext(ctx context.Context) *ImageManifest {
+	manifest, _ := ctx.Value(imageManifestCtxKey{}).(*ImageManifest)
+	return manifest
+}
+
+// Add records an image; a nil manifest ignores it
+func (m *ImageManifest) Add(image model.ChatImage) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.images = append(m.images, image)
+}
+
+// Images returns the recorded images
+func (m *ImageManifest) Images() []model.ChatImage {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]model.ChatImage(nil), m.images...)
+}
@@ -0,0 +1,99 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"awning-backend/services"
+)
+
+func TestImageAltText(t *testing.T) {
+	long := strings.Repeat("golden loaves ", 20)
+	tests := []struct {
+		name     string
+		photo    *services.UnsplashPhoto
+		keywords string
+		want     string
+	}{
+		{"alt description", &services.UnsplashPhoto{AltDescription: ptr("  Bread on\n a shelf "), Description: ptr("A bakery")}, "bread", "Bread on a shelf"},
+		{"description when alt is nil", &services.UnsplashPhoto{Description: ptr("A bakery")}, "bread", "A bakery"},
+		{"description when alt is blank", &services.UnsplashPhoto{AltDescription: ptr("  "), Description: ptr("A bakery")}, "bread", "A bakery"},
+		{"keywords when both are nil", &services.UnsplashPhoto{}, "bread, bakery", "bread, bakery"},
+		{"keywords without a photo", nil, "bread", "bread"},
+		{"never empty", &services.UnsplashPhoto{Description: ptr("")}, " ", "Image"},
+		{"capped at a word boundary", &services.UnsplashPhoto{AltDescription: &long}, "", strings.TrimSpace(strings.Repeat("golden loaves ", 8)) + " golden"},
+	}
+	for _, tt := range tests {
+		if got := imageAltText(tt.photo, tt.keywords); got != tt.want {
+			t.Errorf("%s: imageAltText() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateAltText(t *testing.T) {
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly ten", 11, "exactly ten"},
+		{"a croissant, a baguette", 14, "a croissant"},
+		// No space late enough to cut at
+		{"pâtisserieboulangerie", 10, "pâtisserie"},
+		{"crème brûlée, éclair", 14, "crème brûlée"},
+	}
+	for _, tt := range tests {
+		got := truncateAltText(tt.text, tt.max)
+		if got != tt.want {
+			t.Errorf("truncateAltText(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.max {
+			t.Errorf("truncateAltText(%q, %d) is %d runes", tt.text, tt.max, n)
+		}
+	}
+}
+
+func TestImageProcessorAltAndManifest(t *testing.T) {
+	long := strings.Repeat("A very long description of a loaf ", 10)
+	svc := newTestUnsplash(t, map[string][]services.UnsplashPhoto{
+		"sourdough": {testPhoto("sourdough", nil, nil)},
+		"rye":       {testPhoto("rye", nil, &long)},
+		"oven":      {testPhoto("oven", ptr("A wood-fired oven"), nil)},
+	})
+	p := NewImageProcessor(testImageSettings(t), svc, nil, nil)
+
+	ctx, manifest := WithImageManifest(context.Background())
+	got, err := p.Process(ctx, readFixture(t, "images/alt.html"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	checkGolden(t, "images/alt.golden.html", got)
+
+	images := manifest.Images()
+	if len(images) != 3 {
+		t.Fatalf("manifest has %d images, want 3: %+v", len(images), images)
+	}
+	byPhoto := map[string]string{}
+	for _, image := range images {
+		if image.NodeID == "" || !strings.Contains(string(got), `data-image-id="`+image.NodeID+`"`) {
+			t.Errorf("manifest image %+v doesn't name a node of the output", image)
+		}
+		if image.Credit != "Photo by Ansel "+image.PhotoID+" on Unsplash" {
+			t.Errorf("manifest image %s credit = %q", image.PhotoID, image.Credit)
+		}
+		byPhoto[image.PhotoID] = image.Alt
+	}
+	wantAlt := map[string]string{
+		"sourdough": "sourdough",
+		"rye":       truncateAltText(strings.TrimSpace(long), MAX_IMAGE_ALT_LENGTH),
+		"oven":      "A wood-fired oven",
+	}
+	for photo, alt := range wantAlt {
+		if byPhoto[photo] != alt {
+			t.Errorf("manifest alt for %s = %q, want %q", photo, byPhoto[photo], alt)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package processors
+
+import (
+	"context"
+	"hash/fnv"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/services"
+
+	"golang.org/x/net/html"
+)
+
+// IMAGE_PENDING_ATTR marks images filled without a search while the Unsplash
+// quota was exhausted, so the pending images job can search for them later
+const IMAGE_PENDING_ATTR = "data-image-pending"
+
+type imageMotifCtxKey struct{}
+
+// WithImageMotif returns a context carrying the business motif whose
+// fallback images stand in for searches while the Unsplash quota is exhausted
+func WithImageMotif(ctx context.Context, motif model.BusinessMotif) context.Context {
+	return context.WithValue(ctx, imageMotifCtxKey{}, motif)
+}
+
+func imageMotifFromContext(ctx context.Context) model.BusinessMotif {
+	motif, _ := ctx.Value(imageMotifCtxKey{}).(model.BusinessMotif)
+	return motif
+}
+
+// fallbackImageResult fills a search refused while the Unsplash quota is
+// exhausted: with cached results of a similar search, or else one of the
+// motif's fallback images, picked by keywords so the same query gets the
+// same image. The result is pending, and has no images when neither exists.
+func fallbackImageResult(ctx context.Context, settings common.ImageProcessorSettings, svc *services.UnsplashService, req *ImageQueryRequest) *ImageQueryResult {
+	resp := &ImageQueryResult{RequestID: req.ID, Keywords: req.Keywords, Pending: true}
+
+	if results := svc.CachedSearch(ctx, req.Keywords, req.Orientation); results != nil {
+		for _, photo := range results.Results {
+			resp.ImageURLs = append(resp.ImageURLs, selectPhotoURL(settings, photo, req.Size))
+			resp.RawURLs = append(resp.RawURLs, photo.URLs.Raw)
+		}
+		resp.Photo = &results.Results[0]
+		return resp
+	}
+
+	urls := settings.FallbackImages[string(imageMotifFromContext(ctx))]
+	if len(urls) == 0 {
+		urls = settings.FallbackImages[string(model.BusinessMotifGeneric)]
+	}
+	if len(urls) > 0 {
+		hash := fnv.New32a()
+		hash.Write([]byte(req.Keywords))
+		resp.ImageURLs = []string{urls[hash.Sum32()%uint32(len(urls))]}
+	}
+	return resp
+}
+
+// markImagePending sets or clears IMAGE_PENDING_ATTR on an image node
+func markImagePending(n *html.Node, pending bool, result *common.ProcessorResult) {
+	if !pending {
+		removeAttr(n, IMAGE_PENDING_ATTR)
+		return
+	}
+	setAttr(n, IMAGE_PENDING_ATTR, "quota")
+	result.Count("images_pending", 1)
+}
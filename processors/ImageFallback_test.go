@@ -0,0 +1,148 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/services"
+)
+
+// testSearchCache is an UnsplashSearchCache over a map
+type testSearchCache map[string][]byte
+
+func (c testSearchCache) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c[key] = value
+	return nil
+}
+
+func (c testSearchCache) FindByPattern(ctx context.Context, pattern string) ([]byte, error) {
+	for _, key := range slices.Sorted(maps.Keys(c)) {
+		if ok, _ := path.Match(pattern, key); ok {
+			return c[key], nil
+		}
+	}
+	return nil, nil
+}
+
+// newQuotaUnsplash returns an Unsplash client of a fake API answering each
+// query with one photo named after it until exhausted is set, then with
+// 403s reporting no quota left
+func newQuotaUnsplash(t *testing.T) (*services.UnsplashService, *atomic.Bool) {
+	t.Helper()
+
+	var exhausted atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Limit", "50")
+		if exhausted.Load() {
+			w.Header().Set("X-Ratelimit-Remaining", "0")
+			http.Error(w, "Rate Limit Exceeded", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Ratelimit-Remaining", "40")
+		query := strings.ReplaceAll(r.URL.Query().Get("query"), ", ", "-")
+		photo := testPhoto(query, ptr(query), nil)
+		json.NewEncoder(w).Encode(services.UnsplashSearchResponse{Total: 1, TotalPages: 1, Results: []services.UnsplashPhoto{photo}})
+	}))
+	t.Cleanup(server.Close)
+
+	svc := services.NewUnsplashService("test-access", "test-secret")
+	svc.SetBaseURL(server.URL)
+	svc.SetSearchCache(testSearchCache{}, time.Hour)
+	return svc, &exhausted
+}
+
+func TestImageProcessorQuotaFallbacks(t *testing.T) {
+	svc, exhausted := newQuotaUnsplash(t)
+	settings := testImageSettings(t)
+	settings.FallbackImages = map[string][]string{
+		"bakery":  {"https://cdn.awning.test/bakery-1.jpg", "https://cdn.awning.test/bakery-2.jpg"},
+		"generic": {"https://cdn.awning.test/generic.jpg"},
+	}
+	p := NewImageProcessor(settings, svc, nil, nil)
+
+	// An earlier search is cached before the quota runs out
+	if _, err := svc.SearchPhotos(context.Background(), "rye bread, sourdough", 1, 1, "", "relevant"); err != nil {
+		t.Fatal(err)
+	}
+	exhausted.Store(true)
+
+	ctx := WithImageMotif(context.Background(), model.BusinessMotifBakery)
+	result, err := p.ProcessWithResult(ctx, readFixture(t, "images/quota.html"))
+	if err != nil {
+		t.Fatalf("ProcessWithResult() error = %v", err)
+	}
+	checkGolden(t, "images/quota.golden.html", result.Output)
+
+	// Every image is pending, whether filled by the similar search or the
+	// motif's fallbacks
+	if got := result.Counts["images_pending"]; got != 4 {
+		t.Errorf("images_pending = %d, want 4", got)
+	}
+	if len(result.Warnings) == 0 || !strings.Contains(result.Warnings[len(result.Warnings)-1], "4 images are pending") {
+		t.Errorf("warnings = %q, want the pending images'", result.Warnings)
+	}
+	if !svc.QuotaExhausted() {
+		t.Error("QuotaExhausted() = false after a 403 with none remaining")
+	}
+
+	// Without the motif, its fallbacks give way to the generic ones
+	result, err = p.ProcessWithResult(context.Background(), readFixture(t, "images/quota.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(result.Output), "bakery-") || !strings.Contains(string(result.Output), "generic.jpg") {
+		t.Errorf("Process() without a motif = %s, want the generic fallbacks", result.Output)
+	}
+
+	// Without any fallbacks the placeholders are kept, but still pending
+	settings.FallbackImages = nil
+	result, err = NewImageProcessor(settings, svc, nil, nil).ProcessWithResult(ctx, readFixture(t, "images/quota.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output := string(result.Output); strings.Count(output, `src="placeholder.jpg"`) != 2 || result.Counts["images_pending"] != 4 {
+		t.Errorf("Process() without fallbacks = %s, counts %v; want the placeholders pending", output, result.Counts)
+	}
+}
+
+func TestImageProcessorFillsPendingImages(t *testing.T) {
+	svc, exhausted := newQuotaUnsplash(t)
+	p := NewImageProcessor(testImageSettings(t), svc, nil, nil)
+
+	page := `<html><head></head><body>` +
+		`<img src="https://cdn.awning.test/bakery-1.jpg" alt="" data-image-keywords="croissant" data-image-pending="quota">` +
+		`<img src="https://images.unsplash.com/photo-kept" alt="Kept" data-image-keywords="cake">` +
+		`</body></html>`
+	got, err := p.Process(services.WithPendingImagesOnly(context.Background()), []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := string(got)
+	if strings.Contains(output, IMAGE_PENDING_ATTR) || !strings.Contains(output, "photo-croissant") {
+		t.Errorf("Process() filling pending images = %s, want the pending image searched for", output)
+	}
+	if !strings.Contains(output, "photo-kept") || strings.Contains(output, "photo-cake") {
+		t.Errorf("Process() filling pending images = %s, want the other image left alone", output)
+	}
+
+	// While the quota is still exhausted they stay pending
+	exhausted.Store(true)
+	svc.SearchPhotos(context.Background(), "anything", 1, 1, "", "relevant")
+	got, err = p.Process(services.WithPendingImagesOnly(context.Background()), []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), IMAGE_PENDING_ATTR) {
+		t.Errorf("Process() with the quota exhausted = %s, want the image still pending", got)
+	}
+}
@@ -0,0 +1,31 @@
+package processors
+
+import (
+	"slices"
+	"strings"
+
+	"awning-backend/common"
+
+	"golang.org/x/net/html"
+)
+
+// imageOrientation picks the Unsplash orientation searched for an img or
+// background node: data-image-orientation when it names a known
+// orientation, then the squarish and landscape class hints, then
+// background_orientation for backgrounds, and the orientation setting
+// otherwise
+func imageOrientation(settings common.ImageProcessorSettings, n *html.Node, isBackground bool) string {
+	if explicit := strings.ToLower(strings.TrimSpace(getAttr(n, "data-image-orientation"))); explicit != "" && slices.Contains(common.KnownImageOrientations, explicit) {
+		return explicit
+	}
+	if len(settings.SquarishClasses) > 0 && hasAnyClassOrPrefix(n, settings.SquarishClasses...) {
+		return "squarish"
+	}
+	if len(settings.LandscapeClasses) > 0 && hasAnyClassOrPrefix(n, settings.LandscapeClasses...) {
+		return "landscape"
+	}
+	if isBackground && settings.BackgroundOrientation != "" {
+		return settings.BackgroundOrientation
+	}
+	return settings.Orientation
+}
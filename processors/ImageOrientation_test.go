@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"awning-backend/services"
+
+	"golang.org/x/net/html"
+)
+
+// newOrientationUnsplash returns an Unsplash client of a fake API answering
+// each query with one photo named after it, and the orientations searched
+// for each query
+func newOrientationUnsplash(t *testing.T) (*services.UnsplashService, func() map[string]string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	searched := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		mu.Lock()
+		searched[query] = r.URL.Query().Get("orientation")
+		mu.Unlock()
+		photo := testPhoto(query, ptr(query), nil)
+		json.NewEncoder(w).Encode(services.UnsplashSearchResponse{Total: 1, TotalPages: 1, Results: []services.UnsplashPhoto{photo}})
+	}))
+	t.Cleanup(server.Close)
+
+	svc := services.NewUnsplashService("test-access", "test-secret")
+	svc.SetBaseURL(server.URL)
+	return svc, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return searched
+	}
+}
+
+func TestImageProcessorOrientationFixture(t *testing.T) {
+	svc, searched := newOrientationUnsplash(t)
+	p := NewImageProcessor(testImageSettings(t), svc, nil, nil)
+
+	ctx, manifest := WithImageManifest(context.Background())
+	if _, err := p.Process(ctx, readFixture(t, "images/orientation.html")); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	want := map[string]string{
+		"hero background": "landscape",
+		"hero banner":     "landscape",
+		"card pastry":     "",
+		"avatar baker":    "squarish",
+		"explicit tall":   "portrait",
+		// Unknown hints fall back to the classes
+		"unknown hint": "squarish",
+	}
+	got := searched()
+	recorded := map[string]string{}
+	for _, image := range manifest.Images() {
+		recorded[image.PhotoID] = image.Orientation
+	}
+	for query, orientation := range want {
+		if o, ok := got[query]; !ok || o != orientation {
+			t.Errorf("%q searched with orientation %q (searched: %v), want %q", query, o, ok, orientation)
+		}
+		if recorded[query] != orientation {
+			t.Errorf("manifest orientation of %q = %q, want %q", query, recorded[query], orientation)
+		}
+	}
+}
+
+func TestImageOrientationSettings(t *testing.T) {
+	img := func(class string) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: "img", Attr: []html.Attribute{{Key: "class", Val: class}}}
+	}
+
+	defaults := testImageSettings(t)
+	tuned := testImageSettings(t)
+	tuned.Orientation = "portrait"
+	tuned.BackgroundOrientation = ""
+	tuned.SquarishClasses = nil
+	tuned.LandscapeClasses = []string{"banner"}
+
+	tests := []struct {
+		name       string
+		class      string
+		background bool
+		defaults   string
+		tuned      string
+	}{
+		{"plain img", "object-cover", false, "", "portrait"},
+		{"plain background", "", true, "landscape", "portrait"},
+		{"avatar", "rounded-full w-16", false, "squarish", "portrait"},
+		{"prefix class", "h-[520px]", false, "landscape", "portrait"},
+		{"tuned landscape class", "banner", false, "", "landscape"},
+		// Squarish classes win over landscape ones
+		{"both", "w-full aspect-square", false, "squarish", "portrait"},
+	}
+	for _, tt := range tests {
+		if got := imageOrientation(defaults, img(tt.class), tt.background); got != tt.defaults {
+			t.Errorf("%s: imageOrientation() with the defaults = %q, want %q", tt.name, got, tt.defaults)
+		}
+		if got := imageOrientation(tuned, img(tt.class), tt.background); got != tt.tuned {
+			t.Errorf("%s: imageOrientation() with tuned settings = %q, want %q", tt.name, got, tt.tuned)
+		}
+	}
+}
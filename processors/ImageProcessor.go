@@ -2,9 +2,13 @@ package processors
 
 import (
 	"awning-backend/common"
+	"awning-backend/model"
 	"awning-backend/services"
+	"awning-backend/utils"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"strings"
 	"sync"
@@ -13,21 +17,72 @@ import (
 )
 
 type ImageProcessor struct {
-	logger *slog.Logger
-	cfg    *common.Config
-	svc    *services.UnsplashService
+	logger   *slog.Logger
+	settings common.ImageProcessorSettings
+	svc      *services.UnsplashService
+	rehoster *services.ImageRehoster
+	uploads  services.UploadedImageSource
 }
 
-func NewImageProcessor(cfg *common.Config, svc *services.UnsplashService) *ImageProcessor {
+// NewImageProcessor creates a new image processor. When rehoster is non-nil,
+// selected images are copied to the image store instead of hotlinked. When
+// uploads is non-nil and settings prefer them, matching tenant uploads are
+// used before stock photos.
+func NewImageProcessor(settings common.ImageProcessorSettings, svc *services.UnsplashService, rehoster *services.ImageRehoster, uploads services.UploadedImageSource) *ImageProcessor {
 	logger := slog.With("processor", "ImageProcessor")
 
 	return &ImageProcessor{
-		logger: logger,
-		cfg:    cfg,
-		svc:    svc,
+		logger:   logger,
+		settings: settings,
+		svc:      svc,
+		rehoster: rehoster,
+		uploads:  uploads,
 	}
 }
 
+// rehostResults replaces the first image URL of each result with a rehosted
+// copy, keeping the original in SourceURL. Failures keep the original URL.
+func (p *ImageProcessor) rehostResults(ctx context.Context, results []*ImageQueryResult) {
+	if p.rehoster == nil {
+		return
+	}
+
+	tenantSchema, _ := services.TenantSchemaFromContext(ctx)
+
+	concurrency := p.settings.RehostConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+
+	for _, result := range results {
+		// Pending images are replaced once the quota recovers
+		if result == nil || result.Uploaded != nil || result.Pending || len(result.ImageURLs) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(result *ImageQueryResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			source := result.ImageURLs[0]
+			rehosted, err := p.rehoster.Rehost(ctx, tenantSchema, source)
+			if err != nil {
+				p.logger.Warn("Failed to rehost image, using original URL", "url", source, "error", err)
+				return
+			}
+
+			result.SourceURL = source
+			result.ImageURLs[0] = rehosted
+		}(result)
+	}
+
+	wg.Wait()
+}
+
 // ProcessImageQuery processes an image query and returns results
 func (p *ImageProcessor) ProcessImageQuery(ctx context.Context, query string, page, perPage int, orientation, orderBy string) (*services.UnsplashSearchResponse, error) {
 	p.logger.Info("Processing image query", "query", query)
@@ -85,10 +140,12 @@ func (p *ImageProcessor) processDivWithBackgroundNode(_ context.Context, queryMa
 	keywords := strings.Join(imgKeywords, ", ")
 
 	query := &ImageQueryRequest{
-		ID:       common.RandomID(),
-		Type:     ImageQueryRequestTypeCssBackground,
-		Node:     n,
-		Keywords: keywords,
+		ID:          common.RandomID(),
+		Type:        ImageQueryRequestTypeCssBackground,
+		Node:        n,
+		Keywords:    keywords,
+		Size:        classifyImageSize(n, true),
+		Orientation: imageOrientation(p.settings, n, true),
 	}
 
 	queryMap[query.ID] = query
@@ -110,10 +167,12 @@ func (p *ImageProcessor) processImgNode(_ context.Context, queryMap map[string]*
 
 	// Search for images using the keywords
 	query := &ImageQueryRequest{
-		ID:       common.RandomID(),
-		Type:     ImageQueryRequestTypeImgSrc,
-		Node:     n,
-		Keywords: keywords,
+		ID:          common.RandomID(),
+		Type:        ImageQueryRequestTypeImgSrc,
+		Node:        n,
+		Keywords:    keywords,
+		Size:        classifyImageSize(n, false),
+		Orientation: imageOrientation(p.settings, n, false),
 	}
 
 	queryMap[query.ID] = query
@@ -220,10 +279,22 @@ func (h *ImageProcessor) Name() string {
 
 var keywordsAttrs = []string{"data-image-keywords", "data-image-background-keywords", "title", "alt"}
 
+// Attributes the prompt asks to keep in English; title and alt follow the
+// page's language, so they're only used when these are missing
+var englishKeywordsAttrs = keywordsAttrs[:2]
+
 func (h *ImageProcessor) getImageKeywords(n *html.Node) []string {
 	keywordSet := make(map[string]struct{})
 
-	for _, attrKey := range keywordsAttrs {
+	attrs := keywordsAttrs
+	for _, attrKey := range englishKeywordsAttrs {
+		if strings.TrimSpace(getAttr(n, attrKey)) != "" {
+			attrs = englishKeywordsAttrs
+			break
+		}
+	}
+
+	for _, attrKey := range attrs {
 		attrVal := getAttr(n, attrKey)
 		if attrVal != "" {
 			parts := strings.Split(attrVal, ",")
@@ -245,6 +316,16 @@ func (h *ImageProcessor) getImageKeywords(n *html.Node) []string {
 }
 
 func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	result, err := h.ProcessWithResult(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// ProcessWithResult replaces image placeholders and reports how many were
+// filled and which keywords found no image
+func (h *ImageProcessor) ProcessWithResult(ctx context.Context, input []byte) (*common.ProcessorResult, error) {
 	h.logger.Info("Processing images")
 
 	sr := bytes.NewReader(input)
@@ -256,12 +337,62 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 		return nil, err
 	}
 
+	result, changed := h.processSubtree(ctx, rootNode, findElement(rootNode, "head"))
+	if !changed {
+		h.logger.Warn("No image queries found, returning original input")
+		result.Output = input
+		return result, nil
+	}
+
+	// Serialize the updated HTML back to bytes
+	var buf bytes.Buffer
+	if err := utils.RenderCanonical(&buf, rootNode); err != nil {
+		h.logger.Error("Failed to render updated HTML", "error", err)
+		return nil, err
+	}
+
+	input = buf.Bytes()
+	h.logger.Info("Image processing complete, returning updated input")
+	// Return the updated input with processed images
+	if len(input) == 0 {
+		h.logger.Warn("Processed input is empty, returning original input")
+		result.Output = input
+		return result, nil
+	}
+
+	h.logger.Info("Returning processed input with images")
+	result.Output = input
+	return result, nil
+}
+
+// ProcessNode replaces the image placeholders of a parsed document
+func (h *ImageProcessor) ProcessNode(ctx context.Context, doc *html.Node) (*common.ProcessorResult, error) {
+	result, _ := h.processSubtree(ctx, doc, findElement(doc, "head"))
+	return result, nil
+}
+
+// ProcessSubtree replaces the image placeholders under node. The style
+// element with background images is appended to head.
+func (h *ImageProcessor) ProcessSubtree(ctx context.Context, node, head *html.Node) (*common.ProcessorResult, error) {
+	result, _ := h.processSubtree(ctx, node, head)
+	return result, nil
+}
+
+// processSubtree fills the image placeholders under rootNode and reports
+// whether it found any. Without a head, background images are skipped.
+func (h *ImageProcessor) processSubtree(ctx context.Context, rootNode, headNode *html.Node) (*common.ProcessorResult, bool) {
+	result := &common.ProcessorResult{}
+
 	var queryMap = make(map[string]*ImageQueryRequest)
 
 	queryReqs := make(chan *ImageQueryRequest)
 	queryResp := make(chan *ImageQueryResult)
 
-	asyncProcessor := NewAsyncImageProcessor(queryReqs, queryResp, h.svc)
+	// The async processor stops once this subtree is done
+	asyncCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	asyncProcessor := NewAsyncImageProcessor(h.settings, queryReqs, queryResp, h.svc)
 
 	// process := func(n *html.Node, keywords string) {
 
@@ -304,8 +435,13 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 
 	// h.processNode(ctx, queryMap, rootNode, "")
 
-	// Filter: img or div/section nodes with data-image-keywords attribute
+	// Filter: img or div/section nodes with data-image-keywords attribute,
+	// only those left pending when filling pending images
+	pendingOnly := services.PendingImagesOnly(ctx)
 	filter := func(n *html.Node) bool {
+		if pendingOnly && getAttr(n, IMAGE_PENDING_ATTR) == "" {
+			return false
+		}
 		if n.Type == html.ElementNode && (n.Data == "img" || n.Data == "div" || n.Data == "section") {
 			imgKeywords := h.getImageKeywords(n)
 			return len(imgKeywords) > 0
@@ -334,15 +470,19 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 	h.walkNodes(rootNode, filter, walker)
 
 	if len(queryMap) == 0 {
-		h.logger.Warn("No image queries found, returning original input")
-		return input, nil
+		return result, false
 	}
 
 	var imgResps = make(map[string]*ImageQueryResult, len(queryMap))
 	var cssResps = make(map[string]*ImageQueryResult, len(queryMap))
 
+	// Tenant uploads take precedence over stock photos
+	pending := h.matchUploadedImages(ctx, queryMap, imgResps, cssResps)
+	result.Count("uploaded_images_used", len(queryMap)-len(pending))
+	result.Count("image_searches", len(pending))
+
 	// Start async processor
-	asyncProcessor.Start(ctx)
+	asyncProcessor.Start(asyncCtx)
 
 	// // Send queries
 	// for _, req := range queryMap {
@@ -350,7 +490,7 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 	// 	queryReqs <- req
 	// }
 
-	remaining := len(queryMap)
+	remaining := len(pending)
 
 	wg := sync.WaitGroup{}
 
@@ -393,7 +533,7 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 	}()
 
 	// Send queries
-	for _, req := range queryMap {
+	for _, req := range pending {
 		h.logger.Info("Sending image query request", "keywords", req.Keywords)
 		queryReqs <- req
 	}
@@ -404,6 +544,30 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 
 	if remaining > 0 {
 		h.logger.Warn("Some image queries did not return results", "remaining", remaining)
+		result.Warn(fmt.Sprintf("%d image searches did not return", remaining))
+	}
+
+	// Copy the selected images to our own storage
+	selected := make([]*ImageQueryResult, 0, len(imgResps)+len(cssResps))
+	for _, resp := range imgResps {
+		selected = append(selected, resp)
+	}
+	for _, resp := range cssResps {
+		selected = append(selected, resp)
+	}
+	h.rehostResults(ctx, selected)
+
+	manifest := imageManifestFromContext(ctx)
+	recordImage := func(req *ImageQueryRequest, resp *ImageQueryResult, alt string) {
+		image := model.ChatImage{NodeID: req.ID, Alt: alt, Credit: photoCredit(resp.Photo), Orientation: req.Orientation}
+		if resp.Photo != nil {
+			image.PhotoID = resp.Photo.ID
+		}
+		if resp.Uploaded != nil {
+			image.UploadID = resp.Uploaded.ID
+		}
+		setAttr(req.Node, "data-image-id", req.ID)
+		manifest.Add(image)
 	}
 
 	// Update the corresponding img node with the first image URL
@@ -418,7 +582,13 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 
 		// Update the corresponding img node with the first image URL
 		if resp == nil || len(resp.ImageURLs) == 0 {
+			if req, exists := queryMap[resp.RequestID]; exists && resp.Pending {
+				markImagePending(req.Node, true, result)
+				continue
+			}
 			h.logger.Warn("No images found for request", "request_id", resp.RequestID, "keywords", resp.Keywords)
+			result.Count("images_unmatched", 1)
+			result.Warn("No image found for: " + resp.Keywords)
 			continue
 		}
 
@@ -438,27 +608,29 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 				break
 			}
 		}
-	}
 
-	// Find the head node
-	var headNode *html.Node
-	var findHead func(*html.Node)
-	findHead = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "head" {
-			headNode = n
-			return
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			findHead(c)
-			if headNode != nil {
-				return
+		alt := resultAltText(resp)
+		setAttr(req.Node, "alt", alt)
+		recordImage(req, resp, alt)
+		markImagePending(req.Node, resp.Pending, result)
+		result.Count("images_replaced", 1)
+
+		if resp.SourceURL != "" {
+			setAttr(req.Node, "data-image-source", resp.SourceURL)
+		} else if len(resp.RawURLs) > 0 && resp.RawURLs[0] != "" {
+			// Responsive sizes via Unsplash dynamic resizing (not available for rehosted copies)
+			if widths := widthsFor(h.settings, req.Size); len(widths) > 0 {
+				setAttr(req.Node, "srcset", buildSrcset(resp.RawURLs[0], widths))
+				setAttr(req.Node, "sizes", sizesFor(req.Size))
 			}
 		}
 	}
-	findHead(rootNode)
 
 	if headNode == nil {
 		h.logger.Warn("No head node found in HTML, cannot insert CSS styles")
+		if len(cssResps) > 0 {
+			result.Warn("No <head> element, background images were not applied")
+		}
 	} else {
 		// Create style element
 		styleNode := &html.Node{
@@ -471,7 +643,13 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 		for _, resp := range cssResps {
 			h.logger.Info("Adding CSS background image for keywords", "keywords", resp.Keywords, "image_count", len(resp.ImageURLs))
 			if resp == nil || len(resp.ImageURLs) == 0 {
+				if req, exists := queryMap[resp.RequestID]; exists && resp.Pending {
+					markImagePending(req.Node, true, result)
+					continue
+				}
 				h.logger.Warn("No images found for CSS background request", "request_id", resp.RequestID, "keywords", resp.Keywords)
+				result.Count("backgrounds_unmatched", 1)
+				result.Warn("No background image found for: " + resp.Keywords)
 				continue
 			}
 
@@ -484,22 +662,36 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 			imageURL := resp.ImageURLs[0]
 			h.logger.Info("Adding CSS rule", "keywords", resp.Keywords, "image_url", imageURL)
 
-			// Create a unique class name
+			// Create a unique class name, added to the node's own classes
 			className := "img-bg-" + resp.RequestID
 
-			existingClass := getAttr(req.Node, "class")
-			if existingClass != "" {
-				className = existingClass + " " + className
+			if existingClass := getAttr(req.Node, "class"); existingClass != "" {
+				setAttr(req.Node, "class", existingClass+" "+className)
+			} else {
+				setAttr(req.Node, "class", className)
 			}
 
-			// Add class to the node
-			setAttr(req.Node, "class", className)
-
 			setAttr(req.Node, "data-image-src", imageURL)
 
+			// Backgrounds are decorative; the alt text is kept as data for the editor
+			alt := resultAltText(resp)
+			setAttr(req.Node, "data-image-role", "presentation")
+			setAttr(req.Node, "data-image-alt", alt)
+			recordImage(req, resp, alt)
+			markImagePending(req.Node, resp.Pending, result)
+			result.Count("backgrounds_replaced", 1)
+
+			if resp.SourceURL != "" {
+				setAttr(req.Node, "data-image-source", resp.SourceURL)
+			}
+
 			// Add CSS rule to style content
 			styleContent.WriteString("." + className + " {\n")
-			styleContent.WriteString("  background-image: url('" + imageURL + "');\n")
+			rawURL := ""
+			if resp.SourceURL == "" && len(resp.RawURLs) > 0 {
+				rawURL = resp.RawURLs[0]
+			}
+			styleContent.WriteString(backgroundImageCSS(imageURL, rawURL, widthsFor(h.settings, req.Size)))
 			styleContent.WriteString("  background-size: cover;\n")
 			styleContent.WriteString("  background-position: center;\n")
 			styleContent.WriteString("}\n")
@@ -512,26 +704,16 @@ func (h *ImageProcessor) Process(ctx context.Context, input []byte) ([]byte, err
 		})
 
 		// Append the style node to the head
-		headNode.AppendChild(styleNode)
-	}
-
-	// Serialize the updated HTML back to bytes
-	var buf bytes.Buffer
-	if err := html.Render(&buf, rootNode); err != nil {
-		h.logger.Error("Failed to render updated HTML", "error", err)
-		return nil, err
+		if len(cssResps) > 0 {
+			headNode.AppendChild(styleNode)
+		}
 	}
 
-	input = buf.Bytes()
-	h.logger.Info("Image processing complete, returning updated input")
-	// Return the updated input with processed images
-	if len(input) == 0 {
-		h.logger.Warn("Processed input is empty, returning original input")
-		return input, nil
+	if pending := result.Counts["images_pending"]; pending > 0 {
+		result.Warn(fmt.Sprintf("Unsplash quota exhausted, %d images are pending until it recovers", pending))
 	}
 
-	h.logger.Info("Returning processed input with images")
-	return input, nil
+	return result, true
 }
 
 type ImageQueryRequestType string
@@ -542,26 +724,35 @@ const (
 )
 
 type ImageQueryRequest struct {
-	ID       string
-	Type     ImageQueryRequestType
-	Node     *html.Node
-	Keywords string
+	ID          string
+	Type        ImageQueryRequestType
+	Node        *html.Node
+	Keywords    string
+	Size        ImageSize
+	Orientation string // Unsplash orientation filter, empty for any
 }
 
 type ImageQueryResult struct {
 	RequestID string
 	Keywords  string
 	ImageURLs []string
+	RawURLs   []string                // Unsplash raw URLs matching ImageURLs, for srcset generation
+	Photo     *services.UnsplashPhoto // Photo behind ImageURLs[0]
+	SourceURL string                  // Original URL when ImageURLs[0] has been rehosted
+	Uploaded  *services.UploadedImage // Tenant upload behind ImageURLs[0], instead of Photo
+	Pending   bool                    // Stands in for a search refused while the Unsplash quota is exhausted
 }
 
 type AsyncImageProcessor struct {
 	logger    *slog.Logger
+	settings  common.ImageProcessorSettings
 	queryReqs chan *ImageQueryRequest
 	queryResp chan *ImageQueryResult
 	svc       *services.UnsplashService
 }
 
 func NewAsyncImageProcessor(
+	settings common.ImageProcessorSettings,
 	queryReqs chan *ImageQueryRequest,
 	queryResp chan *ImageQueryResult,
 	svc *services.UnsplashService,
@@ -570,6 +761,7 @@ func NewAsyncImageProcessor(
 
 	return &AsyncImageProcessor{
 		logger:    logger,
+		settings:  settings,
 		queryReqs: queryReqs,
 		queryResp: queryResp,
 		svc:       svc,
@@ -583,24 +775,36 @@ func (p *AsyncImageProcessor) Start(bgCtx context.Context) {
 		for {
 			select {
 			case req := <-p.queryReqs:
-				p.logger.Info("Received image query request", "keywords", req.Keywords)
-
-				// Process the image query
-				results, err := p.svc.SearchPhotos(bgCtx, req.Keywords, 1, 5, "", "relevant")
+				p.logger.Info("Received image query request", "keywords", req.Keywords, "orientation", req.Orientation)
+
+				// Process the image query. Failed searches are still answered,
+				// so the collector isn't left waiting for them.
+				results, err := p.svc.SearchPhotos(bgCtx, req.Keywords, 1, p.settings.PerQuery, req.Orientation, "relevant")
+				if errors.Is(err, services.ErrQuotaExhausted) {
+					p.logger.Warn("Unsplash quota exhausted, using a fallback image", "keywords", req.Keywords)
+					p.queryResp <- fallbackImageResult(bgCtx, p.settings, p.svc, req)
+					continue
+				}
 				if err != nil {
 					p.logger.Error("Failed to search photos", "error", err)
+					p.queryResp <- &ImageQueryResult{RequestID: req.ID, Keywords: req.Keywords}
 					continue
 				}
 
-				var imageURLs []string
+				var imageURLs, rawURLs []string
 				for _, photo := range results.Results {
-					imageURLs = append(imageURLs, photo.URLs.Regular)
+					imageURLs = append(imageURLs, selectPhotoURL(p.settings, photo, req.Size))
+					rawURLs = append(rawURLs, photo.URLs.Raw)
 				}
 
 				resp := &ImageQueryResult{
 					RequestID: req.ID,
 					Keywords:  req.Keywords,
 					ImageURLs: imageURLs,
+					RawURLs:   rawURLs,
+				}
+				if len(results.Results) > 0 {
+					resp.Photo = &results.Results[0]
 				}
 
 				// Send the response
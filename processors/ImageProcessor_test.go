@@ -0,0 +1,111 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/services"
+)
+
+// newSectionPipeline returns the image and cleanup processors of a page,
+// registered the way the server does
+func newSectionPipeline(t *testing.T, concurrency int) *services.Processors {
+	t.Helper()
+
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"images", "cleanup"}
+	cfg.SectionConcurrency = concurrency
+
+	photos := map[string][]services.UnsplashPhoto{
+		"bakery logo": {testPhoto("logo", ptr("A loaf"), nil)},
+	}
+	for query, results := range sizeFixturePhotos {
+		photos[query] = results
+	}
+
+	cleanup, err := cfg.CleanupProcessorSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup.StripEmptyParagraphs = true
+
+	p := services.NewProcessors(cfg)
+	p.RegisterProcessor("images", NewImageProcessor(testImageSettings(t), newTestUnsplash(t, photos), nil, nil))
+	p.RegisterProcessor("cleanup", NewCleanupProcessor(cleanup))
+	return p
+}
+
+func TestRunIncrementalMatchesRun(t *testing.T) {
+	page := string(readFixture(t, "sections/page.html"))
+
+	for _, concurrency := range []int{1, 4} {
+		p := newSectionPipeline(t, concurrency)
+		ctx := context.Background()
+
+		whole, wholeReports := p.Run(ctx, page, nil)
+
+		var mu sync.Mutex
+		var sections []services.ProcessedSection
+		assembled, reports := p.RunIncremental(ctx, page, nil, func(section services.ProcessedSection) {
+			mu.Lock()
+			defer mu.Unlock()
+			sections = append(sections, section)
+		})
+
+		if got, want := numberRandomIDs([]byte(assembled)), numberRandomIDs([]byte(whole)); string(got) != string(want) {
+			t.Errorf("concurrency %d: RunIncremental() differs from Run():\ngot:\n%s\nwant:\n%s", concurrency, got, want)
+		}
+
+		// Every section is emitted once, already processed
+		if len(sections) != 4 {
+			t.Fatalf("concurrency %d: onSection called %d times, want 4", concurrency, len(sections))
+		}
+		seen := map[int]bool{}
+		for _, section := range sections {
+			if section.Total != 4 || seen[section.Index] {
+				t.Errorf("concurrency %d: section %d of %d emitted again or with the wrong total", concurrency, section.Index, section.Total)
+			}
+			seen[section.Index] = true
+			if !strings.HasPrefix(section.HTML, "<section") || strings.Contains(section.HTML, "placeholder.jpg") {
+				t.Errorf("concurrency %d: section %d = %s, want the processed section", concurrency, section.Index, section.HTML)
+			}
+			if !strings.Contains(assembled, section.HTML) {
+				t.Errorf("concurrency %d: section %d is not in the assembled page as emitted", concurrency, section.Index)
+			}
+		}
+
+		// Reports are merged across the sections and the rest of the page
+		if len(reports) != len(wholeReports) {
+			t.Fatalf("concurrency %d: RunIncremental() reports = %+v, want one per processor like %+v", concurrency, reports, wholeReports)
+		}
+		for i, want := range wholeReports {
+			got := reports[i]
+			if got.Name != want.Name || got.Success != want.Success {
+				t.Errorf("concurrency %d: report %d = %s (success %v), want %s (success %v)", concurrency, i, got.Name, got.Success, want.Name, want.Success)
+			}
+			for key, n := range want.Counts {
+				if got.Counts[key] != n {
+					t.Errorf("concurrency %d: %s counts[%s] = %d, want %d", concurrency, got.Name, key, got.Counts[key], n)
+				}
+			}
+		}
+	}
+}
+
+func TestRunIncrementalWithoutSections(t *testing.T) {
+	p := newSectionPipeline(t, 2)
+	page := string(readFixture(t, "images/card.html"))
+
+	called := false
+	got, _ := p.RunIncremental(context.Background(), page, nil, func(services.ProcessedSection) { called = true })
+	want, _ := p.Run(context.Background(), page, nil)
+	if string(numberRandomIDs([]byte(got))) != string(numberRandomIDs([]byte(want))) {
+		t.Errorf("RunIncremental() of a page without sections = %s, want Run()'s %s", got, want)
+	}
+	if called {
+		t.Error("onSection called for a page without sections")
+	}
+}
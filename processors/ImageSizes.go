@@ -0,0 +1,130 @@
+package processors
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/services"
+
+	"golang.org/x/net/html"
+)
+
+type ImageSize string
+
+const (
+	ImageSizeHero    ImageSize = "hero"
+	ImageSizeCard    ImageSize = "card"
+	ImageSizeDefault ImageSize = "default"
+)
+
+// Classes marking small, fixed-size images (cards, avatars, thumbnails).
+// Checked before the hero classes since card images are often also w-full.
+var cardImageClasses = []string{
+	"w-8", "w-10", "w-12", "w-16", "w-20", "w-24", "w-32", "w-40", "w-48",
+	"h-8", "h-10", "h-12", "h-16", "h-20", "h-24", "h-32", "h-40", "h-48", "h-56", "h-64",
+	"rounded-full", "max-w-xs", "aspect-square",
+}
+
+// Classes marking images that span the viewport
+var heroImageClasses = []string{
+	"w-full", "w-screen", "h-screen", "min-h-screen", "h-96", "h-[", "min-h-[", "aspect-video",
+}
+
+// classifyImageSize picks the size class for an img or background node
+func classifyImageSize(n *html.Node, isBackground bool) ImageSize {
+	if hasAnyClassOrPrefix(n, cardImageClasses...) {
+		return ImageSizeCard
+	}
+	if isBackground || hasAnyClassOrPrefix(n, heroImageClasses...) {
+		return ImageSizeHero
+	}
+	return ImageSizeDefault
+}
+
+// widthsFor returns the configured srcset widths for a size class
+func widthsFor(settings common.ImageProcessorSettings, size ImageSize) []int {
+	switch size {
+	case ImageSizeHero:
+		return settings.HeroWidths
+	case ImageSizeCard:
+		return settings.CardWidths
+	default:
+		return settings.DefaultWidths
+	}
+}
+
+// sizesFor returns the sizes attribute heuristic for a size class
+func sizesFor(size ImageSize) string {
+	switch size {
+	case ImageSizeHero:
+		return "100vw"
+	case ImageSizeCard:
+		return "(min-width: 1024px) 25vw, (min-width: 768px) 33vw, 100vw"
+	default:
+		return "(min-width: 1024px) 50vw, 100vw"
+	}
+}
+
+// selectPhotoURL picks the Unsplash URL used as src for the size class
+func selectPhotoURL(settings common.ImageProcessorSettings, photo services.UnsplashPhoto, size ImageSize) string {
+	switch size {
+	case ImageSizeHero:
+		widths := widthsFor(settings, size)
+		if photo.URLs.Raw != "" && len(widths) > 0 {
+			return unsplashSizedURL(photo.URLs.Raw, widths[len(widths)/2])
+		}
+		return photo.URLs.Full
+	case ImageSizeCard:
+		return photo.URLs.Small
+	default:
+		return photo.URLs.Regular
+	}
+}
+
+// unsplashSizedURL sets Unsplash dynamic resizing parameters on a raw URL
+func unsplashSizedURL(rawURL string, width int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	q := u.Query()
+	q.Set("w", strconv.Itoa(width))
+	q.Set("q", "80")
+	q.Set("auto", "format")
+	q.Set("fit", "max")
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// buildSrcset returns a srcset value with one entry per width
+func buildSrcset(rawURL string, widths []int) string {
+	entries := make([]string, 0, len(widths))
+	for _, w := range widths {
+		entries = append(entries, fmt.Sprintf("%s %dw", unsplashSizedURL(rawURL, w), w))
+	}
+	return strings.Join(entries, ", ")
+}
+
+// backgroundImageCSS returns background-image declarations with a plain url()
+// fallback followed by an image-set() for high density displays
+func backgroundImageCSS(fallbackURL, rawURL string, widths []int) string {
+	css := "  background-image: url('" + fallbackURL + "');\n"
+	if rawURL == "" || len(widths) < 2 {
+		return css
+	}
+
+	base := widths[len(widths)/2]
+	if len(widths) > 2 {
+		base = widths[len(widths)-2]
+	}
+	dense := widths[len(widths)-1]
+
+	css += fmt.Sprintf("  background-image: image-set(url('%s') 1x, url('%s') 2x);\n",
+		unsplashSizedURL(rawURL, base), unsplashSizedURL(rawURL, dense))
+	return css
+}
@@ -0,0 +1,106 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"awning-backend/services"
+
+	"golang.org/x/net/html"
+)
+
+// sizeFixturePhotos answers the keywords of the size fixtures
+var sizeFixturePhotos = map[string][]services.UnsplashPhoto{
+	"mountain lake":   {testPhoto("lake", ptr("A lake below mountains"), nil)},
+	"bakery interior": {testPhoto("bakery", ptr("Bread on shelves"), nil)},
+	"baker portrait":  {testPhoto("baker", ptr("A smiling baker"), nil)},
+	"croissant":       {testPhoto("croissant", ptr("Croissants on a tray"), nil)},
+}
+
+func TestImageProcessorSizeFixtures(t *testing.T) {
+	p := NewImageProcessor(testImageSettings(t), newTestUnsplash(t, sizeFixturePhotos), nil, nil)
+
+	for _, name := range []string{"hero", "card", "default"} {
+		t.Run(name, func(t *testing.T) {
+			got, err := p.Process(context.Background(), readFixture(t, "images/"+name+".html"))
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			checkGolden(t, "images/"+name+".golden.html", got)
+		})
+	}
+}
+
+func TestImageProcessorSizeAttributes(t *testing.T) {
+	p := NewImageProcessor(testImageSettings(t), newTestUnsplash(t, sizeFixturePhotos), nil, nil)
+	raw := func(id string) string { return "https://images.unsplash.com/photo-" + id + "?" }
+
+	tests := []struct {
+		fixture string
+		want    []string
+		notWant []string
+	}{
+		{"hero", []string{
+			// The middle hero width for the src, and all of them in srcset
+			`src="` + raw("bakery") + `auto=format&amp;fit=max&amp;ixid=test&amp;q=80&amp;w=1920"`,
+			`sizes="100vw"`,
+			" 1280w, ", " 1920w, ", " 2560w\"",
+			// The background's rule selects its own class alone, with a plain
+			// url() before the image-set()
+			"<style>.img-bg-",
+			"background-image: url('" + raw("lake") + "auto=format&fit=max&ixid=test&q=80&w=1920');",
+			"background-image: image-set(url('" + raw("lake") + "auto=format&fit=max&ixid=test&q=80&w=1920') 1x, url('" + raw("lake") + "auto=format&fit=max&ixid=test&q=80&w=2560') 2x);",
+		}, nil},
+		{"card", []string{
+			`src="` + raw("baker") + `ixid=test&amp;w=400"`,
+			`sizes="(min-width: 1024px) 25vw, (min-width: 768px) 33vw, 100vw"`,
+			" 320w, ", " 480w, ", " 640w\"",
+		}, []string{"1920w"}},
+		{"default", []string{
+			`src="` + raw("croissant") + `ixid=test&amp;w=1080"`,
+			`sizes="(min-width: 1024px) 50vw, 100vw"`,
+			" 640w, ", " 1080w, ", " 1600w\"",
+		}, []string{"<style>"}},
+	}
+	for _, tt := range tests {
+		got, err := p.Process(context.Background(), readFixture(t, "images/"+tt.fixture+".html"))
+		if err != nil {
+			t.Fatalf("%s: Process() error = %v", tt.fixture, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("%s: output lacks %s:\n%s", tt.fixture, want, got)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(string(got), notWant) {
+				t.Errorf("%s: output has %s:\n%s", tt.fixture, notWant, got)
+			}
+		}
+	}
+}
+
+func TestClassifyImageSize(t *testing.T) {
+	tests := []struct {
+		class      string
+		background bool
+		want       ImageSize
+	}{
+		{"", false, ImageSizeDefault},
+		{"object-cover", false, ImageSizeDefault},
+		{"w-full", false, ImageSizeHero},
+		{"h-[480px]", false, ImageSizeHero},
+		{"", true, ImageSizeHero},
+		{"w-16 h-16", false, ImageSizeCard},
+		// Card classes win over hero ones
+		{"w-full aspect-square", false, ImageSizeCard},
+		{"rounded-full", true, ImageSizeCard},
+	}
+	for _, tt := range tests {
+		n := &html.Node{Type: html.ElementNode, Data: "img", Attr: []html.Attribute{{Key: "class", Val: tt.class}}}
+		if got := classifyImageSize(n, tt.background); got != tt.want {
+			t.Errorf("classifyImageSize(%q, %v) = %s, want %s", tt.class, tt.background, got, tt.want)
+		}
+	}
+}
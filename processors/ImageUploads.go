@@ -0,0 +1,115 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"awning-backend/services"
+)
+
+// Fraction of a query's keywords an uploaded image must share to be used
+// instead of a stock photo
+const UPLOADED_IMAGE_MIN_SCORE = 0.5
+
+// keywordTokens splits keywords into lowercase words
+func keywordTokens(keywords ...string) map[string]struct{} {
+	tokens := make(map[string]struct{})
+	for _, k := range keywords {
+		for _, word := range strings.FieldsFunc(strings.ToLower(k), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		}) {
+			tokens[word] = struct{}{}
+		}
+	}
+	return tokens
+}
+
+// matchUploadedImage returns the uploaded image sharing the most keywords
+// with the query, or nil when none reaches UPLOADED_IMAGE_MIN_SCORE. Ties go
+// to the image used least so far, then to the earlier (newer) image.
+func matchUploadedImage(images []services.UploadedImage, keywords string, used map[uint]int) *services.UploadedImage {
+	query := keywordTokens(keywords)
+	if len(query) == 0 {
+		return nil
+	}
+
+	var best *services.UploadedImage
+	bestScore := 0.0
+	for i := range images {
+		overlap := 0
+		for token := range keywordTokens(images[i].Keywords...) {
+			if _, ok := query[token]; ok {
+				overlap++
+			}
+		}
+
+		score := float64(overlap) / float64(len(query))
+		if score < UPLOADED_IMAGE_MIN_SCORE {
+			continue
+		}
+		if best == nil || score > bestScore || (score == bestScore && used[images[i].ID] < used[best.ID]) {
+			best = &images[i]
+			bestScore = score
+		}
+	}
+
+	return best
+}
+
+// matchUploadedImages answers queries from the tenant's uploaded images and
+// returns the queries that still need a stock photo search
+func (h *ImageProcessor) matchUploadedImages(ctx context.Context, queryMap map[string]*ImageQueryRequest, imgResps, cssResps map[string]*ImageQueryResult) []*ImageQueryRequest {
+	pending := make([]*ImageQueryRequest, 0, len(queryMap))
+	for _, req := range queryMap {
+		pending = append(pending, req)
+	}
+
+	tenantSchema, ok := services.TenantSchemaFromContext(ctx)
+	if h.uploads == nil || !h.settings.PreferTenantImages || !ok {
+		return pending
+	}
+
+	images, err := h.uploads.UploadedImages(ctx, tenantSchema)
+	if err != nil {
+		h.logger.Warn("Failed to load uploaded images, using stock photos", "error", err)
+		return pending
+	}
+	if len(images) == 0 {
+		return pending
+	}
+
+	used := make(map[uint]int)
+	remaining := pending[:0]
+	for _, req := range pending {
+		image := matchUploadedImage(images, req.Keywords, used)
+		if image == nil {
+			remaining = append(remaining, req)
+			continue
+		}
+		used[image.ID]++
+
+		h.logger.Info("Using uploaded image", "keywords", req.Keywords, "image_id", image.ID)
+		resp := &ImageQueryResult{
+			RequestID: req.ID,
+			Keywords:  req.Keywords,
+			ImageURLs: []string{image.URL},
+			Uploaded:  image,
+		}
+		if req.Type == ImageQueryRequestTypeCssBackground {
+			cssResps[req.ID] = resp
+		} else {
+			imgResps[req.ID] = resp
+		}
+	}
+
+	return remaining
+}
+
+// resultAltText prefers the alt text the tenant gave an uploaded image
+func resultAltText(resp *ImageQueryResult) string {
+	if resp.Uploaded != nil && strings.TrimSpace(resp.Uploaded.Alt) != "" {
+		return imageAltText(nil, resp.Uploaded.Alt)
+	}
+	return imageAltText(resp.Photo, resp.Keywords)
+}
@@ -0,0 +1,245 @@
+package processors
+
+import (
+	"awning-backend/common"
+	"awning-backend/utils"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// PlaceholderWarningAttr lists the placeholders left on an element, for
+	// the editor to highlight
+	PlaceholderWarningAttr = "data-placeholder-warning"
+
+	// Occurrences listed in the report's warnings; the rest are counted
+	maxPlaceholderWarnings = 20
+)
+
+// PlaceholderValues are the real values placeholders are replaced with,
+// from the chat's onboarding data and the tenant profile. Empty values
+// leave their placeholders flagged.
+type PlaceholderValues struct {
+	BusinessName string
+	Phone        string
+	Email        string
+	Address      string
+}
+
+// field returns the value for a placeholder pattern's field
+func (v PlaceholderValues) field(name string) string {
+	switch name {
+	case "business_name":
+		return v.BusinessName
+	case "phone":
+		return v.Phone
+	case "email":
+		return v.Email
+	case "address":
+		return v.Address
+	}
+	return ""
+}
+
+// contains reports whether match is part of a real value, as for a business
+// actually named "Lorem Ipsum Bakery"
+func (v PlaceholderValues) contains(match string) bool {
+	match = strings.ToLower(match)
+	for _, value := range []string{v.BusinessName, v.Phone, v.Email, v.Address} {
+		if value != "" && strings.Contains(strings.ToLower(value), match) {
+			return true
+		}
+	}
+	return false
+}
+
+type placeholderValuesCtxKey struct{}
+
+// WithPlaceholderValues returns a context carrying the values the
+// placeholder processor replaces placeholders with
+func WithPlaceholderValues(ctx context.Context, values PlaceholderValues) context.Context {
+	return context.WithValue(ctx, placeholderValuesCtxKey{}, values)
+}
+
+func placeholderValuesFromContext(ctx context.Context) PlaceholderValues {
+	values, _ := ctx.Value(placeholderValuesCtxKey{}).(PlaceholderValues)
+	return values
+}
+
+type compiledPlaceholder struct {
+	common.PlaceholderPattern
+	re *regexp.Regexp
+}
+
+// PlaceholderProcessor replaces stock text the model left in, such as
+// "Your Business Name" or "(555) 555-5555", with the tenant's real values,
+// and marks what it can't replace with data-placeholder-warning
+type PlaceholderProcessor struct {
+	logger     *slog.Logger
+	patterns   []compiledPlaceholder
+	attributes []string
+}
+
+// NewPlaceholderProcessor creates a placeholder processor. Patterns were
+// checked by cfg.Validate; any that don't compile are left out.
+func NewPlaceholderProcessor(settings common.PlaceholderProcessorSettings) *PlaceholderProcessor {
+	logger := slog.With("processor", "PlaceholderProcessor")
+
+	var patterns []compiledPlaceholder
+	for _, pattern := range settings.Patterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			logger.Error("Invalid placeholder pattern", "name", pattern.Name, "error", err)
+			continue
+		}
+		patterns = append(patterns, compiledPlaceholder{PlaceholderPattern: pattern, re: re})
+	}
+
+	return &PlaceholderProcessor{
+		logger:     logger,
+		patterns:   patterns,
+		attributes: settings.Attributes,
+	}
+}
+
+func (p *PlaceholderProcessor) Name() string {
+	return "PlaceholderProcessor"
+}
+
+// Process replaces and flags placeholders
+func (p *PlaceholderProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	result, err := p.ProcessWithResult(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// ProcessWithResult replaces and flags placeholders, reporting each one
+// left in the page
+func (p *PlaceholderProcessor) ProcessWithResult(ctx context.Context, input []byte) (*common.ProcessorResult, error) {
+	rootNode, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		p.logger.Error("Failed to parse HTML", "error", err)
+		return nil, err
+	}
+
+	result, _ := p.ProcessSubtree(ctx, rootNode, nil)
+
+	var outputBuf bytes.Buffer
+	if err := utils.RenderCanonical(&outputBuf, rootNode); err != nil {
+		p.logger.Error("Failed to render HTML", "error", err)
+		return nil, err
+	}
+
+	result.Output = outputBuf.Bytes()
+	return result, nil
+}
+
+// placeholderScan collects what one pass over a document found
+type placeholderScan struct {
+	values   PlaceholderValues
+	replaced int
+	flagged  []string
+}
+
+// ProcessNode replaces and flags placeholders in a parsed document
+func (p *PlaceholderProcessor) ProcessNode(ctx context.Context, doc *html.Node) (*common.ProcessorResult, error) {
+	return p.ProcessSubtree(ctx, doc, nil)
+}
+
+// ProcessSubtree replaces and flags placeholders in part of a document
+func (p *PlaceholderProcessor) ProcessSubtree(ctx context.Context, node, _ *html.Node) (*common.ProcessorResult, error) {
+	scan := &placeholderScan{values: placeholderValuesFromContext(ctx)}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.ElementNode:
+			if n.Data == "script" || n.Data == "style" {
+				return
+			}
+			var names []string
+			for i, attr := range n.Attr {
+				if !slices.Contains(p.attributes, attr.Key) {
+					continue
+				}
+				var found []string
+				n.Attr[i].Val, found = p.replace(scan, attr.Val, n.Data)
+				names = append(names, found...)
+			}
+			annotatePlaceholders(n, names)
+		case html.TextNode:
+			var found []string
+			n.Data, found = p.replace(scan, n.Data, parentTag(n))
+			if n.Parent != nil && n.Parent.Type == html.ElementNode {
+				annotatePlaceholders(n.Parent, found)
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	result := &common.ProcessorResult{}
+	result.Count("replaced", scan.replaced)
+	result.Count("flagged", len(scan.flagged))
+	for i, warning := range scan.flagged {
+		if i == maxPlaceholderWarnings {
+			result.Warn(fmt.Sprintf("%d more placeholders", len(scan.flagged)-i))
+			break
+		}
+		result.Warn(warning)
+	}
+	return result, nil
+}
+
+// replace applies the patterns to text in tag, replacing placeholders with
+// known values. It returns the new text and the names of the patterns whose
+// matches were left in.
+func (p *PlaceholderProcessor) replace(scan *placeholderScan, text, tag string) (string, []string) {
+	var names []string
+	for _, pattern := range p.patterns {
+		text = pattern.re.ReplaceAllStringFunc(text, func(match string) string {
+			if scan.values.contains(match) {
+				return match
+			}
+			if value := scan.values.field(pattern.Field); value != "" {
+				scan.replaced++
+				return value
+			}
+			names = append(names, pattern.Name)
+			scan.flagged = append(scan.flagged, fmt.Sprintf("%s placeholder %q in <%s>", pattern.Name, match, tag))
+			return match
+		})
+	}
+	return text, names
+}
+
+// annotatePlaceholders adds names to the element's data-placeholder-warning
+func annotatePlaceholders(n *html.Node, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	if existing := getAttr(n, PlaceholderWarningAttr); existing != "" {
+		names = append(names, strings.Split(existing, ",")...)
+	}
+	slices.Sort(names)
+	setAttr(n, PlaceholderWarningAttr, strings.Join(slices.Compact(names), ","))
+}
+
+// parentTag returns the tag name of n's parent element, for reports
+func parentTag(n *html.Node) string {
+	if n.Parent != nil && n.Parent.Type == html.ElementNode {
+		return n.Parent.Data
+	}
+	return "#text"
+}
@@ -0,0 +1,132 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+)
+
+// newTestPlaceholderProcessor returns a placeholder processor with the
+// default settings
+func newTestPlaceholderProcessor(t *testing.T) *PlaceholderProcessor {
+	t.Helper()
+
+	settings, err := common.DefaultConfig().PlaceholderProcessorSettings()
+	if err != nil {
+		t.Fatalf("PlaceholderProcessorSettings() error = %v", err)
+	}
+	return NewPlaceholderProcessor(settings)
+}
+
+var testPlaceholderValues = PlaceholderValues{
+	BusinessName: "Crumb & Co",
+	Phone:        "(415) 555-0142",
+	Email:        "hello@crumb.co",
+	Address:      "18 Valencia St, San Francisco, CA 94103",
+}
+
+func TestPlaceholderFixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		values   PlaceholderValues
+		replaced int
+		flagged  int
+		warnings []string
+	}{
+		// Every mapped placeholder is replaced, in text and attributes
+		{"replace", testPlaceholderValues, 11, 0, nil},
+		// Without values they are only flagged
+		{"annotate", PlaceholderValues{}, 0, 10, []string{
+			`lorem_ipsum placeholder "Lorem ipsum" in <p>`,
+			`template placeholder "{{city}}" in <p>`,
+			`city placeholder "Anytown" in <p>`,
+			`template placeholder "[Insert team photo]" in <img>`,
+			`phone placeholder "(555) 555-1234" in <p>`,
+			`email placeholder "team@example.com" in <a>`,
+			`address placeholder "123 Elm St." in <p>`,
+		}},
+		// A business really named Lorem Ipsum, and lookalikes of the patterns
+		{"no-false-positives", PlaceholderValues{BusinessName: "Lorem Ipsum Bakery", Phone: "(555) 555-0100"}, 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			ctx := WithPlaceholderValues(context.Background(), tt.values)
+			result, err := newTestPlaceholderProcessor(t).ProcessWithResult(ctx, readFixture(t, "placeholders/"+tt.fixture+".html"))
+			if err != nil {
+				t.Fatalf("ProcessWithResult() error = %v", err)
+			}
+			checkGolden(t, "placeholders/"+tt.fixture+".golden.html", result.Output)
+
+			if result.Counts["replaced"] != tt.replaced || result.Counts["flagged"] != tt.flagged {
+				t.Errorf("counts = %v, want %d replaced and %d flagged", result.Counts, tt.replaced, tt.flagged)
+			}
+			for _, want := range tt.warnings {
+				found := false
+				for _, warning := range result.Warnings {
+					found = found || warning == want
+				}
+				if !found {
+					t.Errorf("warnings lack %s: %q", want, result.Warnings)
+				}
+			}
+			if tt.flagged == 0 && strings.Contains(string(result.Output), PlaceholderWarningAttr) {
+				t.Errorf("output has %s with nothing flagged", PlaceholderWarningAttr)
+			}
+		})
+	}
+}
+
+func TestPlaceholderWarningsCapped(t *testing.T) {
+	page := "<p>" + strings.Repeat("Lorem ipsum. ", maxPlaceholderWarnings+5) + "</p>"
+	result, err := newTestPlaceholderProcessor(t).ProcessWithResult(context.Background(), []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Counts["flagged"] != maxPlaceholderWarnings+5 {
+		t.Errorf("flagged = %d, want every occurrence counted", result.Counts["flagged"])
+	}
+	if len(result.Warnings) != maxPlaceholderWarnings+1 || result.Warnings[maxPlaceholderWarnings] != "5 more placeholders" {
+		t.Errorf("warnings = %d, last %q; want %d and a summary", len(result.Warnings), result.Warnings[len(result.Warnings)-1], maxPlaceholderWarnings)
+	}
+	// One attribute value however often it matched
+	if !strings.Contains(string(result.Output), `<p data-placeholder-warning="lorem_ipsum">`) {
+		t.Errorf("output = %s", result.Output)
+	}
+}
+
+func TestPlaceholderSettings(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.ProcessorSettings = map[string]json.RawMessage{
+		"placeholders": json.RawMessage(`{"patterns": [{"name": "tbd", "pattern": "\\bTBD\\b"}], "attributes": ["alt"]}`),
+	}
+	settings, err := cfg.PlaceholderProcessorSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewPlaceholderProcessor(settings).Process(context.Background(), []byte(`<p title="TBD">Lorem ipsum, opening TBD</p><img alt="TBD">`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configured patterns replace the defaults, and only alt is checked
+	// besides text
+	if !strings.Contains(string(got), `<p data-placeholder-warning="tbd" title="TBD">Lorem ipsum, opening TBD</p>`) || !strings.Contains(string(got), `<img alt="TBD" data-placeholder-warning="tbd"/>`) {
+		t.Errorf("Process() = %s, want only the TBDs flagged", got)
+	}
+	if common.DefaultPlaceholderPatterns[0].Name != "lorem_ipsum" {
+		t.Error("settings changed the default patterns")
+	}
+
+	for _, bad := range []string{
+		`{"name": "", "pattern": "x"}`,
+		`{"name": "broken", "pattern": "("}`,
+		`{"name": "city", "pattern": "x", "field": "city"}`,
+	} {
+		cfg.ProcessorSettings = map[string]json.RawMessage{"placeholders": json.RawMessage(`{"patterns": [` + bad + `]}`)}
+		if _, err := cfg.PlaceholderProcessorSettings(); err == nil {
+			t.Errorf("PlaceholderProcessorSettings() of pattern %s error = nil", bad)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package processors
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/services"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testPhoto returns an Unsplash photo whose URLs are named after id, with
+// the given alt description and description
+func testPhoto(id string, alt, description *string) services.UnsplashPhoto {
+	raw := "https://images.unsplash.com/photo-" + id + "?ixid=test"
+	return services.UnsplashPhoto{
+		ID:             id,
+		AltDescription: alt,
+		Description:    description,
+		URLs: services.UnsplashPhotoURLs{
+			Raw:     raw,
+			Full:    raw + "&q=85",
+			Regular: raw + "&w=1080",
+			Small:   raw + "&w=400",
+			Thumb:   raw + "&w=200",
+		},
+		User: services.UnsplashUser{Name: "Ansel " + id},
+	}
+}
+
+// newTestUnsplash returns an Unsplash client of a fake API answering each
+// search query with photos[query], and no photos for other queries
+func newTestUnsplash(t *testing.T, photos map[string][]services.UnsplashPhoto) *services.UnsplashService {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/search/photos" {
+			http.NotFound(w, r)
+			return
+		}
+		results := photos[r.URL.Query().Get("query")]
+		json.NewEncoder(w).Encode(services.UnsplashSearchResponse{Total: len(results), TotalPages: 1, Results: results})
+	}))
+	t.Cleanup(server.Close)
+
+	svc := services.NewUnsplashService("test-access", "test-secret")
+	svc.SetBaseURL(server.URL)
+	return svc
+}
+
+// testImageSettings returns the default image processor settings
+func testImageSettings(t *testing.T) common.ImageProcessorSettings {
+	t.Helper()
+
+	settings, err := common.DefaultConfig().ImageProcessorSettings()
+	if err != nil {
+		t.Fatalf("ImageProcessorSettings() error = %v", err)
+	}
+	return settings
+}
+
+// readFixture returns testdata/<name>
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+var randomIDPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// numberRandomIDs replaces each distinct common.RandomID in data with
+// id-1, id-2 and so on, in order of first appearance
+func numberRandomIDs(data []byte) []byte {
+	seen := map[string]string{}
+	return randomIDPattern.ReplaceAllFunc(data, func(id []byte) []byte {
+		if _, ok := seen[string(id)]; !ok {
+			seen[string(id)] = fmt.Sprintf("id-%d", len(seen)+1)
+		}
+		return []byte(seen[string(id)])
+	})
+}
+
+// checkGolden compares got, with random IDs numbered, to testdata/<name>.
+// Run with -update to accept it.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	got = numberRandomIDs(got)
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s, run go test ./processors -update to accept:\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
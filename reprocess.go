@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/tenant/publish"
+	"awning-backend/services"
+	"awning-backend/storage"
+)
+
+// runReprocess implements the reprocess subcommand, re-running processors
+// over saved sites in the foreground:
+//
+//	awning-backend reprocess -tenants all|a,b -processors image,cleanup [-dry-run]
+//
+// Each result is printed as a JSON line, followed by a summary. It returns
+// the exit code.
+func runReprocess(ctx context.Context, args []string, cfg *common.Config, database *db.DB, redisClient *storage.RedisClient, processorsSvc *services.Processors, jwtManager *auth.JWTManager) int {
+	fs := flag.NewFlagSet("reprocess", flag.ContinueOnError)
+	tenantsFlag := fs.String("tenants", "", `tenant schemas separated by commas, or "all"`)
+	processorsFlag := fs.String("processors", "", "processors to run, separated by commas")
+	dryRun := fs.Bool("dry-run", false, "report changes without saving them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tenantsFlag == "" || *processorsFlag == "" {
+		fmt.Fprintln(os.Stderr, "reprocess needs -tenants and -processors")
+		fs.Usage()
+		return 2
+	}
+	if database == nil {
+		slog.Error("Reprocessing needs DATABASE_URL")
+		return 1
+	}
+
+	deps := &sections.Dependencies{
+		Config:        cfg,
+		DB:            database,
+		Redis:         redisClient,
+		KV:            redisClient,
+		ProcessorsSvc: processorsSvc,
+		Sites:         sites.NewResolver(cfg, database, redisClient),
+		JWT:           jwtManager,
+	}
+	reprocessor := publish.NewReprocessor(deps)
+
+	processorNames := strings.Split(*processorsFlag, ",")
+	if err := reprocessor.ValidateProcessors(processorNames); err != nil {
+		slog.Error("Invalid processors", "error", err)
+		return 2
+	}
+
+	var raw []byte
+	if *tenantsFlag == "all" {
+		raw, _ = json.Marshal("all")
+	} else {
+		raw, _ = json.Marshal(strings.Split(*tenantsFlag, ","))
+	}
+	tenants, err := reprocessor.ResolveTenants(ctx, raw)
+	if err != nil {
+		slog.Error("Invalid tenants", "error", err)
+		return 2
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	var processed, changed, failed int
+	for _, tenantSchema := range tenants {
+		err := reprocessor.ReprocessTenant(ctx, tenantSchema, processorNames, *dryRun, func(result publish.ReprocessResult) error {
+			processed++
+			switch result.Outcome {
+			case publish.ReprocessChanged:
+				changed++
+			case publish.ReprocessFailed:
+				failed++
+			}
+			return out.Encode(result)
+		})
+		if err != nil {
+			slog.Error("Failed to reprocess tenant", "tenant", tenantSchema, "error", err)
+			failed++
+		}
+	}
+
+	slog.Info("Reprocessing finished", "tenants", len(tenants), "processed", processed, "changed", changed, "failed", failed, "dry_run", *dryRun)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runVerifyPublications implements the verify-publications subcommand,
+// re-hashing stored publications and checking their signatures:
+//
+//	awning-backend verify-publications -tenants all|a,b
+//
+// Each result is printed as a JSON line, followed by a summary. It exits
+// with 1 when any publication doesn't match its hash or signature.
+func runVerifyPublications(ctx context.Context, args []string, cfg *common.Config, database *db.DB, jwtManager *auth.JWTManager) int {
+	fs := flag.NewFlagSet("verify-publications", flag.ContinueOnError)
+	tenantsFlag := fs.String("tenants", "", `tenant schemas separated by commas, or "all"`)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tenantsFlag == "" {
+		fmt.Fprintln(os.Stderr, "verify-publications needs -tenants")
+		fs.Usage()
+		return 2
+	}
+	if database == nil {
+		slog.Error("Verifying publications needs DATABASE_URL")
+		return 1
+	}
+	if jwtManager == nil {
+		slog.Warn("No JWT_PRIVATE_KEY set - signed publications will be reported as unknown_key")
+	}
+
+	deps := &sections.Dependencies{
+		Config: cfg,
+		DB:     database,
+		JWT:    jwtManager,
+	}
+
+	var raw []byte
+	if *tenantsFlag == "all" {
+		raw, _ = json.Marshal("all")
+	} else {
+		raw, _ = json.Marshal(strings.Split(*tenantsFlag, ","))
+	}
+	tenants, err := publish.NewReprocessor(deps).ResolveTenants(ctx, raw)
+	if err != nil {
+		slog.Error("Invalid tenants", "error", err)
+		return 2
+	}
+
+	verifier := publish.NewVerifier(deps)
+	out := json.NewEncoder(os.Stdout)
+	var checked, unsigned, mismatches int
+	for _, tenantSchema := range tenants {
+		err := verifier.VerifyTenant(ctx, tenantSchema, func(result publish.VerifyResult) error {
+			checked++
+			switch result.Status {
+			case publish.VerifyOK:
+			case publish.VerifyUnsigned:
+				unsigned++
+			default:
+				mismatches++
+			}
+			return out.Encode(result)
+		})
+		if err != nil {
+			slog.Error("Failed to verify tenant publications", "tenant", tenantSchema, "error", err)
+			mismatches++
+		}
+	}
+
+	slog.Info("Verification finished", "tenants", len(tenants), "checked", checked, "unsigned", unsigned, "mismatches", mismatches)
+	if mismatches > 0 {
+		return 1
+	}
+	return 0
+}
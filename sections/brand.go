@@ -0,0 +1,22 @@
+package sections
+
+import (
+	"context"
+
+	"awning-backend/processors"
+)
+
+// BrandAssets returns the tenant's brand settings for the header processor,
+// empty without a tenant or settings store
+func (d *Dependencies) BrandAssets(ctx context.Context, tenantSchema string) processors.BrandAssets {
+	if tenantSchema == "" || d.Settings == nil {
+		return processors.BrandAssets{}
+	}
+	brand := d.Settings.Brand(ctx, tenantSchema)
+	return processors.BrandAssets{
+		FaviconURL:    brand.FaviconURL,
+		PrimaryFont:   brand.PrimaryFont,
+		SecondaryFont: brand.SecondaryFont,
+		Colors:        brand.Colors,
+	}
+}
@@ -0,0 +1,460 @@
+package sections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrChatTenantRequired is returned by the Postgres chat store when the
+// context carries no tenant schema
+var ErrChatTenantRequired = errors.New("chat store needs a tenant schema")
+
+// ChatCacheTTL is how long a chat stays in the cache of CachedChatStore
+// after it was last read or saved
+const ChatCacheTTL = 24 * time.Hour
+
+// NewChatStore returns the chat store selected by cfg.ChatStore
+func NewChatStore(cfg *common.Config, database *db.DB, redis *storage.RedisClient) (storage.ChatStore, error) {
+	switch cfg.ChatStore {
+	case "", "redis":
+		return redis, nil
+	case "postgres":
+		return NewPostgresChatStore(database), nil
+	case "cached":
+		return NewCachedChatStore(redis, NewPostgresChatStore(database)), nil
+	default:
+		return nil, fmt.Errorf("unknown chat store: %s", cfg.ChatStore)
+	}
+}
+
+// PostgresChatStore keeps chats in the tenant's chats table. The tenant is
+// read from the context, set with services.WithTenantSchema.
+type PostgresChatStore struct {
+	db *db.DB
+}
+
+var _ storage.ChatStore = (*PostgresChatStore)(nil)
+
+// NewPostgresChatStore creates a chat store on the tenant schemas
+func NewPostgresChatStore(database *db.DB) *PostgresChatStore {
+	return &PostgresChatStore{db: database}
+}
+
+func (s *PostgresChatStore) withTenant(ctx context.Context, fn func(tx *gorm.DB, tenantSchema string) error) error {
+	tenantSchema, ok := services.TenantSchemaFromContext(ctx)
+	if !ok {
+		return ErrChatTenantRequired
+	}
+	return s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return fn(tx, tenantSchema)
+	})
+}
+
+// SaveChat inserts or updates the chat and increments its revision. The
+// update only applies while the stored revision matches the chat's.
+func (s *PostgresChatStore) SaveChat(ctx context.Context, chat *model.Chat) error {
+	messages, err := json.Marshal(chat.Messages)
+	if err != nil {
+		return fmt.Errorf("failed to serialize chat messages: %w", err)
+	}
+	var clarification json.RawMessage
+	if chat.Clarification != nil {
+		if clarification, err = json.Marshal(chat.Clarification); err != nil {
+			return fmt.Errorf("failed to serialize chat clarification: %w", err)
+		}
+	}
+
+	expected := chat.Revision
+	err = s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		row := models.TenantChat{
+			TenantSchema:    tenantSchema,
+			ChatID:          chat.ID,
+			Messages:        string(messages),
+			ChatStage:       string(chat.ChatStage),
+			LastRole:        string(chat.LastRole),
+			Title:           chat.Title,
+			Revision:        expected + 1,
+			PromptVariant:   chat.Variant,
+			ModerationFlags: chat.ModerationFlags,
+			UserID:          chat.UserID,
+			Variables:       chat.Variables,
+			Clarification:   clarification,
+		}
+		row.CreatedAt = time.Unix(chat.CreatedAt, 0).UTC()
+		row.UpdatedAt = time.Unix(chat.UpdatedAt, 0).UTC()
+
+		if expected == 0 {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return storage.ErrChatConflict
+			}
+			return nil
+		}
+
+		result := tx.Model(&models.TenantChat{}).
+			Where("chat_id = ? AND revision = ?", chat.ID, expected).
+			Updates(map[string]any{
+				"messages":         row.Messages,
+				"chat_stage":       row.ChatStage,
+				"last_role":        row.LastRole,
+				"title":            row.Title,
+				"revision":         row.Revision,
+				"prompt_variant":   row.PromptVariant,
+				"moderation_flags": gorm.Expr("?::jsonb", jsonOrNull(chat.ModerationFlags)),
+				"user_id":          row.UserID,
+				"variables":        gorm.Expr("?::jsonb", jsonOrNull(chat.Variables)),
+				"clarification":    gorm.Expr("?::jsonb", jsonOrNull(chat.Clarification)),
+				"updated_at":       row.UpdatedAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return storage.ErrChatConflict
+		}
+		return nil
+	})
+	if errors.Is(err, storage.ErrChatConflict) || errors.Is(err, ErrChatTenantRequired) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save chat to Postgres: %w", err)
+	}
+
+	chat.Revision = expected + 1
+	slog.Debug("Chat saved to Postgres", "chat_id", chat.ID, "revision", chat.Revision)
+	return nil
+}
+
+// GetChat loads a chat of the tenant
+func (s *PostgresChatStore) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
+	var row models.TenantChat
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		return tx.Where("chat_id = ? AND tenant_schema = ?", chatID, tenantSchema).First(&row).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", storage.ErrChatNotFound, chatID)
+	}
+	if errors.Is(err, ErrChatTenantRequired) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat from Postgres: %w", err)
+	}
+	return chatFromRow(&row)
+}
+
+// chatFromRow converts a chats table row to a chat
+func chatFromRow(row *models.TenantChat) (*model.Chat, error) {
+	chat := &model.Chat{
+		ID:              row.ChatID,
+		Title:           row.Title,
+		ChatStage:       model.ChatStage(row.ChatStage),
+		CreatedAt:       row.CreatedAt.Unix(),
+		UpdatedAt:       row.UpdatedAt.Unix(),
+		LastRole:        model.ChatMessageRole(row.LastRole),
+		Revision:        row.Revision,
+		Variant:         row.PromptVariant,
+		ModerationFlags: row.ModerationFlags,
+		TenantSchema:    row.TenantSchema,
+		UserID:          row.UserID,
+		Variables:       row.Variables,
+	}
+	if row.DeletedAt.Valid {
+		chat.DeletedAt = row.DeletedAt.Time.Unix()
+	}
+	if row.Messages != "" {
+		if err := json.Unmarshal([]byte(row.Messages), &chat.Messages); err != nil {
+			return nil, fmt.Errorf("failed to deserialize chat messages: %w", err)
+		}
+	}
+	if len(row.Clarification) > 0 && string(row.Clarification) != "null" {
+		if err := json.Unmarshal(row.Clarification, &chat.Clarification); err != nil {
+			return nil, fmt.Errorf("failed to deserialize chat clarification: %w", err)
+		}
+	}
+	return chat, nil
+}
+
+// DeleteChat removes a chat of the tenant for good
+func (s *PostgresChatStore) DeleteChat(ctx context.Context, chatID string) error {
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		return tx.Unscoped().Where("chat_id = ? AND tenant_schema = ?", chatID, tenantSchema).Delete(&models.TenantChat{}).Error
+	})
+	if err != nil && !errors.Is(err, ErrChatTenantRequired) {
+		return fmt.Errorf("failed to delete chat from Postgres: %w", err)
+	}
+	return err
+}
+
+// ListChats lists the tenant's chat IDs a page at a time
+func (s *PostgresChatStore) ListChats(ctx context.Context, offset, limit int) ([]string, error) {
+	chatIDs := []string{}
+	if limit <= 0 {
+		return chatIDs, nil
+	}
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		return tx.Model(&models.TenantChat{}).
+			Where("tenant_schema = ?", tenantSchema).
+			Order("chat_id").
+			Offset(max(offset, 0)).
+			Limit(limit).
+			Pluck("chat_id", &chatIDs).Error
+	})
+	if err != nil && !errors.Is(err, ErrChatTenantRequired) {
+		return nil, fmt.Errorf("failed to list chats: %w", err)
+	}
+	return chatIDs, err
+}
+
+// TrashChat soft-deletes the chat's row, setting its deleted_at
+func (s *PostgresChatStore) TrashChat(ctx context.Context, chatID string, _ time.Duration) error {
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		result := tx.Where("chat_id = ? AND tenant_schema = ?", chatID, tenantSchema).Delete(&models.TenantChat{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s", storage.ErrChatNotFound, chatID)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, storage.ErrChatNotFound) && !errors.Is(err, ErrChatTenantRequired) {
+		return fmt.Errorf("failed to trash chat in Postgres: %w", err)
+	}
+	return err
+}
+
+// trashed scopes an unscoped query to the rows soft-deleted within
+// retention
+func trashed(retention time.Duration) func(*gorm.DB) *gorm.DB {
+	cutoff := time.Now().Add(-retention)
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("deleted_at IS NOT NULL AND deleted_at > ?", cutoff)
+	}
+}
+
+// GetTrashedChat loads a soft-deleted chat of the tenant
+func (s *PostgresChatStore) GetTrashedChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	var row models.TenantChat
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		return tx.Unscoped().Scopes(trashed(retention)).Where("chat_id = ? AND tenant_schema = ?", chatID, tenantSchema).First(&row).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", storage.ErrChatNotFound, chatID)
+	}
+	if errors.Is(err, ErrChatTenantRequired) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trashed chat from Postgres: %w", err)
+	}
+	return chatFromRow(&row)
+}
+
+// RestoreChat clears the deleted_at of a chat soft-deleted within retention.
+// Chat IDs are unique across live and deleted rows, so there is never a
+// live chat in the way.
+func (s *PostgresChatStore) RestoreChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		result := tx.Unscoped().Model(&models.TenantChat{}).
+			Scopes(trashed(retention)).
+			Where("chat_id = ? AND tenant_schema = ?", chatID, tenantSchema).
+			Update("deleted_at", nil)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s", storage.ErrChatNotFound, chatID)
+		}
+		return nil
+	})
+	if errors.Is(err, storage.ErrChatNotFound) || errors.Is(err, ErrChatTenantRequired) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore chat in Postgres: %w", err)
+	}
+	return s.GetChat(ctx, chatID)
+}
+
+// ListTrashedChats lists the tenant's chats soft-deleted within retention
+func (s *PostgresChatStore) ListTrashedChats(ctx context.Context, retention time.Duration) ([]*model.Chat, error) {
+	var rows []models.TenantChat
+	err := s.withTenant(ctx, func(tx *gorm.DB, tenantSchema string) error {
+		return tx.Unscoped().Scopes(trashed(retention)).
+			Where("tenant_schema = ?", tenantSchema).
+			Order("deleted_at DESC, chat_id").
+			Find(&rows).Error
+	})
+	if errors.Is(err, ErrChatTenantRequired) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trashed chats: %w", err)
+	}
+
+	chats := make([]*model.Chat, 0, len(rows))
+	for i := range rows {
+		chat, err := chatFromRow(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, nil
+}
+
+// PurgeTrash deletes the rows of every tenant soft-deleted more than
+// retention ago. Tenants that fail are reported together once all have been
+// visited.
+func (s *PostgresChatStore) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	var schemas []string
+	if err := s.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	cutoff := time.Now().Add(-retention)
+	var errs []error
+	var purged int64
+	for _, schema := range schemas {
+		err := s.db.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+			result := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at <= ?", cutoff).Delete(&models.TenantChat{})
+			purged += result.RowsAffected
+			return result.Error
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", schema, err))
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// jsonOrNull encodes v for a jsonb column
+func jsonOrNull(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+// CachedChatStore is a write-through cache over a tenant-scoped chat store:
+// saves go to the store and then the cache, reads try the cache first
+type CachedChatStore struct {
+	cache storage.KV
+	store storage.ChatStore
+}
+
+var _ storage.ChatStore = (*CachedChatStore)(nil)
+
+// NewCachedChatStore caches the chats of store in cache
+func NewCachedChatStore(cache storage.KV, store storage.ChatStore) *CachedChatStore {
+	return &CachedChatStore{cache: cache, store: store}
+}
+
+// cacheKey is scoped to the tenant so a chat ID can't be read across tenants
+func (s *CachedChatStore) cacheKey(ctx context.Context, chatID string) string {
+	tenantSchema, _ := services.TenantSchemaFromContext(ctx)
+	return fmt.Sprintf("chat-cache:%s:%s", tenantSchema, chatID)
+}
+
+// SaveChat saves the chat to the store, then refreshes the cache. A chat
+// that can't be cached is evicted so the cache never serves a stale copy.
+func (s *CachedChatStore) SaveChat(ctx context.Context, chat *model.Chat) error {
+	if err := s.store.SaveChat(ctx, chat); err != nil {
+		return err
+	}
+
+	key := s.cacheKey(ctx, chat.ID)
+	data, err := chat.ToJSON()
+	if err == nil {
+		err = s.cache.SetWithTTL(ctx, key, data, ChatCacheTTL)
+	}
+	if err != nil {
+		slog.Warn("Failed to cache chat", "chat_id", chat.ID, "error", err)
+		if err := s.cache.Delete(ctx, key); err != nil {
+			slog.Error("Failed to evict chat from cache", "chat_id", chat.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// GetChat returns the cached chat, loading and caching it on a miss
+func (s *CachedChatStore) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
+	key := s.cacheKey(ctx, chatID)
+	if data, err := s.cache.Get(ctx, key); err == nil {
+		if chat, err := model.FromJSON(data); err == nil {
+			return chat, nil
+		}
+	}
+
+	chat, err := s.store.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := chat.ToJSON(); err == nil {
+		if err := s.cache.SetWithTTL(ctx, key, data, ChatCacheTTL); err != nil {
+			slog.Warn("Failed to cache chat", "chat_id", chatID, "error", err)
+		}
+	}
+	return chat, nil
+}
+
+// DeleteChat deletes the chat from the store and the cache
+func (s *CachedChatStore) DeleteChat(ctx context.Context, chatID string) error {
+	if err := s.store.DeleteChat(ctx, chatID); err != nil {
+		return err
+	}
+	return s.cache.Delete(ctx, s.cacheKey(ctx, chatID))
+}
+
+// ListChats lists chats from the store
+func (s *CachedChatStore) ListChats(ctx context.Context, offset, limit int) ([]string, error) {
+	return s.store.ListChats(ctx, offset, limit)
+}
+
+// TrashChat trashes the chat in the store and evicts it from the cache
+func (s *CachedChatStore) TrashChat(ctx context.Context, chatID string, retention time.Duration) error {
+	if err := s.store.TrashChat(ctx, chatID, retention); err != nil {
+		return err
+	}
+	return s.cache.Delete(ctx, s.cacheKey(ctx, chatID))
+}
+
+// GetTrashedChat loads a trashed chat from the store; the trash isn't cached
+func (s *CachedChatStore) GetTrashedChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	return s.store.GetTrashedChat(ctx, chatID, retention)
+}
+
+// RestoreChat restores the chat in the store. It is cached on its next read.
+func (s *CachedChatStore) RestoreChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	return s.store.RestoreChat(ctx, chatID, retention)
+}
+
+// ListTrashedChats lists trashed chats from the store
+func (s *CachedChatStore) ListTrashedChats(ctx context.Context, retention time.Duration) ([]*model.Chat, error) {
+	return s.store.ListTrashedChats(ctx, retention)
+}
+
+// PurgeTrash purges the store's trash
+func (s *CachedChatStore) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.store.PurgeTrash(ctx, retention)
+}
@@ -0,0 +1,53 @@
+package sections
+
+import (
+	"context"
+	"testing"
+
+	"awning-backend/model"
+	"awning-backend/services"
+	"awning-backend/storage"
+	"awning-backend/storage/storetest"
+)
+
+func TestCachedChatStoreContract(t *testing.T) {
+	storetest.TestChatStore(t, func(t *testing.T) (storage.ChatStore, context.Context) {
+		return NewCachedChatStore(storage.NewMemoryStore(), storage.NewMemoryStore()), services.WithTenantSchema(context.Background(), "t_a")
+	})
+}
+
+func TestCachedChatStoreReadsThrough(t *testing.T) {
+	cache, backing := storage.NewMemoryStore(), storage.NewMemoryStore()
+	store := NewCachedChatStore(cache, backing)
+	ctx := services.WithTenantSchema(context.Background(), "t_a")
+
+	chat := model.NewChat("chat-1")
+	if err := store.SaveChat(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "chat-cache:t_a:chat-1"); err != nil {
+		t.Fatalf("saved chat not cached: %v", err)
+	}
+
+	// A cached chat is served without the backing store
+	backing.DeleteChat(ctx, "chat-1")
+	if _, err := store.GetChat(ctx, "chat-1"); err != nil {
+		t.Errorf("GetChat() of a cached chat error = %v", err)
+	}
+
+	// The cache is per tenant
+	other := services.WithTenantSchema(context.Background(), "t_b")
+	if _, err := store.GetChat(other, "chat-1"); err == nil {
+		t.Error("GetChat() served another tenant's cached chat")
+	}
+
+	// Misses are cached on the way out
+	cache.Delete(ctx, "chat-cache:t_a:chat-1")
+	backing.SaveChat(ctx, model.NewChat("chat-1"))
+	if _, err := store.GetChat(ctx, "chat-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, "chat-cache:t_a:chat-1"); err != nil {
+		t.Errorf("chat loaded on a miss not cached: %v", err)
+	}
+}
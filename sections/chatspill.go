@@ -0,0 +1,347 @@
+package sections
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/jobs"
+	"awning-backend/model"
+	"awning-backend/services"
+	"awning-backend/storage"
+)
+
+// ChatSpillRetryInterval is how often SpillingChatStore looks for spilled
+// chats that are due to be retried
+const ChatSpillRetryInterval = 10 * time.Second
+
+// NewChatSpill returns the spill selected by cfg.ChatSpill, or nil when
+// spilling is off. redisClient is used for "redis" unless
+// cfg.ChatSpillRedisAddr names a secondary instance.
+func NewChatSpill(cfg *common.Config, redisClient *storage.RedisClient) (storage.ChatSpill, error) {
+	switch cfg.ChatSpill {
+	case "off":
+		return nil, nil
+	case "redis":
+		if cfg.ChatSpillRedisAddr == "" {
+			return redisClient, nil
+		}
+		return storage.NewRedisClientWithOptions(storage.RedisOptions{
+			Addrs:         []string{cfg.ChatSpillRedisAddr},
+			Password:      cfg.RedisPassword,
+			TLS:           cfg.RedisTLS,
+			TLSSkipVerify: cfg.RedisTLSSkipVerify,
+		})
+	case "", "dir":
+		return storage.NewDirChatSpill(cfg.ChatSpillDir)
+	default:
+		return nil, fmt.Errorf("unknown chat spill %q", cfg.ChatSpill)
+	}
+}
+
+// ChatSpillHooks observes the spill. Each hook is optional.
+type ChatSpillHooks struct {
+	Spilled   func(chatID string, cause error)
+	Recovered func(chatID string, attempts int, pending time.Duration)
+	Backlog   func(pending int)
+	// BacklogExceeded is called once each time the backlog grows past the
+	// alert threshold
+	BacklogExceeded func(pending, threshold int)
+}
+
+// SpillingChatStore keeps the chats of generations that couldn't be saved in
+// a spill and retries saving them in the background with backoff. Until a
+// spilled chat is saved, GetChat merges its messages into the stored copy.
+// Messages are merged by ID, so a retry never duplicates a message that
+// already reached the store.
+type SpillingChatStore struct {
+	storage.ChatStore
+	spill     storage.ChatSpill
+	threshold int
+	hooks     ChatSpillHooks
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	alerting bool // the backlog is past the threshold
+}
+
+// NewSpillingChatStore spills the failed saves of store into spill and
+// alerts when more than threshold chats are pending
+func NewSpillingChatStore(store storage.ChatStore, spill storage.ChatSpill, threshold int) *SpillingChatStore {
+	return &SpillingChatStore{
+		ChatStore: store,
+		spill:     spill,
+		threshold: threshold,
+		logger:    slog.With("component", "ChatSpill"),
+	}
+}
+
+// SetHooks installs hooks for observing the spill
+func (s *SpillingChatStore) SetHooks(hooks ChatSpillHooks) {
+	s.hooks = hooks
+}
+
+// Spill keeps the chat for a later save. Its messages from baseMessages on
+// are the ones that weren't saved; cause is the error saving it failed with.
+func (s *SpillingChatStore) Spill(ctx context.Context, chat *model.Chat, baseMessages int, cause error) error {
+	snapshot, err := copyChat(chat)
+	if err != nil {
+		return err
+	}
+	if snapshot.TenantSchema == "" {
+		snapshot.TenantSchema, _ = services.TenantSchemaFromContext(ctx)
+	}
+	if err := s.spill.PutDelta(ctx, storage.NewChatDelta(snapshot, baseMessages, cause)); err != nil {
+		return err
+	}
+
+	s.logger.Warn("Spilled chat after failed save", "chat_id", chat.ID, "error", cause)
+	if s.hooks.Spilled != nil {
+		s.hooks.Spilled(chat.ID, cause)
+	}
+	s.checkBacklog(ctx)
+	return nil
+}
+
+// GetChat returns the stored chat with the messages of its pending spilled
+// saves merged in. A chat that only exists in the spill is returned from
+// its snapshot.
+func (s *SpillingChatStore) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
+	chat, err := s.ChatStore.GetChat(ctx, chatID)
+
+	deltas, spillErr := s.chatDeltas(ctx, chatID)
+	if spillErr != nil {
+		s.logger.Error("Failed to read spilled chat saves", "chat_id", chatID, "error", spillErr)
+	}
+	if len(deltas) == 0 {
+		return chat, err
+	}
+
+	if err != nil {
+		s.logger.Warn("Serving chat from spill", "chat_id", chatID, "error", err)
+		if chat, err = copyChat(deltas[0].Chat); err != nil {
+			return nil, err
+		}
+		// The snapshot already has the first delta's messages
+		deltas = deltas[1:]
+	}
+	for _, delta := range deltas {
+		mergeDelta(chat, delta)
+	}
+	return chat, nil
+}
+
+// DeleteChat deletes the chat and drops its pending spilled saves
+func (s *SpillingChatStore) DeleteChat(ctx context.Context, chatID string) error {
+	if err := s.ChatStore.DeleteChat(ctx, chatID); err != nil {
+		return err
+	}
+	s.dropDeltas(ctx, chatID)
+	return nil
+}
+
+// TrashChat trashes the chat and drops its pending spilled saves, so a
+// retry doesn't bring it back
+func (s *SpillingChatStore) TrashChat(ctx context.Context, chatID string, retention time.Duration) error {
+	if err := s.ChatStore.TrashChat(ctx, chatID, retention); err != nil {
+		return err
+	}
+	s.dropDeltas(ctx, chatID)
+	return nil
+}
+
+// chatDeltas returns the chat's pending deltas that belong to the
+// context's tenant, if it has one
+func (s *SpillingChatStore) chatDeltas(ctx context.Context, chatID string) ([]*storage.ChatDelta, error) {
+	deltas, err := s.spill.ChatDeltas(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if tenantSchema, ok := services.TenantSchemaFromContext(ctx); ok && tenantSchema != "" {
+		deltas = slices.DeleteFunc(deltas, func(d *storage.ChatDelta) bool {
+			return d.Chat.TenantSchema != tenantSchema
+		})
+	}
+	return deltas, nil
+}
+
+func (s *SpillingChatStore) dropDeltas(ctx context.Context, chatID string) {
+	deltas, err := s.chatDeltas(ctx, chatID)
+	if err != nil {
+		s.logger.Error("Failed to read spilled chat saves", "chat_id", chatID, "error", err)
+		return
+	}
+	for _, delta := range deltas {
+		if err := s.spill.RemoveDelta(ctx, delta); err != nil {
+			s.logger.Error("Failed to drop spilled chat save", "chat_id", chatID, "error", err)
+		}
+	}
+}
+
+// Run retries the spilled saves that are due until ctx is done. It runs in
+// the process rather than on the job queue, which may well be down along
+// with the chat store.
+func (s *SpillingChatStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(ChatSpillRetryInterval)
+	defer ticker.Stop()
+
+	s.RetryDue(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RetryDue(ctx)
+		}
+	}
+}
+
+// RetryDue retries each spilled save whose next attempt is due, oldest
+// first
+func (s *SpillingChatStore) RetryDue(ctx context.Context) {
+	deltas, err := s.spill.Deltas(ctx)
+	if err != nil {
+		s.logger.Error("Failed to list spilled chat saves", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, delta := range deltas {
+		if ctx.Err() != nil {
+			return
+		}
+		if delta.NextAttemptAt.After(now) {
+			continue
+		}
+		s.retry(ctx, delta)
+	}
+	s.checkBacklog(ctx)
+}
+
+// retry saves one spilled chat, removing it from the spill once saved or
+// once its chat is gone, and scheduling the next attempt otherwise
+func (s *SpillingChatStore) retry(ctx context.Context, delta *storage.ChatDelta) {
+	chatID := delta.Chat.ID
+	delta.Attempts++
+
+	err := s.replay(services.WithTenantSchema(ctx, delta.Chat.TenantSchema), delta)
+	switch {
+	case err == nil:
+		pending := time.Since(delta.SpilledAt)
+		s.logger.Info("Saved spilled chat", "chat_id", chatID, "attempts", delta.Attempts, "pending", pending)
+		if s.hooks.Recovered != nil {
+			s.hooks.Recovered(chatID, delta.Attempts, pending)
+		}
+	case errors.Is(err, storage.ErrChatNotFound):
+		s.logger.Warn("Dropping spilled save of a chat that no longer exists", "chat_id", chatID, "lost", len(delta.Added()))
+	default:
+		delta.LastError = err.Error()
+		delta.NextAttemptAt = time.Now().Add(jobs.Backoff(delta.Attempts))
+		s.logger.Warn("Failed to save spilled chat", "chat_id", chatID, "attempt", delta.Attempts, "next_attempt", delta.NextAttemptAt, "error", err)
+		if err := s.spill.PutDelta(ctx, delta); err != nil {
+			s.logger.Error("Failed to reschedule spilled chat save", "chat_id", chatID, "error", err)
+		}
+		return
+	}
+
+	if err := s.spill.RemoveDelta(ctx, delta); err != nil {
+		s.logger.Error("Failed to remove saved chat from spill", "chat_id", chatID, "error", err)
+	}
+}
+
+// replay saves the delta's snapshot when nothing saved the chat since it
+// was loaded, and otherwise merges the delta into the stored copy
+func (s *SpillingChatStore) replay(ctx context.Context, delta *storage.ChatDelta) error {
+	snapshot, err := copyChat(delta.Chat)
+	if err != nil {
+		return err
+	}
+	err = s.ChatStore.SaveChat(ctx, snapshot)
+	if !errors.Is(err, storage.ErrChatConflict) {
+		return err
+	}
+
+	_, err = storage.UpdateChat(ctx, s.ChatStore, delta.Chat.ID, func(chat *model.Chat) error {
+		if !mergeDelta(chat, delta) {
+			return errNothingToMerge
+		}
+		return nil
+	})
+	if errors.Is(err, errNothingToMerge) {
+		return nil
+	}
+	return err
+}
+
+// errNothingToMerge stops replay from saving a chat that already has every
+// message of the delta
+var errNothingToMerge = errors.New("nothing to merge")
+
+// checkBacklog reports the number of pending saves and alerts when it
+// first grows past the threshold
+func (s *SpillingChatStore) checkBacklog(ctx context.Context) {
+	deltas, err := s.spill.Deltas(ctx)
+	if err != nil {
+		s.logger.Error("Failed to count spilled chat saves", "error", err)
+		return
+	}
+	pending := len(deltas)
+	if s.hooks.Backlog != nil {
+		s.hooks.Backlog(pending)
+	}
+
+	s.mu.Lock()
+	alert := pending > s.threshold && !s.alerting
+	s.alerting = pending > s.threshold
+	s.mu.Unlock()
+
+	if alert {
+		s.logger.Error("Spilled chat saves past alert threshold", "pending", pending, "threshold", s.threshold)
+		if s.hooks.BacklogExceeded != nil {
+			s.hooks.BacklogExceeded(pending, s.threshold)
+		}
+	}
+}
+
+// mergeDelta adds the delta's messages and moderation flags that the chat
+// doesn't have yet, reporting whether it added any
+func mergeDelta(chat *model.Chat, delta *storage.ChatDelta) bool {
+	merged := false
+	for _, message := range delta.Added() {
+		if slices.ContainsFunc(chat.Messages, func(m model.ChatMessage) bool { return sameMessage(m, message) }) {
+			continue
+		}
+		chat.AddMessage(&message)
+		merged = true
+	}
+	for _, category := range delta.Chat.ModerationFlags {
+		if !slices.Contains(chat.ModerationFlags, category) {
+			chat.ModerationFlags = append(chat.ModerationFlags, category)
+			merged = true
+		}
+	}
+	return merged
+}
+
+// sameMessage reports whether a and b are the same message: by ID, or for
+// messages sent without one, by role, content and timestamp
+func sameMessage(a, b model.ChatMessage) bool {
+	if a.ID != "" || b.ID != "" {
+		return a.ID == b.ID
+	}
+	return a.Role == b.Role && a.Content == b.Content && a.Timestamp == b.Timestamp
+}
+
+// copyChat deep-copies a chat through its JSON form
+func copyChat(chat *model.Chat) (*model.Chat, error) {
+	data, err := chat.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy chat: %w", err)
+	}
+	return model.FromJSON(data)
+}
@@ -0,0 +1,349 @@
+package sections
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/services"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// spillHooks counts the calls of each spill hook
+type spillHooks struct {
+	spilled, recovered, exceeded int
+	attempts                     int // of the last recovery
+}
+
+func (h *spillHooks) install(s *SpillingChatStore) {
+	s.SetHooks(ChatSpillHooks{
+		Spilled: func(string, error) { h.spilled++ },
+		Recovered: func(_ string, attempts int, _ time.Duration) {
+			h.recovered++
+			h.attempts = attempts
+		},
+		BacklogExceeded: func(int, int) { h.exceeded++ },
+	})
+}
+
+// newSpillingRedisStore returns a spilling store over a chat store in an
+// in-process Redis, whose failures the test controls through the server,
+// and a spill in a temporary directory
+func newSpillingRedisStore(t *testing.T, threshold int) (*SpillingChatStore, *storage.RedisClient, *miniredis.Miniredis, *spillHooks) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	redisStore, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { redisStore.Close() })
+	spill, err := storage.NewDirChatSpill(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSpillingChatStore(redisStore, spill, threshold)
+	hooks := &spillHooks{}
+	hooks.install(store)
+	return store, redisStore, server, hooks
+}
+
+// spillReply loads the chat, adds an assistant reply and spills it after
+// its save fails, the way saveGeneration does
+func spillReply(t *testing.T, ctx context.Context, store *SpillingChatStore, server *miniredis.Miniredis, chatID, reply string) *model.Chat {
+	t.Helper()
+
+	chat, err := store.GetChat(ctx, chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := len(chat.Messages)
+	chat.AddMessage(model.NewChatMessage(model.ChatMessageRoleAssistant, reply))
+
+	server.SetError("LOADING Redis is loading the dataset in memory")
+	err = store.SaveChat(ctx, chat)
+	server.SetError("")
+	if err == nil {
+		t.Fatal("SaveChat() with Redis failing error = nil")
+	}
+	if err := store.Spill(ctx, chat, base, err); err != nil {
+		t.Fatalf("Spill() error = %v", err)
+	}
+	return chat
+}
+
+// makeDue moves every pending delta's next attempt into the past
+func makeDue(t *testing.T, ctx context.Context, store *SpillingChatStore) {
+	t.Helper()
+
+	deltas, err := store.spill.Deltas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delta := range deltas {
+		delta.NextAttemptAt = time.Now().Add(-time.Second)
+		if err := store.spill.PutDelta(ctx, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertMessages fails unless the chat has exactly the given contents, in
+// order, with no message ID twice
+func assertMessages(t *testing.T, chat *model.Chat, want ...string) {
+	t.Helper()
+
+	seen := map[string]bool{}
+	var got []string
+	for _, message := range chat.Messages {
+		if message.ID != "" && seen[message.ID] {
+			t.Errorf("message %s (%q) is duplicated", message.ID, message.Content)
+		}
+		seen[message.ID] = true
+		got = append(got, message.Content)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("messages = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("messages = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSpillingChatStoreLifecycle(t *testing.T) {
+	store, redisStore, server, hooks := newSpillingRedisStore(t, 10)
+	ctx := services.WithTenantSchema(context.Background(), "t_a")
+
+	chat := model.NewChat("chat-1")
+	chat.TenantSchema = "t_a"
+	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page for my bakery")
+	if err := store.SaveChat(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+
+	spillReply(t, ctx, store, server, "chat-1", "<h1>Bakery</h1>")
+	if hooks.spilled != 1 {
+		t.Errorf("Spilled hook calls = %d, want 1", hooks.spilled)
+	}
+
+	// The spilled reply is merged into reads, and served from the spill
+	// while Redis is down
+	got, err := store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, got, "A page for my bakery", "<h1>Bakery</h1>")
+	server.SetError("LOADING Redis is loading the dataset in memory")
+	got, err = store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("GetChat() with Redis down error = %v, want the spilled copy", err)
+	}
+	assertMessages(t, got, "A page for my bakery", "<h1>Bakery</h1>")
+
+	// A failed retry keeps the delta with a later next attempt
+	store.RetryDue(ctx)
+	deltas, err := store.spill.Deltas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 1 || deltas[0].Attempts != 1 || !deltas[0].NextAttemptAt.After(time.Now()) || deltas[0].LastError == "" {
+		t.Fatalf("deltas after a failed retry = %+v, want one rescheduled", deltas)
+	}
+	server.SetError("")
+
+	// Not due yet, so nothing is retried
+	store.RetryDue(ctx)
+	if hooks.recovered != 0 {
+		t.Fatal("a delta was retried before its next attempt")
+	}
+
+	// The user sends another message before the retry, so the retry
+	// merges into the newer copy
+	stored, err := redisStore.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "Make it blue")
+	if err := redisStore.SaveChat(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+
+	makeDue(t, ctx, store)
+	store.RetryDue(ctx)
+	if hooks.recovered != 1 || hooks.attempts != 2 {
+		t.Errorf("Recovered hook calls = %d after %d attempts, want 1 after 2", hooks.recovered, hooks.attempts)
+	}
+	if deltas, _ := store.spill.Deltas(ctx); len(deltas) != 0 {
+		t.Errorf("%d deltas left after recovery", len(deltas))
+	}
+	saved, err := redisStore.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, saved, "A page for my bakery", "Make it blue", "<h1>Bakery</h1>")
+
+	// Retrying again changes nothing
+	makeDue(t, ctx, store)
+	store.RetryDue(ctx)
+	got, err = store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, got, "A page for my bakery", "Make it blue", "<h1>Bakery</h1>")
+	if got.Revision != saved.Revision {
+		t.Errorf("revision = %d after an idle retry, want %d", got.Revision, saved.Revision)
+	}
+}
+
+func TestSpillingChatStoreSaveThatLanded(t *testing.T) {
+	store, redisStore, server, hooks := newSpillingRedisStore(t, 10)
+	ctx := context.Background()
+
+	chat := model.NewChat("chat-1")
+	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page for my bakery")
+	if err := store.SaveChat(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+
+	// The save timed out on the client but reached Redis
+	spilled := spillReply(t, ctx, store, server, "chat-1", "<h1>Bakery</h1>")
+	landed, err := copyChat(spilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := redisStore.SaveChat(ctx, landed); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, got, "A page for my bakery", "<h1>Bakery</h1>")
+
+	store.RetryDue(ctx)
+	if hooks.recovered != 1 {
+		t.Errorf("Recovered hook calls = %d, want 1", hooks.recovered)
+	}
+	saved, err := redisStore.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMessages(t, saved, "A page for my bakery", "<h1>Bakery</h1>")
+	if saved.Revision != landed.Revision {
+		t.Errorf("revision = %d, want %d: the retry saved a chat with nothing to merge", saved.Revision, landed.Revision)
+	}
+}
+
+func TestSpillingChatStoreDroppedDeltas(t *testing.T) {
+	store, redisStore, server, hooks := newSpillingRedisStore(t, 10)
+	ctx := services.WithTenantSchema(context.Background(), "t_a")
+
+	for _, id := range []string{"deleted", "gone", "trashed"} {
+		chat := model.NewChat(id)
+		chat.TenantSchema = "t_a"
+		if err := store.SaveChat(ctx, chat); err != nil {
+			t.Fatal(err)
+		}
+		spillReply(t, ctx, store, server, id, "<h1>Reply</h1>")
+	}
+
+	// Another tenant doesn't see the spilled messages
+	other := services.WithTenantSchema(context.Background(), "t_b")
+	if got, err := store.GetChat(other, "deleted"); err != nil || len(got.Messages) != 0 {
+		t.Errorf("GetChat() from another tenant = %+v, %v; want the stored chat alone", got, err)
+	}
+
+	if err := store.DeleteChat(ctx, "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TrashChat(ctx, "trashed", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if deltas, _ := store.spill.Deltas(ctx); len(deltas) != 1 || deltas[0].Chat.ID != "gone" {
+		t.Fatalf("deltas after delete and trash = %+v, want only gone's", deltas)
+	}
+
+	// A chat deleted behind the store's back is given up on at its retry
+	if err := redisStore.DeleteChat(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	store.RetryDue(ctx)
+	if deltas, _ := store.spill.Deltas(ctx); len(deltas) != 0 {
+		t.Errorf("%d deltas left, want the deleted chat's dropped", len(deltas))
+	}
+	if _, err := redisStore.GetChat(ctx, "gone"); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetChat() of the deleted chat error = %v, want it kept deleted", err)
+	}
+	if hooks.recovered != 0 {
+		t.Errorf("Recovered hook calls = %d, want 0", hooks.recovered)
+	}
+}
+
+func TestSpillingChatStoreBacklogAlert(t *testing.T) {
+	store, redisStore, server, hooks := newSpillingRedisStore(t, 2)
+	ctx := context.Background()
+
+	spillAll := func(ids ...string) {
+		for _, id := range ids {
+			if err := redisStore.SaveChat(ctx, model.NewChat(id)); err != nil {
+				t.Fatal(err)
+			}
+			spillReply(t, ctx, store, server, id, "<h1>Reply</h1>")
+		}
+	}
+
+	spillAll("a", "b")
+	if hooks.exceeded != 0 {
+		t.Fatalf("BacklogExceeded hook calls at the threshold = %d, want 0", hooks.exceeded)
+	}
+	spillAll("c", "d")
+	if hooks.exceeded != 1 {
+		t.Fatalf("BacklogExceeded hook calls past the threshold = %d, want 1", hooks.exceeded)
+	}
+
+	// Draining the backlog rearms the alert
+	store.RetryDue(ctx)
+	if hooks.recovered != 4 {
+		t.Fatalf("Recovered hook calls = %d, want 4", hooks.recovered)
+	}
+	spillAll("e", "f", "g")
+	if hooks.exceeded != 2 {
+		t.Errorf("BacklogExceeded hook calls after a second crossing = %d, want 2", hooks.exceeded)
+	}
+}
+
+func TestMergeDelta(t *testing.T) {
+	spilled := model.NewChat("chat-1")
+	spilled.AddMessage(&model.ChatMessage{Role: model.ChatMessageRoleUser, Content: "A page for my bakery"})
+	spilled.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "<h1>Bakery</h1>")
+	spilled.ModerationFlags = []string{"spam"}
+	delta := storage.NewChatDelta(spilled, 0, nil)
+
+	stored, err := copyChat(spilled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mergeDelta(stored, delta) {
+		t.Error("mergeDelta() into a chat with every message merged something")
+	}
+	assertMessages(t, stored, "A page for my bakery", "<h1>Bakery</h1>")
+
+	// A message sent without an ID only matches one with the same content
+	empty := model.NewChat("chat-1")
+	empty.AddMessage(&model.ChatMessage{Role: model.ChatMessageRoleUser, Content: "A page for my cafe"})
+	if !mergeDelta(empty, delta) {
+		t.Fatal("mergeDelta() into a chat missing the messages merged nothing")
+	}
+	assertMessages(t, empty, "A page for my cafe", "A page for my bakery", "<h1>Bakery</h1>")
+	if len(empty.ModerationFlags) != 1 || empty.ModerationFlags[0] != "spam" {
+		t.Errorf("moderation flags = %v, want the delta's", empty.ModerationFlags)
+	}
+}
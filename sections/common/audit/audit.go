@@ -0,0 +1,52 @@
+// Package audit records security-relevant actions in the shared audit_events table
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections/models"
+)
+
+const (
+	ActionModerationBlocked = "moderation.blocked"
+	ActionModerationFlagged = "moderation.flagged"
+	ActionLoginLocked       = "auth.login_locked"
+	ActionSiteReprocessed   = "site.reprocessed"
+	ActionFlagsUpdated      = "flags.updated"
+	ActionChatSpillBacklog  = "chat.spill_backlog"
+	ActionRedisKeysReaped   = "redis.keys_reaped"
+	ActionSettingUpdated    = "settings.updated"
+	ActionMetricsTenants    = "metrics.tenants_updated"
+)
+
+// Record saves an audit event. Failures are logged rather than returned so
+// that auditing never fails the request being audited.
+func Record(ctx context.Context, database *db.DB, tenantSchema string, userID *uint, action string, detail any) {
+	event := models.AuditEvent{
+		TenantSchema: tenantSchema,
+		UserID:       userID,
+		Action:       action,
+		Detail:       "{}",
+		IP:           common.ClientIPFromContext(ctx),
+	}
+	if detail != nil {
+		data, err := json.Marshal(detail)
+		if err != nil {
+			slog.Error("Failed to encode audit detail", "action", action, "error", err)
+		} else {
+			event.Detail = string(data)
+		}
+	}
+
+	if database == nil {
+		slog.Warn("Audit event not saved, no database", "action", action, "tenant_schema", tenantSchema, "detail", event.Detail)
+		return
+	}
+	if err := database.DB.WithContext(ctx).Create(&event).Error; err != nil {
+		slog.Error("Failed to record audit event", "action", action, "tenant_schema", tenantSchema, "error", err)
+	}
+}
@@ -0,0 +1,45 @@
+// Package authtest provides a JWT manager with a throwaway key for tests
+package authtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"awning-backend/sections/common/auth"
+)
+
+// NewJWTManager returns a JWTManager signing with a new P-521 key
+func NewJWTManager(t testing.TB) *auth.JWTManager {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	manager, err := auth.NewJWTManager(string(keyPEM), "awning-test", 1)
+	if err != nil {
+		t.Fatalf("failed to create JWT manager: %v", err)
+	}
+	return manager
+}
+
+// Token issues a token for the user, failing the test on error
+func Token(t testing.TB, manager *auth.JWTManager, userID uint, email, tenantSchema string) string {
+	t.Helper()
+
+	token, err := manager.GenerateToken(userID, email, tenantSchema)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	return token
+}
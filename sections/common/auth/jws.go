@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKSPath is where the public keys signatures are checked with are listed
+const JWKSPath = "/.well-known/jwks.json"
+
+var (
+	ErrInvalidJWS = errors.New("invalid detached JWS")
+	ErrUnknownKey = errors.New("JWS signed with an unknown key")
+)
+
+// JWK is an EC public key in JSON Web Key form (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+// JWKSet is the response of the JWKS endpoint
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// KeyID returns the kid of the signing key, its RFC 7638 thumbprint
+func (j *JWTManager) KeyID() string {
+	return j.keyID
+}
+
+// AddVerificationKey adds a PEM-encoded ES512 public key, such as the one
+// of a rotated-out private key, that detached signatures are still
+// verified with and that is listed in the JWKS
+func (j *JWTManager) AddVerificationKey(publicKeyPEM string) error {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return errors.New("failed to parse PEM block from public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P521() {
+		return errors.New("public key is not a P-521 ECDSA key")
+	}
+	kid, err := thumbprint(publicKey)
+	if err != nil {
+		return err
+	}
+	if j.verificationKeys == nil {
+		j.verificationKeys = make(map[string]*ecdsa.PublicKey)
+	}
+	j.verificationKeys[kid] = publicKey
+	return nil
+}
+
+// addVerificationKeysFromEnv adds the keys in JWT_PREVIOUS_PUBLIC_KEYS,
+// base64-encoded PEM public keys separated by commas
+func (j *JWTManager) addVerificationKeysFromEnv() error {
+	for _, encoded := range strings.Split(os.Getenv("JWT_PREVIOUS_PUBLIC_KEYS"), ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+		publicKeyPEM, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode previous JWT public key from base64: %w", err)
+		}
+		if err := j.AddVerificationKey(string(publicKeyPEM)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignDetached signs payload with the ES512 key and returns the JWS in
+// compact form without its payload (RFC 7515 appendix F), header..signature.
+// The header carries the key's kid.
+func (j *JWTManager) SignDetached(payload []byte) (string, error) {
+	header, _ := json.Marshal(jwsHeader{Alg: jwt.SigningMethodES512.Alg(), Kid: j.keyID})
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	signingString := encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	signature, err := jwt.SigningMethodES512.Sign(signingString, j.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign payload: %w", err)
+	}
+	return encodedHeader + ".." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// VerifyDetached checks a detached JWS from SignDetached against payload
+// with the key its kid names: the signing key or one added with
+// AddVerificationKey. It returns the kid.
+func (j *JWTManager) VerifyDetached(jws string, payload []byte) (string, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return "", ErrInvalidJWS
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidJWS
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != jwt.SigningMethodES512.Alg() {
+		return "", ErrInvalidJWS
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidJWS
+	}
+
+	key := j.verificationKeys[header.Kid]
+	if header.Kid == j.keyID {
+		key = j.publicKey
+	}
+	if key == nil {
+		return header.Kid, ErrUnknownKey
+	}
+
+	signingString := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if err := jwt.SigningMethodES512.Verify(signingString, signature, key); err != nil {
+		return header.Kid, ErrInvalidSignature
+	}
+	return header.Kid, nil
+}
+
+// JWKS returns the signing key and the added verification keys
+func (j *JWTManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{toJWK(j.publicKey, j.keyID)}}
+	for kid, key := range j.verificationKeys {
+		set.Keys = append(set.Keys, toJWK(key, kid))
+	}
+	return set
+}
+
+// RegisterJWKSRoutes serves the JWKS at JWKSPath, without authentication
+func RegisterJWKSRoutes(r gin.IRouter, jwtManager *JWTManager) {
+	r.GET(JWKSPath, func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.JSON(http.StatusOK, jwtManager.JWKS())
+	})
+}
+
+// coordinates returns the key's x and y, each padded to the curve size
+func coordinates(key *ecdsa.PublicKey) ([]byte, []byte, error) {
+	ecdhKey, err := key.ECDH()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	// Uncompressed point: 0x04 || x || y
+	point := ecdhKey.Bytes()[1:]
+	return point[:len(point)/2], point[len(point)/2:], nil
+}
+
+func toJWK(key *ecdsa.PublicKey, kid string) JWK {
+	x, y, _ := coordinates(key)
+	return JWK{
+		Kty: "EC",
+		Crv: "P-521",
+		X:   base64.RawURLEncoding.EncodeToString(x),
+		Y:   base64.RawURLEncoding.EncodeToString(y),
+		Kid: kid,
+		Use: "sig",
+		Alg: jwt.SigningMethodES512.Alg(),
+	}
+}
+
+// thumbprint returns the RFC 7638 JWK thumbprint of key
+func thumbprint(key *ecdsa.PublicKey) (string, error) {
+	x, y, err := coordinates(key)
+	if err != nil {
+		return "", err
+	}
+	// Required members only, in lexicographic order
+	canonical := fmt.Sprintf(`{"crv":"P-521","kty":"EC","x":"%s","y":"%s"}`,
+		base64.RawURLEncoding.EncodeToString(x), base64.RawURLEncoding.EncodeToString(y))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,114 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/auth/authtest"
+)
+
+// newKeyPair returns a JWT manager on a new P-521 key and the key's public
+// half in PEM form
+func newKeyPair(t *testing.T) (*auth.JWTManager, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := auth.NewJWTManager(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), "awning-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+}
+
+func TestDetachedJWSRoundTrip(t *testing.T) {
+	manager := authtest.NewJWTManager(t)
+	payload := []byte(`{"digests":{"/":"abc"},"tenant":"tenant_7","version":3}`)
+
+	jws, err := manager.SignDetached(payload)
+	if err != nil {
+		t.Fatalf("SignDetached() error = %v", err)
+	}
+	if parts := strings.Split(jws, "."); len(parts) != 3 || parts[1] != "" {
+		t.Fatalf("SignDetached() = %q, want header..signature", jws)
+	}
+	kid, err := manager.VerifyDetached(jws, payload)
+	if err != nil || kid != manager.KeyID() {
+		t.Errorf("VerifyDetached() = %q, %v; want the signing kid", kid, err)
+	}
+
+	tampered := []byte(strings.Replace(string(payload), `"version":3`, `"version":4`, 1))
+	if _, err := manager.VerifyDetached(jws, tampered); !errors.Is(err, auth.ErrInvalidSignature) {
+		t.Errorf("VerifyDetached() of a changed payload error = %v, want ErrInvalidSignature", err)
+	}
+
+	invalid := []string{
+		"",
+		"not-a-jws",
+		strings.Replace(jws, "..", ".cGF5bG9hZA.", 1), // attached payload
+		"!!!.." + strings.Split(jws, ".")[2],
+	}
+	for _, jws := range invalid {
+		if _, err := manager.VerifyDetached(jws, payload); !errors.Is(err, auth.ErrInvalidJWS) {
+			t.Errorf("VerifyDetached(%q) error = %v, want ErrInvalidJWS", jws, err)
+		}
+	}
+}
+
+func TestDetachedJWSKeyRotation(t *testing.T) {
+	old, oldPublic := newKeyPair(t)
+	current, _ := newKeyPair(t)
+	payload := []byte("published page")
+
+	jws, err := old.SignDetached(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := current.VerifyDetached(jws, payload); !errors.Is(err, auth.ErrUnknownKey) {
+		t.Errorf("VerifyDetached() with the old key unknown error = %v, want ErrUnknownKey", err)
+	}
+
+	if err := current.AddVerificationKey(oldPublic); err != nil {
+		t.Fatalf("AddVerificationKey() error = %v", err)
+	}
+	kid, err := current.VerifyDetached(jws, payload)
+	if err != nil || kid != old.KeyID() {
+		t.Errorf("VerifyDetached() after rotation = %q, %v; want the old kid %q", kid, err, old.KeyID())
+	}
+	if _, err := current.VerifyDetached(jws, []byte("changed page")); !errors.Is(err, auth.ErrInvalidSignature) {
+		t.Errorf("VerifyDetached() of a changed page with the old key error = %v", err)
+	}
+
+	// Both keys are listed for clients to verify with
+	kids := map[string]bool{}
+	for _, key := range current.JWKS().Keys {
+		if key.Kty != "EC" || key.Crv != "P-521" || key.Alg != "ES512" {
+			t.Errorf("JWKS key = %+v, want a P-521 ES512 key", key)
+		}
+		kids[key.Kid] = true
+	}
+	if len(kids) != 2 || !kids[old.KeyID()] || !kids[current.KeyID()] {
+		t.Errorf("JWKS kids = %v, want the current and old keys", kids)
+	}
+
+	if err := current.AddVerificationKey("not a key"); err == nil {
+		t.Error("AddVerificationKey() of an invalid PEM error = nil")
+	}
+}
@@ -36,8 +36,13 @@ type Claims struct {
 type JWTManager struct {
 	privateKey *ecdsa.PrivateKey
 	publicKey  *ecdsa.PublicKey
+	keyID      string
 	issuer     string
 	expiry     time.Duration
+
+	// Public keys of rotated-out private keys, by kid, that detached
+	// signatures are still verified with
+	verificationKeys map[string]*ecdsa.PublicKey
 }
 
 // NewJWTManager creates a new JWT manager from a PEM-encoded ES512 private key
@@ -46,8 +51,6 @@ func NewJWTManager(privateKeyPEM string, issuer string, expiryHours int) (*JWTMa
 		return nil, errors.New("JWT_PRIVATE_KEY environment variable is required")
 	}
 
-	slog.Info("Decoding JWT private key: ", slog.String("key", privateKeyPEM))
-
 	// Parse PEM block
 	block, _ := pem.Decode([]byte(privateKeyPEM))
 	if block == nil {
@@ -76,10 +79,15 @@ func NewJWTManager(privateKeyPEM string, issuer string, expiryHours int) (*JWTMa
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
+	keyID, err := thumbprint(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
 
 	return &JWTManager{
 		privateKey: privateKey,
 		publicKey:  &privateKey.PublicKey,
+		keyID:      keyID,
 		issuer:     issuer,
 		expiry:     time.Duration(expiryHours) * time.Hour,
 	}, nil
@@ -140,8 +148,22 @@ func (j *JWTManager) RefreshToken(claims *Claims) (string, error) {
 
 // JWTAuthMiddleware returns a Gin middleware for JWT authentication
 func JWTAuthMiddleware(jwtManager *JWTManager) gin.HandlerFunc {
+	return jwtAuth(jwtManager, false)
+}
+
+// WebSocketJWTAuthMiddleware is JWTAuthMiddleware for WebSocket handshakes.
+// Browsers can't set the Authorization header on them, so the token is
+// also accepted from the token query parameter.
+func WebSocketJWTAuthMiddleware(jwtManager *JWTManager) gin.HandlerFunc {
+	return jwtAuth(jwtManager, true)
+}
+
+func jwtAuth(jwtManager *JWTManager, queryToken bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && queryToken && c.Query("token") != "" {
+			authHeader = "Bearer " + c.Query("token")
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
 			c.Abort()
@@ -248,5 +270,12 @@ func NewJWTManagerFromEnv() (*JWTManager, error) {
 	if issuer == "" {
 		issuer = "awning-backend"
 	}
-	return NewJWTManager(string(privateKey), issuer, 24) // 24 hour expiry
+	jwtManager, err := NewJWTManager(string(privateKey), issuer, 24) // 24 hour expiry
+	if err != nil {
+		return nil, err
+	}
+	if err := jwtManager.addVerificationKeysFromEnv(); err != nil {
+		return nil, err
+	}
+	return jwtManager, nil
 }
@@ -0,0 +1,61 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/auth/authtest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJWTAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	manager := authtest.NewJWTManager(t)
+	token := authtest.Token(t, manager, 7, "user@example.com", "tenant_7")
+
+	tests := []struct {
+		name       string
+		middleware gin.HandlerFunc
+		header     string
+		query      string
+		want       int
+	}{
+		{"bearer header", auth.JWTAuthMiddleware(manager), "Bearer " + token, "", http.StatusOK},
+		{"missing token", auth.JWTAuthMiddleware(manager), "", "", http.StatusUnauthorized},
+		{"invalid token", auth.JWTAuthMiddleware(manager), "Bearer not-a-token", "", http.StatusUnauthorized},
+		{"query token not accepted", auth.JWTAuthMiddleware(manager), "", token, http.StatusUnauthorized},
+		{"websocket bearer header", auth.WebSocketJWTAuthMiddleware(manager), "Bearer " + token, "", http.StatusOK},
+		{"websocket query token", auth.WebSocketJWTAuthMiddleware(manager), "", token, http.StatusOK},
+		{"websocket invalid query token", auth.WebSocketJWTAuthMiddleware(manager), "", "not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", tt.middleware, func(c *gin.Context) {
+				tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+				userID, _ := auth.GetUserIDFromContext(c)
+				if tenantSchema != "tenant_7" || userID != 7 {
+					t.Errorf("claims in context = %q, %d, want tenant_7, 7", tenantSchema, userID)
+				}
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.query != "" {
+				req.URL.RawQuery = "token=" + tt.query
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,81 @@
 package auth
 
 import (
+	"context"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	ginmw "github.com/bartventer/gorm-multitenancy/middleware/gin/v8"
 	"github.com/gin-gonic/gin"
 )
 
+// ErrCodeTenantPendingDeletion is returned in the "code" field for requests
+// to a tenant that is suspended pending deletion
+const ErrCodeTenantPendingDeletion = "tenant_pending_deletion"
+
+// ErrCodeMaintenanceMode is returned in the "code" field for non-GET tenant
+// requests made during maintenance
+const ErrCodeMaintenanceMode = "maintenance_mode"
+
+// TenantTimezoneHeader carries the tenant's display timezone on responses to
+// tenant requests; timestamps in bodies are always RFC3339 UTC
+const TenantTimezoneHeader = "X-Tenant-Timezone"
+
+// TenantStatusChecker reports when a suspended tenant is due to be deleted,
+// or nil for tenants in good standing
+type TenantStatusChecker interface {
+	DeletionScheduledAt(ctx context.Context, tenantID string) (*time.Time, error)
+}
+
+// TenantTimezoneLookup returns the IANA timezone a tenant displays times in
+type TenantTimezoneLookup interface {
+	TenantTimezone(ctx context.Context, tenantID string) (string, error)
+}
+
+// MaintenanceChecker reports whether the service is in maintenance mode, and
+// how long clients should wait before retrying
+type MaintenanceChecker interface {
+	MaintenanceMode(ctx context.Context) (bool, time.Duration)
+}
+
+var (
+	defaultStatusChecker      TenantStatusChecker
+	defaultTimezoneLookup     TenantTimezoneLookup
+	defaultMaintenanceChecker MaintenanceChecker
+)
+
+// SetTenantStatusChecker sets the checker used by DefaultTenantMiddlewareConfig
+func SetTenantStatusChecker(checker TenantStatusChecker) {
+	defaultStatusChecker = checker
+}
+
+// SetTenantTimezoneLookup sets the lookup used by DefaultTenantMiddlewareConfig
+func SetTenantTimezoneLookup(lookup TenantTimezoneLookup) {
+	defaultTimezoneLookup = lookup
+}
+
+// SetMaintenanceChecker sets the checker used by DefaultTenantMiddlewareConfig
+func SetMaintenanceChecker(checker MaintenanceChecker) {
+	defaultMaintenanceChecker = checker
+}
+
 // TenantMiddlewareConfig holds configuration for tenant resolution
 type TenantMiddlewareConfig struct {
 	// HeaderName is the HTTP header to extract tenant from (e.g., "X-Tenant-ID")
 	HeaderName string
 	// SkipPaths are paths that don't require tenant context
 	SkipPaths []string
+	// StatusChecker blocks tenants pending deletion when set
+	StatusChecker TenantStatusChecker
+	// SuspendedAllowPaths remain reachable for tenants pending deletion
+	SuspendedAllowPaths []string
+	// TimezoneLookup sets TenantTimezoneHeader on responses when set
+	TimezoneLookup TenantTimezoneLookup
+	// MaintenanceChecker rejects non-GET requests during maintenance when set
+	MaintenanceChecker MaintenanceChecker
 }
 
 // DefaultTenantMiddlewareConfig returns the default configuration
@@ -25,6 +87,13 @@ func DefaultTenantMiddlewareConfig() *TenantMiddlewareConfig {
 			"/api/v1/users/",
 			"/health",
 		},
+		StatusChecker: defaultStatusChecker,
+		SuspendedAllowPaths: []string{
+			"/api/v1/tenant/restore",
+			"/api/v1/tenant/export",
+		},
+		TimezoneLookup:     defaultTimezoneLookup,
+		MaintenanceChecker: defaultMaintenanceChecker,
 	}
 }
 
@@ -45,6 +114,13 @@ func TenantFromHeaderMiddleware(cfg *TenantMiddlewareConfig) gin.HandlerFunc {
 			tenantID = c.Query("tenant")
 		}
 
+		if tenantID == "" {
+			// Use the tenant resolved from the request host, if any
+			if hostTenant, ok := GetTenantIDFromContext(c); ok {
+				tenantID = hostTenant
+			}
+		}
+
 		if tenantID == "" {
 			// Try to get from JWT claims
 			if schema, ok := GetTenantSchemaFromContext(c); ok && schema != "" {
@@ -65,12 +141,131 @@ func TenantFromHeaderMiddleware(cfg *TenantMiddlewareConfig) gin.HandlerFunc {
 			return
 		}
 
+		if cfg.StatusChecker != nil && !hasAnyPrefix(c.Request.URL.Path, cfg.SuspendedAllowPaths) {
+			deletionAt, err := cfg.StatusChecker.DeletionScheduledAt(c.Request.Context(), tenantID)
+			if err != nil {
+				slog.Error("Failed to check tenant status", "tenant", tenantID, "error", err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to check tenant status"})
+				c.Abort()
+				return
+			}
+			if deletionAt != nil {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":               "tenant is scheduled for deletion",
+					"code":                ErrCodeTenantPendingDeletion,
+					"deletionScheduledAt": deletionAt,
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		if rejectDuringMaintenance(c, cfg.MaintenanceChecker) {
+			c.Abort()
+			return
+		}
+
+		if cfg.TimezoneLookup != nil {
+			// The header is informational, so lookup failures don't fail the request
+			timezone, err := cfg.TimezoneLookup.TenantTimezone(c.Request.Context(), tenantID)
+			if err != nil {
+				slog.Warn("Failed to look up tenant timezone", "tenant", tenantID, "error", err)
+			} else {
+				c.Header(TenantTimezoneHeader, timezone)
+			}
+		}
+
 		slog.Debug("Tenant context set", "tenant", tenantID)
 		c.Set("tenantID", tenantID)
 		c.Next()
 	}
 }
 
+// MaintenanceMiddleware rejects non-GET requests during maintenance, for
+// tenant routes that take the tenant from the token rather than through
+// TenantFromHeaderMiddleware
+func MaintenanceMiddleware() gin.HandlerFunc {
+	checker := defaultMaintenanceChecker
+	return func(c *gin.Context) {
+		if rejectDuringMaintenance(c, checker) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// rejectDuringMaintenance sends 503 with Retry-After to non-GET requests
+// while checker reports maintenance, reporting whether it did
+func rejectDuringMaintenance(c *gin.Context, checker MaintenanceChecker) bool {
+	if checker == nil || isReadOnlyMethod(c.Request.Method) {
+		return false
+	}
+	active, retryAfter := checker.MaintenanceMode(c.Request.Context())
+	if !active {
+		return false
+	}
+	seconds := int(retryAfter.Seconds())
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error":      "the service is under maintenance, please try again in a few minutes",
+		"code":       ErrCodeMaintenanceMode,
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// isReadOnlyMethod reports whether requests with method don't change state
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostTenantResolver resolves the tenant owning a request host
+type HostTenantResolver interface {
+	IsAppHost(host string) bool
+	ResolveTenant(ctx context.Context, host string) (string, error)
+}
+
+// TenantFromHostMiddleware resolves the tenant from the Host header (a
+// verified custom domain or a subdomain of the site base domain) and sets the
+// tenantID context key. Requests to the app's own hosts pass through without a
+// tenant; unknown hosts get a 404.
+func TenantFromHostMiddleware(resolver HostTenantResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if resolver.IsAppHost(c.Request.Host) {
+			c.Next()
+			return
+		}
+
+		tenantID, err := resolver.ResolveTenant(c.Request.Context(), c.Request.Host)
+		if err != nil {
+			slog.Error("Failed to resolve tenant from host", "host", c.Request.Host, "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to resolve host"})
+			c.Abort()
+			return
+		}
+
+		if tenantID == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "unknown host"})
+			c.Abort()
+			return
+		}
+
+		slog.Debug("Tenant context set from host", "host", c.Request.Host, "tenant", tenantID)
+		c.Set("tenantID", tenantID)
+		c.Next()
+	}
+}
+
 // GetTenantIDFromContext retrieves the tenant ID from the Gin context
 func GetTenantIDFromContext(c *gin.Context) (string, bool) {
 	tenantID, exists := c.Get("tenantID")
@@ -80,6 +275,18 @@ func GetTenantIDFromContext(c *gin.Context) (string, bool) {
 	return tenantID.(string), true
 }
 
+// IdempotencyScope returns the owner of a request's idempotency keys: the
+// tenant for tenant routes, otherwise the authenticated user
+func IdempotencyScope(c *gin.Context) string {
+	if tenantID, ok := GetTenantIDFromContext(c); ok && tenantID != "" {
+		return "tenant:" + tenantID
+	}
+	if userID, ok := GetUserIDFromContext(c); ok {
+		return "user:" + strconv.FormatUint(uint64(userID), 10)
+	}
+	return ""
+}
+
 // validateTenantID validates the tenant ID format
 func validateTenantID(tenantID string) error {
 	if len(tenantID) < 3 {
@@ -116,3 +323,87 @@ func (e *TenantError) Error() string {
 func GormMultitenancyMiddleware() gin.HandlerFunc {
 	return ginmw.WithTenant(ginmw.DefaultWithTenantConfig)
 }
+
+// PublicAPIPrefix is the path prefix of the public content API, called by
+// published sites from their own domains
+const PublicAPIPrefix = "/api/public/"
+
+// ErrCodeTenantMismatch is returned in the "code" field for public API
+// requests whose origin and host belong to different tenants
+const ErrCodeTenantMismatch = "tenant_mismatch"
+
+// OriginTenantResolver resolves the tenant whose site is served on a CORS
+// origin, implemented by sites.Resolver
+type OriginTenantResolver interface {
+	ResolveOrigin(ctx context.Context, origin string) (string, error)
+}
+
+// AllowTenantOrigin returns a CORS origin check allowing the sites of
+// tenants to call the public content API. Origins are only allowed for
+// paths under PublicAPIPrefix; the tenant is stored for
+// TenantFromOriginMiddleware.
+func AllowTenantOrigin(resolver OriginTenantResolver) func(c *gin.Context, origin string) bool {
+	return func(c *gin.Context, origin string) bool {
+		if !strings.HasPrefix(c.Request.URL.Path, PublicAPIPrefix) {
+			return false
+		}
+		tenantID, err := resolver.ResolveOrigin(c.Request.Context(), origin)
+		if err != nil {
+			slog.Error("Failed to resolve tenant from origin", "origin", origin, "error", err)
+			return false
+		}
+		if tenantID == "" {
+			return false
+		}
+		c.Set("originTenantID", tenantID)
+		return true
+	}
+}
+
+// TenantFromOriginMiddleware sets the tenantID context key for the public
+// content API from the request's origin, as allowed by AllowTenantOrigin,
+// or else from its host. Origins and hosts of different tenants get a 403,
+// as do tenants pending deletion; requests with neither get a 400.
+func TenantFromOriginMiddleware() gin.HandlerFunc {
+	statusChecker := defaultStatusChecker
+	maintenanceChecker := defaultMaintenanceChecker
+	return func(c *gin.Context) {
+		hostTenant, _ := GetTenantIDFromContext(c)
+		tenantID := hostTenant
+		if v, ok := c.Get("originTenantID"); ok {
+			tenantID = v.(string)
+			if hostTenant != "" && hostTenant != tenantID {
+				c.JSON(http.StatusForbidden, gin.H{"error": "origin and host belong to different sites", "code": ErrCodeTenantMismatch})
+				c.Abort()
+				return
+			}
+		}
+		if tenantID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "site could not be determined from the origin or host"})
+			c.Abort()
+			return
+		}
+
+		if statusChecker != nil {
+			deletionAt, err := statusChecker.DeletionScheduledAt(c.Request.Context(), tenantID)
+			if err != nil {
+				slog.Error("Failed to check tenant status", "tenant", tenantID, "error", err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to check tenant status"})
+				c.Abort()
+				return
+			}
+			if deletionAt != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "tenant is scheduled for deletion", "code": ErrCodeTenantPendingDeletion})
+				c.Abort()
+				return
+			}
+		}
+		if rejectDuringMaintenance(c, maintenanceChecker) {
+			c.Abort()
+			return
+		}
+
+		c.Set("tenantID", tenantID)
+		c.Next()
+	}
+}
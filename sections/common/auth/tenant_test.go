@@ -0,0 +1,203 @@
+package auth_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// timezones looks up timezones by tenant, failing for tenants without one
+type timezones map[string]string
+
+func (z timezones) TenantTimezone(ctx context.Context, tenantID string) (string, error) {
+	tz, ok := z[tenantID]
+	if !ok {
+		return "", errors.New("lookup failed")
+	}
+	return tz, nil
+}
+
+func TestTenantTimezoneHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lookup := timezones{"tenant_berlin": "Europe/Berlin", "tenant_utc": "UTC"}
+
+	tests := []struct {
+		name   string
+		tenant string
+		lookup auth.TenantTimezoneLookup
+		want   string
+	}{
+		{"tenant timezone", "tenant_berlin", lookup, "Europe/Berlin"},
+		{"default timezone", "tenant_utc", lookup, "UTC"},
+		// Lookup failures leave the header out but don't fail the request
+		{"lookup failure", "tenant_unknown", lookup, ""},
+		{"no lookup", "tenant_berlin", nil, ""},
+	}
+	for _, tt := range tests {
+		cfg := &auth.TenantMiddlewareConfig{HeaderName: "X-Tenant-ID", TimezoneLookup: tt.lookup}
+		r := gin.New()
+		r.GET("/", auth.TenantFromHeaderMiddleware(cfg), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Tenant-ID", tt.tenant)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", tt.name, w.Code)
+		}
+		got, ok := w.Header()[auth.TenantTimezoneHeader]
+		if tt.want == "" && ok {
+			t.Errorf("%s: %s = %q, want no header", tt.name, auth.TenantTimezoneHeader, got)
+		}
+		if tt.want != "" && w.Header().Get(auth.TenantTimezoneHeader) != tt.want {
+			t.Errorf("%s: %s = %q, want %q", tt.name, auth.TenantTimezoneHeader, got, tt.want)
+		}
+	}
+}
+
+// maintenance is a MaintenanceChecker with a fixed answer
+type maintenance bool
+
+func (m maintenance) MaintenanceMode(ctx context.Context) (bool, time.Duration) {
+	return bool(m), 2 * time.Minute
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		active  bool
+		method  string
+		want    int
+		wantErr bool
+	}{
+		{"read during maintenance", true, http.MethodGet, http.StatusOK, false},
+		{"head during maintenance", true, http.MethodHead, http.StatusOK, false},
+		{"write during maintenance", true, http.MethodPost, http.StatusServiceUnavailable, true},
+		{"delete during maintenance", true, http.MethodDelete, http.StatusServiceUnavailable, true},
+		{"write", false, http.MethodPost, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		cfg := &auth.TenantMiddlewareConfig{HeaderName: "X-Tenant-ID", MaintenanceChecker: maintenance(tt.active)}
+		r := gin.New()
+		r.Any("/", auth.TenantFromHeaderMiddleware(cfg), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(tt.method, "/", nil)
+		req.Header.Set("X-Tenant-ID", "tenant_a")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if !tt.wantErr {
+			continue
+		}
+		if got := w.Header().Get("Retry-After"); got != "120" {
+			t.Errorf("%s: Retry-After = %q, want 120", tt.name, got)
+		}
+		var body struct {
+			Code       string `json:"code"`
+			RetryAfter int    `json:"retryAfter"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != auth.ErrCodeMaintenanceMode || body.RetryAfter != 120 {
+			t.Errorf("%s: body = %s, want the maintenance error", tt.name, w.Body)
+		}
+	}
+}
+
+// origins resolves CORS origins to tenants, failing for broken.example
+type origins map[string]string
+
+func (o origins) ResolveOrigin(ctx context.Context, origin string) (string, error) {
+	if origin == "https://broken.example" {
+		return "", errors.New("lookup failed")
+	}
+	return o[origin], nil
+}
+
+func TestPublicAPITenantOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := cors.DefaultConfig()
+	config.AllowOrigins = []string{"https://app.awning.test"}
+	config.AllowOriginWithContextFunc = auth.AllowTenantOrigin(origins{
+		"https://bakery.example":  "tenant_bakery",
+		"https://florist.example": "tenant_florist",
+	})
+	r := gin.New()
+	r.Use(cors.New(config))
+	// Stands in for the site host middleware
+	r.Use(func(c *gin.Context) {
+		if tenant := c.GetHeader("X-Host-Tenant"); tenant != "" {
+			c.Set("tenantID", tenant)
+		}
+	})
+	handler := func(c *gin.Context) {
+		tenantID, _ := auth.GetTenantIDFromContext(c)
+		c.String(http.StatusOK, tenantID)
+	}
+	r.POST("/api/public/v1/contact", auth.TenantFromOriginMiddleware(), handler)
+	r.POST("/api/v1/chat/complete", handler)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		origin     string
+		hostTenant string
+		status     int
+		allowed    bool
+		tenant     string
+	}{
+		{"preflight from a tenant site", http.MethodOptions, "/api/public/v1/contact", "https://bakery.example", "", http.StatusNoContent, true, ""},
+		{"preflight from the app", http.MethodOptions, "/api/public/v1/contact", "https://app.awning.test", "", http.StatusNoContent, true, ""},
+		{"preflight from an unknown site", http.MethodOptions, "/api/public/v1/contact", "https://unknown.example", "", http.StatusForbidden, false, ""},
+		{"preflight when the lookup fails", http.MethodOptions, "/api/public/v1/contact", "https://broken.example", "", http.StatusForbidden, false, ""},
+		{"tenant site outside the public API", http.MethodOptions, "/api/v1/chat/complete", "https://bakery.example", "", http.StatusForbidden, false, ""},
+		{"submission from a tenant site", http.MethodPost, "/api/public/v1/contact", "https://bakery.example", "", http.StatusOK, true, "tenant_bakery"},
+		{"submission on the site's own host", http.MethodPost, "/api/public/v1/contact", "https://bakery.example", "tenant_bakery", http.StatusOK, true, "tenant_bakery"},
+		{"submission without an origin", http.MethodPost, "/api/public/v1/contact", "", "tenant_florist", http.StatusOK, false, "tenant_florist"},
+		{"submission from another tenant's site", http.MethodPost, "/api/public/v1/contact", "https://bakery.example", "tenant_florist", http.StatusForbidden, true, ""},
+		{"submission from nowhere", http.MethodPost, "/api/public/v1/contact", "", "", http.StatusBadRequest, false, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		if tt.hostTenant != "" {
+			req.Header.Set("X-Host-Tenant", tt.hostTenant)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+		if allowed := w.Header().Get("Access-Control-Allow-Origin") == tt.origin && tt.origin != ""; allowed != tt.allowed {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want allowed %v", tt.name, w.Header().Get("Access-Control-Allow-Origin"), tt.allowed)
+		}
+		if tt.status == http.StatusOK && w.Body.String() != tt.tenant {
+			t.Errorf("%s: tenant = %q, want %q", tt.name, w.Body, tt.tenant)
+		}
+	}
+}
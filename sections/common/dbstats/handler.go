@@ -0,0 +1,51 @@
+// Package dbstats reports the database connection pool to admins
+package dbstats
+
+import (
+	"log/slog"
+	"net/http"
+
+	"awning-backend/db"
+	"awning-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves database statistics
+type Handler struct {
+	logger *slog.Logger
+	db     *db.DB
+}
+
+// NewHandler creates a new database statistics handler
+func NewHandler(database *db.DB) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "DBStatsHandler"),
+		db:     database,
+	}
+}
+
+// GetStats returns this instance's connection pool statistics: connections
+// in use and idle, and how often and how long requests waited for one
+func (h *Handler) GetStats(c *gin.Context) {
+	stats, err := h.db.Stats()
+	if err != nil {
+		h.logger.Error("Failed to read pool stats", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read database stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pool": stats})
+}
+
+// RegisterRoutes registers the admin database statistics route,
+// authenticated with the server API key
+func RegisterRoutes(r *gin.RouterGroup, database *db.DB, apiKey, apiKeySecret string) {
+	handler := NewHandler(database)
+
+	adminRoutes := r.Group("/api/v1/admin/db")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret)))
+	{
+		adminRoutes.GET("/stats", handler.GetStats)
+	}
+}
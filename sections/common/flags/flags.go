@@ -0,0 +1,203 @@
+// Package flags switches features off at runtime, during incidents, without
+// a redeploy. Each flag has a built-in default, which config can change, and
+// an optional override stored in Redis, which wins over both.
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Flag names, kept in sync with common.KnownFeatureFlags
+const (
+	// MaintenanceMode rejects every non-GET tenant request
+	MaintenanceMode = "maintenance_mode"
+
+	ChatGeneration     = "chat_generation"
+	ImageProcessing    = "image_processing"
+	DomainRegistration = "domain_registration"
+	Checkout           = "checkout"
+)
+
+// builtinDefaults holds every flag with its value when neither config nor an
+// override sets it
+var builtinDefaults = map[string]bool{
+	MaintenanceMode:    false,
+	ChatGeneration:     true,
+	ImageProcessing:    true,
+	DomainRegistration: true,
+	Checkout:           true,
+}
+
+// CacheTTL bounds how long an instance uses overrides read from Redis, so
+// changes made through another instance apply within it
+const CacheTTL = 5 * time.Second
+
+// Store keeps the flag overrides, implemented by storage.RedisClient
+type Store interface {
+	GetFeatureFlags(ctx context.Context) (map[string]bool, error)
+	UpdateFeatureFlags(ctx context.Context, set map[string]bool, clear []string) error
+}
+
+// ErrUnknownFlag is returned for names that aren't a known flag
+var ErrUnknownFlag = errors.New("unknown flag")
+
+// Flag is a flag's effective value
+type Flag struct {
+	Name       string `json:"name"`
+	Enabled    bool   `json:"enabled"`
+	Default    bool   `json:"default"`
+	Overridden bool   `json:"overridden"`
+}
+
+// Flags reads flags and their overrides, caching the overrides in memory
+type Flags struct {
+	logger     *slog.Logger
+	store      Store
+	defaults   map[string]bool
+	retryAfter time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	overrides map[string]bool
+	loadedAt  time.Time
+}
+
+// New creates the flags with config's defaults over the built-in ones.
+// retryAfter is sent to clients turned away by a switched off feature.
+func New(store Store, configured map[string]bool, retryAfter time.Duration) *Flags {
+	defaults := make(map[string]bool, len(builtinDefaults))
+	for name, enabled := range builtinDefaults {
+		defaults[name] = enabled
+	}
+	for name, enabled := range configured {
+		if _, ok := defaults[name]; ok {
+			defaults[name] = enabled
+		}
+	}
+	return &Flags{
+		logger:     slog.With("service", "FeatureFlags"),
+		store:      store,
+		defaults:   defaults,
+		retryAfter: retryAfter,
+		now:        time.Now,
+	}
+}
+
+// Names returns the known flag names, sorted
+func Names() []string {
+	names := make([]string, 0, len(builtinDefaults))
+	for name := range builtinDefaults {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// IsKnown reports whether name is a known flag
+func IsKnown(name string) bool {
+	_, ok := builtinDefaults[name]
+	return ok
+}
+
+// loadOverrides returns the overrides, reading Redis when the cached copy is
+// older than CacheTTL. When Redis fails the previous overrides are kept
+// until the next attempt, CacheTTL later.
+func (f *Flags) loadOverrides(ctx context.Context) map[string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.overrides != nil && f.now().Sub(f.loadedAt) < CacheTTL {
+		return f.overrides
+	}
+
+	overrides, err := f.store.GetFeatureFlags(ctx)
+	if err != nil {
+		f.logger.Error("Failed to load flag overrides", "error", err)
+		if f.overrides == nil {
+			f.overrides = map[string]bool{}
+		}
+	} else {
+		f.overrides = overrides
+	}
+	f.loadedAt = f.now()
+	return f.overrides
+}
+
+// Enabled reports whether the flag is on: its override when one is stored,
+// else its default. Unknown flags are off.
+func (f *Flags) Enabled(ctx context.Context, name string) bool {
+	def, ok := f.defaults[name]
+	if !ok {
+		return false
+	}
+	if enabled, ok := f.loadOverrides(ctx)[name]; ok {
+		return enabled
+	}
+	return def
+}
+
+// MaintenanceMode implements auth.MaintenanceChecker
+func (f *Flags) MaintenanceMode(ctx context.Context) (bool, time.Duration) {
+	return f.Enabled(ctx, MaintenanceMode), f.retryAfter
+}
+
+// RetryAfter is how long clients turned away by a switched off feature are
+// told to wait
+func (f *Flags) RetryAfter() time.Duration {
+	return f.retryAfter
+}
+
+// All returns every flag with its effective value, sorted by name
+func (f *Flags) All(ctx context.Context) []Flag {
+	overrides := f.loadOverrides(ctx)
+	all := make([]Flag, 0, len(f.defaults))
+	for _, name := range Names() {
+		flag := Flag{Name: name, Enabled: f.defaults[name], Default: f.defaults[name]}
+		if enabled, ok := overrides[name]; ok {
+			flag.Enabled = enabled
+			flag.Overridden = true
+		}
+		all = append(all, flag)
+	}
+	return all
+}
+
+// Set applies changes to the overrides: a value overrides the flag and nil
+// removes its override. It returns the overrides before and after the
+// change.
+func (f *Flags) Set(ctx context.Context, changes map[string]*bool) (before, after map[string]bool, err error) {
+	set := map[string]bool{}
+	var clear []string
+	for name, enabled := range changes {
+		if !IsKnown(name) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownFlag, name)
+		}
+		if enabled == nil {
+			clear = append(clear, name)
+		} else {
+			set[name] = *enabled
+		}
+	}
+
+	if before, err = f.store.GetFeatureFlags(ctx); err != nil {
+		return nil, nil, err
+	}
+	if err := f.store.UpdateFeatureFlags(ctx, set, clear); err != nil {
+		return nil, nil, err
+	}
+	if after, err = f.store.GetFeatureFlags(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	f.mu.Lock()
+	f.overrides = after
+	f.loadedAt = f.now()
+	f.mu.Unlock()
+	return before, after, nil
+}
@@ -0,0 +1,213 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStore keeps overrides in memory, failing reads while err is set
+type memoryStore struct {
+	mu        sync.Mutex
+	overrides map[string]bool
+	reads     int
+	err       error
+}
+
+func (s *memoryStore) GetFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	if s.err != nil {
+		return nil, s.err
+	}
+	copied := make(map[string]bool, len(s.overrides))
+	for name, enabled := range s.overrides {
+		copied[name] = enabled
+	}
+	return copied, nil
+}
+
+func (s *memoryStore) UpdateFeatureFlags(ctx context.Context, set map[string]bool, clear []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.overrides == nil {
+		s.overrides = map[string]bool{}
+	}
+	for name, enabled := range set {
+		s.overrides[name] = enabled
+	}
+	for _, name := range clear {
+		delete(s.overrides, name)
+	}
+	return nil
+}
+
+// newTestFlags returns flags over store whose time is *now
+func newTestFlags(store Store, configured map[string]bool, now *time.Time) *Flags {
+	f := New(store, configured, 30*time.Second)
+	f.now = func() time.Time { return *now }
+	return f
+}
+
+func TestEnabledPrecedence(t *testing.T) {
+	store := &memoryStore{overrides: map[string]bool{ChatGeneration: false, Checkout: true}}
+	configured := map[string]bool{Checkout: false, DomainRegistration: false, "not_a_flag": true}
+	now := time.Now()
+	f := newTestFlags(store, configured, &now)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{MaintenanceMode, false},    // built-in default
+		{ImageProcessing, true},     // built-in default
+		{DomainRegistration, false}, // config over the built-in default
+		{ChatGeneration, false},     // override over the built-in default
+		{Checkout, true},            // override over config
+		{"not_a_flag", false},       // unknown, even when configured
+	}
+	for _, tt := range tests {
+		if got := f.Enabled(ctx, tt.name); got != tt.want {
+			t.Errorf("Enabled(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnabledCachesOverrides(t *testing.T) {
+	store := &memoryStore{}
+	now := time.Now()
+	f := newTestFlags(store, nil, &now)
+	ctx := context.Background()
+
+	if !f.Enabled(ctx, ChatGeneration) {
+		t.Fatal("Enabled(chat_generation) = false without overrides")
+	}
+
+	// Another instance switches the flag off; this one sees it after CacheTTL
+	store.UpdateFeatureFlags(ctx, map[string]bool{ChatGeneration: false}, nil)
+	now = now.Add(CacheTTL - time.Millisecond)
+	if !f.Enabled(ctx, ChatGeneration) || store.reads != 1 {
+		t.Errorf("Enabled() within CacheTTL read the store %d times, want the cached overrides", store.reads)
+	}
+	now = now.Add(time.Millisecond)
+	if f.Enabled(ctx, ChatGeneration) {
+		t.Error("Enabled(chat_generation) = true after CacheTTL, want the new override")
+	}
+
+	// Failed reads keep the previous overrides
+	store.err = errors.New("redis down")
+	now = now.Add(CacheTTL)
+	if f.Enabled(ctx, ChatGeneration) {
+		t.Error("Enabled(chat_generation) = true after a failed read, want the previous override")
+	}
+}
+
+func TestEnabledStoreDown(t *testing.T) {
+	now := time.Now()
+	f := newTestFlags(&memoryStore{err: errors.New("redis down")}, map[string]bool{Checkout: false}, &now)
+
+	if !f.Enabled(context.Background(), ChatGeneration) || f.Enabled(context.Background(), Checkout) {
+		t.Error("Enabled() without a store = overrides, want the defaults")
+	}
+}
+
+func TestSet(t *testing.T) {
+	store := &memoryStore{overrides: map[string]bool{Checkout: false}}
+	now := time.Now()
+	f := newTestFlags(store, nil, &now)
+	ctx := context.Background()
+	f.Enabled(ctx, Checkout) // cache the overrides
+
+	off := false
+	before, after, err := f.Set(ctx, map[string]*bool{MaintenanceMode: &off, Checkout: nil})
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if len(before) != 1 || before[Checkout] || len(after) != 1 || after[MaintenanceMode] {
+		t.Errorf("Set() = %v, %v; want the override moved from checkout to maintenance_mode", before, after)
+	}
+
+	// The change applies at once, without waiting for CacheTTL
+	if !f.Enabled(ctx, Checkout) {
+		t.Error("Enabled(checkout) = false after its override was removed")
+	}
+	for _, flag := range f.All(ctx) {
+		if want := flag.Name == MaintenanceMode; flag.Overridden != want {
+			t.Errorf("All(): %s overridden = %v, want %v", flag.Name, flag.Overridden, want)
+		}
+	}
+
+	if _, _, err := f.Set(ctx, map[string]*bool{"not_a_flag": &off}); !errors.Is(err, ErrUnknownFlag) {
+		t.Errorf("Set() of an unknown flag error = %v, want ErrUnknownFlag", err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &memoryStore{overrides: map[string]bool{DomainRegistration: false}}
+	now := time.Now()
+	f := newTestFlags(store, nil, &now)
+
+	r := gin.New()
+	r.POST("/register", f.Require(DomainRegistration), func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.POST("/checkout", f.Require(Checkout), func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/register", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "30" {
+		t.Fatalf("switched off route = %d with Retry-After %q, want 503 with 30", w.Code, w.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error      string `json:"error"`
+		Code       string `json:"code"`
+		Feature    string `json:"feature"`
+		RetryAfter int    `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != ErrCodeFeatureDisabled || body.Feature != DomainRegistration || body.RetryAfter != 30 || !strings.HasPrefix(body.Error, "domain registration") {
+		t.Errorf("switched off route body = %+v", body)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/checkout", nil))
+	if w.Code != http.StatusCreated {
+		t.Errorf("enabled route = %d, want 201", w.Code)
+	}
+}
+
+func TestUpdateFlagsRejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Now()
+	h := NewHandler(newTestFlags(&memoryStore{}, nil, &now), nil)
+	r := gin.New()
+	r.PUT("/flags", h.UpdateFlags)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no flags", `{}`, http.StatusBadRequest},
+		{"empty flags", `{"flags": {}}`, http.StatusBadRequest},
+		{"unknown flag", `{"flags": {"not_a_flag": false}}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/flags", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: UpdateFlags() = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package flags
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"awning-backend/db"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
+	"awning-backend/sections/common/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrCodeFeatureDisabled is returned in the "code" field for requests to a
+// switched off feature
+const ErrCodeFeatureDisabled = "feature_disabled"
+
+// featureNames names the gated features in error messages
+var featureNames = map[string]string{
+	ChatGeneration:     "site generation",
+	ImageProcessing:    "image processing",
+	DomainRegistration: "domain registration",
+	Checkout:           "checkout",
+}
+
+// DisabledError is the error body for requests to a switched off feature
+func (f *Flags) DisabledError(name string) gin.H {
+	feature, ok := featureNames[name]
+	if !ok {
+		feature = name
+	}
+	return gin.H{
+		"error":      feature + " is temporarily unavailable for maintenance, please try again later",
+		"code":       ErrCodeFeatureDisabled,
+		"feature":    name,
+		"retryAfter": int(f.retryAfter.Seconds()),
+	}
+}
+
+// RespondDisabled sends 503 with Retry-After for a switched off feature
+func (f *Flags) RespondDisabled(c *gin.Context, name string) {
+	c.Header("Retry-After", strconv.Itoa(int(f.retryAfter.Seconds())))
+	c.JSON(http.StatusServiceUnavailable, i18n.Localize(c, f.DisabledError(name)))
+}
+
+// Require returns middleware rejecting requests while the flag is off
+func (f *Flags) Require(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !f.Enabled(c.Request.Context(), name) {
+			f.RespondDisabled(c, name)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Handler serves the flags to admins
+type Handler struct {
+	logger *slog.Logger
+	flags  *Flags
+	db     *db.DB
+}
+
+// NewHandler creates a new flags handler; changes are audited to database
+func NewHandler(flags *Flags, database *db.DB) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "FlagsHandler"),
+		flags:  flags,
+		db:     database,
+	}
+}
+
+// UpdateFlagsRequest overrides flags by name; a null value removes the
+// override, restoring the default
+type UpdateFlagsRequest struct {
+	Flags map[string]*bool `json:"flags" binding:"required"`
+}
+
+// ListFlags returns every flag with its effective value
+func (h *Handler) ListFlags(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"flags": h.flags.All(c.Request.Context())})
+}
+
+// UpdateFlags sets or removes flag overrides and audits the change
+func (h *Handler) UpdateFlags(c *gin.Context) {
+	var req UpdateFlagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Flags) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "flags must not be empty"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, after, err := h.flags.Set(ctx, req.Flags)
+	if errors.Is(err, ErrUnknownFlag) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      err.Error(),
+			"code":       "unknown_flag",
+			"validFlags": Names(),
+		})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to update flags", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update flags"})
+		return
+	}
+
+	changes := map[string]gin.H{}
+	for name := range req.Flags {
+		from, hadFrom := before[name]
+		to, hasTo := after[name]
+		if hadFrom == hasTo && from == to {
+			continue
+		}
+		changes[name] = gin.H{"from": overrideValue(from, hadFrom), "to": overrideValue(to, hasTo)}
+	}
+	if len(changes) > 0 {
+		audit.Record(ctx, h.db, "", nil, audit.ActionFlagsUpdated, map[string]any{
+			"changes": changes,
+			"ip":      middleware.ClientIP(c),
+		})
+		h.logger.Info("Flags updated", "changes", changes)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"flags": h.flags.All(ctx)})
+}
+
+// overrideValue is the audited value of an override, nil when there is none
+func overrideValue(enabled, ok bool) *bool {
+	if !ok {
+		return nil
+	}
+	return &enabled
+}
+
+// RegisterRoutes registers the admin flag routes, authenticated with the
+// server API key
+func RegisterRoutes(r *gin.RouterGroup, flags *Flags, database *db.DB, apiKey, apiKeySecret string) {
+	handler := NewHandler(flags, database)
+
+	adminRoutes := r.Group("/api/v1/admin/flags")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret)))
+	{
+		adminRoutes.GET("", handler.ListFlags)
+		adminRoutes.PUT("", handler.UpdateFlags)
+	}
+}
@@ -0,0 +1,86 @@
+package keyreaper
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"awning-backend/jobs"
+	"awning-backend/middleware"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the admin reaper routes
+type Handler struct {
+	logger *slog.Logger
+	redis  *storage.RedisClient
+	queue  *jobs.Queue
+}
+
+// NewHandler creates a new reaper handler
+func NewHandler(redisClient *storage.RedisClient, queue *jobs.Queue) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "KeyReaperHandler"),
+		redis:  redisClient,
+		queue:  queue,
+	}
+}
+
+// StartRun handles POST /api/v1/admin/redis/reaper/run, queueing a reaper
+// run. With ?dry_run=true it only reports what it would remove.
+func (h *Handler) StartRun(c *gin.Context) {
+	dryRun := false
+	if v := c.Query("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+			return
+		}
+	}
+	if h.queue == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "background jobs unavailable"})
+		return
+	}
+
+	if err := h.queue.Enqueue(c.Request.Context(), jobs.New(JobKindReap, reapPayload{DryRun: dryRun}, 0)); err != nil {
+		h.logger.Error("Failed to enqueue reaper run", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start reaper run"})
+		return
+	}
+
+	h.logger.Info("Reaper run queued", "dry_run", dryRun)
+	c.JSON(http.StatusAccepted, gin.H{"status": "queued", "dryRun": dryRun})
+}
+
+// GetReport handles GET /api/v1/admin/redis/reaper/report, returning the
+// report of the latest run
+func (h *Handler) GetReport(c *gin.Context) {
+	report, err := h.redis.GetReaperReport(c.Request.Context())
+	if errors.Is(err, storage.ErrReaperReportNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get reaper report", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get reaper report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RegisterRoutes registers the admin reaper routes, authenticated with the
+// server API key
+func RegisterRoutes(r *gin.RouterGroup, redisClient *storage.RedisClient, queue *jobs.Queue, apiKey, apiKeySecret string) {
+	handler := NewHandler(redisClient, queue)
+
+	adminRoutes := r.Group("/api/v1/admin/redis/reaper")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret)))
+	{
+		adminRoutes.POST("/run", handler.StartRun)
+		adminRoutes.GET("/report", handler.GetReport)
+	}
+}
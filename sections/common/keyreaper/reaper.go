@@ -0,0 +1,289 @@
+// Package keyreaper removes orphaned Redis keys: sessions of deleted or
+// inactive users, cache entries of deleted tenants, and keys that should
+// expire but were written without a TTL
+package keyreaper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JobKindReap runs the reaper over every namespace with a policy
+const JobKindReap = "redis.reap_keys"
+
+// reapPayload is the payload of JobKindReap. Periodic runs have none.
+type reapPayload struct {
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// Hooks observe reaper runs. Each hook is optional.
+type Hooks struct {
+	// NamespaceReaped is called with the counts of each namespace reaped
+	NamespaceReaped func(report storage.ReaperNamespaceReport, dryRun bool)
+}
+
+// Reaper scans Redis key namespaces and applies their policies. Scans fetch
+// a batch of keys at a time and pause between batches to keep the load on
+// Redis low.
+type Reaper struct {
+	logger   *slog.Logger
+	redis    *storage.RedisClient
+	db       *db.DB
+	policies map[string]common.RedisKeyPolicy
+	dryRun   bool
+	count    int64
+	pause    time.Duration
+	hooks    Hooks
+	now      func() time.Time
+
+	mu sync.Mutex // one run at a time per instance
+}
+
+// NewReaper creates a reaper with the policies and scan settings of cfg.
+// With redis_reaper_dry_run set, every run is a dry run.
+func NewReaper(cfg *common.Config, redisClient *storage.RedisClient, database *db.DB) *Reaper {
+	return &Reaper{
+		logger:   slog.With("service", "RedisKeyReaper"),
+		redis:    redisClient,
+		db:       database,
+		policies: cfg.ReaperPolicies(),
+		dryRun:   cfg.RedisReaperDryRun,
+		count:    int64(cfg.RedisReaperScanCount),
+		pause:    time.Duration(cfg.RedisReaperScanPauseMs) * time.Millisecond,
+		now:      time.Now,
+	}
+}
+
+// SetHooks installs hooks for observing runs
+func (r *Reaper) SetHooks(hooks Hooks) {
+	r.hooks = hooks
+}
+
+// HandleReap is the jobs.Handler for JobKindReap
+func (r *Reaper) HandleReap(ctx context.Context, payload json.RawMessage) error {
+	var p reapPayload
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return fmt.Errorf("invalid reap payload: %w", err)
+		}
+	}
+	_, err := r.Run(ctx, p.DryRun)
+	return err
+}
+
+// Run reaps every namespace in name order and stores the report, which it
+// returns. A namespace that fails is reported with its error and the others
+// are still reaped; the errors are returned together.
+func (r *Reaper) Run(ctx context.Context, dryRun bool) (*storage.ReaperReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &storage.ReaperReport{
+		DryRun:    dryRun || r.dryRun,
+		StartedAt: r.now().UTC(),
+	}
+	run := &reaperRun{Reaper: r, dryRun: report.DryRun}
+
+	var errs []error
+	for _, namespace := range slices.Sorted(maps.Keys(r.policies)) {
+		policy := r.policies[namespace]
+		if policy.Policy == common.REAPER_POLICY_OFF {
+			continue
+		}
+
+		counts, err := run.reapNamespace(ctx, namespace, policy)
+		if err != nil {
+			r.logger.Error("Failed to reap namespace", "namespace", namespace, "error", err)
+			counts.Error = err.Error()
+			errs = append(errs, fmt.Errorf("namespace %s: %w", namespace, err))
+		}
+		r.logger.Info("Reaped namespace", "namespace", namespace, "policy", policy.Policy, "scanned", counts.Scanned,
+			"deleted", counts.Deleted, "expired", counts.Expired, "dry_run", report.DryRun)
+		if r.hooks.NamespaceReaped != nil {
+			r.hooks.NamespaceReaped(counts, report.DryRun)
+		}
+		report.Namespaces = append(report.Namespaces, counts)
+	}
+	report.FinishedAt = r.now().UTC()
+
+	if err := r.redis.SaveReaperReport(ctx, report); err != nil {
+		errs = append(errs, err)
+	}
+	if !report.DryRun {
+		r.audit(ctx, report)
+	}
+	return report, errors.Join(errs...)
+}
+
+// audit records a run that removed or expired any keys
+func (r *Reaper) audit(ctx context.Context, report *storage.ReaperReport) {
+	detail := map[string]any{}
+	for _, ns := range report.Namespaces {
+		if ns.Deleted > 0 || ns.Expired > 0 {
+			detail[ns.Namespace] = map[string]int{"deleted": ns.Deleted, "expired": ns.Expired}
+		}
+	}
+	if len(detail) > 0 && r.db != nil {
+		audit.Record(ctx, r.db, "", nil, audit.ActionRedisKeysReaped, detail)
+	}
+}
+
+// reaperRun holds the state of one run
+type reaperRun struct {
+	*Reaper
+	dryRun  bool
+	tenants map[string]bool // schemas of existing tenants, loaded on first use
+}
+
+// reapNamespace scans the namespace's keys, deletes the orphaned ones and
+// gives the rest the policy's TTL when they have none
+func (run *reaperRun) reapNamespace(ctx context.Context, namespace string, policy common.RedisKeyPolicy) (storage.ReaperNamespaceReport, error) {
+	counts := storage.ReaperNamespaceReport{Namespace: namespace, Policy: policy.Policy}
+	err := run.redis.ScanKeys(ctx, run.redis.NamespacePattern(namespace), run.count, run.pause, func(keys []string) error {
+		counts.Scanned += len(keys)
+
+		var orphaned []string
+		var err error
+		switch policy.Policy {
+		case common.REAPER_POLICY_SESSIONS:
+			orphaned, err = run.orphanedSessions(ctx, keys)
+		case common.REAPER_POLICY_TENANT_CACHE:
+			orphaned, err = run.orphanedTenantCache(ctx, keys)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(orphaned) > 0 {
+			deleted := len(orphaned)
+			if !run.dryRun {
+				if deleted, err = run.redis.DeleteKeys(ctx, orphaned); err != nil {
+					return err
+				}
+			}
+			counts.Deleted += deleted
+		}
+
+		if policy.TTL() <= 0 {
+			return nil
+		}
+		persistent, err := run.redis.PersistentKeys(ctx, slices.DeleteFunc(keys, func(key string) bool {
+			return slices.Contains(orphaned, key)
+		}))
+		if err != nil || len(persistent) == 0 {
+			return err
+		}
+		expired := len(persistent)
+		if !run.dryRun {
+			if expired, err = run.redis.ExpireKeys(ctx, persistent, policy.TTL()); err != nil {
+				return err
+			}
+		}
+		counts.Expired += expired
+		return nil
+	})
+	return counts, err
+}
+
+// orphanedSessions returns the session keys whose token doesn't name a
+// user, or names one that no longer exists or is inactive
+func (run *reaperRun) orphanedSessions(ctx context.Context, keys []string) ([]string, error) {
+	if run.db == nil {
+		return nil, errors.New("the sessions policy needs a database")
+	}
+
+	tokens, found, err := run.redis.GetValues(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphaned []string
+	userIDs := map[string]uint{}
+	for i, key := range keys {
+		if !found[i] {
+			continue // expired since the scan
+		}
+		userID, ok := sessionUserID(tokens[i])
+		if !ok {
+			orphaned = append(orphaned, key)
+			continue
+		}
+		userIDs[key] = userID
+	}
+	if len(userIDs) == 0 {
+		return orphaned, nil
+	}
+
+	var active []uint
+	err = run.db.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id IN ? AND active = ?", slices.Collect(maps.Values(userIDs)), true).
+		Pluck("id", &active).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up session users: %w", err)
+	}
+	for key, userID := range userIDs {
+		if !slices.Contains(active, userID) {
+			orphaned = append(orphaned, key)
+		}
+	}
+	return orphaned, nil
+}
+
+// sessionUserID returns the user of a session's token. Sessions hold tokens
+// we issued, so the claims are read without verifying the signature or
+// expiry; an expired token still names its user.
+func sessionUserID(token string) (uint, bool) {
+	var claims auth.Claims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.UserID == 0 {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
+// orphanedTenantCache returns the <namespace>:<tenant schema>:* keys of
+// tenants that no longer exist. Tenants waiting out their deletion grace
+// period still exist.
+func (run *reaperRun) orphanedTenantCache(ctx context.Context, keys []string) ([]string, error) {
+	if run.tenants == nil {
+		if run.db == nil {
+			return nil, errors.New("the tenant_cache policy needs a database")
+		}
+		var schemas []string
+		if err := run.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+			return nil, fmt.Errorf("failed to list tenants: %w", err)
+		}
+		run.tenants = map[string]bool{}
+		for _, schema := range schemas {
+			run.tenants[schema] = true
+		}
+	}
+
+	var orphaned []string
+	for _, key := range keys {
+		parts := strings.SplitN(key, ":", 3)
+		if len(parts) < 3 || parts[1] == "" {
+			continue // not a tenant's key
+		}
+		if !run.tenants[parts[1]] {
+			orphaned = append(orphaned, key)
+		}
+	}
+	return orphaned, nil
+}
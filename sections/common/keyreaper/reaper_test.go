@@ -0,0 +1,193 @@
+package keyreaper
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections/common/auth/authtest"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestReaper returns a reaper without a database over an in-process
+// Redis, scanning two keys at a time
+func newTestReaper(t *testing.T, policies map[string]common.RedisKeyPolicy) (*Reaper, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return &Reaper{
+		logger:   slog.Default(),
+		redis:    client,
+		policies: policies,
+		count:    2,
+		now:      time.Now,
+	}, server
+}
+
+// namespaceReport returns the report's counts for namespace
+func namespaceReport(report *storage.ReaperReport, namespace string) storage.ReaperNamespaceReport {
+	for _, ns := range report.Namespaces {
+		if ns.Namespace == namespace {
+			return ns
+		}
+	}
+	return storage.ReaperNamespaceReport{}
+}
+
+func TestRunTTLPolicies(t *testing.T) {
+	r, server := newTestReaper(t, map[string]common.RedisKeyPolicy{
+		"oauth_state": {Policy: common.REAPER_POLICY_TTL, TTLSeconds: 600},
+		"idempotency": {Policy: common.REAPER_POLICY_TTL, TTLSeconds: 3600},
+		"unsplash":    {Policy: common.REAPER_POLICY_OFF},
+	})
+	var hooked []string
+	r.SetHooks(Hooks{NamespaceReaped: func(report storage.ReaperNamespaceReport, dryRun bool) {
+		hooked = append(hooked, report.Namespace)
+	}})
+	ctx := context.Background()
+
+	for _, key := range []string{"oauth_state:a", "oauth_state:b", "oauth_state:c", "idempotency:k", "unsplash:q", "chat:1"} {
+		server.Set(key, "x")
+	}
+	server.SetTTL("oauth_state:c", time.Minute)
+
+	report, err := r.Run(ctx, true)
+	if err != nil {
+		t.Fatalf("Run() dry run error = %v", err)
+	}
+	if ns := namespaceReport(report, "oauth_state"); !report.DryRun || ns.Scanned != 3 || ns.Expired != 2 || ns.Deleted != 0 {
+		t.Errorf("dry run oauth_state counts = %+v", ns)
+	}
+	if server.TTL("oauth_state:a") != 0 {
+		t.Error("dry run set an expiry")
+	}
+	if strings.Join(hooked, ",") != "idempotency,oauth_state" {
+		t.Errorf("hooked namespaces = %v, want each reaped namespace in name order", hooked)
+	}
+
+	report, err = r.Run(ctx, false)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if ns := namespaceReport(report, "idempotency"); ns.Expired != 1 {
+		t.Errorf("idempotency counts = %+v, want one key expired", ns)
+	}
+	for key, want := range map[string]time.Duration{
+		"oauth_state:a": 10 * time.Minute,
+		"oauth_state:b": 10 * time.Minute,
+		"oauth_state:c": time.Minute, // kept its own expiry
+		"idempotency:k": time.Hour,
+		"unsplash:q":    0,
+		"chat:1":        0,
+	} {
+		if got := server.TTL(key); got != want {
+			t.Errorf("TTL(%s) = %v, want %v", key, got, want)
+		}
+	}
+
+	saved, err := r.redis.GetReaperReport(ctx)
+	if err != nil || saved.DryRun || len(saved.Namespaces) != 2 {
+		t.Errorf("saved report = %+v, %v; want the last run's", saved, err)
+	}
+
+	// The config's dry run makes every run one
+	r.dryRun = true
+	server.Set("oauth_state:d", "x")
+	if report, _ := r.Run(ctx, false); !report.DryRun || server.TTL("oauth_state:d") != 0 {
+		t.Errorf("Run() with redis_reaper_dry_run = %+v, want a dry run", report)
+	}
+}
+
+func TestRunReportsFailingNamespaces(t *testing.T) {
+	r, server := newTestReaper(t, map[string]common.RedisKeyPolicy{
+		"session":     {Policy: common.REAPER_POLICY_SESSIONS, TTLSeconds: 86400},
+		"oauth_state": {Policy: common.REAPER_POLICY_TTL, TTLSeconds: 600},
+	})
+	server.Set("session:s1", "token")
+	server.Set("oauth_state:a", "x")
+
+	// Sessions need a database; the other namespaces are still reaped
+	report, err := r.Run(context.Background(), false)
+	if err == nil || !strings.Contains(err.Error(), "namespace session") {
+		t.Errorf("Run() error = %v, want the session namespace's", err)
+	}
+	if ns := namespaceReport(report, "session"); ns.Error == "" || ns.Deleted != 0 {
+		t.Errorf("session counts = %+v, want its error", ns)
+	}
+	if ns := namespaceReport(report, "oauth_state"); ns.Expired != 1 || ns.Error != "" {
+		t.Errorf("oauth_state counts = %+v, want it reaped", ns)
+	}
+	if !server.Exists("session:s1") {
+		t.Error("session deleted without its user being checked")
+	}
+}
+
+func TestReapTenantCache(t *testing.T) {
+	policy := common.RedisKeyPolicy{Policy: common.REAPER_POLICY_TENANT_CACHE}
+	seed := map[string]bool{
+		"fs:tenant_a:index.html": true,
+		"fs:tenant_a:about.html": true,
+		"fs:tenant_gone:a":       false,
+		"fs:tenant_gone:b":       false,
+		"fs:noschema":            true,
+		"fs::x":                  true,
+		"session:tenant_gone:x":  true,
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		r, server := newTestReaper(t, map[string]common.RedisKeyPolicy{"fs": policy})
+		for key := range seed {
+			server.Set(key, "x")
+		}
+		run := &reaperRun{Reaper: r, dryRun: dryRun, tenants: map[string]bool{"tenant_a": true}}
+
+		counts, err := run.reapNamespace(context.Background(), "fs", policy)
+		if err != nil {
+			t.Fatalf("reapNamespace() error = %v", err)
+		}
+		if counts.Scanned != 6 || counts.Deleted != 2 || counts.Expired != 0 {
+			t.Errorf("dry run %v: counts = %+v, want 6 scanned and 2 deleted", dryRun, counts)
+		}
+		for key, kept := range seed {
+			if server.Exists(key) != (kept || dryRun) {
+				t.Errorf("dry run %v: %s exists = %v", dryRun, key, server.Exists(key))
+			}
+		}
+	}
+}
+
+func TestSessionUserID(t *testing.T) {
+	manager := authtest.NewJWTManager(t)
+	token, err := manager.GenerateToken(7, "alice@awning.test", "tenant_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, _ := manager.GenerateToken(0, "", "")
+
+	tests := []struct {
+		token  string
+		want   uint
+		wantOK bool
+	}{
+		{token, 7, true},
+		{anonymous, 0, false},
+		{"not-a-jwt", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := sessionUserID(tt.token)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("sessionUserID(%.20q) = %d, %v; want %d, %v", tt.token, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
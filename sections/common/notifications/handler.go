@@ -0,0 +1,166 @@
+package notifications
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultNotificationsPerPage = 50
+	MaxNotificationsPerPage     = 200
+)
+
+// Handler serves the tenant's notification feed
+type Handler struct {
+	logger  *slog.Logger
+	service *Service
+}
+
+// NewHandler creates a new notification handler
+func NewHandler(service *Service) *Handler {
+	return &Handler{
+		logger:  slog.With("handler", "NotificationHandler"),
+		service: service,
+	}
+}
+
+// ListNotifications lists the tenant's notifications, newest first, with the
+// unread count. unread=true lists only unread ones.
+func (h *Handler) ListNotifications(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultNotificationsPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxNotificationsPerPage {
+			perPage = parsed
+		}
+	}
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread"))
+
+	ctx := c.Request.Context()
+	notifications, total, err := h.service.List(ctx, tenantID, unreadOnly, page, perPage)
+	if err != nil {
+		h.logger.Error("Failed to list notifications", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list notifications"})
+		return
+	}
+	unread, err := h.service.UnreadCount(ctx, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to count unread notifications", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"page":          page,
+		"perPage":       perPage,
+		"total":         total,
+		"unread":        unread,
+	})
+}
+
+// UnreadCount returns the tenant's unread notification count
+func (h *Handler) UnreadCount(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	unread, err := h.service.UnreadCount(c.Request.Context(), tenantID)
+	if err != nil {
+		h.logger.Error("Failed to count unread notifications", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to count notifications"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// MarkRead marks one notification read
+func (h *Handler) MarkRead(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err = h.service.MarkRead(ctx, tenantID, uint(id))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to mark notification read", "tenant", tenantID, "notification_id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark notification read"})
+		return
+	}
+
+	unread, err := h.service.UnreadCount(ctx, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to count unread notifications", "tenant", tenantID, "error", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// MarkAllRead marks every notification read
+func (h *Handler) MarkAllRead(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	marked, err := h.service.MarkAllRead(ctx, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to mark notifications read", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to mark notifications read"})
+		return
+	}
+
+	unread, err := h.service.UnreadCount(ctx, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to count unread notifications", "tenant", tenantID, "error", err)
+	}
+	c.JSON(http.StatusOK, gin.H{"marked": marked, "unread": unread})
+}
+
+// RegisterRoutes registers the tenant notification routes
+func RegisterRoutes(r *gin.RouterGroup, service *Service, jwtManager *auth.JWTManager) {
+	handler := NewHandler(service)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	notificationRoutes := r.Group("/api/v1/notifications")
+	notificationRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	notificationRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		notificationRoutes.GET("", handler.ListNotifications)
+		notificationRoutes.GET("/unread-count", handler.UnreadCount)
+		notificationRoutes.POST("/read-all", handler.MarkAllRead)
+		notificationRoutes.POST("/:id/read", handler.MarkRead)
+	}
+}
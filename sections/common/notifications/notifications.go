@@ -0,0 +1,327 @@
+// Package notifications tells tenants about events that need their
+// attention, in an in-app feed and by email, as allowed by the tenant's
+// notification preference settings
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	TypeDomainExpiring      = "domain_expiring"
+	TypeCertificateExpiring = "certificate_expiring"
+	TypePaymentFailed       = "payment_failed"
+	TypeGenerationCompleted = "generation_completed"
+	TypeContactSubmission   = "contact_submission"
+	TypeSpendingCap         = "spending_cap"
+)
+
+// preferences maps each type to its email and in-app settings
+var preferences = map[string]struct{ email, inApp string }{
+	TypeDomainExpiring:      {settings.NotifyDomainExpiringEmail, settings.NotifyDomainExpiringInApp},
+	TypeCertificateExpiring: {settings.NotifyCertificateExpiringEmail, settings.NotifyCertificateExpiringInApp},
+	TypePaymentFailed:       {settings.NotifyPaymentFailedEmail, settings.NotifyPaymentFailedInApp},
+	TypeGenerationCompleted: {settings.NotifyGenerationCompletedEmail, settings.NotifyGenerationCompletedInApp},
+	TypeContactSubmission:   {settings.NotifyContactSubmissionEmail, settings.NotifyContactSubmissionInApp},
+	TypeSpendingCap:         {settings.NotifySpendingCapEmail, settings.NotifySpendingCapInApp},
+}
+
+// UnreadCountTTL bounds how long a cached unread count is trusted, so a
+// count that drifted from the table is corrected
+const UnreadCountTTL = time.Hour
+
+// ErrNotFound is returned for notifications the tenant doesn't have
+var ErrNotFound = errors.New("notification not found")
+
+// Counter caches each tenant's unread count, implemented by
+// storage.RedisClient. Add and Set only change a count that is, and isn't,
+// cached respectively.
+type Counter interface {
+	GetUnreadNotifications(ctx context.Context, tenantSchema string) (int64, bool, error)
+	SetUnreadNotifications(ctx context.Context, tenantSchema string, count int64, ttl time.Duration) error
+	AddUnreadNotifications(ctx context.Context, tenantSchema string, delta int64) error
+	ClearUnreadNotifications(ctx context.Context, tenantSchema string) error
+}
+
+// EmailSender sends notification emails
+type EmailSender interface {
+	SendEmail(ctx context.Context, to []string, subject, body string) error
+}
+
+// LogEmailSender logs emails. It is used until an email service is
+// available.
+type LogEmailSender struct{}
+
+func (LogEmailSender) SendEmail(_ context.Context, to []string, subject, _ string) error {
+	slog.Info("Notification email", "to", to, "subject", subject)
+	return nil
+}
+
+// Notification is a notification to send
+type Notification struct {
+	Type     string
+	Title    string
+	Body     string
+	Metadata map[string]any
+
+	// Addresses emailed besides notification_emails
+	Emails []string
+}
+
+// Service records notifications in the tenant's feed and emails them
+type Service struct {
+	logger   *slog.Logger
+	db       *db.DB
+	counter  Counter
+	settings *settings.Store
+	email    EmailSender
+}
+
+// NewService creates a notification service. A nil email sender logs emails.
+func NewService(database *db.DB, counter Counter, settingsStore *settings.Store, email EmailSender) *Service {
+	if email == nil {
+		email = LogEmailSender{}
+	}
+	return &Service{
+		logger:   slog.With("service", "Notifications"),
+		db:       database,
+		counter:  counter,
+		settings: settingsStore,
+		email:    email,
+	}
+}
+
+// enabled reports whether the tenant's preference setting key is on
+func (s *Service) enabled(ctx context.Context, tenantSchema, key string) bool {
+	if s.settings == nil {
+		def, _ := settings.Lookup(key)
+		enabled, _ := def.Default.(bool)
+		return enabled
+	}
+	return s.settings.GetBool(ctx, tenantSchema, key)
+}
+
+// Notify adds the notification to the tenant's feed and emails it to the
+// tenant's notification_emails, each when the tenant's preferences allow.
+// Email failures are logged; only failing to record the notification is
+// returned. A nil service does nothing.
+func (s *Service) Notify(ctx context.Context, tenantSchema string, n Notification) error {
+	if s == nil || tenantSchema == "" {
+		return nil
+	}
+	prefs, ok := preferences[n.Type]
+	if !ok {
+		return fmt.Errorf("unknown notification type %q", n.Type)
+	}
+
+	inApp, emailed := false, false
+	if s.enabled(ctx, tenantSchema, prefs.inApp) {
+		row := models.TenantNotification{
+			TenantSchema: tenantSchema,
+			Type:         n.Type,
+			Title:        n.Title,
+			Body:         n.Body,
+			Metadata:     n.Metadata,
+		}
+		err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Create(&row).Error
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record notification: %w", err)
+		}
+		s.adjustUnread(ctx, tenantSchema, 1)
+		inApp = true
+	}
+
+	if s.enabled(ctx, tenantSchema, prefs.email) && s.settings != nil {
+		to := s.settings.GetStringList(ctx, tenantSchema, settings.NotificationEmails)
+		for _, addr := range n.Emails {
+			if !slices.Contains(to, addr) {
+				to = append(to, addr)
+			}
+		}
+		if len(to) > 0 {
+			if err := s.email.SendEmail(ctx, to, n.Title, n.Body); err != nil {
+				s.logger.Error("Failed to send notification email", "tenant", tenantSchema, "type", n.Type, "error", err)
+			} else {
+				emailed = true
+			}
+		}
+	}
+
+	s.logger.Info("Notification sent", "tenant", tenantSchema, "type", n.Type, "in_app", inApp, "emailed", emailed)
+	return nil
+}
+
+// adjustUnread adds delta to the cached unread count. When that fails the
+// count is dropped, to be recounted on its next read.
+func (s *Service) adjustUnread(ctx context.Context, tenantSchema string, delta int64) {
+	if s.counter == nil || delta == 0 {
+		return
+	}
+	if err := s.counter.AddUnreadNotifications(ctx, tenantSchema, delta); err != nil {
+		s.logger.Error("Failed to update unread count", "tenant", tenantSchema, "error", err)
+		if err := s.counter.ClearUnreadNotifications(ctx, tenantSchema); err != nil {
+			s.logger.Error("Failed to clear unread count", "tenant", tenantSchema, "error", err)
+		}
+	}
+}
+
+// UnreadCount returns the tenant's unread notification count, counting the
+// table only when no count is cached
+func (s *Service) UnreadCount(ctx context.Context, tenantSchema string) (int64, error) {
+	if s.counter != nil {
+		count, ok, err := s.counter.GetUnreadNotifications(ctx, tenantSchema)
+		if err != nil {
+			s.logger.Error("Failed to get unread count", "tenant", tenantSchema, "error", err)
+		} else if ok {
+			return count, nil
+		}
+	}
+
+	var count int64
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantNotification{}).Where("read_at IS NULL").Count(&count).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+
+	if s.counter != nil {
+		if err := s.counter.SetUnreadNotifications(ctx, tenantSchema, count, UnreadCountTTL); err != nil {
+			s.logger.Error("Failed to cache unread count", "tenant", tenantSchema, "error", err)
+		}
+	}
+	return count, nil
+}
+
+// List returns a page of the tenant's notifications, newest first, and the
+// total matching
+func (s *Service) List(ctx context.Context, tenantSchema string, unreadOnly bool, page, perPage int) ([]models.TenantNotification, int64, error) {
+	var total int64
+	notifications := []models.TenantNotification{}
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		q := tx.Model(&models.TenantNotification{})
+		if unreadOnly {
+			q = q.Where("read_at IS NULL")
+		}
+		if err := q.Count(&total).Error; err != nil {
+			return err
+		}
+		return q.Order("created_at DESC, id DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&notifications).Error
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return notifications, total, nil
+}
+
+// MarkRead marks one of the tenant's notifications read. Marking a read
+// notification again changes nothing.
+func (s *Service) MarkRead(ctx context.Context, tenantSchema string, id uint) error {
+	var marked int64
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		res := tx.Model(&models.TenantNotification{}).
+			Where("id = ? AND read_at IS NULL", id).
+			Update("read_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		marked = res.RowsAffected
+		if marked > 0 {
+			return nil
+		}
+		var exists int64
+		if err := tx.Model(&models.TenantNotification{}).Where("id = ?", id).Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark notification read: %w", err)
+	}
+	s.adjustUnread(ctx, tenantSchema, -marked)
+	return nil
+}
+
+// MarkAllRead marks every unread notification of the tenant read and
+// returns how many it marked
+func (s *Service) MarkAllRead(ctx context.Context, tenantSchema string) (int64, error) {
+	var marked int64
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		res := tx.Model(&models.TenantNotification{}).
+			Where("read_at IS NULL").
+			Update("read_at", time.Now())
+		marked = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", err)
+	}
+	s.adjustUnread(ctx, tenantSchema, -marked)
+	return marked, nil
+}
+
+// DomainExpiring implements domains.ExpiryNotifier
+func (s *Service) DomainExpiring(ctx context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error {
+	body := fmt.Sprintf("%s expires in %s.", domain.Domain, days(daysLeft))
+	if domain.AutoRenew {
+		body += " It is set to renew automatically."
+	} else {
+		body += " Renew it to keep your site reachable."
+	}
+	return s.Notify(ctx, tenantSchema, Notification{
+		Type:  TypeDomainExpiring,
+		Title: fmt.Sprintf("Your domain %s expires in %s", domain.Domain, days(daysLeft)),
+		Body:  body,
+		Metadata: map[string]any{
+			"domain":    domain.Domain,
+			"daysLeft":  daysLeft,
+			"expiresAt": domain.ExpiresAt,
+			"autoRenew": domain.AutoRenew,
+		},
+	})
+}
+
+// CertificateExpiring implements domains.ExpiryNotifier
+func (s *Service) CertificateExpiring(ctx context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error {
+	return s.Notify(ctx, tenantSchema, Notification{
+		Type:  TypeCertificateExpiring,
+		Title: fmt.Sprintf("The certificate for %s expires in %s", domain.Domain, days(daysLeft)),
+		Body:  fmt.Sprintf("The HTTPS certificate for %s expires in %s. Visitors will see security warnings if it isn't renewed.", domain.Domain, days(daysLeft)),
+		Metadata: map[string]any{
+			"domain":    domain.Domain,
+			"daysLeft":  daysLeft,
+			"expiresAt": domain.SSLExpiresAt,
+		},
+	})
+}
+
+// days formats a day count for notification text
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
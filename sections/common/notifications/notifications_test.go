@@ -0,0 +1,116 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeCounter is a Counter whose adds fail while addErr is set
+type fakeCounter struct {
+	count   int64
+	cached  bool
+	addErr  error
+	cleared int
+}
+
+func (f *fakeCounter) GetUnreadNotifications(ctx context.Context, tenantSchema string) (int64, bool, error) {
+	return f.count, f.cached, nil
+}
+
+func (f *fakeCounter) SetUnreadNotifications(ctx context.Context, tenantSchema string, count int64, ttl time.Duration) error {
+	if !f.cached {
+		f.count, f.cached = count, true
+	}
+	return nil
+}
+
+func (f *fakeCounter) AddUnreadNotifications(ctx context.Context, tenantSchema string, delta int64) error {
+	if f.addErr != nil {
+		return f.addErr
+	}
+	if f.cached {
+		f.count += delta
+	}
+	return nil
+}
+
+func (f *fakeCounter) ClearUnreadNotifications(ctx context.Context, tenantSchema string) error {
+	f.cached = false
+	f.cleared++
+	return nil
+}
+
+// recordingSender keeps the emails it was asked to send
+type recordingSender struct {
+	sent []string
+}
+
+func (r *recordingSender) SendEmail(_ context.Context, to []string, subject, _ string) error {
+	r.sent = append(r.sent, subject)
+	return nil
+}
+
+func TestNotifyPreferenceDefaults(t *testing.T) {
+	email := &recordingSender{}
+	// Without a database, recording anything would panic
+	s := NewService(nil, &fakeCounter{}, nil, email)
+
+	// Generation notifications are opt-in
+	if err := s.Notify(context.Background(), "tenant_a", Notification{Type: TypeGenerationCompleted, Title: "Your site is ready"}); err != nil {
+		t.Errorf("Notify() of an opted-out type error = %v", err)
+	}
+	if len(email.sent) != 0 {
+		t.Errorf("emailed %q for an opted-out type", email.sent)
+	}
+
+	if err := s.Notify(context.Background(), "tenant_a", Notification{Type: "weather"}); err == nil {
+		t.Error("Notify() of an unknown type error = nil")
+	}
+	// Tenantless events and a nil service do nothing
+	if err := s.Notify(context.Background(), "", Notification{Type: TypeDomainExpiring}); err != nil {
+		t.Errorf("Notify() without a tenant error = %v", err)
+	}
+	var none *Service
+	if err := none.Notify(context.Background(), "tenant_a", Notification{Type: TypeDomainExpiring}); err != nil {
+		t.Errorf("Notify() on a nil service error = %v", err)
+	}
+}
+
+func TestPreferencesCoverEveryType(t *testing.T) {
+	for _, typ := range []string{TypeDomainExpiring, TypeCertificateExpiring, TypePaymentFailed, TypeGenerationCompleted, TypeContactSubmission, TypeSpendingCap} {
+		prefs, ok := preferences[typ]
+		if !ok || prefs.email == "" || prefs.inApp == "" {
+			t.Errorf("%s has preferences %+v, want an email and an in-app setting", typ, prefs)
+		}
+	}
+}
+
+func TestUnreadCountCached(t *testing.T) {
+	counter := &fakeCounter{count: 4, cached: true}
+	s := NewService(nil, counter, nil, nil)
+
+	// A cached count is served without counting the table
+	if got, err := s.UnreadCount(context.Background(), "tenant_a"); err != nil || got != 4 {
+		t.Errorf("UnreadCount() = %d, %v; want the cached 4", got, err)
+	}
+
+	s.adjustUnread(context.Background(), "tenant_a", 2)
+	if counter.count != 6 {
+		t.Errorf("count after adding 2 = %d, want 6", counter.count)
+	}
+
+	// A failed adjustment drops the count, to be recounted on its next read
+	counter.addErr = errors.New("redis down")
+	s.adjustUnread(context.Background(), "tenant_a", -1)
+	if counter.cached || counter.cleared != 1 {
+		t.Errorf("count after a failed adjustment cached = %v, cleared %d times; want dropped", counter.cached, counter.cleared)
+	}
+}
+
+func TestDays(t *testing.T) {
+	if days(1) != "1 day" || days(14) != "14 days" {
+		t.Errorf("days(1), days(14) = %q, %q", days(1), days(14))
+	}
+}
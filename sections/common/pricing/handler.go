@@ -0,0 +1,156 @@
+package pricing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const PlansCacheControl = "public, max-age=300"
+
+// Handler serves the configured plans to the pricing page
+type Handler struct {
+	logger    *slog.Logger
+	plans     []common.Plan
+	stripeSvc *services.StripeService
+	etag      string
+}
+
+// NewHandler creates a new plans handler. stripeSvc may be nil, in which case
+// prices are always returned in the configured currency.
+func NewHandler(deps *sections.Dependencies, stripeSvc *services.StripeService) *Handler {
+	return &Handler{
+		logger:    slog.With("handler", "PlansHandler"),
+		plans:     deps.Plans,
+		stripeSvc: stripeSvc,
+		etag:      plansHash(deps.Plans),
+	}
+}
+
+// PlanResponse is the public view of a common.Plan; Stripe product and price
+// IDs are left out
+type PlanResponse struct {
+	ID                  string   `json:"id"`
+	Name                string   `json:"name"`
+	Description         string   `json:"description"`
+	PriceCents          int64    `json:"priceCents"`
+	Currency            string   `json:"currency"`
+	Interval            string   `json:"interval"`
+	ChargeDomain        bool     `json:"chargeDomain"`
+	GenerationsPerMonth int      `json:"generationsPerMonth"`
+	Features            []string `json:"features"`
+}
+
+func toResponse(plan *common.Plan) PlanResponse {
+	features := plan.Features
+	if features == nil {
+		features = []string{}
+	}
+	return PlanResponse{
+		ID:                  plan.ID,
+		Name:                plan.Name,
+		Description:         plan.Description,
+		PriceCents:          plan.PriceCents,
+		Currency:            strings.ToLower(plan.Currency),
+		Interval:            plan.Interval,
+		ChargeDomain:        plan.ChargeDomain,
+		GenerationsPerMonth: plan.GenerationsPerMonth,
+		Features:            features,
+	}
+}
+
+func plansHash(plans []common.Plan) string {
+	buf, _ := json.Marshal(plans)
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ListPlans returns every configured plan
+func (h *Handler) ListPlans(c *gin.Context) {
+	currency := strings.ToLower(c.Query("currency"))
+	if h.notModified(c, currency) {
+		return
+	}
+
+	responses := make([]PlanResponse, 0, len(h.plans))
+	for i := range h.plans {
+		responses = append(responses, h.localize(c, &h.plans[i], currency))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"plans": responses})
+}
+
+// GetPlan returns a single plan by ID
+func (h *Handler) GetPlan(c *gin.Context) {
+	plan := common.GetPlan(h.plans, c.Param("id"))
+	if plan == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+		return
+	}
+
+	currency := strings.ToLower(c.Query("currency"))
+	if h.notModified(c, currency) {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.localize(c, plan, currency))
+}
+
+// localize converts the plan price to currency when its Stripe Price has a
+// matching currency option, and otherwise keeps the configured currency
+func (h *Handler) localize(c *gin.Context, plan *common.Plan, currency string) PlanResponse {
+	resp := toResponse(plan)
+	if currency == "" || currency == resp.Currency || h.stripeSvc == nil {
+		return resp
+	}
+
+	amount, ok, err := h.stripeSvc.PlanPriceInCurrency(c.Request.Context(), plan, currency)
+	if err != nil {
+		h.logger.Warn("Failed to get plan price in currency", "plan_id", plan.ID, "currency", currency, "error", err)
+		return resp
+	}
+	if ok {
+		resp.PriceCents = amount
+		resp.Currency = currency
+	}
+	return resp
+}
+
+// notModified sets caching headers and answers 304 when the client already
+// has this version of the plans
+func (h *Handler) notModified(c *gin.Context, currency string) bool {
+	etag := `"` + h.etag
+	if currency != "" {
+		etag += "-" + currency
+	}
+	etag += `"`
+
+	c.Header("Cache-Control", PlansCacheControl)
+	c.Header("ETag", etag)
+
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// RegisterRoutes registers the public plan routes
+func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, stripeSvc *services.StripeService) {
+	handler := NewHandler(deps, stripeSvc)
+
+	public := r.Group("/api/v1/plans")
+	{
+		public.GET("", handler.ListPlans)
+		public.GET("/:id", handler.GetPlan)
+	}
+}
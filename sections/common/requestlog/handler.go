@@ -0,0 +1,179 @@
+package requestlog
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/middleware"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultRequestsPerPage = 50
+	MaxRequestsPerPage     = 200
+)
+
+// Handler serves the request log to admins
+type Handler struct {
+	logger *slog.Logger
+	db     *db.DB
+}
+
+// NewHandler creates a new request log handler
+func NewHandler(database *db.DB) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "RequestLogHandler"),
+		db:     database,
+	}
+}
+
+// ListRequests lists a tenant's logged requests, newest first. Filters: from
+// and to (RFC3339 or YYYY-MM-DD, to is inclusive), status (a code such as
+// 404 or a class such as 5xx), method, route and request_id.
+func (h *Handler) ListRequests(c *gin.Context) {
+	tenantSchema := c.Param("tenantSchema")
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultRequestsPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxRequestsPerPage {
+			perPage = parsed
+		}
+	}
+
+	var conditions []func(*gorm.DB) *gorm.DB
+
+	for _, param := range []string{"from", "to"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, dateOnly, err := parseTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ", expected RFC3339 or YYYY-MM-DD"})
+			return
+		}
+		switch {
+		case param == "from":
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at >= ?", t) })
+		case dateOnly:
+			t = t.AddDate(0, 0, 1)
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at < ?", t) })
+		default:
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at <= ?", t) })
+		}
+	}
+
+	if status := c.Query("status"); status != "" {
+		low, high, ok := parseStatus(status)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be a code such as 404 or a class such as 5xx"})
+			return
+		}
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("status BETWEEN ? AND ?", low, high) })
+	}
+	for _, f := range []struct{ param, column string }{
+		{"method", "method"},
+		{"route", "route"},
+		{"request_id", "request_id"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			if f.param == "method" {
+				v = strings.ToUpper(v)
+			}
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where(f.column+" = ?", v) })
+		}
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		for _, condition := range conditions {
+			db = condition(db)
+		}
+		return db
+	}
+
+	ctx := c.Request.Context()
+	var tenant models.Tenant
+	err := h.db.DB.WithContext(ctx).Where("schema_name = ?", tenantSchema).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load tenant", "tenant_schema", tenantSchema, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list requests"})
+		return
+	}
+
+	var total int64
+	requests := []models.TenantRequestLog{}
+	err = h.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		if err := tx.Model(&models.TenantRequestLog{}).Scopes(filter).Count(&total).Error; err != nil {
+			return err
+		}
+		return tx.Scopes(filter).
+			Order("created_at DESC, id DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&requests).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list requests", "tenant_schema", tenantSchema, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"requests": requests,
+		"page":     page,
+		"perPage":  perPage,
+		"total":    total,
+	})
+}
+
+// parseTime parses an RFC3339 time or a UTC date, reporting which
+func parseTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// parseStatus parses a status code or class (4xx) into an inclusive range
+func parseStatus(v string) (int, int, bool) {
+	if len(v) == 3 && strings.EqualFold(v[1:], "xx") && v[0] >= '1' && v[0] <= '5' {
+		low := int(v[0]-'0') * 100
+		return low, low + 99, true
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, false
+	}
+	return code, code, true
+}
+
+// RegisterRoutes registers the admin request log routes, authenticated with
+// the server API key
+func RegisterRoutes(r *gin.RouterGroup, database *db.DB, apiKey, apiKeySecret string) {
+	handler := NewHandler(database)
+
+	adminRoutes := r.Group("/api/v1/admin/tenants")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret)))
+	{
+		adminRoutes.GET("/:tenantSchema/requests", handler.ListRequests)
+	}
+}
@@ -0,0 +1,83 @@
+package requestlog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// JobKindPrune deletes request logs older than request_log_retention_days
+	JobKindPrune = "requestlog.prune"
+
+	// PruneInterval is how often the prune job runs
+	PruneInterval = 24 * time.Hour
+)
+
+// Pruner deletes request logs past the retention period
+type Pruner struct {
+	logger    *slog.Logger
+	db        *db.DB
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewPruner creates a pruner for request logs older than retentionDays.
+// A nil now uses time.Now.
+func NewPruner(database *db.DB, retentionDays int, now func() time.Time) *Pruner {
+	if now == nil {
+		now = time.Now
+	}
+	return &Pruner{
+		logger:    slog.With("service", "RequestLogPruner"),
+		db:        database,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+		now:       now,
+	}
+}
+
+// HandlePrune is the jobs.Handler for JobKindPrune
+func (p *Pruner) HandlePrune(ctx context.Context, _ json.RawMessage) error {
+	_, err := p.PruneAll(ctx)
+	return err
+}
+
+// PruneAll deletes old request logs of every tenant and returns how many
+// were deleted. Tenants that fail are reported together once all have been
+// visited.
+func (p *Pruner) PruneAll(ctx context.Context) (int64, error) {
+	if p.retention <= 0 {
+		return 0, nil
+	}
+
+	var schemas []string
+	if err := p.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	cutoff := p.now().Add(-p.retention)
+	var errs []error
+	var pruned int64
+	for _, schema := range schemas {
+		err := p.db.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+			res := tx.Where("created_at < ?", cutoff).Delete(&models.TenantRequestLog{})
+			pruned += res.RowsAffected
+			return res.Error
+		})
+		if err != nil {
+			p.logger.Error("Failed to prune request logs", "tenant", schema, "error", err)
+			errs = append(errs, fmt.Errorf("tenant %s: %w", schema, err))
+		}
+	}
+
+	p.logger.Info("Request log prune finished", "pruned", pruned, "failed", len(errs))
+	return pruned, errors.Join(errs...)
+}
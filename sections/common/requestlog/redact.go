@@ -0,0 +1,36 @@
+package requestlog
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// redactions replace values that may identify a user or grant access, in
+// order
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(bearer|basic|apikey)\s+\S+`), "$1 [redacted]"},
+	{regexp.MustCompile(`(?i)\b(password|secret|token|key|code)(["']?\s*[=:]\s*["']?)[^\s"'&,;]+`), "$1$2[redacted]"},
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+(?:\.[A-Za-z0-9_-]+)?`), "[token]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[email]"},
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[number]"},
+	{regexp.MustCompile(`[A-Za-z0-9_+/=-]{32,}`), "[redacted]"},
+}
+
+// Redact removes credentials, tokens, email addresses, long numbers and
+// other long opaque strings from an error message, collapses whitespace and
+// caps it at MaxErrorLength runes
+func Redact(message string) string {
+	for _, r := range redactions {
+		message = r.pattern.ReplaceAllString(message, r.replacement)
+	}
+	message = strings.Join(strings.Fields(message), " ")
+
+	if utf8.RuneCountInString(message) > MaxErrorLength {
+		message = string([]rune(message)[:MaxErrorLength-1]) + "…"
+	}
+	return message
+}
@@ -0,0 +1,220 @@
+// Package requestlog records compact per-tenant logs of API requests, for
+// debugging what a tenant saw at a given time
+package requestlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"awning-backend/db"
+	"awning-backend/middleware"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// RequestIDHeader carries the request ID; a valid one sent by the client
+	// is kept, otherwise one is generated
+	RequestIDHeader = "X-Request-ID"
+
+	// MaxErrorLength caps the stored error, in runes
+	MaxErrorLength = 255
+
+	// Records are written in batches, at least this often
+	flushInterval  = time.Second
+	flushBatchSize = 200
+	queueSize      = 2000
+
+	// Error responses are captured up to this size to read their error
+	maxCapturedBody = 4096
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// Recorder queues request records and writes them in the background. Records
+// that don't fit in the queue are dropped rather than slowing requests down.
+type Recorder struct {
+	logger        *slog.Logger
+	db            *db.DB
+	samplePercent int
+	records       chan models.TenantRequestLog
+
+	// random returns a number in [0, 100)
+	random func() int
+}
+
+// NewRecorder creates a recorder logging every response outside 2xx and
+// samplePercent of the rest
+func NewRecorder(database *db.DB, samplePercent int) *Recorder {
+	return &Recorder{
+		logger:        slog.With("service", "RequestLog"),
+		db:            database,
+		samplePercent: samplePercent,
+		records:       make(chan models.TenantRequestLog, queueSize),
+		random:        func() int { return rand.IntN(100) },
+	}
+}
+
+// shouldLog reports whether a response with status is recorded
+func (r *Recorder) shouldLog(status int) bool {
+	if status < 200 || status >= 300 {
+		return true
+	}
+	return r.random() < r.samplePercent
+}
+
+// Middleware sets the request ID and records API requests that ran in a
+// tenant's context. It must run before the route groups' tenant middleware,
+// whose tenant it reads once the request has been handled.
+func (r *Recorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		start := time.Now()
+		writer := &errorCapturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		tenantSchema, ok := auth.GetTenantIDFromContext(c)
+		if !ok || tenantSchema == "" || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			return
+		}
+		status := c.Writer.Status()
+		if !r.shouldLog(status) {
+			return
+		}
+
+		record := models.TenantRequestLog{
+			CreatedAt:    start,
+			TenantSchema: tenantSchema,
+			RequestID:    requestID,
+			Method:       c.Request.Method,
+			Route:        c.FullPath(),
+			Status:       status,
+			LatencyMs:    time.Since(start).Milliseconds(),
+			IP:           middleware.ClientIP(c),
+		}
+		if record.Route == "" {
+			record.Route = "(unmatched)"
+		}
+		if userID, ok := auth.GetUserIDFromContext(c); ok {
+			record.UserID = &userID
+		}
+		if status >= 400 {
+			record.Error = responseError(writer.body.Bytes())
+		}
+
+		select {
+		case r.records <- record:
+		default:
+			r.logger.Warn("Request log queue full, dropping record", "tenant", tenantSchema, "request_id", requestID)
+		}
+	}
+}
+
+// Run writes queued records until ctx is done, then writes what is left
+func (r *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []models.TenantRequestLog
+	for {
+		select {
+		case record := <-r.records:
+			batch = append(batch, record)
+			if len(batch) >= flushBatchSize {
+				r.flush(context.Background(), batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			r.flush(context.Background(), batch)
+			batch = nil
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-r.records:
+					batch = append(batch, record)
+				default:
+					r.flush(context.Background(), batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes a batch of records, grouped by tenant
+func (r *Recorder) flush(ctx context.Context, batch []models.TenantRequestLog) {
+	byTenant := map[string][]models.TenantRequestLog{}
+	for _, record := range batch {
+		byTenant[record.TenantSchema] = append(byTenant[record.TenantSchema], record)
+	}
+
+	for tenantSchema, records := range byTenant {
+		err := r.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Create(&records).Error
+		})
+		if err != nil {
+			r.logger.Error("Failed to write request log", "tenant", tenantSchema, "records", len(records), "error", err)
+		}
+	}
+}
+
+// errorCapturingWriter keeps the start of error response bodies, so their
+// error message can be recorded
+type errorCapturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorCapturingWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorCapturingWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorCapturingWriter) capture(data []byte) {
+	if w.Status() < 400 || w.body.Len() >= maxCapturedBody {
+		return
+	}
+	w.body.Write(data[:min(len(data), maxCapturedBody-w.body.Len())])
+}
+
+// responseError returns the redacted error of an error response: its JSON
+// error field, or the start of a plain text body
+func responseError(body []byte) string {
+	var payload struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	message := ""
+	if err := json.Unmarshal(body, &payload); err == nil {
+		message = payload.Error
+		if message == "" {
+			message = payload.Code
+		}
+	} else if utf8.Valid(body) && !strings.HasPrefix(strings.TrimSpace(string(body)), "<") {
+		message = string(body)
+	}
+	return Redact(message)
+}
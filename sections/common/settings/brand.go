@@ -0,0 +1,144 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Brand is the tenant's brand, added to generated pages by the header
+// processor
+type Brand struct {
+	FaviconURL    string   `json:"favicon_url"`
+	PrimaryFont   string   `json:"primary_font"`
+	SecondaryFont string   `json:"secondary_font"`
+	Colors        []string `json:"colors"`
+}
+
+// Brand returns the tenant's brand settings, with defaults for those that
+// can't be loaded
+func (s *Store) Brand(ctx context.Context, tenantSchema string) Brand {
+	return Brand{
+		FaviconURL:    s.GetString(ctx, tenantSchema, BrandFaviconURL),
+		PrimaryFont:   s.GetString(ctx, tenantSchema, BrandPrimaryFont),
+		SecondaryFont: s.GetString(ctx, tenantSchema, BrandSecondaryFont),
+		Colors:        s.GetStringList(ctx, tenantSchema, BrandColors),
+	}
+}
+
+var ErrFaviconNotFound = errors.New("favicon not found")
+
+// faviconURL returns the URL of one of the tenant's uploaded favicons
+func (s *Store) faviconURL(ctx context.Context, tenantSchema string, imageID uint) (string, error) {
+	var image models.TenantImage
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND id = ? AND purpose = ?", tenantSchema, imageID, models.ImagePurposeFavicon).First(&image).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", ErrFaviconNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load favicon: %w", err)
+	}
+	return image.URL, nil
+}
+
+// BrandRequest sets the brand settings it includes; null restores a
+// setting's default. FaviconImageID picks an uploaded favicon instead of
+// FaviconURL.
+type BrandRequest struct {
+	FaviconURL     json.RawMessage `json:"favicon_url"`
+	FaviconImageID *uint           `json:"favicon_image_id"`
+	PrimaryFont    json.RawMessage `json:"primary_font"`
+	SecondaryFont  json.RawMessage `json:"secondary_font"`
+	Colors         json.RawMessage `json:"colors"`
+}
+
+// GetBrand returns the tenant's brand settings
+func (h *Handler) GetBrand(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.store.Brand(c.Request.Context(), tenantID))
+}
+
+// UpdateBrand validates and stores the brand settings in the request. None
+// are stored unless all of them are valid.
+func (h *Handler) UpdateBrand(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	userID, _ := auth.GetUserIDFromContext(c)
+
+	var req BrandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.FaviconImageID != nil {
+		if req.FaviconURL != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "favicon_url and favicon_image_id can't be combined", "code": "invalid_setting"})
+			return
+		}
+		url, err := h.store.faviconURL(ctx, tenantID, *req.FaviconImageID)
+		if errors.Is(err, ErrFaviconNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "favicon not found", "code": "favicon_not_found"})
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to load favicon", "tenant", tenantID, "image_id", *req.FaviconImageID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load favicon"})
+			return
+		}
+		req.FaviconURL, _ = json.Marshal(url)
+	}
+
+	values := map[string]json.RawMessage{}
+	for key, raw := range map[string]json.RawMessage{
+		BrandFaviconURL:    req.FaviconURL,
+		BrandPrimaryFont:   req.PrimaryFont,
+		BrandSecondaryFont: req.SecondaryFont,
+		BrandColors:        req.Colors,
+	} {
+		if raw == nil {
+			continue
+		}
+		if string(raw) != "null" {
+			def, _ := Lookup(key)
+			if _, err := def.Decode(raw); err != nil {
+				var invalid *ValidationError
+				errors.As(err, &invalid)
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_setting", "key": invalid.Key})
+				return
+			}
+		}
+		values[key] = raw
+	}
+
+	for key, raw := range values {
+		if _, err := h.store.Set(ctx, tenantID, key, raw, userID); err != nil {
+			h.logger.Error("Failed to save brand setting", "tenant", tenantID, "key", key, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save brand settings"})
+			return
+		}
+	}
+
+	h.logger.Info("Brand updated", "tenant", tenantID, "settings", len(values))
+
+	c.JSON(http.StatusOK, h.store.Brand(ctx, tenantID))
+}
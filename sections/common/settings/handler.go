@@ -0,0 +1,133 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler handles tenant settings requests
+type Handler struct {
+	logger *slog.Logger
+	store  *Store
+}
+
+// NewHandler creates a new settings handler
+func NewHandler(store *Store) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "SettingsHandler"),
+		store:  store,
+	}
+}
+
+// SettingRequest sets a setting; a null value restores the default
+type SettingRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// ListSettings returns every registered setting with the tenant's value
+func (h *Handler) ListSettings(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	all, err := h.store.All(c.Request.Context(), tenantID)
+	if err != nil {
+		h.logger.Error("Failed to load settings", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": all})
+}
+
+// UpdateSetting validates and stores one setting for the tenant
+func (h *Handler) UpdateSetting(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	userID, _ := auth.GetUserIDFromContext(c)
+
+	key := c.Param("key")
+	def, ok := Lookup(key)
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     "unknown setting " + key,
+			"code":      "unknown_setting",
+			"validKeys": Keys(),
+		})
+		return
+	}
+
+	var req SettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var previous Setting
+	if def.AdminOnly {
+		allowed, err := h.store.isAdmin(ctx, tenantID, userID)
+		if err != nil {
+			h.logger.Error("Failed to load membership", "tenant", tenantID, "user_id", userID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load membership"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only tenant owners and admins can change " + key, "code": "setting_forbidden"})
+			return
+		}
+		previous, _ = h.store.Get(ctx, tenantID, key)
+	}
+
+	setting, err := h.store.Set(ctx, tenantID, key, req.Value, userID)
+	if err != nil {
+		var invalid *ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error(), "code": "invalid_setting"})
+			return
+		}
+		h.logger.Error("Failed to save setting", "tenant", tenantID, "key", key, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save setting"})
+		return
+	}
+
+	h.logger.Info("Setting updated", "tenant", tenantID, "key", key, "overridden", setting.Overridden)
+	if def.AdminOnly {
+		audit.Record(ctx, h.store.db, tenantID, &userID, audit.ActionSettingUpdated, map[string]any{
+			"key":  key,
+			"from": previous.Value,
+			"to":   setting.Value,
+		})
+	}
+
+	c.JSON(http.StatusOK, setting)
+}
+
+// RegisterRoutes registers tenant settings routes
+func RegisterRoutes(r *gin.RouterGroup, store *Store, jwtManager *auth.JWTManager) {
+	handler := NewHandler(store)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	settingsRoutes := r.Group("/api/v1/settings")
+	settingsRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	settingsRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		settingsRoutes.GET("", handler.ListSettings)
+		settingsRoutes.GET("/brand", handler.GetBrand)
+		settingsRoutes.PUT("/brand", handler.UpdateBrand)
+		settingsRoutes.PUT("/:key", handler.UpdateSetting)
+	}
+}
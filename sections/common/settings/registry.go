@@ -0,0 +1,323 @@
+// Package settings holds tenant preferences: a registry of known settings
+// with their types, defaults and validation, and a store of per-tenant
+// overrides cached in Redis
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+
+	"awning-backend/common"
+)
+
+// Type is the JSON type of a setting's value
+type Type string
+
+const (
+	TypeBool       Type = "bool"
+	TypeString     Type = "string"
+	TypeInt        Type = "int"
+	TypeStringList Type = "string_list"
+)
+
+// Registered setting keys
+const (
+	DefaultImageOrientation = "default_image_orientation"
+	AutoPublish             = "auto_publish"
+	NotificationEmails      = "notification_emails"
+	BrandColors             = "brand_colors"
+	BrandFaviconURL         = "brand_favicon_url"
+	BrandPrimaryFont        = "brand_primary_font"
+	BrandSecondaryFont      = "brand_secondary_font"
+	SiteIndexable           = "site_indexable"
+	RobotsDisallow          = "robots_disallow"
+	AutoSaveDrafts          = "auto_save_drafts"
+
+	// Spending caps on AI usage per calendar month, 0 for none
+	MaxMonthlyTokens    = "max_monthly_tokens"
+	MaxMonthlyCostCents = "max_monthly_cost_cents"
+
+	// Notification preferences, one per notification type and channel. Email
+	// goes to notification_emails.
+	NotifyDomainExpiringEmail      = "notify_domain_expiring_email"
+	NotifyDomainExpiringInApp      = "notify_domain_expiring_in_app"
+	NotifyCertificateExpiringEmail = "notify_certificate_expiring_email"
+	NotifyCertificateExpiringInApp = "notify_certificate_expiring_in_app"
+	NotifyPaymentFailedEmail       = "notify_payment_failed_email"
+	NotifyPaymentFailedInApp       = "notify_payment_failed_in_app"
+	NotifyGenerationCompletedEmail = "notify_generation_completed_email"
+	NotifyGenerationCompletedInApp = "notify_generation_completed_in_app"
+	NotifyContactSubmissionEmail   = "notify_contact_submission_email"
+	NotifyContactSubmissionInApp   = "notify_contact_submission_in_app"
+	NotifySpendingCapEmail         = "notify_spending_cap_email"
+	NotifySpendingCapInApp         = "notify_spending_cap_in_app"
+)
+
+const (
+	MaxNotificationEmails = 10
+	MaxBrandColors        = 8
+	MaxRobotsDisallow     = 20
+	MaxBrandURLLength     = 1024
+)
+
+// Definition describes a registered setting. Validate, if set, runs on
+// values already decoded to the setting's Go type (bool, string, int or
+// []string).
+type Definition struct {
+	Key         string `json:"key"`
+	Type        Type   `json:"type"`
+	Default     any    `json:"default"`
+	Description string `json:"description"`
+	// Only tenant owners and admins may change the setting, and changes are
+	// audit-logged
+	AdminOnly bool `json:"adminOnly,omitempty"`
+
+	Validate func(value any) error `json:"-"`
+}
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var robotsPath = regexp.MustCompile(`^/[^\s#]*$`)
+
+var registry = map[string]Definition{
+	DefaultImageOrientation: {
+		Key:         DefaultImageOrientation,
+		Type:        TypeString,
+		Default:     "",
+		Description: "Orientation used for image searches that don't set one: landscape, portrait, squarish, or empty for any",
+		Validate:    oneOf("", "landscape", "portrait", "squarish"),
+	},
+	AutoPublish: {
+		Key:         AutoPublish,
+		Type:        TypeBool,
+		Default:     false,
+		Description: "Publish the generated site automatically after each generation",
+	},
+	NotificationEmails: {
+		Key:         NotificationEmails,
+		Type:        TypeStringList,
+		Default:     []string{},
+		Description: fmt.Sprintf("Addresses that receive tenant notifications, up to %d", MaxNotificationEmails),
+		Validate: eachOf(MaxNotificationEmails, func(s string) error {
+			if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+				return fmt.Errorf("invalid email address %q", s)
+			}
+			return nil
+		}),
+	},
+	BrandColors: {
+		Key:         BrandColors,
+		Type:        TypeStringList,
+		Default:     []string{},
+		Description: fmt.Sprintf("Brand colors as #rrggbb, up to %d, used in generated sites", MaxBrandColors),
+		Validate: eachOf(MaxBrandColors, func(s string) error {
+			if !hexColor.MatchString(s) {
+				return fmt.Errorf("invalid color %q, expected #rrggbb", s)
+			}
+			return nil
+		}),
+	},
+	BrandFaviconURL: {
+		Key:         BrandFaviconURL,
+		Type:        TypeString,
+		Default:     "",
+		Description: "Favicon linked from generated sites, an https URL such as an uploaded favicon's",
+		Validate: func(value any) error {
+			s := value.(string)
+			if s == "" {
+				return nil
+			}
+			if u, err := url.Parse(s); err != nil || u.Scheme != "https" || u.Host == "" || len(s) > MaxBrandURLLength {
+				return fmt.Errorf("invalid favicon URL %q, expected an https URL", s)
+			}
+			return nil
+		},
+	},
+	BrandPrimaryFont: {
+		Key:         BrandPrimaryFont,
+		Type:        TypeString,
+		Default:     "",
+		Description: "Font for headings in generated sites, from the curated Google Fonts list, or empty for the default",
+		Validate:    oneOf(append([]string{""}, common.BrandFontNames()...)...),
+	},
+	BrandSecondaryFont: {
+		Key:         BrandSecondaryFont,
+		Type:        TypeString,
+		Default:     "",
+		Description: "Font for body text in generated sites, from the curated Google Fonts list, or empty for the default",
+		Validate:    oneOf(append([]string{""}, common.BrandFontNames()...)...),
+	},
+	SiteIndexable: {
+		Key:         SiteIndexable,
+		Type:        TypeBool,
+		Default:     true,
+		Description: "Let search engines index the published site on custom domains; when false robots.txt disallows everything",
+	},
+	RobotsDisallow: {
+		Key:         RobotsDisallow,
+		Type:        TypeStringList,
+		Default:     []string{},
+		Description: fmt.Sprintf("Paths listed as Disallow in the published site's robots.txt, up to %d", MaxRobotsDisallow),
+		Validate: eachOf(MaxRobotsDisallow, func(s string) error {
+			if !robotsPath.MatchString(s) {
+				return fmt.Errorf("invalid path %q, expected a path starting with /", s)
+			}
+			return nil
+		}),
+	},
+	AutoSaveDrafts: {
+		Key:         AutoSaveDrafts,
+		Type:        TypeBool,
+		Default:     true,
+		Description: "Save each generated page to the filesystem under drafts/chat/<chatId>/",
+	},
+	MaxMonthlyTokens: {
+		Key:         MaxMonthlyTokens,
+		Type:        TypeInt,
+		Default:     0,
+		Description: "Prompt and completion tokens generations may use each calendar month (UTC), or 0 for no cap",
+		AdminOnly:   true,
+		Validate:    nonNegative,
+	},
+	MaxMonthlyCostCents: {
+		Key:         MaxMonthlyCostCents,
+		Type:        TypeInt,
+		Default:     0,
+		Description: "Cents generations may cost each calendar month (UTC) at the configured model prices, or 0 for no cap",
+		AdminOnly:   true,
+		Validate:    nonNegative,
+	},
+
+	NotifyDomainExpiringEmail:      notificationPreference(NotifyDomainExpiringEmail, "Email when a registered domain is about to expire", true),
+	NotifyDomainExpiringInApp:      notificationPreference(NotifyDomainExpiringInApp, "Notify in the app when a registered domain is about to expire", true),
+	NotifyCertificateExpiringEmail: notificationPreference(NotifyCertificateExpiringEmail, "Email when a domain's certificate is about to expire", true),
+	NotifyCertificateExpiringInApp: notificationPreference(NotifyCertificateExpiringInApp, "Notify in the app when a domain's certificate is about to expire", true),
+	NotifyPaymentFailedEmail:       notificationPreference(NotifyPaymentFailedEmail, "Email when a payment fails", true),
+	NotifyPaymentFailedInApp:       notificationPreference(NotifyPaymentFailedInApp, "Notify in the app when a payment fails", true),
+	NotifyGenerationCompletedEmail: notificationPreference(NotifyGenerationCompletedEmail, "Email when a site generation finishes", false),
+	NotifyGenerationCompletedInApp: notificationPreference(NotifyGenerationCompletedInApp, "Notify in the app when a site generation finishes", false),
+	NotifyContactSubmissionEmail:   notificationPreference(NotifyContactSubmissionEmail, "Email when a visitor submits the site's contact form", true),
+	NotifyContactSubmissionInApp:   notificationPreference(NotifyContactSubmissionInApp, "Notify in the app when a visitor submits the site's contact form", true),
+	NotifySpendingCapEmail:         notificationPreference(NotifySpendingCapEmail, "Email when AI usage reaches 80% and 100% of a monthly spending cap", true),
+	NotifySpendingCapInApp:         notificationPreference(NotifySpendingCapInApp, "Notify in the app when AI usage reaches 80% and 100% of a monthly spending cap", true),
+}
+
+// notificationPreference defines a setting switching one channel of a
+// notification type on or off
+func notificationPreference(key, description string, enabled bool) Definition {
+	return Definition{Key: key, Type: TypeBool, Default: enabled, Description: description}
+}
+
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// ValidationError reports a value that doesn't fit its setting
+type ValidationError struct {
+	Key    string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value for %s: %s", e.Key, e.Reason)
+}
+
+// Lookup returns the definition of a registered setting
+func Lookup(key string) (Definition, bool) {
+	def, ok := registry[key]
+	return def, ok
+}
+
+// Keys returns the registered setting keys, sorted
+func Keys() []string {
+	keys := make([]string, 0, len(registry))
+	for key := range registry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Definitions returns the registered settings, sorted by key
+func Definitions() []Definition {
+	defs := make([]Definition, 0, len(registry))
+	for _, key := range Keys() {
+		defs = append(defs, registry[key])
+	}
+	return defs
+}
+
+// Decode parses a JSON value as the setting's type and validates it
+func (d Definition) Decode(raw json.RawMessage) (any, error) {
+	var value any
+	var err error
+	switch d.Type {
+	case TypeBool:
+		var v bool
+		err = json.Unmarshal(raw, &v)
+		value = v
+	case TypeString:
+		var v string
+		err = json.Unmarshal(raw, &v)
+		value = v
+	case TypeInt:
+		var v int
+		err = json.Unmarshal(raw, &v)
+		value = v
+	case TypeStringList:
+		var v []string
+		err = json.Unmarshal(raw, &v)
+		if v == nil {
+			v = []string{}
+		}
+		value = v
+	default:
+		return nil, fmt.Errorf("setting %s has unsupported type %s", d.Key, d.Type)
+	}
+	if err != nil || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, &ValidationError{Key: d.Key, Reason: "expected " + string(d.Type)}
+	}
+
+	if d.Validate != nil {
+		if err := d.Validate(value); err != nil {
+			return nil, &ValidationError{Key: d.Key, Reason: err.Error()}
+		}
+	}
+	return value, nil
+}
+
+func nonNegative(value any) error {
+	if value.(int) < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
+
+func oneOf(allowed ...string) func(any) error {
+	return func(value any) error {
+		if !slices.Contains(allowed, value.(string)) {
+			return fmt.Errorf("must be one of %q", allowed)
+		}
+		return nil
+	}
+}
+
+func eachOf(max int, check func(string) error) func(any) error {
+	return func(value any) error {
+		items := value.([]string)
+		if len(items) > max {
+			return fmt.Errorf("at most %d entries allowed", max)
+		}
+		for _, item := range items {
+			if err := check(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
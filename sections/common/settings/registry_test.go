@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		key     string
+		raw     string
+		want    any
+		wantErr bool
+	}{
+		{AutoPublish, `true`, true, false},
+		{AutoPublish, `"true"`, nil, true},
+		{AutoPublish, `null`, nil, true},
+		{DefaultImageOrientation, `"portrait"`, "portrait", false},
+		{DefaultImageOrientation, `""`, "", false},
+		{DefaultImageOrientation, `"diagonal"`, nil, true},
+		{DefaultImageOrientation, `7`, nil, true},
+		{MaxMonthlyTokens, `50000`, 50000, false},
+		{MaxMonthlyTokens, `-1`, nil, true},
+		{MaxMonthlyTokens, `1.5`, nil, true},
+		{BrandColors, `["#ff0000", "#00AAff"]`, []string{"#ff0000", "#00AAff"}, false},
+		{BrandColors, `["red"]`, nil, true},
+		{BrandColors, `[]`, []string{}, false},
+		{BrandColors, `"#ff0000"`, nil, true},
+		{NotificationEmails, `["owner@example.com"]`, []string{"owner@example.com"}, false},
+		{NotificationEmails, `["Owner <owner@example.com>"]`, nil, true},
+		{NotificationEmails, `["not an email"]`, nil, true},
+		{BrandFaviconURL, `"https://cdn.example.com/favicon.ico"`, "https://cdn.example.com/favicon.ico", false},
+		{BrandFaviconURL, `"http://cdn.example.com/favicon.ico"`, nil, true},
+		{RobotsDisallow, `["/admin", "/drafts/"]`, []string{"/admin", "/drafts/"}, false},
+		{RobotsDisallow, `["admin"]`, nil, true},
+	}
+	for _, tt := range tests {
+		def, _ := Lookup(tt.key)
+		got, err := def.Decode(json.RawMessage(tt.raw))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Decode(%s, %s) error = %v, wantErr %v", tt.key, tt.raw, err, tt.wantErr)
+			continue
+		}
+		var invalid *ValidationError
+		if err != nil && (!errors.As(err, &invalid) || invalid.Key != tt.key) {
+			t.Errorf("Decode(%s, %s) error = %v, want a ValidationError for the key", tt.key, tt.raw, err)
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%s, %s) = %#v, want %#v", tt.key, tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeListLimits(t *testing.T) {
+	colors := make([]string, MaxBrandColors+1)
+	for i := range colors {
+		colors[i] = "#000000"
+	}
+	raw, _ := json.Marshal(colors)
+	def, _ := Lookup(BrandColors)
+	if _, err := def.Decode(raw); err == nil {
+		t.Errorf("Decode() of %d brand colors error = nil", len(colors))
+	}
+	if _, err := def.Decode(json.RawMessage(strings.Replace(string(raw), `"#000000",`, "", 1))); err != nil {
+		t.Errorf("Decode() of %d brand colors error = %v", MaxBrandColors, err)
+	}
+}
+
+func TestDefaultsAreValid(t *testing.T) {
+	for _, def := range Definitions() {
+		raw, err := json.Marshal(def.Default)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := def.Decode(raw)
+		if err != nil {
+			t.Errorf("default of %s doesn't decode: %v", def.Key, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, def.Default) {
+			t.Errorf("default of %s = %#v, decodes to %#v", def.Key, def.Default, value)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	keys := Keys()
+	if !slices.IsSorted(keys) || len(keys) != len(registry) {
+		t.Errorf("Keys() = %q, want every key sorted", keys)
+	}
+	for _, key := range keys {
+		if def, ok := Lookup(key); !ok || def.Key != key {
+			t.Errorf("Lookup(%s) = %+v, %v", key, def, ok)
+		}
+	}
+	if _, ok := Lookup("favourite_colour"); ok {
+		t.Error("Lookup() of an unknown key ok = true")
+	}
+}
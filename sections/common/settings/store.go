@@ -0,0 +1,232 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RedisCacheTTL bounds how long cached overrides live; writes invalidate
+// them explicitly
+const RedisCacheTTL = 10 * time.Minute
+
+// Setting is a setting's effective value for a tenant
+type Setting struct {
+	Definition
+	Value      any  `json:"value"`
+	Overridden bool `json:"overridden"`
+}
+
+// Store reads and writes tenant setting overrides, caching each tenant's
+// overrides in Redis
+type Store struct {
+	logger *slog.Logger
+	db     *db.DB
+	redis  storage.KV
+}
+
+// NewStore creates a new settings store
+func NewStore(database *db.DB, redis storage.KV) *Store {
+	return &Store{
+		logger: slog.With("service", "SettingsStore"),
+		db:     database,
+		redis:  redis,
+	}
+}
+
+func cacheKey(tenantSchema string) string {
+	return "settings:" + tenantSchema
+}
+
+// overrides returns the tenant's stored values by key, from Redis when cached
+func (s *Store) overrides(ctx context.Context, tenantSchema string) (map[string]json.RawMessage, error) {
+	key := cacheKey(tenantSchema)
+	if s.redis != nil {
+		if data, err := s.redis.Get(ctx, key); err == nil {
+			var cached map[string]json.RawMessage
+			if err := json.Unmarshal(data, &cached); err == nil {
+				return cached, nil
+			}
+		}
+	}
+
+	var rows []models.TenantSetting
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).Find(&rows).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %w", err)
+	}
+
+	values := make(map[string]json.RawMessage, len(rows))
+	for _, row := range rows {
+		values[row.Key] = row.Value
+	}
+
+	if s.redis != nil {
+		if data, err := json.Marshal(values); err == nil {
+			if err := s.redis.SetWithTTL(ctx, key, data, RedisCacheTTL); err != nil {
+				s.logger.Error("Failed to cache settings", "tenant", tenantSchema, "error", err)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (s *Store) invalidate(ctx context.Context, tenantSchema string) {
+	if s.redis == nil {
+		return
+	}
+	if err := s.redis.Delete(ctx, cacheKey(tenantSchema)); err != nil {
+		s.logger.Error("Failed to invalidate settings cache", "tenant", tenantSchema, "error", err)
+	}
+}
+
+// resolve returns the setting's effective value. Stored values that no
+// longer validate, e.g. after a registry change, fall back to the default.
+func (s *Store) resolve(def Definition, overrides map[string]json.RawMessage, tenantSchema string) Setting {
+	raw, ok := overrides[def.Key]
+	if !ok {
+		return Setting{Definition: def, Value: def.Default}
+	}
+	value, err := def.Decode(raw)
+	if err != nil {
+		s.logger.Warn("Ignoring invalid stored setting", "tenant", tenantSchema, "key", def.Key, "error", err)
+		return Setting{Definition: def, Value: def.Default}
+	}
+	return Setting{Definition: def, Value: value, Overridden: true}
+}
+
+// All returns every registered setting with its effective value for the
+// tenant, sorted by key
+func (s *Store) All(ctx context.Context, tenantSchema string) ([]Setting, error) {
+	overrides, err := s.overrides(ctx, tenantSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Setting, 0, len(registry))
+	for _, def := range Definitions() {
+		result = append(result, s.resolve(def, overrides, tenantSchema))
+	}
+	return result, nil
+}
+
+// Get returns one setting's effective value for the tenant
+func (s *Store) Get(ctx context.Context, tenantSchema, key string) (Setting, error) {
+	def, ok := Lookup(key)
+	if !ok {
+		return Setting{}, ErrUnknownSetting
+	}
+	overrides, err := s.overrides(ctx, tenantSchema)
+	if err != nil {
+		return Setting{Definition: def, Value: def.Default}, err
+	}
+	return s.resolve(def, overrides, tenantSchema), nil
+}
+
+// Set validates and stores the tenant's value for a setting. A JSON null
+// removes the override, restoring the default.
+func (s *Store) Set(ctx context.Context, tenantSchema, key string, raw json.RawMessage, userID uint) (Setting, error) {
+	def, ok := Lookup(key)
+	if !ok {
+		return Setting{}, ErrUnknownSetting
+	}
+
+	if string(raw) == "null" || len(raw) == 0 {
+		err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Unscoped().Where("tenant_schema = ? AND key = ?", tenantSchema, key).Delete(&models.TenantSetting{}).Error
+		})
+		if err != nil {
+			return Setting{}, fmt.Errorf("failed to reset setting: %w", err)
+		}
+		s.invalidate(ctx, tenantSchema)
+		return Setting{Definition: def, Value: def.Default}, nil
+	}
+
+	value, err := def.Decode(raw)
+	if err != nil {
+		return Setting{}, err
+	}
+	// Store the canonical encoding of the decoded value
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to encode setting: %w", err)
+	}
+
+	row := models.TenantSetting{
+		TenantSchema: tenantSchema,
+		Key:          key,
+		Value:        canonical,
+		UpdatedBy:    userID,
+	}
+	err = s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "tenant_schema"}, {Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_by", "updated_at"}),
+		}).Create(&row).Error
+	})
+	if err != nil {
+		return Setting{}, fmt.Errorf("failed to save setting: %w", err)
+	}
+	s.invalidate(ctx, tenantSchema)
+
+	return Setting{Definition: def, Value: value, Overridden: true}, nil
+}
+
+// value returns the setting's effective value, or its default when it can't
+// be loaded
+func (s *Store) value(ctx context.Context, tenantSchema, key string) any {
+	setting, err := s.Get(ctx, tenantSchema, key)
+	if err != nil {
+		s.logger.Error("Failed to get setting, using default", "tenant", tenantSchema, "key", key, "error", err)
+	}
+	return setting.Value
+}
+
+// GetBool returns a bool setting for the tenant, falling back to its default
+func (s *Store) GetBool(ctx context.Context, tenantSchema, key string) bool {
+	v, _ := s.value(ctx, tenantSchema, key).(bool)
+	return v
+}
+
+// GetString returns a string setting for the tenant, falling back to its
+// default
+func (s *Store) GetString(ctx context.Context, tenantSchema, key string) string {
+	v, _ := s.value(ctx, tenantSchema, key).(string)
+	return v
+}
+
+// GetInt returns an int setting for the tenant, falling back to its default
+func (s *Store) GetInt(ctx context.Context, tenantSchema, key string) int {
+	v, _ := s.value(ctx, tenantSchema, key).(int)
+	return v
+}
+
+// GetStringList returns a string list setting for the tenant, falling back
+// to its default
+func (s *Store) GetStringList(ctx context.Context, tenantSchema, key string) []string {
+	v, _ := s.value(ctx, tenantSchema, key).([]string)
+	return v
+}
+
+// isAdmin reports whether the user is an owner or admin of the tenant
+func (s *Store) isAdmin(ctx context.Context, tenantSchema string, userID uint) (bool, error) {
+	var count int64
+	err := s.db.DB.WithContext(ctx).Model(&models.UserTenant{}).
+		Where("user_id = ? AND tenant_schema = ? AND role IN ?", userID, tenantSchema, []string{"owner", "admin"}).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load membership: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,86 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"awning-backend/storage"
+)
+
+// newCachedStore returns a store without a database whose tenant_a
+// overrides are cached as overrides, a JSON object
+func newCachedStore(t *testing.T, overrides string) *Store {
+	t.Helper()
+
+	kv := storage.NewMemoryStore()
+	if err := kv.SetWithTTL(context.Background(), cacheKey("tenant_a"), []byte(overrides), RedisCacheTTL); err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(nil, kv)
+}
+
+func TestAllMergesDefaults(t *testing.T) {
+	s := newCachedStore(t, `{"auto_publish": true, "brand_colors": ["#112233"], "default_image_orientation": "diagonal", "retired_key": 1}`)
+
+	all, err := s.All(context.Background(), "tenant_a")
+	if err != nil {
+		t.Fatalf("All() error = %v", err)
+	}
+	if len(all) != len(registry) {
+		t.Errorf("All() returned %d settings, want all %d", len(all), len(registry))
+	}
+
+	byKey := map[string]Setting{}
+	for _, setting := range all {
+		byKey[setting.Key] = setting
+	}
+	tests := []struct {
+		key        string
+		want       any
+		overridden bool
+	}{
+		{AutoPublish, true, true},
+		{BrandColors, []string{"#112233"}, true},
+		{SiteIndexable, true, false},
+		{MaxMonthlyTokens, 0, false},
+		// Stored values that no longer validate fall back to the default
+		{DefaultImageOrientation, "", false},
+	}
+	for _, tt := range tests {
+		got := byKey[tt.key]
+		if !reflect.DeepEqual(got.Value, tt.want) || got.Overridden != tt.overridden {
+			t.Errorf("%s = %#v (overridden %v), want %#v (overridden %v)", tt.key, got.Value, got.Overridden, tt.want, tt.overridden)
+		}
+	}
+}
+
+func TestTypedAccessors(t *testing.T) {
+	s := newCachedStore(t, `{"auto_publish": true, "default_image_orientation": "portrait", "max_monthly_tokens": 1000, "robots_disallow": ["/admin"]}`)
+	ctx := context.Background()
+
+	if !s.GetBool(ctx, "tenant_a", AutoPublish) {
+		t.Error("GetBool(auto_publish) = false, want the override")
+	}
+	if !s.GetBool(ctx, "tenant_a", SiteIndexable) {
+		t.Error("GetBool(site_indexable) = false, want the default")
+	}
+	if got := s.GetString(ctx, "tenant_a", DefaultImageOrientation); got != "portrait" {
+		t.Errorf("GetString() = %q, want portrait", got)
+	}
+	if got := s.GetInt(ctx, "tenant_a", MaxMonthlyTokens); got != 1000 {
+		t.Errorf("GetInt() = %d, want 1000", got)
+	}
+	if got := s.GetStringList(ctx, "tenant_a", RobotsDisallow); !reflect.DeepEqual(got, []string{"/admin"}) {
+		t.Errorf("GetStringList() = %q", got)
+	}
+
+	// The wrong accessor gets the zero value rather than panicking
+	if got := s.GetString(ctx, "tenant_a", AutoPublish); got != "" {
+		t.Errorf("GetString() of a bool = %q", got)
+	}
+	if _, err := s.Get(ctx, "tenant_a", "favourite_colour"); !errors.Is(err, ErrUnknownSetting) {
+		t.Errorf("Get() of an unknown key error = %v, want ErrUnknownSetting", err)
+	}
+}
@@ -0,0 +1,442 @@
+// Package sites resolves request hosts to tenants and caches their published sites
+package sites
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/db"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// In-memory entries are short-lived so other instances pick up changes quickly
+	MemoryCacheTTL = 30 * time.Second
+	// Redis entries are invalidated explicitly on publish, rollback and domain changes
+	RedisCacheTTL = 10 * time.Minute
+
+	// Stored in the host cache for hosts that don't belong to any tenant
+	noTenant = "-"
+)
+
+var ErrNotPublished = errors.New("site not published")
+
+// Publication is the cached form of a tenant's current publication
+type Publication struct {
+	Version     int       `json:"version"`
+	ContentHash string    `json:"contentHash"`
+	PublishedAt time.Time `json:"publishedAt"`
+	Content     string    `json:"content"`
+	Signature   string    `json:"signature,omitempty"`
+
+	// Pages of a multi-page site other than /, by path
+	Pages map[string]string `json:"pages,omitempty"`
+}
+
+type memoryEntry struct {
+	value   any
+	expires time.Time
+}
+
+// Resolver maps request hosts to tenants and tenants to their current
+// publication, caching both in memory and in Redis
+type Resolver struct {
+	logger *slog.Logger
+	cfg    *common.Config
+	db     *db.DB
+	redis  storage.KV
+
+	appHosts map[string]struct{}
+
+	mu    sync.Mutex
+	cache map[string]memoryEntry
+}
+
+// NewResolver creates a new site resolver
+func NewResolver(cfg *common.Config, database *db.DB, redis storage.KV) *Resolver {
+	appHosts := map[string]struct{}{"localhost": {}}
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Host != "" {
+		appHosts[NormalizeHost(u.Host)] = struct{}{}
+	}
+	if base := strings.ToLower(cfg.SiteBaseDomain); base != "" {
+		appHosts[base] = struct{}{}
+		appHosts["www."+base] = struct{}{}
+	}
+	for _, host := range cfg.AppHosts {
+		appHosts[NormalizeHost(host)] = struct{}{}
+	}
+
+	return &Resolver{
+		logger:   slog.With("service", "SiteResolver"),
+		cfg:      cfg,
+		db:       database,
+		redis:    redis,
+		appHosts: appHosts,
+		cache:    make(map[string]memoryEntry),
+	}
+}
+
+// IsAppHost reports whether the host serves the app itself rather than a
+// tenant site: the base URL host, the site base domain, configured app hosts,
+// IP addresses and single-label hosts such as localhost or service names
+func (r *Resolver) IsAppHost(host string) bool {
+	host = NormalizeHost(host)
+	if _, ok := r.appHosts[host]; ok {
+		return true
+	}
+	return net.ParseIP(host) != nil || !strings.Contains(host, ".")
+}
+
+// IsPreviewHost reports whether the host is a tenant subdomain of the site
+// base domain rather than a verified custom domain
+func (r *Resolver) IsPreviewHost(host string) bool {
+	base := strings.ToLower(r.cfg.SiteBaseDomain)
+	return base != "" && strings.HasSuffix(NormalizeHost(host), "."+base)
+}
+
+func hostCacheKey(host string) string {
+	return "site:host:" + host
+}
+
+func publicationCacheKey(tenantSchema string) string {
+	return "site:pub:" + tenantSchema
+}
+
+func (r *Resolver) getMemory(key string) (any, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		delete(r.cache, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (r *Resolver) setMemory(key string, value any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache[key] = memoryEntry{value: value, expires: time.Now().Add(MemoryCacheTTL)}
+}
+
+func (r *Resolver) invalidate(ctx context.Context, key string) {
+	r.mu.Lock()
+	delete(r.cache, key)
+	r.mu.Unlock()
+
+	if r.redis != nil {
+		if err := r.redis.Delete(ctx, key); err != nil {
+			r.logger.Error("Failed to invalidate cache", "key", key, "error", err)
+		}
+	}
+}
+
+// NormalizeHost lowercases the host and strips the port and any trailing dot
+func NormalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// ResolveTenant returns the tenant schema serving the given host, or "" when
+// the host doesn't belong to any tenant
+func (r *Resolver) ResolveTenant(ctx context.Context, host string) (string, error) {
+	host = NormalizeHost(host)
+	if host == "" {
+		return "", nil
+	}
+
+	key := hostCacheKey(host)
+	if v, ok := r.getMemory(key); ok {
+		if v.(string) == noTenant {
+			return "", nil
+		}
+		return v.(string), nil
+	}
+
+	if r.redis != nil {
+		if data, err := r.redis.Get(ctx, key); err == nil {
+			tenantSchema := string(data)
+			r.setMemory(key, tenantSchema)
+			if tenantSchema == noTenant {
+				return "", nil
+			}
+			return tenantSchema, nil
+		}
+	}
+
+	tenantSchema, err := r.lookupTenant(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	cached := tenantSchema
+	if cached == "" {
+		cached = noTenant
+	}
+	r.setMemory(key, cached)
+	if r.redis != nil {
+		if err := r.redis.SetWithTTL(ctx, key, []byte(cached), RedisCacheTTL); err != nil {
+			r.logger.Error("Failed to cache host", "host", host, "error", err)
+		}
+	}
+
+	return tenantSchema, nil
+}
+
+// lookupTenant resolves the host from the database: first as a subdomain of
+// the site base domain, then as a verified custom domain
+func (r *Resolver) lookupTenant(ctx context.Context, host string) (string, error) {
+	bare := strings.TrimPrefix(host, "www.")
+
+	if base := strings.ToLower(r.cfg.SiteBaseDomain); base != "" && strings.HasSuffix(bare, "."+base) {
+		label := strings.TrimSuffix(bare, "."+base)
+		if strings.Contains(label, ".") {
+			return "", nil
+		}
+
+		var count int64
+		err := r.db.DB.WithContext(ctx).Model(&models.Tenant{}).
+			Where("schema_name = ? AND active = ?", label, true).
+			Count(&count).Error
+		if err != nil {
+			return "", fmt.Errorf("failed to look up tenant: %w", err)
+		}
+		if count > 0 {
+			return label, nil
+		}
+		return "", nil
+	}
+
+	// Domains live in tenant schemas, so check each active tenant. Results
+	// are cached, so this only runs on a cache miss.
+	var tenants []models.Tenant
+	if err := r.db.DB.WithContext(ctx).Where("active = ?", true).Find(&tenants).Error; err != nil {
+		return "", fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	candidates := []string{bare, "www." + bare}
+	for _, tenant := range tenants {
+		var count int64
+		err := r.db.WithTenant(ctx, tenant.SchemaName, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantDomain{}).
+				Where("domain IN ? AND verified = ?", candidates, true).
+				Count(&count).Error
+		})
+		if err != nil {
+			r.logger.Warn("Failed to look up domains for tenant", "tenant", tenant.SchemaName, "error", err)
+			continue
+		}
+		if count > 0 {
+			return tenant.SchemaName, nil
+		}
+	}
+
+	return "", nil
+}
+
+// ResolveOrigin returns the tenant whose site is served on a CORS origin's
+// host, or "" for other origins: those that aren't https, carry a path, or
+// are the app's own hosts. It shares ResolveTenant's cache.
+func (r *Resolver) ResolveOrigin(ctx context.Context, origin string) (string, error) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != "https" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", nil
+	}
+	if r.IsAppHost(u.Host) {
+		return "", nil
+	}
+	return r.ResolveTenant(ctx, u.Host)
+}
+
+// InvalidateHost drops the cached tenant mapping for a host
+func (r *Resolver) InvalidateHost(ctx context.Context, host string) {
+	host = NormalizeHost(host)
+	r.invalidate(ctx, hostCacheKey(host))
+	if bare := strings.TrimPrefix(host, "www."); bare != host {
+		r.invalidate(ctx, hostCacheKey(bare))
+	} else {
+		r.invalidate(ctx, hostCacheKey("www."+host))
+	}
+}
+
+// CurrentPublication returns the tenant's live publication
+func (r *Resolver) CurrentPublication(ctx context.Context, tenantSchema string) (*Publication, error) {
+	key := publicationCacheKey(tenantSchema)
+	if v, ok := r.getMemory(key); ok {
+		return v.(*Publication), nil
+	}
+
+	if r.redis != nil {
+		if data, err := r.redis.Get(ctx, key); err == nil {
+			var pub Publication
+			if err := json.Unmarshal(data, &pub); err == nil {
+				r.setMemory(key, &pub)
+				return &pub, nil
+			}
+		}
+	}
+
+	var publication models.TenantPublication
+	err := r.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND current = ?", tenantSchema, true).First(&publication).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotPublished
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load publication: %w", err)
+	}
+
+	pub := &Publication{
+		Version:     publication.Version,
+		ContentHash: publication.ContentHash,
+		PublishedAt: publication.PublishedAt,
+		Content:     publication.Content,
+		Signature:   publication.Signature,
+	}
+	if publication.Pages != "" {
+		if err := json.Unmarshal([]byte(publication.Pages), &pub.Pages); err != nil {
+			r.logger.Error("Failed to decode publication pages", "tenant", tenantSchema, "version", publication.Version, "error", err)
+		}
+	}
+
+	r.setMemory(key, pub)
+	if r.redis != nil {
+		if data, err := json.Marshal(pub); err == nil {
+			if err := r.redis.SetWithTTL(ctx, key, data, RedisCacheTTL); err != nil {
+				r.logger.Error("Failed to cache publication", "tenant", tenantSchema, "error", err)
+			}
+		}
+	}
+
+	return pub, nil
+}
+
+// SitemapEntry is a published page listed in the site's sitemap
+type SitemapEntry struct {
+	Path         string
+	LastModified time.Time
+}
+
+// SitemapEntries returns the tenant's published pages, built from the cached
+// current publication: / and the other pages of a multi-page site, sorted.
+func (r *Resolver) SitemapEntries(ctx context.Context, tenantSchema string) ([]SitemapEntry, error) {
+	pub, err := r.CurrentPublication(ctx, tenantSchema)
+	if errors.Is(err, ErrNotPublished) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := []SitemapEntry{{Path: "/", LastModified: pub.PublishedAt}}
+	for _, path := range slices.Sorted(maps.Keys(pub.Pages)) {
+		entries = append(entries, SitemapEntry{Path: path, LastModified: pub.PublishedAt})
+	}
+	return entries, nil
+}
+
+// InvalidatePublication drops the cached current publication for a tenant
+func (r *Resolver) InvalidatePublication(ctx context.Context, tenantSchema string) {
+	r.invalidate(ctx, publicationCacheKey(tenantSchema))
+}
+
+func tenantStatusCacheKey(tenantSchema string) string {
+	return "site:status:" + tenantSchema
+}
+
+type tenantStatus struct {
+	deletionAt *time.Time
+}
+
+// DeletionScheduledAt returns when a tenant pending deletion will be removed,
+// or nil for tenants in good standing. Results are cached in memory only, so
+// a restore takes effect on other instances within MemoryCacheTTL.
+func (r *Resolver) DeletionScheduledAt(ctx context.Context, tenantSchema string) (*time.Time, error) {
+	key := tenantStatusCacheKey(tenantSchema)
+	if v, ok := r.getMemory(key); ok {
+		return v.(tenantStatus).deletionAt, nil
+	}
+
+	var tenant models.Tenant
+	err := r.db.DB.WithContext(ctx).
+		Select("schema_name", "active", "deletion_scheduled_at").
+		Where("schema_name = ?", tenantSchema).
+		First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		r.setMemory(key, tenantStatus{})
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up tenant: %w", err)
+	}
+
+	status := tenantStatus{}
+	if !tenant.Active && tenant.DeletionScheduledAt != nil {
+		status.deletionAt = tenant.DeletionScheduledAt
+	}
+
+	r.setMemory(key, status)
+	return status.deletionAt, nil
+}
+
+// InvalidateTenantStatus drops the cached deletion status for a tenant
+func (r *Resolver) InvalidateTenantStatus(ctx context.Context, tenantSchema string) {
+	r.invalidate(ctx, tenantStatusCacheKey(tenantSchema))
+}
+
+func tenantTimezoneCacheKey(tenantSchema string) string {
+	return "site:tz:" + tenantSchema
+}
+
+// TenantTimezone returns the IANA timezone from the tenant's profile, or UTC
+// when the tenant has no profile or an unknown zone. Results are cached in
+// memory only, so profile changes reach other instances within MemoryCacheTTL.
+func (r *Resolver) TenantTimezone(ctx context.Context, tenantSchema string) (string, error) {
+	key := tenantTimezoneCacheKey(tenantSchema)
+	if v, ok := r.getMemory(key); ok {
+		return v.(string), nil
+	}
+
+	var timezones []string
+	err := r.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantProfile{}).
+			Where("tenant_schema = ?", tenantSchema).
+			Limit(1).
+			Pluck("timezone", &timezones).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up tenant timezone: %w", err)
+	}
+
+	timezone := "UTC"
+	if len(timezones) > 0 && timezones[0] != "" {
+		if _, err := time.LoadLocation(timezones[0]); err == nil {
+			timezone = timezones[0]
+		}
+	}
+
+	r.setMemory(key, timezone)
+	return timezone, nil
+}
+
+// InvalidateTenantTimezone drops the cached timezone for a tenant
+func (r *Resolver) InvalidateTenantTimezone(ctx context.Context, tenantSchema string) {
+	r.invalidate(ctx, tenantTimezoneCacheKey(tenantSchema))
+}
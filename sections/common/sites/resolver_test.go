@@ -0,0 +1,87 @@
+package sites
+
+import (
+	"context"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/storage"
+)
+
+// newCachedResolver returns a resolver without a database whose Redis cache
+// maps hosts to tenants, "-" for none
+func newCachedResolver(t *testing.T, hosts map[string]string) (*Resolver, *storage.MemoryStore) {
+	t.Helper()
+
+	cfg := common.DefaultConfig()
+	cfg.BaseURL = "https://app.awning.test"
+	cfg.SiteBaseDomain = "awning.site"
+	kv := storage.NewMemoryStore()
+	for host, tenant := range hosts {
+		if err := kv.SetWithTTL(context.Background(), hostCacheKey(host), []byte(tenant), RedisCacheTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewResolver(cfg, nil, kv), kv
+}
+
+func TestResolveOrigin(t *testing.T) {
+	r, _ := newCachedResolver(t, map[string]string{
+		"bakery.example":     "tenant_bakery",
+		"bakery.awning.site": "tenant_bakery",
+		"unknown.example":    noTenant,
+		"app.awning.test":    "tenant_app",
+	})
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://bakery.example", "tenant_bakery"},
+		{"https://Bakery.Example:443", "tenant_bakery"},
+		{"https://bakery.example/", "tenant_bakery"},
+		{"https://bakery.awning.site", "tenant_bakery"},
+		{"https://unknown.example", ""},
+		// Only plain https origins of tenant sites
+		{"http://bakery.example", ""},
+		{"https://bakery.example/contact", ""},
+		{"https://bakery.example?x=1", ""},
+		{"https://user@bakery.example", ""},
+		{"null", ""},
+		{"", ""},
+		// The app's own hosts are never a tenant's
+		{"https://app.awning.test", ""},
+		{"https://awning.site", ""},
+		{"https://localhost", ""},
+		{"https://127.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		got, err := r.ResolveOrigin(context.Background(), tt.origin)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveOrigin(%q) = %q, %v; want %q", tt.origin, got, err, tt.want)
+		}
+	}
+}
+
+func TestInvalidateHost(t *testing.T) {
+	r, kv := newCachedResolver(t, map[string]string{"bakery.example": "tenant_bakery", "www.bakery.example": "tenant_bakery"})
+	ctx := context.Background()
+
+	// Resolving fills the in-memory cache as well
+	if got, _ := r.ResolveOrigin(ctx, "https://bakery.example"); got != "tenant_bakery" {
+		t.Fatalf("ResolveOrigin() = %q, want tenant_bakery", got)
+	}
+	if _, ok := r.getMemory(hostCacheKey("bakery.example")); !ok {
+		t.Fatal("resolved host isn't cached in memory")
+	}
+
+	r.InvalidateHost(ctx, "BAKERY.example.")
+	if _, ok := r.getMemory(hostCacheKey("bakery.example")); ok {
+		t.Error("host still cached in memory after InvalidateHost()")
+	}
+	for _, host := range []string{"bakery.example", "www.bakery.example"} {
+		if _, err := kv.Get(ctx, hostCacheKey(host)); err == nil {
+			t.Errorf("%s still cached in Redis after InvalidateHost()", host)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package unsplash
+
+import (
+	"log/slog"
+	"net/http"
+
+	"awning-backend/middleware"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the admin Unsplash routes
+type Handler struct {
+	logger *slog.Logger
+	svc    *services.UnsplashService
+}
+
+// NewHandler creates a new Unsplash admin handler; svc is nil when Unsplash
+// isn't configured
+func NewHandler(svc *services.UnsplashService) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "UnsplashHandler"),
+		svc:    svc,
+	}
+}
+
+// GetStatus handles GET /api/v1/admin/unsplash/status, returning the
+// Unsplash rate limit as of the latest response
+func (h *Handler) GetStatus(c *gin.Context) {
+	if h.svc == nil {
+		c.JSON(http.StatusOK, gin.H{"configured": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"configured": true, "quota": h.svc.Quota()})
+}
+
+// RegisterRoutes registers the admin Unsplash routes, authenticated with the
+// server API key
+func RegisterRoutes(r *gin.RouterGroup, svc *services.UnsplashService, apiKey, apiKeySecret string) {
+	handler := NewHandler(svc)
+
+	adminRoutes := r.Group("/api/v1/admin/unsplash")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(apiKey, apiKeySecret)))
+	{
+		adminRoutes.GET("/status", handler.GetStatus)
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
 	"awning-backend/sections/models"
@@ -46,7 +48,7 @@ func NewHandler(deps *sections.Dependencies, jwtManager *auth.JWTManager) *Handl
 // RegisterRequest represents a user registration request
 type RegisterRequest struct {
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
+	Password  string `json:"password" binding:"required"`
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 }
@@ -65,7 +67,7 @@ type PasswordResetRequest struct {
 // PasswordResetConfirmRequest represents a password reset confirmation request
 type PasswordResetConfirmRequest struct {
 	Token       string `json:"token" binding:"required"`
-	NewPassword string `json:"newPassword" binding:"required,min=8"`
+	NewPassword string `json:"newPassword" binding:"required"`
 }
 
 // AuthResponse represents an authentication response
@@ -74,6 +76,22 @@ type AuthResponse struct {
 	User  UserResponse `json:"user"`
 }
 
+// UpdateUserRequest represents an update to the current user's name
+type UpdateUserRequest struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// TenantResponse represents a tenant the user belongs to
+type TenantResponse struct {
+	SchemaName  string `json:"schemaName"`
+	DomainURL   string `json:"domainUrl"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Role        string `json:"role"`
+	Primary     bool   `json:"primary"`
+}
+
 // UserResponse represents a user in API responses
 type UserResponse struct {
 	ID            uint       `json:"id"`
@@ -92,6 +110,11 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
+	if err := validatePassword(h.deps.Config, req.Email, req.Password); err != nil {
+		i18n.Error(c, http.StatusBadRequest, WeakPasswordCode, err.Error())
+		return
+	}
+
 	// Check if user already exists
 	var existingUser models.User
 	if err := h.deps.DB.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
@@ -147,11 +170,22 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// Find user
+	ctx := c.Request.Context()
+	email := normalizeEmail(req.Email)
+	ip := middleware.ClientIP(c)
+
+	if lockout := h.loginLockout(ctx, email, ip); lockout > 0 {
+		respondLoginLocked(c, lockout)
+		return
+	}
+
+	// Find user. Unknown emails still pay for a bcrypt comparison so response
+	// times don't reveal which accounts exist.
 	var user models.User
-	if err := h.deps.DB.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := h.deps.DB.DB.Where("LOWER(email) = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+			compareDummyPassword(req.Password)
+			h.loginFailed(c, email, ip, nil)
 			return
 		}
 		h.logger.Error("Failed to find user", "error", err)
@@ -159,28 +193,34 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	// Check if user is active
-	if !user.Active {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "account is disabled"})
+	// Verify password
+	if user.PasswordHash == "" {
+		compareDummyPassword(req.Password)
+		h.loginFailed(c, email, ip, &user.ID)
 		return
 	}
-
-	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		h.loginFailed(c, email, ip, &user.ID)
 		return
 	}
 
+	// Check if user is active, only once the password is known to be right
+	if !user.Active {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "account is disabled"})
+		return
+	}
+
+	h.clearLoginFailures(ctx, email)
+
 	// Update last login time
 	now := time.Now()
 	h.deps.DB.DB.Model(&user).Update("last_login_at", now)
 	user.LastLoginAt = &now
 
-	// Get default tenant for user (if any)
-	var userTenant models.UserTenant
-	tenantSchema := ""
-	if err := h.deps.DB.DB.Where("user_id = ?", user.ID).Order("created_at ASC").First(&userTenant).Error; err == nil {
-		tenantSchema = userTenant.TenantSchema
+	// Get the user's primary tenant (if any)
+	tenantSchema, err := h.userService.GetPrimaryTenantSchema(ctx, user.ID)
+	if err != nil {
+		tenantSchema = ""
 	}
 
 	// Generate JWT token
@@ -214,7 +254,7 @@ func (h *Handler) RequestPasswordReset(c *gin.Context) {
 
 	// Find user
 	var user models.User
-	if err := h.deps.DB.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := h.deps.DB.DB.Where("LOWER(email) = ?", normalizeEmail(req.Email)).First(&user).Error; err != nil {
 		// Don't reveal if user exists
 		c.JSON(http.StatusOK, gin.H{"message": "if an account exists with this email, a reset link will be sent"})
 		return
@@ -229,10 +269,10 @@ func (h *Handler) RequestPasswordReset(c *gin.Context) {
 	}
 	token := hex.EncodeToString(tokenBytes)
 
-	// Save token with expiry
+	// Save the token hash with expiry; only the emailed link has the token
 	expires := time.Now().Add(1 * time.Hour)
 	h.deps.DB.DB.Model(&user).Updates(map[string]interface{}{
-		"password_reset_token":   token,
+		"password_reset_token":   hashResetToken(token),
 		"password_reset_expires": expires,
 	})
 
@@ -257,7 +297,7 @@ func (h *Handler) ConfirmPasswordReset(c *gin.Context) {
 
 	// Find user by reset token
 	var user models.User
-	if err := h.deps.DB.DB.Where("password_reset_token = ?", req.Token).First(&user).Error; err != nil {
+	if err := h.deps.DB.DB.Where("password_reset_token = ?", hashResetToken(req.Token)).First(&user).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
 		return
 	}
@@ -268,6 +308,11 @@ func (h *Handler) ConfirmPasswordReset(c *gin.Context) {
 		return
 	}
 
+	if err := validatePassword(h.deps.Config, user.Email, req.NewPassword); err != nil {
+		i18n.Error(c, http.StatusBadRequest, WeakPasswordCode, err.Error())
+		return
+	}
+
 	// Hash new password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -324,10 +369,7 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		FirstName string `json:"firstName"`
-		LastName  string `json:"lastName"`
-	}
+	var req UpdateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -365,23 +407,9 @@ func (h *Handler) GetTenants(c *gin.Context) {
 		return
 	}
 
-	type TenantResponse struct {
-		SchemaName  string `json:"schemaName"`
-		DomainURL   string `json:"domainUrl"`
-		Name        string `json:"name"`
-		DisplayName string `json:"displayName"`
-		Role        string `json:"role"`
-	}
-
 	tenants := make([]TenantResponse, len(userTenants))
-	for i, ut := range userTenants {
-		tenants[i] = TenantResponse{
-			SchemaName:  ut.Tenant.SchemaName,
-			DomainURL:   ut.Tenant.DomainURL,
-			Name:        ut.Tenant.Name,
-			DisplayName: ut.Tenant.DisplayName,
-			Role:        ut.Role,
-		}
+	for i := range userTenants {
+		tenants[i] = toTenantResponse(&userTenants[i].Tenant, &userTenants[i])
 	}
 
 	response := common.ApiResponse[[]TenantResponse]{
@@ -398,7 +426,7 @@ func (h *Handler) toUserResponse(user *models.User) UserResponse {
 		FirstName:     user.FirstName,
 		LastName:      user.LastName,
 		EmailVerified: user.EmailVerified,
-		LastLoginAt:   user.LastLoginAt,
+		LastLoginAt:   common.UTC(user.LastLoginAt),
 	}
 }
 
@@ -422,5 +450,13 @@ func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager
 		protected.GET("/me", handler.GetProfile)
 		protected.PUT("/me", handler.UpdateProfile)
 		protected.GET("/me/tenants", handler.GetTenants)
+		protected.PATCH("/me/tenants/:schema/primary", handler.SetPrimaryTenant)
+	}
+
+	// Tenant creation for existing users; no tenant header needed
+	tenants := r.Group("/api/v1/tenants")
+	tenants.Use(auth.JWTAuthMiddleware(jwtManager))
+	{
+		tenants.POST("", handler.CreateTenant)
 	}
 }
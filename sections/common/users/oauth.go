@@ -9,12 +9,15 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"time"
 
 	"awning-backend/common"
+	"awning-backend/i18n"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
 	"awning-backend/sections/models"
+	"awning-backend/storage"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
@@ -100,9 +103,10 @@ func (h *OAuthHandler) GoogleLogin(c *gin.Context) {
 		return
 	}
 
-	state := generateOAuthState()
-	// Store state in session/cookie for verification
-	c.SetCookie("oauth_state", state, 300, "/", "", true, true)
+	state, ok := h.beginOAuth(c, "google")
+	if !ok {
+		return
+	}
 
 	url := h.configs.Google.AuthCodeURL(state)
 	h.logger.Debug("Redirecting to Google OAuth URL", "url", url)
@@ -128,17 +132,9 @@ func (h *OAuthHandler) GoogleCallback(c *gin.Context) {
 		return
 	}
 
-	// Verify state
-	state := c.Query("state")
-	storedState, err := c.Cookie("oauth_state")
-	if err != nil || state != storedState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
-		return
-	}
-
-	code := c.Query("code")
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing authorization code"})
+	// Verify state, and that the code hasn't been used
+	code, ok := h.verifyOAuthCallback(c, "google")
+	if !ok {
 		return
 	}
 
@@ -183,16 +179,14 @@ func (h *OAuthHandler) GoogleCallback(c *gin.Context) {
 
 	// Store session in Redis
 	sessionID := generateOAuthState() // Generate unique session ID
-	if err := h.deps.Redis.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
+	if err := h.deps.KV.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
 		h.logger.Error("Failed to store session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store session"})
 		return
 	}
 
-	// Redirect to frontend with token (or return JSON based on Accept header)
-	frontendURL := c.Query("redirect_uri")
-	if frontendURL != "" {
-		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"?token="+jwtToken+"&session_id="+sessionID)
+	// Redirect to the frontend with a one-time code (or return JSON)
+	if h.redirectWithCode(c, user, jwtToken, sessionID) {
 		return
 	}
 
@@ -260,8 +254,10 @@ func (h *OAuthHandler) FacebookLogin(c *gin.Context) {
 		return
 	}
 
-	state := generateOAuthState()
-	c.SetCookie("oauth_state", state, 300, "/", "", true, true)
+	state, ok := h.beginOAuth(c, "facebook")
+	if !ok {
+		return
+	}
 
 	url := h.configs.Facebook.AuthCodeURL(state)
 
@@ -286,16 +282,8 @@ func (h *OAuthHandler) FacebookCallback(c *gin.Context) {
 		return
 	}
 
-	state := c.Query("state")
-	storedState, err := c.Cookie("oauth_state")
-	if err != nil || state != storedState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
-		return
-	}
-
-	code := c.Query("code")
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing authorization code"})
+	code, ok := h.verifyOAuthCallback(c, "facebook")
+	if !ok {
 		return
 	}
 
@@ -337,15 +325,14 @@ func (h *OAuthHandler) FacebookCallback(c *gin.Context) {
 
 	// Store session in Redis
 	sessionID := generateOAuthState() // Generate unique session ID
-	if err := h.deps.Redis.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
+	if err := h.deps.KV.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
 		h.logger.Error("Failed to store session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store session"})
 		return
 	}
 
-	frontendURL := c.Query("redirect_uri")
-	if frontendURL != "" {
-		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"?token="+jwtToken+"&session_id="+sessionID)
+	// Redirect to the frontend with a one-time code (or return JSON)
+	if h.redirectWithCode(c, user, jwtToken, sessionID) {
 		return
 	}
 
@@ -412,8 +399,10 @@ func (h *OAuthHandler) TikTokLogin(c *gin.Context) {
 		return
 	}
 
-	state := generateOAuthState()
-	c.SetCookie("oauth_state", state, 300, "/", "", true, true)
+	state, ok := h.beginOAuth(c, "tiktok")
+	if !ok {
+		return
+	}
 
 	// TikTok requires additional parameters
 	url := fmt.Sprintf("%s?client_key=%s&scope=%s&response_type=code&redirect_uri=%s&state=%s",
@@ -445,16 +434,8 @@ func (h *OAuthHandler) TikTokCallback(c *gin.Context) {
 		return
 	}
 
-	state := c.Query("state")
-	storedState, err := c.Cookie("oauth_state")
-	if err != nil || state != storedState {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state"})
-		return
-	}
-
-	code := c.Query("code")
-	if code == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "missing authorization code"})
+	code, ok := h.verifyOAuthCallback(c, "tiktok")
+	if !ok {
 		return
 	}
 
@@ -496,15 +477,14 @@ func (h *OAuthHandler) TikTokCallback(c *gin.Context) {
 
 	// Store session in Redis
 	sessionID := generateOAuthState() // Generate unique session ID
-	if err := h.deps.Redis.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
+	if err := h.deps.KV.SetSession(c.Request.Context(), sessionID, jwtToken, 24*time.Hour); err != nil {
 		h.logger.Error("Failed to store session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store session"})
 		return
 	}
 
-	frontendURL := c.Query("redirect_uri")
-	if frontendURL != "" {
-		c.Redirect(http.StatusTemporaryRedirect, frontendURL+"?token="+jwtToken+"&session_id="+sessionID)
+	// Redirect to the frontend with a one-time code (or return JSON)
+	if h.redirectWithCode(c, user, jwtToken, sessionID) {
 		return
 	}
 
@@ -569,6 +549,112 @@ func (h *OAuthHandler) findOrCreateTikTokUser(ctx context.Context, info *tiktokU
 	return h.userService.FindOrCreateUserWithOAuth(ctx, user, "tiktok", info.OpenID)
 }
 
+// OAuthCodeTTL is how long the one-time code handed to the frontend can be
+// exchanged for the login
+const OAuthCodeTTL = time.Minute
+
+// OAuthExchangeRequest exchanges a one-time code for the login
+type OAuthExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// OAuthExchangeResponse is the login a one-time code was issued for
+type OAuthExchangeResponse struct {
+	Token     string       `json:"token"`
+	SessionID string       `json:"sessionId"`
+	User      UserResponse `json:"user"`
+}
+
+// oauthLogin is stored in Redis under a one-time code
+type oauthLogin struct {
+	Token     string `json:"token"`
+	SessionID string `json:"session_id"`
+	UserID    uint   `json:"user_id"`
+}
+
+// redirectWithCode redirects to the redirect_uri query parameter, if given,
+// with a one-time code in the fragment that the frontend exchanges for the
+// token, so the token never appears in a URL. Targets outside
+// allowed_redirect_origins are replaced by frontend_url, or rejected with 400
+// when it is unset. It returns false when there is no redirect_uri.
+func (h *OAuthHandler) redirectWithCode(c *gin.Context, user *models.User, jwtToken, sessionID string) bool {
+	target := c.Query("redirect_uri")
+	if target == "" {
+		return false
+	}
+
+	if err := h.deps.Config.ValidateRedirectURL(target); err != nil {
+		h.logger.Warn("OAuth redirect_uri not allowed", "redirect_uri", target)
+		if h.deps.Config.FrontendURL == "" {
+			i18n.Error(c, http.StatusBadRequest, "redirect_not_allowed", "redirect_uri is not allowed")
+			return true
+		}
+		target = h.deps.Config.FrontendURL
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid redirect_uri"})
+		return true
+	}
+
+	login, _ := json.Marshal(oauthLogin{Token: jwtToken, SessionID: sessionID, UserID: user.ID})
+	code := generateOAuthState()
+	if err := h.deps.Redis.SetOAuthCode(c.Request.Context(), code, login, OAuthCodeTTL); err != nil {
+		h.logger.Error("Failed to store OAuth code", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store session"})
+		return true
+	}
+
+	u.Fragment = "code=" + code
+	c.Redirect(http.StatusTemporaryRedirect, u.String())
+	return true
+}
+
+// ExchangeCode returns the login for a one-time code from an OAuth redirect.
+// Each code can be used once, within OAuthCodeTTL.
+func (h *OAuthHandler) ExchangeCode(c *gin.Context) {
+	var req OAuthExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := h.deps.Redis.ConsumeOAuthCode(c.Request.Context(), req.Code)
+	if errors.Is(err, storage.ErrOAuthCodeNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired code"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get OAuth code", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange code"})
+		return
+	}
+
+	var login oauthLogin
+	if err := json.Unmarshal(data, &login); err != nil {
+		h.logger.Error("Failed to decode OAuth login", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to exchange code"})
+		return
+	}
+
+	var user models.User
+	if err := h.deps.DB.DB.First(&user, login.UserID).Error; err != nil {
+		h.logger.Error("Failed to get user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, common.ApiResponse[OAuthExchangeResponse]{
+		Data: OAuthExchangeResponse{
+			Token:     login.Token,
+			SessionID: login.SessionID,
+			User:      toUserResponse(&user),
+		},
+		Success: true,
+	})
+}
+
 func generateOAuthState() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -582,7 +668,7 @@ func toUserResponse(user *models.User) UserResponse {
 		FirstName:     user.FirstName,
 		LastName:      user.LastName,
 		EmailVerified: user.EmailVerified,
-		LastLoginAt:   user.LastLoginAt,
+		LastLoginAt:   common.UTC(user.LastLoginAt),
 	}
 }
 
@@ -596,6 +682,7 @@ func RegisterOAuthRoutes(frontendRoutes *gin.RouterGroup, callbackRoutes *gin.Ro
 
 	oauth := frontendRoutes.Group("/api/v1/auth")
 	{
+		oauth.POST("/oauth/exchange", handler.ExchangeCode)
 		if configs.Google != nil {
 			oauth.GET("/google", handler.GoogleLogin)
 			oauth.GET("/google/callback", handler.GoogleCallback)
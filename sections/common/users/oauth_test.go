@@ -0,0 +1,139 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// newOAuthCodeRouter serves redirectWithCode for user 7 at /redirect, and
+// ExchangeCode at /exchange, with codes kept in an in-process Redis
+func newOAuthCodeRouter(t *testing.T, frontendURL string) (*gin.Engine, *miniredis.Miniredis) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	redisClient, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	cfg := common.DefaultConfig()
+	cfg.FrontendURL = frontendURL
+	cfg.AllowedRedirectOrigins = []string{"https://*.sites.example.com"}
+	h := NewOAuthHandler(&sections.Dependencies{Config: cfg, Redis: redisClient}, nil, &OAuthConfig{})
+
+	r := gin.New()
+	r.GET("/redirect", func(c *gin.Context) {
+		user := &models.User{}
+		user.ID = 7
+		if !h.redirectWithCode(c, user, "jwt-token", "session-1") {
+			c.String(http.StatusOK, "json")
+		}
+	})
+	r.POST("/exchange", h.ExchangeCode)
+	return r, server
+}
+
+func TestRedirectWithCode(t *testing.T) {
+	tests := []struct {
+		name        string
+		frontendURL string
+		redirectURI string
+		status      int
+		location    string
+	}{
+		{"frontend", "https://app.example.com", "https://app.example.com/auth/done?next=/", http.StatusTemporaryRedirect, "https://app.example.com/auth/done?next=/"},
+		{"site subdomain", "https://app.example.com", "https://shop.sites.example.com/login", http.StatusTemporaryRedirect, "https://shop.sites.example.com/login"},
+		{"foreign target falls back", "https://app.example.com", "https://evil.example/steal", http.StatusTemporaryRedirect, "https://app.example.com"},
+		{"fragment is replaced", "https://app.example.com", "https://app.example.com/done#token=x", http.StatusTemporaryRedirect, "https://app.example.com/done"},
+		{"foreign target without frontend", "", "https://evil.example/steal", http.StatusBadRequest, ""},
+		{"no redirect_uri", "https://app.example.com", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, server := newOAuthCodeRouter(t, tt.frontendURL)
+
+			path := "/redirect"
+			if tt.redirectURI != "" {
+				path += "?" + url.Values{"redirect_uri": {tt.redirectURI}}.Encode()
+			}
+			w := serveOAuth(r, path, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusTemporaryRedirect {
+				if keys := server.Keys(); len(keys) != 0 {
+					t.Errorf("stored %v without redirecting", keys)
+				}
+				if tt.status == http.StatusBadRequest && responseCode(t, w) != "redirect_not_allowed" {
+					t.Errorf("body = %s, want code redirect_not_allowed", w.Body)
+				}
+				return
+			}
+
+			location := w.Header().Get("Location")
+			if strings.Contains(location, "jwt-token") || strings.Contains(location, "session-1") {
+				t.Errorf("Location %q carries the login", location)
+			}
+			target, code, ok := strings.Cut(location, "#code=")
+			if !ok || target != tt.location {
+				t.Fatalf("Location = %q, want %s#code=...", location, tt.location)
+			}
+
+			stored, err := server.Get("oauth_code:" + code)
+			if err != nil {
+				t.Fatalf("code %q not stored: %v", code, err)
+			}
+			var login oauthLogin
+			json.Unmarshal([]byte(stored), &login)
+			if login != (oauthLogin{Token: "jwt-token", SessionID: "session-1", UserID: 7}) {
+				t.Errorf("stored login = %+v", login)
+			}
+			if ttl := server.TTL("oauth_code:" + code); ttl <= 0 || ttl > OAuthCodeTTL {
+				t.Errorf("code TTL = %v, want up to %v", ttl, OAuthCodeTTL)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeRejects(t *testing.T) {
+	r, server := newOAuthCodeRouter(t, "https://app.example.com")
+
+	exchange := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/exchange", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := exchange(`{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("exchange without a code = %d, want 400", w.Code)
+	}
+	if w := exchange(`{"code":"unknown"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("exchange of an unknown code = %d, want 401", w.Code)
+	}
+
+	// An expired code is gone
+	w := serveOAuth(r, "/redirect?redirect_uri="+url.QueryEscape("https://app.example.com"), nil)
+	_, code, _ := strings.Cut(w.Header().Get("Location"), "#code=")
+	server.FastForward(OAuthCodeTTL + time.Second)
+	if w := exchange(`{"code":"` + code + `"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("exchange of an expired code = %d, want 401", w.Code)
+	}
+}
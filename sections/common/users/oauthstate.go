@@ -0,0 +1,131 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// OAuthStateTTL is how long a login started at a provider can take to
+	// come back to the callback; abandoned states expire after it
+	OAuthStateTTL = 10 * time.Minute
+
+	// OAuthUsedCodeTTL is how long authorization codes are remembered, so a
+	// replayed callback can't log in twice. Providers expire codes sooner.
+	OAuthUsedCodeTTL = 15 * time.Minute
+
+	oauthStateCookie = "oauth_state"
+)
+
+// pendingOAuthLogin is stored in Redis under the state parameter while the
+// user is at the provider
+type pendingOAuthLogin struct {
+	Provider  string `json:"provider"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// beginOAuth checks where the login was started from and stores a new
+// single-use state for provider, also set in the oauth_state cookie. It
+// writes the error response and returns false when the login can't start.
+func (h *OAuthHandler) beginOAuth(c *gin.Context, provider string) (string, bool) {
+	if origin, ok := h.initiationOrigin(c); !ok {
+		h.logger.Warn("OAuth login started from a disallowed origin", "provider", provider, "origin", origin)
+		i18n.Error(c, http.StatusForbidden, "origin_not_allowed", "origin not allowed")
+		return "", false
+	}
+
+	state := generateOAuthState()
+	data, _ := json.Marshal(pendingOAuthLogin{Provider: provider, CreatedAt: time.Now().Unix()})
+	if err := h.deps.Redis.SetOAuthState(c.Request.Context(), state, data, OAuthStateTTL); err != nil {
+		h.logger.Error("Failed to store OAuth state", "provider", provider, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start login"})
+		return "", false
+	}
+
+	c.SetCookie(oauthStateCookie, state, int(OAuthStateTTL.Seconds()), "/", "", true, true)
+	return state, true
+}
+
+// initiationOrigin returns the origin of the page a login was started from,
+// from the Origin or Referer header, and whether it is allowed: the API's
+// own base_url or a redirect origin (see Config.OriginAllowed).
+// Requests without either header, such as server-to-server calls and
+// typed-in URLs, are allowed.
+func (h *OAuthHandler) initiationOrigin(c *gin.Context) (string, bool) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		referer := c.GetHeader("Referer")
+		if referer == "" {
+			return "", true
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return referer, false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	return origin, h.deps.Config.OriginAllowed(origin)
+}
+
+// verifyOAuthCallback checks a callback's state against the oauth_state
+// cookie and the stored state, and that its authorization code hasn't been
+// used before. The cookie is cleared and the state consumed whatever the
+// outcome, so a state only works for the first callback. It writes the error
+// response and returns the code, or false.
+func (h *OAuthHandler) verifyOAuthCallback(c *gin.Context, provider string) (string, bool) {
+	c.SetCookie(oauthStateCookie, "", -1, "/", "", true, true)
+
+	ctx := c.Request.Context()
+	state := c.Query("state")
+	storedState, err := c.Cookie(oauthStateCookie)
+	if state == "" {
+		i18n.Error(c, http.StatusBadRequest, "invalid_state", "invalid state")
+		return "", false
+	}
+
+	data, consumeErr := h.deps.Redis.ConsumeOAuthState(ctx, state)
+	if consumeErr != nil && !errors.Is(consumeErr, storage.ErrOAuthStateNotFound) {
+		h.logger.Error("Failed to get OAuth state", "provider", provider, "error", consumeErr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+		return "", false
+	}
+
+	var pending pendingOAuthLogin
+	if consumeErr == nil {
+		_ = json.Unmarshal(data, &pending)
+	}
+	if err != nil || state != storedState || consumeErr != nil || pending.Provider != provider {
+		h.logger.Warn("Rejected OAuth callback with invalid state", "provider", provider,
+			"cookie", err == nil, "cookie_matches", state == storedState, "stored", consumeErr == nil)
+		i18n.Error(c, http.StatusBadRequest, "invalid_state", "invalid state")
+		return "", false
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing authorization code"})
+		return "", false
+	}
+
+	first, err := h.deps.Redis.ClaimOAuthAuthorizationCode(ctx, provider, code, OAuthUsedCodeTTL)
+	if err != nil {
+		h.logger.Error("Failed to claim OAuth authorization code", "provider", provider, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate"})
+		return "", false
+	}
+	if !first {
+		h.logger.Warn("Rejected replayed OAuth authorization code", "provider", provider)
+		i18n.Error(c, http.StatusBadRequest, "code_already_used", "authorization code already used")
+		return "", false
+	}
+
+	return code, true
+}
@@ -0,0 +1,240 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// newOAuthStateRouter serves beginOAuth at /begin/:provider and
+// verifyOAuthCallback at /callback/:provider, answering with the state or
+// code, with the state kept in an in-process Redis
+func newOAuthStateRouter(t *testing.T) (*gin.Engine, *miniredis.Miniredis) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	redisClient, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	cfg := common.DefaultConfig()
+	cfg.BaseURL = "https://api.example.com"
+	cfg.FrontendURL = "https://app.example.com"
+	cfg.AllowedRedirectOrigins = []string{"https://*.sites.example.com"}
+	h := NewOAuthHandler(&sections.Dependencies{Config: cfg, Redis: redisClient}, nil, &OAuthConfig{})
+
+	r := gin.New()
+	r.GET("/begin/:provider", func(c *gin.Context) {
+		if state, ok := h.beginOAuth(c, c.Param("provider")); ok {
+			c.String(http.StatusOK, state)
+		}
+	})
+	r.GET("/callback/:provider", func(c *gin.Context) {
+		if code, ok := h.verifyOAuthCallback(c, c.Param("provider")); ok {
+			c.String(http.StatusOK, code)
+		}
+	})
+	return r, server
+}
+
+func serveOAuth(r *gin.Engine, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func stateCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == oauthStateCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// beginLogin starts a login for provider, returning the state and the
+// Cookie header the browser would send back with the callback
+func beginLogin(t *testing.T, r *gin.Engine, provider string) (string, string) {
+	t.Helper()
+	w := serveOAuth(r, "/begin/"+provider, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin status = %d, want 200: %s", w.Code, w.Body)
+	}
+	cookie := stateCookie(w)
+	if cookie == nil || cookie.Value != w.Body.String() {
+		t.Fatalf("state cookie = %v, want the state %q", cookie, w.Body)
+	}
+	return w.Body.String(), (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String()
+}
+
+func callback(r *gin.Engine, provider, state, code, cookie string) *httptest.ResponseRecorder {
+	header := http.Header{}
+	if cookie != "" {
+		header.Set("Cookie", cookie)
+	}
+	return serveOAuth(r, "/callback/"+provider+"?"+url.Values{"state": {state}, "code": {code}}.Encode(), header)
+}
+
+func responseCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", w.Body, err)
+	}
+	return body.Code
+}
+
+func TestBeginOAuthChecksOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		allowed bool
+	}{
+		{"no origin or referer", nil, true},
+		{"frontend origin", http.Header{"Origin": {"https://app.example.com"}}, true},
+		{"API origin", http.Header{"Origin": {"https://api.example.com"}}, true},
+		{"site subdomain", http.Header{"Origin": {"https://shop.sites.example.com"}}, true},
+		{"frontend referer", http.Header{"Referer": {"https://app.example.com/login?next=/"}}, true},
+		{"foreign origin", http.Header{"Origin": {"https://evil.example"}}, false},
+		{"foreign origin with a frontend referer", http.Header{
+			"Origin": {"https://evil.example"}, "Referer": {"https://app.example.com/login"},
+		}, false},
+		{"frontend host over http", http.Header{"Origin": {"http://app.example.com"}}, false},
+		{"lookalike host", http.Header{"Origin": {"https://app.example.com.evil.example"}}, false},
+		{"opaque origin", http.Header{"Origin": {"null"}}, false},
+		{"foreign referer", http.Header{"Referer": {"https://evil.example/app.example.com"}}, false},
+		{"unparsable referer", http.Header{"Referer": {"https://app.example.com/%zz"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, server := newOAuthStateRouter(t)
+
+			w := serveOAuth(r, "/begin/google", tt.header)
+			if tt.allowed {
+				if w.Code != http.StatusOK || stateCookie(w) == nil {
+					t.Fatalf("status = %d, cookie %v, want 200 with a state cookie: %s", w.Code, stateCookie(w), w.Body)
+				}
+				if !server.Exists("oauth_state:" + w.Body.String()) {
+					t.Errorf("state %q not stored", w.Body)
+				}
+				return
+			}
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want 403: %s", w.Code, w.Body)
+			}
+			if code := responseCode(t, w); code != "origin_not_allowed" {
+				t.Errorf("code = %q, want origin_not_allowed", code)
+			}
+			if stateCookie(w) != nil {
+				t.Errorf("rejected login set a state cookie")
+			}
+			if keys := server.Keys(); len(keys) != 0 {
+				t.Errorf("rejected login stored %v", keys)
+			}
+		})
+	}
+}
+
+func TestOAuthCallbackReplay(t *testing.T) {
+	r, _ := newOAuthStateRouter(t)
+	state, cookie := beginLogin(t, r, "google")
+
+	w := callback(r, "google", state, "code-1", cookie)
+	if w.Code != http.StatusOK || w.Body.String() != "code-1" {
+		t.Fatalf("first callback = %d %q, want 200 with the code", w.Code, w.Body)
+	}
+	if c := stateCookie(w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("first callback didn't clear the state cookie: %v", c)
+	}
+
+	// The same callback again finds its state consumed
+	w = callback(r, "google", state, "code-1", cookie)
+	if w.Code != http.StatusBadRequest || responseCode(t, w) != "invalid_state" {
+		t.Fatalf("replayed callback = %d %s, want 400 invalid_state", w.Code, w.Body)
+	}
+	if c := stateCookie(w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("rejected callback didn't clear the state cookie: %v", c)
+	}
+
+	// The code is refused under a fresh state, for this provider only
+	state, cookie = beginLogin(t, r, "google")
+	w = callback(r, "google", state, "code-1", cookie)
+	if w.Code != http.StatusBadRequest || responseCode(t, w) != "code_already_used" {
+		t.Fatalf("replayed code = %d %s, want 400 code_already_used", w.Code, w.Body)
+	}
+	state, cookie = beginLogin(t, r, "facebook")
+	if w = callback(r, "facebook", state, "code-1", cookie); w.Code != http.StatusOK {
+		t.Errorf("another provider's code = %d %s, want 200", w.Code, w.Body)
+	}
+}
+
+func TestOAuthCallbackRejectsState(t *testing.T) {
+	tests := []struct {
+		name     string
+		callback func(t *testing.T, r *gin.Engine, state, cookie string) *httptest.ResponseRecorder
+		// Whether the attempt uses up the state, so a forged callback
+		// can't be followed by the real one
+		consumes bool
+	}{
+		{"missing cookie", func(t *testing.T, r *gin.Engine, state, _ string) *httptest.ResponseRecorder {
+			return callback(r, "google", state, "code-1", "")
+		}, true},
+		{"cookie of another login", func(t *testing.T, r *gin.Engine, state, _ string) *httptest.ResponseRecorder {
+			_, otherCookie := beginLogin(t, r, "google")
+			return callback(r, "google", state, "code-1", otherCookie)
+		}, true},
+		{"another provider's state", func(t *testing.T, r *gin.Engine, state, cookie string) *httptest.ResponseRecorder {
+			return callback(r, "facebook", state, "code-1", cookie)
+		}, true},
+		{"missing state", func(t *testing.T, r *gin.Engine, _, cookie string) *httptest.ResponseRecorder {
+			return callback(r, "google", "", "code-1", cookie)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, server := newOAuthStateRouter(t)
+			state, cookie := beginLogin(t, r, "google")
+
+			w := tt.callback(t, r, state, cookie)
+			if w.Code != http.StatusBadRequest || responseCode(t, w) != "invalid_state" {
+				t.Fatalf("callback = %d %s, want 400 invalid_state", w.Code, w.Body)
+			}
+			if c := stateCookie(w); c == nil || c.MaxAge >= 0 {
+				t.Errorf("rejected callback didn't clear the state cookie: %v", c)
+			}
+			if stored := server.Exists("oauth_state:" + state); stored == tt.consumes {
+				t.Errorf("state stored after the rejected callback = %v, want %v", stored, !tt.consumes)
+			}
+		})
+	}
+}
+
+func TestOAuthStateExpires(t *testing.T) {
+	r, server := newOAuthStateRouter(t)
+	state, cookie := beginLogin(t, r, "google")
+
+	server.FastForward(OAuthStateTTL)
+	w := callback(r, "google", state, "code-1", cookie)
+	if w.Code != http.StatusBadRequest || responseCode(t, w) != "invalid_state" {
+		t.Fatalf("callback after the state TTL = %d %s, want 400 invalid_state", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,69 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"awning-backend/common"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WeakPasswordCode is the error code returned with 400 when a password does
+// not meet the policy
+const WeakPasswordCode = "weak_password"
+
+// resetTokenHashPrefix marks hashed password reset tokens. db migrations hash
+// tokens stored before hashing was introduced into the same format.
+const resetTokenHashPrefix = "sha256:"
+
+var ErrCommonPassword = errors.New("password is too common")
+
+// validatePassword checks a new password against the configured policy.
+// Lengths are in bytes, as bcrypt ignores anything past 72 bytes.
+func validatePassword(cfg *common.Config, email, password string) error {
+	if len(password) < cfg.PasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters", cfg.PasswordMinLength)
+	}
+	if len(password) > cfg.PasswordMaxLength {
+		return fmt.Errorf("password must be at most %d bytes", cfg.PasswordMaxLength)
+	}
+
+	lower := strings.ToLower(password)
+	if email != "" && lower == strings.ToLower(email) {
+		return ErrCommonPassword
+	}
+	for _, denied := range cfg.PasswordDenylist {
+		if lower == strings.ToLower(strings.TrimSpace(denied)) {
+			return ErrCommonPassword
+		}
+	}
+	return nil
+}
+
+// hashResetToken returns the stored form of a password reset token
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return resetTokenHashPrefix + hex.EncodeToString(sum[:])
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyPassword spends the time of a real bcrypt comparison, so that
+// logins for unknown emails take as long as wrong passwords
+func compareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
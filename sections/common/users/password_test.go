@@ -0,0 +1,60 @@
+package users
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+)
+
+func TestValidatePassword(t *testing.T) {
+	cfg := common.DefaultConfig()
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+		isCommon bool
+	}{
+		{"long enough", "Correct-Horse-7", false, false},
+		{"too short", "short1!", true, false},
+		{"too long", strings.Repeat("a", cfg.PasswordMaxLength+1), true, false},
+		{"at the byte limit", strings.Repeat("é", cfg.PasswordMaxLength/2), false, false},
+		{"over the byte limit in fewer characters", strings.Repeat("é", cfg.PasswordMaxLength/2+1), true, false},
+		{"denylisted", "password123", true, true},
+		{"denylisted in another case", "PassWord123", true, true},
+		{"the email", "Carol@Example.com", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePassword(cfg, "carol@example.com", tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrCommonPassword) != tt.isCommon {
+				t.Errorf("validatePassword() error = %v, want ErrCommonPassword %v", err, tt.isCommon)
+			}
+		})
+	}
+}
+
+func TestHashResetToken(t *testing.T) {
+	token := "4f1c2a9e8b7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f0e9d8c7b6a5f4e3d2c1b"
+
+	hashed := hashResetToken(token)
+	// The format db migrations hash tokens issued before hashing into
+	if !regexp.MustCompile(`^sha256:[0-9a-f]{64}$`).MatchString(hashed) {
+		t.Fatalf("hashResetToken() = %q, want sha256: and 64 hex digits", hashed)
+	}
+	if strings.Contains(hashed, token) {
+		t.Errorf("hashResetToken() = %q contains the token", hashed)
+	}
+	if hashResetToken(token) != hashed {
+		t.Errorf("hashResetToken() is not deterministic")
+	}
+	if hashResetToken(token+"0") == hashed {
+		t.Errorf("different tokens hash the same")
+	}
+}
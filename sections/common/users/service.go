@@ -35,7 +35,10 @@ type CreateUserWithTenantParams struct {
 	TenantSchema string // Optional: if not provided, auto-generated
 }
 
-// CreateUserWithTenant creates a user and associated tenant, making the user admin if they're the first
+// CreateUserWithTenant creates a user and associated tenant, making the user admin if they're the first.
+// A new tenant's schema is created before the rows, outside the transaction,
+// since DDL can't be rolled back with it; the schema is dropped again if the
+// rows can't be saved.
 func (s *UserService) CreateUserWithTenant(ctx context.Context, params CreateUserWithTenantParams) (*models.User, *models.Tenant, error) {
 	// Generate tenant name if not provided
 	tenantName := params.TenantName
@@ -49,98 +52,111 @@ func (s *UserService) CreateUserWithTenant(ctx context.Context, params CreateUse
 		tenantSchema = s.generateTenantSchema(params.User.Email)
 	}
 
-	// Start transaction
-	tx := s.deps.DB.DB.Begin()
-	if tx.Error != nil {
-		return nil, nil, fmt.Errorf("failed to start transaction: %w", tx.Error)
-	}
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Create user
-	if err := tx.Create(&params.User).Error; err != nil {
-		tx.Rollback()
-		return nil, nil, fmt.Errorf("failed to create user: %w", err)
-	}
-
-	s.logger.Info("User created", "user_id", params.User.ID, "email", params.User.Email)
-
 	// Check if tenant exists
 	var tenant models.Tenant
-	err := tx.Where("schema_name = ?", tenantSchema).First(&tenant).Error
+	err := s.deps.DB.DB.WithContext(ctx).Where("schema_name = ?", tenantSchema).First(&tenant).Error
 	tenantExists := err == nil
 
 	if !tenantExists {
-		// Create new tenant
-		tenant = models.Tenant{
-			Name:        tenantName,
-			DisplayName: tenantName,
-			Active:      true,
+		if err := s.createTenantSchema(ctx, tenantSchema); err != nil {
+			return nil, nil, err
 		}
-		tenant.SchemaName = tenantSchema
-		tenant.DomainURL = tenantSchema
+	} else {
+		s.logger.Info("Using existing tenant", "tenant_schema", tenantSchema)
+	}
 
-		if err := tx.Create(&tenant).Error; err != nil {
-			tx.Rollback()
-			return nil, nil, fmt.Errorf("failed to create tenant: %w", err)
+	err = s.deps.DB.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Create user
+		if err := tx.Create(&params.User).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
 		}
 
-		s.logger.Info("Tenant created", "tenant_schema", tenantSchema, "tenant_name", tenantName)
+		s.logger.Info("User created", "user_id", params.User.ID, "email", params.User.Email)
+
+		if !tenantExists {
+			// Create new tenant
+			tenant = models.Tenant{
+				Name:        tenantName,
+				DisplayName: tenantName,
+				Active:      true,
+			}
+			tenant.SchemaName = tenantSchema
+			tenant.DomainURL = tenantSchema
+
+			if err := tx.Create(&tenant).Error; err != nil {
+				return fmt.Errorf("failed to create tenant: %w", err)
+			}
 
-		// Migrate tenant schema
-		if err := s.deps.DB.CreateTenantSchema(ctx, tenantSchema); err != nil {
-			tx.Rollback()
-			return nil, nil, fmt.Errorf("failed to migrate tenant schema: %w", err)
+			s.logger.Info("Tenant created", "tenant_schema", tenantSchema, "tenant_name", tenantName)
 		}
 
-		s.logger.Info("Tenant schema migrated", "tenant_schema", tenantSchema)
-	} else {
-		s.logger.Info("Using existing tenant", "tenant_schema", tenantSchema)
-	}
+		// Check if this is the first user for the tenant
+		var userTenantCount int64
+		if err := tx.Model(&models.UserTenant{}).
+			Where("tenant_schema = ?", tenantSchema).
+			Count(&userTenantCount).Error; err != nil {
+			return fmt.Errorf("failed to count tenant users: %w", err)
+		}
 
-	// Check if this is the first user for the tenant
-	var userTenantCount int64
-	if err := tx.Model(&models.UserTenant{}).
-		Where("tenant_schema = ?", tenantSchema).
-		Count(&userTenantCount).Error; err != nil {
-		tx.Rollback()
-		return nil, nil, fmt.Errorf("failed to count tenant users: %w", err)
-	}
+		// Determine role: first user is admin, others are members
+		role := "member"
+		if userTenantCount == 0 {
+			role = "admin"
+		}
 
-	// Determine role: first user is admin, others are members
-	role := "member"
-	if userTenantCount == 0 {
-		role = "admin"
-	}
+		// Link user to tenant
+		userTenant := models.UserTenant{
+			UserID:        params.User.ID,
+			TenantSchema:  tenantSchema,
+			Role:          role,
+			PrimaryTenant: true, // First tenant is always primary
+		}
 
-	// Link user to tenant
-	userTenant := models.UserTenant{
-		UserID:        params.User.ID,
-		TenantSchema:  tenantSchema,
-		Role:          role,
-		PrimaryTenant: true, // First tenant is always primary
-	}
+		if err := tx.Create(&userTenant).Error; err != nil {
+			return fmt.Errorf("failed to link user to tenant: %w", err)
+		}
 
-	if err := tx.Create(&userTenant).Error; err != nil {
-		tx.Rollback()
-		return nil, nil, fmt.Errorf("failed to link user to tenant: %w", err)
+		s.logger.Info("User linked to tenant",
+			"user_id", params.User.ID,
+			"tenant_schema", tenantSchema,
+			"role", role,
+			"is_first_user", role == "admin")
+		return nil
+	})
+	if err != nil {
+		if !tenantExists {
+			s.dropOrphanedSchema(ctx, tenantSchema)
+		}
+		return nil, nil, err
 	}
 
-	s.logger.Info("User linked to tenant",
-		"user_id", params.User.ID,
-		"tenant_schema", tenantSchema,
-		"role", role,
-		"is_first_user", role == "admin")
+	return &params.User, &tenant, nil
+}
 
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+// createTenantSchema creates and migrates a new tenant's schema, dropping
+// it again if the migration fails part way
+func (s *UserService) createTenantSchema(ctx context.Context, tenantSchema string) error {
+	if err := s.deps.DB.CreateTenantSchema(ctx, tenantSchema); err != nil {
+		s.dropOrphanedSchema(ctx, tenantSchema)
+		return fmt.Errorf("failed to migrate tenant schema: %w", err)
 	}
+	s.logger.Info("Tenant schema migrated", "tenant_schema", tenantSchema)
+	return nil
+}
 
-	return &params.User, &tenant, nil
+// dropOrphanedSchema drops a schema created for a tenant whose rows were
+// never saved. A schema that a tenant row does point at, e.g. one created
+// concurrently under the same name, is left alone.
+func (s *UserService) dropOrphanedSchema(ctx context.Context, tenantSchema string) {
+	var count int64
+	if err := s.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).Where("schema_name = ?", tenantSchema).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+	if err := s.deps.DB.DeleteTenantSchema(ctx, tenantSchema); err != nil {
+		s.logger.Error("Failed to drop orphaned tenant schema", "tenant_schema", tenantSchema, "error", err)
+		return
+	}
+	s.logger.Warn("Dropped orphaned tenant schema", "tenant_schema", tenantSchema)
 }
 
 // FindOrCreateUserWithOAuth finds existing user or creates new one with tenant for OAuth login
@@ -259,36 +275,52 @@ func (s *UserService) generateTenantNameFromEmail(email string) string {
 // generateTenantSchema generates a unique tenant schema name
 func (s *UserService) generateTenantSchema(email string) string {
 	parts := strings.Split(email, "@")
-	if len(parts) > 0 {
-		username := parts[0]
-		// Remove non-alphanumeric characters and convert to lowercase
-		schema := strings.ToLower(username)
-		schema = strings.Map(func(r rune) rune {
-			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
-				return r
-			}
-			return '_'
-		}, schema)
+	if len(parts) > 0 && parts[0] != "" {
+		return s.uniqueTenantSchema(parts[0])
+	}
+	return "tenant_" + fmt.Sprintf("%d", time.Now().Unix())
+}
 
-		// Ensure it starts with a letter
-		if len(schema) > 0 && schema[0] >= '0' && schema[0] <= '9' {
-			schema = "t_" + schema
+// maxSchemaBaseLength leaves room in the 63 character schema name for the
+// numeric suffix added on collisions
+const maxSchemaBaseLength = 50
+
+// uniqueTenantSchema derives a schema name from base that no tenant uses yet
+func (s *UserService) uniqueTenantSchema(base string) string {
+	// Remove non-alphanumeric characters and convert to lowercase
+	schema := strings.ToLower(base)
+	schema = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
 		}
+		return '_'
+	}, schema)
+	if len(schema) > maxSchemaBaseLength {
+		schema = schema[:maxSchemaBaseLength]
+	}
 
-		// Check if schema exists, append number if needed
-		var tenant models.Tenant
-		baseSchema := schema
-		counter := 1
-		for {
-			err := s.deps.DB.DB.Where("schema_name = ?", schema).First(&tenant).Error
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				break
-			}
-			schema = fmt.Sprintf("%s_%d", baseSchema, counter)
-			counter++
-		}
+	// Ensure it starts with a letter, isn't reserved by Postgres and is long
+	// enough for the tenants check constraint
+	if schema == "" || schema[0] < 'a' || schema[0] > 'z' || strings.HasPrefix(schema, "pg_") ||
+		schema == "public" || schema == "information_schema" {
+		schema = "t_" + schema
+	}
+	for len(schema) < 3 {
+		schema += "_"
+	}
 
-		return schema
+	// Check if schema exists, append number if needed
+	var tenant models.Tenant
+	baseSchema := schema
+	counter := 1
+	for {
+		err := s.deps.DB.DB.Where("schema_name = ?", schema).First(&tenant).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		schema = fmt.Sprintf("%s_%d", baseSchema, counter)
+		counter++
 	}
-	return "tenant_" + fmt.Sprintf("%d", time.Now().Unix())
+
+	return schema
 }
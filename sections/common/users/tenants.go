@@ -0,0 +1,285 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TenantLimitCode is the error code returned with 403 when the user already
+// owns as many tenants as their plan allows
+const TenantLimitCode = "tenant_limit_reached"
+
+var (
+	ErrTenantLimitReached = errors.New("tenant limit reached")
+	ErrNotTenantMember    = errors.New("not a member of this tenant")
+)
+
+// ownerRoles are the membership roles that count towards the tenant limit;
+// signup makes the first user admin, tenants created later make them owner
+var ownerRoles = []string{"owner", "admin"}
+
+// CreateTenantRequest creates an additional tenant for the current user
+type CreateTenantRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// SchemaHint is the preferred schema name; it is sanitized and suffixed
+	// when taken. Defaults to the name.
+	SchemaHint string `json:"schemaHint" binding:"max=50"`
+}
+
+// TenantTokenResponse returns a tenant with a token scoped to it
+type TenantTokenResponse struct {
+	Tenant TenantResponse `json:"tenant"`
+	Token  string         `json:"token"`
+}
+
+// TenantLimit returns how many tenants the user may own: the largest
+// maxTenants among plans of the user's active subscriptions, or the free
+// limit without one. 0 means unlimited.
+func (s *UserService) TenantLimit(ctx context.Context, userID uint) (int, error) {
+	var priceIDs []string
+	err := s.deps.DB.DB.WithContext(ctx).Model(&models.Subscription{}).
+		Where("user_id = ? AND status IN ?", userID, []string{"active", "trialing"}).
+		Pluck("stripe_price_id", &priceIDs).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up subscriptions: %w", err)
+	}
+
+	limit := s.deps.Config.FreeMaxTenants
+	for _, priceID := range priceIDs {
+		plan := findPlanByPrice(s.deps.Plans, priceID)
+		if plan == nil {
+			continue
+		}
+		if plan.MaxTenants == 0 {
+			return 0, nil
+		}
+		limit = max(limit, plan.MaxTenants)
+	}
+	return limit, nil
+}
+
+func findPlanByPrice(plans []common.Plan, priceID string) *common.Plan {
+	for i := range plans {
+		if plans[i].PriceId == priceID {
+			return &plans[i]
+		}
+	}
+	return nil
+}
+
+// countOwnedTenants counts the active tenants the user owns
+func countOwnedTenants(tx *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := tx.Model(&models.UserTenant{}).
+		Joins("JOIN public.tenants ON public.tenants.schema_name = public.user_tenants.tenant_schema AND public.tenants.deleted_at IS NULL").
+		Where("public.user_tenants.user_id = ? AND public.user_tenants.role IN ? AND public.tenants.active", userID, ownerRoles).
+		Count(&count).Error
+	return count, err
+}
+
+// CreateTenantForUser creates a tenant owned by an existing user, within the
+// limit of the user's plan. The schema is created before the rows and
+// dropped again if they can't be saved.
+func (s *UserService) CreateTenantForUser(ctx context.Context, userID uint, name, schemaHint string) (*models.Tenant, *models.UserTenant, error) {
+	limit, err := s.TenantLimit(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db := s.deps.DB.DB.DB.WithContext(ctx)
+	if limit > 0 {
+		owned, err := countOwnedTenants(db, userID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to count tenants: %w", err)
+		}
+		if owned >= int64(limit) {
+			return nil, nil, ErrTenantLimitReached
+		}
+	}
+
+	if schemaHint == "" {
+		schemaHint = name
+	}
+	tenantSchema := s.uniqueTenantSchema(schemaHint)
+	if err := s.createTenantSchema(ctx, tenantSchema); err != nil {
+		return nil, nil, err
+	}
+
+	tenant := models.Tenant{
+		Name:        name,
+		DisplayName: name,
+		Active:      true,
+	}
+	tenant.SchemaName = tenantSchema
+	tenant.DomainURL = tenantSchema
+
+	membership := models.UserTenant{
+		UserID:       userID,
+		TenantSchema: tenantSchema,
+		Role:         "owner",
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Serialize tenant creation per user so concurrent requests can't
+		// both pass the limit
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+		if limit > 0 {
+			owned, err := countOwnedTenants(tx, userID)
+			if err != nil {
+				return fmt.Errorf("failed to count tenants: %w", err)
+			}
+			if owned >= int64(limit) {
+				return ErrTenantLimitReached
+			}
+		}
+
+		if err := tx.Create(&tenant).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+		if err := tx.Create(&membership).Error; err != nil {
+			return fmt.Errorf("failed to link user to tenant: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		s.dropOrphanedSchema(ctx, tenantSchema)
+		return nil, nil, err
+	}
+
+	s.logger.Info("Tenant created for existing user", "user_id", userID, "tenant_schema", tenantSchema, "tenant_name", name)
+	return &tenant, &membership, nil
+}
+
+// SetPrimaryTenant makes the tenant the user's primary one, used at login
+func (s *UserService) SetPrimaryTenant(ctx context.Context, userID uint, tenantSchema string) (*models.UserTenant, error) {
+	var membership models.UserTenant
+	err := s.deps.DB.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Tenant").Where("user_id = ? AND tenant_schema = ?", userID, tenantSchema).First(&membership).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotTenantMember
+			}
+			return err
+		}
+		if err := tx.Model(&models.UserTenant{}).
+			Where("user_id = ? AND tenant_schema <> ?", userID, tenantSchema).
+			Update("primary_tenant", false).Error; err != nil {
+			return err
+		}
+		membership.PrimaryTenant = true
+		return tx.Model(&membership).Update("primary_tenant", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+func toTenantResponse(tenant *models.Tenant, membership *models.UserTenant) TenantResponse {
+	return TenantResponse{
+		SchemaName:  tenant.SchemaName,
+		DomainURL:   tenant.DomainURL,
+		Name:        tenant.Name,
+		DisplayName: tenant.DisplayName,
+		Role:        membership.Role,
+		Primary:     membership.PrimaryTenant,
+	}
+}
+
+// CreateTenant creates an additional tenant owned by the current user and
+// returns it with a token scoped to it
+func (h *Handler) CreateTenant(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenant, membership, err := h.userService.CreateTenantForUser(ctx, claims.UserID, name, strings.TrimSpace(req.SchemaHint))
+	if errors.Is(err, ErrTenantLimitReached) {
+		limit, _ := h.userService.TenantLimit(ctx, claims.UserID)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "your plan allows no more tenants",
+			"code":  TenantLimitCode,
+			"limit": limit,
+		})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create tenant", "user_id", claims.UserID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create tenant"})
+		return
+	}
+
+	token, err := h.jwtManager.GenerateToken(claims.UserID, claims.Email, tenant.SchemaName)
+	if err != nil {
+		h.logger.Error("Failed to generate token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, TenantTokenResponse{
+		Tenant: toTenantResponse(tenant, membership),
+		Token:  token,
+	})
+}
+
+// SetPrimaryTenant switches the tenant the user's logins default to and
+// returns a token scoped to it
+func (h *Handler) SetPrimaryTenant(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	membership, err := h.userService.SetPrimaryTenant(c.Request.Context(), claims.UserID, c.Param("schema"))
+	if errors.Is(err, ErrNotTenantMember) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to set primary tenant", "user_id", claims.UserID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set primary tenant"})
+		return
+	}
+
+	token, err := h.jwtManager.GenerateToken(claims.UserID, claims.Email, membership.TenantSchema)
+	if err != nil {
+		h.logger.Error("Failed to generate token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	h.logger.Info("Primary tenant changed", "user_id", claims.UserID, "tenant_schema", membership.TenantSchema)
+
+	c.JSON(http.StatusOK, TenantTokenResponse{
+		Tenant: toTenantResponse(&membership.Tenant, membership),
+		Token:  token,
+	})
+}
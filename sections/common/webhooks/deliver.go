@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DeliveryTimeout bounds each POST to a webhook, including connecting
+	DeliveryTimeout = 10 * time.Second
+
+	// maxErrorLength caps the error stored with a delivery attempt
+	maxErrorLength = 255
+)
+
+// Deliverer posts queued events to webhooks and logs each attempt
+type Deliverer struct {
+	logger   *slog.Logger
+	db       *db.DB
+	client   *http.Client
+	validate func(ctx context.Context, raw string) error
+	now      func() time.Time
+}
+
+// NewDeliverer creates a deliverer whose requests time out after timeout
+func NewDeliverer(database *db.DB, timeout time.Duration) *Deliverer {
+	return &Deliverer{
+		logger:   slog.With("service", "WebhookDeliverer"),
+		db:       database,
+		client:   newClient(timeout),
+		validate: ValidateURL,
+		now:      time.Now,
+	}
+}
+
+// HandleDeliver is the jobs.Handler for JobKindDeliver. Failed attempts
+// return an error so the queue retries them with backoff; deliveries to
+// deleted or disabled webhooks and to blocked targets are dropped.
+func (d *Deliverer) HandleDeliver(ctx context.Context, raw json.RawMessage) error {
+	var p deliveryPayload
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return fmt.Errorf("invalid delivery payload: %w", err)
+	}
+
+	var hook models.TenantWebhook
+	var attempts int64
+	err := d.db.WithTenant(ctx, p.TenantSchema, func(tx *gorm.DB) error {
+		if err := tx.First(&hook, p.WebhookID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.TenantWebhookDelivery{}).
+			Where("webhook_id = ? AND event_id = ?", p.WebhookID, p.EventID).
+			Count(&attempts).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		d.logger.Info("Dropping delivery to deleted webhook", "tenant", p.TenantSchema, "webhook_id", p.WebhookID, "event_id", p.EventID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load webhook: %w", err)
+	}
+	if !hook.Enabled {
+		d.logger.Info("Dropping delivery to disabled webhook", "tenant", p.TenantSchema, "webhook_id", p.WebhookID, "event_id", p.EventID)
+		return nil
+	}
+
+	delivery, err := d.attempt(ctx, &hook, p, int(attempts)+1)
+	if logErr := d.db.WithTenant(ctx, p.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Create(&delivery).Error
+	}); logErr != nil {
+		d.logger.Error("Failed to log webhook delivery", "tenant", p.TenantSchema, "webhook_id", hook.ID, "event_id", p.EventID, "error", logErr)
+	}
+	return err
+}
+
+// attempt posts the event to the webhook and returns the attempt's log
+// entry, and the error to retry with: nil once delivered, and for blocked
+// targets, which aren't retried
+func (d *Deliverer) attempt(ctx context.Context, hook *models.TenantWebhook, p deliveryPayload, number int) (models.TenantWebhookDelivery, error) {
+	start := d.now()
+	status, err := d.post(ctx, hook, p)
+	delivery := models.TenantWebhookDelivery{
+		CreatedAt:  start,
+		WebhookID:  hook.ID,
+		EventID:    p.EventID,
+		EventType:  p.EventType,
+		Attempt:    number,
+		StatusCode: status,
+		Success:    err == nil,
+		DurationMs: d.now().Sub(start).Milliseconds(),
+	}
+	if err != nil {
+		delivery.Error = truncate(err.Error(), maxErrorLength)
+	}
+
+	if errors.Is(err, ErrBlockedTarget) {
+		d.logger.Warn("Webhook target blocked, not retrying", "tenant", p.TenantSchema, "webhook_id", hook.ID, "error", err)
+		return delivery, nil
+	}
+	if err != nil {
+		return delivery, err
+	}
+	d.logger.Info("Webhook delivered", "tenant", p.TenantSchema, "webhook_id", hook.ID, "event_id", p.EventID, "status", status)
+	return delivery, nil
+}
+
+// post sends the signed event and returns the response status, failing for
+// anything but 2xx
+func (d *Deliverer) post(ctx context.Context, hook *models.TenantWebhook, p deliveryPayload) (int, error) {
+	if err := d.validate(ctx, hook.URL); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(p.Body))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrBlockedTarget, err)
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Awning-Webhooks/1.0")
+	req.Header.Set(EventIDHeader, p.EventID)
+	req.Header.Set(EventTypeHeader, p.EventType)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, p.Body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,172 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/sections/models"
+)
+
+// testDeliverer returns a deliverer that may reach the test server, which
+// listens on loopback
+func testDeliverer(server *httptest.Server) *Deliverer {
+	client := server.Client()
+	client.Timeout = time.Second
+	client.CheckRedirect = newClient(time.Second).CheckRedirect
+	return &Deliverer{
+		logger:   slog.Default(),
+		client:   client,
+		validate: func(context.Context, string) error { return nil },
+		now:      func() time.Time { return time.Unix(1700000000, 0) },
+	}
+}
+
+func testDelivery() (*models.TenantWebhook, deliveryPayload) {
+	hook := &models.TenantWebhook{Secret: "whsec_test", Enabled: true}
+	hook.ID = 7
+	return hook, deliveryPayload{
+		TenantSchema: "tenant_a",
+		WebhookID:    hook.ID,
+		EventID:      "evt_1",
+		EventType:    EventChatCompleted,
+		Body:         []byte(`{"id":"evt_1"}`),
+	}
+}
+
+func TestAttemptSignsDelivery(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	hook, p := testDelivery()
+	hook.URL = server.URL
+	delivery, err := testDeliverer(server).attempt(context.Background(), hook, p, 1)
+	if err != nil {
+		t.Fatalf("attempt() error = %v", err)
+	}
+	if !delivery.Success || delivery.StatusCode != http.StatusNoContent || delivery.Attempt != 1 || delivery.EventID != "evt_1" {
+		t.Errorf("delivery = %+v", delivery)
+	}
+
+	if got.Method != http.MethodPost || string(body) != string(p.Body) {
+		t.Fatalf("received %s %q", got.Method, body)
+	}
+	if got.Header.Get(EventIDHeader) != "evt_1" || got.Header.Get(EventTypeHeader) != EventChatCompleted {
+		t.Errorf("event headers = %q, %q", got.Header.Get(EventIDHeader), got.Header.Get(EventTypeHeader))
+	}
+	timestamp := got.Header.Get(TimestampHeader)
+	if timestamp != "1700000000" {
+		t.Errorf("%s = %q, want the delivery time", TimestampHeader, timestamp)
+	}
+
+	// Verify the way a receiver would, from the request alone
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	signature, ok := strings.CutPrefix(got.Header.Get(SignatureHeader), "sha256=")
+	if !ok || !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		t.Errorf("%s = %q doesn't verify", SignatureHeader, got.Header.Get(SignatureHeader))
+	}
+}
+
+func TestAttemptRetriesFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusInternalServerError},
+		{"rate limited", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}, http.StatusTooManyRequests},
+		{"redirect, not followed", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+		}, http.StatusFound},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(3 * time.Second):
+			case <-r.Context().Done():
+			}
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handler)
+			defer server.Close()
+
+			hook, p := testDelivery()
+			hook.URL = server.URL
+			delivery, err := testDeliverer(server).attempt(context.Background(), hook, p, 3)
+			if err == nil || errors.Is(err, ErrBlockedTarget) {
+				t.Fatalf("attempt() error = %v, want an error to retry with", err)
+			}
+			if delivery.Success || delivery.StatusCode != tt.status || delivery.Attempt != 3 || delivery.Error == "" {
+				t.Errorf("delivery = %+v, want a failed attempt 3 with status %d", delivery, tt.status)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	hook, p := testDelivery()
+	hook.URL = server.URL
+	server.Close()
+	if _, err := testDeliverer(server).attempt(context.Background(), hook, p, 1); err == nil {
+		t.Errorf("attempt() to a closed server error = nil, want an error to retry with")
+	}
+}
+
+func TestAttemptDropsBlockedTargets(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+	hook, p := testDelivery()
+	hook.URL = server.URL
+
+	// Rejected when validated, and when dialled after the host changed
+	// addresses since
+	validated := testDeliverer(server)
+	validated.validate = ValidateURL
+	rebound := testDeliverer(server)
+	rebound.client = newClient(time.Second)
+
+	for name, d := range map[string]*Deliverer{"validated": validated, "rebound": rebound} {
+		delivery, err := d.attempt(context.Background(), hook, p, 1)
+		if err != nil {
+			t.Errorf("%s: attempt() error = %v, want nil so it isn't retried", name, err)
+		}
+		if delivery.Success || !strings.Contains(delivery.Error, ErrBlockedTarget.Error()) {
+			t.Errorf("%s: delivery = %+v, want a failure logged as blocked", name, delivery)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Errorf("the blocked server was reached %d times", hits.Load())
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate(strings.Repeat("x", 300), maxErrorLength); len(got) != maxErrorLength {
+		t.Errorf("truncate() length = %d, want %d", len(got), maxErrorLength)
+	}
+	if got := truncate("short", maxErrorLength); got != "short" {
+		t.Errorf("truncate() = %q, want it unchanged", got)
+	}
+}
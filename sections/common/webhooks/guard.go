@@ -0,0 +1,118 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedTarget is returned for webhook URLs that aren't public http(s)
+// endpoints
+var ErrBlockedTarget = errors.New("webhook target not allowed")
+
+// Ranges that aren't publicly routable beyond what net.IP reports
+var blockedNets = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),
+	mustCIDR("100.64.0.0/10"), // Carrier-grade NAT
+	mustCIDR("192.0.0.0/24"),
+	mustCIDR("198.18.0.0/15"), // Benchmarking
+	mustCIDR("240.0.0.0/4"),
+	mustCIDR("64:ff9b::/96"), // NAT64, may reach IPv4 private ranges
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// blockedIP reports whether ip is private, loopback, link-local or
+// otherwise not a public unicast address
+func blockedIP(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that raw is an http(s) URL without credentials whose
+// host resolves only to public addresses
+func ValidateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: invalid URL", ErrBlockedTarget)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrBlockedTarget)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials are not allowed in the URL", ErrBlockedTarget)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: host is required", ErrBlockedTarget)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if blockedIP(ip) {
+			return fmt.Errorf("%w: %s is not a public address", ErrBlockedTarget, ip)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: failed to resolve %s", ErrBlockedTarget, host)
+	}
+	for _, addr := range addrs {
+		if blockedIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s, which is not a public address", ErrBlockedTarget, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// newClient returns an HTTP client that refuses to connect to blocked
+// addresses, so DNS answers that change after ValidateURL can't reach
+// internal hosts. It ignores proxy settings and doesn't follow redirects.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return fmt.Errorf("%w: %s is not a public address", ErrBlockedTarget, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        20,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,362 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"awning-backend/db"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// MaxWebhooksPerTenant caps the webhooks a tenant may configure
+	MaxWebhooksPerTenant = 10
+
+	DefaultDeliveriesPerPage = 50
+	MaxDeliveriesPerPage     = 200
+)
+
+// Handler handles tenant webhook management requests
+type Handler struct {
+	logger *slog.Logger
+	db     *db.DB
+}
+
+// NewHandler creates a new webhook handler
+func NewHandler(database *db.DB) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "WebhookHandler"),
+		db:     database,
+	}
+}
+
+// CreateWebhookRequest configures a new webhook
+type CreateWebhookRequest struct {
+	URL     string   `json:"url" binding:"required"`
+	Events  []string `json:"events" binding:"required,min=1"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// UpdateWebhookRequest changes a webhook; omitted fields are kept
+type UpdateWebhookRequest struct {
+	URL     *string  `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+// validEvents reports whether events is a non-empty list of known event types
+func validEvents(events []string) bool {
+	if len(events) == 0 {
+		return false
+	}
+	for _, event := range events {
+		if !slices.Contains(EventTypes, event) {
+			return false
+		}
+	}
+	return true
+}
+
+// newSecret returns a random signing secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// webhookID parses the :id route parameter
+func webhookID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// ListWebhooks returns the tenant's webhooks
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	hooks := []models.TenantWebhook{}
+	err := h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Order("id").Find(&hooks).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list webhooks", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks, "eventTypes": EventTypes})
+}
+
+// CreateWebhook adds a webhook. The signing secret is only returned here.
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validEvents(req.Events) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "unknown event type", "code": "unknown_event", "eventTypes": EventTypes})
+		return
+	}
+	if err := ValidateURL(c.Request.Context(), req.URL); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "invalid_url"})
+		return
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate webhook secret", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+	hook := models.TenantWebhook{
+		TenantSchema: tenantID,
+		URL:          req.URL,
+		Secret:       secret,
+		Events:       slices.Compact(slices.Sorted(slices.Values(req.Events))),
+		Enabled:      req.Enabled == nil || *req.Enabled,
+	}
+
+	errLimit := errors.New("webhook limit reached")
+	err = h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.TenantWebhook{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxWebhooksPerTenant {
+			return errLimit
+		}
+		// gorm skips zero values that have a default, so a disabled webhook
+		// is created enabled and then switched off
+		if err := tx.Create(&hook).Error; err != nil {
+			return err
+		}
+		if !hook.Enabled {
+			return tx.Model(&hook).Update("enabled", false).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errLimit) {
+		c.JSON(http.StatusConflict, gin.H{"error": "a tenant can have at most " + strconv.Itoa(MaxWebhooksPerTenant) + " webhooks", "code": "webhook_limit"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create webhook", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create webhook"})
+		return
+	}
+
+	h.logger.Info("Webhook created", "tenant", tenantID, "webhook_id", hook.ID, "events", hook.Events)
+
+	c.JSON(http.StatusCreated, gin.H{"webhook": hook, "secret": secret})
+}
+
+// loadWebhook loads the webhook named by :id, responding when it can't
+func (h *Handler) loadWebhook(c *gin.Context, tenantID string) (*models.TenantWebhook, bool) {
+	id, ok := webhookID(c)
+	if !ok {
+		return nil, false
+	}
+	var hook models.TenantWebhook
+	err := h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.First(&hook, id).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.logger.Error("Failed to load webhook", "tenant", tenantID, "webhook_id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load webhook"})
+		return nil, false
+	}
+	return &hook, true
+}
+
+// GetWebhook returns one webhook
+func (h *Handler) GetWebhook(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	hook, ok := h.loadWebhook(c, tenantID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// UpdateWebhook changes a webhook's URL, events or whether it is enabled
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Events != nil && !validEvents(req.Events) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "unknown event type", "code": "unknown_event", "eventTypes": EventTypes})
+		return
+	}
+	if req.URL != nil {
+		if err := ValidateURL(c.Request.Context(), *req.URL); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "code": "invalid_url"})
+			return
+		}
+	}
+
+	hook, ok := h.loadWebhook(c, tenantID)
+	if !ok {
+		return
+	}
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Events != nil {
+		hook.Events = slices.Compact(slices.Sorted(slices.Values(req.Events)))
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+
+	err := h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Model(hook).Select("url", "events", "enabled").Updates(hook).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to update webhook", "tenant", tenantID, "webhook_id", hook.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update webhook"})
+		return
+	}
+
+	h.logger.Info("Webhook updated", "tenant", tenantID, "webhook_id", hook.ID, "enabled", hook.Enabled)
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// DeleteWebhook removes a webhook. Queued deliveries to it are dropped.
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	hook, ok := h.loadWebhook(c, tenantID)
+	if !ok {
+		return
+	}
+
+	err := h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Delete(hook).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to delete webhook", "tenant", tenantID, "webhook_id", hook.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete webhook"})
+		return
+	}
+
+	h.logger.Info("Webhook deleted", "tenant", tenantID, "webhook_id", hook.ID)
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries returns a webhook's delivery attempts, newest first
+func (h *Handler) ListDeliveries(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultDeliveriesPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxDeliveriesPerPage {
+			perPage = parsed
+		}
+	}
+
+	hook, ok := h.loadWebhook(c, tenantID)
+	if !ok {
+		return
+	}
+
+	var total int64
+	deliveries := []models.TenantWebhookDelivery{}
+	err := h.db.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		q := tx.Model(&models.TenantWebhookDelivery{}).Where("webhook_id = ?", hook.ID)
+		if err := q.Count(&total).Error; err != nil {
+			return err
+		}
+		return q.Order("created_at DESC, id DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&deliveries).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list webhook deliveries", "tenant", tenantID, "webhook_id", hook.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"page":       page,
+		"perPage":    perPage,
+		"total":      total,
+	})
+}
+
+// RegisterRoutes registers tenant webhook routes
+func RegisterRoutes(r *gin.RouterGroup, database *db.DB, jwtManager *auth.JWTManager) {
+	handler := NewHandler(database)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	webhookRoutes := r.Group("/api/v1/webhooks")
+	webhookRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	webhookRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		webhookRoutes.GET("", handler.ListWebhooks)
+		webhookRoutes.POST("", handler.CreateWebhook)
+		webhookRoutes.GET("/:id", handler.GetWebhook)
+		webhookRoutes.PATCH("/:id", handler.UpdateWebhook)
+		webhookRoutes.DELETE("/:id", handler.DeleteWebhook)
+		webhookRoutes.GET("/:id/deliveries", handler.ListDeliveries)
+	}
+}
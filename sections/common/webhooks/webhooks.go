@@ -0,0 +1,140 @@
+// Package webhooks posts the tenant's events to the endpoints it configured,
+// signed with each endpoint's secret and retried through the job queue
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/jobs"
+	"awning-backend/sections/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Event types. Payloads never carry page HTML, only references to it.
+const (
+	EventChatCompleted      = "chat.completed"
+	EventPublicationCreated = "publication.created"
+	EventDomainVerified     = "domain.verified"
+	EventPaymentSucceeded   = "payment.succeeded"
+)
+
+// EventTypes lists the event types webhooks may subscribe to
+var EventTypes = []string{EventChatCompleted, EventPublicationCreated, EventDomainVerified, EventPaymentSucceeded}
+
+// Headers sent with each delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the webhook's secret, prefixed with "sha256=".
+const (
+	SignatureHeader = "X-Awning-Signature"
+	TimestampHeader = "X-Awning-Timestamp"
+	EventIDHeader   = "X-Awning-Event-Id"
+	EventTypeHeader = "X-Awning-Event"
+)
+
+// JobKindDeliver posts one event to one webhook
+const JobKindDeliver = "webhooks.deliver"
+
+// Event is the JSON body posted to webhooks
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Tenant    string    `json:"tenant"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+// deliveryPayload is the payload of JobKindDeliver jobs
+type deliveryPayload struct {
+	TenantSchema string          `json:"tenantSchema"`
+	WebhookID    uint            `json:"webhookId"`
+	EventID      string          `json:"eventId"`
+	EventType    string          `json:"eventType"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Emitter queues deliveries of the tenant's events to its webhooks
+type Emitter struct {
+	logger *slog.Logger
+	db     *db.DB
+	queue  *jobs.Queue
+}
+
+// NewEmitter creates an emitter queueing deliveries on queue
+func NewEmitter(database *db.DB, queue *jobs.Queue) *Emitter {
+	return &Emitter{
+		logger: slog.With("service", "WebhookEmitter"),
+		db:     database,
+		queue:  queue,
+	}
+}
+
+// Emit queues a delivery of the event to each of the tenant's enabled
+// webhooks subscribed to eventType. Failures are logged rather than returned
+// so that webhooks never fail the action they report. A nil emitter does
+// nothing.
+func (e *Emitter) Emit(ctx context.Context, tenantSchema, eventType string, data any) {
+	if e == nil || tenantSchema == "" {
+		return
+	}
+
+	var hooks []models.TenantWebhook
+	err := e.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("enabled = ?", true).Find(&hooks).Error
+	})
+	if err != nil {
+		e.logger.Error("Failed to load webhooks", "tenant", tenantSchema, "event", eventType, "error", err)
+		return
+	}
+	hooks = slices.DeleteFunc(hooks, func(hook models.TenantWebhook) bool {
+		return !slices.Contains(hook.Events, eventType)
+	})
+	if len(hooks) == 0 {
+		return
+	}
+
+	event := Event{
+		ID:        "evt_" + uuid.NewString(),
+		Type:      eventType,
+		Tenant:    tenantSchema,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		e.logger.Error("Failed to encode webhook event", "tenant", tenantSchema, "event", eventType, "error", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		job := jobs.New(JobKindDeliver, deliveryPayload{
+			TenantSchema: tenantSchema,
+			WebhookID:    hook.ID,
+			EventID:      event.ID,
+			EventType:    eventType,
+			Body:         body,
+		}, jobs.DEFAULT_MAX_RETRIES)
+		if err := e.queue.Enqueue(ctx, job); err != nil {
+			e.logger.Error("Failed to queue webhook delivery", "tenant", tenantSchema, "webhook_id", hook.ID, "event_id", event.ID, "error", err)
+		}
+	}
+	e.logger.Info("Webhook event queued", "tenant", tenantSchema, "event", eventType, "event_id", event.ID, "webhooks", len(hooks))
+}
@@ -0,0 +1,23 @@
+package webhooks
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+
+	// HMAC-SHA256 of "1700000000.{"id":"evt_1"}" with the key whsec_test
+	want := "sha256=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got := Sign("whsec_test", 1700000000, body); got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+
+	if Sign("whsec_other", 1700000000, body) == want {
+		t.Errorf("signature doesn't depend on the secret")
+	}
+	if Sign("whsec_test", 1700000001, body) == want {
+		t.Errorf("signature doesn't depend on the timestamp")
+	}
+	if Sign("whsec_test", 1700000000, []byte(`{"id":"evt_2"}`)) == want {
+		t.Errorf("signature doesn't depend on the body")
+	}
+}
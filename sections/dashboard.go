@@ -0,0 +1,18 @@
+package sections
+
+import (
+	"context"
+	"log/slog"
+)
+
+// InvalidateDashboard drops the tenant's cached dashboard after a change to
+// data it shows, such as credits, domains or the subscription. Failures are
+// logged; the cache expires on its own shortly after.
+func (d *Dependencies) InvalidateDashboard(ctx context.Context, tenantSchema string) {
+	if d.Redis == nil || tenantSchema == "" {
+		return
+	}
+	if err := d.Redis.InvalidateDashboard(ctx, tenantSchema); err != nil {
+		slog.Warn("Failed to invalidate dashboard", "tenant_schema", tenantSchema, "error", err)
+	}
+}
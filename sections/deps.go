@@ -5,20 +5,38 @@ import (
 
 	"awning-backend/common"
 	"awning-backend/db"
+	"awning-backend/jobs"
+	"awning-backend/metrics"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/common/webhooks"
 	"awning-backend/services"
+	"awning-backend/services/ai"
 	"awning-backend/storage"
 	"awning-backend/utils"
 )
 
 // StreamEvent represents a streaming event from the AI
-type StreamEvent struct {
-	Type    string
-	Content string
-}
+type StreamEvent = ai.StreamEvent
 
-// VertexClient interface for AI content generation (copied from handlers for decoupling)
+// VertexClient interface for AI content generation, implemented by ai.Client
 type VertexClient interface {
-	GenerateContentStream(ctx context.Context, prompt string, callback func(StreamEvent) error) error
+	GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(StreamEvent) error) error
+}
+
+// VertexCompletionClient is optionally implemented by clients that support
+// non-streaming generation
+type VertexCompletionClient interface {
+	GenerateContent(ctx context.Context, prompt string, params common.GenerationParams) (string, error)
+}
+
+// VertexModelCompletionClient is optionally implemented by clients that can
+// run a non-streaming generation on a specific model
+type VertexModelCompletionClient interface {
+	GenerateContentWithModel(ctx context.Context, model string, prompt string) (string, error)
 }
 
 // Dependencies holds all shared dependencies for handlers
@@ -26,10 +44,26 @@ type Dependencies struct {
 	Config        *common.Config
 	DB            *db.DB
 	Redis         *storage.RedisClient
+	Chats         storage.ChatStore
+	ChatSpill     *SpillingChatStore // Wraps Chats; nil when chat_spill is off
+	ChatLocks     storage.ChatLocker
+	KV            storage.KV
 	PromptBuilder *utils.PromptBuilder
 	VertexClient  VertexClient
 	ProcessorsSvc *services.Processors
 	UnsplashSvc   *services.UnsplashService
+	ImageStore    services.ImageStore
+	Responses     services.ResponseStore
+	Moderation    services.ModerationService
+	Plans         []common.Plan
+	Sites         *sites.Resolver
+	Settings      *settings.Store
+	Jobs          *jobs.Queue
+	Flags         *flags.Flags
+	Webhooks      *webhooks.Emitter
+	Notifications *notifications.Service
+	MetricsLabels *metrics.TenantLabels
+	JWT           *auth.JWTManager
 }
 
 // NewDependencies creates a new Dependencies instance
@@ -46,6 +80,9 @@ func NewDependencies(
 		Config:        cfg,
 		DB:            database,
 		Redis:         redis,
+		Chats:         redis,
+		ChatLocks:     redis,
+		KV:            redis,
 		PromptBuilder: promptBuilder,
 		VertexClient:  vertexClient,
 		ProcessorsSvc: processorsSvc,
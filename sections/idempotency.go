@@ -0,0 +1,23 @@
+package sections
+
+import (
+	"time"
+
+	"awning-backend/middleware"
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idempotency returns middleware that replays the stored response to
+// requests retried with the same Idempotency-Key. Use it on routes that
+// charge or move credits, after authentication so keys are scoped to the
+// tenant or user.
+func (d *Dependencies) Idempotency() gin.HandlerFunc {
+	return middleware.IdempotencyMiddleware(d.Redis, middleware.IdempotencyConfig{
+		TTL:          time.Duration(d.Config.IdempotencyTTLHours) * time.Hour,
+		MaxBodyBytes: d.Config.IdempotencyMaxBodyBytes,
+		Wait:         time.Duration(d.Config.IdempotencyWaitSeconds) * time.Second,
+		Scope:        auth.IdempotencyScope,
+	})
+}
@@ -17,7 +17,8 @@ type Payment struct {
 	StripeCustomerID      string `gorm:"size:255;index" json:"stripeCustomerId"`
 	Amount                int64  `gorm:"not null" json:"amount"` // Amount in cents
 	Currency              string `gorm:"size:3;not null;default:'usd'" json:"currency"`
-	Status                string `gorm:"size:50;not null;default:'pending'" json:"status"` // pending, succeeded, failed, canceled
+	Status                string `gorm:"size:50;not null;default:'pending'" json:"status"` // pending, succeeded, failed, canceled, refunded, partially_refunded
+	AmountRefunded        int64  `gorm:"not null;default:0" json:"amountRefunded"`         // Amount refunded in cents
 	Description           string `gorm:"size:500" json:"description"`
 	Metadata              string `gorm:"type:jsonb" json:"metadata,omitempty"` // JSON string for additional data
 
@@ -40,6 +41,37 @@ func (Payment) IsSharedModel() bool {
 	return true
 }
 
+// Refund represents a full or partial refund of a Payment, whether issued
+// through the API or from the Stripe dashboard
+type Refund struct {
+	gorm.Model
+	PaymentID uint `gorm:"not null;index" json:"paymentId"`
+
+	// Stripe fields
+	StripeRefundID string `gorm:"uniqueIndex;size:255;not null" json:"stripeRefundId"`
+	Amount         int64  `gorm:"not null" json:"amount"` // Amount in cents
+	Currency       string `gorm:"size:3;not null;default:'usd'" json:"currency"`
+	Reason         string `gorm:"size:50" json:"reason"`
+	Status         string `gorm:"size:50;not null;default:'pending'" json:"status"` // pending, requires_action, succeeded, failed, canceled
+
+	// CreditsClawedBack is the number of unspent credits removed from the
+	// tenant account when the refund was issued
+	CreditsClawedBack int `gorm:"default:0" json:"creditsClawedBack"`
+
+	// Relations
+	Payment Payment `gorm:"foreignKey:PaymentID" json:"-"`
+}
+
+// TableName returns the table name with public schema prefix
+func (Refund) TableName() string {
+	return "public.refunds"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (Refund) IsSharedModel() bool {
+	return true
+}
+
 // Subscription represents a recurring subscription
 type Subscription struct {
 	gorm.Model
@@ -0,0 +1,38 @@
+package models
+
+import "github.com/bartventer/gorm-multitenancy/v8/pkg/driver"
+
+// All returns the shared and tenant models, in the order they are
+// registered with the database
+func All() []driver.TenantTabler {
+	return []driver.TenantTabler{
+		&Tenant{},
+		&User{},
+		&UserTenant{},
+		&Payment{},
+		&Refund{},
+		&Subscription{},
+		&ScheduledJob{},
+		&TenantExport{},
+		&CertificateRequest{},
+		&AuditEvent{},
+		&MessageFeedback{},
+		&ShareLink{},
+		// Tenant models
+		&TenantFilesystem{},
+		&TenantChat{},
+		&TenantProfile{},
+		&TenantAccount{},
+		&CreditTransaction{},
+		&TenantRequestLog{},
+		&UsageRecord{},
+		&TenantDomain{},
+		&TenantPublication{},
+		&TenantImage{},
+		&TenantSetting{},
+		&TenantWebhook{},
+		&TenantWebhookDelivery{},
+		&TenantNotification{},
+		&ContactSubmission{},
+	}
+}
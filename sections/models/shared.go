@@ -14,6 +14,14 @@ type Tenant struct {
 	Name        string `gorm:"size:255;not null" json:"name"`
 	DisplayName string `gorm:"size:255" json:"displayName"`
 	Active      bool   `gorm:"default:true" json:"active"`
+
+	// DeletionScheduledAt is set while an inactive tenant waits out the
+	// deletion grace period
+	DeletionScheduledAt *time.Time `gorm:"index" json:"deletionScheduledAt,omitempty"`
+
+	// ModerationMode overrides the configured moderation mode for this
+	// tenant (off, flag, block); empty uses the config
+	ModerationMode string `gorm:"size:10" json:"moderationMode,omitempty"`
 }
 
 // TableName returns the table name with public schema prefix
@@ -46,6 +54,9 @@ type User struct {
 	// Password reset
 	PasswordResetToken   *string    `gorm:"size:255" json:"-"`
 	PasswordResetExpires *time.Time `json:"-"`
+
+	// Stripe customer, set on the first payment
+	StripeCustomerID *string `gorm:"size:255;index" json:"-"`
 }
 
 // TableName returns the table name with public schema prefix
@@ -78,3 +89,145 @@ func (UserTenant) TableName() string {
 func (UserTenant) IsSharedModel() bool {
 	return true
 }
+
+// ScheduledJob is a unit of deferred work picked up by a background worker
+// once RunAt has passed (public/shared model)
+type ScheduledJob struct {
+	gorm.Model
+	Kind         string     `gorm:"size:100;not null;index" json:"kind"`
+	TenantSchema string     `gorm:"size:63;index" json:"tenantSchema"`
+	RunAt        time.Time  `gorm:"not null;index" json:"runAt"`
+	Status       string     `gorm:"size:20;not null;default:'pending';index" json:"status"` // pending, running, done, failed, canceled
+	Attempts     int        `gorm:"default:0" json:"attempts"`
+	LastError    string     `gorm:"size:1000" json:"lastError,omitempty"`
+	CompletedAt  *time.Time `json:"completedAt,omitempty"`
+}
+
+// TableName returns the table name with public schema prefix
+func (ScheduledJob) TableName() string {
+	return "public.scheduled_jobs"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (ScheduledJob) IsSharedModel() bool {
+	return true
+}
+
+// TenantExport is a snapshot of a tenant's data kept outside the tenant
+// schema so it can be downloaded after offboarding (public/shared model)
+type TenantExport struct {
+	gorm.Model
+	TenantSchema string `gorm:"size:63;not null;index" json:"tenantSchema"`
+	RequestedBy  uint   `gorm:"not null" json:"requestedBy"`
+	Content      string `gorm:"type:jsonb;not null" json:"-"`
+	Size         int64  `gorm:"default:0" json:"size"`
+	Checksum     string `gorm:"size:64" json:"checksum"` // SHA256 hash
+}
+
+// TableName returns the table name with public schema prefix
+func (TenantExport) TableName() string {
+	return "public.tenant_exports"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (TenantExport) IsSharedModel() bool {
+	return true
+}
+
+// CertificateRequest asks the external ACME worker to issue a certificate for
+// a verified domain that has no valid one (public/shared model)
+type CertificateRequest struct {
+	gorm.Model
+	TenantSchema string     `gorm:"size:63;not null;index" json:"tenantSchema"`
+	Domain       string     `gorm:"size:255;not null;uniqueIndex:idx_cert_requests_one_pending,where:status = 'pending'" json:"domain"`
+	Status       string     `gorm:"size:20;not null;default:'pending';index" json:"status"` // pending, issued, failed
+	Error        string     `gorm:"size:1000" json:"error,omitempty"`
+	IssuedAt     *time.Time `json:"issuedAt,omitempty"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+}
+
+// TableName returns the table name with public schema prefix
+func (CertificateRequest) TableName() string {
+	return "public.certificate_requests"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (CertificateRequest) IsSharedModel() bool {
+	return true
+}
+
+// AuditEvent records a security-relevant action (public/shared model)
+type AuditEvent struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"createdAt"`
+	TenantSchema string    `gorm:"size:63;index" json:"tenantSchema,omitempty"`
+	UserID       *uint     `gorm:"index" json:"userId,omitempty"`
+	Action       string    `gorm:"size:100;not null;index" json:"action"`
+	Detail       string    `gorm:"type:jsonb" json:"detail,omitempty"`
+	IP           string    `gorm:"size:64" json:"ip,omitempty"` // Client IP, for events recorded during a request
+}
+
+// TableName returns the table name with public schema prefix
+func (AuditEvent) TableName() string {
+	return "public.audit_events"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (AuditEvent) IsSharedModel() bool {
+	return true
+}
+
+// MessageFeedback is a user's rating of an assistant message, kept with the
+// model and prompt experiment that produced it for evaluation (public/shared
+// model). A user has one rating per message.
+type MessageFeedback struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+	TenantSchema string    `gorm:"size:63;index" json:"tenantSchema,omitempty"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_message_feedback_user_message" json:"userId"`
+	ChatID       string    `gorm:"size:36;not null;uniqueIndex:idx_message_feedback_user_message;index" json:"chatId"`
+	MessageID    string    `gorm:"size:64;not null;uniqueIndex:idx_message_feedback_user_message" json:"messageId"`
+	Rating       string    `gorm:"size:4;not null;index" json:"rating"` // up, down
+	Reasons      []string  `gorm:"serializer:json;type:jsonb" json:"reasons"`
+	Comment      string    `gorm:"size:2000" json:"comment,omitempty"`
+	Model        string    `gorm:"size:100;index" json:"model,omitempty"`
+	Variant      string    `gorm:"size:100;index" json:"promptVariant,omitempty"`
+}
+
+// TableName returns the table name with public schema prefix
+func (MessageFeedback) TableName() string {
+	return "public.message_feedback"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (MessageFeedback) IsSharedModel() bool {
+	return true
+}
+
+// ShareLink is a read-only preview link to a generated site, usable without
+// an account until it expires or is deleted (public/shared model). Only the
+// SHA-256 hash of the token is stored.
+type ShareLink struct {
+	gorm.Model
+	TenantSchema   string     `gorm:"size:63;not null;index" json:"tenantSchema"`
+	TokenHash      string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ChatID         string     `gorm:"size:36;index" json:"chatId,omitempty"`
+	MessageID      string     `gorm:"size:64" json:"messageId,omitempty"`
+	FilesystemKey  string     `gorm:"size:255" json:"filesystemKey,omitempty"`
+	PasswordHash   string     `gorm:"size:255" json:"-"`
+	ExpiresAt      time.Time  `gorm:"not null;index" json:"expiresAt"`
+	CreatedBy      uint       `gorm:"not null" json:"createdBy"`
+	AccessCount    int64      `gorm:"default:0" json:"accessCount"`
+	LastAccessedAt *time.Time `json:"lastAccessedAt,omitempty"`
+}
+
+// TableName returns the table name with public schema prefix
+func (ShareLink) TableName() string {
+	return "public.share_links"
+}
+
+// IsSharedModel indicates this is a shared/public model
+func (ShareLink) IsSharedModel() bool {
+	return true
+}
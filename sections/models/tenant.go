@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -19,7 +20,8 @@ type TenantProfile struct {
 	Address      string `gorm:"type:text" json:"address"`
 	Timezone     string `gorm:"size:50;default:'UTC'" json:"timezone"`
 	Locale       string `gorm:"size:10;default:'en-US'" json:"locale"`
-	Metadata     string `gorm:"type:jsonb" json:"metadata"` // Additional JSON metadata
+	Metadata     string `gorm:"type:jsonb" json:"metadata"`  // Additional JSON metadata
+	BrandVoice   string `gorm:"type:text" json:"brandVoice"` // Extra copywriting instructions added to prompts
 }
 
 // TableName returns the table name (no prefix for tenant-scoped)
@@ -27,6 +29,18 @@ func (TenantProfile) TableName() string {
 	return "profiles"
 }
 
+// Hours returns the opening hours kept in the profile's metadata as
+// {"hours": "..."}, or "" when there are none
+func (p *TenantProfile) Hours() string {
+	var metadata struct {
+		Hours string `json:"hours"`
+	}
+	if err := json.Unmarshal([]byte(p.Metadata), &metadata); err != nil {
+		return ""
+	}
+	return metadata.Hours
+}
+
 // IsSharedModel indicates this is a tenant-specific model
 func (TenantProfile) IsSharedModel() bool {
 	return false
@@ -35,7 +49,7 @@ func (TenantProfile) IsSharedModel() bool {
 // TenantAccount stores account/billing information (tenant-scoped model)
 type TenantAccount struct {
 	gorm.Model
-	TenantSchema      string     `gorm:"size:63;not null;index" json:"tenantSchema"`
+	TenantSchema      string     `gorm:"size:63;not null;index;uniqueIndex:idx_accounts_one_per_tenant,where:deleted_at IS NULL" json:"tenantSchema"`
 	PaidAccount       bool       `gorm:"default:false" json:"paidAccount"`
 	BasicCredits      int        `gorm:"default:0" json:"basicCredits"`
 	PremiumCredits    int        `gorm:"default:0" json:"premiumCredits"`
@@ -56,10 +70,89 @@ func (TenantAccount) IsSharedModel() bool {
 	return false
 }
 
+// CreditTransaction is one entry of the tenant's credit ledger (tenant-scoped
+// model). The account's balances change only together with a transaction, so
+// BalanceAfter of the latest entry per credit type matches the account.
+type CreditTransaction struct {
+	gorm.Model
+	TenantSchema string `gorm:"size:63;not null;index" json:"tenantSchema"`
+	CreditType   string `gorm:"size:20;not null;index" json:"creditType"` // basic, premium
+	Type         string `gorm:"size:30;not null;index" json:"type"`       // opening_balance, grant, usage, adjustment, refund
+	Delta        int    `gorm:"not null" json:"delta"`
+	BalanceAfter int    `gorm:"not null" json:"balanceAfter"`
+	Reason       string `gorm:"size:255" json:"reason,omitempty"`
+	Actor        string `gorm:"size:100" json:"actor,omitempty"` // user:<id>, admin or system
+	PaymentID    *uint  `gorm:"index" json:"paymentId,omitempty"`
+	ChatID       string `gorm:"size:36;index" json:"chatId,omitempty"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (CreditTransaction) TableName() string {
+	return "credit_transactions"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (CreditTransaction) IsSharedModel() bool {
+	return false
+}
+
+// TenantRequestLog is a compact record of one API request made in the
+// tenant's context (tenant-scoped model). Request and response bodies are
+// never stored; Error is redacted before it is saved.
+type TenantRequestLog struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"createdAt"`
+	TenantSchema string    `gorm:"size:63;not null;index" json:"tenantSchema"`
+	RequestID    string    `gorm:"size:64;index" json:"requestId"`
+	Method       string    `gorm:"size:10" json:"method"`
+	Route        string    `gorm:"size:255" json:"route"` // Route pattern, such as /api/v1/chat/:id
+	Status       int       `gorm:"index" json:"status"`
+	LatencyMs    int64     `json:"latencyMs"`
+	UserID       *uint     `json:"userId,omitempty"`
+	IP           string    `gorm:"size:64" json:"ip,omitempty"`
+	Error        string    `gorm:"size:255" json:"error,omitempty"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantRequestLog) TableName() string {
+	return "request_logs"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantRequestLog) IsSharedModel() bool {
+	return false
+}
+
+// UsageRecord is the usage of one completed chat generation, which usage
+// reports add up (tenant-scoped model). Tokens cover every model request
+// the generation made: the page or pages, and any site plan, site metadata
+// and language retry.
+type UsageRecord struct {
+	ID               uint      `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time `gorm:"index" json:"createdAt"`
+	TenantSchema     string    `gorm:"size:63;not null;index" json:"tenantSchema"`
+	ChatID           string    `gorm:"size:36;index" json:"chatId"`
+	MessageID        string    `gorm:"size:36" json:"messageId"`
+	Model            string    `gorm:"size:100;not null" json:"model"`
+	PromptTokens     int64     `gorm:"not null;default:0" json:"promptTokens"`
+	CompletionTokens int64     `gorm:"not null;default:0" json:"completionTokens"`
+	ImageSearches    int64     `gorm:"not null;default:0" json:"imageSearches"` // Unsplash searches made
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (UsageRecord) TableName() string {
+	return "usage_records"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (UsageRecord) IsSharedModel() bool {
+	return false
+}
+
 // TenantDomain stores domain configuration (tenant-scoped model)
 type TenantDomain struct {
 	gorm.Model
-	TenantSchema  string     `gorm:"size:63;not null;index" json:"tenantSchema"`
+	TenantSchema  string     `gorm:"size:63;not null;index;uniqueIndex:idx_domains_one_primary,where:is_primary" json:"tenantSchema"`
 	Domain        string     `gorm:"size:255;not null;uniqueIndex" json:"domain"`
 	DomainType    string     `gorm:"size:50;default:'subdomain'" json:"domainType"` // subdomain, custom, registered
 	Verified      bool       `gorm:"default:false" json:"verified"`
@@ -69,7 +162,24 @@ type TenantDomain struct {
 	RegistrarID   *string    `gorm:"size:255" json:"-"` // External registrar reference ID
 	RegistrarName *string    `gorm:"size:100" json:"-"` // namecheap, cloudflare, opensrs
 	DNSConfigured bool       `gorm:"default:false" json:"dnsConfigured"`
-	Primary       bool       `gorm:"default:false" json:"primary"`
+	// Stored as is_primary since "primary" is reserved in Postgres; the JSON
+	// name is unchanged. At most one primary per tenant (partial unique index).
+	IsPrimary bool `gorm:"column:is_primary;default:false" json:"primary"`
+	// Registration expiry, synced from the registrar for registered domains
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	AutoRenew bool       `gorm:"default:false" json:"autoRenew"`
+	// Smallest reminder threshold (in days) already sent for ExpiresAt; reset
+	// when the expiry moves
+	ExpiryReminderDays int `gorm:"default:0" json:"-"`
+	// Payment intent of the last applied renewal, so a paid renewal is not
+	// applied twice
+	RenewalPaymentIntentID *string `gorm:"size:255" json:"-"`
+	// Result of the last TLS check against the domain on port 443
+	SSLIssuer        string     `gorm:"size:255" json:"sslIssuer,omitempty"`
+	SSLHostnameMatch bool       `gorm:"default:false" json:"sslHostnameMatch"`
+	SSLError         string     `gorm:"size:500" json:"sslError,omitempty"`
+	SSLCheckedAt     *time.Time `json:"sslCheckedAt,omitempty"`
+	SSLReminderSent  bool       `gorm:"default:false" json:"-"` // reset when SSLExpiresAt moves
 }
 
 // TableName returns the table name (no prefix for tenant-scoped)
@@ -87,7 +197,7 @@ type TenantFilesystem struct {
 	gorm.Model
 	TenantSchema string `gorm:"size:63;not null;index" json:"tenantSchema"`
 	Key          string `gorm:"size:255;not null;index" json:"key"` // Path-like key
-	Data         string `gorm:"type:jsonb;not null" json:"data"`
+	Data         string `gorm:"type:jsonb;not null;index:idx_filesystem_data,type:gin,expression:data jsonb_path_ops" json:"data"`
 	ContentType  string `gorm:"size:100;default:'application/json'" json:"contentType"`
 	Size         int64  `gorm:"default:0" json:"size"`
 	Checksum     string `gorm:"size:64" json:"checksum"` // SHA256 hash
@@ -111,6 +221,16 @@ type TenantChat struct {
 	Messages     string `gorm:"type:jsonb" json:"messages"`                 // JSON array of messages
 	ChatStage    string `gorm:"size:50" json:"chatStage"`
 	LastRole     string `gorm:"size:20" json:"lastRole"`
+
+	// Fields of model.Chat kept for the Postgres chat store
+	Title           string   `gorm:"size:255" json:"title,omitempty"`
+	Revision        int64    `gorm:"not null;default:0" json:"revision"`
+	PromptVariant   string   `gorm:"size:50" json:"promptVariant,omitempty"`
+	ModerationFlags []string `gorm:"type:jsonb;serializer:json" json:"moderationFlags,omitempty"`
+	UserID          uint     `gorm:"index" json:"userId,omitempty"`
+
+	Variables     map[string]string `gorm:"type:jsonb;serializer:json" json:"variables,omitempty"`
+	Clarification json.RawMessage   `gorm:"type:jsonb;serializer:json" json:"clarification,omitempty"` // model.ChatClarification
 }
 
 // TableName returns the table name (no prefix for tenant-scoped)
@@ -122,3 +242,175 @@ func (TenantChat) TableName() string {
 func (TenantChat) IsSharedModel() bool {
 	return false
 }
+
+// TenantPublication stores a published snapshot of the tenant's site (tenant-scoped model)
+type TenantPublication struct {
+	gorm.Model
+	TenantSchema string    `gorm:"size:63;not null;index" json:"tenantSchema"`
+	Version      int       `gorm:"not null;uniqueIndex" json:"version"`
+	Content      string    `gorm:"type:text;not null" json:"-"`
+	Pages        string    `gorm:"type:text" json:"-"`         // JSON object of path to HTML for pages other than /
+	ContentHash  string    `gorm:"size:64" json:"contentHash"` // SHA256 hash
+	Source       string    `gorm:"size:255" json:"source"`     // chat:<id> or filesystem:<key>
+	Current      bool      `gorm:"default:false;index" json:"current"`
+	PublishedAt  time.Time `json:"publishedAt"`
+	PublishedBy  uint      `json:"publishedBy"`
+
+	// Detached ES512 JWS over the publication's digests, see publish.Statement
+	Signature      string     `gorm:"type:text" json:"-"`
+	SignatureKeyID string     `gorm:"size:64" json:"signatureKeyId,omitempty"`
+	SignedAt       *time.Time `json:"signedAt,omitempty"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantPublication) TableName() string {
+	return "publications"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantPublication) IsSharedModel() bool {
+	return false
+}
+
+// TenantImage stores an image uploaded by the tenant (tenant-scoped model)
+type TenantImage struct {
+	gorm.Model
+	TenantSchema string   `gorm:"size:63;not null;index" json:"tenantSchema"`
+	Key          string   `gorm:"size:512;not null" json:"key"` // Object path in the image store
+	URL          string   `gorm:"size:1024;not null" json:"url"`
+	ContentType  string   `gorm:"size:50" json:"contentType"`
+	Size         int      `json:"size"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	Alt          string   `gorm:"size:255" json:"alt"`
+	Keywords     []string `gorm:"type:jsonb;serializer:json" json:"keywords"`
+	Purpose      string   `gorm:"size:20;not null;default:''" json:"purpose"` // Empty for page images, or favicon
+}
+
+// ImagePurposeFavicon marks uploads made as favicons, which aren't used as
+// page images
+const ImagePurposeFavicon = "favicon"
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantImage) TableName() string {
+	return "images"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantImage) IsSharedModel() bool {
+	return false
+}
+
+// TenantSetting overrides the default of one registered tenant setting
+// (tenant-scoped model). Value holds the JSON-encoded setting value.
+type TenantSetting struct {
+	gorm.Model
+	TenantSchema string          `gorm:"size:63;not null;uniqueIndex:idx_settings_tenant_key" json:"tenantSchema"`
+	Key          string          `gorm:"size:100;not null;uniqueIndex:idx_settings_tenant_key" json:"key"`
+	Value        json.RawMessage `gorm:"type:jsonb;serializer:json" json:"value"`
+	UpdatedBy    uint            `json:"updatedBy"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantSetting) TableName() string {
+	return "settings"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantSetting) IsSharedModel() bool {
+	return false
+}
+
+// TenantWebhook is an endpoint the tenant's events are posted to
+// (tenant-scoped model). Events lists the event types it receives.
+type TenantWebhook struct {
+	gorm.Model
+	TenantSchema string   `gorm:"size:63;not null;index" json:"tenantSchema"`
+	URL          string   `gorm:"size:2048;not null" json:"url"`
+	Secret       string   `gorm:"size:255;not null" json:"-"` // HMAC-SHA256 signing key
+	Events       []string `gorm:"type:jsonb;serializer:json" json:"events"`
+	Enabled      bool     `gorm:"default:true" json:"enabled"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantWebhook) TableName() string {
+	return "webhooks"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantWebhook) IsSharedModel() bool {
+	return false
+}
+
+// TenantWebhookDelivery is one attempt at posting an event to a webhook
+// (tenant-scoped model)
+type TenantWebhookDelivery struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"createdAt"`
+	WebhookID  uint      `gorm:"not null;index" json:"webhookId"`
+	EventID    string    `gorm:"size:64;not null;index" json:"eventId"`
+	EventType  string    `gorm:"size:64;not null" json:"eventType"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"statusCode,omitempty"` // 0 when no response was received
+	Success    bool      `json:"success"`
+	Error      string    `gorm:"size:255" json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantWebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantWebhookDelivery) IsSharedModel() bool {
+	return false
+}
+
+// TenantNotification is an entry of the tenant's in-app notification feed
+// (tenant-scoped model)
+type TenantNotification struct {
+	ID           uint           `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time      `gorm:"index" json:"createdAt"`
+	TenantSchema string         `gorm:"size:63;not null;index" json:"tenantSchema"`
+	Type         string         `gorm:"size:64;not null;index" json:"type"`
+	Title        string         `gorm:"size:255;not null" json:"title"`
+	Body         string         `gorm:"type:text" json:"body"`
+	Metadata     map[string]any `gorm:"type:jsonb;serializer:json" json:"metadata,omitempty"`
+	ReadAt       *time.Time     `gorm:"index" json:"readAt"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (TenantNotification) TableName() string {
+	return "notifications"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (TenantNotification) IsSharedModel() bool {
+	return false
+}
+
+// ContactSubmission is a message sent through a published site's contact
+// form (tenant-scoped model)
+type ContactSubmission struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `gorm:"index" json:"createdAt"`
+	TenantSchema string    `gorm:"size:63;not null;index" json:"tenantSchema"`
+	Name         string    `gorm:"size:255" json:"name"`
+	Email        string    `gorm:"size:255;not null" json:"email"`
+	Message      string    `gorm:"type:text;not null" json:"message"`
+	Page         string    `gorm:"size:2048" json:"page,omitempty"`  // URL of the page the form is on
+	Origin       string    `gorm:"size:255" json:"origin,omitempty"` // Origin header, empty for same-origin requests
+	IP           string    `gorm:"size:64" json:"-"`
+	UserAgent    string    `gorm:"size:512" json:"-"`
+}
+
+// TableName returns the table name (no prefix for tenant-scoped)
+func (ContactSubmission) TableName() string {
+	return "contact_submissions"
+}
+
+// IsSharedModel indicates this is a tenant-specific model
+func (ContactSubmission) IsSharedModel() bool {
+	return false
+}
@@ -0,0 +1,54 @@
+package sections
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"gorm.io/gorm"
+)
+
+// StripeCustomerID returns the user's Stripe customer ID. The stored ID is
+// used when there is one; otherwise the customer is found by email or
+// created, and its ID saved on the user and, when tenantSchema is set, on
+// that tenant's account if it has none yet.
+func (d *Dependencies) StripeCustomerID(ctx context.Context, stripeSvc *services.StripeService, user *models.User, tenantSchema string) (string, error) {
+	if user.StripeCustomerID != nil && *user.StripeCustomerID != "" {
+		return *user.StripeCustomerID, nil
+	}
+
+	customer, err := stripeSvc.GetOrCreateCustomer(ctx, user.Email, user.FirstName+" "+user.LastName, map[string]string{
+		"user_id": strconv.FormatUint(uint64(user.ID), 10),
+		"email":   user.Email,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The customer exists either way, so failing to store it only costs
+	// another lookup next time
+	err = d.DB.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND stripe_customer_id IS NULL", user.ID).
+		Update("stripe_customer_id", customer.ID).Error
+	if err != nil {
+		slog.Error("Failed to store Stripe customer on user", "user_id", user.ID, "customer_id", customer.ID, "error", err)
+	} else {
+		user.StripeCustomerID = &customer.ID
+	}
+
+	if tenantSchema != "" {
+		err = d.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantAccount{}).
+				Where("stripe_customer_id IS NULL").
+				Update("stripe_customer_id", customer.ID).Error
+		})
+		if err != nil {
+			slog.Error("Failed to store Stripe customer on account", "tenant", tenantSchema, "customer_id", customer.ID, "error", err)
+		}
+	}
+
+	return customer.ID, nil
+}
@@ -1,9 +1,12 @@
 package account
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
 
+	"awning-backend/i18n"
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
 	"awning-backend/sections/models"
@@ -14,15 +17,19 @@ import (
 
 // Handler handles account-related requests
 type Handler struct {
-	logger *slog.Logger
-	deps   *sections.Dependencies
+	logger   *slog.Logger
+	deps     *sections.Dependencies
+	quota    *QuotaService
+	spending *SpendingService
 }
 
 // NewHandler creates a new account handler
 func NewHandler(deps *sections.Dependencies) *Handler {
 	return &Handler{
-		logger: slog.With("handler", "AccountHandler"),
-		deps:   deps,
+		logger:   slog.With("handler", "AccountHandler"),
+		deps:     deps,
+		quota:    NewQuotaService(deps),
+		spending: NewSpendingService(deps),
 	}
 }
 
@@ -44,6 +51,26 @@ type UpdateAccountRequest struct {
 	SubscriptionPlan *string `json:"subscriptionPlan,omitempty"`
 }
 
+// CreditsRequest adds or uses credits of one type
+type CreditsRequest struct {
+	Type   string `json:"type" binding:"required,oneof=basic premium"`
+	Amount int    `json:"amount" binding:"required,min=1"`
+	Reason string `json:"reason,omitempty" binding:"omitempty,max=255"`
+	// ChatID links credits used for a generation to its chat
+	ChatID string `json:"chatId,omitempty" binding:"omitempty,max=36"`
+}
+
+// GrantQuotaRequest grants extra generations for the current period
+type GrantQuotaRequest struct {
+	Amount int64  `json:"amount" binding:"required,min=1"`
+	Reason string `json:"reason"`
+}
+
+// ModerationModeRequest sets a tenant's moderation override; empty clears it
+type ModerationModeRequest struct {
+	Mode string `json:"mode" binding:"omitempty,oneof=off flag block"`
+}
+
 // GetAccount retrieves the tenant account
 func (h *Handler) GetAccount(c *gin.Context) {
 	tenantID, ok := auth.GetTenantIDFromContext(c)
@@ -87,28 +114,27 @@ func (h *Handler) UpdateAccount(c *gin.Context) {
 		return
 	}
 
-	var account models.TenantAccount
-	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		err := tx.Where("tenant_schema = ?", tenantID).First(&account).Error
-		if err != nil {
-			account = models.TenantAccount{
-				TenantSchema:     tenantID,
-				SubscriptionPlan: "free",
-			}
-		}
+	if (req.BasicCredits != nil && *req.BasicCredits < 0) || (req.PremiumCredits != nil && *req.PremiumCredits < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "credits must not be negative"})
+		return
+	}
 
+	// Setting a balance is recorded as an adjustment by the difference
+	account, _, err := ChangeCredits(c.Request.Context(), h.deps, tenantID, true, func(account *models.TenantAccount) ([]CreditEntry, error) {
+		var entries []CreditEntry
 		if req.BasicCredits != nil {
-			account.BasicCredits = *req.BasicCredits
+			entries = append(entries, CreditEntry{CreditType: CreditTypeBasic, Type: CreditTxnAdjustment,
+				Delta: *req.BasicCredits - account.BasicCredits, Reason: "Account update", Actor: userActor(c)})
 		}
 		if req.PremiumCredits != nil {
-			account.PremiumCredits = *req.PremiumCredits
+			entries = append(entries, CreditEntry{CreditType: CreditTypePremium, Type: CreditTxnAdjustment,
+				Delta: *req.PremiumCredits - account.PremiumCredits, Reason: "Account update", Actor: userActor(c)})
 		}
 		if req.SubscriptionPlan != nil {
 			account.SubscriptionPlan = *req.SubscriptionPlan
 			account.PaidAccount = *req.SubscriptionPlan != "free"
 		}
-
-		return tx.Save(&account).Error
+		return entries, nil
 	})
 
 	if err != nil {
@@ -117,7 +143,7 @@ func (h *Handler) UpdateAccount(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, h.toResponse(&account))
+	c.JSON(http.StatusOK, h.toResponse(account))
 }
 
 // AddCredits adds credits to the tenant account
@@ -128,32 +154,15 @@ func (h *Handler) AddCredits(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Type   string `json:"type" binding:"required,oneof=basic premium"`
-		Amount int    `json:"amount" binding:"required,min=1"`
-	}
+	var req CreditsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var account models.TenantAccount
-	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		err := tx.Where("tenant_schema = ?", tenantID).First(&account).Error
-		if err != nil {
-			account = models.TenantAccount{
-				TenantSchema:     tenantID,
-				SubscriptionPlan: "free",
-			}
-		}
-
-		if req.Type == "basic" {
-			account.BasicCredits += req.Amount
-		} else {
-			account.PremiumCredits += req.Amount
-		}
-
-		return tx.Save(&account).Error
+	account, _, err := ChangeCredits(c.Request.Context(), h.deps, tenantID, true, func(*models.TenantAccount) ([]CreditEntry, error) {
+		return []CreditEntry{{CreditType: req.Type, Type: CreditTxnGrant, Delta: req.Amount,
+			Reason: req.Reason, Actor: userActor(c), ChatID: req.ChatID}}, nil
 	})
 
 	if err != nil {
@@ -162,7 +171,7 @@ func (h *Handler) AddCredits(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, h.toResponse(&account))
+	c.JSON(http.StatusOK, h.toResponse(account))
 }
 
 // UseCredits deducts credits from the tenant account
@@ -173,39 +182,19 @@ func (h *Handler) UseCredits(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Type   string `json:"type" binding:"required,oneof=basic premium"`
-		Amount int    `json:"amount" binding:"required,min=1"`
-	}
+	var req CreditsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var account models.TenantAccount
-	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		err := tx.Where("tenant_schema = ?", tenantID).First(&account).Error
-		if err != nil {
-			return err
-		}
-
-		if req.Type == "basic" {
-			if account.BasicCredits < req.Amount {
-				return ErrInsufficientCredits
-			}
-			account.BasicCredits -= req.Amount
-		} else {
-			if account.PremiumCredits < req.Amount {
-				return ErrInsufficientCredits
-			}
-			account.PremiumCredits -= req.Amount
-		}
-
-		return tx.Save(&account).Error
+	account, _, err := ChangeCredits(c.Request.Context(), h.deps, tenantID, false, func(*models.TenantAccount) ([]CreditEntry, error) {
+		return []CreditEntry{{CreditType: req.Type, Type: CreditTxnUsage, Delta: -req.Amount,
+			Reason: req.Reason, Actor: userActor(c), ChatID: req.ChatID}}, nil
 	})
 
-	if err == ErrInsufficientCredits {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient credits"})
+	if errors.Is(err, ErrInsufficientCredits) || errors.Is(err, gorm.ErrRecordNotFound) {
+		i18n.Error(c, http.StatusBadRequest, "insufficient_credits", "insufficient credits")
 		return
 	}
 	if err != nil {
@@ -214,7 +203,101 @@ func (h *Handler) UseCredits(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, h.toResponse(&account))
+	c.JSON(http.StatusOK, h.toResponse(account))
+}
+
+// GetQuota returns the tenant's generation quota for the current period
+func (h *Handler) GetQuota(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	status, err := h.quota.GetStatus(c.Request.Context(), tenantID)
+	if err != nil {
+		h.logger.Error("Failed to get quota", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// GetSpending returns the tenant's AI usage this month against its
+// spending caps
+func (h *Handler) GetSpending(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	status, err := h.spending.GetStatus(c.Request.Context(), tenantID)
+	if err != nil {
+		h.logger.Error("Failed to get spending", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get spending"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// GrantQuota grants extra generations to a tenant for the current period (admin only)
+func (h *Handler) GrantQuota(c *gin.Context) {
+	tenantSchema := c.Param("tenantSchema")
+	if tenantSchema == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant schema is required"})
+		return
+	}
+
+	var req GrantQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	status, err := h.quota.Grant(c.Request.Context(), tenantSchema, req.Amount)
+	if err != nil {
+		h.logger.Error("Failed to grant quota", "tenant_schema", tenantSchema, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant quota"})
+		return
+	}
+
+	h.logger.Info("Admin quota grant", "tenant_schema", tenantSchema, "amount", req.Amount, "reason", req.Reason)
+	c.JSON(http.StatusOK, status)
+}
+
+// SetModerationMode sets or clears a tenant's moderation override (admin only)
+func (h *Handler) SetModerationMode(c *gin.Context) {
+	tenantSchema := c.Param("tenantSchema")
+
+	var req ModerationModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := h.deps.DB.DB.Model(&models.Tenant{}).
+		Where("schema_name = ?", tenantSchema).
+		Update("moderation_mode", req.Mode)
+	if res.Error != nil {
+		h.logger.Error("Failed to set moderation mode", "tenant_schema", tenantSchema, "error", res.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set moderation mode"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "tenant not found"})
+		return
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = h.deps.Config.ModerationMode
+	}
+
+	h.logger.Info("Admin moderation override", "tenant_schema", tenantSchema, "mode", req.Mode)
+	c.JSON(http.StatusOK, gin.H{"tenantSchema": tenantSchema, "override": req.Mode, "mode": mode})
 }
 
 var ErrInsufficientCredits = &AccountError{Message: "insufficient credits"}
@@ -251,7 +334,18 @@ func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager
 	{
 		accountRoutes.GET("", handler.GetAccount)
 		accountRoutes.PUT("", handler.UpdateAccount)
-		accountRoutes.POST("/credits/add", handler.AddCredits)
-		accountRoutes.POST("/credits/use", handler.UseCredits)
+		accountRoutes.POST("/credits/add", deps.Idempotency(), handler.AddCredits)
+		accountRoutes.POST("/credits/use", deps.Idempotency(), handler.UseCredits)
+		accountRoutes.GET("/credits/history", handler.CreditHistory)
+		accountRoutes.GET("/quota", handler.GetQuota)
+		accountRoutes.GET("/spending", handler.GetSpending)
+	}
+
+	// Admin routes authenticated with the server API key
+	adminRoutes := r.Group("/api/v1/admin/tenants")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		adminRoutes.POST("/:tenantSchema/quota/grant", handler.GrantQuota)
+		adminRoutes.PUT("/:tenantSchema/moderation", handler.SetModerationMode)
 	}
 }
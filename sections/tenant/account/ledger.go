@@ -0,0 +1,282 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Credit types
+const (
+	CreditTypeBasic   = "basic"
+	CreditTypePremium = "premium"
+)
+
+// Credit transaction types
+const (
+	CreditTxnOpeningBalance = "opening_balance"
+	CreditTxnGrant          = "grant"
+	CreditTxnUsage          = "usage"
+	CreditTxnAdjustment     = "adjustment"
+	CreditTxnRefund         = "refund"
+)
+
+var knownCreditTxnTypes = []string{CreditTxnOpeningBalance, CreditTxnGrant, CreditTxnUsage, CreditTxnAdjustment, CreditTxnRefund}
+
+const (
+	DefaultCreditHistoryPerPage = 50
+	MaxCreditHistoryPerPage     = 200
+)
+
+// CreditEntry is a change to one credit balance, recorded as a transaction
+type CreditEntry struct {
+	CreditType string
+	Type       string
+	Delta      int
+	Reason     string
+	Actor      string
+	PaymentID  *uint
+	ChatID     string
+}
+
+// ChangeCredits locks the tenant's account row and applies the entries that
+// change returns for it, inserting one transaction per entry, all in one
+// database transaction. Entries that would leave a balance negative fail the
+// whole change with ErrInsufficientCredits. With create, a missing account is
+// created on the free plan; otherwise gorm.ErrRecordNotFound is returned.
+// change may also modify other account fields, which are saved with the
+// balances.
+func ChangeCredits(ctx context.Context, deps *sections.Dependencies, tenantSchema string, create bool,
+	change func(account *models.TenantAccount) ([]CreditEntry, error)) (*models.TenantAccount, []models.CreditTransaction, error) {
+	var account models.TenantAccount
+	var txns []models.CreditTransaction
+
+	err := deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			account = models.TenantAccount{}
+			txns = nil
+
+			// Concurrent first changes both insert; the loser's insert is
+			// a no-op and it waits on the winner's row lock below
+			if create {
+				missing := models.TenantAccount{TenantSchema: tenantSchema, SubscriptionPlan: "free"}
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error; err != nil {
+					return err
+				}
+			}
+
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("tenant_schema = ?", tenantSchema).
+				First(&account).Error
+			if err != nil {
+				return err
+			}
+
+			entries, err := change(&account)
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				if entry.Delta == 0 {
+					continue
+				}
+
+				var balance *int
+				switch entry.CreditType {
+				case CreditTypeBasic:
+					balance = &account.BasicCredits
+				case CreditTypePremium:
+					balance = &account.PremiumCredits
+				default:
+					return fmt.Errorf("unknown credit type %q", entry.CreditType)
+				}
+				if *balance+entry.Delta < 0 {
+					return ErrInsufficientCredits
+				}
+				*balance += entry.Delta
+
+				txns = append(txns, models.CreditTransaction{
+					TenantSchema: tenantSchema,
+					CreditType:   entry.CreditType,
+					Type:         entry.Type,
+					Delta:        entry.Delta,
+					BalanceAfter: *balance,
+					Reason:       entry.Reason,
+					Actor:        entry.Actor,
+					PaymentID:    entry.PaymentID,
+					ChatID:       entry.ChatID,
+				})
+			}
+
+			if len(txns) > 0 {
+				if err := tx.Create(&txns).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Save(&account).Error
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deps.InvalidateDashboard(ctx, tenantSchema)
+	return &account, txns, nil
+}
+
+// HasCredits reports whether the tenant's account holds at least amount
+// credits of creditType. A missing account holds none.
+func HasCredits(ctx context.Context, deps *sections.Dependencies, tenantSchema, creditType string, amount int) (bool, error) {
+	var account models.TenantAccount
+	err := deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).First(&account).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return amount <= 0, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if creditType == CreditTypePremium {
+		return account.PremiumCredits >= amount, nil
+	}
+	return account.BasicCredits >= amount, nil
+}
+
+// ChargeGeneration deducts amount basic credits for a completed chat
+// generation, recorded as usage of the chat
+func ChargeGeneration(ctx context.Context, deps *sections.Dependencies, tenantSchema string, userID uint, chatID string, amount int) error {
+	_, _, err := ChangeCredits(ctx, deps, tenantSchema, false, func(*models.TenantAccount) ([]CreditEntry, error) {
+		return []CreditEntry{{CreditType: CreditTypeBasic, Type: CreditTxnUsage, Delta: -amount,
+			Reason: "Chat generation", Actor: fmt.Sprintf("user:%d", userID), ChatID: chatID}}, nil
+	})
+	return err
+}
+
+// CreditHistory lists the tenant's credit transactions, newest first.
+// Filters: type, creditType, chatId, and from and to (RFC3339 or YYYY-MM-DD,
+// to is inclusive).
+func (h *Handler) CreditHistory(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultCreditHistoryPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxCreditHistoryPerPage {
+			perPage = parsed
+		}
+	}
+
+	var conditions []func(*gorm.DB) *gorm.DB
+
+	for _, param := range []string{"from", "to"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, dateOnly, err := parseHistoryTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ", expected RFC3339 or YYYY-MM-DD"})
+			return
+		}
+		switch {
+		case param == "from":
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at >= ?", t) })
+		case dateOnly:
+			t = t.AddDate(0, 0, 1)
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at < ?", t) })
+		default:
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at <= ?", t) })
+		}
+	}
+
+	if txnType := c.Query("type"); txnType != "" {
+		if !slices.Contains(knownCreditTxnTypes, txnType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown transaction type", "known": knownCreditTxnTypes})
+			return
+		}
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("type = ?", txnType) })
+	}
+	if creditType := c.Query("creditType"); creditType != "" {
+		if creditType != CreditTypeBasic && creditType != CreditTypePremium {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "creditType must be basic or premium"})
+			return
+		}
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("credit_type = ?", creditType) })
+	}
+	if chatID := c.Query("chatId"); chatID != "" {
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("chat_id = ?", chatID) })
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("tenant_schema = ?", tenantID)
+		for _, condition := range conditions {
+			db = condition(db)
+		}
+		return db
+	}
+
+	var total int64
+	txns := []models.CreditTransaction{}
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		if err := tx.Model(&models.CreditTransaction{}).Scopes(filter).Count(&total).Error; err != nil {
+			return err
+		}
+		return tx.Scopes(filter).
+			Order("created_at DESC, id DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&txns).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list credit transactions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list credit history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transactions": txns,
+		"page":         page,
+		"perPage":      perPage,
+		"total":        total,
+	})
+}
+
+// parseHistoryTime parses an RFC3339 time or a UTC date, reporting which
+func parseHistoryTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
+
+// userActor names the authenticated user for the ledger, as user:<id>
+func userActor(c *gin.Context) string {
+	if userID, ok := auth.GetUserIDFromContext(c); ok {
+		return fmt.Sprintf("user:%d", userID)
+	}
+	return ""
+}
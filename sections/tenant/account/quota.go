@@ -0,0 +1,211 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// quotaKeyGrace keeps counters around for a while after the period ends
+const quotaKeyGrace = 7 * 24 * time.Hour
+
+var ErrQuotaExceeded = errors.New("generation quota exceeded")
+
+// QuotaStatus describes a tenant's generation quota for the current billing period
+type QuotaStatus struct {
+	TenantSchema string    `json:"tenantSchema"`
+	PlanID       string    `json:"planId"`
+	Paid         bool      `json:"paid"`
+	Unlimited    bool      `json:"unlimited"`
+	Limit        int64     `json:"limit"`
+	Granted      int64     `json:"granted"`
+	Used         int64     `json:"used"`
+	InProgress   int64     `json:"inProgress"`
+	Remaining    int64     `json:"remaining"`
+	PeriodStart  time.Time `json:"periodStart"`
+	ResetsAt     time.Time `json:"resetsAt"`
+
+	period string
+}
+
+// QuotaReservation holds a slot taken by ReserveGeneration until the
+// generation finishes. Exactly one of Commit or Release should be called.
+type QuotaReservation struct {
+	svc    *QuotaService
+	status *QuotaStatus
+	id     string
+	done   bool
+}
+
+// QuotaService tracks per-tenant generation counts in Redis
+type QuotaService struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(deps *sections.Dependencies) *QuotaService {
+	return &QuotaService{
+		logger: slog.With("service", "QuotaService"),
+		deps:   deps,
+	}
+}
+
+// resolvePeriod determines the plan limit and billing period for the tenant.
+// Tenants with an active subscription use the subscription period; everyone
+// else gets the free allowance on calendar months (UTC).
+func (s *QuotaService) resolvePeriod(ctx context.Context, tenantSchema string) (*QuotaStatus, error) {
+	now := time.Now().UTC()
+	status := &QuotaStatus{TenantSchema: tenantSchema}
+
+	var sub models.Subscription
+	err := s.deps.DB.DB.WithContext(ctx).
+		Where("tenant_schema = ? AND status IN ?", tenantSchema, []string{"active", "trialing"}).
+		Where("current_period_end > ?", now).
+		Order("current_period_end DESC").
+		First(&sub).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to look up subscription: %w", err)
+	}
+
+	if err == nil {
+		status.Paid = true
+		status.PeriodStart = sub.CurrentPeriodStart.UTC()
+		status.ResetsAt = sub.CurrentPeriodEnd.UTC()
+
+		plan := s.findPlanByPrice(sub.StripePriceID)
+		if plan == nil {
+			s.logger.Warn("No plan matches subscription price, treating quota as unlimited", "tenant_schema", tenantSchema, "price_id", sub.StripePriceID)
+			status.PlanID = sub.PlanName
+		} else {
+			status.PlanID = plan.ID
+			status.Limit = int64(plan.GenerationsPerMonth)
+		}
+	} else {
+		status.PlanID = "free"
+		status.PeriodStart = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		status.ResetsAt = status.PeriodStart.AddDate(0, 1, 0)
+		status.Limit = int64(s.deps.Config.FreeGenerationsPerMonth)
+	}
+
+	status.Unlimited = status.Limit <= 0
+	status.period = status.PeriodStart.Format("20060102")
+	return status, nil
+}
+
+func (s *QuotaService) findPlanByPrice(priceID string) *common.Plan {
+	for _, plan := range s.deps.Plans {
+		if plan.PriceId != "" && plan.PriceId == priceID {
+			return &plan
+		}
+	}
+	return nil
+}
+
+func (s *QuotaService) keyTTL(status *QuotaStatus) time.Duration {
+	return time.Until(status.ResetsAt) + quotaKeyGrace
+}
+
+func (s *QuotaService) fillCounters(ctx context.Context, status *QuotaStatus) error {
+	counters, err := s.deps.Redis.GetQuotaCounters(ctx, status.TenantSchema, status.period)
+	if err != nil {
+		return err
+	}
+
+	status.Used = counters.Used
+	status.InProgress = counters.Inflight
+	status.Granted = counters.Granted
+	if !status.Unlimited {
+		status.Remaining = max(status.Limit+status.Granted-status.Used-status.InProgress, 0)
+	}
+	return nil
+}
+
+// GetStatus returns the tenant's current quota usage
+func (s *QuotaService) GetStatus(ctx context.Context, tenantSchema string) (*QuotaStatus, error) {
+	status, err := s.resolvePeriod(ctx, tenantSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.fillCounters(ctx, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ReserveGeneration takes a quota slot for a generation. It returns
+// ErrQuotaExceeded together with the current status when no slot is left.
+func (s *QuotaService) ReserveGeneration(ctx context.Context, tenantSchema string) (*QuotaReservation, *QuotaStatus, error) {
+	status, err := s.resolvePeriod(ctx, tenantSchema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Unlimited plans still go through the counters so usage is reported
+	limit := status.Limit
+	if status.Unlimited {
+		limit = math.MaxInt32
+	}
+
+	id, err := s.deps.Redis.ReserveQuota(ctx, tenantSchema, status.period, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.fillCounters(ctx, status); err != nil {
+		s.logger.Warn("Failed to read quota counters", "tenant_schema", tenantSchema, "error", err)
+	}
+
+	if id == "" {
+		return nil, status, ErrQuotaExceeded
+	}
+
+	return &QuotaReservation{svc: s, status: status, id: id}, status, nil
+}
+
+// Grant adds extra generations to the tenant's current period
+func (s *QuotaService) Grant(ctx context.Context, tenantSchema string, amount int64) (*QuotaStatus, error) {
+	status, err := s.resolvePeriod(ctx, tenantSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.deps.Redis.GrantQuota(ctx, tenantSchema, status.period, amount, s.keyTTL(status)); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Granted extra generations", "tenant_schema", tenantSchema, "amount", amount, "period", status.period)
+
+	if err := s.fillCounters(ctx, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Commit marks the reserved generation as used
+func (r *QuotaReservation) Commit(ctx context.Context) error {
+	if r == nil || r.done {
+		return nil
+	}
+	r.done = true
+	return r.svc.deps.Redis.CommitQuota(ctx, r.status.TenantSchema, r.status.period, r.id, r.svc.keyTTL(r.status))
+}
+
+// Release returns the reserved slot without consuming quota
+func (r *QuotaReservation) Release(ctx context.Context) error {
+	if r == nil || r.done {
+		return nil
+	}
+	r.done = true
+	return r.svc.deps.Redis.ReleaseQuota(ctx, r.status.TenantSchema, r.status.period, r.id)
+}
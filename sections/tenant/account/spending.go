@@ -0,0 +1,292 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+)
+
+// spendingKeyGrace keeps counters and alert marks for a while after the
+// month ends
+const spendingKeyGrace = 7 * 24 * time.Hour
+
+// spendingAlertPercents are the shares of a cap tenant admins are notified
+// of reaching, once per month each
+var spendingAlertPercents = []int{80, 100}
+
+var ErrSpendingCapReached = errors.New("monthly spending cap reached")
+
+// SpendingStatus describes a tenant's AI usage against its spending caps
+// for the current calendar month. Caps of 0 aren't enforced.
+type SpendingStatus struct {
+	TenantSchema string    `json:"tenantSchema"`
+	Tokens       int64     `json:"tokens"`
+	MaxTokens    int64     `json:"maxTokens"`
+	CostCents    float64   `json:"costCents"`
+	MaxCostCents int64     `json:"maxCostCents"`
+	PeriodStart  time.Time `json:"periodStart"`
+	ResetsAt     time.Time `json:"resetsAt"`
+
+	period         string
+	costMicrocents int64
+}
+
+// Capped reports whether the tenant has any spending cap
+func (s *SpendingStatus) Capped() bool {
+	return s.MaxTokens > 0 || s.MaxCostCents > 0
+}
+
+// percentUsed returns the share of the nearest cap used, in percent
+func (s *SpendingStatus) percentUsed() int64 {
+	var percent int64
+	if s.MaxTokens > 0 {
+		percent = max(percent, s.Tokens*100/s.MaxTokens)
+	}
+	if s.MaxCostCents > 0 {
+		percent = max(percent, s.costMicrocents/(s.MaxCostCents*10_000))
+	}
+	return percent
+}
+
+// Reached reports whether a cap is used up
+func (s *SpendingStatus) Reached() bool {
+	return s.Capped() && s.percentUsed() >= 100
+}
+
+// SpendingService enforces the max_monthly_tokens and
+// max_monthly_cost_cents settings. Usage is counted in Redis, rebuilt from
+// the tenant's usage records when the counters are missing.
+type SpendingService struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+}
+
+// NewSpendingService creates a new spending service
+func NewSpendingService(deps *sections.Dependencies) *SpendingService {
+	return &SpendingService{
+		logger: slog.With("service", "SpendingService"),
+		deps:   deps,
+	}
+}
+
+// newStatus returns the tenant's caps for the current month, without usage
+func (s *SpendingService) newStatus(ctx context.Context, tenantSchema string) *SpendingStatus {
+	start, end := common.SpendingPeriod(time.Now())
+	status := &SpendingStatus{
+		TenantSchema: tenantSchema,
+		PeriodStart:  start,
+		ResetsAt:     end,
+		period:       start.Format("200601"),
+	}
+	if s.deps.Settings != nil {
+		status.MaxTokens = int64(s.deps.Settings.GetInt(ctx, tenantSchema, settings.MaxMonthlyTokens))
+		status.MaxCostCents = int64(s.deps.Settings.GetInt(ctx, tenantSchema, settings.MaxMonthlyCostCents))
+	}
+	return status
+}
+
+func (s *SpendingService) keyTTL(status *SpendingStatus) time.Duration {
+	return time.Until(status.ResetsAt) + spendingKeyGrace
+}
+
+func (s *SpendingService) setCounters(status *SpendingStatus, counters storage.SpendingCounters) {
+	status.Tokens = counters.Tokens
+	status.costMicrocents = counters.CostMicrocents
+	status.CostCents = float64(counters.CostMicrocents) / 1_000_000
+}
+
+// fillCounters reads the month's usage from Redis, rebuilding the counters
+// from usage records when they are missing
+func (s *SpendingService) fillCounters(ctx context.Context, status *SpendingStatus) error {
+	if s.deps.Redis != nil {
+		counters, ok, err := s.deps.Redis.GetSpending(ctx, status.TenantSchema, status.period)
+		if err != nil {
+			return err
+		}
+		if ok {
+			s.setCounters(status, counters)
+			return nil
+		}
+	}
+
+	counters, err := s.countUsage(ctx, status)
+	if err != nil {
+		return err
+	}
+	if s.deps.Redis != nil {
+		s.logger.Info("Rebuilt spending counters from usage records", "tenant_schema", status.TenantSchema, "period", status.period, "tokens", counters.Tokens)
+		if counters, err = s.deps.Redis.SetSpending(ctx, status.TenantSchema, status.period, counters, s.keyTTL(status)); err != nil {
+			return err
+		}
+	}
+	s.setCounters(status, counters)
+	return nil
+}
+
+// countUsage adds up the tenant's usage records of the month, priced by
+// model
+func (s *SpendingService) countUsage(ctx context.Context, status *SpendingStatus) (storage.SpendingCounters, error) {
+	var rows []struct {
+		Model            string
+		PromptTokens     int64
+		CompletionTokens int64
+	}
+	err := s.deps.DB.WithTenant(ctx, status.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.UsageRecord{}).
+			Select("model, SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens").
+			Where("tenant_schema = ? AND created_at >= ? AND created_at < ?", status.TenantSchema, status.PeriodStart, status.ResetsAt).
+			Group("model").
+			Scan(&rows).Error
+	})
+	if err != nil {
+		return storage.SpendingCounters{}, fmt.Errorf("failed to count usage: %w", err)
+	}
+
+	var counters storage.SpendingCounters
+	for _, row := range rows {
+		counters.Tokens += row.PromptTokens + row.CompletionTokens
+		counters.CostMicrocents += s.deps.Config.CostMicrocents(row.Model, row.PromptTokens, row.CompletionTokens)
+	}
+	return counters, nil
+}
+
+// GetStatus returns the tenant's spending this month and its caps
+func (s *SpendingService) GetStatus(ctx context.Context, tenantSchema string) (*SpendingStatus, error) {
+	status := s.newStatus(ctx, tenantSchema)
+	if err := s.fillCounters(ctx, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Check returns the tenant's spending status, with ErrSpendingCapReached
+// when a cap is used up. The usage of tenants without caps isn't loaded.
+func (s *SpendingService) Check(ctx context.Context, tenantSchema string) (*SpendingStatus, error) {
+	status := s.newStatus(ctx, tenantSchema)
+	if !status.Capped() {
+		return status, nil
+	}
+	if err := s.fillCounters(ctx, status); err != nil {
+		return nil, err
+	}
+	if status.Reached() {
+		return status, ErrSpendingCapReached
+	}
+	return status, nil
+}
+
+// Record counts a generation saved as a usage record towards the tenant's
+// spending, and notifies tenant admins of thresholds it crosses. Failures
+// are only logged.
+func (s *SpendingService) Record(ctx context.Context, tenantSchema, model string, promptTokens, completionTokens int64) {
+	status := s.newStatus(ctx, tenantSchema)
+
+	// Counters are kept up while they exist, so caps set later find them
+	// accurate
+	var counted bool
+	if s.deps.Redis != nil {
+		cost := s.deps.Config.CostMicrocents(model, promptTokens, completionTokens)
+		counters, ok, err := s.deps.Redis.AddSpending(ctx, tenantSchema, status.period, promptTokens+completionTokens, cost)
+		if err != nil {
+			s.logger.Error("Failed to add spending", "tenant_schema", tenantSchema, "error", err)
+			return
+		}
+		if ok {
+			s.setCounters(status, counters)
+			counted = true
+		}
+	}
+	if !status.Capped() {
+		return
+	}
+	if !counted {
+		// The usage record is saved, so rebuilding counts it
+		if err := s.fillCounters(ctx, status); err != nil {
+			s.logger.Error("Failed to count spending", "tenant_schema", tenantSchema, "error", err)
+			return
+		}
+	}
+
+	s.alert(ctx, status)
+}
+
+// alert notifies tenant admins of the highest threshold reached that they
+// haven't been told of this month. Without Redis, alerts aren't sent, as
+// they couldn't be sent only once.
+func (s *SpendingService) alert(ctx context.Context, status *SpendingStatus) {
+	if s.deps.Redis == nil || s.deps.Notifications == nil {
+		return
+	}
+
+	used := status.percentUsed()
+	notify := 0
+	for _, percent := range spendingAlertPercents {
+		if used < int64(percent) {
+			break
+		}
+		first, err := s.deps.Redis.MarkSpendingAlert(ctx, status.TenantSchema, status.period, percent, s.keyTTL(status))
+		if err != nil {
+			s.logger.Error("Failed to mark spending alert", "tenant_schema", status.TenantSchema, "percent", percent, "error", err)
+			continue
+		}
+		if first {
+			notify = percent
+		}
+	}
+	if notify == 0 {
+		return
+	}
+
+	title := fmt.Sprintf("AI usage reached %d%% of the monthly spending cap", notify)
+	body := "Generations will resume when the cap resets or is raised."
+	if notify < 100 {
+		body = "Generations stop once the cap is reached, until it resets or is raised."
+	}
+	body += fmt.Sprintf(" Used this month: %d tokens, %.2f cents. Resets %s.", status.Tokens, status.CostCents, status.ResetsAt.Format(time.RFC3339))
+
+	err := s.deps.Notifications.Notify(ctx, status.TenantSchema, notifications.Notification{
+		Type:  notifications.TypeSpendingCap,
+		Title: title,
+		Body:  body,
+		Metadata: map[string]any{
+			"percent":      notify,
+			"tokens":       status.Tokens,
+			"maxTokens":    status.MaxTokens,
+			"costCents":    status.CostCents,
+			"maxCostCents": status.MaxCostCents,
+			"resetsAt":     status.ResetsAt,
+		},
+		Emails: s.adminEmails(ctx, status.TenantSchema),
+	})
+	if err != nil {
+		s.logger.Error("Failed to send spending alert", "tenant_schema", status.TenantSchema, "percent", notify, "error", err)
+		// Let the next generation try again
+		if err := s.deps.Redis.ClearSpendingAlert(ctx, status.TenantSchema, status.period, notify); err != nil {
+			s.logger.Error("Failed to clear spending alert", "tenant_schema", status.TenantSchema, "percent", notify, "error", err)
+		}
+	}
+}
+
+// adminEmails returns the addresses of the tenant's owners and admins
+func (s *SpendingService) adminEmails(ctx context.Context, tenantSchema string) []string {
+	var emails []string
+	err := s.deps.DB.DB.WithContext(ctx).Model(&models.UserTenant{}).
+		Joins("JOIN public.users ON public.users.id = public.user_tenants.user_id AND public.users.deleted_at IS NULL").
+		Where("public.user_tenants.tenant_schema = ? AND public.user_tenants.role IN ?", tenantSchema, []string{"owner", "admin"}).
+		Pluck("public.users.email", &emails).Error
+	if err != nil {
+		s.logger.Error("Failed to load tenant admins", "tenant_schema", tenantSchema, "error", err)
+	}
+	return emails
+}
@@ -0,0 +1,230 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/jobs"
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+	"awning-backend/services"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// JobKindGenerate runs a chat generation started with POST
+// /api/v1/chat/generate
+const JobKindGenerate = "chat.generate"
+
+// generatePayload is the payload of JobKindGenerate
+type generatePayload struct {
+	JobID        string            `json:"jobId"`
+	TenantSchema string            `json:"tenantSchema"`
+	UserID       uint              `json:"userId"`
+	Request      model.ChatRequest `json:"request"`
+}
+
+// asyncTimeout is the hard limit on an async generation
+func (h *Handler) asyncTimeout() time.Duration {
+	return time.Duration(h.deps.Config.AsyncGenerationTimeoutSeconds) * time.Second
+}
+
+// asyncResultTTL is how long a finished async generation can be polled
+func (h *Handler) asyncResultTTL() time.Duration {
+	return time.Duration(h.deps.Config.AsyncGenerationResultTTLMinutes) * time.Minute
+}
+
+// CreateAsyncGeneration handles POST /api/v1/chat/generate. It queues the
+// generation and returns 202 with the job, to be polled at
+// GET /api/v1/chat/generate/:jobId, for clients that can't hold an event
+// stream open.
+func (h *Handler) CreateAsyncGeneration(c *gin.Context) {
+	if h.deps.Redis == nil || h.deps.Jobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "background jobs unavailable"})
+		return
+	}
+
+	var req model.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+	ctx := c.Request.Context()
+
+	job := &storage.GenerationJob{
+		ID:           uuid.New().String(),
+		TenantSchema: tenantSchema,
+		UserID:       userID,
+		Status:       storage.GenerationQueued,
+		Progress:     storage.GenerationProgress{Stage: storage.GenerationQueued},
+		ChatID:       req.ChatID,
+		CreatedAt:    time.Now().UTC(),
+	}
+	// Queued jobs are kept until they could have run and been polled
+	if err := h.deps.Redis.SaveGenerationJob(ctx, job, h.asyncTimeout()+h.asyncResultTTL()); err != nil {
+		h.logger.Error("Failed to create generation job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start generation"})
+		return
+	}
+
+	// Not retried: a generation that failed part way has already used the
+	// model and may have saved the chat
+	payload := generatePayload{JobID: job.ID, TenantSchema: tenantSchema, UserID: userID, Request: req}
+	if err := h.deps.Jobs.Enqueue(ctx, jobs.New(JobKindGenerate, payload, 0)); err != nil {
+		h.logger.Error("Failed to enqueue generation job", "job_id", job.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start generation"})
+		return
+	}
+
+	h.logger.Info("Async generation queued", "job_id", job.ID, "tenant", tenantSchema, "chat_id", req.ChatID)
+	c.Header("Location", "/api/v1/chat/generate/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetAsyncGeneration handles GET /api/v1/chat/generate/:jobId. The body
+// only changes with the job's status or progress, so clients polling with
+// If-None-Match get 304 in between.
+func (h *Handler) GetAsyncGeneration(c *gin.Context) {
+	if h.deps.Redis == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "background jobs unavailable"})
+		return
+	}
+
+	job, err := h.deps.Redis.GetGenerationJob(c.Request.Context(), c.Param("jobId"))
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	if errors.Is(err, storage.ErrGenerationJobNotFound) || (err == nil && job.TenantSchema != tenantSchema) {
+		c.JSON(http.StatusNotFound, gin.H{"error": storage.ErrGenerationJobNotFound.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load generation job", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load generation"})
+		return
+	}
+
+	if job.Error != nil {
+		job.Error = i18n.Localize(c, job.Error)
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load generation"})
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Header("Cache-Control", "no-cache")
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// HandleGenerateJob is the jobs.Handler for JobKindGenerate. Outcomes,
+// including failures, are written to the job record rather than returned,
+// so the queue never retries a generation.
+func (h *Handler) HandleGenerateJob(ctx context.Context, raw json.RawMessage) error {
+	var payload generatePayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		h.logger.Error("Invalid generation job payload", "error", err)
+		return nil
+	}
+
+	job, err := h.deps.Redis.GetGenerationJob(ctx, payload.JobID)
+	if errors.Is(err, storage.ErrGenerationJobNotFound) {
+		h.logger.Warn("Generation job expired before it ran", "job_id", payload.JobID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch job.Status {
+	case storage.GenerationQueued:
+	case storage.GenerationRunning:
+		// Delivered again after the worker running it went away
+		h.finishGenerationJob(ctx, job, nil, gin.H{"error": "generation was interrupted, please try again", "code": "generation_interrupted"})
+		return nil
+	default:
+		return nil
+	}
+
+	h.updateGenerationJob(ctx, job, storage.GenerationRunning, storage.GenerationProgress{Stage: "preparing"})
+
+	ctx, cancel := context.WithTimeout(ctx, h.asyncTimeout())
+	defer cancel()
+	ctx = common.WithTimings(services.WithTenantSchema(ctx, payload.TenantSchema), common.NewTimings())
+
+	gen, genErr := h.prepareGeneration(ctx, payload.TenantSchema, payload.UserID, payload.Request)
+	if genErr != nil {
+		h.finishGenerationJob(ctx, job, nil, genErr.Body)
+		return nil
+	}
+	defer h.releaseChatLock(context.WithoutCancel(ctx), gen.lock)
+
+	job.ChatID = gen.chatID
+	h.updateGenerationJob(ctx, job, storage.GenerationRunning, storage.GenerationProgress{Stage: "generating"})
+
+	progress := func(name string) {
+		h.updateGenerationJob(ctx, job, storage.GenerationRunning, storage.GenerationProgress{Stage: "postprocessing", Step: name})
+	}
+	response, err := h.runCompletion(context.WithoutCancel(ctx), ctx, gen, progress)
+	if err != nil {
+		h.logger.Error("Async generation failed", "job_id", job.ID, "chat_id", gen.chatID, "error", err)
+		body := gin.H{"error": err.Error(), "code": "generation_failed"}
+		if errors.Is(err, context.DeadlineExceeded) {
+			body = gin.H{"error": "generation did not finish in time", "code": "generation_timeout"}
+		}
+		h.finishGenerationJob(ctx, job, nil, body)
+		return nil
+	}
+
+	h.finishGenerationJob(ctx, job, response, nil)
+	h.logger.Info("Async generation done", "job_id", job.ID, "chat_id", gen.chatID)
+	return nil
+}
+
+// updateGenerationJob records the job's status and progress while it runs
+func (h *Handler) updateGenerationJob(ctx context.Context, job *storage.GenerationJob, status string, progress storage.GenerationProgress) {
+	job.Status = status
+	job.Progress = progress
+	if err := h.deps.Redis.SaveGenerationJob(context.WithoutCancel(ctx), job, h.asyncTimeout()+h.asyncResultTTL()); err != nil {
+		h.logger.Error("Failed to update generation job", "job_id", job.ID, "error", err)
+	}
+}
+
+// finishGenerationJob stores the response of a done job, or the error body
+// of a failed one, for async_generation_result_ttl_minutes
+func (h *Handler) finishGenerationJob(ctx context.Context, job *storage.GenerationJob, response *model.ChatResponse, errBody gin.H) {
+	job.Status = storage.GenerationDone
+	job.Progress = storage.GenerationProgress{Stage: storage.GenerationDone}
+	if errBody != nil {
+		job.Status = storage.GenerationFailed
+		job.Progress = storage.GenerationProgress{Stage: storage.GenerationFailed}
+		job.Error = errBody
+	}
+	if response != nil {
+		result, err := json.Marshal(response)
+		if err != nil {
+			h.logger.Error("Failed to serialize generation result", "job_id", job.ID, "error", err)
+		}
+		job.Result = result
+	}
+	if err := h.deps.Redis.SaveGenerationJob(context.WithoutCancel(ctx), job, h.asyncResultTTL()); err != nil {
+		h.logger.Error("Failed to save generation job result", "job_id", job.ID, "error", err)
+	}
+}
@@ -0,0 +1,254 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/jobs"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// newAsyncRouter serves the async generation routes of a handler with a
+// job queue on an in-process Redis, as user 1 of no tenant, or of tenant
+// other for requests with X-Test-User: other
+func newAsyncRouter(t *testing.T, vertex *fakeVertex) (*Handler, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	redisClient, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Redis = redisClient
+	h.deps.Jobs = jobs.NewQueue(redisClient.Client(), "test:")
+
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}, "other": {"tenant_other", 2}})
+	as := func(c *gin.Context) {
+		if c.GetHeader("X-Test-User") == "other" {
+			asUser("tenant_other", 2)(c)
+			return
+		}
+		asUser("", 1)(c)
+	}
+	r.POST("/generate", as, h.CreateAsyncGeneration)
+	r.GET("/generate/:jobId", as, h.GetAsyncGeneration)
+	return h, r
+}
+
+// startAsync queues a generation and returns its job
+func startAsync(t *testing.T, r *gin.Engine, body string) storage.GenerationJob {
+	t.Helper()
+
+	w := do(r, http.MethodPost, "/generate", "alice", body)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST /generate = %d: %s", w.Code, w.Body)
+	}
+	var job storage.GenerationJob
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != storage.GenerationQueued || w.Header().Get("Location") != "/api/v1/chat/generate/"+job.ID {
+		t.Errorf("POST /generate = %+v at %q, want a queued job and its Location", job, w.Header().Get("Location"))
+	}
+	return job
+}
+
+// runNextJob fetches the queued generation job and runs it
+func runNextJob(t *testing.T, h *Handler) {
+	t.Helper()
+
+	d, err := h.deps.Jobs.Fetch(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if d.Name != JobKindGenerate {
+		t.Fatalf("queued job %q, want %q", d.Name, JobKindGenerate)
+	}
+	if err := h.HandleGenerateJob(context.Background(), d.Payload); err != nil {
+		t.Errorf("HandleGenerateJob() error = %v", err)
+	}
+}
+
+// pollAsync returns the job polled as user, with the response
+func pollAsync(t *testing.T, r *gin.Engine, jobID, as, etag string) (storage.GenerationJob, *httptest.ResponseRecorder) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/generate/"+jobID, nil)
+	req.Header.Set("X-Test-User", as)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var job storage.GenerationJob
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return job, w
+}
+
+func TestAsyncGenerationLifecycle(t *testing.T) {
+	h, r := newAsyncRouter(t, &fakeVertex{reply: testPage, delay: 300 * time.Millisecond})
+	queued := startAsync(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+
+	polled, w := pollAsync(t, r, queued.ID, "alice", "")
+	if polled.Status != storage.GenerationQueued {
+		t.Fatalf("job before it ran = %+v", polled)
+	}
+	queuedTag := w.Header().Get("ETag")
+	if queuedTag == "" {
+		t.Fatal("poll response has no ETag")
+	}
+	if _, w := pollAsync(t, r, queued.ID, "alice", queuedTag); w.Code != http.StatusNotModified {
+		t.Errorf("poll with an unchanged ETag = %d, want 304", w.Code)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runNextJob(t, h)
+	}()
+
+	// The fake model is slow enough to see the job run
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		polled, _ = pollAsync(t, r, queued.ID, "alice", "")
+		if polled.Status == storage.GenerationRunning && polled.Progress.Stage == "generating" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never seen generating, last %+v", polled)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if polled.ChatID == "" {
+		t.Error("running job has no chat ID")
+	}
+	<-done
+
+	polled, w = pollAsync(t, r, queued.ID, "alice", queuedTag)
+	if w.Code != http.StatusOK || polled.Status != storage.GenerationDone || polled.Progress.Stage != storage.GenerationDone || polled.Error != nil {
+		t.Fatalf("finished job = %d %+v, want done", w.Code, polled)
+	}
+	var result struct {
+		ChatID  string `json:"chat_id"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(polled.Result, &result); err != nil || !strings.Contains(result.Message.Content, "<h1>Hello</h1>") || result.ChatID != polled.ChatID {
+		t.Errorf("job result = %s, want the generated page of chat %s", polled.Result, polled.ChatID)
+	}
+
+	// The chat was saved like a synchronous generation's
+	if w := do(r, http.MethodGet, "/chat/"+polled.ChatID, "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("GET chat = %d, want the generated chat", w.Code)
+	}
+
+	// Jobs are scoped to their tenant
+	if _, w := pollAsync(t, r, queued.ID, "other", ""); w.Code != http.StatusNotFound {
+		t.Errorf("poll from another tenant = %d, want 404", w.Code)
+	}
+	if _, w := pollAsync(t, r, "missing", "alice", ""); w.Code != http.StatusNotFound {
+		t.Errorf("poll of an unknown job = %d, want 404", w.Code)
+	}
+}
+
+func TestAsyncGenerationFailure(t *testing.T) {
+	h, r := newAsyncRouter(t, &fakeVertex{err: errors.New("model unavailable")})
+	queued := startAsync(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	runNextJob(t, h)
+
+	polled, _ := pollAsync(t, r, queued.ID, "alice", "")
+	if polled.Status != storage.GenerationFailed || polled.Progress.Stage != storage.GenerationFailed || polled.Result != nil {
+		t.Fatalf("job = %+v, want failed without a result", polled)
+	}
+	if polled.Error["code"] != "generation_failed" || !strings.Contains(polled.Error["error"].(string), "model unavailable") {
+		t.Errorf("job error = %v, want the generation_failed body", polled.Error)
+	}
+}
+
+func TestAsyncGenerationTimeout(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage, delay: 5 * time.Second}
+	h, r := newAsyncRouter(t, vertex)
+	h.deps.Config.AsyncGenerationTimeoutSeconds = 1
+	queued := startAsync(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	runNextJob(t, h)
+
+	polled, _ := pollAsync(t, r, queued.ID, "alice", "")
+	if polled.Status != storage.GenerationFailed || polled.Error["code"] != "generation_timeout" {
+		t.Errorf("job = %+v, want failed with generation_timeout", polled)
+	}
+}
+
+func TestAsyncGenerationRejected(t *testing.T) {
+	h, r := newAsyncRouter(t, &fakeVertex{reply: testPage})
+
+	// Errors prepareGeneration returns are stored as they would be sent
+	queued := startAsync(t, r, `{"message": {"role": "user", "content": "Hi"}, "language": "not a locale"}`)
+	runNextJob(t, h)
+	polled, _ := pollAsync(t, r, queued.ID, "alice", "")
+	if polled.Status != storage.GenerationFailed || polled.Error["code"] != "invalid_language" {
+		t.Errorf("job of an invalid request = %+v, want failed with its error body", polled)
+	}
+
+	if w := do(r, http.MethodPost, "/generate", "alice", `{"message": `); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /generate with invalid JSON = %d, want 400", w.Code)
+	}
+}
+
+func TestAsyncGenerationRedelivered(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage}
+	h, r := newAsyncRouter(t, vertex)
+	queued := startAsync(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+
+	// A worker marked it running and went away
+	job, err := h.deps.Redis.GetGenerationJob(context.Background(), queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Status = storage.GenerationRunning
+	if err := h.deps.Redis.SaveGenerationJob(context.Background(), job, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	runNextJob(t, h)
+
+	polled, _ := pollAsync(t, r, queued.ID, "alice", "")
+	if polled.Status != storage.GenerationFailed || polled.Error["code"] != "generation_interrupted" {
+		t.Errorf("redelivered job = %+v, want failed with generation_interrupted", polled)
+	}
+	if vertex.calls.Load() != 0 {
+		t.Errorf("model called %d times for a redelivered job, want none", vertex.calls.Load())
+	}
+}
+
+func TestAsyncGenerationUnavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := gin.New()
+	r.POST("/generate", asUser("", 1), h.CreateAsyncGeneration)
+	r.GET("/generate/:jobId", asUser("", 1), h.GetAsyncGeneration)
+
+	if w := do(r, http.MethodPost, "/generate", "", `{"message": {"role": "user", "content": "Hi"}}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /generate without Redis = %d, want 503", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/generate/abc", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /generate without Redis = %d, want 503", w.Code)
+	}
+}
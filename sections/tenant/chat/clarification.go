@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of requests on a chat paused for clarification
+const (
+	ErrCodeClarificationPending = "clarification_pending"
+	ErrCodeNoClarification      = "no_clarification_pending"
+)
+
+// clarificationEnabled reports whether the generation asks for missing
+// facts first: the request's clarify when set, else clarification_enabled.
+// Mock responses, targeted edits and resumed generations never do.
+func (h *Handler) clarificationEnabled(gen *generation) bool {
+	if h.deps.Config.MockResponse || gen.edit != nil || gen.clarification != nil {
+		return false
+	}
+	if gen.req.Clarify != nil {
+		return *gen.req.Clarify
+	}
+	return h.deps.Config.ClarificationEnabled
+}
+
+// clarify asks the model which facts it is missing and, when it names any,
+// pauses the generation on them. It returns the response for the client
+// when the generation paused, and nil to go on generating. Failures are
+// logged and go on without questions.
+func (h *Handler) clarify(ctx, requestCtx context.Context, gen *generation) *model.ChatResponse {
+	if !h.clarificationEnabled(gen) {
+		return nil
+	}
+
+	stop := gen.timings.Start("clarification")
+	questions := h.askClarification(requestCtx, gen)
+	stop()
+	if len(questions) == 0 {
+		return nil
+	}
+	return h.pauseForClarification(ctx, gen, questions)
+}
+
+// askClarification sends the clarification prompt and returns the
+// model's validated questions
+func (h *Handler) askClarification(ctx context.Context, gen *generation) []model.ClarificationQuestion {
+	var onboardingData *model.OnboardingData
+	if gen.req.Message.Context != nil {
+		onboardingData = gen.req.Message.Context.OnboardingData
+	}
+	prompt := utils.BuildClarificationPrompt(onboardingData, gen.chat.Variables, gen.req.Message.Content, h.deps.Config.ClarificationMaxQuestions)
+
+	modelName := h.generationModel(false)
+	promptTokens, err := utils.CountTokens(prompt)
+	if err != nil {
+		slog.Error("Failed to count clarification tokens", "chat_id", gen.chatID, "error", err)
+		return nil
+	}
+	params, _ := h.deps.Config.ResolveGenerationParams(modelName, nil)
+	if err := h.deps.Config.FitOutputBudget(modelName, promptTokens, &params); err != nil {
+		slog.Warn("Request too long for clarification", "chat_id", gen.chatID, "prompt_tokens", promptTokens, "error", err)
+		return nil
+	}
+
+	reply, err := h.generateContent(ctx, prompt, params)
+	if err != nil {
+		slog.Error("Clarification request failed, generating without it", "chat_id", gen.chatID, "error", err)
+		return nil
+	}
+	gen.addReplyUsage(promptTokens, reply)
+
+	questions, err := parseClarification(reply, h.deps.Config.ClarificationMaxQuestions)
+	if err != nil {
+		slog.Warn("Rejected clarification reply, generating without it", "chat_id", gen.chatID, "error", err)
+		return nil
+	}
+	slog.Info("Clarification questions", "chat_id", gen.chatID, "questions", len(questions))
+	return questions
+}
+
+// parseClarification decodes and validates a clarification reply
+func parseClarification(reply string, max int) ([]model.ClarificationQuestion, error) {
+	var parsed struct {
+		Questions []model.ClarificationQuestion `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(utils.ExtractJSONObject(reply)), &parsed); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return model.ValidateClarificationQuestions(parsed.Questions, max)
+}
+
+// pauseForClarification stores the questions on the chat with the request's
+// message and returns the quota reserved for the generation, which runs
+// when the chat is resumed
+func (h *Handler) pauseForClarification(ctx context.Context, gen *generation, questions []model.ClarificationQuestion) *model.ChatResponse {
+	now := time.Now()
+	clarification := &model.ChatClarification{
+		Questions: questions,
+		MessageID: gen.req.Message.ID,
+		AskedAt:   now.Unix(),
+		ExpiresAt: now.Add(time.Duration(h.deps.Config.ClarificationTimeoutSeconds) * time.Second).Unix(),
+	}
+	gen.chat.Clarification = clarification
+	gen.chat.ChatStage = model.ChatStageClarification
+
+	h.releaseQuota(ctx, gen)
+	countGeneration(gen, outcomeClarification)
+	if err := h.saveGeneration(ctx, gen); err != nil {
+		slog.Error("Failed to save chat paused for clarification", "chat_id", gen.chatID, "error", err)
+	}
+
+	slog.Info("Generation paused for clarification", "chat_id", gen.chatID, "questions", len(questions),
+		"prompt_tokens", gen.usage.PromptTokens, "completion_tokens", gen.usage.CompletionTokens)
+	return &model.ChatResponse{
+		ChatID:        gen.chatID,
+		ChatStage:     model.ChatStageClarification,
+		Timestamp:     now.Unix(),
+		Clarification: clarification,
+	}
+}
+
+// clarificationEvent is the data of the clarification stream event
+func clarificationEvent(response *model.ChatResponse) string {
+	data, _ := json.Marshal(gin.H{
+		"type":          "clarification",
+		"chat_id":       response.ChatID,
+		"chat_stage":    response.ChatStage,
+		"clarification": response.Clarification,
+	})
+	return string(data)
+}
+
+// resolveClarification resumes a chat paused for clarification with the
+// answers in the request's variables. Questions left unanswered are
+// assumed, which needs at least one answer, skip_clarification or the
+// questions to have expired. It returns the answers and the questions that
+// were assumed.
+func resolveClarification(pending *model.ChatClarification, req model.ChatRequest, now time.Time) (map[string]string, []model.ClarificationQuestion, *generationError) {
+	answers := map[string]string{}
+	var assumed []model.ClarificationQuestion
+	for _, q := range pending.Questions {
+		if answer := strings.TrimSpace(req.Variables[q.Key]); answer != "" {
+			answers[q.Key] = answer
+		} else {
+			assumed = append(assumed, q)
+		}
+	}
+
+	if len(answers) == 0 && !req.SkipClarification && !pending.Expired(now) {
+		return nil, nil, &generationError{Status: http.StatusConflict, Body: gin.H{
+			"error":         "the chat is waiting for answers to its questions; answer them in variables or set skip_clarification",
+			"code":          ErrCodeClarificationPending,
+			"clarification": pending,
+		}}
+	}
+	return answers, assumed, nil
+}
+
+// mergeVariables returns the chat's kept variables overlaid with the
+// request's
+func mergeVariables(kept, requested map[string]string) map[string]string {
+	if len(kept) == 0 && len(requested) == 0 {
+		return nil
+	}
+	merged := maps.Clone(kept)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, requested)
+	return merged
+}
@@ -0,0 +1,243 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const clarificationReply = `{"questions": [
+	{"key": "opening_hours", "question": "When are you open?", "assumption": "Monday to Friday, 9 to 5"},
+	{"key": "delivery", "question": "Do you deliver?", "assumption": "No delivery"}
+]}`
+
+// newClarifyingHandler returns a handler with clarification on whose model
+// asks clarificationReply's questions, then writes testPage
+func newClarifyingHandler(t *testing.T) (*Handler, *scriptedVertex) {
+	t.Helper()
+
+	vertex := &scriptedVertex{replies: []string{clarificationReply, testPage}}
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Config.ClarificationEnabled = true
+	h.deps.Config.ChatDoneInlineContent = true
+	return h, vertex
+}
+
+// pauseChat sends a first message and returns the ID of the chat it paused
+func pauseChat(t *testing.T, h *Handler) string {
+	t.Helper()
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if response.ChatStage != model.ChatStageClarification || response.Clarification == nil || len(response.Clarification.Questions) != 2 {
+		t.Fatalf("response = %s, want the chat paused on two questions", w.Body)
+	}
+	return response.ChatID
+}
+
+func TestClarificationPauseAndResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, vertex := newClarifyingHandler(t)
+	ctx := context.Background()
+
+	chatID := pauseChat(t, h)
+	chat, err := h.deps.Chats.GetChat(ctx, chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.ChatStage != model.ChatStageClarification || chat.Clarification == nil || len(chat.Messages) != 1 {
+		t.Fatalf("paused chat = %+v, want the question set and the request alone", chat)
+	}
+	if prompts := vertex.sent(); len(prompts) != 1 || !strings.Contains(prompts[0], "list the facts you need") {
+		t.Fatalf("prompts = %q, want the clarification prompt alone", prompts)
+	}
+
+	// Resuming without answers is refused until the questions expire
+	w := postCompletion(h, `{"chat_id": "`+chatID+`"}`)
+	if w.Code != http.StatusConflict || errorCode(t, w) != ErrCodeClarificationPending {
+		t.Fatalf("resume without answers = %d: %s, want 409 %s", w.Code, w.Body, ErrCodeClarificationPending)
+	}
+
+	// Answering one question generates with it and assumes the other. The
+	// stream resumes without a message, for the paused one.
+	event := streamDone(t, newContentRouter(h), `{"chat_id": "`+chatID+`", "variables": {"opening_hours": "7am to 3pm"}}`)
+	var assumptions []model.ClarificationQuestion
+	json.Unmarshal(event["assumptions"], &assumptions)
+	if len(assumptions) != 1 || assumptions[0].Key != "delivery" {
+		t.Errorf("done event assumptions = %s, want delivery", event["assumptions"])
+	}
+	var response struct {
+		Message model.ChatMessage `json:"message"`
+	}
+	json.Unmarshal(event["response"], &response)
+	if !strings.Contains(response.Message.Content, "<h1>Hello</h1>") {
+		t.Errorf("done event page = %q, want the generated page", response.Message.Content)
+	}
+	if prompts := vertex.sent(); len(prompts) != 2 || !strings.Contains(prompts[1], "A page for my bakery") {
+		t.Errorf("prompts = %q, want the paused request generated", prompts)
+	}
+
+	chat, err = h.deps.Chats.GetChat(ctx, chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.Clarification != nil || chat.ChatStage == model.ChatStageClarification || chat.Variables["opening_hours"] != "7am to 3pm" {
+		t.Errorf("resumed chat stage = %s, clarification %+v, variables %v", chat.ChatStage, chat.Clarification, chat.Variables)
+	}
+	if len(chat.Messages) != 2 || chat.Messages[0].Content != "A page for my bakery" || chat.Messages[1].Role != model.ChatMessageRoleAssistant {
+		t.Errorf("messages = %+v, want the request once and the page", chat.Messages)
+	}
+
+	// A chat that isn't paused has nothing to resume
+	w = postCompletion(h, `{"chat_id": "`+chatID+`"}`)
+	if w.Code != http.StatusConflict || errorCode(t, w) != ErrCodeNoClarification {
+		t.Errorf("resume of a generated chat = %d: %s, want 409 %s", w.Code, w.Body, ErrCodeNoClarification)
+	}
+}
+
+func TestClarificationPausedStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, vertex := newClarifyingHandler(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(`{"message": {"role": "user", "content": "A page for my bakery"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newContentRouter(h).ServeHTTP(w, req)
+
+	var event struct {
+		ChatStage     model.ChatStage          `json:"chat_stage"`
+		Clarification *model.ChatClarification `json:"clarification"`
+	}
+	for _, frame := range strings.Split(w.Body.String(), "\n\n") {
+		if data, ok := strings.CutPrefix(frame, "event: clarification\ndata: "); ok {
+			json.Unmarshal([]byte(data), &event)
+		}
+		if strings.HasPrefix(frame, "event: done") {
+			t.Errorf("paused stream sent a done event: %s", frame)
+		}
+	}
+	if event.ChatStage != model.ChatStageClarification || event.Clarification == nil || event.Clarification.Questions[0].Key != "opening_hours" {
+		t.Errorf("clarification event = %+v in %s", event, w.Body)
+	}
+	if n := len(vertex.sent()); n != 1 {
+		t.Errorf("model called %d times, want the clarification prompt alone", n)
+	}
+}
+
+func TestClarificationSkip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		timeout int
+		body    string
+	}{
+		{"skipped", 3600, `"skip_clarification": true`},
+		{"timed out", 0, `"variables": {}`},
+	}
+	for _, tt := range tests {
+		h, vertex := newClarifyingHandler(t)
+		h.deps.Config.ClarificationTimeoutSeconds = tt.timeout
+		chatID := pauseChat(t, h)
+
+		w := postCompletion(h, `{"chat_id": "`+chatID+`", `+tt.body+`}`)
+		var response model.ChatResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: resume status = %d: %s", tt.name, w.Code, w.Body)
+		}
+		if len(response.Assumptions) != 2 || response.Assumptions[1].Assumption != "No delivery" {
+			t.Errorf("%s: assumptions = %+v, want both questions assumed", tt.name, response.Assumptions)
+		}
+		if !strings.Contains(response.Message.Content, "<h1>Hello</h1>") {
+			t.Errorf("%s: response = %s, want the generated page", tt.name, w.Body)
+		}
+		if n := len(vertex.sent()); n != 2 {
+			t.Errorf("%s: model called %d times, want clarification then generation", tt.name, n)
+		}
+	}
+}
+
+func TestClarificationNotAsked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// No questions, an unreadable reply or clarify off all generate straight away
+	tests := []struct {
+		name    string
+		replies []string
+		body    string
+		prompts int
+	}{
+		{"no questions", []string{`{"questions": []}`, testPage}, `{"message": {"role": "user", "content": "A page for my bakery"}}`, 2},
+		{"invalid reply", []string{"I need more details", testPage}, `{"message": {"role": "user", "content": "A page for my bakery"}}`, 2},
+		{"clarify off", []string{testPage}, `{"message": {"role": "user", "content": "A page for my bakery"}, "clarify": false}`, 1},
+	}
+	for _, tt := range tests {
+		vertex := &scriptedVertex{replies: tt.replies}
+		h, _ := newTestHandler(t, vertex)
+		h.deps.Config.ClarificationEnabled = true
+
+		w := postCompletion(h, tt.body)
+		var response model.ChatResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		if w.Code != http.StatusOK || response.ChatStage == model.ChatStageClarification || response.Message.Content == "" {
+			t.Errorf("%s: response = %d: %s, want the page generated", tt.name, w.Code, w.Body)
+		}
+		if n := len(vertex.sent()); n != tt.prompts {
+			t.Errorf("%s: model called %d times, want %d", tt.name, n, tt.prompts)
+		}
+	}
+}
+
+func TestResolveClarification(t *testing.T) {
+	now := time.Unix(1000, 0)
+	pending := &model.ChatClarification{
+		Questions: []model.ClarificationQuestion{{Key: "opening_hours"}, {Key: "delivery"}},
+		ExpiresAt: 2000,
+	}
+
+	tests := []struct {
+		name      string
+		req       model.ChatRequest
+		now       time.Time
+		answered  int
+		assumed   int
+		wantError bool
+	}{
+		{"all answered", model.ChatRequest{Variables: map[string]string{"opening_hours": "9-5", "delivery": "yes"}}, now, 2, 0, false},
+		{"one answered", model.ChatRequest{Variables: map[string]string{"delivery": " yes "}}, now, 1, 1, false},
+		{"blank answers", model.ChatRequest{Variables: map[string]string{"delivery": "  "}}, now, 0, 0, true},
+		{"unrelated variables", model.ChatRequest{Variables: map[string]string{"color": "blue"}}, now, 0, 0, true},
+		{"skipped", model.ChatRequest{SkipClarification: true}, now, 0, 2, false},
+		{"expired", model.ChatRequest{}, time.Unix(2000, 0), 0, 2, false},
+	}
+	for _, tt := range tests {
+		answers, assumed, genErr := resolveClarification(pending, tt.req, tt.now)
+		if (genErr != nil) != tt.wantError {
+			t.Errorf("%s: resolveClarification() error = %+v, want error %v", tt.name, genErr, tt.wantError)
+			continue
+		}
+		if genErr != nil {
+			if genErr.Status != http.StatusConflict || genErr.Body["code"] != ErrCodeClarificationPending {
+				t.Errorf("%s: resolveClarification() error = %+v", tt.name, genErr)
+			}
+			continue
+		}
+		if len(answers) != tt.answered || len(assumed) != tt.assumed {
+			t.Errorf("%s: resolveClarification() = %v answered, %v assumed; want %d and %d", tt.name, answers, assumed, tt.answered, tt.assumed)
+		}
+	}
+	if answers, _, _ := resolveClarification(pending, model.ChatRequest{Variables: map[string]string{"delivery": " yes "}}, now); answers["delivery"] != "yes" {
+		t.Errorf("answer = %q, want it trimmed", answers["delivery"])
+	}
+}
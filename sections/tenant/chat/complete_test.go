@@ -0,0 +1,249 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postCompletion(h *Handler, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/complete", asUser("", 1), h.CreateChatCompletion)
+
+	req := httptest.NewRequest(http.MethodPost, "/complete", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateChatCompletion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if response.ChatID == "" {
+		t.Fatal("response has no chat ID")
+	}
+	chat, err := store.GetChat(context.Background(), response.ChatID)
+	if err != nil {
+		t.Fatalf("chat was not saved: %v", err)
+	}
+	if n := len(chat.Messages); n != 2 {
+		t.Errorf("saved chat has %d messages, want 2", n)
+	}
+}
+
+func TestCreateChatCompletionTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage, delay: 1500 * time.Millisecond}
+	h, store := newTestHandler(t, vertex)
+	h.deps.Config.ChatCompleteTimeoutSeconds = 1
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		ChatID string `json:"chat_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.ChatID == "" {
+		t.Fatalf("504 response has no chat_id: %s", w.Body)
+	}
+
+	// The generation continues after the timeout and saves the chat
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		chat, err := store.GetChat(context.Background(), body.ChatID)
+		if err == nil && len(chat.Messages) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("generation was not saved after the timeout (err = %v)", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCreateChatCompletionFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{err: errors.New("upstream unavailable")}
+	h, _ := newTestHandler(t, vertex)
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "chat_id") {
+		t.Errorf("502 response has no chat_id: %s", w.Body)
+	}
+}
+
+func TestGenerationParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, _ := newTestHandler(t, vertex)
+	limit := h.deps.Config.LimitsFor(h.generationModel(false)).MaxOutputTokens
+	temperature := 0.4
+	h.deps.Config.ModelParams = map[string]common.GenerationParams{
+		h.generationModel(false): {Temperature: &temperature},
+	}
+
+	// Requested values over the model's defaults, with the output tokens capped
+	body := fmt.Sprintf(`{"message": {"role": "user", "content": "A page"}, "generation": {"top_p": 0.5, "max_output_tokens": %d}}`, limit+1000)
+	check := func(name string, got *common.GenerationParams) {
+		t.Helper()
+		if got == nil || got.Temperature == nil || *got.Temperature != 0.4 || got.TopP == nil || *got.TopP != 0.5 ||
+			got.MaxOutputTokens == nil || *got.MaxOutputTokens > limit {
+			t.Errorf("%s generation = %+v, want temperature 0.4, top_p 0.5 and at most %d tokens", name, got, limit)
+		}
+	}
+
+	w := postCompletion(h, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	check("sent", vertex.params.Load())
+	check("echoed", response.Generation)
+
+	// The stream echoes them in its done event
+	event := streamDone(t, newContentRouter(h), body)
+	var streamed model.ChatResponse
+	if err := json.Unmarshal(event["response"], &streamed); err != nil {
+		t.Fatalf("invalid done response: %v", err)
+	}
+	check("streamed", streamed.Generation)
+}
+
+func TestGenerationParamsRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, _ := newTestHandler(t, vertex)
+
+	for _, generation := range []string{`{"temperature": 2.5}`, `{"top_p": 0}`, `{"max_output_tokens": -1}`} {
+		w := postCompletion(h, `{"message": {"role": "user", "content": "A page"}, "generation": `+generation+`}`)
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != "invalid_generation_params" {
+			t.Errorf("generation %s: status = %d: %s, want 400 invalid_generation_params", generation, w.Code, w.Body)
+		}
+	}
+	if n := vertex.calls.Load(); n != 0 {
+		t.Errorf("model called %d times for rejected parameters", n)
+	}
+}
+
+func TestPromptTooLong(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, _ := newTestHandler(t, vertex)
+	model := h.generationModel(false)
+	h.deps.Config.ModelLimits = map[string]common.ModelLimits{model: {ContextWindow: 60, MaxInputTokens: 20}}
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "`+strings.Repeat("a bakery page ", 10)+`"}}`)
+	var body struct {
+		Code           string `json:"code"`
+		PromptTokens   int    `json:"prompt_tokens"`
+		MaxInputTokens int    `json:"max_input_tokens"`
+		ContextWindow  int    `json:"context_window"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if w.Code != http.StatusBadRequest || body.Code != "prompt_too_long" {
+		t.Fatalf("status = %d: %s, want 400 prompt_too_long", w.Code, w.Body)
+	}
+	if body.PromptTokens <= 20 || body.MaxInputTokens != 20 || body.ContextWindow != 60 {
+		t.Errorf("token counts = %+v, want the prompt's count over the model's limits", body)
+	}
+	if n := vertex.calls.Load(); n != 0 {
+		t.Errorf("model called %d times for a prompt over the limit", n)
+	}
+
+	// A prompt that fits gets the rest of the window as its output budget
+	h.deps.Config.ModelLimits[model] = common.ModelLimits{ContextWindow: 1000, MaxInputTokens: 900}
+	w = postCompletion(h, `{"message": {"role": "user", "content": "A bakery page"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if params := vertex.params.Load(); params == nil || params.MaxOutputTokens == nil || *params.MaxOutputTokens >= 1000 || *params.MaxOutputTokens < 900 {
+		t.Errorf("max output tokens sent = %v, want what the prompt leaves of 1000", params)
+	}
+}
+
+// failingChats is a chat store whose saves fail while fail is set
+type failingChats struct {
+	storage.ChatStore
+	fail atomic.Bool
+}
+
+func (f *failingChats) SaveChat(ctx context.Context, chat *model.Chat) error {
+	if f.fail.Load() {
+		return errors.New("redis: connection refused")
+	}
+	return f.ChatStore.SaveChat(ctx, chat)
+}
+
+func TestCompletionSpillsFailedSave(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	chats := &failingChats{ChatStore: store}
+	spillDir, err := storage.NewDirChatSpill(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.deps.ChatSpill = sections.NewSpillingChatStore(chats, spillDir, 10)
+	h.deps.Chats = h.deps.ChatSpill
+
+	chats.fail.Store(true)
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s, want the generation spilled rather than failed", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.GetChat(ctx, response.ChatID); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Fatalf("stored chat error = %v, want it only spilled", err)
+	}
+	chat, err := h.deps.Chats.GetChat(ctx, response.ChatID)
+	if err != nil || len(chat.Messages) != 2 {
+		t.Fatalf("GetChat() of the spilled chat = %+v, %v; want both messages", chat, err)
+	}
+
+	chats.fail.Store(false)
+	h.deps.ChatSpill.RetryDue(ctx)
+	saved, err := store.GetChat(ctx, response.ChatID)
+	if err != nil {
+		t.Fatalf("chat not saved by the retry: %v", err)
+	}
+	if len(saved.Messages) != 2 || saved.Messages[1].Content == "" {
+		t.Errorf("saved messages = %+v, want the request and the page once each", saved.Messages)
+	}
+}
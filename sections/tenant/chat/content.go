@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"awning-backend/common"
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentRef points to generated content left out of the done event or a
+// chat listing. The content is stored with the chat and fetched from URL.
+type ContentRef = model.ContentRef
+
+// GenerationDiagnostics reports problems with the prompt of a generation,
+// sent in the done event
+type GenerationDiagnostics struct {
+	// Placeholders no variable replaced, such as {{businessTypeLabel}}
+	UnresolvedPlaceholders []string `json:"unresolved_placeholders,omitempty"`
+
+	// Processors that failed, panicked or timed out; the page was passed on
+	// as it was before each of them
+	ProcessorFailures []ProcessorFailure `json:"processor_failures,omitempty"`
+}
+
+// ProcessorFailure is one failed processor run of a generation
+type ProcessorFailure struct {
+	Processor string `json:"processor"`
+	Failure   string `json:"failure"`
+	Error     string `json:"error"`
+
+	// Slug of the page it failed on, for multi-page generations
+	Page string `json:"page,omitempty"`
+}
+
+// recordProcessorFailures adds the failed processors of reports to the
+// generation's diagnostics. It is not safe for concurrent use.
+func (gen *generation) recordProcessorFailures(page string, reports []common.ProcessorReport) {
+	for _, report := range reports {
+		if report.Success {
+			continue
+		}
+		if gen.diagnostics == nil {
+			gen.diagnostics = &GenerationDiagnostics{}
+		}
+		gen.diagnostics.ProcessorFailures = append(gen.diagnostics.ProcessorFailures, ProcessorFailure{
+			Processor: report.Name,
+			Failure:   report.Failure,
+			Error:     report.Error,
+			Page:      page,
+		})
+	}
+}
+
+// MessageContent is the content of one chat message
+type MessageContent struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+func contentURL(chatID, messageID string) string {
+	return fmt.Sprintf("/api/v1/chat/%s/content/%s", chatID, messageID)
+}
+
+// withContentPreviews returns a copy of chat whose message contents longer
+// than previewRunes are cut to that many runes, each with a content_ref to
+// the whole content. The stored chat is left as it was.
+func withContentPreviews(chat *model.Chat, previewRunes int) *model.Chat {
+	trimmed := *chat
+	trimmed.Messages = make([]model.ChatMessage, len(chat.Messages))
+	for i, msg := range chat.Messages {
+		if utf8.RuneCountInString(msg.Content) > previewRunes {
+			msg.ContentRef = &ContentRef{
+				MessageID: msg.ID,
+				URL:       contentURL(chat.ID, msg.ID),
+				Size:      len(msg.Content),
+			}
+			msg.Content = string([]rune(msg.Content)[:previewRunes])
+		}
+		trimmed.Messages[i] = msg
+	}
+	return &trimmed
+}
+
+// includesContent reports whether the request asks for whole message
+// contents with ?include=content, a comma-separated list
+func includesContent(c *gin.Context) bool {
+	for _, field := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(field) == "content" {
+			return true
+		}
+	}
+	return false
+}
+
+// doneEvent encodes the done event. Generated pages run to hundreds of KB,
+// so unless chat_done_inline_content is set the message content is replaced
+// by a content_ref the client fetches with a compressed GET. timings carries
+// the generation's phase durations in milliseconds, diagnostics the
+// problems found in its prompt and site the pages of a multi-page
+// generation.
+func (h *Handler) doneEvent(response *model.ChatResponse, gen *generation) []byte {
+	variant := gen.variant
+	phases := gen.timings.Milliseconds()
+	inlineJSON, _ := json.Marshal(map[string]interface{}{
+		"type":              "done",
+		"response":          response,
+		"message_id":        response.Message.ID,
+		"prompt_variant":    variant,
+		"language_mismatch": response.LanguageMismatch,
+		"site_metadata":     response.SiteMetadata,
+		"site":              response.Site,
+		"timings":           phases,
+		"diagnostics":       gen.diagnostics,
+		"assumptions":       response.Assumptions,
+		"section_diff":      response.SectionDiff,
+		"truncated":         response.Truncated,
+		"truncated_reason":  response.TruncatedReason,
+	})
+	if h.deps.Config.ChatDoneInlineContent {
+		h.logger.Info("Sending done event", "bytes", len(inlineJSON))
+		return inlineJSON
+	}
+
+	ref := ContentRef{
+		MessageID: response.Message.ID,
+		URL:       contentURL(response.ChatID, response.Message.ID),
+		Size:      len(response.Message.Content),
+	}
+	withoutContent := *response
+	withoutContent.Message.Content = ""
+
+	doneJSON, _ := json.Marshal(map[string]interface{}{
+		"type":              "done",
+		"response":          withoutContent,
+		"message_id":        response.Message.ID,
+		"content_ref":       ref,
+		"prompt_variant":    variant,
+		"language_mismatch": response.LanguageMismatch,
+		"site_metadata":     response.SiteMetadata,
+		"site":              response.Site,
+		"timings":           phases,
+		"diagnostics":       gen.diagnostics,
+		"assumptions":       response.Assumptions,
+		"section_diff":      response.SectionDiff,
+		"truncated":         response.Truncated,
+		"truncated_reason":  response.TruncatedReason,
+	})
+	h.logger.Info("Sending done event", "bytes", len(doneJSON), "inline_bytes", len(inlineJSON))
+	return doneJSON
+}
+
+// GetMessageContent returns the content of a chat message, as referenced by
+// the done event
+func (h *Handler) GetMessageContent(c *gin.Context) {
+	messageID := c.Param("messageId")
+
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
+		return
+	}
+	chatID := chat.ID
+
+	for _, msg := range chat.Messages {
+		if msg.ID == messageID {
+			c.JSON(http.StatusOK, MessageContent{
+				ChatID:    chatID,
+				MessageID: messageID,
+				Content:   msg.Content,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+}
+
+// GetMessage returns one message of a chat with its whole content, for
+// listings that cut it to a preview
+func (h *Handler) GetMessage(c *gin.Context) {
+	messageID := c.Param("messageId")
+
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
+		return
+	}
+
+	for _, msg := range chat.Messages {
+		if msg.ID == messageID {
+			c.JSON(http.StatusOK, msg)
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+}
@@ -0,0 +1,318 @@
+package chat
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"awning-backend/common"
+	"awning-backend/middleware"
+	"awning-backend/model"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newContentRouter serves the stream, chat and content routes behind the
+// gzip middleware, as the server does
+func newContentRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.GzipMiddleware(100))
+	r.POST("/stream", asUser("", 1), h.CreateChatStream)
+	r.GET("/api/v1/chat/:id", asUser("", 1), h.GetChat)
+	r.GET("/api/v1/chat/:id/content/:messageId", asUser("", 1), h.GetMessageContent)
+	r.GET("/api/v1/chat/:id/messages/:messageId", asUser("", 1), h.GetMessage)
+	return r
+}
+
+// streamDone posts body to the stream and returns the generation's decoded done event
+func streamDone(t *testing.T, r *gin.Engine, body string) map[string]json.RawMessage {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return streamDoneEvent(t, w)
+}
+
+// streamDoneEvent returns the generation's decoded done event from a
+// recorded stream
+func streamDoneEvent(t *testing.T, w *httptest.ResponseRecorder) map[string]json.RawMessage {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("stream status = %d: %s", w.Code, w.Body)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("event stream Content-Encoding = %q, want none", enc)
+	}
+
+	// The model's own done event is passed on before the generation's
+	for _, frame := range strings.Split(w.Body.String(), "\n\n") {
+		if data, ok := strings.CutPrefix(frame, "event: done\ndata: "); ok {
+			var event map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Fatalf("invalid done event: %v", err)
+			}
+			if _, ok := event["response"]; ok {
+				return event
+			}
+		}
+	}
+	t.Fatalf("stream has no done event: %s", w.Body)
+	return nil
+}
+
+func TestDoneEventContentRef(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	page := "<section><h1>Hello</h1>" + strings.Repeat("<p>Fresh bread every morning.</p>", 200) + "</section>"
+	h, _ := newTestHandler(t, &fakeVertex{reply: page})
+	r := newContentRouter(h)
+
+	event := streamDone(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	var ref ContentRef
+	if err := json.Unmarshal(event["content_ref"], &ref); err != nil || ref.URL == "" {
+		t.Fatalf("done event has no content_ref: %s", event["content_ref"])
+	}
+	var response struct {
+		ChatID  string `json:"chat_id"`
+		Message struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	json.Unmarshal(event["response"], &response)
+	if response.Message.Content != "" {
+		t.Errorf("done event inlines %d bytes of content", len(response.Message.Content))
+	}
+	if ref.MessageID != response.Message.ID || ref.URL != contentURL(response.ChatID, response.Message.ID) {
+		t.Errorf("content_ref = %+v, want the response message %s", ref, response.Message.ID)
+	}
+
+	// The referenced content comes back gzipped
+	req := httptest.NewRequest(http.MethodGet, ref.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("content status = %d, Content-Encoding = %q; want gzipped 200", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(gz)
+	var content MessageContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatalf("invalid content response: %v", err)
+	}
+	if content.MessageID != ref.MessageID || len(content.Content) != ref.Size || !strings.Contains(content.Content, "Fresh bread") {
+		t.Errorf("content = %d bytes of message %s, want the %d referenced", len(content.Content), content.MessageID, ref.Size)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, contentURL(response.ChatID, "missing"), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown message status = %d, want 404", w.Code)
+	}
+}
+
+func TestDoneEventInlineContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	h.deps.Config.ChatDoneInlineContent = true
+
+	event := streamDone(t, newContentRouter(h), `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if _, ok := event["content_ref"]; ok {
+		t.Error("done event has a content_ref with inline content kept")
+	}
+	var response struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	json.Unmarshal(event["response"], &response)
+	if !strings.Contains(response.Message.Content, "<h1>Hello</h1>") {
+		t.Errorf("inline content = %q, want the generated page", response.Message.Content)
+	}
+}
+
+func TestDoneEventDiagnostics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := newContentRouter(h)
+	body := `{"message": {"role": "user", "content": "A page for my bakery"}}`
+
+	if event := streamDone(t, r, body); string(event["diagnostics"]) != "null" {
+		t.Errorf("done event diagnostics = %s, want null for a resolved prompt", event["diagnostics"])
+	}
+
+	dir := t.TempDir()
+	base, request := filepath.Join(dir, "base.md"), filepath.Join(dir, "request.md")
+	os.WriteFile(base, []byte("Build a page for a {{businessTypeLabel}} in {{city}}."), 0o644)
+	os.WriteFile(request, nil, 0o644)
+	builder, err := utils.NewPromptBuilder(base, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.deps.PromptBuilder = builder
+
+	var diagnostics GenerationDiagnostics
+	if err := json.Unmarshal(streamDone(t, r, body)["diagnostics"], &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := diagnostics.UnresolvedPlaceholders, []string{"businessTypeLabel", "city"}; !slices.Equal(got, want) {
+		t.Errorf("unresolved_placeholders = %q, want %q", got, want)
+	}
+}
+
+// panicProcessor panics on every page
+type panicProcessor struct{}
+
+func (panicProcessor) Name() string { return "broken" }
+
+func (panicProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	panic("bad markup")
+}
+
+func TestDoneEventProcessorFailures(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	h.deps.Config.EnabledProcessors = []string{"broken"}
+	h.deps.Config.ChatDoneInlineContent = true
+	h.deps.ProcessorsSvc.RegisterProcessor("broken", panicProcessor{})
+	r := newContentRouter(h)
+
+	event := streamDone(t, r, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	var diagnostics GenerationDiagnostics
+	if err := json.Unmarshal(event["diagnostics"], &diagnostics); err != nil {
+		t.Fatal(err)
+	}
+	failures := diagnostics.ProcessorFailures
+	if len(failures) != 1 || failures[0].Processor != "broken" || failures[0].Failure != common.ProcessorFailurePanic || !strings.Contains(failures[0].Error, "bad markup") {
+		t.Errorf("processor_failures = %+v, want the panic", failures)
+	}
+
+	// The page is sent as generated
+	var response struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	json.Unmarshal(event["response"], &response)
+	if !strings.Contains(response.Message.Content, "<h1>Hello</h1>") {
+		t.Errorf("done event content = %q, want the unprocessed page", response.Message.Content)
+	}
+}
+
+// getJSON gets path from r and decodes its 200 response into v, returning
+// the size of the body
+func getJSON(t *testing.T, r *gin.Engine, path string, v any) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d: %s", path, w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: invalid response: %v", path, err)
+	}
+	return w.Body.Len()
+}
+
+func TestGetChatContentPreviews(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{})
+	r := newContentRouter(h)
+	preview := h.deps.Config.ChatContentPreviewRunes
+
+	// Three generated pages of about 300KB, with characters of more than
+	// one byte around the preview's end
+	chat := model.NewChat("big-chat")
+	chat.UserID = 1
+	for i := 1; i <= 3; i++ {
+		page := "<section><h1>Café " + strings.Repeat("é", preview) + "</h1>" + strings.Repeat("<p>Fresh bread every morning.</p>", 9000) + "</section>"
+		chat.Messages = append(chat.Messages,
+			model.ChatMessage{ID: fmt.Sprintf("u%d", i), Role: model.ChatMessageRoleUser, Content: "Make it warmer"},
+			model.ChatMessage{ID: fmt.Sprintf("a%d", i), Role: model.ChatMessageRoleAssistant, Content: page},
+		)
+	}
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+
+	var full, trimmed model.Chat
+	fullSize := getJSON(t, r, "/api/v1/chat/big-chat?include=content", &full)
+	trimmedSize := getJSON(t, r, "/api/v1/chat/big-chat", &trimmed)
+	if fullSize < 900<<10 || trimmedSize > 20<<10 {
+		t.Errorf("GetChat() is %d bytes with content and %d without, want over 900KB and under 20KB", fullSize, trimmedSize)
+	}
+	t.Logf("GetChat() is %d bytes with content and %d without", fullSize, trimmedSize)
+
+	for i, msg := range trimmed.Messages {
+		stored := chat.Messages[i]
+		if full.Messages[i].Content != stored.Content || full.Messages[i].ContentRef != nil {
+			t.Errorf("message %s with ?include=content differs from the stored one", stored.ID)
+		}
+		if stored.Role == model.ChatMessageRoleUser {
+			if msg.Content != stored.Content || msg.ContentRef != nil {
+				t.Errorf("short message %s = %q, %+v; want it whole", stored.ID, msg.Content, msg.ContentRef)
+			}
+			continue
+		}
+		want := ContentRef{MessageID: stored.ID, URL: contentURL("big-chat", stored.ID), Size: len(stored.Content)}
+		if msg.ContentRef == nil || *msg.ContentRef != want {
+			t.Errorf("message %s content_ref = %+v, want %+v", stored.ID, msg.ContentRef, want)
+		}
+		if !utf8.ValidString(msg.Content) || utf8.RuneCountInString(msg.Content) != preview || !strings.HasPrefix(stored.Content, msg.Content) {
+			t.Errorf("message %s preview is %d runes, want the first %d", stored.ID, utf8.RuneCountInString(msg.Content), preview)
+		}
+
+		// The message fetched on its own is the stored one, byte for byte
+		var one model.ChatMessage
+		getJSON(t, r, "/api/v1/chat/big-chat/messages/"+stored.ID, &one)
+		if one.Content != stored.Content || one.ContentRef != nil {
+			t.Errorf("GetMessage(%s) = %d bytes, want the %d stored", stored.ID, len(one.Content), len(stored.Content))
+		}
+		var content MessageContent
+		getJSON(t, r, msg.ContentRef.URL, &content)
+		if content.Content != stored.Content {
+			t.Errorf("content_ref of %s = %d bytes, want the %d stored", stored.ID, len(content.Content), len(stored.Content))
+		}
+	}
+
+	// Listings don't touch the stored chat
+	saved, _ := store.GetChat(context.Background(), "big-chat")
+	for i, msg := range saved.Messages {
+		if msg.ContentRef != nil || msg.Content != chat.Messages[i].Content {
+			t.Errorf("stored message %s changed by GetChat()", msg.ID)
+		}
+	}
+
+	// Older clients get whole messages
+	h.deps.Config.ChatFullContent = true
+	var legacy model.Chat
+	if size := getJSON(t, r, "/api/v1/chat/big-chat", &legacy); size != fullSize || legacy.Messages[1].ContentRef != nil {
+		t.Errorf("GetChat() with chat_full_content is %d bytes, want the %d of whole messages", size, fullSize)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/chat/big-chat/messages/missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unknown message status = %d, want 404", w.Code)
+	}
+}
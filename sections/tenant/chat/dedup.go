@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/storage"
+)
+
+const (
+	// Requests are deduplicated per kind of result replayed: the done event
+	// for streams (SSE and WebSocket) or the ChatResponse for completions
+	dedupKindStream   = "stream"
+	dedupKindComplete = "complete"
+
+	dedupPollInterval = 250 * time.Millisecond
+)
+
+// chatDedup marks a chat request in progress, so identical requests get its
+// result instead of generating again
+type chatDedup struct {
+	redis *storage.RedisClient
+	hash  string
+	ttl   time.Duration
+}
+
+// beginDedup checks for a request identical to req made in the last
+// chat_dedup_ttl_seconds. While one is in progress it waits for it to
+// finish, and returns its result for replay. Otherwise req is marked in
+// progress and the returned chatDedup must be finished. Both are nil with
+// the guard off, for forced requests and for requests without a message.
+// The error is ctx's, when the client goes away while waiting.
+func (h *Handler) beginDedup(ctx context.Context, tenantSchema, kind string, req model.ChatRequest) (*chatDedup, []byte, error) {
+	ttl := time.Duration(h.deps.Config.ChatDedupTTLSeconds) * time.Second
+	if ttl <= 0 || h.deps.Redis == nil || req.Force || req.Message == nil {
+		return nil, nil, nil
+	}
+
+	hash := chatRequestHash(tenantSchema, kind, req)
+	for {
+		result, err := h.deps.Redis.BeginChatRequest(ctx, hash, ttl)
+		if errors.Is(err, storage.ErrDuplicateChatInProgress) {
+			select {
+			case <-time.After(dedupPollInterval):
+				continue
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		if err != nil {
+			// Fail open: a duplicate generation beats refusing the request
+			slog.Error("Failed to check for duplicate chat request", "chat_id", req.ChatID, "error", err)
+			return nil, nil, nil
+		}
+		if result != nil {
+			h.logger.Info("Replaying result of identical chat request", "chat_id", req.ChatID, "tenant", tenantSchema, "kind", kind)
+			return nil, result, nil
+		}
+		return &chatDedup{redis: h.deps.Redis, hash: hash, ttl: ttl}, nil, nil
+	}
+}
+
+// finish stores the result replayed to identical requests, or without one
+// clears the mark so they generate again
+func (d *chatDedup) finish(ctx context.Context, result []byte) {
+	if d == nil {
+		return
+	}
+	if result == nil {
+		if err := d.redis.ReleaseChatRequest(ctx, d.hash); err != nil {
+			slog.Error("Failed to release chat request", "error", err)
+		}
+		return
+	}
+	if err := d.redis.SaveChatRequestResult(ctx, d.hash, result, d.ttl); err != nil {
+		slog.Error("Failed to save chat request result", "error", err)
+	}
+}
+
+// chatRequestHash identifies a chat request by its tenant, chat, message
+// content (with whitespace collapsed) and onboarding data
+func chatRequestHash(tenantSchema, kind string, req model.ChatRequest) string {
+	var onboardingData []byte
+	if req.Message.Context != nil && req.Message.Context.OnboardingData != nil {
+		onboardingData, _ = json.Marshal(req.Message.Context.OnboardingData)
+	}
+
+	h := sha256.New()
+	for _, part := range []string{tenantSchema, kind, req.ChatID, strings.Join(strings.Fields(req.Message.Content), " ")} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(onboardingData)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayDoneEvent sends the start and done events of an identical earlier
+// stream, with the done event marked deduplicated
+func replayDoneEvent(done []byte, sendEvent SendSSEEvent) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(done, &event); err != nil {
+		slog.Error("Failed to unmarshal stored done event", "error", err)
+		sendEvent("error", `{"error":"Failed to replay identical request"}`)
+		return
+	}
+	event["deduplicated"] = json.RawMessage("true")
+
+	var response struct {
+		ChatID string `json:"chat_id"`
+	}
+	json.Unmarshal(event["response"], &response)
+
+	startJSON, _ := json.Marshal(map[string]any{"chat_id": response.ChatID, "deduplicated": true})
+	doneJSON, _ := json.Marshal(event)
+	sendEvent("start", string(startJSON))
+	sendEvent("done", string(doneJSON))
+}
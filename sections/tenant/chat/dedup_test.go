@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// newDedupHandler returns a chat handler whose duplicate request guard
+// runs on an in-process Redis
+func newDedupHandler(t *testing.T, vertex *fakeVertex) *Handler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	server := miniredis.RunT(t)
+	redisClient, err := storage.NewRedisClient(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisClient() error = %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Redis = redisClient
+	return h
+}
+
+// completion posts body for a completion and decodes its response
+func completion(t *testing.T, h *Handler, body string) model.ChatResponse {
+	t.Helper()
+
+	w := postCompletion(h, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("completion status = %d: %s", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid completion response: %v", err)
+	}
+	return response
+}
+
+func TestDedupStreamInProgress(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage, delay: 300 * time.Millisecond}
+	h := newDedupHandler(t, vertex)
+	h.deps.Config.ChatDoneInlineContent = true
+	r := newContentRouter(h)
+	body := `{"message": {"role": "user", "content": "A page for my bakery"}}`
+
+	// The second request arrives while the first is generating, and waits
+	// for its done event
+	var wg sync.WaitGroup
+	streams := make([]*httptest.ResponseRecorder, 2)
+	for i := range streams {
+		streams[i] = httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.ServeHTTP(streams[i], req)
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	wg.Wait()
+	events := []map[string]json.RawMessage{streamDoneEvent(t, streams[0]), streamDoneEvent(t, streams[1])}
+
+	if n := vertex.calls.Load(); n != 1 {
+		t.Errorf("model called %d times, want once", n)
+	}
+	if _, ok := events[0]["deduplicated"]; ok {
+		t.Error("first stream's done event is marked deduplicated")
+	}
+	if string(events[1]["deduplicated"]) != "true" {
+		t.Errorf("second stream's done event deduplicated = %s, want true", events[1]["deduplicated"])
+	}
+	if string(events[0]["response"]) != string(events[1]["response"]) {
+		t.Errorf("replayed response = %s, want the first stream's %s", events[1]["response"], events[0]["response"])
+	}
+}
+
+func TestDedupCompletionReplay(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage}
+	h := newDedupHandler(t, vertex)
+
+	first := completion(t, h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	// Whitespace doesn't make a request different
+	second := completion(t, h, `{"message": {"role": "user", "content": "  A page for\nmy   bakery "}}`)
+	if n := vertex.calls.Load(); n != 1 {
+		t.Fatalf("model called %d times, want once", n)
+	}
+	if first.Deduplicated || !second.Deduplicated {
+		t.Errorf("deduplicated = %v, %v; want only the replay marked", first.Deduplicated, second.Deduplicated)
+	}
+	if second.ChatID != first.ChatID || second.Message.ID != first.Message.ID || second.Message.Content != first.Message.Content {
+		t.Errorf("replayed response = %+v, want the first's %+v", second, first)
+	}
+
+	// Other onboarding data, and streams, generate again
+	completion(t, h, `{"message": {"role": "user", "content": "A page for my bakery", "context": {"onboarding_data": {"businessName": "Rye & Co"}}}}`)
+	streamDone(t, newContentRouter(h), `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if n := vertex.calls.Load(); n != 3 {
+		t.Errorf("model called %d times, want 3", n)
+	}
+}
+
+func TestDedupBypass(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage}
+	h := newDedupHandler(t, vertex)
+	body := `{"message": {"role": "user", "content": "A page for my bakery"}}`
+
+	completion(t, h, body)
+	if forced := completion(t, h, `{"force": true, "message": {"role": "user", "content": "A page for my bakery"}}`); forced.Deduplicated {
+		t.Error("forced request was deduplicated")
+	}
+	if n := vertex.calls.Load(); n != 2 {
+		t.Errorf("model called %d times, want twice with force", n)
+	}
+
+	// With the guard off every request generates
+	h.deps.Config.ChatDedupTTLSeconds = 0
+	if response := completion(t, h, body); response.Deduplicated || vertex.calls.Load() != 3 {
+		t.Errorf("request with the guard off deduplicated = %v after %d calls", response.Deduplicated, vertex.calls.Load())
+	}
+}
+
+func TestDedupFailedGenerationReleases(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage, err: errors.New("model unavailable")}
+	h := newDedupHandler(t, vertex)
+	body := `{"message": {"role": "user", "content": "A page for my bakery"}}`
+
+	if w := postCompletion(h, body); w.Code == http.StatusOK {
+		t.Fatalf("completion with a failing model = %d, want an error", w.Code)
+	}
+
+	// Failures aren't replayed, and don't hold up the next request
+	vertex.err = nil
+	start := time.Now()
+	if response := completion(t, h, body); response.Deduplicated || vertex.calls.Load() != 2 {
+		t.Errorf("request after a failure deduplicated = %v after %d calls, want a new generation", response.Deduplicated, vertex.calls.Load())
+	}
+	if elapsed := time.Since(start); elapsed > dedupPollInterval {
+		t.Errorf("request after a failure took %v, want it not to wait", elapsed)
+	}
+}
+
+func TestChatRequestHash(t *testing.T) {
+	request := func(chatID, content string, onboarding *model.OnboardingData) model.ChatRequest {
+		req := model.ChatRequest{ChatID: chatID, Message: &model.ChatMessage{Content: content}}
+		if onboarding != nil {
+			req.Message.Context = &model.ChatMessageContext{OnboardingData: onboarding}
+		}
+		return req
+	}
+	base := chatRequestHash("tenant_a", dedupKindStream, request("c1", "Make it blue", nil))
+
+	if got := chatRequestHash("tenant_a", dedupKindStream, request("c1", " Make  it\tblue ", nil)); got != base {
+		t.Error("chatRequestHash() differs by whitespace")
+	}
+	for name, hash := range map[string]string{
+		"tenant":     chatRequestHash("tenant_b", dedupKindStream, request("c1", "Make it blue", nil)),
+		"kind":       chatRequestHash("tenant_a", dedupKindComplete, request("c1", "Make it blue", nil)),
+		"chat":       chatRequestHash("tenant_a", dedupKindStream, request("c2", "Make it blue", nil)),
+		"content":    chatRequestHash("tenant_a", dedupKindStream, request("c1", "Make it red", nil)),
+		"onboarding": chatRequestHash("tenant_a", dedupKindStream, request("c1", "Make it blue", &model.OnboardingData{BusinessName: "Rye"})),
+		// Parts are separated, so they can't run into each other
+		"boundary": chatRequestHash("tenant_a", dedupKindStream, request("c1Make", " it blue", nil)),
+	} {
+		if hash == base {
+			t.Errorf("chatRequestHash() doesn't depend on the %s", name)
+		}
+	}
+}
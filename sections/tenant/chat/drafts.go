@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/model"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/filesystem"
+	"awning-backend/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// DraftKeyPrefix is the filesystem prefix generated pages are saved under
+	DraftKeyPrefix = "drafts/chat/"
+
+	// JobKindPruneDrafts deletes chat drafts older than draft_max_age_days
+	JobKindPruneDrafts = "chat.prune_drafts"
+
+	// DraftPruneInterval is how often the prune job runs
+	DraftPruneInterval = 24 * time.Hour
+
+	// draftVersionFormat names each generation's draft, sorting by time
+	draftVersionFormat = "20060102T150405.000Z"
+
+	// draftMetadataSuffix is added to a draft version's key for its site
+	// metadata
+	draftMetadataSuffix = ".metadata"
+)
+
+// draftKeys returns the filesystem keys of a chat's latest draft and of the
+// draft version saved at t
+func draftKeys(chatID string, t time.Time) (latest, version, versionKey string) {
+	version = t.UTC().Format(draftVersionFormat)
+	return DraftKeyPrefix + chatID + "/latest", version, DraftKeyPrefix + chatID + "/" + version
+}
+
+// saveDraft saves the generated page to the tenant filesystem, both as the
+// chat's latest draft and as a version of its own, unless the tenant turned
+// auto_save_drafts off. Site metadata, when generated, is saved next to the
+// version. Failures are logged and return nil, so they never fail the
+// generation.
+func (h *Handler) saveDraft(ctx context.Context, gen *generation, html string, metadata *model.SiteMetadata) *model.ChatDraft {
+	if gen.tenantSchema == "" || h.deps.DB == nil || strings.TrimSpace(html) == "" {
+		return nil
+	}
+	if h.deps.Settings != nil && !h.deps.Settings.GetBool(ctx, gen.tenantSchema, settings.AutoSaveDrafts) {
+		return nil
+	}
+
+	data, err := json.Marshal(html)
+	if err != nil {
+		return nil
+	}
+
+	latest, version, versionKey := draftKeys(gen.chatID, time.Now())
+	for _, key := range []string{versionKey, latest} {
+		if _, err := filesystem.SaveEntry(ctx, h.deps, gen.tenantSchema, key, data); err != nil {
+			h.logger.Error("Failed to save chat draft", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "key", key, "error", err)
+			return nil
+		}
+	}
+
+	draft := &model.ChatDraft{Key: latest, VersionKey: versionKey, Version: version}
+	if metadata != nil {
+		metadataKey := versionKey + draftMetadataSuffix
+		if data, err := json.Marshal(metadata); err == nil {
+			if _, err := filesystem.SaveEntry(ctx, h.deps, gen.tenantSchema, metadataKey, data); err != nil {
+				h.logger.Error("Failed to save draft site metadata", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "key", metadataKey, "error", err)
+			} else {
+				draft.MetadataKey = metadataKey
+			}
+		}
+	}
+
+	h.logger.Info("Chat draft saved", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "version", version)
+	return draft
+}
+
+// DraftPruner deletes chat drafts that haven't been updated for the
+// configured number of days
+type DraftPruner struct {
+	logger *slog.Logger
+	db     *db.DB
+	kv     storage.KV
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewDraftPruner creates a pruner for drafts older than maxAgeDays. Cached
+// entries are evicted from kv when it is set.
+func NewDraftPruner(database *db.DB, kv storage.KV, maxAgeDays int) *DraftPruner {
+	return &DraftPruner{
+		logger: slog.With("service", "DraftPruner"),
+		db:     database,
+		kv:     kv,
+		maxAge: time.Duration(maxAgeDays) * 24 * time.Hour,
+		now:    time.Now,
+	}
+}
+
+// HandlePrune is the jobs.Handler for JobKindPruneDrafts
+func (p *DraftPruner) HandlePrune(ctx context.Context, _ json.RawMessage) error {
+	_, err := p.PruneAll(ctx)
+	return err
+}
+
+// PruneAll deletes old drafts of every tenant and returns how many were
+// deleted. Tenants that fail are reported together once all have been
+// visited.
+func (p *DraftPruner) PruneAll(ctx context.Context) (int, error) {
+	if p.maxAge <= 0 {
+		return 0, nil
+	}
+
+	var schemas []string
+	if err := p.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	cutoff := p.now().Add(-p.maxAge)
+	var errs []error
+	pruned := 0
+	for _, schema := range schemas {
+		n, err := p.pruneTenant(ctx, schema, cutoff)
+		if err != nil {
+			p.logger.Error("Failed to prune chat drafts", "tenant", schema, "error", err)
+			errs = append(errs, fmt.Errorf("tenant %s: %w", schema, err))
+			continue
+		}
+		pruned += n
+	}
+
+	p.logger.Info("Chat draft prune finished", "pruned", pruned, "failed", len(errs))
+	return pruned, errors.Join(errs...)
+}
+
+// pruneTenant deletes the tenant's drafts last updated before cutoff for good
+func (p *DraftPruner) pruneTenant(ctx context.Context, tenantSchema string, cutoff time.Time) (int, error) {
+	var keys []string
+	err := p.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		var old []models.TenantFilesystem
+		err := tx.Unscoped().
+			Where("tenant_schema = ? AND key LIKE ? AND updated_at < ?", tenantSchema, DraftKeyPrefix+"%", cutoff).
+			Select("id", "key").
+			Find(&old).Error
+		if err != nil || len(old) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(old))
+		for i, entry := range old {
+			ids[i] = entry.ID
+			keys = append(keys, entry.Key)
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&models.TenantFilesystem{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if p.kv != nil {
+		for _, key := range keys {
+			if err := p.kv.Delete(ctx, filesystem.CacheKey(tenantSchema, key)); err != nil {
+				p.logger.Error("Failed to invalidate filesystem cache", "tenant", tenantSchema, "key", key, "error", err)
+			}
+		}
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDraftKeys(t *testing.T) {
+	at := time.Date(2026, 10, 15, 9, 30, 5, 123_000_000, time.FixedZone("CEST", 2*60*60))
+
+	latest, version, versionKey := draftKeys("chat-1", at)
+	if latest != "drafts/chat/chat-1/latest" {
+		t.Errorf("latest = %q", latest)
+	}
+	if version != "20261015T073005.123Z" || versionKey != "drafts/chat/chat-1/20261015T073005.123Z" {
+		t.Errorf("version = %q at %q, want the UTC time", version, versionKey)
+	}
+
+	// Versions sort by time, and before the latest draft
+	_, _, later := draftKeys("chat-1", at.Add(time.Millisecond))
+	if !(versionKey < later && later < latest) {
+		t.Errorf("draft keys sort %q, %q, %q; want by time then latest", versionKey, later, latest)
+	}
+}
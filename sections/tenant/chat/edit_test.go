@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const editPage = `<!DOCTYPE html><html><head><title>Bakery</title></head><body>` +
+	`<section id="hero"><h1>Fresh bread</h1></section>` +
+	`<section class="card"><p>One</p></section><section class="card"><p>Two</p></section>` +
+	`</body></html>`
+
+// editRequest is a completion request editing target of editPage
+func editRequest(target string) string {
+	body, _ := json.Marshal(map[string]any{
+		"message":      map[string]string{"role": "user", "content": "Make the headline warmer"},
+		"edit_target":  json.RawMessage(target),
+		"current_html": editPage,
+	})
+	return string(body)
+}
+
+func TestSectionEditCompletion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: "```html\n<section id=\"hero\"><h1>Warm bread, warmer welcome</h1></section>\n```"}
+	h, _ := newTestHandler(t, vertex)
+
+	w := postCompletion(h, editRequest(`{"selector": "#hero"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	diff := response.SectionEdit
+	if diff == nil || diff.Before != `<section id="hero"><h1>Fresh bread</h1></section>` ||
+		diff.After != `<section id="hero"><h1>Warm bread, warmer welcome</h1></section>` {
+		t.Fatalf("section_edit = %+v, want the hero before and after", diff)
+	}
+	doc := response.Message.Content
+	if !strings.Contains(doc, diff.After) || strings.Contains(doc, diff.Before) || strings.Count(doc, `class="card"`) != 2 {
+		t.Errorf("message = %s, want the whole page with only the hero replaced", doc)
+	}
+}
+
+func TestSectionEditRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"several matches", editRequest(`{"selector": ".card"}`), http.StatusUnprocessableEntity, "edit_target_ambiguous"},
+		{"no match", editRequest(`{"selector": "#pricing"}`), http.StatusUnprocessableEntity, "edit_target_not_found"},
+		{"section index out of range", editRequest(`{"section_index": 3}`), http.StatusUnprocessableEntity, "edit_target_not_found"},
+		{"unsupported selector", editRequest(`{"selector": "body > section"}`), http.StatusBadRequest, "invalid_edit_target"},
+		{"no current_html", `{"message": {"role": "user", "content": "Edit"}, "edit_target": {"selector": "#hero"}}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		vertex := &fakeVertex{reply: testPage}
+		h, _ := newTestHandler(t, vertex)
+
+		w := postCompletion(h, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.code != "" && errorCode(t, w) != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, errorCode(t, w), tt.code)
+		}
+		if n := vertex.calls.Load(); n != 0 {
+			t.Errorf("%s: model called %d times for a rejected edit", tt.name, n)
+		}
+	}
+}
+
+func TestSectionEditInvalidReply(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: `<div id="hero">Not a section</div>`}
+	h, _ := newTestHandler(t, vertex)
+
+	w := postCompletion(h, editRequest(`{"section_index": 0}`))
+	if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "replacement must be a single element") {
+		t.Errorf("status = %d for a reply that isn't a section, want 502: %s", w.Code, w.Body)
+	}
+}
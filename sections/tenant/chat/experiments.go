@@ -0,0 +1,42 @@
+package chat
+
+import (
+	"net/http"
+
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExperimentStatus is a configured prompt experiment with its recorded usage
+type ExperimentStatus struct {
+	Name           string `json:"name"`
+	Template       string `json:"template"`
+	TrafficPercent int    `json:"trafficPercent"`
+	Active         bool   `json:"active"`
+	storage.ExperimentCounters
+}
+
+// ListExperiments returns each configured prompt experiment with the number
+// of chats assigned to it and generations that used its template
+func (h *Handler) ListExperiments(c *gin.Context) {
+	experiments := make([]ExperimentStatus, 0, len(h.deps.Config.PromptExperiments))
+	for _, e := range h.deps.Config.PromptExperiments {
+		counters, err := h.deps.Redis.GetExperimentCounters(c.Request.Context(), e.Name)
+		if err != nil {
+			h.logger.Error("Failed to get experiment counters", "experiment", e.Name, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get experiment counters"})
+			return
+		}
+
+		experiments = append(experiments, ExperimentStatus{
+			Name:               e.Name,
+			Template:           e.Template,
+			TrafficPercent:     e.TrafficPercent,
+			Active:             h.deps.Config.IsPromptExperimentActive(e.Name),
+			ExperimentCounters: *counters,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"experiments": experiments})
+}
@@ -0,0 +1,245 @@
+package chat
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FeedbackRatingUp   = "up"
+	FeedbackRatingDown = "down"
+
+	DefaultFeedbackPerPage = 50
+	MaxFeedbackPerPage     = 200
+)
+
+// FeedbackRequest rates an assistant message
+type FeedbackRequest struct {
+	Rating  string   `json:"rating" binding:"required,oneof=up down"`
+	Reasons []string `json:"reasons" binding:"max=10,dive,max=50"`
+	Comment string   `json:"comment" binding:"max=2000"`
+}
+
+// FeedbackCount is the number of ratings for one model and prompt variant
+type FeedbackCount struct {
+	Model   string `json:"model"`
+	Variant string `json:"promptVariant"`
+	Up      int64  `json:"up"`
+	Down    int64  `json:"down"`
+}
+
+// SubmitFeedback records the user's rating of an assistant message. Rating
+// the same message again replaces the earlier rating.
+func (h *Handler) SubmitFeedback(c *gin.Context) {
+	if h.deps.DB == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "feedback not available"})
+		return
+	}
+
+	userID, ok := auth.GetUserIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req FeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	messageID := c.Param("messageId")
+
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
+		return
+	}
+	chatID := chat.ID
+
+	var message *model.ChatMessage
+	for i := range chat.Messages {
+		if chat.Messages[i].ID == messageID {
+			message = &chat.Messages[i]
+			break
+		}
+	}
+	if message == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if message.Role != model.ChatMessageRoleAssistant {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only assistant messages can be rated"})
+		return
+	}
+
+	reasons := make([]string, 0, len(req.Reasons))
+	for _, reason := range req.Reasons {
+		if reason = strings.TrimSpace(reason); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	feedback := models.MessageFeedback{
+		TenantSchema: tenantSchema,
+		UserID:       userID,
+		ChatID:       chatID,
+		MessageID:    messageID,
+		Rating:       req.Rating,
+		Reasons:      reasons,
+		Comment:      strings.TrimSpace(req.Comment),
+		Model:        message.Model,
+		Variant:      chat.Variant,
+	}
+
+	db := h.deps.DB.DB.WithContext(c.Request.Context())
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "chat_id"}, {Name: "message_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "reasons", "comment", "updated_at"}),
+	}).Create(&feedback).Error
+	if err == nil {
+		err = db.Where("user_id = ? AND chat_id = ? AND message_id = ?", userID, chatID, messageID).First(&feedback).Error
+	}
+	if err != nil {
+		h.logger.Error("Failed to save feedback", "chat_id", chatID, "message_id", messageID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save feedback"})
+		return
+	}
+
+	h.logger.Info("Feedback recorded", "chat_id", chatID, "message_id", messageID, "rating", req.Rating, "model", message.Model, "variant", chat.Variant)
+
+	c.JSON(http.StatusOK, feedback)
+}
+
+// ListFeedback lists message feedback, newest first, with up and down counts
+// per model and prompt variant. Filters: from and to (RFC3339 or
+// YYYY-MM-DD, to is inclusive), rating, model, variant and tenant.
+func (h *Handler) ListFeedback(c *gin.Context) {
+	if h.deps.DB == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "feedback not available"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultFeedbackPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxFeedbackPerPage {
+			perPage = parsed
+		}
+	}
+
+	var conditions []func(*gorm.DB) *gorm.DB
+
+	for _, param := range []string{"from", "to"} {
+		v := c.Query(param)
+		if v == "" {
+			continue
+		}
+		t, dateOnly, err := parseFeedbackTime(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param + ", expected RFC3339 or YYYY-MM-DD"})
+			return
+		}
+		if param == "from" {
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at >= ?", t) })
+		} else {
+			if dateOnly {
+				t = t.AddDate(0, 0, 1)
+				conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at < ?", t) })
+			} else {
+				conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("created_at <= ?", t) })
+			}
+		}
+	}
+
+	if rating := c.Query("rating"); rating != "" {
+		if rating != FeedbackRatingUp && rating != FeedbackRatingDown {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be up or down"})
+			return
+		}
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where("rating = ?", rating) })
+	}
+	for _, f := range []struct{ param, column string }{
+		{"model", "model"},
+		{"variant", "variant"},
+		{"tenant", "tenant_schema"},
+	} {
+		if v, ok := c.GetQuery(f.param); ok {
+			conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where(f.column+" = ?", v) })
+		}
+	}
+
+	filter := func(db *gorm.DB) *gorm.DB {
+		for _, condition := range conditions {
+			db = condition(db)
+		}
+		return db
+	}
+
+	db := h.deps.DB.DB.WithContext(c.Request.Context())
+
+	var total int64
+	if err := db.Model(&models.MessageFeedback{}).Scopes(filter).Count(&total).Error; err != nil {
+		h.logger.Error("Failed to count feedback", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list feedback"})
+		return
+	}
+
+	feedback := []models.MessageFeedback{}
+	err := db.Scopes(filter).
+		Order("created_at DESC, id DESC").
+		Offset((page - 1) * perPage).
+		Limit(perPage).
+		Find(&feedback).Error
+	if err != nil {
+		h.logger.Error("Failed to list feedback", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list feedback"})
+		return
+	}
+
+	counts := []FeedbackCount{}
+	err = db.Model(&models.MessageFeedback{}).Scopes(filter).
+		Select("model, variant, " +
+			"COUNT(*) FILTER (WHERE rating = 'up') AS up, " +
+			"COUNT(*) FILTER (WHERE rating = 'down') AS down").
+		Group("model, variant").
+		Order("model, variant").
+		Scan(&counts).Error
+	if err != nil {
+		h.logger.Error("Failed to count feedback by model", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list feedback"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"feedback": feedback,
+		"counts":   counts,
+		"page":     page,
+		"perPage":  perPage,
+		"total":    total,
+	})
+}
+
+// parseFeedbackTime parses an RFC3339 time or a UTC date, reporting which
+func parseFeedbackTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, false, err
+}
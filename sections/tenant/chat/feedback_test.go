@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseFeedbackTime(t *testing.T) {
+	tests := []struct {
+		in       string
+		want     time.Time
+		dateOnly bool
+		wantErr  bool
+	}{
+		{"2026-10-01", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), true, false},
+		{"2026-10-01T12:30:00Z", time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC), false, false},
+		{"2026-10-01T12:30:00+02:00", time.Date(2026, 10, 1, 10, 30, 0, 0, time.UTC), false, false},
+		{"yesterday", time.Time{}, false, true},
+		{"2026-13-01", time.Time{}, false, true},
+	}
+	for _, tt := range tests {
+		got, dateOnly, err := parseFeedbackTime(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFeedbackTime(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (!got.Equal(tt.want) || dateOnly != tt.dateOnly) {
+			t.Errorf("parseFeedbackTime(%q) = %v, %v; want %v, %v", tt.in, got, dateOnly, tt.want, tt.dateOnly)
+		}
+	}
+}
+
+func TestFeedbackWithoutDatabase(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := gin.New()
+	r.POST("/chat/:id/messages/:messageId/feedback", asUser("", 1), h.SubmitFeedback)
+	r.GET("/admin/feedback", h.ListFeedback)
+
+	if w := do(r, http.MethodPost, "/chat/c/messages/m/feedback", "", `{"rating": "up"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("SubmitFeedback status = %d, want 503 without a database", w.Code)
+	}
+	if w := do(r, http.MethodGet, "/admin/feedback", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("ListFeedback status = %d, want 503 without a database", w.Code)
+	}
+}
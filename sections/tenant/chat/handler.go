@@ -3,75 +3,111 @@ package chat
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
 	"awning-backend/model"
+	"awning-backend/processors"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+	"awning-backend/services"
+	"awning-backend/services/ai"
+	"awning-backend/storage"
+	"awning-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/tiktoken-go/tokenizer"
+	"gorm.io/gorm"
 )
 
 const (
-	MAX_INPUT_TOKENS  = 200000
-	MAX_OUTPUT_TOKENS = 400000
-	TOKEN_MODEL       = tokenizer.Cl100kBase
+	// COMPLETION_MAX_DURATION caps background generations started by
+	// CreateChatCompletion after the client has been answered with a 504
+	COMPLETION_MAX_DURATION = 10 * time.Minute
 )
 
 // Handler handles chat-related requests
 type Handler struct {
-	logger *slog.Logger
-	deps   *sections.Dependencies
+	logger   *slog.Logger
+	deps     *sections.Dependencies
+	quota    *account.QuotaService
+	spending *account.SpendingService
+	mocks    *services.MockResponder
 }
 
 // NewHandler creates a new chat handler
 func NewHandler(deps *sections.Dependencies) *Handler {
 	return &Handler{
-		logger: slog.With("handler", "ChatHandler"),
-		deps:   deps,
+		logger:   slog.With("handler", "ChatHandler"),
+		deps:     deps,
+		quota:    account.NewQuotaService(deps),
+		spending: account.NewSpendingService(deps),
+		mocks:    services.NewMockResponder(deps.Config),
 	}
 }
 
-type SendSSEEvent func(c *gin.Context, eventType, data string)
+// SendSSEEvent emits one stream event; see utils.SSEWriter
+type SendSSEEvent func(eventType, data string)
 
-func (h *Handler) streamVertexResponse(c *gin.Context, requestCtx context.Context, prompt string, chatID string, chatStage model.ChatStage, fullContent *strings.Builder, sendSSEEvent SendSSEEvent) error {
+func (h *Handler) streamVertexResponse(c *gin.Context, requestCtx context.Context, prompt string, params common.GenerationParams, chatID string, chatStage model.ChatStage, fullContent *strings.Builder, guard *utils.OutputGuard, sendSSEEvent SendSSEEvent) error {
 	// Send start message
-	sendSSEEvent(c, "start", `{"message":"Starting response generation..."}`)
-
-	// Send periodic placeholder event
-	if !h.deps.Config.SendThinking {
-		tmr := time.NewTicker(10 * time.Second)
-		defer tmr.Stop()
-
-		go func() {
-			for {
-				select {
-				case <-tmr.C:
-					h.logger.Info("Sending periodic thinking event")
-					sendSSEEvent(c, "thinking", `{"message":"still thinking..."}`)
-				case <-requestCtx.Done():
-					return
-				}
-			}
-		}()
-	}
+	sendSSEEvent("start", `{"message":"Starting response generation..."}`)
+
+	// Reasoning goes out as configured by thinking_mode
+	thinking := services.NewThinkingStream(h.deps.Config, func(data string) {
+		sendSSEEvent("thinking", data)
+	})
+	thinking.Start(requestCtx)
+	defer thinking.Stop()
+
+	// Time to the model's first event, then the rest of the stream
+	timings := common.TimingsFromContext(requestCtx)
+	start := time.Now()
+	var firstEventAt time.Time
+	defer func() {
+		if firstEventAt.IsZero() {
+			timings.Add("model_ttfb", time.Since(start))
+			return
+		}
+		timings.Add("model_stream", time.Since(firstEventAt))
+	}()
+
+	// The guard cancels the model request once the output runs away
+	streamCtx, cancel := context.WithCancelCause(requestCtx)
+	defer cancel(nil)
 
 	// Stream response using Vertex AI
-	err := h.deps.VertexClient.GenerateContentStream(requestCtx, prompt, func(event sections.StreamEvent) error {
-		if !h.deps.Config.SendThinking && event.Type == "thinking" {
+	err := h.deps.VertexClient.GenerateContentStream(streamCtx, prompt, params, func(event sections.StreamEvent) error {
+		if firstEventAt.IsZero() {
+			firstEventAt = time.Now()
+			timings.Add("model_ttfb", firstEventAt.Sub(start))
+		}
+
+		if event.Type == "thinking" {
+			thinking.Add(event.Content)
 			return nil
 		}
 
 		if event.Type == "content" {
 			fullContent.WriteString(event.Content)
+			if !guard.Check(fullContent.String()) {
+				cancel(errOutputStopped)
+				return errOutputStopped
+			}
 			return nil
 		}
 
@@ -80,108 +116,400 @@ func (h *Handler) streamVertexResponse(c *gin.Context, requestCtx context.Contex
 			"type":    event.Type,
 			"content": event.Content,
 		})
-		sendSSEEvent(c, event.Type, string(eventJSON))
+		sendSSEEvent(event.Type, string(eventJSON))
 
 		return nil
 	})
+	if guard.Reason() != "" {
+		// What was streamed is cut short by the caller
+		return nil
+	}
 
 	return err
 }
 
-func (h *Handler) postProcessAssistantMessage(requestCtx context.Context, assistantMessage string) (string, error) {
-	// Apply processors to the assistant message
-	processors := h.deps.ProcessorsSvc.GetEnabledProcessors()
-	processedMessage := assistantMessage
+// postProcessAssistantMessage runs the enabled processors over the assistant
+// message. Failing processors are skipped and show up in the report. With
+// onSection set, sections are processed incrementally and passed to it as
+// they finish.
+func (h *Handler) postProcessAssistantMessage(requestCtx context.Context, assistantMessage string, progress func(name string), onSection func(services.ProcessedSection)) (string, []common.ProcessorReport) {
+	if onSection != nil {
+		return h.deps.ProcessorsSvc.RunIncremental(requestCtx, assistantMessage, progress, onSection)
+	}
+	return h.deps.ProcessorsSvc.Run(requestCtx, assistantMessage, progress)
+}
 
-	for _, processor := range processors {
-		h.logger.Info("Applying processor to assistant message", "processor", processor.Name())
-		processedContent, err := processor.Process(requestCtx, []byte(processedMessage))
-		if err != nil {
-			h.logger.Error("Failed to process content with processor", "processor", processor.Name(), "error", err)
-		} else {
-			processedMessage = string(processedContent)
+// generation holds the state of a single chat generation, shared by the
+// streaming and non-streaming endpoints
+type generation struct {
+	req          model.ChatRequest
+	tenantSchema string
+	userID       uint
+	chatID       string
+	chat         *model.Chat
+	prompt       string
+	keywords     []string
+	reservation  *account.QuotaReservation
+	lock         *storage.ChatLock
+	startedAt    time.Time
+	variant      string // Active prompt experiment, empty for the default template
+	params       common.GenerationParams
+
+	// Locale the reply should be written in, and the prompt to retry with
+	// when it isn't (set when language_retry is on)
+	locale      string
+	retryPrompt string
+
+	// Set for targeted edits of one element of req.CurrentHTML
+	edit *services.SectionEdit
+
+	// Questions the chat was paused on when this generation resumes it,
+	// and those of them it goes ahead assuming
+	clarification *model.ChatClarification
+	assumptions   []model.ClarificationQuestion
+
+	// Page slugs of a multi-page generation, home first
+	pages []string
+
+	// Set for updates (chat_stage update) that start from the current site
+	current *currentSite
+
+	// Number of messages the chat had when loaded; later ones were added by
+	// this generation
+	baseMessages int
+
+	// Phase timings and prompt problems sent in the done event
+	timings     *common.Timings
+	diagnostics *GenerationDiagnostics
+
+	// Real values for placeholders the model leaves in the page, and the
+	// tenant's contact details for its links and address
+	placeholders processors.PlaceholderValues
+	contact      processors.ContactDetails
+
+	// Favicon, fonts and palette the header processor adds to the page
+	brand processors.BrandAssets
+
+	// Motif whose fallback images are used while the Unsplash quota is
+	// exhausted
+	motif model.BusinessMotif
+
+	// Tokens of the prompt, and of every model request made so far, for
+	// usage records
+	promptTokens int
+	usage        model.TokenUsage
+
+	// Set when identical requests wait for this generation's result
+	dedup *chatDedup
+
+	// Why the output guard cut the reply short, if it did
+	truncated string
+}
+
+// generationError is returned by prepareGeneration with the status and body
+// to send to the client
+type generationError struct {
+	Status int
+	Body   gin.H
+}
+
+func newGenerationError(status int, message string) *generationError {
+	return &generationError{Status: status, Body: gin.H{"error": message}}
+}
+
+// prepareGeneration locks and loads the chat, builds the prompt, checks the
+// token limit and reserves quota for the tenant (when tenantSchema is set)
+func (h *Handler) prepareGeneration(ctx context.Context, tenantSchema string, userID uint, req model.ChatRequest) (_ *generation, genErr *generationError) {
+	// Phases are recorded on the request's timings when it has them, so
+	// they show up in its Server-Timing header too
+	timings := common.TimingsFromContext(ctx)
+	if timings == nil {
+		timings = common.NewTimings()
+	}
+
+	// A chat paused for clarification can be resumed without a message, to
+	// generate for the paused one
+	resumed := false
+	if req.Message == nil && req.ChatID != "" {
+		chat, err := h.deps.Chats.GetChat(ctx, req.ChatID)
+		if err != nil && !errors.Is(err, storage.ErrChatNotFound) {
+			slog.Error("Failed to load chat", "chat_id", req.ChatID, "error", err)
+			return nil, newGenerationError(http.StatusInternalServerError, "Failed to load chat")
 		}
+		if err == nil && chat.Clarification == nil {
+			return nil, &generationError{Status: http.StatusConflict, Body: gin.H{"error": "the chat is not waiting for answers", "code": ErrCodeNoClarification}}
+		}
+		if err == nil {
+			if message := chat.Clarification.Message(chat); message != nil {
+				paused := *message
+				req.Message = &paused
+				resumed = true
+			}
+		}
+	}
+	if req.Message == nil {
+		return nil, newGenerationError(http.StatusBadRequest, "message is required")
 	}
 
-	return processedMessage, nil
-}
+	params, err := h.deps.Config.ResolveGenerationParams(h.generationModel(false), req.Generation)
+	if err != nil {
+		return nil, &generationError{Status: http.StatusBadRequest, Body: gin.H{"error": err.Error(), "code": "invalid_generation_params"}}
+	}
+	if req.Language != "" && !utils.ValidLocale(req.Language) {
+		return nil, &generationError{Status: http.StatusBadRequest, Body: gin.H{"error": "language must be a locale such as es-MX", "code": "invalid_language"}}
+	}
 
-// CreateChatStream handles streaming chat requests
-func (h *Handler) CreateChatStream(c *gin.Context) {
-	var req model.ChatRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		slog.Error("HTTP request binding failed", "status", http.StatusBadRequest, "error", err.Error())
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	// The paused message was moderated when it was first sent
+	var moderation *services.ModerationResult
+	if !resumed {
+		if moderation, genErr = h.moderateRequest(ctx, tenantSchema, req); genErr != nil {
+			return nil, genErr
+		}
 	}
 
-	slog.Debug("Processing streaming chat request", "message_length", len(req.Message.Content), "chat_id", req.ChatID)
+	// Targeted edits replace one element of the page the client sends
+	var edit *services.SectionEdit
+	if req.EditTarget != nil {
+		if genErr := h.prepareSectionEdit(req, &edit); genErr != nil {
+			return nil, genErr
+		}
+	}
+
+	// Multi-page sites are planned, then generated page by page
+	pages, genErr := h.sitePages(tenantSchema, req)
+	if genErr != nil {
+		return nil, genErr
+	}
 
 	// Determine chat ID
 	chatID := req.ChatID
 	var chat *model.Chat
-	var err error
-
-	ctx := context.Background()
+	var lock *storage.ChatLock
+	created := false
 
 	if chatID == "" {
 		chatID = uuid.New().String()
 		chat = model.NewChat(chatID)
+		created = true
 	} else {
-		chat, err = h.deps.Redis.GetChat(ctx, chatID)
+		// One generation per chat at a time, so a double submit can't race
+		lock, err = h.deps.ChatLocks.AcquireChatLock(ctx, chatID, storage.ChatGenerationLockTTL)
+		if errors.Is(err, storage.ErrChatLocked) {
+			return nil, newGenerationError(http.StatusConflict, "generation in progress")
+		}
 		if err != nil {
-			slog.Warn("Failed to load existing chat, creating new one", "chat_id", chatID, "error", err)
+			// Fall back to optimistic locking in SaveChat
+			slog.Error("Failed to acquire chat lock", "chat_id", chatID, "error", err)
+		}
+		defer func() {
+			if genErr != nil {
+				h.releaseChatLock(ctx, lock)
+			}
+		}()
+
+		chat, err = h.deps.Chats.GetChat(ctx, chatID)
+		switch {
+		case errors.Is(err, storage.ErrChatNotFound):
+			slog.Info("Chat not found, creating new one", "chat_id", chatID)
 			chat = model.NewChat(chatID)
+			created = true
+		case err != nil:
+			// Replacing a chat that failed to load would lose its history
+			slog.Error("Failed to load chat", "chat_id", chatID, "error", err)
+			return nil, newGenerationError(http.StatusInternalServerError, "Failed to load chat")
 		}
 	}
 
-	// Add user message to chat
-	chat.AddMessage(req.Message)
+	// Another tenant's chat can't be continued, nor can a chat without an
+	// owner until an admin assigns it one
+	if created {
+		stampOwner(chat, tenantSchema, userID)
+	} else if h.deps.Config.ChatOwnershipChecks && !ownsChat(chat, tenantSchema, userID) {
+		code := "chat_forbidden"
+		if !chat.HasOwner() {
+			code = "chat_unowned"
+		}
+		slog.Warn("Chat access denied", "chat_id", chatID, "tenant", tenantSchema, "user_id", userID, "code", code)
+		return nil, &generationError{Status: http.StatusForbidden, Body: gin.H{"error": "You don't have access to this chat", "code": code}}
+	}
 
-	// Build prompt
-	chatHistory := chat.GetMessageHistory()
-	var prompt string
+	// A paused chat resumes with the answers in the request's variables
+	clarification := chat.Clarification
+	var answers map[string]string
+	var assumptions []model.ClarificationQuestion
+	if clarification != nil {
+		if answers, assumptions, genErr = resolveClarification(clarification, req, time.Now()); genErr != nil {
+			return nil, genErr
+		}
+		chat.Clarification = nil
+		slog.Info("Resuming chat paused for clarification", "chat_id", chatID, "answered", len(answers), "assumed", len(assumptions))
+	} else if resumed {
+		return nil, &generationError{Status: http.StatusConflict, Body: gin.H{"error": "the chat is not waiting for answers", "code": ErrCodeNoClarification}}
+	}
+	chat.Variables = mergeVariables(chat.Variables, req.Variables)
+
+	// Experiments are assigned once, when the chat is created
+	if created {
+		h.assignPromptVariant(ctx, chat)
+	}
+	variant := chat.Variant
+	if !h.deps.Config.IsPromptExperimentActive(variant) {
+		variant = ""
+	}
+
+	flagChat(chat, moderation)
+
+	baseMessages := len(chat.Messages)
+
+	// Add user message to chat; a resumed chat has it already
+	if !resumed {
+		chat.AddMessage(req.Message)
+	}
+
+	// Build prompt; long histories are compacted before the tokens are
+	// counted below
+	stopPromptBuild := timings.Start("prompt_build")
+	chatHistory := h.messageHistory(ctx, chat)
 
 	var onboardingData *model.OnboardingData
 	if req.Message.Context != nil && req.Message.Context.OnboardingData != nil {
 		onboardingData = req.Message.Context.OnboardingData
 	}
-	prompt = h.deps.PromptBuilder.Build(onboardingData, req.Variables, chatHistory, req.Message.Content)
+	profile := h.loadTenantProfile(ctx, tenantSchema)
+	brandVoice := h.brandVoice(tenantSchema, profile)
+
+	// The request's language wins over the tenant's locale
+	locale := req.Language
+	if locale == "" && profile != nil {
+		locale = profile.Locale
+	}
+	variables := promptVariables(chat.Variables, locale)
+
+	// Updates keep the sections of the page they start from
+	var current *currentSite
+	if req.ChatStage == model.ChatStageUserInput && edit == nil && len(pages) == 0 && h.deps.Config.UpdateCurrentSite {
+		current = h.loadCurrentSite(ctx, tenantSchema, chatID)
+	}
+
+	buildPrompt := func(strictLanguage bool) string {
+		language := utils.LanguageInstruction(locale, strictLanguage)
+		if edit != nil {
+			return utils.BuildSectionEditPrompt(edit.TagName(), edit.Original, req.Message.Content, brandVoice, language)
+		}
+		prompt := h.deps.PromptBuilder.BuildVariant(variant, onboardingData, variables, chatHistory, req.Message.Content, brandVoice, language)
+		if clarification != nil {
+			prompt += utils.ClarificationSection(clarification.Questions, answers)
+		}
+		if current != nil {
+			prompt += utils.CurrentSiteSection(current.sections, h.deps.Config.UpdateKeepUnchangedSections)
+		}
+		return prompt
+	}
+	prompt := buildPrompt(false)
+	var retryPrompt string
+	if h.deps.Config.LanguageRetry && locale != "" {
+		retryPrompt = buildPrompt(true)
+	}
+	stopPromptBuild()
+
+	// Placeholders left in the prompt reach the model as literal braces
+	var diagnostics *GenerationDiagnostics
+	if unresolved := utils.UnresolvedPlaceholders(prompt); len(unresolved) > 0 {
+		slog.Warn("Prompt has unresolved placeholders", "chat_id", chatID, "prompt_variant", variant, "placeholders", unresolved)
+		diagnostics = &GenerationDiagnostics{UnresolvedPlaceholders: unresolved}
+	}
 
 	fmt.Fprintf(os.Stderr, "Prompt built:\n======\n%s\n=====\n", prompt)
 
 	// Count tokens using tiktoken
-	enc, err := tokenizer.Get(TOKEN_MODEL)
-	if err != nil {
-		slog.Error("Failed to initialize tokenizer", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initialize tokenizer"})
-		return
-	}
-	numTokens, err := enc.Count(prompt)
+	stopTokenCount := timings.Start("token_count")
+	numTokens, err := utils.CountTokens(prompt)
+	stopTokenCount()
 	if err != nil {
 		slog.Error("Failed to count tokens", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tokens"})
-		return
+		return nil, newGenerationError(http.StatusInternalServerError, "Failed to count tokens")
 	}
 	slog.Info("Token count calculated", "count", numTokens)
-	if numTokens > MAX_INPUT_TOKENS {
-		errorMsg := fmt.Sprintf("Input exceeds maximum token limit of %d", MAX_INPUT_TOKENS)
-		slog.Error("Token limit exceeded", "limit", MAX_INPUT_TOKENS, "count", numTokens)
-		c.JSON(http.StatusBadRequest, gin.H{"error": errorMsg})
-		return
+
+	// The output gets what the prompt leaves of the context window
+	var tooLong *common.PromptTooLongError
+	if err := h.deps.Config.FitOutputBudget(h.generationModel(false), numTokens, &params); errors.As(err, &tooLong) {
+		slog.Error("Token limit exceeded", "limit", tooLong.Limits.MaxInputTokens, "count", numTokens)
+		return nil, &generationError{Status: http.StatusBadRequest, Body: gin.H{
+			"error":            fmt.Sprintf("Input exceeds maximum token limit of %d", tooLong.Limits.MaxInputTokens),
+			"code":             "prompt_too_long",
+			"prompt_tokens":    numTokens,
+			"max_input_tokens": tooLong.Limits.MaxInputTokens,
+			"context_window":   tooLong.Limits.ContextWindow,
+		}}
 	}
 
 	slog.Info("Full prompt (with context)", "prompt", prompt)
 
-	// Set SSE headers
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("X-Accel-Buffering", "no")
+	// Stop at the tenant's spending caps (mock responses are free)
+	if tenantSchema != "" && !h.deps.Config.MockResponse {
+		spending, err := h.spending.Check(ctx, tenantSchema)
+		if errors.Is(err, account.ErrSpendingCapReached) {
+			slog.Warn("Spending cap reached", "tenant_schema", tenantSchema, "tokens", spending.Tokens, "max_tokens", spending.MaxTokens, "cost_cents", spending.CostCents, "max_cost_cents", spending.MaxCostCents)
+			return nil, &generationError{Status: http.StatusPaymentRequired, Body: gin.H{
+				"error":        "Monthly spending cap reached",
+				"code":         "spending_cap_reached",
+				"tokens":       spending.Tokens,
+				"maxTokens":    spending.MaxTokens,
+				"costCents":    spending.CostCents,
+				"maxCostCents": spending.MaxCostCents,
+				"resetsAt":     spending.ResetsAt,
+			}}
+		}
+		if err != nil {
+			// Don't block generation on spending bookkeeping failures
+			slog.Error("Failed to check spending caps", "tenant_schema", tenantSchema, "error", err)
+		}
+	}
 
-	requestCtx := c.Request.Context()
+	// Reserve a generation from the tenant's quota (mock responses are free)
+	var reservation *account.QuotaReservation
+	if tenantSchema != "" && !h.deps.Config.MockResponse {
+		var quotaStatus *account.QuotaStatus
+		reservation, quotaStatus, err = h.quota.ReserveGeneration(ctx, tenantSchema)
+		if errors.Is(err, account.ErrQuotaExceeded) {
+			status := http.StatusPaymentRequired
+			if quotaStatus.Paid {
+				status = http.StatusTooManyRequests
+			}
+			slog.Warn("Generation quota exceeded", "tenant_schema", tenantSchema, "used", quotaStatus.Used, "limit", quotaStatus.Limit)
+			return nil, &generationError{Status: status, Body: gin.H{
+				"error":    "Generation quota exceeded for this billing period",
+				"used":     quotaStatus.Used,
+				"limit":    quotaStatus.Limit + quotaStatus.Granted,
+				"resetsAt": quotaStatus.ResetsAt,
+			}}
+		}
+		if err != nil {
+			// Don't block generation on quota bookkeeping failures
+			slog.Error("Failed to reserve generation quota", "tenant_schema", tenantSchema, "error", err)
+			reservation = nil
+		}
+	}
 
-	sendSSEEvent(c, "start", fmt.Sprintf(`{"chat_id":"%s"}`, chatID))
+	// Charged generations need the credits up front; they are deducted once
+	// the reply is in
+	if cost := h.deps.Config.ChatGenerationCredits; cost > 0 && tenantSchema != "" && !h.deps.Config.MockResponse {
+		enough, err := account.HasCredits(ctx, h.deps, tenantSchema, account.CreditTypeBasic, cost)
+		if err != nil {
+			slog.Error("Failed to check credits", "tenant_schema", tenantSchema, "error", err)
+			reservation.Release(ctx)
+			return nil, newGenerationError(http.StatusInternalServerError, "Failed to check credits")
+		}
+		if !enough {
+			reservation.Release(ctx)
+			return nil, &generationError{Status: http.StatusPaymentRequired, Body: gin.H{
+				"error":    "Not enough credits for a generation",
+				"code":     "insufficient_credits",
+				"required": cost,
+			}}
+		}
+	}
 
 	keywords := []string{}
 
@@ -194,7 +522,7 @@ func (h *Handler) CreateChatStream(c *gin.Context) {
 			keywords = append(keywords, keyword)
 		}
 
-		if od.BusinessTypeData.Label != "" {
+		if od.BusinessTypeData != nil && od.BusinessTypeData.Label != "" {
 			keyword := common.SafeString(od.BusinessTypeData.Label)
 			keywords = append(keywords, keyword)
 		}
@@ -202,59 +530,332 @@ func (h *Handler) CreateChatStream(c *gin.Context) {
 
 	slog.Info("Request keywords (used for mock/saved response filenames)", "keywords", keywords)
 
-	isMockResponse := false
-	var assistantMessage string
+	var placeholders processors.PlaceholderValues
+	var contact processors.ContactDetails
+	if profile != nil {
+		placeholders = processors.PlaceholderValues{
+			BusinessName: profile.BusinessName,
+			Phone:        profile.Phone,
+			Email:        profile.Email,
+			Address:      profile.Address,
+		}
+		contact = processors.ContactDetails{
+			Phone:   profile.Phone,
+			Email:   profile.Email,
+			Address: profile.Address,
+			Hours:   profile.Hours(),
+			Locale:  profile.Locale,
+		}
+	}
+	if onboardingData != nil && onboardingData.BusinessName != "" {
+		placeholders.BusinessName = onboardingData.BusinessName
+	}
+	var motif model.BusinessMotif
+	if onboardingData != nil {
+		motif = onboardingData.SelectedMotif
+	}
 
-	if h.deps.Config.MockResponse {
-		mockFile := ".config/mock_content.txt"
-		if len(keywords) > 0 {
-			keywordPart := strings.Join(keywords, "_")
-			mockFileCandidate := fmt.Sprintf(".config/mocks/mock_content_%s.html", keywordPart)
-			slog.Info("Looking for keyword-specific mock file", "file", mockFileCandidate)
-			if _, err := os.Stat(mockFileCandidate); err == nil {
-				mockFile = mockFileCandidate
+	return &generation{
+		req:          req,
+		tenantSchema: tenantSchema,
+		userID:       userID,
+		chatID:       chatID,
+		chat:         chat,
+		prompt:       prompt,
+		keywords:     keywords,
+		reservation:  reservation,
+		lock:         lock,
+		startedAt:    time.Now(),
+		variant:      variant,
+		params:       params,
+		locale:       locale,
+		retryPrompt:  retryPrompt,
+		baseMessages: baseMessages,
+		edit:         edit,
+		pages:        pages,
+		current:      current,
+
+		clarification: clarification,
+		assumptions:   assumptions,
+
+		timings:      timings,
+		diagnostics:  diagnostics,
+		promptTokens: numTokens,
+
+		placeholders: placeholders,
+		contact:      contact,
+		motif:        motif,
+		brand:        h.deps.BrandAssets(ctx, tenantSchema),
+	}, nil
+}
+
+// prepareSectionEdit finds the element a targeted edit replaces. Targets
+// matching no element or several get 422.
+func (h *Handler) prepareSectionEdit(req model.ChatRequest, edit **services.SectionEdit) *generationError {
+	if req.CurrentHTML == "" {
+		return newGenerationError(http.StatusBadRequest, "current_html is required with edit_target")
+	}
+
+	var err error
+	*edit, err = services.NewSectionEdit(req.CurrentHTML, req.EditTarget)
+	switch {
+	case errors.Is(err, services.ErrEditTargetNotFound):
+		return &generationError{Status: http.StatusUnprocessableEntity, Body: gin.H{"error": err.Error(), "code": "edit_target_not_found"}}
+	case errors.Is(err, services.ErrEditTargetAmbiguous):
+		return &generationError{Status: http.StatusUnprocessableEntity, Body: gin.H{"error": err.Error(), "code": "edit_target_ambiguous"}}
+	case err != nil:
+		return &generationError{Status: http.StatusBadRequest, Body: gin.H{"error": err.Error(), "code": "invalid_edit_target"}}
+	}
+	return nil
+}
+
+// assignPromptVariant assigns a new chat to a prompt experiment and counts
+// the assignment
+func (h *Handler) assignPromptVariant(ctx context.Context, chat *model.Chat) {
+	chat.Variant = common.AssignPromptVariant(chat.ID, h.deps.Config.PromptExperiments)
+	if chat.Variant == "" {
+		return
+	}
+	slog.Info("Assigned prompt experiment", "chat_id", chat.ID, "variant", chat.Variant)
+	if err := h.deps.Redis.RecordExperimentChat(ctx, chat.Variant); err != nil {
+		slog.Error("Failed to record experiment assignment", "variant", chat.Variant, "error", err)
+	}
+}
+
+func (h *Handler) releaseChatLock(ctx context.Context, lock *storage.ChatLock) {
+	if err := lock.Release(ctx); err != nil {
+		slog.Error("Failed to release chat lock", "error", err)
+	}
+}
+
+// saveGeneration saves the chat. If another request saved it since it was
+// loaded, this generation's messages are merged into the latest copy. A
+// chat that can't be saved is spilled for a background retry instead, when
+// the spill is on.
+func (h *Handler) saveGeneration(ctx context.Context, gen *generation) error {
+	err := h.mergeGeneration(ctx, gen)
+	// A retry can't give a chat the tenant it was saved without, bring back
+	// a deleted chat or save into another owner's
+	if err == nil || h.deps.ChatSpill == nil || errors.Is(err, sections.ErrChatTenantRequired) ||
+		errors.Is(err, storage.ErrChatNotFound) || errors.Is(err, errChatNotOwned) {
+		return err
+	}
+	if spillErr := h.deps.ChatSpill.Spill(ctx, gen.chat, gen.baseMessages, err); spillErr != nil {
+		slog.Error("Failed to spill chat", "chat_id", gen.chatID, "error", spillErr)
+		return err
+	}
+	return nil
+}
+
+// mergeGeneration saves the chat, merging this generation's messages into
+// the latest copy on a conflict. The latest copy must still belong to the
+// requester, and a chat deleted in the meantime isn't recreated.
+func (h *Handler) mergeGeneration(ctx context.Context, gen *generation) error {
+	err := h.deps.Chats.SaveChat(ctx, gen.chat)
+	if !errors.Is(err, storage.ErrChatConflict) {
+		return err
+	}
+
+	added := gen.chat.Messages[gen.baseMessages:]
+	slog.Warn("Chat modified concurrently, merging messages", "chat_id", gen.chatID, "added", len(added))
+
+	chat, err := storage.UpdateChat(ctx, h.deps.Chats, gen.chatID, func(chat *model.Chat) error {
+		if h.deps.Config.ChatOwnershipChecks && !ownsChat(chat, gen.tenantSchema, gen.userID) {
+			return errChatNotOwned
+		}
+		for i := range added {
+			chat.AddMessage(&added[i])
+		}
+		for _, category := range gen.chat.ModerationFlags {
+			if !slices.Contains(chat.ModerationFlags, category) {
+				chat.ModerationFlags = append(chat.ModerationFlags, category)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		slog.Info("Using mock response", "file", mockFile)
-		if _, err := os.Stat(mockFile); err == nil {
-			data, err := os.ReadFile(mockFile)
-			if err != nil {
-				slog.Error("Failed to read mock response file", "error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read mock response file"})
-				return
-			}
-			assistantMessage = string(data)
-			isMockResponse = true
+	gen.chat = chat
+	return nil
+}
+
+// loadTenantProfile returns the tenant's profile, or nil without a tenant or
+// profile
+func (h *Handler) loadTenantProfile(ctx context.Context, tenantSchema string) *models.TenantProfile {
+	if tenantSchema == "" || h.deps.DB == nil {
+		return nil
+	}
+
+	var profile models.TenantProfile
+	err := h.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).First(&profile).Error
+	})
+	if err != nil {
+		return nil
+	}
+	return &profile
+}
+
+// brandVoice returns the tenant's sanitized brand voice instructions, if any
+func (h *Handler) brandVoice(tenantSchema string, profile *models.TenantProfile) string {
+	if profile == nil || strings.TrimSpace(profile.BrandVoice) == "" {
+		return ""
+	}
+
+	brandVoice, removed := utils.SanitizeInstructions(profile.BrandVoice, h.deps.Config.BrandVoiceDenylist)
+	if len(removed) > 0 {
+		slog.Warn("Removed denied lines from brand voice", "tenant_schema", tenantSchema, "removed", removed)
+	}
+	slog.Info("Including tenant brand voice in prompt", "tenant_schema", tenantSchema, "length", len(brandVoice))
+
+	return brandVoice
+}
+
+// loadMockResponse returns the mock content for the generation's stage and
+// keywords, if any, filled in with its onboarding data and variables
+func (h *Handler) loadMockResponse(gen *generation) (string, bool, error) {
+	var onboardingData *model.OnboardingData
+	if gen.req.Message.Context != nil {
+		onboardingData = gen.req.Message.Context.OnboardingData
+	}
+	return h.mocks.Load(services.MockRequest{
+		Stage:          gen.req.ChatStage,
+		Keywords:       gen.keywords,
+		OnboardingData: onboardingData,
+		Variables:      promptVariables(gen.chat.Variables, gen.locale),
+	})
+}
+
+// streamMockResponse streams mock content like a model reply: thinking as
+// configured by thinking_mode, then the content in content events
+func (h *Handler) streamMockResponse(requestCtx context.Context, content string, sendSSEEvent SendSSEEvent) error {
+	sendSSEEvent("start", `{"message":"Starting response generation..."}`)
+
+	thinking := services.NewThinkingStream(h.deps.Config, func(data string) {
+		sendSSEEvent("thinking", data)
+	})
+	thinking.Start(requestCtx)
+	defer thinking.Stop()
+
+	return h.mocks.Stream(requestCtx, content, func(event ai.StreamEvent) error {
+		if event.Type == "thinking" {
+			thinking.Add(event.Content)
+			return nil
 		}
-	} else {
-		fullContent := strings.Builder{}
-		err = h.streamVertexResponse(c, requestCtx, prompt, chatID, req.ChatStage, &fullContent, sendSSEEvent)
+		eventJSON, _ := json.Marshal(map[string]string{
+			"type":    event.Type,
+			"content": event.Content,
+		})
+		sendSSEEvent(event.Type, string(eventJSON))
+		return nil
+	})
+}
 
-		if err == nil {
-			assistantMessage = fullContent.String()
+// failGeneration returns the reserved quota after a failed generation
+func (h *Handler) failGeneration(ctx context.Context, gen *generation) {
+	h.releaseQuota(ctx, gen)
+	countGeneration(gen, outcomeFailed)
+}
+
+// releaseQuota returns the quota reserved for the generation
+func (h *Handler) releaseQuota(ctx context.Context, gen *generation) {
+	if err := gen.reservation.Release(ctx); err != nil {
+		slog.Error("Failed to release generation quota", "error", err)
+	}
+}
+
+// completeGeneration commits quota, post-processes and persists the assistant
+// message, and returns the response sent to the client
+// progress, when set, is called as each processor starts, and onSection as
+// each section is processed.
+func (h *Handler) completeGeneration(ctx context.Context, requestCtx context.Context, gen *generation, assistantMessage string, isMockResponse bool, progress func(name string), onSection func(services.ProcessedSection)) (*model.ChatResponse, error) {
+	gen.addReplyUsage(gen.promptTokens, assistantMessage)
+	assistantMessage, languageMismatch := h.checkLanguage(requestCtx, gen, assistantMessage, isMockResponse, progress)
+
+	// A reply that can't be spliced into the page fails the generation
+	if gen.edit != nil {
+		if err := gen.edit.Replace(assistantMessage); err != nil {
+			h.failGeneration(ctx, gen)
+			return nil, err
 		}
 	}
 
-	if err != nil {
-		slog.Error("Streaming failed", "error", err)
-		sendSSEEvent(c, "error", fmt.Sprintf(`{"error":"%s"}`, err.Error()))
-		return
+	if err := gen.reservation.Commit(ctx); err != nil {
+		slog.Error("Failed to commit generation quota", "error", err)
+	}
+	if cost := h.deps.Config.ChatGenerationCredits; cost > 0 && gen.tenantSchema != "" && !isMockResponse {
+		// Balances are checked before generating; a tenant that spent its
+		// credits meanwhile keeps this reply
+		if err := account.ChargeGeneration(ctx, h.deps, gen.tenantSchema, gen.userID, gen.chatID, cost); err != nil {
+			slog.Error("Failed to charge credits for generation", "tenant_schema", gen.tenantSchema, "chat_id", gen.chatID, "error", err)
+		}
+	}
+	countGeneration(gen, outcomeCompleted)
+	if gen.variant != "" {
+		if err := h.deps.Redis.RecordExperimentGeneration(ctx, gen.variant); err != nil {
+			slog.Error("Failed to record experiment generation", "variant", gen.variant, "error", err)
+		}
 	}
 
+	var images *processors.ImageManifest
+	var report []common.ProcessorReport
 	if !isMockResponse || h.deps.Config.PostProcessMockResponses {
-		assistantMessage, err = h.postProcessAssistantMessage(requestCtx, assistantMessage)
-		if err != nil {
-			slog.Error("Post-processing assistant message failed", "error", err)
-			sendSSEEvent(c, "error", fmt.Sprintf(`{"error":"%s"}`, err.Error()))
-			return
+		stopPostprocess := gen.timings.Start("postprocess_total")
+		processCtx := services.WithTenantSchema(requestCtx, gen.tenantSchema)
+		processCtx, images = processors.WithImageManifest(processCtx)
+		processCtx = processors.WithPlaceholderValues(processCtx, gen.placeholders)
+		processCtx = processors.WithContactDetails(processCtx, gen.contact)
+		processCtx = processors.WithImageMotif(processCtx, gen.motif)
+		processCtx = processors.WithBrandAssets(processCtx, gen.brand)
+		if gen.truncated != "" {
+			processCtx = services.WithTruncatedOutput(processCtx)
+		}
+		if gen.edit != nil {
+			// The rest of the page was processed when it was generated
+			report = gen.edit.Process(processCtx, h.deps.ProcessorsSvc)
+		} else {
+			assistantMessage, report = h.postProcessAssistantMessage(processCtx, assistantMessage, progress, onSection)
 		}
+		stopPostprocess()
+		gen.recordProcessorFailures("", report)
 	}
 
-	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, assistantMessage)
+	var sectionEdit *model.SectionEditDiff
+	if gen.edit != nil {
+		sectionEdit = &model.SectionEditDiff{Before: gen.edit.Original, After: gen.edit.Current()}
+		assistantMessage = gen.edit.Document()
+	}
+
+	// Updates report what changed and keep the sections left unchanged
+	var sectionDiff *model.SectionDiff
+	if gen.current != nil {
+		assistantMessage, sectionDiff = h.applyCurrentSite(gen, assistantMessage)
+	}
+
+	var siteMetadata *model.SiteMetadata
+	var siteMetadataUsage *model.SiteMetadataUsage
+	if h.siteMetadataEnabled(gen, isMockResponse) {
+		if progress != nil {
+			progress("site_metadata")
+		}
+		siteMetadata, siteMetadataUsage = h.generateSiteMetadata(requestCtx, gen, assistantMessage)
+		if siteMetadataUsage != nil {
+			gen.addUsage(siteMetadataUsage.PromptTokens, siteMetadataUsage.CompletionTokens)
+		}
+	}
+
+	// The response carries the stored message's ID so its content can be
+	// fetched again by reference
+	message := model.NewChatMessage(model.ChatMessageRoleAssistant, assistantMessage)
+	message.Model = h.generationModel(isMockResponse)
+	message.SiteMetadata = siteMetadata
+	gen.chat.AddMessage(message)
 
 	// Save chat to Redis
-	if err := h.deps.Redis.SaveChat(ctx, chat); err != nil {
+	stopPersistence := gen.timings.Start("persistence")
+	if err := h.saveGeneration(ctx, gen); err != nil {
 		slog.Error("Failed to save chat", "error", err)
 	}
 
@@ -262,98 +863,501 @@ func (h *Handler) CreateChatStream(c *gin.Context) {
 		fmt.Fprintf(os.Stderr, "\n\n%s\n\n", assistantMessage)
 	}
 
-	// Send done event
-	response := model.ChatResponse{
-		ChatID:    chatID,
-		ChatStage: req.ChatStage,
-		Message: model.ChatMessage{
-			ID:        common.RandomID(),
-			Role:      "assistant",
-			Content:   assistantMessage,
-			Timestamp: time.Now().Unix(),
-		},
+	draft := h.saveDraft(ctx, gen, assistantMessage, siteMetadata)
+	stopPersistence()
+
+	// The event references the page rather than carrying its HTML
+	h.deps.Webhooks.Emit(ctx, gen.tenantSchema, webhooks.EventChatCompleted, gin.H{
+		"chatId":      gen.chatID,
+		"messageId":   message.ID,
+		"chatStage":   gen.req.ChatStage,
+		"model":       message.Model,
+		"language":    gen.locale,
+		"contentPath": "/api/v1/chat/" + gen.chatID + "/content/" + message.ID,
+		"draft":       draft,
+	})
+	h.notifyGenerationCompleted(ctx, gen, message)
+	h.recordUsage(ctx, gen, message, isMockResponse, report)
+
+	response := &model.ChatResponse{
+		ChatID:    gen.chatID,
+		ChatStage: gen.req.ChatStage,
+		Message:   *message,
 		Timestamp: time.Now().Unix(),
+		Images:    images.Images(),
+
+		ProcessingReport: report,
+		SectionEdit:      sectionEdit,
+		SectionDiff:      sectionDiff,
+		Generation:       &gen.params,
+		Draft:            draft,
+		Language:         gen.locale,
+		LanguageMismatch: languageMismatch,
+
+		SiteMetadata:      siteMetadata,
+		SiteMetadataUsage: siteMetadataUsage,
+		Assumptions:       gen.assumptions,
+
+		Truncated:       gen.truncated != "",
+		TruncatedReason: gen.truncated,
+	}
+
+	if h.deps.Config.SaveResponses && h.deps.Responses != nil {
+		h.saveResponse(ctx, gen, assistantMessage, isMockResponse)
 	}
 
-	if h.deps.Config.SaveResponses {
-		responseDir := ".var/saved_responses"
-		canSave := false
-		if _, err := os.Stat(responseDir); os.IsNotExist(err) {
-			err := os.MkdirAll(responseDir, 0755)
-			if err != nil {
-				slog.Error("Failed to create responses directory", "dir", responseDir, "error", err)
-				canSave = false
+	return response, nil
+}
+
+// generationModel returns the name of the model generating responses, or
+// mock for mock responses
+func (h *Handler) generationModel(isMockResponse bool) string {
+	if isMockResponse {
+		return "mock"
+	}
+	modelName, _ := h.deps.Config.GetDefaultModel()
+	return modelName
+}
+
+// saveResponse records the raw assistant response with its generation
+// metadata so it can be browsed and replayed from the admin endpoints
+func (h *Handler) saveResponse(ctx context.Context, gen *generation, content string, isMockResponse bool) {
+	id, err := h.deps.Responses.Save(ctx, gen.keywords, content, services.SavedResponseMetadata{
+		ChatID:       gen.chatID,
+		Model:        h.generationModel(isMockResponse),
+		TenantSchema: gen.tenantSchema,
+		Variant:      gen.variant,
+		DurationMs:   time.Since(gen.startedAt).Milliseconds(),
+		Generation:   &gen.params,
+	})
+	if err != nil {
+		slog.Error("Failed to save response", "id", id, "error", err)
+		return
+	}
+	slog.Info("Saved response", "id", id)
+}
+
+// runStream streams a prepared generation to the client through sendEvent,
+// from the start event through to done or error
+func (h *Handler) runStream(c *gin.Context, ctx context.Context, requestCtx context.Context, gen *generation, sendEvent SendSSEEvent) {
+	defer h.releaseChatLock(ctx, gen.lock)
+	requestCtx = common.WithTimings(requestCtx, gen.timings)
+
+	// Identical requests get the done event; anything else lets them
+	// generate again
+	if gen.dedup != nil {
+		var done []byte
+		defer func() { gen.dedup.finish(ctx, done) }()
+		send := sendEvent
+		sendEvent = func(eventType, data string) {
+			if eventType == "done" {
+				done = []byte(data)
 			}
-		} else {
-			canSave = true
+			send(eventType, data)
 		}
+	}
 
-		if canSave {
-			responsePrefix := ".var/saved_responses/response"
-			if len(keywords) > 0 {
-				keywordPart := strings.Join(keywords, "_")
-				responsePrefix = fmt.Sprintf(".var/saved_responses/response_%s", keywordPart)
-			}
-			timestamp := time.Now().Unix() + (time.Now().UnixNano()%1e6)*1000
-			responseFile := fmt.Sprintf("%s_%d.html", responsePrefix, timestamp)
-			err := os.WriteFile(responseFile, []byte(assistantMessage), 0644)
-			if err != nil {
-				slog.Error("Failed to save response to file", "file", responseFile, "error", err)
-			} else {
-				slog.Info("Saved response to file", "file", responseFile)
-			}
+	sendEvent("start", fmt.Sprintf(`{"chat_id":"%s"}`, gen.chatID))
+
+	// A generation missing facts pauses until the client answers them
+	if response := h.clarify(ctx, requestCtx, gen); response != nil {
+		sendEvent("clarification", clarificationEvent(response))
+		return
+	}
+
+	// Multi-page sites send page events instead of streaming the model
+	if len(gen.pages) > 0 {
+		response, err := h.runSite(ctx, requestCtx, gen, sendEvent, nil)
+		if err != nil {
+			slog.Error("Site generation failed", "chat_id", gen.chatID, "error", err)
+			errorJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+			sendEvent("error", string(errorJSON))
+			return
+		}
+		sendEvent("done", string(h.doneEvent(response, gen)))
+		h.startTitleGeneration(gen.tenantSchema, gen.chat)
+		return
+	}
+
+	isMockResponse := false
+	var assistantMessage string
+	var err error
+
+	if h.deps.Config.MockResponse {
+		assistantMessage, isMockResponse, err = h.loadMockResponse(gen)
+		if err != nil {
+			slog.Error("Failed to read mock response file", "error", err)
+			sendEvent("error", `{"error":"Failed to read mock response file"}`)
+			return
+		}
+		if isMockResponse {
+			err = h.streamMockResponse(requestCtx, assistantMessage, sendEvent)
+		}
+	} else {
+		fullContent := strings.Builder{}
+		guard := h.newOutputGuard(gen)
+		err = h.streamVertexResponse(c, requestCtx, gen.prompt, gen.params, gen.chatID, gen.req.ChatStage, &fullContent, guard, sendEvent)
+
+		if err == nil {
+			assistantMessage = gen.truncateOutput(guard, fullContent.String())
+		}
+	}
+
+	if err != nil {
+		slog.Error("Streaming failed", "error", err)
+		h.failGeneration(ctx, gen)
+		errorEvent := map[string]string{"error": err.Error()}
+		var tooLarge *ai.EventTooLargeError
+		if errors.As(err, &tooLarge) {
+			errorEvent["code"] = "stream_event_too_large"
+		}
+		errorJSON, _ := json.Marshal(errorEvent)
+		sendEvent("error", string(errorJSON))
+		return
+	}
+
+	progress := func(name string) {
+		progressJSON, _ := json.Marshal(map[string]string{
+			"processor": name,
+			"message":   "running " + name,
+		})
+		sendEvent("processing", string(progressJSON))
+	}
+
+	var onSection func(services.ProcessedSection)
+	if gen.req.StreamProcessing {
+		onSection = func(section services.ProcessedSection) {
+			sectionJSON, _ := json.Marshal(section)
+			sendEvent("section_processed", string(sectionJSON))
 		}
 	}
 
-	doneJSON, _ := json.Marshal(map[string]interface{}{
-		"type":     "done",
-		"response": response,
+	response, err := h.completeGeneration(ctx, requestCtx, gen, assistantMessage, isMockResponse, progress, onSection)
+	if err != nil {
+		slog.Error("Post-processing assistant message failed", "error", err)
+		sendEvent("error", fmt.Sprintf(`{"error":"%s"}`, err.Error()))
+		return
+	}
+
+	sendEvent("done", string(h.doneEvent(response, gen)))
+
+	h.startTitleGeneration(gen.tenantSchema, gen.chat)
+}
+
+// CreateChatStream handles streaming chat requests
+func (h *Handler) CreateChatStream(c *gin.Context) {
+	var req model.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("HTTP request binding failed", "status", http.StatusBadRequest, "error", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+	ctx := common.WithTimings(services.WithTenantSchema(context.Background(), tenantSchema), common.TimingsFromContext(c.Request.Context()))
+
+	dedup, replay, err := h.beginDedup(c.Request.Context(), tenantSchema, dedupKindStream, req)
+	if err != nil {
+		return
+	}
+	if replay != nil {
+		sse := startSSE(c)
+		defer sse.Close()
+		replayDoneEvent(replay, sse.SendEvent)
+		return
+	}
+
+	gen, genErr := h.prepareGeneration(ctx, tenantSchema, userID, req)
+	if genErr != nil {
+		dedup.finish(ctx, nil)
+		c.JSON(genErr.Status, i18n.Localize(c, genErr.Body))
+		return
+	}
+	gen.dedup = dedup
+
+	slog.Debug("Processing streaming chat request", "message_length", len(gen.req.Message.Content), "chat_id", gen.chatID)
+
+	sse := startSSE(c)
+	defer sse.Close()
+
+	h.runStream(c, ctx, c.Request.Context(), gen, sse.SendEvent)
+}
+
+// startSSE sets the event stream headers and returns the writer all events
+// go through; the thinking stream sends from its own goroutine
+func startSSE(c *gin.Context) *utils.SSEWriter {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	return utils.NewSSEWriter(c.Writer)
+}
+
+// generateContent produces the full assistant message without streaming,
+// using the client's non-streaming call when it has one
+func (h *Handler) generateContent(ctx context.Context, prompt string, params common.GenerationParams) (string, error) {
+	if client, ok := h.deps.VertexClient.(sections.VertexCompletionClient); ok {
+		return client.GenerateContent(ctx, prompt, params)
+	}
+
+	fullContent := strings.Builder{}
+	err := h.deps.VertexClient.GenerateContentStream(ctx, prompt, params, func(event sections.StreamEvent) error {
+		if event.Type == "content" {
+			fullContent.WriteString(event.Content)
+		}
+		return nil
 	})
-	h.logger.Info("Sending done event")
-	sendSSEEvent(c, "done", string(doneJSON))
+	if err != nil {
+		return "", err
+	}
+	return fullContent.String(), nil
+}
+
+// runCompletion generates the reply without streaming and post-processes
+// it, for the completion endpoint and async generation jobs. A failed
+// generation releases its quota. The caller releases the chat lock.
+func (h *Handler) runCompletion(ctx, genCtx context.Context, gen *generation, progress func(name string)) (*model.ChatResponse, error) {
+	if response := h.clarify(ctx, genCtx, gen); response != nil {
+		return response, nil
+	}
+
+	if len(gen.pages) > 0 {
+		response, err := h.runSite(ctx, genCtx, gen, nil, progress)
+		if err == nil {
+			h.startTitleGeneration(gen.tenantSchema, gen.chat)
+		}
+		return response, err
+	}
+
+	var assistantMessage string
+	var isMockResponse bool
+	var err error
+
+	if h.deps.Config.MockResponse {
+		assistantMessage, isMockResponse, err = h.loadMockResponse(gen)
+		if err == nil && isMockResponse {
+			// Only the latency is simulated; there's no one to stream to
+			err = h.mocks.Stream(genCtx, assistantMessage, func(ai.StreamEvent) error { return nil })
+		}
+	} else {
+		stopModel := gen.timings.Start("model_stream")
+		assistantMessage, err = h.generateReply(genCtx, gen)
+		stopModel()
+	}
+
+	if err != nil {
+		h.failGeneration(ctx, gen)
+		return nil, err
+	}
+
+	response, err := h.completeGeneration(ctx, genCtx, gen, assistantMessage, isMockResponse, progress, nil)
+	if err == nil {
+		h.startTitleGeneration(gen.tenantSchema, gen.chat)
+	}
+	return response, err
 }
 
-// sendSSEEvent sends a Server-Sent Event
-func sendSSEEvent(c *gin.Context, eventType, data string) {
-	c.Writer.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, data)))
-	c.Writer.Flush()
+// CreateChatCompletion handles non-streaming chat requests, returning a single
+// ChatResponse. If the generation outlives the configured timeout the client
+// gets a 504 with the chat ID; the generation keeps running and its result can
+// be fetched later with GET /api/v1/chat/:id.
+func (h *Handler) CreateChatCompletion(c *gin.Context) {
+	var req model.ChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		slog.Error("HTTP request binding failed", "status", http.StatusBadRequest, "error", err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+	ctx := common.WithTimings(services.WithTenantSchema(context.Background(), tenantSchema), common.TimingsFromContext(c.Request.Context()))
+
+	dedup, replay, err := h.beginDedup(c.Request.Context(), tenantSchema, dedupKindComplete, req)
+	if err != nil {
+		return
+	}
+	if replay != nil {
+		var response model.ChatResponse
+		if err := json.Unmarshal(replay, &response); err != nil {
+			slog.Error("Failed to unmarshal stored chat response", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay identical request"})
+			return
+		}
+		response.Deduplicated = true
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	gen, genErr := h.prepareGeneration(ctx, tenantSchema, userID, req)
+	if genErr != nil {
+		dedup.finish(ctx, nil)
+		c.JSON(genErr.Status, i18n.Localize(c, genErr.Body))
+		return
+	}
+
+	slog.Debug("Processing chat completion request", "message_length", len(gen.req.Message.Content), "chat_id", gen.chatID)
+
+	type result struct {
+		response *model.ChatResponse
+		err      error
+	}
+	done := make(chan result, 1)
+
+	// Detached from the request so a client timeout doesn't lose the generation
+	genCtx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), COMPLETION_MAX_DURATION)
+	genCtx = common.WithTimings(genCtx, gen.timings)
+
+	go func() {
+		defer cancel()
+		defer h.releaseChatLock(ctx, gen.lock)
+
+		response, err := h.runCompletion(ctx, genCtx, gen, nil)
+		var stored []byte
+		if err == nil {
+			stored, _ = json.Marshal(response)
+		}
+		dedup.finish(ctx, stored)
+		done <- result{response: response, err: err}
+	}()
+
+	timeout := time.Duration(h.deps.Config.ChatCompleteTimeoutSeconds) * time.Second
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			slog.Error("Chat completion failed", "chat_id", gen.chatID, "error", res.err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": res.err.Error(), "chat_id": gen.chatID})
+			return
+		}
+		c.JSON(http.StatusOK, res.response)
+	case <-time.After(timeout):
+		slog.Warn("Chat completion timed out, generation continues in background", "chat_id", gen.chatID, "timeout", timeout)
+		c.JSON(http.StatusGatewayTimeout, gin.H{
+			"error":   "Generation did not finish in time; poll the chat for the result",
+			"chat_id": gen.chatID,
+		})
+	}
 }
 
-// GetChat retrieves a chat by ID
+// GetChat retrieves a chat by ID. Message contents are cut to a preview
+// with a content_ref unless the request passes ?include=content or
+// chat_full_content is set.
 func (h *Handler) GetChat(c *gin.Context) {
-	chatID := c.Param("id")
-	if chatID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required"})
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
 		return
 	}
 
-	ctx := context.Background()
-	chat, err := h.deps.Redis.GetChat(ctx, chatID)
-	if err != nil {
-		slog.Error("Failed to get chat", "chat_id", chatID, "error", err)
+	if h.deps.Config.ChatFullContent || includesContent(c) {
+		c.JSON(http.StatusOK, chat)
+		return
+	}
+	c.JSON(http.StatusOK, withContentPreviews(chat, h.deps.Config.ChatContentPreviewRunes))
+}
+
+// GetChatMeta retrieves a chat summary without its messages
+func (h *Handler) GetChatMeta(c *gin.Context) {
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, chat.Meta())
+}
+
+// UpdateChatRequest holds the editable chat fields
+type UpdateChatRequest struct {
+	Title string `json:"title" binding:"required,max=80"`
+}
+
+// UpdateChat renames a chat
+func (h *Handler) UpdateChat(c *gin.Context) {
+	var req UpdateChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing := h.loadAuthorizedChat(c)
+	if existing == nil {
+		return
+	}
+	chatID := existing.ID
+
+	// The owner is checked again on the copy being saved, which may have
+	// changed since it was authorized
+	chat, err := storage.UpdateChat(chatContext(c.Request.Context(), c), h.deps.Chats, chatID, func(chat *model.Chat) error {
+		if !h.mayAccessChat(c, chat) {
+			return errChatNotOwned
+		}
+		chat.Title = strings.TrimSpace(req.Title)
+		return nil
+	})
+	if errors.Is(err, storage.ErrChatNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
 		return
 	}
+	if errors.Is(err, errChatNotOwned) {
+		i18n.Error(c, http.StatusForbidden, "chat_forbidden", "You don't have access to this chat")
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to save chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
 
-	c.JSON(http.StatusOK, chat)
+	c.JSON(http.StatusOK, chat.Meta())
 }
 
-// DeleteChat deletes a chat by ID
+// DeleteChat moves a chat to the trash, from which it can be restored for
+// chat_trash_retention_days
 func (h *Handler) DeleteChat(c *gin.Context) {
-	chatID := c.Param("id")
-	if chatID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required"})
+	chat := h.loadAuthorizedChat(c)
+	if chat == nil {
 		return
 	}
+	chatID := chat.ID
 
-	ctx := context.Background()
-	if err := h.deps.Redis.DeleteChat(ctx, chatID); err != nil {
+	ctx := chatContext(context.Background(), c)
+	if err := h.deps.Chats.TrashChat(ctx, chatID, h.deps.Config.ChatTrashRetention()); err != nil {
 		slog.Error("Failed to delete chat", "chat_id", chatID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Chat moved to the trash"})
+}
+
+// notifyGenerationCompleted sends the generation_completed notification,
+// which tenants opt in to
+func (h *Handler) notifyGenerationCompleted(ctx context.Context, gen *generation, message *model.ChatMessage) {
+	title := "Your site is ready"
+	if gen.chat.Title != "" {
+		title = fmt.Sprintf("%q is ready", gen.chat.Title)
+	}
+	err := h.deps.Notifications.Notify(ctx, gen.tenantSchema, notifications.Notification{
+		Type:  notifications.TypeGenerationCompleted,
+		Title: title,
+		Body:  "A new version of your site has been generated.",
+		Metadata: map[string]any{
+			"chatId":    gen.chatID,
+			"messageId": message.ID,
+		},
+	})
+	if err != nil {
+		h.logger.Error("Failed to send generation notification", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "error", err)
+	}
+}
+
+// chatContext attaches the request's tenant to ctx for tenant-scoped chat
+// stores
+func chatContext(ctx context.Context, c *gin.Context) context.Context {
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	return services.WithTenantSchema(ctx, tenantSchema)
 }
 
 // RegisterRoutes registers chat-related routes
@@ -362,10 +1366,80 @@ func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager
 
 	// Tenant-scoped chat routes
 	tenantRoutes := r.Group("/api/v1/chat")
+	tenantRoutes.Use(middleware.ServerTimingMiddleware())
 	tenantRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	tenantRoutes.Use(auth.MaintenanceMiddleware())
 	{
-		tenantRoutes.POST("/stream", handler.CreateChatStream)
+		tenantRoutes.POST("/stream", deps.Flags.Require(flags.ChatGeneration), handler.CreateChatStream)
+		tenantRoutes.POST("/complete", deps.Flags.Require(flags.ChatGeneration), handler.CreateChatCompletion)
+		tenantRoutes.POST("/generate", deps.Flags.Require(flags.ChatGeneration), handler.CreateAsyncGeneration)
+		tenantRoutes.GET("/generate/:jobId", handler.GetAsyncGeneration)
+		tenantRoutes.GET("/trash", handler.ListTrash)
+		tenantRoutes.POST("/:id/restore", handler.RestoreChat)
+		tenantRoutes.DELETE("/trash/:id", handler.PurgeChat)
 		tenantRoutes.GET("/:id", handler.GetChat)
+		tenantRoutes.GET("/:id/meta", handler.GetChatMeta)
+		tenantRoutes.GET("/:id/content/:messageId", handler.GetMessageContent)
+		tenantRoutes.GET("/:id/messages/:messageId", handler.GetMessage)
+		tenantRoutes.POST("/:id/messages/:messageId/feedback", handler.SubmitFeedback)
+		tenantRoutes.PATCH("/:id", handler.UpdateChat)
 		tenantRoutes.DELETE("/:id", handler.DeleteChat)
 	}
+
+	// The WebSocket transport also accepts the JWT as the token query
+	// parameter, as browsers can't set headers on the handshake
+	wsRoutes := r.Group("/api/v1/chat")
+	wsRoutes.Use(auth.WebSocketJWTAuthMiddleware(jwtManager))
+	wsRoutes.Use(auth.MaintenanceMiddleware())
+	{
+		wsRoutes.GET("/ws", deps.Flags.Require(flags.ChatGeneration), handler.ChatWebSocket)
+	}
+
+	// Admin routes for browsing and replaying saved responses, authenticated
+	// with the server API key
+	adminRoutes := r.Group("/api/v1/admin/responses")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		adminRoutes.GET("", handler.ListSavedResponses)
+		adminRoutes.GET("/:id", handler.GetSavedResponse)
+		adminRoutes.POST("/:id/replay", handler.ReplaySavedResponse)
+	}
+
+	// Any chat, including chats without a recorded owner
+	adminChatRoutes := r.Group("/api/v1/admin/chats")
+	adminChatRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		adminChatRoutes.GET("/:id", handler.AdminGetChat)
+		adminChatRoutes.DELETE("/:id", handler.AdminDeleteChat)
+		adminChatRoutes.PUT("/:id/owner", handler.AdminSetChatOwner)
+	}
+
+	experimentRoutes := r.Group("/api/v1/admin/experiments")
+	experimentRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		experimentRoutes.GET("", handler.ListExperiments)
+	}
+
+	feedbackRoutes := r.Group("/api/v1/admin/feedback")
+	feedbackRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		feedbackRoutes.GET("", handler.ListFeedback)
+	}
+}
+
+// RegisterSimpleRoutes registers the chat routes simple mode serves: the
+// completion endpoint for integrations and the chat its 504 says to poll,
+// authenticated with the server API key. Chats made there have no tenant
+// or owner, and only chats without an owner can be reached.
+func RegisterSimpleRoutes(r *gin.RouterGroup, deps *sections.Dependencies) {
+	handler := NewHandler(deps)
+
+	simpleRoutes := r.Group("/api/v1/chat")
+	simpleRoutes.Use(middleware.ServerTimingMiddleware())
+	simpleRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	simpleRoutes.Use(auth.MaintenanceMiddleware())
+	{
+		simpleRoutes.POST("/complete", deps.Flags.Require(flags.ChatGeneration), handler.CreateChatCompletion)
+		simpleRoutes.GET("/:id", handler.GetChat)
+	}
 }
@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/services"
+	"awning-backend/storage"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testPage = "<section><h1>Hello</h1></section>"
+
+// fakeVertex streams reply as one content event after delay, or fails with
+// err. It stops early when the generation is cancelled. The parameters of
+// the last call are kept in params.
+type fakeVertex struct {
+	reply  string
+	delay  time.Duration
+	err    error
+	calls  atomic.Int32
+	params atomic.Pointer[common.GenerationParams]
+}
+
+func (f *fakeVertex) GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(sections.StreamEvent) error) error {
+	f.calls.Add(1)
+	f.params.Store(&params)
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if f.err != nil {
+		return f.err
+	}
+	if err := callback(sections.StreamEvent{Type: "content", Content: f.reply}); err != nil {
+		return err
+	}
+	return callback(sections.StreamEvent{Type: "done"})
+}
+
+// scriptedVertex streams its replies in turn, repeating the last, and keeps
+// the prompts it was sent
+type scriptedVertex struct {
+	mu      sync.Mutex
+	replies []string
+	prompts []string
+}
+
+func (s *scriptedVertex) GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(sections.StreamEvent) error) error {
+	s.mu.Lock()
+	reply := s.replies[min(len(s.prompts), len(s.replies)-1)]
+	s.prompts = append(s.prompts, prompt)
+	s.mu.Unlock()
+
+	if err := callback(sections.StreamEvent{Type: "content", Content: reply}); err != nil {
+		return err
+	}
+	return callback(sections.StreamEvent{Type: "done"})
+}
+
+// sent returns the prompts sent so far
+func (s *scriptedVertex) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.prompts)
+}
+
+// newTestHandler returns a chat handler on in-memory stores, without a
+// database or Redis, and the store it saves chats to
+func newTestHandler(t *testing.T, vertex sections.VertexClient) (*Handler, *storage.MemoryStore) {
+	t.Helper()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.md")
+	request := filepath.Join(dir, "request.md")
+	if err := os.WriteFile(base, []byte("Build a page."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(request, []byte("{{user_request}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builder, err := utils.NewPromptBuilder(base, request)
+	if err != nil {
+		t.Fatalf("NewPromptBuilder() error = %v", err)
+	}
+
+	store := storage.NewMemoryStore()
+	cfg := common.DefaultConfig()
+	cfg.MockResponse = false
+	// Titles are generated in the background, a model call tests would race
+	cfg.ChatTitlesEnabled = false
+	deps := &sections.Dependencies{
+		Config:        cfg,
+		Chats:         store,
+		ChatLocks:     store,
+		KV:            store,
+		PromptBuilder: builder,
+		VertexClient:  vertex,
+		ProcessorsSvc: services.NewProcessors(cfg),
+	}
+	return NewHandler(deps), store
+}
+
+// asUser stands in for the JWT middleware
+func asUser(tenantSchema string, userID uint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("userId", userID)
+		c.Set("tenantSchema", tenantSchema)
+		c.Next()
+	}
+}
@@ -0,0 +1,87 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/utils"
+)
+
+// messageHistory formats the chat's history for the prompt. Past
+// history_compaction_threshold_tokens, pages before the latest are replaced
+// by outlines, which are stored on their messages so each is made once, and
+// user messages are capped.
+func (h *Handler) messageHistory(ctx context.Context, chat *model.Chat) string {
+	full := chat.GetMessageHistory()
+
+	cfg := h.deps.Config
+	fullTokens, err := utils.CountTokens(full)
+	if err != nil {
+		// The prompt's own count reports the error
+		return full
+	}
+	if cfg.HistoryCompactionThresholdTokens > 0 && fullTokens <= cfg.HistoryCompactionThresholdTokens {
+		return full
+	}
+
+	latest := chat.LatestAssistantIndex()
+	outlined := 0
+	for i := range chat.Messages {
+		msg := &chat.Messages[i]
+		if msg.Role != model.ChatMessageRoleAssistant || i == latest || msg.Summary != "" {
+			continue
+		}
+		msg.Summary = h.outlinePage(ctx, chat.ID, msg.Content)
+		outlined++
+	}
+
+	compacted := chat.CompactMessageHistory(cfg.HistoryUserMessageMaxChars)
+	compactedTokens, _ := utils.CountTokens(compacted)
+	h.logger.Info("Chat history compacted", "chat_id", chat.ID, "tokens_before", fullTokens, "tokens_after", compactedTokens,
+		"outlined", outlined, "strategy", cfg.HistorySummaryStrategy)
+	return compacted
+}
+
+// outlinePage outlines an earlier page with the configured strategy. Model
+// outlines fall back to the heuristic one when they fail.
+func (h *Handler) outlinePage(ctx context.Context, chatID, page string) string {
+	if h.deps.Config.HistorySummaryStrategy != "model" || h.deps.Config.MockResponse {
+		return utils.OutlinePageHTML(page)
+	}
+
+	outline, err := h.generatePageOutline(ctx, page)
+	if err != nil || outline == "" {
+		h.logger.Warn("Failed to outline page with the model, using the heuristic outline", "chat_id", chatID, "error", err)
+		return utils.OutlinePageHTML(page)
+	}
+	return outline
+}
+
+func (h *Handler) generatePageOutline(ctx context.Context, page string) (string, error) {
+	prompt := utils.BuildPageOutlinePrompt(page)
+
+	if client, ok := h.deps.VertexClient.(sections.VertexModelCompletionClient); ok && h.deps.Config.HistorySummaryModel != "" {
+		raw, err := client.GenerateContentWithModel(ctx, h.deps.Config.HistorySummaryModel, prompt)
+		if err != nil {
+			return "", err
+		}
+		return utils.CleanPageOutline(raw), nil
+	}
+
+	modelName := h.generationModel(false)
+	promptTokens, err := utils.CountTokens(prompt)
+	if err != nil {
+		return "", err
+	}
+	params, _ := h.deps.Config.ResolveGenerationParams(modelName, nil)
+	if err := h.deps.Config.FitOutputBudget(modelName, promptTokens, &params); err != nil {
+		return "", fmt.Errorf("page too long to outline: %w", err)
+	}
+	raw, err := h.generateContent(ctx, prompt, params)
+	if err != nil {
+		return "", err
+	}
+	return utils.CleanPageOutline(raw), nil
+}
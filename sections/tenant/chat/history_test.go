@@ -0,0 +1,189 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// longPage returns a generated page of about sections sections, each with a
+// heading and some copy, named after version
+func longPage(version, sections int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<html><head><title>Bakery v%d</title></head><body>", version)
+	for i := range sections {
+		fmt.Fprintf(&b, `<section class="py-12"><h2>Section %d of version %d</h2><p>%s</p></section>`,
+			i, version, strings.Repeat("Fresh bread baked every morning with local flour. ", 10))
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+// longChat returns a chat of turns user requests, each answered with a
+// long page
+func longChat(id string, turns int) *model.Chat {
+	chat := model.NewChat(id)
+	chat.UserID = 1
+	for i := 1; i <= turns; i++ {
+		chat.Messages = append(chat.Messages,
+			model.ChatMessage{ID: fmt.Sprintf("u%d", i), Role: model.ChatMessageRoleUser, Content: fmt.Sprintf("Change %d: make it warmer", i)},
+			model.ChatMessage{ID: fmt.Sprintf("a%d", i), Role: model.ChatMessageRoleAssistant, Content: longPage(i, 40)},
+		)
+	}
+	return chat
+}
+
+func tokens(t *testing.T, s string) int {
+	t.Helper()
+
+	n, err := utils.CountTokens(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMessageHistoryCompacts(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	h.deps.Config.HistoryCompactionThresholdTokens = 5000
+	chat := longChat("long-chat", 6)
+	latest := chat.Messages[len(chat.Messages)-1].Content
+
+	full := chat.GetMessageHistory()
+	history := h.messageHistory(context.Background(), chat)
+
+	if got, before := tokens(t, history), tokens(t, full); got >= before/3 {
+		t.Errorf("compacted history has %d tokens of %d, want far fewer", got, before)
+	}
+	if !strings.Contains(history, latest) {
+		t.Error("compacted history lacks the latest page in full")
+	}
+	for i := 1; i < 6; i++ {
+		if strings.Contains(history, longPage(i, 40)) {
+			t.Errorf("compacted history has page %d in full", i)
+		}
+		if !strings.Contains(history, fmt.Sprintf("Section 0 of version %d", i)) {
+			t.Errorf("compacted history lacks the outline of page %d", i)
+		}
+		if !strings.Contains(history, fmt.Sprintf("Change %d: make it warmer", i)) {
+			t.Errorf("compacted history lacks user message %d", i)
+		}
+	}
+
+	// Outlines are stored on their messages, the latest page gets none
+	for _, msg := range chat.Messages {
+		wantSummary := msg.Role == model.ChatMessageRoleAssistant && msg.ID != "a6"
+		if (msg.Summary != "") != wantSummary {
+			t.Errorf("message %s summary = %q", msg.ID, msg.Summary)
+		}
+	}
+}
+
+func TestMessageHistoryUnderThreshold(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	chat := longChat("short-chat", 2)
+
+	if got, want := h.messageHistory(context.Background(), chat), chat.GetMessageHistory(); got != want {
+		t.Error("history under the threshold was compacted")
+	}
+	for _, msg := range chat.Messages {
+		if msg.Summary != "" {
+			t.Errorf("message %s outlined under the threshold", msg.ID)
+		}
+	}
+}
+
+func TestMessageHistoryCapsUserMessages(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	h.deps.Config.HistoryCompactionThresholdTokens = 10
+	h.deps.Config.HistoryUserMessageMaxChars = 20
+	chat := longChat("wordy-chat", 1)
+	chat.Messages[0].Content = strings.Repeat("really ", 50) + "long request"
+
+	history := h.messageHistory(context.Background(), chat)
+	if !strings.Contains(history, "user: really really really [...]\n") || strings.Contains(history, "long request") {
+		t.Errorf("history = %.200s, want the user message cut to 20 characters", history)
+	}
+}
+
+func TestMessageHistoryModelOutlines(t *testing.T) {
+	vertex := &fakeVertex{reply: "```\n- Hero: fresh bread\n- Menu: croissants\n```"}
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Config.HistoryCompactionThresholdTokens = 5000
+	h.deps.Config.HistorySummaryStrategy = "model"
+	chat := longChat("model-chat", 3)
+
+	history := h.messageHistory(context.Background(), chat)
+	if n := vertex.calls.Load(); n != 2 {
+		t.Errorf("model called %d times, want once per earlier page", n)
+	}
+	if chat.Messages[1].Summary != "- Hero: fresh bread\n- Menu: croissants" || !strings.Contains(history, "- Menu: croissants") {
+		t.Errorf("summary = %q, want the model's outline without fences", chat.Messages[1].Summary)
+	}
+
+	// Stored outlines aren't made again
+	h.messageHistory(context.Background(), chat)
+	if n := vertex.calls.Load(); n != 2 {
+		t.Errorf("model called %d times after the outlines were stored", n)
+	}
+
+	// Model failures fall back to the heuristic outline
+	vertex.err = errors.New("model unavailable")
+	failing := longChat("failing-chat", 2)
+	h.messageHistory(context.Background(), failing)
+	if want := utils.OutlinePageHTML(failing.Messages[1].Content); failing.Messages[1].Summary != want {
+		t.Errorf("summary after a model failure = %q, want the heuristic outline %q", failing.Messages[1].Summary, want)
+	}
+}
+
+func TestLongChatStaysUnderBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &scriptedVertex{replies: []string{testPage}}
+	h, store := newTestHandler(t, vertex)
+	chat := longChat("budget-chat", 6)
+	latest := chat.Messages[len(chat.Messages)-1].Content
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+
+	// The full history is over the model's input limit, the compacted one fits
+	fullTokens := tokens(t, chat.GetMessageHistory())
+	maxInput := fullTokens / 2
+	h.deps.Config.HistoryCompactionThresholdTokens = maxInput / 2
+	h.deps.Config.ModelLimits = map[string]common.ModelLimits{
+		h.generationModel(false): {ContextWindow: maxInput * 2, MaxInputTokens: maxInput},
+	}
+
+	w := postCompletion(h, `{"chat_id": "budget-chat", "message": {"role": "user", "content": "Make it blue"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want the compacted prompt accepted: %s", w.Code, w.Body)
+	}
+	prompts := vertex.sent()
+	if len(prompts) != 1 {
+		t.Fatalf("model called %d times, want once", len(prompts))
+	}
+	if got := tokens(t, prompts[0]); got > maxInput {
+		t.Errorf("prompt has %d tokens, want at most %d", got, maxInput)
+	}
+	if !strings.Contains(prompts[0], latest) {
+		t.Error("prompt lacks the latest page in full")
+	}
+
+	// The outlines were saved with the chat
+	saved, err := store.GetChat(context.Background(), "budget-chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Messages[1].Summary == "" {
+		t.Error("outline of the first page not saved")
+	}
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+
+	"awning-backend/utils"
+)
+
+// promptVariables adds the locale and language template variables to the
+// request's variables, unless the request sets them itself
+func promptVariables(requested map[string]string, locale string) map[string]string {
+	if locale == "" {
+		return requested
+	}
+
+	variables := maps.Clone(requested)
+	if variables == nil {
+		variables = map[string]string{}
+	}
+	if _, ok := variables["locale"]; !ok {
+		variables["locale"] = locale
+	}
+	if _, ok := variables["language"]; !ok {
+		variables["language"] = utils.LanguageName(locale)
+	}
+	return variables
+}
+
+// checkLanguage compares the language of the reply with the generation's
+// locale. With language_retry on, a mismatched reply is generated once more
+// with a stronger instruction (mock responses aren't). It returns the reply
+// to keep and whether it was detected as another language.
+func (h *Handler) checkLanguage(ctx context.Context, gen *generation, reply string, isMockResponse bool, progress func(name string)) (string, bool) {
+	if gen.locale == "" {
+		return reply, false
+	}
+
+	mismatch, detected := utils.LanguageMismatch(reply, gen.locale)
+	if !mismatch {
+		return reply, false
+	}
+	slog.Warn("Reply language doesn't match locale", "chat_id", gen.chatID, "locale", gen.locale, "detected", detected)
+
+	if gen.retryPrompt == "" || isMockResponse {
+		return reply, true
+	}
+
+	if progress != nil {
+		progress("language_retry")
+	}
+	retried, err := h.generateContent(ctx, gen.retryPrompt, gen.params)
+	if err != nil {
+		slog.Error("Language retry failed, keeping first reply", "chat_id", gen.chatID, "error", err)
+		return reply, true
+	}
+	if retryTokens, err := utils.CountTokens(gen.retryPrompt); err == nil {
+		gen.addReplyUsage(retryTokens, retried)
+	}
+
+	mismatch, detected = utils.LanguageMismatch(retried, gen.locale)
+	if mismatch {
+		slog.Warn("Retried reply language still doesn't match locale", "chat_id", gen.chatID, "locale", gen.locale, "detected", detected)
+	}
+	return retried, mismatch
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	englishPage = "<section><h1>Fresh bread for your table</h1><p>We bake every morning with flour from the valley and love for our neighbours. " +
+		"Visit us on Main Street or call for more information about orders for your party.</p></section>"
+	spanishPage = "<section><h1>Pan fresco para tu mesa</h1><p>Horneamos cada mañana con harina del valle y con cariño para nuestros vecinos. " +
+		"Visítanos en la calle Principal o llama para más información sobre los pedidos para la fiesta.</p></section>"
+)
+
+// completeInSpanish asks for a page in es-MX and returns the response
+func completeInSpanish(t *testing.T, h *Handler) model.ChatResponse {
+	t.Helper()
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}, "language": "es-MX"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return response
+}
+
+func TestLanguageMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range []struct {
+		reply    string
+		mismatch bool
+	}{
+		{englishPage, true},
+		{spanishPage, false},
+	} {
+		vertex := &scriptedVertex{replies: []string{tt.reply}}
+		h, _ := newTestHandler(t, vertex)
+
+		response := completeInSpanish(t, h)
+		if response.Language != "es-MX" || response.LanguageMismatch != tt.mismatch {
+			t.Errorf("language = %q, mismatch %v; want es-MX, %v", response.Language, response.LanguageMismatch, tt.mismatch)
+		}
+		prompts := vertex.sent()
+		if len(prompts) != 1 || !strings.Contains(prompts[0], "Spanish (locale es-MX)") {
+			t.Errorf("prompts = %q, want one asking for Spanish", prompts)
+		}
+	}
+}
+
+func TestLanguageRetry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &scriptedVertex{replies: []string{englishPage, spanishPage}}
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Config.LanguageRetry = true
+
+	response := completeInSpanish(t, h)
+	if response.LanguageMismatch || !strings.Contains(response.Message.Content, "Pan fresco") {
+		t.Errorf("response = mismatch %v with %q, want the retried Spanish page", response.LanguageMismatch, response.Message.Content)
+	}
+	prompts := vertex.sent()
+	if len(prompts) != 2 || strings.Contains(prompts[0], "IMPORTANT") || !strings.Contains(prompts[1], "IMPORTANT") {
+		t.Errorf("prompts = %q, want a retry with the stronger instruction", prompts)
+	}
+
+	// A reply in the right language isn't retried
+	vertex = &scriptedVertex{replies: []string{spanishPage}}
+	h, _ = newTestHandler(t, vertex)
+	h.deps.Config.LanguageRetry = true
+	completeInSpanish(t, h)
+	if n := len(vertex.sent()); n != 1 {
+		t.Errorf("model called %d times for a Spanish reply, want 1", n)
+	}
+}
+
+func TestPromptVariables(t *testing.T) {
+	if got := promptVariables(map[string]string{"tone": "warm"}, ""); len(got) != 1 {
+		t.Errorf("promptVariables() without a locale = %v", got)
+	}
+
+	requested := map[string]string{"language": "Mexican Spanish"}
+	got := promptVariables(requested, "es-MX")
+	if got["locale"] != "es-MX" || got["language"] != "Mexican Spanish" {
+		t.Errorf("promptVariables() = %v, want the locale added and the requested language kept", got)
+	}
+	if len(requested) != 1 {
+		t.Errorf("promptVariables() changed the request's variables: %v", requested)
+	}
+}
@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"awning-backend/model"
+	"awning-backend/utils"
+)
+
+// SITE_METADATA_ATTEMPTS is how many times site metadata is requested before
+// giving up: once, and once more when the reply doesn't validate
+const SITE_METADATA_ATTEMPTS = 2
+
+// siteMetadataEnabled reports whether the generation gets site metadata: the
+// request's site_metadata when set, else site_metadata_enabled. Mock
+// responses never do.
+func (h *Handler) siteMetadataEnabled(gen *generation, isMockResponse bool) bool {
+	if isMockResponse {
+		return false
+	}
+	if gen.req.SiteMetadata != nil {
+		return *gen.req.SiteMetadata
+	}
+	return h.deps.Config.SiteMetadataEnabled
+}
+
+// generateSiteMetadata asks the model for the JSON summary of the generated
+// page, retrying once with the validation error when the reply is rejected.
+// Failures are logged and return nil metadata, so they never fail the
+// generation. The usage covers every attempt made.
+func (h *Handler) generateSiteMetadata(ctx context.Context, gen *generation, html string) (*model.SiteMetadata, *model.SiteMetadataUsage) {
+	modelName := h.generationModel(false)
+	usage := &model.SiteMetadataUsage{}
+
+	previousError := ""
+	for usage.Attempts < SITE_METADATA_ATTEMPTS {
+		prompt := utils.BuildSiteMetadataPrompt(html, previousError)
+		promptTokens, err := utils.CountTokens(prompt)
+		if err != nil {
+			slog.Error("Failed to count site metadata tokens", "chat_id", gen.chatID, "error", err)
+			break
+		}
+
+		params, _ := h.deps.Config.ResolveGenerationParams(modelName, nil)
+		if err := h.deps.Config.FitOutputBudget(modelName, promptTokens, &params); err != nil {
+			slog.Warn("Page too long for site metadata", "chat_id", gen.chatID, "prompt_tokens", promptTokens, "error", err)
+			break
+		}
+
+		usage.Attempts++
+		usage.PromptTokens += promptTokens
+		reply, err := h.generateContent(ctx, prompt, params)
+		if err != nil {
+			slog.Error("Site metadata generation failed", "chat_id", gen.chatID, "error", err)
+			break
+		}
+		if completionTokens, err := utils.CountTokens(reply); err == nil {
+			usage.CompletionTokens += completionTokens
+		}
+
+		metadata, err := parseSiteMetadata(reply)
+		if err == nil {
+			slog.Info("Site metadata generated", "chat_id", gen.chatID, "sections", len(metadata.Sections),
+				"attempts", usage.Attempts, "prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+			return metadata, usage
+		}
+		slog.Warn("Rejected site metadata reply", "chat_id", gen.chatID, "attempt", usage.Attempts, "error", err)
+		previousError = err.Error()
+	}
+
+	if usage.Attempts == 0 {
+		return nil, nil
+	}
+	slog.Warn("No valid site metadata", "chat_id", gen.chatID,
+		"attempts", usage.Attempts, "prompt_tokens", usage.PromptTokens, "completion_tokens", usage.CompletionTokens)
+	return nil, usage
+}
+
+// parseSiteMetadata decodes and validates a site metadata reply
+func parseSiteMetadata(reply string) (*model.SiteMetadata, error) {
+	var metadata model.SiteMetadata
+	if err := json.Unmarshal([]byte(utils.ExtractJSONObject(reply)), &metadata); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+const validMetadata = "```json\n" + `{"sections": [{"id": "hero", "title": "Hello"}], "palette": ["#112233"], "fonts": ["Inter"]}` + "\n```"
+
+// completeWithMetadata runs a completion asking for site metadata
+func completeWithMetadata(t *testing.T, h *Handler) model.ChatResponse {
+	t.Helper()
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "A page"}, "site_metadata": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSiteMetadataRetriesMalformedJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &scriptedVertex{replies: []string{testPage, `{"sections": [{"id": "hero", "title": `, validMetadata}}
+	h, store := newTestHandler(t, vertex)
+
+	resp := completeWithMetadata(t, h)
+	if resp.SiteMetadata == nil || len(resp.SiteMetadata.Sections) != 1 || resp.SiteMetadata.Sections[0].ID != "hero" {
+		t.Fatalf("site metadata = %+v, want the retried reply", resp.SiteMetadata)
+	}
+	if usage := resp.SiteMetadataUsage; usage == nil || usage.Attempts != 2 || usage.PromptTokens == 0 || usage.CompletionTokens == 0 {
+		t.Errorf("site metadata usage = %+v, want both attempts counted", usage)
+	}
+
+	prompts := vertex.sent()
+	if len(prompts) != 3 {
+		t.Fatalf("model asked %d times, want the page and two metadata attempts", len(prompts))
+	}
+	if !strings.Contains(prompts[1], testPage) || strings.Contains(prompts[1], "rejected") {
+		t.Errorf("first metadata prompt = %q, want the page without a rejection", prompts[1])
+	}
+	if !strings.Contains(prompts[2], "Your previous reply was rejected: invalid JSON") {
+		t.Errorf("retry prompt = %q, want the validation error", prompts[2])
+	}
+	// Saved with the chat's message too
+	chat, err := store.GetChat(context.Background(), resp.ChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := chat.Messages[len(chat.Messages)-1]; last.SiteMetadata == nil || last.SiteMetadata.Sections[0].Title != "Hello" {
+		t.Errorf("saved message site metadata = %+v, want the response's", last.SiteMetadata)
+	}
+}
+
+func TestSiteMetadataGivesUp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &scriptedVertex{replies: []string{testPage, `{"sections": [], "palette": ["#112233"]}`}}
+	h, _ := newTestHandler(t, vertex)
+
+	resp := completeWithMetadata(t, h)
+	if resp.SiteMetadata != nil {
+		t.Errorf("site metadata = %+v, want none after invalid replies", resp.SiteMetadata)
+	}
+	if usage := resp.SiteMetadataUsage; usage == nil || usage.Attempts != SITE_METADATA_ATTEMPTS {
+		t.Errorf("site metadata usage = %+v, want %d attempts", usage, SITE_METADATA_ATTEMPTS)
+	}
+	if prompts := vertex.sent(); len(prompts) != 1+SITE_METADATA_ATTEMPTS {
+		t.Errorf("model asked %d times, want no more than %d metadata attempts", len(prompts), SITE_METADATA_ATTEMPTS)
+	}
+}
+
+func TestSiteMetadataToggle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		enabled bool
+		flag    string
+		want    bool
+	}{
+		{false, ``, false},
+		{true, ``, true},
+		{true, `, "site_metadata": false`, false},
+		{false, `, "site_metadata": true`, true},
+	}
+	for _, tt := range tests {
+		vertex := &scriptedVertex{replies: []string{testPage, validMetadata}}
+		h, _ := newTestHandler(t, vertex)
+		h.deps.Config.SiteMetadataEnabled = tt.enabled
+
+		w := postCompletion(h, `{"message": {"role": "user", "content": "A page"}`+tt.flag+`}`)
+		var resp model.ChatResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if got := resp.SiteMetadata != nil; got != tt.want || len(vertex.sent()) != map[bool]int{false: 1, true: 2}[tt.want] {
+			t.Errorf("enabled %v, flag %q: metadata %v after %d model calls, want %v", tt.enabled, tt.flag, got, len(vertex.sent()), tt.want)
+		}
+	}
+}
+
+func TestSiteMetadataInDoneEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &scriptedVertex{replies: []string{testPage, validMetadata}})
+
+	done := streamDone(t, newContentRouter(h), `{"message": {"role": "user", "content": "A page"}, "site_metadata": true}`)
+	var metadata model.SiteMetadata
+	if err := json.Unmarshal(done["site_metadata"], &metadata); err != nil || len(metadata.Palette) != 1 || metadata.Palette[0] != "#112233" {
+		t.Errorf("done event site_metadata = %s, want the generated metadata", done["site_metadata"])
+	}
+}
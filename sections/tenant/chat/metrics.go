@@ -0,0 +1,30 @@
+package chat
+
+import "awning-backend/metrics"
+
+// Generation outcomes counted in generationsTotal
+const (
+	outcomeCompleted     = "completed"
+	outcomeFailed        = "failed"
+	outcomeClarification = "clarification"
+)
+
+var (
+	generationsTotal = metrics.NewCounterVec("awning_chat_generations_total",
+		"Chat generations by tenant and outcome: completed, failed, or paused for clarification",
+		"tenant", "outcome")
+	truncationsTotal = metrics.NewCounterVec("awning_chat_truncations_total",
+		"Replies cut short by the output guard, by tenant and reason: output_too_large or repetition",
+		"tenant", "reason")
+	usageTokensTotal = metrics.NewCounterVec("awning_usage_tokens_total",
+		"Tokens of recorded generations by tenant, model and kind (prompt or completion)",
+		"tenant", "model", "kind")
+	usageImageSearchesTotal = metrics.NewCounterVec("awning_usage_image_searches_total",
+		"Image searches of recorded generations by tenant",
+		"tenant")
+)
+
+// countGeneration counts a generation's outcome
+func countGeneration(gen *generation, outcome string) {
+	generationsTotal.Inc(metrics.TenantLabel(gen.tenantSchema), outcome)
+}
@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStreamMockResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, _ := newTestHandler(t, &fakeVertex{err: os.ErrInvalid})
+	cfg := h.deps.Config
+	cfg.MockResponse = true
+	cfg.MockChunkSize = 8
+	cfg.ThinkingMode = common.THINKING_MODE_FULL
+	cfg.ChatDoneInlineContent = true
+
+	// Mock files are read relative to the working directory
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, ".config", "mocks"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	page := "<section><h1>{{businessName}}</h1></section>"
+	if err := os.WriteFile(filepath.Join(dir, ".config", "mocks", "mock_initial_creation.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	body := `{"chat_stage": "initial_creation", "message": {"role": "user", "content": "A page", "context": {"onboarding_data": {"businessName": "Rye & Co"}}}}`
+	req := httptest.NewRequest(http.MethodPost, "/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	newContentRouter(h).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	// Thinking, then the filled-in page in chunks, then done
+	var sequence, chunks []string
+	var done struct {
+		Response struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"response"`
+	}
+	for _, frame := range strings.Split(strings.TrimSpace(w.Body.String()), "\n\n") {
+		eventType, data, _ := strings.Cut(strings.TrimPrefix(frame, "event: "), "\ndata: ")
+		if len(sequence) == 0 || sequence[len(sequence)-1] != eventType {
+			sequence = append(sequence, eventType)
+		}
+		switch eventType {
+		case "content":
+			var event struct {
+				Content string `json:"content"`
+			}
+			json.Unmarshal([]byte(data), &event)
+			chunks = append(chunks, event.Content)
+		case "done":
+			json.Unmarshal([]byte(data), &done)
+		}
+	}
+	if got := strings.Join(sequence, ","); !strings.Contains(got, "thinking,content,done") {
+		t.Errorf("event sequence = %s, want thinking, content then done", got)
+	}
+	want := "<section><h1>Rye & Co</h1></section>"
+	if strings.Join(chunks, "") != want || len(chunks) != 5 {
+		t.Errorf("content chunks = %q, want %q in chunks of 8", chunks, want)
+	}
+	for _, chunk := range chunks[:len(chunks)-1] {
+		if len([]rune(chunk)) != 8 {
+			t.Errorf("chunk %q is not 8 characters", chunk)
+		}
+	}
+	if !strings.Contains(done.Response.Message.Content, "<h1>Rye & Co</h1>") {
+		t.Errorf("done event page = %q, want the business name filled in", done.Response.Message.Content)
+	}
+}
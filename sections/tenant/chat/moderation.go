@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"awning-backend/model"
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModerationBlockedCode is the error code returned with 422 when a message is
+// rejected by moderation
+const ModerationBlockedCode = "moderation_blocked"
+
+// moderationMode returns the tenant's moderation override when one is set,
+// otherwise the configured mode. Moderation is off without a service.
+func (h *Handler) moderationMode(ctx context.Context, tenantSchema string) string {
+	if h.deps.Moderation == nil {
+		return services.ModerationModeOff
+	}
+
+	if tenantSchema != "" && h.deps.DB != nil {
+		var modes []string
+		err := h.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).
+			Where("schema_name = ?", tenantSchema).
+			Pluck("moderation_mode", &modes).Error
+		if err != nil {
+			h.logger.Error("Failed to load tenant moderation mode", "tenant_schema", tenantSchema, "error", err)
+		} else if len(modes) > 0 && modes[0] != "" {
+			return modes[0]
+		}
+	}
+
+	return h.deps.Config.ModerationMode
+}
+
+// moderateRequest checks the user message and onboarding free text before the
+// prompt is built. A flagged result is returned in flag mode; in block mode a
+// 422 generationError is returned instead. Moderation failures let the
+// message through.
+func (h *Handler) moderateRequest(ctx context.Context, tenantSchema string, req model.ChatRequest) (*services.ModerationResult, *generationError) {
+	mode := h.moderationMode(ctx, tenantSchema)
+	if mode == services.ModerationModeOff {
+		return nil, nil
+	}
+
+	result, err := h.deps.Moderation.Check(ctx, moderationTexts(req.Message))
+	if err != nil {
+		h.logger.Error("Moderation check failed, allowing message", "tenant_schema", tenantSchema, "error", err)
+		return nil, nil
+	}
+	if !result.Flagged {
+		return nil, nil
+	}
+
+	detail := map[string]string{
+		"chat_id":  req.ChatID,
+		"provider": h.deps.Moderation.Name(),
+		"category": result.Category,
+		"match":    result.Match,
+	}
+
+	if mode == services.ModerationModeBlock {
+		h.logger.Warn("Message blocked by moderation", "tenant_schema", tenantSchema, "chat_id", req.ChatID, "category", result.Category)
+		audit.Record(ctx, h.deps.DB, tenantSchema, nil, audit.ActionModerationBlocked, detail)
+		return nil, &generationError{Status: http.StatusUnprocessableEntity, Body: gin.H{
+			"error":    "message rejected by content moderation",
+			"code":     ModerationBlockedCode,
+			"category": result.Category,
+		}}
+	}
+
+	h.logger.Warn("Message flagged by moderation", "tenant_schema", tenantSchema, "chat_id", req.ChatID, "category", result.Category)
+	audit.Record(ctx, h.deps.DB, tenantSchema, nil, audit.ActionModerationFlagged, detail)
+	return result, nil
+}
+
+// flagChat records a flagged moderation category on the chat
+func flagChat(chat *model.Chat, result *services.ModerationResult) {
+	if result == nil || slices.Contains(chat.ModerationFlags, result.Category) {
+		return
+	}
+	chat.ModerationFlags = append(chat.ModerationFlags, result.Category)
+}
+
+// moderationTexts returns the user-written text of a message: its content and
+// the free-text onboarding fields
+func moderationTexts(msg *model.ChatMessage) []string {
+	texts := []string{msg.Content}
+	if msg.Context != nil && msg.Context.OnboardingData != nil {
+		od := msg.Context.OnboardingData
+		for _, text := range []string{od.BusinessName, od.CustomGoal, od.CustomNotes} {
+			if text != "" {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return texts
+}
@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingModerator stands in for an unreachable moderation endpoint
+type failingModerator struct{}
+
+func (failingModerator) Name() string { return "failing" }
+
+func (failingModerator) Check(context.Context, []string) (*services.ModerationResult, error) {
+	return nil, errors.New("moderation unavailable")
+}
+
+func newModeratedHandler(t *testing.T, vertex *fakeVertex, mode string) (*Handler, *fakeVertex) {
+	t.Helper()
+
+	h, _ := newTestHandler(t, vertex)
+	moderator, err := services.NewDenylistModerator([]common.ModerationRule{{Pattern: `(?i)casino`, Category: "gambling"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.deps.Moderation = moderator
+	h.deps.Config.ModerationMode = mode
+	return h, vertex
+}
+
+func TestModerationBlocks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, vertex := newModeratedHandler(t, &fakeVertex{reply: testPage}, services.ModerationModeBlock)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"message", `{"message": {"role": "user", "content": "An online casino"}}`},
+		{"onboarding notes", `{"message": {"role": "user", "content": "A page", "context": {"onboarding_data": {"customNotes": "Casino night every Friday"}}}}`},
+	}
+	for _, tt := range tests {
+		w := postCompletion(h, tt.body)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: status = %d, want 422: %s", tt.name, w.Code, w.Body)
+		}
+		var body struct {
+			Code     string `json:"code"`
+			Category string `json:"category"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid response: %v", tt.name, err)
+		}
+		if body.Code != ModerationBlockedCode || body.Category != "gambling" {
+			t.Errorf("%s: response = %s, want the moderation code and category", tt.name, w.Body)
+		}
+	}
+	if n := vertex.calls.Load(); n != 0 {
+		t.Errorf("model called %d times for blocked messages, want 0", n)
+	}
+
+	if w := postCompletion(h, `{"message": {"role": "user", "content": "A page for my bakery"}}`); w.Code != http.StatusOK {
+		t.Errorf("clean message status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestModerationFlags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, vertex := newModeratedHandler(t, &fakeVertex{reply: testPage}, services.ModerationModeFlag)
+	store := h.deps.Chats
+
+	w := postCompletion(h, `{"message": {"role": "user", "content": "An online casino"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 in flag mode: %s", w.Code, w.Body)
+	}
+	if n := vertex.calls.Load(); n != 1 {
+		t.Errorf("model called %d times, want 1", n)
+	}
+
+	var response model.ChatResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	chat, err := store.GetChat(context.Background(), response.ChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.ModerationFlags) != 1 || chat.ModerationFlags[0] != "gambling" {
+		t.Errorf("ModerationFlags = %q, want [gambling]", chat.ModerationFlags)
+	}
+}
+
+func TestModerationOffAndFailing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"message": {"role": "user", "content": "An online casino"}}`
+
+	h, _ := newModeratedHandler(t, &fakeVertex{reply: testPage}, services.ModerationModeOff)
+	if w := postCompletion(h, body); w.Code != http.StatusOK {
+		t.Errorf("moderation off: status = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// A failing check lets the message through
+	h.deps.Moderation = failingModerator{}
+	h.deps.Config.ModerationMode = services.ModerationModeBlock
+	if w := postCompletion(h, body); w.Code != http.StatusOK {
+		t.Errorf("failing moderator: status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"awning-backend/metrics"
+	"awning-backend/sections"
+	"awning-backend/utils"
+)
+
+// errOutputStopped ends a model stream the output guard stopped
+var errOutputStopped = errors.New("output stopped by the output guard")
+
+// newOutputGuard creates the guard for a generation's reply, with the
+// output_guard_max_tokens ceiling or, by default, the generation's
+// max_output_tokens
+func (h *Handler) newOutputGuard(gen *generation) *utils.OutputGuard {
+	maxTokens := h.deps.Config.OutputGuardMaxTokens
+	if maxTokens == 0 {
+		if gen.params.MaxOutputTokens != nil {
+			maxTokens = *gen.params.MaxOutputTokens
+		} else {
+			maxTokens = h.deps.Config.LimitsFor(h.generationModel(false)).MaxOutputTokens
+		}
+	}
+	return utils.NewOutputGuard(h.deps.Config.OutputGuardMaxBytes, maxTokens)
+}
+
+// generateReply produces the assistant message without streaming, like
+// generateContent, cut short when the output guard stops it. A streamed
+// reply has its model request cancelled then.
+func (h *Handler) generateReply(ctx context.Context, gen *generation) (string, error) {
+	guard := h.newOutputGuard(gen)
+	if client, ok := h.deps.VertexClient.(sections.VertexCompletionClient); ok {
+		content, err := client.GenerateContent(ctx, gen.prompt, gen.params)
+		if err != nil {
+			return "", err
+		}
+		guard.Check(content)
+		return gen.truncateOutput(guard, content), nil
+	}
+
+	streamCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	fullContent := strings.Builder{}
+	err := h.deps.VertexClient.GenerateContentStream(streamCtx, gen.prompt, gen.params, func(event sections.StreamEvent) error {
+		if event.Type != "content" {
+			return nil
+		}
+		fullContent.WriteString(event.Content)
+		if !guard.Check(fullContent.String()) {
+			cancel(errOutputStopped)
+			return errOutputStopped
+		}
+		return nil
+	})
+	if err != nil && guard.Reason() == "" {
+		return "", err
+	}
+	return gen.truncateOutput(guard, fullContent.String()), nil
+}
+
+// truncateOutput cuts the reply short when the guard stopped it, recording
+// why on the generation
+func (gen *generation) truncateOutput(guard *utils.OutputGuard, content string) string {
+	if guard.Reason() == "" {
+		return content
+	}
+	gen.truncated = guard.Reason()
+	truncated := guard.Truncate(content)
+	slog.Warn("Truncated model output", "chat_id", gen.chatID, "reason", gen.truncated, "bytes", len(content), "kept_bytes", len(truncated))
+	truncationsTotal.Inc(metrics.TenantLabel(gen.tenantSchema), gen.truncated)
+	return truncated
+}
@@ -0,0 +1,200 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/services"
+	"awning-backend/utils"
+)
+
+// runawayVertex streams a page head and then unit over and over, in chunks
+// that don't line up with it, until the stream is cancelled. It keeps what
+// it sent and why it stopped.
+type runawayVertex struct {
+	unit  func(i int) string
+	chunk int
+
+	mu    sync.Mutex
+	sent  strings.Builder
+	cause error
+}
+
+// maxRunawayBytes stops a runaway stream the guard failed to stop
+const maxRunawayBytes = 8 << 20
+
+func (f *runawayVertex) GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(sections.StreamEvent) error) error {
+	var pending strings.Builder
+	pending.WriteString(`<!DOCTYPE html><html><head><title>Rye &amp; Co</title></head><body><header><h1>Rye &amp; Co</h1></header>`)
+	for i := 0; ; {
+		for pending.Len() < f.chunk {
+			pending.WriteString(f.unit(i))
+			i++
+		}
+		chunk := pending.String()[:f.chunk]
+		rest := pending.String()[f.chunk:]
+		pending.Reset()
+		pending.WriteString(rest)
+
+		f.mu.Lock()
+		f.sent.WriteString(chunk)
+		size := f.sent.Len()
+		f.mu.Unlock()
+		if size > maxRunawayBytes {
+			return errors.New("runaway stream wasn't stopped")
+		}
+
+		err := callback(sections.StreamEvent{Type: "content", Content: chunk})
+		if ctx.Err() != nil {
+			f.mu.Lock()
+			f.cause = context.Cause(ctx)
+			f.mu.Unlock()
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stopped returns the bytes streamed and why the stream was cancelled
+func (f *runawayVertex) stopped() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sent.Len(), f.cause
+}
+
+// markerProcessor marks the page it processed with its name
+type markerProcessor string
+
+func (m markerProcessor) Name() string { return string(m) }
+
+func (m markerProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	return []byte(strings.Replace(string(input), "</body>", "<!--"+string(m)+"--></body>", 1)), nil
+}
+
+// newGuardedHandler returns a chat handler streaming from vertex, with an
+// image processor gated on truncation as in main.go and a cleanup processor
+func newGuardedHandler(t *testing.T, vertex sections.VertexClient) *Handler {
+	t.Helper()
+
+	h, _ := newTestHandler(t, vertex)
+	h.deps.Config.ChatDoneInlineContent = true
+	h.deps.Config.EnabledProcessors = []string{"image", "cleanup"}
+	h.deps.ProcessorsSvc.RegisterProcessor("image", markerProcessor("image"))
+	h.deps.ProcessorsSvc.RegisterProcessor("cleanup", markerProcessor("cleanup"))
+	h.deps.ProcessorsSvc.AddGate("image", "skipped, the output was truncated", func(ctx context.Context) bool {
+		return !services.TruncatedOutput(ctx)
+	})
+	return h
+}
+
+// checkTruncatedPage checks the reply is a prefix of what was streamed, cut
+// after a complete section and closed
+func checkTruncatedPage(t *testing.T, content string, vertex *runawayVertex) {
+	t.Helper()
+
+	// Processors ran on the cut page
+	body, ok := strings.CutSuffix(content, "<!--cleanup--></body></html>")
+	if !ok {
+		t.Fatalf("reply ends %q, want the closed page with only cleanup run", content[max(0, len(content)-80):])
+	}
+	vertex.mu.Lock()
+	sent := vertex.sent.String()
+	vertex.mu.Unlock()
+	if !strings.HasSuffix(body, "</section>") || !strings.HasPrefix(sent, body) {
+		t.Errorf("reply = %q..., want what was streamed cut after a complete section", body[:min(len(body), 200)])
+	}
+	if open, closed := strings.Count(body, "<section"), strings.Count(body, "</section>"); open == 0 || open != closed {
+		t.Errorf("reply has %d sections opened and %d closed", open, closed)
+	}
+}
+
+func TestOutputGuardStopsRunawayStream(t *testing.T) {
+	vertex := &runawayVertex{
+		chunk: 700,
+		unit: func(int) string {
+			return `<section class="promo"><h2>Fresh bread</h2><p>Sourdough, rye and spelt, baked daily.</p></section>`
+		},
+	}
+	h := newGuardedHandler(t, vertex)
+
+	event := streamDone(t, newContentRouter(h), `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+
+	// The model request is cancelled soon after the output starts looping
+	sent, cause := vertex.stopped()
+	if !errors.Is(cause, errOutputStopped) {
+		t.Errorf("model stream stopped by %v, want errOutputStopped", cause)
+	}
+	if limit := (utils.REPETITION_MAX_REPEATS + 4) * utils.REPETITION_BLOCK_BYTES; sent > limit {
+		t.Errorf("model streamed %d bytes, want it stopped within %d", sent, limit)
+	}
+
+	if string(event["truncated"]) != "true" || string(event["truncated_reason"]) != `"repetition"` {
+		t.Errorf("done event truncated = %s, %s; want true, repetition", event["truncated"], event["truncated_reason"])
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(event["response"], &response); err != nil {
+		t.Fatal(err)
+	}
+	if !response.Truncated || response.TruncatedReason != utils.OUTPUT_REPETITION {
+		t.Errorf("response truncated = %v, %q", response.Truncated, response.TruncatedReason)
+	}
+	checkTruncatedPage(t, response.Message.Content, vertex)
+	if !skippedProcessor(response.ProcessingReport, "image") {
+		t.Errorf("processing report = %+v, want the image processor skipped", response.ProcessingReport)
+	}
+}
+
+func TestOutputGuardCeilingOnCompletion(t *testing.T) {
+	// Sections that never repeat only stop at the size ceiling
+	vertex := &runawayVertex{
+		chunk: 1500,
+		unit: func(i int) string {
+			return fmt.Sprintf(`<section id="loaf-%d"><h2>Loaf %d</h2><p>Baked at %d:00.</p></section>`, i, i, i%24)
+		},
+	}
+	h := newGuardedHandler(t, vertex)
+	h.deps.Config.OutputGuardMaxBytes = 32 << 10
+
+	response := completion(t, h, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+
+	sent, cause := vertex.stopped()
+	if !errors.Is(cause, errOutputStopped) || sent > 32<<10+vertex.chunk {
+		t.Errorf("model stream stopped by %v after %d bytes, want errOutputStopped at the 32KB ceiling", cause, sent)
+	}
+	if !response.Truncated || response.TruncatedReason != utils.OUTPUT_TOO_LARGE {
+		t.Errorf("response truncated = %v, %q; want output_too_large", response.Truncated, response.TruncatedReason)
+	}
+	if len(response.Message.Content) > 32<<10+100 {
+		t.Errorf("reply is %d bytes, want it within the 32KB ceiling", len(response.Message.Content))
+	}
+	checkTruncatedPage(t, response.Message.Content, vertex)
+	if !skippedProcessor(response.ProcessingReport, "image") {
+		t.Errorf("processing report = %+v, want the image processor skipped", response.ProcessingReport)
+	}
+
+	// Replies within the ceilings aren't marked, and get every processor
+	h = newGuardedHandler(t, &fakeVertex{reply: testPage})
+	if response := completion(t, h, `{"message": {"role": "user", "content": "A page for my bakery"}}`); response.Truncated || skippedProcessor(response.ProcessingReport, "image") {
+		t.Errorf("short reply truncated = %v, reports %+v", response.Truncated, response.ProcessingReport)
+	}
+}
+
+// skippedProcessor reports whether the named processor was skipped
+func skippedProcessor(reports []common.ProcessorReport, name string) bool {
+	for _, report := range reports {
+		if report.Name == name {
+			return report.Skipped
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+	"awning-backend/services"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errChatNotOwned is returned when the latest copy of a chat being saved no
+// longer belongs to the requester
+var errChatNotOwned = errors.New("chat is not owned by the requester")
+
+// authorizeChat checks that the requester may access the chat, sending 403
+// when not. Chats without a recorded owner are only reachable through the
+// admin routes.
+func (h *Handler) authorizeChat(c *gin.Context, chat *model.Chat) bool {
+	if h.mayAccessChat(c, chat) {
+		return true
+	}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+	code := "chat_forbidden"
+	if !chat.HasOwner() {
+		code = "chat_unowned"
+	}
+	h.logger.Warn("Chat access denied", "chat_id", chat.ID, "tenant", tenantSchema, "user_id", userID, "code", code)
+	i18n.Error(c, http.StatusForbidden, code, "You don't have access to this chat")
+	return false
+}
+
+// mayAccessChat reports whether the requester may access the chat
+func (h *Handler) mayAccessChat(c *gin.Context, chat *model.Chat) bool {
+	if !h.deps.Config.ChatOwnershipChecks {
+		return true
+	}
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+	return ownsChat(chat, tenantSchema, userID)
+}
+
+// ownsChat reports whether the chat belongs to the requester. Requests
+// without a tenant or user, which only simple mode serves, own the chats
+// without an owner.
+func ownsChat(chat *model.Chat, tenantSchema string, userID uint) bool {
+	if tenantSchema == "" && userID == 0 {
+		return !chat.HasOwner()
+	}
+	return chat.OwnedBy(tenantSchema, userID)
+}
+
+// loadAuthorizedChat loads the chat named by the id parameter, sending 404
+// or 403 and returning nil when it can't be accessed
+func (h *Handler) loadAuthorizedChat(c *gin.Context) *model.Chat {
+	chatID := c.Param("id")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required"})
+		return nil
+	}
+
+	stopLoad := common.StartTiming(c.Request.Context(), "chat_load")
+	chat, err := h.deps.Chats.GetChat(chatContext(c.Request.Context(), c), chatID)
+	stopLoad()
+	if err != nil {
+		h.logger.Error("Failed to get chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return nil
+	}
+	if !h.authorizeChat(c, chat) {
+		return nil
+	}
+	return chat
+}
+
+// stampOwner records the owner of a chat that has none: a new chat, or one
+// created before owners were recorded that an admin assigns
+func stampOwner(chat *model.Chat, tenantSchema string, userID uint) {
+	if chat.HasOwner() {
+		return
+	}
+	chat.TenantSchema = tenantSchema
+	chat.UserID = userID
+}
+
+// adminChatContext scopes tenant chat stores to the tenant query parameter
+func adminChatContext(c *gin.Context) context.Context {
+	return services.WithTenantSchema(c.Request.Context(), c.Query("tenant"))
+}
+
+// AdminGetChat returns any chat, including chats without a recorded owner.
+// Tenant chat stores need the tenant query parameter.
+func (h *Handler) AdminGetChat(c *gin.Context) {
+	chatID := c.Param("id")
+	chat, err := h.deps.Chats.GetChat(adminChatContext(c), chatID)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+		return
+	}
+	c.JSON(http.StatusOK, chat)
+}
+
+// ChatOwnerRequest assigns an owner to a chat created before owners were
+// recorded
+type ChatOwnerRequest struct {
+	TenantSchema string `json:"tenantSchema"`
+	UserID       uint   `json:"userId"`
+}
+
+// AdminSetChatOwner records the owner of a chat without one, making it
+// reachable by that tenant (or user, for chats without a tenant). Chats
+// that have an owner are left alone with 409.
+func (h *Handler) AdminSetChatOwner(c *gin.Context) {
+	var req ChatOwnerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.TenantSchema == "" && req.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenantSchema or userId is required"})
+		return
+	}
+
+	ctx := adminChatContext(c)
+	chatID := c.Param("id")
+	chat, err := h.deps.Chats.GetChat(ctx, chatID)
+	if errors.Is(err, storage.ErrChatNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to get chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+		return
+	}
+	if chat.HasOwner() {
+		c.JSON(http.StatusConflict, gin.H{"error": "chat already has an owner", "code": "chat_owned"})
+		return
+	}
+
+	stampOwner(chat, req.TenantSchema, req.UserID)
+	if err := h.deps.Chats.SaveChat(ctx, chat); err != nil {
+		h.logger.Error("Failed to save chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save chat"})
+		return
+	}
+	h.logger.Info("Chat owner assigned by admin", "chat_id", chatID, "tenant", req.TenantSchema, "user_id", req.UserID)
+	c.JSON(http.StatusOK, chat)
+}
+
+// AdminDeleteChat deletes any chat for good, skipping the trash, including
+// chats without a recorded owner
+func (h *Handler) AdminDeleteChat(c *gin.Context) {
+	chatID := c.Param("id")
+	if err := h.deps.Chats.DeleteChat(adminChatContext(c), chatID); err != nil {
+		h.logger.Error("Failed to delete chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat"})
+		return
+	}
+	h.logger.Info("Chat deleted by admin", "chat_id", chatID)
+	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted successfully"})
+}
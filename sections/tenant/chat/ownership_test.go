@@ -0,0 +1,346 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"awning-backend/model"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// user is who a request to ownershipRouter is made as
+type user struct {
+	tenantSchema string
+	id           uint
+}
+
+// ownershipRouter serves the chat routes ownership applies to, with the
+// user taken from the X-Test-User header set by do
+func ownershipRouter(h *Handler, users map[string]user) *gin.Engine {
+	r := gin.New()
+	as := func(c *gin.Context) {
+		u := users[c.GetHeader("X-Test-User")]
+		asUser(u.tenantSchema, u.id)(c)
+	}
+	r.POST("/complete", as, h.CreateChatCompletion)
+	r.GET("/chat/:id", as, h.GetChat)
+	r.PUT("/chat/:id", as, h.UpdateChat)
+	r.DELETE("/chat/:id", as, h.DeleteChat)
+	r.PUT("/admin/chats/:id/owner", h.AdminSetChatOwner)
+	return r
+}
+
+func do(r *gin.Engine, method, path, as, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", as)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid error response: %s", w.Body)
+	}
+	return body.Code
+}
+
+func TestChatOwnershipAcrossTenants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+	r := ownershipRouter(h, map[string]user{
+		"alice":   {"tenant_a", 1},
+		"alice2":  {"tenant_a", 2}, // a teammate of alice's
+		"mallory": {"tenant_b", 3},
+		"nobody":  {"", 1}, // alice's user ID without her tenant
+	})
+
+	chat := model.NewChat("tenant-chat")
+	chat.TenantSchema = "tenant_a"
+	chat.UserID = 1
+	chat.Messages = append(chat.Messages, model.ChatMessage{ID: "m1", Role: "user", Content: "A page"})
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+	continueBody := `{"chat_id": "tenant-chat", "message": {"role": "user", "content": "Make it blue"}}`
+
+	tests := []struct {
+		as   string
+		want int
+	}{
+		{"alice", http.StatusOK},
+		{"alice2", http.StatusOK},
+		{"mallory", http.StatusForbidden},
+		{"nobody", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.as, func(t *testing.T) {
+			w := do(r, http.MethodGet, "/chat/tenant-chat", tt.as, "")
+			if w.Code != tt.want {
+				t.Fatalf("get: status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusForbidden {
+				return
+			}
+			if code := errorCode(t, w); code != "chat_forbidden" {
+				t.Errorf("get: code = %q, want chat_forbidden", code)
+			}
+			w = do(r, http.MethodPost, "/complete", tt.as, continueBody)
+			if w.Code != http.StatusForbidden || errorCode(t, w) != "chat_forbidden" {
+				t.Errorf("continue: status = %d, want 403 chat_forbidden: %s", w.Code, w.Body)
+			}
+			w = do(r, http.MethodDelete, "/chat/tenant-chat", tt.as, "")
+			if w.Code != http.StatusForbidden {
+				t.Errorf("delete: status = %d, want 403: %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	if calls := vertex.calls.Load(); calls != 0 {
+		t.Errorf("forbidden continuations called the model %d times", calls)
+	}
+	saved, err := store.GetChat(context.Background(), "tenant-chat")
+	if err != nil {
+		t.Fatalf("chat was deleted: %v", err)
+	}
+	if saved.TenantSchema != "tenant_a" || saved.UserID != 1 || len(saved.Messages) != 1 {
+		t.Errorf("chat changed by other tenants: owner %q/%d, %d messages", saved.TenantSchema, saved.UserID, len(saved.Messages))
+	}
+}
+
+func TestNewChatStampedWithOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	// Users without a tenant own their chats by user ID
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}, "bob": {"", 2}})
+
+	w := do(r, http.MethodPost, "/complete", "alice", `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var created model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	chat, err := store.GetChat(context.Background(), created.ChatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.UserID != 1 || chat.TenantSchema != "" {
+		t.Errorf("new chat owner = %q/%d, want the user 1", chat.TenantSchema, chat.UserID)
+	}
+
+	continueBody := `{"chat_id": "` + created.ChatID + `", "message": {"role": "user", "content": "Make it blue"}}`
+	if w := do(r, http.MethodPost, "/complete", "bob", continueBody); w.Code != http.StatusForbidden {
+		t.Errorf("continue as bob: status = %d, want 403: %s", w.Code, w.Body)
+	}
+	if w := do(r, http.MethodPost, "/complete", "alice", continueBody); w.Code != http.StatusOK {
+		t.Errorf("continue as alice: status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+func TestUnownedChatNeedsAdminToAssignOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+	r := ownershipRouter(h, map[string]user{"alice": {"tenant_a", 1}})
+
+	// A chat saved before owners were recorded
+	legacy := model.NewChat("legacy-chat")
+	legacy.Messages = append(legacy.Messages, model.ChatMessage{ID: "m1", Role: "user", Content: "A page"})
+	if err := store.SaveChat(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	owner := func() (string, uint) {
+		chat, err := store.GetChat(context.Background(), "legacy-chat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chat.TenantSchema, chat.UserID
+	}
+
+	w := do(r, http.MethodGet, "/chat/legacy-chat", "alice", "")
+	if w.Code != http.StatusForbidden || errorCode(t, w) != "chat_unowned" {
+		t.Fatalf("get: status = %d, want 403 chat_unowned: %s", w.Code, w.Body)
+	}
+
+	// Continuing it neither works nor claims it
+	w = do(r, http.MethodPost, "/complete", "alice", `{"chat_id": "legacy-chat", "message": {"role": "user", "content": "Go on"}}`)
+	if w.Code != http.StatusForbidden || errorCode(t, w) != "chat_unowned" {
+		t.Fatalf("continue: status = %d, want 403 chat_unowned: %s", w.Code, w.Body)
+	}
+	if calls := vertex.calls.Load(); calls != 0 {
+		t.Errorf("continuing an unowned chat called the model %d times", calls)
+	}
+	if tenant, userID := owner(); tenant != "" || userID != 0 {
+		t.Fatalf("continuing stamped the owner %q/%d", tenant, userID)
+	}
+
+	w = do(r, http.MethodPut, "/admin/chats/legacy-chat/owner", "", `{}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("assign nobody: status = %d, want 400: %s", w.Code, w.Body)
+	}
+	w = do(r, http.MethodPut, "/admin/chats/legacy-chat/owner", "", `{"tenantSchema": "tenant_a", "userId": 1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("assign: status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if tenant, userID := owner(); tenant != "tenant_a" || userID != 1 {
+		t.Errorf("owner = %q/%d, want tenant_a/1", tenant, userID)
+	}
+
+	// Owned chats keep their owner
+	w = do(r, http.MethodPut, "/admin/chats/legacy-chat/owner", "", `{"tenantSchema": "tenant_b", "userId": 3}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("reassign: status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if tenant, _ := owner(); tenant != "tenant_a" {
+		t.Errorf("reassigning changed the owner to %q", tenant)
+	}
+
+	if w := do(r, http.MethodGet, "/chat/legacy-chat", "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("get after assigning: status = %d, want 200: %s", w.Code, w.Body)
+	}
+}
+
+// hookedStore fails every GetChat with getErr, or calls onGet with each
+// chat it loads, numbered from 1
+type hookedStore struct {
+	*storage.MemoryStore
+	getErr error
+	onGet  func(n int32, chat *model.Chat)
+	gets   atomic.Int32
+}
+
+func (s *hookedStore) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
+	n := s.gets.Add(1)
+	if s.getErr != nil {
+		return nil, s.getErr
+	}
+	chat, err := s.MemoryStore.GetChat(ctx, chatID)
+	if err == nil && s.onGet != nil {
+		s.onGet(n, chat)
+	}
+	return chat, err
+}
+
+// saveOwnedChat saves a chat with one message owned by the user 1
+func saveOwnedChat(t *testing.T, store *storage.MemoryStore, chatID string) {
+	t.Helper()
+
+	chat := model.NewChat(chatID)
+	stampOwner(chat, "", 1)
+	chat.Messages = append(chat.Messages, model.ChatMessage{ID: "m1", Role: "user", Content: "A page"})
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// giveChatTo makes another user the owner of the stored chat
+func giveChatTo(t *testing.T, store *storage.MemoryStore, chatID string, userID uint) {
+	t.Helper()
+
+	_, err := storage.UpdateChat(context.Background(), store, chatID, func(chat *model.Chat) error {
+		chat.UserID = userID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerationChatLoadFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+	saveOwnedChat(t, store, "chat-1")
+	h.deps.Chats = &hookedStore{MemoryStore: store, getErr: errors.New("connection refused")}
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}})
+
+	// A chat that fails to load isn't replaced with a new one
+	w := do(r, http.MethodPost, "/complete", "alice", `{"chat_id": "chat-1", "message": {"role": "user", "content": "Go on"}}`)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500: %s", w.Code, w.Body)
+	}
+	if n := vertex.calls.Load(); n != 0 {
+		t.Errorf("model called %d times for a chat that failed to load", n)
+	}
+	if chat, err := store.GetChat(context.Background(), "chat-1"); err != nil || len(chat.Messages) != 1 {
+		t.Errorf("stored chat = %+v, %v; want it untouched", chat, err)
+	}
+}
+
+func TestGenerationUnknownChatID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}})
+
+	w := do(r, http.MethodPost, "/complete", "alice", `{"chat_id": "chat-new", "message": {"role": "user", "content": "A page"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	chat, err := store.GetChat(context.Background(), "chat-new")
+	if err != nil {
+		t.Fatalf("chat was not created: %v", err)
+	}
+	if !chat.OwnedBy("", 1) || len(chat.Messages) != 2 {
+		t.Errorf("created chat = %+v, want alice's with both messages", chat)
+	}
+}
+
+func TestGenerationMergeRechecksOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	saveOwnedChat(t, store, "chat-1")
+
+	// The chat changes hands after the generation loaded it, so its save
+	// conflicts and the merge finds another owner
+	h.deps.Chats = &hookedStore{MemoryStore: store, onGet: func(n int32, chat *model.Chat) {
+		if n == 1 {
+			giveChatTo(t, store, "chat-1", 2)
+		}
+	}}
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}})
+
+	do(r, http.MethodPost, "/complete", "alice", `{"chat_id": "chat-1", "message": {"role": "user", "content": "Go on"}}`)
+	chat, err := store.GetChat(context.Background(), "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.UserID != 2 || len(chat.Messages) != 1 {
+		t.Errorf("stored chat = user %d with %d messages, want the new owner's untouched", chat.UserID, len(chat.Messages))
+	}
+}
+
+func TestRenameRechecksOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	saveOwnedChat(t, store, "chat-1")
+	h.deps.Chats = &hookedStore{MemoryStore: store, onGet: func(n int32, chat *model.Chat) {
+		if n == 1 {
+			giveChatTo(t, store, "chat-1", 2)
+		}
+	}}
+	r := ownershipRouter(h, map[string]user{"alice": {"", 1}})
+
+	w := do(r, http.MethodPut, "/chat/chat-1", "alice", `{"title": "Mine now"}`)
+	if w.Code != http.StatusForbidden || errorCode(t, w) != "chat_forbidden" {
+		t.Errorf("status = %d, want 403 chat_forbidden: %s", w.Code, w.Body)
+	}
+	if chat, _ := store.GetChat(context.Background(), "chat-1"); chat.Title != "" {
+		t.Errorf("title = %q, want the rename refused", chat.Title)
+	}
+}
@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultResponsesPerPage = 20
+	MaxResponsesPerPage     = 100
+)
+
+// ListSavedResponses lists saved responses, newest first. ?keyword= keeps
+// responses saved with all the given comma-separated keywords.
+func (h *Handler) ListSavedResponses(c *gin.Context) {
+	if h.deps.Responses == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "response store not configured"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultResponsesPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxResponsesPerPage {
+			perPage = parsed
+		}
+	}
+
+	var keywords []string
+	for _, k := range strings.Split(c.Query("keyword"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, strings.ToLower(k))
+		}
+	}
+
+	responses, err := h.deps.Responses.List(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list saved responses", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list saved responses"})
+		return
+	}
+
+	if len(keywords) > 0 {
+		filtered := responses[:0]
+		for _, r := range responses {
+			if hasAllKeywords(r.Keywords, keywords) {
+				filtered = append(filtered, r)
+			}
+		}
+		responses = filtered
+	}
+
+	total := len(responses)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+
+	c.JSON(http.StatusOK, gin.H{
+		"responses": responses[start:end],
+		"page":      page,
+		"perPage":   perPage,
+		"total":     total,
+	})
+}
+
+func hasAllKeywords(have, want []string) bool {
+	for _, k := range want {
+		if !slices.Contains(have, k) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetSavedResponse returns the raw HTML of a saved response
+func (h *Handler) GetSavedResponse(c *gin.Context) {
+	if h.deps.Responses == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "response store not configured"})
+		return
+	}
+
+	content, _, err := h.deps.Responses.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrSavedResponseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "saved response not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read saved response", "id", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read saved response"})
+		return
+	}
+
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
+}
+
+// ReplaySavedResponse runs a saved response through the current processor
+// pipeline, scoped to the tenant it was generated for, and returns the result
+// without saving anything
+func (h *Handler) ReplaySavedResponse(c *gin.Context) {
+	if h.deps.Responses == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "response store not configured"})
+		return
+	}
+
+	content, response, err := h.deps.Responses.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrSavedResponseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "saved response not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read saved response", "id", c.Param("id"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read saved response"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if response.Metadata != nil && response.Metadata.TenantSchema != "" {
+		ctx = services.WithTenantSchema(ctx, response.Metadata.TenantSchema)
+	}
+
+	output, report := h.deps.ProcessorsSvc.Run(ctx, content, nil)
+
+	h.logger.Info("Replayed saved response", "id", response.ID, "processors", len(report))
+
+	c.JSON(http.StatusOK, gin.H{
+		"response":         response,
+		"content":          output,
+		"processingReport": report,
+	})
+}
@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tenantStamp adds the tenant schema of its context to the page's heading
+type tenantStamp struct{}
+
+func (tenantStamp) Name() string { return "cleanup" }
+
+func (tenantStamp) Process(ctx context.Context, input []byte) ([]byte, error) {
+	tenant, _ := services.TenantSchemaFromContext(ctx)
+	return bytes.ReplaceAll(input, []byte("Hello"), []byte("Hello "+tenant)), nil
+}
+
+// newResponsesRouter serves the admin response routes of h, with the store
+// it reads from
+func newResponsesRouter(t *testing.T, h *Handler) (*gin.Engine, services.ResponseStore) {
+	t.Helper()
+
+	store := services.NewLocalResponseStore(filepath.Join(t.TempDir(), "responses"))
+	h.deps.Responses = store
+	h.deps.Config.EnabledProcessors = []string{"cleanup"}
+	h.deps.ProcessorsSvc.RegisterProcessor("cleanup", tenantStamp{})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/responses", h.ListSavedResponses)
+	r.GET("/responses/:id", h.GetSavedResponse)
+	r.POST("/responses/:id/replay", h.ReplaySavedResponse)
+	return r, store
+}
+
+func serveResponses(r *gin.Engine, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestListSavedResponses(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	r, store := newResponsesRouter(t, h)
+
+	for i, keywords := range [][]string{{"bakery", "hero"}, {"bakery"}, {"garage"}} {
+		if _, err := store.Save(context.Background(), keywords, fmt.Sprintf("<p>%d</p>", i), services.SavedResponseMetadata{ChatID: fmt.Sprintf("chat-%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"chat-2", "chat-1", "chat-0"}, 3},
+		{"?keyword=bakery", []string{"chat-1", "chat-0"}, 2},
+		{"?keyword=bakery,%20HERO", []string{"chat-0"}, 1},
+		{"?keyword=florist", []string{}, 0},
+		{"?per_page=2", []string{"chat-2", "chat-1"}, 3},
+		{"?per_page=2&page=2", []string{"chat-0"}, 3},
+		{"?per_page=2&page=3", []string{}, 3},
+	}
+	for _, tt := range tests {
+		w := serveResponses(r, http.MethodGet, "/responses"+tt.query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", tt.query, w.Code, w.Body)
+		}
+		var resp struct {
+			Responses []services.SavedResponse `json:"responses"`
+			Total     int                      `json:"total"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, saved := range resp.Responses {
+			got = append(got, saved.Metadata.ChatID)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") || resp.Total != tt.total {
+			t.Errorf("GET %s = %q of %d, want %q of %d", tt.query, got, resp.Total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestGetAndReplaySavedResponse(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	r, store := newResponsesRouter(t, h)
+
+	id, err := store.Save(context.Background(), []string{"bakery"}, testPage, services.SavedResponseMetadata{TenantSchema: "tenant_a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serveResponses(r, http.MethodGet, "/responses/"+id)
+	if w.Code != http.StatusOK || w.Body.String() != testPage || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("GET = %d %s %q, want the raw HTML", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+
+	w = serveResponses(r, http.MethodPost, "/responses/"+id+"/replay")
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay = %d: %s", w.Code, w.Body)
+	}
+	var replay struct {
+		Content          string `json:"content"`
+		ProcessingReport []struct {
+			Name    string `json:"name"`
+			Success bool   `json:"success"`
+		} `json:"processingReport"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &replay); err != nil {
+		t.Fatal(err)
+	}
+	// Processed as the tenant it was generated for
+	if !strings.Contains(replay.Content, "<h1>Hello tenant_a</h1>") {
+		t.Errorf("replayed content = %q", replay.Content)
+	}
+	if len(replay.ProcessingReport) != 1 || replay.ProcessingReport[0].Name != "cleanup" || !replay.ProcessingReport[0].Success {
+		t.Errorf("processing report = %+v", replay.ProcessingReport)
+	}
+
+	// Replaying saves nothing
+	if responses, _ := store.List(context.Background()); len(responses) != 1 {
+		t.Errorf("store has %d responses after replay, want 1", len(responses))
+	}
+
+	for _, path := range []string{"/responses/response_missing_1", "/responses/response_missing_1/replay"} {
+		method := http.MethodGet
+		if strings.HasSuffix(path, "/replay") {
+			method = http.MethodPost
+		}
+		if w := serveResponses(r, method, path); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s = %d, want 404", method, path, w.Code)
+		}
+	}
+}
+
+func TestSavedResponsesWithoutStore(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{})
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/responses", h.ListSavedResponses)
+
+	h.deps.Responses = nil
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/responses", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET without a store = %d, want 503", w.Code)
+	}
+}
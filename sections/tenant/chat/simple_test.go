@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+	"awning-backend/sections/common/flags"
+
+	"github.com/gin-gonic/gin"
+)
+
+// noOverrides is a flags store without overrides, leaving every flag at
+// its default
+type noOverrides struct{}
+
+func (noOverrides) GetFeatureFlags(ctx context.Context) (map[string]bool, error) { return nil, nil }
+func (noOverrides) UpdateFeatureFlags(ctx context.Context, set map[string]bool, clear []string) error {
+	return nil
+}
+
+// newSimpleRouter serves the simple-mode chat routes over the test handler's
+// dependencies, with the API key "key:secret"
+func newSimpleRouter(h *Handler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	h.deps.Config.ApiKey = "key"
+	h.deps.Config.ApiKeySecret = "secret"
+	h.deps.Flags = flags.New(noOverrides{}, nil, 0)
+
+	r := gin.New()
+	RegisterSimpleRoutes(&r.RouterGroup, h.deps)
+	return r
+}
+
+func serveSimple(r *gin.Engine, method, path, auth, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSimpleCompletionRequiresAPIKey(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := newSimpleRouter(h)
+
+	for _, auth := range []string{"", "ApiKey key:wrong", "Bearer key:secret"} {
+		w := serveSimple(r, http.MethodPost, "/api/v1/chat/complete", auth, `{"message": {"role": "user", "content": "A bakery"}}`)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status = %d, want 401", auth, w.Code)
+		}
+	}
+}
+
+func TestSimpleCompletion(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+	r := newSimpleRouter(h)
+	const auth = "ApiKey key:secret"
+
+	w := serveSimple(r, http.MethodPost, "/api/v1/chat/complete", auth, `{"message": {"role": "user", "content": "A page for my bakery"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var response model.ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	chat, err := store.GetChat(context.Background(), response.ChatID)
+	if err != nil {
+		t.Fatalf("chat was not saved: %v", err)
+	}
+	if chat.HasOwner() {
+		t.Errorf("chat owner = %q/%d, want none", chat.TenantSchema, chat.UserID)
+	}
+
+	// The chat can be polled and continued without a tenant
+	if w := serveSimple(r, http.MethodGet, "/api/v1/chat/"+response.ChatID, auth, ""); w.Code != http.StatusOK {
+		t.Errorf("GET chat status = %d, want 200: %s", w.Code, w.Body)
+	}
+	w = serveSimple(r, http.MethodPost, "/api/v1/chat/complete", auth,
+		`{"chat_id": "`+response.ChatID+`", "message": {"role": "user", "content": "Add a menu"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("continuation status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if chat, _ := store.GetChat(context.Background(), response.ChatID); len(chat.Messages) != 4 {
+		t.Errorf("continued chat has %d messages, want 4", len(chat.Messages))
+	}
+}
+
+func TestSimpleCompletionRefusesTenantChats(t *testing.T) {
+	vertex := &fakeVertex{reply: testPage}
+	h, store := newTestHandler(t, vertex)
+	r := newSimpleRouter(h)
+	const auth = "ApiKey key:secret"
+
+	owned := model.NewChat("chat-owned")
+	owned.TenantSchema = "tenant_a"
+	owned.UserID = 1
+	if err := store.SaveChat(context.Background(), owned); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serveSimple(r, http.MethodGet, "/api/v1/chat/chat-owned", auth, ""); w.Code != http.StatusForbidden {
+		t.Errorf("GET status = %d, want 403: %s", w.Code, w.Body)
+	}
+	w := serveSimple(r, http.MethodPost, "/api/v1/chat/complete", auth,
+		`{"chat_id": "chat-owned", "message": {"role": "user", "content": "Add a menu"}}`)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("continuation status = %d, want 403: %s", w.Code, w.Body)
+	}
+	if n := vertex.calls.Load(); n != 0 {
+		t.Errorf("model called %d times, want 0", n)
+	}
+}
@@ -0,0 +1,402 @@
+package chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/processors"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/tenant/filesystem"
+	"awning-backend/services"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SitePageKeyPrefix is the filesystem prefix the pages of multi-page
+	// generations are saved under, as pages/<slug>
+	SitePageKeyPrefix = "pages/"
+
+	// SiteManifestKey is the filesystem key of the latest multi-page
+	// generation's manifest
+	SiteManifestKey = "site/manifest"
+
+	// SITE_PLAN_ATTEMPTS is how many times the site plan is requested before
+	// falling back to the default plan
+	SITE_PLAN_ATTEMPTS = 2
+)
+
+// sitePages returns the page slugs of a multi-page generation, home first,
+// or nil for a single page. Without explicit pages they are derived from
+// the onboarding goals.
+func (h *Handler) sitePages(tenantSchema string, req model.ChatRequest) ([]string, *generationError) {
+	if len(req.Pages) == 0 && !req.MultiPage {
+		return nil, nil
+	}
+	invalid := func(message string) *generationError {
+		return &generationError{Status: http.StatusBadRequest, Body: gin.H{"error": message, "code": "invalid_pages"}}
+	}
+	if req.EditTarget != nil {
+		return nil, invalid("pages can't be combined with edit_target")
+	}
+	// Pages other than the home page are only kept in the tenant filesystem
+	if tenantSchema == "" || h.deps.DB == nil {
+		return nil, &generationError{Status: http.StatusBadRequest, Body: gin.H{"error": "multi-page generation needs a tenant to store its pages", "code": "multi_page_requires_tenant"}}
+	}
+
+	slugs := req.Pages
+	if len(slugs) == 0 {
+		var onboardingData *model.OnboardingData
+		if req.Message.Context != nil {
+			onboardingData = req.Message.Context.OnboardingData
+		}
+		slugs = onboardingData.SitePages()
+	}
+
+	pages := []string{model.SiteHomePage}
+	for _, slug := range slugs {
+		slug = strings.ToLower(strings.TrimSpace(slug))
+		if !model.ValidSitePageSlug(slug) {
+			return nil, invalid(fmt.Sprintf("page %q must be a slug such as about or our-team", slug))
+		}
+		if slug == model.SiteHomePage {
+			continue
+		}
+		if slices.Contains(pages, slug) {
+			return nil, invalid(fmt.Sprintf("page %q is listed twice", slug))
+		}
+		pages = append(pages, slug)
+	}
+	if max := h.deps.Config.MultiPageMaxPages; len(pages) > max {
+		return nil, invalid(fmt.Sprintf("at most %d pages are allowed", max))
+	}
+	return pages, nil
+}
+
+// runSite generates the pages of a multi-page generation: the site plan
+// first, then each page with the plan's shared navigation,
+// multi_page_concurrency at a time. A page that fails is reported in the
+// manifest and the others are kept; the generation only fails when every
+// page does. send, when set, gets the site_plan, page_start and page_done
+// events, and progress the site_plan and page:<slug> steps.
+func (h *Handler) runSite(ctx, genCtx context.Context, gen *generation, send SendSSEEvent, progress func(name string)) (*model.ChatResponse, error) {
+	// Pages finish on their own goroutines
+	var mu sync.Mutex
+	emit := func(eventType string, payload any) {
+		if send == nil {
+			return
+		}
+		data, _ := json.Marshal(payload)
+		mu.Lock()
+		defer mu.Unlock()
+		send(eventType, string(data))
+	}
+	step := func(name string) {
+		if progress == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		progress(name)
+	}
+
+	isMockResponse := h.deps.Config.MockResponse
+
+	step("site_plan")
+	stopPlan := gen.timings.Start("site_plan")
+	plan, planUsage := h.planSite(genCtx, gen, isMockResponse)
+	stopPlan()
+	nav := plan.Nav()
+	emit("site_plan", gin.H{"site_name": plan.SiteName, "pages": plan.Pages, "nav": nav})
+
+	manifest := &model.SiteManifest{SiteName: plan.SiteName, Nav: nav, Pages: make([]model.SitePage, len(plan.Pages)), PlanUsage: planUsage}
+	documents := make([]string, len(plan.Pages))
+	images := make([][]model.ChatImage, len(plan.Pages))
+
+	stopPages := gen.timings.Start("site_pages")
+	slots := make(chan struct{}, h.deps.Config.MultiPageConcurrency)
+	var wg sync.WaitGroup
+	for i, page := range plan.Pages {
+		// Taken before starting, so pages start in order
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			emit("page_start", gin.H{"slug": page.Slug, "title": page.Title, "index": i, "total": len(plan.Pages)})
+			step("page:" + page.Slug)
+			manifest.Pages[i], documents[i], images[i] = h.generateSitePage(genCtx, gen, plan, page, isMockResponse)
+			emit("page_done", manifest.Pages[i])
+		}()
+	}
+	wg.Wait()
+	stopPages()
+	if manifest.PlanUsage != nil {
+		gen.addUsage(manifest.PlanUsage.PromptTokens, manifest.PlanUsage.CompletionTokens)
+	}
+	for _, page := range manifest.Pages {
+		gen.recordProcessorFailures(page.Slug, page.ProcessingReport)
+		if page.Usage != nil {
+			gen.addUsage(page.Usage.PromptTokens, page.Usage.CompletionTokens)
+		}
+	}
+
+	return h.completeSite(ctx, gen, manifest, documents, slices.Concat(images...))
+}
+
+// planSite asks the model for the site plan, retrying once with the
+// validation error when the reply is rejected. Failures fall back to
+// model.DefaultSitePlan, so they never fail the generation. The usage
+// covers every attempt made.
+func (h *Handler) planSite(ctx context.Context, gen *generation, isMockResponse bool) (*model.SitePlan, *model.TokenUsage) {
+	siteName := gen.placeholders.BusinessName
+	if isMockResponse {
+		return model.DefaultSitePlan(siteName, gen.pages), nil
+	}
+
+	modelName := h.generationModel(false)
+	var usage *model.TokenUsage
+	previousError := ""
+	for attempt := 1; attempt <= SITE_PLAN_ATTEMPTS; attempt++ {
+		prompt := utils.BuildSitePlanPrompt(gen.req.Message.Content, siteName, gen.pages, gen.locale, previousError)
+		promptTokens, err := utils.CountTokens(prompt)
+		if err != nil {
+			h.logger.Error("Failed to count site plan tokens", "chat_id", gen.chatID, "error", err)
+			break
+		}
+		params, _ := h.deps.Config.ResolveGenerationParams(modelName, nil)
+		if err := h.deps.Config.FitOutputBudget(modelName, promptTokens, &params); err != nil {
+			h.logger.Warn("Request too long for a site plan", "chat_id", gen.chatID, "prompt_tokens", promptTokens, "error", err)
+			break
+		}
+
+		if usage == nil {
+			usage = &model.TokenUsage{}
+		}
+		usage.PromptTokens += promptTokens
+		reply, err := h.generateContent(ctx, prompt, params)
+		if err != nil {
+			h.logger.Error("Site plan generation failed", "chat_id", gen.chatID, "error", err)
+			break
+		}
+		if completionTokens, err := utils.CountTokens(reply); err == nil {
+			usage.CompletionTokens += completionTokens
+		}
+
+		plan, err := parseSitePlan(reply, gen.pages)
+		if err == nil {
+			if plan.SiteName == "" {
+				plan.SiteName = siteName
+			}
+			h.logger.Info("Site plan generated", "chat_id", gen.chatID, "pages", len(plan.Pages), "attempts", attempt)
+			return plan, usage
+		}
+		h.logger.Warn("Rejected site plan reply", "chat_id", gen.chatID, "attempt", attempt, "error", err)
+		previousError = err.Error()
+	}
+
+	h.logger.Warn("Using the default site plan", "chat_id", gen.chatID)
+	return model.DefaultSitePlan(siteName, gen.pages), usage
+}
+
+// parseSitePlan decodes and validates a site plan reply
+func parseSitePlan(reply string, slugs []string) (*model.SitePlan, error) {
+	var plan model.SitePlan
+	if err := json.Unmarshal([]byte(utils.ExtractJSONObject(reply)), &plan); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := plan.Validate(slugs); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// generateSitePage generates one page of the site, runs the processors over
+// it and points its links to the other pages at their paths. It returns the
+// page's manifest entry, the page and the images placed on it; a failed
+// page has no HTML and its entry carries the error.
+func (h *Handler) generateSitePage(ctx context.Context, gen *generation, plan *model.SitePlan, page model.SitePlanPage, isMockResponse bool) (model.SitePage, string, []model.ChatImage) {
+	entry := model.SitePage{Slug: page.Slug, Title: page.Title, Path: model.SitePagePath(page.Slug), Status: model.SitePageFailed}
+	fail := func(err error) (model.SitePage, string, []model.ChatImage) {
+		h.logger.Error("Site page failed", "chat_id", gen.chatID, "page", page.Slug, "error", err)
+		entry.Error = err.Error()
+		return entry, "", nil
+	}
+
+	start := time.Now()
+	var document string
+	if isMockResponse {
+		content, found, err := h.loadMockResponse(gen)
+		if err == nil && !found {
+			err = errors.New("no mock response")
+		}
+		if err != nil {
+			return fail(err)
+		}
+		document = content
+	} else {
+		prompt := h.deps.PromptBuilder.BuildPagePrompt(gen.prompt, plan, page)
+		promptTokens, err := utils.CountTokens(prompt)
+		if err != nil {
+			return fail(err)
+		}
+		params := gen.params
+		if err := h.deps.Config.FitOutputBudget(h.generationModel(false), promptTokens, &params); err != nil {
+			return fail(err)
+		}
+		entry.Usage = &model.TokenUsage{PromptTokens: promptTokens}
+
+		document, err = h.generateContent(ctx, prompt, params)
+		if err != nil {
+			return fail(err)
+		}
+		if completionTokens, err := utils.CountTokens(document); err == nil {
+			entry.Usage.CompletionTokens = completionTokens
+		}
+	}
+	if strings.TrimSpace(document) == "" {
+		return fail(errors.New("the model returned an empty page"))
+	}
+
+	var images *processors.ImageManifest
+	if !isMockResponse || h.deps.Config.PostProcessMockResponses {
+		processCtx := services.WithTenantSchema(ctx, gen.tenantSchema)
+		processCtx, images = processors.WithImageManifest(processCtx)
+		processCtx = processors.WithPlaceholderValues(processCtx, gen.placeholders)
+		processCtx = processors.WithContactDetails(processCtx, gen.contact)
+		processCtx = processors.WithImageMotif(processCtx, gen.motif)
+		processCtx = processors.WithBrandAssets(processCtx, gen.brand)
+		document, entry.ProcessingReport = h.postProcessAssistantMessage(processCtx, document, nil, nil)
+	}
+
+	document, linked := services.LinkSitePages(document, plan.Nav(), page.Slug)
+	entry.Status = model.SitePageDone
+	h.logger.Info("Site page generated", "chat_id", gen.chatID, "page", page.Slug, "links_rewritten", linked, "duration", time.Since(start))
+	return entry, document, images.Images()
+}
+
+// completeSite commits quota and persists a multi-page generation. The
+// chat's assistant message holds the home page, or the first page that
+// was generated when the home page failed, with the manifest.
+func (h *Handler) completeSite(ctx context.Context, gen *generation, manifest *model.SiteManifest, documents []string, images []model.ChatImage) (*model.ChatResponse, error) {
+	stopPersistence := gen.timings.Start("persistence")
+	defer stopPersistence()
+
+	h.saveSitePages(ctx, gen, manifest, documents)
+
+	home := ""
+	for i, page := range manifest.Pages {
+		if page.Status == model.SitePageDone {
+			home = documents[i]
+			break
+		}
+	}
+	if home == "" {
+		h.failGeneration(ctx, gen)
+		return nil, model.ErrNoSitePages
+	}
+
+	if err := gen.reservation.Commit(ctx); err != nil {
+		h.logger.Error("Failed to commit generation quota", "error", err)
+	}
+	countGeneration(gen, outcomeCompleted)
+	if gen.variant != "" {
+		if err := h.deps.Redis.RecordExperimentGeneration(ctx, gen.variant); err != nil {
+			h.logger.Error("Failed to record experiment generation", "variant", gen.variant, "error", err)
+		}
+	}
+
+	message := model.NewChatMessage(model.ChatMessageRoleAssistant, home)
+	message.Model = h.generationModel(h.deps.Config.MockResponse)
+	message.Site = manifest
+	gen.chat.AddMessage(message)
+
+	if err := h.saveGeneration(ctx, gen); err != nil {
+		h.logger.Error("Failed to save chat", "error", err)
+	}
+	draft := h.saveDraft(ctx, gen, home, nil)
+
+	h.deps.Webhooks.Emit(ctx, gen.tenantSchema, webhooks.EventChatCompleted, gin.H{
+		"chatId":      gen.chatID,
+		"messageId":   message.ID,
+		"chatStage":   gen.req.ChatStage,
+		"model":       message.Model,
+		"language":    gen.locale,
+		"contentPath": "/api/v1/chat/" + gen.chatID + "/content/" + message.ID,
+		"draft":       draft,
+		"site":        manifest,
+	})
+	h.notifyGenerationCompleted(ctx, gen, message)
+
+	reports := make([][]common.ProcessorReport, len(manifest.Pages))
+	for i, page := range manifest.Pages {
+		reports[i] = page.ProcessingReport
+	}
+	h.recordUsage(ctx, gen, message, h.deps.Config.MockResponse, reports...)
+
+	return &model.ChatResponse{
+		ChatID:     gen.chatID,
+		ChatStage:  gen.req.ChatStage,
+		Message:    *message,
+		Timestamp:  time.Now().Unix(),
+		Images:     images,
+		Generation: &gen.params,
+		Draft:      draft,
+		Language:   gen.locale,
+		Site:       manifest,
+
+		Assumptions: gen.assumptions,
+	}, nil
+}
+
+// saveSitePages saves each generated page to the tenant filesystem as
+// pages/<slug>, then the manifest as site/manifest. The filesystem is the
+// only place pages other than the home page are kept, so they are saved
+// whatever auto_save_drafts says, and a page that can't be saved is marked
+// failed.
+func (h *Handler) saveSitePages(ctx context.Context, gen *generation, manifest *model.SiteManifest, documents []string) {
+	saved := 0
+	for i := range manifest.Pages {
+		page := &manifest.Pages[i]
+		if page.Status != model.SitePageDone {
+			continue
+		}
+		data, _ := json.Marshal(documents[i])
+		key := SitePageKeyPrefix + page.Slug
+		if _, err := filesystem.SaveEntry(ctx, h.deps, gen.tenantSchema, key, data); err != nil {
+			h.logger.Error("Failed to save site page", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "key", key, "error", err)
+			page.Status = model.SitePageFailed
+			page.Error = "failed to save page"
+			continue
+		}
+		sum := sha256.Sum256([]byte(documents[i]))
+		page.Key = key
+		page.Hash = hex.EncodeToString(sum[:])
+		saved++
+	}
+	if saved == 0 {
+		return
+	}
+
+	manifest.Key = SiteManifestKey
+	data, _ := json.Marshal(manifest)
+	if _, err := filesystem.SaveEntry(ctx, h.deps, gen.tenantSchema, SiteManifestKey, data); err != nil {
+		h.logger.Error("Failed to save site manifest", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "error", err)
+		manifest.Key = ""
+		return
+	}
+	h.logger.Info("Site pages saved", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "pages", saved, "of", len(manifest.Pages))
+}
@@ -0,0 +1,190 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/processors"
+	"awning-backend/sections"
+)
+
+const testSitePlan = `{"site_name": "Crumb", "pages": [
+	{"slug": "about", "title": "About us", "nav_label": "About"},
+	{"slug": "home", "title": "Welcome", "nav_label": "Home", "purpose": "Introduce the bakery"}
+]}`
+
+// pageVertex replies to each page prompt with the page whose slug it names,
+// failing for the slugs in errs
+type pageVertex struct {
+	pages map[string]string
+	errs  map[string]error
+}
+
+func (p *pageVertex) GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(sections.StreamEvent) error) error {
+	for slug, err := range p.errs {
+		if strings.Contains(prompt, "page ("+slug+")") {
+			return err
+		}
+	}
+	for slug, page := range p.pages {
+		if strings.Contains(prompt, "page ("+slug+")") {
+			if err := callback(sections.StreamEvent{Type: "content", Content: page}); err != nil {
+				return err
+			}
+			return callback(sections.StreamEvent{Type: "done"})
+		}
+	}
+	return errors.New("unexpected prompt")
+}
+
+// newSiteGeneration returns a generation of the home and about pages
+func newSiteGeneration() *generation {
+	return &generation{
+		req:          model.ChatRequest{Message: &model.ChatMessage{Role: "user", Content: "A bakery site"}},
+		chatID:       "chat-site",
+		prompt:       "Build a page.",
+		pages:        []string{"home", "about"},
+		placeholders: processors.PlaceholderValues{BusinessName: "Crumb"},
+		timings:      common.NewTimings(),
+	}
+}
+
+func TestParseSitePlan(t *testing.T) {
+	slugs := []string{"home", "about"}
+
+	plan, err := parseSitePlan("Here is the plan:\n```json\n"+testSitePlan+"\n```", slugs)
+	if err != nil {
+		t.Fatalf("parseSitePlan() error = %v", err)
+	}
+	if plan.SiteName != "Crumb" || plan.Pages[0].Slug != "home" || plan.Pages[1].Slug != "about" {
+		t.Errorf("parseSitePlan() = %+v, want the pages in the requested order", plan)
+	}
+
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{"not JSON", "I can't plan that", "invalid JSON"},
+		{"missing page", `{"pages": [{"slug": "home", "title": "Home"}]}`, "expected 2 pages"},
+		{"unexpected slug", `{"pages": [{"slug": "home", "title": "Home"}, {"slug": "blog", "title": "Blog"}]}`, "unexpected slug"},
+	}
+	for _, tt := range tests {
+		if _, err := parseSitePlan(tt.reply, slugs); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: parseSitePlan() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPlanSiteRetriesRejectedReply(t *testing.T) {
+	vertex := &scriptedVertex{replies: []string{"Sure! A home page and an about page.", testSitePlan}}
+	h, _ := newTestHandler(t, vertex)
+
+	plan, usage := h.planSite(context.Background(), newSiteGeneration(), false)
+	if plan.SiteName != "Crumb" || plan.Pages[1].Title != "About us" {
+		t.Errorf("planSite() = %+v, want the second reply's plan", plan)
+	}
+
+	prompts := vertex.sent()
+	if len(prompts) != 2 {
+		t.Fatalf("planSite() made %d model calls, want 2", len(prompts))
+	}
+	if strings.Contains(prompts[0], "previous reply was rejected") || !strings.Contains(prompts[1], "previous reply was rejected: invalid JSON") {
+		t.Errorf("retry prompt doesn't carry the validation error:\n%s", prompts[1])
+	}
+	if usage == nil || usage.PromptTokens == 0 || usage.CompletionTokens == 0 {
+		t.Fatalf("planSite() usage = %+v, want both attempts counted", usage)
+	}
+}
+
+func TestPlanSiteFallsBackToDefault(t *testing.T) {
+	vertex := &scriptedVertex{replies: []string{"no plan", `{"pages": []}`}}
+	h, _ := newTestHandler(t, vertex)
+
+	plan, usage := h.planSite(context.Background(), newSiteGeneration(), false)
+	want := model.DefaultSitePlan("Crumb", []string{"home", "about"})
+	if plan.SiteName != want.SiteName || len(plan.Pages) != 2 || plan.Pages[1].Title != want.Pages[1].Title {
+		t.Errorf("planSite() = %+v, want the default plan %+v", plan, want)
+	}
+	if len(vertex.sent()) != SITE_PLAN_ATTEMPTS {
+		t.Errorf("planSite() made %d model calls, want %d", len(vertex.sent()), SITE_PLAN_ATTEMPTS)
+	}
+	if usage == nil || usage.CompletionTokens == 0 {
+		t.Errorf("planSite() usage = %+v, want the rejected attempts counted", usage)
+	}
+
+	// Mock responses don't call the model
+	vertex = &scriptedVertex{replies: []string{testSitePlan}}
+	h, _ = newTestHandler(t, vertex)
+	if _, usage := h.planSite(context.Background(), newSiteGeneration(), true); usage != nil || len(vertex.sent()) != 0 {
+		t.Errorf("planSite() of a mock response called the model")
+	}
+}
+
+func TestGenerateSitePage(t *testing.T) {
+	vertex := &pageVertex{
+		pages: map[string]string{
+			"home":  `<nav><a href="#home">Home</a><a href="about.html">About</a></nav><section><h1>Crumb</h1></section>`,
+			"about": "  ",
+		},
+	}
+	h, _ := newTestHandler(t, vertex)
+	gen := newSiteGeneration()
+	plan, err := parseSitePlan(testSitePlan, gen.pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, document, _ := h.generateSitePage(context.Background(), gen, plan, plan.Pages[0], false)
+	if entry.Status != model.SitePageDone || entry.Path != "/" || entry.Error != "" {
+		t.Errorf("home entry = %+v, want a done page at /", entry)
+	}
+	if entry.Usage == nil || entry.Usage.PromptTokens == 0 || entry.Usage.CompletionTokens == 0 {
+		t.Errorf("home entry usage = %+v", entry.Usage)
+	}
+	if !strings.Contains(document, `href="/about"`) || strings.Contains(document, "about.html") {
+		t.Errorf("home page links weren't pointed at the about page's path:\n%s", document)
+	}
+
+	// An empty page fails on its own
+	entry, document, _ = h.generateSitePage(context.Background(), gen, plan, plan.Pages[1], false)
+	if entry.Status != model.SitePageFailed || entry.Error != "the model returned an empty page" || document != "" {
+		t.Errorf("about entry = %+v, document %q; want a failed page", entry, document)
+	}
+
+	vertex.errs = map[string]error{"about": errors.New("model overloaded")}
+	entry, _, _ = h.generateSitePage(context.Background(), gen, plan, plan.Pages[1], false)
+	if entry.Status != model.SitePageFailed || !strings.Contains(entry.Error, "model overloaded") {
+		t.Errorf("about entry = %+v, want the model error", entry)
+	}
+}
+
+func TestSitePages(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	message := &model.ChatMessage{Role: "user", Content: "A bakery site"}
+
+	if pages, genErr := h.sitePages("", model.ChatRequest{Message: message}); pages != nil || genErr != nil {
+		t.Errorf("sitePages() of a single page = %v, %v; want nil", pages, genErr)
+	}
+
+	tests := []struct {
+		name string
+		req  model.ChatRequest
+		code string
+	}{
+		{"edit target", model.ChatRequest{Message: message, Pages: []string{"about"}, EditTarget: &model.EditTarget{Selector: "h1"}}, "invalid_pages"},
+		{"no tenant", model.ChatRequest{Message: message, Pages: []string{"about"}}, "multi_page_requires_tenant"},
+		{"multi page without tenant", model.ChatRequest{Message: message, MultiPage: true}, "multi_page_requires_tenant"},
+	}
+	for _, tt := range tests {
+		_, genErr := h.sitePages("", tt.req)
+		if genErr == nil || genErr.Status != http.StatusBadRequest || genErr.Body["code"] != tt.code {
+			t.Errorf("%s: sitePages() error = %+v, want %s", tt.name, genErr, tt.code)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/middleware"
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDoneEventTimings(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const delay = 50 * time.Millisecond
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage, delay: delay})
+
+	start := time.Now()
+	event := streamDone(t, newContentRouter(h), `{"message":{"role":"user","content":"a bakery"}}`)
+	elapsed := time.Since(start).Milliseconds()
+
+	var timings map[string]int64
+	if err := json.Unmarshal(event["timings"], &timings); err != nil {
+		t.Fatalf("done event timings = %s: %v", event["timings"], err)
+	}
+	for _, phase := range []string{"prompt_build", "token_count", "model_ttfb", "model_stream", "postprocess_total", "persistence"} {
+		ms, ok := timings[phase]
+		if !ok {
+			t.Errorf("done event has no %s timing: %v", phase, timings)
+			continue
+		}
+		if ms < 0 || ms > elapsed {
+			t.Errorf("%s = %dms, want between 0 and the %dms the request took", phase, ms, elapsed)
+		}
+	}
+
+	// The fake model waits before its first event
+	if timings["model_ttfb"] < delay.Milliseconds() {
+		t.Errorf("model_ttfb = %dms, want at least the model's %s delay", timings["model_ttfb"], delay)
+	}
+}
+
+func TestGetChatServerTiming(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	chat := model.NewChat("timed-chat")
+	chat.UserID = 1
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/chat/:id", middleware.ServerTimingMiddleware(), asUser("", 1), h.GetChat)
+	w := do(r, http.MethodGet, "/chat/timed-chat", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET chat = %d: %s", w.Code, w.Body)
+	}
+	header := w.Header().Get("Server-Timing")
+	if !strings.HasPrefix(header, "chat_load;dur=") || !strings.Contains(header, ", total;dur=") {
+		t.Errorf("Server-Timing = %q, want chat_load then total", header)
+	}
+}
@@ -0,0 +1,82 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/services"
+	"awning-backend/storage"
+	"awning-backend/utils"
+)
+
+// TITLE_GENERATION_TIMEOUT bounds the background title request
+const TITLE_GENERATION_TIMEOUT = 30 * time.Second
+
+var errTitleAlreadySet = errors.New("chat title already set")
+
+// startTitleGeneration names the chat in the background after its first
+// assistant response. It never blocks the response to the client.
+func (h *Handler) startTitleGeneration(tenantSchema string, chat *model.Chat) {
+	if !h.deps.Config.ChatTitlesEnabled || h.deps.Config.MockResponse || !chat.NeedsTitle() {
+		return
+	}
+
+	firstMessage := chat.FirstUserMessage()
+	if firstMessage == "" {
+		return
+	}
+
+	chatID := chat.ID
+	go func() {
+		ctx, cancel := context.WithTimeout(services.WithTenantSchema(context.Background(), tenantSchema), TITLE_GENERATION_TIMEOUT)
+		defer cancel()
+
+		title, err := h.generateTitle(ctx, firstMessage)
+		if err != nil {
+			h.logger.Warn("Failed to generate chat title", "chat_id", chatID, "error", err)
+			return
+		}
+		if title == "" {
+			return
+		}
+
+		// Applied to the latest copy so messages saved in the meantime aren't
+		// overwritten; a title set by the user wins
+		_, err = storage.UpdateChat(ctx, h.deps.Chats, chatID, func(chat *model.Chat) error {
+			if chat.Title != "" {
+				return errTitleAlreadySet
+			}
+			chat.Title = title
+			return nil
+		})
+		if errors.Is(err, errTitleAlreadySet) {
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to save chat title", "chat_id", chatID, "error", err)
+			return
+		}
+		h.logger.Debug("Chat title generated", "chat_id", chatID, "title", title)
+	}()
+}
+
+func (h *Handler) generateTitle(ctx context.Context, firstMessage string) (string, error) {
+	prompt := utils.BuildChatTitlePrompt(firstMessage)
+
+	var raw string
+	var err error
+	if client, ok := h.deps.VertexClient.(sections.VertexModelCompletionClient); ok && h.deps.Config.ChatTitleModel != "" {
+		raw, err = client.GenerateContentWithModel(ctx, h.deps.Config.ChatTitleModel, prompt)
+	} else {
+		params, _ := h.deps.Config.ResolveGenerationParams(h.generationModel(false), nil)
+		raw, err = h.generateContent(ctx, prompt, params)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return utils.CleanChatTitle(raw), nil
+}
@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/model"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultTrashPerPage = 50
+	MaxTrashPerPage     = 200
+
+	// JobKindPurgeTrash deletes chats trashed more than
+	// chat_trash_retention_days ago, for stores whose trash doesn't expire on
+	// its own
+	JobKindPurgeTrash = "chat.purge_trash"
+
+	// TrashPurgeInterval is how often the purge job runs
+	TrashPurgeInterval = 24 * time.Hour
+)
+
+// loadAuthorizedTrashedChat loads the trashed chat named by the id
+// parameter, sending 404 or 403 and returning nil when it can't be accessed
+func (h *Handler) loadAuthorizedTrashedChat(c *gin.Context) *model.Chat {
+	chatID := c.Param("id")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required"})
+		return nil
+	}
+
+	chat, err := h.deps.Chats.GetTrashedChat(chatContext(c.Request.Context(), c), chatID, h.deps.Config.ChatTrashRetention())
+	if errors.Is(err, storage.ErrChatNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found in the trash"})
+		return nil
+	}
+	if err != nil {
+		h.logger.Error("Failed to get trashed chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat"})
+		return nil
+	}
+	if !h.authorizeChat(c, chat) {
+		return nil
+	}
+	return chat
+}
+
+// ListTrash lists the requester's chats in the trash, most recently deleted
+// first, without their messages
+func (h *Handler) ListTrash(c *gin.Context) {
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultTrashPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxTrashPerPage {
+			perPage = parsed
+		}
+	}
+
+	chats, err := h.deps.Chats.ListTrashedChats(chatContext(c.Request.Context(), c), h.deps.Config.ChatTrashRetention())
+	if err != nil {
+		h.logger.Error("Failed to list trashed chats", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trashed chats"})
+		return
+	}
+
+	items := []model.ChatMeta{}
+	for _, chat := range chats {
+		if h.mayAccessChat(c, chat) {
+			items = append(items, chat.Meta())
+		}
+	}
+	total := len(items)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":         items[start:end],
+		"page":          page,
+		"perPage":       perPage,
+		"total":         total,
+		"retentionDays": h.deps.Config.ChatTrashRetentionDays,
+	})
+}
+
+// RestoreChat moves a chat out of the trash
+func (h *Handler) RestoreChat(c *gin.Context) {
+	trashed := h.loadAuthorizedTrashedChat(c)
+	if trashed == nil {
+		return
+	}
+	chatID := trashed.ID
+
+	chat, err := h.deps.Chats.RestoreChat(chatContext(c.Request.Context(), c), chatID, h.deps.Config.ChatTrashRetention())
+	if errors.Is(err, storage.ErrChatNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chat not found in the trash"})
+		return
+	}
+	if errors.Is(err, storage.ErrChatConflict) {
+		i18n.Error(c, http.StatusConflict, "chat_exists", "A chat with this ID already exists")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to restore chat", "chat_id", chatID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore chat"})
+		return
+	}
+
+	h.logger.Info("Chat restored from the trash", "chat_id", chatID)
+	c.JSON(http.StatusOK, chat.Meta())
+}
+
+// PurgeChat deletes a chat in the trash for good
+func (h *Handler) PurgeChat(c *gin.Context) {
+	chat := h.loadAuthorizedTrashedChat(c)
+	if chat == nil {
+		return
+	}
+
+	if err := h.deps.Chats.DeleteChat(chatContext(c.Request.Context(), c), chat.ID); err != nil {
+		h.logger.Error("Failed to purge chat", "chat_id", chat.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete chat"})
+		return
+	}
+
+	h.logger.Info("Chat purged from the trash", "chat_id", chat.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "Chat deleted permanently"})
+}
+
+// TrashPurger deletes chats whose time in the trash is up
+type TrashPurger struct {
+	logger    *slog.Logger
+	chats     storage.ChatStore
+	retention time.Duration
+}
+
+// NewTrashPurger creates a purger for chats trashed more than retention ago
+func NewTrashPurger(chats storage.ChatStore, retention time.Duration) *TrashPurger {
+	return &TrashPurger{
+		logger:    slog.With("service", "ChatTrashPurger"),
+		chats:     chats,
+		retention: retention,
+	}
+}
+
+// HandlePurge is the jobs.Handler for JobKindPurgeTrash
+func (p *TrashPurger) HandlePurge(ctx context.Context, _ json.RawMessage) error {
+	purged, err := p.chats.PurgeTrash(ctx, p.retention)
+	p.logger.Info("Chat trash purge finished", "purged", purged, "error", err)
+	return err
+}
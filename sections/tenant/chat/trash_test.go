@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashRouter serves the chat and trash routes, as ownershipRouter does
+func trashRouter(h *Handler) *gin.Engine {
+	users := map[string]user{
+		"alice":   {"tenant_a", 1},
+		"mallory": {"tenant_b", 3},
+	}
+	r := ownershipRouter(h, users)
+	as := func(c *gin.Context) {
+		u := users[c.GetHeader("X-Test-User")]
+		asUser(u.tenantSchema, u.id)(c)
+	}
+	r.GET("/trash", as, h.ListTrash)
+	r.POST("/chat/:id/restore", as, h.RestoreChat)
+	r.DELETE("/trash/:id", as, h.PurgeChat)
+	return r
+}
+
+// trashedIDs lists the IDs in the user's trash
+func trashedIDs(t *testing.T, r *gin.Engine, as string) []string {
+	t.Helper()
+
+	w := do(r, http.MethodGet, "/trash", as, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list trash: status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Items         []model.ChatMeta `json:"items"`
+		RetentionDays int              `json:"retentionDays"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RetentionDays != 30 {
+		t.Errorf("retentionDays = %d, want the default 30", body.RetentionDays)
+	}
+	var ids []string
+	for _, item := range body.Items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, store := newTestHandler(t, &fakeVertex{reply: testPage})
+	r := trashRouter(h)
+
+	for _, id := range []string{"chat-1", "chat-2"} {
+		chat := model.NewChat(id)
+		chat.TenantSchema, chat.UserID = "tenant_a", 1
+		chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page")
+		if err := store.SaveChat(context.Background(), chat); err != nil {
+			t.Fatal(err)
+		}
+		if w := do(r, http.MethodDelete, "/chat/"+id, "alice", ""); w.Code != http.StatusOK {
+			t.Fatalf("delete %s: status = %d: %s", id, w.Code, w.Body)
+		}
+	}
+	if w := do(r, http.MethodGet, "/chat/chat-1", "alice", ""); w.Code != http.StatusNotFound {
+		t.Errorf("get trashed chat: status = %d, want 404", w.Code)
+	}
+	if ids := trashedIDs(t, r, "alice"); len(ids) != 2 {
+		t.Errorf("alice's trash = %q, want both chats", ids)
+	}
+	if ids := trashedIDs(t, r, "mallory"); len(ids) != 0 {
+		t.Errorf("mallory's trash = %q, want none of alice's chats", ids)
+	}
+
+	// Only the owner restores or purges
+	if w := do(r, http.MethodPost, "/chat/chat-1/restore", "mallory", ""); w.Code != http.StatusForbidden {
+		t.Errorf("restore as another tenant: status = %d, want 403", w.Code)
+	}
+	if w := do(r, http.MethodDelete, "/trash/chat-2", "mallory", ""); w.Code != http.StatusForbidden {
+		t.Errorf("purge as another tenant: status = %d, want 403", w.Code)
+	}
+
+	if w := do(r, http.MethodPost, "/chat/chat-1/restore", "alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("restore: status = %d: %s", w.Code, w.Body)
+	}
+	if w := do(r, http.MethodGet, "/chat/chat-1", "alice", ""); w.Code != http.StatusOK {
+		t.Errorf("get restored chat: status = %d, want 200", w.Code)
+	}
+
+	if w := do(r, http.MethodDelete, "/trash/chat-2", "alice", ""); w.Code != http.StatusOK {
+		t.Fatalf("purge: status = %d: %s", w.Code, w.Body)
+	}
+	if w := do(r, http.MethodPost, "/chat/chat-2/restore", "alice", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore purged chat: status = %d, want 404", w.Code)
+	}
+	if ids := trashedIDs(t, r, "alice"); len(ids) != 0 {
+		t.Errorf("alice's trash after restoring and purging = %q, want empty", ids)
+	}
+}
+
+func TestTrashPurgerRemovesExpired(t *testing.T) {
+	h, store := newTestHandler(t, &fakeVertex{})
+	ctx := context.Background()
+	chat := model.NewChat("chat-1")
+	if err := store.SaveChat(ctx, chat); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.TrashChat(ctx, "chat-1", h.deps.Config.ChatTrashRetention()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the retention the purge keeps it; with none left it goes
+	if err := NewTrashPurger(store, time.Hour).HandlePurge(ctx, nil); err != nil {
+		t.Fatalf("HandlePurge() error = %v", err)
+	}
+	if _, err := store.GetTrashedChat(ctx, "chat-1", time.Hour); err != nil {
+		t.Errorf("chat purged within the retention: %v", err)
+	}
+	if err := NewTrashPurger(store, -time.Second).HandlePurge(ctx, nil); err != nil {
+		t.Fatalf("HandlePurge() error = %v", err)
+	}
+	if _, err := store.GetTrashedChat(ctx, "chat-1", time.Hour); err == nil {
+		t.Error("chat kept after its retention passed")
+	}
+}
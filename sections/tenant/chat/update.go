@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Where an update generation's current page came from
+const (
+	currentSiteDraft       = "draft"
+	currentSitePublication = "publication"
+)
+
+// currentSite is the page an update generation starts from
+type currentSite struct {
+	source   string
+	sections []utils.PageSection
+}
+
+// loadCurrentSite returns the page an update of the chat starts from: its
+// latest draft, or else the tenant's current publication. It returns nil
+// when there is neither or the page has no sections to outline.
+func (h *Handler) loadCurrentSite(ctx context.Context, tenantSchema, chatID string) *currentSite {
+	if tenantSchema == "" || h.deps.DB == nil {
+		return nil
+	}
+
+	source, page := currentSiteDraft, h.latestDraft(ctx, tenantSchema, chatID)
+	if strings.TrimSpace(page) == "" {
+		source = currentSitePublication
+		var publication models.TenantPublication
+		err := h.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ? AND current = ?", tenantSchema, true).First(&publication).Error
+		})
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				h.logger.Error("Failed to load current publication", "tenant", tenantSchema, "error", err)
+			}
+			return nil
+		}
+		page = publication.Content
+	}
+
+	sections, err := utils.PageSections(page)
+	if err != nil || len(sections) == 0 {
+		return nil
+	}
+	return &currentSite{source: source, sections: sections}
+}
+
+// latestDraft returns the chat's latest saved page, or "" when it has none
+func (h *Handler) latestDraft(ctx context.Context, tenantSchema, chatID string) string {
+	latest, _, _ := draftKeys(chatID, time.Now())
+	var entry models.TenantFilesystem
+	err := h.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND key = ?", tenantSchema, latest).First(&entry).Error
+	})
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			h.logger.Error("Failed to load chat draft", "chat_id", chatID, "tenant", tenantSchema, "error", err)
+		}
+		return ""
+	}
+
+	var page string
+	if err := json.Unmarshal([]byte(entry.Data), &page); err != nil {
+		return ""
+	}
+	return page
+}
+
+// applyCurrentSite diffs an update's page against the page it started from,
+// then fills its placeholder sections with the current ones
+func (h *Handler) applyCurrentSite(gen *generation, page string) (string, *model.SectionDiff) {
+	sections, err := utils.PageSections(page)
+	if err != nil {
+		h.logger.Warn("Failed to read sections of updated page", "chat_id", gen.chatID, "error", err)
+		return page, nil
+	}
+	diff := utils.DiffSections(gen.current.sections, sections)
+	diff.Base = gen.current.source
+
+	restored, err := utils.RestoreUnchangedSections(page, gen.current.sections)
+	if err != nil {
+		h.logger.Warn("Failed to restore unchanged sections", "chat_id", gen.chatID, "error", err)
+		return page, diff
+	}
+
+	h.logger.Info("Site updated", "chat_id", gen.chatID, "base", diff.Base, "added", len(diff.Added),
+		"removed", len(diff.Removed), "modified", len(diff.Modified), "unchanged", len(diff.Unchanged))
+	return restored, diff
+}
@@ -0,0 +1,30 @@
+package chat
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"awning-backend/utils"
+)
+
+func TestApplyCurrentSite(t *testing.T) {
+	h, _ := newTestHandler(t, &fakeVertex{reply: testPage})
+	current, err := utils.PageSections(`<section id="hero"><h1>Rye</h1></section><section id="menu"><p>Bread</p></section><section id="contact"><p>Call</p></section>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := &generation{chatID: "chat-1", current: &currentSite{source: currentSitePublication, sections: current}}
+
+	page, diff := h.applyCurrentSite(gen, `<section id="hero" `+utils.UNCHANGED_SECTION_ATTR+`></section><section id="menu"><p>Cakes</p></section><section id="hours"><p>9 to 5</p></section>`)
+	if diff == nil || diff.Base != currentSitePublication {
+		t.Fatalf("diff = %+v, want one against the publication", diff)
+	}
+	if !slices.Equal(diff.Added, []string{"hours"}) || !slices.Equal(diff.Removed, []string{"contact"}) ||
+		!slices.Equal(diff.Modified, []string{"menu"}) || !slices.Equal(diff.Unchanged, []string{"hero"}) {
+		t.Errorf("diff = %+v", diff)
+	}
+	if !strings.Contains(page, `<section id="hero"><h1>Rye</h1></section>`) || strings.Contains(page, utils.UNCHANGED_SECTION_ATTR) {
+		t.Errorf("page = %q, want the unchanged hero restored", page)
+	}
+}
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"context"
+
+	"awning-backend/common"
+	"awning-backend/metrics"
+	"awning-backend/model"
+	"awning-backend/sections/models"
+	"awning-backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// addUsage adds the tokens of one model request to the generation's usage
+func (gen *generation) addUsage(promptTokens, completionTokens int) {
+	gen.usage.PromptTokens += promptTokens
+	gen.usage.CompletionTokens += completionTokens
+}
+
+// addReplyUsage adds a model request whose prompt was counted before it was
+// sent, counting the tokens of its reply
+func (gen *generation) addReplyUsage(promptTokens int, reply string) {
+	completionTokens, err := utils.CountTokens(reply)
+	if err != nil {
+		completionTokens = 0
+	}
+	gen.addUsage(promptTokens, completionTokens)
+}
+
+// recordUsage saves the usage of a completed generation as a
+// models.UsageRecord, with the image searches counted in reports. Mock
+// responses and generations without a tenant aren't recorded, and failures
+// are only logged.
+func (h *Handler) recordUsage(ctx context.Context, gen *generation, message *model.ChatMessage, isMockResponse bool, reports ...[]common.ProcessorReport) {
+	if isMockResponse || gen.tenantSchema == "" || h.deps.DB == nil {
+		return
+	}
+
+	record := models.UsageRecord{
+		TenantSchema:     gen.tenantSchema,
+		ChatID:           gen.chatID,
+		MessageID:        message.ID,
+		Model:            message.Model,
+		PromptTokens:     int64(gen.usage.PromptTokens),
+		CompletionTokens: int64(gen.usage.CompletionTokens),
+	}
+	for _, report := range reports {
+		for _, r := range report {
+			record.ImageSearches += int64(r.Counts["image_searches"])
+		}
+	}
+
+	tenant := metrics.TenantLabel(gen.tenantSchema)
+	usageTokensTotal.Add(float64(record.PromptTokens), tenant, record.Model, "prompt")
+	usageTokensTotal.Add(float64(record.CompletionTokens), tenant, record.Model, "completion")
+	usageImageSearchesTotal.Add(float64(record.ImageSearches), tenant)
+
+	err := h.deps.DB.WithTenant(ctx, gen.tenantSchema, func(tx *gorm.DB) error {
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to record usage", "chat_id", gen.chatID, "tenant", gen.tenantSchema, "error", err)
+		return
+	}
+	h.spending.Record(ctx, gen.tenantSchema, record.Model, record.PromptTokens, record.CompletionTokens)
+}
@@ -0,0 +1,191 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_FIRST_MESSAGE_TIMEOUT = 30 * time.Second
+	WS_PING_INTERVAL         = 25 * time.Second
+	WS_READ_TIMEOUT          = 2 * WS_PING_INTERVAL
+	WS_WRITE_TIMEOUT         = 10 * time.Second
+)
+
+// wsClientMessage is a message sent by the client over the WebSocket. The
+// first message carries the chat request; later messages are control
+// messages.
+type wsClientMessage struct {
+	Type string `json:"type,omitempty"` // cancel, ping (empty for the initial request)
+	model.ChatRequest
+}
+
+// wsServerMessage wraps an event sent to the client. Data holds the same
+// payload as the data line of the corresponding SSE event.
+type wsServerMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// wsSender serializes writes to the connection; the thinking stream and the
+// keepalive ping write from their own goroutines
+type wsSender struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (s *wsSender) send(eventType string, data json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	if err := s.conn.WriteJSON(wsServerMessage{Type: eventType, Data: data}); err != nil {
+		slog.Debug("Failed to send WebSocket message", "type", eventType, "error", err)
+	}
+}
+
+func (s *wsSender) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	return s.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// sendEvent adapts the sender to the SendSSEEvent signature used by runStream
+func (s *wsSender) sendEvent(eventType, data string) {
+	s.send(eventType, json.RawMessage(data))
+}
+
+func (s *wsSender) sendError(body gin.H) {
+	data, _ := json.Marshal(body)
+	s.send("error", data)
+}
+
+// wsUpgrader returns the upgrader for chat WebSockets. The JWT may come from
+// the token query parameter, which a page on any origin could replay, so
+// browser handshakes are only accepted from origins allowed to use the API.
+// Clients that don't send an Origin, which aren't browsers, are allowed.
+func (h *Handler) wsUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || h.deps.Config.OriginAllowed(origin) {
+				return true
+			}
+			slog.Warn("WebSocket handshake from a disallowed origin", "origin", origin)
+			return false
+		},
+	}
+}
+
+// ChatWebSocket streams a chat generation over a WebSocket. It runs behind
+// auth.WebSocketJWTAuthMiddleware.
+func (h *Handler) ChatWebSocket(c *gin.Context) {
+	conn, err := h.wsUpgrader().Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already written the error response
+		slog.Warn("WebSocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	h.serveChatWebSocket(c, conn)
+}
+
+func (h *Handler) serveChatWebSocket(c *gin.Context, conn *websocket.Conn) {
+	sender := &wsSender{conn: conn}
+
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	userID, _ := auth.GetUserIDFromContext(c)
+
+	conn.SetReadDeadline(time.Now().Add(WS_FIRST_MESSAGE_TIMEOUT))
+
+	var first wsClientMessage
+	if err := conn.ReadJSON(&first); err != nil {
+		slog.Warn("Failed to read initial WebSocket message", "error", err)
+		sender.sendError(gin.H{"error": "invalid chat request"})
+		return
+	}
+
+	ctx := chatContext(context.Background(), c)
+
+	dedup, replay, err := h.beginDedup(c.Request.Context(), tenantSchema, dedupKindStream, first.ChatRequest)
+	if err != nil {
+		return
+	}
+	if replay != nil {
+		replayDoneEvent(replay, sender.sendEvent)
+		return
+	}
+
+	gen, genErr := h.prepareGeneration(ctx, tenantSchema, userID, first.ChatRequest)
+	if genErr != nil {
+		dedup.finish(ctx, nil)
+		sender.sendError(i18n.Localize(c, genErr.Body))
+		return
+	}
+	gen.dedup = dedup
+
+	slog.Debug("Processing WebSocket chat request", "message_length", len(gen.req.Message.Content), "chat_id", gen.chatID)
+
+	requestCtx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// Each pong from the client extends the read deadline, so a client that
+	// stops answering pings is dropped
+	conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+	})
+
+	// Read control messages until the connection closes
+	go func() {
+		defer cancel()
+		for {
+			var msg wsClientMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+
+			switch msg.Type {
+			case "cancel":
+				h.logger.Info("Generation cancelled by client", "chat_id", gen.chatID)
+				cancel()
+			case "ping":
+				sender.send("pong", nil)
+			}
+		}
+	}()
+
+	// Keepalive pings so idle proxies don't drop the connection
+	go func() {
+		ticker := time.NewTicker(WS_PING_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := sender.ping(); err != nil {
+					cancel()
+					return
+				}
+			case <-requestCtx.Done():
+				return
+			}
+		}
+	}()
+
+	h.runStream(c, ctx, requestCtx, gen, sender.sendEvent)
+}
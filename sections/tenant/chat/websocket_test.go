@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/auth/authtest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+func TestChatWebSocketHandshake(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	manager := authtest.NewJWTManager(t)
+	token := authtest.Token(t, manager, 1, "user@example.com", "tenant_1")
+
+	cfg := common.DefaultConfig()
+	cfg.BaseURL = "https://api.example.com"
+	cfg.FrontendURL = "https://app.example.com"
+	h := NewHandler(&sections.Dependencies{Config: cfg})
+
+	r := gin.New()
+	r.GET("/ws", auth.WebSocketJWTAuthMiddleware(manager), h.ChatWebSocket)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	tests := []struct {
+		name   string
+		query  string
+		origin string
+		want   int
+	}{
+		{"allowed origin", "?token=" + token, "https://app.example.com", http.StatusSwitchingProtocols},
+		{"no origin", "?token=" + token, "", http.StatusSwitchingProtocols},
+		{"disallowed origin", "?token=" + token, "https://evil.example", http.StatusForbidden},
+		{"missing token", "", "https://app.example.com", http.StatusUnauthorized},
+		{"invalid token", "?token=invalid", "https://app.example.com", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial(wsURL+tt.query, header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("handshake status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package contact accepts contact form submissions from published sites
+// through the public content API and lists them for the tenant
+package contact
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"awning-backend/i18n"
+	"awning-backend/middleware"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Limits of a submission's fields, in characters
+const (
+	MaxNameLength    = 255
+	MaxEmailLength   = 255
+	MaxMessageLength = 5000
+	MaxPageLength    = 2048
+)
+
+const (
+	DefaultSubmissionsPerPage = 20
+	MaxSubmissionsPerPage     = 100
+)
+
+// Error codes
+const (
+	ErrCodeInvalidSubmission = "invalid_contact_submission"
+	ErrCodeRateLimited       = "contact_rate_limited"
+)
+
+// rateLimitScope counts submissions per client IP
+const rateLimitScope = "contact:ip"
+
+// Handler handles contact form submissions
+type Handler struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+}
+
+// NewHandler creates a new contact handler
+func NewHandler(deps *sections.Dependencies) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "ContactHandler"),
+		deps:   deps,
+	}
+}
+
+// SubmitRequest is a contact form submission
+type SubmitRequest struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+	Page    string `json:"page"` // URL of the page the form is on
+}
+
+// SubmitResponse acknowledges a stored submission
+type SubmitResponse struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// validate trims the request's fields and checks them against their limits
+func (req *SubmitRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Message = strings.TrimSpace(req.Message)
+	req.Page = strings.TrimSpace(req.Page)
+
+	if addr, err := mail.ParseAddress(req.Email); err != nil || addr.Address != req.Email || utf8.RuneCountInString(req.Email) > MaxEmailLength {
+		return fmt.Errorf("email must be a valid address of at most %d characters", MaxEmailLength)
+	}
+	if req.Message == "" || utf8.RuneCountInString(req.Message) > MaxMessageLength {
+		return fmt.Errorf("message is required and must be at most %d characters", MaxMessageLength)
+	}
+	if utf8.RuneCountInString(req.Name) > MaxNameLength {
+		return fmt.Errorf("name must be at most %d characters", MaxNameLength)
+	}
+	if utf8.RuneCountInString(req.Page) > MaxPageLength {
+		return fmt.Errorf("page must be at most %d characters", MaxPageLength)
+	}
+	return nil
+}
+
+// Submit stores a contact form submission for the tenant resolved from the
+// request's origin or host, and notifies the tenant
+func (h *Handler) Submit(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var req SubmitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		i18n.Error(c, http.StatusBadRequest, ErrCodeInvalidSubmission, err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	ip := middleware.ClientIP(c)
+
+	// Submissions are not limited when Redis is unavailable
+	if h.deps.Redis != nil {
+		allowed, retryAfter, err := h.deps.Redis.HitRateLimit(ctx, rateLimitScope, ip, h.deps.Config.ContactRateLimitPerHour, time.Hour)
+		if err != nil {
+			h.logger.Error("Failed to check contact rate limit", "error", err)
+		} else if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.JSON(http.StatusTooManyRequests, i18n.Localize(c, gin.H{
+				"error":      fmt.Sprintf("too many messages, try again in %d seconds", seconds),
+				"code":       ErrCodeRateLimited,
+				"retryAfter": seconds,
+			}))
+			return
+		}
+	}
+
+	submission := models.ContactSubmission{
+		TenantSchema: tenantID,
+		Name:         req.Name,
+		Email:        req.Email,
+		Message:      req.Message,
+		Page:         req.Page,
+		Origin:       truncate(c.GetHeader("Origin"), 255),
+		IP:           ip,
+		UserAgent:    truncate(c.Request.UserAgent(), 512),
+	}
+	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Create(&submission).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to save contact submission", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		return
+	}
+
+	from := submission.Email
+	if submission.Name != "" {
+		from = submission.Name + " <" + submission.Email + ">"
+	}
+	if err := h.deps.Notifications.Notify(ctx, tenantID, notifications.Notification{
+		Type:  notifications.TypeContactSubmission,
+		Title: "New message from " + from,
+		Body:  submission.Message,
+		Metadata: map[string]any{
+			"submissionId": submission.ID,
+			"email":        submission.Email,
+			"page":         submission.Page,
+		},
+	}); err != nil {
+		h.logger.Error("Failed to notify contact submission", "tenant", tenantID, "error", err)
+	}
+
+	h.logger.Info("Contact form submitted", "tenant", tenantID, "id", submission.ID)
+	c.JSON(http.StatusCreated, SubmitResponse{ID: submission.ID, CreatedAt: submission.CreatedAt})
+}
+
+// ListSubmissions returns the tenant's contact form submissions, newest
+// first
+func (h *Handler) ListSubmissions(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultSubmissionsPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxSubmissionsPerPage {
+			perPage = parsed
+		}
+	}
+
+	var submissions []models.ContactSubmission
+	var total int64
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		query := tx.Model(&models.ContactSubmission{}).Where("tenant_schema = ?", tenantID)
+		if err := query.Count(&total).Error; err != nil {
+			return err
+		}
+		return query.Order("created_at DESC, id DESC").
+			Offset((page - 1) * perPage).
+			Limit(perPage).
+			Find(&submissions).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list contact submissions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list submissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"submissions": submissions,
+		"total":       total,
+		"page":        page,
+		"perPage":     perPage,
+	})
+}
+
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// RegisterRoutes registers the public submission route, without the
+// frontend key, and the tenant's listing
+func RegisterRoutes(r *gin.Engine, frontend *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager) {
+	handler := NewHandler(deps)
+
+	publicRoutes := r.Group(strings.TrimSuffix(auth.PublicAPIPrefix, "/") + "/v1")
+	publicRoutes.Use(auth.TenantFromOriginMiddleware())
+	{
+		publicRoutes.POST("/contact", handler.Submit)
+	}
+
+	contactRoutes := frontend.Group("/api/v1/contact")
+	contactRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	contactRoutes.Use(auth.TenantFromHeaderMiddleware(auth.DefaultTenantMiddlewareConfig()))
+	{
+		contactRoutes.GET("/submissions", handler.ListSubmissions)
+	}
+}
@@ -0,0 +1,352 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// Version is the version of the DashboardResponse shape. Fields may be
+// added within a version; renaming or removing one bumps it.
+const Version = 1
+
+// Timeout bounds the time spent assembling a dashboard. Sections that don't
+// finish in time are returned with an error.
+const Timeout = 2 * time.Second
+
+// RecentChatsLimit is the number of recent chats on the dashboard
+const RecentChatsLimit = 5
+
+// Section carries the outcome of one dashboard section. When OK is false
+// the section's other fields are zero and Error says why.
+type Section struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// GenerationsSection is the generation quota for the current period
+type GenerationsSection struct {
+	Section
+	Used      int64     `json:"used"`
+	Limit     int64     `json:"limit"`
+	Unlimited bool      `json:"unlimited"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// CreditsSection is the tenant's remaining credits
+type CreditsSection struct {
+	Section
+	Basic   int `json:"basic"`
+	Premium int `json:"premium"`
+}
+
+// StorageSection is the space used by filesystem entries
+type StorageSection struct {
+	Section
+	Entries int64 `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// DashboardDomain is one of the tenant's domains and its verification state
+type DashboardDomain struct {
+	Domain        string     `json:"domain"`
+	DomainType    string     `json:"domainType"`
+	Primary       bool       `json:"primary"`
+	Verified      bool       `json:"verified"`
+	DNSConfigured bool       `json:"dnsConfigured"`
+	SSLEnabled    bool       `json:"sslEnabled"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+}
+
+// DomainsSection lists the tenant's domains
+type DomainsSection struct {
+	Section
+	Domains []DashboardDomain `json:"domains"`
+}
+
+// SubscriptionSection is the tenant's latest subscription. Status is "none"
+// without one.
+type SubscriptionSection struct {
+	Section
+	Status            string     `json:"status"`
+	PlanName          string     `json:"planName,omitempty"`
+	CurrentPeriodEnd  *time.Time `json:"currentPeriodEnd,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancelAtPeriodEnd"`
+}
+
+// DashboardChat is one of the tenant's recently updated chats
+type DashboardChat struct {
+	ChatID    string    `json:"chatId"`
+	ChatStage string    `json:"chatStage"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RecentChatsSection lists the most recently updated chats
+type RecentChatsSection struct {
+	Section
+	Chats []DashboardChat `json:"chats"`
+}
+
+// DashboardResponse is everything the tenant dashboard shows, assembled in
+// one call. A failing section doesn't fail the response; check each
+// section's ok flag.
+type DashboardResponse struct {
+	Version      int                 `json:"version"`
+	TenantSchema string              `json:"tenantSchema"`
+	GeneratedAt  time.Time           `json:"generatedAt"`
+	Generations  GenerationsSection  `json:"generations"`
+	Credits      CreditsSection      `json:"credits"`
+	Storage      StorageSection      `json:"storage"`
+	Domains      DomainsSection      `json:"domains"`
+	Subscription SubscriptionSection `json:"subscription"`
+	RecentChats  RecentChatsSection  `json:"recentChats"`
+}
+
+// complete reports whether every section loaded
+func (d *DashboardResponse) complete() bool {
+	for _, s := range []Section{d.Generations.Section, d.Credits.Section, d.Storage.Section, d.Domains.Section, d.Subscription.Section, d.RecentChats.Section} {
+		if !s.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler serves the tenant dashboard
+type Handler struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+	quota  *account.QuotaService
+}
+
+// NewHandler creates a new dashboard handler
+func NewHandler(deps *sections.Dependencies) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "DashboardHandler"),
+		deps:   deps,
+		quota:  account.NewQuotaService(deps),
+	}
+}
+
+// GetDashboard returns the tenant dashboard, from the cache when it was
+// assembled in the last minute
+func (h *Handler) GetDashboard(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if h.deps.Redis != nil {
+		cached, err := h.deps.Redis.GetDashboard(ctx, tenantID)
+		if err != nil {
+			h.logger.Warn("Failed to read cached dashboard", "tenant_schema", tenantID, "error", err)
+		}
+		if cached != nil {
+			c.Header("X-Cache", "HIT")
+			c.Data(http.StatusOK, "application/json; charset=utf-8", cached)
+			return
+		}
+	}
+
+	dashboard := h.Build(ctx, tenantID)
+
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		h.logger.Error("Failed to encode dashboard", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build dashboard"})
+		return
+	}
+
+	// Partial results are not cached so the next request tries again
+	if h.deps.Redis != nil && dashboard.complete() {
+		if err := h.deps.Redis.SetDashboard(ctx, tenantID, data); err != nil {
+			h.logger.Warn("Failed to cache dashboard", "tenant_schema", tenantID, "error", err)
+		}
+	}
+
+	c.Header("X-Cache", "MISS")
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// Build assembles the dashboard, loading the sections concurrently within
+// Timeout. Sections that fail or time out are flagged rather than failing
+// the whole dashboard.
+func (h *Handler) Build(ctx context.Context, tenantID string) *DashboardResponse {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	d := &DashboardResponse{
+		Version:      Version,
+		TenantSchema: tenantID,
+		GeneratedAt:  time.Now().UTC(),
+	}
+
+	// Each loader writes only its own section
+	var g errgroup.Group
+	load := func(name string, section *Section, fn func(ctx context.Context) error) {
+		g.Go(func() error {
+			if err := fn(ctx); err != nil {
+				h.logger.Warn("Failed to load dashboard section", "section", name, "tenant_schema", tenantID, "error", err)
+				section.Error = sectionError(name, err)
+				return nil
+			}
+			section.OK = true
+			return nil
+		})
+	}
+
+	load("generations", &d.Generations.Section, func(ctx context.Context) error {
+		status, err := h.quota.GetStatus(ctx, tenantID)
+		if err != nil {
+			return err
+		}
+		d.Generations.Used = status.Used
+		d.Generations.Limit = status.Limit
+		d.Generations.Unlimited = status.Unlimited
+		d.Generations.Remaining = status.Remaining
+		d.Generations.ResetsAt = status.ResetsAt
+		return nil
+	})
+
+	load("credits", &d.Credits.Section, func(ctx context.Context) error {
+		var acct models.TenantAccount
+		err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ?", tenantID).First(&acct).Error
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.Credits.Basic = acct.BasicCredits
+		d.Credits.Premium = acct.PremiumCredits
+		return nil
+	})
+
+	load("storage", &d.Storage.Section, func(ctx context.Context) error {
+		var usage struct {
+			Entries int64
+			Bytes   int64
+		}
+		err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantFilesystem{}).
+				Select("COUNT(*) AS entries, COALESCE(SUM(size), 0) AS bytes").
+				Where("tenant_schema = ?", tenantID).
+				Scan(&usage).Error
+		})
+		if err != nil {
+			return err
+		}
+		d.Storage.Entries = usage.Entries
+		d.Storage.Bytes = usage.Bytes
+		return nil
+	})
+
+	load("domains", &d.Domains.Section, func(ctx context.Context) error {
+		var domains []models.TenantDomain
+		err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ?", tenantID).Order("is_primary DESC, created_at").Find(&domains).Error
+		})
+		if err != nil {
+			return err
+		}
+		d.Domains.Domains = make([]DashboardDomain, 0, len(domains))
+		for _, domain := range domains {
+			d.Domains.Domains = append(d.Domains.Domains, DashboardDomain{
+				Domain:        domain.Domain,
+				DomainType:    domain.DomainType,
+				Primary:       domain.IsPrimary,
+				Verified:      domain.Verified,
+				DNSConfigured: domain.DNSConfigured,
+				SSLEnabled:    domain.SSLEnabled,
+				ExpiresAt:     domain.ExpiresAt,
+			})
+		}
+		return nil
+	})
+
+	load("subscription", &d.Subscription.Section, func(ctx context.Context) error {
+		var sub models.Subscription
+		err := h.deps.DB.DB.WithContext(ctx).
+			Where("tenant_schema = ?", tenantID).
+			Order("current_period_end DESC").
+			First(&sub).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			d.Subscription.Status = "none"
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.Subscription.Status = sub.Status
+		d.Subscription.PlanName = sub.PlanName
+		d.Subscription.CurrentPeriodEnd = &sub.CurrentPeriodEnd
+		d.Subscription.CancelAtPeriodEnd = sub.CancelAtPeriodEnd
+		return nil
+	})
+
+	load("recentChats", &d.RecentChats.Section, func(ctx context.Context) error {
+		var chats []models.TenantChat
+		err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Select("chat_id", "chat_stage", "updated_at").
+				Where("tenant_schema = ?", tenantID).
+				Order("updated_at DESC").
+				Limit(RecentChatsLimit).
+				Find(&chats).Error
+		})
+		if err != nil {
+			return err
+		}
+		d.RecentChats.Chats = make([]DashboardChat, 0, len(chats))
+		for _, chat := range chats {
+			d.RecentChats.Chats = append(d.RecentChats.Chats, DashboardChat{
+				ChatID:    chat.ChatID,
+				ChatStage: chat.ChatStage,
+				UpdatedAt: chat.UpdatedAt.UTC(),
+			})
+		}
+		return nil
+	})
+
+	_ = g.Wait()
+	return d
+}
+
+// sectionError is the error shown for a failed section. Database errors are
+// not passed on to the client.
+func sectionError(name string, err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timed out"
+	}
+	return "failed to load " + name
+}
+
+// RegisterRoutes registers the dashboard route
+func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager) {
+	handler := NewHandler(deps)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	dashboardRoutes := r.Group("/api/v1/dashboard")
+	dashboardRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	dashboardRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		dashboardRoutes.GET("", handler.GetDashboard)
+	}
+}
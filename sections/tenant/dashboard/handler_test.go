@@ -0,0 +1,43 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSectionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.DeadlineExceeded, "timed out"},
+		{fmt.Errorf("query: %w", context.DeadlineExceeded), "timed out"},
+		{errors.New(`relation "t_alice.filesystem" does not exist`), "failed to load storage"},
+	}
+	for _, tt := range tests {
+		if got := sectionError("storage", tt.err); got != tt.want {
+			t.Errorf("sectionError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDashboardComplete(t *testing.T) {
+	ok := Section{OK: true}
+	d := DashboardResponse{
+		Generations:  GenerationsSection{Section: ok},
+		Credits:      CreditsSection{Section: ok},
+		Storage:      StorageSection{Section: ok},
+		Domains:      DomainsSection{Section: ok},
+		Subscription: SubscriptionSection{Section: ok},
+		RecentChats:  RecentChatsSection{Section: ok},
+	}
+	if !d.complete() {
+		t.Error("complete() = false with every section loaded")
+	}
+	d.RecentChats.Section = Section{Error: "timed out"}
+	if d.complete() {
+		t.Error("complete() = true with a failed section")
+	}
+}
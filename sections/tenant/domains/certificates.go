@@ -0,0 +1,170 @@
+package domains
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultPendingCertificatesLimit and MaxPendingCertificatesLimit bound
+	// the limit query parameter of ListPendingCertificates
+	DefaultPendingCertificatesLimit = 100
+	MaxPendingCertificatesLimit     = 1000
+)
+
+var errRequestNotPending = errors.New("certificate request is not pending")
+
+// CheckSSL runs a certificate check against the domain on demand
+func (h *Handler) CheckSSL(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	domainName := c.Param("domain")
+
+	var domain models.TenantDomain
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND domain = ?", tenantID, domainName).First(&domain).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load domain", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load domain"})
+		return
+	}
+
+	result, err := h.ssl.CheckDomain(c.Request.Context(), tenantID, &domain)
+	if err != nil {
+		h.logger.Error("Failed to record certificate check", "domain", domainName, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check certificate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ssl":    result,
+		"domain": h.toResponse(&domain),
+	})
+}
+
+// ListPendingCertificates returns pending certificate requests, oldest first,
+// for the external ACME worker
+func (h *Handler) ListPendingCertificates(c *gin.Context) {
+	limit := DefaultPendingCertificatesLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxPendingCertificatesLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = n
+	}
+
+	var requests []models.CertificateRequest
+	if err := h.deps.DB.DB.Where("status = ?", CertificateStatusPending).
+		Order("created_at").
+		Limit(limit).
+		Find(&requests).Error; err != nil {
+		h.logger.Error("Failed to list certificate requests", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list certificate requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"certificates": requests})
+}
+
+// UpdateCertificateRequest is the ACME worker's result for a pending request.
+// Issued certificates are also recorded on the tenant's domain.
+type UpdateCertificateRequest struct {
+	Status    string     `json:"status" binding:"required,oneof=issued failed"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Error     string     `json:"error"`
+}
+
+// UpdateCertificate applies the ACME worker's result to a certificate request
+func (h *Handler) UpdateCertificate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	var req UpdateCertificateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status == CertificateStatusIssued && req.ExpiresAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt is required for issued certificates"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+
+	var request models.CertificateRequest
+	err = h.deps.DB.DB.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, id).Error; err != nil {
+			return err
+		}
+		if request.Status != CertificateStatusPending {
+			return errRequestNotPending
+		}
+
+		request.Status = req.Status
+		request.Error = truncate(req.Error, 1000)
+		if req.Status == CertificateStatusIssued {
+			expiresAt := req.ExpiresAt.UTC()
+			request.IssuedAt = &now
+			request.ExpiresAt = &expiresAt
+		}
+		return tx.Model(&request).Select("status", "error", "issued_at", "expires_at").Updates(&request).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "certificate request not found"})
+		return
+	}
+	if errors.Is(err, errRequestNotPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": request.Status})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to update certificate request", "id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update certificate request"})
+		return
+	}
+
+	if request.Status == CertificateStatusIssued {
+		err := h.deps.DB.WithTenant(ctx, request.TenantSchema, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantDomain{}).
+				Where("tenant_schema = ? AND domain = ?", request.TenantSchema, request.Domain).
+				Updates(map[string]interface{}{
+					"ssl_enabled":       true,
+					"ssl_expires_at":    request.ExpiresAt,
+					"ssl_error":         "",
+					"ssl_reminder_sent": false,
+				}).Error
+		})
+		if err != nil {
+			// The next scheduled check records the certificate instead
+			h.logger.Error("Failed to record issued certificate on domain", "domain", request.Domain, "error", err)
+		}
+	}
+
+	h.logger.Info("Certificate request updated", "id", request.ID, "domain", request.Domain, "status", request.Status)
+
+	c.JSON(http.StatusOK, gin.H{"certificate": request})
+}
@@ -0,0 +1,197 @@
+package domains
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// JobKindSyncExpiry refreshes expiry dates of registered domains and
+	// sends renewal reminders
+	JobKindSyncExpiry = "domains.sync_expiry"
+
+	// ExpirySyncInterval is how often the sync job runs
+	ExpirySyncInterval = 24 * time.Hour
+)
+
+// ExpiryReminderDays are the thresholds, in days before expiry, at which
+// reminders are sent
+var ExpiryReminderDays = []int{30, 7, 1}
+
+// ExpiryNotifier is told when a registered domain crosses a reminder
+// threshold or a domain's certificate is about to expire
+type ExpiryNotifier interface {
+	DomainExpiring(ctx context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error
+	CertificateExpiring(ctx context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error
+}
+
+// LogExpiryNotifier logs reminders. It is used until an email service is
+// available.
+type LogExpiryNotifier struct{}
+
+func (LogExpiryNotifier) DomainExpiring(_ context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error {
+	slog.Info("Domain expiring", "tenant", tenantSchema, "domain", domain.Domain, "days_left", daysLeft, "expires_at", domain.ExpiresAt, "auto_renew", domain.AutoRenew)
+	return nil
+}
+
+func (LogExpiryNotifier) CertificateExpiring(_ context.Context, tenantSchema string, domain *models.TenantDomain, daysLeft int) error {
+	slog.Info("Certificate expiring", "tenant", tenantSchema, "domain", domain.Domain, "days_left", daysLeft, "expires_at", domain.SSLExpiresAt, "issuer", domain.SSLIssuer)
+	return nil
+}
+
+// ExpiryMonitor syncs expiry information from the registrar for every
+// tenant's registered domains and sends reminders as expiry approaches
+type ExpiryMonitor struct {
+	logger    *slog.Logger
+	db        *db.DB
+	registrar DomainRegistrar
+	notifier  ExpiryNotifier
+	now       func() time.Time
+}
+
+// NewExpiryMonitor creates a new expiry monitor. A nil notifier logs reminders.
+func NewExpiryMonitor(database *db.DB, registrar DomainRegistrar, notifier ExpiryNotifier) *ExpiryMonitor {
+	if notifier == nil {
+		notifier = LogExpiryNotifier{}
+	}
+	return &ExpiryMonitor{
+		logger:    slog.With("service", "DomainExpiryMonitor"),
+		db:        database,
+		registrar: registrar,
+		notifier:  notifier,
+		now:       time.Now,
+	}
+}
+
+// HandleSync is the jobs.Handler for JobKindSyncExpiry
+func (m *ExpiryMonitor) HandleSync(ctx context.Context, _ json.RawMessage) error {
+	return m.SyncAll(ctx)
+}
+
+// SyncAll syncs every registered domain of every tenant. Domains that fail
+// are logged and reported together once all tenants have been visited.
+func (m *ExpiryMonitor) SyncAll(ctx context.Context) error {
+	var schemas []string
+	if err := m.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	var errs []error
+	synced := 0
+	for _, schema := range schemas {
+		var domains []models.TenantDomain
+		err := m.db.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ? AND domain_type = ? AND registrar_id IS NOT NULL", schema, "registered").
+				Find(&domains).Error
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", schema, err))
+			continue
+		}
+
+		for i := range domains {
+			if err := m.syncDomain(ctx, schema, &domains[i]); err != nil {
+				m.logger.Error("Failed to sync domain expiry", "tenant", schema, "domain", domains[i].Domain, "error", err)
+				errs = append(errs, fmt.Errorf("domain %s: %w", domains[i].Domain, err))
+				continue
+			}
+			synced++
+		}
+	}
+
+	m.logger.Info("Domain expiry sync finished", "synced", synced, "failed", len(errs))
+	return errors.Join(errs...)
+}
+
+// syncDomain refreshes a domain from the registrar and sends a reminder when
+// a new threshold has been reached. Registrars without GetDomainInfo keep the
+// expiry recorded at registration.
+func (m *ExpiryMonitor) syncDomain(ctx context.Context, tenantSchema string, domain *models.TenantDomain) error {
+	updates := map[string]interface{}{}
+
+	info, err := m.registrar.GetDomainInfo(ctx, domain.Domain)
+	switch {
+	case err == nil:
+		if expiresAt := parseRegistrarTime(info.ExpiresAt); expiresAt != nil &&
+			(domain.ExpiresAt == nil || !expiresAt.Equal(*domain.ExpiresAt)) {
+			domain.ExpiresAt = expiresAt
+			domain.ExpiryReminderDays = 0
+			updates["expires_at"] = expiresAt
+			updates["expiry_reminder_days"] = 0
+		}
+		if info.AutoRenew != domain.AutoRenew {
+			domain.AutoRenew = info.AutoRenew
+			updates["auto_renew"] = info.AutoRenew
+		}
+	case !errors.Is(err, ErrNotImplemented):
+		return err
+	}
+
+	if domain.ExpiresAt != nil {
+		now := m.now()
+		if threshold := reminderDue(*domain.ExpiresAt, now, domain.ExpiryReminderDays); threshold > 0 {
+			if err := m.notifier.DomainExpiring(ctx, tenantSchema, domain, daysUntil(*domain.ExpiresAt, now)); err != nil {
+				return fmt.Errorf("failed to send reminder: %w", err)
+			}
+			domain.ExpiryReminderDays = threshold
+			updates["expiry_reminder_days"] = threshold
+		}
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	return m.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(domain).Updates(updates).Error
+	})
+}
+
+// daysUntil returns the whole days left before expiresAt, rounded up
+func daysUntil(expiresAt, now time.Time) int {
+	return int(math.Ceil(expiresAt.Sub(now).Hours() / 24))
+}
+
+// reminderDue returns the reminder threshold to send now, or 0. lastSent is
+// the smallest threshold already sent for this expiry (0 for none), so each
+// threshold is sent once and a missed one is not sent after a smaller one.
+func reminderDue(expiresAt, now time.Time, lastSent int) int {
+	if !now.Before(expiresAt) {
+		return 0
+	}
+
+	daysLeft := daysUntil(expiresAt, now)
+	due := 0
+	for _, threshold := range ExpiryReminderDays {
+		if daysLeft <= threshold && (due == 0 || threshold < due) {
+			due = threshold
+		}
+	}
+	if due == 0 || (lastSent != 0 && due >= lastSent) {
+		return 0
+	}
+	return due
+}
+
+// parseRegistrarTime parses an RFC3339 time from a registrar response,
+// returning nil when it is empty or malformed
+func parseRegistrarTime(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
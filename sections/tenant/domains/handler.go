@@ -1,32 +1,56 @@
 package domains
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"time"
 
+	"awning-backend/common"
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/webhooks"
 	"awning-backend/sections/models"
+	"awning-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var errDomainNotFound = errors.New("domain not found")
+
 // Handler handles domain-related requests
 type Handler struct {
 	logger    *slog.Logger
 	deps      *sections.Dependencies
 	registrar DomainRegistrar
+	stripeSvc *services.StripeService
+	ssl       *SSLMonitor
 }
 
-// NewHandler creates a new domains handler
-func NewHandler(deps *sections.Dependencies, registrar DomainRegistrar) *Handler {
+// NewHandler creates a new domains handler. stripeSvc may be nil, in which
+// case renewals are unavailable.
+func NewHandler(deps *sections.Dependencies, registrar DomainRegistrar, stripeSvc *services.StripeService) *Handler {
 	return &Handler{
 		logger:    slog.With("handler", "DomainsHandler"),
 		deps:      deps,
 		registrar: registrar,
+		stripeSvc: stripeSvc,
+		ssl:       NewSSLMonitor(deps.DB, expiryNotifier(deps)),
+	}
+}
+
+// expiryNotifier returns the notification service as the expiry notifier,
+// or nil to log reminders when there is none
+func expiryNotifier(deps *sections.Dependencies) ExpiryNotifier {
+	if deps.Notifications == nil {
+		return nil
 	}
+	return deps.Notifications
 }
 
 // DomainResponse represents a domain response
@@ -40,6 +64,24 @@ type DomainResponse struct {
 	SSLExpiresAt  *time.Time `json:"sslExpiresAt,omitempty"`
 	DNSConfigured bool       `json:"dnsConfigured"`
 	Primary       bool       `json:"primary"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	AutoRenew     bool       `json:"autoRenew"`
+	SSLIssuer     string     `json:"sslIssuer,omitempty"`
+	SSLError      string     `json:"sslError,omitempty"`
+	SSLCheckedAt  *time.Time `json:"sslCheckedAt,omitempty"`
+}
+
+// AddDomainRequest adds a domain the tenant already controls
+type AddDomainRequest struct {
+	Domain     string `json:"domain" binding:"required"`
+	DomainType string `json:"domainType"` // subdomain, custom, registered
+}
+
+// RegisterDomainRequest registers a new domain through the registrar
+type RegisterDomainRequest struct {
+	Domain  string       `json:"domain" binding:"required"`
+	Years   int          `json:"years" binding:"min=1,max=10"`
+	Contact *ContactInfo `json:"contact" binding:"required"`
 }
 
 // ListDomains retrieves all domains for a tenant
@@ -100,10 +142,7 @@ func (h *Handler) AddDomain(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Domain     string `json:"domain" binding:"required"`
-		DomainType string `json:"domainType"` // subdomain, custom, registered
-	}
+	var req AddDomainRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -120,16 +159,15 @@ func (h *Handler) AddDomain(c *gin.Context) {
 		Verified:     false,
 	}
 
-	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		return tx.Create(&domain).Error
-	})
-
+	err := h.createDomain(c.Request.Context(), tenantID, &domain)
 	if err != nil {
 		h.logger.Error("Failed to add domain", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add domain"})
 		return
 	}
 
+	h.invalidateHost(c.Request.Context(), tenantID, domain.Domain)
+
 	c.JSON(http.StatusCreated, h.toResponse(&domain))
 }
 
@@ -144,7 +182,38 @@ func (h *Handler) DeleteDomain(c *gin.Context) {
 	domainName := c.Param("domain")
 
 	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		return tx.Where("tenant_schema = ? AND domain = ?", tenantID, domainName).Delete(&models.TenantDomain{}).Error
+		return tx.Transaction(func(tx *gorm.DB) error {
+			var domain models.TenantDomain
+			if err := tx.Where("tenant_schema = ? AND domain = ?", tenantID, domainName).First(&domain).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+			if !domain.IsPrimary {
+				return tx.Delete(&domain).Error
+			}
+
+			// Soft-deleted rows stay in the partial unique index, so clear
+			// the flag before deleting
+			if err := tx.Model(&domain).Update("is_primary", false).Error; err != nil {
+				return err
+			}
+			if err := tx.Delete(&domain).Error; err != nil {
+				return err
+			}
+
+			// Promote the oldest remaining domain
+			var next models.TenantDomain
+			err := tx.Where("tenant_schema = ?", tenantID).Order("created_at").First(&next).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			return tx.Model(&next).Update("is_primary", true).Error
+		})
 	})
 
 	if err != nil {
@@ -153,6 +222,8 @@ func (h *Handler) DeleteDomain(c *gin.Context) {
 		return
 	}
 
+	h.invalidateHost(c.Request.Context(), tenantID, domainName)
+
 	c.JSON(http.StatusOK, gin.H{"message": "domain deleted"})
 }
 
@@ -167,25 +238,50 @@ func (h *Handler) SetPrimaryDomain(c *gin.Context) {
 	domainName := c.Param("domain")
 
 	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		// Unset all primary domains
-		if err := tx.Model(&models.TenantDomain{}).
-			Where("tenant_schema = ?", tenantID).
-			Update("primary", false).Error; err != nil {
-			return err
-		}
-
-		// Set the specified domain as primary
-		return tx.Model(&models.TenantDomain{}).
-			Where("tenant_schema = ? AND domain = ?", tenantID, domainName).
-			Update("primary", true).Error
+		return tx.Transaction(func(tx *gorm.DB) error {
+			// Lock the tenant's domains so concurrent calls apply one at a time
+			var domains []models.TenantDomain
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("tenant_schema = ?", tenantID).
+				Find(&domains).Error; err != nil {
+				return err
+			}
+
+			found := false
+			for _, d := range domains {
+				if d.Domain == domainName {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return errDomainNotFound
+			}
+
+			if err := tx.Model(&models.TenantDomain{}).
+				Where("tenant_schema = ? AND is_primary = ? AND domain <> ?", tenantID, true, domainName).
+				Update("is_primary", false).Error; err != nil {
+				return err
+			}
+
+			return tx.Model(&models.TenantDomain{}).
+				Where("tenant_schema = ? AND domain = ?", tenantID, domainName).
+				Update("is_primary", true).Error
+		})
 	})
 
+	if errors.Is(err, errDomainNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+		return
+	}
 	if err != nil {
 		h.logger.Error("Failed to set primary domain", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set primary domain"})
 		return
 	}
 
+	h.deps.InvalidateDashboard(c.Request.Context(), tenantID)
+
 	c.JSON(http.StatusOK, gin.H{"message": "primary domain set"})
 }
 
@@ -229,11 +325,7 @@ func (h *Handler) RegisterDomain(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		Domain  string       `json:"domain" binding:"required"`
-		Years   int          `json:"years" binding:"min=1,max=10"`
-		Contact *ContactInfo `json:"contact" binding:"required"`
-	}
+	var req RegisterDomainRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -264,12 +356,10 @@ func (h *Handler) RegisterDomain(c *gin.Context) {
 		VerifiedAt:    ptrTime(time.Now()),
 		RegistrarID:   &result.RegistrarID,
 		RegistrarName: &registrarName,
+		ExpiresAt:     parseRegistrarTime(result.ExpiresAt),
 	}
 
-	err = h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		return tx.Create(&domain).Error
-	})
-
+	err = h.createDomain(c.Request.Context(), tenantID, &domain)
 	if err != nil {
 		h.logger.Error("Failed to save registered domain", "error", err)
 		// Domain was registered but save failed - return partial success
@@ -280,12 +370,51 @@ func (h *Handler) RegisterDomain(c *gin.Context) {
 		return
 	}
 
+	h.invalidateHost(c.Request.Context(), tenantID, domain.Domain)
+
+	// Registered domains are verified on creation
+	h.deps.Webhooks.Emit(c.Request.Context(), tenantID, webhooks.EventDomainVerified, h.toResponse(&domain))
+
 	c.JSON(http.StatusCreated, gin.H{
 		"registration": result,
 		"domain":       h.toResponse(&domain),
 	})
 }
 
+// createDomain saves a new domain, making it primary when the tenant has no
+// primary domain yet
+func (h *Handler) createDomain(ctx context.Context, tenantID string, domain *models.TenantDomain) error {
+	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		var primaries int64
+		if err := tx.Model(&models.TenantDomain{}).
+			Where("tenant_schema = ? AND is_primary = ?", tenantID, true).
+			Count(&primaries).Error; err != nil {
+			return err
+		}
+		domain.IsPrimary = primaries == 0
+		return tx.Create(domain).Error
+	})
+	if err != nil && domain.IsPrimary {
+		// A concurrent request claimed primary first and the partial unique
+		// index rejected this row; save it as a secondary domain instead
+		domain.IsPrimary = false
+		domain.ID = 0
+		err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Create(domain).Error
+		})
+	}
+	return err
+}
+
+// invalidateHost drops the cached host to tenant mapping and the tenant's
+// dashboard after a domain change
+func (h *Handler) invalidateHost(ctx context.Context, tenantID, domain string) {
+	if h.deps.Sites != nil {
+		h.deps.Sites.InvalidateHost(ctx, domain)
+	}
+	h.deps.InvalidateDashboard(ctx, tenantID)
+}
+
 func ptrTime(t time.Time) *time.Time {
 	return &t
 }
@@ -296,17 +425,22 @@ func (h *Handler) toResponse(domain *models.TenantDomain) DomainResponse {
 		Domain:        domain.Domain,
 		DomainType:    domain.DomainType,
 		Verified:      domain.Verified,
-		VerifiedAt:    domain.VerifiedAt,
+		VerifiedAt:    common.UTC(domain.VerifiedAt),
 		SSLEnabled:    domain.SSLEnabled,
-		SSLExpiresAt:  domain.SSLExpiresAt,
+		SSLExpiresAt:  common.UTC(domain.SSLExpiresAt),
 		DNSConfigured: domain.DNSConfigured,
-		Primary:       domain.Primary,
+		Primary:       domain.IsPrimary,
+		ExpiresAt:     common.UTC(domain.ExpiresAt),
+		AutoRenew:     domain.AutoRenew,
+		SSLIssuer:     domain.SSLIssuer,
+		SSLError:      domain.SSLError,
+		SSLCheckedAt:  common.UTC(domain.SSLCheckedAt),
 	}
 }
 
 // RegisterRoutes registers domain-related routes
-func RegisterRoutes(r *gin.Engine, deps *sections.Dependencies, jwtManager *auth.JWTManager, registrar DomainRegistrar) {
-	handler := NewHandler(deps, registrar)
+func RegisterRoutes(r *gin.Engine, deps *sections.Dependencies, jwtManager *auth.JWTManager, registrar DomainRegistrar, stripeSvc *services.StripeService) {
+	handler := NewHandler(deps, registrar, stripeSvc)
 
 	tenantCfg := auth.DefaultTenantMiddlewareConfig()
 
@@ -315,11 +449,22 @@ func RegisterRoutes(r *gin.Engine, deps *sections.Dependencies, jwtManager *auth
 	domainRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
 	{
 		domainRoutes.GET("", handler.ListDomains)
+		domainRoutes.GET("/expiring", handler.ListExpiringDomains)
 		domainRoutes.GET("/:domain", handler.GetDomain)
 		domainRoutes.POST("", handler.AddDomain)
 		domainRoutes.DELETE("/:domain", handler.DeleteDomain)
 		domainRoutes.POST("/:domain/primary", handler.SetPrimaryDomain)
+		domainRoutes.POST("/:domain/renew", handler.RenewDomain)
+		domainRoutes.POST("/:domain/ssl/check", handler.CheckSSL)
 		domainRoutes.GET("/check", handler.CheckDomainAvailability)
-		domainRoutes.POST("/register", handler.RegisterDomain)
+		domainRoutes.POST("/register", deps.Flags.Require(flags.DomainRegistration), deps.Idempotency(), handler.RegisterDomain)
+	}
+
+	// Internal routes for the ACME worker, authenticated with the server API key
+	internalRoutes := r.Group("/api/v1/internal/certificates")
+	internalRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		internalRoutes.GET("/pending", handler.ListPendingCertificates)
+		internalRoutes.PATCH("/:id", handler.UpdateCertificate)
 	}
 }
@@ -0,0 +1,283 @@
+package domains
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v84"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// renewalPurpose marks payment intents created for domain renewals
+	renewalPurpose = "domain_renewal"
+
+	// MaxExpiringDays bounds the days query parameter of ListExpiringDomains
+	MaxExpiringDays = 365
+)
+
+var (
+	errNotRegistered   = errors.New("domain is not registered through the registrar")
+	errRenewalApplied  = errors.New("renewal already applied for this payment")
+	errPaymentMismatch = errors.New("payment intent does not match this renewal")
+)
+
+// RenewDomainRequest starts or completes a renewal. Without PaymentIntentID a
+// payment intent is created; once the client has confirmed it, the same
+// endpoint is called again with its ID to renew the domain.
+type RenewDomainRequest struct {
+	Years           int    `json:"years" binding:"omitempty,min=1,max=10"`
+	PaymentIntentID string `json:"paymentIntentId"`
+}
+
+// RenewDomain charges the tenant for a renewal and, once the payment has
+// succeeded, renews the domain with the registrar
+func (h *Handler) RenewDomain(c *gin.Context) {
+	if h.registrar == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "domain registrar not configured"})
+		return
+	}
+	if h.stripeSvc == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "payments not configured"})
+		return
+	}
+
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var req RenewDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Years == 0 {
+		req.Years = 1
+	}
+
+	domainName := c.Param("domain")
+
+	var domain models.TenantDomain
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND domain = ?", tenantID, domainName).First(&domain).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domain not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load domain", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load domain"})
+		return
+	}
+	if domain.DomainType != "registered" || domain.RegistrarID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errNotRegistered.Error()})
+		return
+	}
+
+	if req.PaymentIntentID == "" {
+		h.startRenewalPayment(c, tenantID, &domain, req.Years)
+		return
+	}
+	h.completeRenewal(c, tenantID, &domain, req.PaymentIntentID)
+}
+
+// startRenewalPayment creates the payment intent for a renewal and records it
+// as a pending payment
+func (h *Handler) startRenewalPayment(c *gin.Context, tenantID string, domain *models.TenantDomain, years int) {
+	ctx := c.Request.Context()
+
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var user models.User
+	if err := h.deps.DB.DB.First(&user, claims.UserID).Error; err != nil {
+		h.logger.Error("Failed to get user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
+		return
+	}
+
+	customerID, err := h.deps.StripeCustomerID(ctx, h.stripeSvc, &user, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to get or create customer", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create customer"})
+		return
+	}
+
+	amount := int64(h.deps.Config.DomainRenewalPriceCents) * int64(years)
+	currency := h.deps.Config.DomainRenewalCurrency
+	description := fmt.Sprintf("Domain renewal: %s (%d year(s))", domain.Domain, years)
+	metadata := map[string]string{
+		"purpose":       renewalPurpose,
+		"tenant_schema": tenantID,
+		"domain":        domain.Domain,
+		"years":         strconv.Itoa(years),
+	}
+
+	pi, err := h.stripeSvc.CreatePaymentIntent(ctx, amount, currency, customerID, description, metadata)
+	if err != nil {
+		h.logger.Error("Failed to create payment intent", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment intent"})
+		return
+	}
+
+	metadataJSON, _ := json.Marshal(metadata)
+	payment := models.Payment{
+		TenantSchema:          tenantID,
+		UserID:                user.ID,
+		StripePaymentIntentID: pi.ID,
+		StripeCustomerID:      customerID,
+		Amount:                amount,
+		Currency:              currency,
+		Status:                "pending",
+		Description:           description,
+		Metadata:              string(metadataJSON),
+	}
+	if err := h.deps.DB.DB.Create(&payment).Error; err != nil {
+		h.logger.Error("Failed to save renewal payment", "payment_intent_id", pi.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save payment"})
+		return
+	}
+
+	h.logger.Info("Created domain renewal payment", "tenant", tenantID, "domain", domain.Domain, "years", years, "payment_intent_id", pi.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"paymentIntentId": pi.ID,
+		"clientSecret":    pi.ClientSecret,
+		"amount":          amount,
+		"currency":        currency,
+		"years":           years,
+	})
+}
+
+// completeRenewal renews the domain once its payment intent has succeeded.
+// The domain row stays locked during the registrar call so a payment is
+// applied at most once; if the registrar fails the call can be retried with
+// the same payment intent.
+func (h *Handler) completeRenewal(c *gin.Context, tenantID string, domain *models.TenantDomain, paymentIntentID string) {
+	ctx := c.Request.Context()
+
+	pi, err := h.stripeSvc.GetPaymentIntent(ctx, paymentIntentID)
+	if err != nil {
+		h.logger.Error("Failed to get payment intent", "payment_intent_id", paymentIntentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify payment"})
+		return
+	}
+	if pi.Metadata["purpose"] != renewalPurpose || pi.Metadata["tenant_schema"] != tenantID || pi.Metadata["domain"] != domain.Domain {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errPaymentMismatch.Error()})
+		return
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "payment has not succeeded", "status": pi.Status})
+		return
+	}
+
+	years, err := strconv.Atoi(pi.Metadata["years"])
+	if err != nil || years < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errPaymentMismatch.Error()})
+		return
+	}
+
+	var result *RenewalResult
+	err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(domain, domain.ID).Error; err != nil {
+				return err
+			}
+			if domain.RenewalPaymentIntentID != nil && *domain.RenewalPaymentIntentID == pi.ID {
+				return errRenewalApplied
+			}
+
+			result, err = h.registrar.RenewDomain(ctx, domain.Domain, years)
+			if err != nil {
+				return err
+			}
+
+			updates := map[string]interface{}{
+				"renewal_payment_intent_id": pi.ID,
+				"expiry_reminder_days":      0,
+			}
+			if expiresAt := parseRegistrarTime(result.NewExpiresAt); expiresAt != nil {
+				domain.ExpiresAt = expiresAt
+				updates["expires_at"] = expiresAt
+			}
+			return tx.Model(domain).Updates(updates).Error
+		})
+	})
+	if errors.Is(err, errRenewalApplied) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrNotImplemented) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "domain renewal not implemented for this registrar"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Domain renewal failed after payment", "tenant", tenantID, "domain", domain.Domain, "payment_intent_id", pi.ID, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "payment received but renewal failed; retry with the same paymentIntentId"})
+		return
+	}
+
+	h.logger.Info("Domain renewed", "tenant", tenantID, "domain", domain.Domain, "years", years, "expires_at", domain.ExpiresAt)
+	h.deps.InvalidateDashboard(c.Request.Context(), tenantID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"renewal": result,
+		"domain":  h.toResponse(domain),
+	})
+}
+
+// ListExpiringDomains returns the tenant's domains expiring within ?days=
+// (default the largest reminder threshold), soonest first
+func (h *Handler) ListExpiringDomains(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	days := ExpiryReminderDays[0]
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxExpiringDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", MaxExpiringDays)})
+			return
+		}
+		days = n
+	}
+
+	before := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+
+	var domains []models.TenantDomain
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND expires_at IS NOT NULL AND expires_at <= ?", tenantID, before).
+			Order("expires_at").
+			Find(&domains).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list expiring domains", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list domains"})
+		return
+	}
+
+	responses := make([]DomainResponse, len(domains))
+	for i := range domains {
+		responses[i] = h.toResponse(&domains[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"domains": responses, "days": days})
+}
@@ -0,0 +1,273 @@
+package domains
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// JobKindCheckSSL checks the certificate of every verified custom domain
+	JobKindCheckSSL = "domains.check_ssl"
+
+	// SSLCheckInterval is how often the check job runs
+	SSLCheckInterval = 12 * time.Hour
+	// SSLExpiryWarningDays is how close to expiry a certificate triggers a reminder
+	SSLExpiryWarningDays = 14
+	// SSLHandshakeTimeout bounds the connection and handshake of each check
+	SSLHandshakeTimeout = 10 * time.Second
+
+	CertificateStatusPending = "pending"
+	CertificateStatusIssued  = "issued"
+	CertificateStatusFailed  = "failed"
+)
+
+// SSLCheckResult is the outcome of a TLS handshake against a domain
+type SSLCheckResult struct {
+	Valid         bool      `json:"valid"`
+	Issuer        string    `json:"issuer"`
+	NotAfter      time.Time `json:"notAfter"`
+	HostnameMatch bool      `json:"hostnameMatch"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// SSLChecker performs TLS handshakes and verifies the presented certificate
+type SSLChecker struct {
+	timeout time.Duration
+	roots   *x509.CertPool // nil uses the system roots
+	addr    func(domain string) string
+	now     func() time.Time
+}
+
+// NewSSLChecker creates a checker connecting to port 443 of each domain
+func NewSSLChecker() *SSLChecker {
+	return &SSLChecker{
+		timeout: SSLHandshakeTimeout,
+		addr: func(domain string) string {
+			return net.JoinHostPort(domain, "443")
+		},
+		now: time.Now,
+	}
+}
+
+// Check connects to the domain and reports the certificate it presents. An
+// error means no certificate could be read; an invalid certificate is
+// reported in the result.
+func (c *SSLChecker) Check(ctx context.Context, domain string) (*SSLCheckResult, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: c.timeout},
+		// Verification happens below so invalid certificates can be described
+		Config: &tls.Config{ServerName: domain, InsecureSkipVerify: true},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr(domain))
+	if err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	result := &SSLCheckResult{
+		Issuer:        issuerName(leaf),
+		NotAfter:      leaf.NotAfter.UTC(),
+		HostnameMatch: leaf.VerifyHostname(domain) == nil,
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         c.roots,
+		Intermediates: intermediates,
+		CurrentTime:   c.now(),
+	})
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Valid = true
+	}
+
+	return result, nil
+}
+
+func issuerName(cert *x509.Certificate) string {
+	if cert.Issuer.CommonName != "" {
+		return cert.Issuer.CommonName
+	}
+	if len(cert.Issuer.Organization) > 0 {
+		return cert.Issuer.Organization[0]
+	}
+	return cert.Issuer.String()
+}
+
+// SSLMonitor records certificate checks on tenant domains, requests
+// certificates for verified domains without a valid one and warns when a
+// certificate is about to expire
+type SSLMonitor struct {
+	logger   *slog.Logger
+	db       *db.DB
+	checker  *SSLChecker
+	notifier ExpiryNotifier
+	now      func() time.Time
+}
+
+// NewSSLMonitor creates a new SSL monitor. A nil notifier logs reminders.
+func NewSSLMonitor(database *db.DB, notifier ExpiryNotifier) *SSLMonitor {
+	if notifier == nil {
+		notifier = LogExpiryNotifier{}
+	}
+	return &SSLMonitor{
+		logger:   slog.With("service", "DomainSSLMonitor"),
+		db:       database,
+		checker:  NewSSLChecker(),
+		notifier: notifier,
+		now:      time.Now,
+	}
+}
+
+// HandleCheck is the jobs.Handler for JobKindCheckSSL
+func (m *SSLMonitor) HandleCheck(ctx context.Context, _ json.RawMessage) error {
+	return m.CheckAll(ctx)
+}
+
+// CheckAll checks every verified custom or registered domain of every
+// tenant. Subdomains of the site base domain are served with the platform
+// certificate and are skipped.
+func (m *SSLMonitor) CheckAll(ctx context.Context) error {
+	var schemas []string
+	if err := m.db.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	var errs []error
+	checked := 0
+	for _, schema := range schemas {
+		var domains []models.TenantDomain
+		err := m.db.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ? AND verified = ? AND domain_type <> ?", schema, true, "subdomain").
+				Find(&domains).Error
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", schema, err))
+			continue
+		}
+
+		for i := range domains {
+			if _, err := m.CheckDomain(ctx, schema, &domains[i]); err != nil {
+				m.logger.Error("Failed to check domain certificate", "tenant", schema, "domain", domains[i].Domain, "error", err)
+				errs = append(errs, fmt.Errorf("domain %s: %w", domains[i].Domain, err))
+				continue
+			}
+			checked++
+		}
+	}
+
+	m.logger.Info("Domain certificate check finished", "checked", checked, "failed", len(errs))
+	return errors.Join(errs...)
+}
+
+// CheckDomain runs a TLS check against the domain and saves the outcome. A
+// failed handshake is recorded on the domain rather than returned; the error
+// is only for failures to save or notify.
+func (m *SSLMonitor) CheckDomain(ctx context.Context, tenantSchema string, domain *models.TenantDomain) (*SSLCheckResult, error) {
+	now := m.now().UTC()
+
+	result, err := m.checker.Check(ctx, domain.Domain)
+	if err != nil {
+		result = &SSLCheckResult{Error: err.Error()}
+	}
+
+	updates := map[string]interface{}{
+		"ssl_enabled":        result.Valid,
+		"ssl_issuer":         result.Issuer,
+		"ssl_hostname_match": result.HostnameMatch,
+		"ssl_error":          truncate(result.Error, 500),
+		"ssl_checked_at":     now,
+	}
+	domain.SSLEnabled = result.Valid
+	domain.SSLIssuer = result.Issuer
+	domain.SSLHostnameMatch = result.HostnameMatch
+	domain.SSLError = result.Error
+	domain.SSLCheckedAt = &now
+
+	if !result.NotAfter.IsZero() && (domain.SSLExpiresAt == nil || !result.NotAfter.Equal(*domain.SSLExpiresAt)) {
+		notAfter := result.NotAfter
+		domain.SSLExpiresAt = &notAfter
+		domain.SSLReminderSent = false
+		updates["ssl_expires_at"] = notAfter
+		updates["ssl_reminder_sent"] = false
+	}
+
+	if result.Valid && !domain.SSLReminderSent {
+		if daysLeft := daysUntil(result.NotAfter, now); daysLeft < SSLExpiryWarningDays {
+			if err := m.notifier.CertificateExpiring(ctx, tenantSchema, domain, daysLeft); err != nil {
+				return result, fmt.Errorf("failed to send reminder: %w", err)
+			}
+			domain.SSLReminderSent = true
+			updates["ssl_reminder_sent"] = true
+		}
+	}
+
+	err = m.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(domain).Updates(updates).Error
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if domain.Verified && !result.Valid {
+		if err := m.requestCertificate(ctx, tenantSchema, domain.Domain); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// requestCertificate records a pending certificate request for the ACME
+// worker unless one is already pending for the domain
+func (m *SSLMonitor) requestCertificate(ctx context.Context, tenantSchema, domain string) error {
+	request := models.CertificateRequest{
+		TenantSchema: tenantSchema,
+		Domain:       domain,
+		Status:       CertificateStatusPending,
+	}
+	res := m.db.DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&request)
+	if res.Error != nil {
+		return fmt.Errorf("failed to request certificate: %w", res.Error)
+	}
+	if res.RowsAffected > 0 {
+		m.logger.Info("Requested certificate", "tenant", tenantSchema, "domain", domain, "request_id", request.ID)
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,535 @@
+package filesystem
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// ExportPath is routed to Export by getEntryOrSearch, so an entry keyed
+	// "export" can't be read with GET
+	ExportPath = "/export"
+
+	ManifestName    = "manifest.json"
+	ManifestVersion = 1
+
+	// Limits on imported archives
+	MaxImportBytes      = 64 << 20
+	MaxImportEntries    = 5000
+	MaxImportEntryBytes = 8 << 20
+
+	exportBatchSize = 100
+	archiveFilesDir = "files/"
+)
+
+// Import modes: merge keeps entries missing from the archive, replace deletes them
+const (
+	ImportModeMerge   = "merge"
+	ImportModeReplace = "replace"
+)
+
+// Per-entry import outcomes
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportConflict  = "conflict"
+	ImportDeleted   = "deleted"
+	ImportFailed    = "failed"
+)
+
+// ArchiveManifest lists the entries of an export archive
+type ArchiveManifest struct {
+	Version      int                    `json:"version"`
+	TenantSchema string                 `json:"tenantSchema"`
+	ExportedAt   string                 `json:"exportedAt"`
+	Entries      []ArchiveManifestEntry `json:"entries"`
+}
+
+// ArchiveManifestEntry maps an archive file back to its entry. Checksum is
+// the entry's checksum when exported and FileChecksum that of the file.
+// Raw entries are JSON strings written as their text.
+type ArchiveManifestEntry struct {
+	Key          string `json:"key"`
+	Path         string `json:"path"`
+	ContentType  string `json:"contentType"`
+	Raw          bool   `json:"raw,omitempty"`
+	Size         int64  `json:"size"`
+	Checksum     string `json:"checksum"`
+	FileChecksum string `json:"fileChecksum"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// ImportResult is the outcome of importing one entry
+type ImportResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Export streams a zip of every filesystem entry of the tenant the member
+// may read, with a manifest.json written last
+func (h *Handler) Export(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	manifest := ArchiveManifest{
+		Version:      ManifestVersion,
+		TenantSchema: tenantID,
+		ExportedAt:   common.FormatTime(now),
+		Entries:      []ArchiveManifestEntry{},
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="filesystem-%s-%s.zip"`, tenantID, now.Format("20060102T150405Z")))
+	c.Status(http.StatusOK)
+
+	zw := zip.NewWriter(c.Writer)
+	paths := map[string]bool{}
+
+	var batch []models.TenantFilesystem
+	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		query := accessFromContext(c).excludeUnreadable(tx.Where("tenant_schema = ?", tenantID))
+		return query.Order("id").FindInBatches(&batch, exportBatchSize, func(_ *gorm.DB, _ int) error {
+			for i := range batch {
+				entry, err := writeArchiveEntry(zw, &batch[i], paths)
+				if err != nil {
+					return err
+				}
+				manifest.Entries = append(manifest.Entries, entry)
+			}
+			return nil
+		}).Error
+	})
+	if err == nil {
+		err = writeManifest(zw, &manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// The status is already sent; a truncated zip fails to open
+		h.logger.Error("Failed to export filesystem", "tenant", tenantID, "error", err)
+		return
+	}
+
+	h.logger.Info("Exported filesystem", "tenant", tenantID, "entries", len(manifest.Entries))
+}
+
+// writeArchiveEntry adds one entry to the archive and returns its manifest entry
+func writeArchiveEntry(zw *zip.Writer, e *models.TenantFilesystem, paths map[string]bool) (ArchiveManifestEntry, error) {
+	content, raw := archiveContent(e)
+
+	entryPath := archivePath(e.Key, archiveExtension(e.ContentType, raw))
+	for i := 2; paths[entryPath]; i++ {
+		entryPath = archivePath(fmt.Sprintf("%s~%d", e.Key, i), archiveExtension(e.ContentType, raw))
+	}
+	paths[entryPath] = true
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: entryPath, Method: zip.Deflate, Modified: e.UpdatedAt})
+	if err != nil {
+		return ArchiveManifestEntry{}, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return ArchiveManifestEntry{}, err
+	}
+
+	sum := sha256.Sum256(content)
+	return ArchiveManifestEntry{
+		Key:          e.Key,
+		Path:         entryPath,
+		ContentType:  e.ContentType,
+		Raw:          raw,
+		Size:         e.Size,
+		Checksum:     e.Checksum,
+		FileChecksum: hex.EncodeToString(sum[:]),
+		UpdatedAt:    common.FormatTime(e.UpdatedAt),
+	}, nil
+}
+
+func writeManifest(zw *zip.Writer, manifest *ArchiveManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create(ManifestName)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// archiveContent returns the file content of an entry: the text of a JSON
+// string when the content type isn't JSON, otherwise pretty-printed JSON
+func archiveContent(e *models.TenantFilesystem) ([]byte, bool) {
+	if !isJSONContentType(e.ContentType) {
+		var text string
+		if err := json.Unmarshal([]byte(e.Data), &text); err == nil {
+			return []byte(text), true
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(e.Data), "", "  "); err != nil {
+		return []byte(e.Data), false
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), false
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return contentType == "" || mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// archiveExtension guesses the file extension for an entry
+func archiveExtension(contentType string, raw bool) string {
+	if !raw {
+		return ".json"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/html":
+		return ".html"
+	case "text/plain":
+		return ".txt"
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".txt"
+}
+
+// archivePath turns a key into a file path under files/, replacing path
+// segments a zip reader would refuse or resolve
+func archivePath(key, ext string) string {
+	segments := strings.Split(strings.Trim(key, "/"), "/")
+	for i, s := range segments {
+		if s == "" || s == "." || s == ".." {
+			segments[i] = "_"
+		}
+	}
+	return archiveFilesDir + strings.Join(segments, "/") + ext
+}
+
+// Import restores entries from an archive made by Export, sent as the
+// request body or as the multipart field file. mode is merge (default) or
+// replace. An entry whose current checksum differs from the one in the
+// manifest is a conflict and skipped, unless on_conflict=overwrite. Each
+// entry is written in its own transaction. Entries the member may not write
+// fail, and replace only deletes entries the member may write.
+func (h *Handler) Import(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	mode := c.DefaultQuery("mode", ImportModeMerge)
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be merge or replace"})
+		return
+	}
+	onConflict := c.DefaultQuery("on_conflict", "skip")
+	if onConflict != "skip" && onConflict != "overwrite" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_conflict must be skip or overwrite"})
+		return
+	}
+
+	archive, err := readImportBody(c)
+	if err != nil {
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zip archive"})
+		return
+	}
+
+	manifest, files, err := readManifest(zr)
+	if err != nil {
+		i18n.Error(c, http.StatusBadRequest, "invalid_manifest", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	access := accessFromContext(c)
+	results := make([]ImportResult, 0, len(manifest.Entries))
+	imported := map[string]bool{}
+	counts := map[string]int{}
+
+	for _, entry := range manifest.Entries {
+		imported[entry.Key] = true
+		if !access.canWrite(entry.Key) {
+			counts[ImportFailed]++
+			results = append(results, ImportResult{Key: entry.Key, Status: ImportFailed, Reason: "not allowed to write this key"})
+			continue
+		}
+		result := h.importEntry(ctx, tenantID, entry, files[entry.Path], onConflict == "overwrite" || mode == ImportModeReplace)
+		counts[result.Status]++
+		results = append(results, result)
+	}
+
+	if mode == ImportModeReplace {
+		deleted, err := h.deleteMissing(ctx, tenantID, access, imported)
+		for _, key := range deleted {
+			counts[ImportDeleted]++
+			results = append(results, ImportResult{Key: key, Status: ImportDeleted})
+		}
+		if err != nil {
+			h.logger.Error("Failed to delete entries missing from import", "tenant", tenantID, "error", err)
+			counts[ImportFailed]++
+			results = append(results, ImportResult{Status: ImportFailed, Reason: "failed to delete entries missing from the archive"})
+		}
+	}
+
+	h.logger.Info("Imported filesystem", "tenant", tenantID, "mode", mode, "counts", counts)
+
+	c.JSON(http.StatusOK, gin.H{"mode": mode, "results": results, "counts": counts})
+}
+
+// readImportBody reads the archive from the multipart field file or the
+// request body, up to MaxImportBytes
+func readImportBody(c *gin.Context) ([]byte, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxImportBytes+1<<20)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, MaxImportBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxImportBytes {
+		return nil, &http.MaxBytesError{Limit: MaxImportBytes}
+	}
+	return data, nil
+}
+
+// readManifest reads and checks the manifest, returning it with the archive
+// files by path
+func readManifest(zr *zip.Reader) (*ArchiveManifest, map[string]*zip.File, error) {
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	mf, ok := files[ManifestName]
+	if !ok {
+		return nil, nil, fmt.Errorf("archive has no %s", ManifestName)
+	}
+	data, err := readArchiveFile(mf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", ManifestName, err)
+	}
+
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", ManifestName, err)
+	}
+	if manifest.Version != ManifestVersion {
+		return nil, nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	if len(manifest.Entries) > MaxImportEntries {
+		return nil, nil, fmt.Errorf("archive has %d entries, more than %d", len(manifest.Entries), MaxImportEntries)
+	}
+
+	keys := map[string]bool{}
+	for _, entry := range manifest.Entries {
+		if entry.Key == "" || len(entry.Key) > 255 {
+			return nil, nil, fmt.Errorf("invalid key %q", entry.Key)
+		}
+		if keys[entry.Key] {
+			return nil, nil, fmt.Errorf("duplicate key %q", entry.Key)
+		}
+		keys[entry.Key] = true
+	}
+
+	return &manifest, files, nil
+}
+
+// readArchiveFile reads a file from the archive, up to MaxImportEntryBytes
+func readArchiveFile(f *zip.File) ([]byte, error) {
+	if f.UncompressedSize64 > MaxImportEntryBytes {
+		return nil, fmt.Errorf("larger than %d bytes", MaxImportEntryBytes)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, MaxImportEntryBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxImportEntryBytes {
+		return nil, fmt.Errorf("larger than %d bytes", MaxImportEntryBytes)
+	}
+	return data, nil
+}
+
+// importEntry writes one manifest entry. An existing entry whose checksum
+// differs from the manifest's is only replaced with overwrite.
+func (h *Handler) importEntry(ctx context.Context, tenantID string, entry ArchiveManifestEntry, file *zip.File, overwrite bool) ImportResult {
+	result := ImportResult{Key: entry.Key}
+	fail := func(reason string) ImportResult {
+		result.Status = ImportFailed
+		result.Reason = reason
+		return result
+	}
+
+	if file == nil {
+		return fail("file " + entry.Path + " missing from archive")
+	}
+	content, err := readArchiveFile(file)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if sum := sha256.Sum256(content); entry.FileChecksum != "" && hex.EncodeToString(sum[:]) != entry.FileChecksum {
+		return fail("file checksum mismatch")
+	}
+
+	data, err := importData(content, entry.Raw)
+	if err != nil {
+		return fail(err.Error())
+	}
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	dataSum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(dataSum[:])
+
+	err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			var existing models.TenantFilesystem
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("tenant_schema = ? AND key = ?", tenantID, entry.Key).First(&existing).Error
+			switch {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				existing = models.TenantFilesystem{TenantSchema: tenantID, Key: entry.Key}
+				result.Status = ImportCreated
+			case err != nil:
+				return err
+			case existing.Checksum == entry.Checksum || existing.Checksum == checksum:
+				// Already holds the exported content
+				result.Status = ImportUnchanged
+				return nil
+			case !overwrite:
+				result.Status = ImportConflict
+				result.Reason = "entry changed since export"
+				return nil
+			default:
+				result.Status = ImportUpdated
+			}
+
+			existing.Data = string(data)
+			existing.ContentType = contentType
+			existing.Size = int64(len(data))
+			existing.Checksum = checksum
+			return tx.Save(&existing).Error
+		})
+	})
+	if err != nil {
+		h.logger.Error("Failed to import filesystem entry", "tenant", tenantID, "key", entry.Key, "error", err)
+		return fail("failed to save entry")
+	}
+
+	if (result.Status == ImportCreated || result.Status == ImportUpdated) && h.deps.KV != nil {
+		h.invalidateCache(ctx, tenantID, entry.Key)
+	}
+	return result
+}
+
+// importData turns an archive file back into the entry's JSON data
+func importData(content []byte, raw bool) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if raw {
+		// Unescaped, so exported HTML comes back byte for byte
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(string(content)); err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+	}
+	if err := json.Compact(&buf, content); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// deleteMissing deletes the tenant's entries that aren't in keep and access
+// may write, returning the deleted keys
+func (h *Handler) deleteMissing(ctx context.Context, tenantID string, access *keyAccess, keep map[string]bool) ([]string, error) {
+	var keys []string
+	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantFilesystem{}).Where("tenant_schema = ?", tenantID).Order("key").Pluck("key", &keys).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, key := range keys {
+		if keep[key] || !access.canWrite(key) {
+			continue
+		}
+		err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ? AND key = ?", tenantID, key).Delete(&models.TenantFilesystem{}).Error
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if h.deps.KV != nil {
+			h.invalidateCache(ctx, tenantID, key)
+		}
+		deleted = append(deleted, key)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,158 @@
+package filesystem
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"awning-backend/sections/models"
+)
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		key, ext, want string
+	}{
+		{"site/index", ".json", "files/site/index.json"},
+		{"/site//index/", ".json", "files/site/_/index.json"},
+		{"../../etc/passwd", ".txt", "files/_/_/etc/passwd.txt"},
+		{"drafts/./page", ".html", "files/drafts/_/page.html"},
+	}
+	for _, tt := range tests {
+		if got := archivePath(tt.key, tt.ext); got != tt.want {
+			t.Errorf("archivePath(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveContentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		entry       models.TenantFilesystem
+		raw         bool
+		ext         string
+		fileContent string
+	}{
+		{"json", models.TenantFilesystem{Data: `{"a":1,"b":[true]}`, ContentType: "application/json"}, false, ".json", "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}\n"},
+		{"html string", models.TenantFilesystem{Data: `"<h1>Hi</h1>\n"`, ContentType: "text/html; charset=utf-8"}, true, ".html", "<h1>Hi</h1>\n"},
+		{"text string", models.TenantFilesystem{Data: `"notes"`, ContentType: "text/plain"}, true, ".txt", "notes"},
+		// A JSON string stays JSON when the content type says so
+		{"json string", models.TenantFilesystem{Data: `"notes"`, ContentType: "application/json"}, false, ".json", "\"notes\"\n"},
+		{"json object as text", models.TenantFilesystem{Data: `{"a":1}`, ContentType: "text/plain"}, false, ".json", "{\n  \"a\": 1\n}\n"},
+	}
+	for _, tt := range tests {
+		content, raw := archiveContent(&tt.entry)
+		if raw != tt.raw || string(content) != tt.fileContent {
+			t.Errorf("%s: archiveContent() = %q (raw %v), want %q (raw %v)", tt.name, content, raw, tt.fileContent, tt.raw)
+		}
+		if ext := archiveExtension(tt.entry.ContentType, raw); ext != tt.ext {
+			t.Errorf("%s: archiveExtension() = %q, want %q", tt.name, ext, tt.ext)
+		}
+
+		data, err := importData(content, raw)
+		if err != nil {
+			t.Errorf("%s: importData() error = %v", tt.name, err)
+			continue
+		}
+		if string(data) != tt.entry.Data {
+			t.Errorf("%s: importData() = %s, want the entry's %s", tt.name, data, tt.entry.Data)
+		}
+	}
+
+	if _, err := importData([]byte("{not json"), false); err == nil {
+		t.Error("importData() of invalid JSON error = nil")
+	}
+}
+
+// testArchive zips files, with a manifest listing entries unless it is nil
+func testArchive(t *testing.T, manifest *ArchiveManifest, files map[string]string) *zip.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if manifest != nil {
+		if err := writeManifest(zw, manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestReadManifest(t *testing.T) {
+	entry := func(key string) ArchiveManifestEntry {
+		return ArchiveManifestEntry{Key: key, Path: archivePath(key, ".json")}
+	}
+	tooMany := make([]ArchiveManifestEntry, MaxImportEntries+1)
+	for i := range tooMany {
+		tooMany[i] = entry(fmt.Sprintf("page-%d", i))
+	}
+
+	tests := []struct {
+		name     string
+		manifest *ArchiveManifest
+		want     string
+	}{
+		{"no manifest", nil, "archive has no manifest.json"},
+		{"old version", &ArchiveManifest{Version: 0}, "unsupported manifest version 0"},
+		{"too many entries", &ArchiveManifest{Version: ManifestVersion, Entries: tooMany}, "more than 5000"},
+		{"empty key", &ArchiveManifest{Version: ManifestVersion, Entries: []ArchiveManifestEntry{entry("")}}, `invalid key ""`},
+		{"long key", &ArchiveManifest{Version: ManifestVersion, Entries: []ArchiveManifestEntry{entry(strings.Repeat("k", 256))}}, "invalid key"},
+		{"duplicate key", &ArchiveManifest{Version: ManifestVersion, Entries: []ArchiveManifestEntry{entry("a"), entry("a")}}, `duplicate key "a"`},
+	}
+	for _, tt := range tests {
+		_, _, err := readManifest(testArchive(t, tt.manifest, nil))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: readManifest() error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	manifest := &ArchiveManifest{Version: ManifestVersion, Entries: []ArchiveManifestEntry{entry("site/index")}}
+	got, files, err := readManifest(testArchive(t, manifest, map[string]string{"files/site/index.json": "{}"}))
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+	if len(got.Entries) != 1 || files[got.Entries[0].Path] == nil {
+		t.Errorf("readManifest() = %+v with files %v, want the entry's file", got, files)
+	}
+}
+
+func TestReadArchiveFileLimit(t *testing.T) {
+	zr := testArchive(t, nil, map[string]string{"big": strings.Repeat("x", MaxImportEntryBytes+1), "small": "{}"})
+	for _, f := range zr.File {
+		data, err := readArchiveFile(f)
+		switch f.Name {
+		case "big":
+			if err == nil {
+				t.Error("readArchiveFile() of an oversized file error = nil")
+			}
+		case "small":
+			if err != nil || string(data) != "{}" {
+				t.Errorf("readArchiveFile() = %q, %v", data, err)
+			}
+		}
+	}
+}
+
+func TestManifestJSON(t *testing.T) {
+	// Raw is left out for JSON entries
+	data, _ := json.Marshal(ArchiveManifestEntry{Key: "a"})
+	if strings.Contains(string(data), "raw") {
+		t.Errorf("manifest entry = %s, want raw omitted", data)
+	}
+}
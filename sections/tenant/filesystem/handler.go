@@ -10,9 +10,12 @@ import (
 	"net/http"
 	"time"
 
+	"awning-backend/common"
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
 	"awning-backend/sections/models"
+	"awning-backend/storage"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -48,9 +51,24 @@ type FilesystemEntry struct {
 	UpdatedAt   string `json:"updatedAt"`
 }
 
+// EntryMeta is a filesystem entry without its data, as listed
+type EntryMeta struct {
+	ID          uint   `json:"id"`
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Checksum    string `json:"checksum"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// CacheKey is the Redis cache key of a filesystem entry
+func CacheKey(tenantID, key string) string {
+	return fmt.Sprintf("fs:%s:%s", tenantID, key)
+}
+
 // cacheKey generates a Redis cache key for a filesystem entry
 func (h *Handler) cacheKey(tenantID, key string) string {
-	return fmt.Sprintf("fs:%s:%s", tenantID, key)
+	return CacheKey(tenantID, key)
 }
 
 // GetEntry retrieves a filesystem entry by key
@@ -69,12 +87,18 @@ func (h *Handler) GetEntry(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
 		return
 	}
+	if access := accessFromContext(c); !access.canRead(key) {
+		access.forbidKey(c, key, false)
+		return
+	}
 
 	ctx := c.Request.Context()
 
 	// Try to get from Redis cache first
-	if h.deps.Redis != nil {
+	if h.deps.KV != nil {
+		stopCache := common.StartTiming(ctx, "cache")
 		cached, err := h.getFromCache(ctx, tenantID, key)
+		stopCache()
 		if err == nil && cached != nil {
 			h.logger.Debug("Cache hit", "tenant", tenantID, "key", key)
 			c.JSON(http.StatusOK, cached)
@@ -84,9 +108,11 @@ func (h *Handler) GetEntry(c *gin.Context) {
 
 	// Get from database
 	var entry models.TenantFilesystem
+	stopDB := common.StartTiming(ctx, "db")
 	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
 		return tx.Where("tenant_schema = ? AND key = ?", tenantID, key).First(&entry).Error
 	})
+	stopDB()
 
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "entry not found"})
@@ -96,13 +122,29 @@ func (h *Handler) GetEntry(c *gin.Context) {
 	response := h.toResponse(&entry)
 
 	// Cache the result
-	if h.deps.Redis != nil {
+	if h.deps.KV != nil {
 		h.cacheEntry(ctx, tenantID, key, &response)
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// getEntryOrSearch routes GET /search?q= to Search and GET /export to
+// Export. Gin does not allow a static route beside the catch-all key, so an
+// entry keyed "search" is only readable without a q parameter, and one keyed
+// "export" not at all.
+func (h *Handler) getEntryOrSearch(c *gin.Context) {
+	if c.Param("key") == SearchPath && c.Query("q") != "" {
+		h.Search(c)
+		return
+	}
+	if c.Param("key") == ExportPath {
+		h.Export(c)
+		return
+	}
+	h.GetEntry(c)
+}
+
 // PutEntry creates or updates a filesystem entry
 func (h *Handler) PutEntry(c *gin.Context) {
 	tenantID, ok := auth.GetTenantIDFromContext(c)
@@ -116,6 +158,10 @@ func (h *Handler) PutEntry(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
 		return
 	}
+	if access := accessFromContext(c); !access.canWrite(key) {
+		access.forbidKey(c, key, true)
+		return
+	}
 
 	// Read the JSON body
 	var data json.RawMessage
@@ -124,14 +170,26 @@ func (h *Handler) PutEntry(c *gin.Context) {
 		return
 	}
 
-	dataStr := string(data)
+	response, err := SaveEntry(c.Request.Context(), h.deps, tenantID, key, data)
+	if err != nil {
+		h.logger.Error("Failed to save filesystem entry", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SaveEntry creates or updates the tenant's entry at key with JSON data and
+// refreshes its cache. It is for server code and writes any key, whatever
+// the namespace.
+func SaveEntry(ctx context.Context, deps *sections.Dependencies, tenantID, key string, data json.RawMessage) (*FilesystemEntry, error) {
 	checksum := sha256.Sum256(data)
 	checksumHex := hex.EncodeToString(checksum[:])
 
-	ctx := c.Request.Context()
-
 	var entry models.TenantFilesystem
-	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+	stopDB := common.StartTiming(ctx, "db")
+	err := deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
 		// Try to find existing entry
 		err := tx.Where("tenant_schema = ? AND key = ?", tenantID, key).First(&entry).Error
 		if err != nil {
@@ -142,28 +200,26 @@ func (h *Handler) PutEntry(c *gin.Context) {
 			}
 		}
 
-		entry.Data = dataStr
+		entry.Data = string(data)
 		entry.ContentType = "application/json"
 		entry.Size = int64(len(data))
 		entry.Checksum = checksumHex
 
 		return tx.Save(&entry).Error
 	})
-
+	stopDB()
 	if err != nil {
-		h.logger.Error("Failed to save filesystem entry", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save entry"})
-		return
+		return nil, err
 	}
 
-	response := h.toResponse(&entry)
+	response := toResponse(&entry)
 
 	// Update cache
-	if h.deps.Redis != nil {
-		h.cacheEntry(ctx, tenantID, key, &response)
+	if deps.KV != nil {
+		cacheEntry(ctx, deps.KV, tenantID, key, &response)
 	}
 
-	c.JSON(http.StatusOK, response)
+	return &response, nil
 }
 
 // DeleteEntry removes a filesystem entry
@@ -179,12 +235,18 @@ func (h *Handler) DeleteEntry(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
 		return
 	}
+	if access := accessFromContext(c); !access.canWrite(key) {
+		access.forbidKey(c, key, true)
+		return
+	}
 
 	ctx := c.Request.Context()
 
+	stopDB := common.StartTiming(ctx, "db")
 	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
 		return tx.Where("tenant_schema = ? AND key = ?", tenantID, key).Delete(&models.TenantFilesystem{}).Error
 	})
+	stopDB()
 
 	if err != nil {
 		h.logger.Error("Failed to delete filesystem entry", "error", err)
@@ -193,14 +255,14 @@ func (h *Handler) DeleteEntry(c *gin.Context) {
 	}
 
 	// Invalidate cache
-	if h.deps.Redis != nil {
+	if h.deps.KV != nil {
 		h.invalidateCache(ctx, tenantID, key)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "entry deleted"})
 }
 
-// ListEntries lists all filesystem entries for a tenant
+// ListEntries lists the filesystem entries of a tenant the member may read
 func (h *Handler) ListEntries(c *gin.Context) {
 	tenantID, ok := auth.GetTenantIDFromContext(c)
 	if !ok {
@@ -211,13 +273,15 @@ func (h *Handler) ListEntries(c *gin.Context) {
 	prefix := c.Query("prefix")
 
 	var entries []models.TenantFilesystem
+	stopDB := common.StartTiming(c.Request.Context(), "db")
 	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
-		query := tx.Where("tenant_schema = ?", tenantID)
+		query := accessFromContext(c).excludeUnreadable(tx.Where("tenant_schema = ?", tenantID))
 		if prefix != "" {
 			query = query.Where("key LIKE ?", prefix+"%")
 		}
 		return query.Select("id, key, content_type, size, checksum, updated_at").Find(&entries).Error
 	})
+	stopDB()
 
 	if err != nil {
 		h.logger.Error("Failed to list filesystem entries", "error", err)
@@ -226,15 +290,6 @@ func (h *Handler) ListEntries(c *gin.Context) {
 	}
 
 	// Return metadata only, not data
-	type EntryMeta struct {
-		ID          uint   `json:"id"`
-		Key         string `json:"key"`
-		ContentType string `json:"contentType"`
-		Size        int64  `json:"size"`
-		Checksum    string `json:"checksum"`
-		UpdatedAt   string `json:"updatedAt"`
-	}
-
 	responses := make([]EntryMeta, len(entries))
 	for i, e := range entries {
 		responses[i] = EntryMeta{
@@ -243,7 +298,7 @@ func (h *Handler) ListEntries(c *gin.Context) {
 			ContentType: e.ContentType,
 			Size:        e.Size,
 			Checksum:    e.Checksum,
-			UpdatedAt:   e.UpdatedAt.Format(time.RFC3339),
+			UpdatedAt:   common.FormatTime(e.UpdatedAt),
 		}
 	}
 
@@ -256,7 +311,7 @@ func (h *Handler) getFromCache(ctx context.Context, tenantID, key string) (*File
 	cacheKey := h.cacheKey(tenantID, key)
 
 	// Use Redis client to get cached data
-	data, err := h.deps.Redis.Get(ctx, cacheKey)
+	data, err := h.deps.KV.Get(ctx, cacheKey)
 	if err != nil {
 		return nil, err
 	}
@@ -270,27 +325,33 @@ func (h *Handler) getFromCache(ctx context.Context, tenantID, key string) (*File
 }
 
 func (h *Handler) cacheEntry(ctx context.Context, tenantID, key string, entry *FilesystemEntry) {
-	cacheKey := h.cacheKey(tenantID, key)
+	cacheEntry(ctx, h.deps.KV, tenantID, key, entry)
+}
 
+func cacheEntry(ctx context.Context, kv storage.KV, tenantID, key string, entry *FilesystemEntry) {
 	data, err := json.Marshal(entry)
 	if err != nil {
-		h.logger.Error("Failed to marshal entry for cache", "error", err)
+		slog.Error("Failed to marshal entry for cache", "error", err)
 		return
 	}
 
-	if err := h.deps.Redis.SetWithTTL(ctx, cacheKey, data, CacheTTL); err != nil {
-		h.logger.Error("Failed to cache entry", "error", err)
+	if err := kv.SetWithTTL(ctx, CacheKey(tenantID, key), data, CacheTTL); err != nil {
+		slog.Error("Failed to cache entry", "error", err)
 	}
 }
 
 func (h *Handler) invalidateCache(ctx context.Context, tenantID, key string) {
 	cacheKey := h.cacheKey(tenantID, key)
-	if err := h.deps.Redis.Delete(ctx, cacheKey); err != nil {
+	if err := h.deps.KV.Delete(ctx, cacheKey); err != nil {
 		h.logger.Error("Failed to invalidate cache", "error", err)
 	}
 }
 
 func (h *Handler) toResponse(entry *models.TenantFilesystem) FilesystemEntry {
+	return toResponse(entry)
+}
+
+func toResponse(entry *models.TenantFilesystem) FilesystemEntry {
 	var data any
 	if err := json.Unmarshal([]byte(entry.Data), &data); err != nil {
 		data = entry.Data // Return as string if not valid JSON
@@ -303,7 +364,7 @@ func (h *Handler) toResponse(entry *models.TenantFilesystem) FilesystemEntry {
 		ContentType: entry.ContentType,
 		Size:        entry.Size,
 		Checksum:    entry.Checksum,
-		UpdatedAt:   entry.UpdatedAt.Format(time.RFC3339),
+		UpdatedAt:   common.FormatTime(entry.UpdatedAt),
 	}
 }
 
@@ -314,11 +375,15 @@ func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager
 	tenantCfg := auth.DefaultTenantMiddlewareConfig()
 
 	fsRoutes := r.Group("/api/v1/filesystem")
+	fsRoutes.Use(middleware.ServerTimingMiddleware())
+	fsRoutes.Use(countOperations())
 	fsRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
 	fsRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	fsRoutes.Use(handler.requireMembership())
 	{
 		fsRoutes.GET("", handler.ListEntries)
-		fsRoutes.GET("/*key", handler.GetEntry)
+		fsRoutes.GET("/*key", handler.getEntryOrSearch)
+		fsRoutes.POST("/import", handler.Import)
 		fsRoutes.PUT("/*key", handler.PutEntry)
 		fsRoutes.DELETE("/*key", handler.DeleteEntry)
 	}
@@ -0,0 +1,25 @@
+package filesystem
+
+import (
+	"strconv"
+	"strings"
+
+	"awning-backend/metrics"
+	"awning-backend/sections/common/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+var operationsTotal = metrics.NewCounterVec("awning_filesystem_operations_total",
+	"Filesystem API requests by tenant, method and status class (2xx, 4xx, ...)",
+	"tenant", "method", "status")
+
+// countOperations counts the filesystem requests of each tenant
+func countOperations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		tenantID, _ := auth.GetTenantIDFromContext(c)
+		status := strconv.Itoa(c.Writer.Status()/100) + "xx"
+		operationsTotal.Inc(metrics.TenantLabel(tenantID), strings.ToLower(c.Request.Method), status)
+	}
+}
@@ -0,0 +1,183 @@
+package filesystem
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ErrCodeKeyForbidden is returned in the "code" field for requests to keys
+// outside the namespaces the member's role may read or write
+const ErrCodeKeyForbidden = "filesystem_key_forbidden"
+
+const keyAccessCtxKey = "filesystemKeyAccess"
+
+// keyAccess is what a member may do with the tenant's filesystem keys
+type keyAccess struct {
+	role       string
+	namespaces []common.FilesystemNamespace // sorted by prefix
+}
+
+// namespace returns the namespace key belongs to: the longest prefix it
+// matches, ignoring a leading slash
+func (a *keyAccess) namespace(key string) *common.FilesystemNamespace {
+	key = strings.TrimPrefix(key, "/")
+	for i := len(a.namespaces) - 1; i >= 0; i-- {
+		if strings.HasPrefix(key, a.namespaces[i].Prefix) {
+			return &a.namespaces[i]
+		}
+	}
+	return nil
+}
+
+func (a *keyAccess) allows(ns *common.FilesystemNamespace, write bool) bool {
+	if ns == nil {
+		return false
+	}
+	switch ns.Access[a.role] {
+	case common.FilesystemAccessWrite:
+		return true
+	case common.FilesystemAccessRead:
+		return !write
+	}
+	return false
+}
+
+func (a *keyAccess) canRead(key string) bool {
+	return a.allows(a.namespace(key), false)
+}
+
+func (a *keyAccess) canWrite(key string) bool {
+	return a.allows(a.namespace(key), true)
+}
+
+// allowedPrefixes lists the prefixes of the namespaces the role may read,
+// or write
+func (a *keyAccess) allowedPrefixes(write bool) []string {
+	prefixes := []string{}
+	for i := range a.namespaces {
+		if a.allows(&a.namespaces[i], write) {
+			prefixes = append(prefixes, a.namespaces[i].Prefix)
+		}
+	}
+	return prefixes
+}
+
+// excludeUnreadable leaves the keys the role can't read out of query. Each
+// unreadable namespace excludes its keys but those of longer namespaces
+// within it, which decide for themselves.
+func (a *keyAccess) excludeUnreadable(query *gorm.DB) *gorm.DB {
+	for i, ns := range a.namespaces {
+		if a.allows(&a.namespaces[i], false) {
+			continue
+		}
+		cond, args := keyLike(ns.Prefix)
+		for _, inner := range a.namespaces {
+			if len(inner.Prefix) > len(ns.Prefix) && strings.HasPrefix(inner.Prefix, ns.Prefix) {
+				innerCond, innerArgs := keyLike(inner.Prefix)
+				cond += " AND NOT " + innerCond
+				args = append(args, innerArgs...)
+			}
+		}
+		query = query.Where("NOT ("+cond+")", args...)
+	}
+	return query
+}
+
+// keyLike matches the keys under prefix, with or without a leading slash
+func keyLike(prefix string) (string, []any) {
+	pattern := escapeLike(prefix) + "%"
+	return "(key LIKE ? OR key LIKE ?)", []any{pattern, "/" + pattern}
+}
+
+// forbidKey writes the 403 for a key outside the role's namespaces, listing
+// the prefixes it may use
+func (a *keyAccess) forbidKey(c *gin.Context, key string, write bool) {
+	message := "not allowed to read this key"
+	if write {
+		message = "not allowed to write this key"
+	}
+	c.JSON(http.StatusForbidden, i18n.Localize(c, gin.H{
+		"error":           message,
+		"code":            ErrCodeKeyForbidden,
+		"key":             key,
+		"role":            a.role,
+		"allowedPrefixes": a.allowedPrefixes(write),
+	}))
+}
+
+// requireMembership loads the user's role in the tenant and the namespaces
+// of the tenant's subscription plan, rejecting users who aren't members
+func (h *Handler) requireMembership() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenantID, ok := auth.GetTenantIDFromContext(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+			c.Abort()
+			return
+		}
+		userID, ok := auth.GetUserIDFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+			c.Abort()
+			return
+		}
+
+		ctx := c.Request.Context()
+		var membership models.UserTenant
+		err := h.deps.DB.DB.WithContext(ctx).
+			Where("user_id = ? AND tenant_schema = ?", userID, tenantID).
+			First(&membership).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not a member of this tenant"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to load membership", "tenant", tenantID, "user_id", userID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load membership"})
+			c.Abort()
+			return
+		}
+
+		// Tenants without an account get the default namespaces
+		var plans []string
+		err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantAccount{}).Where("tenant_schema = ?", tenantID).Limit(1).Pluck("subscription_plan", &plans).Error
+		})
+		if err != nil {
+			h.logger.Error("Failed to load subscription plan", "tenant", tenantID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load subscription plan"})
+			c.Abort()
+			return
+		}
+		plan := ""
+		if len(plans) > 0 {
+			plan = plans[0]
+		}
+
+		c.Set(keyAccessCtxKey, &keyAccess{
+			role:       membership.Role,
+			namespaces: h.deps.Config.FilesystemNamespacesFor(plan),
+		})
+		c.Next()
+	}
+}
+
+// accessFromContext returns the access set by requireMembership
+func accessFromContext(c *gin.Context) *keyAccess {
+	access, _ := c.Get(keyAccessCtxKey)
+	if a, ok := access.(*keyAccess); ok {
+		return a
+	}
+	// No membership loaded: nothing is allowed
+	return &keyAccess{}
+}
@@ -0,0 +1,154 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Keys of each default namespace, with and without the leading slash the
+// route's *key parameter has. sitemap.json and systemic.json share a
+// prefix's letters but not its slash, so they're at the root.
+var namespaceKeys = map[string][]string{
+	"":          {"notes.json", "/notes.json", "sitemap.json", "systemic.json"},
+	"site/":     {"site/index.json", "/site/pages/about.json"},
+	"settings/": {"settings/billing.json", "/settings/theme.json"},
+	"system/":   {"system/publish-manifest.json", "/system/settings/x.json"},
+}
+
+func TestKeyAccessMatrix(t *testing.T) {
+	const none, read, write = "", common.FilesystemAccessRead, common.FilesystemAccessWrite
+
+	// The default namespaces, by role then prefix. Unknown roles and no
+	// membership at all get nothing.
+	want := map[string]map[string]string{
+		"owner":  {"": write, "site/": write, "settings/": write, "system/": none},
+		"admin":  {"": write, "site/": write, "settings/": write, "system/": none},
+		"member": {"": write, "site/": write, "settings/": none, "system/": none},
+		"viewer": {"": none, "site/": none, "settings/": none, "system/": none},
+		"":       {"": none, "site/": none, "settings/": none, "system/": none},
+	}
+	cfg := common.DefaultConfig()
+	for role, byPrefix := range want {
+		access := &keyAccess{role: role, namespaces: cfg.FilesystemNamespacesFor("")}
+		for prefix, level := range byPrefix {
+			for _, key := range namespaceKeys[prefix] {
+				if got := access.canRead(key); got != (level != none) {
+					t.Errorf("role %q canRead(%q) = %v, want %v", role, key, got, !got)
+				}
+				if got := access.canWrite(key); got != (level == write) {
+					t.Errorf("role %q canWrite(%q) = %v, want %v", role, key, got, !got)
+				}
+			}
+		}
+	}
+
+	var nobody keyAccess
+	if nobody.canRead("site/index.json") || nobody.canWrite("site/index.json") {
+		t.Errorf("access without namespaces allows site/index.json")
+	}
+}
+
+func TestKeyAccessPlanOverrides(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.FilesystemNamespaces = map[string][]common.FilesystemNamespace{
+		"pro": {
+			{Prefix: "settings/", Access: map[string]string{"owner": common.FilesystemAccessWrite, "member": common.FilesystemAccessRead}},
+			{Prefix: "site/drafts/", Access: map[string]string{"owner": common.FilesystemAccessWrite}},
+		},
+	}
+	tests := []struct {
+		plan, role, key string
+		read, write     bool
+	}{
+		{"pro", "member", "settings/theme.json", true, false},
+		{"pro", "owner", "settings/theme.json", true, true},
+		// Replaced, so admin is no longer listed
+		{"pro", "admin", "settings/theme.json", false, false},
+		// The longer prefix decides within site/
+		{"pro", "member", "site/drafts/home.json", false, false},
+		{"pro", "owner", "/site/drafts/home.json", true, true},
+		{"pro", "member", "site/index.json", true, true},
+		// Other plans keep the defaults
+		{"free", "member", "settings/theme.json", false, false},
+		{"free", "admin", "settings/theme.json", true, true},
+		{"free", "member", "site/drafts/home.json", true, true},
+	}
+	for _, tt := range tests {
+		access := &keyAccess{role: tt.role, namespaces: cfg.FilesystemNamespacesFor(tt.plan)}
+		if got := access.canRead(tt.key); got != tt.read {
+			t.Errorf("%s %s canRead(%q) = %v, want %v", tt.plan, tt.role, tt.key, got, tt.read)
+		}
+		if got := access.canWrite(tt.key); got != tt.write {
+			t.Errorf("%s %s canWrite(%q) = %v, want %v", tt.plan, tt.role, tt.key, got, tt.write)
+		}
+	}
+}
+
+// newAccessRouter serves the entry handlers as role, without a database:
+// only requests refused before the handlers load anything may be sent. An
+// empty role stands for a request requireMembership never saw.
+func newAccessRouter(role string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	h := NewHandler(nil)
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("tenantID", "tenant_a")
+		if role != "" {
+			c.Set(keyAccessCtxKey, &keyAccess{role: role, namespaces: common.DefaultConfig().FilesystemNamespacesFor("")})
+		}
+	})
+	r.GET("/api/v1/filesystem/*key", h.GetEntry)
+	r.PUT("/api/v1/filesystem/*key", h.PutEntry)
+	r.DELETE("/api/v1/filesystem/*key", h.DeleteEntry)
+	return r
+}
+
+func TestEntryHandlersForbidKeys(t *testing.T) {
+	tests := []struct {
+		role, method, key string
+		allowed           []string
+	}{
+		{"member", http.MethodPut, "settings/billing.json", []string{"", "site/"}},
+		{"member", http.MethodDelete, "settings/billing.json", []string{"", "site/"}},
+		{"member", http.MethodGet, "settings/billing.json", []string{"", "site/"}},
+		{"owner", http.MethodPut, "system/publish-manifest.json", []string{"", "settings/", "site/"}},
+		{"admin", http.MethodGet, "system/publish-manifest.json", []string{"", "settings/", "site/"}},
+		{"viewer", http.MethodGet, "site/index.json", []string{}},
+		{"", http.MethodPut, "site/index.json", []string{}},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, "/api/v1/filesystem/"+tt.key, strings.NewReader(`{}`))
+		newAccessRouter(tt.role).ServeHTTP(w, req)
+
+		name := tt.role + " " + tt.method + " " + tt.key
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: status = %d, want 403: %s", name, w.Code, w.Body)
+			continue
+		}
+		var body struct {
+			Code            string   `json:"code"`
+			Key             string   `json:"key"`
+			Role            string   `json:"role"`
+			AllowedPrefixes []string `json:"allowedPrefixes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid JSON body %q: %v", name, w.Body, err)
+		}
+		if body.Code != ErrCodeKeyForbidden || body.Key != "/"+tt.key || body.Role != tt.role {
+			t.Errorf("%s: body = %+v", name, body)
+		}
+		if !slices.Equal(body.AllowedPrefixes, tt.allowed) {
+			t.Errorf("%s: allowedPrefixes = %q, want %q", name, body.AllowedPrefixes, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"awning-backend/common"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// SearchPath is the key GET requests with a q parameter are routed to search
+	SearchPath = "/search"
+
+	DefaultSearchPerPage = 20
+	MaxSearchPerPage     = 50
+	// MaxSearchResults caps how deep pagination can go
+	MaxSearchResults = 500
+	// MaxMatchesPerEntry caps the match paths returned for one entry
+	MaxMatchesPerEntry = 10
+	// ExcerptRadius is the number of characters kept on each side of a match
+	ExcerptRadius = 40
+)
+
+// SearchMatch is one place in an entry's JSON where the query matched
+type SearchMatch struct {
+	Path    string `json:"path"`
+	Excerpt string `json:"excerpt"`
+}
+
+// SearchResult is an entry matching a search
+type SearchResult struct {
+	Key       string        `json:"key"`
+	Size      int64         `json:"size"`
+	UpdatedAt string        `json:"updatedAt"`
+	Matches   []SearchMatch `json:"matches"`
+}
+
+// Search finds entries whose JSON contains q. A q that is a JSON object or
+// array is matched by jsonb containment (served by the jsonb_path_ops index);
+// anything else is a case-insensitive text match over keys and values.
+func (h *Handler) Search(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	prefix := c.Query("prefix")
+
+	page := 1
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+	perPage := DefaultSearchPerPage
+	if pp := c.Query("per_page"); pp != "" {
+		if parsed, err := strconv.Atoi(pp); err == nil && parsed > 0 && parsed <= MaxSearchPerPage {
+			perPage = parsed
+		}
+	}
+
+	offset := (page - 1) * perPage
+	if offset >= MaxSearchResults {
+		c.JSON(http.StatusOK, gin.H{"results": []SearchResult{}, "page": page, "perPage": perPage, "hasMore": false})
+		return
+	}
+	limit := min(perPage, MaxSearchResults-offset)
+
+	var contains any
+	if strings.HasPrefix(q, "{") || strings.HasPrefix(q, "[") {
+		if err := json.Unmarshal([]byte(q), &contains); err != nil {
+			contains = nil
+		}
+	}
+
+	var entries []models.TenantFilesystem
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		query := accessFromContext(c).excludeUnreadable(tx.Where("tenant_schema = ?", tenantID))
+		if maxSize := h.deps.Config.FilesystemSearchMaxBytes; maxSize > 0 {
+			query = query.Where("size <= ?", maxSize)
+		}
+		if prefix != "" {
+			query = query.Where("key LIKE ?", escapeLike(prefix)+"%")
+		}
+		if contains != nil {
+			query = query.Where("data @> ?::jsonb", q)
+		} else {
+			query = query.Where("data::text ILIKE ?", "%"+escapeLike(q)+"%")
+		}
+		// One extra row tells whether there is another page
+		return query.Order("key").Offset(offset).Limit(limit + 1).Find(&entries).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to search filesystem entries", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search entries"})
+		return
+	}
+
+	hasMore := len(entries) > limit && offset+limit < MaxSearchResults
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	results := make([]SearchResult, len(entries))
+	for i, e := range entries {
+		var data any
+		_ = json.Unmarshal([]byte(e.Data), &data)
+
+		var matches []SearchMatch
+		if contains != nil {
+			matches = findContaining(data, contains, "$", nil)
+		} else {
+			matches = findText(data, strings.ToLower(q), "$", nil)
+		}
+
+		results[i] = SearchResult{
+			Key:       e.Key,
+			Size:      e.Size,
+			UpdatedAt: common.FormatTime(e.UpdatedAt),
+			Matches:   matches,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "page": page, "perPage": perPage, "hasMore": hasMore})
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// findText returns the paths of object keys and scalar values containing
+// needle (already lower-cased), in document order
+func findText(node any, needle, path string, matches []SearchMatch) []SearchMatch {
+	if len(matches) >= MaxMatchesPerEntry {
+		return matches
+	}
+
+	switch v := node.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			childPath := objectPath(path, k)
+			if strings.Contains(strings.ToLower(k), needle) && len(matches) < MaxMatchesPerEntry {
+				matches = append(matches, SearchMatch{Path: childPath, Excerpt: excerpt(k, needle)})
+			}
+			matches = findText(v[k], needle, childPath, matches)
+		}
+	case []any:
+		for i, item := range v {
+			matches = findText(item, needle, fmt.Sprintf("%s[%d]", path, i), matches)
+		}
+	case nil:
+	default:
+		s := fmt.Sprint(v)
+		if strings.Contains(strings.ToLower(s), needle) {
+			matches = append(matches, SearchMatch{Path: path, Excerpt: excerpt(s, needle)})
+		}
+	}
+	return matches
+}
+
+// findContaining returns the paths of nodes that contain want with jsonb @>
+// semantics, deepest first within each branch
+func findContaining(node, want any, path string, matches []SearchMatch) []SearchMatch {
+	switch v := node.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			matches = findContaining(v[k], want, objectPath(path, k), matches)
+		}
+	case []any:
+		for i, item := range v {
+			matches = findContaining(item, want, fmt.Sprintf("%s[%d]", path, i), matches)
+		}
+	}
+
+	if len(matches) < MaxMatchesPerEntry && jsonContains(node, want) {
+		raw, _ := json.Marshal(node)
+		matches = append(matches, SearchMatch{Path: path, Excerpt: truncateRunes(string(raw), 2*ExcerptRadius)})
+	}
+	return matches
+}
+
+// jsonContains reports whether have contains want as Postgres jsonb @> does
+func jsonContains(have, want any) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		h, ok := have.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, wv := range w {
+			hv, ok := h[k]
+			if !ok || !jsonContains(hv, wv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		h, ok := have.([]any)
+		if !ok {
+			return false
+		}
+		for _, wv := range w {
+			found := false
+			for _, hv := range h {
+				if jsonContains(hv, wv) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(have, want)
+	}
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// objectPath appends a key to a JSONPath, quoting keys that are not identifiers
+func objectPath(path, key string) string {
+	for i, r := range key {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return path + "[" + strconv.Quote(key) + "]"
+		}
+	}
+	if key == "" {
+		return path + `[""]`
+	}
+	return path + "." + key
+}
+
+// excerpt returns the text around the first match of needle in s
+func excerpt(s, needle string) string {
+	idx := strings.Index(strings.ToLower(s), needle)
+	if idx < 0 {
+		return truncateRunes(s, 2*ExcerptRadius)
+	}
+
+	start := max(idx-ExcerptRadius, 0)
+	end := min(idx+len(needle)+ExcerptRadius, len(s))
+	for start > 0 && !utf8.RuneStart(s[start]) {
+		start--
+	}
+	for end < len(s) && !utf8.RuneStart(s[end]) {
+		end++
+	}
+
+	out := s[start:end]
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(s) {
+		out += "…"
+	}
+	return out
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
@@ -0,0 +1,145 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testPage is a site entry with nested sections and components
+const testPage = `{
+	"title": "Bakery",
+	"sections": [
+		{"id": "hero", "heading": "Fresh Sourdough daily", "components": [{"id": "cta-1", "type": "button"}]},
+		{"id": "menu", "items": ["Rye", "sourdough loaf", 3]}
+	],
+	"meta": {"Sourdough-Tag": true, "price": 4.5}
+}`
+
+func decodeTestJSON(t *testing.T, s string) any {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func matchPaths(matches []SearchMatch) string {
+	paths := make([]string, len(matches))
+	for i, m := range matches {
+		paths[i] = m.Path
+	}
+	return strings.Join(paths, " ")
+}
+
+func TestFindText(t *testing.T) {
+	page := decodeTestJSON(t, testPage)
+	tests := []struct {
+		needle string
+		want   string
+	}{
+		// Keys and values, case-insensitively, in sorted key order
+		{"sourdough", `$.meta["Sourdough-Tag"] $.sections[0].heading $.sections[1].items[1]`},
+		{"cta-1", "$.sections[0].components[0].id"},
+		{"4.5", "$.meta.price"},
+		{"title", "$.title"},
+		{"croissant", ""},
+	}
+	for _, tt := range tests {
+		if got := matchPaths(findText(page, tt.needle, "$", nil)); got != tt.want {
+			t.Errorf("findText(%q) paths = %q, want %q", tt.needle, got, tt.want)
+		}
+	}
+}
+
+func TestFindTextCapsMatches(t *testing.T) {
+	items := make([]any, 3*MaxMatchesPerEntry)
+	for i := range items {
+		items[i] = "loaf"
+	}
+	if got := findText(items, "loaf", "$", nil); len(got) != MaxMatchesPerEntry {
+		t.Errorf("findText() returned %d matches, want %d", len(got), MaxMatchesPerEntry)
+	}
+}
+
+func TestFindContaining(t *testing.T) {
+	page := decodeTestJSON(t, testPage)
+	tests := []struct {
+		want string
+		path string
+	}{
+		{`{"id": "cta-1"}`, "$.sections[0].components[0]"},
+		{`{"id": "menu", "items": ["Rye"]}`, "$.sections[1]"},
+		// The entry itself
+		{`{"sections": [{"id": "hero"}]}`, "$"},
+		{`[{"type": "button"}]`, "$.sections[0].components"},
+		{`{"id": "nope"}`, ""},
+	}
+	for _, tt := range tests {
+		got := findContaining(page, decodeTestJSON(t, tt.want), "$", nil)
+		if paths := matchPaths(got); paths != tt.path {
+			t.Errorf("findContaining(%s) paths = %q, want %q", tt.want, paths, tt.path)
+		}
+	}
+}
+
+func TestJSONContains(t *testing.T) {
+	tests := []struct {
+		have, want string
+		ok         bool
+	}{
+		{`{"a": 1, "b": 2}`, `{"a": 1}`, true},
+		{`{"a": 1}`, `{"a": 2}`, false},
+		{`{"a": [1, 2, 3]}`, `{"a": [3, 1]}`, true},
+		{`{"a": [1, 2]}`, `{"a": [4]}`, false},
+		{`[{"id": "x", "n": 1}]`, `[{"id": "x"}]`, true},
+		{`"x"`, `{"a": 1}`, false},
+	}
+	for _, tt := range tests {
+		if got := jsonContains(decodeTestJSON(t, tt.have), decodeTestJSON(t, tt.want)); got != tt.ok {
+			t.Errorf("jsonContains(%s, %s) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"title", "$.title"},
+		{"_id2", "$._id2"},
+		{"2nd", `$["2nd"]`},
+		{"Sourdough-Tag", `$["Sourdough-Tag"]`},
+		{"", `$[""]`},
+	}
+	for _, tt := range tests {
+		if got := objectPath("$", tt.key); got != tt.want {
+			t.Errorf("objectPath(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	long := strings.Repeat("a", 60) + "Sourdough" + strings.Repeat("b", 60)
+	tests := []struct {
+		s, needle, want string
+	}{
+		{"Fresh Sourdough daily", "sourdough", "Fresh Sourdough daily"},
+		{long, "sourdough", "…" + strings.Repeat("a", ExcerptRadius) + "Sourdough" + strings.Repeat("b", ExcerptRadius) + "…"},
+		// Cut on rune boundaries
+		{strings.Repeat("é", 50) + "x", "x", "…" + strings.Repeat("é", 20) + "x"},
+	}
+	for _, tt := range tests {
+		if got := excerpt(tt.s, tt.needle); got != tt.want {
+			t.Errorf("excerpt(%q, %q) = %q, want %q", tt.s, tt.needle, got, tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if got := escapeLike(`50%_off\`); got != `50\%\_off\\` {
+		t.Errorf("escapeLike() = %q", got)
+	}
+}
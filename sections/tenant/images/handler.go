@@ -7,6 +7,7 @@ import (
 
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/settings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -54,7 +55,10 @@ func (h *Handler) SearchPhotos(c *gin.Context) {
 	}
 
 	orientation := c.Query("orientation") // landscape, portrait, squarish
-	orderBy := c.Query("order_by")        // latest, oldest, popular
+	if tenantID, ok := auth.GetTenantIDFromContext(c); ok && orientation == "" && h.deps.Settings != nil {
+		orientation = h.deps.Settings.GetString(c.Request.Context(), tenantID, settings.DefaultImageOrientation)
+	}
+	orderBy := c.Query("order_by") // latest, oldest, popular
 	if orderBy == "" {
 		orderBy = "relevant"
 	}
@@ -92,18 +96,28 @@ func (h *Handler) GetPhoto(c *gin.Context) {
 
 // RegisterRoutes registers image-related routes
 func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager) {
-	if deps.UnsplashSvc == nil {
-		slog.Info("Skipping image routes - Unsplash service not configured")
-		return
-	}
-
 	handler := NewHandler(deps)
 
 	// Tenant-scoped image routes
 	imageRoutes := r.Group("/api/v1/images")
 	imageRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
-	{
+
+	if deps.UnsplashSvc != nil {
 		imageRoutes.GET("/search", handler.SearchPhotos)
 		imageRoutes.GET("/photos/:id", handler.GetPhoto)
+	} else {
+		slog.Info("Skipping image search routes - Unsplash service not configured")
+	}
+
+	if deps.ImageStore != nil {
+		uploadRoutes := imageRoutes.Group("")
+		uploadRoutes.Use(auth.TenantFromHeaderMiddleware(auth.DefaultTenantMiddlewareConfig()))
+		{
+			uploadRoutes.POST("/upload", handler.UploadImage)
+			uploadRoutes.GET("", handler.ListImages)
+			uploadRoutes.DELETE("/:id", handler.DeleteImage)
+		}
+	} else {
+		slog.Info("Skipping image upload routes - image store not configured")
 	}
 }
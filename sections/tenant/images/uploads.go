@@ -0,0 +1,352 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"awning-backend/db"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_UPLOAD_IMAGE_BYTES  = 10 << 20
+	MAX_UPLOAD_IMAGE_PIXELS = 40_000_000
+	MAX_UPLOAD_KEYWORDS     = 20
+
+	// Favicons are square PNGs or ICOs of at most 256 KB
+	MAX_FAVICON_BYTES  = 256 << 10
+	MIN_FAVICON_PIXELS = 16
+	MAX_FAVICON_PIXELS = 512
+)
+
+// Upload types, limited to formats the standard library can decode
+var uploadImageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Favicon upload types; ICO is checked by its header as the standard
+// library can't decode it
+var faviconExtensions = map[string]string{
+	"image/png":    ".png",
+	"image/x-icon": ".ico",
+}
+
+// UploadImage stores an uploaded image under the tenant prefix of the image
+// store. With purpose favicon it takes a square PNG or ICO, kept out of the
+// images used in pages.
+func (h *Handler) UploadImage(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MAX_UPLOAD_IMAGE_BYTES+1<<20)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+
+	purpose := c.PostForm("purpose")
+	if purpose != "" && purpose != models.ImagePurposeFavicon {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purpose must be empty or favicon"})
+		return
+	}
+	if purpose == models.ImagePurposeFavicon && file.Size > MAX_FAVICON_BYTES {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("favicon exceeds %d bytes", MAX_FAVICON_BYTES)})
+		return
+	}
+	if file.Size > MAX_UPLOAD_IMAGE_BYTES {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("image exceeds %d bytes", MAX_UPLOAD_IMAGE_BYTES)})
+		return
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		return
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MAX_UPLOAD_IMAGE_BYTES+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		return
+	}
+	if len(data) > MAX_UPLOAD_IMAGE_BYTES {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("image exceeds %d bytes", MAX_UPLOAD_IMAGE_BYTES)})
+		return
+	}
+
+	// Trust the bytes, not the client supplied content type
+	contentType := http.DetectContentType(data)
+	dir := "uploads"
+	var ext string
+	var imgCfg image.Config
+	if purpose == models.ImagePurposeFavicon {
+		dir = "favicons"
+		if len(data) > MAX_FAVICON_BYTES {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("favicon exceeds %d bytes", MAX_FAVICON_BYTES)})
+			return
+		}
+		if ext, ok = faviconExtensions[contentType]; !ok {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported favicon type, use PNG or ICO"})
+			return
+		}
+		if imgCfg, err = faviconConfig(contentType, data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		if ext, ok = uploadImageExtensions[contentType]; !ok {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported image type, use JPEG, PNG or GIF"})
+			return
+		}
+		imgCfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image"})
+			return
+		}
+		if imgCfg.Width <= 0 || imgCfg.Height <= 0 || imgCfg.Width*imgCfg.Height > MAX_UPLOAD_IMAGE_PIXELS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image dimensions"})
+			return
+		}
+	}
+
+	keywords := parseKeywords(c.PostForm("keywords"))
+	alt := strings.TrimSpace(c.PostForm("alt"))
+	if len(alt) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "alt must be at most 255 characters"})
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	key := path.Join(services.ImageObjectPrefix(tenantID), dir, hex.EncodeToString(sum[:])+ext)
+
+	ctx := c.Request.Context()
+	url, err := h.deps.ImageStore.Put(ctx, key, contentType, data)
+	if err != nil {
+		h.logger.Error("Failed to store uploaded image", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store image"})
+		return
+	}
+
+	record := models.TenantImage{
+		TenantSchema: tenantID,
+		Key:          key,
+		URL:          url,
+		ContentType:  contentType,
+		Size:         len(data),
+		Width:        imgCfg.Width,
+		Height:       imgCfg.Height,
+		Alt:          alt,
+		Keywords:     keywords,
+		Purpose:      purpose,
+	}
+	err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to save uploaded image", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save image"})
+		return
+	}
+
+	h.logger.Info("Image uploaded", "tenant", tenantID, "image_id", record.ID, "size", len(data))
+	c.JSON(http.StatusCreated, record)
+}
+
+// ListImages lists the tenant's uploaded images, optionally filtered by keyword
+func (h *Handler) ListImages(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	keyword := strings.ToLower(strings.TrimSpace(c.Query("keyword")))
+
+	var images []models.TenantImage
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		query := tx.Where("tenant_schema = ?", tenantID)
+		if keyword != "" {
+			filter, _ := json.Marshal([]string{keyword})
+			query = query.Where("keywords @> ?::jsonb", string(filter))
+		}
+		return query.Order("created_at DESC").Find(&images).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list images", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list images"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+// DeleteImage removes an uploaded image. The stored object is kept when
+// another record still points at the same content.
+func (h *Handler) DeleteImage(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var record models.TenantImage
+	var shared int64
+	err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_schema = ? AND id = ?", tenantID, id).First(&record).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.TenantImage{}).Where("tenant_schema = ? AND key = ?", tenantID, record.Key).Count(&shared).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete image", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete image"})
+		return
+	}
+
+	if lister, ok := h.deps.ImageStore.(services.ImageLister); ok && shared == 0 {
+		if err := lister.Delete(ctx, record.Key); err != nil {
+			h.logger.Warn("Failed to delete stored image", "key", record.Key, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "image deleted"})
+}
+
+// faviconConfig returns the dimensions of a favicon, the largest image of an
+// ICO. Favicons must be square, between MIN_FAVICON_PIXELS and
+// MAX_FAVICON_PIXELS wide.
+func faviconConfig(contentType string, data []byte) (image.Config, error) {
+	var cfg image.Config
+	if contentType == "image/x-icon" {
+		// ICONDIR (reserved, type 1, count), then a 16 byte entry per image
+		// whose first bytes are the width and height, 0 meaning 256
+		if len(data) < 6 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+			return cfg, errors.New("invalid favicon")
+		}
+		count := int(binary.LittleEndian.Uint16(data[4:]))
+		if count == 0 || len(data) < 6+16*count {
+			return cfg, errors.New("invalid favicon")
+		}
+		for i := 0; i < count; i++ {
+			entry := data[6+16*i:]
+			width, height := int(entry[0]), int(entry[1])
+			if width == 0 {
+				width = 256
+			}
+			if height == 0 {
+				height = 256
+			}
+			if width > cfg.Width {
+				cfg.Width, cfg.Height = width, height
+			}
+		}
+	} else {
+		var err error
+		if cfg, _, err = image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			return cfg, errors.New("invalid favicon")
+		}
+	}
+
+	if cfg.Width != cfg.Height || cfg.Width < MIN_FAVICON_PIXELS || cfg.Width > MAX_FAVICON_PIXELS {
+		return cfg, fmt.Errorf("favicon must be square, %d to %d pixels wide", MIN_FAVICON_PIXELS, MAX_FAVICON_PIXELS)
+	}
+	return cfg, nil
+}
+
+// parseKeywords splits a comma separated keyword list into unique lowercase keywords
+func parseKeywords(s string) []string {
+	seen := make(map[string]struct{})
+	keywords := []string{}
+	for _, part := range strings.Split(s, ",") {
+		k := strings.ToLower(strings.TrimSpace(part))
+		if k == "" {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		keywords = append(keywords, k)
+		if len(keywords) == MAX_UPLOAD_KEYWORDS {
+			break
+		}
+	}
+	return keywords
+}
+
+// UploadedImageSource serves tenant uploads to the image processor
+type UploadedImageSource struct {
+	db *db.DB
+}
+
+// NewUploadedImageSource creates a new uploaded image source
+func NewUploadedImageSource(database *db.DB) *UploadedImageSource {
+	return &UploadedImageSource{db: database}
+}
+
+// UploadedImages returns the tenant's uploaded images, newest first
+func (s *UploadedImageSource) UploadedImages(ctx context.Context, tenantSchema string) ([]services.UploadedImage, error) {
+	var records []models.TenantImage
+	err := s.db.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND purpose = ?", tenantSchema, "").Order("created_at DESC").Find(&records).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load uploaded images: %w", err)
+	}
+
+	images := make([]services.UploadedImage, len(records))
+	for i, r := range records {
+		images[i] = services.UploadedImage{
+			ID:       r.ID,
+			URL:      r.URL,
+			Alt:      r.Alt,
+			Width:    r.Width,
+			Height:   r.Height,
+			Keywords: r.Keywords,
+		}
+	}
+	return images, nil
+}
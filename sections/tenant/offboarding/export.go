@@ -0,0 +1,156 @@
+package offboarding
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+)
+
+// TenantExport is the JSON document produced when a tenant is offboarded
+type TenantExport struct {
+	TenantSchema string             `json:"tenantSchema"`
+	ExportedAt   time.Time          `json:"exportedAt"`
+	Profile      json.RawMessage    `json:"profile,omitempty"`
+	Filesystem   []FilesystemEntry  `json:"filesystem"`
+	Chats        []ChatEntry        `json:"chats"`
+	Publications []PublicationEntry `json:"publications"`
+}
+
+// FilesystemEntry is an exported tenant filesystem entry
+type FilesystemEntry struct {
+	Key         string          `json:"key"`
+	ContentType string          `json:"contentType"`
+	Checksum    string          `json:"checksum"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// ChatEntry is an exported chat
+type ChatEntry struct {
+	ChatID    string          `json:"chatId"`
+	ChatStage string          `json:"chatStage"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Messages  json.RawMessage `json:"messages"`
+}
+
+// PublicationEntry is an exported site publication
+type PublicationEntry struct {
+	Version     int       `json:"version"`
+	Current     bool      `json:"current"`
+	PublishedAt time.Time `json:"publishedAt"`
+	Content     string    `json:"content"`
+}
+
+// rawJSON returns stored jsonb text as a raw message, quoting it when it is
+// not valid JSON so the export always parses
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	quoted, _ := json.Marshal(s)
+	return quoted
+}
+
+// buildExport snapshots the tenant's filesystem, chats, profile and
+// publications
+func (h *Handler) buildExport(ctx context.Context, tenantSchema string) (*TenantExport, error) {
+	export := &TenantExport{
+		TenantSchema: tenantSchema,
+		ExportedAt:   h.now().UTC(),
+		Filesystem:   []FilesystemEntry{},
+		Chats:        []ChatEntry{},
+		Publications: []PublicationEntry{},
+	}
+
+	err := h.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		var entries []models.TenantFilesystem
+		if err := tx.Where("tenant_schema = ?", tenantSchema).Order("key").Find(&entries).Error; err != nil {
+			return fmt.Errorf("failed to load filesystem: %w", err)
+		}
+		for _, e := range entries {
+			export.Filesystem = append(export.Filesystem, FilesystemEntry{
+				Key:         e.Key,
+				ContentType: e.ContentType,
+				Checksum:    e.Checksum,
+				UpdatedAt:   e.UpdatedAt,
+				Data:        rawJSON(e.Data),
+			})
+		}
+
+		var chats []models.TenantChat
+		if err := tx.Where("tenant_schema = ?", tenantSchema).Order("created_at").Find(&chats).Error; err != nil {
+			return fmt.Errorf("failed to load chats: %w", err)
+		}
+		for _, chat := range chats {
+			export.Chats = append(export.Chats, ChatEntry{
+				ChatID:    chat.ChatID,
+				ChatStage: chat.ChatStage,
+				UpdatedAt: chat.UpdatedAt,
+				Messages:  rawJSON(chat.Messages),
+			})
+		}
+
+		var publications []models.TenantPublication
+		if err := tx.Where("tenant_schema = ?", tenantSchema).Order("version").Find(&publications).Error; err != nil {
+			return fmt.Errorf("failed to load publications: %w", err)
+		}
+		for _, p := range publications {
+			export.Publications = append(export.Publications, PublicationEntry{
+				Version:     p.Version,
+				Current:     p.Current,
+				PublishedAt: p.PublishedAt,
+				Content:     p.Content,
+			})
+		}
+
+		var profile models.TenantProfile
+		err := tx.Where("tenant_schema = ?", tenantSchema).First(&profile).Error
+		if err == nil {
+			if data, err := json.Marshal(profile); err == nil {
+				export.Profile = data
+			}
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to load profile: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// saveExport stores the export in the shared schema so it survives the
+// tenant schema being dropped
+func (h *Handler) saveExport(export *TenantExport, requestedBy uint) (*models.TenantExport, error) {
+	data, err := json.Marshal(export)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	record := models.TenantExport{
+		TenantSchema: export.TenantSchema,
+		RequestedBy:  requestedBy,
+		Content:      string(data),
+		Size:         int64(len(data)),
+		Checksum:     hex.EncodeToString(sum[:]),
+	}
+	if err := h.deps.DB.DB.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save export: %w", err)
+	}
+	return &record, nil
+}
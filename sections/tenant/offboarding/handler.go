@@ -0,0 +1,323 @@
+package offboarding
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	errNotOwner       = errors.New("owner role required")
+	errNotScheduled   = errors.New("tenant is not scheduled for deletion")
+	errAlreadyRunning = errors.New("tenant deletion is already in progress")
+)
+
+// Handler handles tenant offboarding requests
+type Handler struct {
+	logger    *slog.Logger
+	deps      *sections.Dependencies
+	stripeSvc *services.StripeService
+	now       func() time.Time
+}
+
+// NewHandler creates a new offboarding handler. stripeSvc may be nil when
+// payments are not configured.
+func NewHandler(deps *sections.Dependencies, stripeSvc *services.StripeService) *Handler {
+	return &Handler{
+		logger:    slog.With("handler", "OffboardingHandler"),
+		deps:      deps,
+		stripeSvc: stripeSvc,
+		now:       time.Now,
+	}
+}
+
+// DeleteTenantRequest confirms the deletion. Users with a password must
+// provide it; users signed in through OAuth confirm with the tenant schema.
+type DeleteTenantRequest struct {
+	Password      string `json:"password"`
+	ConfirmTenant string `json:"confirmTenant"`
+}
+
+// gracePeriod returns the configured delay before a tenant schema is dropped
+func (h *Handler) gracePeriod() time.Duration {
+	return time.Duration(h.deps.Config.TenantDeletionGraceDays) * 24 * time.Hour
+}
+
+// requireOwner checks that the user owns the tenant
+func (h *Handler) requireOwner(userID uint, tenantID string) error {
+	var membership models.UserTenant
+	err := h.deps.DB.DB.Where("user_id = ? AND tenant_schema = ?", userID, tenantID).First(&membership).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNotOwner
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load membership: %w", err)
+	}
+	if membership.Role != "owner" {
+		return errNotOwner
+	}
+	return nil
+}
+
+// tenantContext reads the tenant and user, and checks the user owns the
+// tenant, writing an error response when it fails
+func (h *Handler) tenantContext(c *gin.Context) (string, uint, bool) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return "", 0, false
+	}
+
+	userID, ok := auth.GetUserIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return "", 0, false
+	}
+
+	if err := h.requireOwner(userID, tenantID); err != nil {
+		if errors.Is(err, errNotOwner) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		} else {
+			h.logger.Error("Failed to check tenant role", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check tenant role"})
+		}
+		return "", 0, false
+	}
+
+	return tenantID, userID, true
+}
+
+// DeleteTenant deactivates the tenant, cancels its subscriptions, stores a
+// final export and schedules the schema for deletion after the grace period
+func (h *Handler) DeleteTenant(c *gin.Context) {
+	tenantID, userID, ok := h.tenantContext(c)
+	if !ok {
+		return
+	}
+
+	var req DeleteTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.deps.DB.DB.First(&user, userID).Error; err != nil {
+		h.logger.Error("Failed to load user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+		return
+	}
+	if user.PasswordHash != "" {
+		if req.Password == "" || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+			return
+		}
+	} else if req.ConfirmTenant != tenantID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirmTenant must match the tenant"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var tenant models.Tenant
+	if err := h.deps.DB.DB.Where("schema_name = ?", tenantID).First(&tenant).Error; err != nil {
+		h.logger.Error("Failed to load tenant", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load tenant"})
+		return
+	}
+	if tenant.DeletionScheduledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "tenant is already scheduled for deletion", "deletionScheduledAt": tenant.DeletionScheduledAt})
+		return
+	}
+
+	// Export while the data is still reachable
+	export, err := h.buildExport(ctx, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to build export", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export tenant data"})
+		return
+	}
+	record, err := h.saveExport(export, userID)
+	if err != nil {
+		h.logger.Error("Failed to save export", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export tenant data"})
+		return
+	}
+
+	deletionAt := h.now().UTC().Add(h.gracePeriod())
+	err = h.deps.DB.DB.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&tenant).Updates(map[string]interface{}{
+			"active":                false,
+			"deletion_scheduled_at": deletionAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ScheduledJob{
+			Kind:         JobKindDeleteTenant,
+			TenantSchema: tenantID,
+			RunAt:        deletionAt,
+			Status:       JobStatusPending,
+		}).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to schedule tenant deletion", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to schedule deletion"})
+		return
+	}
+
+	canceled := h.cancelSubscriptions(c, tenantID)
+
+	if h.deps.Sites != nil {
+		h.deps.Sites.InvalidateTenantStatus(ctx, tenantID)
+		h.deps.Sites.InvalidatePublication(ctx, tenantID)
+	}
+
+	h.logger.Info("Tenant scheduled for deletion", "tenant", tenantID, "user_id", userID, "deletion_at", deletionAt, "subscriptions_canceled", canceled)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"deletionScheduledAt":   deletionAt,
+		"exportId":              record.ID,
+		"exportSize":            record.Size,
+		"subscriptionsCanceled": canceled,
+	})
+}
+
+// cancelSubscriptions cancels the tenant's active Stripe subscriptions
+// immediately. Failures are logged so they can be cancelled by hand; they do
+// not stop the offboarding.
+func (h *Handler) cancelSubscriptions(c *gin.Context, tenantID string) int {
+	var subs []models.Subscription
+	if err := h.deps.DB.DB.Where("tenant_schema = ? AND status IN ?", tenantID, []string{"active", "trialing", "past_due", "incomplete"}).
+		Find(&subs).Error; err != nil {
+		h.logger.Error("Failed to load subscriptions", "tenant", tenantID, "error", err)
+		return 0
+	}
+	if len(subs) == 0 {
+		return 0
+	}
+	if h.stripeSvc == nil {
+		h.logger.Warn("Stripe not configured, subscriptions left active", "tenant", tenantID, "count", len(subs))
+		return 0
+	}
+
+	canceled := 0
+	for _, sub := range subs {
+		if _, err := h.stripeSvc.CancelSubscription(c.Request.Context(), sub.StripeSubscriptionID, false); err != nil {
+			h.logger.Error("Failed to cancel subscription", "tenant", tenantID, "subscription_id", sub.ID, "error", err)
+			continue
+		}
+
+		now := h.now()
+		if err := h.deps.DB.DB.Model(&sub).Updates(map[string]interface{}{
+			"status":      "canceled",
+			"canceled_at": &now,
+		}).Error; err != nil {
+			h.logger.Error("Failed to update subscription", "subscription_id", sub.ID, "error", err)
+		}
+		canceled++
+	}
+	return canceled
+}
+
+// RestoreTenant cancels a scheduled deletion during the grace period.
+// Subscriptions cancelled during offboarding are not restarted.
+func (h *Handler) RestoreTenant(c *gin.Context) {
+	tenantID, userID, ok := h.tenantContext(c)
+	if !ok {
+		return
+	}
+
+	err := h.deps.DB.DB.DB.Transaction(func(tx *gorm.DB) error {
+		var tenant models.Tenant
+		if err := tx.Where("schema_name = ?", tenantID).First(&tenant).Error; err != nil {
+			return err
+		}
+		if tenant.DeletionScheduledAt == nil {
+			return errNotScheduled
+		}
+
+		result := tx.Model(&models.ScheduledJob{}).
+			Where("kind = ? AND tenant_schema = ? AND status = ?", JobKindDeleteTenant, tenantID, JobStatusPending).
+			Update("status", JobStatusCanceled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errAlreadyRunning
+		}
+
+		return tx.Model(&tenant).Updates(map[string]interface{}{
+			"active":                true,
+			"deletion_scheduled_at": nil,
+		}).Error
+	})
+	if errors.Is(err, errNotScheduled) || errors.Is(err, errAlreadyRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to restore tenant", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore tenant"})
+		return
+	}
+
+	if h.deps.Sites != nil {
+		h.deps.Sites.InvalidateTenantStatus(c.Request.Context(), tenantID)
+	}
+
+	h.logger.Info("Tenant deletion canceled", "tenant", tenantID, "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{"restored": true})
+}
+
+// DownloadExport returns the tenant's latest export as a JSON attachment
+func (h *Handler) DownloadExport(c *gin.Context) {
+	tenantID, _, ok := h.tenantContext(c)
+	if !ok {
+		return
+	}
+
+	var record models.TenantExport
+	err := h.deps.DB.DB.Where("tenant_schema = ?", tenantID).Order("created_at DESC").First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no export available"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load export", "tenant", tenantID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load export"})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-export-%s.json", tenantID, record.CreatedAt.UTC().Format("20060102"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("ETag", `"`+record.Checksum+`"`)
+	c.Data(http.StatusOK, "application/json", []byte(record.Content))
+}
+
+// RegisterRoutes registers tenant offboarding routes
+func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager, stripeSvc *services.StripeService) {
+	handler := NewHandler(deps, stripeSvc)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	tenantRoutes := r.Group("/api/v1/tenant")
+	tenantRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	tenantRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		tenantRoutes.DELETE("", handler.DeleteTenant)
+		tenantRoutes.POST("/restore", handler.RestoreTenant)
+		tenantRoutes.GET("/export", handler.DownloadExport)
+	}
+}
@@ -0,0 +1,199 @@
+package offboarding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// JobKindDeleteTenant drops a tenant schema once its grace period ends
+	JobKindDeleteTenant = "tenant.delete"
+
+	JobStatusPending  = "pending"
+	JobStatusRunning  = "running"
+	JobStatusDone     = "done"
+	JobStatusFailed   = "failed"
+	JobStatusCanceled = "canceled"
+
+	// DeletionPollInterval is how often the worker looks for due jobs
+	DeletionPollInterval = time.Minute
+	// MaxDeletionAttempts before a job is marked failed
+	MaxDeletionAttempts = 5
+	// DeletionRetryDelay is multiplied by the attempt count between retries
+	DeletionRetryDelay = 10 * time.Minute
+)
+
+// Worker polls the scheduled jobs table and deletes tenants whose grace
+// period has ended
+type Worker struct {
+	logger   *slog.Logger
+	db       *db.DB
+	sites    *sites.Resolver
+	now      func() time.Time
+	interval time.Duration
+}
+
+// NewWorker creates a new tenant deletion worker. sites may be nil, and a
+// nil now uses time.Now.
+func NewWorker(database *db.DB, resolver *sites.Resolver, now func() time.Time) *Worker {
+	if now == nil {
+		now = time.Now
+	}
+	return &Worker{
+		logger:   slog.With("service", "TenantDeletionWorker"),
+		db:       database,
+		sites:    resolver,
+		now:      now,
+		interval: DeletionPollInterval,
+	}
+}
+
+// Run processes due jobs every poll interval until ctx is done
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.RunOnce(ctx); err != nil {
+			w.logger.Error("Failed to process scheduled jobs", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce processes every job that is due and returns how many ran
+func (w *Worker) RunOnce(ctx context.Context) (int, error) {
+	processed := 0
+	for ctx.Err() == nil {
+		job, err := w.claim(ctx)
+		if err != nil {
+			return processed, err
+		}
+		if job == nil {
+			return processed, nil
+		}
+
+		w.finish(ctx, job, w.deleteTenant(ctx, job))
+		processed++
+	}
+	return processed, ctx.Err()
+}
+
+// claim marks the next due job as running. Rows are locked with SKIP LOCKED
+// so several instances can poll the same table.
+func (w *Worker) claim(ctx context.Context) (*models.ScheduledJob, error) {
+	var job models.ScheduledJob
+	err := w.db.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("kind = ? AND status = ? AND run_at <= ?", JobKindDeleteTenant, JobStatusPending, w.now().UTC()).
+			Order("run_at").
+			First(&job).Error; err != nil {
+			return err
+		}
+
+		job.Status = JobStatusRunning
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":   job.Status,
+			"attempts": job.Attempts,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	return &job, nil
+}
+
+// finish records the outcome of a job, rescheduling it with a growing delay
+// until MaxDeletionAttempts is reached
+func (w *Worker) finish(ctx context.Context, job *models.ScheduledJob, jobErr error) {
+	updates := map[string]interface{}{}
+	switch {
+	case errors.Is(jobErr, errNotScheduled):
+		updates["status"] = JobStatusCanceled
+	case jobErr == nil:
+		now := w.now().UTC()
+		updates["status"] = JobStatusDone
+		updates["completed_at"] = &now
+		updates["last_error"] = ""
+	case job.Attempts >= MaxDeletionAttempts:
+		updates["status"] = JobStatusFailed
+		updates["last_error"] = jobErr.Error()
+	default:
+		updates["status"] = JobStatusPending
+		updates["run_at"] = w.now().UTC().Add(time.Duration(job.Attempts) * DeletionRetryDelay)
+		updates["last_error"] = jobErr.Error()
+	}
+
+	if jobErr != nil && !errors.Is(jobErr, errNotScheduled) {
+		w.logger.Error("Tenant deletion failed", "tenant", job.TenantSchema, "attempt", job.Attempts, "error", jobErr)
+	}
+
+	if err := w.db.DB.WithContext(ctx).Model(job).Updates(updates).Error; err != nil {
+		w.logger.Error("Failed to update job", "job_id", job.ID, "error", err)
+	}
+}
+
+// deleteTenant drops the tenant schema and removes its shared records
+func (w *Worker) deleteTenant(ctx context.Context, job *models.ScheduledJob) error {
+	var tenant models.Tenant
+	err := w.db.DB.WithContext(ctx).Where("schema_name = ?", job.TenantSchema).First(&tenant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errNotScheduled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load tenant: %w", err)
+	}
+
+	// Restored after the job was claimed
+	if tenant.Active || tenant.DeletionScheduledAt == nil {
+		w.logger.Info("Skipping deletion of restored tenant", "tenant", tenant.SchemaName)
+		return errNotScheduled
+	}
+
+	if err := w.db.DeleteTenantSchema(ctx, tenant.SchemaName); err != nil {
+		return err
+	}
+
+	err = w.db.DB.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_schema = ?", tenant.SchemaName).Delete(&models.UserTenant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_schema = ?", tenant.SchemaName).Delete(&models.TenantExport{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tenant_schema = ?", tenant.SchemaName).Delete(&models.ShareLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&tenant).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove tenant records: %w", err)
+	}
+
+	if w.sites != nil {
+		w.sites.InvalidateTenantStatus(ctx, tenant.SchemaName)
+		w.sites.InvalidatePublication(ctx, tenant.SchemaName)
+	}
+
+	w.logger.Info("Tenant deleted", "tenant", tenant.SchemaName)
+	return nil
+}
@@ -1,22 +1,29 @@
 package payment
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/webhooks"
 	"awning-backend/sections/models"
 	"awning-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v84"
+	"gorm.io/gorm"
 )
 
 // Handler handles payment-related requests
@@ -88,25 +95,21 @@ func (h *Handler) CreatePaymentIntentForPlan(c *gin.Context) {
 		return
 	}
 
-	// Get or create Stripe customer
-	customerName := user.FirstName + " " + user.LastName
-	metadata := map[string]string{
-		"user_id": fmt.Sprintf("%d", user.ID),
-		"email":   user.Email,
-	}
+	// Stripe creates are keyed to the request's Idempotency-Key, if any
+	ctx := services.WithIdempotencyKey(c.Request.Context(), middleware.IdempotencyKey(c))
 
-	customer, err := h.stripeSvc.GetOrCreateCustomer(c.Request.Context(), user.Email, customerName, metadata)
+	// Use the user's Stripe customer, created on their first payment
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	customerID, err := h.deps.StripeCustomerID(ctx, h.stripeSvc, &user, tenantSchema)
 	if err != nil {
 		h.logger.Error("Failed to get or create customer", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create customer"})
 		return
 	}
 
-	customerId := customer.ID
-
 	// Create payment intent for the plan
 
-	pi, err := h.stripeSvc.CreatePaymentIntentForPlan(c.Request.Context(), req.PlanID, customerId)
+	pi, err := h.stripeSvc.CreatePaymentIntentForPlan(ctx, req.PlanID, customerID)
 	if err != nil {
 		h.logger.Error("Failed to create payment intent", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create payment intent"})
@@ -150,14 +153,12 @@ func (h *Handler) CreateCheckoutSessionForPlan(c *gin.Context) {
 		return
 	}
 
-	// Get or create Stripe customer
-	customerName := user.FirstName + " " + user.LastName
-	metadata := map[string]string{
-		"user_id": fmt.Sprintf("%d", user.ID),
-		"email":   user.Email,
-	}
+	// Stripe creates are keyed to the request's Idempotency-Key, if any
+	ctx := services.WithIdempotencyKey(c.Request.Context(), middleware.IdempotencyKey(c))
 
-	customer, err := h.stripeSvc.GetOrCreateCustomer(c.Request.Context(), user.Email, customerName, metadata)
+	// Use the user's Stripe customer, created on their first payment
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	customerID, err := h.deps.StripeCustomerID(ctx, h.stripeSvc, &user, tenantSchema)
 	if err != nil {
 		h.logger.Error("Failed to get or create customer", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create customer"})
@@ -168,21 +169,21 @@ func (h *Handler) CreateCheckoutSessionForPlan(c *gin.Context) {
 	if req.Metadata == nil {
 		req.Metadata = make(map[string]string)
 	}
-	if tenantSchema, ok := auth.GetTenantSchemaFromContext(c); ok {
+	if tenantSchema != "" {
 		req.Metadata["tenant_schema"] = tenantSchema
 	}
 	req.Metadata["user_id"] = fmt.Sprintf("%d", user.ID)
 
 	// Create checkout session
 
-	session, err := h.stripeSvc.CreateCheckoutSessionForPlan(c.Request.Context(), user.Email, customer.ID, req.PlanID, req.Metadata)
+	session, err := h.stripeSvc.CreateCheckoutSessionForPlan(ctx, user.Email, customerID, req.PlanID, req.Metadata)
 	if err != nil {
 		h.logger.Error("Failed to create checkout session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create checkout session"})
 		return
 	}
 
-	h.logger.Info("Created checkout session for plan", "plan_id", req.PlanID, "session_id", session.ID, "client_secret", session.ClientSecret)
+	h.logger.Info("Created checkout session for plan", "plan_id", req.PlanID, "session_id", session.ID)
 
 	data := &CheckoutSessionResponse{
 		SessionID:    session.ID,
@@ -209,6 +210,17 @@ func (h *Handler) CreateCheckoutSession(c *gin.Context) {
 		return
 	}
 
+	// Only redirect back to our own origins
+	for field, target := range map[string]string{"successUrl": req.SuccessURL, "cancelUrl": req.CancelURL} {
+		if target == "" {
+			continue
+		}
+		if err := h.deps.Config.ValidateRedirectURL(target); err != nil {
+			i18n.Error(c, http.StatusBadRequest, "redirect_not_allowed", field+" is not allowed")
+			return
+		}
+	}
+
 	// Get user from context
 	claims, ok := auth.GetClaimsFromContext(c)
 	if !ok {
@@ -224,14 +236,12 @@ func (h *Handler) CreateCheckoutSession(c *gin.Context) {
 		return
 	}
 
-	// Get or create Stripe customer
-	customerName := user.FirstName + " " + user.LastName
-	metadata := map[string]string{
-		"user_id": fmt.Sprintf("%d", user.ID),
-		"email":   user.Email,
-	}
+	// Stripe creates are keyed to the request's Idempotency-Key, if any
+	ctx := services.WithIdempotencyKey(c.Request.Context(), middleware.IdempotencyKey(c))
 
-	customer, err := h.stripeSvc.GetOrCreateCustomer(c.Request.Context(), user.Email, customerName, metadata)
+	// Use the user's Stripe customer, created on their first payment
+	tenantSchema, _ := auth.GetTenantSchemaFromContext(c)
+	customerID, err := h.deps.StripeCustomerID(ctx, h.stripeSvc, &user, tenantSchema)
 	if err != nil {
 		h.logger.Error("Failed to get or create customer", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create customer"})
@@ -242,14 +252,14 @@ func (h *Handler) CreateCheckoutSession(c *gin.Context) {
 	if req.Metadata == nil {
 		req.Metadata = make(map[string]string)
 	}
-	if tenantSchema, ok := auth.GetTenantSchemaFromContext(c); ok {
+	if tenantSchema != "" {
 		req.Metadata["tenant_schema"] = tenantSchema
 	}
 	req.Metadata["user_id"] = fmt.Sprintf("%d", user.ID)
 
 	// Create checkout session
 	sessionParams := &services.CheckoutSessionParams{
-		CustomerID:  customer.ID,
+		CustomerID:  customerID,
 		Mode:        req.Mode,
 		PriceID:     req.PriceID,
 		Amount:      req.Amount,
@@ -264,7 +274,7 @@ func (h *Handler) CreateCheckoutSession(c *gin.Context) {
 		UIMode: stripe.String("embedded"),
 	}
 
-	session, err := h.stripeSvc.CreateCheckoutSession(c.Request.Context(), sessionParams, stripeSessionParams)
+	session, err := h.stripeSvc.CreateCheckoutSession(ctx, sessionParams, stripeSessionParams)
 	if err != nil {
 		h.logger.Error("Failed to create checkout session", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create checkout session"})
@@ -318,10 +328,16 @@ func (h *Handler) HandleWebhook(c *gin.Context) {
 		h.handleSubscriptionUpdated(event)
 	case "customer.subscription.deleted":
 		h.handleSubscriptionDeleted(event)
+	case "charge.refunded":
+		h.handleChargeRefunded(event)
+	case "refund.created", "refund.updated":
+		h.handleRefundUpdated(event)
 	case "invoice.paid":
 		h.handleInvoicePaid(event)
 	case "invoice.payment_failed":
 		h.handleInvoicePaymentFailed(event)
+	case "customer.deleted":
+		h.handleCustomerDeleted(event)
 	default:
 		h.logger.Info("Unhandled webhook event type", "type", event.Type)
 	}
@@ -382,6 +398,19 @@ func (h *Handler) handleOneTimePayment(session *stripe.CheckoutSession) {
 	}
 
 	h.logger.Info("One-time payment recorded", "payment_id", payment.ID, "amount", payment.Amount)
+	h.emitPaymentSucceeded(&payment)
+}
+
+// emitPaymentSucceeded sends the payment.succeeded webhook event
+func (h *Handler) emitPaymentSucceeded(payment *models.Payment) {
+	h.deps.Webhooks.Emit(context.Background(), payment.TenantSchema, webhooks.EventPaymentSucceeded, gin.H{
+		"paymentId":             payment.ID,
+		"stripePaymentIntentId": payment.StripePaymentIntentID,
+		"amount":                payment.Amount,
+		"currency":              payment.Currency,
+		"description":           payment.Description,
+		"paidAt":                payment.PaidAt,
+	})
 }
 
 func (h *Handler) handleSubscriptionCheckout(session *stripe.CheckoutSession) {
@@ -396,17 +425,28 @@ func (h *Handler) handlePaymentIntentSucceeded(event stripe.Event) {
 		return
 	}
 
-	// Update payment status
-	if err := h.deps.DB.DB.Model(&models.Payment{}).
-		Where("stripe_payment_intent_id = ?", paymentIntent.ID).
+	// Update payment status. Payments already marked succeeded, such as
+	// those recorded from a checkout session, were reported then.
+	res := h.deps.DB.DB.Model(&models.Payment{}).
+		Where("stripe_payment_intent_id = ? AND status <> ?", paymentIntent.ID, "succeeded").
 		Updates(map[string]interface{}{
 			"status":  "succeeded",
 			"paid_at": time.Now(),
-		}).Error; err != nil {
-		h.logger.Error("Failed to update payment", "error", err)
+		})
+	if res.Error != nil {
+		h.logger.Error("Failed to update payment", "error", res.Error)
 	}
 
 	h.logger.Info("Payment succeeded", "payment_intent_id", paymentIntent.ID)
+
+	if res.Error == nil && res.RowsAffected > 0 {
+		var payment models.Payment
+		if err := h.deps.DB.DB.Where("stripe_payment_intent_id = ?", paymentIntent.ID).First(&payment).Error; err != nil {
+			h.logger.Error("Failed to load payment", "payment_intent_id", paymentIntent.ID, "error", err)
+			return
+		}
+		h.emitPaymentSucceeded(&payment)
+	}
 }
 
 func (h *Handler) handlePaymentIntentFailed(event stripe.Event) {
@@ -416,14 +456,51 @@ func (h *Handler) handlePaymentIntentFailed(event stripe.Event) {
 		return
 	}
 
-	// Update payment status
-	if err := h.deps.DB.DB.Model(&models.Payment{}).
-		Where("stripe_payment_intent_id = ?", paymentIntent.ID).
-		Update("status", "failed").Error; err != nil {
-		h.logger.Error("Failed to update payment", "error", err)
+	// Update payment status. Payments already marked failed were reported
+	// then.
+	res := h.deps.DB.DB.Model(&models.Payment{}).
+		Where("stripe_payment_intent_id = ? AND status <> ?", paymentIntent.ID, "failed").
+		Update("status", "failed")
+	if res.Error != nil {
+		h.logger.Error("Failed to update payment", "error", res.Error)
 	}
 
 	h.logger.Info("Payment failed", "payment_intent_id", paymentIntent.ID)
+
+	if res.Error == nil && res.RowsAffected > 0 {
+		var payment models.Payment
+		if err := h.deps.DB.DB.Where("stripe_payment_intent_id = ?", paymentIntent.ID).First(&payment).Error; err != nil {
+			h.logger.Error("Failed to load payment", "payment_intent_id", paymentIntent.ID, "error", err)
+			return
+		}
+		reason := ""
+		if paymentIntent.LastPaymentError != nil {
+			reason = paymentIntent.LastPaymentError.Msg
+		}
+		h.notifyPaymentFailed(payment.TenantSchema, payment.Amount, payment.Currency, reason, gin.H{
+			"paymentId":             payment.ID,
+			"stripePaymentIntentId": payment.StripePaymentIntentID,
+		})
+	}
+}
+
+// notifyPaymentFailed sends the payment_failed notification
+func (h *Handler) notifyPaymentFailed(tenantSchema string, amount int64, currency, reason string, metadata gin.H) {
+	body := fmt.Sprintf("A payment of %.2f %s could not be processed.", float64(amount)/100, strings.ToUpper(currency))
+	if reason != "" {
+		body += " " + reason
+	}
+	metadata["amount"] = amount
+	metadata["currency"] = currency
+	err := h.deps.Notifications.Notify(context.Background(), tenantSchema, notifications.Notification{
+		Type:     notifications.TypePaymentFailed,
+		Title:    "A payment failed",
+		Body:     body,
+		Metadata: metadata,
+	})
+	if err != nil {
+		h.logger.Error("Failed to send payment failed notification", "tenant", tenantSchema, "error", err)
+	}
 }
 
 func (h *Handler) handleSubscriptionCreated(event stripe.Event) {
@@ -443,21 +520,40 @@ func (h *Handler) handleSubscriptionCreated(event stripe.Event) {
 		return
 	}
 
+	full, periodStart, periodEnd, ok := h.subscriptionPeriod(context.Background(), &sub)
+	if !ok {
+		// Better than the Unix epoch; corrected by the next update event
+		h.logger.Warn("Subscription period unknown, using its start date", "stripe_id", sub.ID)
+		periodStart = time.Unix(sub.StartDate, 0)
+		periodEnd = periodStart
+	}
+
 	subscription := models.Subscription{
 		TenantSchema:         tenantSchema,
 		UserID:               uint(userID),
 		StripeSubscriptionID: sub.ID,
-		StripeCustomerID:     sub.Customer.ID,
-		StripePriceID:        sub.Items.Data[0].Price.ID,
-		StripeProductID:      sub.Items.Data[0].Price.Product.ID,
 		Status:               string(sub.Status),
-		PlanName:             sub.Items.Data[0].Price.Nickname,
-		Amount:               sub.Items.Data[0].Price.UnitAmount,
-		Currency:             string(sub.Items.Data[0].Price.Currency),
-		Interval:             string(sub.Items.Data[0].Price.Recurring.Interval),
-		IntervalCount:        int(sub.Items.Data[0].Price.Recurring.IntervalCount),
-		CurrentPeriodStart:   time.Unix(sub.LatestInvoice.PeriodStart, 0),
-		CurrentPeriodEnd:     time.Unix(sub.LatestInvoice.PeriodEnd, 0),
+		CurrentPeriodStart:   periodStart,
+		CurrentPeriodEnd:     periodEnd,
+	}
+	if sub.Customer != nil {
+		subscription.StripeCustomerID = sub.Customer.ID
+	}
+	if full.Items != nil && len(full.Items.Data) > 0 && full.Items.Data[0].Price != nil {
+		price := full.Items.Data[0].Price
+		subscription.StripePriceID = price.ID
+		subscription.PlanName = price.Nickname
+		subscription.Amount = price.UnitAmount
+		subscription.Currency = string(price.Currency)
+		if price.Product != nil {
+			subscription.StripeProductID = price.Product.ID
+		}
+		if price.Recurring != nil {
+			subscription.Interval = string(price.Recurring.Interval)
+			subscription.IntervalCount = int(price.Recurring.IntervalCount)
+		}
+	} else {
+		h.logger.Warn("Subscription has no items", "stripe_id", sub.ID)
 	}
 
 	if sub.TrialStart != 0 {
@@ -478,6 +574,8 @@ func (h *Handler) handleSubscriptionCreated(event stripe.Event) {
 		return
 	}
 
+	h.deps.InvalidateDashboard(context.Background(), tenantSchema)
+
 	h.logger.Info("Subscription created", "subscription_id", subscription.ID, "stripe_id", sub.ID)
 }
 
@@ -488,24 +586,41 @@ func (h *Handler) handleSubscriptionUpdated(event stripe.Event) {
 		return
 	}
 
+	full, periodStart, periodEnd, ok := h.subscriptionPeriod(context.Background(), &sub)
+
 	updates := map[string]interface{}{
 		"status":               string(sub.Status),
-		"current_period_start": time.Unix(sub.LatestInvoice.PeriodStart, 0),
-		"current_period_end":   time.Unix(sub.LatestInvoice.PeriodEnd, 0),
 		"cancel_at_period_end": sub.CancelAtPeriodEnd,
 	}
+	if ok {
+		updates["current_period_start"] = periodStart
+		updates["current_period_end"] = periodEnd
+	} else {
+		h.logger.Warn("Subscription period unknown, keeping the stored period", "stripe_id", sub.ID)
+	}
 
 	if sub.CanceledAt != 0 {
 		canceledAt := time.Unix(sub.CanceledAt, 0)
 		updates["canceled_at"] = &canceledAt
 	}
 
-	if err := h.deps.DB.DB.Model(&models.Subscription{}).
-		Where("stripe_subscription_id = ?", sub.ID).
-		Updates(updates).Error; err != nil {
+	var local models.Subscription
+	if err := h.deps.DB.DB.Where("stripe_subscription_id = ?", sub.ID).First(&local).Error; err != nil {
+		h.logger.Error("Failed to load subscription", "error", err, "stripe_id", sub.ID)
+		return
+	}
+
+	if err := h.deps.DB.DB.Model(&local).Updates(updates).Error; err != nil {
 		h.logger.Error("Failed to update subscription", "error", err)
 	}
 
+	// The price may have been changed from the Stripe dashboard
+	if err := h.applySubscriptionPrice(context.Background(), &local, full); err != nil {
+		h.logger.Error("Failed to update subscription price", "error", err)
+	}
+
+	h.deps.InvalidateDashboard(context.Background(), local.TenantSchema)
+
 	h.logger.Info("Subscription updated", "stripe_id", sub.ID, "status", sub.Status)
 }
 
@@ -522,9 +637,47 @@ func (h *Handler) handleSubscriptionDeleted(event stripe.Event) {
 		h.logger.Error("Failed to update subscription", "error", err)
 	}
 
+	h.deps.InvalidateDashboard(context.Background(), sub.Metadata["tenant_schema"])
+
 	h.logger.Info("Subscription deleted", "stripe_id", sub.ID)
 }
 
+// handleCustomerDeleted forgets a deleted Stripe customer, so the next
+// payment finds or creates another one
+func (h *Handler) handleCustomerDeleted(event stripe.Event) {
+	var cust stripe.Customer
+	if err := h.stripeSvc.ParseWebhookData(event.Data, &cust); err != nil {
+		h.logger.Error("Failed to parse customer", "error", err)
+		return
+	}
+	ctx := context.Background()
+
+	res := h.deps.DB.DB.WithContext(ctx).Model(&models.User{}).
+		Where("stripe_customer_id = ?", cust.ID).
+		Update("stripe_customer_id", nil)
+	if res.Error != nil {
+		h.logger.Error("Failed to clear Stripe customer on users", "customer_id", cust.ID, "error", res.Error)
+	}
+
+	var schemas []string
+	if err := h.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).Pluck("schema_name", &schemas).Error; err != nil {
+		h.logger.Error("Failed to list tenants", "error", err)
+		return
+	}
+	for _, schema := range schemas {
+		err := h.deps.DB.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+			return tx.Model(&models.TenantAccount{}).
+				Where("stripe_customer_id = ?", cust.ID).
+				Update("stripe_customer_id", nil).Error
+		})
+		if err != nil {
+			h.logger.Error("Failed to clear Stripe customer on account", "tenant", schema, "customer_id", cust.ID, "error", err)
+		}
+	}
+
+	h.logger.Info("Customer deleted", "customer_id", cust.ID, "users", res.RowsAffected)
+}
+
 func (h *Handler) handleInvoicePaid(event stripe.Event) {
 	var invoice stripe.Invoice
 	if err := h.stripeSvc.ParseWebhookData(event.Data, &invoice); err != nil {
@@ -543,4 +696,22 @@ func (h *Handler) handleInvoicePaymentFailed(event stripe.Event) {
 	}
 
 	h.logger.Info("Invoice payment failed", "invoice_id", invoice.ID)
+
+	// Invoices belong to the tenant subscribed with the invoice's customer
+	if invoice.Customer == nil {
+		return
+	}
+	var sub models.Subscription
+	err := h.deps.DB.DB.Where("stripe_customer_id = ?", invoice.Customer.ID).
+		Order("created_at DESC").
+		First(&sub).Error
+	if err != nil {
+		h.logger.Warn("No subscription found for failed invoice", "invoice_id", invoice.ID, "customer_id", invoice.Customer.ID, "error", err)
+		return
+	}
+	h.notifyPaymentFailed(sub.TenantSchema, invoice.AmountDue, string(invoice.Currency), "", gin.H{
+		"invoiceId":        invoice.ID,
+		"invoiceNumber":    invoice.Number,
+		"hostedInvoiceUrl": invoice.HostedInvoiceURL,
+	})
 }
@@ -0,0 +1,57 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/sections"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCheckoutRedirectsAllowlisted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := common.DefaultConfig()
+	cfg.FrontendURL = "https://app.example.com"
+	cfg.AllowedRedirectOrigins = []string{"https://*.sites.example.com"}
+	h := NewHandler(&sections.Dependencies{Config: cfg}, nil)
+	r := gin.New()
+	r.POST("/checkout", h.CreateCheckoutSession)
+
+	tests := []struct {
+		name       string
+		successURL string
+		cancelURL  string
+		status     int
+	}{
+		{"own origins", "https://app.example.com/success", "https://shop.sites.example.com/cart", http.StatusUnauthorized},
+		{"no URLs", "", "", http.StatusUnauthorized},
+		{"foreign success URL", "https://evil.example/success", "", http.StatusBadRequest},
+		{"foreign cancel URL", "https://app.example.com/success", "http://app.example.com/cancel", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(CreateCheckoutSessionRequest{Mode: "payment", Amount: 500, Currency: "usd", SuccessURL: tt.successURL, CancelURL: tt.cancelURL})
+		req := httptest.NewRequest(http.MethodPost, "/checkout", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		// Allowed URLs get as far as the missing login
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		var resp struct {
+			Code string `json:"code"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if tt.status == http.StatusBadRequest && resp.Code != "redirect_not_allowed" {
+			t.Errorf("%s: code = %q, want redirect_not_allowed", tt.name, resp.Code)
+		}
+	}
+}
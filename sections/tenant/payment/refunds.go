@@ -0,0 +1,301 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/account"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v84"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Payment metadata keys recording the credits a payment bought
+const (
+	MetadataBasicCredits   = "basic_credits"
+	MetadataPremiumCredits = "premium_credits"
+)
+
+// RefundRequest issues a full or partial refund of a payment
+type RefundRequest struct {
+	// Amount in cents; omit to refund the remaining balance
+	Amount *int64 `json:"amount,omitempty" binding:"omitempty,min=1"`
+	Reason string `json:"reason" binding:"omitempty,oneof=duplicate fraudulent requested_by_customer"`
+	// ClawBackCredits removes unspent credits bought with the payment, in
+	// proportion to the refunded amount
+	ClawBackCredits bool `json:"clawBackCredits,omitempty"`
+}
+
+// RefundPayment refunds a payment (admin only)
+func (h *Handler) RefundPayment(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment id"})
+		return
+	}
+
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var payment models.Payment
+	err = h.deps.DB.DB.First(&payment, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load payment", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load payment"})
+		return
+	}
+
+	if payment.Status != "succeeded" && payment.Status != "partially_refunded" {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment cannot be refunded in status " + payment.Status})
+		return
+	}
+
+	remaining := payment.Amount - payment.AmountRefunded
+	amount := remaining
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	if amount <= 0 || amount > remaining {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount exceeds the refundable balance", "refundable": remaining})
+		return
+	}
+
+	ref, err := h.stripeSvc.RefundPayment(ctx, payment.StripePaymentIntentID, &amount, req.Reason)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to refund payment"})
+		return
+	}
+
+	record, err := h.syncRefund(&payment, ref)
+	if err != nil {
+		h.logger.Error("Failed to record refund", "error", err, "refund_id", ref.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "refund issued but could not be recorded"})
+		return
+	}
+
+	if req.ClawBackCredits {
+		clawed, err := h.clawBackCredits(ctx, &payment, amount)
+		if err != nil {
+			h.logger.Error("Failed to claw back credits", "error", err, "payment_id", payment.ID)
+		} else if clawed > 0 {
+			record.CreditsClawedBack = clawed
+			if err := h.deps.DB.DB.Model(record).Update("credits_clawed_back", clawed).Error; err != nil {
+				h.logger.Error("Failed to update refund", "error", err)
+			}
+		}
+	}
+
+	if err := h.refreshPaymentRefunds(&payment); err != nil {
+		h.logger.Error("Failed to update payment", "error", err)
+	}
+
+	h.logger.Info("Payment refunded", "payment_id", payment.ID, "refund_id", ref.ID, "amount", amount)
+
+	c.JSON(http.StatusOK, gin.H{"refund": record, "payment": payment})
+}
+
+// syncRefund creates or updates the local row for a Stripe refund
+func (h *Handler) syncRefund(payment *models.Payment, ref *stripe.Refund) (*models.Refund, error) {
+	record := models.Refund{
+		PaymentID:      payment.ID,
+		StripeRefundID: ref.ID,
+		Amount:         ref.Amount,
+		Currency:       string(ref.Currency),
+		Reason:         string(ref.Reason),
+		Status:         string(ref.Status),
+	}
+	if record.Currency == "" {
+		record.Currency = payment.Currency
+	}
+
+	err := h.deps.DB.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "stripe_refund_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"amount", "reason", "status", "updated_at"}),
+	}).Create(&record).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.deps.DB.DB.Where("stripe_refund_id = ?", ref.ID).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// refreshPaymentRefunds recomputes the refunded amount and status of a
+// payment from its refund rows
+func (h *Handler) refreshPaymentRefunds(payment *models.Payment) error {
+	var refunded int64
+	if err := h.deps.DB.DB.Model(&models.Refund{}).
+		Where("payment_id = ? AND status IN ?", payment.ID, []string{"pending", "requires_action", "succeeded"}).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&refunded).Error; err != nil {
+		return err
+	}
+	return h.setPaymentRefunded(payment, refunded)
+}
+
+// setPaymentRefunded stores the refunded amount and derives the status
+func (h *Handler) setPaymentRefunded(payment *models.Payment, refunded int64) error {
+	status := payment.Status
+	switch {
+	case refunded >= payment.Amount:
+		status = "refunded"
+	case refunded > 0:
+		status = "partially_refunded"
+	case payment.Status == "refunded" || payment.Status == "partially_refunded":
+		status = "succeeded"
+	}
+
+	payment.AmountRefunded = refunded
+	payment.Status = status
+	return h.deps.DB.DB.Model(payment).Updates(map[string]interface{}{
+		"amount_refunded": refunded,
+		"status":          status,
+	}).Error
+}
+
+// clawBackCredits removes the unspent share of credits bought with the
+// payment, proportional to the refunded amount, and returns how many were
+// removed
+func (h *Handler) clawBackCredits(ctx context.Context, payment *models.Payment, refunded int64) (int, error) {
+	if payment.Metadata == "" || payment.Amount <= 0 || payment.TenantSchema == "" {
+		return 0, nil
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal([]byte(payment.Metadata), &metadata); err != nil {
+		return 0, nil
+	}
+
+	share := func(key string) int {
+		n, err := strconv.Atoi(metadata[key])
+		if err != nil || n <= 0 {
+			return 0
+		}
+		return int(int64(n) * refunded / payment.Amount)
+	}
+	basic, premium := share(MetadataBasicCredits), share(MetadataPremiumCredits)
+	if basic == 0 && premium == 0 {
+		return 0, nil
+	}
+
+	clawed := 0
+	_, _, err := account.ChangeCredits(ctx, h.deps, payment.TenantSchema, false, func(acct *models.TenantAccount) ([]account.CreditEntry, error) {
+		basic = min(basic, acct.BasicCredits)
+		premium = min(premium, acct.PremiumCredits)
+		clawed = basic + premium
+
+		reason := fmt.Sprintf("Refund of payment %d", payment.ID)
+		return []account.CreditEntry{
+			{CreditType: account.CreditTypeBasic, Type: account.CreditTxnRefund, Delta: -basic, Reason: reason, Actor: "admin", PaymentID: &payment.ID},
+			{CreditType: account.CreditTypePremium, Type: account.CreditTxnRefund, Delta: -premium, Reason: reason, Actor: "admin", PaymentID: &payment.ID},
+		}, nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	h.logger.Info("Clawed back credits", "tenant_schema", payment.TenantSchema, "basic", basic, "premium", premium)
+	return clawed, nil
+}
+
+// findPayment loads the payment for a payment intent, returning nil when the
+// payment is not tracked locally
+func (h *Handler) findPayment(paymentIntentID string) (*models.Payment, error) {
+	var payment models.Payment
+	err := h.deps.DB.DB.Where("stripe_payment_intent_id = ?", paymentIntentID).First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (h *Handler) handleChargeRefunded(event stripe.Event) {
+	var charge stripe.Charge
+	if err := h.stripeSvc.ParseWebhookData(event.Data, &charge); err != nil {
+		h.logger.Error("Failed to parse charge", "error", err)
+		return
+	}
+	if charge.PaymentIntent == nil {
+		return
+	}
+
+	payment, err := h.findPayment(charge.PaymentIntent.ID)
+	if err != nil {
+		h.logger.Error("Failed to load payment", "error", err)
+		return
+	}
+	if payment == nil {
+		h.logger.Info("Refund for untracked payment", "payment_intent_id", charge.PaymentIntent.ID)
+		return
+	}
+
+	if charge.Refunds != nil {
+		for _, ref := range charge.Refunds.Data {
+			if _, err := h.syncRefund(payment, ref); err != nil {
+				h.logger.Error("Failed to sync refund", "error", err, "refund_id", ref.ID)
+			}
+		}
+	}
+
+	// The charge carries the authoritative refunded total
+	if err := h.setPaymentRefunded(payment, charge.AmountRefunded); err != nil {
+		h.logger.Error("Failed to update payment", "error", err)
+		return
+	}
+
+	h.logger.Info("Charge refunded", "payment_id", payment.ID, "amount_refunded", charge.AmountRefunded)
+}
+
+func (h *Handler) handleRefundUpdated(event stripe.Event) {
+	var ref stripe.Refund
+	if err := h.stripeSvc.ParseWebhookData(event.Data, &ref); err != nil {
+		h.logger.Error("Failed to parse refund", "error", err)
+		return
+	}
+	if ref.PaymentIntent == nil {
+		return
+	}
+
+	payment, err := h.findPayment(ref.PaymentIntent.ID)
+	if err != nil {
+		h.logger.Error("Failed to load payment", "error", err)
+		return
+	}
+	if payment == nil {
+		return
+	}
+
+	if _, err := h.syncRefund(payment, &ref); err != nil {
+		h.logger.Error("Failed to sync refund", "error", err, "refund_id", ref.ID)
+		return
+	}
+	if err := h.refreshPaymentRefunds(payment); err != nil {
+		h.logger.Error("Failed to update payment", "error", err)
+	}
+
+	h.logger.Info("Refund updated", "refund_id", ref.ID, "status", ref.Status)
+}
@@ -1,8 +1,10 @@
 package payment
 
 import (
+	"awning-backend/middleware"
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/flags"
 	"awning-backend/services"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +17,25 @@ func RegisterRoutes(frontendRoutes, webhookRoutes *gin.RouterGroup, deps *sectio
 	// Protected routes for creating checkout sessions (requires authentication)
 	payment := frontendRoutes.Group("/api/v1/payments")
 	payment.Use(auth.JWTAuthMiddleware(jwtManager))
+	payment.Use(auth.MaintenanceMiddleware())
 	{
-		payment.POST("/plan", handler.CreatePaymentIntentForPlan)
-		payment.POST("/checkout", handler.CreateCheckoutSession)
+		payment.POST("/plan", deps.Flags.Require(flags.Checkout), deps.Idempotency(), handler.CreatePaymentIntentForPlan)
+		payment.POST("/checkout", deps.Flags.Require(flags.Checkout), deps.Idempotency(), handler.CreateCheckoutSession)
+	}
+
+	// Tenant subscription management
+	subscriptions := frontendRoutes.Group("/api/v1/subscriptions")
+	subscriptions.Use(auth.JWTAuthMiddleware(jwtManager))
+	subscriptions.Use(auth.TenantFromHeaderMiddleware(auth.DefaultTenantMiddlewareConfig()))
+	{
+		subscriptions.POST("/:id/change-plan", handler.ChangePlan)
+	}
+
+	// Admin routes authenticated with the server API key
+	admin := frontendRoutes.Group("/api/v1/admin/payments")
+	admin.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		admin.POST("/:id/refund", handler.RefundPayment)
 	}
 
 	// Webhook routes (no authentication, verified via Stripe signature)
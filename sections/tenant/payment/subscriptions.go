@@ -0,0 +1,194 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v84"
+	"gorm.io/gorm"
+)
+
+// ChangePlanRequest selects the plan to move a subscription to
+type ChangePlanRequest struct {
+	PlanID            string `json:"planId" binding:"required"`
+	ProrationBehavior string `json:"prorationBehavior" binding:"omitempty,oneof=create_prorations none"`
+	// AtPeriodEnd defers the change to the end of the current billing period,
+	// typically for downgrades
+	AtPeriodEnd bool `json:"atPeriodEnd,omitempty"`
+}
+
+// ChangePlan moves the tenant's subscription to another recurring plan
+func (h *Handler) ChangePlan(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid subscription id"})
+		return
+	}
+
+	var req ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.ProrationBehavior == "" {
+		req.ProrationBehavior = "create_prorations"
+	}
+
+	plan := common.GetPlan(h.deps.Plans, req.PlanID)
+	if plan == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+		return
+	}
+	if plan.Interval == "" || plan.PriceId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "plan is not a recurring plan"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var sub models.Subscription
+	err = h.deps.DB.DB.Where("id = ? AND tenant_schema = ?", id, tenantID).First(&sub).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "subscription not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load subscription", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load subscription"})
+		return
+	}
+
+	if sub.Status == "canceled" {
+		c.JSON(http.StatusConflict, gin.H{"error": "subscription is canceled"})
+		return
+	}
+	if sub.StripePriceID == plan.PriceId {
+		c.JSON(http.StatusConflict, gin.H{"error": "subscription is already on this plan"})
+		return
+	}
+
+	if req.AtPeriodEnd {
+		schedule, err := h.stripeSvc.ScheduleSubscriptionPriceChange(ctx, sub.StripeSubscriptionID, plan.PriceId)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "failed to schedule plan change"})
+			return
+		}
+
+		// The local row is updated by the webhook once the new phase starts
+		c.JSON(http.StatusAccepted, gin.H{
+			"scheduled":   true,
+			"scheduleId":  schedule.ID,
+			"planId":      plan.ID,
+			"effectiveAt": time.Unix(schedule.CurrentPhase.EndDate, 0).UTC(),
+		})
+		return
+	}
+
+	updated, err := h.stripeSvc.UpdateSubscriptionPrice(ctx, sub.StripeSubscriptionID, plan.PriceId, req.ProrationBehavior)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to change plan"})
+		return
+	}
+
+	if err := h.applySubscriptionPrice(ctx, &sub, updated); err != nil {
+		h.logger.Error("Failed to update subscription record", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update subscription"})
+		return
+	}
+
+	h.logger.Info("Subscription plan changed", "subscription_id", sub.ID, "plan_id", plan.ID, "proration_behavior", req.ProrationBehavior)
+
+	c.JSON(http.StatusOK, gin.H{"subscription": sub})
+}
+
+// planForPrice returns the configured plan billed with priceID, if any
+func (h *Handler) planForPrice(priceID string) *common.Plan {
+	for i := range h.deps.Plans {
+		if h.deps.Plans[i].PriceId == priceID {
+			return &h.deps.Plans[i]
+		}
+	}
+	return nil
+}
+
+// subscriptionPeriod returns the subscription's current period. Webhook
+// payloads may lack the items carrying it, in which case the subscription is
+// fetched from Stripe and returned in place of sub. ok is false when the
+// period is still unknown.
+func (h *Handler) subscriptionPeriod(ctx context.Context, sub *stripe.Subscription) (*stripe.Subscription, time.Time, time.Time, bool) {
+	if start, end, ok := services.SubscriptionPeriod(sub); ok {
+		return sub, start, end, true
+	}
+
+	h.logger.Debug("Subscription period missing from payload, fetching subscription", "stripe_id", sub.ID)
+	fetched, err := h.stripeSvc.GetSubscription(ctx, sub.ID)
+	if err != nil {
+		h.logger.Error("Failed to fetch subscription", "stripe_id", sub.ID, "error", err)
+		return sub, time.Time{}, time.Time{}, false
+	}
+	start, end, ok := services.SubscriptionPeriod(fetched)
+	return fetched, start, end, ok
+}
+
+// applySubscriptionPrice copies the price of the subscription's first item
+// onto the local row and moves the tenant account to the matching plan. It is
+// used both after a plan change through the API and for webhook updates, so
+// changes made from the Stripe dashboard are picked up too.
+func (h *Handler) applySubscriptionPrice(ctx context.Context, local *models.Subscription, sub *stripe.Subscription) error {
+	if sub.Items == nil || len(sub.Items.Data) == 0 || sub.Items.Data[0].Price == nil {
+		return nil
+	}
+	price := sub.Items.Data[0].Price
+
+	planName := price.Nickname
+	plan := h.planForPrice(price.ID)
+	if plan != nil {
+		planName = plan.Name
+	}
+
+	updates := map[string]interface{}{
+		"stripe_price_id": price.ID,
+		"amount":          price.UnitAmount,
+		"currency":        string(price.Currency),
+		"plan_name":       planName,
+	}
+	if price.Product != nil {
+		updates["stripe_product_id"] = price.Product.ID
+	}
+	if price.Recurring != nil {
+		updates["interval"] = string(price.Recurring.Interval)
+		updates["interval_count"] = int(price.Recurring.IntervalCount)
+	}
+
+	if err := h.deps.DB.DB.Model(local).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if plan == nil || local.TenantSchema == "" {
+		return nil
+	}
+
+	return h.deps.DB.WithTenant(ctx, local.TenantSchema, func(tx *gorm.DB) error {
+		return tx.Model(&models.TenantAccount{}).
+			Where("tenant_schema = ?", local.TenantSchema).
+			Updates(map[string]interface{}{
+				"subscription_plan": plan.ID,
+				"paid_account":      true,
+			}).Error
+	})
+}
@@ -3,6 +3,7 @@ package profile
 import (
 	"log/slog"
 	"net/http"
+	"time"
 
 	"awning-backend/sections"
 	"awning-backend/sections/common/auth"
@@ -38,6 +39,7 @@ type ProfileRequest struct {
 	Timezone     string `json:"timezone"`
 	Locale       string `json:"locale"`
 	Metadata     string `json:"metadata"`
+	BrandVoice   string `json:"brandVoice" binding:"max=2000"`
 }
 
 // ProfileResponse represents a profile response
@@ -54,6 +56,7 @@ type ProfileResponse struct {
 	Timezone     string `json:"timezone"`
 	Locale       string `json:"locale"`
 	Metadata     string `json:"metadata"`
+	BrandVoice   string `json:"brandVoice"`
 }
 
 // GetProfile retrieves the tenant profile
@@ -97,6 +100,13 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if req.Timezone != "" {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+			return
+		}
+	}
+
 	var profile models.TenantProfile
 	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
 		// Try to find existing profile
@@ -123,6 +133,7 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 			profile.Locale = req.Locale
 		}
 		profile.Metadata = req.Metadata
+		profile.BrandVoice = req.BrandVoice
 
 		return tx.Save(&profile).Error
 	})
@@ -133,6 +144,10 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	if h.deps.Sites != nil {
+		h.deps.Sites.InvalidateTenantTimezone(c.Request.Context(), tenantID)
+	}
+
 	c.JSON(http.StatusOK, h.toResponse(&profile))
 }
 
@@ -150,6 +165,7 @@ func (h *Handler) toResponse(profile *models.TenantProfile) ProfileResponse {
 		Timezone:     profile.Timezone,
 		Locale:       profile.Locale,
 		Metadata:     profile.Metadata,
+		BrandVoice:   profile.BrandVoice,
 	}
 }
 
@@ -0,0 +1,454 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awning-backend/db"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errSourceNotFound   = errors.New("source not found")
+	errSourceNoHTML     = errors.New("source has no HTML content")
+	errSitePagesChanged = errors.New("the site's pages were replaced by a later generation")
+)
+
+// Handler handles site publishing requests
+type Handler struct {
+	logger      *slog.Logger
+	deps        *sections.Dependencies
+	reprocessor *Reprocessor
+	verifier    *Verifier
+}
+
+// NewHandler creates a new publish handler
+func NewHandler(deps *sections.Dependencies) *Handler {
+	return &Handler{
+		logger:      slog.With("handler", "PublishHandler"),
+		deps:        deps,
+		reprocessor: NewReprocessor(deps),
+		verifier:    NewVerifier(deps),
+	}
+}
+
+// PublishRequest selects the content to publish; exactly one field is required
+type PublishRequest struct {
+	FilesystemKey string `json:"filesystemKey"`
+	ChatID        string `json:"chatId"`
+}
+
+// PublicationResponse represents a publication response
+type PublicationResponse struct {
+	Version     int       `json:"version"`
+	ContentHash string    `json:"contentHash"`
+	Source      string    `json:"source"`
+	Current     bool      `json:"current"`
+	PublishedAt time.Time `json:"publishedAt"`
+	PublishedBy uint      `json:"publishedBy"`
+	Size        int       `json:"size,omitempty"`
+}
+
+func toResponse(p *models.TenantPublication) PublicationResponse {
+	return PublicationResponse{
+		Version:     p.Version,
+		ContentHash: p.ContentHash,
+		Source:      p.Source,
+		Current:     p.Current,
+		PublishedAt: p.PublishedAt.UTC(),
+		PublishedBy: p.PublishedBy,
+		Size:        len(p.Content),
+	}
+}
+
+// Publish snapshots HTML from the filesystem or a chat as the tenant's new live site
+func (h *Handler) Publish(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var req PublishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.FilesystemKey == "") == (req.ChatID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of filesystemKey or chatId is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var content, source string
+	var pages map[string]string
+	var err error
+	if req.ChatID != "" {
+		source = "chat:" + req.ChatID
+		content, pages, err = h.loadChatSite(ctx, tenantID, req.ChatID)
+	} else {
+		source = "filesystem:" + req.FilesystemKey
+		content, err = h.loadFilesystemHTML(ctx, tenantID, req.FilesystemKey)
+	}
+	if errors.Is(err, errSourceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errSourceNoHTML) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errSitePagesChanged) {
+		i18n.Error(c, http.StatusConflict, "site_pages_changed", err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load publish source", "source", source, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load content"})
+		return
+	}
+
+	userID, _ := auth.GetUserIDFromContext(c)
+
+	content = CanonicalHTML(content)
+	for path, page := range pages {
+		pages[path] = CanonicalHTML(page)
+	}
+
+	var pagesJSON string
+	if len(pages) > 0 {
+		data, _ := json.Marshal(pages)
+		pagesJSON = string(data)
+	}
+	publication := models.TenantPublication{
+		TenantSchema: tenantID,
+		Content:      content,
+		Pages:        pagesJSON,
+		ContentHash:  publicationHash(content, pagesJSON),
+		Source:       source,
+		Current:      true,
+		PublishedAt:  time.Now().UTC(),
+		PublishedBy:  userID,
+	}
+
+	unchanged, err := savePublication(ctx, h.deps.DB, h.deps.JWT, &publication)
+	if err != nil {
+		h.logger.Error("Failed to save publication", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to publish"})
+		return
+	}
+
+	if unchanged {
+		c.JSON(http.StatusOK, gin.H{"publication": toResponse(&publication), "unchanged": true})
+		return
+	}
+
+	h.deps.Sites.InvalidatePublication(ctx, tenantID)
+	h.logger.Info("Site published", "tenant", tenantID, "version", publication.Version, "source", source)
+	h.deps.Webhooks.Emit(ctx, tenantID, webhooks.EventPublicationCreated, toResponse(&publication))
+
+	c.JSON(http.StatusCreated, gin.H{"publication": toResponse(&publication)})
+}
+
+// publicationHash is the content hash of a publication. Single-page
+// publications hash their content alone, as they always have.
+func publicationHash(content, pages string) string {
+	sum := sha256.Sum256([]byte(content))
+	if pages != "" {
+		sum = sha256.Sum256([]byte(content + "\x00" + pages))
+	}
+	return hex.EncodeToString(sum[:])
+}
+
+// savePublication stores publication as the tenant's new current version,
+// signed with signer when there is one. When its content matches the
+// current version nothing is saved but a new signature, publication is set
+// to the current version and unchanged is true.
+func savePublication(ctx context.Context, database *db.DB, signer *auth.JWTManager, publication *models.TenantPublication) (unchanged bool, err error) {
+	tenantID := publication.TenantSchema
+	err = database.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			var current models.TenantPublication
+			err := tx.Where("tenant_schema = ? AND current = ?", tenantID, true).First(&current).Error
+			if err == nil && current.ContentHash == publication.ContentHash {
+				*publication = current
+				unchanged = true
+				return saveSignature(tx, signer, publication)
+			}
+
+			var latest int
+			if err := tx.Model(&models.TenantPublication{}).
+				Where("tenant_schema = ?", tenantID).
+				Select("COALESCE(MAX(version), 0)").
+				Scan(&latest).Error; err != nil {
+				return err
+			}
+			publication.Version = latest + 1
+			if err := signPublication(signer, publication); err != nil {
+				return err
+			}
+
+			if err := tx.Model(&models.TenantPublication{}).
+				Where("tenant_schema = ? AND current = ?", tenantID, true).
+				Update("current", false).Error; err != nil {
+				return err
+			}
+			return tx.Create(publication).Error
+		})
+	})
+	return unchanged, err
+}
+
+// loadChatSite returns the latest assistant message of a chat, and for a
+// multi-page generation its other pages by path. Assistant messages are
+// stored after the processor pipeline has run, so they are published as-is.
+func (h *Handler) loadChatSite(ctx context.Context, tenantID, chatID string) (string, map[string]string, error) {
+	msg, err := h.loadChatMessage(ctx, tenantID, chatID, "")
+	if err != nil {
+		return "", nil, err
+	}
+	if msg.Site == nil {
+		return msg.Content, nil, nil
+	}
+
+	pages, err := h.loadSitePages(ctx, tenantID, msg.Site)
+	if err != nil {
+		return "", nil, err
+	}
+	return msg.Content, pages, nil
+}
+
+// loadSitePages reads the pages of a multi-page generation from the tenant
+// filesystem, keyed by path, leaving out the one served at /. Pages are
+// only kept for the latest generation, so a page whose hash no longer
+// matches the manifest fails with errSitePagesChanged.
+func (h *Handler) loadSitePages(ctx context.Context, tenantID string, manifest *model.SiteManifest) (map[string]string, error) {
+	pages := map[string]string{}
+	for _, page := range manifest.Done() {
+		if page.Path == "/" {
+			continue
+		}
+		content, err := h.readFilesystemHTML(ctx, tenantID, page.Key)
+		if errors.Is(err, errSourceNotFound) || errors.Is(err, errSourceNoHTML) {
+			return nil, errSitePagesChanged
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sum := sha256.Sum256([]byte(content)); hex.EncodeToString(sum[:]) != page.Hash {
+			return nil, errSitePagesChanged
+		}
+		pages[page.Path] = content
+	}
+	return pages, nil
+}
+
+// loadChatMessage returns the assistant message with the given ID, or the
+// latest assistant message when messageID is empty
+func (h *Handler) loadChatMessage(ctx context.Context, tenantID, chatID, messageID string) (*model.ChatMessage, error) {
+	chat, err := h.deps.Chats.GetChat(services.WithTenantSchema(ctx, tenantID), chatID)
+	if err != nil || chat == nil {
+		return nil, errSourceNotFound
+	}
+	// Only the tenant's own chats can be published or shared
+	if h.deps.Config.ChatOwnershipChecks && !chat.OwnedBy(tenantID, 0) {
+		return nil, errSourceNotFound
+	}
+
+	for i := len(chat.Messages) - 1; i >= 0; i-- {
+		msg := chat.Messages[i]
+		if messageID != "" && msg.ID != messageID {
+			continue
+		}
+		if msg.Role == model.ChatMessageRoleAssistant && strings.TrimSpace(msg.Content) != "" {
+			return &msg, nil
+		}
+		if messageID != "" {
+			return nil, errSourceNoHTML
+		}
+	}
+	if messageID != "" {
+		return nil, errSourceNotFound
+	}
+	return nil, errSourceNoHTML
+}
+
+// loadFilesystemHTML reads HTML saved in the tenant filesystem and runs the
+// processor pipeline over it
+func (h *Handler) loadFilesystemHTML(ctx context.Context, tenantID, key string) (string, error) {
+	content, err := h.readFilesystemHTML(ctx, tenantID, key)
+	if err != nil {
+		return "", err
+	}
+
+	content, _ = h.deps.ProcessorsSvc.Run(services.WithTenantSchema(ctx, tenantID), content, nil)
+
+	return content, nil
+}
+
+// readFilesystemHTML reads HTML saved in the tenant filesystem, stored either
+// as a JSON string or as an object with an html field
+func (h *Handler) readFilesystemHTML(ctx context.Context, tenantID, key string) (string, error) {
+	var entry models.TenantFilesystem
+	err := h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND key = ?", tenantID, key).First(&entry).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", errSourceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load filesystem entry: %w", err)
+	}
+
+	var content string
+	if err := json.Unmarshal([]byte(entry.Data), &content); err != nil {
+		var doc struct {
+			HTML string `json:"html"`
+		}
+		if err := json.Unmarshal([]byte(entry.Data), &doc); err != nil {
+			return "", errSourceNoHTML
+		}
+		content = doc.HTML
+	}
+	if strings.TrimSpace(content) == "" {
+		return "", errSourceNoHTML
+	}
+
+	return content, nil
+}
+
+// ListPublications returns the tenant's publication history, newest first
+func (h *Handler) ListPublications(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var publications []models.TenantPublication
+	err := h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantID).
+			Select("id, version, content_hash, source, current, published_at, published_by").
+			Order("version DESC").
+			Find(&publications).Error
+	})
+	if err != nil {
+		h.logger.Error("Failed to list publications", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list publications"})
+		return
+	}
+
+	responses := make([]PublicationResponse, len(publications))
+	for i := range publications {
+		responses[i] = toResponse(&publications[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publications": responses})
+}
+
+// Rollback makes an earlier publication version live again
+func (h *Handler) Rollback(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var publication models.TenantPublication
+	err = h.deps.DB.WithTenant(ctx, tenantID, func(tx *gorm.DB) error {
+		return tx.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("tenant_schema = ? AND version = ?", tenantID, version).First(&publication).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(&models.TenantPublication{}).
+				Where("tenant_schema = ? AND current = ?", tenantID, true).
+				Update("current", false).Error; err != nil {
+				return err
+			}
+
+			publication.Current = true
+			if err := tx.Model(&publication).Update("current", true).Error; err != nil {
+				return err
+			}
+			// The version is live again under the current key
+			return saveSignature(tx, h.deps.JWT, &publication)
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "publication not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to roll back publication", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to roll back"})
+		return
+	}
+
+	h.deps.Sites.InvalidatePublication(ctx, tenantID)
+	h.logger.Info("Publication rolled back", "tenant", tenantID, "version", version)
+
+	c.JSON(http.StatusOK, gin.H{"publication": toResponse(&publication)})
+}
+
+// RegisterRoutes registers publishing routes
+func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager) {
+	handler := NewHandler(deps)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	publishRoutes := r.Group("/api/v1")
+	publishRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	publishRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		publishRoutes.POST("/publish", handler.Publish)
+		publishRoutes.GET("/publications", handler.ListPublications)
+		publishRoutes.POST("/publications/:version/rollback", handler.Rollback)
+		publishRoutes.GET("/publications/:version/signature", handler.GetSignature)
+		publishRoutes.POST("/chat/:id/share", handler.CreateChatShare)
+		publishRoutes.POST("/shares", handler.CreateShare)
+		publishRoutes.GET("/shares", handler.ListShares)
+		publishRoutes.DELETE("/shares/:id", handler.DeleteShare)
+	}
+
+	adminRoutes := r.Group("/api/v1/admin/reprocess")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		adminRoutes.POST("", handler.StartReprocess)
+		adminRoutes.GET("/:jobId", handler.GetReprocess)
+	}
+
+	verifyRoutes := r.Group("/api/v1/admin/publications")
+	verifyRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		verifyRoutes.GET("/verify", handler.VerifyPublications)
+	}
+}
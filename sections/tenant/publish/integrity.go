@@ -0,0 +1,336 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Outcomes of verifying a publication
+const (
+	VerifyOK               = "ok"
+	VerifyUnsigned         = "unsigned"
+	VerifyHashMismatch     = "hash_mismatch"
+	VerifySignatureInvalid = "signature_invalid"
+	VerifyUnknownKey       = "unknown_key"
+)
+
+// Statement is the payload a publication's detached JWS signs: the SHA-256
+// of each of its pages, so the signature covers the HTML as it is served
+type Statement struct {
+	Tenant  string            `json:"tenant"`
+	Version int               `json:"version"`
+	Digests map[string]string `json:"digests"` // path to hex SHA-256
+}
+
+// CanonicalHTML is the form HTML is published, hashed and signed in: without
+// a byte order mark and with LF line endings, serialized by
+// utils.RenderCanonical so that equivalent pages hash the same
+func CanonicalHTML(content string) string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return utils.CanonicalHTML(strings.ReplaceAll(content, "\r", "\n"))
+}
+
+// pageDigest is the hex SHA-256 of a page
+func pageDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReprDigest is the Repr-Digest header value (RFC 9530) of a page
+func ReprDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+// statement builds the signed statement from the publication's stored pages
+func statement(publication *models.TenantPublication) (Statement, []byte, error) {
+	stmt := Statement{
+		Tenant:  publication.TenantSchema,
+		Version: publication.Version,
+		Digests: map[string]string{"/": pageDigest(publication.Content)},
+	}
+	if publication.Pages != "" {
+		var pages map[string]string
+		if err := json.Unmarshal([]byte(publication.Pages), &pages); err != nil {
+			return stmt, nil, fmt.Errorf("failed to decode publication pages: %w", err)
+		}
+		for path, content := range pages {
+			stmt.Digests[path] = pageDigest(content)
+		}
+	}
+	// Map keys are marshalled sorted, so the payload is deterministic
+	payload, err := json.Marshal(stmt)
+	return stmt, payload, err
+}
+
+// signPublication signs the publication's statement with the server key,
+// setting its signature fields. It does nothing without a key.
+func signPublication(signer *auth.JWTManager, publication *models.TenantPublication) error {
+	if signer == nil {
+		return nil
+	}
+	_, payload, err := statement(publication)
+	if err != nil {
+		return err
+	}
+	signature, err := signer.SignDetached(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	publication.Signature = signature
+	publication.SignatureKeyID = signer.KeyID()
+	publication.SignedAt = &now
+	return nil
+}
+
+// saveSignature signs a stored publication again and saves the signature
+func saveSignature(tx *gorm.DB, signer *auth.JWTManager, publication *models.TenantPublication) error {
+	if signer == nil {
+		return nil
+	}
+	if err := signPublication(signer, publication); err != nil {
+		return err
+	}
+	return tx.Model(publication).Updates(map[string]any{
+		"signature":        publication.Signature,
+		"signature_key_id": publication.SignatureKeyID,
+		"signed_at":        publication.SignedAt,
+	}).Error
+}
+
+// VerifyResult is the outcome of verifying one publication
+type VerifyResult struct {
+	TenantSchema string `json:"tenantSchema"`
+	Version      int    `json:"version"`
+	Current      bool   `json:"current"`
+	Status       string `json:"status"`
+	KeyID        string `json:"keyId,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// verifyPublication re-hashes the publication's stored content and checks
+// its signature against it
+func verifyPublication(verifier *auth.JWTManager, publication *models.TenantPublication) VerifyResult {
+	result := VerifyResult{
+		TenantSchema: publication.TenantSchema,
+		Version:      publication.Version,
+		Current:      publication.Current,
+		KeyID:        publication.SignatureKeyID,
+	}
+
+	if publicationHash(publication.Content, publication.Pages) != publication.ContentHash {
+		result.Status = VerifyHashMismatch
+		result.Error = "stored content does not match its content hash"
+		return result
+	}
+	if publication.Signature == "" {
+		result.Status = VerifyUnsigned
+		return result
+	}
+	_, payload, err := statement(publication)
+	if err != nil {
+		result.Status = VerifyHashMismatch
+		result.Error = err.Error()
+		return result
+	}
+	if verifier == nil {
+		result.Status = VerifyUnknownKey
+		result.Error = "no signing key configured"
+		return result
+	}
+
+	kid, err := verifier.VerifyDetached(publication.Signature, payload)
+	if kid != "" {
+		result.KeyID = kid
+	}
+	switch {
+	case errors.Is(err, auth.ErrUnknownKey):
+		result.Status = VerifyUnknownKey
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = VerifySignatureInvalid
+		result.Error = "signature does not match stored content"
+	default:
+		result.Status = VerifyOK
+	}
+	return result
+}
+
+// Verifier checks stored publications against their hashes and signatures
+type Verifier struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+}
+
+// NewVerifier creates a publication verifier
+func NewVerifier(deps *sections.Dependencies) *Verifier {
+	return &Verifier{
+		logger: slog.With("component", "PublicationVerifier"),
+		deps:   deps,
+	}
+}
+
+// VerifyTenant verifies every publication version of a tenant, calling
+// report for each
+func (v *Verifier) VerifyTenant(ctx context.Context, tenantSchema string, report func(VerifyResult) error) error {
+	var publications []models.TenantPublication
+	err := v.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).Order("version").Find(&publications).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list publications: %w", err)
+	}
+	for i := range publications {
+		result := verifyPublication(v.deps.JWT, &publications[i])
+		if result.Status != VerifyOK && result.Status != VerifyUnsigned {
+			v.logger.Warn("Publication failed verification", "tenant", tenantSchema, "version", result.Version, "status", result.Status)
+		}
+		if err := report(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SignatureResponse is a publication's signature and the statement it signs
+type SignatureResponse struct {
+	Version     int        `json:"version"`
+	ContentHash string     `json:"contentHash"`
+	Algorithm   string     `json:"algorithm"`
+	KeyID       string     `json:"keyId"`
+	Signature   string     `json:"signature"` // detached JWS, header..signature
+	Payload     string     `json:"payload"`   // base64url of the signed statement
+	Statement   Statement  `json:"statement"`
+	SignedAt    *time.Time `json:"signedAt"`
+	JWKSURL     string     `json:"jwksUrl"`
+}
+
+// GetSignature returns the signature of a publication version. The payload
+// is rebuilt from the stored content, so it only verifies against the JWKS
+// when that content is untouched.
+func (h *Handler) GetSignature(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid version"})
+		return
+	}
+
+	var publication models.TenantPublication
+	err = h.deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND version = ?", tenantID, version).First(&publication).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "publication not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load publication", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load publication"})
+		return
+	}
+	if publication.Signature == "" {
+		i18n.Error(c, http.StatusNotFound, "publication_unsigned", "publication is not signed")
+		return
+	}
+
+	stmt, payload, err := statement(&publication)
+	if err != nil {
+		h.logger.Error("Failed to build publication statement", "version", version, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load publication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SignatureResponse{
+		Version:     publication.Version,
+		ContentHash: publication.ContentHash,
+		Algorithm:   "ES512",
+		KeyID:       publication.SignatureKeyID,
+		Signature:   publication.Signature,
+		Payload:     base64.RawURLEncoding.EncodeToString(payload),
+		Statement:   stmt,
+		SignedAt:    publication.SignedAt,
+		JWKSURL:     auth.JWKSPath,
+	})
+}
+
+// VerifyPublications re-hashes the stored publications of the tenants in
+// the tenants query parameter, "all" or schemas separated by commas, and
+// reports each one's outcome
+func (h *Handler) VerifyPublications(c *gin.Context) {
+	tenantsParam := c.Query("tenants")
+	if tenantsParam == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenants is required"})
+		return
+	}
+	var raw []byte
+	if tenantsParam == "all" {
+		raw, _ = json.Marshal("all")
+	} else {
+		raw, _ = json.Marshal(strings.Split(tenantsParam, ","))
+	}
+
+	ctx := c.Request.Context()
+	tenants, err := h.reprocessor.ResolveTenants(ctx, raw)
+	if errors.Is(err, errUnknownTenant) {
+		i18n.Error(c, http.StatusBadRequest, "unknown_tenant", err.Error())
+		return
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "failed") {
+		h.logger.Error("Failed to resolve verification tenants", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve tenants"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := []VerifyResult{}
+	counts := map[string]int{}
+	for _, tenantSchema := range tenants {
+		err := h.verifier.VerifyTenant(ctx, tenantSchema, func(result VerifyResult) error {
+			counts[result.Status]++
+			results = append(results, result)
+			return nil
+		})
+		if err != nil {
+			h.logger.Error("Failed to verify tenant publications", "tenant", tenantSchema, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify publications"})
+			return
+		}
+	}
+
+	mismatches := counts[VerifyHashMismatch] + counts[VerifySignatureInvalid] + counts[VerifyUnknownKey]
+	c.JSON(http.StatusOK, gin.H{
+		"results":    results,
+		"counts":     counts,
+		"mismatches": mismatches,
+	})
+}
@@ -0,0 +1,134 @@
+package publish
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/auth/authtest"
+	"awning-backend/sections/models"
+)
+
+// newPublication returns a publication of a home and about page, hashed as
+// savePublication does
+func newPublication() *models.TenantPublication {
+	content := CanonicalHTML("<html><head></head><body><h1>Home</h1></body></html>")
+	pages := `{"/about":"<html><head></head><body><h1>About</h1></body></html>"}`
+	return &models.TenantPublication{
+		TenantSchema: "tenant_7",
+		Version:      3,
+		Content:      content,
+		Pages:        pages,
+		ContentHash:  publicationHash(content, pages),
+	}
+}
+
+func TestCanonicalHTML(t *testing.T) {
+	want := CanonicalHTML("<html><head></head><body><p>a\nb</p></body></html>")
+	for _, input := range []string{
+		"\ufeff<html><head></head><body><p>a\nb</p></body></html>",
+		"<html><head></head><body><p>a\r\nb</p></body></html>",
+		"<html><head></head><body><p>a\rb</p></body></html>",
+	} {
+		if got := CanonicalHTML(input); got != want {
+			t.Errorf("CanonicalHTML(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if CanonicalHTML(want) != want {
+		t.Error("CanonicalHTML() is not idempotent")
+	}
+}
+
+func TestVerifyPublication(t *testing.T) {
+	signer := authtest.NewJWTManager(t)
+
+	publication := newPublication()
+	if result := verifyPublication(signer, publication); result.Status != VerifyUnsigned {
+		t.Errorf("verifyPublication() of an unsigned publication = %+v", result)
+	}
+	if err := signPublication(signer, publication); err != nil {
+		t.Fatalf("signPublication() error = %v", err)
+	}
+	if publication.SignatureKeyID != signer.KeyID() || publication.SignedAt == nil {
+		t.Errorf("signed publication key = %q at %v", publication.SignatureKeyID, publication.SignedAt)
+	}
+	if result := verifyPublication(signer, publication); result.Status != VerifyOK || result.KeyID != signer.KeyID() {
+		t.Errorf("verifyPublication() of a signed publication = %+v, want ok", result)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(p *models.TenantPublication)
+		want   string
+	}{
+		{"content edited", func(p *models.TenantPublication) {
+			p.Content += "<script>steal()</script>"
+		}, VerifyHashMismatch},
+		{"page edited", func(p *models.TenantPublication) {
+			p.Pages = `{"/about":"<h1>Hacked</h1>"}`
+		}, VerifyHashMismatch},
+		{"content edited with its hash", func(p *models.TenantPublication) {
+			p.Content += "<script>steal()</script>"
+			p.ContentHash = publicationHash(p.Content, p.Pages)
+		}, VerifySignatureInvalid},
+		{"signature of another version", func(p *models.TenantPublication) {
+			p.Version = 4
+		}, VerifySignatureInvalid},
+		{"signed by an unknown key", func(p *models.TenantPublication) {
+			other := newPublication()
+			signPublication(authtest.NewJWTManager(t), other)
+			p.Signature = other.Signature
+		}, VerifyUnknownKey},
+	}
+	for _, tt := range tests {
+		mutated := *publication
+		tt.mutate(&mutated)
+		if result := verifyPublication(signer, &mutated); result.Status != tt.want {
+			t.Errorf("%s: verifyPublication() = %+v, want %s", tt.name, result, tt.want)
+		}
+	}
+
+	if result := verifyPublication(nil, publication); result.Status != VerifyUnknownKey {
+		t.Errorf("verifyPublication() without a key = %+v, want %s", result, VerifyUnknownKey)
+	}
+}
+
+func TestVerifyPublicationAfterKeyRotation(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	old, err := auth.NewJWTManager(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), "awning-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publication := newPublication()
+	if err := signPublication(old, publication); err != nil {
+		t.Fatal(err)
+	}
+
+	current := authtest.NewJWTManager(t)
+	if result := verifyPublication(current, publication); result.Status != VerifyUnknownKey {
+		t.Errorf("verifyPublication() before the old key is added = %+v", result)
+	}
+	publicDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err := current.AddVerificationKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))); err != nil {
+		t.Fatal(err)
+	}
+	if result := verifyPublication(current, publication); result.Status != VerifyOK || result.KeyID != old.KeyID() {
+		t.Errorf("verifyPublication() with the rotated-out key = %+v, want ok with kid %s", result, old.KeyID())
+	}
+
+	// Signing again uses the current key
+	if err := signPublication(current, publication); err != nil {
+		t.Fatal(err)
+	}
+	if publication.SignatureKeyID != current.KeyID() {
+		t.Errorf("re-signed with %s, want the current key %s", publication.SignatureKeyID, current.KeyID())
+	}
+}
@@ -0,0 +1,120 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"awning-backend/processors"
+	"awning-backend/sections"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// JobKindFillPendingImages searches for the images left pending while
+	// the Unsplash quota was exhausted
+	JobKindFillPendingImages = "publish.fill_pending_images"
+
+	// PendingImagesInterval is how often the fill job runs, matching
+	// Unsplash's hourly rate limit
+	PendingImagesInterval = time.Hour
+)
+
+// PendingImageFiller re-runs the image processor over the saved sites of
+// tenants with pending images once the Unsplash quota has recovered
+type PendingImageFiller struct {
+	logger      *slog.Logger
+	deps        *sections.Dependencies
+	reprocessor *Reprocessor
+}
+
+// NewPendingImageFiller creates a filler saving through a reprocessor
+func NewPendingImageFiller(deps *sections.Dependencies) *PendingImageFiller {
+	return &PendingImageFiller{
+		logger:      slog.With("service", "PendingImageFiller"),
+		deps:        deps,
+		reprocessor: NewReprocessor(deps),
+	}
+}
+
+// HandleFill is the jobs.Handler for JobKindFillPendingImages. It does
+// nothing while the quota is still exhausted, and stops when it runs out
+// again; the next run picks up the remaining tenants.
+func (f *PendingImageFiller) HandleFill(ctx context.Context, _ json.RawMessage) error {
+	if f.deps.UnsplashSvc == nil || f.deps.DB == nil {
+		return nil
+	}
+	if f.deps.UnsplashSvc.QuotaExhausted() {
+		f.logger.Info("Unsplash quota still exhausted, pending images left for the next run")
+		return nil
+	}
+
+	var schemas []string
+	err := f.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).
+		Where("active = ?", true).
+		Order("schema_name").
+		Pluck("schema_name", &schemas).Error
+	if err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+
+	ctx = services.WithPendingImagesOnly(ctx)
+	filled, failed := 0, 0
+	for _, schema := range schemas {
+		if f.deps.UnsplashSvc.QuotaExhausted() {
+			f.logger.Info("Unsplash quota exhausted again, stopping")
+			break
+		}
+		pending, err := f.hasPendingImages(ctx, schema)
+		if err != nil {
+			f.logger.Error("Failed to look for pending images", "tenant", schema, "error", err)
+			continue
+		}
+		if !pending {
+			continue
+		}
+
+		err = f.reprocessor.ReprocessTenant(ctx, schema, []string{"image"}, false, func(result ReprocessResult) error {
+			switch result.Outcome {
+			case ReprocessChanged:
+				filled++
+			case ReprocessFailed:
+				failed++
+			}
+			return nil
+		})
+		if err != nil {
+			f.logger.Error("Failed to fill pending images", "tenant", schema, "error", err)
+		}
+	}
+
+	f.logger.Info("Pending images filled", "documents_changed", filled, "documents_failed", failed)
+	return nil
+}
+
+// hasPendingImages reports whether the tenant's current publication or a
+// filesystem entry has images marked pending
+func (f *PendingImageFiller) hasPendingImages(ctx context.Context, tenantSchema string) (bool, error) {
+	pattern := "%" + processors.IMAGE_PENDING_ATTR + "%"
+	var publications, entries int64
+	err := f.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		err := tx.Model(&models.TenantPublication{}).
+			Where("tenant_schema = ? AND current = ? AND content LIKE ?", tenantSchema, true, pattern).
+			Count(&publications).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.TenantFilesystem{}).
+			Where("tenant_schema = ? AND data::text LIKE ?", tenantSchema, pattern).
+			Count(&entries).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return publications+entries > 0, nil
+}
@@ -0,0 +1,479 @@
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/jobs"
+	"awning-backend/processors"
+	"awning-backend/sections"
+	"awning-backend/sections/common/audit"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/filesystem"
+	"awning-backend/services"
+	"awning-backend/storage"
+	"awning-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// JobKindReprocess re-runs processors over one tenant's saved sites
+	JobKindReprocess = "publish.reprocess"
+
+	// MaxReprocessDiffLines bounds each dry-run diff
+	MaxReprocessDiffLines = 200
+)
+
+// Outcomes of reprocessing one document
+const (
+	ReprocessChanged   = "changed"
+	ReprocessUnchanged = "unchanged"
+	ReprocessFailed    = "failed"
+)
+
+// ReprocessRequest starts a batch. Tenants is a list of tenant schemas or
+// the string "all" for every active tenant.
+type ReprocessRequest struct {
+	Tenants    json.RawMessage `json:"tenants" binding:"required"`
+	Processors []string        `json:"processors" binding:"required,min=1"`
+	DryRun     bool            `json:"dryRun"`
+}
+
+// ReprocessResult is the outcome of reprocessing one saved document: the
+// tenant's current publication or a filesystem entry holding HTML
+type ReprocessResult struct {
+	TenantSchema    string   `json:"tenantSchema"`
+	Source          string   `json:"source"` // "publication" or filesystem:<key>
+	Outcome         string   `json:"outcome"`
+	PreviousVersion int      `json:"previousVersion,omitempty"`
+	Version         int      `json:"version,omitempty"` // Publication written for a changed publication
+	Diff            []string `json:"diff,omitempty"`    // Dry runs only
+	Error           string   `json:"error,omitempty"`
+}
+
+type reprocessPayload struct {
+	BatchID      string   `json:"batchId"`
+	TenantSchema string   `json:"tenantSchema"`
+	Processors   []string `json:"processors"`
+	DryRun       bool     `json:"dryRun"`
+}
+
+var (
+	errUnknownProcessor = errors.New("unknown processor")
+	errUnknownTenant    = errors.New("unknown tenant")
+)
+
+// Reprocessor re-runs processors over saved sites after a processor fix,
+// either reporting the changes or saving them
+type Reprocessor struct {
+	logger   *slog.Logger
+	deps     *sections.Dependencies
+	throttle *services.Throttle
+}
+
+// NewReprocessor creates a reprocessor. Its Unsplash searches are spaced
+// reprocess_throttle_ms apart.
+func NewReprocessor(deps *sections.Dependencies) *Reprocessor {
+	r := &Reprocessor{
+		logger: slog.With("service", "Reprocessor"),
+		deps:   deps,
+	}
+	if ms := deps.Config.ReprocessThrottleMs; ms > 0 {
+		r.throttle = services.NewThrottle(time.Duration(ms) * time.Millisecond)
+	}
+	return r
+}
+
+// ValidateProcessors checks that every name is a registered processor
+func (r *Reprocessor) ValidateProcessors(names []string) error {
+	for _, name := range names {
+		if _, ok := r.deps.ProcessorsSvc.GetProcessor(name); !ok {
+			return fmt.Errorf("%w: %s", errUnknownProcessor, name)
+		}
+	}
+	return nil
+}
+
+// ResolveTenants returns the tenant schemas selected by a "tenants" value:
+// "all" for every active tenant, or a list of existing tenant schemas
+func (r *Reprocessor) ResolveTenants(ctx context.Context, raw json.RawMessage) ([]string, error) {
+	var all string
+	if err := json.Unmarshal(raw, &all); err == nil {
+		if all != "all" {
+			return nil, errors.New(`tenants must be a list of tenant schemas or "all"`)
+		}
+		var schemas []string
+		err := r.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).
+			Where("active = ?", true).
+			Order("schema_name").
+			Pluck("schema_name", &schemas).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tenants: %w", err)
+		}
+		return schemas, nil
+	}
+
+	var requested []string
+	if err := json.Unmarshal(raw, &requested); err != nil || len(requested) == 0 {
+		return nil, errors.New(`tenants must be a list of tenant schemas or "all"`)
+	}
+	slices.Sort(requested)
+	requested = slices.Compact(requested)
+
+	var schemas []string
+	err := r.deps.DB.DB.WithContext(ctx).Model(&models.Tenant{}).
+		Where("schema_name IN ?", requested).
+		Pluck("schema_name", &schemas).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up tenants: %w", err)
+	}
+	for _, schema := range requested {
+		if !slices.Contains(schemas, schema) {
+			return nil, fmt.Errorf("%w: %s", errUnknownTenant, schema)
+		}
+	}
+	return requested, nil
+}
+
+// ReprocessTenant runs the processors over the tenant's current publication
+// and the filesystem entries holding HTML, calling report for each. Changed
+// documents are saved unless dryRun: a publication as a new version, a
+// filesystem entry in place, each with an audit event. Documents that fail
+// are reported as failed; the returned error is for failures to list them.
+func (r *Reprocessor) ReprocessTenant(ctx context.Context, tenantSchema string, processors []string, dryRun bool, report func(ReprocessResult) error) error {
+	ctx = services.WithTenantSchema(ctx, tenantSchema)
+	if r.throttle != nil {
+		ctx = services.WithUnsplashThrottle(ctx, r.throttle)
+	}
+
+	// The contact processor fills in the profile's current details, and
+	// the header processor the current brand
+	ctx, err := r.withContactDetails(ctx, tenantSchema)
+	if err != nil {
+		return err
+	}
+	ctx = r.withBrandAssets(ctx, tenantSchema)
+
+	var current models.TenantPublication
+	err = r.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ? AND current = ?", tenantSchema, true).First(&current).Error
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return fmt.Errorf("failed to load publication: %w", err)
+	default:
+		if err := report(r.reprocessPublication(ctx, &current, processors, dryRun)); err != nil {
+			return err
+		}
+	}
+
+	var entries []models.TenantFilesystem
+	err = r.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).
+			Where("jsonb_typeof(data) = ? OR jsonb_typeof(data->'html') = ?", "string", "string").
+			Order("key").
+			Find(&entries).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list filesystem entries: %w", err)
+	}
+	for i := range entries {
+		result, ok := r.reprocessEntry(ctx, &entries[i], processors, dryRun)
+		if !ok {
+			continue
+		}
+		if err := report(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withContactDetails returns ctx carrying the contact details of the
+// tenant's profile, if it has one
+func (r *Reprocessor) withContactDetails(ctx context.Context, tenantSchema string) (context.Context, error) {
+	var profile models.TenantProfile
+	err := r.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+		return tx.Where("tenant_schema = ?", tenantSchema).First(&profile).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ctx, nil
+	}
+	if err != nil {
+		return ctx, fmt.Errorf("failed to load profile: %w", err)
+	}
+	return processors.WithContactDetails(ctx, processors.ContactDetails{
+		Phone:   profile.Phone,
+		Email:   profile.Email,
+		Address: profile.Address,
+		Hours:   profile.Hours(),
+		Locale:  profile.Locale,
+	}), nil
+}
+
+// withBrandAssets returns ctx carrying the tenant's brand settings
+func (r *Reprocessor) withBrandAssets(ctx context.Context, tenantSchema string) context.Context {
+	return processors.WithBrandAssets(ctx, r.deps.BrandAssets(ctx, tenantSchema))
+}
+
+func (r *Reprocessor) reprocessPublication(ctx context.Context, current *models.TenantPublication, processors []string, dryRun bool) ReprocessResult {
+	tenantSchema := current.TenantSchema
+	result := ReprocessResult{TenantSchema: tenantSchema, Source: "publication", PreviousVersion: current.Version}
+
+	content, _ := r.deps.ProcessorsSvc.RunOnly(ctx, current.Content, processors...)
+	content = CanonicalHTML(content)
+	// Publications made before serialization was canonical differ only in
+	// form, which doesn't count as a change
+	previous := CanonicalHTML(current.Content)
+	if content == previous {
+		result.Outcome = ReprocessUnchanged
+		return result
+	}
+
+	result.Outcome = ReprocessChanged
+	if dryRun {
+		result.Diff = utils.LineDiff(previous, content, MaxReprocessDiffLines)
+		return result
+	}
+
+	// Only the page at / is reprocessed; the others are kept as they are
+	publication := models.TenantPublication{
+		TenantSchema: tenantSchema,
+		Content:      content,
+		Pages:        current.Pages,
+		ContentHash:  publicationHash(content, current.Pages),
+		Source:       current.Source,
+		Current:      true,
+		PublishedAt:  time.Now().UTC(),
+	}
+	unchanged, err := savePublication(ctx, r.deps.DB, r.deps.JWT, &publication)
+	if err != nil {
+		r.logger.Error("Failed to save reprocessed publication", "tenant", tenantSchema, "error", err)
+		result.Outcome = ReprocessFailed
+		result.Error = "failed to save publication"
+		return result
+	}
+	result.Version = publication.Version
+	if unchanged {
+		result.Outcome = ReprocessUnchanged
+		return result
+	}
+
+	if r.deps.Sites != nil {
+		r.deps.Sites.InvalidatePublication(ctx, tenantSchema)
+	}
+	audit.Record(ctx, r.deps.DB, tenantSchema, nil, audit.ActionSiteReprocessed, map[string]any{
+		"source":          "publication",
+		"processors":      processors,
+		"previousVersion": current.Version,
+		"version":         publication.Version,
+	})
+	r.logger.Info("Publication reprocessed", "tenant", tenantSchema, "previous_version", current.Version, "version", publication.Version)
+	return result
+}
+
+// reprocessEntry reprocesses a filesystem entry stored as a JSON string or
+// an object with an html field. Entries whose HTML is blank are skipped.
+func (r *Reprocessor) reprocessEntry(ctx context.Context, entry *models.TenantFilesystem, processors []string, dryRun bool) (ReprocessResult, bool) {
+	tenantSchema := entry.TenantSchema
+	result := ReprocessResult{TenantSchema: tenantSchema, Source: "filesystem:" + entry.Key}
+
+	var before string
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(entry.Data), &before); err != nil {
+		if err := json.Unmarshal([]byte(entry.Data), &doc); err != nil {
+			return result, false
+		}
+		before, _ = doc["html"].(string)
+	}
+	if strings.TrimSpace(before) == "" {
+		return result, false
+	}
+
+	// The pipeline's output is canonical, so the entry is compared in that
+	// form and only real changes count
+	before = utils.CanonicalHTML(before)
+	after, _ := r.deps.ProcessorsSvc.RunOnly(ctx, before, processors...)
+	if after == before {
+		result.Outcome = ReprocessUnchanged
+		return result, true
+	}
+
+	result.Outcome = ReprocessChanged
+	if dryRun {
+		result.Diff = utils.LineDiff(before, after, MaxReprocessDiffLines)
+		return result, true
+	}
+
+	var data []byte
+	var err error
+	if doc != nil {
+		doc["html"] = after
+		data, err = json.Marshal(doc)
+	} else {
+		data, err = json.Marshal(after)
+	}
+	if err == nil {
+		sum := sha256.Sum256(data)
+		err = r.deps.DB.WithTenant(ctx, tenantSchema, func(tx *gorm.DB) error {
+			return tx.Model(entry).Updates(map[string]any{
+				"data":     string(data),
+				"size":     int64(len(data)),
+				"checksum": hex.EncodeToString(sum[:]),
+			}).Error
+		})
+	}
+	if err != nil {
+		r.logger.Error("Failed to save reprocessed filesystem entry", "tenant", tenantSchema, "key", entry.Key, "error", err)
+		result.Outcome = ReprocessFailed
+		result.Error = "failed to save filesystem entry"
+		return result, true
+	}
+
+	if r.deps.KV != nil {
+		if err := r.deps.KV.Delete(ctx, filesystem.CacheKey(tenantSchema, entry.Key)); err != nil {
+			r.logger.Error("Failed to invalidate filesystem cache", "tenant", tenantSchema, "key", entry.Key, "error", err)
+		}
+	}
+	audit.Record(ctx, r.deps.DB, tenantSchema, nil, audit.ActionSiteReprocessed, map[string]any{
+		"source":     result.Source,
+		"processors": processors,
+	})
+	r.logger.Info("Filesystem entry reprocessed", "tenant", tenantSchema, "key", entry.Key)
+	return result, true
+}
+
+// HandleJob is the jobs.Handler for JobKindReprocess. Documents are counted
+// once per batch and finished tenants are skipped, so a job delivered again
+// doesn't inflate the counts; saving is skipped for content that no longer
+// changes.
+func (r *Reprocessor) HandleJob(ctx context.Context, raw json.RawMessage) error {
+	var payload reprocessPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("invalid reprocess payload: %w", err)
+	}
+	if r.deps.Redis == nil {
+		return errors.New("reprocessing needs Redis")
+	}
+
+	done, err := r.deps.Redis.ReprocessTenantDone(ctx, payload.BatchID, payload.TenantSchema)
+	if err != nil {
+		return err
+	}
+	if done {
+		return nil
+	}
+
+	err = r.ReprocessTenant(ctx, payload.TenantSchema, payload.Processors, payload.DryRun, func(result ReprocessResult) error {
+		var diff []byte
+		if payload.DryRun && result.Outcome == ReprocessChanged {
+			diff, _ = json.Marshal(result)
+		}
+		_, err := r.deps.Redis.RecordReprocessItem(ctx, payload.BatchID, result.TenantSchema+"/"+result.Source, result.Outcome, diff)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return r.deps.Redis.FinishReprocessTenant(ctx, payload.BatchID, payload.TenantSchema)
+}
+
+// StartReprocess handles POST /api/v1/admin/reprocess, enqueueing one job
+// per tenant
+func (h *Handler) StartReprocess(c *gin.Context) {
+	var req ReprocessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if h.deps.Redis == nil || h.deps.Jobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "background jobs unavailable"})
+		return
+	}
+
+	if err := h.reprocessor.ValidateProcessors(req.Processors); err != nil {
+		i18n.Error(c, http.StatusBadRequest, "unknown_processor", err.Error())
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenants, err := h.reprocessor.ResolveTenants(ctx, req.Tenants)
+	if errors.Is(err, errUnknownTenant) {
+		i18n.Error(c, http.StatusBadRequest, "unknown_tenant", err.Error())
+		return
+	}
+	if err != nil && strings.HasPrefix(err.Error(), "failed") {
+		h.logger.Error("Failed to resolve reprocess tenants", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve tenants"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	batch := &storage.ReprocessBatch{
+		ID:         uuid.New().String(),
+		Processors: req.Processors,
+		DryRun:     req.DryRun,
+		CreatedAt:  time.Now().UTC(),
+		Tenants:    len(tenants),
+	}
+	if err := h.deps.Redis.CreateReprocessBatch(ctx, batch); err != nil {
+		h.logger.Error("Failed to create reprocess batch", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start reprocessing"})
+		return
+	}
+
+	for _, tenantSchema := range tenants {
+		job := jobs.New(JobKindReprocess, reprocessPayload{
+			BatchID:      batch.ID,
+			TenantSchema: tenantSchema,
+			Processors:   req.Processors,
+			DryRun:       req.DryRun,
+		}, -1)
+		if err := h.deps.Jobs.Enqueue(ctx, job); err != nil {
+			h.logger.Error("Failed to enqueue reprocess job", "batch_id", batch.ID, "tenant", tenantSchema, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start reprocessing", "jobId": batch.ID})
+			return
+		}
+	}
+
+	h.logger.Info("Reprocessing started", "batch_id", batch.ID, "tenants", len(tenants), "processors", req.Processors, "dry_run", req.DryRun)
+	c.JSON(http.StatusAccepted, batch)
+}
+
+// GetReprocess handles GET /api/v1/admin/reprocess/:jobId, returning the
+// batch's counts and, for dry runs, the diffs of changed documents
+func (h *Handler) GetReprocess(c *gin.Context) {
+	if h.deps.Redis == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "background jobs unavailable"})
+		return
+	}
+
+	batch, err := h.deps.Redis.GetReprocessBatch(c.Request.Context(), c.Param("jobId"))
+	if errors.Is(err, storage.ErrReprocessBatchNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load reprocess batch", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load reprocessing progress"})
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
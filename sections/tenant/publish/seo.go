@@ -0,0 +1,111 @@
+package publish
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// siteSEO decides how search engines may treat a tenant site on one host
+type siteSEO struct {
+	resolver *sites.Resolver
+	settings *settings.Store
+}
+
+// indexable reports whether the site may be indexed on the request's host.
+// Preview hosts on the site base domain never are; custom domains are unless
+// the tenant turned site_indexable off.
+func (s *siteSEO) indexable(c *gin.Context, tenantSchema string) bool {
+	if s.resolver.IsPreviewHost(c.Request.Host) {
+		return false
+	}
+	if s.settings == nil {
+		return true
+	}
+	return s.settings.GetBool(c.Request.Context(), tenantSchema, settings.SiteIndexable)
+}
+
+// siteURL returns the absolute URL of path on the request's host
+func siteURL(c *gin.Context, path string) string {
+	scheme := "https"
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if c.Request.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + sites.NormalizeHost(c.Request.Host) + path
+}
+
+// serveRobots writes robots.txt: allow-all with the sitemap and the tenant's
+// robots_disallow paths, or Disallow: / for sites that can't be indexed
+func (s *siteSEO) serveRobots(c *gin.Context, tenantSchema string) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+
+	if !s.indexable(c, tenantSchema) {
+		b.WriteString("Disallow: /\n")
+		c.Header("X-Robots-Tag", "noindex")
+	} else {
+		b.WriteString("Allow: /\n")
+		if s.settings != nil {
+			for _, path := range s.settings.GetStringList(c.Request.Context(), tenantSchema, settings.RobotsDisallow) {
+				fmt.Fprintf(&b, "Disallow: %s\n", path)
+			}
+		}
+		fmt.Fprintf(&b, "\nSitemap: %s\n", siteURL(c, "/sitemap.xml"))
+	}
+
+	c.Header("Cache-Control", SiteCacheControl)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(b.String()))
+}
+
+// serveSitemap writes sitemap.xml listing the published pages with their
+// publication time. Sites that can't be indexed get an empty sitemap.
+func (s *siteSEO) serveSitemap(c *gin.Context, tenantSchema string) {
+	urlSet := sitemapURLSet{Xmlns: sitemapNamespace, URLs: []sitemapURL{}}
+
+	if s.indexable(c, tenantSchema) {
+		entries, err := s.resolver.SitemapEntries(c.Request.Context(), tenantSchema)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "internal error")
+			return
+		}
+		for _, entry := range entries {
+			urlSet.URLs = append(urlSet.URLs, sitemapURL{
+				Loc:     siteURL(c, entry.Path),
+				LastMod: entry.LastModified.UTC().Format(time.RFC3339),
+			})
+		}
+	} else {
+		c.Header("X-Robots-Tag", "noindex")
+	}
+
+	data, err := xml.MarshalIndent(urlSet, "", "  ")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	c.Header("Cache-Control", SiteCacheControl)
+	c.Data(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), data...))
+}
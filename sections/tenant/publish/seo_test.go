@@ -0,0 +1,181 @@
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testPublishedAt = time.Date(2026, 9, 30, 8, 15, 0, 0, time.UTC)
+
+// newSiteRouter serves tenant sites from a cache seeded with two tenants:
+// t_bakery on www.bakery.example with robots_disallow set, and t_hidden on
+// hidden.example with site_indexable off. Both have preview hosts on
+// sites.example.com.
+func newSiteRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	ctx := context.Background()
+	kv := storage.NewMemoryStore()
+	set := func(key string, value any) {
+		data, ok := value.([]byte)
+		if !ok {
+			data, _ = json.Marshal(value)
+		}
+		if err := kv.SetWithTTL(ctx, key, data, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for tenant, host := range map[string]string{"t_bakery": "www.bakery.example", "t_hidden": "hidden.example"} {
+		set("site:host:"+host, []byte(tenant))
+		set("site:host:"+tenant+".sites.example.com", []byte(tenant))
+		set("site:pub:"+tenant, sites.Publication{
+			Version:     3,
+			ContentHash: "abc",
+			PublishedAt: testPublishedAt,
+			Content:     "<html><body>Home</body></html>",
+			Pages:       map[string]string{"/menu": "<html><body>Menu</body></html>", "/about": "<html></html>"},
+		})
+	}
+	set("settings:t_bakery", map[string]any{settings.RobotsDisallow: []string{"/drafts", "/private/"}})
+	set("settings:t_hidden", map[string]any{settings.SiteIndexable: false})
+
+	cfg := common.DefaultConfig()
+	cfg.BaseURL = "https://api.example.com"
+	cfg.SiteBaseDomain = "sites.example.com"
+	resolver := sites.NewResolver(cfg, nil, kv)
+
+	r := gin.New()
+	r.Use(auth.TenantFromHostMiddleware(resolver))
+	r.Use(SiteMiddleware(resolver, settings.NewStore(nil, kv)))
+	return r
+}
+
+func serveSite(r *gin.Engine, host, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Host = host
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRobotsTxt(t *testing.T) {
+	r := newSiteRouter(t)
+
+	tests := []struct {
+		name    string
+		host    string
+		want    string
+		noindex bool
+	}{
+		{"custom domain", "www.bakery.example", "User-agent: *\nAllow: /\nDisallow: /drafts\nDisallow: /private/\n\nSitemap: https://www.bakery.example/sitemap.xml\n", false},
+		{"preview host", "t_bakery.sites.example.com", "User-agent: *\nDisallow: /\n", true},
+		{"not indexable", "hidden.example", "User-agent: *\nDisallow: /\n", true},
+	}
+	for _, tt := range tests {
+		w := serveSite(r, tt.host, "/robots.txt")
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200: %s", tt.name, w.Code, w.Body)
+			continue
+		}
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: robots.txt =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+			t.Errorf("%s: Content-Type = %q", tt.name, ct)
+		}
+		if got := w.Header().Get("X-Robots-Tag") == "noindex"; got != tt.noindex {
+			t.Errorf("%s: X-Robots-Tag = %q, want noindex %v", tt.name, w.Header().Get("X-Robots-Tag"), tt.noindex)
+		}
+	}
+}
+
+func TestSitemapXML(t *testing.T) {
+	r := newSiteRouter(t)
+
+	tests := []struct {
+		name    string
+		host    string
+		want    []string
+		noindex bool
+	}{
+		{"custom domain", "www.bakery.example", []string{
+			"https://www.bakery.example/",
+			"https://www.bakery.example/about",
+			"https://www.bakery.example/menu",
+		}, false},
+		{"preview host", "t_bakery.sites.example.com", nil, true},
+		{"not indexable", "hidden.example", nil, true},
+	}
+	for _, tt := range tests {
+		w := serveSite(r, tt.host, "/sitemap.xml")
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200: %s", tt.name, w.Code, w.Body)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+			t.Errorf("%s: Content-Type = %q", tt.name, ct)
+		}
+		if !strings.HasPrefix(w.Body.String(), xml.Header) {
+			t.Errorf("%s: sitemap has no XML declaration: %s", tt.name, w.Body)
+		}
+
+		var urlSet sitemapURLSet
+		if err := xml.Unmarshal(w.Body.Bytes(), &urlSet); err != nil {
+			t.Fatalf("%s: invalid sitemap: %v", tt.name, err)
+		}
+		if urlSet.Xmlns != sitemapNamespace {
+			t.Errorf("%s: xmlns = %q", tt.name, urlSet.Xmlns)
+		}
+		var locs []string
+		for _, u := range urlSet.URLs {
+			locs = append(locs, u.Loc)
+			if u.LastMod != "2026-09-30T08:15:00Z" {
+				t.Errorf("%s: lastmod of %s = %q, want the publication time", tt.name, u.Loc, u.LastMod)
+			}
+		}
+		if strings.Join(locs, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: sitemap URLs = %q, want %q", tt.name, locs, tt.want)
+		}
+		if got := w.Header().Get("X-Robots-Tag") == "noindex"; got != tt.noindex {
+			t.Errorf("%s: X-Robots-Tag = %q, want noindex %v", tt.name, w.Header().Get("X-Robots-Tag"), tt.noindex)
+		}
+	}
+}
+
+func TestSitePagesNoindex(t *testing.T) {
+	r := newSiteRouter(t)
+
+	for host, noindex := range map[string]bool{
+		"www.bakery.example":         false,
+		"t_bakery.sites.example.com": true,
+		"hidden.example":             true,
+	} {
+		for _, path := range []string{"/", "/menu"} {
+			w := serveSite(r, host, path)
+			if w.Code != http.StatusOK {
+				t.Errorf("%s%s: status = %d, want 200", host, path, w.Code)
+				continue
+			}
+			if got := w.Header().Get("X-Robots-Tag") == "noindex"; got != noindex {
+				t.Errorf("%s%s: X-Robots-Tag = %q, want noindex %v", host, path, w.Header().Get("X-Robots-Tag"), noindex)
+			}
+		}
+	}
+}
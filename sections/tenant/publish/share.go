@@ -0,0 +1,494 @@
+package publish
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"awning-backend/middleware"
+	"awning-backend/model"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	previewCookieName = "awning_preview"
+	previewRobots     = "noindex, nofollow, noarchive"
+
+	// Shared pages are tenant HTML served from the API's origin, so they
+	// run sandboxed in an opaque origin: their scripts can't read the
+	// origin's storage or cookies or call the API as the viewer
+	previewContentPolicy = "sandbox allow-scripts allow-forms allow-popups"
+
+	// The password form is static and only posts to its own URL
+	passwordFormPolicy = "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'"
+
+	// Password attempts are counted per link and per client IP
+	unlockScopeLink = "preview-unlock:link"
+	unlockScopeIP   = "preview-unlock:ip"
+)
+
+// previewProcessors are the processors run over filesystem HTML for a
+// preview. They only rewrite the markup; the image processor is left out
+// since it calls Unsplash and rehosts images.
+var previewProcessors = []string{"header", "cleanup"}
+
+// ShareRequest creates a preview link; exactly one of chatId or
+// filesystemKey is required. messageId pins a chat message, otherwise the
+// latest assistant message at the time of sharing is used.
+type ShareRequest struct {
+	ChatID        string `json:"chatId"`
+	MessageID     string `json:"messageId"`
+	FilesystemKey string `json:"filesystemKey"`
+	ExpiresInDays int    `json:"expiresInDays" binding:"omitempty,min=1"`
+	Password      string `json:"password" binding:"max=72"`
+}
+
+// ShareResponse represents a preview link. URL is only returned when the
+// link is created, since only a hash of the token is stored.
+type ShareResponse struct {
+	ID             uint       `json:"id"`
+	URL            string     `json:"url,omitempty"`
+	ChatID         string     `json:"chatId,omitempty"`
+	MessageID      string     `json:"messageId,omitempty"`
+	FilesystemKey  string     `json:"filesystemKey,omitempty"`
+	HasPassword    bool       `json:"hasPassword"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	Expired        bool       `json:"expired"`
+	AccessCount    int64      `json:"accessCount"`
+	LastAccessedAt *time.Time `json:"lastAccessedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	CreatedBy      uint       `json:"createdBy"`
+}
+
+func toShareResponse(link *models.ShareLink) ShareResponse {
+	resp := ShareResponse{
+		ID:            link.ID,
+		ChatID:        link.ChatID,
+		MessageID:     link.MessageID,
+		FilesystemKey: link.FilesystemKey,
+		HasPassword:   link.PasswordHash != "",
+		ExpiresAt:     link.ExpiresAt.UTC(),
+		Expired:       time.Now().After(link.ExpiresAt),
+		AccessCount:   link.AccessCount,
+		CreatedAt:     link.CreatedAt.UTC(),
+		CreatedBy:     link.CreatedBy,
+	}
+	if link.LastAccessedAt != nil {
+		t := link.LastAccessedAt.UTC()
+		resp.LastAccessedAt = &t
+	}
+	return resp
+}
+
+func hashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// previewCookieValue proves the password of a link was entered; it changes
+// when the link is recreated, so it can't be reused for another link
+func previewCookieValue(link *models.ShareLink) string {
+	sum := sha256.Sum256([]byte(link.TokenHash + ":" + link.PasswordHash))
+	return hex.EncodeToString(sum[:])
+}
+
+func (h *Handler) previewURL(token string) string {
+	return strings.TrimSuffix(h.deps.Config.BaseURL, "/") + "/preview/" + token
+}
+
+// CreateShare creates a read-only preview link to a chat message or
+// filesystem entry
+func (h *Handler) CreateShare(c *gin.Context) {
+	var req ShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.createShare(c, req)
+}
+
+// CreateChatShare creates a preview link to a chat's latest assistant
+// message, or the message given in the body
+func (h *Handler) CreateChatShare(c *gin.Context) {
+	var req ShareRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.ChatID = c.Param("id")
+	req.FilesystemKey = ""
+	h.createShare(c, req)
+}
+
+func (h *Handler) createShare(c *gin.Context, req ShareRequest) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+	if (req.FilesystemKey == "") == (req.ChatID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of filesystemKey or chatId is required"})
+		return
+	}
+
+	days := h.deps.Config.ShareLinkDays
+	if req.ExpiresInDays > 0 {
+		days = req.ExpiresInDays
+	}
+	if days > h.deps.Config.ShareLinkMaxDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expiresInDays must be at most %d", h.deps.Config.ShareLinkMaxDays)})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, _ := auth.GetUserIDFromContext(c)
+
+	link := models.ShareLink{
+		TenantSchema:  tenantID,
+		FilesystemKey: req.FilesystemKey,
+		ExpiresAt:     time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour),
+		CreatedBy:     userID,
+	}
+
+	// Check the source now so broken links aren't handed out
+	var err error
+	if req.ChatID != "" {
+		var msg *model.ChatMessage
+		msg, err = h.loadChatMessage(ctx, tenantID, req.ChatID, req.MessageID)
+		if err == nil {
+			link.ChatID = req.ChatID
+			link.MessageID = msg.ID
+		}
+	} else {
+		_, err = h.readFilesystemHTML(ctx, tenantID, req.FilesystemKey)
+	}
+	if errors.Is(err, errSourceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errSourceNoHTML) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load share source", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load content"})
+		return
+	}
+
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			h.logger.Error("Failed to hash share password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
+			return
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		h.logger.Error("Failed to generate share token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	link.TokenHash = hashShareToken(token)
+
+	if err := h.deps.DB.DB.WithContext(ctx).Create(&link).Error; err != nil {
+		h.logger.Error("Failed to save share link", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create share link"})
+		return
+	}
+
+	h.logger.Info("Share link created", "tenant", tenantID, "share_id", link.ID, "expires_at", link.ExpiresAt)
+
+	resp := toShareResponse(&link)
+	resp.URL = h.previewURL(token)
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListShares returns the tenant's share links, newest first. Expired links
+// are listed until they are deleted.
+func (h *Handler) ListShares(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	var links []models.ShareLink
+	if err := h.deps.DB.DB.WithContext(c.Request.Context()).
+		Where("tenant_schema = ?", tenantID).
+		Order("created_at DESC").
+		Find(&links).Error; err != nil {
+		h.logger.Error("Failed to list share links", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list share links"})
+		return
+	}
+
+	responses := make([]ShareResponse, len(links))
+	for i := range links {
+		responses[i] = toShareResponse(&links[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": responses})
+}
+
+// DeleteShare revokes a share link
+func (h *Handler) DeleteShare(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share id"})
+		return
+	}
+
+	result := h.deps.DB.DB.WithContext(c.Request.Context()).
+		Where("id = ? AND tenant_schema = ?", id, tenantID).
+		Delete(&models.ShareLink{})
+	if result.Error != nil {
+		h.logger.Error("Failed to delete share link", "error", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete share link"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+		return
+	}
+
+	h.logger.Info("Share link revoked", "tenant", tenantID, "share_id", id)
+	c.Status(http.StatusNoContent)
+}
+
+// loadShareLink finds a live link by token. Links of deleted or deactivated
+// tenants are treated as missing.
+func (h *Handler) loadShareLink(ctx context.Context, token string) (*models.ShareLink, error) {
+	var link models.ShareLink
+	err := h.deps.DB.DB.WithContext(ctx).
+		Joins("JOIN public.tenants ON public.tenants.schema_name = public.share_links.tenant_schema AND public.tenants.active AND public.tenants.deleted_at IS NULL").
+		Where("public.share_links.token_hash = ?", hashShareToken(token)).
+		First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// loadShareHTML renders the link's target. Chat messages are stored
+// processed; filesystem HTML goes through previewProcessors only.
+func (h *Handler) loadShareHTML(ctx context.Context, link *models.ShareLink) (string, error) {
+	if link.ChatID != "" {
+		msg, err := h.loadChatMessage(ctx, link.TenantSchema, link.ChatID, link.MessageID)
+		if err != nil {
+			return "", err
+		}
+		return msg.Content, nil
+	}
+
+	content, err := h.readFilesystemHTML(ctx, link.TenantSchema, link.FilesystemKey)
+	if err != nil {
+		return "", err
+	}
+	content, _ = h.deps.ProcessorsSvc.RunOnly(services.WithTenantSchema(ctx, link.TenantSchema), content, previewProcessors...)
+	return content, nil
+}
+
+func setPreviewHeaders(c *gin.Context) {
+	c.Header("X-Robots-Tag", previewRobots)
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("X-Content-Type-Options", "nosniff")
+}
+
+// Preview serves the page behind a share link without authentication.
+// Password protected links show a password form until the password is
+// entered.
+func (h *Handler) Preview(c *gin.Context) {
+	setPreviewHeaders(c)
+
+	link, ok := h.previewLink(c)
+	if !ok {
+		return
+	}
+
+	if link.PasswordHash != "" {
+		cookie, err := c.Cookie(previewCookieName)
+		if err != nil || !hmac.Equal([]byte(cookie), []byte(previewCookieValue(link))) {
+			renderPasswordForm(c, http.StatusUnauthorized, "")
+			return
+		}
+	}
+
+	h.servePreview(c, link)
+}
+
+// UnlockPreview checks the password posted from the preview form and serves
+// the page, remembering the password in a cookie scoped to the link
+func (h *Handler) UnlockPreview(c *gin.Context) {
+	setPreviewHeaders(c)
+
+	link, ok := h.previewLink(c)
+	if !ok {
+		return
+	}
+	if link.PasswordHash == "" {
+		c.Redirect(http.StatusSeeOther, c.Request.URL.Path)
+		return
+	}
+
+	if !h.allowUnlock(c, link) {
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(c.PostForm("password"))); err != nil {
+		renderPasswordForm(c, http.StatusUnauthorized, "Incorrect password.")
+		return
+	}
+
+	maxAge := int(time.Until(link.ExpiresAt).Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(previewCookieName, previewCookieValue(link), maxAge, c.Request.URL.Path, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusSeeOther, c.Request.URL.Path)
+}
+
+// allowUnlock counts a password attempt against the link and the client IP,
+// and writes the password form with 429 once either is over
+// preview_unlock_per_hour. Attempts are not limited when Redis is
+// unavailable.
+func (h *Handler) allowUnlock(c *gin.Context, link *models.ShareLink) bool {
+	if h.deps.Redis == nil {
+		return true
+	}
+
+	limit := h.deps.Config.PreviewUnlockPerHour
+	var retryAfter time.Duration
+	for scope, id := range map[string]string{unlockScopeLink: link.TokenHash, unlockScopeIP: middleware.ClientIP(c)} {
+		allowed, wait, err := h.deps.Redis.HitRateLimit(c.Request.Context(), scope, id, limit, time.Hour)
+		if err != nil {
+			h.logger.Error("Failed to check preview unlock rate limit", "scope", scope, "error", err)
+			continue
+		}
+		if !allowed {
+			retryAfter = max(retryAfter, wait)
+		}
+	}
+	if retryAfter == 0 {
+		return true
+	}
+
+	h.logger.Warn("Preview unlock rate limited", "share_id", link.ID, "ip", middleware.ClientIP(c))
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	renderPasswordForm(c, http.StatusTooManyRequests, "Too many attempts, try again later.")
+	return false
+}
+
+// previewLink loads the link for the request's token and writes the error
+// page when it is missing, revoked or expired
+func (h *Handler) previewLink(c *gin.Context) (*models.ShareLink, bool) {
+	link, err := h.loadShareLink(c.Request.Context(), c.Param("token"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.String(http.StatusNotFound, "preview not found")
+		return nil, false
+	}
+	if err != nil {
+		h.logger.Error("Failed to load share link", "error", err)
+		c.String(http.StatusInternalServerError, "internal error")
+		return nil, false
+	}
+	if time.Now().After(link.ExpiresAt) {
+		c.String(http.StatusGone, "preview link expired")
+		return nil, false
+	}
+	return link, true
+}
+
+func (h *Handler) servePreview(c *gin.Context, link *models.ShareLink) {
+	ctx := c.Request.Context()
+
+	content, err := h.loadShareHTML(ctx, link)
+	if errors.Is(err, errSourceNotFound) || errors.Is(err, errSourceNoHTML) {
+		c.String(http.StatusNotFound, "preview content no longer available")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to load share content", "share_id", link.ID, "error", err)
+		c.String(http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	if err := h.deps.DB.DB.WithContext(ctx).Model(link).UpdateColumns(map[string]interface{}{
+		"access_count":     gorm.Expr("access_count + 1"),
+		"last_accessed_at": time.Now().UTC(),
+	}).Error; err != nil {
+		h.logger.Warn("Failed to record share link access", "share_id", link.ID, "error", err)
+	}
+
+	c.Header("Content-Security-Policy", previewContentPolicy)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
+}
+
+const passwordFormHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex, nofollow">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Protected preview</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; min-height: 100vh; margin: 0; align-items: center; justify-content: center; background: #f5f5f4; }
+form { background: #fff; padding: 2rem; border-radius: 8px; box-shadow: 0 1px 4px rgba(0,0,0,.1); display: flex; flex-direction: column; gap: .75rem; min-width: 260px; }
+p.error { color: #b91c1c; margin: 0; }
+</style>
+</head>
+<body>
+<form method="post">
+<label for="password">This preview is password protected.</label>
+%s<input id="password" name="password" type="password" autocomplete="current-password" autofocus required>
+<button type="submit">View preview</button>
+</form>
+</body>
+</html>
+`
+
+// renderPasswordForm writes the password form; message must be static text
+func renderPasswordForm(c *gin.Context, status int, message string) {
+	errorHTML := ""
+	if message != "" {
+		errorHTML = `<p class="error">` + message + "</p>\n"
+	}
+	c.Header("Content-Security-Policy", passwordFormPolicy)
+	c.Data(status, "text/html; charset=utf-8", fmt.Appendf(nil, passwordFormHTML, errorHTML))
+}
+
+// RegisterPreviewRoutes registers the public preview pages. They are served
+// without the frontend key, since previews are opened straight from the link.
+func RegisterPreviewRoutes(r gin.IRoutes, deps *sections.Dependencies) {
+	handler := NewHandler(deps)
+
+	r.GET("/preview/:token", handler.Preview)
+	r.POST("/preview/:token", handler.UnlockPreview)
+}
@@ -0,0 +1,112 @@
+package publish
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"awning-backend/model"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/sites"
+
+	"github.com/gin-gonic/gin"
+)
+
+const SiteCacheControl = "public, max-age=60, stale-while-revalidate=300"
+
+// SiteMiddleware serves the current publication at / for requests whose
+// tenant was resolved from the host by auth.TenantFromHostMiddleware, and
+// the other pages of a multi-page publication at /<slug>. Other requests
+// continue down the chain. /robots.txt and /sitemap.xml are generated from
+// the tenant's publications and settings.
+func SiteMiddleware(resolver *sites.Resolver, store *settings.Store) gin.HandlerFunc {
+	logger := slog.With("handler", "SiteHandler")
+	seo := &siteSEO{resolver: resolver, settings: store}
+
+	return func(c *gin.Context) {
+		if resolver.IsAppHost(c.Request.Host) {
+			c.Next()
+			return
+		}
+
+		tenantSchema, ok := auth.GetTenantIDFromContext(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		path := c.Request.URL.Path
+		method := c.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			c.Next()
+			return
+		}
+
+		switch path {
+		case "/robots.txt":
+			c.Abort()
+			seo.serveRobots(c, tenantSchema)
+			return
+		case "/sitemap.xml":
+			c.Abort()
+			seo.serveSitemap(c, tenantSchema)
+			return
+		case "/", "/index.html":
+			path = "/"
+		default:
+			// Could be a page of a multi-page site
+			if !model.ValidSitePageSlug(strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/")) {
+				c.Next()
+				return
+			}
+			path = strings.TrimSuffix(path, "/")
+		}
+
+		pub, err := resolver.CurrentPublication(c.Request.Context(), tenantSchema)
+		// Paths that aren't published pages are left to the rest of the chain
+		if path != "/" && (errors.Is(err, sites.ErrNotPublished) || (err == nil && pub.Pages[path] == "")) {
+			c.Next()
+			return
+		}
+
+		c.Abort()
+
+		if errors.Is(err, sites.ErrNotPublished) {
+			c.String(http.StatusNotFound, "site not published")
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to load publication", "tenant", tenantSchema, "error", err)
+			c.String(http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		content, etag := pub.Content, `"`+pub.ContentHash+`"`
+		if path != "/" {
+			content, etag = pub.Pages[path], `"`+pub.ContentHash+`-`+strings.TrimPrefix(path, "/")+`"`
+		}
+
+		if !seo.indexable(c, tenantSchema) {
+			c.Header("X-Robots-Tag", "noindex")
+		}
+
+		c.Header("Cache-Control", SiteCacheControl)
+		c.Header("ETag", etag)
+		c.Header("Last-Modified", pub.PublishedAt.UTC().Format(http.TimeFormat))
+		c.Header("Repr-Digest", ReprDigest(content))
+		c.Header("X-Publication-Version", strconv.Itoa(pub.Version))
+		if pub.Signature != "" {
+			c.Header("X-Publication-Signature", pub.Signature)
+		}
+
+		if match := c.GetHeader("If-None-Match"); match != "" && (match == etag || match == "*") {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(content))
+	}
+}
@@ -0,0 +1,350 @@
+package usage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"awning-backend/i18n"
+	"awning-backend/middleware"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Report granularities and formats
+const (
+	GranularityDay   = "day"
+	GranularityMonth = "month"
+
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Longest ranges a report may cover, in periods of its granularity
+const (
+	MaxReportDays   = 366
+	MaxReportMonths = 36
+)
+
+// Row is the usage of one model in one period, added up from the
+// generations' usage records. TenantSchema is only set in admin reports.
+type Row struct {
+	TenantSchema     string `json:"tenantSchema,omitempty" gorm:"-"`
+	Period           string `json:"period"`
+	Model            string `json:"model"`
+	Generations      int64  `json:"generations"`
+	PromptTokens     int64  `json:"promptTokens"`
+	CompletionTokens int64  `json:"completionTokens"`
+	TotalTokens      int64  `json:"totalTokens" gorm:"-"`
+	ImageSearches    int64  `json:"imageSearches"`
+}
+
+// Totals adds up the rows of a report
+type Totals struct {
+	Generations      int64 `json:"generations"`
+	PromptTokens     int64 `json:"promptTokens"`
+	CompletionTokens int64 `json:"completionTokens"`
+	TotalTokens      int64 `json:"totalTokens"`
+	ImageSearches    int64 `json:"imageSearches"`
+}
+
+func (t *Totals) add(row Row) {
+	t.Generations += row.Generations
+	t.PromptTokens += row.PromptTokens
+	t.CompletionTokens += row.CompletionTokens
+	t.TotalTokens += row.TotalTokens
+	t.ImageSearches += row.ImageSearches
+}
+
+// Report is a usage report in JSON. Periods are UTC days (YYYY-MM-DD) or
+// months (YYYY-MM); those without usage are left out.
+type Report struct {
+	TenantSchema string `json:"tenantSchema,omitempty"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Granularity  string `json:"granularity"`
+	Timezone     string `json:"timezone"`
+	Rows         []Row  `json:"rows"`
+	Totals       Totals `json:"totals"`
+}
+
+// reportQuery is a validated report request. to is exclusive: the day
+// after the requested one.
+type reportQuery struct {
+	from, to    time.Time
+	granularity string
+	format      string
+}
+
+// Handler serves usage reports
+type Handler struct {
+	logger *slog.Logger
+	deps   *sections.Dependencies
+}
+
+// NewHandler creates a new usage handler
+func NewHandler(deps *sections.Dependencies) *Handler {
+	return &Handler{
+		logger: slog.With("handler", "UsageHandler"),
+		deps:   deps,
+	}
+}
+
+// GetReport reports the tenant's usage per period and model. Query: from
+// and to (UTC dates, YYYY-MM-DD, to inclusive; to defaults to today and
+// from to the start of to's month, or year for months), granularity (day or
+// month) and format (json or csv).
+func (h *Handler) GetReport(c *gin.Context) {
+	tenantID, ok := auth.GetTenantIDFromContext(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenant context required"})
+		return
+	}
+
+	q, ok := parseReportQuery(c)
+	if !ok {
+		return
+	}
+	h.serveReport(c, q, tenantID, []string{tenantID})
+}
+
+// GetAdminReport is GetReport across every tenant, with a row per tenant,
+// period and model
+func (h *Handler) GetAdminReport(c *gin.Context) {
+	q, ok := parseReportQuery(c)
+	if !ok {
+		return
+	}
+
+	var schemas []string
+	err := h.deps.DB.DB.WithContext(c.Request.Context()).Model(&models.Tenant{}).Order("schema_name").Pluck("schema_name", &schemas).Error
+	if err != nil {
+		h.logger.Error("Failed to list tenants", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build usage report"})
+		return
+	}
+	h.serveReport(c, q, "", schemas)
+}
+
+// serveReport writes the report of the given tenants; tenantID is empty
+// for admin reports, whose rows name their tenant
+func (h *Handler) serveReport(c *gin.Context, q *reportQuery, tenantID string, schemas []string) {
+	ctx := c.Request.Context()
+	if q.format == FormatCSV {
+		h.writeCSV(c, q, tenantID, schemas)
+		return
+	}
+
+	report := Report{
+		TenantSchema: tenantID,
+		From:         q.from.Format(time.DateOnly),
+		To:           q.to.AddDate(0, 0, -1).Format(time.DateOnly),
+		Granularity:  q.granularity,
+		Timezone:     "UTC",
+		Rows:         []Row{},
+	}
+	for _, schema := range schemas {
+		err := h.scanUsage(ctx, schema, q, func(row Row) error {
+			if tenantID == "" {
+				row.TenantSchema = schema
+			}
+			report.Rows = append(report.Rows, row)
+			report.Totals.add(row)
+			return nil
+		})
+		if err != nil {
+			h.logger.Error("Failed to build usage report", "tenant", schema, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build usage report"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// writeCSV streams the report as CSV, ending with a total row. Once rows
+// are written the status can't change, so a failure stops the file before
+// its total row.
+func (h *Handler) writeCSV(c *gin.Context, q *reportQuery, tenantID string, schemas []string) {
+	name := tenantID
+	if name == "" {
+		name = "all"
+	}
+	filename := fmt.Sprintf("usage-%s-%s-%s.csv", name, q.from.Format(time.DateOnly), q.to.AddDate(0, 0, -1).Format(time.DateOnly))
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	header := []string{"period", "model", "generations", "prompt_tokens", "completion_tokens", "total_tokens", "image_searches"}
+	if tenantID == "" {
+		header = append([]string{"tenant"}, header...)
+	}
+	record := func(tenant, period, model string, generations, prompt, completion, total, images int64) error {
+		fields := []string{period, model,
+			strconv.FormatInt(generations, 10),
+			strconv.FormatInt(prompt, 10),
+			strconv.FormatInt(completion, 10),
+			strconv.FormatInt(total, 10),
+			strconv.FormatInt(images, 10),
+		}
+		if tenantID == "" {
+			fields = append([]string{tenant}, fields...)
+		}
+		return w.Write(fields)
+	}
+
+	if err := w.Write(header); err != nil {
+		h.logger.Error("Failed to write usage report", "error", err)
+		return
+	}
+	var totals Totals
+	for _, schema := range schemas {
+		err := h.scanUsage(c.Request.Context(), schema, q, func(row Row) error {
+			totals.add(row)
+			return record(schema, row.Period, row.Model, row.Generations, row.PromptTokens, row.CompletionTokens, row.TotalTokens, row.ImageSearches)
+		})
+		if err != nil {
+			h.logger.Error("Failed to stream usage report, stopping before the total row", "tenant", schema, "error", err)
+			w.Flush()
+			return
+		}
+	}
+	if err := record("", "total", "", totals.Generations, totals.PromptTokens, totals.CompletionTokens, totals.TotalTokens, totals.ImageSearches); err != nil {
+		h.logger.Error("Failed to write usage report", "error", err)
+		return
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		h.logger.Error("Failed to write usage report", "error", err)
+	}
+}
+
+// scanUsage calls fn with the tenant's usage per period and model, ordered
+// by period then model. Records are bucketed by their UTC creation time.
+func (h *Handler) scanUsage(ctx context.Context, schema string, q *reportQuery, fn func(Row) error) error {
+	layout := "YYYY-MM-DD"
+	if q.granularity == GranularityMonth {
+		layout = "YYYY-MM"
+	}
+
+	return h.deps.DB.WithTenant(ctx, schema, func(tx *gorm.DB) error {
+		rows, err := tx.Model(&models.UsageRecord{}).
+			Select("to_char(date_trunc(?, created_at AT TIME ZONE 'UTC'), ?) AS period, model, "+
+				"COUNT(*) AS generations, COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, "+
+				"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, COALESCE(SUM(image_searches), 0) AS image_searches",
+				q.granularity, layout).
+			Where("tenant_schema = ? AND created_at >= ? AND created_at < ?", schema, q.from, q.to).
+			Group("period, model").
+			Order("period, model").
+			Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var row Row
+			if err := tx.ScanRows(rows, &row); err != nil {
+				return err
+			}
+			row.TotalTokens = row.PromptTokens + row.CompletionTokens
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+// parseReportQuery reads and validates the report query, writing the error
+// response when it is invalid
+func parseReportQuery(c *gin.Context) (*reportQuery, bool) {
+	q := &reportQuery{
+		granularity: c.DefaultQuery("granularity", GranularityDay),
+		format:      c.DefaultQuery("format", FormatJSON),
+	}
+	if q.granularity != GranularityDay && q.granularity != GranularityMonth {
+		i18n.Error(c, http.StatusBadRequest, "invalid_usage_report", "granularity must be day or month")
+		return nil, false
+	}
+	if q.format != FormatJSON && q.format != FormatCSV {
+		i18n.Error(c, http.StatusBadRequest, "invalid_usage_report", "format must be json or csv")
+		return nil, false
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			i18n.Error(c, http.StatusBadRequest, "invalid_usage_report", "to must be a date (YYYY-MM-DD)")
+			return nil, false
+		}
+		to = t
+	}
+	from := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if q.granularity == GranularityMonth {
+		from = time.Date(to.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			i18n.Error(c, http.StatusBadRequest, "invalid_usage_report", "from must be a date (YYYY-MM-DD)")
+			return nil, false
+		}
+		from = t
+	}
+	if from.After(to) {
+		i18n.Error(c, http.StatusBadRequest, "invalid_usage_report", "from must not be after to")
+		return nil, false
+	}
+
+	periods := int(to.Sub(from).Hours()/24) + 1
+	maxPeriods := MaxReportDays
+	if q.granularity == GranularityMonth {
+		periods = (to.Year()-from.Year())*12 + int(to.Month()-from.Month()) + 1
+		maxPeriods = MaxReportMonths
+	}
+	if periods > maxPeriods {
+		c.JSON(http.StatusBadRequest, i18n.Localize(c, gin.H{
+			"error":       fmt.Sprintf("usage reports cover at most %d %ss", maxPeriods, q.granularity),
+			"code":        "usage_range_too_large",
+			"maxPeriods":  maxPeriods,
+			"granularity": q.granularity,
+		}))
+		return nil, false
+	}
+
+	q.from = from
+	q.to = to.AddDate(0, 0, 1)
+	return q, true
+}
+
+// RegisterRoutes registers the tenant usage report route, and the admin
+// report across tenants authenticated with the server API key
+func RegisterRoutes(r *gin.RouterGroup, deps *sections.Dependencies, jwtManager *auth.JWTManager) {
+	handler := NewHandler(deps)
+
+	tenantCfg := auth.DefaultTenantMiddlewareConfig()
+
+	usageRoutes := r.Group("/api/v1/usage")
+	usageRoutes.Use(auth.JWTAuthMiddleware(jwtManager))
+	usageRoutes.Use(auth.TenantFromHeaderMiddleware(tenantCfg))
+	{
+		usageRoutes.GET("/report", handler.GetReport)
+	}
+
+	adminRoutes := r.Group("/api/v1/admin/usage")
+	adminRoutes.Use(middleware.APIKeyAuthMiddleware(middleware.StaticAPIKeyValidator(deps.Config.ApiKey, deps.Config.ApiKeySecret)))
+	{
+		adminRoutes.GET("/report", handler.GetAdminReport)
+	}
+}
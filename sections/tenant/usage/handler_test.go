@@ -0,0 +1,100 @@
+package usage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseQuery runs parseReportQuery on the query string, returning the
+// error response's code when it fails
+func parseQuery(t *testing.T, query string) (*reportQuery, string) {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/usage/report?"+query, nil)
+
+	q, ok := parseReportQuery(c)
+	if ok {
+		return q, ""
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("parseReportQuery(%q) status = %d, want 400", query, w.Code)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return nil, body.Code
+}
+
+func TestParseReportQuery(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+
+	tests := []struct {
+		query       string
+		from, to    string // to is exclusive
+		granularity string
+		format      string
+	}{
+		{"from=2026-03-01&to=2026-03-31", "2026-03-01", "2026-04-01", GranularityDay, FormatJSON},
+		{"from=2026-03-01&to=2026-03-01&format=csv", "2026-03-01", "2026-03-02", GranularityDay, FormatCSV},
+		{"to=2026-03-15", "2026-03-01", "2026-03-16", GranularityDay, FormatJSON},
+		{"to=2026-03-15&granularity=month", "2026-01-01", "2026-03-16", GranularityMonth, FormatJSON},
+		{"from=2025-01-01&to=2025-12-31", "2025-01-01", "2026-01-01", GranularityDay, FormatJSON},
+	}
+	for _, tt := range tests {
+		q, code := parseQuery(t, tt.query)
+		if q == nil {
+			t.Errorf("parseReportQuery(%q) failed with %s", tt.query, code)
+			continue
+		}
+		if !q.from.Equal(date(tt.from)) || !q.to.Equal(date(tt.to)) || q.granularity != tt.granularity || q.format != tt.format {
+			t.Errorf("parseReportQuery(%q) = %s..%s %s %s, want %s..%s %s %s", tt.query,
+				q.from.Format(time.DateOnly), q.to.Format(time.DateOnly), q.granularity, q.format,
+				tt.from, tt.to, tt.granularity, tt.format)
+		}
+	}
+
+	// Bucketed by UTC days, whatever the server's zone
+	if q, _ := parseQuery(t, "from=2026-03-01&to=2026-03-01"); q.from.Location() != time.UTC || q.to.Location() != time.UTC {
+		t.Errorf("report range is in %s, want UTC", q.from.Location())
+	}
+}
+
+func TestParseReportQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		code  string
+	}{
+		{"granularity=week", "invalid_usage_report"},
+		{"format=xlsx", "invalid_usage_report"},
+		{"from=03/01/2026", "invalid_usage_report"},
+		{"to=2026-3-1", "invalid_usage_report"},
+		{"from=2026-03-02&to=2026-03-01", "invalid_usage_report"},
+		// 366 days are allowed, a leap year and a day isn't
+		{"from=2024-01-01&to=2025-01-01", "usage_range_too_large"},
+		{"from=2023-01-01&to=2026-01-01&granularity=month", "usage_range_too_large"},
+	}
+	for _, tt := range tests {
+		if q, code := parseQuery(t, tt.query); q != nil || code != tt.code {
+			t.Errorf("parseReportQuery(%q) code = %q, want %q", tt.query, code, tt.code)
+		}
+	}
+
+	if q, code := parseQuery(t, "from=2024-01-01&to=2024-12-31"); q == nil {
+		t.Errorf("parseReportQuery() of a 366 day leap year failed with %s", code)
+	}
+	if q, code := parseQuery(t, "from=2023-01-01&to=2025-12-31&granularity=month"); q == nil {
+		t.Errorf("parseReportQuery() of 36 months failed with %s", code)
+	}
+}
@@ -0,0 +1,152 @@
+// Package serverbuilder builds the HTTP router for both server modes. The
+// common middleware, API docs and static files are registered in every
+// mode; the multi-tenant sections only in full mode.
+package serverbuilder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"awning-backend/i18n"
+	"awning-backend/jobs"
+	"awning-backend/metrics"
+	"awning-backend/middleware"
+	"awning-backend/openapi"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/tenant/chat"
+	"awning-backend/sections/tenant/domains"
+	"awning-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mode selects which routes the server registers
+type Mode string
+
+const (
+	// ModeSimple runs without Postgres or JWT auth
+	ModeSimple Mode = "simple"
+	// ModeFull adds the multi-tenant sections
+	ModeFull Mode = "full"
+)
+
+// ResolveMode returns the mode for the server_mode setting. An empty setting
+// picks full when both a database and JWT auth are configured, and simple
+// otherwise. full without either is an error.
+func ResolveMode(setting string, hasDatabase, hasJWT bool) (Mode, error) {
+	switch Mode(setting) {
+	case ModeSimple:
+		return ModeSimple, nil
+	case ModeFull:
+		if !hasDatabase || !hasJWT {
+			return "", fmt.Errorf("server_mode full needs DATABASE_URL and JWT_PRIVATE_KEY")
+		}
+		return ModeFull, nil
+	case "":
+		if hasDatabase && hasJWT {
+			return ModeFull, nil
+		}
+		return ModeSimple, nil
+	}
+	return "", fmt.Errorf("unknown server_mode %q", setting)
+}
+
+// Options holds the router settings that aren't part of the dependencies
+type Options struct {
+	Mode Mode
+
+	// APP_ENV; outside development trusted_proxies and CORSOrigins must be
+	// set
+	Env string
+	// Comma-separated list from CORS_ORIGINS
+	CORSOrigins string
+
+	// SPA directory (APP_PUBLIC), or a dev server to proxy unmatched
+	// requests to (APP_PUBLIC_PROXY)
+	PublicDir   string
+	PublicProxy string
+
+	// Used in full mode only. Stripe and Registrar may be nil, which leaves
+	// out the payment and domain routes.
+	JWTManager *auth.JWTManager
+	Jobs       *jobs.Pool
+	Stripe     *services.StripeService
+	Registrar  domains.DomainRegistrar
+}
+
+// New builds the router. Background workers used by the routes (the request
+// log recorder, the offboarding worker) run until ctx is done.
+func New(ctx context.Context, deps *sections.Dependencies, opts Options) (*gin.Engine, error) {
+	if opts.Mode == ModeFull && (deps.DB == nil || opts.JWTManager == nil || opts.Jobs == nil) {
+		return nil, fmt.Errorf("full mode needs a database, a JWT manager and a job pool")
+	}
+
+	r := gin.Default()
+
+	clientIPResolver, err := setTrustedProxies(r, deps.Config, opts)
+	if err != nil {
+		return nil, err
+	}
+	r.Use(middleware.ClientIPMiddleware(clientIPResolver))
+	corsMiddleware, err := newCORSMiddleware(opts, deps.Sites)
+	if err != nil {
+		return nil, err
+	}
+	r.Use(corsMiddleware)
+	r.Use(middleware.GzipMiddleware(deps.Config.GzipMinBytes))
+	r.Use(i18n.Middleware(tenantLocale(deps)))
+
+	useTenantHostMiddleware(ctx, r, deps)
+
+	// OpenAPI spec, and Swagger UI when api_docs_enabled is set
+	openapi.RegisterRoutes(r, deps.Config.ApiDocsEnabled)
+
+	// Prometheus metrics, when metrics_enabled is set
+	if deps.Config.MetricsEnabled && deps.MetricsLabels != nil {
+		metrics.RegisterRoutes(&r.RouterGroup, deps.MetricsLabels, deps.DB, deps.Config.ApiKey, deps.Config.ApiKeySecret)
+	}
+	i18n.RegisterRoutes(r)
+
+	// Simple mode has no tenants, so of the chat routes it serves only the
+	// tenant-less completion endpoint for integrations
+	if opts.Mode == ModeFull {
+		registerSections(ctx, r, deps, opts)
+	} else {
+		chat.RegisterSimpleRoutes(&r.RouterGroup, deps)
+	}
+
+	registerStatic(r, deps, opts)
+
+	return r, nil
+}
+
+// registerStatic serves rehosted images from the local image store and the
+// frontend, either from a directory (with index.html for unknown non-API
+// paths) or through a proxy
+func registerStatic(r *gin.Engine, deps *sections.Dependencies, opts Options) {
+	cfg := deps.Config
+
+	// Serve rehosted images from the local image store (development)
+	if localStore, ok := deps.ImageStore.(*services.LocalImageStore); ok && strings.HasPrefix(cfg.ImageStorePublicBaseURL, "/") {
+		r.Static(cfg.ImageStorePublicBaseURL, localStore.Dir())
+	}
+
+	if publicDir := opts.PublicDir; publicDir != "" {
+		slog.Info("Serving static files", "directory", publicDir, "base_path", cfg.StaticBasePath)
+		// Served for unmatched routes only, so it can't shadow the API;
+		// index.html is the fallback for client-side routes
+		r.NoRoute(middleware.StaticHandler(middleware.StaticConfig{
+			Dir:               publicDir,
+			BasePath:          cfg.StaticBasePath,
+			ImmutablePrefixes: cfg.StaticImmutablePrefixes,
+		}))
+	} else if publicProxy := opts.PublicProxy; publicProxy != "" {
+		slog.Info("Serving static files via proxy", "proxy", publicProxy)
+		r.Use(middleware.StaticProxyMiddleware(publicProxy))
+	} else {
+		slog.Info("No static file directory set (APP_PUBLIC not defined) and no proxy set (APP_PUBLIC_PROXY not defined)")
+	}
+}
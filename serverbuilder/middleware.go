@@ -0,0 +1,121 @@
+package serverbuilder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/i18n"
+	"awning-backend/middleware"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/requestlog"
+	"awning-backend/sections/common/sites"
+	"awning-backend/sections/models"
+	"awning-backend/sections/tenant/publish"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// setTrustedProxies configures gin's ClientIP and returns the resolver for
+// ClientIPMiddleware from the same trusted_proxies and client_ip_headers, so
+// both agree on the client IP. Without trusted proxies forwarding headers
+// are ignored.
+func setTrustedProxies(r *gin.Engine, cfg *common.Config, opts Options) (*middleware.ClientIPResolver, error) {
+	if opts.Env != "development" && len(cfg.TrustedProxies) == 0 {
+		return nil, errors.New("in production mode, TRUSTED_PROXIES must be set")
+	}
+	if len(cfg.TrustedProxies) == 0 {
+		slog.Warn("No trusted proxies set (TRUSTED_PROXIES not defined)")
+	} else {
+		slog.Info("Setting trusted proxies", "proxies", cfg.TrustedProxies, "headers", cfg.ClientIPHeaders)
+	}
+
+	// nil trusts no proxy, where gin would otherwise trust all of them
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("failed to set trusted proxies: %w", err)
+	}
+	r.RemoteIPHeaders = cfg.ClientIPHeaders
+
+	resolver, err := middleware.NewClientIPResolver(cfg.TrustedProxies, cfg.ClientIPHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set trusted proxies: %w", err)
+	}
+	return resolver, nil
+}
+
+// newCORSMiddleware allows the origins in CORS_ORIGINS, and with a site
+// resolver, the verified domains and site subdomains of tenants for the
+// public content API
+func newCORSMiddleware(opts Options, resolver *sites.Resolver) (gin.HandlerFunc, error) {
+	corsConfig := cors.DefaultConfig()
+
+	if opts.Env != "development" && opts.CORSOrigins == "" {
+		return nil, errors.New("in production mode, CORS_ORIGINS must be set")
+	} else if opts.CORSOrigins != "" {
+		slog.Info("CORS origins set from CORS_ORIGINS")
+		corsConfig.AllowOrigins = strings.Split(opts.CORSOrigins, ",")
+	} else {
+		slog.Warn("Using default origin function in non-production mode (CORS_ORIGINS not defined)")
+		corsConfig.AllowOriginFunc = func(origin string) bool {
+			return origin == "http://localhost" || strings.HasPrefix(origin, "http://localhost:")
+		}
+	}
+
+	if resolver != nil {
+		corsConfig.AllowOriginWithContextFunc = auth.AllowTenantOrigin(resolver)
+	}
+
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Awning-Frontend-Key"}
+	return cors.New(corsConfig), nil
+}
+
+// useTenantHostMiddleware resolves tenants from custom domains and site
+// subdomains and serves published sites on those hosts ahead of the app
+// routes. It does nothing without a database.
+func useTenantHostMiddleware(ctx context.Context, r *gin.Engine, deps *sections.Dependencies) {
+	if deps.DB == nil {
+		return
+	}
+	r.Use(auth.TenantFromHostMiddleware(deps.Sites))
+	r.Use(publish.SiteMiddleware(deps.Sites, deps.Settings))
+
+	// Per-tenant request log, written in the background. Installed before
+	// the route groups so it wraps their tenant middleware.
+	if deps.Config.RequestLogEnabled {
+		recorder := requestlog.NewRecorder(deps.DB, deps.Config.RequestLogSuccessSamplePercent)
+		go recorder.Run(ctx)
+		r.Use(recorder.Middleware())
+	}
+}
+
+// tenantLocale looks up the tenant profile's locale, for error messages of
+// requests without a supported Accept-Language. It returns nil without a
+// database.
+func tenantLocale(deps *sections.Dependencies) i18n.TenantLocaleFunc {
+	if deps.DB == nil {
+		return nil
+	}
+	return func(c *gin.Context) string {
+		tenantID, ok := auth.GetTenantIDFromContext(c)
+		if !ok || tenantID == "" {
+			if tenantID, ok = auth.GetTenantSchemaFromContext(c); !ok || tenantID == "" {
+				return ""
+			}
+		}
+		var profile models.TenantProfile
+		err := deps.DB.WithTenant(c.Request.Context(), tenantID, func(tx *gorm.DB) error {
+			return tx.Where("tenant_schema = ?", tenantID).First(&profile).Error
+		})
+		if err != nil {
+			return ""
+		}
+		return profile.Locale
+	}
+}
@@ -0,0 +1,166 @@
+package serverbuilder
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"awning-backend/middleware"
+	"awning-backend/sections"
+	"awning-backend/sections/common/auth"
+	"awning-backend/sections/common/dbstats"
+	"awning-backend/sections/common/flags"
+	"awning-backend/sections/common/keyreaper"
+	"awning-backend/sections/common/notifications"
+	"awning-backend/sections/common/pricing"
+	"awning-backend/sections/common/requestlog"
+	"awning-backend/sections/common/settings"
+	"awning-backend/sections/common/unsplash"
+	"awning-backend/sections/common/users"
+	"awning-backend/sections/common/webhooks"
+	"awning-backend/sections/tenant/account"
+	"awning-backend/sections/tenant/chat"
+	"awning-backend/sections/tenant/contact"
+	"awning-backend/sections/tenant/dashboard"
+	"awning-backend/sections/tenant/domains"
+	"awning-backend/sections/tenant/filesystem"
+	"awning-backend/sections/tenant/images"
+	"awning-backend/sections/tenant/offboarding"
+	"awning-backend/sections/tenant/payment"
+	"awning-backend/sections/tenant/profile"
+	"awning-backend/sections/tenant/publish"
+	"awning-backend/sections/tenant/usage"
+	"awning-backend/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerSections registers the multi-tenant section routes and the jobs
+// they schedule
+func registerSections(ctx context.Context, r *gin.Engine, deps *sections.Dependencies, opts Options) {
+	slog.Info("Initializing multi-tenant sections")
+
+	cfg := deps.Config
+	jwtManager := opts.JWTManager
+	jobPool := opts.Jobs
+	stripeSvc := opts.Stripe
+
+	frontendRoutes := r.Group("/")
+	frontendRoutes.Use(middleware.APIFrontendKeyAuthMiddleware(cfg.ApiFrontendKey))
+
+	callbackRoutes := r.Group("/callbacks")
+	webhookRoutes := r.Group("/webhooks")
+
+	// Register user routes (public - no tenant context needed)
+	users.RegisterRoutes(frontendRoutes, deps, jwtManager)
+
+	// Register OAuth routes if configured
+	if cfg.OauthGoogleClientID != "" || cfg.OauthFacebookClientID != "" || cfg.OauthTikTokClientID != "" {
+		slog.Info("OAuth client IDs provided, registering OAuth routes")
+		oauthConfig := users.NewOAuthConfig(cfg)
+		users.RegisterOAuthRoutes(frontendRoutes, callbackRoutes, deps, jwtManager, oauthConfig)
+		slog.Info("OAuth routes registered")
+	}
+
+	// Register plan routes (public - prices are localized via Stripe when configured)
+	pricing.RegisterRoutes(frontendRoutes, deps, stripeSvc)
+
+	// Register tenant-scoped routes
+	// Each RegisterRoutes function creates its own route group with JWT + tenant middleware
+	chat.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	images.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	profile.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	account.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	dashboard.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	usage.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	contact.RegisterRoutes(r, frontendRoutes, deps, jwtManager)
+	filesystem.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	publish.RegisterRoutes(frontendRoutes, deps, jwtManager)
+	publish.RegisterPreviewRoutes(r, deps)
+
+	// Public keys publication signatures are verified with, no frontend key needed
+	auth.RegisterJWKSRoutes(r, jwtManager)
+	jobPool.Register(publish.JobKindReprocess, publish.NewReprocessor(deps).HandleJob)
+
+	// Unsplash quota for admins, and hourly searches for the images left
+	// pending while it was exhausted
+	unsplash.RegisterRoutes(frontendRoutes, deps.UnsplashSvc, cfg.ApiKey, cfg.ApiKeySecret)
+	if deps.UnsplashSvc != nil {
+		jobPool.Every(publish.JobKindFillPendingImages, publish.PendingImagesInterval, publish.NewPendingImageFiller(deps).HandleFill)
+	}
+
+	// Daily deletion of chat drafts older than draft_max_age_days
+	if cfg.DraftMaxAgeDays > 0 {
+		draftPruner := chat.NewDraftPruner(deps.DB, deps.Redis, cfg.DraftMaxAgeDays)
+		jobPool.Every(chat.JobKindPruneDrafts, chat.DraftPruneInterval, draftPruner.HandlePrune)
+	}
+
+	// Daily deletion of chats trashed more than chat_trash_retention_days
+	// ago (trashed Redis chats expire on their own)
+	trashPurger := chat.NewTrashPurger(deps.Chats, cfg.ChatTrashRetention())
+	jobPool.Every(chat.JobKindPurgeTrash, chat.TrashPurgeInterval, trashPurger.HandlePurge)
+
+	// Chat generations started with POST /api/v1/chat/generate
+	jobPool.Register(chat.JobKindGenerate, chat.NewHandler(deps).HandleGenerateJob)
+	settings.RegisterRoutes(frontendRoutes, deps.Settings, jwtManager)
+	flags.RegisterRoutes(frontendRoutes, deps.Flags, deps.DB, cfg.ApiKey, cfg.ApiKeySecret)
+
+	// Tenant webhooks, delivered through the job queue
+	webhooks.RegisterRoutes(frontendRoutes, deps.DB, jwtManager)
+	jobPool.Register(webhooks.JobKindDeliver, webhooks.NewDeliverer(deps.DB, webhooks.DeliveryTimeout).HandleDeliver)
+
+	// In-app notification feed
+	notifications.RegisterRoutes(frontendRoutes, deps.Notifications, jwtManager)
+
+	dbstats.RegisterRoutes(frontendRoutes, deps.DB, cfg.ApiKey, cfg.ApiKeySecret)
+
+	// Request log queries, and daily deletion past request_log_retention_days
+	requestlog.RegisterRoutes(frontendRoutes, deps.DB, cfg.ApiKey, cfg.ApiKeySecret)
+	if cfg.RequestLogEnabled {
+		requestPruner := requestlog.NewPruner(deps.DB, cfg.RequestLogRetentionDays, nil)
+		jobPool.Every(requestlog.JobKindPrune, requestlog.PruneInterval, requestPruner.HandlePrune)
+	}
+
+	// Reaping of orphaned Redis keys, every redis_reaper_interval_hours when
+	// enabled and on demand by admins
+	keyReaper := keyreaper.NewReaper(cfg, deps.Redis, deps.DB)
+	keyReaper.SetHooks(keyreaper.Hooks{
+		NamespaceReaped: func(report storage.ReaperNamespaceReport, dryRun bool) {
+			slog.Info("Redis keys reaped", "namespace", report.Namespace, "scanned", report.Scanned,
+				"deleted", report.Deleted, "expired", report.Expired, "dry_run", dryRun)
+		},
+	})
+	if cfg.RedisReaperEnabled {
+		jobPool.Every(keyreaper.JobKindReap, time.Duration(cfg.RedisReaperIntervalHours)*time.Hour, keyReaper.HandleReap)
+	} else {
+		jobPool.Register(keyreaper.JobKindReap, keyReaper.HandleReap)
+	}
+	keyreaper.RegisterRoutes(frontendRoutes, deps.Redis, deps.Jobs, cfg.ApiKey, cfg.ApiKeySecret)
+
+	// Register payment routes if Stripe is configured
+	if stripeSvc != nil {
+		payment.RegisterRoutes(frontendRoutes, webhookRoutes, deps, jwtManager, stripeSvc)
+		slog.Info("Payment routes registered")
+	}
+
+	// Register tenant offboarding routes and the worker deleting tenants
+	// once their grace period ends
+	offboarding.RegisterRoutes(frontendRoutes, deps, jwtManager, stripeSvc)
+	go offboarding.NewWorker(deps.DB, deps.Sites, nil).Run(ctx)
+
+	// Register domain routes when a registrar is available
+	if registrar := opts.Registrar; registrar != nil {
+		domains.RegisterRoutes(r, deps, jwtManager, registrar, stripeSvc)
+
+		// Daily registrar sync of domain expiry and renewal reminders
+		expiryMonitor := domains.NewExpiryMonitor(deps.DB, registrar, deps.Notifications)
+		jobPool.Every(domains.JobKindSyncExpiry, domains.ExpirySyncInterval, expiryMonitor.HandleSync)
+
+		// Certificate checks and requests for the external ACME worker
+		sslMonitor := domains.NewSSLMonitor(deps.DB, deps.Notifications)
+		jobPool.Every(domains.JobKindCheckSSL, domains.SSLCheckInterval, sslMonitor.HandleCheck)
+		slog.Info("Domain routes registered", "provider", cfg.DomainRegistrarProvider)
+	}
+
+	slog.Info("Multi-tenant sections initialized")
+}
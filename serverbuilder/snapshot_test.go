@@ -0,0 +1,140 @@
+package serverbuilder
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/metrics"
+	"awning-backend/sections"
+	"awning-backend/sections/common/flags"
+
+	"github.com/gin-gonic/gin"
+)
+
+var update = flag.Bool("update", false, "rewrite the route snapshots in testdata/routes")
+
+// newSimpleRouter builds the simple-mode router as main wires it, with no
+// database and the optional routes enabled
+func newSimpleRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	redisClient := newTestRedis(t)
+	cfg := common.DefaultConfig()
+	cfg.MetricsEnabled = true
+	cfg.ImageStorePublicBaseURL = "/media"
+
+	deps := &sections.Dependencies{
+		Config:        cfg,
+		Redis:         redisClient,
+		Chats:         redisClient,
+		ChatLocks:     redisClient,
+		KV:            redisClient,
+		ImageStore:    newTestImageStore(t, cfg),
+		Flags:         flags.New(redisClient, nil, 0),
+		MetricsLabels: metrics.NewTenantLabels(redisClient, nil, 0, 0),
+	}
+	r, err := New(context.Background(), deps, Options{
+		Mode: ModeSimple,
+		Env:  "development",
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return r
+}
+
+// routeTable lists the routes one per line as "METHOD path handler",
+// sorted by method and path
+func routeTable(routes gin.RoutesInfo) string {
+	lines := make([]string, len(routes))
+	for i, route := range routes {
+		lines[i] = route.Method + " " + route.Path + " " + strings.TrimPrefix(route.Handler, "awning-backend/")
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// TestRouteSnapshots compares each mode's route table with its snapshot,
+// so a change to the routes a mode serves shows up in review. Run with
+// -update to accept one.
+func TestRouteSnapshots(t *testing.T) {
+	tests := []struct {
+		mode   Mode
+		router func(*testing.T) *gin.Engine
+	}{
+		{ModeSimple, newSimpleRouter},
+		{ModeFull, newFullRouter},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			got := routeTable(tt.router(t).Routes())
+			path := filepath.Join("testdata", "routes", string(tt.mode)+".txt")
+
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read the snapshot: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("%s routes differ from %s, run go test ./serverbuilder -update to accept:\n%s",
+					tt.mode, path, lineDiff(string(want), got))
+			}
+		})
+	}
+}
+
+// TestSimpleRoutesInFull checks that full mode serves every simple-mode
+// route with the same handler, so the modes share that code
+func TestSimpleRoutesInFull(t *testing.T) {
+	full := map[string]string{}
+	for _, route := range newFullRouter(t).Routes() {
+		full[route.Method+" "+route.Path] = route.Handler
+	}
+	for _, route := range newSimpleRouter(t).Routes() {
+		key := route.Method + " " + route.Path
+		if handler, ok := full[key]; !ok {
+			t.Errorf("%s is served in simple mode only", key)
+		} else if handler != route.Handler {
+			t.Errorf("%s is served by %s in simple mode and %s in full mode", key, route.Handler, handler)
+		}
+	}
+}
+
+// lineDiff lists the lines only in want with a "-" and those only in got
+// with a "+"
+func lineDiff(want, got string) string {
+	count := map[string]int{}
+	for _, line := range strings.Split(want, "\n") {
+		count[line]++
+	}
+	for _, line := range strings.Split(got, "\n") {
+		count[line]--
+	}
+
+	var diff []string
+	for line, n := range count {
+		switch {
+		case n > 0:
+			diff = append(diff, "- "+line)
+		case n < 0:
+			diff = append(diff, "+ "+line)
+		}
+	}
+	sort.Slice(diff, func(i, j int) bool { return diff[i][2:] < diff[j][2:] })
+	return strings.Join(diff, "\n")
+}
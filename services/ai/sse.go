@@ -0,0 +1,98 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EventTooLargeError is returned when a server-sent event is larger than the
+// configured stream_max_event_bytes
+type EventTooLargeError struct {
+	Limit int
+}
+
+func (e *EventTooLargeError) Error() string {
+	return fmt.Sprintf("stream event exceeds %d bytes", e.Limit)
+}
+
+// sseReader reads the data of server-sent events. Lines end with \n or
+// \r\n, consecutive data: lines of one event are joined with \n, and a blank
+// line ends the event. Comments and other fields are skipped.
+type sseReader struct {
+	r        *bufio.Reader
+	maxEvent int
+}
+
+func newSSEReader(r io.Reader, maxEventBytes int) *sseReader {
+	return &sseReader{r: bufio.NewReader(r), maxEvent: maxEventBytes}
+}
+
+// Next returns the data of the next event with data, or io.EOF at the end of
+// the stream. An unterminated event at the end of the stream is returned
+// too, since some servers close without the final blank line.
+func (s *sseReader) Next() (string, error) {
+	var data []byte
+	hasData := false
+	size := 0
+
+	for {
+		line, err := s.readLine(&size)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		eof := err != nil
+
+		if len(line) == 0 {
+			if hasData {
+				return string(data), nil
+			}
+			if eof {
+				return "", io.EOF
+			}
+			// Blank line without data, such as after a comment
+			size = 0
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if string(field) == "data" {
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, value...)
+			hasData = true
+		}
+
+		if eof {
+			if hasData {
+				return string(data), nil
+			}
+			return "", io.EOF
+		}
+	}
+}
+
+// readLine reads one line without its line ending, adding its length to the
+// size of the current event
+func (s *sseReader) readLine(size *int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		*size += len(chunk)
+		if *size > s.maxEvent {
+			return nil, &EventTooLargeError{Limit: s.maxEvent}
+		}
+		line = append(line, chunk...)
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		return line, err
+	}
+}
@@ -0,0 +1,146 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+)
+
+// readFixture returns a file from testdata/sse
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "sse", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// readEvents reads every event of stream
+func readEvents(stream string, maxEventBytes int) ([]string, error) {
+	events := newSSEReader(strings.NewReader(stream), maxEventBytes)
+	var got []string
+	for {
+		data, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			return got, nil
+		}
+		if err != nil {
+			return got, err
+		}
+		got = append(got, data)
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []string
+	}{
+		{"crlf.txt", []string{
+			`{"choices":[{"delta":{"content":"<h1>"}}]}`,
+			`{"choices":[{"delta":{"content":"Hi</h1>"}}]}`,
+			"[DONE]",
+		}},
+		// Data lines of one event are joined with newlines, whatever their
+		// line endings
+		{"multiline.txt", []string{
+			"{\"choices\":[{\"delta\":\n{\"content\":\"<p>one</p>\"}}]}",
+			`{"choices":[{"delta":{"content":"<p>two</p>"}}]}`,
+			"{\"choices\":[\n{\"delta\":{\"content\":\"<p>three</p>\"},\"finish_reason\":\"stop\"}]}",
+			"[DONE]",
+		}},
+		{"unterminated.txt", []string{`{"choices":[{"delta":{"content":"cut off"}}]}`}},
+	}
+	for _, tt := range tests {
+		got, err := readEvents(readFixture(t, tt.fixture), common.DEFAULT_STREAM_MAX_EVENT_BYTES)
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.fixture, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: events = %q, want %q", tt.fixture, got, tt.want)
+		}
+	}
+}
+
+func TestSSEReaderEventSize(t *testing.T) {
+	// Larger than bufio's buffer and the old scanner's 64KB token limit
+	big := strings.Repeat("x", 300<<10)
+	stream := "data: " + big + "\r\n\r\ndata: after\n\n"
+
+	got, err := readEvents(stream, 1<<20)
+	if err != nil || len(got) != 2 || got[0] != big || got[1] != "after" {
+		t.Errorf("events of a 300KB event = %d, %v; want it whole and the next", len(got), err)
+	}
+
+	// The limit counts every line of the event
+	split := "data: " + big[:200<<10] + "\ndata: " + big[:200<<10] + "\n\n"
+	for _, s := range []string{stream, split} {
+		_, err := readEvents(s, 256<<10)
+		var tooLarge *EventTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != 256<<10 {
+			t.Errorf("error of an event over the limit = %v, want EventTooLargeError", err)
+		}
+	}
+
+	// Events under the limit reset the count
+	small := strings.Repeat("data: "+big[:100<<10]+"\n\n", 5)
+	if got, err := readEvents(small, 256<<10); err != nil || len(got) != 5 {
+		t.Errorf("events under the limit = %d, %v; want 5", len(got), err)
+	}
+}
+
+func TestGenerateContentStreamFixtures(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    string
+	}{
+		{"crlf.txt", "<h1>Hi</h1>"},
+		{"multiline.txt", "<p>one</p><p>two</p><p>three</p>"},
+	}
+	for _, tt := range tests {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			writeEvents(w, readFixture(t, tt.fixture))
+		})
+
+		var content strings.Builder
+		err := client.GenerateContentStream(context.Background(), "Build a page", common.GenerationParams{}, func(event StreamEvent) error {
+			content.WriteString(event.Content)
+			return nil
+		})
+		if err != nil || content.String() != tt.want {
+			t.Errorf("%s: streamed %q, %v; want %q", tt.fixture, content.String(), err, tt.want)
+		}
+	}
+}
+
+func TestGenerateContentStreamOversizedEvent(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeEvents(w,
+			`data: {"choices":[{"delta":{"content":"start"}}]}`+"\n\n",
+			`data: {"choices":[{"delta":{"content":"`+strings.Repeat("x", 2<<20)+`"}}]}`+"\n\n",
+			"data: [DONE]\n\n")
+	})
+
+	var content strings.Builder
+	err := client.GenerateContentStream(context.Background(), "Build a page", common.GenerationParams{}, func(event StreamEvent) error {
+		content.WriteString(event.Content)
+		return nil
+	})
+	var tooLarge *EventTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Limit != common.DEFAULT_STREAM_MAX_EVENT_BYTES {
+		t.Errorf("GenerateContentStream() error = %v, want EventTooLargeError", err)
+	}
+	if content.String() != "start" {
+		t.Errorf("streamed %q before the oversized event, want start", content.String())
+	}
+}
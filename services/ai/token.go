@@ -0,0 +1,217 @@
+package ai
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	TOKEN_FETCH_ATTEMPTS      = 3
+	TOKEN_RETRY_INITIAL_DELAY = 500 * time.Millisecond
+	TOKEN_RETRY_MAX_DELAY     = 30 * time.Second
+
+	// Tokens are fetched at most this often, even when the one fetched
+	// expires within the refresh window
+	TOKEN_REFRESH_MIN_INTERVAL = 30 * time.Second
+)
+
+// freshTokenSource fetches a new token on every call. The token sources of
+// google.Credentials reuse their token until shortly before it expires, so
+// refreshing through one ahead of that gets the same token back; new
+// credentials are made for each fetch instead.
+type freshTokenSource struct {
+	ctx      context.Context
+	credData []byte
+	scopes   []string
+}
+
+func (s freshTokenSource) Token() (*oauth2.Token, error) {
+	creds, err := google.CredentialsFromJSON(s.ctx, s.credData, s.scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource.Token()
+}
+
+// cachedTokenSource caches an access token and refreshes it ahead of expiry.
+// Fetches are serialized by the mutex, so concurrent callers share a single
+// fetch instead of each hitting the token endpoint. src must fetch a new
+// token on every call, as freshTokenSource does.
+type cachedTokenSource struct {
+	mu            sync.Mutex
+	src           oauth2.TokenSource
+	token         *oauth2.Token
+	fetchedAt     time.Time
+	refreshBefore time.Duration
+	minInterval   time.Duration
+	logger        *slog.Logger
+}
+
+func newCachedTokenSource(src oauth2.TokenSource, refreshBefore time.Duration) *cachedTokenSource {
+	return &cachedTokenSource{
+		src:           src,
+		refreshBefore: refreshBefore,
+		minInterval:   TOKEN_REFRESH_MIN_INTERVAL,
+		logger:        slog.With("service", "VertexTokenSource"),
+	}
+}
+
+// fresh reports whether the cached token is usable without refreshing
+func (s *cachedTokenSource) fresh() bool {
+	if s.token == nil || s.token.AccessToken == "" {
+		return false
+	}
+	if s.token.Expiry.IsZero() {
+		return true
+	}
+	return time.Until(s.token.Expiry) > s.refreshBefore
+}
+
+// usable reports whether the cached token has not actually expired yet
+func (s *cachedTokenSource) usable() bool {
+	return s.token != nil && s.token.AccessToken != "" &&
+		(s.token.Expiry.IsZero() || time.Now().Before(s.token.Expiry))
+}
+
+// Token returns the cached token, refreshing it when it is within the refresh
+// window. If the refresh fails but the old token is still valid, the old
+// token is returned, as it is when the last fetch was under minInterval ago.
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fresh() {
+		return s.token, nil
+	}
+	if s.usable() && time.Since(s.fetchedAt) < s.minInterval {
+		return s.token, nil
+	}
+
+	token, err := s.fetchLocked(TOKEN_FETCH_ATTEMPTS)
+	if err != nil {
+		if s.usable() {
+			s.logger.Warn("Token refresh failed, using cached token", "expires_at", s.token.Expiry, "error", err)
+			return s.token, nil
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+// fetchLocked fetches a new token with exponential backoff. s.mu must be held.
+func (s *cachedTokenSource) fetchLocked(attempts int) (*oauth2.Token, error) {
+	delay := TOKEN_RETRY_INITIAL_DELAY
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var token *oauth2.Token
+		token, err = s.src.Token()
+		if err == nil {
+			s.token = token
+			s.fetchedAt = time.Now()
+			s.logger.Debug("Access token refreshed", "expires_at", token.Expiry, "expires_in", time.Until(token.Expiry).Round(time.Second))
+			return token, nil
+		}
+
+		s.logger.Warn("Failed to fetch access token", "attempt", attempt, "error", err)
+		if attempt < attempts {
+			time.Sleep(delay)
+			delay = min(delay*2, TOKEN_RETRY_MAX_DELAY)
+		}
+	}
+	return nil, err
+}
+
+// Expiry returns the expiry of the cached token
+func (s *cachedTokenSource) Expiry() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == nil {
+		return time.Time{}
+	}
+	return s.token.Expiry
+}
+
+// nextRefresh returns how long the refresher waits before fetching again:
+// until the token enters the refresh window, but at least minInterval after
+// the last fetch. Tokens without an expiry are never refreshed.
+func (s *cachedTokenSource) nextRefresh() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return 0, true
+	}
+	if s.token.Expiry.IsZero() {
+		return 0, false
+	}
+	wait := time.Until(s.token.Expiry) - s.refreshBefore
+	return max(wait, s.minInterval-time.Since(s.fetchedAt), 0), true
+}
+
+// StartRefresher refreshes the token in the background as it enters the
+// refresh window, so chat requests rarely wait on a fetch. Failed refreshes
+// are retried with backoff until ctx is done.
+func (s *cachedTokenSource) StartRefresher(ctx context.Context) {
+	go func() {
+		retryDelay := TOKEN_RETRY_INITIAL_DELAY
+		for {
+			wait, ok := s.nextRefresh()
+			if !ok {
+				s.logger.Debug("Access token has no expiry, not refreshing it")
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(max(wait, 0)):
+			}
+
+			s.mu.Lock()
+			_, err := s.fetchLocked(1)
+			s.mu.Unlock()
+
+			if err == nil {
+				retryDelay = TOKEN_RETRY_INITIAL_DELAY
+				continue
+			}
+
+			// Retry on the backoff schedule rather than waiting for the expiry
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			retryDelay = min(retryDelay*2, TOKEN_RETRY_MAX_DELAY)
+		}
+	}()
+}
+
+// newVertexHTTPClient returns an HTTP client with pooled connections and
+// dial/TLS timeouts. There is no overall timeout since responses stream.
+func newVertexHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			ResponseHeaderTimeout: 2 * time.Minute,
+		},
+	}
+}
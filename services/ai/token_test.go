@@ -0,0 +1,171 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeTokenSource hands out a new token on every call, valid for lifetime,
+// after delay
+type fakeTokenSource struct {
+	calls    atomic.Int32
+	delay    time.Duration
+	lifetime time.Duration
+	err      error
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	n := f.calls.Add(1)
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return nil, f.err
+	}
+	token := &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", n)}
+	if f.lifetime > 0 {
+		token.Expiry = time.Now().Add(f.lifetime)
+	}
+	return token, nil
+}
+
+func TestCachedTokenSourceSingleFlight(t *testing.T) {
+	src := &fakeTokenSource{delay: 50 * time.Millisecond, lifetime: time.Hour}
+	tokens := newCachedTokenSource(src, 5*time.Minute)
+
+	var wg sync.WaitGroup
+	got := make([]string, 50)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tokens.Token()
+			if err != nil {
+				t.Errorf("Token() error = %v", err)
+				return
+			}
+			got[i] = token.AccessToken
+		}()
+	}
+	wg.Wait()
+
+	if calls := src.calls.Load(); calls != 1 {
+		t.Errorf("fetched %d times, want 1", calls)
+	}
+	for i, token := range got {
+		if token != "token-1" {
+			t.Errorf("caller %d got %q, want token-1", i, token)
+		}
+	}
+}
+
+func TestCachedTokenSourceRefreshesInWindow(t *testing.T) {
+	src := &fakeTokenSource{lifetime: time.Minute}
+	tokens := newCachedTokenSource(src, 5*time.Minute)
+	tokens.minInterval = 0
+
+	first, err := tokens.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	// The token expires within the refresh window, so the next call fetches
+	second, err := tokens.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if first.AccessToken == second.AccessToken {
+		t.Errorf("token in the refresh window was not refreshed")
+	}
+}
+
+func TestCachedTokenSourceMinInterval(t *testing.T) {
+	src := &fakeTokenSource{lifetime: time.Minute}
+	tokens := newCachedTokenSource(src, 5*time.Minute)
+
+	for range 10 {
+		if _, err := tokens.Token(); err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+	}
+	if calls := src.calls.Load(); calls != 1 {
+		t.Errorf("fetched %d times within the minimum interval, want 1", calls)
+	}
+}
+
+func TestCachedTokenSourceKeepsUsableTokenOnFailure(t *testing.T) {
+	src := &fakeTokenSource{lifetime: time.Minute}
+	tokens := newCachedTokenSource(src, 5*time.Minute)
+	tokens.minInterval = 0
+
+	first, err := tokens.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+
+	src.err = errors.New("oauth2: cannot fetch token")
+	got, err := tokens.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v, want the cached token", err)
+	}
+	if got.AccessToken != first.AccessToken {
+		t.Errorf("got %q, want the cached %q", got.AccessToken, first.AccessToken)
+	}
+}
+
+func TestStartRefresherWaitsForRefreshWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+	}{
+		{"expires within the window", time.Minute},
+		{"expires after the window", time.Hour},
+		{"never expires", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := &fakeTokenSource{lifetime: tt.lifetime}
+			tokens := newCachedTokenSource(src, 5*time.Minute)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tokens.StartRefresher(ctx)
+			time.Sleep(200 * time.Millisecond)
+
+			// The first token is fetched at once; the next not before the
+			// window or the minimum interval
+			if calls := src.calls.Load(); calls != 1 {
+				t.Errorf("fetched %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	tokens := newCachedTokenSource(&fakeTokenSource{}, 5*time.Minute)
+	if wait, ok := tokens.nextRefresh(); !ok || wait != 0 {
+		t.Errorf("without a token: nextRefresh() = %v, %v, want 0, true", wait, ok)
+	}
+
+	tokens.token = &oauth2.Token{AccessToken: "a", Expiry: time.Now().Add(time.Hour)}
+	tokens.fetchedAt = time.Now()
+	wait, ok := tokens.nextRefresh()
+	if !ok || wait < 54*time.Minute || wait > 55*time.Minute {
+		t.Errorf("nextRefresh() = %v, %v, want about 55m, true", wait, ok)
+	}
+
+	tokens.token.Expiry = time.Now().Add(time.Minute)
+	wait, _ = tokens.nextRefresh()
+	if wait < 29*time.Second || wait > TOKEN_REFRESH_MIN_INTERVAL {
+		t.Errorf("in the window: nextRefresh() = %v, want the minimum interval", wait)
+	}
+
+	tokens.token.Expiry = time.Time{}
+	if _, ok := tokens.nextRefresh(); ok {
+		t.Errorf("without an expiry: nextRefresh() reports a refresh")
+	}
+}
@@ -1,16 +1,17 @@
-package main
+// Package ai contains the clients used to generate content with hosted models
+package ai
 
 import (
 	"awning-backend/common"
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"strings"
+	"time"
 
 	"golang.org/x/oauth2/google"
 )
@@ -32,9 +33,27 @@ type OpenAIMessage struct {
 }
 
 type OpenAIChatRequest struct {
-	Model    string          `json:"model"`
-	Messages []OpenAIMessage `json:"messages"`
-	Stream   bool            `json:"stream"`
+	Model       string          `json:"model"`
+	Messages    []OpenAIMessage `json:"messages"`
+	Stream      bool            `json:"stream"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	TopP        *float64        `json:"top_p,omitempty"`
+	MaxTokens   *int            `json:"max_tokens,omitempty"`
+}
+
+// newChatRequest builds a single-prompt request with the generation
+// parameters that are set
+func newChatRequest(model, prompt string, params common.GenerationParams, stream bool) OpenAIChatRequest {
+	return OpenAIChatRequest{
+		Model: model,
+		Messages: []OpenAIMessage{
+			{Role: "user", Content: prompt},
+		},
+		Stream:      stream,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		MaxTokens:   params.MaxOutputTokens,
+	}
 }
 
 type OpenAIChoice struct {
@@ -80,6 +99,16 @@ type StreamEvent struct {
 	Content string `json:"content"` // Text content for the event
 }
 
+// StreamCallback is called for each streaming event
+type StreamCallback func(event StreamEvent) error
+
+// Client generates content from a prompt with the given generation
+// parameters, either streamed or in one response
+type Client interface {
+	GenerateContent(ctx context.Context, prompt string, params common.GenerationParams) (string, error)
+	GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(StreamEvent) error) error
+}
+
 // VertexOpenAIClient handles API calls to Vertex AI using OpenAI-compatible endpoint
 type VertexOpenAIClient struct {
 	projectID           string
@@ -90,8 +119,7 @@ type VertexOpenAIClient struct {
 	cfg                 *common.Config
 }
 
-// GlobalVertexOpenAIClient is the global instance
-var GlobalVertexOpenAIClient *VertexOpenAIClient
+var _ Client = (*VertexOpenAIClient)(nil)
 
 // NewVertexOpenAIClient creates a client using service account credentials
 func NewVertexOpenAIClient(ctx context.Context, cfg *common.Config, credData []byte) (*VertexOpenAIClient, error) {
@@ -107,8 +135,8 @@ func NewVertexOpenAIClient(ctx context.Context, cfg *common.Config, credData []b
 		return nil, fmt.Errorf("failed to parse credentials: %w", err)
 	}
 
-	creds, err := google.CredentialsFromJSON(ctx, credData, VERTEX_SCOPE)
-	if err != nil {
+	// Checked once here; tokens are fetched with new credentials each time
+	if _, err := google.CredentialsFromJSON(ctx, credData, VERTEX_SCOPE); err != nil {
 		return nil, fmt.Errorf("failed to create credentials: %w", err)
 	}
 
@@ -117,12 +145,17 @@ func NewVertexOpenAIClient(ctx context.Context, cfg *common.Config, credData []b
 	// Build endpoint URL
 	endpoint := fmt.Sprintf("%s/endpoints/openapi/chat/completions", locationEndpoint)
 
+	// The credentials' own source would keep returning the same token
+	// through the refresh window
+	tokens := newCachedTokenSource(freshTokenSource{ctx: ctx, credData: credData, scopes: []string{VERTEX_SCOPE}}, time.Duration(cfg.VertexTokenRefreshSeconds)*time.Second)
+	tokens.StartRefresher(ctx)
+
 	client := &VertexOpenAIClient{
 		projectID:           cred.ProjectID,
 		completionsEndpoint: endpoint,
-		httpClient:          &http.Client{},
+		httpClient:          newVertexHTTPClient(),
 		tokenSrc: func() (string, error) {
-			token, err := creds.TokenSource.Token()
+			token, err := tokens.Token()
 			if err != nil {
 				return "", err
 			}
@@ -135,35 +168,53 @@ func NewVertexOpenAIClient(ctx context.Context, cfg *common.Config, credData []b
 	return client, nil
 }
 
-// GenerateContent sends a chat completion request
-func (c *VertexOpenAIClient) GenerateContent(ctx context.Context, prompt string) (string, error) {
-	token, err := c.tokenSrc()
-	if err != nil {
-		return "", fmt.Errorf("failed to get token: %w", err)
+// NewOpenAICompatibleClient creates a client for another OpenAI-compatible
+// chat completions endpoint, such as a local model server or a fake in
+// integration tests, sending the tokens tokenSrc returns
+func NewOpenAICompatibleClient(cfg *common.Config, endpoint string, tokenSrc func() (string, error)) *VertexOpenAIClient {
+	return &VertexOpenAIClient{
+		completionsEndpoint: endpoint,
+		httpClient:          newVertexHTTPClient(),
+		tokenSrc:            tokenSrc,
+		cfg:                 cfg,
 	}
+}
 
+// GenerateContent sends a chat completion request to the default model
+func (c *VertexOpenAIClient) GenerateContent(ctx context.Context, prompt string, params common.GenerationParams) (string, error) {
 	model, ok := c.cfg.GetDefaultModel()
 	if !ok {
 		slog.Warn("Default model not in enabled models, using fallback", "default_model", DEFAULT_VERTEX_MODEL)
 		model = DEFAULT_VERTEX_MODEL
 	}
 
-	slog.Debug("Using model for content generation", "model", model)
+	return c.generateContent(ctx, model, prompt, params)
+}
 
-	reqBody := OpenAIChatRequest{
-		Model: model,
-		Messages: []OpenAIMessage{
-			{Role: "user", Content: prompt},
-		},
-		Stream: false,
+// GenerateContentWithModel sends a chat completion request to a specific
+// model, with the model's default parameters
+func (c *VertexOpenAIClient) GenerateContentWithModel(ctx context.Context, model string, prompt string) (string, error) {
+	return c.generateContent(ctx, model, prompt, common.GenerationParams{})
+}
+
+func (c *VertexOpenAIClient) generateContent(ctx context.Context, model string, prompt string, params common.GenerationParams) (string, error) {
+	stopAuth := common.StartTiming(ctx, "model_auth")
+	token, err := c.tokenSrc()
+	stopAuth()
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
 	}
 
+	slog.Debug("Using model for content generation", "model", model)
+
+	reqBody := newChatRequest(model, prompt, params, false)
+
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	slog.Debug("Calling Vertex AI", "endpoint", c.completionsEndpoint, "model", DEFAULT_VERTEX_MODEL)
+	slog.Debug("Calling Vertex AI", "endpoint", c.completionsEndpoint, "model", model)
 
 	req, err := http.NewRequestWithContext(ctx, "POST", c.completionsEndpoint, bytes.NewReader(jsonBody))
 	if err != nil {
@@ -208,12 +259,11 @@ func (c *VertexOpenAIClient) GenerateContent(ctx context.Context, prompt string)
 	return chatResp.Choices[0].Message.Content, nil
 }
 
-// StreamCallback is called for each streaming event
-type StreamCallback func(event StreamEvent) error
-
 // GenerateContentStream sends a streaming chat completion request
-func (c *VertexOpenAIClient) GenerateContentStream(ctx context.Context, prompt string, callback StreamCallback) error {
+func (c *VertexOpenAIClient) GenerateContentStream(ctx context.Context, prompt string, params common.GenerationParams, callback func(StreamEvent) error) error {
+	stopAuth := common.StartTiming(ctx, "model_auth")
 	token, err := c.tokenSrc()
+	stopAuth()
 	if err != nil {
 		return fmt.Errorf("failed to get token: %w", err)
 	}
@@ -226,13 +276,7 @@ func (c *VertexOpenAIClient) GenerateContentStream(ctx context.Context, prompt s
 
 	slog.Debug("Using model for content generation", "model", model)
 
-	reqBody := OpenAIChatRequest{
-		Model: DEFAULT_VERTEX_MODEL,
-		Messages: []OpenAIMessage{
-			{Role: "user", Content: prompt},
-		},
-		Stream: true,
-	}
+	reqBody := newChatRequest(model, prompt, params, true)
 
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -262,22 +306,24 @@ func (c *VertexOpenAIClient) GenerateContentStream(ctx context.Context, prompt s
 		return fmt.Errorf("API error: status %d", resp.StatusCode)
 	}
 
-	// Process SSE stream
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Skip empty lines
-		if line == "" {
-			continue
+	// Process SSE stream: each event's data is "{...}" or "[DONE]"
+	maxEvent := c.cfg.StreamMaxEventBytes
+	if maxEvent <= 0 {
+		maxEvent = common.DEFAULT_STREAM_MAX_EVENT_BYTES
+	}
+	events := newSSEReader(resp.Body, maxEvent)
+	for {
+		data, err := events.Next()
+		if errors.Is(err, io.EOF) {
+			break
 		}
-
-		// SSE format: "data: {...}" or "data: [DONE]"
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+		var tooLarge *EventTooLargeError
+		if errors.As(err, &tooLarge) {
+			slog.Error("Stream event too large", "limit", tooLarge.Limit, "model", model)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading stream: %w", err)
 		}
-
-		data := strings.TrimPrefix(line, "data: ")
 
 		// Check for stream end
 		if data == "[DONE]" {
@@ -318,19 +364,5 @@ func (c *VertexOpenAIClient) GenerateContentStream(ctx context.Context, prompt s
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stream: %w", err)
-	}
-
-	return nil
-}
-
-// InitGlobalVertexOpenAIClient initializes the global client
-func InitGlobalVertexOpenAIClient(ctx context.Context, cfg *common.Config, credData []byte) error {
-	client, err := NewVertexOpenAIClient(ctx, cfg, credData)
-	if err != nil {
-		return err
-	}
-	GlobalVertexOpenAIClient = client
 	return nil
 }
@@ -0,0 +1,258 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+)
+
+// newTestClient returns a client of a test server serving handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *VertexOpenAIClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewOpenAICompatibleClient(common.DefaultConfig(), server.URL+"/chat/completions", func() (string, error) {
+		return "test-token", nil
+	})
+}
+
+// decodeChatRequest checks the request's headers and returns its body
+func decodeChatRequest(t *testing.T, r *http.Request) OpenAIChatRequest {
+	t.Helper()
+
+	if r.Method != http.MethodPost || r.URL.Path != "/chat/completions" {
+		t.Errorf("request = %s %s, want POST /chat/completions", r.Method, r.URL.Path)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+		t.Errorf("Authorization = %q, want the token", got)
+	}
+	var req OpenAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		t.Errorf("invalid request body: %v", err)
+	}
+	return req
+}
+
+func TestGenerateContent(t *testing.T) {
+	temperature := 0.2
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		req := decodeChatRequest(t, r)
+		if req.Stream {
+			t.Error("non-streaming request asked for a stream")
+		}
+		if len(req.Messages) != 1 || req.Messages[0].Role != "user" || req.Messages[0].Content != "Build a page" {
+			t.Errorf("messages = %+v, want the prompt", req.Messages)
+		}
+		if req.Temperature == nil || *req.Temperature != temperature || req.TopP != nil {
+			t.Errorf("parameters = %v/%v, want only the temperature", req.Temperature, req.TopP)
+		}
+		_ = json.NewEncoder(w).Encode(OpenAIChatResponse{
+			Choices: []OpenAIChoice{{Message: OpenAIMessage{Role: "assistant", Content: "<h1>Hi</h1>"}}},
+			Usage:   &OpenAIUsage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7},
+		})
+	})
+
+	got, err := client.GenerateContent(context.Background(), "Build a page", common.GenerationParams{Temperature: &temperature})
+	if err != nil {
+		t.Fatalf("GenerateContent() error = %v", err)
+	}
+	if got != "<h1>Hi</h1>" {
+		t.Errorf("GenerateContent() = %q", got)
+	}
+}
+
+func TestGenerationParamsInRequestBody(t *testing.T) {
+	temperature, topP, maxTokens := 1.3, 0.8, 512
+	params := common.GenerationParams{Temperature: &temperature, TopP: &topP, MaxOutputTokens: &maxTokens}
+
+	tests := []struct {
+		params common.GenerationParams
+		want   map[string]any
+	}{
+		{params, map[string]any{"temperature": 1.3, "top_p": 0.8, "max_tokens": 512.0}},
+		{common.GenerationParams{}, map[string]any{}},
+	}
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			var body map[string]any
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("invalid request body: %v", err)
+				}
+				if stream {
+					writeEvents(w, `data: {"choices":[{"delta":{"content":"ok"}}]}`+"\n\n", "data: [DONE]\n\n")
+					return
+				}
+				_ = json.NewEncoder(w).Encode(OpenAIChatResponse{Choices: []OpenAIChoice{{Message: OpenAIMessage{Content: "ok"}}}})
+			})
+
+			var err error
+			if stream {
+				err = client.GenerateContentStream(context.Background(), "Build a page", tt.params, func(StreamEvent) error { return nil })
+			} else {
+				_, err = client.GenerateContent(context.Background(), "Build a page", tt.params)
+			}
+			if err != nil {
+				t.Fatalf("stream %v: error = %v", stream, err)
+			}
+
+			// Unset parameters are left out of the body
+			for _, key := range []string{"temperature", "top_p", "max_tokens"} {
+				want, set := tt.want[key]
+				if got, ok := body[key]; ok != set || got != want {
+					t.Errorf("stream %v: body[%s] = %v, want %v", stream, key, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateContentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"overloaded"}`, http.StatusServiceUnavailable)
+		}},
+		{"no choices", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id":"x","choices":[]}`)
+		}},
+		{"invalid JSON", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<html>`)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.handler)
+			if _, err := client.GenerateContent(context.Background(), "Build a page", common.GenerationParams{}); err == nil {
+				t.Error("GenerateContent() succeeded, want an error")
+			}
+		})
+	}
+
+	t.Run("token error", func(t *testing.T) {
+		called := false
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) { called = true })
+		client.tokenSrc = func() (string, error) { return "", errors.New("no credentials") }
+		if _, err := client.GenerateContent(context.Background(), "Build a page", common.GenerationParams{}); err == nil {
+			t.Error("GenerateContent() succeeded without a token")
+		}
+		if called {
+			t.Error("request sent without a token")
+		}
+	})
+}
+
+// writeEvents writes raw server-sent events
+func writeEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		fmt.Fprint(w, event)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestGenerateContentStream(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if req := decodeChatRequest(t, r); !req.Stream {
+			t.Error("streaming request didn't ask for a stream")
+		}
+		if got := r.Header.Get("Accept"); got != "text/event-stream" {
+			t.Errorf("Accept = %q", got)
+		}
+		writeEvents(w,
+			": keep-alive\n\n",
+			`data: {"choices":[{"delta":{"role":"assistant","reasoning_content":"Planning"}}]}`+"\n\n",
+			`data: {"choices":[{"delta":{"content":"<h1>"}}]}`+"\r\n\r\n",
+			"data: not json\n\n",
+			`data: {"choices":[]}`+"\n\n",
+			`data: {"choices":[{"delta":{"content":"Hi</h1>"},"finish_reason":"stop"}]}`+"\n\n",
+			"data: [DONE]\n\n",
+			`data: {"choices":[{"delta":{"content":"after done"}}]}`+"\n\n",
+		)
+	})
+
+	var events []StreamEvent
+	err := client.GenerateContentStream(context.Background(), "Build a page", common.GenerationParams{}, func(e StreamEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("GenerateContentStream() error = %v", err)
+	}
+
+	want := []StreamEvent{
+		{Type: "thinking", Content: "Planning"},
+		{Type: "content", Content: "<h1>"},
+		{Type: "content", Content: "Hi</h1>"},
+	}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestGenerateContentStreamErrors(t *testing.T) {
+	t.Run("server error", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "quota", http.StatusTooManyRequests)
+		})
+		err := client.GenerateContentStream(context.Background(), "p", common.GenerationParams{}, func(StreamEvent) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "429") {
+			t.Errorf("error = %v, want the status", err)
+		}
+	})
+
+	t.Run("event too large", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			writeEvents(w, "data: "+strings.Repeat("x", 1024)+"\n\n")
+		})
+		client.cfg.StreamMaxEventBytes = 256
+		err := client.GenerateContentStream(context.Background(), "p", common.GenerationParams{}, func(StreamEvent) error { return nil })
+		var tooLarge *EventTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != 256 {
+			t.Errorf("error = %v, want EventTooLargeError", err)
+		}
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			writeEvents(w,
+				`data: {"choices":[{"delta":{"content":"a"}}]}`+"\n\n",
+				`data: {"choices":[{"delta":{"content":"b"}}]}`+"\n\n",
+			)
+		})
+		stop := errors.New("client went away")
+		calls := 0
+		err := client.GenerateContentStream(context.Background(), "p", common.GenerationParams{}, func(StreamEvent) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("error = %v after %d calls, want the callback's error after 1", err, calls)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			writeEvents(w, `data: {"choices":[{"delta":{"content":"a"}}]}`+"\n\n")
+			<-r.Context().Done()
+		})
+		err := client.GenerateContentStream(ctx, "p", common.GenerationParams{}, func(StreamEvent) error {
+			cancel()
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want context.Canceled", err)
+		}
+	})
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"awning-backend/common"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	MAX_REHOST_IMAGE_BYTES = 15 << 20
+	GCS_SCOPE              = "https://www.googleapis.com/auth/devstorage.read_write"
+	GCS_UPLOAD_ENDPOINT    = "https://storage.googleapis.com/upload/storage/v1/b"
+)
+
+var (
+	ErrImageStoreNotConfigured = errors.New("image store not configured")
+	ErrImageListUnsupported    = errors.New("image store does not support listing")
+)
+
+// ImageStore stores image bytes and returns a stable public URL
+type ImageStore interface {
+	Name() string
+	Put(ctx context.Context, objectPath string, contentType string, data []byte) (string, error)
+}
+
+// ImageLister is implemented by stores that can enumerate and delete objects
+type ImageLister interface {
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, objectPath string) error
+}
+
+// NewImageStoreFromConfig creates the configured image store, or returns nil
+// when rehosting is disabled
+func NewImageStoreFromConfig(ctx context.Context, cfg *common.Config, credData []byte) (ImageStore, error) {
+	switch cfg.ImageStore {
+	case "":
+		return nil, nil
+	case "local":
+		return NewLocalImageStore(cfg.ImageStoreLocalDir, cfg.ImageStorePublicBaseURL)
+	case "gcs":
+		return NewGCSImageStore(ctx, credData, cfg.ImageStoreBucket, cfg.ImageStorePublicBaseURL)
+	default:
+		return nil, fmt.Errorf("unknown image store: %s", cfg.ImageStore)
+	}
+}
+
+type tenantSchemaCtxKey struct{}
+
+// WithTenantSchema attaches the tenant schema to the context so processors can
+// scope stored objects to the tenant
+func WithTenantSchema(ctx context.Context, tenantSchema string) context.Context {
+	return context.WithValue(ctx, tenantSchemaCtxKey{}, tenantSchema)
+}
+
+// TenantSchemaFromContext returns the tenant schema set by WithTenantSchema
+func TenantSchemaFromContext(ctx context.Context) (string, bool) {
+	schema, ok := ctx.Value(tenantSchemaCtxKey{}).(string)
+	return schema, ok && schema != ""
+}
+
+// ImageObjectPrefix returns the storage prefix for a tenant's images
+func ImageObjectPrefix(tenantSchema string) string {
+	if tenantSchema == "" {
+		tenantSchema = "shared"
+	}
+	return path.Join("tenants", tenantSchema, "images")
+}
+
+// ImageRehoster downloads remote images and copies them into an ImageStore
+type ImageRehoster struct {
+	logger     *slog.Logger
+	store      ImageStore
+	httpClient *http.Client
+}
+
+// NewImageRehoster creates a new image rehoster
+func NewImageRehoster(store ImageStore) *ImageRehoster {
+	return &ImageRehoster{
+		logger:     slog.With("service", "ImageRehoster"),
+		store:      store,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Rehost downloads srcURL and stores it under the tenant's prefix with a
+// content hash filename, returning the public URL
+func (r *ImageRehoster) Rehost(ctx context.Context, tenantSchema string, srcURL string) (string, error) {
+	if r == nil || r.store == nil {
+		return "", ErrImageStoreNotConfigured
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srcURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download image: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MAX_REHOST_IMAGE_BYTES+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read image: %w", err)
+	}
+	if len(data) > MAX_REHOST_IMAGE_BYTES {
+		return "", fmt.Errorf("image exceeds %d bytes", MAX_REHOST_IMAGE_BYTES)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+		if !strings.HasPrefix(contentType, "image/") {
+			return "", fmt.Errorf("unexpected content type: %s", contentType)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	objectPath := path.Join(ImageObjectPrefix(tenantSchema), hex.EncodeToString(sum[:])+imageExtension(contentType))
+
+	publicURL, err := r.store.Put(ctx, objectPath, contentType, data)
+	if err != nil {
+		return "", err
+	}
+
+	r.logger.Debug("Rehosted image", "source", srcURL, "url", publicURL, "size", len(data))
+	return publicURL, nil
+}
+
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	case "image/avif":
+		return ".avif"
+	}
+	return ""
+}
+
+func joinPublicURL(base, objectPath string) string {
+	return strings.TrimRight(base, "/") + "/" + objectPath
+}
+
+// LocalImageStore stores images in a local directory (development)
+type LocalImageStore struct {
+	dir           string
+	publicBaseURL string
+}
+
+// NewLocalImageStore creates a new local directory image store
+func NewLocalImageStore(dir, publicBaseURL string) (*LocalImageStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("image store directory is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create image store directory: %w", err)
+	}
+	return &LocalImageStore{dir: dir, publicBaseURL: publicBaseURL}, nil
+}
+
+func (s *LocalImageStore) Name() string {
+	return "local"
+}
+
+// Dir returns the directory images are written to
+func (s *LocalImageStore) Dir() string {
+	return s.dir
+}
+
+func (s *LocalImageStore) Put(_ context.Context, objectPath string, _ string, data []byte) (string, error) {
+	fullPath := filepath.Join(s.dir, filepath.FromSlash(objectPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create image directory: %w", err)
+	}
+
+	// Content-addressed, so an existing file already has these bytes
+	if _, err := os.Stat(fullPath); err != nil {
+		if err := os.WriteFile(fullPath, data, 0644); err != nil {
+			return "", fmt.Errorf("failed to write image: %w", err)
+		}
+	}
+
+	return joinPublicURL(s.publicBaseURL, objectPath), nil
+}
+
+func (s *LocalImageStore) List(_ context.Context, prefix string) ([]string, error) {
+	root := filepath.Join(s.dir, filepath.FromSlash(prefix))
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	return paths, nil
+}
+
+func (s *LocalImageStore) Delete(_ context.Context, objectPath string) error {
+	if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(objectPath))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete image: %w", err)
+	}
+	return nil
+}
+
+// GCSImageStore stores images in a Google Cloud Storage bucket
+type GCSImageStore struct {
+	bucket        string
+	publicBaseURL string
+	tokenSource   oauth2.TokenSource
+	httpClient    *http.Client
+}
+
+// NewGCSImageStore creates a new GCS image store using service account credentials
+func NewGCSImageStore(ctx context.Context, credData []byte, bucket, publicBaseURL string) (*GCSImageStore, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("image store bucket is required")
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, credData, GCS_SCOPE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credentials: %w", err)
+	}
+
+	if publicBaseURL == "" {
+		publicBaseURL = "https://storage.googleapis.com/" + bucket
+	}
+
+	return &GCSImageStore{
+		bucket:        bucket,
+		publicBaseURL: publicBaseURL,
+		tokenSource:   creds.TokenSource,
+		httpClient:    &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (s *GCSImageStore) Name() string {
+	return "gcs"
+}
+
+func (s *GCSImageStore) Put(ctx context.Context, objectPath string, contentType string, data []byte) (string, error) {
+	token, err := s.tokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/%s/o?uploadType=media&name=%s", GCS_UPLOAD_ENDPOINT, url.PathEscape(s.bucket), url.QueryEscape(objectPath))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to upload image: status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return joinPublicURL(s.publicBaseURL, objectPath), nil
+}
+
+// CleanupOrphanedImages deletes a tenant's stored images whose paths are not
+// in referenced. Only stores implementing ImageLister are supported for now.
+func CleanupOrphanedImages(ctx context.Context, store ImageStore, tenantSchema string, referenced map[string]struct{}) (int, error) {
+	lister, ok := store.(ImageLister)
+	if !ok {
+		return 0, ErrImageListUnsupported
+	}
+
+	paths, err := lister.List(ctx, ImageObjectPrefix(tenantSchema))
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, p := range paths {
+		if _, ok := referenced[p]; ok {
+			continue
+		}
+		if err := lister.Delete(ctx, p); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// UploadedImage is an image the tenant uploaded themselves
+type UploadedImage struct {
+	ID       uint
+	URL      string
+	Alt      string
+	Width    int
+	Height   int
+	Keywords []string
+}
+
+// UploadedImageSource lists a tenant's uploaded images so the image processor
+// can prefer them over stock photos
+type UploadedImageSource interface {
+	UploadedImages(ctx context.Context, tenantSchema string) ([]UploadedImage, error)
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// testPNG is the 8-byte PNG signature, enough for content sniffing
+var testPNG = []byte("\x89PNG\r\n\x1a\n")
+
+// newTestImageServer serves testPNG at /photo.png and, as a generic
+// octet-stream, at /sniffed, text at /text and 404 elsewhere
+func newTestImageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Header().Set("Content-Type", "image/png; charset=binary")
+			w.Write(testPNG)
+		case "/sniffed":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(testPNG)
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("not an image"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestLocalStore(t *testing.T) *LocalImageStore {
+	t.Helper()
+
+	store, err := NewLocalImageStore(filepath.Join(t.TempDir(), "images"), "https://cdn.example.com/media/")
+	if err != nil {
+		t.Fatalf("NewLocalImageStore() error = %v", err)
+	}
+	return store
+}
+
+func TestRehostStoresByContentHash(t *testing.T) {
+	server := newTestImageServer(t)
+	store := newTestLocalStore(t)
+	rehoster := NewImageRehoster(store)
+	ctx := context.Background()
+
+	sum := sha256.Sum256(testPNG)
+	hash := hex.EncodeToString(sum[:])
+	tests := []struct {
+		tenant, src, wantPath string
+	}{
+		{"tenant_a", server.URL + "/photo.png", "tenants/tenant_a/images/" + hash + ".png"},
+		// The same bytes from another URL land on the same object
+		{"tenant_a", server.URL + "/sniffed", "tenants/tenant_a/images/" + hash + ".png"},
+		{"tenant_b", server.URL + "/photo.png", "tenants/tenant_b/images/" + hash + ".png"},
+		{"", server.URL + "/photo.png", "tenants/shared/images/" + hash + ".png"},
+	}
+	for _, tt := range tests {
+		got, err := rehoster.Rehost(ctx, tt.tenant, tt.src)
+		if err != nil {
+			t.Fatalf("Rehost(%q, %q) error = %v", tt.tenant, tt.src, err)
+		}
+		if want := "https://cdn.example.com/media/" + tt.wantPath; got != want {
+			t.Errorf("Rehost(%q, %q) = %q, want %q", tt.tenant, tt.src, got, want)
+		}
+		data, err := os.ReadFile(filepath.Join(store.Dir(), filepath.FromSlash(tt.wantPath)))
+		if err != nil || string(data) != string(testPNG) {
+			t.Errorf("stored %s = %q, %v; want the downloaded bytes", tt.wantPath, data, err)
+		}
+	}
+
+	paths, err := store.List(ctx, "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Errorf("store has %q, want one object per tenant", paths)
+	}
+}
+
+func TestRehostFailures(t *testing.T) {
+	server := newTestImageServer(t)
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+
+	for _, src := range []string{server.URL + "/missing.png", server.URL + "/text", "http://127.0.0.1:0/photo.png"} {
+		if got, err := NewImageRehoster(store).Rehost(ctx, "tenant_a", src); err == nil {
+			t.Errorf("Rehost(%q) = %q, want an error", src, got)
+		}
+	}
+	if paths, _ := store.List(ctx, "tenants"); len(paths) != 0 {
+		t.Errorf("failed rehosts stored %q", paths)
+	}
+
+	var unconfigured *ImageRehoster
+	if _, err := unconfigured.Rehost(ctx, "tenant_a", server.URL+"/photo.png"); !errors.Is(err, ErrImageStoreNotConfigured) {
+		t.Errorf("Rehost() without a store error = %v, want ErrImageStoreNotConfigured", err)
+	}
+}
+
+func TestCleanupOrphanedImages(t *testing.T) {
+	store := newTestLocalStore(t)
+	ctx := context.Background()
+
+	for _, p := range []string{
+		"tenants/tenant_a/images/kept.png",
+		"tenants/tenant_a/images/orphan.png",
+		"tenants/tenant_a/images/old/orphan.jpg",
+		"tenants/tenant_b/images/other.png",
+	} {
+		if _, err := store.Put(ctx, p, "image/png", testPNG); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	referenced := map[string]struct{}{"tenants/tenant_a/images/kept.png": {}}
+	deleted, err := CleanupOrphanedImages(ctx, store, "tenant_a", referenced)
+	if err != nil {
+		t.Fatalf("CleanupOrphanedImages() error = %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("CleanupOrphanedImages() = %d, want 2", deleted)
+	}
+
+	remaining, err := store.List(ctx, "tenants")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(remaining)
+	want := []string{"tenants/tenant_a/images/kept.png", "tenants/tenant_b/images/other.png"}
+	if !slices.Equal(remaining, want) {
+		t.Errorf("remaining = %q, want %q", remaining, want)
+	}
+
+	// Deleting what's already gone is not an error
+	if err := store.Delete(ctx, "tenants/tenant_a/images/orphan.png"); err != nil {
+		t.Errorf("Delete() of a missing object error = %v", err)
+	}
+}
+
+// putOnlyStore can't list, so orphans can't be found in it
+type putOnlyStore struct{}
+
+func (putOnlyStore) Name() string { return "put-only" }
+func (putOnlyStore) Put(ctx context.Context, objectPath, contentType string, data []byte) (string, error) {
+	return objectPath, nil
+}
+
+func TestCleanupOrphanedImagesUnsupported(t *testing.T) {
+	if _, err := CleanupOrphanedImages(context.Background(), putOnlyStore{}, "tenant_a", nil); !errors.Is(err, ErrImageListUnsupported) {
+		t.Errorf("CleanupOrphanedImages() error = %v, want ErrImageListUnsupported", err)
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/services/ai"
+	"awning-backend/utils"
+)
+
+const (
+	// MOCK_CONTENT_FILE is the mock response used when no more specific
+	// file in MOCK_CONTENT_DIR matches
+	MOCK_CONTENT_FILE = ".config/mock_content.txt"
+	MOCK_CONTENT_DIR  = ".config/mocks"
+)
+
+// mockThinking is the reasoning streamed before a mock response when
+// thinking is sent, so clients can exercise their thinking display
+var mockThinking = []string{
+	"Reading the request and the business details. ",
+	"Choosing the sections the page needs. ",
+	"Writing the HTML for each section.",
+}
+
+// MockRequest is what a mock response is picked and filled in for
+type MockRequest struct {
+	Stage          model.ChatStage
+	Keywords       []string
+	OnboardingData *model.OnboardingData
+	Variables      map[string]string
+}
+
+// MockResponder stands in for the model with mock_response on. Both chat
+// servers load and stream mock responses through it, so they behave alike.
+type MockResponder struct {
+	cfg         *common.Config
+	dir         string
+	defaultFile string
+}
+
+// NewMockResponder creates a mock responder reading the standard mock files
+func NewMockResponder(cfg *common.Config) *MockResponder {
+	return &MockResponder{cfg: cfg, dir: MOCK_CONTENT_DIR, defaultFile: MOCK_CONTENT_FILE}
+}
+
+// candidates lists the files a request's mock may come from, most specific
+// first
+func (m *MockResponder) candidates(req MockRequest) []string {
+	keywordPart := strings.Join(req.Keywords, "_")
+	var files []string
+	if req.Stage != "" && keywordPart != "" {
+		files = append(files, filepath.Join(m.dir, fmt.Sprintf("mock_%s_%s.html", req.Stage, keywordPart)))
+	}
+	if keywordPart != "" {
+		files = append(files, filepath.Join(m.dir, fmt.Sprintf("mock_content_%s.html", keywordPart)))
+	}
+	if req.Stage != "" {
+		files = append(files, filepath.Join(m.dir, fmt.Sprintf("mock_%s.html", req.Stage)))
+	}
+	return append(files, m.defaultFile)
+}
+
+// Load returns the mock content for the request, with its {{key}}
+// placeholders filled from the onboarding data and variables like a
+// prompt's. Files are tried in order: mock_<stage>_<keywords>.html,
+// mock_content_<keywords>.html, mock_<stage>.html, then MOCK_CONTENT_FILE.
+// It reports false when none exists.
+func (m *MockResponder) Load(req MockRequest) (string, bool, error) {
+	for _, file := range m.candidates(req) {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		slog.Info("Using mock response", "file", file)
+		return utils.ReplaceVariables(string(data), req.OnboardingData, req.Variables), true, nil
+	}
+	return "", false, nil
+}
+
+// Stream sends content to callback the way the model streams a reply:
+// thinking events first when thinking is sent, then after mock_latency_ms
+// the content in content events of mock_chunk_size characters,
+// mock_chunk_delay_ms apart. The events are the same for the same content,
+// only their timing is simulated. It stops with ctx's error.
+func (m *MockResponder) Stream(ctx context.Context, content string, callback ai.StreamCallback) error {
+	if m.cfg.GetThinkingMode() != common.THINKING_MODE_OFF {
+		for _, thought := range mockThinking {
+			if err := callback(ai.StreamEvent{Type: "thinking", Content: thought}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := sleepContext(ctx, time.Duration(m.cfg.MockLatencyMs)*time.Millisecond); err != nil {
+		return err
+	}
+
+	delay := time.Duration(m.cfg.MockChunkDelayMs) * time.Millisecond
+	for i, chunk := range MockChunks(content, m.cfg.MockChunkSize) {
+		if i > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return err
+			}
+		}
+		if err := callback(ai.StreamEvent{Type: "content", Content: chunk}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MockChunks splits content into chunks of size characters, never inside
+// a UTF-8 sequence. A size below 1 keeps the content in one chunk.
+func MockChunks(content string, size int) []string {
+	if content == "" {
+		return nil
+	}
+	if size < 1 {
+		return []string{content}
+	}
+
+	var chunks []string
+	runes := []rune(content)
+	for start := 0; start < len(runes); start += size {
+		chunks = append(chunks, string(runes[start:min(start+size, len(runes))]))
+	}
+	return chunks
+}
+
+// sleepContext waits for d, or returns ctx's error if it's done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
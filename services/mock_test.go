@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/services/ai"
+)
+
+// newTestMockResponder returns a mock responder reading files from a
+// temporary directory, written from files by name
+func newTestMockResponder(t *testing.T, cfg *common.Config, files map[string]string) *MockResponder {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &MockResponder{cfg: cfg, dir: dir, defaultFile: filepath.Join(dir, "mock_content.txt")}
+}
+
+func TestMockResponderLoad(t *testing.T) {
+	m := newTestMockResponder(t, common.DefaultConfig(), map[string]string{
+		"mock_initial_creation_bakery.html": "stage and keywords",
+		"mock_content_bakery.html":          "keywords",
+		"mock_content_cafe.html":            "cafe keywords",
+		"mock_update.html":                  "stage",
+		"mock_content.txt":                  "default",
+	})
+
+	tests := []struct {
+		name string
+		req  MockRequest
+		want string
+	}{
+		{"stage and keywords", MockRequest{Stage: model.ChatStageInitialCreation, Keywords: []string{"bakery"}}, "stage and keywords"},
+		{"keywords over stage", MockRequest{Stage: model.ChatStageUserInput, Keywords: []string{"cafe"}}, "cafe keywords"},
+		{"keywords without a stage file", MockRequest{Stage: model.ChatStageUserInput, Keywords: []string{"bakery"}}, "keywords"},
+		{"stage", MockRequest{Stage: model.ChatStageUserInput, Keywords: []string{"florist"}}, "stage"},
+		{"default", MockRequest{Stage: model.ChatStageInitialCreation}, "default"},
+		{"nothing", MockRequest{}, "default"},
+	}
+	for _, tt := range tests {
+		got, ok, err := m.Load(tt.req)
+		if err != nil || !ok || got != tt.want {
+			t.Errorf("%s: Load() = %q, %v, %v; want %q", tt.name, got, ok, err, tt.want)
+		}
+	}
+
+	empty := newTestMockResponder(t, common.DefaultConfig(), nil)
+	if got, ok, err := empty.Load(MockRequest{Keywords: []string{"bakery"}}); ok || err != nil || got != "" {
+		t.Errorf("Load() without mock files = %q, %v, %v; want none", got, ok, err)
+	}
+}
+
+func TestMockResponderLoadVariables(t *testing.T) {
+	m := newTestMockResponder(t, common.DefaultConfig(), map[string]string{
+		"mock_content.txt": "<h1>{{businessName}}</h1><p>{{selectedMotif}}</p><p>{{opening_hours}}</p><p>{{unknown}}</p>",
+	})
+
+	got, _, err := m.Load(MockRequest{
+		OnboardingData: &model.OnboardingData{BusinessName: "Rye & Co", SelectedMotif: "rustic"},
+		Variables:      map[string]string{"opening_hours": "7am to 3pm"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<h1>Rye & Co</h1><p>rustic</p><p>7am to 3pm</p><p>{{unknown}}</p>"; got != want {
+		t.Errorf("Load() = %q, want %q", got, want)
+	}
+
+	// Variables win over onboarding data, and either may be missing
+	got, _, _ = m.Load(MockRequest{
+		OnboardingData: &model.OnboardingData{BusinessName: "Rye & Co"},
+		Variables:      map[string]string{"businessName": "Crumb"},
+	})
+	if !strings.HasPrefix(got, "<h1>Crumb</h1>") {
+		t.Errorf("Load() = %q, want the variable's business name", got)
+	}
+	if got, _, _ := m.Load(MockRequest{}); !strings.HasPrefix(got, "<h1>{{businessName}}</h1>") {
+		t.Errorf("Load() without data = %q, want the placeholders kept", got)
+	}
+}
+
+// collectEvents streams content through m and returns the events as
+// type:content strings
+func collectEvents(t *testing.T, m *MockResponder, ctx context.Context, content string) ([]string, error) {
+	t.Helper()
+
+	var events []string
+	err := m.Stream(ctx, content, func(event ai.StreamEvent) error {
+		events = append(events, event.Type+":"+event.Content)
+		return nil
+	})
+	return events, err
+}
+
+func TestMockResponderStream(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.ThinkingMode = common.THINKING_MODE_OFF
+	cfg.MockChunkSize = 4
+	m := newTestMockResponder(t, cfg, nil)
+
+	events, err := collectEvents(t, m, context.Background(), "<h1>Café</h1>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"content:<h1>", "content:Café", "content:</h1", "content:>"}
+	if strings.Join(events, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+
+	// Thinking comes first when it's sent, and the events don't change
+	// from one stream to the next
+	cfg.ThinkingMode = common.THINKING_MODE_FULL
+	first, _ := collectEvents(t, m, context.Background(), "<h1>Café</h1>")
+	second, _ := collectEvents(t, m, context.Background(), "<h1>Café</h1>")
+	if len(first) != len(mockThinking)+len(want) || !strings.HasPrefix(first[0], "thinking:") || first[len(mockThinking)] != want[0] {
+		t.Errorf("events with thinking = %q", first)
+	}
+	if strings.Join(first, "|") != strings.Join(second, "|") {
+		t.Errorf("events differ between streams: %q and %q", first, second)
+	}
+
+	// A chunk size of 0 sends the page whole
+	cfg.ThinkingMode = common.THINKING_MODE_OFF
+	cfg.MockChunkSize = 0
+	if events, _ := collectEvents(t, m, context.Background(), "<h1>Café</h1>"); len(events) != 1 || events[0] != "content:<h1>Café</h1>" {
+		t.Errorf("events with chunk size 0 = %q", events)
+	}
+}
+
+func TestMockResponderStreamLatency(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.ThinkingMode = common.THINKING_MODE_OFF
+	cfg.MockLatencyMs = 30
+	cfg.MockChunkSize = 2
+	cfg.MockChunkDelayMs = 10
+	m := newTestMockResponder(t, cfg, nil)
+
+	start := time.Now()
+	events, err := collectEvents(t, m, context.Background(), "abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); len(events) != 3 || elapsed < 50*time.Millisecond {
+		t.Errorf("streamed %d events in %v, want 3 after at least 50ms", len(events), elapsed)
+	}
+
+	// Cancelling stops the stream during the simulated latency
+	cfg.MockLatencyMs = 10000
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	events, err = collectEvents(t, m, ctx, "abcdef")
+	if !errors.Is(err, context.DeadlineExceeded) || len(events) != 0 {
+		t.Errorf("Stream() cancelled = %q, %v; want no events and the context's error", events, err)
+	}
+}
+
+func TestMockChunks(t *testing.T) {
+	tests := []struct {
+		content string
+		size    int
+		want    []string
+	}{
+		{"", 4, nil},
+		{"abc", 0, []string{"abc"}},
+		{"abc", -1, []string{"abc"}},
+		{"abcdef", 4, []string{"abcd", "ef"}},
+		{"abcd", 4, []string{"abcd"}},
+		{"ñandú", 2, []string{"ña", "nd", "ú"}},
+		{"🥐🥖", 1, []string{"🥐", "🥖"}},
+	}
+	for _, tt := range tests {
+		got := MockChunks(tt.content, tt.size)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("MockChunks(%q, %d) = %q, want %q", tt.content, tt.size, got, tt.want)
+		}
+	}
+}
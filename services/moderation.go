@@ -0,0 +1,203 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"awning-backend/common"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	MODERATION_SCOPE = "https://www.googleapis.com/auth/cloud-platform"
+
+	// Moderation modes. Off skips the check, flag records matches on the chat
+	// and block rejects the message.
+	ModerationModeOff   = "off"
+	ModerationModeFlag  = "flag"
+	ModerationModeBlock = "block"
+)
+
+// ModerationResult is the verdict for a set of texts. Category and Match are
+// only set when Flagged is true.
+type ModerationResult struct {
+	Flagged  bool   `json:"flagged"`
+	Category string `json:"category,omitempty"`
+	Match    string `json:"match,omitempty"`
+}
+
+// ModerationService checks user-supplied text before it is sent to the model
+type ModerationService interface {
+	Name() string
+	Check(ctx context.Context, texts []string) (*ModerationResult, error)
+}
+
+// NewModerationServiceFromConfig creates the configured moderation service, or
+// returns nil when moderation is disabled
+func NewModerationServiceFromConfig(ctx context.Context, cfg *common.Config, credData []byte) (ModerationService, error) {
+	if !cfg.ModerationEnabled {
+		return nil, nil
+	}
+	switch cfg.ModerationProvider {
+	case "", "denylist":
+		return NewDenylistModerator(cfg.ModerationRules)
+	case "endpoint":
+		return NewEndpointModerator(ctx, credData, cfg.ModerationEndpointURL)
+	default:
+		return nil, fmt.Errorf("unknown moderation provider: %s", cfg.ModerationProvider)
+	}
+}
+
+type denylistRule struct {
+	category string
+	pattern  *regexp.Regexp
+}
+
+// DenylistModerator flags text matching any of the configured patterns
+type DenylistModerator struct {
+	rules []denylistRule
+}
+
+// NewDenylistModerator compiles the rules. Patterns are regular expressions;
+// plain keywords work as-is and can be made case-insensitive with (?i).
+func NewDenylistModerator(rules []common.ModerationRule) (*DenylistModerator, error) {
+	m := &DenylistModerator{}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation pattern %q: %w", rule.Pattern, err)
+		}
+		category := rule.Category
+		if category == "" {
+			category = "denylist"
+		}
+		m.rules = append(m.rules, denylistRule{category: category, pattern: re})
+	}
+	return m, nil
+}
+
+func (m *DenylistModerator) Name() string {
+	return "denylist"
+}
+
+// Check returns the first rule, in config order, matching any of the texts
+func (m *DenylistModerator) Check(_ context.Context, texts []string) (*ModerationResult, error) {
+	for _, rule := range m.rules {
+		for _, text := range texts {
+			if match := rule.pattern.FindString(text); match != "" {
+				return &ModerationResult{Flagged: true, Category: rule.category, Match: match}, nil
+			}
+		}
+	}
+	return &ModerationResult{}, nil
+}
+
+// EndpointModerator sends texts to a moderation model endpoint speaking the
+// OpenAI moderations format, authenticated with the Vertex service account
+type EndpointModerator struct {
+	endpoint    string
+	tokenSource oauth2.TokenSource
+	httpClient  *http.Client
+}
+
+// NewEndpointModerator creates a moderator calling endpoint
+func NewEndpointModerator(ctx context.Context, credData []byte, endpoint string) (*EndpointModerator, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("moderation endpoint URL is required")
+	}
+
+	creds, err := google.CredentialsFromJSON(ctx, credData, MODERATION_SCOPE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credentials: %w", err)
+	}
+
+	return &EndpointModerator{
+		endpoint:    endpoint,
+		tokenSource: creds.TokenSource,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (m *EndpointModerator) Name() string {
+	return "endpoint"
+}
+
+type moderationRequest struct {
+	Input []string `json:"input"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+func (m *EndpointModerator) Check(ctx context.Context, texts []string) (*ModerationResult, error) {
+	token, err := m.tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	body, err := json.Marshal(moderationRequest{Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode moderation request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("moderation request failed: status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result moderationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode moderation response: %w", err)
+	}
+
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		// Report the highest scoring flagged category
+		var categories []string
+		for name, flagged := range r.Categories {
+			if flagged {
+				categories = append(categories, name)
+			}
+		}
+		sort.Strings(categories)
+		sort.SliceStable(categories, func(i, j int) bool {
+			return r.CategoryScores[categories[i]] > r.CategoryScores[categories[j]]
+		})
+		category := "flagged"
+		if len(categories) > 0 {
+			category = categories[0]
+		}
+		return &ModerationResult{Flagged: true, Category: category}, nil
+	}
+
+	return &ModerationResult{}, nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"awning-backend/common"
+
+	"golang.org/x/oauth2"
+)
+
+func TestDenylistModerator(t *testing.T) {
+	m, err := NewDenylistModerator([]common.ModerationRule{
+		{Pattern: `(?i)\bcasino\b`, Category: "gambling"},
+		{Pattern: `buy followers`},
+		{Pattern: `\b\d{3}-\d{2}-\d{4}\b`, Category: "pii"},
+	})
+	if err != nil {
+		t.Fatalf("NewDenylistModerator() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		texts []string
+		want  ModerationResult
+	}{
+		{"clean", []string{"A page for my bakery"}, ModerationResult{}},
+		{"keyword", []string{"Online CASINO bonuses"}, ModerationResult{Flagged: true, Category: "gambling", Match: "CASINO"}},
+		{"word boundary", []string{"Casinos near the beach"}, ModerationResult{}},
+		{"default category", []string{"We buy followers cheap"}, ModerationResult{Flagged: true, Category: "denylist", Match: "buy followers"}},
+		{"case sensitive without (?i)", []string{"Buy Followers"}, ModerationResult{}},
+		{"regex", []string{"My SSN is 123-45-6789"}, ModerationResult{Flagged: true, Category: "pii", Match: "123-45-6789"}},
+		{"later text", []string{"A bakery", "with a casino"}, ModerationResult{Flagged: true, Category: "gambling", Match: "casino"}},
+		{"rule order wins over text order", []string{"buy followers", "casino"}, ModerationResult{Flagged: true, Category: "gambling", Match: "casino"}},
+		{"no texts", nil, ModerationResult{}},
+	}
+	for _, tt := range tests {
+		got, err := m.Check(context.Background(), tt.texts)
+		if err != nil {
+			t.Fatalf("%s: Check() error = %v", tt.name, err)
+		}
+		if *got != tt.want {
+			t.Errorf("%s: Check(%q) = %+v, want %+v", tt.name, tt.texts, *got, tt.want)
+		}
+	}
+}
+
+func TestNewDenylistModeratorInvalidPattern(t *testing.T) {
+	if _, err := NewDenylistModerator([]common.ModerationRule{{Pattern: "(unclosed"}}); err == nil {
+		t.Error("NewDenylistModerator() with an invalid pattern error = nil")
+	}
+}
+
+func TestNewModerationServiceFromConfig(t *testing.T) {
+	cfg := common.DefaultConfig()
+	if m, err := NewModerationServiceFromConfig(context.Background(), cfg, nil); m != nil || err != nil {
+		t.Errorf("disabled moderation = %v, %v; want nil, nil", m, err)
+	}
+
+	cfg.ModerationEnabled = true
+	m, err := NewModerationServiceFromConfig(context.Background(), cfg, nil)
+	if err != nil || m == nil || m.Name() != "denylist" {
+		t.Errorf("default provider = %v, %v; want the denylist", m, err)
+	}
+
+	cfg.ModerationProvider = "nope"
+	if _, err := NewModerationServiceFromConfig(context.Background(), cfg, nil); err == nil {
+		t.Error("unknown provider error = nil")
+	}
+}
+
+func TestEndpointModerator(t *testing.T) {
+	var got moderationRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		flagged := got.Input[0] == "bad"
+		json.NewEncoder(w).Encode(map[string]any{"results": []map[string]any{{
+			"flagged":         flagged,
+			"categories":      map[string]bool{"violence": flagged, "harassment": flagged, "sexual": false},
+			"category_scores": map[string]float64{"violence": 0.4, "harassment": 0.9, "sexual": 0.95},
+		}}})
+	}))
+	defer server.Close()
+
+	m := &EndpointModerator{
+		endpoint:    server.URL,
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}),
+		httpClient:  server.Client(),
+	}
+
+	result, err := m.Check(context.Background(), []string{"bad", "onboarding text"})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	// The highest scoring category that is flagged
+	if !result.Flagged || result.Category != "harassment" {
+		t.Errorf("Check() = %+v, want flagged as harassment", result)
+	}
+	if len(got.Input) != 2 {
+		t.Errorf("request input = %q, want both texts", got.Input)
+	}
+
+	result, err = m.Check(context.Background(), []string{"fine"})
+	if err != nil || result.Flagged {
+		t.Errorf("Check() of clean text = %+v, %v", result, err)
+	}
+
+	m.tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "wrong"})
+	if _, err := m.Check(context.Background(), []string{"fine"}); err == nil {
+		t.Error("Check() with a rejected token error = nil")
+	}
+}
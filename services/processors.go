@@ -2,13 +2,50 @@ package services
 
 import (
 	"awning-backend/common"
+	"awning-backend/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 type Processors struct {
 	logger       *slog.Logger
 	cfg          *common.Config
 	processorMap map[string]common.Processor
+	gates        map[string][]processorGate
+	hooks        ProcessorHooks
+}
+
+// ProcessorHooks receive processor metrics. Any field may be nil.
+type ProcessorHooks struct {
+	// ProcessorFailed is called for every failed processor run, with the
+	// failure kind from common.ProcessorFailure*. Sections are processed
+	// concurrently, so it may be called from several goroutines.
+	ProcessorFailed func(name, failure string, err error)
+}
+
+// ErrProcessorTimeout fails a processor still running after
+// processor_timeout_seconds
+var ErrProcessorTimeout = errors.New("processor timed out")
+
+// ErrProcessorPanic fails a processor that panicked
+var ErrProcessorPanic = errors.New("processor panicked")
+
+// processorGate lets a processor run only while allow returns true
+type processorGate struct {
+	allow  func(ctx context.Context) bool
+	reason string
 }
 
 func NewProcessors(cfg *common.Config) *Processors {
@@ -20,6 +57,7 @@ func NewProcessors(cfg *common.Config) *Processors {
 		logger:       logger,
 		cfg:          cfg,
 		processorMap: processorMap,
+		gates:        make(map[string][]processorGate),
 	}
 }
 
@@ -33,12 +71,520 @@ func (p *Processors) GetProcessor(name string) (common.Processor, bool) {
 	return processor, exists
 }
 
+// SetHooks sets the hooks called as processors run
+func (p *Processors) SetHooks(hooks ProcessorHooks) {
+	p.hooks = hooks
+}
+
+// AddGate makes the processor registered under name run only while allow
+// returns true, as well as any gates added before. Skipped runs are
+// reported with the reason of the first closed gate as a warning.
+func (p *Processors) AddGate(name, reason string, allow func(ctx context.Context) bool) {
+	p.gates[name] = append(p.gates[name], processorGate{allow: allow, reason: reason})
+}
+
+// closedGate returns the first gate of the processor registered under name
+// that doesn't allow it to run
+func (p *Processors) closedGate(ctx context.Context, name string) (processorGate, bool) {
+	for _, gate := range p.gates[name] {
+		if !gate.allow(ctx) {
+			return gate, true
+		}
+	}
+	return processorGate{}, false
+}
+
+type truncatedOutputCtxKey struct{}
+
+// WithTruncatedOutput marks the content being processed as cut short by the
+// output guard, which gates may skip the heavier processors for
+func WithTruncatedOutput(ctx context.Context) context.Context {
+	return context.WithValue(ctx, truncatedOutputCtxKey{}, true)
+}
+
+// TruncatedOutput reports whether the context was made by WithTruncatedOutput
+func TruncatedOutput(ctx context.Context) bool {
+	truncated, _ := ctx.Value(truncatedOutputCtxKey{}).(bool)
+	return truncated
+}
+
+// GetEnabledProcessors returns the registered processors in the order of
+// the enabled_processors config
 func (p *Processors) GetEnabledProcessors() []common.Processor {
 	var processors []common.Processor
-	for name, processor := range p.processorMap {
-		if p.cfg.IsProcessorEnabled(name) {
+	for _, name := range p.cfg.EnabledProcessors {
+		if processor, ok := p.processorMap[name]; ok {
 			processors = append(processors, processor)
 		}
 	}
 	return processors
 }
+
+// gatedProcessors returns the enabled processors registered under the given
+// names (all of them when names is empty) in the order of the
+// enabled_processors config, leaving out those whose gate is closed. Each
+// of those gets a skipped report instead.
+func (p *Processors) gatedProcessors(ctx context.Context, names []string) ([]common.Processor, []common.ProcessorReport) {
+	var processors []common.Processor
+	var skipped []common.ProcessorReport
+	for _, name := range p.cfg.EnabledProcessors {
+		if len(names) > 0 && !slices.Contains(names, name) {
+			continue
+		}
+		processor, ok := p.processorMap[name]
+		if !ok {
+			continue
+		}
+		if gate, ok := p.closedGate(ctx, name); ok {
+			p.logger.Info("Skipping processor", "processor", processor.Name(), "reason", gate.reason)
+			skipped = append(skipped, common.ProcessorReport{
+				Name:     processor.Name(),
+				Success:  true,
+				Skipped:  true,
+				Warnings: []string{gate.reason},
+			})
+			continue
+		}
+		processors = append(processors, processor)
+	}
+	return processors, skipped
+}
+
+// Run applies the enabled processors in order. A failing processor is skipped
+// and its input passed on unchanged, as is one that panics or runs past
+// processor_timeout_seconds. progress, when set, is called with each
+// processor's name before it runs.
+func (p *Processors) Run(ctx context.Context, input string, progress func(name string)) (string, []common.ProcessorReport) {
+	processors, skipped := p.gatedProcessors(ctx, nil)
+	output, reports := p.run(ctx, processors, input, progress)
+	return output, append(reports, skipped...)
+}
+
+// RunOnly applies the enabled processors registered under the given names,
+// in the order of the enabled_processors config
+func (p *Processors) RunOnly(ctx context.Context, input string, names ...string) (string, []common.ProcessorReport) {
+	if len(names) == 0 {
+		return input, nil
+	}
+	processors, skipped := p.gatedProcessors(ctx, names)
+	output, reports := p.run(ctx, processors, input, nil)
+	return output, append(reports, skipped...)
+}
+
+// run parses the page once, applies the processors to the tree and renders
+// it once at the end, in canonical form
+func (p *Processors) run(ctx context.Context, processors []common.Processor, input string, progress func(name string)) (string, []common.ProcessorReport) {
+	root, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		p.logger.Error("Failed to parse content, leaving it unprocessed", "error", err)
+		return input, nil
+	}
+	reports := p.runNodes(ctx, processors, root, progress)
+	return renderNode(root), reports
+}
+
+// runNodes applies the processors to a parsed document in place. Each works
+// on a copy of the document that replaces it once it succeeds, so a failing
+// processor leaves it as it was. Processors that don't implement
+// common.NodeProcessor get the document rendered, and their output is
+// parsed again.
+func (p *Processors) runNodes(ctx context.Context, processors []common.Processor, root *html.Node, progress func(name string)) []common.ProcessorReport {
+	var reports []common.ProcessorReport
+
+	for _, processor := range processors {
+		name := processor.Name()
+		if progress != nil {
+			progress(name)
+		}
+
+		p.logger.Info("Applying processor", "processor", name)
+		start := time.Now()
+		// The processor gets its own copy of the page, which it may keep
+		// working on after being abandoned
+		var doc *html.Node
+		var result *common.ProcessorResult
+		var err error
+		if np, ok := processor.(common.NodeProcessor); ok {
+			doc = cloneNode(root, nil)
+			result, err = p.invoke(ctx, name, func(ctx context.Context) (*common.ProcessorResult, error) {
+				return np.ProcessNode(ctx, doc)
+			})
+		} else {
+			input := []byte(renderNode(root))
+			result, err = p.invoke(ctx, name, func(ctx context.Context) (*common.ProcessorResult, error) {
+				return common.RunProcessor(ctx, processor, input)
+			})
+			if err == nil {
+				doc, err = html.Parse(bytes.NewReader(result.Output))
+			}
+		}
+		elapsed := time.Since(start)
+		common.TimingsFromContext(ctx).Add("postprocess_"+name, elapsed)
+
+		report := common.ProcessorReport{
+			Name:       name,
+			Success:    err == nil,
+			DurationMs: elapsed.Milliseconds(),
+		}
+		if err != nil {
+			p.logger.Error("Failed to process content with processor", "processor", name, "error", err)
+			p.fail(&report, err)
+		} else {
+			adoptNode(root, doc)
+			report.Counts = result.Counts
+			report.Warnings = result.Warnings
+		}
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// ProcessedSection is one top-level <section> of the page after the
+// section-aware processors ran on it. Head holds what they added to <head>
+// for it, such as background image styles.
+type ProcessedSection struct {
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+	HTML  string `json:"html"`
+	Head  string `json:"head,omitempty"`
+}
+
+// RunIncremental is Run, but processes the page's top-level sections
+// concurrently with the processors that implement common.SubtreeProcessor,
+// calling onSection as each one finishes. The rest of the page is then
+// processed the same way, and the remaining processors run on the whole
+// document afterwards. Pages without sections fall back to Run. onSection
+// calls are serialized but may come in any order.
+func (p *Processors) RunIncremental(ctx context.Context, input string, progress func(name string), onSection func(section ProcessedSection)) (string, []common.ProcessorReport) {
+	processors, skipped := p.gatedProcessors(ctx, nil)
+	output, reports := p.runIncremental(ctx, processors, input, progress, onSection)
+	return output, append(reports, skipped...)
+}
+
+func (p *Processors) runIncremental(ctx context.Context, processors []common.Processor, input string, progress func(name string), onSection func(section ProcessedSection)) (string, []common.ProcessorReport) {
+	var subtree []common.SubtreeProcessor
+	var document []common.Processor
+	for _, processor := range processors {
+		if sp, ok := processor.(common.SubtreeProcessor); ok {
+			subtree = append(subtree, sp)
+		} else {
+			document = append(document, processor)
+		}
+	}
+	if len(subtree) == 0 {
+		return p.run(ctx, processors, input, progress)
+	}
+
+	root, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		p.logger.Warn("Failed to parse content, processing it as a whole", "error", err)
+		return p.run(ctx, processors, input, progress)
+	}
+	head := findElement(root, atom.Head)
+	sections := topLevelSections(root)
+	if head == nil || len(sections) == 0 {
+		return p.run(ctx, processors, input, progress)
+	}
+
+	if progress != nil {
+		for _, processor := range subtree {
+			progress(processor.Name())
+		}
+	}
+
+	// Detach the sections so they can be worked on apart from the page. The
+	// placeholders are found again by their marker, as processing the rest
+	// of the page replaces its nodes.
+	placeholders := make([]*html.Node, len(sections))
+	for i, section := range sections {
+		placeholders[i] = &html.Node{Type: html.CommentNode, Data: sectionMarker(i)}
+		section.Parent.InsertBefore(placeholders[i], section)
+		section.Parent.RemoveChild(section)
+	}
+
+	type sectionResult struct {
+		head    []*html.Node
+		reports []common.ProcessorReport
+	}
+	results := make([]sectionResult, len(sections))
+
+	sem := make(chan struct{}, max(p.cfg.SectionConcurrency, 1))
+	var wg sync.WaitGroup
+	var emitMu sync.Mutex
+
+	for i, section := range sections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// Head nodes are collected per section and added in page order
+			scratch := &html.Node{Type: html.ElementNode, Data: "head", DataAtom: atom.Head}
+			results[i].reports = p.runSubtree(ctx, subtree, section, scratch)
+
+			if onSection != nil {
+				ps := ProcessedSection{Index: i, Total: len(sections), HTML: renderNode(section)}
+				for c := scratch.FirstChild; c != nil; c = c.NextSibling {
+					ps.Head += renderNode(c)
+				}
+				emitMu.Lock()
+				onSection(ps)
+				emitMu.Unlock()
+			}
+
+			for c := scratch.FirstChild; c != nil; c = scratch.FirstChild {
+				scratch.RemoveChild(c)
+				results[i].head = append(results[i].head, c)
+			}
+		}()
+	}
+	wg.Wait()
+
+	rest := p.runSubtree(ctx, subtree, root, head)
+	head = findElement(root, atom.Head)
+	markers := make(map[string]*html.Node)
+	walkComments(root, func(n *html.Node) { markers[n.Data] = n })
+
+	for i, section := range sections {
+		if placeholder, ok := markers[sectionMarker(i)]; ok {
+			placeholder.Parent.InsertBefore(section, placeholder)
+			placeholder.Parent.RemoveChild(placeholder)
+		} else {
+			p.logger.Warn("Section placeholder lost, appending the section to the page", "index", i)
+			if body := findElement(root, atom.Body); body != nil {
+				body.AppendChild(section)
+			}
+		}
+		if head == nil {
+			continue
+		}
+		for _, n := range results[i].head {
+			head.AppendChild(n)
+		}
+	}
+
+	// Reports for the same processor are merged across sections
+	merged := make(map[string]*common.ProcessorReport)
+	merge := func(reports []common.ProcessorReport) {
+		for _, r := range reports {
+			m, ok := merged[r.Name]
+			if !ok {
+				r.Counts = maps.Clone(r.Counts)
+				merged[r.Name] = &r
+				continue
+			}
+			m.DurationMs += r.DurationMs
+			m.Warnings = append(m.Warnings, r.Warnings...)
+			for k, v := range r.Counts {
+				if m.Counts == nil {
+					m.Counts = make(map[string]int)
+				}
+				m.Counts[k] += v
+			}
+			if !r.Success && m.Success {
+				m.Success = false
+				m.Error = r.Error
+				m.Failure = r.Failure
+			}
+		}
+	}
+	for _, result := range results {
+		merge(result.reports)
+	}
+	merge(rest)
+
+	documentReports := p.runNodes(ctx, document, root, progress)
+	output := renderNode(root)
+
+	var reports []common.ProcessorReport
+	for _, processor := range subtree {
+		if r, ok := merged[processor.Name()]; ok {
+			reports = append(reports, *r)
+		}
+	}
+	reports = append(reports, documentReports...)
+
+	return output, reports
+}
+
+// runSubtree applies the subtree processors to node. Each works on a copy of
+// node and head that replaces them once it succeeds, so a failing processor
+// leaves them as they were.
+func (p *Processors) runSubtree(ctx context.Context, processors []common.SubtreeProcessor, node, head *html.Node) []common.ProcessorReport {
+	var reports []common.ProcessorReport
+
+	for _, processor := range processors {
+		name := processor.Name()
+		start := time.Now()
+		// head may be inside node, as for the rest of the page
+		copies := make(map[*html.Node]*html.Node)
+		nodeCopy := cloneNode(node, copies)
+		headCopy, headInside := copies[head]
+		if !headInside {
+			headCopy = cloneNode(head, nil)
+		}
+		result, err := p.invoke(ctx, name, func(ctx context.Context) (*common.ProcessorResult, error) {
+			return processor.ProcessSubtree(ctx, nodeCopy, headCopy)
+		})
+		elapsed := time.Since(start)
+		common.TimingsFromContext(ctx).Add("postprocess_"+name, elapsed)
+
+		report := common.ProcessorReport{
+			Name:       name,
+			Success:    err == nil,
+			DurationMs: elapsed.Milliseconds(),
+		}
+		if err != nil {
+			p.logger.Error("Failed to process section with processor", "processor", name, "error", err)
+			p.fail(&report, err)
+		} else {
+			adoptNode(node, nodeCopy)
+			if !headInside {
+				adoptNode(head, headCopy)
+			}
+			report.Counts = result.Counts
+			report.Warnings = result.Warnings
+		}
+		reports = append(reports, report)
+	}
+
+	return reports
+}
+
+// invoke runs one processor with the processor timeout, turning a panic into
+// an error. A processor still running at the timeout is abandoned rather
+// than waited for, so fn must only work on its own copy of the input.
+func (p *Processors) invoke(ctx context.Context, name string, fn func(ctx context.Context) (*common.ProcessorResult, error)) (*common.ProcessorResult, error) {
+	timeout := time.Duration(p.cfg.ProcessorTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w after %s", ErrProcessorTimeout, timeout))
+	defer cancel()
+
+	type outcome struct {
+		result *common.ProcessorResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				p.logger.Error("Processor panicked", "processor", name, "panic", v, "stack", string(debug.Stack()))
+				done <- outcome{err: fmt.Errorf("%w: %v", ErrProcessorPanic, v)}
+			}
+		}()
+		result, err := fn(ctx)
+		if err == nil && result == nil {
+			result = &common.ProcessorResult{}
+		}
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+}
+
+// fail records err on a failed processor's report and calls the hook
+func (p *Processors) fail(report *common.ProcessorReport, err error) {
+	report.Error = err.Error()
+	switch {
+	case errors.Is(err, ErrProcessorPanic):
+		report.Failure = common.ProcessorFailurePanic
+	case errors.Is(err, ErrProcessorTimeout):
+		report.Failure = common.ProcessorFailureTimeout
+	default:
+		report.Failure = common.ProcessorFailureError
+	}
+	if p.hooks.ProcessorFailed != nil {
+		p.hooks.ProcessorFailed(report.Name, report.Failure, err)
+	}
+}
+
+// topLevelSections returns the <section> elements of the document that are
+// not inside another section
+func topLevelSections(n *html.Node) []*html.Node {
+	var sections []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.DataAtom == atom.Section {
+			sections = append(sections, c)
+			continue
+		}
+		sections = append(sections, topLevelSections(c)...)
+	}
+	return sections
+}
+
+// sectionMarker is the comment a detached section's place is kept with
+func sectionMarker(i int) string {
+	return fmt.Sprintf("awning-section-%d", i)
+}
+
+func walkComments(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.CommentNode {
+		fn(n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walkComments(c, fn)
+	}
+}
+
+// findElement returns the first element of the given kind under n
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// cloneNode returns a deep copy of n, detached from its parent. copies, when
+// set, maps each copied node to its copy.
+func cloneNode(n *html.Node, copies map[*html.Node]*html.Node) *html.Node {
+	if n == nil {
+		return nil
+	}
+	c := &html.Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		Namespace: n.Namespace,
+		Attr:      slices.Clone(n.Attr),
+	}
+	if copies != nil {
+		copies[n] = c
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.AppendChild(cloneNode(child, copies))
+	}
+	return c
+}
+
+// adoptNode gives n the attributes and children of its processed copy,
+// keeping n where it is in its tree. Nodes under n are replaced by their
+// copies.
+func adoptNode(n, processed *html.Node) {
+	if n == nil {
+		return
+	}
+	n.Attr = processed.Attr
+	for child := n.FirstChild; child != nil; child = n.FirstChild {
+		n.RemoveChild(child)
+	}
+	for child := processed.FirstChild; child != nil; child = processed.FirstChild {
+		processed.RemoveChild(child)
+		n.AppendChild(child)
+	}
+}
+
+// renderNode renders n in canonical form
+func renderNode(n *html.Node) string {
+	return utils.CanonicalNode(n)
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+
+	"golang.org/x/net/html"
+)
+
+// markProcessor appends a comment naming itself to the page
+type markProcessor string
+
+func (m markProcessor) Name() string { return string(m) }
+
+func (m markProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	return []byte(strings.Replace(string(input), "</body>", "<!--"+string(m)+"--></body>", 1)), nil
+}
+
+func TestProcessorGates(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"images", "cleanup"}
+	p := NewProcessors(cfg)
+	p.RegisterProcessor("images", markProcessor("images"))
+	p.RegisterProcessor("cleanup", markProcessor("cleanup"))
+
+	imagesOn := true
+	p.AddGate("images", "image processing is switched off", func(ctx context.Context) bool { return imagesOn })
+
+	output, reports := p.Run(context.Background(), "<p>hi</p>", nil)
+	if !strings.Contains(output, "<!--images-->") || len(reports) != 2 {
+		t.Fatalf("Run() with the gate open = %s, %+v; want both processors", output, reports)
+	}
+
+	imagesOn = false
+	output, reports = p.Run(context.Background(), "<p>hi</p>", nil)
+	if strings.Contains(output, "<!--images-->") || !strings.Contains(output, "<!--cleanup-->") {
+		t.Errorf("Run() with the gate closed = %s, want only cleanup applied", output)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Run() reports = %+v, want cleanup's and the skipped images'", reports)
+	}
+	skipped := reports[1]
+	if skipped.Name != "images" || !skipped.Skipped || !skipped.Success || len(skipped.Warnings) != 1 || skipped.Warnings[0] != "image processing is switched off" {
+		t.Errorf("skipped report = %+v", skipped)
+	}
+
+	// RunOnly skips it the same way
+	if _, reports := p.RunOnly(context.Background(), "<p>hi</p>", "images"); len(reports) != 1 || !reports[0].Skipped {
+		t.Errorf("RunOnly() reports = %+v, want the skipped report", reports)
+	}
+}
+
+// panicProcessor panics part way through its work. As a subtree processor
+// it first empties the node it was given.
+type panicProcessor struct{}
+
+func (panicProcessor) Name() string { return "broken" }
+
+func (panicProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	var node *html.Node
+	return []byte(node.Data), nil
+}
+
+func (panicProcessor) ProcessSubtree(ctx context.Context, node, head *html.Node) (*common.ProcessorResult, error) {
+	for node.FirstChild != nil {
+		node.RemoveChild(node.FirstChild)
+	}
+	panic("bad markup")
+}
+
+// stuckProcessor ignores its context, overwriting its input once released
+type stuckProcessor struct {
+	release chan struct{}
+	done    chan struct{}
+}
+
+func (stuckProcessor) Name() string { return "stuck" }
+
+func (s stuckProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	<-s.release
+	defer close(s.done)
+	for i := range input {
+		input[i] = 'x'
+	}
+	return input, nil
+}
+
+// failureHooks keeps the failures a pipeline reports
+type failureHooks struct {
+	mu       sync.Mutex
+	failures []string
+}
+
+func (f *failureHooks) hooks() ProcessorHooks {
+	return ProcessorHooks{ProcessorFailed: func(name, failure string, err error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.failures = append(f.failures, name+":"+failure)
+	}}
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"broken", "cleanup"}
+	p := NewProcessors(cfg)
+	p.RegisterProcessor("broken", panicProcessor{})
+	p.RegisterProcessor("cleanup", markProcessor("cleanup"))
+	hooks := &failureHooks{}
+	p.SetHooks(hooks.hooks())
+
+	page := "<html><head></head><body><p>hi</p></body></html>"
+	output, reports := p.Run(context.Background(), page, nil)
+	if !strings.Contains(output, "<p>hi</p>") || !strings.Contains(output, "<!--cleanup-->") {
+		t.Errorf("Run() = %s, want the page passed on to cleanup", output)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Run() reports = %+v, want one per processor", reports)
+	}
+	if broken := reports[0]; broken.Success || broken.Failure != common.ProcessorFailurePanic || !strings.Contains(broken.Error, "processor panicked") {
+		t.Errorf("panicking processor's report = %+v", broken)
+	}
+	if !reports[1].Success {
+		t.Errorf("cleanup report = %+v, want success", reports[1])
+	}
+	if len(hooks.failures) != 1 || hooks.failures[0] != "broken:panic" {
+		t.Errorf("ProcessorFailed calls = %q, want the panic", hooks.failures)
+	}
+}
+
+func TestRunIncrementalRecoversSubtreePanics(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"broken"}
+	p := NewProcessors(cfg)
+	p.RegisterProcessor("broken", panicProcessor{})
+	hooks := &failureHooks{}
+	p.SetHooks(hooks.hooks())
+
+	page := "<html><head></head><body><section><h1>One</h1></section><section><h1>Two</h1></section></body></html>"
+	sections := 0
+	output, reports := p.RunIncremental(context.Background(), page, nil, func(ProcessedSection) { sections++ })
+
+	// The processor emptied its copies only
+	if !strings.Contains(output, "<section><h1>One</h1></section><section><h1>Two</h1></section>") {
+		t.Errorf("RunIncremental() = %s, want the sections as they were", output)
+	}
+	if sections != 2 {
+		t.Errorf("onSection called %d times, want 2", sections)
+	}
+	if len(reports) != 1 || reports[0].Success || reports[0].Failure != common.ProcessorFailurePanic {
+		t.Errorf("RunIncremental() reports = %+v, want the panic", reports)
+	}
+	// Both sections and the rest of the page
+	if len(hooks.failures) != 3 {
+		t.Errorf("ProcessorFailed calls = %q, want one per run", hooks.failures)
+	}
+}
+
+func TestRunTimesOutSlowProcessors(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"stuck", "cleanup"}
+	cfg.ProcessorTimeoutSeconds = 1
+	stuck := stuckProcessor{release: make(chan struct{}), done: make(chan struct{})}
+	p := NewProcessors(cfg)
+	p.RegisterProcessor("stuck", stuck)
+	p.RegisterProcessor("cleanup", markProcessor("cleanup"))
+	hooks := &failureHooks{}
+	p.SetHooks(hooks.hooks())
+
+	page := "<html><head></head><body><p>hi</p></body></html>"
+	start := time.Now()
+	output, reports := p.Run(context.Background(), page, nil)
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("Run() took %s, want it to give up after the 1s timeout", elapsed)
+	}
+	if !strings.Contains(output, "<p>hi</p>") || !strings.Contains(output, "<!--cleanup-->") {
+		t.Errorf("Run() = %s, want the page passed on to cleanup", output)
+	}
+	if len(reports) != 2 || reports[0].Failure != common.ProcessorFailureTimeout || !strings.Contains(reports[0].Error, "processor timed out after 1s") {
+		t.Errorf("Run() reports = %+v, want the timeout", reports)
+	}
+	if len(hooks.failures) != 1 || hooks.failures[0] != "stuck:timeout" {
+		t.Errorf("ProcessorFailed calls = %q, want the timeout", hooks.failures)
+	}
+
+	// The abandoned processor finishing late doesn't touch the result
+	before := strings.Clone(output)
+	close(stuck.release)
+	<-stuck.done
+	if output != before {
+		t.Errorf("Run() result changed after the abandoned processor finished: %s", output)
+	}
+}
+
+// tagProcessor marks every section of the page with a data attribute, on
+// the parsed document or on its bytes
+type tagProcessor string
+
+func (p tagProcessor) Name() string { return string(p) }
+
+func (p tagProcessor) ProcessNode(ctx context.Context, doc *html.Node) (*common.ProcessorResult, error) {
+	result := &common.ProcessorResult{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "section" {
+			n.Attr = append(n.Attr, html.Attribute{Key: "data-" + string(p), Val: "1"})
+			result.Count("sections", 1)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return result, nil
+}
+
+func (p tagProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.ProcessNode(ctx, doc); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := html.Render(&b, doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// byteProcessor hides a processor's ProcessNode, so the pipeline renders
+// the page for it and parses its output
+type byteProcessor struct{ p tagProcessor }
+
+func (p byteProcessor) Name() string { return p.p.Name() }
+
+func (p byteProcessor) Process(ctx context.Context, input []byte) ([]byte, error) {
+	return p.p.Process(ctx, input)
+}
+
+// benchmarkPipeline returns a quiet pipeline of five processors, wrapped
+// by wrap
+func benchmarkPipeline(wrap func(tagProcessor) common.Processor) *Processors {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"a", "b", "c", "d", "e"}
+	p := NewProcessors(cfg)
+	p.logger = slog.New(slog.DiscardHandler)
+	for _, name := range cfg.EnabledProcessors {
+		p.RegisterProcessor(name, wrap(tagProcessor(name)))
+	}
+	return p
+}
+
+// benchmarkPage returns a generated page of about size bytes
+func benchmarkPage(size int) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html><html><head><title>Bakery</title></head><body>\n")
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, `<section id="section-%d" class="py-12 px-4">
+  <div class="container mx-auto grid grid-cols-3 gap-4">
+    <h2 class="text-3xl font-bold">Section %d</h2>
+    <p class="text-gray-600">Fresh rye &amp; sourdough, baked <strong>every</strong> morning.</p>
+    <img src="/images/%d.jpg" alt="Loaf %d" width="640" class="rounded">
+    <a href="/menu#%d" class="btn btn-primary" data-track="cta">Order</a>
+  </div>
+</section>
+`, i, i, i, i, i)
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+func TestRunNodeAndByteProcessorsAgree(t *testing.T) {
+	page := benchmarkPage(4 << 10)
+	nodes, nodeReports := benchmarkPipeline(func(p tagProcessor) common.Processor { return p }).Run(context.Background(), page, nil)
+	rendered, _ := benchmarkPipeline(func(p tagProcessor) common.Processor { return byteProcessor{p} }).Run(context.Background(), page, nil)
+
+	if nodes != rendered {
+		t.Errorf("Run() with node processors = %s\nwith byte processors = %s\nwant the same", nodes, rendered)
+	}
+	if !strings.Contains(nodes, `data-a="1" data-b="1" data-c="1" data-d="1" data-e="1"`) {
+		t.Errorf("Run() = %s, want every processor applied", nodes)
+	}
+	if len(nodeReports) != 5 || nodeReports[0].Counts["sections"] == 0 {
+		t.Errorf("Run() reports = %+v, want the processors' counts", nodeReports)
+	}
+}
+
+// The pipeline parses a 300KB page once for node processors, but renders
+// and parses it again around each byte processor
+func BenchmarkRunNodeProcessors(b *testing.B) {
+	benchmarkRun(b, func(p tagProcessor) common.Processor { return p })
+}
+
+func BenchmarkRunByteProcessors(b *testing.B) {
+	benchmarkRun(b, func(p tagProcessor) common.Processor { return byteProcessor{p} })
+}
+
+func benchmarkRun(b *testing.B, wrap func(tagProcessor) common.Processor) {
+	p := benchmarkPipeline(wrap)
+	page := benchmarkPage(300 << 10)
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	for b.Loop() {
+		p.Run(context.Background(), page, nil)
+	}
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"awning-backend/common"
+)
+
+const (
+	SAVED_RESPONSES_DIR = ".var/saved_responses"
+
+	savedResponsePrefix = "response"
+	savedResponseExt    = ".html"
+	savedResponseMeta   = ".json"
+)
+
+var ErrSavedResponseNotFound = errors.New("saved response not found")
+
+// SavedResponseMetadata is written next to each saved response
+type SavedResponseMetadata struct {
+	ChatID       string    `json:"chat_id"`
+	Model        string    `json:"model"`
+	TenantSchema string    `json:"tenant_schema,omitempty"`
+	Variant      string    `json:"prompt_variant,omitempty"`
+	DurationMs   int64     `json:"duration_ms"`
+	SavedAt      time.Time `json:"saved_at"`
+
+	Generation *common.GenerationParams `json:"generation,omitempty"`
+}
+
+// SavedResponse describes a saved response. Keywords come from the filename;
+// responses saved before metadata was recorded have no Metadata and use the
+// file modification time.
+type SavedResponse struct {
+	ID       string                 `json:"id"`
+	Keywords []string               `json:"keywords"`
+	SavedAt  time.Time              `json:"saved_at"`
+	Size     int64                  `json:"size"`
+	Metadata *SavedResponseMetadata `json:"metadata,omitempty"`
+}
+
+// ResponseStore keeps raw generated responses for debugging and replay
+type ResponseStore interface {
+	Save(ctx context.Context, keywords []string, content string, meta SavedResponseMetadata) (string, error)
+	List(ctx context.Context) ([]SavedResponse, error)
+	Get(ctx context.Context, id string) (string, *SavedResponse, error)
+}
+
+// LocalResponseStore saves responses as response_<keywords>_<timestamp>.html
+// files in a local directory, each with a .json metadata sidecar
+type LocalResponseStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewLocalResponseStore creates a response store writing to dir. The
+// directory is created on first save.
+func NewLocalResponseStore(dir string) *LocalResponseStore {
+	return &LocalResponseStore{dir: dir, now: time.Now}
+}
+
+func (s *LocalResponseStore) Save(_ context.Context, keywords []string, content string, meta SavedResponseMetadata) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create responses directory: %w", err)
+	}
+
+	now := s.now().UTC()
+	meta.SavedAt = now
+
+	id := savedResponsePrefix
+	if len(keywords) > 0 {
+		id += "_" + strings.Join(keywords, "_")
+	}
+	id = fmt.Sprintf("%s_%d", id, now.UnixMilli())
+
+	if err := os.WriteFile(filepath.Join(s.dir, id+savedResponseExt), []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("failed to write response: %w", err)
+	}
+
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return id, fmt.Errorf("failed to encode response metadata: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, id+savedResponseMeta), metaJSON, 0644); err != nil {
+		return id, fmt.Errorf("failed to write response metadata: %w", err)
+	}
+
+	return id, nil
+}
+
+// List returns the saved responses, newest first
+func (s *LocalResponseStore) List(_ context.Context) ([]SavedResponse, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []SavedResponse{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list responses: %w", err)
+	}
+
+	responses := []SavedResponse{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, savedResponseExt) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		responses = append(responses, s.describe(strings.TrimSuffix(name, savedResponseExt), info))
+	}
+
+	sort.Slice(responses, func(i, j int) bool {
+		return responses[i].SavedAt.After(responses[j].SavedAt)
+	})
+	return responses, nil
+}
+
+func (s *LocalResponseStore) Get(_ context.Context, id string) (string, *SavedResponse, error) {
+	if !validSavedResponseID(id) {
+		return "", nil, ErrSavedResponseNotFound
+	}
+
+	path := filepath.Join(s.dir, id+savedResponseExt)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, ErrSavedResponseNotFound
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	response := s.describe(id, info)
+	return string(data), &response, nil
+}
+
+// describe builds the listing entry for a response file, reading its sidecar
+// when there is one
+func (s *LocalResponseStore) describe(id string, info fs.FileInfo) SavedResponse {
+	response := SavedResponse{
+		ID:       id,
+		Keywords: savedResponseKeywords(id),
+		SavedAt:  info.ModTime().UTC(),
+		Size:     info.Size(),
+	}
+
+	if data, err := os.ReadFile(filepath.Join(s.dir, id+savedResponseMeta)); err == nil {
+		var meta SavedResponseMetadata
+		if json.Unmarshal(data, &meta) == nil {
+			response.Metadata = &meta
+			if !meta.SavedAt.IsZero() {
+				response.SavedAt = meta.SavedAt.UTC()
+			}
+		}
+	}
+
+	return response
+}
+
+// savedResponseKeywords parses the keywords from response_<keywords>_<timestamp>
+func savedResponseKeywords(id string) []string {
+	rest := strings.TrimPrefix(id, savedResponsePrefix)
+	if i := strings.LastIndex(rest, "_"); i >= 0 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimPrefix(rest, "_")
+	if rest == "" {
+		return []string{}
+	}
+	return strings.Split(rest, "_")
+}
+
+// validSavedResponseID rejects IDs that could escape the responses directory
+func validSavedResponseID(id string) bool {
+	return strings.HasPrefix(id, savedResponsePrefix) &&
+		!strings.ContainsAny(id, `/\`) &&
+		!strings.Contains(id, "..")
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestResponseStore returns a store in a temp dir whose clock starts at
+// 2026-03-01 09:30 UTC and moves a minute per save
+func newTestResponseStore(t *testing.T) *LocalResponseStore {
+	t.Helper()
+
+	store := NewLocalResponseStore(filepath.Join(t.TempDir(), "responses"))
+	now := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	store.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return store
+}
+
+func TestLocalResponseStoreSaveAndGet(t *testing.T) {
+	store := newTestResponseStore(t)
+	ctx := context.Background()
+
+	id, err := store.Save(ctx, []string{"bakery", "hero"}, "<section>Bread</section>", SavedResponseMetadata{
+		ChatID: "chat-1", Model: "google/gemini", TenantSchema: "tenant_a", DurationMs: 1200,
+	})
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if want := "response_bakery_hero_1772357460000"; id != want {
+		t.Errorf("Save() id = %q, want %q", id, want)
+	}
+
+	content, response, err := store.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if content != "<section>Bread</section>" {
+		t.Errorf("Get() content = %q", content)
+	}
+	if !slices.Equal(response.Keywords, []string{"bakery", "hero"}) || response.Size != int64(len(content)) {
+		t.Errorf("Get() response = %+v", response)
+	}
+	meta := response.Metadata
+	if meta == nil || meta.ChatID != "chat-1" || meta.Model != "google/gemini" || meta.TenantSchema != "tenant_a" || meta.DurationMs != 1200 {
+		t.Fatalf("Get() metadata = %+v, want the sidecar", meta)
+	}
+	if want := time.Date(2026, 3, 1, 9, 31, 0, 0, time.UTC); !response.SavedAt.Equal(want) || !meta.SavedAt.Equal(want) {
+		t.Errorf("SavedAt = %v, %v; want %v", response.SavedAt, meta.SavedAt, want)
+	}
+}
+
+func TestLocalResponseStoreList(t *testing.T) {
+	store := newTestResponseStore(t)
+	ctx := context.Background()
+
+	if responses, err := store.List(ctx); err != nil || len(responses) != 0 {
+		t.Errorf("List() before any save = %v, %v; want empty", responses, err)
+	}
+
+	for _, keywords := range [][]string{{"bakery"}, {"garage", "hero"}, nil} {
+		if _, err := store.Save(ctx, keywords, "<p></p>", SavedResponseMetadata{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Saved before sidecars were written, so dated by its modification time
+	legacy := filepath.Join(store.dir, "response_florist_1.html")
+	if err := os.WriteFile(legacy, []byte("<p>old</p>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(legacy, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var ids []string
+	for _, r := range responses {
+		ids = append(ids, r.ID)
+	}
+	// Newest first
+	want := []string{"response_1772357580000", "response_garage_hero_1772357520000", "response_bakery_1772357460000", "response_florist_1"}
+	if !slices.Equal(ids, want) {
+		t.Fatalf("List() ids = %q, want %q", ids, want)
+	}
+	if last := responses[3]; last.Metadata != nil || !last.SavedAt.Equal(old) || !slices.Equal(last.Keywords, []string{"florist"}) {
+		t.Errorf("legacy response = %+v", last)
+	}
+	if first := responses[0]; len(first.Keywords) != 0 || first.Keywords == nil {
+		t.Errorf("keywords of a response saved without any = %#v, want empty", first.Keywords)
+	}
+}
+
+func TestLocalResponseStoreGetRejectsPaths(t *testing.T) {
+	store := newTestResponseStore(t)
+	if _, err := store.Save(context.Background(), nil, "<p></p>", SavedResponseMetadata{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"missing", "response_missing_1", "response_../../etc/passwd", `response_..\x`, "../response_1"} {
+		if _, _, err := store.Get(context.Background(), id); !errors.Is(err, ErrSavedResponseNotFound) {
+			t.Errorf("Get(%q) error = %v, want ErrSavedResponseNotFound", id, err)
+		}
+	}
+}
+
+func TestSavedResponseKeywords(t *testing.T) {
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{"response_1772357460000", []string{}},
+		{"response_bakery_1772357460000", []string{"bakery"}},
+		{"response_bakery_hero_image_1772357460000", []string{"bakery", "hero", "image"}},
+	}
+	for _, tt := range tests {
+		if got := savedResponseKeywords(tt.id); !slices.Equal(got, tt.want) {
+			t.Errorf("savedResponseKeywords(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
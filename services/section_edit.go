@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"awning-backend/common"
+	"awning-backend/model"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	ErrInvalidSelector       = errors.New("invalid selector")
+	ErrEditTargetNotFound    = errors.New("edit target matches no element")
+	ErrEditTargetAmbiguous   = errors.New("edit target matches more than one element")
+	ErrInvalidEditTarget     = errors.New("edit target needs a selector or a section index")
+	ErrInvalidSectionReplace = errors.New("replacement must be a single element of the same kind as the target")
+)
+
+// SectionEdit is a document with one element picked out to be replaced by
+// generated markup
+type SectionEdit struct {
+	root   *html.Node
+	target *html.Node
+
+	// Original is the target's markup before the edit
+	Original string
+}
+
+// NewSectionEdit parses document and finds the element picked by target
+func NewSectionEdit(document string, target *model.EditTarget) (*SectionEdit, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	var matches []*html.Node
+	switch {
+	case target == nil || (target.Selector == "") == (target.SectionIndex == nil):
+		return nil, ErrInvalidEditTarget
+	case target.SectionIndex != nil:
+		sections := topLevelSections(root)
+		if i := *target.SectionIndex; i >= 0 && i < len(sections) {
+			matches = sections[i : i+1]
+		}
+	default:
+		sel, err := parseSelector(target.Selector)
+		if err != nil {
+			return nil, err
+		}
+		matches = sel.findAll(root)
+	}
+
+	switch {
+	case len(matches) == 0:
+		return nil, ErrEditTargetNotFound
+	case len(matches) > 1:
+		return nil, fmt.Errorf("%w (%d matches)", ErrEditTargetAmbiguous, len(matches))
+	}
+
+	switch matches[0].DataAtom {
+	case atom.Html, atom.Head, atom.Body:
+		return nil, fmt.Errorf("%w: can't replace <%s>", ErrInvalidEditTarget, matches[0].Data)
+	}
+
+	return &SectionEdit{
+		root:     root,
+		target:   matches[0],
+		Original: renderNode(matches[0]),
+	}, nil
+}
+
+// TagName returns the name of the element being replaced
+func (e *SectionEdit) TagName() string {
+	return e.target.Data
+}
+
+// Replace parses the generated markup and splices it in place of the target.
+// The markup must hold a single element with the target's tag name; code
+// fences around it are ignored.
+func (e *SectionEdit) Replace(markup string) error {
+	parent := e.target.Parent
+	nodes, err := html.ParseFragment(strings.NewReader(stripCodeFence(markup)), parent)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSectionReplace, err)
+	}
+
+	var replacement *html.Node
+	for _, n := range nodes {
+		switch {
+		case n.Type == html.CommentNode:
+		case n.Type == html.TextNode && strings.TrimSpace(n.Data) == "":
+		case n.Type == html.ElementNode && replacement == nil:
+			replacement = n
+		default:
+			return ErrInvalidSectionReplace
+		}
+	}
+	if replacement == nil || replacement.Data != e.target.Data {
+		return ErrInvalidSectionReplace
+	}
+
+	parent.InsertBefore(replacement, e.target)
+	parent.RemoveChild(e.target)
+	e.target = replacement
+	return nil
+}
+
+// Process runs the section-aware processors on the element in the target's
+// place. What they add to <head> goes into the document's head.
+func (e *SectionEdit) Process(ctx context.Context, p *Processors) []common.ProcessorReport {
+	return p.RunSubtree(ctx, e.target, findElement(e.root, atom.Head))
+}
+
+// Document renders the whole document with the edit applied
+func (e *SectionEdit) Document() string {
+	return renderNode(e.root)
+}
+
+// Current renders the element now in the target's place
+func (e *SectionEdit) Current() string {
+	return renderNode(e.target)
+}
+
+// RunSubtree applies the enabled processors that implement
+// common.SubtreeProcessor to node, with head as the document's <head>. Other
+// processors are skipped, as the rest of the document was processed before.
+func (p *Processors) RunSubtree(ctx context.Context, node, head *html.Node) []common.ProcessorReport {
+	processors, skipped := p.gatedProcessors(ctx, nil)
+	var subtree []common.SubtreeProcessor
+	for _, processor := range processors {
+		if sp, ok := processor.(common.SubtreeProcessor); ok {
+			subtree = append(subtree, sp)
+		}
+	}
+	return append(p.runSubtree(ctx, subtree, node, head), skipped...)
+}
+
+// stripCodeFence removes a markdown code fence the model may have wrapped
+// the markup in
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s
+	}
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	} else {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "```"))
+}
+
+// selector is a single compound CSS selector: an optional tag name followed
+// by any number of #id, .class, [attr] and [attr=value] parts
+type selector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []selectorAttr
+}
+
+type selectorAttr struct {
+	key, value string
+	hasValue   bool
+}
+
+var (
+	selectorTag    = regexp.MustCompile(`^(\*|[a-zA-Z][a-zA-Z0-9-]*)`)
+	selectorName   = regexp.MustCompile(`^[a-zA-Z0-9_-]+`)
+	selectorAttrRe = regexp.MustCompile(`^\[\s*([a-zA-Z_:][a-zA-Z0-9_:.-]*)\s*(?:=\s*(?:"([^"]*)"|'([^']*)'|([^\]\s"']+))\s*)?\]`)
+)
+
+// parseSelector parses a compound selector. Combinators, selector lists and
+// pseudo-classes are not supported.
+func parseSelector(s string) (*selector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrInvalidSelector
+	}
+
+	sel := &selector{}
+	if m := selectorTag.FindString(s); m != "" {
+		if m != "*" {
+			sel.tag = strings.ToLower(m)
+		}
+		s = s[len(m):]
+	}
+
+	for s != "" {
+		switch s[0] {
+		case '#', '.':
+			name := selectorName.FindString(s[1:])
+			if name == "" {
+				return nil, fmt.Errorf("%w: expected a name after %q", ErrInvalidSelector, s[0])
+			}
+			if s[0] == '#' {
+				sel.id = name
+			} else {
+				sel.classes = append(sel.classes, name)
+			}
+			s = s[1+len(name):]
+		case '[':
+			m := selectorAttrRe.FindStringSubmatch(s)
+			if m == nil {
+				return nil, fmt.Errorf("%w: malformed attribute selector", ErrInvalidSelector)
+			}
+			attr := selectorAttr{key: strings.ToLower(m[1]), value: m[2] + m[3] + m[4]}
+			attr.hasValue = strings.Contains(m[0], "=")
+			sel.attrs = append(sel.attrs, attr)
+			s = s[len(m[0]):]
+		default:
+			return nil, fmt.Errorf("%w: only tag, #id, .class and [attr=value] are supported", ErrInvalidSelector)
+		}
+	}
+	return sel, nil
+}
+
+func (sel *selector) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode || (sel.tag != "" && n.Data != sel.tag) {
+		return false
+	}
+	if sel.id != "" && nodeAttr(n, "id") != sel.id {
+		return false
+	}
+	classes := strings.Fields(nodeAttr(n, "class"))
+	for _, want := range sel.classes {
+		if !slices.Contains(classes, want) {
+			return false
+		}
+	}
+	for _, attr := range sel.attrs {
+		val, ok := lookupAttr(n, attr.key)
+		if !ok || (attr.hasValue && val != attr.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sel *selector) findAll(n *html.Node) []*html.Node {
+	var found []*html.Node
+	if sel.matches(n) {
+		found = append(found, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		found = append(found, sel.findAll(c)...)
+	}
+	return found
+}
+
+func lookupAttr(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func nodeAttr(n *html.Node, key string) string {
+	val, _ := lookupAttr(n, key)
+	return val
+}
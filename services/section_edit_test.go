@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"awning-backend/common"
+	"awning-backend/model"
+	"awning-backend/utils"
+
+	"golang.org/x/net/html"
+)
+
+// readEditFixture returns testdata/edit/<name>
+func readEditFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "edit", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return string(data)
+}
+
+// canonical renders markup the way SectionEdit does
+func canonical(t *testing.T, markup string) string {
+	t.Helper()
+
+	root, err := html.Parse(strings.NewReader(markup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return utils.CanonicalNode(root)
+}
+
+func TestSectionEditSplice(t *testing.T) {
+	page := readEditFixture(t, "page.html")
+	replacement := readEditFixture(t, "hero.html")
+	index := func(i int) *int { return &i }
+
+	tests := []struct {
+		name   string
+		target model.EditTarget
+		markup string
+		before string
+	}{
+		{"id selector", model.EditTarget{Selector: "#hero"}, replacement, `<section id="hero"`},
+		{"tag and class", model.EditTarget{Selector: "section.hero.bg-amber-50"}, replacement, `<section id="hero"`},
+		{"section index", model.EditTarget{SectionIndex: index(0)}, replacement, `<section id="hero"`},
+		{"attribute value", model.EditTarget{Selector: `section[data-kind="menu"]`}, `<section id="menu" data-kind="menu"><h2>Today</h2></section>`, `<section id="menu"`},
+		{"last section", model.EditTarget{SectionIndex: index(2)}, `<section id="visit"><h2>Find us</h2></section>`, `<section id="visit"`},
+		{"nested element", model.EditTarget{Selector: "nav"}, `<nav><a href="#menu">Menu</a></nav>`, `<nav>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit, err := NewSectionEdit(page, &tt.target)
+			if err != nil {
+				t.Fatalf("NewSectionEdit() error = %v", err)
+			}
+			if !strings.HasPrefix(edit.Original, tt.before) {
+				t.Fatalf("Original = %s, want the element starting %s", edit.Original, tt.before)
+			}
+			if err := edit.Replace(tt.markup); err != nil {
+				t.Fatalf("Replace() error = %v", err)
+			}
+
+			// Everything but the target is left exactly as it was
+			want := strings.Replace(canonical(t, page), edit.Original, edit.Current(), 1)
+			if got := edit.Document(); got != want {
+				t.Errorf("Document() =\n%s\nwant\n%s", got, want)
+			}
+			if strings.Contains(edit.Current(), "```") || edit.Current() == edit.Original {
+				t.Errorf("Current() = %s, want the replacement", edit.Current())
+			}
+		})
+	}
+}
+
+func TestSectionEditTargetRejected(t *testing.T) {
+	page := readEditFixture(t, "page.html")
+	index := func(i int) *int { return &i }
+
+	tests := []struct {
+		name   string
+		target *model.EditTarget
+		want   error
+	}{
+		{"several matches", &model.EditTarget{Selector: ".card"}, ErrEditTargetAmbiguous},
+		{"every section", &model.EditTarget{Selector: "section"}, ErrEditTargetAmbiguous},
+		{"no match", &model.EditTarget{Selector: "#pricing"}, ErrEditTargetNotFound},
+		{"index past the end", &model.EditTarget{SectionIndex: index(3)}, ErrEditTargetNotFound},
+		{"negative index", &model.EditTarget{SectionIndex: index(-1)}, ErrEditTargetNotFound},
+		{"selector and index", &model.EditTarget{Selector: "#hero", SectionIndex: index(0)}, ErrInvalidEditTarget},
+		{"neither", &model.EditTarget{}, ErrInvalidEditTarget},
+		{"no target", nil, ErrInvalidEditTarget},
+		{"body", &model.EditTarget{Selector: "body"}, ErrInvalidEditTarget},
+		{"combinator", &model.EditTarget{Selector: "main > section"}, ErrInvalidSelector},
+		{"pseudo-class", &model.EditTarget{Selector: "section:first-child"}, ErrInvalidSelector},
+		{"malformed attribute", &model.EditTarget{Selector: "section[data-kind"}, ErrInvalidSelector},
+	}
+	for _, tt := range tests {
+		if _, err := NewSectionEdit(page, tt.target); !errors.Is(err, tt.want) {
+			t.Errorf("%s: NewSectionEdit() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSectionEditReplaceRejected(t *testing.T) {
+	page := readEditFixture(t, "page.html")
+
+	tests := []struct {
+		name   string
+		markup string
+	}{
+		{"two sections", `<section id="hero"></section><section></section>`},
+		{"another element", `<div id="hero"><h1>Hi</h1></div>`},
+		{"text around it", `Here you go: <section id="hero"></section>`},
+		{"empty", ""},
+		{"empty fence", "```"},
+	}
+	for _, tt := range tests {
+		edit, err := NewSectionEdit(page, &model.EditTarget{Selector: "#hero"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := edit.Replace(tt.markup); !errors.Is(err, ErrInvalidSectionReplace) {
+			t.Errorf("%s: Replace() error = %v, want ErrInvalidSectionReplace", tt.name, err)
+		}
+		if edit.Document() != canonical(t, page) {
+			t.Errorf("%s: rejected Replace() changed the document", tt.name)
+		}
+	}
+}
+
+// headAdder is a subtree processor marking its node and adding a style to
+// the head
+type headAdder struct{}
+
+func (headAdder) Name() string { return "headAdder" }
+
+func (headAdder) Process(_ context.Context, input []byte) ([]byte, error) { return input, nil }
+
+func (headAdder) ProcessSubtree(_ context.Context, node, head *html.Node) (*common.ProcessorResult, error) {
+	node.Attr = append(node.Attr, html.Attribute{Key: "data-processed", Val: "true"})
+	head.AppendChild(&html.Node{Type: html.ElementNode, Data: "style"})
+	return &common.ProcessorResult{}, nil
+}
+
+func TestSectionEditProcess(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.EnabledProcessors = []string{"marker"}
+	p := NewProcessors(cfg)
+	p.RegisterProcessor("marker", headAdder{})
+
+	edit, err := NewSectionEdit(readEditFixture(t, "page.html"), &model.EditTarget{Selector: "#menu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := edit.Replace(`<section id="menu"><h2>Today</h2></section>`); err != nil {
+		t.Fatal(err)
+	}
+	reports := edit.Process(context.Background(), p)
+	if len(reports) != 1 || !reports[0].Success {
+		t.Fatalf("Process() reports = %+v, want one successful run", reports)
+	}
+
+	doc := edit.Document()
+	if n := strings.Count(doc, `data-processed="true"`); n != 1 || !strings.Contains(edit.Current(), `data-processed="true"`) {
+		t.Errorf("processed %d elements, want only the replacement:\n%s", n, doc)
+	}
+	if !strings.Contains(doc, "<style></style></head>") {
+		t.Errorf("Document() = %s, want the processor's style in the head", doc)
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+
+	"awning-backend/model"
+	"awning-backend/utils"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// LinkSitePages rewrites the links of one page of a multi-page site that
+// point at another page, however the model wrote them (about.html,
+// ./about, /about/, #about), to the page's canonical path from nav.
+// #anchors are left alone when the page has an element with that id. Links
+// to the current page inside <nav> get aria-current="page". It returns the
+// page and the number of links rewritten; unparseable pages are returned
+// as they are.
+func LinkSitePages(document string, nav []model.SiteNavLink, current string) (string, int) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return document, 0
+	}
+
+	hrefs := map[string]string{}
+	for _, link := range nav {
+		hrefs[link.Slug] = link.Href
+	}
+	ids := map[string]bool{}
+	walkElements(root, func(n *html.Node) {
+		for _, attr := range n.Attr {
+			if attr.Key == "id" {
+				ids[attr.Val] = true
+			}
+		}
+	})
+
+	rewritten := 0
+	walkElements(root, func(n *html.Node) {
+		if n.DataAtom != atom.A {
+			return
+		}
+		for i, attr := range n.Attr {
+			if attr.Key != "href" {
+				continue
+			}
+			slug, fragment, anchor := linkedSitePage(attr.Val)
+			href, ok := hrefs[slug]
+			if !ok || (anchor && ids[slug]) {
+				return
+			}
+			href += fragment
+			if attr.Val != href {
+				n.Attr[i].Val = href
+				rewritten++
+			}
+			if slug == current && insideElement(n, atom.Nav) && !hasAttribute(n, "aria-current") {
+				n.Attr = append(n.Attr, html.Attribute{Key: "aria-current", Val: "page"})
+			}
+			return
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := utils.RenderCanonical(&buf, root); err != nil {
+		return document, 0
+	}
+	return buf.String(), rewritten
+}
+
+// linkedSitePage returns the page slug an href may point at, the #fragment
+// it links to on that page, and whether the whole href was an #anchor.
+// Links to other sites, mailto: and tel: get no slug.
+func linkedSitePage(href string) (slug, fragment string, anchor bool) {
+	href = strings.TrimSpace(href)
+	if strings.Contains(href, ":") || strings.HasPrefix(href, "//") {
+		return "", "", false
+	}
+	if rest, ok := strings.CutPrefix(href, "#"); ok {
+		return strings.ToLower(rest), "", true
+	}
+	if i := strings.Index(href, "#"); i >= 0 {
+		href, fragment = href[:i], href[i:]
+	}
+	if i := strings.Index(href, "?"); i >= 0 {
+		href = href[:i]
+	}
+	href = strings.ToLower(href)
+	href = strings.TrimPrefix(href, ".")
+	href = strings.Trim(href, "/")
+	href = strings.TrimSuffix(href, ".html")
+	if href == "" || href == "index" {
+		return model.SiteHomePage, fragment, false
+	}
+	return href, fragment, false
+}
+
+func walkElements(n *html.Node, fn func(*html.Node)) {
+	if n.Type == html.ElementNode {
+		fn(n)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walkElements(child, fn)
+	}
+}
+
+func insideElement(n *html.Node, a atom.Atom) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.DataAtom == a {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAttribute(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
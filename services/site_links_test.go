@@ -0,0 +1,68 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+)
+
+func TestLinkSitePages(t *testing.T) {
+	nav := model.DefaultSitePlan("Crumb & Co", []string{"home", "about", "contact"}).Nav()
+	page := `<html><body>
+<nav><a href="index.html">Home</a><a href="./about.html">About</a><a href="/contact/">Contact</a></nav>
+<section id="contact"><a href="#contact">Jump</a><a href="#about">About us</a></section>
+<a href="about#team">Team</a><a href="/about?ref=footer">Footer</a><a href="/about">Already</a>
+<a href="https://example.com/about">Elsewhere</a><a href="mailto:hi@crumb.co">Mail</a><a href="/blog">Blog</a>
+</body></html>`
+
+	got, rewritten := LinkSitePages(page, nav, "about")
+	for _, want := range []string{
+		`<a href="/">Home</a>`,
+		`<a aria-current="page" href="/about">About</a>`,
+		`<a href="/contact">Contact</a>`,
+		// An anchor to an element of the page stays, others go to the page
+		`<a href="#contact">Jump</a>`,
+		`<a href="/about">About us</a>`,
+		`<a href="/about#team">Team</a>`,
+		`<a href="/about">Footer</a>`,
+		`<a href="/about">Already</a>`,
+		`<a href="https://example.com/about">Elsewhere</a>`,
+		`<a href="mailto:hi@crumb.co">Mail</a>`,
+		`<a href="/blog">Blog</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("LinkSitePages() lacks %s:\n%s", want, got)
+		}
+	}
+	if rewritten != 6 {
+		t.Errorf("LinkSitePages() rewrote %d links, want 6", rewritten)
+	}
+
+	// aria-current only marks the current page's nav link
+	if strings.Count(got, "aria-current") != 1 {
+		t.Errorf("LinkSitePages() marked %d links current, want 1", strings.Count(got, "aria-current"))
+	}
+}
+
+func TestLinkedSitePage(t *testing.T) {
+	tests := []struct {
+		href           string
+		slug, fragment string
+		anchor         bool
+	}{
+		{"/", "home", "", false},
+		{"index.html", "home", "", false},
+		{"./About.HTML", "about", "", false},
+		{"/our-team/#lead", "our-team", "#lead", false},
+		{"#Contact", "contact", "", true},
+		{"//cdn.example.com/x", "", "", false},
+		{"tel:+14155550142", "", "", false},
+	}
+	for _, tt := range tests {
+		slug, fragment, anchor := linkedSitePage(tt.href)
+		if slug != tt.slug || fragment != tt.fragment || anchor != tt.anchor {
+			t.Errorf("linkedSitePage(%q) = %q, %q, %v; want %q, %q, %v", tt.href, slug, fragment, anchor, tt.slug, tt.fragment, tt.anchor)
+		}
+	}
+}
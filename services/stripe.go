@@ -6,12 +6,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/stripe/stripe-go/v84"
 	"github.com/stripe/stripe-go/v84/checkout/session"
 	"github.com/stripe/stripe-go/v84/customer"
 	"github.com/stripe/stripe-go/v84/paymentintent"
+	"github.com/stripe/stripe-go/v84/price"
+	"github.com/stripe/stripe-go/v84/refund"
 	"github.com/stripe/stripe-go/v84/subscription"
+	"github.com/stripe/stripe-go/v84/subscriptionschedule"
 	"github.com/stripe/stripe-go/v84/webhook"
 )
 
@@ -23,6 +30,17 @@ type StripeService struct {
 	successURL    string
 	cancelURL     string
 	logger        *slog.Logger
+
+	priceMu    sync.Mutex
+	priceCache map[string]cachedCurrencyOptions
+}
+
+// Currency options change rarely, so Stripe is asked at most once per period
+const PRICE_CURRENCY_CACHE_TTL = time.Hour
+
+type cachedCurrencyOptions struct {
+	amounts   map[string]int64
+	fetchedAt time.Time
 }
 
 // NewStripeService creates a new Stripe service
@@ -36,6 +54,27 @@ func NewStripeService(plans []common.Plan, secretKey, webhookSecret, successURL,
 		successURL:    successURL,
 		cancelURL:     cancelURL,
 		logger:        slog.With("service", "StripeService"),
+		priceCache:    make(map[string]cachedCurrencyOptions),
+	}
+}
+
+type idempotencyKeyCtxKey struct{}
+
+// WithIdempotencyKey attaches a request's idempotency key to the context.
+// Stripe calls that create objects pass it on, suffixed per operation, so
+// retried requests don't create duplicates.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyCtxKey{}, key)
+}
+
+// setIdempotencyKey sets the Stripe idempotency key for operation op when the
+// context carries one
+func setIdempotencyKey(ctx context.Context, params *stripe.Params, op string) {
+	if key, ok := ctx.Value(idempotencyKeyCtxKey{}).(string); ok && key != "" {
+		params.SetIdempotencyKey(key + "-" + op)
 	}
 }
 
@@ -133,6 +172,8 @@ func (s *StripeService) CreateCheckoutSession(ctx context.Context, params *Check
 		// Add more fields as needed
 	}
 
+	setIdempotencyKey(ctx, &sessionParams.Params, "checkout-session")
+
 	sess, err := session.New(sessionParams)
 	if err != nil {
 		s.logger.Error("Failed to create checkout session", "error", err)
@@ -238,6 +279,7 @@ func (s *StripeService) CreatePaymentIntentForPlan(ctx context.Context, planId,
 		Description: stripe.String(description),
 		Metadata:    metadata,
 	}
+	setIdempotencyKey(ctx, &params.Params, "payment-intent")
 
 	pi, err := paymentintent.New(params)
 	if err != nil {
@@ -258,6 +300,7 @@ func (s *StripeService) CreatePaymentIntent(ctx context.Context, amount int64, c
 		Description: stripe.String(description),
 		Metadata:    metadata,
 	}
+	setIdempotencyKey(ctx, &params.Params, "payment-intent")
 
 	pi, err := paymentintent.New(params)
 	if err != nil {
@@ -269,28 +312,67 @@ func (s *StripeService) CreatePaymentIntent(ctx context.Context, amount int64, c
 	return pi, nil
 }
 
-// GetOrCreateCustomer retrieves an existing customer or creates a new one
+// GetPaymentIntent retrieves a payment intent by ID
+func (s *StripeService) GetPaymentIntent(ctx context.Context, paymentIntentID string) (*stripe.PaymentIntent, error) {
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+
+	pi, err := paymentintent.Get(paymentIntentID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment intent: %w", err)
+	}
+	return pi, nil
+}
+
+// GetOrCreateCustomer retrieves an existing customer by email or creates a
+// new one. Prefer a stored customer ID: search is eventually consistent and
+// misses customers whose email has changed.
 func (s *StripeService) GetOrCreateCustomer(ctx context.Context, email, name string, metadata map[string]string) (*stripe.Customer, error) {
 	// Try to find existing customer by email
+	customers, err := s.FindCustomersByEmail(ctx, email)
+	if err != nil {
+		s.logger.Warn("Stripe customer search failed, creating customer", "email", email, "error", err)
+	}
+	if len(customers) > 0 {
+		cust := customers[0]
+		s.logger.Info("Found existing Stripe customer", "customer_id", cust.ID, "email", email)
+		return cust, nil
+	}
+
+	return s.CreateCustomer(ctx, email, name, metadata)
+}
+
+// FindCustomersByEmail returns the Stripe customers with the email, oldest
+// first
+func (s *StripeService) FindCustomersByEmail(ctx context.Context, email string) ([]*stripe.Customer, error) {
 	searchParams := &stripe.CustomerSearchParams{
 		SearchParams: stripe.SearchParams{
-			Query: fmt.Sprintf("email:'%s'", email),
+			Query:   fmt.Sprintf("email:'%s'", strings.ReplaceAll(email, "'", "\\'")),
+			Context: ctx,
 		},
 	}
 	iter := customer.Search(searchParams)
 
-	if iter.Next() {
-		cust := iter.Customer()
-		s.logger.Info("Found existing Stripe customer", "customer_id", cust.ID, "email", email)
-		return cust, nil
+	var customers []*stripe.Customer
+	for iter.Next() {
+		customers = append(customers, iter.Customer())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search customers: %w", err)
 	}
 
-	// Create new customer
+	sort.Slice(customers, func(i, j int) bool { return customers[i].Created < customers[j].Created })
+	return customers, nil
+}
+
+// CreateCustomer creates a Stripe customer
+func (s *StripeService) CreateCustomer(ctx context.Context, email, name string, metadata map[string]string) (*stripe.Customer, error) {
 	params := &stripe.CustomerParams{
 		Email:    stripe.String(email),
 		Name:     stripe.String(name),
 		Metadata: metadata,
 	}
+	setIdempotencyKey(ctx, &params.Params, "customer")
 
 	cust, err := customer.New(params)
 	if err != nil {
@@ -302,6 +384,42 @@ func (s *StripeService) GetOrCreateCustomer(ctx context.Context, email, name str
 	return cust, nil
 }
 
+// GetSubscription retrieves a subscription with its items
+func (s *StripeService) GetSubscription(ctx context.Context, subscriptionID string) (*stripe.Subscription, error) {
+	params := &stripe.SubscriptionParams{}
+	params.Context = ctx
+	params.AddExpand("items.data.price.product")
+
+	sub, err := subscription.Get(subscriptionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// SubscriptionPeriod returns the current billing period of a subscription,
+// spanning all of its items. Since API version 2025-03-31 the period is only
+// on the items; the latest invoice's period is the one it billed, not the
+// current one. ok is false when no item carries the period.
+func SubscriptionPeriod(sub *stripe.Subscription) (start, end time.Time, ok bool) {
+	var startUnix, endUnix int64
+	if sub.Items != nil {
+		for _, item := range sub.Items.Data {
+			if item == nil || item.CurrentPeriodStart == 0 || item.CurrentPeriodEnd == 0 {
+				continue
+			}
+			if startUnix == 0 || item.CurrentPeriodStart < startUnix {
+				startUnix = item.CurrentPeriodStart
+			}
+			endUnix = max(endUnix, item.CurrentPeriodEnd)
+		}
+	}
+	if startUnix == 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(startUnix, 0), time.Unix(endUnix, 0), true
+}
+
 // CancelSubscription cancels a subscription
 func (s *StripeService) CancelSubscription(ctx context.Context, subscriptionID string, cancelAtPeriodEnd bool) (*stripe.Subscription, error) {
 	var sub *stripe.Subscription
@@ -327,6 +445,119 @@ func (s *StripeService) CancelSubscription(ctx context.Context, subscriptionID s
 	return sub, nil
 }
 
+// UpdateSubscriptionPrice switches the subscription's item to newPriceID.
+// prorationBehavior is passed through to Stripe ("create_prorations" or "none").
+func (s *StripeService) UpdateSubscriptionPrice(ctx context.Context, subscriptionID, newPriceID, prorationBehavior string) (*stripe.Subscription, error) {
+	current, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if current.Items == nil || len(current.Items.Data) == 0 {
+		return nil, fmt.Errorf("subscription %s has no items", subscriptionID)
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				ID:    stripe.String(current.Items.Data[0].ID),
+				Price: stripe.String(newPriceID),
+			},
+		},
+		ProrationBehavior: stripe.String(prorationBehavior),
+	}
+	params.Context = ctx
+
+	sub, err := subscription.Update(subscriptionID, params)
+	if err != nil {
+		s.logger.Error("Failed to update subscription price", "error", err, "subscription_id", subscriptionID)
+		return nil, fmt.Errorf("failed to update subscription price: %w", err)
+	}
+
+	s.logger.Info("Updated subscription price", "subscription_id", subscriptionID, "price_id", newPriceID, "proration_behavior", prorationBehavior)
+	return sub, nil
+}
+
+// ScheduleSubscriptionPriceChange moves the subscription to newPriceID at the
+// end of the current period using a subscription schedule. The schedule is
+// released afterwards, so the subscription continues on the new price and the
+// change arrives through the customer.subscription.updated webhook.
+func (s *StripeService) ScheduleSubscriptionPriceChange(ctx context.Context, subscriptionID, newPriceID string) (*stripe.SubscriptionSchedule, error) {
+	current, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if current.Items == nil || len(current.Items.Data) == 0 {
+		return nil, fmt.Errorf("subscription %s has no items", subscriptionID)
+	}
+	item := current.Items.Data[0]
+
+	var schedule *stripe.SubscriptionSchedule
+	if current.Schedule != nil && current.Schedule.ID != "" {
+		schedule, err = subscriptionschedule.Get(current.Schedule.ID, nil)
+	} else {
+		schedule, err = subscriptionschedule.New(&stripe.SubscriptionScheduleParams{
+			FromSubscription: stripe.String(subscriptionID),
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscription schedule: %w", err)
+	}
+	if schedule.CurrentPhase == nil {
+		return nil, fmt.Errorf("subscription schedule %s has no current phase", schedule.ID)
+	}
+
+	params := &stripe.SubscriptionScheduleParams{
+		EndBehavior: stripe.String("release"),
+		Phases: []*stripe.SubscriptionSchedulePhaseParams{
+			{
+				Items: []*stripe.SubscriptionSchedulePhaseItemParams{
+					{Price: stripe.String(item.Price.ID), Quantity: stripe.Int64(item.Quantity)},
+				},
+				StartDate: stripe.Int64(schedule.CurrentPhase.StartDate),
+				EndDate:   stripe.Int64(schedule.CurrentPhase.EndDate),
+			},
+			{
+				Items: []*stripe.SubscriptionSchedulePhaseItemParams{
+					{Price: stripe.String(newPriceID), Quantity: stripe.Int64(item.Quantity)},
+				},
+				ProrationBehavior: stripe.String("none"),
+			},
+		},
+	}
+	params.Context = ctx
+
+	schedule, err = subscriptionschedule.Update(schedule.ID, params)
+	if err != nil {
+		s.logger.Error("Failed to schedule subscription price change", "error", err, "subscription_id", subscriptionID)
+		return nil, fmt.Errorf("failed to schedule subscription price change: %w", err)
+	}
+
+	s.logger.Info("Scheduled subscription price change", "subscription_id", subscriptionID, "price_id", newPriceID, "schedule_id", schedule.ID)
+	return schedule, nil
+}
+
+// RefundPayment refunds a payment intent. A nil amount refunds the remaining
+// balance; reason is one of Stripe's refund reasons or empty.
+func (s *StripeService) RefundPayment(ctx context.Context, paymentIntentID string, amount *int64, reason string) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Amount:        amount,
+	}
+	if reason != "" {
+		params.Reason = stripe.String(reason)
+	}
+	params.Context = ctx
+
+	ref, err := refund.New(params)
+	if err != nil {
+		s.logger.Error("Failed to refund payment", "error", err, "payment_intent_id", paymentIntentID)
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	s.logger.Info("Refunded payment", "payment_intent_id", paymentIntentID, "refund_id", ref.ID, "amount", ref.Amount)
+	return ref, nil
+}
+
 // ConstructWebhookEvent constructs and validates a webhook event
 func (s *StripeService) ConstructWebhookEvent(payload []byte, signature string) (stripe.Event, error) {
 	options := &webhook.ConstructEventOptions{
@@ -351,3 +582,57 @@ func (s *StripeService) ParseWebhookData(data *stripe.EventData, target interfac
 	}
 	return nil
 }
+
+// PlanPriceInCurrency returns the plan price in cents for currency, using the
+// currency options of the plan's Stripe Price. ok is false when the price has
+// no option for that currency.
+func (s *StripeService) PlanPriceInCurrency(ctx context.Context, plan *common.Plan, currency string) (int64, bool, error) {
+	currency = strings.ToLower(currency)
+	if strings.EqualFold(plan.Currency, currency) {
+		return plan.PriceCents, true, nil
+	}
+	if plan.PriceId == "" {
+		return 0, false, nil
+	}
+
+	amounts, err := s.priceCurrencyOptions(ctx, plan.PriceId)
+	if err != nil {
+		return 0, false, err
+	}
+
+	amount, ok := amounts[currency]
+	return amount, ok, nil
+}
+
+func (s *StripeService) priceCurrencyOptions(ctx context.Context, priceID string) (map[string]int64, error) {
+	s.priceMu.Lock()
+	cached, ok := s.priceCache[priceID]
+	s.priceMu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < PRICE_CURRENCY_CACHE_TTL {
+		return cached.amounts, nil
+	}
+
+	params := &stripe.PriceParams{}
+	params.Context = ctx
+	params.AddExpand("currency_options")
+
+	p, err := price.Get(priceID, params)
+	if err != nil {
+		s.logger.Error("Failed to get price", "price_id", priceID, "error", err)
+		return nil, fmt.Errorf("failed to get price: %w", err)
+	}
+
+	amounts := make(map[string]int64, len(p.CurrencyOptions)+1)
+	amounts[strings.ToLower(string(p.Currency))] = p.UnitAmount
+	for currency, option := range p.CurrencyOptions {
+		if option != nil {
+			amounts[strings.ToLower(currency)] = option.UnitAmount
+		}
+	}
+
+	s.priceMu.Lock()
+	s.priceCache[priceID] = cachedCurrencyOptions{amounts: amounts, fetchedAt: time.Now()}
+	s.priceMu.Unlock()
+
+	return amounts, nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v84"
+)
+
+func TestSubscriptionPeriod(t *testing.T) {
+	item := func(start, end int64) *stripe.SubscriptionItem {
+		return &stripe.SubscriptionItem{CurrentPeriodStart: start, CurrentPeriodEnd: end}
+	}
+	tests := []struct {
+		name      string
+		items     *stripe.SubscriptionItemList
+		wantStart int64
+		wantEnd   int64
+		wantOK    bool
+	}{
+		{"no item list", nil, 0, 0, false},
+		{"no items", &stripe.SubscriptionItemList{}, 0, 0, false},
+		{"items without periods", &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{item(0, 0), nil}}, 0, 0, false},
+		{"one item", &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{item(100, 200)}}, 100, 200, true},
+		{"spans all items", &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{item(150, 250), item(0, 0), item(100, 200)}}, 100, 250, true},
+	}
+	for _, tt := range tests {
+		start, end, ok := SubscriptionPeriod(&stripe.Subscription{Items: tt.items})
+		if ok != tt.wantOK {
+			t.Errorf("%s: SubscriptionPeriod() ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && (!start.Equal(time.Unix(tt.wantStart, 0)) || !end.Equal(time.Unix(tt.wantEnd, 0))) {
+			t.Errorf("%s: SubscriptionPeriod() = %d to %d, want %d to %d", tt.name, start.Unix(), end.Unix(), tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestSubscriptionPeriodUnexpandedPayload(t *testing.T) {
+	// latest_invoice is only an ID in webhook payloads, so its period is zero
+	payload := `{
+		"id": "sub_1",
+		"object": "subscription",
+		"latest_invoice": "in_1",
+		"items": {"object": "list", "data": [{"id": "si_1", "object": "subscription_item", "current_period_start": 1790000000, "current_period_end": 1792592000}]}
+	}`
+	var sub stripe.Subscription
+	if err := json.Unmarshal([]byte(payload), &sub); err != nil {
+		t.Fatal(err)
+	}
+	if sub.LatestInvoice == nil || sub.LatestInvoice.PeriodStart != 0 {
+		t.Fatalf("latest_invoice = %+v, want an unexpanded invoice", sub.LatestInvoice)
+	}
+	start, end, ok := SubscriptionPeriod(&sub)
+	if !ok || start.Unix() != 1790000000 || end.Unix() != 1792592000 {
+		t.Errorf("SubscriptionPeriod() = %s to %s, %v; want the item's period", start, end, ok)
+	}
+}
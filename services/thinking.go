@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"awning-backend/common"
+)
+
+const (
+	// ThinkingPlaceholderInterval is how long the stream may stay silent
+	// before a placeholder is sent, keeping proxies from closing it
+	ThinkingPlaceholderInterval = 10 * time.Second
+
+	thinkingPlaceholder = `{"message":"still thinking..."}`
+
+	// Summaries only look at complete lines, unless a line grows past this
+	maxThinkingLine = 4096
+	maxSummaryChars = 80
+)
+
+// ThinkingStream turns the model's reasoning deltas into thinking events
+// for one generation, according to Config.GetThinkingMode:
+//
+//   - off drops reasoning and sends nothing
+//   - placeholder drops reasoning and sends "still thinking..." every
+//     ThinkingPlaceholderInterval
+//   - throttled buffers reasoning and sends it at most every
+//     thinking_interval_ms, capped at thinking_max_chars, or a short summary
+//     with thinking_summary; placeholders fill silent stretches
+//   - full sends every delta as it arrives
+//
+// Events are passed to send as the JSON data of a "thinking" event. In the
+// placeholder and throttled modes they are sent from the stream's own
+// goroutine between Start and Stop.
+type ThinkingStream struct {
+	mode     string
+	interval time.Duration
+	maxChars int
+	summary  bool
+	send     func(data string)
+
+	mu          sync.Mutex
+	buf         strings.Builder
+	lastSent    time.Time
+	lastSummary string
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewThinkingStream creates a thinking stream for the configured mode
+func NewThinkingStream(cfg *common.Config, send func(data string)) *ThinkingStream {
+	t := &ThinkingStream{
+		mode:     cfg.GetThinkingMode(),
+		interval: time.Duration(cfg.ThinkingIntervalMs) * time.Millisecond,
+		maxChars: cfg.ThinkingMaxChars,
+		summary:  cfg.ThinkingSummary,
+		send:     send,
+		stop:     make(chan struct{}),
+	}
+	if t.mode == common.THINKING_MODE_PLACEHOLDER {
+		t.interval = ThinkingPlaceholderInterval
+	}
+	return t
+}
+
+// Start begins sending throttled events and placeholders until Stop is
+// called or ctx is done
+func (t *ThinkingStream) Start(ctx context.Context) {
+	if t.mode != common.THINKING_MODE_PLACEHOLDER && t.mode != common.THINKING_MODE_THROTTLED {
+		return
+	}
+
+	t.mu.Lock()
+	t.lastSent = time.Now()
+	t.mu.Unlock()
+
+	t.done = make(chan struct{})
+	ticker := time.NewTicker(t.interval)
+
+	go func() {
+		defer close(t.done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				if data := t.next(now, false); data != "" {
+					t.send(data)
+				}
+			case <-t.stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Add takes a reasoning delta from the model
+func (t *ThinkingStream) Add(delta string) {
+	switch t.mode {
+	case common.THINKING_MODE_FULL:
+		data, _ := json.Marshal(map[string]string{
+			"type":    "thinking",
+			"content": delta,
+		})
+		t.send(string(data))
+	case common.THINKING_MODE_THROTTLED:
+		t.mu.Lock()
+		t.buf.WriteString(delta)
+		t.mu.Unlock()
+	}
+}
+
+// Stop ends the placeholders and sends any reasoning still buffered. It is
+// safe to call more than once.
+func (t *ThinkingStream) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		if t.done != nil {
+			<-t.done
+		}
+		if data := t.next(time.Now(), true); data != "" {
+			t.send(data)
+		}
+	})
+}
+
+// next returns the event to send now, if any: buffered reasoning, or a
+// placeholder when nothing was sent for ThinkingPlaceholderInterval. final
+// summarizes partial lines too and never returns a placeholder.
+func (t *ThinkingStream) next(now time.Time, final bool) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.buf.Len() > 0 {
+		if data := t.drain(final); data != "" {
+			t.lastSent = now
+			return data
+		}
+	}
+
+	// Ticks may arrive a little early, so allow some slack
+	if !final && now.Sub(t.lastSent) >= ThinkingPlaceholderInterval-t.interval/2 {
+		t.lastSent = now
+		return thinkingPlaceholder
+	}
+	return ""
+}
+
+// drain empties the buffer into an event. Summaries keep a trailing partial
+// line buffered and are only sent when they change.
+func (t *ThinkingStream) drain(final bool) string {
+	text := t.buf.String()
+
+	if !t.summary {
+		t.buf.Reset()
+
+		event := map[string]any{"type": "thinking", "content": text}
+		if runes := []rune(text); len(runes) > t.maxChars {
+			event["content"] = string(runes[len(runes)-t.maxChars:])
+			event["truncated"] = true
+		}
+		data, _ := json.Marshal(event)
+		return string(data)
+	}
+
+	if !final && len(text) < maxThinkingLine {
+		end := strings.LastIndexByte(text, '\n')
+		if end < 0 {
+			return ""
+		}
+		t.buf.Reset()
+		t.buf.WriteString(text[end+1:])
+		text = text[:end]
+	} else {
+		t.buf.Reset()
+	}
+
+	summary := SummarizeThinking(text)
+	if summary == "" || summary == t.lastSummary {
+		return ""
+	}
+	t.lastSummary = summary
+
+	data, _ := json.Marshal(map[string]string{
+		"type":    "thinking",
+		"message": summary,
+	})
+	return string(data)
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+	boldHeading     = regexp.MustCompile(`^(?:\d+\.\s*)?\*\*([^*]+)\*\*:?$`)
+	numberedStep    = regexp.MustCompile(`^\d+\.\s+([^:.]{3,60})[:.]`)
+	sectionMention  = regexp.MustCompile(`(?i)\b(hero|navigation|nav bar|header|footer|about|services|features|testimonials|pricing|gallery|faq|contact|call to action)\b(?:\s+section)?`)
+)
+
+// SummarizeThinking derives a short progress message from reasoning text:
+// the last heading or numbered step the model wrote, or else the last page
+// section it mentioned. It returns "" when nothing stands out.
+func SummarizeThinking(text string) string {
+	var heading, section string
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			heading = m[1]
+		} else if m := boldHeading.FindStringSubmatch(line); m != nil {
+			heading = m[1]
+		} else if m := numberedStep.FindStringSubmatch(line); m != nil {
+			heading = m[1]
+		}
+
+		if matches := sectionMention.FindAllStringSubmatch(line, -1); matches != nil {
+			section = strings.ToLower(matches[len(matches)-1][1])
+		}
+	}
+
+	var summary string
+	switch {
+	case heading != "":
+		summary = "Planning: " + strings.Trim(strings.TrimSpace(heading), "*:# ")
+	case section != "":
+		summary = "Working on the " + section + " section"
+	default:
+		return ""
+	}
+
+	if runes := []rune(summary); len(runes) > maxSummaryChars {
+		summary = string(runes[:maxSummaryChars-1]) + "…"
+	}
+	return summary
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/common"
+)
+
+// thinkingEvents collects the events a thinking stream sends
+type thinkingEvents struct {
+	mu     sync.Mutex
+	events []map[string]any
+}
+
+func (e *thinkingEvents) send(data string) {
+	var event map[string]any
+	json.Unmarshal([]byte(data), &event)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.events = append(e.events, event)
+}
+
+// content joins the content of the events
+func (e *thinkingEvents) content() string {
+	var b strings.Builder
+	for _, event := range e.events {
+		content, _ := event["content"].(string)
+		b.WriteString(content)
+	}
+	return b.String()
+}
+
+// runThinking streams n deltas of delta over about duration through a
+// thinking stream in mode, returning what it sent
+func runThinking(t *testing.T, mode string, configure func(*common.Config), n int, delta string, duration time.Duration) *thinkingEvents {
+	t.Helper()
+
+	cfg := common.DefaultConfig()
+	cfg.ThinkingMode = mode
+	cfg.ThinkingIntervalMs = 50
+	if configure != nil {
+		configure(cfg)
+	}
+
+	events := &thinkingEvents{}
+	stream := NewThinkingStream(cfg, events.send)
+	stream.Start(context.Background())
+	pause := duration / time.Duration(n)
+	for range n {
+		stream.Add(delta)
+		time.Sleep(pause)
+	}
+	stream.Stop()
+	stream.Stop()
+	return events
+}
+
+func TestThinkingStreamModes(t *testing.T) {
+	const deltas = 200
+	const duration = 400 * time.Millisecond
+	want := strings.Repeat("ab", deltas)
+
+	full := runThinking(t, common.THINKING_MODE_FULL, nil, deltas, "ab", duration)
+	if len(full.events) != deltas || full.content() != want {
+		t.Errorf("full: %d events, want one per delta", len(full.events))
+	}
+
+	if off := runThinking(t, common.THINKING_MODE_OFF, nil, deltas, "ab", duration); len(off.events) != 0 {
+		t.Errorf("off: %d events, want none", len(off.events))
+	}
+
+	// Placeholders only fill silences longer than this stream
+	if placeholder := runThinking(t, common.THINKING_MODE_PLACEHOLDER, nil, deltas, "ab", duration); len(placeholder.events) != 0 {
+		t.Errorf("placeholder: %d events, want none within %s", len(placeholder.events), duration)
+	}
+
+	// At most one event per 50ms interval, plus the final flush, and none
+	// of the reasoning lost
+	throttled := runThinking(t, common.THINKING_MODE_THROTTLED, nil, deltas, "ab", duration)
+	if n := len(throttled.events); n < 2 || n > int(duration/(50*time.Millisecond))+3 {
+		t.Errorf("throttled: %d events for %d deltas over %s", n, deltas, duration)
+	}
+	if throttled.content() != want {
+		t.Errorf("throttled content has %d chars, want all %d", len(throttled.content()), len(want))
+	}
+}
+
+func TestThinkingStreamTruncates(t *testing.T) {
+	events := runThinking(t, common.THINKING_MODE_THROTTLED, func(cfg *common.Config) {
+		cfg.ThinkingIntervalMs = 10000
+		cfg.ThinkingMaxChars = 10
+	}, 20, "abcde", 0)
+
+	if len(events.events) != 1 {
+		t.Fatalf("%d events, want the final flush only", len(events.events))
+	}
+	event := events.events[0]
+	if event["content"] != "abcdeabcde" || event["truncated"] != true {
+		t.Errorf("event = %v, want the last 10 chars marked truncated", event)
+	}
+}
+
+func TestThinkingStreamSummaries(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.ThinkingMode = common.THINKING_MODE_THROTTLED
+	cfg.ThinkingSummary = true
+	events := &thinkingEvents{}
+	stream := NewThinkingStream(cfg, events.send)
+
+	// Driven through next so the test doesn't depend on the ticker
+	now := time.Now()
+	stream.lastSent = now
+	for _, delta := range []string{"## Hero sec", "tion\nSome detail\n", "## Hero section\n", "Then the footer", " section"} {
+		stream.Add(delta)
+		now = now.Add(time.Second)
+		if data := stream.next(now, false); data != "" {
+			events.send(data)
+		}
+	}
+	if data := stream.next(now, true); data != "" {
+		events.send(data)
+	}
+
+	var messages []string
+	for _, event := range events.events {
+		messages = append(messages, event["message"].(string))
+	}
+	want := []string{"Planning: Hero section", "Working on the footer section"}
+	if strings.Join(messages, "|") != strings.Join(want, "|") {
+		t.Errorf("summaries = %q, want %q: unchanged summaries are not repeated", messages, want)
+	}
+}
+
+func TestThinkingStreamPlaceholderAfterSilence(t *testing.T) {
+	cfg := common.DefaultConfig()
+	cfg.ThinkingMode = common.THINKING_MODE_THROTTLED
+	stream := NewThinkingStream(cfg, func(string) {})
+
+	start := time.Now()
+	stream.lastSent = start
+	if data := stream.next(start.Add(ThinkingPlaceholderInterval/2), false); data != "" {
+		t.Errorf("next() after %s of silence = %s, want nothing", ThinkingPlaceholderInterval/2, data)
+	}
+	if data := stream.next(start.Add(ThinkingPlaceholderInterval), false); data != thinkingPlaceholder {
+		t.Errorf("next() after %s of silence = %q, want the placeholder", ThinkingPlaceholderInterval, data)
+	}
+	if data := stream.next(start.Add(2*ThinkingPlaceholderInterval), true); data != "" {
+		t.Errorf("final next() = %q, want no placeholder", data)
+	}
+}
+
+func TestSummarizeThinking(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"Let me consider the colors.", ""},
+		{"## Layout plan\nSome words", "Planning: Layout plan"},
+		{"**Color palette**:\nwarm tones", "Planning: Color palette"},
+		{"1. Build the navigation: links to pages", "Planning: Build the navigation"},
+		{"## First\n## Second", "Planning: Second"},
+		{"I'll add testimonials and then a FAQ section", "Working on the faq section"},
+		{"## " + strings.Repeat("x", 100), "Planning: " + strings.Repeat("x", 69) + "…"},
+	}
+	for _, tt := range tests {
+		if got := SummarizeThinking(tt.text); got != tt.want {
+			t.Errorf("SummarizeThinking(%.30q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle spaces calls at least an interval apart, across goroutines
+type Throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewThrottle creates a throttle allowing one call per interval
+func NewThrottle(interval time.Duration) *Throttle {
+	return &Throttle{interval: interval}
+}
+
+// Wait blocks until the caller's turn, or until ctx is done
+func (t *Throttle) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(t.interval)
+	t.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type unsplashThrottleCtxKey struct{}
+
+// WithUnsplashThrottle makes Unsplash API calls made with the context wait
+// on t, so batch work stays within the API's rate limit
+func WithUnsplashThrottle(ctx context.Context, t *Throttle) context.Context {
+	return context.WithValue(ctx, unsplashThrottleCtxKey{}, t)
+}
+
+// waitUnsplashThrottle waits on the throttle set by WithUnsplashThrottle, if any
+func waitUnsplashThrottle(ctx context.Context) error {
+	if t, ok := ctx.Value(unsplashThrottleCtxKey{}).(*Throttle); ok && t != nil {
+		return t.Wait(ctx)
+	}
+	return nil
+}
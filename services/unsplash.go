@@ -9,6 +9,9 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -93,6 +96,19 @@ type UnsplashService struct {
 	logger    *slog.Logger
 	accessKey string
 	secretKey string
+	baseURL   string
+
+	cache    UnsplashSearchCache
+	cacheTTL time.Duration
+	hooks    UnsplashHooks
+
+	// Rate limit reported by the latest response, guarded by mu
+	mu          sync.Mutex
+	reserve     int
+	limit       int
+	remaining   int
+	updatedAt   time.Time
+	exhaustedAt time.Time // zero unless remaining fell to the reserve
 }
 
 // NewUnsplashService creates a new Unsplash handler
@@ -103,12 +119,20 @@ func NewUnsplashService(accessKey, secretKey string) *UnsplashService {
 		logger:    logger,
 		accessKey: accessKey,
 		secretKey: secretKey,
+		baseURL:   UNSPLASH_API_BASE_URL,
 	}
 }
 
-// SearchPhotos searches Unsplash for photos matching the query
+// SetBaseURL sends API requests to baseURL instead of UNSPLASH_API_BASE_URL,
+// such as a proxy or a fake in tests
+func (s *UnsplashService) SetBaseURL(baseURL string) {
+	s.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// SearchPhotos searches Unsplash for photos matching the query. It returns
+// ErrQuotaExhausted without calling the API while the quota is exhausted.
 func (s *UnsplashService) SearchPhotos(ctx context.Context, query string, page, perPage int, orientation, orderBy string) (*UnsplashSearchResponse, error) {
-	apiURL, err := url.Parse(fmt.Sprintf("%s/search/photos", UNSPLASH_API_BASE_URL))
+	apiURL, err := url.Parse(fmt.Sprintf("%s/search/photos", s.baseURL))
 	if err != nil {
 		return nil, err
 	}
@@ -125,6 +149,13 @@ func (s *UnsplashService) SearchPhotos(ctx context.Context, query string, page,
 	}
 	apiURL.RawQuery = params.Encode()
 
+	if err := s.checkQuota(); err != nil {
+		return nil, err
+	}
+	if err := waitUnsplashThrottle(ctx); err != nil {
+		return nil, err
+	}
+
 	req, err := http.NewRequest("GET", apiURL.String(), nil)
 	if err != nil {
 		return nil, err
@@ -138,8 +169,12 @@ func (s *UnsplashService) SearchPhotos(ctx context.Context, query string, page,
 		return nil, err
 	}
 	defer resp.Body.Close()
+	s.recordQuota(resp)
 
 	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusForbidden && s.QuotaExhausted() {
+			return nil, ErrQuotaExhausted
+		}
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("error calling Unsplash API: %v", string(body))
 	}
@@ -148,12 +183,15 @@ func (s *UnsplashService) SearchPhotos(ctx context.Context, query string, page,
 	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
 		return nil, err
 	}
+	if page == 1 {
+		s.cacheSearch(ctx, query, orientation, &searchResp)
+	}
 	return &searchResp, nil
 }
 
 // GetPhoto retrieves a single photo by its ID from Unsplash
 func (s *UnsplashService) GetPhoto(photoID string) (*UnsplashPhoto, error) {
-	apiURL := fmt.Sprintf("%s/photos/%s", UNSPLASH_API_BASE_URL, url.PathEscape(photoID))
+	apiURL := fmt.Sprintf("%s/photos/%s", s.baseURL, url.PathEscape(photoID))
 
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
@@ -168,6 +206,7 @@ func (s *UnsplashService) GetPhoto(photoID string) (*UnsplashPhoto, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	s.recordQuota(resp)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
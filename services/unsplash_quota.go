@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Unsplash's rate limit is per hour; an exhausted quota is retried after this
+	UNSPLASH_QUOTA_WINDOW = time.Hour
+
+	// Prefix of the keys cached search results are stored under
+	UNSPLASH_SEARCH_CACHE_PREFIX = "unsplash:search:"
+)
+
+// ErrQuotaExhausted is returned instead of searching while the remaining
+// Unsplash quota is at or below the configured reserve
+var ErrQuotaExhausted = errors.New("unsplash quota exhausted")
+
+// UnsplashQuota is the Unsplash rate limit as of the latest response
+type UnsplashQuota struct {
+	Limit     int        `json:"limit"`
+	Remaining int        `json:"remaining"`
+	Reserve   int        `json:"reserve"`
+	Exhausted bool       `json:"exhausted"`
+	Known     bool       `json:"known"` // false until a response reported the limit
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	ResetsAt  *time.Time `json:"resetsAt,omitempty"` // when searches are tried again, while exhausted
+}
+
+// UnsplashHooks observes the Unsplash quota, for metrics
+type UnsplashHooks struct {
+	// QuotaUpdated is called with the quota after each response reporting it
+	QuotaUpdated func(quota UnsplashQuota)
+}
+
+// UnsplashSearchCache stores search results so they can stand in for
+// searches while the quota is exhausted
+type UnsplashSearchCache interface {
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// FindByPattern returns the value of a key matching the glob pattern,
+	// or nil when none does
+	FindByPattern(ctx context.Context, pattern string) ([]byte, error)
+}
+
+type pendingImagesOnlyCtxKey struct{}
+
+// WithPendingImagesOnly makes the image processor fill only the images it
+// left pending while the Unsplash quota was exhausted
+func WithPendingImagesOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pendingImagesOnlyCtxKey{}, true)
+}
+
+// PendingImagesOnly reports whether the context was made by WithPendingImagesOnly
+func PendingImagesOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(pendingImagesOnlyCtxKey{}).(bool)
+	return only
+}
+
+// SetQuotaReserve makes searches fail with ErrQuotaExhausted once the
+// remaining quota is at or below reserve, keeping it for interactive use
+func (s *UnsplashService) SetQuotaReserve(reserve int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserve = reserve
+}
+
+// SetSearchCache stores the first page of each search in cache for ttl
+func (s *UnsplashService) SetSearchCache(cache UnsplashSearchCache, ttl time.Duration) {
+	s.cache = cache
+	s.cacheTTL = ttl
+}
+
+// SetHooks sets the hooks observing the quota
+func (s *UnsplashService) SetHooks(hooks UnsplashHooks) {
+	s.hooks = hooks
+}
+
+// Quota returns the rate limit as of the latest response
+func (s *UnsplashService) Quota() UnsplashQuota {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quotaLocked()
+}
+
+// QuotaExhausted reports whether searches would fail with ErrQuotaExhausted
+func (s *UnsplashService) QuotaExhausted() bool {
+	return s.checkQuota() != nil
+}
+
+func (s *UnsplashService) quotaLocked() UnsplashQuota {
+	quota := UnsplashQuota{
+		Limit:     s.limit,
+		Remaining: s.remaining,
+		Reserve:   s.reserve,
+		Known:     !s.updatedAt.IsZero(),
+	}
+	if quota.Known {
+		updatedAt := s.updatedAt
+		quota.UpdatedAt = &updatedAt
+	}
+	if !s.exhaustedAt.IsZero() {
+		resetsAt := s.exhaustedAt.Add(UNSPLASH_QUOTA_WINDOW)
+		quota.Exhausted = time.Now().Before(resetsAt)
+		if quota.Exhausted {
+			quota.ResetsAt = &resetsAt
+		}
+	}
+	return quota
+}
+
+// checkQuota returns ErrQuotaExhausted while the quota is exhausted. Once
+// the window has passed, searches go through again and the next response
+// tells whether it has recovered.
+func (s *UnsplashService) checkQuota() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.exhaustedAt.IsZero() && time.Since(s.exhaustedAt) < UNSPLASH_QUOTA_WINDOW {
+		return ErrQuotaExhausted
+	}
+	return nil
+}
+
+// recordQuota stores the rate limit reported by a response. A 403 reporting
+// nothing remaining exhausts the quota whatever the reserve.
+func (s *UnsplashService) recordQuota(resp *http.Response) {
+	limit, remaining, ok := parseUnsplashRateLimit(resp.Header)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.limit, s.remaining, s.updatedAt = limit, remaining, time.Now()
+	exhausted := remaining <= s.reserve || (resp.StatusCode == http.StatusForbidden && remaining == 0)
+	switch {
+	case exhausted:
+		s.exhaustedAt = s.updatedAt
+	case !s.exhaustedAt.IsZero():
+		s.exhaustedAt = time.Time{}
+	}
+	quota := s.quotaLocked()
+	s.mu.Unlock()
+
+	if exhausted {
+		s.logger.Warn("Unsplash quota exhausted", "remaining", remaining, "limit", limit, "reserve", quota.Reserve)
+	}
+	if s.hooks.QuotaUpdated != nil {
+		s.hooks.QuotaUpdated(quota)
+	}
+}
+
+// parseUnsplashRateLimit reads the X-Ratelimit-Limit and
+// X-Ratelimit-Remaining headers
+func parseUnsplashRateLimit(h http.Header) (limit, remaining int, ok bool) {
+	remaining, err := strconv.Atoi(strings.TrimSpace(h.Get("X-Ratelimit-Remaining")))
+	if err != nil {
+		return 0, 0, false
+	}
+	limit, _ = strconv.Atoi(strings.TrimSpace(h.Get("X-Ratelimit-Limit")))
+	return limit, remaining, true
+}
+
+// cacheSearch stores the results of a search for CachedSearch. Failures are
+// only logged.
+func (s *UnsplashService) cacheSearch(ctx context.Context, query, orientation string, results *UnsplashSearchResponse) {
+	if s.cache == nil || len(results.Results) == 0 {
+		return
+	}
+	data, err := json.Marshal(results)
+	if err != nil {
+		return
+	}
+	if err := s.cache.SetWithTTL(ctx, unsplashSearchCacheKey(orientation, normalizeSearchKeywords(query)), data, s.cacheTTL); err != nil {
+		s.logger.Warn("Failed to cache Unsplash search", "query", query, "error", err)
+	}
+}
+
+// CachedSearch returns cached results for the query, or for a similar one:
+// a search that started with the same keywords, fewer of them, or any of
+// them, in the same orientation and then in any. It returns nil when there
+// is no cache or nothing matches.
+func (s *UnsplashService) CachedSearch(ctx context.Context, query, orientation string) *UnsplashSearchResponse {
+	if s.cache == nil {
+		return nil
+	}
+
+	keywords := normalizeSearchKeywords(query)
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	if orientation == "" {
+		orientation = "any"
+	}
+	var patterns []string
+	for _, o := range []string{orientation, "*"} {
+		if o != "*" {
+			o = escapeGlob(o)
+		}
+		for i := len(keywords); i > 0; i-- {
+			patterns = append(patterns, UNSPLASH_SEARCH_CACHE_PREFIX+o+":"+escapeGlob(strings.Join(keywords[:i], ","))+"*")
+		}
+		for _, keyword := range keywords[1:] {
+			patterns = append(patterns, UNSPLASH_SEARCH_CACHE_PREFIX+o+":"+escapeGlob(keyword)+"*")
+		}
+	}
+
+	for _, pattern := range patterns {
+		data, err := s.cache.FindByPattern(ctx, pattern)
+		if err != nil {
+			s.logger.Warn("Failed to look up cached Unsplash search", "pattern", pattern, "error", err)
+			return nil
+		}
+		if data == nil {
+			continue
+		}
+		var results UnsplashSearchResponse
+		if err := json.Unmarshal(data, &results); err == nil && len(results.Results) > 0 {
+			return &results
+		}
+	}
+	return nil
+}
+
+// normalizeSearchKeywords returns the comma-separated keywords of a query,
+// lowercased and sorted so the same keywords in any order share a key
+func normalizeSearchKeywords(query string) []string {
+	var keywords []string
+	for _, keyword := range strings.Split(strings.ToLower(query), ",") {
+		if keyword = strings.Join(strings.Fields(keyword), " "); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	slices.Sort(keywords)
+	return slices.Compact(keywords)
+}
+
+func unsplashSearchCacheKey(orientation string, keywords []string) string {
+	if orientation == "" {
+		orientation = "any"
+	}
+	return UNSPLASH_SEARCH_CACHE_PREFIX + orientation + ":" + strings.Join(keywords, ",")
+}
+
+// escapeGlob escapes the characters Redis glob patterns treat specially
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
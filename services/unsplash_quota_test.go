@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseUnsplashRateLimit(t *testing.T) {
+	tests := []struct {
+		limit, remaining string
+		wantLimit        int
+		wantRemaining    int
+		wantOK           bool
+	}{
+		{"50", "42", 50, 42, true},
+		{"", "0", 0, 0, true},
+		{" 5000 ", " 12 ", 5000, 12, true},
+		{"50", "", 0, 0, false},
+		{"50", "lots", 0, 0, false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.limit != "" {
+			h.Set("X-Ratelimit-Limit", tt.limit)
+		}
+		if tt.remaining != "" {
+			h.Set("X-Ratelimit-Remaining", tt.remaining)
+		}
+		limit, remaining, ok := parseUnsplashRateLimit(h)
+		if limit != tt.wantLimit || remaining != tt.wantRemaining || ok != tt.wantOK {
+			t.Errorf("parseUnsplashRateLimit(%q, %q) = %d, %d, %v; want %d, %d, %v",
+				tt.limit, tt.remaining, limit, remaining, ok, tt.wantLimit, tt.wantRemaining, tt.wantOK)
+		}
+	}
+}
+
+// fakeSearchCache is an UnsplashSearchCache over a map, matching patterns
+// the way Redis globs do
+type fakeSearchCache map[string][]byte
+
+func (c fakeSearchCache) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c[key] = value
+	return nil
+}
+
+func (c fakeSearchCache) FindByPattern(ctx context.Context, pattern string) ([]byte, error) {
+	for _, key := range slices.Sorted(maps.Keys(c)) {
+		if ok, _ := path.Match(pattern, key); ok {
+			return c[key], nil
+		}
+	}
+	return nil, nil
+}
+
+// newQuotaServer returns an Unsplash client of a fake API reporting
+// remaining requests left, one fewer after each search, and the number of
+// searches it answered
+func newQuotaServer(t *testing.T, remaining int, status int) (*UnsplashService, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Ratelimit-Limit", "50")
+		w.Header().Set("X-Ratelimit-Remaining", strconv.Itoa(max(remaining-int(n), 0)))
+		if status != http.StatusOK {
+			http.Error(w, "Rate Limit Exceeded", status)
+			return
+		}
+		json.NewEncoder(w).Encode(UnsplashSearchResponse{Total: 1, TotalPages: 1, Results: []UnsplashPhoto{{ID: r.URL.Query().Get("query")}}})
+	}))
+	t.Cleanup(server.Close)
+
+	svc := NewUnsplashService("test-access", "test-secret")
+	svc.SetBaseURL(server.URL)
+	return svc, &calls
+}
+
+func TestUnsplashQuotaReserve(t *testing.T) {
+	svc, calls := newQuotaServer(t, 4, http.StatusOK)
+	svc.SetQuotaReserve(2)
+	var updates []UnsplashQuota
+	svc.SetHooks(UnsplashHooks{QuotaUpdated: func(quota UnsplashQuota) { updates = append(updates, quota) }})
+	ctx := context.Background()
+
+	if quota := svc.Quota(); quota.Known || quota.Exhausted {
+		t.Errorf("Quota() before any response = %+v, want it unknown", quota)
+	}
+
+	// 3 left, then 2: the search reaching the reserve still returns
+	for i := range 2 {
+		if _, err := svc.SearchPhotos(ctx, "bread", 1, 1, "", "relevant"); err != nil {
+			t.Fatalf("search %d: SearchPhotos() error = %v", i+1, err)
+		}
+	}
+	quota := svc.Quota()
+	if !quota.Known || quota.Limit != 50 || quota.Remaining != 2 || quota.Reserve != 2 || !quota.Exhausted || quota.ResetsAt == nil {
+		t.Errorf("Quota() at the reserve = %+v", quota)
+	}
+	if len(updates) != 2 || updates[0].Exhausted || !updates[1].Exhausted {
+		t.Errorf("QuotaUpdated calls = %+v, want one per response", updates)
+	}
+
+	// Further searches don't call the API
+	if _, err := svc.SearchPhotos(ctx, "bread", 1, 1, "", "relevant"); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("SearchPhotos() at the reserve error = %v, want ErrQuotaExhausted", err)
+	}
+	if !svc.QuotaExhausted() || calls.Load() != 2 {
+		t.Errorf("QuotaExhausted() = %v after %d calls, want true after 2", svc.QuotaExhausted(), calls.Load())
+	}
+
+	// Once the window has passed searches are tried again, and a lower
+	// reserve lets them through
+	svc.mu.Lock()
+	svc.exhaustedAt = time.Now().Add(-UNSPLASH_QUOTA_WINDOW)
+	svc.mu.Unlock()
+	svc.SetQuotaReserve(0)
+	if _, err := svc.SearchPhotos(ctx, "bread", 1, 1, "", "relevant"); err != nil {
+		t.Fatalf("SearchPhotos() after the window error = %v", err)
+	}
+	if quota := svc.Quota(); quota.Exhausted || quota.Remaining != 1 || quota.ResetsAt != nil {
+		t.Errorf("Quota() after recovering = %+v", quota)
+	}
+}
+
+func TestUnsplashQuotaForbidden(t *testing.T) {
+	svc, calls := newQuotaServer(t, 1, http.StatusForbidden)
+	ctx := context.Background()
+
+	// A 403 with nothing remaining exhausts the quota without a reserve
+	if _, err := svc.SearchPhotos(ctx, "bread", 1, 1, "", "relevant"); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("SearchPhotos() error = %v, want ErrQuotaExhausted", err)
+	}
+	if _, err := svc.SearchPhotos(ctx, "cake", 1, 1, "", "relevant"); !errors.Is(err, ErrQuotaExhausted) || calls.Load() != 1 {
+		t.Errorf("SearchPhotos() after a 403 = %v after %d calls, want ErrQuotaExhausted after 1", err, calls.Load())
+	}
+
+	// Other failures aren't quota errors
+	other, _ := newQuotaServer(t, 40, http.StatusForbidden)
+	if _, err := other.SearchPhotos(ctx, "bread", 1, 1, "", "relevant"); err == nil || errors.Is(err, ErrQuotaExhausted) || other.QuotaExhausted() {
+		t.Errorf("SearchPhotos() with quota left = %v, want the API's error", err)
+	}
+}
+
+func TestUnsplashCachedSearch(t *testing.T) {
+	svc, _ := newQuotaServer(t, 50, http.StatusOK)
+	ctx := context.Background()
+
+	if got := svc.CachedSearch(ctx, "bread", ""); got != nil {
+		t.Errorf("CachedSearch() without a cache = %+v, want nil", got)
+	}
+
+	cache := fakeSearchCache{}
+	svc.SetSearchCache(cache, time.Hour)
+	for _, search := range []struct{ query, orientation string }{
+		{"Sourdough, Rye  Bread", "landscape"},
+		{"croissant", ""},
+		{"cake*", "portrait"},
+	} {
+		if _, err := svc.SearchPhotos(ctx, search.query, 1, 1, search.orientation, "relevant"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only first pages are cached
+	svc.SearchPhotos(ctx, "baguette", 2, 1, "", "relevant")
+	if _, ok := cache["unsplash:search:landscape:rye bread,sourdough"]; !ok || len(cache) != 3 {
+		t.Fatalf("cache keys = %v, want normalized keywords by orientation", slices.Sorted(maps.Keys(cache)))
+	}
+
+	tests := []struct {
+		query, orientation string
+		want               string // the cached search's query, or "" for none
+	}{
+		{"sourdough, rye bread", "landscape", "Sourdough, Rye  Bread"},
+		{"rye bread", "landscape", "Sourdough, Rye  Bread"},
+		{"rye bread, pastry", "portrait", "Sourdough, Rye  Bread"},
+		{"pastry, sourdough", "", ""}, // only the first cached keyword is a prefix
+		{"croissant", "landscape", "croissant"},
+		{"cake", "portrait", "cake*"},
+		{"cak?", "portrait", ""}, // glob characters match themselves
+		{"baguette", "", ""},
+		{" , ", "", ""},
+	}
+	for _, tt := range tests {
+		got := svc.CachedSearch(ctx, tt.query, tt.orientation)
+		if (got == nil) != (tt.want == "") || got != nil && got.Results[0].ID != tt.want {
+			t.Errorf("CachedSearch(%q, %q) = %+v, want the results for %q", tt.query, tt.orientation, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrDuplicateChatInProgress = errors.New("identical chat request in progress")
+
+// beginChatRequestScript returns the stored result, or marks the request
+// in progress. KEYS: result, lock; ARGV: ttl (ms)
+// Returns {1, result}, {0, ""} when the request was marked, or {2, ""}
+// while an identical request is in progress.
+var beginChatRequestScript = redis.NewScript(`
+local stored = redis.call("GET", KEYS[1])
+if stored then
+	return {1, stored}
+end
+if redis.call("SET", KEYS[2], "1", "NX", "PX", tonumber(ARGV[1])) then
+	return {0, ""}
+end
+return {2, ""}
+`)
+
+func (r *RedisClient) chatRequestKeys(hash string) (result, lock string) {
+	base := r.slotKey("chatdedup:" + hash)
+	return base + ":result", base + ":lock"
+}
+
+// BeginChatRequest returns the stored result of the chat request with the
+// hash, if any. Otherwise it marks the request in progress for ttl, and the
+// caller must then call SaveChatRequestResult or ReleaseChatRequest. While
+// an identical request is in progress it returns
+// ErrDuplicateChatInProgress.
+func (r *RedisClient) BeginChatRequest(ctx context.Context, hash string, ttl time.Duration) ([]byte, error) {
+	resultKey, lockKey := r.chatRequestKeys(hash)
+	res, err := beginChatRequestScript.Run(ctx, r.client, []string{resultKey, lockKey}, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin chat request in Redis: %w", err)
+	}
+
+	state, _ := res[0].(int64)
+	value, _ := res[1].(string)
+	switch state {
+	case 0:
+		return nil, nil
+	case 1:
+		return []byte(value), nil
+	default:
+		return nil, ErrDuplicateChatInProgress
+	}
+}
+
+// SaveChatRequestResult stores the result replayed to identical requests
+// for ttl and clears the in-progress mark
+func (r *RedisClient) SaveChatRequestResult(ctx context.Context, hash string, result []byte, ttl time.Duration) error {
+	resultKey, lockKey := r.chatRequestKeys(hash)
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, resultKey, result, ttl)
+	pipe.Del(ctx, lockKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save chat request result in Redis: %w", err)
+	}
+	return nil
+}
+
+// ReleaseChatRequest clears the in-progress mark without storing a result,
+// so an identical request generates again
+func (r *RedisClient) ReleaseChatRequest(ctx context.Context, hash string) error {
+	_, lockKey := r.chatRequestKeys(hash)
+	if err := r.client.Del(ctx, lockKey).Err(); err != nil {
+		return fmt.Errorf("failed to release chat request in Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"awning-backend/model"
+)
+
+// ChatStore persists chats. SaveChat increments the chat's revision and
+// returns ErrChatConflict when the stored revision no longer matches the
+// one the chat was loaded with; GetChat returns ErrChatNotFound for unknown
+// chats. Stores that keep chats per tenant read the tenant schema from the
+// context.
+type ChatStore interface {
+	SaveChat(ctx context.Context, chat *model.Chat) error
+	GetChat(ctx context.Context, chatID string) (*model.Chat, error)
+
+	// DeleteChat deletes a chat for good, whether live or in the trash
+	DeleteChat(ctx context.Context, chatID string) error
+
+	// ListChats returns up to limit chat IDs in ID order, skipping the
+	// first offset
+	ListChats(ctx context.Context, offset, limit int) ([]string, error)
+
+	ChatTrash
+}
+
+// ChatTrash keeps deleted chats restorable for a retention window. A
+// trashed chat has DeletedAt set and is left out of GetChat and ListChats;
+// the trash methods return ErrChatNotFound for chats that aren't in the
+// trash or were trashed more than retention ago.
+type ChatTrash interface {
+	// TrashChat moves a live chat to the trash
+	TrashChat(ctx context.Context, chatID string, retention time.Duration) error
+	GetTrashedChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error)
+
+	// RestoreChat moves a chat back out of the trash, returning
+	// ErrChatConflict when a live chat has taken its ID
+	RestoreChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error)
+
+	// ListTrashedChats returns the chats in the trash, most recently
+	// deleted first
+	ListTrashedChats(ctx context.Context, retention time.Duration) ([]*model.Chat, error)
+
+	// PurgeTrash deletes chats trashed more than retention ago and returns
+	// how many it deleted. Stores whose trash expires on its own do nothing.
+	PurgeTrash(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// ChatLocker hands out the generation lock for a chat
+type ChatLocker interface {
+	AcquireChatLock(ctx context.Context, chatID string, ttl time.Duration) (*ChatLock, error)
+}
+
+// KV is a key-value store with expiring keys, used for caches and sessions.
+// Get returns an error for missing keys.
+type KV interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	SetSession(ctx context.Context, sessionID string, token string, ttl time.Duration) error
+	GetSession(ctx context.Context, sessionID string) (string, error)
+	DeleteSession(ctx context.Context, sessionID string) error
+}
+
+var (
+	_ ChatStore  = (*RedisClient)(nil)
+	_ ChatLocker = (*RedisClient)(nil)
+	_ KV         = (*RedisClient)(nil)
+)
+
+// ChatLock serializes generations on a chat
+type ChatLock struct {
+	release func(ctx context.Context) error
+}
+
+// Release gives up the lock if it is still ours. Safe to call on nil.
+func (l *ChatLock) Release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	return l.release(ctx)
+}
+
+// UpdateChat loads the chat from store, applies mutate and saves it,
+// reloading and reapplying mutate when a concurrent save wins the race
+func UpdateChat(ctx context.Context, store ChatStore, chatID string, mutate func(chat *model.Chat) error) (*model.Chat, error) {
+	for attempt := 1; ; attempt++ {
+		chat, err := store.GetChat(ctx, chatID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := mutate(chat); err != nil {
+			return nil, err
+		}
+
+		err = store.SaveChat(ctx, chat)
+		if err == nil {
+			return chat, nil
+		}
+		if !errors.Is(err, ErrChatConflict) || attempt == MaxChatSaveAttempts {
+			return nil, err
+		}
+		slog.Debug("Chat save conflict, retrying", "chat_id", chatID, "attempt", attempt)
+	}
+}
+
+// sortTrashed sorts trashed chats most recently deleted first
+func sortTrashed(chats []*model.Chat) {
+	slices.SortFunc(chats, func(a, b *model.Chat) int {
+		if c := cmp.Compare(b.DeletedAt, a.DeletedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// pageIDs returns the page of ids, which must be sorted
+func pageIDs(ids []string, offset, limit int) []string {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(ids) || limit <= 0 {
+		return []string{}
+	}
+	end := min(offset+limit, len(ids))
+	return ids[offset:end]
+}
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+)
+
+func TestSaveChatRevisionConflict(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	chat := model.NewChat("chat-1")
+	if err := r.SaveChat(ctx, chat); err != nil {
+		t.Fatalf("SaveChat() error = %v", err)
+	}
+	if chat.Revision != 1 {
+		t.Errorf("Revision after first save = %d, want 1", chat.Revision)
+	}
+
+	// Two requests load the same revision; the second save loses
+	first, _ := r.GetChat(ctx, "chat-1")
+	second, _ := r.GetChat(ctx, "chat-1")
+	first.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "first")
+	second.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "second")
+	if err := r.SaveChat(ctx, first); err != nil {
+		t.Fatalf("SaveChat() error = %v", err)
+	}
+	if err := r.SaveChat(ctx, second); !errors.Is(err, ErrChatConflict) {
+		t.Fatalf("SaveChat() of a stale chat error = %v, want ErrChatConflict", err)
+	}
+	if second.Revision != 1 {
+		t.Errorf("Revision after a conflict = %d, want it restored to 1", second.Revision)
+	}
+
+	stored, _ := r.GetChat(ctx, "chat-1")
+	if len(stored.Messages) != 1 || stored.Messages[0].Content != "first" || stored.Revision != 2 {
+		t.Errorf("stored chat = %+v, want the first save at revision 2", stored)
+	}
+
+	// Saving a new chat over an existing ID conflicts too
+	if err := r.SaveChat(ctx, model.NewChat("chat-1")); !errors.Is(err, ErrChatConflict) {
+		t.Errorf("SaveChat() of a new chat over an existing one error = %v, want ErrChatConflict", err)
+	}
+}
+
+func TestUpdateChatConcurrent(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := r.SaveChat(ctx, model.NewChat("chat-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fewer writers than save attempts, so every one of them gets in
+	const writers = MaxChatSaveAttempts - 1
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := UpdateChat(ctx, r, "chat-1", func(chat *model.Chat) error {
+				chat.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, fmt.Sprintf("reply %d", i))
+				return nil
+			})
+			if err != nil {
+				t.Errorf("UpdateChat() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stored, err := r.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Messages) != writers {
+		t.Errorf("stored chat has %d messages, want %d: no assistant message may be lost", len(stored.Messages), writers)
+	}
+	if stored.Revision != writers+1 {
+		t.Errorf("Revision = %d, want %d", stored.Revision, writers+1)
+	}
+}
+
+// conflictingStore saves another message behind UpdateChat's back before
+// of its first conflicts saves, so each of those saves conflicts
+type conflictingStore struct {
+	*RedisClient
+	conflicts int
+}
+
+func (s *conflictingStore) SaveChat(ctx context.Context, chat *model.Chat) error {
+	if s.conflicts > 0 {
+		s.conflicts--
+		other, err := s.RedisClient.GetChat(ctx, chat.ID)
+		if err != nil {
+			return err
+		}
+		other.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "concurrent")
+		if err := s.RedisClient.SaveChat(ctx, other); err != nil {
+			return err
+		}
+	}
+	return s.RedisClient.SaveChat(ctx, chat)
+}
+
+func TestUpdateChatRetries(t *testing.T) {
+	tests := []struct {
+		conflicts int
+		wantErr   error
+	}{
+		{0, nil},
+		{MaxChatSaveAttempts - 1, nil},
+		{MaxChatSaveAttempts, ErrChatConflict},
+	}
+	for _, tt := range tests {
+		r, _ := newTestRedis(t)
+		ctx := context.Background()
+		if err := r.SaveChat(ctx, model.NewChat("chat-1")); err != nil {
+			t.Fatal(err)
+		}
+
+		store := &conflictingStore{RedisClient: r, conflicts: tt.conflicts}
+		calls := 0
+		_, err := UpdateChat(ctx, store, "chat-1", func(chat *model.Chat) error {
+			calls++
+			chat.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "mine")
+			return nil
+		})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%d conflicts: UpdateChat() error = %v, want %v", tt.conflicts, err, tt.wantErr)
+		}
+		if want := min(tt.conflicts+1, MaxChatSaveAttempts); calls != want {
+			t.Errorf("%d conflicts: mutate called %d times, want %d", tt.conflicts, calls, want)
+		}
+
+		stored, _ := r.GetChat(ctx, "chat-1")
+		mine := 0
+		for _, m := range stored.Messages {
+			if m.Content == "mine" {
+				mine++
+			}
+		}
+		wantMine := 1
+		if tt.wantErr != nil {
+			wantMine = 0
+		}
+		if mine != wantMine {
+			t.Errorf("%d conflicts: stored chat has our message %d times, want %d", tt.conflicts, mine, wantMine)
+		}
+	}
+}
+
+func TestUpdateChatNotFound(t *testing.T) {
+	r, _ := newTestRedis(t)
+	_, err := UpdateChat(context.Background(), r, "missing", func(chat *model.Chat) error { return nil })
+	if !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("UpdateChat() error = %v, want ErrChatNotFound", err)
+	}
+}
+
+func TestAcquireChatLock(t *testing.T) {
+	r, server := newTestRedis(t)
+	ctx := context.Background()
+
+	lock, err := r.AcquireChatLock(ctx, "chat-1", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireChatLock() error = %v", err)
+	}
+	if _, err := r.AcquireChatLock(ctx, "chat-1", time.Minute); !errors.Is(err, ErrChatLocked) {
+		t.Errorf("second AcquireChatLock() error = %v, want ErrChatLocked", err)
+	}
+	if _, err := r.AcquireChatLock(ctx, "chat-2", time.Minute); err != nil {
+		t.Errorf("AcquireChatLock() of another chat error = %v", err)
+	}
+
+	// A lock that expired and was taken by another generation isn't released
+	server.FastForward(2 * time.Minute)
+	other, err := r.AcquireChatLock(ctx, "chat-1", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireChatLock() after expiry error = %v", err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AcquireChatLock(ctx, "chat-1", time.Minute); !errors.Is(err, ErrChatLocked) {
+		t.Errorf("AcquireChatLock() after a stale release error = %v, want ErrChatLocked", err)
+	}
+
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AcquireChatLock(ctx, "chat-1", time.Minute); err != nil {
+		t.Errorf("AcquireChatLock() after release error = %v", err)
+	}
+
+	var none *ChatLock
+	if err := none.Release(ctx); err != nil {
+		t.Errorf("Release() of nil error = %v", err)
+	}
+}
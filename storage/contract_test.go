@@ -0,0 +1,29 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"awning-backend/storage"
+	"awning-backend/storage/storetest"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestChatStoreContract(t *testing.T) {
+	t.Run("Memory", func(t *testing.T) {
+		storetest.TestChatStore(t, func(t *testing.T) (storage.ChatStore, context.Context) {
+			return storage.NewMemoryStore(), context.Background()
+		})
+	})
+	t.Run("Redis", func(t *testing.T) {
+		storetest.TestChatStore(t, func(t *testing.T) (storage.ChatStore, context.Context) {
+			client, err := storage.NewRedisClient(miniredis.RunT(t).Addr(), "", 0)
+			if err != nil {
+				t.Fatalf("NewRedisClient() error = %v", err)
+			}
+			t.Cleanup(func() { client.Close() })
+			return client, context.Background()
+		})
+	})
+}
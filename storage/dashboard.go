@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DashboardCacheTTL is how long an assembled tenant dashboard is served from
+// the cache
+const DashboardCacheTTL = 60 * time.Second
+
+func dashboardKey(tenantSchema string) string {
+	return fmt.Sprintf("dashboard:%s", tenantSchema)
+}
+
+// GetDashboard returns the cached dashboard for the tenant, or nil when
+// there is none
+func (r *RedisClient) GetDashboard(ctx context.Context, tenantSchema string) ([]byte, error) {
+	data, err := r.client.Get(ctx, dashboardKey(tenantSchema)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dashboard from Redis: %w", err)
+	}
+	return data, nil
+}
+
+// SetDashboard caches the tenant's dashboard for DashboardCacheTTL
+func (r *RedisClient) SetDashboard(ctx context.Context, tenantSchema string, data []byte) error {
+	if err := r.client.Set(ctx, dashboardKey(tenantSchema), data, DashboardCacheTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set dashboard in Redis: %w", err)
+	}
+	return nil
+}
+
+// InvalidateDashboard drops the tenant's cached dashboard
+func (r *RedisClient) InvalidateDashboard(ctx context.Context, tenantSchema string) error {
+	if err := r.client.Del(ctx, dashboardKey(tenantSchema)).Err(); err != nil {
+		return fmt.Errorf("failed to delete dashboard from Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// ExperimentCounters holds the usage recorded for a prompt experiment
+type ExperimentCounters struct {
+	Chats       int64 `json:"chats"`
+	Generations int64 `json:"generations"`
+}
+
+func experimentKeys(name string) (chats, generations string) {
+	base := "experiment:" + name
+	return base + ":chats", base + ":generations"
+}
+
+// RecordExperimentChat counts a new chat assigned to the experiment
+func (r *RedisClient) RecordExperimentChat(ctx context.Context, name string) error {
+	chatsKey, _ := experimentKeys(name)
+	if err := r.client.Incr(ctx, chatsKey).Err(); err != nil {
+		return fmt.Errorf("failed to record experiment chat in Redis: %w", err)
+	}
+	return nil
+}
+
+// RecordExperimentGeneration counts a completed generation that used the
+// experiment's template
+func (r *RedisClient) RecordExperimentGeneration(ctx context.Context, name string) error {
+	_, generationsKey := experimentKeys(name)
+	if err := r.client.Incr(ctx, generationsKey).Err(); err != nil {
+		return fmt.Errorf("failed to record experiment generation in Redis: %w", err)
+	}
+	return nil
+}
+
+// GetExperimentCounters returns the counters for the experiment
+func (r *RedisClient) GetExperimentCounters(ctx context.Context, name string) (*ExperimentCounters, error) {
+	chatsKey, generationsKey := experimentKeys(name)
+	vals, err := r.client.MGet(ctx, chatsKey, generationsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get experiment counters from Redis: %w", err)
+	}
+
+	parse := func(v interface{}) int64 {
+		s, ok := v.(string)
+		if !ok {
+			return 0
+		}
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return n
+	}
+
+	return &ExperimentCounters{
+		Chats:       parse(vals[0]),
+		Generations: parse(vals[1]),
+	}, nil
+}
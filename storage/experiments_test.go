@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExperimentCounters(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	for range 3 {
+		if err := r.RecordExperimentChat(ctx, "warm"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.RecordExperimentGeneration(ctx, "warm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecordExperimentChat(ctx, "bold"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want ExperimentCounters
+	}{
+		{"warm", ExperimentCounters{Chats: 3, Generations: 1}},
+		{"bold", ExperimentCounters{Chats: 1}},
+		{"unused", ExperimentCounters{}},
+	}
+	for _, tt := range tests {
+		got, err := r.GetExperimentCounters(ctx, tt.name)
+		if err != nil {
+			t.Fatalf("GetExperimentCounters(%q) error = %v", tt.name, err)
+		}
+		if *got != tt.want {
+			t.Errorf("GetExperimentCounters(%q) = %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// featureFlagsKey is the hash of feature flag overrides, by flag name
+const featureFlagsKey = "feature_flags"
+
+// GetFeatureFlags returns the stored feature flag overrides
+func (r *RedisClient) GetFeatureFlags(ctx context.Context) (map[string]bool, error) {
+	values, err := r.client.HGetAll(ctx, featureFlagsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feature flags from Redis: %w", err)
+	}
+	flags := make(map[string]bool, len(values))
+	for name, value := range values {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for feature flag %s", value, name)
+		}
+		flags[name] = enabled
+	}
+	return flags, nil
+}
+
+// UpdateFeatureFlags stores the overrides in set and removes those in clear,
+// atomically
+func (r *RedisClient) UpdateFeatureFlags(ctx context.Context, set map[string]bool, clear []string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for name, enabled := range set {
+			pipe.HSet(ctx, featureFlagsKey, name, strconv.FormatBool(enabled))
+		}
+		if len(clear) > 0 {
+			pipe.HDel(ctx, featureFlagsKey, clear...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update feature flags in Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Statuses of an async generation job
+const (
+	GenerationQueued  = "queued"
+	GenerationRunning = "running"
+	GenerationDone    = "done"
+	GenerationFailed  = "failed"
+)
+
+var ErrGenerationJobNotFound = errors.New("generation job not found")
+
+// GenerationProgress is how far an async generation got. Stage is queued,
+// preparing, generating or postprocessing; Step names the processor or
+// retry running during postprocessing.
+type GenerationProgress struct {
+	Stage string `json:"stage"`
+	Step  string `json:"step,omitempty"`
+}
+
+// GenerationJob is the state of a chat generation run in the background.
+// Result holds the model.ChatResponse once done, and Error the error body
+// sent by the synchronous endpoints once failed.
+type GenerationJob struct {
+	ID           string             `json:"id"`
+	TenantSchema string             `json:"tenantSchema"`
+	UserID       uint               `json:"userId"`
+	Status       string             `json:"status"`
+	Progress     GenerationProgress `json:"progress"`
+	ChatID       string             `json:"chatId,omitempty"`
+	Result       json.RawMessage    `json:"result,omitempty"`
+	Error        map[string]any     `json:"error,omitempty"`
+	CreatedAt    time.Time          `json:"createdAt"`
+	UpdatedAt    time.Time          `json:"updatedAt"`
+}
+
+// Finished reports whether the job is done or failed
+func (j *GenerationJob) Finished() bool {
+	return j.Status == GenerationDone || j.Status == GenerationFailed
+}
+
+func generationJobKey(jobID string) string {
+	return fmt.Sprintf("generation-job:%s", jobID)
+}
+
+// SaveGenerationJob stores job, replacing its previous state, for ttl
+func (r *RedisClient) SaveGenerationJob(ctx context.Context, job *GenerationJob, ttl time.Duration) error {
+	job.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to serialize generation job: %w", err)
+	}
+	if err := r.client.Set(ctx, generationJobKey(job.ID), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save generation job to Redis: %w", err)
+	}
+	return nil
+}
+
+// GetGenerationJob returns the job's current state
+func (r *RedisClient) GetGenerationJob(ctx context.Context, jobID string) (*GenerationJob, error) {
+	data, err := r.client.Get(ctx, generationJobKey(jobID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrGenerationJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get generation job from Redis: %w", err)
+	}
+
+	var job GenerationJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to deserialize generation job: %w", err)
+	}
+	return &job, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGenerationJobRoundTrip(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	if _, err := client.GetGenerationJob(ctx, "job-1"); !errors.Is(err, ErrGenerationJobNotFound) {
+		t.Fatalf("GetGenerationJob() of a missing job error = %v, want ErrGenerationJobNotFound", err)
+	}
+
+	job := &GenerationJob{ID: "job-1", TenantSchema: "tenant_a", UserID: 7, Status: GenerationQueued, CreatedAt: time.Now().UTC()}
+	if err := client.SaveGenerationJob(ctx, job, time.Minute); err != nil {
+		t.Fatalf("SaveGenerationJob() error = %v", err)
+	}
+	if job.UpdatedAt.IsZero() {
+		t.Error("SaveGenerationJob() didn't set UpdatedAt")
+	}
+
+	job.Status = GenerationFailed
+	job.Progress = GenerationProgress{Stage: GenerationFailed}
+	job.Error = map[string]any{"error": "model unavailable", "code": "generation_failed"}
+	if err := client.SaveGenerationJob(ctx, job, 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.GetGenerationJob(ctx, "job-1")
+	if err != nil {
+		t.Fatalf("GetGenerationJob() error = %v", err)
+	}
+	if got.Status != GenerationFailed || got.TenantSchema != "tenant_a" || got.UserID != 7 || got.Error["code"] != "generation_failed" || !got.Finished() {
+		t.Errorf("GetGenerationJob() = %+v", got)
+	}
+
+	// Each save sets the TTL it was given
+	if ttl := server.TTL(generationJobKey("job-1")); ttl != 10*time.Minute {
+		t.Errorf("job TTL = %s, want 10m", ttl)
+	}
+	server.FastForward(11 * time.Minute)
+	if _, err := client.GetGenerationJob(ctx, "job-1"); !errors.Is(err, ErrGenerationJobNotFound) {
+		t.Errorf("GetGenerationJob() after the TTL error = %v, want ErrGenerationJobNotFound", err)
+	}
+}
+
+func TestGenerationJobFinished(t *testing.T) {
+	for status, want := range map[string]bool{
+		GenerationQueued:  false,
+		GenerationRunning: false,
+		GenerationDone:    true,
+		GenerationFailed:  true,
+	} {
+		if got := (&GenerationJob{Status: status}).Finished(); got != want {
+			t.Errorf("Finished() of a %s job = %v, want %v", status, got, want)
+		}
+	}
+}
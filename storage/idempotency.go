@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyLockTTL bounds how long a request holds its key, in case the
+// process dies before storing the response
+const IdempotencyLockTTL = time.Minute
+
+var ErrIdempotencyInProgress = errors.New("request with this idempotency key in progress")
+
+// IdempotentResponse is a stored response replayed for repeated requests.
+// Fingerprint identifies the request body the key was first used with.
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	Body        []byte `json:"body"`
+}
+
+// beginIdempotentScript returns the stored response, or takes the lock.
+// KEYS: response, lock; ARGV: fingerprint, lock ttl (ms)
+// Returns {1, response}, {0, ""} when the lock was taken, or {2, fingerprint}
+// of the request holding the lock.
+var beginIdempotentScript = redis.NewScript(`
+local stored = redis.call("GET", KEYS[1])
+if stored then
+	return {1, stored}
+end
+if redis.call("SET", KEYS[2], ARGV[1], "NX", "PX", tonumber(ARGV[2])) then
+	return {0, ""}
+end
+return {2, redis.call("GET", KEYS[2]) or ""}
+`)
+
+func (r *RedisClient) idempotencyKeys(scope, route, key string) (response, lock string) {
+	base := r.slotKey(fmt.Sprintf("idempotency:%s:%s:%s", scope, route, key))
+	return base + ":response", base + ":lock"
+}
+
+// BeginIdempotentRequest returns the stored response for the key, if any.
+// Otherwise it locks the key for the caller, who must then call
+// SaveIdempotentResponse or ReleaseIdempotentRequest. While another request
+// holds the key it returns ErrIdempotencyInProgress with that request's
+// fingerprint.
+func (r *RedisClient) BeginIdempotentRequest(ctx context.Context, scope, route, key, fingerprint string) (*IdempotentResponse, string, error) {
+	responseKey, lockKey := r.idempotencyKeys(scope, route, key)
+	res, err := beginIdempotentScript.Run(ctx, r.client, []string{responseKey, lockKey}, fingerprint, IdempotencyLockTTL.Milliseconds()).Slice()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin idempotent request in Redis: %w", err)
+	}
+
+	state, _ := res[0].(int64)
+	value, _ := res[1].(string)
+	switch state {
+	case 0:
+		return nil, "", nil
+	case 1:
+		var stored IdempotentResponse
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal idempotent response: %w", err)
+		}
+		return &stored, stored.Fingerprint, nil
+	default:
+		return nil, value, ErrIdempotencyInProgress
+	}
+}
+
+// SaveIdempotentResponse stores the response for replay and unlocks the key
+func (r *RedisClient) SaveIdempotentResponse(ctx context.Context, scope, route, key string, response *IdempotentResponse, ttl time.Duration) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotent response: %w", err)
+	}
+
+	responseKey, lockKey := r.idempotencyKeys(scope, route, key)
+	pipe := r.client.TxPipeline()
+	pipe.Set(ctx, responseKey, data, ttl)
+	pipe.Del(ctx, lockKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save idempotent response in Redis: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotentRequest unlocks the key without storing a response, so
+// the request can be retried
+func (r *RedisClient) ReleaseIdempotentRequest(ctx context.Context, scope, route, key string) error {
+	_, lockKey := r.idempotencyKeys(scope, route, key)
+	if err := r.client.Del(ctx, lockKey).Err(); err != nil {
+		return fmt.Errorf("failed to release idempotency key in Redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReaperReport is the outcome of a Redis key reaper run. In a dry run the
+// counts are of the keys that would have been deleted or expired.
+type ReaperReport struct {
+	DryRun     bool                    `json:"dryRun"`
+	StartedAt  time.Time               `json:"startedAt"`
+	FinishedAt time.Time               `json:"finishedAt"`
+	Namespaces []ReaperNamespaceReport `json:"namespaces"`
+}
+
+// ReaperNamespaceReport counts the keys of one namespace that were scanned,
+// deleted and given an expiry
+type ReaperNamespaceReport struct {
+	Namespace string `json:"namespace"`
+	Policy    string `json:"policy"`
+	Scanned   int    `json:"scanned"`
+	Deleted   int    `json:"deleted"`
+	Expired   int    `json:"expired"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reaperReportKey holds the report of the last reaper run
+const reaperReportKey = "redis-reaper:last-report"
+
+var ErrReaperReportNotFound = errors.New("the Redis key reaper hasn't run yet")
+
+// slottedNamespaces are written with slotKey, so in cluster mode their
+// keys start with a hash tag
+var slottedNamespaces = []string{"idempotency", "quota"}
+
+// NamespacePattern returns the SCAN pattern matching the keys of a namespace
+func (r *RedisClient) NamespacePattern(namespace string) string {
+	if r.cluster && slices.Contains(slottedNamespaces, namespace) {
+		return "{" + namespace + ":*"
+	}
+	return namespace + ":*"
+}
+
+// ScanKeys calls fn with each batch of keys matching pattern, fetching about
+// count keys at a time and waiting pause between batches so a full scan
+// doesn't hold up other clients. In cluster mode every master is scanned.
+// Keys may be seen twice when the keyspace changes during the scan.
+func (r *RedisClient) ScanKeys(ctx context.Context, pattern string, count int64, pause time.Duration, fn func(keys []string) error) error {
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, pattern, count).Result()
+			if err != nil {
+				return fmt.Errorf("failed to scan Redis keys: %w", err)
+			}
+			if len(keys) > 0 {
+				if err := fn(keys); err != nil {
+					return err
+				}
+			}
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+	}
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		// Masters are scanned one at a time to keep the load down
+		var mu sync.Mutex
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return scan(ctx, node)
+		})
+	}
+	return scan(ctx, r.client)
+}
+
+// GetValues returns the string values of keys, in order. Keys that are gone
+// have an empty value and false in found.
+func (r *RedisClient) GetValues(ctx context.Context, keys []string) (values []string, found []bool, err error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, fmt.Errorf("failed to get keys from Redis: %w", err)
+	}
+
+	values = make([]string, len(keys))
+	found = make([]bool, len(keys))
+	for i, cmd := range cmds {
+		values[i], found[i] = cmd.Val(), cmd.Err() == nil
+	}
+	return values, found, nil
+}
+
+// DeleteKeys deletes keys and returns how many existed. Each key is deleted
+// by its own command, as in cluster mode they may be in different slots.
+func (r *RedisClient) DeleteKeys(ctx context.Context, keys []string) (int, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to delete keys from Redis: %w", err)
+	}
+
+	deleted := 0
+	for _, cmd := range cmds {
+		deleted += int(cmd.Val())
+	}
+	return deleted, nil
+}
+
+// PersistentKeys returns the keys that exist without an expiry
+func (r *RedisClient) PersistentKeys(ctx context.Context, keys []string) ([]string, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.TTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get key TTLs from Redis: %w", err)
+	}
+
+	var persistent []string
+	for i, cmd := range cmds {
+		if cmd.Val() == -1 {
+			persistent = append(persistent, keys[i])
+		}
+	}
+	return persistent, nil
+}
+
+// ExpireKeys gives each key of keys that exists the ttl, returning how many
+// it was set on
+func (r *RedisClient) ExpireKeys(ctx context.Context, keys []string, ttl time.Duration) (int, error) {
+	pipe := r.client.Pipeline()
+	cmds := make([]*redis.BoolCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Expire(ctx, key, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to expire keys in Redis: %w", err)
+	}
+
+	expired := 0
+	for _, cmd := range cmds {
+		if cmd.Val() {
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+// SaveReaperReport stores the report of the latest reaper run
+func (r *RedisClient) SaveReaperReport(ctx context.Context, report *ReaperReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to serialize reaper report: %w", err)
+	}
+	if err := r.client.Set(ctx, reaperReportKey, data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to save reaper report to Redis: %w", err)
+	}
+	return nil
+}
+
+// GetReaperReport returns the report of the latest reaper run
+func (r *RedisClient) GetReaperReport(ctx context.Context) (*ReaperReport, error) {
+	data, err := r.client.Get(ctx, reaperReportKey).Bytes()
+	if err == redis.Nil {
+		return nil, ErrReaperReportNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reaper report from Redis: %w", err)
+	}
+
+	var report ReaperReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse reaper report: %w", err)
+	}
+	return &report, nil
+}
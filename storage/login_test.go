@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecordLoginFailureLocksAtMaxAttempts(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 1; i < 3; i++ {
+		lockout, err := client.RecordLoginFailure(ctx, "email", "a@example.com", 3, time.Minute)
+		if err != nil {
+			t.Fatalf("RecordLoginFailure() error = %v", err)
+		}
+		if lockout != 0 {
+			t.Fatalf("failure %d locked out for %v, want no lockout below the limit", i, lockout)
+		}
+	}
+	if lockout, _ := client.GetLoginLockout(ctx, "email", "a@example.com"); lockout != 0 {
+		t.Fatalf("GetLoginLockout() = %v below the limit, want 0", lockout)
+	}
+
+	lockout, err := client.RecordLoginFailure(ctx, "email", "a@example.com", 3, time.Minute)
+	if err != nil {
+		t.Fatalf("RecordLoginFailure() error = %v", err)
+	}
+	if lockout != time.Minute {
+		t.Fatalf("failure at the limit locked out for %v, want 1m", lockout)
+	}
+	if got, _ := client.GetLoginLockout(ctx, "email", "a@example.com"); got <= 0 || got > time.Minute {
+		t.Errorf("GetLoginLockout() = %v, want up to 1m", got)
+	}
+
+	// Other identifiers and scopes are counted separately
+	if got, _ := client.GetLoginLockout(ctx, "email", "b@example.com"); got != 0 {
+		t.Errorf("another email is locked out for %v", got)
+	}
+	if got, _ := client.GetLoginLockout(ctx, "ip", "a@example.com"); got != 0 {
+		t.Errorf("another scope is locked out for %v", got)
+	}
+
+	server.FastForward(time.Minute)
+	if got, _ := client.GetLoginLockout(ctx, "email", "a@example.com"); got != 0 {
+		t.Errorf("GetLoginLockout() = %v after the lockout, want 0", got)
+	}
+}
+
+func TestRecordLoginFailureWindow(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	client.RecordLoginFailure(ctx, "ip", "192.0.2.1", 2, time.Minute)
+	// Failures older than the base period no longer count
+	server.FastForward(time.Minute)
+	if lockout, _ := client.RecordLoginFailure(ctx, "ip", "192.0.2.1", 2, time.Minute); lockout != 0 {
+		t.Errorf("failure after the window locked out for %v, want no lockout", lockout)
+	}
+}
+
+func TestRecordLoginFailureDoublesRepeatLockouts(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	want := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour, 16 * time.Hour, MaxLoginLockout, MaxLoginLockout}
+	for i, w := range want {
+		lockout, err := client.RecordLoginFailure(ctx, "email", "a@example.com", 1, time.Hour)
+		if err != nil {
+			t.Fatalf("RecordLoginFailure() error = %v", err)
+		}
+		if lockout != w {
+			t.Errorf("lockout %d = %v, want %v", i+1, lockout, w)
+		}
+		server.FastForward(time.Minute)
+	}
+}
+
+func TestClearLoginFailures(t *testing.T) {
+	client, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	client.RecordLoginFailure(ctx, "email", "a@example.com", 2, time.Minute)
+	if err := client.ClearLoginFailures(ctx, "email", "a@example.com"); err != nil {
+		t.Fatalf("ClearLoginFailures() error = %v", err)
+	}
+	if lockout, _ := client.RecordLoginFailure(ctx, "email", "a@example.com", 2, time.Minute); lockout != 0 {
+		t.Fatalf("failure after clearing locked out for %v, want the count restarted", lockout)
+	}
+
+	// Lockout history survives, so the next lockout is doubled
+	client.RecordLoginFailure(ctx, "email", "a@example.com", 2, time.Minute)
+	client.ClearLoginFailures(ctx, "email", "a@example.com")
+	client.RecordLoginFailure(ctx, "email", "a@example.com", 2, time.Minute)
+	lockout, _ := client.RecordLoginFailure(ctx, "email", "a@example.com", 2, time.Minute)
+	if lockout != 2*time.Minute {
+		t.Errorf("lockout after clearing = %v, want 2m", lockout)
+	}
+}
+
+func TestConsumeOAuthCodeOnce(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := client.SetOAuthCode(ctx, "code-1", []byte(`{"token":"t"}`), time.Minute); err != nil {
+		t.Fatalf("SetOAuthCode() error = %v", err)
+	}
+	login, err := client.ConsumeOAuthCode(ctx, "code-1")
+	if err != nil || string(login) != `{"token":"t"}` {
+		t.Fatalf("ConsumeOAuthCode() = %s, %v; want the stored login", login, err)
+	}
+	if _, err := client.ConsumeOAuthCode(ctx, "code-1"); !errors.Is(err, ErrOAuthCodeNotFound) {
+		t.Errorf("second ConsumeOAuthCode() error = %v, want ErrOAuthCodeNotFound", err)
+	}
+
+	client.SetOAuthCode(ctx, "code-2", []byte(`{}`), time.Minute)
+	server.FastForward(time.Minute)
+	if _, err := client.ConsumeOAuthCode(ctx, "code-2"); !errors.Is(err, ErrOAuthCodeNotFound) {
+		t.Errorf("ConsumeOAuthCode() after the TTL error = %v, want ErrOAuthCodeNotFound", err)
+	}
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"awning-backend/model"
+)
+
+// MemoryStore keeps chats, locks and keys in process memory. It is meant
+// for tests and local runs; nothing survives a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	chats map[string][]byte
+	trash map[string][]byte
+	locks map[string]time.Time
+	keys  map[string]memoryValue
+}
+
+type memoryValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+var (
+	_ ChatStore  = (*MemoryStore)(nil)
+	_ ChatLocker = (*MemoryStore)(nil)
+	_ KV         = (*MemoryStore)(nil)
+)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		chats: make(map[string][]byte),
+		trash: make(map[string][]byte),
+		locks: make(map[string]time.Time),
+		keys:  make(map[string]memoryValue),
+	}
+}
+
+// SaveChat stores a copy of the chat and increments its revision
+func (m *MemoryStore) SaveChat(ctx context.Context, chat *model.Chat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var revision int64
+	if data, ok := m.chats[chat.ID]; ok {
+		current, err := model.FromJSON(data)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize chat: %w", err)
+		}
+		revision = current.Revision
+	}
+	if revision != chat.Revision {
+		return ErrChatConflict
+	}
+
+	chat.Revision++
+	data, err := chat.ToJSON()
+	if err != nil {
+		chat.Revision--
+		return fmt.Errorf("failed to serialize chat: %w", err)
+	}
+	m.chats[chat.ID] = data
+	return nil
+}
+
+// GetChat returns a copy of the stored chat
+func (m *MemoryStore) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
+	m.mu.Lock()
+	data, ok := m.chats[chatID]
+	m.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
+	}
+	chat, err := model.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize chat: %w", err)
+	}
+	return chat, nil
+}
+
+// DeleteChat deletes a chat, live or trashed
+func (m *MemoryStore) DeleteChat(ctx context.Context, chatID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.chats, chatID)
+	delete(m.trash, chatID)
+	return nil
+}
+
+// TrashChat moves a chat to the trash
+func (m *MemoryStore) TrashChat(ctx context.Context, chatID string, retention time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.chats[chatID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
+	}
+	chat, err := model.FromJSON(data)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize chat: %w", err)
+	}
+	chat.DeletedAt = time.Now().Unix()
+	if data, err = chat.ToJSON(); err != nil {
+		return fmt.Errorf("failed to serialize chat: %w", err)
+	}
+	m.trash[chatID] = data
+	delete(m.chats, chatID)
+	return nil
+}
+
+// trashedChat returns the chat in the trash, dropping it once it was
+// trashed more than retention ago. The caller holds m.mu.
+func (m *MemoryStore) trashedChat(chatID string, retention time.Duration) (*model.Chat, error) {
+	data, ok := m.trash[chatID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
+	}
+	chat, err := model.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize chat: %w", err)
+	}
+	if time.Since(time.Unix(chat.DeletedAt, 0)) > retention {
+		delete(m.trash, chatID)
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
+	}
+	return chat, nil
+}
+
+// GetTrashedChat returns a copy of a chat in the trash
+func (m *MemoryStore) GetTrashedChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.trashedChat(chatID, retention)
+}
+
+// RestoreChat moves a chat out of the trash
+func (m *MemoryStore) RestoreChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chat, err := m.trashedChat(chatID, retention)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.chats[chatID]; ok {
+		return nil, ErrChatConflict
+	}
+	chat.DeletedAt = 0
+	data, err := chat.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize chat: %w", err)
+	}
+	m.chats[chatID] = data
+	delete(m.trash, chatID)
+	return chat, nil
+}
+
+// ListTrashedChats lists the chats in the trash
+func (m *MemoryStore) ListTrashedChats(ctx context.Context, retention time.Duration) ([]*model.Chat, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chats := make([]*model.Chat, 0, len(m.trash))
+	for chatID := range m.trash {
+		chat, err := m.trashedChat(chatID, retention)
+		if errors.Is(err, ErrChatNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	sortTrashed(chats)
+	return chats, nil
+}
+
+// PurgeTrash drops chats trashed more than retention ago
+func (m *MemoryStore) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var purged int64
+	for chatID := range m.trash {
+		if _, err := m.trashedChat(chatID, retention); errors.Is(err, ErrChatNotFound) {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// ListChats lists chat IDs a page at a time
+func (m *MemoryStore) ListChats(ctx context.Context, offset, limit int) ([]string, error) {
+	m.mu.Lock()
+	chatIDs := make([]string, 0, len(m.chats))
+	for id := range m.chats {
+		chatIDs = append(chatIDs, id)
+	}
+	m.mu.Unlock()
+
+	slices.Sort(chatIDs)
+	return pageIDs(chatIDs, offset, limit), nil
+}
+
+// AcquireChatLock takes the generation lock for a chat, returning
+// ErrChatLocked when another generation holds it
+func (m *MemoryStore) AcquireChatLock(ctx context.Context, chatID string, ttl time.Duration) (*ChatLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expiresAt, ok := m.locks[chatID]; ok && now.Before(expiresAt) {
+		return nil, ErrChatLocked
+	}
+	expiresAt := now.Add(ttl)
+	m.locks[chatID] = expiresAt
+
+	return &ChatLock{release: func(ctx context.Context) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.locks[chatID] == expiresAt {
+			delete(m.locks, chatID)
+		}
+		return nil
+	}}, nil
+}
+
+// Get returns the value of a key that hasn't expired
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.keys[key]
+	if !ok || time.Now().After(v.expiresAt) {
+		delete(m.keys, key)
+		return nil, fmt.Errorf("key not found: %s", key)
+	}
+	return slices.Clone(v.data), nil
+}
+
+// SetWithTTL stores a value with a TTL
+func (m *MemoryStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key] = memoryValue{data: slices.Clone(value), expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Delete removes a key
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+	return nil
+}
+
+// SetSession stores a session token with a TTL
+func (m *MemoryStore) SetSession(ctx context.Context, sessionID string, token string, ttl time.Duration) error {
+	return m.SetWithTTL(ctx, "session:"+sessionID, []byte(token), ttl)
+}
+
+// GetSession retrieves a session token
+func (m *MemoryStore) GetSession(ctx context.Context, sessionID string) (string, error) {
+	token, err := m.Get(ctx, "session:"+sessionID)
+	if err != nil {
+		return "", fmt.Errorf("session not found: %s", sessionID)
+	}
+	return string(token), nil
+}
+
+// DeleteSession removes a session
+func (m *MemoryStore) DeleteSession(ctx context.Context, sessionID string) error {
+	return m.Delete(ctx, "session:"+sessionID)
+}
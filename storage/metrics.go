@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// metricsTenantAllowlistKey holds the JSON list of tenants labelled by
+// schema in metrics, overriding metrics_tenant_allowlist
+const metricsTenantAllowlistKey = "metrics_tenant_allowlist"
+
+// GetMetricsTenantAllowlist returns the stored metrics tenant allowlist. ok
+// is false when none is stored.
+func (r *RedisClient) GetMetricsTenantAllowlist(ctx context.Context) (tenants []string, ok bool, err error) {
+	data, err := r.client.Get(ctx, metricsTenantAllowlistKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get metrics tenant allowlist from Redis: %w", err)
+	}
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, false, fmt.Errorf("invalid metrics tenant allowlist in Redis: %w", err)
+	}
+	return tenants, true, nil
+}
+
+// SetMetricsTenantAllowlist stores the metrics tenant allowlist, or removes
+// it when tenants is nil
+func (r *RedisClient) SetMetricsTenantAllowlist(ctx context.Context, tenants []string) error {
+	if tenants == nil {
+		if err := r.client.Del(ctx, metricsTenantAllowlistKey).Err(); err != nil {
+			return fmt.Errorf("failed to clear metrics tenant allowlist in Redis: %w", err)
+		}
+		return nil
+	}
+	data, err := json.Marshal(tenants)
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics tenant allowlist: %w", err)
+	}
+	if err := r.client.Set(ctx, metricsTenantAllowlistKey, data, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set metrics tenant allowlist in Redis: %w", err)
+	}
+	return nil
+}
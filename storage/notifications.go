@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// addUnreadScript adjusts the unread counter only while it exists, so a
+// counter that was never loaded isn't started from the wrong value. A
+// counter that would drop below zero is deleted to be recounted.
+// KEYS: counter; ARGV: delta
+var addUnreadScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if redis.call("INCRBY", KEYS[1], ARGV[1]) < 0 then
+	redis.call("DEL", KEYS[1])
+end
+return 1
+`)
+
+func notificationUnreadKey(tenantSchema string) string {
+	return "notifications-unread:" + tenantSchema
+}
+
+// GetUnreadNotifications returns the tenant's cached unread notification
+// count, reporting false when it isn't cached
+func (r *RedisClient) GetUnreadNotifications(ctx context.Context, tenantSchema string) (int64, bool, error) {
+	count, err := r.client.Get(ctx, notificationUnreadKey(tenantSchema)).Int64()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get unread notifications: %w", err)
+	}
+	return count, true, nil
+}
+
+// SetUnreadNotifications caches the tenant's unread notification count
+// unless a count is already cached
+func (r *RedisClient) SetUnreadNotifications(ctx context.Context, tenantSchema string, count int64, ttl time.Duration) error {
+	if err := r.client.SetNX(ctx, notificationUnreadKey(tenantSchema), count, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set unread notifications: %w", err)
+	}
+	return nil
+}
+
+// AddUnreadNotifications adds delta to the tenant's cached unread count, if
+// one is cached
+func (r *RedisClient) AddUnreadNotifications(ctx context.Context, tenantSchema string, delta int64) error {
+	if err := addUnreadScript.Run(ctx, r.client, []string{notificationUnreadKey(tenantSchema)}, delta).Err(); err != nil {
+		return fmt.Errorf("failed to update unread notifications: %w", err)
+	}
+	return nil
+}
+
+// ClearUnreadNotifications drops the tenant's cached unread count, so the
+// next read recounts it
+func (r *RedisClient) ClearUnreadNotifications(ctx context.Context, tenantSchema string) error {
+	if err := r.client.Del(ctx, notificationUnreadKey(tenantSchema)).Err(); err != nil {
+		return fmt.Errorf("failed to clear unread notifications: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUnreadNotificationsCounter(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+
+	// Adding to a count that isn't cached leaves it uncached
+	if err := client.AddUnreadNotifications(ctx, "tenant_a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := client.GetUnreadNotifications(ctx, "tenant_a"); ok || err != nil {
+		t.Errorf("GetUnreadNotifications() after adding to no count = %v, %v; want not cached", ok, err)
+	}
+
+	if err := client.SetUnreadNotifications(ctx, "tenant_a", 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// A count that is already cached isn't replaced by a stale recount
+	client.SetUnreadNotifications(ctx, "tenant_a", 10, time.Hour)
+	client.AddUnreadNotifications(ctx, "tenant_a", 2)
+	client.AddUnreadNotifications(ctx, "tenant_a", -1)
+	if count, ok, _ := client.GetUnreadNotifications(ctx, "tenant_a"); !ok || count != 4 {
+		t.Errorf("GetUnreadNotifications() = %d, %v; want 4", count, ok)
+	}
+	if ttl := server.TTL(notificationUnreadKey("tenant_a")); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("counter TTL = %s, want the hour it was set with", ttl)
+	}
+	if _, ok, _ := client.GetUnreadNotifications(ctx, "tenant_b"); ok {
+		t.Error("tenant_b has tenant_a's count")
+	}
+
+	// A count that would go negative is dropped, to be recounted
+	client.AddUnreadNotifications(ctx, "tenant_a", -5)
+	if _, ok, _ := client.GetUnreadNotifications(ctx, "tenant_a"); ok {
+		t.Error("negative count kept")
+	}
+
+	client.SetUnreadNotifications(ctx, "tenant_a", 1, time.Hour)
+	if err := client.ClearUnreadNotifications(ctx, "tenant_a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := client.GetUnreadNotifications(ctx, "tenant_a"); ok {
+		t.Error("count kept after ClearUnreadNotifications()")
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// QuotaInflightTTL bounds how long an unfinished reservation can hold a slot
+// if the process dies before committing or releasing it
+const QuotaInflightTTL = 15 * time.Minute
+
+// reserveQuotaScript atomically checks used + inflight against limit + granted
+// and takes an inflight slot when there is room. Reservations are members of
+// the inflight sorted set scored by their deadline in milliseconds, so ones
+// that were never committed or released drop out once it passes.
+// KEYS: used, inflight, granted; ARGV: limit, inflight ttl (ms), reservation id
+var reserveQuotaScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", now)
+local used = tonumber(redis.call("GET", KEYS[1]) or "0")
+local inflight = redis.call("ZCARD", KEYS[2])
+local granted = tonumber(redis.call("GET", KEYS[3]) or "0")
+local limit = tonumber(ARGV[1])
+if used + inflight >= limit + granted then
+	return 0
+end
+local ttl = tonumber(ARGV[2])
+redis.call("ZADD", KEYS[2], now + ttl, ARGV[3])
+redis.call("PEXPIRE", KEYS[2], ttl)
+return 1
+`)
+
+// countInflightScript counts the reservations whose deadline hasn't passed.
+// KEYS: inflight
+var countInflightScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+return redis.call("ZCOUNT", KEYS[1], "(" .. now, "+inf")
+`)
+
+// QuotaCounters holds the raw counter values for a quota period
+type QuotaCounters struct {
+	Used     int64
+	Inflight int64
+	Granted  int64
+}
+
+func (r *RedisClient) quotaKeys(tenantSchema, period string) (used, inflight, granted string) {
+	base := r.slotKey(fmt.Sprintf("quota:%s:%s", tenantSchema, period))
+	return base + ":used", base + ":inflight", base + ":granted"
+}
+
+// ReserveQuota reserves one unit of quota for the tenant in the given period,
+// returning the reservation's ID for CommitQuota or ReleaseQuota. The ID is
+// empty when the limit (plus any granted extra) has been reached.
+func (r *RedisClient) ReserveQuota(ctx context.Context, tenantSchema, period string, limit int64) (string, error) {
+	usedKey, inflightKey, grantedKey := r.quotaKeys(tenantSchema, period)
+	id := uuid.NewString()
+	res, err := reserveQuotaScript.Run(ctx, r.client, []string{usedKey, inflightKey, grantedKey}, limit, QuotaInflightTTL.Milliseconds(), id).Int64()
+	if err != nil {
+		return "", fmt.Errorf("failed to reserve quota in Redis: %w", err)
+	}
+	if res != 1 {
+		return "", nil
+	}
+	return id, nil
+}
+
+// CommitQuota converts a reservation into a used unit
+func (r *RedisClient) CommitQuota(ctx context.Context, tenantSchema, period, id string, ttl time.Duration) error {
+	usedKey, inflightKey, _ := r.quotaKeys(tenantSchema, period)
+	pipe := r.client.TxPipeline()
+	pipe.Incr(ctx, usedKey)
+	pipe.Expire(ctx, usedKey, ttl)
+	pipe.ZRem(ctx, inflightKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to commit quota in Redis: %w", err)
+	}
+	slog.Debug("Quota committed", "tenant_schema", tenantSchema, "period", period)
+	return nil
+}
+
+// ReleaseQuota gives back a reservation without consuming quota. Releasing
+// one that already expired or was released is a no-op.
+func (r *RedisClient) ReleaseQuota(ctx context.Context, tenantSchema, period, id string) error {
+	_, inflightKey, _ := r.quotaKeys(tenantSchema, period)
+	if err := r.client.ZRem(ctx, inflightKey, id).Err(); err != nil {
+		return fmt.Errorf("failed to release quota in Redis: %w", err)
+	}
+	return nil
+}
+
+// GrantQuota adds extra units to the tenant's quota for the given period
+func (r *RedisClient) GrantQuota(ctx context.Context, tenantSchema, period string, amount int64, ttl time.Duration) (int64, error) {
+	_, _, grantedKey := r.quotaKeys(tenantSchema, period)
+	pipe := r.client.TxPipeline()
+	incr := pipe.IncrBy(ctx, grantedKey, amount)
+	pipe.Expire(ctx, grantedKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to grant quota in Redis: %w", err)
+	}
+	return incr.Val(), nil
+}
+
+// GetQuotaCounters returns the current counters for the tenant and period
+func (r *RedisClient) GetQuotaCounters(ctx context.Context, tenantSchema, period string) (*QuotaCounters, error) {
+	usedKey, inflightKey, grantedKey := r.quotaKeys(tenantSchema, period)
+	vals, err := r.client.MGet(ctx, usedKey, grantedKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota counters from Redis: %w", err)
+	}
+	inflight, err := countInflightScript.Run(ctx, r.client, []string{inflightKey}).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count inflight quota in Redis: %w", err)
+	}
+
+	parse := func(v interface{}) int64 {
+		s, ok := v.(string)
+		if !ok {
+			return 0
+		}
+		n, _ := strconv.ParseInt(s, 10, 64)
+		return n
+	}
+
+	return &QuotaCounters{
+		Used:     parse(vals[0]),
+		Inflight: inflight,
+		Granted:  parse(vals[1]),
+	}, nil
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReserveQuotaConcurrentAtLimitOne(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := r.ReserveQuota(ctx, "tenant_a", "20261001", 1)
+			if err != nil {
+				t.Errorf("ReserveQuota() error = %v", err)
+				return
+			}
+			if id != "" {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := reserved.Load(); n != 1 {
+		t.Fatalf("%d concurrent reservations succeeded at limit 1, want 1", n)
+	}
+	counters, err := r.GetQuotaCounters(ctx, "tenant_a", "20261001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters.Inflight != 1 || counters.Used != 0 {
+		t.Errorf("counters = %+v, want 1 in flight", counters)
+	}
+}
+
+func TestReserveQuotaCommitAndRelease(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+	reserve := func() string {
+		t.Helper()
+		id, err := r.ReserveQuota(ctx, "tenant_a", "20261001", 1)
+		if err != nil {
+			t.Fatalf("ReserveQuota() error = %v", err)
+		}
+		return id
+	}
+
+	// A released reservation frees its slot
+	id := reserve()
+	if id == "" {
+		t.Fatal("first reservation failed")
+	}
+	if err := r.ReleaseQuota(ctx, "tenant_a", "20261001", id); err != nil {
+		t.Fatal(err)
+	}
+	if id = reserve(); id == "" {
+		t.Fatal("reservation after a release failed")
+	}
+
+	// A committed one uses it up, until more is granted
+	if err := r.CommitQuota(ctx, "tenant_a", "20261001", id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if reserve() != "" {
+		t.Fatal("reservation succeeded with the quota used up")
+	}
+	if _, err := r.GrantQuota(ctx, "tenant_a", "20261001", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if reserve() == "" {
+		t.Fatal("reservation after a grant failed")
+	}
+
+	// Other tenants and periods count separately
+	if id, _ := r.ReserveQuota(ctx, "tenant_b", "20261001", 1); id == "" {
+		t.Error("another tenant's reservation failed")
+	}
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261101", 1); id == "" {
+		t.Error("the next period's reservation failed")
+	}
+}
+
+func TestReleaseQuotaTwice(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	first, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 2)
+	second, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 2)
+	if first == "" || second == "" || first == second {
+		t.Fatalf("reservations = %q, %q, want two distinct", first, second)
+	}
+
+	// Releasing one twice gives back only its own slot
+	for range 2 {
+		if err := r.ReleaseQuota(ctx, "tenant_a", "20261001", first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counters, err := r.GetQuotaCounters(ctx, "tenant_a", "20261001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters.Inflight != 1 {
+		t.Errorf("Inflight = %d after releasing one of two twice, want 1", counters.Inflight)
+	}
+}
+
+func TestReserveQuotaLeakedSlotsExpire(t *testing.T) {
+	r, server := newTestRedis(t)
+	ctx := context.Background()
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	server.SetTime(now)
+
+	// A reservation never committed or released, as when the process dies
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 1); id == "" {
+		t.Fatal("first reservation failed")
+	}
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 1); id != "" {
+		t.Fatal("reservation succeeded with the slot held")
+	}
+
+	// Other reservations on the set don't extend the leaked one's deadline
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 2); id == "" {
+		t.Fatal("reservation under a higher limit failed")
+	}
+	server.SetTime(now.Add(QuotaInflightTTL / 2))
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 3); id == "" {
+		t.Fatal("reservation under a higher limit failed")
+	}
+
+	server.SetTime(now.Add(QuotaInflightTTL + time.Second))
+	counters, err := r.GetQuotaCounters(ctx, "tenant_a", "20261001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counters.Inflight != 1 {
+		t.Errorf("Inflight = %d past the first deadlines, want the 1 taken later", counters.Inflight)
+	}
+	if id, _ := r.ReserveQuota(ctx, "tenant_a", "20261001", 2); id == "" {
+		t.Error("reservation failed after the leaked slots expired")
+	}
+}
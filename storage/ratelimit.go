@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// rateLimitKey returns the counter key of an identifier, such as a client
+// IP, within a scope
+func rateLimitKey(scope, id string) string {
+	return "ratelimit:" + scope + ":" + id
+}
+
+// HitRateLimit counts a request by the identifier in a fixed window. Once
+// more than limit requests were made in the window it returns false with
+// the time left until the window ends.
+func (r *RedisClient) HitRateLimit(ctx context.Context, scope, id string, limit int, window time.Duration) (bool, time.Duration, error) {
+	key := rateLimitKey(scope, id)
+
+	pipe := r.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, fmt.Errorf("failed to count request in Redis: %w", err)
+	}
+	if incr.Val() <= int64(limit) {
+		return true, 0, nil
+	}
+	return false, max(ttl.Val(), 0), nil
+}
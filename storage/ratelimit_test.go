@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHitRateLimit(t *testing.T) {
+	r, server := newTestRedis(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		if allowed, _, err := r.HitRateLimit(ctx, "contact", "203.0.113.7", 3, time.Hour); err != nil || !allowed {
+			t.Fatalf("hit %d = %v, %v; want allowed", i, allowed, err)
+		}
+	}
+	allowed, retryAfter, err := r.HitRateLimit(ctx, "contact", "203.0.113.7", 3, time.Hour)
+	if err != nil || allowed {
+		t.Fatalf("hit over the limit = %v, %v; want refused", allowed, err)
+	}
+	if retryAfter <= 0 || retryAfter > time.Hour {
+		t.Errorf("retry after = %s, want the rest of the hour", retryAfter)
+	}
+
+	// Other identifiers and scopes count apart
+	if allowed, _, _ := r.HitRateLimit(ctx, "contact", "198.51.100.1", 3, time.Hour); !allowed {
+		t.Error("another IP was limited")
+	}
+	if allowed, _, _ := r.HitRateLimit(ctx, "other", "203.0.113.7", 3, time.Hour); !allowed {
+		t.Error("the IP was limited in another scope")
+	}
+
+	// Refused hits don't extend the window
+	server.FastForward(30 * time.Minute)
+	r.HitRateLimit(ctx, "contact", "203.0.113.7", 3, time.Hour)
+	server.FastForward(30 * time.Minute)
+	if allowed, _, _ := r.HitRateLimit(ctx, "contact", "203.0.113.7", 3, time.Hour); !allowed {
+		t.Error("still limited after the window ended")
+	}
+}
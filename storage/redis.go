@@ -2,8 +2,16 @@ package storage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"awning-backend/model"
@@ -11,29 +19,162 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Redis topologies
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
+// RedisOptions configures NewRedisClientWithOptions. Zero values keep
+// go-redis' defaults.
+type RedisOptions struct {
+	Mode string // RedisModeSingle when empty
+
+	// Addrs holds the server in single mode and the seed nodes in cluster
+	// mode. Sentinel mode uses SentinelAddrs instead.
+	Addrs []string
+
+	MasterName       string
+	SentinelAddrs    []string
+	SentinelPassword string
+
+	Password string
+	DB       int // Ignored in cluster mode
+
+	TLS           bool
+	TLSSkipVerify bool
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
 // RedisClient wraps the Redis client with chat storage operations
 type RedisClient struct {
-	client *redis.Client
+	client  redis.UniversalClient
+	cluster bool
 }
 
-// NewRedisClient creates a new Redis client
+// NewRedisClient creates a single-node Redis client
 func NewRedisClient(addr, password string, db int) (*RedisClient, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
+	return NewRedisClientWithOptions(RedisOptions{
+		Addrs:    []string{addr},
 		Password: password,
 		DB:       db,
 	})
+}
+
+// NewRedisClientWithOptions creates a Redis client for the topology in opts
+// and checks that it can reach the server
+func NewRedisClientWithOptions(opts RedisOptions) (*RedisClient, error) {
+	r, err := newRedisClient(opts)
+	if err != nil {
+		return nil, err
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		_ = r.client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis in %s mode: %w", redisMode(opts), err)
 	}
 
-	slog.Info("Redis client initialized successfully", "addr", addr)
-	return &RedisClient{client: client}, nil
+	slog.Info("Redis client initialized successfully", "mode", redisMode(opts), "addrs", opts.Addrs, "sentinels", opts.SentinelAddrs, "tls", opts.TLS)
+	return r, nil
+}
+
+// redisMode returns the mode of opts, defaulting to single
+func redisMode(opts RedisOptions) string {
+	if opts.Mode == "" {
+		return RedisModeSingle
+	}
+	return opts.Mode
+}
+
+// newRedisClient builds the go-redis client for opts without connecting
+func newRedisClient(opts RedisOptions) (*RedisClient, error) {
+	var tlsConfig *tls.Config
+	if opts.TLS {
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: opts.TLSSkipVerify,
+		}
+	}
+
+	switch mode := redisMode(opts); mode {
+	case RedisModeSingle:
+		if len(opts.Addrs) == 0 || opts.Addrs[0] == "" {
+			return nil, fmt.Errorf("redis %s mode requires an address", mode)
+		}
+		return &RedisClient{client: redis.NewClient(&redis.Options{
+			Addr:         opts.Addrs[0],
+			Password:     opts.Password,
+			DB:           opts.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+		})}, nil
+
+	case RedisModeSentinel:
+		if opts.MasterName == "" || len(opts.SentinelAddrs) == 0 {
+			return nil, fmt.Errorf("redis %s mode requires a master name and sentinel addresses", mode)
+		}
+		return &RedisClient{client: redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.SentinelAddrs,
+			SentinelPassword: opts.SentinelPassword,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         opts.PoolSize,
+			MinIdleConns:     opts.MinIdleConns,
+			DialTimeout:      opts.DialTimeout,
+			ReadTimeout:      opts.ReadTimeout,
+			WriteTimeout:     opts.WriteTimeout,
+		})}, nil
+
+	case RedisModeCluster:
+		if len(opts.Addrs) == 0 {
+			return nil, fmt.Errorf("redis %s mode requires seed node addresses", mode)
+		}
+		return &RedisClient{client: redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Password:     opts.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+		}), cluster: true}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", mode)
+	}
+}
+
+// Client returns the underlying go-redis client for packages that manage
+// their own keys, such as the job queue
+func (r *RedisClient) Client() redis.UniversalClient {
+	return r.client
+}
+
+// slotKey returns base for use as a prefix of keys that one script or
+// transaction touches together. In cluster mode it is wrapped in a hash tag
+// so those keys share a slot; other modes keep their existing key names.
+func (r *RedisClient) slotKey(base string) string {
+	if r.cluster {
+		return "{" + base + "}"
+	}
+	return base
 }
 
 // Close closes the Redis connection
@@ -41,29 +182,107 @@ func (r *RedisClient) Close() error {
 	return r.client.Close()
 }
 
-// SaveChat saves a chat to Redis
+var (
+	ErrChatNotFound = errors.New("chat not found")
+	ErrChatConflict = errors.New("chat was modified concurrently")
+	ErrChatLocked   = errors.New("chat generation in progress")
+
+	ErrOAuthCodeNotFound  = errors.New("OAuth code not found or already used")
+	ErrOAuthStateNotFound = errors.New("OAuth state not found or already used")
+)
+
+// MaxChatSaveAttempts bounds the reload and retry loop in UpdateChat
+const MaxChatSaveAttempts = 5
+
+// ChatGenerationLockTTL bounds how long a crashed generation can hold a chat
+const ChatGenerationLockTTL = 15 * time.Minute
+
+// saveChatScript writes the chat only if the stored revision still matches
+// the one it was loaded with. A missing chat has revision 0.
+// KEYS: chat; ARGV: expected revision, chat JSON
+var saveChatScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+local revision = 0
+if current then
+	local ok, decoded = pcall(cjson.decode, current)
+	if ok and type(decoded) == "table" and tonumber(decoded.revision) then
+		revision = tonumber(decoded.revision)
+	end
+end
+if revision ~= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[2])
+return 1
+`)
+
+// releaseLockScript deletes a lock only if it is still held with our token
+// KEYS: lock; ARGV: token
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// SaveChat saves a chat to Redis and increments its revision. It returns
+// ErrChatConflict when the chat was saved by someone else since it was loaded.
 func (r *RedisClient) SaveChat(ctx context.Context, chat *model.Chat) error {
+	expected := chat.Revision
+	chat.Revision++
+
 	data, err := chat.ToJSON()
 	if err != nil {
+		chat.Revision = expected
 		return fmt.Errorf("failed to serialize chat: %w", err)
 	}
 
 	key := fmt.Sprintf("chat:%s", chat.ID)
-	err = r.client.Set(ctx, key, data, 0).Err()
+	saved, err := saveChatScript.Run(ctx, r.client, []string{key}, expected, data).Int64()
 	if err != nil {
+		chat.Revision = expected
 		return fmt.Errorf("failed to save chat to Redis: %w", err)
 	}
+	if saved == 0 {
+		chat.Revision = expected
+		return ErrChatConflict
+	}
 
-	slog.Debug("Chat saved to Redis", "chat_id", chat.ID)
+	slog.Debug("Chat saved to Redis", "chat_id", chat.ID, "revision", chat.Revision)
 	return nil
 }
 
+// AcquireChatLock takes the generation lock for a chat, returning
+// ErrChatLocked when another generation holds it
+func (r *RedisClient) AcquireChatLock(ctx context.Context, chatID string, ttl time.Duration) (*ChatLock, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+
+	key := fmt.Sprintf("chat-lock:%s", chatID)
+	token := hex.EncodeToString(buf)
+	ok, err := r.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire chat lock: %w", err)
+	}
+	if !ok {
+		return nil, ErrChatLocked
+	}
+	return &ChatLock{release: func(ctx context.Context) error {
+		if err := releaseLockScript.Run(ctx, r.client, []string{key}, token).Err(); err != nil {
+			return fmt.Errorf("failed to release chat lock: %w", err)
+		}
+		return nil
+	}}, nil
+}
+
 // GetChat retrieves a chat from Redis
 func (r *RedisClient) GetChat(ctx context.Context, chatID string) (*model.Chat, error) {
 	key := fmt.Sprintf("chat:%s", chatID)
 	data, err := r.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
-		return nil, fmt.Errorf("chat not found: %s", chatID)
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chat from Redis: %w", err)
@@ -78,32 +297,155 @@ func (r *RedisClient) GetChat(ctx context.Context, chatID string) (*model.Chat,
 	return chat, nil
 }
 
-// DeleteChat deletes a chat from Redis
+// DeleteChat deletes a chat from Redis, along with its copy in the trash.
+// The keys are deleted one at a time since they may live in different
+// cluster slots.
 func (r *RedisClient) DeleteChat(ctx context.Context, chatID string) error {
-	key := fmt.Sprintf("chat:%s", chatID)
-	err := r.client.Del(ctx, key).Err()
-	if err != nil {
-		return fmt.Errorf("failed to delete chat from Redis: %w", err)
+	for _, key := range []string{"chat:" + chatID, "chat-trash:" + chatID} {
+		if err := r.client.Del(ctx, key).Err(); err != nil {
+			return fmt.Errorf("failed to delete chat from Redis: %w", err)
+		}
 	}
 
 	slog.Debug("Chat deleted from Redis", "chat_id", chatID)
 	return nil
 }
 
-// ListChats lists all chat IDs (for debugging/admin purposes)
-func (r *RedisClient) ListChats(ctx context.Context) ([]string, error) {
-	keys, err := r.client.Keys(ctx, "chat:*").Result()
+// ListChats lists chat IDs a page at a time (for debugging/admin purposes).
+// Redis has no index of chats, so every page scans all chat keys.
+func (r *RedisClient) ListChats(ctx context.Context, offset, limit int) ([]string, error) {
+	chatIDs, err := r.scanKeys(ctx, "chat:")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list chats: %w", err)
 	}
 
-	// Strip "chat:" prefix from keys
-	chatIDs := make([]string, len(keys))
-	for i, key := range keys {
-		chatIDs[i] = key[5:] // Remove "chat:" prefix
+	slices.Sort(chatIDs)
+	return pageIDs(chatIDs, offset, limit), nil
+}
+
+// scanKeys returns every key starting with prefix, without the prefix
+func (r *RedisClient) scanKeys(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			names = append(names, strings.TrimPrefix(iter.Val(), prefix))
+		}
+		return iter.Err()
 	}
 
-	return chatIDs, nil
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		// Each master holds its own share of the keys
+		var mu sync.Mutex
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return scan(ctx, node)
+		})
+		return names, err
+	}
+	return names, scan(ctx, r.client)
+}
+
+// TrashChat moves a chat to chat-trash:<id>, which expires after retention.
+// The trashed copy is written before the chat is deleted, so a failure
+// midway leaves the chat live rather than lost.
+func (r *RedisClient) TrashChat(ctx context.Context, chatID string, retention time.Duration) error {
+	chat, err := r.GetChat(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	chat.DeletedAt = time.Now().Unix()
+	data, err := chat.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize chat: %w", err)
+	}
+	if err := r.client.Set(ctx, "chat-trash:"+chatID, data, retention).Err(); err != nil {
+		return fmt.Errorf("failed to move chat to the trash: %w", err)
+	}
+	if err := r.client.Del(ctx, "chat:"+chatID).Err(); err != nil {
+		return fmt.Errorf("failed to move chat to the trash: %w", err)
+	}
+
+	slog.Debug("Chat moved to the trash in Redis", "chat_id", chatID)
+	return nil
+}
+
+// GetTrashedChat retrieves a chat from the trash. Trashed chats expire on
+// their own, so retention isn't needed.
+func (r *RedisClient) GetTrashedChat(ctx context.Context, chatID string, _ time.Duration) (*model.Chat, error) {
+	data, err := r.client.Get(ctx, "chat-trash:"+chatID).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: %s", ErrChatNotFound, chatID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat from the trash: %w", err)
+	}
+
+	chat, err := model.FromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize chat: %w", err)
+	}
+	return chat, nil
+}
+
+// RestoreChat moves a chat from the trash back to chat:<id>, keeping its
+// revision
+func (r *RedisClient) RestoreChat(ctx context.Context, chatID string, retention time.Duration) (*model.Chat, error) {
+	chat, err := r.GetTrashedChat(ctx, chatID, retention)
+	if err != nil {
+		return nil, err
+	}
+
+	chat.DeletedAt = 0
+	data, err := chat.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize chat: %w", err)
+	}
+	restored, err := r.client.SetNX(ctx, "chat:"+chatID, data, 0).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore chat: %w", err)
+	}
+	if !restored {
+		return nil, ErrChatConflict
+	}
+	if err := r.client.Del(ctx, "chat-trash:"+chatID).Err(); err != nil {
+		slog.Error("Failed to remove restored chat from the trash", "chat_id", chatID, "error", err)
+	}
+
+	slog.Debug("Chat restored from the trash in Redis", "chat_id", chatID)
+	return chat, nil
+}
+
+// ListTrashedChats lists the chats in the trash. Like ListChats it scans
+// every trash key.
+func (r *RedisClient) ListTrashedChats(ctx context.Context, retention time.Duration) ([]*model.Chat, error) {
+	chatIDs, err := r.scanKeys(ctx, "chat-trash:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trashed chats: %w", err)
+	}
+
+	chats := make([]*model.Chat, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		chat, err := r.GetTrashedChat(ctx, chatID, retention)
+		if errors.Is(err, ErrChatNotFound) {
+			// Expired since the scan
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	sortTrashed(chats)
+	return chats, nil
+}
+
+// PurgeTrash does nothing: trashed chats expire after the retention they
+// were trashed with
+func (r *RedisClient) PurgeTrash(ctx context.Context, retention time.Duration) (int64, error) {
+	return 0, nil
 }
 
 // Get retrieves a value from Redis by key
@@ -161,6 +503,64 @@ func (r *RedisClient) GetSession(ctx context.Context, sessionID string) (string,
 	return token, nil
 }
 
+// SetOAuthCode stores the login a one-time OAuth code is exchanged for
+func (r *RedisClient) SetOAuthCode(ctx context.Context, code string, login []byte, ttl time.Duration) error {
+	key := fmt.Sprintf("oauth_code:%s", code)
+	if err := r.client.Set(ctx, key, login, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set OAuth code in Redis: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOAuthCode returns the login stored for a one-time OAuth code and
+// deletes it, so each code can be exchanged once
+func (r *RedisClient) ConsumeOAuthCode(ctx context.Context, code string) ([]byte, error) {
+	key := fmt.Sprintf("oauth_code:%s", code)
+	login, err := r.client.GetDel(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrOAuthCodeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth code from Redis: %w", err)
+	}
+	return login, nil
+}
+
+// SetOAuthState stores a pending OAuth login under its state parameter
+func (r *RedisClient) SetOAuthState(ctx context.Context, state string, data []byte, ttl time.Duration) error {
+	key := fmt.Sprintf("oauth_state:%s", state)
+	if err := r.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set OAuth state in Redis: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOAuthState returns the data stored for an OAuth state and deletes
+// it, so each state can be used by one callback
+func (r *RedisClient) ConsumeOAuthState(ctx context.Context, state string) ([]byte, error) {
+	key := fmt.Sprintf("oauth_state:%s", state)
+	data, err := r.client.GetDel(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrOAuthStateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get OAuth state from Redis: %w", err)
+	}
+	return data, nil
+}
+
+// ClaimOAuthAuthorizationCode records that a provider's authorization code
+// was used, returning false if it already was within ttl
+func (r *RedisClient) ClaimOAuthAuthorizationCode(ctx context.Context, provider, code string, ttl time.Duration) (bool, error) {
+	sum := sha256.Sum256([]byte(code))
+	key := fmt.Sprintf("oauth_used_code:%s:%s", provider, hex.EncodeToString(sum[:]))
+	ok, err := r.client.SetNX(ctx, key, time.Now().Unix(), ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim OAuth authorization code in Redis: %w", err)
+	}
+	return ok, nil
+}
+
 // DeleteSession removes a session from Redis
 func (r *RedisClient) DeleteSession(ctx context.Context, sessionID string) error {
 	key := fmt.Sprintf("session:%s", sessionID)
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewRedisClientWithOptionsSingle(t *testing.T) {
+	_, server := newTestRedis(t)
+
+	client, err := NewRedisClientWithOptions(RedisOptions{
+		Addrs:        []string{server.Addr()},
+		DB:           0,
+		PoolSize:     3,
+		MinIdleConns: 1,
+		DialTimeout:  time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewRedisClientWithOptions() error = %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.client.Set(ctx, "greeting", "hi", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := server.Get("greeting"); got != "hi" {
+		t.Errorf("stored greeting = %q, want hi", got)
+	}
+	if opts := client.client.(*redis.Client).Options(); opts.PoolSize != 3 || opts.MinIdleConns != 1 || opts.ReadTimeout != time.Second {
+		t.Errorf("client options = pool %d, idle %d, read timeout %s", opts.PoolSize, opts.MinIdleConns, opts.ReadTimeout)
+	}
+	if client.slotKey("quota") != "quota" {
+		t.Errorf("slotKey() in single mode = %q, want the key unchanged", client.slotKey("quota"))
+	}
+}
+
+func TestNewRedisClientWithOptionsUnreachable(t *testing.T) {
+	_, server := newTestRedis(t)
+	addr := server.Addr()
+	server.Close()
+
+	tests := []struct {
+		mode string
+		opts RedisOptions
+	}{
+		{"single", RedisOptions{Addrs: []string{addr}}},
+		{"sentinel", RedisOptions{Mode: RedisModeSentinel, MasterName: "mymaster", SentinelAddrs: []string{addr}}},
+		{"cluster", RedisOptions{Mode: RedisModeCluster, Addrs: []string{addr}}},
+	}
+	for _, tt := range tests {
+		tt.opts.DialTimeout = 100 * time.Millisecond
+		_, err := NewRedisClientWithOptions(tt.opts)
+		if err == nil || !strings.Contains(err.Error(), "in "+tt.mode+" mode") {
+			t.Errorf("%s: NewRedisClientWithOptions() error = %v, want one naming the mode", tt.mode, err)
+		}
+	}
+}
+
+func TestNewRedisClientModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RedisOptions
+		cluster bool
+		wantErr string
+	}{
+		{"single", RedisOptions{Addrs: []string{"localhost:6379"}, TLS: true}, false, ""},
+		{"single without address", RedisOptions{Mode: RedisModeSingle}, false, "requires an address"},
+		{"sentinel", RedisOptions{Mode: RedisModeSentinel, MasterName: "mymaster", SentinelAddrs: []string{"a:26379", "b:26379"}, TLS: true, TLSSkipVerify: true}, false, ""},
+		{"sentinel without master", RedisOptions{Mode: RedisModeSentinel, SentinelAddrs: []string{"a:26379"}}, false, "requires a master name"},
+		{"cluster", RedisOptions{Mode: RedisModeCluster, Addrs: []string{"a:7000", "b:7000"}, TLS: true}, true, ""},
+		{"cluster without seeds", RedisOptions{Mode: RedisModeCluster}, true, "requires seed node addresses"},
+		{"unknown mode", RedisOptions{Mode: "mesh", Addrs: []string{"a:6379"}}, false, `unknown redis mode "mesh"`},
+	}
+	for _, tt := range tests {
+		r, err := newRedisClient(tt.opts)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: newRedisClient() error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: newRedisClient() error = %v", tt.name, err)
+			continue
+		}
+
+		var tlsSkipVerify, hasTLS bool
+		switch client := r.client.(type) {
+		case *redis.Client:
+			if tt.cluster {
+				t.Errorf("%s: built a single-node client, want a cluster client", tt.name)
+			}
+			hasTLS = client.Options().TLSConfig != nil
+			tlsSkipVerify = hasTLS && client.Options().TLSConfig.InsecureSkipVerify
+		case *redis.ClusterClient:
+			if !tt.cluster {
+				t.Errorf("%s: built a cluster client", tt.name)
+			}
+			hasTLS = client.Options().TLSConfig != nil
+			if addrs := client.Options().Addrs; len(addrs) != 2 {
+				t.Errorf("%s: cluster seeds = %q", tt.name, addrs)
+			}
+		default:
+			t.Errorf("%s: built a %T", tt.name, client)
+		}
+		if hasTLS != tt.opts.TLS || tlsSkipVerify != tt.opts.TLSSkipVerify {
+			t.Errorf("%s: TLS = %v (skip verify %v), want %v (%v)", tt.name, hasTLS, tlsSkipVerify, tt.opts.TLS, tt.opts.TLSSkipVerify)
+		}
+		if r.cluster != tt.cluster {
+			t.Errorf("%s: cluster = %v, want %v", tt.name, r.cluster, tt.cluster)
+		}
+		if tt.cluster && r.slotKey("quota") != "{quota}" {
+			t.Errorf("%s: slotKey() = %q, want a hash tag", tt.name, r.slotKey("quota"))
+		}
+		r.Close()
+	}
+}
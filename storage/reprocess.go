@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReprocessBatchTTL is how long the progress of a reprocessing batch is kept
+const ReprocessBatchTTL = 7 * 24 * time.Hour
+
+// MaxReprocessDiffs bounds the dry-run diffs kept per batch
+const MaxReprocessDiffs = 500
+
+var ErrReprocessBatchNotFound = errors.New("reprocess batch not found")
+
+// ReprocessBatch is the progress of re-running processors over saved sites.
+// Counts are of documents (publications and filesystem entries), except
+// Tenants and TenantsDone.
+type ReprocessBatch struct {
+	ID          string            `json:"id"`
+	Processors  []string          `json:"processors"`
+	DryRun      bool              `json:"dryRun"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Tenants     int               `json:"tenants"`
+	TenantsDone int               `json:"tenantsDone"`
+	Processed   int               `json:"processed"`
+	Changed     int               `json:"changed"`
+	Failed      int               `json:"failed"`
+	Diffs       []json.RawMessage `json:"diffs,omitempty"`
+}
+
+func reprocessKey(batchID string) string {
+	return fmt.Sprintf("reprocess:%s", batchID)
+}
+
+// CreateReprocessBatch stores a new batch with zero counts
+func (r *RedisClient) CreateReprocessBatch(ctx context.Context, batch *ReprocessBatch) error {
+	meta, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to serialize reprocess batch: %w", err)
+	}
+
+	key := reprocessKey(batch.ID)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, "meta", meta, "tenants_done", 0, "processed", 0, "changed", 0, "failed", 0)
+	pipe.Expire(ctx, key, ReprocessBatchTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to create reprocess batch in Redis: %w", err)
+	}
+	return nil
+}
+
+// RecordReprocessItem counts the outcome ("changed", "unchanged" or
+// "failed") of one document, keeping diff for dry runs. A document already
+// recorded for the batch, as when a job is delivered again, is not counted
+// twice; the result reports whether it was new.
+func (r *RedisClient) RecordReprocessItem(ctx context.Context, batchID, item, outcome string, diff []byte) (bool, error) {
+	key := reprocessKey(batchID)
+	added, err := r.client.SAdd(ctx, key+":items", item).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record reprocess item: %w", err)
+	}
+	if added == 0 {
+		return false, nil
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Expire(ctx, key+":items", ReprocessBatchTTL)
+	pipe.HIncrBy(ctx, key, "processed", 1)
+	if outcome == "changed" || outcome == "failed" {
+		pipe.HIncrBy(ctx, key, outcome, 1)
+	}
+	if diff != nil {
+		pipe.RPush(ctx, key+":diffs", diff)
+		pipe.LTrim(ctx, key+":diffs", 0, MaxReprocessDiffs-1)
+		pipe.Expire(ctx, key+":diffs", ReprocessBatchTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return true, fmt.Errorf("failed to record reprocess item: %w", err)
+	}
+	return true, nil
+}
+
+// ReprocessTenantDone reports whether the tenant was already finished in the batch
+func (r *RedisClient) ReprocessTenantDone(ctx context.Context, batchID, tenantSchema string) (bool, error) {
+	done, err := r.client.SIsMember(ctx, reprocessKey(batchID)+":tenants", tenantSchema).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check reprocess tenant: %w", err)
+	}
+	return done, nil
+}
+
+// FinishReprocessTenant marks the tenant as finished, counting it once
+func (r *RedisClient) FinishReprocessTenant(ctx context.Context, batchID, tenantSchema string) error {
+	key := reprocessKey(batchID)
+	added, err := r.client.SAdd(ctx, key+":tenants", tenantSchema).Result()
+	if err != nil {
+		return fmt.Errorf("failed to finish reprocess tenant: %w", err)
+	}
+	if added == 0 {
+		return nil
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.Expire(ctx, key+":tenants", ReprocessBatchTTL)
+	pipe.HIncrBy(ctx, key, "tenants_done", 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to finish reprocess tenant: %w", err)
+	}
+	return nil
+}
+
+// GetReprocessBatch returns a batch with its current counts and diffs
+func (r *RedisClient) GetReprocessBatch(ctx context.Context, batchID string) (*ReprocessBatch, error) {
+	key := reprocessKey(batchID)
+	fields, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reprocess batch from Redis: %w", err)
+	}
+	if fields["meta"] == "" {
+		return nil, ErrReprocessBatchNotFound
+	}
+
+	var batch ReprocessBatch
+	if err := json.Unmarshal([]byte(fields["meta"]), &batch); err != nil {
+		return nil, fmt.Errorf("failed to deserialize reprocess batch: %w", err)
+	}
+	batch.TenantsDone, _ = strconv.Atoi(fields["tenants_done"])
+	batch.Processed, _ = strconv.Atoi(fields["processed"])
+	batch.Changed, _ = strconv.Atoi(fields["changed"])
+	batch.Failed, _ = strconv.Atoi(fields["failed"])
+
+	diffs, err := r.client.LRange(ctx, key+":diffs", 0, -1).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get reprocess diffs: %w", err)
+	}
+	for _, diff := range diffs {
+		batch.Diffs = append(batch.Diffs, json.RawMessage(diff))
+	}
+	return &batch, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReprocessBatchCountsOnce(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := r.CreateReprocessBatch(ctx, &ReprocessBatch{ID: "b1", Processors: []string{"cleanup"}, DryRun: true, Tenants: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second delivery of the same documents isn't counted
+	items := []struct {
+		item, outcome string
+		diff          []byte
+	}{
+		{"t_a/publication", "changed", []byte(`{"diff":["-<br>"]}`)},
+		{"t_a/filesystem:site/index.json", "unchanged", nil},
+		{"t_b/publication", "failed", nil},
+	}
+	for delivery := range 2 {
+		for _, it := range items {
+			added, err := r.RecordReprocessItem(ctx, "b1", it.item, it.outcome, it.diff)
+			if err != nil {
+				t.Fatalf("RecordReprocessItem() error = %v", err)
+			}
+			if added != (delivery == 0) {
+				t.Errorf("delivery %d: RecordReprocessItem(%s) = %v", delivery, it.item, added)
+			}
+		}
+		for _, tenant := range []string{"t_a", "t_a", "t_b"} {
+			if err := r.FinishReprocessTenant(ctx, "b1", tenant); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	batch, err := r.GetReprocessBatch(ctx, "b1")
+	if err != nil {
+		t.Fatalf("GetReprocessBatch() error = %v", err)
+	}
+	if batch.Processed != 3 || batch.Changed != 1 || batch.Failed != 1 || batch.TenantsDone != 2 || batch.Tenants != 2 {
+		t.Errorf("GetReprocessBatch() = %+v, want each document and tenant counted once", batch)
+	}
+	if len(batch.Diffs) != 1 || string(batch.Diffs[0]) != `{"diff":["-<br>"]}` {
+		t.Errorf("diffs = %q, want the one dry-run diff", batch.Diffs)
+	}
+	if !batch.DryRun || len(batch.Processors) != 1 {
+		t.Errorf("batch meta = %+v", batch)
+	}
+
+	if done, _ := r.ReprocessTenantDone(ctx, "b1", "t_b"); !done {
+		t.Error("ReprocessTenantDone(t_b) = false after finishing it")
+	}
+	if done, _ := r.ReprocessTenantDone(ctx, "b1", "t_c"); done {
+		t.Error("ReprocessTenantDone(t_c) = true for a tenant never finished")
+	}
+}
+
+func TestGetReprocessBatchNotFound(t *testing.T) {
+	r, _ := newTestRedis(t)
+
+	if _, err := r.GetReprocessBatch(context.Background(), "missing"); !errors.Is(err, ErrReprocessBatchNotFound) {
+		t.Errorf("GetReprocessBatch() error = %v, want ErrReprocessBatchNotFound", err)
+	}
+}
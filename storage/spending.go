@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SpendingCounters are a tenant's tokens and cost in a spending period
+type SpendingCounters struct {
+	Tokens         int64
+	CostMicrocents int64
+}
+
+// setSpendingScript stores rebuilt counters unless they were stored
+// meanwhile, and returns the stored ones.
+// KEYS: counters; ARGV: tokens, cost, ttl (seconds)
+var setSpendingScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	redis.call("HSET", KEYS[1], "tokens", ARGV[1], "cost", ARGV[2])
+	redis.call("EXPIRE", KEYS[1], tonumber(ARGV[3]))
+end
+return redis.call("HMGET", KEYS[1], "tokens", "cost")
+`)
+
+// addSpendingScript adds to the counters, only when they exist.
+// KEYS: counters; ARGV: tokens, cost
+// Returns {tokens, cost} after adding, or nil when there are no counters.
+var addSpendingScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return nil
+end
+return {redis.call("HINCRBY", KEYS[1], "tokens", ARGV[1]), redis.call("HINCRBY", KEYS[1], "cost", ARGV[2])}
+`)
+
+func (r *RedisClient) spendingKey(tenantSchema, period string) string {
+	return r.slotKey(fmt.Sprintf("spending:%s:%s", tenantSchema, period))
+}
+
+// GetSpending returns the tenant's counters for the period. ok is false
+// when they aren't in Redis and must be rebuilt with SetSpending.
+func (r *RedisClient) GetSpending(ctx context.Context, tenantSchema, period string) (counters SpendingCounters, ok bool, err error) {
+	vals, err := r.client.HMGet(ctx, r.spendingKey(tenantSchema, period), "tokens", "cost").Result()
+	if err != nil {
+		return SpendingCounters{}, false, fmt.Errorf("failed to get spending from Redis: %w", err)
+	}
+	return parseSpending(vals)
+}
+
+// SetSpending stores counters rebuilt from usage records for ttl. Counters
+// stored meanwhile win; the stored counters are returned.
+func (r *RedisClient) SetSpending(ctx context.Context, tenantSchema, period string, counters SpendingCounters, ttl time.Duration) (SpendingCounters, error) {
+	res, err := setSpendingScript.Run(ctx, r.client, []string{r.spendingKey(tenantSchema, period)},
+		counters.Tokens, counters.CostMicrocents, int64(ttl.Seconds())).Slice()
+	if err != nil {
+		return SpendingCounters{}, fmt.Errorf("failed to set spending in Redis: %w", err)
+	}
+	stored, _, err := parseSpending(res)
+	return stored, err
+}
+
+// AddSpending adds a generation's tokens and cost to the tenant's counters
+// and returns the new totals. Counters that aren't in Redis are left to be
+// rebuilt, with the generation, from usage records; ok is then false.
+func (r *RedisClient) AddSpending(ctx context.Context, tenantSchema, period string, tokens, costMicrocents int64) (counters SpendingCounters, ok bool, err error) {
+	res, err := addSpendingScript.Run(ctx, r.client, []string{r.spendingKey(tenantSchema, period)}, tokens, costMicrocents).Int64Slice()
+	if errors.Is(err, redis.Nil) {
+		return SpendingCounters{}, false, nil
+	}
+	if err != nil {
+		return SpendingCounters{}, false, fmt.Errorf("failed to add spending in Redis: %w", err)
+	}
+	return SpendingCounters{Tokens: res[0], CostMicrocents: res[1]}, true, nil
+}
+
+// MarkSpendingAlert records that the alert for a threshold was sent in the
+// period, returning false when it already was
+func (r *RedisClient) MarkSpendingAlert(ctx context.Context, tenantSchema, period string, percent int, ttl time.Duration) (bool, error) {
+	key := r.slotKey(fmt.Sprintf("spending-alert:%s:%s:%d", tenantSchema, period, percent))
+	ok, err := r.client.SetNX(ctx, key, "1", ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark spending alert in Redis: %w", err)
+	}
+	return ok, nil
+}
+
+// ClearSpendingAlert forgets a threshold's alert, so it is sent again, as
+// when sending it failed
+func (r *RedisClient) ClearSpendingAlert(ctx context.Context, tenantSchema, period string, percent int) error {
+	key := r.slotKey(fmt.Sprintf("spending-alert:%s:%s:%d", tenantSchema, period, percent))
+	if err := r.client.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to clear spending alert in Redis: %w", err)
+	}
+	return nil
+}
+
+// parseSpending reads HMGET's tokens and cost; ok is false when they're
+// missing
+func parseSpending(vals []interface{}) (counters SpendingCounters, ok bool, err error) {
+	if len(vals) != 2 || vals[0] == nil || vals[1] == nil {
+		return SpendingCounters{}, false, nil
+	}
+	tokens, _ := vals[0].(string)
+	cost, _ := vals[1].(string)
+	if counters.Tokens, err = strconv.ParseInt(tokens, 10, 64); err != nil {
+		return SpendingCounters{}, false, fmt.Errorf("invalid spending tokens in Redis: %w", err)
+	}
+	if counters.CostMicrocents, err = strconv.ParseInt(cost, 10, 64); err != nil {
+		return SpendingCounters{}, false, fmt.Errorf("invalid spending cost in Redis: %w", err)
+	}
+	return counters, true, nil
+}
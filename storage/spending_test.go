@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSpendingCountersRebuild(t *testing.T) {
+	r, server := newTestRedis(t)
+	ctx := context.Background()
+
+	// Missing counters aren't added to, so they're rebuilt with the usage
+	if _, ok, err := r.GetSpending(ctx, "tenant_a", "202610"); ok || err != nil {
+		t.Fatalf("GetSpending() of missing counters = %v, %v; want a miss", ok, err)
+	}
+	if _, ok, err := r.AddSpending(ctx, "tenant_a", "202610", 100, 5); ok || err != nil {
+		t.Fatalf("AddSpending() to missing counters = %v, %v; want a miss", ok, err)
+	}
+	if server.Exists("spending:tenant_a:202610") {
+		t.Fatal("AddSpending() created counters it couldn't rebuild")
+	}
+
+	stored, err := r.SetSpending(ctx, "tenant_a", "202610", SpendingCounters{Tokens: 700, CostMicrocents: 40}, time.Hour)
+	if err != nil || stored != (SpendingCounters{Tokens: 700, CostMicrocents: 40}) {
+		t.Fatalf("SetSpending() = %+v, %v", stored, err)
+	}
+	if ttl := server.TTL("spending:tenant_a:202610"); ttl != time.Hour {
+		t.Errorf("counters TTL = %v, want 1h", ttl)
+	}
+	counters, ok, err := r.AddSpending(ctx, "tenant_a", "202610", 100, 5)
+	if !ok || err != nil || counters != (SpendingCounters{Tokens: 800, CostMicrocents: 45}) {
+		t.Errorf("AddSpending() = %+v, %v, %v; want 800 tokens and 45 microcents", counters, ok, err)
+	}
+
+	// A rebuild racing an earlier one keeps the counters that were stored
+	stored, err = r.SetSpending(ctx, "tenant_a", "202610", SpendingCounters{Tokens: 700}, time.Hour)
+	if err != nil || stored.Tokens != 800 {
+		t.Errorf("SetSpending() over stored counters = %+v, %v; want the stored 800 tokens", stored, err)
+	}
+	if counters, ok, _ := r.GetSpending(ctx, "tenant_a", "202610"); !ok || counters.Tokens != 800 {
+		t.Errorf("GetSpending() = %+v, %v; want 800 tokens", counters, ok)
+	}
+	if _, ok, _ := r.GetSpending(ctx, "tenant_a", "202611"); ok {
+		t.Error("GetSpending() found counters of another period")
+	}
+
+	server.Set("spending:tenant_b:202610", "not a hash")
+	if _, _, err := r.GetSpending(ctx, "tenant_b", "202610"); err == nil {
+		t.Error("GetSpending() of a corrupt key error = nil")
+	}
+}
+
+func TestMarkSpendingAlertOnce(t *testing.T) {
+	r, _ := newTestRedis(t)
+	ctx := context.Background()
+	mark := func(tenantSchema, period string, percent int) bool {
+		t.Helper()
+		first, err := r.MarkSpendingAlert(ctx, tenantSchema, period, percent, time.Hour)
+		if err != nil {
+			t.Fatalf("MarkSpendingAlert() error = %v", err)
+		}
+		return first
+	}
+
+	if !mark("tenant_a", "202610", 80) || mark("tenant_a", "202610", 80) {
+		t.Error("MarkSpendingAlert() isn't true only the first time")
+	}
+	// Each threshold, tenant and period is marked on its own
+	if !mark("tenant_a", "202610", 100) || !mark("tenant_b", "202610", 80) || !mark("tenant_a", "202611", 80) {
+		t.Error("MarkSpendingAlert() shares marks between thresholds, tenants or periods")
+	}
+
+	// A cleared alert is sent again
+	if err := r.ClearSpendingAlert(ctx, "tenant_a", "202610", 80); err != nil {
+		t.Fatal(err)
+	}
+	if !mark("tenant_a", "202610", 80) {
+		t.Error("MarkSpendingAlert() after ClearSpendingAlert() = false, want true")
+	}
+}
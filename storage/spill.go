@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"awning-backend/model"
+
+	"github.com/google/uuid"
+)
+
+// ChatDelta is a generation's chat that couldn't be saved, kept until a
+// retry saves it. Chat is the chat as the generation left it, with the
+// revision it was loaded with; its messages from BaseMessages on are the
+// ones the generation added.
+type ChatDelta struct {
+	ID            string      `json:"id"`
+	Chat          *model.Chat `json:"chat"`
+	BaseMessages  int         `json:"baseMessages"`
+	SpilledAt     time.Time   `json:"spilledAt"`
+	Attempts      int         `json:"attempts"`
+	NextAttemptAt time.Time   `json:"nextAttemptAt"`
+	LastError     string      `json:"lastError,omitempty"`
+}
+
+// NewChatDelta creates a delta for the chat's messages from baseMessages on
+func NewChatDelta(chat *model.Chat, baseMessages int, cause error) *ChatDelta {
+	now := time.Now().UTC()
+	delta := &ChatDelta{
+		ID:            uuid.New().String(),
+		Chat:          chat,
+		BaseMessages:  min(max(baseMessages, 0), len(chat.Messages)),
+		SpilledAt:     now,
+		NextAttemptAt: now,
+	}
+	if cause != nil {
+		delta.LastError = cause.Error()
+	}
+	return delta
+}
+
+// Added returns the messages the generation added
+func (d *ChatDelta) Added() []model.ChatMessage {
+	return d.Chat.Messages[min(d.BaseMessages, len(d.Chat.Messages)):]
+}
+
+// ChatSpill keeps the deltas of chats that couldn't be saved. It is
+// implemented by DirChatSpill and RedisClient.
+type ChatSpill interface {
+	// PutDelta stores the delta, replacing one with the same ID
+	PutDelta(ctx context.Context, delta *ChatDelta) error
+	// Deltas returns every pending delta, oldest first
+	Deltas(ctx context.Context) ([]*ChatDelta, error)
+	// ChatDeltas returns the pending deltas of a chat, oldest first
+	ChatDeltas(ctx context.Context, chatID string) ([]*ChatDelta, error)
+	// RemoveDelta drops a delta once it is saved or given up on
+	RemoveDelta(ctx context.Context, delta *ChatDelta) error
+}
+
+var (
+	_ ChatSpill = (*DirChatSpill)(nil)
+	_ ChatSpill = (*RedisClient)(nil)
+)
+
+// sortDeltas sorts deltas oldest first
+func sortDeltas(deltas []*ChatDelta) {
+	slices.SortFunc(deltas, func(a, b *ChatDelta) int {
+		if c := a.SpilledAt.Compare(b.SpilledAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// DirChatSpill keeps deltas as JSON files in a local directory, so they
+// survive Redis being unavailable but are only seen by this instance
+type DirChatSpill struct {
+	dir string
+}
+
+// NewDirChatSpill creates a spill in dir, creating it if needed
+func NewDirChatSpill(dir string) (*DirChatSpill, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create chat spill directory: %w", err)
+	}
+	return &DirChatSpill{dir: dir}, nil
+}
+
+func (s *DirChatSpill) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// PutDelta writes the delta to a temporary file and renames it into place,
+// so readers never see a partial delta
+func (s *DirChatSpill) PutDelta(ctx context.Context, delta *ChatDelta) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to serialize chat delta: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".delta-*")
+	if err != nil {
+		return fmt.Errorf("failed to write chat delta: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chat delta: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write chat delta: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write chat delta: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path(delta.ID)); err != nil {
+		return fmt.Errorf("failed to write chat delta: %w", err)
+	}
+	return nil
+}
+
+// Deltas reads every delta in the directory. Unreadable files are logged
+// and skipped.
+func (s *DirChatSpill) Deltas(ctx context.Context) ([]*ChatDelta, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat deltas: %w", err)
+	}
+
+	var deltas []*ChatDelta
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // removed by a concurrent retry
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chat delta: %w", err)
+		}
+		var delta ChatDelta
+		if err := json.Unmarshal(data, &delta); err != nil || delta.Chat == nil {
+			slog.Error("Skipping unreadable chat delta", "file", name, "error", err)
+			continue
+		}
+		deltas = append(deltas, &delta)
+	}
+	sortDeltas(deltas)
+	return deltas, nil
+}
+
+// ChatDeltas filters Deltas by chat
+func (s *DirChatSpill) ChatDeltas(ctx context.Context, chatID string) ([]*ChatDelta, error) {
+	deltas, err := s.Deltas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(deltas, func(d *ChatDelta) bool { return d.Chat.ID != chatID }), nil
+}
+
+// RemoveDelta deletes the delta's file
+func (s *DirChatSpill) RemoveDelta(ctx context.Context, delta *ChatDelta) error {
+	if err := os.Remove(s.path(delta.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove chat delta: %w", err)
+	}
+	return nil
+}
+
+// chatSpillKey is the hash holding spilled deltas by ID
+const chatSpillKey = "chat-spill:deltas"
+
+// PutDelta stores the delta in the spill hash
+func (r *RedisClient) PutDelta(ctx context.Context, delta *ChatDelta) error {
+	data, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("failed to serialize chat delta: %w", err)
+	}
+	if err := r.client.HSet(ctx, chatSpillKey, delta.ID, data).Err(); err != nil {
+		return fmt.Errorf("failed to spill chat delta to Redis: %w", err)
+	}
+	return nil
+}
+
+// Deltas returns every delta in the spill hash
+func (r *RedisClient) Deltas(ctx context.Context) ([]*ChatDelta, error) {
+	values, err := r.client.HGetAll(ctx, chatSpillKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chat deltas from Redis: %w", err)
+	}
+
+	deltas := make([]*ChatDelta, 0, len(values))
+	for id, data := range values {
+		var delta ChatDelta
+		if err := json.Unmarshal([]byte(data), &delta); err != nil || delta.Chat == nil {
+			slog.Error("Skipping unreadable chat delta", "id", id, "error", err)
+			continue
+		}
+		deltas = append(deltas, &delta)
+	}
+	sortDeltas(deltas)
+	return deltas, nil
+}
+
+// ChatDeltas filters Deltas by chat
+func (r *RedisClient) ChatDeltas(ctx context.Context, chatID string) ([]*ChatDelta, error) {
+	deltas, err := r.Deltas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(deltas, func(d *ChatDelta) bool { return d.Chat.ID != chatID }), nil
+}
+
+// RemoveDelta deletes the delta from the spill hash
+func (r *RedisClient) RemoveDelta(ctx context.Context, delta *ChatDelta) error {
+	if err := r.client.HDel(ctx, chatSpillKey, delta.ID).Err(); err != nil {
+		return fmt.Errorf("failed to remove chat delta from Redis: %w", err)
+	}
+	return nil
+}
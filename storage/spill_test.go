@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+)
+
+// testChatSpill checks a spill keeps, lists and drops deltas; corrupt
+// stores an unreadable entry in it
+func testChatSpill(t *testing.T, spill ChatSpill, corrupt func()) {
+	ctx := context.Background()
+
+	newDelta := func(chatID string, spilledAt time.Time) *ChatDelta {
+		chat := model.NewChat(chatID)
+		chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page for my bakery")
+		chat.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "<h1>Bakery</h1>")
+		delta := NewChatDelta(chat, 1, errors.New("redis down"))
+		delta.SpilledAt = spilledAt
+		return delta
+	}
+	now := time.Now().UTC()
+	second := newDelta("chat-1", now)
+	first := newDelta("chat-1", now.Add(-time.Minute))
+	other := newDelta("chat-2", now.Add(-time.Second))
+	for _, delta := range []*ChatDelta{second, first, other} {
+		if err := spill.PutDelta(ctx, delta); err != nil {
+			t.Fatalf("PutDelta() error = %v", err)
+		}
+	}
+	corrupt()
+
+	deltas, err := spill.Deltas(ctx)
+	if err != nil {
+		t.Fatalf("Deltas() error = %v", err)
+	}
+	if len(deltas) != 3 || deltas[0].ID != first.ID || deltas[1].ID != other.ID || deltas[2].ID != second.ID {
+		t.Fatalf("Deltas() = %+v, want the three oldest first", deltas)
+	}
+	if added := deltas[0].Added(); len(added) != 1 || added[0].Content != "<h1>Bakery</h1>" || deltas[0].LastError != "redis down" {
+		t.Errorf("read back delta = %+v, added %+v", deltas[0], added)
+	}
+
+	chatDeltas, err := spill.ChatDeltas(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chatDeltas) != 2 || chatDeltas[0].ID != first.ID || chatDeltas[1].ID != second.ID {
+		t.Errorf("ChatDeltas() = %+v, want chat-1's two oldest first", chatDeltas)
+	}
+
+	// Putting a delta again replaces it
+	first.Attempts = 3
+	if err := spill.PutDelta(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := spill.RemoveDelta(ctx, other); err != nil {
+		t.Fatalf("RemoveDelta() error = %v", err)
+	}
+	if err := spill.RemoveDelta(ctx, other); err != nil {
+		t.Errorf("RemoveDelta() of a removed delta error = %v", err)
+	}
+	deltas, err = spill.Deltas(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deltas) != 2 || deltas[0].ID != first.ID || deltas[0].Attempts != 3 || deltas[1].ID != second.ID {
+		t.Errorf("Deltas() after replace and remove = %+v", deltas)
+	}
+}
+
+func TestDirChatSpill(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spill")
+	spill, err := NewDirChatSpill(dir)
+	if err != nil {
+		t.Fatalf("NewDirChatSpill() error = %v", err)
+	}
+	testChatSpill(t, spill, func() {
+		for name, data := range map[string]string{
+			"broken.json":  "{",
+			"no-chat.json": `{"id":"no-chat"}`,
+			".delta-123":   "{",
+			"notes.txt":    "not a delta",
+		} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestRedisChatSpill(t *testing.T) {
+	client, server := newTestRedis(t)
+	testChatSpill(t, client, func() {
+		server.HSet(chatSpillKey, "broken", "{")
+		server.HSet(chatSpillKey, "no-chat", `{"id":"no-chat"}`)
+	})
+}
+
+func TestNewChatDelta(t *testing.T) {
+	chat := model.NewChat("chat-1")
+	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page for my bakery")
+
+	tests := []struct {
+		base, want int
+	}{
+		{-1, 0},
+		{0, 0},
+		{1, 1},
+		{5, 1},
+	}
+	for _, tt := range tests {
+		delta := NewChatDelta(chat, tt.base, nil)
+		if delta.BaseMessages != tt.want || len(delta.Added()) != 1-tt.want {
+			t.Errorf("NewChatDelta(base %d) BaseMessages = %d, want %d", tt.base, delta.BaseMessages, tt.want)
+		}
+		if delta.ID == "" || delta.NextAttemptAt.IsZero() || delta.LastError != "" {
+			t.Errorf("NewChatDelta() = %+v, want an ID, a due attempt and no error", delta)
+		}
+	}
+}
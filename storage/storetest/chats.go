@@ -0,0 +1,246 @@
+// Package storetest holds the contract tests every storage.ChatStore
+// implementation must pass
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+	"awning-backend/storage"
+)
+
+// NewChatStore returns an empty chat store for one test, with the context
+// its calls are made with
+type NewChatStore func(t *testing.T) (storage.ChatStore, context.Context)
+
+// trashRetention is long enough that nothing expires during a test
+const trashRetention = time.Hour
+
+// TestChatStore runs the chat store contract against the stores made by
+// newStore
+func TestChatStore(t *testing.T, newStore NewChatStore) {
+	t.Run("GetMissing", func(t *testing.T) { testGetMissing(t, newStore) })
+	t.Run("SaveAndGet", func(t *testing.T) { testSaveAndGet(t, newStore) })
+	t.Run("RevisionConflict", func(t *testing.T) { testRevisionConflict(t, newStore) })
+	t.Run("ListChats", func(t *testing.T) { testListChats(t, newStore) })
+	t.Run("DeleteChat", func(t *testing.T) { testDeleteChat(t, newStore) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, newStore) })
+	t.Run("UpdateChat", func(t *testing.T) { testUpdateChat(t, newStore) })
+}
+
+// saveChat saves a new chat with one user message
+func saveChat(t *testing.T, store storage.ChatStore, ctx context.Context, chatID string) *model.Chat {
+	t.Helper()
+
+	chat := model.NewChat(chatID)
+	chat.Title = "Chat " + chatID
+	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A page for "+chatID)
+	if err := store.SaveChat(ctx, chat); err != nil {
+		t.Fatalf("SaveChat(%s) error = %v", chatID, err)
+	}
+	return chat
+}
+
+func testGetMissing(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+
+	if _, err := store.GetChat(ctx, "missing"); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetChat() of an unknown chat error = %v, want ErrChatNotFound", err)
+	}
+	if _, err := store.GetTrashedChat(ctx, "missing", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetTrashedChat() of an unknown chat error = %v, want ErrChatNotFound", err)
+	}
+	if err := store.TrashChat(ctx, "missing", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("TrashChat() of an unknown chat error = %v, want ErrChatNotFound", err)
+	}
+	if _, err := store.RestoreChat(ctx, "missing", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("RestoreChat() of an unknown chat error = %v, want ErrChatNotFound", err)
+	}
+}
+
+func testSaveAndGet(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+
+	chat := saveChat(t, store, ctx, "chat-1")
+	if chat.Revision != 1 {
+		t.Errorf("Revision after the first save = %d, want 1", chat.Revision)
+	}
+
+	got, err := store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatalf("GetChat() error = %v", err)
+	}
+	if got.ID != "chat-1" || got.Title != "Chat chat-1" || got.Revision != 1 || got.ChatStage != chat.ChatStage {
+		t.Errorf("GetChat() = %+v, want the saved chat", got)
+	}
+	if len(got.Messages) != 1 || got.Messages[0].Content != "A page for chat-1" || got.Messages[0].Role != model.ChatMessageRoleUser {
+		t.Errorf("GetChat() messages = %+v, want the saved message", got.Messages)
+	}
+
+	got.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "<html></html>")
+	if err := store.SaveChat(ctx, got); err != nil {
+		t.Fatalf("SaveChat() of a loaded chat error = %v", err)
+	}
+	again, err := store.GetChat(ctx, "chat-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Revision != 2 || len(again.Messages) != 2 {
+		t.Errorf("chat after the second save = revision %d with %d messages, want 2 and 2", again.Revision, len(again.Messages))
+	}
+}
+
+func testRevisionConflict(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+	saveChat(t, store, ctx, "chat-1")
+
+	first, _ := store.GetChat(ctx, "chat-1")
+	second, _ := store.GetChat(ctx, "chat-1")
+	first.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "first")
+	second.AddMessageWithRoleAndContent(model.ChatMessageRoleAssistant, "second")
+	if err := store.SaveChat(ctx, first); err != nil {
+		t.Fatalf("SaveChat() error = %v", err)
+	}
+	if err := store.SaveChat(ctx, second); !errors.Is(err, storage.ErrChatConflict) {
+		t.Errorf("SaveChat() of a stale chat error = %v, want ErrChatConflict", err)
+	}
+	if err := store.SaveChat(ctx, model.NewChat("chat-1")); !errors.Is(err, storage.ErrChatConflict) {
+		t.Errorf("SaveChat() of a new chat over an existing one error = %v, want ErrChatConflict", err)
+	}
+
+	stored, _ := store.GetChat(ctx, "chat-1")
+	if stored.Revision != 2 || stored.Messages[len(stored.Messages)-1].Content != "first" {
+		t.Errorf("stored chat = revision %d ending %+v, want the first save", stored.Revision, stored.Messages)
+	}
+}
+
+func testListChats(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+	for _, id := range []string{"chat-c", "chat-a", "chat-e", "chat-b", "chat-d"} {
+		saveChat(t, store, ctx, id)
+	}
+
+	tests := []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 2, []string{"chat-a", "chat-b"}},
+		{2, 2, []string{"chat-c", "chat-d"}},
+		{4, 2, []string{"chat-e"}},
+		{5, 2, []string{}},
+		{0, 10, []string{"chat-a", "chat-b", "chat-c", "chat-d", "chat-e"}},
+		{0, 0, []string{}},
+		{-1, 1, []string{"chat-a"}},
+	}
+	for _, tt := range tests {
+		got, err := store.ListChats(ctx, tt.offset, tt.limit)
+		if err != nil {
+			t.Fatalf("ListChats(%d, %d) error = %v", tt.offset, tt.limit, err)
+		}
+		if got == nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ListChats(%d, %d) = %q, want %q", tt.offset, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func testDeleteChat(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+	saveChat(t, store, ctx, "chat-1")
+	saveChat(t, store, ctx, "chat-2")
+	saveChat(t, store, ctx, "chat-3")
+
+	if err := store.DeleteChat(ctx, "chat-1"); err != nil {
+		t.Fatalf("DeleteChat() error = %v", err)
+	}
+	if _, err := store.GetChat(ctx, "chat-1"); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetChat() of a deleted chat error = %v, want ErrChatNotFound", err)
+	}
+
+	// Trashed chats are deleted for good too
+	if err := store.TrashChat(ctx, "chat-2", trashRetention); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteChat(ctx, "chat-2"); err != nil {
+		t.Fatalf("DeleteChat() of a trashed chat error = %v", err)
+	}
+	if _, err := store.GetTrashedChat(ctx, "chat-2", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetTrashedChat() of a deleted chat error = %v, want ErrChatNotFound", err)
+	}
+
+	if ids, _ := store.ListChats(ctx, 0, 10); !slices.Equal(ids, []string{"chat-3"}) {
+		t.Errorf("ListChats() after deleting = %q, want [chat-3]", ids)
+	}
+}
+
+func testTrash(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+	saveChat(t, store, ctx, "chat-1")
+	saveChat(t, store, ctx, "chat-2")
+
+	before := time.Now().Add(-time.Second).Unix()
+	if err := store.TrashChat(ctx, "chat-1", trashRetention); err != nil {
+		t.Fatalf("TrashChat() error = %v", err)
+	}
+	if _, err := store.GetChat(ctx, "chat-1"); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("GetChat() of a trashed chat error = %v, want ErrChatNotFound", err)
+	}
+	if ids, _ := store.ListChats(ctx, 0, 10); !slices.Equal(ids, []string{"chat-2"}) {
+		t.Errorf("ListChats() with a trashed chat = %q, want [chat-2]", ids)
+	}
+
+	trashed, err := store.GetTrashedChat(ctx, "chat-1", trashRetention)
+	if err != nil {
+		t.Fatalf("GetTrashedChat() error = %v", err)
+	}
+	if trashed.DeletedAt < before || len(trashed.Messages) != 1 {
+		t.Errorf("GetTrashedChat() = deleted at %d with %d messages, want now and the chat's message", trashed.DeletedAt, len(trashed.Messages))
+	}
+	list, err := store.ListTrashedChats(ctx, trashRetention)
+	if err != nil || len(list) != 1 || list[0].ID != "chat-1" {
+		t.Errorf("ListTrashedChats() = %v, %v; want chat-1", list, err)
+	}
+	if err := store.TrashChat(ctx, "chat-1", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("TrashChat() of a trashed chat error = %v, want ErrChatNotFound", err)
+	}
+
+	restored, err := store.RestoreChat(ctx, "chat-1", trashRetention)
+	if err != nil {
+		t.Fatalf("RestoreChat() error = %v", err)
+	}
+	if restored.DeletedAt != 0 || restored.ID != "chat-1" {
+		t.Errorf("RestoreChat() = %+v, want the live chat", restored)
+	}
+	got, err := store.GetChat(ctx, "chat-1")
+	if err != nil || got.DeletedAt != 0 || len(got.Messages) != 1 {
+		t.Errorf("GetChat() after restoring = %+v, %v; want the chat back", got, err)
+	}
+	if list, _ := store.ListTrashedChats(ctx, trashRetention); len(list) != 0 {
+		t.Errorf("ListTrashedChats() after restoring = %d chats, want none", len(list))
+	}
+	if _, err := store.RestoreChat(ctx, "chat-1", trashRetention); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("second RestoreChat() error = %v, want ErrChatNotFound", err)
+	}
+}
+
+func testUpdateChat(t *testing.T, newStore NewChatStore) {
+	store, ctx := newStore(t)
+	saveChat(t, store, ctx, "chat-1")
+
+	updated, err := storage.UpdateChat(ctx, store, "chat-1", func(chat *model.Chat) error {
+		chat.Title = "Renamed"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateChat() error = %v", err)
+	}
+	if stored, _ := store.GetChat(ctx, "chat-1"); stored.Title != "Renamed" || stored.Revision != updated.Revision {
+		t.Errorf("stored chat = %q at revision %d, want the update at %d", stored.Title, stored.Revision, updated.Revision)
+	}
+	if _, err := storage.UpdateChat(ctx, store, "missing", func(*model.Chat) error { return nil }); !errors.Is(err, storage.ErrChatNotFound) {
+		t.Errorf("UpdateChat() of an unknown chat error = %v, want ErrChatNotFound", err)
+	}
+}
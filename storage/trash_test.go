@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"awning-backend/model"
+)
+
+const testRetention = 30 * 24 * time.Hour
+
+// trashTestChat saves a chat with one message to store
+func trashTestChat(t *testing.T, store ChatStore, chatID string) {
+	t.Helper()
+
+	chat := model.NewChat(chatID)
+	chat.AddMessageWithRoleAndContent(model.ChatMessageRoleUser, "A bakery page")
+	if err := store.SaveChat(context.Background(), chat); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisTrashExpires(t *testing.T) {
+	client, server := newTestRedis(t)
+	ctx := context.Background()
+	trashTestChat(t, client, "kept")
+	trashTestChat(t, client, "expired")
+
+	for _, id := range []string{"kept", "expired"} {
+		if err := client.TrashChat(ctx, id, testRetention); err != nil {
+			t.Fatalf("TrashChat(%s) error = %v", id, err)
+		}
+	}
+	if ttl := server.TTL("chat-trash:kept"); ttl != testRetention {
+		t.Errorf("trash TTL = %s, want the retention", ttl)
+	}
+
+	// Within the window the chat comes back, without an expiry
+	server.FastForward(testRetention - time.Hour)
+	if _, err := client.RestoreChat(ctx, "kept", testRetention); err != nil {
+		t.Fatalf("RestoreChat() within the retention error = %v", err)
+	}
+	if ttl := server.TTL("chat:kept"); ttl != 0 {
+		t.Errorf("restored chat TTL = %s, want none", ttl)
+	}
+
+	// Past it the trashed copy is gone
+	server.FastForward(2 * time.Hour)
+	if _, err := client.GetTrashedChat(ctx, "expired", testRetention); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("GetTrashedChat() after the retention error = %v, want ErrChatNotFound", err)
+	}
+	if _, err := client.RestoreChat(ctx, "expired", testRetention); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("RestoreChat() after the retention error = %v, want ErrChatNotFound", err)
+	}
+	if list, err := client.ListTrashedChats(ctx, testRetention); err != nil || len(list) != 0 {
+		t.Errorf("ListTrashedChats() after the retention = %d chats, %v; want none", len(list), err)
+	}
+	if _, err := client.GetChat(ctx, "kept"); err != nil {
+		t.Errorf("GetChat() of the restored chat error = %v", err)
+	}
+}
+
+func TestRedisRestoreConflict(t *testing.T) {
+	client, _ := newTestRedis(t)
+	ctx := context.Background()
+	trashTestChat(t, client, "chat-1")
+	if err := client.TrashChat(ctx, "chat-1", testRetention); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new chat took the ID meanwhile
+	trashTestChat(t, client, "chat-1")
+	if _, err := client.RestoreChat(ctx, "chat-1", testRetention); !errors.Is(err, ErrChatConflict) {
+		t.Errorf("RestoreChat() over a live chat error = %v, want ErrChatConflict", err)
+	}
+	if _, err := client.GetTrashedChat(ctx, "chat-1", testRetention); err != nil {
+		t.Errorf("trashed chat lost after a conflicting restore: %v", err)
+	}
+}
+
+func TestMemoryPurgeTrash(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	for _, id := range []string{"recent", "old"} {
+		trashTestChat(t, store, id)
+		if err := store.TrashChat(ctx, id, testRetention); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Backdate one chat's deletion past the retention
+	old, _ := model.FromJSON(store.trash["old"])
+	old.DeletedAt = time.Now().Add(-testRetention - time.Minute).Unix()
+	store.trash["old"], _ = old.ToJSON()
+
+	if list, _ := store.ListTrashedChats(ctx, testRetention); len(list) != 1 || list[0].ID != "recent" {
+		t.Errorf("ListTrashedChats() = %v, want only the recent chat", list)
+	}
+	store.trash["old"], _ = old.ToJSON()
+	if n, err := store.PurgeTrash(ctx, testRetention); err != nil || n != 1 {
+		t.Errorf("PurgeTrash() = %d, %v; want the old chat purged", n, err)
+	}
+	if _, err := store.RestoreChat(ctx, "old", testRetention); !errors.Is(err, ErrChatNotFound) {
+		t.Errorf("RestoreChat() of a purged chat error = %v, want ErrChatNotFound", err)
+	}
+	if _, err := store.RestoreChat(ctx, "recent", testRetention); err != nil {
+		t.Errorf("RestoreChat() within the retention error = %v", err)
+	}
+}
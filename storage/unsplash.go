@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// errPatternFound stops the scan of FindByPattern at the first hit
+var errPatternFound = errors.New("pattern found")
+
+// FindByPattern returns the value of the first key found matching the glob
+// pattern, or nil when none does. It scans the keyspace, so it is meant for
+// occasional lookups such as cached Unsplash searches while the quota is
+// exhausted.
+func (r *RedisClient) FindByPattern(ctx context.Context, pattern string) ([]byte, error) {
+	var value []byte
+	err := r.ScanKeys(ctx, pattern, 1000, 0, func(keys []string) error {
+		for _, key := range keys {
+			data, err := r.client.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				continue // expired since the scan
+			}
+			if err != nil {
+				return err
+			}
+			value = data
+			return errPatternFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPatternFound) {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Elements whitespace between which doesn't render, so it is written as a
+// single newline
+var canonicalBlockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "base": true, "blockquote": true, "body": true,
+	"dd": true, "details": true, "dialog": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true, "form": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hgroup": true, "hr": true, "html": true, "li": true, "link": true,
+	"main": true, "meta": true, "nav": true, "noscript": true, "ol": true, "p": true, "pre": true,
+	"script": true, "section": true, "style": true, "summary": true, "table": true, "tbody": true,
+	"td": true, "template": true, "tfoot": true, "th": true, "thead": true, "title": true, "tr": true, "ul": true,
+}
+
+// Elements whose text is written as it is
+var canonicalLiteralElements = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true, "script": true, "style": true, "xmp": true,
+}
+
+// Elements, and Tailwind classes, under which whitespace renders
+var (
+	canonicalPreservedElements = map[string]bool{"pre": true, "textarea": true, "listing": true}
+	canonicalPreservedClasses  = []string{"whitespace-pre", "whitespace-break-spaces"}
+)
+
+// Void elements, written as <br/>
+var canonicalVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true, "input": true,
+	"keygen": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// RenderCanonical renders the tree under n in canonical form, so the same
+// document always serializes to the same bytes however it was written:
+// attributes in the order id, class, then by name, always double-quoted;
+// void elements as <br/>; adjacent text merged and escaped like
+// html.Render; and whitespace-only text next to block elements as a single
+// newline. Whitespace inside pre, textarea and elements with a
+// whitespace-pre class is kept. n is not modified.
+func RenderCanonical(w io.Writer, n *html.Node) error {
+	bw := bufio.NewWriter(w)
+	if err := renderCanonical(bw, n, false); err != nil && err != errPlaintextAbort {
+		return err
+	}
+	return bw.Flush()
+}
+
+// errPlaintextAbort stops rendering after <plaintext>, which runs to the end
+// of the document and has no closing tag
+var errPlaintextAbort = errors.New("html: internal error (plaintext abort)")
+
+// CanonicalHTML parses a page and renders it in canonical form. Pages that
+// fail to parse or render are returned as they are.
+func CanonicalHTML(page string) string {
+	root, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return page
+	}
+	var b strings.Builder
+	if err := RenderCanonical(&b, root); err != nil {
+		return page
+	}
+	return b.String()
+}
+
+// CanonicalNode renders n in canonical form, or returns "" when it can't be
+func CanonicalNode(n *html.Node) string {
+	var b strings.Builder
+	if err := RenderCanonical(&b, n); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+func renderCanonical(w *bufio.Writer, n *html.Node, preserve bool) error {
+	switch n.Type {
+	case html.ErrorNode:
+		return errors.New("html: cannot render an ErrorNode node")
+	case html.TextNode:
+		_, err := w.WriteString(html.EscapeString(n.Data))
+		return err
+	case html.DocumentNode:
+		return renderCanonicalChildren(w, n, preserve)
+	case html.CommentNode, html.DoctypeNode:
+		return html.Render(w, n)
+	case html.RawNode:
+		_, err := w.WriteString(n.Data)
+		return err
+	case html.ElementNode:
+	default:
+		return errors.New("html: unknown node type")
+	}
+
+	w.WriteByte('<')
+	w.WriteString(n.Data)
+	for _, attr := range canonicalAttrs(n.Attr) {
+		w.WriteByte(' ')
+		if attr.Namespace != "" {
+			w.WriteString(attr.Namespace)
+			w.WriteByte(':')
+		}
+		w.WriteString(attr.Key)
+		w.WriteString(`="`)
+		w.WriteString(html.EscapeString(attr.Val))
+		w.WriteByte('"')
+	}
+	if n.Namespace == "" && canonicalVoidElements[n.Data] {
+		if n.FirstChild != nil {
+			return fmt.Errorf("html: void element <%s> has child nodes", n.Data)
+		}
+		_, err := w.WriteString("/>")
+		return err
+	}
+	w.WriteByte('>')
+
+	// A newline at the start of these is dropped when parsing
+	if c := n.FirstChild; c != nil && c.Type == html.TextNode && strings.HasPrefix(c.Data, "\n") && canonicalPreservedElements[n.Data] {
+		w.WriteByte('\n')
+	}
+
+	if n.Namespace == "" && canonicalLiteralElements[n.Data] {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				w.WriteString(c.Data)
+			} else if err := renderCanonical(w, c, true); err != nil {
+				return err
+			}
+		}
+		if n.Data == "plaintext" {
+			return errPlaintextAbort
+		}
+	} else {
+		preserve = preserve || (n.Namespace == "" && canonicalPreservedElements[n.Data]) || hasPreservedClass(n)
+		if err := renderCanonicalChildren(w, n, preserve); err != nil {
+			return err
+		}
+	}
+
+	w.WriteString("</")
+	w.WriteString(n.Data)
+	return w.WriteByte('>')
+}
+
+// renderCanonicalChildren renders n's children, merging runs of text
+func renderCanonicalChildren(w *bufio.Writer, n *html.Node, preserve bool) error {
+	for c := n.FirstChild; c != nil; {
+		if c.Type != html.TextNode {
+			if err := renderCanonical(w, c, preserve); err != nil {
+				return err
+			}
+			c = c.NextSibling
+			continue
+		}
+
+		prev := c.PrevSibling
+		var text strings.Builder
+		for ; c != nil && c.Type == html.TextNode; c = c.NextSibling {
+			text.WriteString(c.Data)
+		}
+		data := text.String()
+		if data == "" {
+			continue
+		}
+		if !preserve && strings.Trim(data, " \t\n\f\r") == "" &&
+			(isCanonicalBlock(prev) || isCanonicalBlock(c) || ((prev == nil || c == nil) && isCanonicalBlock(n))) {
+			data = "\n"
+		}
+		if _, err := w.WriteString(html.EscapeString(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalAttrs returns attrs ordered id, class, then by namespace and name
+func canonicalAttrs(attrs []html.Attribute) []html.Attribute {
+	if len(attrs) < 2 {
+		return attrs
+	}
+	rank := func(a html.Attribute) int {
+		switch {
+		case a.Namespace == "" && a.Key == "id":
+			return 0
+		case a.Namespace == "" && a.Key == "class":
+			return 1
+		}
+		return 2
+	}
+	sorted := slices.Clone(attrs)
+	slices.SortStableFunc(sorted, func(a, b html.Attribute) int {
+		return cmp.Or(cmp.Compare(rank(a), rank(b)), cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Key, b.Key))
+	})
+	return sorted
+}
+
+func isCanonicalBlock(n *html.Node) bool {
+	if n == nil {
+		return false
+	}
+	return n.Type == html.DocumentNode || (n.Type == html.ElementNode && n.Namespace == "" && canonicalBlockElements[n.Data])
+}
+
+func hasPreservedClass(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		if attr.Key != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(attr.Val) {
+			for _, preserved := range canonicalPreservedClasses {
+				if strings.HasPrefix(class, preserved) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
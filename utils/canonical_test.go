@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestCanonicalHTMLGolden(t *testing.T) {
+	for _, name := range []string{"attributes", "whitespace", "preserved", "literal"} {
+		input, err := os.ReadFile(filepath.Join("testdata", "canonical", name+".html"))
+		if err != nil {
+			t.Fatalf("failed to read fixture: %v", err)
+		}
+		got := []byte(CanonicalHTML(string(input)))
+
+		path := filepath.Join("testdata", "canonical", name+".golden.html")
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read golden file: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("output differs from %s, run go test ./utils -update to accept:\ngot:\n%s\nwant:\n%s", path, got, want)
+		}
+
+		// The canonical form is its own canonical form
+		if again := CanonicalHTML(string(got)); again != string(got) {
+			t.Errorf("%s: CanonicalHTML() isn't idempotent:\nonce:\n%s\ntwice:\n%s", name, got, again)
+		}
+	}
+}
+
+func TestCanonicalHTMLEquivalent(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"attribute order and quoting", `<a href=/x class='c' id="i" data-k=v>x</a>`, `<a id="i" class="c" data-k="v" href="/x">x</a>`},
+		{"void elements", `<p>a<br>b<img src=x></p>`, `<p>a<br/>b<img src="x" /></p>`},
+		{"whitespace between blocks", "<div>\n  <p>a</p>\n\n  <p>b</p>\n</div>", "<div>\n<p>a</p> <p>b</p>\n</div>"},
+		{"entities", `<p>&#38; &amp; &lt;</p>`, `<p>&amp; &amp; &lt;</p>`},
+	}
+	for _, tt := range tests {
+		if a, b := CanonicalHTML(tt.a), CanonicalHTML(tt.b); a != b {
+			t.Errorf("%s: CanonicalHTML() = %q and %q, want the same", tt.name, a, b)
+		}
+	}
+
+	// Whitespace that renders is kept
+	for _, page := range []string{"<pre>a  b</pre>", "<p>a <b>b</b> c</p>", `<span class="whitespace-pre">a  b</span>`} {
+		if a, b := CanonicalHTML(page), CanonicalHTML(strings.ReplaceAll(page, " ", "")); a == b {
+			t.Errorf("CanonicalHTML(%q) dropped rendered whitespace: %q", page, a)
+		}
+	}
+}
+
+func TestCanonicalNode(t *testing.T) {
+	root, err := html.Parse(strings.NewReader(`<p class="b" id="a">x</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := CanonicalNode(root)
+	if !strings.Contains(before, `<p id="a" class="b">x</p>`) {
+		t.Errorf("CanonicalNode() = %q", before)
+	}
+	if root.LastChild.LastChild.FirstChild.Attr[0].Key != "class" {
+		t.Error("CanonicalNode() reordered the node's attributes")
+	}
+
+	if got := CanonicalNode(&html.Node{Type: html.ErrorNode}); got != "" {
+		t.Errorf("CanonicalNode() of an error node = %q, want empty", got)
+	}
+}
+
+// benchmarkPage returns a generated page of about size bytes
+func benchmarkPage(size int) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html><html><head><title>Bakery</title><style>.hero{color:red}</style></head><body>\n")
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, `<section id="section-%d" class="py-12 px-4">
+  <div class="container mx-auto grid grid-cols-3 gap-4">
+    <h2 class="text-3xl font-bold">Section %d</h2>
+    <p class="text-gray-600">Fresh rye &amp; sourdough, baked <strong>every</strong> morning.</p>
+    <img src="/images/%d.jpg" alt="Loaf %d" width="640" class="rounded">
+    <a href="/menu#%d" class="btn btn-primary" data-track="cta">Order</a>
+  </div>
+</section>
+`, i, i, i, i, i)
+	}
+	b.WriteString("</body></html>")
+	return b.String()
+}
+
+func BenchmarkCanonicalHTML(b *testing.B) {
+	page := benchmarkPage(300 << 10)
+	b.SetBytes(int64(len(page)))
+	for b.Loop() {
+		CanonicalHTML(page)
+	}
+}
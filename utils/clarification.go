@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"awning-backend/model"
+)
+
+const clarificationSchema = `{
+  "questions": [{"key": "<snake_case variable name>", "question": "<question for the business owner>", "assumption": "<what you would assume without an answer>"}]
+}`
+
+// BuildClarificationPrompt builds the prompt asking which facts the model is
+// missing to generate the page for request: at most maxQuestions, leaving
+// out those that onboarding data or the known variables already answer
+func BuildClarificationPrompt(onboardingData *model.OnboardingData, known map[string]string, request string, maxQuestions int) string {
+	var b strings.Builder
+	b.WriteString("You are about to build a website for a small business. Before you start, list the facts you need but don't have, ")
+	b.WriteString("such as opening hours, services offered, prices or the service area, so that you don't have to invent them. ")
+	fmt.Fprintf(&b, "Ask at most %d questions, most important first, and none about anything answered below. ", maxQuestions)
+	b.WriteString("Ask nothing about design, colors or wording. When nothing important is missing, return an empty list.\n\n")
+	b.WriteString("Reply with a JSON object with exactly this shape:\n\n")
+	b.WriteString(clarificationSchema)
+	b.WriteString("\n\nReply with the JSON object only, without markdown fences or commentary.\n")
+
+	facts := map[string]string{}
+	if onboardingData != nil {
+		maps.Copy(facts, onboardingData.ToMap())
+	}
+	maps.Copy(facts, known)
+	b.WriteString("\n## Known Facts\n\n")
+	for _, key := range slices.Sorted(maps.Keys(facts)) {
+		if value := strings.TrimSpace(facts[key]); value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", key, value)
+		}
+	}
+
+	b.WriteString("\n## Request\n\n")
+	b.WriteString(request)
+	return b.String()
+}
+
+// ClarificationSection is the prompt section with the answers to a paused
+// generation's questions, and the assumptions made for the unanswered ones
+func ClarificationSection(questions []model.ClarificationQuestion, answers map[string]string) string {
+	if len(questions) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n## Clarified Facts\n\nThe business owner was asked about these facts before generation. ")
+	b.WriteString("Use the answers as given. Where there is no answer, use the assumption and keep it easy for the owner to change.\n")
+	for _, q := range questions {
+		if answer := strings.TrimSpace(answers[q.Key]); answer != "" {
+			fmt.Fprintf(&b, "\n- %s\n  Answer: %s", q.Question, answer)
+		} else if q.Assumption != "" {
+			fmt.Fprintf(&b, "\n- %s\n  No answer, assume: %s", q.Question, q.Assumption)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxDiffCells bounds the line-by-line table LineDiff builds; larger inputs
+// are reported as a single replacement
+const MaxDiffCells = 4_000_000
+
+// LineDiff returns the lines removed from before ("-" prefix) and added in
+// after ("+" prefix), in order, with unchanged lines left out. At most limit
+// lines are returned, followed by a note of how many were dropped.
+func LineDiff(before, after string, limit int) []string {
+	a := strings.Split(before, "\n")
+	b := strings.Split(after, "\n")
+
+	// Trim the common prefix and suffix, which is most of a processed page
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	endA, endB := len(a), len(b)
+	for endA > start && endB > start && a[endA-1] == b[endB-1] {
+		endA--
+		endB--
+	}
+	a, b = a[start:endA], b[start:endB]
+
+	var diff []string
+	if len(a)*len(b) > MaxDiffCells {
+		for _, line := range a {
+			diff = append(diff, "-"+line)
+		}
+		for _, line := range b {
+			diff = append(diff, "+"+line)
+		}
+	} else {
+		diff = lcsDiff(a, b)
+	}
+
+	if limit > 0 && len(diff) > limit {
+		dropped := len(diff) - limit
+		diff = append(diff[:limit], fmt.Sprintf("... %d more lines", dropped))
+	}
+	return diff
+}
+
+// lcsDiff diffs two line slices through their longest common subsequence
+func lcsDiff(a, b []string) []string {
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		limit         int
+		want          []string
+	}{
+		{"identical", "<div>\n<p>a</p>\n</div>", "<div>\n<p>a</p>\n</div>", 0, nil},
+		{"changed line", "<div>\n<p>a<br></p>\n</div>", "<div>\n<p>a</p>\n</div>", 0, []string{"-<p>a<br></p>", "+<p>a</p>"}},
+		{"inserted", "a\nc", "a\nb\nc", 0, []string{"+b"}},
+		{"deleted", "a\nb\nc", "a\nc", 0, []string{"-b"}},
+		{"in order", "a\nb\nc\nd", "a\nx\nc\ny", 0, []string{"-b", "+x", "-d", "+y"}},
+		{"limited", "a\nb\nc", "x\ny\nz", 2, []string{"-a", "-b", "... 4 more lines"}},
+	}
+	for _, tt := range tests {
+		if got := LineDiff(tt.before, tt.after, tt.limit); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: LineDiff() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLineDiffLargeReplacement(t *testing.T) {
+	// Past MaxDiffCells the differing lines are reported as a replacement
+	var a, b []string
+	for i := range 2001 {
+		a = append(a, "old "+strings.Repeat("x", i%7))
+		b = append(b, "new "+strings.Repeat("y", i%5))
+	}
+	before := "head\n" + strings.Join(a, "\n") + "\ntail"
+	after := "head\n" + strings.Join(b, "\n") + "\ntail"
+
+	got := LineDiff(before, after, 0)
+	if len(got) != 4002 || got[0] != "-"+a[0] || got[2001] != "+"+b[0] || got[4001] != "+"+b[2000] {
+		t.Errorf("LineDiff() of a large change = %d lines starting %q, want all removed then all added", len(got), got[:2])
+	}
+}
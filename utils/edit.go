@@ -0,0 +1,29 @@
+package utils
+
+import "fmt"
+
+// BuildSectionEditPrompt builds the prompt for a targeted edit: the model
+// gets the element's current markup and the user's request, and must reply
+// with the replacement element only. brandVoice should already be passed
+// through SanitizeInstructions, and language built by LanguageInstruction.
+func BuildSectionEditPrompt(tagName string, currentHTML string, userRequestMessage string, brandVoice string, language string) string {
+	prompt := fmt.Sprintf("You are editing one <%s> element of an existing web page. "+
+		"Apply the user's request to this element only and keep everything the request doesn't ask to change, "+
+		"including classes, ids, data attributes and image placeholders.\n\n"+
+		"Reply with the complete replacement markup: exactly one <%s> element, "+
+		"with no other elements, explanations or markdown around it.", tagName, tagName)
+
+	prompt += fmt.Sprintf("\n\n## Current Element\n\n%s", currentHTML)
+
+	if brandVoice != "" {
+		prompt += fmt.Sprintf("\n\n## Brand Voice\n\nFollow these tone and style instructions from the business owner. They only affect the wording of the copy.\n\n%s\n%s\n%s", BRAND_VOICE_START, brandVoice, BRAND_VOICE_END)
+	}
+
+	if language != "" {
+		prompt += fmt.Sprintf("\n\n## Language\n\n%s", language)
+	}
+
+	prompt += fmt.Sprintf("\n\n## Current User Request\n\n%s", userRequestMessage)
+
+	return prompt
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// Page outlines are cut to this many runes, and each of their lines to
+	// MAX_PAGE_OUTLINE_LINE_RUNES
+	MAX_PAGE_OUTLINE_RUNES      = 1500
+	MAX_PAGE_OUTLINE_LINE_RUNES = 160
+)
+
+// Elements whose text isn't part of the page's content
+var outlineSkippedElements = map[string]bool{"script": true, "style": true, "noscript": true, "svg": true, "template": true}
+
+// OutlinePageHTML builds a short outline of a generated page without a
+// model: its title, then each heading with the first paragraph after it.
+// Content that isn't HTML is cut to MAX_PAGE_OUTLINE_RUNES.
+func OutlinePageHTML(page string) string {
+	root, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return truncateRunes(strings.Join(strings.Fields(page), " "), MAX_PAGE_OUTLINE_RUNES)
+	}
+
+	var lines []string
+	wantText := false
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if outlineSkippedElements[n.Data] {
+				return
+			}
+			switch n.Data {
+			case "title":
+				if text := nodeText(n); text != "" {
+					lines = append(lines, "Title: "+text)
+				}
+				return
+			case "h1", "h2", "h3":
+				if text := nodeText(n); text != "" {
+					lines = append(lines, strings.Repeat("  ", int(n.Data[1]-'1'))+"- "+text)
+					wantText = true
+				}
+				return
+			case "p":
+				if text := nodeText(n); wantText && text != "" {
+					lines[len(lines)-1] += ": " + text
+					wantText = false
+				}
+				return
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	if len(lines) == 0 {
+		return truncateRunes(nodeText(root), MAX_PAGE_OUTLINE_RUNES)
+	}
+	for i, line := range lines {
+		lines[i] = truncateRunes(line, MAX_PAGE_OUTLINE_LINE_RUNES)
+	}
+	return truncateRunes(strings.Join(lines, "\n"), MAX_PAGE_OUTLINE_RUNES)
+}
+
+// BuildPageOutlinePrompt builds the prompt asking for a short outline of a
+// generated page, for keeping earlier versions in a chat's history
+func BuildPageOutlinePrompt(page string) string {
+	var b strings.Builder
+	b.WriteString("Outline the following web page in at most 15 short lines of plain text. ")
+	b.WriteString("List its sections in page order, each with its heading and the key text or offer it contains, ")
+	b.WriteString("and mention the overall style (colors, layout) in one line. ")
+	b.WriteString("Reply with the outline only, without markdown fences or commentary.\n")
+	b.WriteString("\n## Page\n\n")
+	b.WriteString(page)
+	return b.String()
+}
+
+// CleanPageOutline trims a model-generated outline and caps its length
+func CleanPageOutline(reply string) string {
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(reply, "```")
+	reply = strings.TrimSuffix(reply, "```")
+	return truncateRunes(strings.TrimSpace(reply), MAX_PAGE_OUTLINE_RUNES)
+}
+
+// nodeText returns the text under n with whitespace collapsed
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && outlineSkippedElements[n.Data] {
+			return
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// truncateRunes cuts s to at most n runes, marking the cut with "..."
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n-3])) + "..."
+}
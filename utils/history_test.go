@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestOutlinePageHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"headings with their first paragraph", `<html><head><title>Crumb Bakery</title><style>h1 { color: red }</style></head><body>
+			<h1>Fresh bread</h1><p>Baked <em>daily</em>.</p><p>Second paragraph.</p>
+			<h2>Menu</h2><ul><li>Rye</li></ul>
+			<h3>Hours</h3><p>7am to 3pm</p>
+			<script>var h2 = "<h2>Not a heading</h2>";</script></body></html>`,
+			"Title: Crumb Bakery\n- Fresh bread: Baked daily .\n  - Menu\n    - Hours: 7am to 3pm"},
+		{"no headings", `<div><p>Just   some
+			text</p></div>`, "Just some text"},
+		{"empty headings skipped", `<h1> </h1><p>Orphan</p><h2>Real</h2>`, "  - Real"},
+	}
+	for _, tt := range tests {
+		if got := OutlinePageHTML(tt.page); got != tt.want {
+			t.Errorf("%s: OutlinePageHTML() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOutlinePageHTMLLimits(t *testing.T) {
+	var b strings.Builder
+	for range 100 {
+		b.WriteString("<h2>" + strings.Repeat("long heading ", 30) + "</h2>")
+	}
+
+	outline := OutlinePageHTML(b.String())
+	if n := utf8.RuneCountInString(outline); n > MAX_PAGE_OUTLINE_RUNES {
+		t.Errorf("outline has %d runes, want at most %d", n, MAX_PAGE_OUTLINE_RUNES)
+	}
+	for _, line := range strings.Split(outline, "\n") {
+		if n := utf8.RuneCountInString(line); n > MAX_PAGE_OUTLINE_LINE_RUNES {
+			t.Errorf("outline line has %d runes, want at most %d", n, MAX_PAGE_OUTLINE_LINE_RUNES)
+		}
+	}
+	if !strings.HasSuffix(outline, "...") {
+		t.Errorf("outline = %q, want the cut marked", outline[len(outline)-20:])
+	}
+}
+
+func TestCleanPageOutline(t *testing.T) {
+	if got := CleanPageOutline("\n```\n- Hero\n- Menu\n```\n"); got != "- Hero\n- Menu" {
+		t.Errorf("CleanPageOutline() = %q", got)
+	}
+	if got := CleanPageOutline(strings.Repeat("é", 2000)); utf8.RuneCountInString(got) != MAX_PAGE_OUTLINE_RUNES {
+		t.Errorf("CleanPageOutline() of a long reply has %d runes, want %d", utf8.RuneCountInString(got), MAX_PAGE_OUTLINE_RUNES)
+	}
+}
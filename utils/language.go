@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// Fewer recognised words than this and DetectLanguage gives up
+	MIN_DETECT_WORDS = 12
+)
+
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Names used in prompts, by language code
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"pt": "Portuguese",
+	"it": "Italian",
+	"nl": "Dutch",
+}
+
+// Common words of each language DetectLanguage knows. Words shared by
+// several languages count for each of them.
+var languageWords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "for", "with", "our", "your", "you", "we", "are", "on", "that", "this", "from", "more", "about", "contact", "us", "at", "by", "it"},
+	"es": {"el", "la", "los", "las", "de", "del", "y", "en", "con", "para", "por", "nuestro", "nuestra", "nuestros", "su", "sus", "que", "es", "una", "un", "más", "sobre", "nosotros", "contacto"},
+	"fr": {"le", "la", "les", "de", "des", "du", "et", "en", "pour", "avec", "notre", "nos", "votre", "vos", "est", "une", "un", "sur", "nous", "plus", "qui", "dans", "au", "aux"},
+	"de": {"der", "die", "das", "und", "ist", "mit", "für", "von", "zu", "den", "dem", "ein", "eine", "unser", "unsere", "ihr", "ihre", "wir", "sie", "auf", "mehr", "über", "uns", "nicht"},
+	"pt": {"o", "os", "as", "de", "do", "da", "dos", "das", "e", "em", "com", "para", "nosso", "nossa", "nossos", "seu", "sua", "que", "é", "uma", "um", "mais", "sobre", "contato"},
+	"it": {"il", "lo", "la", "gli", "le", "di", "del", "della", "e", "in", "con", "per", "nostro", "nostra", "nostri", "tuo", "tua", "che", "è", "una", "un", "più", "chi", "siamo"},
+	"nl": {"de", "het", "een", "en", "van", "voor", "met", "onze", "ons", "uw", "jouw", "is", "zijn", "wij", "we", "op", "meer", "over", "dat", "niet", "bij", "naar", "ook", "contact"},
+}
+
+var languageWordSets = func() map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(languageWords))
+	for lang, words := range languageWords {
+		sets[lang] = make(map[string]bool, len(words))
+		for _, w := range words {
+			sets[lang][w] = true
+		}
+	}
+	return sets
+}()
+
+// ValidLocale reports whether locale looks like a BCP 47 tag, such as es or es-MX
+func ValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// LocaleLanguage returns the lowercase language code of a locale, es for es-MX
+func LocaleLanguage(locale string) string {
+	lang, _, _ := strings.Cut(locale, "-")
+	return strings.ToLower(lang)
+}
+
+// LanguageName returns the English name of the locale's language, or the
+// locale itself for languages without a known name
+func LanguageName(locale string) string {
+	if name, ok := languageNames[LocaleLanguage(locale)]; ok {
+		return name
+	}
+	return locale
+}
+
+// LanguageInstruction builds the prompt section telling the model which
+// language to write the page in. strict adds a stronger reminder, for
+// retrying a reply that came back in another language.
+func LanguageInstruction(locale string, strict bool) string {
+	if locale == "" {
+		return ""
+	}
+
+	name := LanguageName(locale)
+	instruction := fmt.Sprintf("Write all visible text of the page in %s (locale %s): headings, body copy, buttons, navigation, "+
+		"form labels, alt text and meta descriptions, using the spelling, date, number and currency conventions of that locale. "+
+		"Keep the values of data-image-keywords and data-image-background-keywords attributes in English; "+
+		"they are search terms for the image library and are never shown.", name, locale)
+
+	if strict {
+		instruction += fmt.Sprintf("\n\nIMPORTANT: a previous reply to this request was not written in %s. "+
+			"Every visible word must be in %s. Only proper names, such as the business name, may stay in another language.", name, name)
+	}
+
+	return instruction
+}
+
+// DetectLanguage returns the code of the dominant language of the visible
+// text of an HTML document or fragment, by counting common words of each
+// language it knows. It returns an empty string when there is too little
+// text or no language clearly leads.
+func DetectLanguage(document string) string {
+	counts := map[string]int{}
+	total := 0
+	for _, word := range visibleWords(document) {
+		matched := false
+		for lang, words := range languageWordSets {
+			if words[word] {
+				counts[lang]++
+				matched = true
+			}
+		}
+		if matched {
+			total++
+		}
+	}
+	if total < MIN_DETECT_WORDS {
+		return ""
+	}
+
+	best, bestCount, secondCount := "", 0, 0
+	for lang, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, secondCount = lang, count, bestCount
+		case count > secondCount:
+			secondCount = count
+		}
+	}
+
+	// Closely related languages share many words, so require a clear lead
+	if bestCount*4 < secondCount*5 {
+		return ""
+	}
+	return best
+}
+
+// LanguageMismatch reports whether the document's text was detected as a
+// language other than the locale's. Undetermined text and languages the
+// detector doesn't know never mismatch.
+func LanguageMismatch(document string, locale string) (bool, string) {
+	want := LocaleLanguage(locale)
+	if _, ok := languageWords[want]; !ok {
+		return false, ""
+	}
+	detected := DetectLanguage(document)
+	return detected != "" && detected != want, detected
+}
+
+// visibleWords returns the lowercase words of the document's text nodes,
+// skipping scripts and styles
+func visibleWords(document string) []string {
+	var words []string
+	skip := 0
+
+	z := html.NewTokenizer(strings.NewReader(document))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return words
+		case html.StartTagToken:
+			if name, _ := z.TagName(); isHiddenTextTag(string(name)) {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); isHiddenTextTag(string(name)) && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			words = append(words, strings.FieldsFunc(strings.ToLower(string(z.Text())), func(r rune) bool {
+				return !unicode.IsLetter(r)
+			})...)
+		}
+	}
+}
+
+func isHiddenTextTag(name string) bool {
+	return name == "script" || name == "style" || name == "noscript" || name == "template"
+}
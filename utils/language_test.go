@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLanguageFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "language", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{"english page", readLanguageFixture(t, "en.html"), "en"},
+		{"spanish page", readLanguageFixture(t, "es.html"), "es"},
+		{"too little text", "<h1>Panadería Rosa</h1><p>Pan fresco</p>", ""},
+		// Scripts and styles aren't visible text
+		{"script only", "<p>Rosa</p><script>var s = 'the and of to is in for with our your you we are on that';</script>", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.document); got != tt.want {
+			t.Errorf("%s: DetectLanguage() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLanguageMismatch(t *testing.T) {
+	en, es := readLanguageFixture(t, "en.html"), readLanguageFixture(t, "es.html")
+	tests := []struct {
+		document, locale string
+		mismatch         bool
+		detected         string
+	}{
+		{en, "es-MX", true, "en"},
+		{es, "es-MX", false, "es"},
+		{es, "ES", false, "es"},
+		{es, "en-US", true, "es"},
+		{en, "en", false, "en"},
+		// Languages the detector doesn't know never mismatch
+		{en, "ja-JP", false, ""},
+		{"<p>Hola</p>", "en", false, ""},
+	}
+	for _, tt := range tests {
+		mismatch, detected := LanguageMismatch(tt.document, tt.locale)
+		if mismatch != tt.mismatch || detected != tt.detected {
+			t.Errorf("LanguageMismatch(%.20q, %s) = %v, %q; want %v, %q", tt.document, tt.locale, mismatch, detected, tt.mismatch, tt.detected)
+		}
+	}
+}
+
+func TestLocales(t *testing.T) {
+	for locale, valid := range map[string]bool{"es": true, "es-MX": true, "zh-Hant-TW": true, "": false, "e": false, "es_MX": false, "es-": false} {
+		if got := ValidLocale(locale); got != valid {
+			t.Errorf("ValidLocale(%q) = %v, want %v", locale, got, valid)
+		}
+	}
+	for locale, name := range map[string]string{"es-MX": "Spanish", "DE": "German", "ja-JP": "ja-JP"} {
+		if got := LanguageName(locale); got != name {
+			t.Errorf("LanguageName(%q) = %q, want %q", locale, got, name)
+		}
+	}
+}
+
+func TestLanguageInstruction(t *testing.T) {
+	if got := LanguageInstruction("", false); got != "" {
+		t.Errorf("LanguageInstruction() without a locale = %q", got)
+	}
+
+	plain := LanguageInstruction("es-MX", false)
+	if !strings.Contains(plain, "Spanish (locale es-MX)") || !strings.Contains(plain, "data-image-keywords") || strings.Contains(plain, "IMPORTANT") {
+		t.Errorf("LanguageInstruction(es-MX) = %q, want the language and English image keywords", plain)
+	}
+	if strict := LanguageInstruction("es-MX", true); !strings.HasPrefix(strict, plain) || !strings.Contains(strict, "IMPORTANT") {
+		t.Errorf("strict LanguageInstruction(es-MX) = %q, want the reminder added", strict)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import "strings"
+
+const siteMetadataSchema = `{
+  "sections": [{"id": "<id attribute of the section element>", "title": "<short human-readable title>"}],
+  "palette": ["#rrggbb"],
+  "fonts": ["<font family>"],
+  "nav_labels": ["<navigation link label>"]
+}`
+
+// BuildSiteMetadataPrompt builds the prompt asking for a JSON summary of a
+// generated page. previousError is set when retrying after a reply that
+// didn't validate.
+func BuildSiteMetadataPrompt(html string, previousError string) string {
+	var b strings.Builder
+	b.WriteString("Summarize the following web page as a JSON object with exactly this shape:\n\n")
+	b.WriteString(siteMetadataSchema)
+	b.WriteString("\n\nList every top-level section in page order, using its id attribute (make one up in kebab-case if it has none). ")
+	b.WriteString("The palette holds up to 12 hex colors used by the page, most prominent first. ")
+	b.WriteString("fonts lists the font families it uses and nav_labels the labels of its navigation links, in order.\n")
+	b.WriteString("Reply with the JSON object only, without markdown fences or commentary.\n")
+	if previousError != "" {
+		b.WriteString("\nYour previous reply was rejected: ")
+		b.WriteString(previousError)
+		b.WriteString(". Reply with valid JSON matching the shape above.\n")
+	}
+	b.WriteString("\n## Page\n\n")
+	b.WriteString(html)
+	return b.String()
+}
+
+// ExtractJSONObject returns the outermost {...} of a model reply, dropping
+// markdown fences and text around it
+func ExtractJSONObject(reply string) string {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return strings.TrimSpace(reply)
+	}
+	return reply[start : end+1]
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractJSONObject(t *testing.T) {
+	tests := []struct {
+		reply, want string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"```json\n{\"a\": {\"b\": 2}}\n```", `{"a": {"b": 2}}`},
+		{"Here you go: {\"a\": 1} Enjoy!", `{"a": 1}`},
+		{"  no object  ", "no object"},
+		{"} backwards {", "} backwards {"},
+	}
+	for _, tt := range tests {
+		if got := ExtractJSONObject(tt.reply); got != tt.want {
+			t.Errorf("ExtractJSONObject(%q) = %q, want %q", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestBuildSiteMetadataPrompt(t *testing.T) {
+	prompt := BuildSiteMetadataPrompt("<section id=\"hero\"></section>", "")
+	if !strings.HasSuffix(prompt, "## Page\n\n<section id=\"hero\"></section>") || strings.Contains(prompt, "rejected") {
+		t.Errorf("BuildSiteMetadataPrompt() = %q, want the page last and no rejection", prompt)
+	}
+	retry := BuildSiteMetadataPrompt("<p></p>", "palette: 1 to 12 colors required")
+	if !strings.Contains(retry, "Your previous reply was rejected: palette: 1 to 12 colors required.") {
+		t.Errorf("retry prompt = %q, want the rejection", retry)
+	}
+}
@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Reasons an OutputGuard stops a generation
+const (
+	OUTPUT_TOO_LARGE  = "output_too_large"
+	OUTPUT_REPETITION = "repetition"
+)
+
+const (
+	// Output tokens are estimated from its size at this many bytes each,
+	// as counting them while streaming is too slow
+	ESTIMATED_BYTES_PER_TOKEN = 4
+
+	// Output repeating the same block more than REPETITION_MAX_REPEATS
+	// times within the last REPETITION_WINDOW_BYTES is looping. It is
+	// checked each time the output grows by a block.
+	REPETITION_BLOCK_BYTES  = 1024
+	REPETITION_MAX_REPEATS  = 5
+	REPETITION_WINDOW_BYTES = 16 * 1024
+)
+
+// voidElements have no end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// OutputGuard stops runaway model output: output over a size or estimated
+// token ceiling, or repeating itself. Limits of 0 aren't enforced. It isn't
+// safe for concurrent use.
+type OutputGuard struct {
+	maxBytes  int
+	maxTokens int
+
+	nextCheck int    // output size at which repetition is checked next
+	reason    string // why the output was stopped
+	keep      int    // bytes of the output kept once stopped
+}
+
+// NewOutputGuard creates a guard for one generation's output
+func NewOutputGuard(maxBytes, maxTokens int) *OutputGuard {
+	return &OutputGuard{
+		maxBytes:  maxBytes,
+		maxTokens: maxTokens,
+		nextCheck: REPETITION_BLOCK_BYTES,
+	}
+}
+
+// Check inspects the output so far and returns false once it should be
+// stopped. After that, Reason says why and Truncate cuts it.
+func (g *OutputGuard) Check(content string) bool {
+	if g.reason != "" {
+		return false
+	}
+
+	// Chunks may be larger than a block, so each block boundary passed is
+	// checked as if the output had stopped there
+	for ; g.nextCheck <= len(content); g.nextCheck += REPETITION_BLOCK_BYTES {
+		if start, ok := repetitionStart(content[:g.nextCheck]); ok {
+			g.stop(OUTPUT_REPETITION, start+REPETITION_BLOCK_BYTES)
+			return false
+		}
+	}
+
+	if limit := g.sizeLimit(); limit > 0 && len(content) > limit {
+		g.stop(OUTPUT_TOO_LARGE, limit)
+		return false
+	}
+	return true
+}
+
+// sizeLimit returns the lower of the size and estimated token ceilings in
+// bytes, or 0 when neither is enforced
+func (g *OutputGuard) sizeLimit() int {
+	limit := g.maxBytes
+	if g.maxTokens > 0 {
+		if tokenBytes := g.maxTokens * ESTIMATED_BYTES_PER_TOKEN; limit == 0 || tokenBytes < limit {
+			limit = tokenBytes
+		}
+	}
+	return limit
+}
+
+func (g *OutputGuard) stop(reason string, keep int) {
+	g.reason = reason
+	g.keep = keep
+}
+
+// Reason returns why the output was stopped, or "" while it hasn't been
+func (g *OutputGuard) Reason() string {
+	return g.reason
+}
+
+// Truncate cuts stopped output after the last complete top-level element
+// of what the guard keeps: output over a ceiling is cut within it, and
+// looping output after the first of its repeated blocks. Output that wasn't
+// stopped is returned as it is.
+func (g *OutputGuard) Truncate(content string) string {
+	if g.reason == "" {
+		return content
+	}
+	return TruncateAtTopLevelElement(content[:min(g.keep, len(content))])
+}
+
+// repetitionStart reports whether the last block of content occurs more
+// than REPETITION_MAX_REPEATS times in its last REPETITION_WINDOW_BYTES,
+// returning where the first of those occurrences starts. content must be at
+// least a block long.
+func repetitionStart(content string) (int, bool) {
+	block := content[len(content)-REPETITION_BLOCK_BYTES:]
+	windowStart := max(0, len(content)-REPETITION_WINDOW_BYTES)
+	window := content[windowStart:]
+
+	// Occurrences don't overlap, so output looping over a unit shorter than
+	// a block has to repeat it for as many blocks
+	first, count := -1, 0
+	for i := 0; count <= REPETITION_MAX_REPEATS; i += REPETITION_BLOCK_BYTES {
+		j := strings.Index(window[i:], block)
+		if j < 0 {
+			return 0, false
+		}
+		i += j
+		if first < 0 {
+			first = i
+		}
+		count++
+	}
+	return windowStart + first, true
+}
+
+// TruncateAtTopLevelElement cuts a page after its last complete top-level
+// element, a child of <body> (or of the document, for markup without one),
+// and closes the elements left open around it. A page without a complete
+// top-level element is cut after its last complete tag instead.
+func TruncateAtTopLevelElement(page string) string {
+	z := html.NewTokenizer(strings.NewReader(page))
+
+	var open []string
+	base := 0 // depth of the top-level elements
+	offset := 0
+
+	// Where to cut, and the elements open there
+	cut, cutOpen := -1, []string(nil)
+	lastTag, lastTagOpen := 0, []string(nil)
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		offset += len(z.Raw())
+
+		var name string
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tag, _ := z.TagName()
+			name = string(tag)
+			if tt == html.StartTagToken && !voidElements[name] {
+				open = append(open, name)
+			}
+			if name == "body" {
+				base = len(open)
+			}
+		case html.EndTagToken:
+			tag, _ := z.TagName()
+			name = string(tag)
+			// Unmatched end tags are ignored, as by browsers
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == name {
+					open = open[:i]
+					break
+				}
+			}
+		default:
+			continue
+		}
+
+		lastTag, lastTagOpen = offset, append(lastTagOpen[:0], open...)
+		if len(open) == base && name != "body" {
+			cut, cutOpen = offset, append(cutOpen[:0], open...)
+		}
+	}
+
+	if cut < 0 {
+		cut, cutOpen = lastTag, lastTagOpen
+	}
+	var b strings.Builder
+	b.WriteString(page[:cut])
+	for i := len(cutOpen) - 1; i >= 0; i-- {
+		b.WriteString("</" + cutOpen[i] + ">")
+	}
+	return b.String()
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTruncateAtTopLevelElement(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want string
+	}{
+		{"cut inside a section",
+			`<html><head><title>Rye</title></head><body><section id="a"><p>A</p></section><section id="b"><p>B`,
+			`<html><head><title>Rye</title></head><body><section id="a"><p>A</p></section></body></html>`},
+		{"cut inside a tag",
+			`<html><body><header>Rye</header><main><img src="/a.jpg"><p class="lo`,
+			`<html><body><header>Rye</header></body></html>`},
+		{"void and self-closing elements are complete",
+			`<html><body><section>A</section><hr><img src="/a.jpg"/><div>B`,
+			`<html><body><section>A</section><hr><img src="/a.jpg"/></body></html>`},
+		{"unmatched end tags are ignored",
+			`<html><body><section>A</div></section><section>B`,
+			`<html><body><section>A</div></section></body></html>`},
+		{"markup without a body",
+			`<section>A</section><section>B</section><section>C`,
+			`<section>A</section><section>B</section>`},
+		{"no complete top-level element",
+			`<html><body><main><section><p>A</p><p>B`,
+			`<html><body><main><section><p>A</p><p></p></section></main></body></html>`},
+		{"complete page", `<html><body><p>A</p></body></html>`, `<html><body><p>A</p></body></html>`},
+	}
+	for _, tt := range tests {
+		if got := TruncateAtTopLevelElement(tt.page); got != tt.want {
+			t.Errorf("%s: TruncateAtTopLevelElement() =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// runawayPage returns a page head and n copies of a section
+func runawayPage(n int) string {
+	return `<html><head><title>Rye</title></head><body><header><h1>Rye &amp; Co</h1></header>` +
+		strings.Repeat(`<section class="promo"><h2>Fresh bread</h2><p>Sourdough, rye and spelt, baked daily.</p></section>`, n)
+}
+
+func TestOutputGuardRepetition(t *testing.T) {
+	page := runawayPage(400)
+	g := NewOutputGuard(0, 0)
+
+	// Streamed in chunks that don't line up with blocks or sections
+	stoppedAt := -1
+	for end := 333; end <= len(page); end += 333 {
+		if !g.Check(page[:end]) {
+			stoppedAt = end
+			break
+		}
+	}
+	if stoppedAt < 0 || g.Reason() != OUTPUT_REPETITION {
+		t.Fatalf("Check() of %d bytes of repeated sections didn't stop, reason %q", len(page), g.Reason())
+	}
+	if stoppedAt > (REPETITION_MAX_REPEATS+3)*REPETITION_BLOCK_BYTES {
+		t.Errorf("Check() stopped at %d bytes, want within %d blocks", stoppedAt, REPETITION_MAX_REPEATS+3)
+	}
+	if g.Check(page[:stoppedAt+1000]) {
+		t.Error("Check() after stopping = true, want false")
+	}
+
+	// The loop is cut after the first repeated block, at a section boundary
+	got := g.Truncate(page[:stoppedAt])
+	body, ok := strings.CutSuffix(got, "</body></html>")
+	if !ok || !strings.HasSuffix(body, "</section>") || !strings.HasPrefix(page, body) {
+		t.Fatalf("Truncate() = %q, want the output up to a complete section", got)
+	}
+	if len(body) > stoppedAt-REPETITION_MAX_REPEATS*REPETITION_BLOCK_BYTES+REPETITION_BLOCK_BYTES {
+		t.Errorf("Truncate() kept %d of %d bytes, want the repeats dropped", len(body), stoppedAt)
+	}
+
+	// Distinct sections of the same size aren't a loop
+	var b strings.Builder
+	for i := range 400 {
+		fmt.Fprintf(&b, `<section id="s%d"><h2>Loaf %d</h2><p>Sourdough, rye and spelt, baked daily.</p></section>`, i, i)
+	}
+	if g := NewOutputGuard(0, 0); !g.Check(b.String()) {
+		t.Errorf("Check() of distinct sections stopped them: %s", g.Reason())
+	}
+}
+
+func TestOutputGuardCeilings(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<html><body>")
+	for i := 0; b.Len() < 10000; i++ {
+		fmt.Fprintf(&b, `<section id="s%d"><p>Loaf number %d</p></section>`, i, i)
+	}
+	page := b.String()
+
+	tests := []struct {
+		name                string
+		maxBytes, maxTokens int
+		keep                int // bytes kept at most, or 0 for the whole page
+	}{
+		{"under the ceilings", 20000, 5000, 0},
+		{"bytes", 4096, 0, 4096},
+		{"tokens", 0, 500, 500 * ESTIMATED_BYTES_PER_TOKEN},
+		{"the lower of both", 8000, 500, 500 * ESTIMATED_BYTES_PER_TOKEN},
+		{"no ceilings", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		g := NewOutputGuard(tt.maxBytes, tt.maxTokens)
+		ok := g.Check(page)
+		got := g.Truncate(page)
+		if tt.keep == 0 {
+			if !ok || g.Reason() != "" || got != page {
+				t.Errorf("%s: Check() = %v, reason %q; want the page untouched", tt.name, ok, g.Reason())
+			}
+			continue
+		}
+		if ok || g.Reason() != OUTPUT_TOO_LARGE {
+			t.Errorf("%s: Check() = %v, reason %q; want output_too_large", tt.name, ok, g.Reason())
+		}
+		body, cut := strings.CutSuffix(got, "</body></html>")
+		if !cut || !strings.HasSuffix(body, "</section>") || !strings.HasPrefix(page, body) || len(body) > tt.keep || len(body) < tt.keep-100 {
+			t.Errorf("%s: Truncate() kept %d bytes ending %q, want up to the last section within %d", tt.name, len(body), body[max(0, len(body)-30):], tt.keep)
+		}
+	}
+}
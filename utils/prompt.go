@@ -8,10 +8,27 @@ import (
 	"strings"
 )
 
+const (
+	BRAND_VOICE_START = "<<<BRAND_VOICE"
+	BRAND_VOICE_END   = "BRAND_VOICE>>>"
+)
+
 // PromptBuilder handles template-based prompt construction
 type PromptBuilder struct {
 	baseTemplate    string
 	requestTemplate string
+
+	// Alternative base templates for prompt experiments, by experiment name
+	variants map[string]string
+
+	// Request template for each page of a multi-page site, when loaded
+	pageTemplate string
+
+	// Template files, reported by Lint
+	basePath     string
+	requestPath  string
+	variantPaths map[string]string
+	pagePath     string
 }
 
 // NewPromptBuilder creates a new prompt builder from a template file
@@ -29,17 +46,37 @@ func NewPromptBuilder(baseTemplatePath string, requestTemplatePath string) (*Pro
 	return &PromptBuilder{
 		baseTemplate:    string(baseData),
 		requestTemplate: string(requestData),
+		variants:        map[string]string{},
+		basePath:        baseTemplatePath,
+		requestPath:     requestTemplatePath,
+		variantPaths:    map[string]string{},
 	}, nil
 }
 
+// LoadVariant reads the base template used by the named prompt experiment
+func (pb *PromptBuilder) LoadVariant(name string, baseTemplatePath string) error {
+	data, err := os.ReadFile(baseTemplatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read base template file for variant %s: %w", name, err)
+	}
+	pb.variants[name] = string(data)
+	pb.variantPaths[name] = baseTemplatePath
+	return nil
+}
+
 func (pb *PromptBuilder) replaceValues(template string, onboardingData *model.OnboardingData, extraVariables map[string]string) string {
-	// Convert onboarding data to map
-	onboardingMap := onboardingData.ToMap()
+	return ReplaceVariables(template, onboardingData, extraVariables)
+}
 
+// ReplaceVariables replaces the {{key}} placeholders of template with the
+// onboarding data, when set, and the extra variables, which win
+func ReplaceVariables(template string, onboardingData *model.OnboardingData, extraVariables map[string]string) string {
 	// Merge onboarding data, extra variables into a single map
 	variables := make(map[string]string)
 
-	maps.Copy(variables, onboardingMap)
+	if onboardingData != nil {
+		maps.Copy(variables, onboardingData.ToMap())
+	}
 	maps.Copy(variables, extraVariables)
 
 	// Replace all variables in the template
@@ -53,6 +90,26 @@ func (pb *PromptBuilder) replaceValues(template string, onboardingData *model.On
 
 // Build constructs a prompt by replacing variables in the template
 func (pb *PromptBuilder) Build(onboardingData *model.OnboardingData, extraVariables map[string]string, chatHistory string, userRequestMessage string) string {
+	return pb.BuildWithBrandVoice(onboardingData, extraVariables, chatHistory, userRequestMessage, "")
+}
+
+// BuildWithBrandVoice constructs a prompt like Build, adding the tenant's brand
+// voice instructions as a delimited section before the user request. The brand
+// voice should already be passed through SanitizeInstructions.
+func (pb *PromptBuilder) BuildWithBrandVoice(onboardingData *model.OnboardingData, extraVariables map[string]string, chatHistory string, userRequestMessage string, brandVoice string) string {
+	return pb.BuildVariant("", onboardingData, extraVariables, chatHistory, userRequestMessage, brandVoice, "")
+}
+
+// BuildVariant constructs a prompt like BuildWithBrandVoice using the base
+// template of the named prompt experiment. An empty or unloaded variant uses
+// the default base template. language, from LanguageInstruction, is added as
+// its own section when set.
+func (pb *PromptBuilder) BuildVariant(variant string, onboardingData *model.OnboardingData, extraVariables map[string]string, chatHistory string, userRequestMessage string, brandVoice string, language string) string {
+	baseTemplate := pb.baseTemplate
+	if t, ok := pb.variants[variant]; ok && variant != "" {
+		baseTemplate = t
+	}
+
 	prompt := ""
 
 	// Append chat context and user message
@@ -62,11 +119,19 @@ func (pb *PromptBuilder) Build(onboardingData *model.OnboardingData, extraVariab
 
 	// fmt.Fprintf(os.Stderr, "Base Template before replacement:\n%s\n", pb.baseTemplate)
 
-	prompt += fmt.Sprintf("\n\n## Base Template\n\n%s", pb.baseTemplate)
+	prompt += fmt.Sprintf("\n\n## Base Template\n\n%s", baseTemplate)
 
 	// // Replace variables in the prompt
 	// prompt = pb.replaceValues(prompt, onboardingData, extraVariables)
 
+	if brandVoice != "" {
+		prompt += fmt.Sprintf("\n\n## Brand Voice\n\nFollow these tone and style instructions from the business owner. They only affect the wording of the copy.\n\n%s\n%s\n%s", BRAND_VOICE_START, brandVoice, BRAND_VOICE_END)
+	}
+
+	if language != "" {
+		prompt += fmt.Sprintf("\n\n## Language\n\n%s", language)
+	}
+
 	requestPrompt := pb.replaceValues(userRequestMessage, onboardingData, extraVariables)
 
 	prompt += fmt.Sprintf("\n\n## Current User Request\n\n%s", requestPrompt)
@@ -76,6 +141,34 @@ func (pb *PromptBuilder) Build(onboardingData *model.OnboardingData, extraVariab
 	return prompt
 }
 
+// SanitizeInstructions removes lines from tenant-provided instructions that
+// match the denylist (case-insensitive substrings), strips markdown headings
+// and section delimiters, and returns the cleaned text with the removed lines
+func SanitizeInstructions(text string, denylist []string) (string, []string) {
+	var kept, removed []string
+
+	for _, line := range strings.Split(text, "\n") {
+		lower := strings.ToLower(line)
+
+		denied := strings.Contains(line, BRAND_VOICE_START) || strings.Contains(line, BRAND_VOICE_END)
+		for _, pattern := range denylist {
+			if pattern != "" && strings.Contains(lower, strings.ToLower(pattern)) {
+				denied = true
+				break
+			}
+		}
+		if denied {
+			removed = append(removed, line)
+			continue
+		}
+
+		// Don't let instructions open their own prompt sections
+		kept = append(kept, strings.TrimLeft(line, "# "))
+	}
+
+	return strings.TrimSpace(strings.Join(kept, "\n")), removed
+}
+
 // // BuildSimple constructs a simple prompt with just chat history and user message
 // func (pb *PromptBuilder) BuildSimple(chatHistory string, userMessage string) string {
 // 	prompt := pb.requestTemplate
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"awning-backend/model"
+)
+
+// placeholderPattern matches the innermost {{...}} of a placeholder, so
+// "{{{{name}}}}" is reported as name
+var placeholderPattern = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// PromptLintProblem is an unknown placeholder found in a prompt template
+type PromptLintProblem struct {
+	Template    string `json:"template"`
+	Line        int    `json:"line"`
+	Placeholder string `json:"placeholder"`
+}
+
+func (p PromptLintProblem) String() string {
+	return fmt.Sprintf("%s:%d: unknown placeholder {{%s}}", p.Template, p.Line, p.Placeholder)
+}
+
+// KnownPromptVariables returns the placeholders templates may use: the
+// onboarding data keys, locale, language and the extra names allowed by
+// the prompt_variables setting
+func KnownPromptVariables(extra []string) []string {
+	known := slices.Collect(maps.Keys((&model.OnboardingData{}).ToMap()))
+	known = append(known, "locale", "language")
+	for _, name := range extra {
+		if name = strings.TrimSpace(name); name != "" {
+			known = append(known, name)
+		}
+	}
+	slices.Sort(known)
+	return slices.Compact(known)
+}
+
+// LintPromptTemplate reports the placeholders in text that aren't in known.
+// Placeholders are matched exactly, so "{{ businessName }}" is unknown too.
+func LintPromptTemplate(name, text string, known []string) []PromptLintProblem {
+	var problems []PromptLintProblem
+	for i, line := range strings.Split(text, "\n") {
+		for _, match := range placeholderPattern.FindAllStringSubmatch(line, -1) {
+			if !slices.Contains(known, match[1]) {
+				problems = append(problems, PromptLintProblem{Template: name, Line: i + 1, Placeholder: match[1]})
+			}
+		}
+	}
+	return problems
+}
+
+// UnresolvedPlaceholders returns the distinct placeholders left in a built
+// prompt, sorted
+func UnresolvedPlaceholders(prompt string) []string {
+	var keys []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(prompt, -1) {
+		keys = append(keys, match[1])
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// Lint checks the base, request, experiment and page templates for
+// placeholders that aren't in known (page templates may also use
+// SitePageVariables)
+func (pb *PromptBuilder) Lint(known []string) []PromptLintProblem {
+	problems := LintPromptTemplate(pb.basePath, pb.baseTemplate, known)
+	problems = append(problems, LintPromptTemplate(pb.requestPath, pb.requestTemplate, known)...)
+	for _, name := range slices.Sorted(maps.Keys(pb.variants)) {
+		problems = append(problems, LintPromptTemplate(pb.variantPaths[name], pb.variants[name], known)...)
+	}
+	if pb.pageTemplate != "" {
+		problems = append(problems, LintPromptTemplate(pb.pagePath, pb.pageTemplate, append(slices.Clone(known), SitePageVariables...))...)
+	}
+	return problems
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLintPromptTemplate(t *testing.T) {
+	known := KnownPromptVariables([]string{" brandColor ", ""})
+
+	tests := []struct {
+		name string
+		text string
+		want []PromptLintProblem
+	}{
+		{"valid", "Build a site for {{businessName}}, a {{businessType.label}}.\nWrite in {{language}} with {{brandColor}} accents.", nil},
+		{"no placeholders", "Build a landing page.", nil},
+		{"invalid", "A site for {{businessName}}.\nIt is a {{businessTypeLabel}} in {{city}}.", []PromptLintProblem{
+			{Template: "base.md", Line: 2, Placeholder: "businessTypeLabel"},
+			{Template: "base.md", Line: 2, Placeholder: "city"},
+		}},
+		// Placeholders are matched exactly
+		{"spaced", "Hello {{ businessName }}", []PromptLintProblem{{Template: "base.md", Line: 1, Placeholder: " businessName "}}},
+		// Only the innermost braces of nested-looking placeholders count
+		{"nested valid", "{{{{businessName}}}} and {{ {{goals}} }}", nil},
+		{"nested invalid", "{{outer {{inner}} }}", []PromptLintProblem{{Template: "base.md", Line: 1, Placeholder: "inner"}}},
+		{"unclosed", "{{businessName} and {businessName}} and {{}", nil},
+		{"empty", "{{}}", []PromptLintProblem{{Template: "base.md", Line: 1, Placeholder: ""}}},
+	}
+	for _, tt := range tests {
+		if got := LintPromptTemplate("base.md", tt.text, known); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: LintPromptTemplate() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKnownPromptVariables(t *testing.T) {
+	known := KnownPromptVariables([]string{"brandColor", " brandColor", "locale"})
+	for _, name := range []string{"businessName", "businessType.label", "goals", "locale", "language", "brandColor"} {
+		if !slices.Contains(known, name) {
+			t.Errorf("KnownPromptVariables() lacks %s: %v", name, known)
+		}
+	}
+	if !slices.IsSorted(known) || len(slices.Compact(slices.Clone(known))) != len(known) {
+		t.Errorf("KnownPromptVariables() = %v, want sorted without duplicates", known)
+	}
+}
+
+func TestUnresolvedPlaceholders(t *testing.T) {
+	prompt := "## Base Template\n\nA {{businessTypeLabel}} in {{city}}.\n\n## Current User Request\n\nMore {{city}}, {not} this"
+	if got, want := UnresolvedPlaceholders(prompt), []string{"businessTypeLabel", "city"}; !slices.Equal(got, want) {
+		t.Errorf("UnresolvedPlaceholders() = %q, want %q", got, want)
+	}
+	if got := UnresolvedPlaceholders("A bakery"); got != nil {
+		t.Errorf("UnresolvedPlaceholders() of a resolved prompt = %q, want none", got)
+	}
+}
+
+func TestPromptBuilderLint(t *testing.T) {
+	basePath := writeTemp(t, "A site for {{businessName}}.\n{{businessTypeLabel}}")
+	requestPath := writeTemp(t, "{{customNotes}}")
+	pb, err := NewPromptBuilder(basePath, requestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variantPath := writeTemp(t, "Short: {{unknownVariant}}")
+	if err := pb.LoadVariant("short", variantPath); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PromptLintProblem{
+		{Template: basePath, Line: 2, Placeholder: "businessTypeLabel"},
+		{Template: variantPath, Line: 1, Placeholder: "unknownVariant"},
+	}
+	if got := pb.Lint(KnownPromptVariables(nil)); !slices.Equal(got, want) {
+		t.Errorf("Lint() = %v, want %v", got, want)
+	}
+
+	// The allowlist silences them
+	if got := pb.Lint(KnownPromptVariables([]string{"businessTypeLabel", "unknownVariant"})); len(got) != 0 {
+		t.Errorf("Lint() with the placeholders allowed = %v, want none", got)
+	}
+}
+
+func TestPromptLintProblemString(t *testing.T) {
+	problem := PromptLintProblem{Template: "prompts/base.md", Line: 3, Placeholder: "city"}
+	if got, want := problem.String(), "prompts/base.md:3: unknown placeholder {{city}}"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"awning-backend/model"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// Section outlines keep this many runes of each section's text
+	MAX_SECTION_OUTLINE_RUNES = 200
+
+	// UNCHANGED_SECTION_ATTR marks an empty placeholder section standing in
+	// for the current site's section with the same id
+	UNCHANGED_SECTION_ATTR = "data-awning-unchanged"
+)
+
+// PageSection is a top-level <section> of a page
+type PageSection struct {
+	ID        string // its id, or section-<n> by position when it has none
+	Text      string // the start of its text, up to MAX_SECTION_OUTLINE_RUNES
+	HTML      string
+	Unchanged bool // a placeholder for the current site's section ID
+}
+
+// PageSections returns the top-level sections of a page in page order
+func PageSections(page string) ([]PageSection, error) {
+	root, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	var sections []PageSection
+	for i, n := range topLevelSections(root) {
+		var b strings.Builder
+		if err := RenderCanonical(&b, n); err != nil {
+			return nil, fmt.Errorf("failed to render section: %w", err)
+		}
+		sections = append(sections, PageSection{
+			ID:        sectionID(n, i),
+			Text:      truncateRunes(nodeText(n), MAX_SECTION_OUTLINE_RUNES),
+			HTML:      b.String(),
+			Unchanged: hasAttr(n, UNCHANGED_SECTION_ATTR),
+		})
+	}
+	return sections, nil
+}
+
+// CurrentSiteSection is the prompt section outlining the page an update
+// starts from. With keepUnchanged, the model may leave sections it doesn't
+// change as placeholders, which RestoreUnchangedSections fills in.
+func CurrentSiteSection(sections []PageSection, keepUnchanged bool) string {
+	if len(sections) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\n## Current Site\n\nThe business owner is asking for changes to their current site. ")
+	b.WriteString("Its sections are listed below in page order, by id with the start of their text. ")
+	b.WriteString("Keep every section the request doesn't ask to change or remove, in the same order and with the same id, ")
+	b.WriteString("and give new sections an id of their own.\n")
+	if keepUnchanged {
+		fmt.Fprintf(&b, "To keep a section exactly as it is, write it as an empty placeholder instead of repeating it: <section id=\"ID\" %s></section>\n", UNCHANGED_SECTION_ATTR)
+	}
+	for _, s := range sections {
+		fmt.Fprintf(&b, "\n- #%s: %s", s.ID, s.Text)
+	}
+	return b.String()
+}
+
+// DiffSections compares an update's sections with those of the page it
+// started from. Placeholders and sections whose markup only differs in
+// whitespace are unchanged.
+func DiffSections(before, after []PageSection) *model.SectionDiff {
+	diff := &model.SectionDiff{Added: []string{}, Removed: []string{}, Modified: []string{}, Unchanged: []string{}}
+
+	previous := make(map[string]PageSection, len(before))
+	for _, s := range before {
+		previous[s.ID] = s
+	}
+	seen := make(map[string]bool, len(after))
+	for _, s := range after {
+		seen[s.ID] = true
+		old, ok := previous[s.ID]
+		switch {
+		case !ok:
+			if s.Unchanged {
+				continue // a placeholder for a section that doesn't exist
+			}
+			diff.Added = append(diff.Added, s.ID)
+		case s.Unchanged || collapseSpace(s.HTML) == collapseSpace(old.HTML):
+			diff.Unchanged = append(diff.Unchanged, s.ID)
+		default:
+			diff.Modified = append(diff.Modified, s.ID)
+		}
+	}
+	for _, s := range before {
+		if !seen[s.ID] {
+			diff.Removed = append(diff.Removed, s.ID)
+		}
+	}
+	return diff
+}
+
+// RestoreUnchangedSections replaces the placeholder sections of an update
+// with the current site's sections of the same id, verbatim. Placeholders
+// for sections that don't exist are dropped. Pages without placeholders are
+// returned as they are.
+func RestoreUnchangedSections(page string, current []PageSection) (string, error) {
+	if !strings.Contains(page, UNCHANGED_SECTION_ATTR) {
+		return page, nil
+	}
+
+	root, err := html.Parse(strings.NewReader(page))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	previous := make(map[string]string, len(current))
+	for _, s := range current {
+		previous[s.ID] = s.HTML
+	}
+	for i, n := range topLevelSections(root) {
+		if !hasAttr(n, UNCHANGED_SECTION_ATTR) {
+			continue
+		}
+		parent := n.Parent
+		if old, ok := previous[sectionID(n, i)]; ok {
+			nodes, err := html.ParseFragment(strings.NewReader(old), parent)
+			if err != nil {
+				return "", fmt.Errorf("failed to parse section %s: %w", sectionID(n, i), err)
+			}
+			for _, node := range nodes {
+				parent.InsertBefore(node, n)
+			}
+		}
+		parent.RemoveChild(n)
+	}
+
+	var b strings.Builder
+	if err := RenderCanonical(&b, root); err != nil {
+		return "", fmt.Errorf("failed to render page: %w", err)
+	}
+	return b.String(), nil
+}
+
+// topLevelSections returns the <section> elements that aren't inside
+// another section, in document order
+func topLevelSections(n *html.Node) []*html.Node {
+	var sections []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "section" {
+			sections = append(sections, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return sections
+}
+
+// sectionID is the id of the index'th top-level section, or section-<n> by
+// its position when it has none
+func sectionID(n *html.Node, index int) string {
+	for _, attr := range n.Attr {
+		if attr.Key == "id" && strings.TrimSpace(attr.Val) != "" {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return fmt.Sprintf("section-%d", index+1)
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// collapseSpace collapses runs of whitespace and drops it around tags, so
+// markup reindented by the model compares equal
+func collapseSpace(s string) string {
+	collapsed := strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer("> ", ">", " <", "<").Replace(collapsed)
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"awning-backend/model"
+)
+
+const currentPage = `<html><head><title>Bakery</title></head><body>
+<section id="hero"><h1>Rye &amp; Co</h1><p>Fresh bread daily</p></section>
+<section id="menu"><h2>Menu</h2><ul><li>Rye</li><li>Sourdough</li></ul><section id="nested"><p>Seasonal</p></section></section>
+<section><h2>Contact</h2><p>Call us</p></section>
+</body></html>`
+
+// sectionIDs returns the IDs of sections in order
+func sectionIDs(sections []PageSection) []string {
+	ids := make([]string, len(sections))
+	for i, s := range sections {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+func TestPageSections(t *testing.T) {
+	sections, err := PageSections(currentPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nested sections belong to their top-level section
+	if got, want := sectionIDs(sections), []string{"hero", "menu", "section-3"}; !slices.Equal(got, want) {
+		t.Fatalf("section IDs = %q, want %q", got, want)
+	}
+	if sections[0].Text != "Rye & Co Fresh bread daily" && sections[0].Text != "Rye & CoFresh bread daily" {
+		t.Errorf("hero text = %q", sections[0].Text)
+	}
+	if !strings.Contains(sections[1].HTML, `<section id="nested">`) || !strings.HasPrefix(sections[1].HTML, `<section id="menu">`) {
+		t.Errorf("menu HTML = %q, want the section with its nested section", sections[1].HTML)
+	}
+
+	long := "<section id=\"about\"><p>" + strings.Repeat("ñ", MAX_SECTION_OUTLINE_RUNES+50) + "</p></section>" +
+		`<section id="faq" ` + UNCHANGED_SECTION_ATTR + `></section>`
+	sections, err = PageSections(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len([]rune(sections[0].Text)); n > MAX_SECTION_OUTLINE_RUNES+1 || n < MAX_SECTION_OUTLINE_RUNES {
+		t.Errorf("outline text is %d runes, want it cut to about %d", n, MAX_SECTION_OUTLINE_RUNES)
+	}
+	if sections[0].Unchanged || !sections[1].Unchanged {
+		t.Errorf("unchanged = %v, %v; want only the placeholder", sections[0].Unchanged, sections[1].Unchanged)
+	}
+
+	if sections, err := PageSections("<html><body><p>No sections</p></body></html>"); err != nil || len(sections) != 0 {
+		t.Errorf("PageSections() of a page without sections = %+v, %v", sections, err)
+	}
+}
+
+func TestDiffSections(t *testing.T) {
+	before := []PageSection{
+		{ID: "hero", HTML: `<section id="hero"><h1>Rye</h1></section>`},
+		{ID: "menu", HTML: `<section id="menu"><p>Bread</p></section>`},
+		{ID: "contact", HTML: `<section id="contact"><p>Call</p></section>`},
+	}
+
+	tests := []struct {
+		name  string
+		after []PageSection
+		want  model.SectionDiff
+	}{
+		{"identical", before, model.SectionDiff{Unchanged: []string{"hero", "menu", "contact"}}},
+		{"whitespace only", []PageSection{
+			{ID: "hero", HTML: "<section id=\"hero\">\n  <h1>Rye</h1>\n</section>"},
+			{ID: "menu", HTML: `<section id="menu"><p>Bread</p></section>`},
+			{ID: "contact", HTML: `<section id="contact"><p>Call</p></section>`},
+		}, model.SectionDiff{Unchanged: []string{"hero", "menu", "contact"}}},
+		{"added, removed and modified", []PageSection{
+			{ID: "hero", HTML: `<section id="hero"><h1>Rye &amp; Co</h1></section>`},
+			{ID: "gallery", HTML: `<section id="gallery"></section>`},
+			{ID: "contact", HTML: `<section id="contact"><p>Call</p></section>`},
+		}, model.SectionDiff{Added: []string{"gallery"}, Removed: []string{"menu"}, Modified: []string{"hero"}, Unchanged: []string{"contact"}}},
+		{"placeholders", []PageSection{
+			{ID: "hero", Unchanged: true},
+			{ID: "menu", HTML: `<section id="menu"><p>Cakes</p></section>`},
+			{ID: "contact", Unchanged: true},
+			{ID: "ghost", Unchanged: true},
+		}, model.SectionDiff{Modified: []string{"menu"}, Unchanged: []string{"hero", "contact"}}},
+		{"everything removed", nil, model.SectionDiff{Removed: []string{"hero", "menu", "contact"}}},
+	}
+	for _, tt := range tests {
+		got := DiffSections(before, tt.after)
+		for _, field := range []struct {
+			name      string
+			got, want []string
+		}{
+			{"added", got.Added, tt.want.Added},
+			{"removed", got.Removed, tt.want.Removed},
+			{"modified", got.Modified, tt.want.Modified},
+			{"unchanged", got.Unchanged, tt.want.Unchanged},
+		} {
+			// Empty lists are encoded as [] rather than null
+			if field.got == nil || !slices.Equal(field.got, field.want) && len(field.got)+len(field.want) > 0 {
+				t.Errorf("%s: %s = %q, want %q", tt.name, field.name, field.got, field.want)
+			}
+		}
+	}
+}
+
+func TestRestoreUnchangedSections(t *testing.T) {
+	current, err := PageSections(currentPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := `<html><head></head><body>
+<section id="hero" ` + UNCHANGED_SECTION_ATTR + `></section>
+<section id="menu"><h2>New menu</h2></section>
+<section id="ghost" ` + UNCHANGED_SECTION_ATTR + `></section>
+<section id="section-3" ` + UNCHANGED_SECTION_ATTR + `></section>
+</body></html>`
+	restored, err := RestoreUnchangedSections(update, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sections, err := PageSections(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sectionIDs(sections), []string{"hero", "menu", "section-3"}; !slices.Equal(got, want) {
+		t.Fatalf("restored section IDs = %q, want %q", got, want)
+	}
+	if sections[0].HTML != current[0].HTML || sections[2].HTML != current[2].HTML {
+		t.Errorf("restored sections = %q, want the current ones verbatim", restored)
+	}
+	if sections[1].HTML != `<section id="menu"><h2>New menu</h2></section>` {
+		t.Errorf("changed section = %q, want the model's", sections[1].HTML)
+	}
+	if strings.Contains(restored, UNCHANGED_SECTION_ATTR) {
+		t.Errorf("restored page still has placeholders: %q", restored)
+	}
+
+	// Pages without placeholders are left as they are
+	page := "<section id=\"hero\">\n<h1>New</h1></section>"
+	if got, err := RestoreUnchangedSections(page, current); err != nil || got != page {
+		t.Errorf("RestoreUnchangedSections() without placeholders = %q, %v", got, err)
+	}
+}
+
+func TestCurrentSiteSection(t *testing.T) {
+	sections := []PageSection{{ID: "hero", Text: "Rye & Co"}, {ID: "menu", Text: "Menu Rye Sourdough"}}
+
+	got := CurrentSiteSection(sections, false)
+	for _, want := range []string{"## Current Site", "- #hero: Rye & Co", "- #menu: Menu Rye Sourdough", "Keep every section"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("CurrentSiteSection() = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, UNCHANGED_SECTION_ATTR) {
+		t.Error("CurrentSiteSection() offers placeholders when they aren't restored")
+	}
+	if !strings.Contains(CurrentSiteSection(sections, true), UNCHANGED_SECTION_ATTR) {
+		t.Error("CurrentSiteSection() with keepUnchanged doesn't explain placeholders")
+	}
+	if got := CurrentSiteSection(nil, true); got != "" {
+		t.Errorf("CurrentSiteSection(nil) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"awning-backend/model"
+)
+
+const sitePlanSchema = `{
+  "site_name": "<name of the business or site>",
+  "pages": [{"slug": "<slug as given>", "title": "<page title>", "nav_label": "<short navigation label>", "purpose": "<one sentence on what the page is for>", "sections": ["<section the page should have>"]}]
+}`
+
+// DefaultPageRequestTemplate asks for one page of a multi-page site. It is
+// used unless the prompt directory has a <name>-page.md template.
+const DefaultPageRequestTemplate = `You are generating the "{{pageTitle}}" page ({{pageSlug}}) of a multi-page website, {{siteName}}. This page's purpose: {{pagePurpose}}
+
+The site has these pages:
+
+{{sitePages}}
+
+Every page shares the same header navigation, with exactly these links in this order:
+
+{{siteNav}}
+
+Use the same header, navigation, footer, colors and fonts as the rest of the site. Link to other pages only with the paths above. Plan this page with these sections: {{pageSections}}.
+
+Reply with the complete HTML document of this page only.`
+
+// SitePageVariables are the placeholders a page request template may use
+var SitePageVariables = []string{"pageSlug", "pageTitle", "pagePurpose", "pageSections", "siteName", "sitePages", "siteNav"}
+
+// LoadPageTemplate reads the request template used for each page of a
+// multi-page site, instead of DefaultPageRequestTemplate
+func (pb *PromptBuilder) LoadPageTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read page template file: %w", err)
+	}
+	pb.pageTemplate = string(data)
+	pb.pagePath = path
+	return nil
+}
+
+// BuildPagePrompt adds the request for one page of a multi-page site to the
+// prompt built for the whole generation
+func (pb *PromptBuilder) BuildPagePrompt(prompt string, plan *model.SitePlan, page model.SitePlanPage) string {
+	template := DefaultPageRequestTemplate
+	if pb.pageTemplate != "" {
+		template = pb.pageTemplate
+	}
+
+	var pages, nav strings.Builder
+	for _, p := range plan.Pages {
+		fmt.Fprintf(&pages, "- %s (%s)", p.Title, model.SitePagePath(p.Slug))
+		if p.Purpose != "" {
+			fmt.Fprintf(&pages, ": %s", p.Purpose)
+		}
+		pages.WriteString("\n")
+	}
+	for _, link := range plan.Nav() {
+		fmt.Fprintf(&nav, "- <a href=\"%s\">%s</a>\n", link.Href, link.Label)
+	}
+
+	sections := strings.Join(page.Sections, ", ")
+	if sections == "" {
+		sections = "whatever suits its purpose"
+	}
+	purpose := page.Purpose
+	if purpose == "" {
+		purpose = "the site's " + strings.ToLower(page.Title) + " page"
+	}
+	siteName := plan.SiteName
+	if siteName == "" {
+		siteName = "the business's site"
+	}
+
+	variables := map[string]string{
+		"pageSlug":     page.Slug,
+		"pageTitle":    page.Title,
+		"pagePurpose":  purpose,
+		"pageSections": sections,
+		"siteName":     siteName,
+		"sitePages":    strings.TrimRight(pages.String(), "\n"),
+		"siteNav":      strings.TrimRight(nav.String(), "\n"),
+	}
+	for key, value := range variables {
+		template = strings.ReplaceAll(template, "{{"+key+"}}", value)
+	}
+
+	return prompt + fmt.Sprintf("\n\n## Site Page\n\n%s", template)
+}
+
+// BuildSitePlanPrompt builds the prompt asking for the JSON plan of a
+// multi-page site with the given page slugs, written for locale when set.
+// previousError is set when retrying after a reply that didn't validate.
+func BuildSitePlanPrompt(userRequestMessage string, siteName string, slugs []string, locale string, previousError string) string {
+	var b strings.Builder
+	b.WriteString("Plan a small multi-page website as a JSON object with exactly this shape:\n\n")
+	b.WriteString(sitePlanSchema)
+	fmt.Fprintf(&b, "\n\nThe site has exactly these pages, in this order, with these slugs: %s. ", strings.Join(slugs, ", "))
+	b.WriteString("Give each a title, a nav_label of one or two words and a purpose, and list the sections it should have so no two pages repeat each other. ")
+	if siteName != "" {
+		fmt.Fprintf(&b, "The business is called %s. ", siteName)
+	}
+	b.WriteString("Reply with the JSON object only, without markdown fences or commentary.\n")
+	if locale != "" {
+		fmt.Fprintf(&b, "Write the site name, titles, labels and purposes in %s (locale %s), and keep the slugs as given.\n", LanguageName(locale), locale)
+	}
+	if previousError != "" {
+		b.WriteString("\nYour previous reply was rejected: ")
+		b.WriteString(previousError)
+		b.WriteString(". Reply with valid JSON matching the shape above.\n")
+	}
+	b.WriteString("\n## User Request\n\n")
+	b.WriteString(userRequestMessage)
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var ErrSSEClosed = errors.New("event stream closed")
+
+// SSEWriter writes server-sent events for one request. Writes are
+// serialized, so goroutines such as the thinking ticker can share it with
+// the stream callback. After a failed write or Close, further events are
+// dropped.
+type SSEWriter struct {
+	mu     sync.Mutex
+	w      http.ResponseWriter
+	closed bool
+	err    error
+}
+
+// NewSSEWriter creates an event writer for the response
+func NewSSEWriter(w http.ResponseWriter) *SSEWriter {
+	return &SSEWriter{w: w}
+}
+
+// Send writes one event and flushes it. Multi-line data is split over
+// several data fields so it can't break the framing.
+func (s *SSEWriter) Send(eventType, data string) error {
+	var frame strings.Builder
+	fmt.Fprintf(&frame, "event: %s\n", eventType)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&frame, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	frame.WriteString("\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSSEClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+
+	if _, err := s.w.Write([]byte(frame.String())); err != nil {
+		// Usually the client went away; log once and drop the rest
+		slog.Warn("Failed to write event, dropping the rest of the stream", "event", eventType, "error", err)
+		s.err = fmt.Errorf("failed to write %s event: %w", eventType, err)
+		return s.err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// SendEvent is Send for callers that carry on when the client is gone
+func (s *SSEWriter) SendEvent(eventType, data string) {
+	_ = s.Send(eventType, data)
+}
+
+// Close stops the writer; later events are dropped. It returns the first
+// write error, if any, and is safe to call more than once.
+func (s *SSEWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	return s.err
+}
+
+// Err returns the first write error, if any
+func (s *SSEWriter) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// frameWriter is a ResponseWriter that records writes and flags overlapping
+// Write or Flush calls, which a real connection would interleave
+type frameWriter struct {
+	buf      []byte // only touched inside Write, so racy if writes overlap
+	active   atomic.Int32
+	overlaps atomic.Int32
+	writes   atomic.Int32
+	flushes  atomic.Int32
+	failAt   int32 // the write to fail, from 1; 0 never fails
+}
+
+func (w *frameWriter) enter() {
+	if w.active.Add(1) > 1 {
+		w.overlaps.Add(1)
+	}
+	// Widen the window for another goroutine to get in
+	runtime.Gosched()
+}
+
+func (w *frameWriter) leave() {
+	w.active.Add(-1)
+}
+
+func (w *frameWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (w *frameWriter) WriteHeader(int) {}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	w.enter()
+	defer w.leave()
+
+	if n := w.writes.Add(1); n == w.failAt {
+		return 0, errors.New("broken pipe")
+	}
+	// Write in halves, as a connection may, so unserialized frames interleave
+	half := len(p) / 2
+	w.buf = append(w.buf, p[:half]...)
+	runtime.Gosched()
+	w.buf = append(w.buf, p[half:]...)
+	return len(p), nil
+}
+
+func (w *frameWriter) Flush() {
+	w.enter()
+	defer w.leave()
+	w.flushes.Add(1)
+}
+
+// parseFrames splits an event stream into its events, failing the test on
+// malformed frames
+func parseFrames(t *testing.T, stream string) []struct{ event, data string } {
+	t.Helper()
+
+	var events []struct{ event, data string }
+	if stream == "" {
+		return events
+	}
+	frames := strings.Split(strings.TrimSuffix(stream, "\n\n"), "\n\n")
+	for _, frame := range frames {
+		lines := strings.Split(frame, "\n")
+		event, ok := strings.CutPrefix(lines[0], "event: ")
+		if !ok {
+			t.Fatalf("frame %q doesn't start with an event field", frame)
+		}
+		var data []string
+		for _, line := range lines[1:] {
+			value, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				t.Fatalf("frame %q has a line that isn't a data field: %q", frame, line)
+			}
+			data = append(data, value)
+		}
+		events = append(events, struct{ event, data string }{event, strings.Join(data, "\n")})
+	}
+	return events
+}
+
+func TestSSEWriterConcurrentFrames(t *testing.T) {
+	const goroutines, perGoroutine = 16, 200
+
+	w := &frameWriter{}
+	sse := NewSSEWriter(w)
+
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perGoroutine {
+				data := fmt.Sprintf("%d-%d first\n%d-%d second\r\n%d-%d third", g, i, g, i, g, i)
+				if err := sse.Send(fmt.Sprintf("g%d", g), data); err != nil {
+					t.Errorf("Send() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := w.overlaps.Load(); n > 0 {
+		t.Errorf("%d overlapping Write or Flush calls", n)
+	}
+	if got, want := w.flushes.Load(), int32(goroutines*perGoroutine); got != want {
+		t.Errorf("%d flushes, want one per event (%d)", got, want)
+	}
+
+	events := parseFrames(t, string(w.buf))
+	if len(events) != goroutines*perGoroutine {
+		t.Fatalf("%d events, want %d", len(events), goroutines*perGoroutine)
+	}
+	next := make([]int, goroutines)
+	for _, e := range events {
+		var g, i int
+		if _, err := fmt.Sscanf(e.event, "g%d", &g); err != nil || g < 0 || g >= goroutines {
+			t.Fatalf("unexpected event type %q", e.event)
+		}
+		fmt.Sscanf(e.data, "%d-%d", &g, &i)
+		want := fmt.Sprintf("%d-%d first\n%d-%d second\n%d-%d third", g, i, g, i, g, i)
+		if e.event != fmt.Sprintf("g%d", g) || e.data != want {
+			t.Fatalf("event %q has data %q, want %q", e.event, e.data, want)
+		}
+		// Each goroutine's events arrive in the order it sent them
+		if i != next[g] {
+			t.Fatalf("goroutine %d: event %d arrived when %d was next", g, i, next[g])
+		}
+		next[g]++
+	}
+}
+
+func TestSSEWriterCloseDuringSends(t *testing.T) {
+	w := &frameWriter{}
+	sse := NewSSEWriter(w)
+
+	var wg sync.WaitGroup
+	var sent atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				switch err := sse.Send("thinking", "..."); {
+				case err == nil:
+					sent.Add(1)
+				case errors.Is(err, ErrSSEClosed):
+				default:
+					t.Errorf("Send() error = %v", err)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 3 {
+			if err := sse.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if n := w.overlaps.Load(); n > 0 {
+		t.Errorf("%d overlapping Write or Flush calls", n)
+	}
+	if got := len(parseFrames(t, string(w.buf))); got != int(sent.Load()) {
+		t.Errorf("%d events written, want the %d sent before Close", got, sent.Load())
+	}
+	if err := sse.Send("content", "late"); !errors.Is(err, ErrSSEClosed) {
+		t.Errorf("Send() after Close error = %v, want ErrSSEClosed", err)
+	}
+}
+
+func TestSSEWriterDropsAfterWriteError(t *testing.T) {
+	w := &frameWriter{failAt: 2}
+	sse := NewSSEWriter(w)
+
+	if err := sse.Send("content", "one"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	err := sse.Send("content", "two")
+	if err == nil {
+		t.Fatalf("Send() error = nil, want the write error")
+	}
+	sse.SendEvent("content", "three")
+	if got := w.writes.Load(); got != 2 {
+		t.Errorf("%d writes, want none after the failed one", got)
+	}
+	if !errors.Is(sse.Err(), err) {
+		t.Errorf("Err() = %v, want %v", sse.Err(), err)
+	}
+	if closeErr := sse.Close(); !errors.Is(closeErr, err) {
+		t.Errorf("Close() = %v, want the first write error", closeErr)
+	}
+	if closeErr := sse.Close(); !errors.Is(closeErr, err) {
+		t.Errorf("second Close() = %v, want the first write error", closeErr)
+	}
+	if got := parseFrames(t, string(w.buf)); len(got) != 1 || got[0].data != "one" {
+		t.Errorf("events = %v, want only the first", got)
+	}
+}
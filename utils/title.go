@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MAX_CHAT_TITLE_LENGTH      = 80
+	MAX_CHAT_TITLE_INPUT_RUNES = 2000
+)
+
+// BuildChatTitlePrompt builds the prompt asking for a short chat title
+func BuildChatTitlePrompt(firstUserMessage string) string {
+	if utf8.RuneCountInString(firstUserMessage) > MAX_CHAT_TITLE_INPUT_RUNES {
+		firstUserMessage = string([]rune(firstUserMessage)[:MAX_CHAT_TITLE_INPUT_RUNES])
+	}
+
+	return "Summarize the following website request in 6 words or fewer, for use as a conversation title. " +
+		"Reply with the title only, without quotes or punctuation at the end.\n\n" +
+		"Request:\n" + firstUserMessage
+}
+
+// CleanChatTitle normalizes a model-generated title: first non-empty line,
+// no surrounding quotes or markdown, capped in length
+func CleanChatTitle(raw string) string {
+	title := ""
+	for _, line := range strings.Split(raw, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			title = line
+			break
+		}
+	}
+
+	title = strings.TrimPrefix(title, "Title:")
+	title = strings.Trim(title, " \t\"'`*#.")
+	title = strings.Join(strings.Fields(title), " ")
+
+	if utf8.RuneCountInString(title) > MAX_CHAT_TITLE_LENGTH {
+		title = strings.TrimSpace(string([]rune(title)[:MAX_CHAT_TITLE_LENGTH]))
+	}
+	return title
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/tiktoken-go/tokenizer"
+)
+
+const TOKEN_MODEL = tokenizer.Cl100kBase
+
+// The codec is built once: loading the BPE ranks is slow, and counting with
+// a built codec is safe from any goroutine
+var sharedCodec = sync.OnceValues(func() (tokenizer.Codec, error) {
+	return tokenizer.Get(TOKEN_MODEL)
+})
+
+// CountTokens counts the tokens of text with the shared TOKEN_MODEL codec
+func CountTokens(text string) (int, error) {
+	codec, err := sharedCodec()
+	if err != nil {
+		return 0, err
+	}
+	return codec.Count(text)
+}
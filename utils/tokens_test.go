@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello world", 2},
+		{"<section><h1>Bakery</h1></section>", 13},
+	}
+	for _, tt := range tests {
+		got, err := CountTokens(tt.text)
+		if err != nil || got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, %v, want %d", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestCountTokensConcurrent(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)
+	want, err := CountTokens(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				if got, err := CountTokens(text); err != nil || got != want {
+					t.Errorf("CountTokens() = %d, %v concurrently, want %d", got, err, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkCountTokens(b *testing.B) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)
+	b.ReportAllocs()
+	for b.Loop() {
+		CountTokens(text)
+	}
+}
+
+func BenchmarkCountTokensParallel(b *testing.B) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			CountTokens(text)
+		}
+	})
+}